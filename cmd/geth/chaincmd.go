@@ -308,6 +308,10 @@ func importChain(ctx *cli.Context) error {
 		}
 	}
 	chain.Stop()
+	// The import may have run with --bolt.nosync, flush it before the database is closed
+	if err := ethdb.EnableSync(db); err != nil {
+		log.Error("Failed to sync the database", "err", err)
+	}
 	fmt.Printf("Import done in %v.\n\n", time.Since(start))
 
 	// Print the memory statistics used by the importing
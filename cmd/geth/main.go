@@ -69,6 +69,13 @@ var (
 		utils.LegacyBootnodesV5Flag,
 		utils.DataDirFlag,
 		utils.AncientFlag,
+		utils.HistoryDataDirFlag,
+		utils.BoltMmapSizeFlag,
+		utils.BoltFreelistFlag,
+		utils.BoltNoSyncFlag,
+		utils.BoltScrubIntervalFlag,
+		utils.DatabaseCodecsFlag,
+		utils.CodeColdDaysFlag,
 		utils.KeyStoreDirFlag,
 		utils.ExternalSignerFlag,
 		utils.NoUSBFlag,
@@ -100,6 +107,8 @@ var (
 		utils.GCModeLimitFlag,
 		utils.GCModeBlockToPruneFlag,
 		utils.GCModeTickTimeout,
+		utils.HistoryRetentionFullFlag,
+		utils.HistoryRetentionAccountsFlag,
 		utils.LightServFlag,
 		utils.LightPeersFlag,
 		utils.LightKDFFlag,
@@ -112,11 +121,39 @@ var (
 		utils.CacheTrieFlag,
 		utils.CacheGCFlag,
 		utils.TrieCacheGenFlag,
+		utils.TrieCacheAccountsFlag,
+		utils.TrieCacheStorageFlag,
+		utils.TrieEvictionPolicyFlag,
+		utils.TrieWitnessLenFlag,
+		utils.StageLogIntervalFlag,
+		utils.AccountFilterFlag,
+		utils.ExecutionPrefetchFlag,
+		utils.CodeCacheFlag,
 		utils.DownloadOnlyFlag,
 		utils.StorageModeFlag,
 		utils.ArchiveSyncInterval,
+		utils.FlatHashingFlag,
+		utils.HashingWorkersFlag,
+		utils.RetainListBudgetFlag,
+		utils.HistoryCommitWindowFlag,
+		utils.SplitStateFlag,
+		utils.StorageChangeSetV2Flag,
+		utils.AsyncCommitFlag,
+		utils.HistoryIndexAllowFlag,
+		utils.HistoryIndexDenyFlag,
+		utils.HistoryIndexRebuildFlag,
+		utils.TxAddressIndexFlag,
+		utils.TxAddressIndexKeepFlag,
+		utils.PinnedStorageFlag,
+		utils.HotAccountsFlag,
+		utils.TraceAccountsFlag,
 		utils.DatabaseFlag,
 		utils.RemoteDbListenAddress,
+		utils.RemoteDbMaxConnectionsFlag,
+		utils.RemoteDbMaxTxsPerHostFlag,
+		utils.RemoteDbMaxCursorsPerTxFlag,
+		utils.RemoteDbIdleTimeoutFlag,
+		utils.RemoteDbGrpcListenAddress,
 		utils.CacheNoPrefetchFlag,
 		utils.ListenPortFlag,
 		utils.MaxPeersFlag,
@@ -150,6 +187,7 @@ var (
 		utils.RinkebyFlag,
 		utils.GoerliFlag,
 		utils.VMEnableDebugFlag,
+		utils.VMBatchStorageReadsFlag,
 		utils.NetworkIdFlag,
 		utils.EthStatsURLFlag,
 		utils.FakePoWFlag,
@@ -199,6 +237,7 @@ var (
 	metricsFlags = []cli.Flag{
 		utils.MetricsEnabledFlag,
 		utils.MetricsEnabledExpensiveFlag,
+		utils.MetricsDatabaseStatsIntervalFlag,
 		utils.MetricsEnableInfluxDBFlag,
 		utils.MetricsInfluxDBEndpointFlag,
 		utils.MetricsInfluxDBDatabaseFlag,
@@ -68,6 +68,13 @@ var AppHelpFlagGroups = []flagGroup{
 			configFileFlag,
 			utils.DataDirFlag,
 			utils.AncientFlag,
+			utils.HistoryDataDirFlag,
+			utils.BoltMmapSizeFlag,
+			utils.BoltFreelistFlag,
+			utils.BoltNoSyncFlag,
+			utils.BoltScrubIntervalFlag,
+			utils.DatabaseCodecsFlag,
+			utils.CodeColdDaysFlag,
 			utils.KeyStoreDirFlag,
 			utils.NoUSBFlag,
 			utils.SmartCardDaemonPathFlag,
@@ -82,6 +89,8 @@ var AppHelpFlagGroups = []flagGroup{
 			utils.GCModeLimitFlag,
 			utils.GCModeBlockToPruneFlag,
 			utils.GCModeTickTimeout,
+			utils.HistoryRetentionFullFlag,
+			utils.HistoryRetentionAccountsFlag,
 			utils.EthStatsURLFlag,
 			utils.IdentityFlag,
 			utils.LightKDFFlag,
@@ -89,6 +98,21 @@ var AppHelpFlagGroups = []flagGroup{
 			utils.DownloadOnlyFlag,
 			utils.StorageModeFlag,
 			utils.ArchiveSyncInterval,
+			utils.FlatHashingFlag,
+			utils.HashingWorkersFlag,
+			utils.RetainListBudgetFlag,
+			utils.HistoryCommitWindowFlag,
+			utils.SplitStateFlag,
+			utils.StorageChangeSetV2Flag,
+			utils.AsyncCommitFlag,
+			utils.HistoryIndexAllowFlag,
+			utils.HistoryIndexDenyFlag,
+			utils.HistoryIndexRebuildFlag,
+			utils.TxAddressIndexFlag,
+			utils.TxAddressIndexKeepFlag,
+			utils.PinnedStorageFlag,
+			utils.HotAccountsFlag,
+			utils.TraceAccountsFlag,
 		},
 	},
 	{
@@ -148,6 +172,14 @@ var AppHelpFlagGroups = []flagGroup{
 			utils.CacheGCFlag,
 			utils.CacheNoPrefetchFlag,
 			utils.TrieCacheGenFlag,
+			utils.TrieCacheAccountsFlag,
+			utils.TrieCacheStorageFlag,
+			utils.TrieEvictionPolicyFlag,
+			utils.TrieWitnessLenFlag,
+			utils.StageLogIntervalFlag,
+			utils.AccountFilterFlag,
+			utils.ExecutionPrefetchFlag,
+			utils.CodeCacheFlag,
 			utils.DatabaseFlag,
 		},
 	},
@@ -186,6 +218,11 @@ var AppHelpFlagGroups = []flagGroup{
 			utils.ExecFlag,
 			utils.PreloadJSFlag,
 			utils.RemoteDbListenAddress,
+			utils.RemoteDbMaxConnectionsFlag,
+			utils.RemoteDbMaxTxsPerHostFlag,
+			utils.RemoteDbMaxCursorsPerTxFlag,
+			utils.RemoteDbIdleTimeoutFlag,
+			utils.RemoteDbGrpcListenAddress,
 		},
 	},
 	{
@@ -232,6 +269,7 @@ var AppHelpFlagGroups = []flagGroup{
 		Name: "VIRTUAL MACHINE",
 		Flags: []cli.Flag{
 			utils.VMEnableDebugFlag,
+			utils.VMBatchStorageReadsFlag,
 			utils.EVMInterpreterFlag,
 			utils.EWASMInterpreterFlag,
 		},
@@ -61,6 +61,7 @@ var name = flag.String("name", "", "name to add to the file names")
 var chaindata = flag.String("chaindata", "chaindata", "path to the chaindata database file")
 var hash = flag.String("hash", "0x00", "image for preimage or state root for testBlockHashes action")
 var preImage = flag.String("preimage", "0x00", "preimage")
+var concurrency = flag.Int("concurrency", runtime.NumCPU(), "number of workers generating the witnesses of the slices of a tick in mgrSchedule")
 
 func bucketList(db *bolt.DB) [][]byte {
 	bucketList := [][]byte{}
@@ -621,7 +622,7 @@ func trieChart() {
 	check(err)
 }
 
-func mgrSchedule(chaindata string, block uint64) {
+func mgrSchedule(chaindata string, block uint64, concurrency int) {
 	db, err := ethdb.NewBoltDatabase(chaindata)
 	check(err)
 
@@ -646,7 +647,7 @@ func mgrSchedule(chaindata string, block uint64) {
 	stateSize := tr.EstimateWitnessSize([]byte{})
 	schedule := mgr.NewStateSchedule(stateSize, block, block+mgr.BlocksPerCycle+100)
 
-	var buf bytes.Buffer
+	witnessCache := mgr.NewWitnessCache(1024)
 	var witnessSizeAccumulator uint64
 	var witnessCount int64
 	var witnessEstimatedSizeAccumulator uint64
@@ -654,29 +655,17 @@ func mgrSchedule(chaindata string, block uint64) {
 	//fmt.Printf("stateSize: %d\n", stateSize)
 	for i := range schedule.Ticks {
 		tick := schedule.Ticks[i]
-		for j := range tick.StateSizeSlices {
-			ss := tick.StateSizeSlices[j]
-			stateSlice, err2 := mgr.StateSizeSlice2StateSlice(db, tr, ss)
-			if err2 != nil {
-				panic(err2)
-			}
-			retain := trie.NewRetainRange(common.CopyBytes(stateSlice.From), common.CopyBytes(stateSlice.To))
-			//if tick.IsLastInCycle() {
-			//	fmt.Printf("\nretain: %s\n", retain)
-			//}
-			witness, err2 := tr.ExtractWitness(false, retain)
-			if err2 != nil {
-				panic(err2)
-			}
-
-			buf.Reset()
-			_, err = witness.WriteTo(&buf)
-			if err != nil {
-				panic(err)
-			}
-
+		stateSlices, err2 := mgr.TickStateSlices(db, tr, tick)
+		if err2 != nil {
+			panic(err2)
+		}
+		witnesses, err2 := witnessCache.GenerateWitnesses(tr, stateSlices, concurrency)
+		if err2 != nil {
+			panic(err2)
+		}
+		for _, witness := range witnesses {
 			witnessCount++
-			witnessSizeAccumulator += uint64(buf.Len())
+			witnessSizeAccumulator += uint64(len(witness))
 		}
 		witnessEstimatedSizeAccumulator += tick.ToSize - tick.FromSize
 	}
@@ -1273,7 +1262,7 @@ func readAccount(chaindata string, account common.Address, block uint64, rewind
 		if v != nil {
 			err = changeset.StorageChangeSetBytes(v).Walk(func(key, value []byte) error {
 				if bytes.HasPrefix(key, secKey) {
-					incarnation := ^binary.BigEndian.Uint64(key[common.HashLength : common.HashLength+common.IncarnationLength])
+					incarnation := dbutils.DecodeIncarnation(key[common.HashLength : common.HashLength+common.IncarnationLength])
 					if !printed {
 						fmt.Printf("Changes for block %d\n", timestamp)
 						printed = true
@@ -1320,7 +1309,7 @@ func nextIncarnation(chaindata string, addrHash common.Hash) {
 		return
 	}
 	if found {
-		fmt.Printf("Incarnation: %d\n", (^binary.BigEndian.Uint64(incarnationBytes[:]))+1)
+		fmt.Printf("Incarnation: %d\n", dbutils.DecodeIncarnation(incarnationBytes[:])+1)
 		return
 	}
 	fmt.Printf("Incarnation(f): %d\n", state.FirstContractIncarnation)
@@ -2001,7 +1990,7 @@ func walkOverStorage(chaindata string) {
 	h, err := common.HashData(common.FromHex("0x109c4f2ccc82c4d77bde15f306707320294aea3f"))
 	check(err)
 	copy(startkey[:], h[:])
-	binary.BigEndian.PutUint64(startkey[32:], ^uint64(1))
+	dbutils.EncodeIncarnation(startkey[32:], 1)
 	fmt.Printf("startkey %x\n", startkey)
 	err = db.WalkAsOf(dbutils.CurrentStateBucket, dbutils.StorageHistoryBucket, startkey[:], 8*(32+8), 50796, func(k []byte, v []byte) (bool, error) {
 		fmt.Printf("%x: %x\n", k, v)
@@ -2027,6 +2016,8 @@ func resetState(chaindata string) {
 	db.DeleteBucket(dbutils.PlainAccountChangeSetBucket)
 	//nolint:errcheck
 	db.DeleteBucket(dbutils.PlainStorageChangeSetBucket)
+	//nolint:errcheck
+	db.DeleteBucket(dbutils.ChangeSetSummaryBucket)
 	_, _, err = core.DefaultGenesisBlock().CommitGenesisState(db, false)
 	check(err)
 	core.UsePlainStateExecution = true
@@ -2379,7 +2370,7 @@ func main() {
 		dbSlice(*chaindata, common.FromHex(*hash))
 	}
 	if *action == "mgrSchedule" {
-		mgrSchedule(*chaindata, uint64(*block))
+		mgrSchedule(*chaindata, uint64(*block), *concurrency)
 	}
 	if *action == "resetState" {
 		resetState(*chaindata)
@@ -2,7 +2,6 @@ package apis
 
 import (
 	"bytes"
-	"encoding/binary"
 	"math/big"
 
 	"github.com/ledgerwatch/turbo-geth/common"
@@ -237,7 +236,7 @@ func (r *RemoteReader) ReadAccountIncarnation(address common.Address) (uint64, e
 		return 0, err
 	}
 	if found {
-		return (^binary.BigEndian.Uint64(incarnationBytes[:])), nil
+		return dbutils.DecodeIncarnation(incarnationBytes[:]), nil
 	}
 	return 0, nil
 }
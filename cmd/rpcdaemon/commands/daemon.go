@@ -39,6 +39,12 @@ func splitAndTrim(input string) []string {
 type EthAPI interface {
 	BlockNumber(ctx context.Context) (hexutil.Uint64, error)
 	GetBlockByNumber(ctx context.Context, number rpc.BlockNumber, fullTx bool) (map[string]interface{}, error)
+	GetBlockByHash(ctx context.Context, hash common.Hash, fullTx bool) (map[string]interface{}, error)
+	GetBalance(ctx context.Context, address common.Address, blockNrOrHash rpc.BlockNumberOrHash) (*hexutil.Big, error)
+	GetTransactionCount(ctx context.Context, address common.Address, blockNrOrHash rpc.BlockNumberOrHash) (*hexutil.Uint64, error)
+	GetCode(ctx context.Context, address common.Address, blockNrOrHash rpc.BlockNumberOrHash) (hexutil.Bytes, error)
+	GetStorageAt(ctx context.Context, address common.Address, key string, blockNrOrHash rpc.BlockNumberOrHash) (hexutil.Bytes, error)
+	GetTransactionsByAddress(ctx context.Context, address common.Address, fromBlock, toBlock rpc.BlockNumber, page, pageSize hexutil.Uint64) ([]*ethapi.RPCTransaction, error)
 }
 
 // APIImpl is implementation of the EthAPI interface based on remote Db access
@@ -46,11 +52,16 @@ type APIImpl struct {
 	db           ethdb.KV
 	dbReader     ethdb.Getter
 	chainContext core.ChainContext
+	blockCache   *lru.Cache // Blocks by hash, the canonical ones are looked up by number first
 }
 
 // PrivateDebugAPI
 type PrivateDebugAPI interface {
 	StorageRangeAt(ctx context.Context, blockHash common.Hash, txIndex uint64, contractAddress common.Address, keyStart hexutil.Bytes, maxResult int) (eth.StorageRangeResult, error)
+	GetModifiedAccountsByNumber(ctx context.Context, startNum uint64, endNum *uint64) ([]common.Address, error)
+	GetModifiedAccountsByHash(ctx context.Context, startHash common.Hash, endHash *common.Hash) ([]common.Address, error)
+	GetModifiedAccountsRangeByNumber(ctx context.Context, startNum uint64, endNum *uint64, keyStart hexutil.Bytes, maxResult int) (eth.ModifiedAccountsResult, error)
+	IntermediateHashesRange(ctx context.Context, prefix hexutil.Bytes, keyStart hexutil.Bytes, maxResult int) (state.IntermediateHashesRange, error)
 }
 
 // APIImpl is implementation of the EthAPI interface based on remote Db access
@@ -60,12 +71,14 @@ type PrivateDebugAPIImpl struct {
 	chainContext core.ChainContext
 }
 
-// NewAPI returns APIImpl instance
-func NewAPI(db ethdb.KV, dbReader ethdb.Getter, chainContext core.ChainContext) *APIImpl {
+// NewAPI returns APIImpl instance, caching up to blockCacheSize blocks
+func NewAPI(db ethdb.KV, dbReader ethdb.Getter, chainContext core.ChainContext, blockCacheSize int) *APIImpl {
+	blockCache, _ := lru.New(blockCacheSize)
 	return &APIImpl{
 		db:           db,
 		dbReader:     dbReader,
 		chainContext: chainContext,
+		blockCache:   blockCache,
 	}
 }
 
@@ -114,8 +127,8 @@ type chainContext struct {
 	db rawdb.DatabaseReader
 }
 
-func NewChainContext(db rawdb.DatabaseReader) *chainContext {
-	headerCache, _ := lru.New(512)
+func NewChainContext(db rawdb.DatabaseReader, headerCacheSize int) *chainContext {
+	headerCache, _ := lru.New(headerCacheSize)
 	return &chainContext{
 		headerCache: headerCache,
 		db:          db,
@@ -191,20 +204,23 @@ func (c *chainContext) Engine() consensus.Engine {
 // GetBlockByNumber see https://github.com/ethereum/wiki/wiki/JSON-RPC#eth_getblockbynumber
 // see internal/ethapi.PublicBlockChainAPI.GetBlockByNumber
 func (api *APIImpl) GetBlockByNumber(ctx context.Context, number rpc.BlockNumber, fullTx bool) (map[string]interface{}, error) {
-	var err error
 	var block *types.Block
 	additionalFields := make(map[string]interface{})
 
-	err = api.db.View(ctx, func(tx ethdb.Tx) error {
-		block, err = remotechain.GetBlockByNumber(tx, uint64(number.Int64()))
+	err := api.db.View(ctx, func(tx ethdb.Tx) error {
+		n, err := blockNumber(tx, number)
 		if err != nil {
 			return err
 		}
-		additionalFields["totalDifficulty"], err = remotechain.ReadTd(tx, block.Hash(), uint64(number.Int64()))
+		hash, err := remotechain.ReadCanonicalHash(tx, n)
 		if err != nil {
 			return err
 		}
-		return nil
+		if hash == (common.Hash{}) {
+			return nil
+		}
+		block, additionalFields["totalDifficulty"], err = api.readBlock(tx, hash, n)
+		return err
 	})
 	if err != nil {
 		return nil, err
@@ -224,6 +240,128 @@ func (api *APIImpl) GetBlockByNumber(ctx context.Context, number rpc.BlockNumber
 	return nil, nil
 }
 
+// GetBlockByHash see https://github.com/ethereum/wiki/wiki/JSON-RPC#eth_getblockbyhash
+// see internal/ethapi.PublicBlockChainAPI.GetBlockByHash
+func (api *APIImpl) GetBlockByHash(ctx context.Context, hash common.Hash, fullTx bool) (map[string]interface{}, error) {
+	var block *types.Block
+	additionalFields := make(map[string]interface{})
+
+	err := api.db.View(ctx, func(tx ethdb.Tx) error {
+		number := rawdb.ReadHeaderNumber(api.dbReader, hash)
+		if number == nil {
+			return nil
+		}
+		var err error
+		block, additionalFields["totalDifficulty"], err = api.readBlock(tx, hash, *number)
+		return err
+	})
+	if err != nil || block == nil {
+		return nil, err
+	}
+	return api.rpcMarshalBlock(block, true, fullTx, additionalFields)
+}
+
+// readBlock reads the block and its total difficulty, the blocks are taken from the cache, when they are there
+func (api *APIImpl) readBlock(tx ethdb.Tx, hash common.Hash, number uint64) (*types.Block, *hexutil.Big, error) {
+	td, err := remotechain.ReadTd(tx, hash, number)
+	if err != nil {
+		return nil, nil, err
+	}
+	if block, ok := api.blockCache.Get(hash); ok {
+		return block.(*types.Block), td, nil
+	}
+	block, err := remotechain.ReadBlock(tx, hash, number)
+	if err != nil || block == nil {
+		return nil, nil, err
+	}
+	api.blockCache.Add(hash, block)
+	return block, td, nil
+}
+
+// blockNumber resolves the latest and the pending block numbers to the head block, there are no pending blocks
+// in the remote database
+func blockNumber(tx ethdb.Tx, number rpc.BlockNumber) (uint64, error) {
+	if number == rpc.LatestBlockNumber || number == rpc.PendingBlockNumber {
+		return remotechain.ReadLastBlockNumber(tx)
+	}
+	return uint64(number.Int64()), nil
+}
+
+// maxTxsPageSize limits the pages of GetTransactionsByAddress, as the offset of etherscan's txlist
+const maxTxsPageSize = 10000
+
+// GetTransactionsByAddress pages through the transactions sent by or to the address in the blocks [fromBlock, toBlock],
+// in the ascending order, similar to etherscan's txlist (the pages start with 1). It uses the transaction address index,
+// so the node has to run with --txaddrindex, and the blocks above the indexed ones are not looked at
+func (api *APIImpl) GetTransactionsByAddress(ctx context.Context, address common.Address, fromBlock, toBlock rpc.BlockNumber, page, pageSize hexutil.Uint64) ([]*ethapi.RPCTransaction, error) {
+	if page == 0 {
+		return nil, fmt.Errorf("page must be positive")
+	}
+	if pageSize == 0 || pageSize > maxTxsPageSize {
+		return nil, fmt.Errorf("page size must be between 1 and %d, got %d", maxTxsPageSize, pageSize)
+	}
+	progress, ok, err := rawdb.ReadTxAddressIndexProgress(api.dbReader)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("transaction address index is not available, enable it by --txaddrindex")
+	}
+	pruned, err := rawdb.ReadTxAddressIndexPruned(api.dbReader)
+	if err != nil {
+		return nil, err
+	}
+	from, to := progress, progress
+	if fromBlock >= 0 {
+		from = uint64(fromBlock)
+	}
+	if toBlock >= 0 && uint64(toBlock) < progress {
+		to = uint64(toBlock)
+	}
+	if from < pruned {
+		return nil, fmt.Errorf("transactions before block %d are pruned from the index", pruned)
+	}
+	blocks, err := rawdb.ReadTxAddressIndex(api.dbReader, address, from, to)
+	if err != nil {
+		return nil, err
+	}
+	skip := uint64(page-1) * uint64(pageSize)
+	txs := make([]*ethapi.RPCTransaction, 0)
+	for _, number := range blocks {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		// The index may contain the blocks of the abandoned forks
+		hash := rawdb.ReadCanonicalHash(api.dbReader, number)
+		body := rawdb.ReadBody(api.dbReader, hash, number)
+		if body == nil {
+			continue
+		}
+		for i, tx := range body.Transactions {
+			var signer types.Signer = types.FrontierSigner{}
+			if tx.Protected() {
+				signer = types.NewEIP155Signer(tx.ChainId())
+			}
+			sender, err := types.Sender(signer, tx)
+			if err != nil {
+				return nil, err
+			}
+			if sender != address && (tx.To() == nil || *tx.To() != address) {
+				continue
+			}
+			if skip > 0 {
+				skip--
+				continue
+			}
+			txs = append(txs, ethapi.NewRPCTransaction(tx, hash, number, uint64(i)))
+			if len(txs) == int(pageSize) {
+				return txs, nil
+			}
+		}
+	}
+	return txs, nil
+}
+
 // StorageRangeAt re-implementation of eth/api.go:StorageRangeAt
 func (api *PrivateDebugAPIImpl) StorageRangeAt(ctx context.Context, blockHash common.Hash, txIndex uint64, contractAddress common.Address, keyStart hexutil.Bytes, maxResult int) (eth.StorageRangeResult, error) {
 	_, _, _, dbstate, err := eth.ComputeTxEnv(ctx, &blockGetter{api.dbReader}, params.MainnetChainConfig, &chainContext{db: api.dbReader}, api.dbReader, blockHash, txIndex)
@@ -236,6 +374,80 @@ func (api *PrivateDebugAPIImpl) StorageRangeAt(ctx context.Context, blockHash co
 	return eth.StorageRangeAt(dbstate, contractAddress, keyStart, maxResult)
 }
 
+// GetModifiedAccountsByNumber re-implementation of eth/api.go:GetModifiedAccountsByNumber
+func (api *PrivateDebugAPIImpl) GetModifiedAccountsByNumber(ctx context.Context, startNum uint64, endNum *uint64) ([]common.Address, error) {
+	from, to, err := modifiedAccountsRange(startNum, endNum)
+	if err != nil {
+		return nil, err
+	}
+	return ethdb.GetModifiedAccounts(api.dbReader, from, to)
+}
+
+// GetModifiedAccountsByHash re-implementation of eth/api.go:GetModifiedAccountsByHash
+func (api *PrivateDebugAPIImpl) GetModifiedAccountsByHash(ctx context.Context, startHash common.Hash, endHash *common.Hash) ([]common.Address, error) {
+	startNum, err := api.canonicalBlockNumber(startHash)
+	if err != nil {
+		return nil, err
+	}
+	var endNum *uint64
+	if endHash != nil {
+		number, err := api.canonicalBlockNumber(*endHash)
+		if err != nil {
+			return nil, err
+		}
+		endNum = &number
+	}
+	return api.GetModifiedAccountsByNumber(ctx, startNum, endNum)
+}
+
+// GetModifiedAccountsRangeByNumber re-implementation of eth/api.go:GetModifiedAccountsRangeByNumber
+func (api *PrivateDebugAPIImpl) GetModifiedAccountsRangeByNumber(ctx context.Context, startNum uint64, endNum *uint64, keyStart hexutil.Bytes, maxResult int) (eth.ModifiedAccountsResult, error) {
+	if maxResult <= 0 {
+		return eth.ModifiedAccountsResult{}, fmt.Errorf("maxResult must be positive, got %d", maxResult)
+	}
+	from, to, err := modifiedAccountsRange(startNum, endNum)
+	if err != nil {
+		return eth.ModifiedAccountsResult{}, err
+	}
+	accounts, next, err := ethdb.GetModifiedAccountsRange(api.dbReader, from, to, keyStart, maxResult)
+	if err != nil {
+		return eth.ModifiedAccountsResult{}, err
+	}
+	return eth.ModifiedAccountsResult{Accounts: accounts, NextKey: next}, nil
+}
+
+// IntermediateHashesRange re-implementation of eth/api.go:IntermediateHashesRange
+func (api *PrivateDebugAPIImpl) IntermediateHashesRange(ctx context.Context, prefix hexutil.Bytes, keyStart hexutil.Bytes, maxResult int) (state.IntermediateHashesRange, error) {
+	return state.ReadIntermediateHashesRange(ctx, api.db, prefix, keyStart, maxResult)
+}
+
+// modifiedAccountsRange converts the arguments of GetModifiedAccounts* into the range of the changesets (both inclusive)
+func modifiedAccountsRange(startNum uint64, endNum *uint64) (uint64, uint64, error) {
+	if endNum == nil {
+		if startNum == 0 {
+			return 0, 0, fmt.Errorf("block %d has no parent", startNum)
+		}
+		return startNum, startNum, nil
+	}
+	if startNum >= *endNum {
+		return 0, 0, fmt.Errorf("start block height (%d) must be less than end block height (%d)", startNum, *endNum)
+	}
+	return startNum + 1, *endNum, nil
+}
+
+// canonicalBlockNumber returns the number of the block, which has to be in the canonical chain,
+// because the changesets are stored by block number
+func (api *PrivateDebugAPIImpl) canonicalBlockNumber(hash common.Hash) (uint64, error) {
+	number := rawdb.ReadHeaderNumber(api.dbReader, hash)
+	if number == nil {
+		return 0, fmt.Errorf("block %x not found", hash)
+	}
+	if rawdb.ReadCanonicalHash(api.dbReader, *number) != hash {
+		return 0, fmt.Errorf("block %x (%d) is not in the canonical chain", hash, *number)
+	}
+	return *number, nil
+}
+
 // computeIntraBlockState retrieves the state database associated with a certain block.
 // If no state is locally available for the given block, a number of blocks are
 // attempted to be reexecuted to generate the desired state.
@@ -264,6 +476,12 @@ func daemon(cmd *cobra.Command, cfg Config) {
 	vhosts := splitAndTrim(cfg.rpcVirtualHost)
 	cors := splitAndTrim(cfg.rpcCORSDomain)
 	enabledApis := splitAndTrim(cfg.rpcAPI)
+	if cfg.blockCacheSize <= 0 || cfg.headerCacheSize <= 0 {
+		log.Error("Block and header caches must be positive", "blockcache", cfg.blockCacheSize, "headercache", cfg.headerCacheSize)
+		return
+	}
+	// The code cache is created on the first state read
+	state.CodeCacheSize = cfg.codeCacheSize * 1024 * 1024
 
 	db, err := ethdb.NewRemote().Path(cfg.remoteDbAddress).Open(cmd.Context())
 	if err != nil {
@@ -274,8 +492,8 @@ func daemon(cmd *cobra.Command, cfg Config) {
 	var rpcAPI = []rpc.API{}
 
 	dbReader := ethdb.NewRemoteBoltDatabase(db)
-	chainContext := NewChainContext(dbReader)
-	apiImpl := NewAPI(db, dbReader, chainContext)
+	chainContext := NewChainContext(dbReader, cfg.headerCacheSize)
+	apiImpl := NewAPI(db, dbReader, chainContext, cfg.blockCacheSize)
 	dbgAPIImpl := NewPrivateDebugAPI(db, dbReader, chainContext)
 
 	for _, enabledAPI := range enabledApis {
@@ -0,0 +1,86 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/holiman/uint256"
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/common/hexutil"
+	"github.com/ledgerwatch/turbo-geth/core/rawdb"
+	"github.com/ledgerwatch/turbo-geth/core/state"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+	"github.com/ledgerwatch/turbo-geth/rpc"
+)
+
+// GetBalance see https://github.com/ethereum/wiki/wiki/JSON-RPC#eth_getbalance
+// see internal/ethapi.PublicBlockChainAPI.GetBalance
+func (api *APIImpl) GetBalance(ctx context.Context, address common.Address, blockNrOrHash rpc.BlockNumberOrHash) (*hexutil.Big, error) {
+	ibs, err := api.stateAt(ctx, blockNrOrHash)
+	if err != nil {
+		return nil, err
+	}
+	return (*hexutil.Big)(ibs.GetBalance(address).ToBig()), ibs.Error()
+}
+
+// GetTransactionCount see https://github.com/ethereum/wiki/wiki/JSON-RPC#eth_gettransactioncount
+// see internal/ethapi.PublicTransactionPoolAPI.GetTransactionCount, the pending transactions are not known to the daemon
+func (api *APIImpl) GetTransactionCount(ctx context.Context, address common.Address, blockNrOrHash rpc.BlockNumberOrHash) (*hexutil.Uint64, error) {
+	ibs, err := api.stateAt(ctx, blockNrOrHash)
+	if err != nil {
+		return nil, err
+	}
+	nonce := ibs.GetNonce(address)
+	return (*hexutil.Uint64)(&nonce), ibs.Error()
+}
+
+// GetCode see https://github.com/ethereum/wiki/wiki/JSON-RPC#eth_getcode
+// see internal/ethapi.PublicBlockChainAPI.GetCode
+func (api *APIImpl) GetCode(ctx context.Context, address common.Address, blockNrOrHash rpc.BlockNumberOrHash) (hexutil.Bytes, error) {
+	ibs, err := api.stateAt(ctx, blockNrOrHash)
+	if err != nil {
+		return nil, err
+	}
+	code := ibs.GetCode(address)
+	return code, ibs.Error()
+}
+
+// GetStorageAt see https://github.com/ethereum/wiki/wiki/JSON-RPC#eth_getstorageat
+// see internal/ethapi.PublicBlockChainAPI.GetStorageAt
+func (api *APIImpl) GetStorageAt(ctx context.Context, address common.Address, key string, blockNrOrHash rpc.BlockNumberOrHash) (hexutil.Bytes, error) {
+	ibs, err := api.stateAt(ctx, blockNrOrHash)
+	if err != nil {
+		return nil, err
+	}
+	keyHash := common.HexToHash(key)
+	var res uint256.Int
+	ibs.GetState(address, &keyHash, &res)
+	return res.Bytes(), ibs.Error()
+}
+
+// stateAt returns the state after the block, read from the history of the remote database.
+// The contract code is read through the process-wide code cache, see state.CodeCacheSize
+func (api *APIImpl) stateAt(ctx context.Context, blockNrOrHash rpc.BlockNumberOrHash) (*state.IntraBlockState, error) {
+	var number uint64
+	if n, ok := blockNrOrHash.Number(); ok {
+		if err := api.db.View(ctx, func(tx ethdb.Tx) error {
+			var err error
+			number, err = blockNumber(tx, n)
+			return err
+		}); err != nil {
+			return nil, err
+		}
+	} else if hash, ok := blockNrOrHash.Hash(); ok {
+		n := rawdb.ReadHeaderNumber(api.dbReader, hash)
+		if n == nil {
+			return nil, fmt.Errorf("block %x not found", hash)
+		}
+		if blockNrOrHash.RequireCanonical && rawdb.ReadCanonicalHash(api.dbReader, *n) != hash {
+			return nil, fmt.Errorf("hash %x is not currently canonical", hash)
+		}
+		number = *n
+	} else {
+		return nil, fmt.Errorf("invalid arguments; neither block nor hash specified")
+	}
+	return state.New(state.NewDbState(api.dbReader, number)), nil
+}
@@ -13,6 +13,7 @@ import (
 
 	"github.com/spf13/cobra"
 
+	"github.com/ledgerwatch/turbo-geth/core/state"
 	"github.com/ledgerwatch/turbo-geth/log"
 	"github.com/ledgerwatch/turbo-geth/node"
 )
@@ -24,6 +25,9 @@ type Config struct {
 	rpcCORSDomain    string
 	rpcVirtualHost   string
 	rpcAPI           string
+	blockCacheSize   int
+	headerCacheSize  int
+	codeCacheSize    int
 }
 
 var (
@@ -43,6 +47,9 @@ func init() {
 	rootCmd.Flags().StringVar(&cfg.rpcCORSDomain, "rpccorsdomain", "", "Comma separated list of domains from which to accept cross origin requests (browser enforced)")
 	rootCmd.Flags().StringVar(&cfg.rpcVirtualHost, "rpcvhosts", strings.Join(node.DefaultConfig.HTTPVirtualHosts, ","), "Comma separated list of virtual hostnames from which to accept requests (server enforced). Accepts '*' wildcard.")
 	rootCmd.Flags().StringVar(&cfg.rpcAPI, "rpcapi", "", "API's offered over the HTTP-RPC interface")
+	rootCmd.Flags().IntVar(&cfg.blockCacheSize, "blockcache", 128, "Number of the blocks cached by the daemon")
+	rootCmd.Flags().IntVar(&cfg.headerCacheSize, "headercache", 512, "Number of the block headers cached by the daemon")
+	rootCmd.Flags().IntVar(&cfg.codeCacheSize, "codecache", state.CodeCacheSize/1024/1024, "Megabytes of memory allocated to the cache of the contract code (0 disables the cache)")
 }
 
 var rootCmd = &cobra.Command{
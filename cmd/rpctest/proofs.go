@@ -2,7 +2,6 @@ package main
 
 import (
 	"bytes"
-	"encoding/binary"
 	"fmt"
 	"net/http"
 	"os"
@@ -218,7 +217,7 @@ func fixState(chaindata string, url string) {
 			sl := trie.NewSubTrieLoader(blockNum)
 			contractPrefix := make([]byte, common.HashLength+common.IncarnationLength)
 			copy(contractPrefix, addrHash[:])
-			binary.BigEndian.PutUint64(contractPrefix[common.HashLength:], ^account.Incarnation)
+			dbutils.EncodeIncarnation(contractPrefix[common.HashLength:], account.Incarnation)
 			rl := trie.NewRetainList(0)
 			subTries, err1 := sl.LoadSubTries(stateDb, blockNum, rl, [][]byte{contractPrefix}, []int{8 * len(contractPrefix)}, false)
 			if err1 != nil || subTries.Hashes[0] != account.Root {
@@ -248,7 +247,7 @@ func fixState(chaindata string, url string) {
 				for key, entry := range sm {
 					var cKey [common.HashLength + common.IncarnationLength + common.HashLength]byte
 					copy(cKey[:], addrHash[:])
-					binary.BigEndian.PutUint64(cKey[common.HashLength:], ^account.Incarnation)
+					dbutils.EncodeIncarnation(cKey[common.HashLength:], account.Incarnation)
 					copy(cKey[common.HashLength+common.IncarnationLength:], key[:])
 					dbValue, _ := stateDb.Get(dbutils.CurrentStateBucket, cKey[:])
 					value := bytes.TrimLeft(entry.Value[:], "\x00")
@@ -261,7 +260,7 @@ func fixState(chaindata string, url string) {
 				}
 				var cKey [common.HashLength + common.IncarnationLength + common.HashLength]byte
 				copy(cKey[:], addrHash[:])
-				binary.BigEndian.PutUint64(cKey[common.HashLength:], ^account.Incarnation)
+				dbutils.EncodeIncarnation(cKey[common.HashLength:], account.Incarnation)
 				err = stateDb.Walk(dbutils.CurrentStateBucket, cKey[:], 8*(common.HashLength+common.IncarnationLength), func(k, v []byte) (bool, error) {
 					var kh common.Hash
 					copy(kh[:], k[common.HashLength+common.IncarnationLength:])
@@ -0,0 +1,193 @@
+// Package chainexport reads and writes the RLP chain export format of go-ethereum (the stream of RLP encoded blocks
+// produced by `geth export` and consumed by `geth import`) directly from and into the turbo-geth buckets,
+// without executing the blocks
+package chainexport
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/core/rawdb"
+	"github.com/ledgerwatch/turbo-geth/core/types"
+	"github.com/ledgerwatch/turbo-geth/eth/downloader"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+	"github.com/ledgerwatch/turbo-geth/log"
+	"github.com/ledgerwatch/turbo-geth/rlp"
+)
+
+// logInterval is how often the progress is reported
+const logInterval = 30 * time.Second
+
+// HeadBlock returns the number of the head block of the database
+func HeadBlock(db ethdb.Database) (uint64, error) {
+	hash := rawdb.ReadHeadBlockHash(db)
+	if hash == (common.Hash{}) {
+		return 0, errors.New("head block not found")
+	}
+	number := rawdb.ReadHeaderNumber(db, hash)
+	if number == nil {
+		return 0, fmt.Errorf("number of the head block %x not found", hash)
+	}
+	return *number, nil
+}
+
+// ExportFile writes the canonical blocks from..to (including) into the file, the file is gzipped if its name ends with .gz
+func ExportFile(db ethdb.Database, fn string, from, to uint64) (err error) {
+	fh, err := os.OpenFile(fn, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := fh.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+	if !strings.HasSuffix(fn, ".gz") {
+		return Export(db, fh, from, to)
+	}
+	gw := gzip.NewWriter(fh)
+	if err := Export(db, gw, from, to); err != nil {
+		gw.Close()
+		return err
+	}
+	// The gzip footer is written by Close
+	return gw.Close()
+}
+
+// Export writes the canonical blocks from..to (including) RLP encoded one after another
+func Export(db ethdb.Database, w io.Writer, from, to uint64) error {
+	if from > to {
+		return fmt.Errorf("export failed: from (%d) is greater than to (%d)", from, to)
+	}
+	log.Info("Exporting blocks", "from", from, "to", to)
+	reported := time.Now()
+	for number := from; number <= to; number++ {
+		hash := rawdb.ReadCanonicalHash(db, number)
+		if hash == (common.Hash{}) {
+			return fmt.Errorf("export failed on #%d: canonical hash not found", number)
+		}
+		block := rawdb.ReadBlock(db, hash, number)
+		if block == nil {
+			return fmt.Errorf("export failed on #%d: block %x not found", number, hash)
+		}
+		if err := block.EncodeRLP(w); err != nil {
+			return err
+		}
+		if time.Since(reported) >= logInterval {
+			log.Info("Exporting blocks", "number", number, "to", to)
+			reported = time.Now()
+		}
+	}
+	log.Info("Export finished", "blocks", to-from+1)
+	return nil
+}
+
+// ImportFile reads the blocks from the export file, which is gunzipped if its name ends with .gz
+func ImportFile(db ethdb.Database, fn string) (uint64, error) {
+	fh, err := os.Open(fn)
+	if err != nil {
+		return 0, err
+	}
+	defer fh.Close()
+	var r io.Reader = fh
+	if strings.HasSuffix(fn, ".gz") {
+		if r, err = gzip.NewReader(fh); err != nil {
+			return 0, err
+		}
+	}
+	return Import(db, r)
+}
+
+// Import writes the headers, the bodies and the total difficulties of the exported blocks into the database,
+// and makes them canonical. The blocks are not executed: the database has to contain the genesis block
+// (with its state), and the Headers and Bodies stages are advanced, so that the staged sync executes the imported
+// blocks. The blocks which are already canonical are skipped, so an interrupted import can be repeated, and the import
+// of the blocks diverging from the canonical chain is refused.
+// Returns the number of the last imported block
+func Import(db ethdb.Database, r io.Reader) (uint64, error) {
+	genesis := rawdb.ReadCanonicalHash(db, 0)
+	if genesis == (common.Hash{}) {
+		return 0, errors.New("genesis block not found, the database has to be initialised first")
+	}
+	stream := rlp.NewStream(r, 0)
+	batch := db.NewBatch()
+	defer batch.Rollback()
+	var last uint64
+	var imported int
+	reported := time.Now()
+	for {
+		var block types.Block
+		if err := stream.Decode(&block); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return 0, fmt.Errorf("decoding block after #%d: %w", last, err)
+		}
+		number, hash := block.NumberU64(), block.Hash()
+		if number == 0 {
+			if hash != genesis {
+				return 0, fmt.Errorf("genesis of the export %x does not match the genesis of the database %x", hash, genesis)
+			}
+			continue
+		}
+		if last != 0 && number != last+1 {
+			return 0, fmt.Errorf("export is not contiguous, block #%d follows #%d", number, last)
+		}
+		last = number
+		if canonical := rawdb.ReadCanonicalHash(batch, number); canonical == hash {
+			continue
+		} else if canonical != (common.Hash{}) {
+			// The state of the database may have been executed past the diverging block, which needs the unwind
+			return 0, fmt.Errorf("block #%d %x diverges from the canonical block %x, unwind the database first", number, hash, canonical)
+		}
+		parentTd := rawdb.ReadTd(batch, block.ParentHash(), number-1)
+		if parentTd == nil || rawdb.ReadCanonicalHash(batch, number-1) != block.ParentHash() {
+			return 0, fmt.Errorf("parent %x of block #%d is not canonical", block.ParentHash(), number)
+		}
+		rawdb.WriteBlock(context.Background(), batch, &block)
+		rawdb.WriteTd(batch, hash, number, new(big.Int).Add(parentTd, block.Difficulty()))
+		rawdb.WriteCanonicalHash(batch, hash, number)
+		imported++
+		if batch.BatchSize() >= batch.IdealBatchSize() {
+			if err := commitImport(batch, hash, number); err != nil {
+				return 0, err
+			}
+		}
+		if time.Since(reported) >= logInterval {
+			log.Info("Importing blocks", "number", number, "imported", imported)
+			reported = time.Now()
+		}
+	}
+	if imported > 0 {
+		if err := commitImport(batch, rawdb.ReadCanonicalHash(batch, last), last); err != nil {
+			return 0, err
+		}
+	}
+	log.Info("Import finished", "imported", imported, "last", last)
+	return last, nil
+}
+
+// commitImport makes the block the head header and advances the stages, the data for which has been imported
+func commitImport(batch ethdb.DbWithPendingMutations, hash common.Hash, number uint64) error {
+	rawdb.WriteHeadHeaderHash(batch, hash)
+	for _, stage := range []downloader.SyncStage{downloader.Headers, downloader.Bodies} {
+		progress, err := downloader.GetStageProgress(batch, stage)
+		if err != nil {
+			return err
+		}
+		if progress < number {
+			if err := downloader.SaveStageProgress(batch, stage, number); err != nil {
+				return err
+			}
+		}
+	}
+	_, err := batch.Commit()
+	return err
+}
@@ -0,0 +1,82 @@
+package chainexport
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/consensus/ethash"
+	"github.com/ledgerwatch/turbo-geth/core"
+	"github.com/ledgerwatch/turbo-geth/core/rawdb"
+	"github.com/ledgerwatch/turbo-geth/eth/downloader"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+	"github.com/ledgerwatch/turbo-geth/params"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportImport(t *testing.T) {
+	gspec := &core.Genesis{Config: params.TestChainConfig}
+	db := ethdb.NewMemDatabase()
+	genesis := gspec.MustCommit(db)
+	blocks, _ := core.GenerateChain(context.Background(), gspec.Config, genesis, ethash.NewFaker(), db.MemCopy(), 10, nil)
+	td := rawdb.ReadTd(db, genesis.Hash(), 0)
+	for _, block := range blocks {
+		rawdb.WriteBlock(context.Background(), db, block)
+		td.Add(td, block.Difficulty())
+		rawdb.WriteTd(db, block.Hash(), block.NumberU64(), td)
+		rawdb.WriteCanonicalHash(db, block.Hash(), block.NumberU64())
+	}
+	rawdb.WriteHeadBlockHash(db, blocks[len(blocks)-1].Hash())
+	head, err := HeadBlock(db)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(10), head)
+
+	var buf bytes.Buffer
+	require.NoError(t, Export(db, &buf, 0, 6))
+	exported := buf.Bytes()
+
+	db2 := ethdb.NewMemDatabase()
+	gspec.MustCommit(db2)
+	last, err := Import(db2, bytes.NewReader(exported))
+	require.NoError(t, err)
+	assert.Equal(t, uint64(6), last)
+	for _, block := range blocks[:6] {
+		number := block.NumberU64()
+		assert.Equal(t, block.Hash(), rawdb.ReadCanonicalHash(db2, number))
+		assert.Equal(t, block.Hash(), rawdb.ReadBlock(db2, block.Hash(), number).Hash())
+		assert.Equal(t, rawdb.ReadTd(db, block.Hash(), number), rawdb.ReadTd(db2, block.Hash(), number))
+	}
+	assert.Equal(t, blocks[5].Hash(), rawdb.ReadHeadHeaderHash(db2))
+	progress, err := downloader.GetStageProgress(db2, downloader.Bodies)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(6), progress)
+
+	// The import continues from the overlapping export
+	buf.Reset()
+	require.NoError(t, Export(db, &buf, 4, 10))
+	last, err = Import(db2, &buf)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(10), last)
+	assert.Equal(t, blocks[9].Hash(), rawdb.ReadHeadHeaderHash(db2))
+
+	// The diverging chain is refused
+	forked, _ := core.GenerateChain(context.Background(), gspec.Config, genesis, ethash.NewFaker(), db.MemCopy(), 2, func(i int, gen *core.BlockGen) {
+		gen.SetExtra([]byte("fork"))
+	})
+	buf.Reset()
+	for _, block := range forked {
+		require.NoError(t, block.EncodeRLP(&buf))
+	}
+	_, err = Import(db2, &buf)
+	assert.Error(t, err)
+	assert.Equal(t, blocks[0].Hash(), rawdb.ReadCanonicalHash(db2, 1))
+
+	// Export of a different chain is refused
+	db3 := ethdb.NewMemDatabase()
+	(&core.Genesis{Config: params.TestChainConfig, ExtraData: []byte("other")}).MustCommit(db3)
+	_, err = Import(db3, bytes.NewReader(exported))
+	assert.Error(t, err)
+	assert.Equal(t, common.Hash{}, rawdb.ReadCanonicalHash(db3, 1))
+}
@@ -0,0 +1,28 @@
+package commands
+
+import (
+	"github.com/ledgerwatch/turbo-geth/cmd/state/stats"
+	"github.com/spf13/cobra"
+)
+
+var (
+	changeSetStatsFrom uint64
+	changeSetStatsTo   uint64
+)
+
+func init() {
+	withChaindata(changeSetStatsCmd)
+	withStatsfile(changeSetStatsCmd)
+	withReadonly(changeSetStatsCmd)
+	changeSetStatsCmd.Flags().Uint64Var(&changeSetStatsFrom, "from", 0, "first block")
+	changeSetStatsCmd.Flags().Uint64Var(&changeSetStatsTo, "to", 0, "last block (0 = the head block)")
+	rootCmd.AddCommand(changeSetStatsCmd)
+}
+
+var changeSetStatsCmd = &cobra.Command{
+	Use:   "changesetStats",
+	Short: "Writes the number of the account and storage changes and the size of the changesets per block into the csv file",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return stats.ChangeSetStats(chaindata, changeSetStatsFrom, changeSetStatsTo, statsfile, readonly)
+	},
+}
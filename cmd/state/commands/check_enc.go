@@ -8,6 +8,7 @@ import (
 func init() {
 	withChaindata(checkEncCmd)
 	withStatsfile(checkEncCmd)
+	withReadonly(checkEncCmd)
 	rootCmd.AddCommand(checkEncCmd)
 }
 
@@ -15,6 +16,6 @@ var checkEncCmd = &cobra.Command{
 	Use:   "checkEnc",
 	Short: "Check changesets Encoding",
 	RunE: func(cmd *cobra.Command, args []string) error {
-		return verify.CheckEnc(chaindata)
+		return verify.CheckEnc(chaindata, readonly)
 	},
 }
@@ -0,0 +1,26 @@
+package commands
+
+import (
+	"github.com/ledgerwatch/turbo-geth/cmd/state/verify"
+	"github.com/spf13/cobra"
+)
+
+var (
+	checkIHSample int
+	checkIHFix    bool
+)
+
+func init() {
+	withChaindata(checkIHCmd)
+	checkIHCmd.Flags().IntVar(&checkIHSample, "sample", 1, "check only every n-th intermediate hash (1 = all)")
+	checkIHCmd.Flags().BoolVar(&checkIHFix, "fix", false, "delete the intermediate hashes, which do not match the state")
+	rootCmd.AddCommand(checkIHCmd)
+}
+
+var checkIHCmd = &cobra.Command{
+	Use:   "checkIH",
+	Short: "Recomputes the intermediate trie hashes from the state, reports (and optionally deletes) the wrong and the dangling ones",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return verify.CheckIntermediateHashes(chaindata, checkIHSample, checkIHFix)
+	},
+}
@@ -0,0 +1,19 @@
+package commands
+
+import (
+	"github.com/ledgerwatch/turbo-geth/cmd/state/verify"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	withChaindata(checkIncarnationsCmd)
+	rootCmd.AddCommand(checkIncarnationsCmd)
+}
+
+var checkIncarnationsCmd = &cobra.Command{
+	Use:   "checkIncarnations",
+	Short: "Checks that the incarnation of every storage item matches the current or a historical incarnation of its account, reports the orphans",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return verify.CheckIncarnations(chaindata)
+	},
+}
@@ -9,6 +9,7 @@ func init() {
 	withChaindata(checkIndexCMD)
 	withIndexBucket(checkIndexCMD)
 	withCSBucket(checkIndexCMD)
+	withReadonly(checkIndexCMD)
 	rootCmd.AddCommand(checkIndexCMD)
 }
 
@@ -16,6 +17,6 @@ var checkIndexCMD = &cobra.Command{
 	Use:   "checkIndex",
 	Short: "Index checker",
 	RunE: func(cmd *cobra.Command, args []string) error {
-		return verify.CheckIndex(chaindata, []byte(changeSetBucket), []byte(indexBucket))
+		return verify.CheckIndex(chaindata, []byte(changeSetBucket), []byte(indexBucket), readonly)
 	},
 }
@@ -0,0 +1,31 @@
+package commands
+
+import (
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+	"github.com/ledgerwatch/turbo-geth/log"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	withChaindata(compactChangeSetsCmd)
+	withBlock(compactChangeSetsCmd)
+	rootCmd.AddCommand(compactChangeSetsCmd)
+}
+
+var compactChangeSetsCmd = &cobra.Command{
+	Use:   "compactChangeSets",
+	Short: "Merges the changesets of the blocks below --block into epochs. The block has to be far enough behind the head to never be unwound",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		db, err := ethdb.NewBoltDatabase(chaindata)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		epochs, err := ethdb.CompactChangeSets(db, block)
+		if err != nil {
+			return err
+		}
+		log.Info("Compaction finished", "epochs", epochs)
+		return nil
+	},
+}
@@ -0,0 +1,35 @@
+package commands
+
+import (
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/core/rawdb"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+	"github.com/ledgerwatch/turbo-geth/log"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	withChaindata(compactReceiptsCmd)
+	withBlock(compactReceiptsCmd)
+	rootCmd.AddCommand(compactReceiptsCmd)
+}
+
+var compactReceiptsCmd = &cobra.Command{
+	Use:   "compactReceipts",
+	Short: "Re-encodes the receipts of the epochs below --block with the dictionaries of the log addresses and topics, and reports the sizes",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		db, err := ethdb.NewBoltDatabase(chaindata)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		stats, err := rawdb.CompactReceipts(db, block)
+		if err != nil {
+			return err
+		}
+		log.Info("Compaction finished", "epochs", stats.Epochs, "blocks", stats.Blocks,
+			"before", common.StorageSize(stats.Before), "after", common.StorageSize(stats.After),
+			"dictionaries", common.StorageSize(stats.Dictionaries))
+		return nil
+	},
+}
@@ -0,0 +1,130 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+	"github.com/spf13/cobra"
+)
+
+var (
+	compactTo        string
+	compactBatchSize int
+	scrubBuckets     string
+	scrubFix         bool
+)
+
+func init() {
+	withChaindata(dbReportCmd)
+	dbCmd.AddCommand(dbReportCmd)
+
+	withChaindata(dbCompactCmd)
+	dbCompactCmd.Flags().StringVar(&compactTo, "to", "", "path to the compacted database file (must not exist)")
+	dbCompactCmd.Flags().IntVar(&compactBatchSize, "batchSize", 512*1024*1024, "max size (in bytes) of data written by one transaction")
+	must(dbCompactCmd.MarkFlagRequired("to"))
+	must(dbCompactCmd.MarkFlagFilename("to", ""))
+	dbCmd.AddCommand(dbCompactCmd)
+
+	withChaindata(dbScrubCmd)
+	dbScrubCmd.Flags().StringVar(&scrubBuckets, "buckets", "", "comma separated list of the buckets to scrub (default: the chain data buckets checksummed by the node)")
+	dbScrubCmd.Flags().BoolVar(&scrubFix, "fix", false, "record the recomputed checksums of the mismatching ranges, once the corruption has been dealt with")
+	dbCmd.AddCommand(dbScrubCmd)
+
+	rootCmd.AddCommand(dbCmd)
+}
+
+var dbCmd = &cobra.Command{
+	Use:   "db",
+	Short: "Maintenance of the Bolt database file",
+}
+
+var dbReportCmd = &cobra.Command{
+	Use:   "report",
+	Short: "Prints per-bucket page utilisation, overflow pages and freelist size",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		report, err := ethdb.ReportBoltPages(chaindata)
+		if err != nil {
+			return err
+		}
+		printBoltReport(report)
+		return nil
+	},
+}
+
+var dbCompactCmd = &cobra.Command{
+	Use:   "compact",
+	Short: "Rebuilds the database into a new file with sequential inserts, reclaiming the space of deleted records",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := ethdb.CompactBolt(chaindata, compactTo, compactBatchSize); err != nil {
+			return err
+		}
+		before, err := os.Stat(chaindata)
+		if err != nil {
+			return err
+		}
+		after, err := os.Stat(compactTo)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Compacted %s (%s) into %s (%s)\n", chaindata, common.StorageSize(before.Size()), compactTo, common.StorageSize(after.Size()))
+		return nil
+	},
+}
+
+func printBoltReport(report *ethdb.BoltFileReport) {
+	buckets := report.Buckets
+	sort.Slice(buckets, func(i, j int) bool {
+		return buckets[i].Alloc > buckets[j].Alloc
+	})
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 1, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(w, "bucket\tkeys\tdepth\tbranch pages\tleaf pages\toverflow pages\tallocated\tin use\tfill\t\n")
+	for _, b := range buckets {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%d\t%s\t%s\t%.1f%%\t\n",
+			b.Name, b.Keys, b.Depth, b.BranchPages, b.LeafPages, b.OverflowPages,
+			common.StorageSize(b.Alloc), common.StorageSize(b.Inuse), 100*b.FillRatio())
+	}
+	w.Flush()
+	fmt.Printf("\nFile size: %s, page size: %d\n", common.StorageSize(report.FileSize), report.PageSize)
+	fmt.Printf("Freelist: %d free pages (%s), %d pending pages, freelist itself takes %s\n",
+		report.FreePages, common.StorageSize(report.FreePages*report.PageSize), report.PendingPages, common.StorageSize(report.FreelistInuse))
+}
+
+var dbScrubCmd = &cobra.Command{
+	Use:   "scrub",
+	Short: "Verifies the records of the buckets against the checksums maintained by the node (--bolt.scrub.interval), to detect silent disk corruption",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		db, err := ethdb.NewBoltDatabase(chaindata)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		buckets := ethdb.DefaultChecksumBuckets
+		if scrubBuckets != "" {
+			buckets = nil
+			for _, name := range strings.Split(scrubBuckets, ",") {
+				buckets = append(buckets, []byte(name))
+			}
+		}
+		var mismatches int
+		if err = db.ScrubBuckets(buckets, func(m *ethdb.ChecksumMismatch) error {
+			mismatches++
+			fmt.Printf("Mismatch: %s\n", m)
+			if scrubFix {
+				return db.RecordRangeChecksum(m.Bucket, m.Range)
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		if mismatches > 0 && !scrubFix {
+			return fmt.Errorf("%d key ranges do not match their checksums", mismatches)
+		}
+		fmt.Printf("Scrubbed %d buckets, %d mismatches\n", len(buckets), mismatches)
+		return nil
+	},
+}
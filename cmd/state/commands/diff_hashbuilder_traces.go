@@ -0,0 +1,51 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/ledgerwatch/turbo-geth/trie"
+	"github.com/spf13/cobra"
+)
+
+var (
+	traceA       string
+	traceB       string
+	traceContext int
+)
+
+func init() {
+	diffHashBuilderTracesCmd.Flags().StringVar(&traceA, "a", "", "path to the first trace of the hash builder opcodes")
+	diffHashBuilderTracesCmd.Flags().StringVar(&traceB, "b", "", "path to the second trace of the hash builder opcodes")
+	diffHashBuilderTracesCmd.Flags().IntVar(&traceContext, "context", 10, "number of the common opcodes printed before the divergence")
+	must(diffHashBuilderTracesCmd.MarkFlagRequired("a"))
+	must(diffHashBuilderTracesCmd.MarkFlagRequired("b"))
+	rootCmd.AddCommand(diffHashBuilderTracesCmd)
+}
+
+var diffHashBuilderTracesCmd = &cobra.Command{
+	Use:   "diffHashBuilderTraces",
+	Short: "Compares two traces of the hash builder opcodes (written with --trace) and prints the first divergence",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		a, err := os.Open(traceA)
+		if err != nil {
+			return err
+		}
+		defer a.Close()
+		b, err := os.Open(traceB)
+		if err != nil {
+			return err
+		}
+		defer b.Close()
+		d, err := trie.DiffTraces(a, b, traceContext)
+		if err != nil {
+			return err
+		}
+		if d == nil {
+			fmt.Println("traces are the same")
+			return nil
+		}
+		fmt.Print(d)
+		return nil
+	},
+}
@@ -0,0 +1,68 @@
+package commands
+
+import (
+	"github.com/ledgerwatch/turbo-geth/cmd/state/chainexport"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+	"github.com/ledgerwatch/turbo-geth/log"
+	"github.com/spf13/cobra"
+)
+
+var (
+	exportFrom uint64
+	exportTo   uint64
+)
+
+func withExportFile(cmd *cobra.Command) {
+	cmd.Flags().StringVarP(&exportFilePath, "file", "f", "", "path to the RLP chain export file, gzipped if the name ends with .gz")
+	must(cmd.MarkFlagFilename("file", ""))
+	must(cmd.MarkFlagRequired("file"))
+}
+
+func init() {
+	withChaindata(exportChainCmd)
+	withExportFile(exportChainCmd)
+	exportChainCmd.Flags().Uint64Var(&exportFrom, "from", 0, "first block to export")
+	exportChainCmd.Flags().Uint64Var(&exportTo, "to", 0, "last block to export (0 = the head block)")
+	rootCmd.AddCommand(exportChainCmd)
+
+	withChaindata(importChainCmd)
+	withExportFile(importChainCmd)
+	rootCmd.AddCommand(importChainCmd)
+}
+
+var exportChainCmd = &cobra.Command{
+	Use:   "exportChain",
+	Short: "Writes the canonical blocks into a file in the format of `geth export`, which can be imported by `geth import`",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		db, err := ethdb.NewBoltDatabase(chaindata)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		to := exportTo
+		if to == 0 {
+			if to, err = chainexport.HeadBlock(db); err != nil {
+				return err
+			}
+		}
+		return chainexport.ExportFile(db, exportFilePath, exportFrom, to)
+	},
+}
+
+var importChainCmd = &cobra.Command{
+	Use:   "importChain",
+	Short: "Writes the blocks from a file in the format of `geth export` into the database initialised with the same genesis, the staged sync executes them",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		db, err := ethdb.NewBoltDatabase(chaindata)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		last, err := chainexport.ImportFile(db, exportFilePath)
+		if err != nil {
+			return err
+		}
+		log.Info("Blocks imported", "last", last)
+		return nil
+	},
+}
@@ -0,0 +1,95 @@
+package commands
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/core/state/stateexport"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+	"github.com/ledgerwatch/turbo-geth/log"
+	"github.com/ledgerwatch/turbo-geth/trie"
+	"github.com/spf13/cobra"
+)
+
+var (
+	stateExportFilePath  string
+	hashBuilderTracePath string
+)
+
+func withStateExportFile(cmd *cobra.Command) {
+	cmd.Flags().StringVarP(&stateExportFilePath, "file", "f", "", "path to the state export file, gzipped if the name ends with .gz")
+	must(cmd.MarkFlagFilename("file", ""))
+	must(cmd.MarkFlagRequired("file"))
+}
+
+func init() {
+	withChaindata(exportStateCmd)
+	withBlock(exportStateCmd)
+	withStateExportFile(exportStateCmd)
+	withReadonly(exportStateCmd)
+	rootCmd.AddCommand(exportStateCmd)
+
+	withStateExportFile(verifyStateExportCmd)
+	verifyStateExportCmd.Flags().StringVar(&hashBuilderTracePath, "trace", "", "path to the file receiving the trace of the hash builder opcodes, see diffHashBuilderTraces")
+	rootCmd.AddCommand(verifyStateExportCmd)
+}
+
+var exportStateCmd = &cobra.Command{
+	Use:   "exportState",
+	Short: "Writes the state at the block into the deterministic file with the hashes of its chunks, which can be loaded into the in-memory database as a test fixture",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		db, err := ethdb.OpenBoltDatabase(chaindata, readonly)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		_, err = stateexport.ExportFile(db, stateExportFilePath, block)
+		return err
+	},
+}
+
+var verifyStateExportCmd = &cobra.Command{
+	Use:   "verifyStateExport",
+	Short: "Loads the state export file into the in-memory database and checks the hashes of its chunks and the state root",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		db, header, err := stateexport.LoadMemDatabase(stateExportFilePath)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		loader := trie.NewFlatDbSubTrieLoader()
+		if err = loader.Reset(db, trie.NewRetainList(0), [][]byte{nil}, []int{0}, false); err != nil {
+			return err
+		}
+		var tracer *trie.JSONTracer
+		if hashBuilderTracePath != "" {
+			f, err := os.Create(hashBuilderTracePath)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			w := bufio.NewWriter(f)
+			defer w.Flush()
+			tracer = trie.NewJSONTracer(w)
+			loader.SetHashBuilderTracer(tracer)
+		}
+		subTries, err := loader.LoadSubTries()
+		if err != nil {
+			return err
+		}
+		if tracer != nil && tracer.Err() != nil {
+			return tracer.Err()
+		}
+		root := trie.EmptyRoot
+		if len(subTries.Hashes) > 0 && subTries.Hashes[0] != (common.Hash{}) {
+			root = subTries.Hashes[0]
+		}
+		if root != header.Root {
+			return fmt.Errorf("state root mismatch at block %d: %x, expected %x", header.Block, root, header.Root)
+		}
+		log.Info("State export verified", "block", header.Block, "root", root)
+		return nil
+	},
+}
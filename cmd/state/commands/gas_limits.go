@@ -3,7 +3,6 @@ package commands
 import (
 	"fmt"
 
-	"github.com/ledgerwatch/bolt"
 	"github.com/ledgerwatch/turbo-geth/cmd/state/stateless"
 	"github.com/ledgerwatch/turbo-geth/ethdb"
 	"github.com/spf13/cobra"
@@ -20,7 +19,7 @@ var gasLimitsCmd = &cobra.Command{
 	RunE: func(cmd *cobra.Command, args []string) error {
 		ctx := cmd.Context()
 
-		localDB, err := bolt.Open(file()+"_gl", 0600, &bolt.Options{})
+		localDB, err := ethdb.NewBolt().Path(file() + "_gl").Open(ctx)
 		if err != nil {
 			panic(err)
 		}
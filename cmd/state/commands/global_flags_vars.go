@@ -12,6 +12,7 @@ var (
 	remoteDbAddress string
 	changeSetBucket string
 	indexBucket     string
+	readonly        bool
 )
 
 func must(err error) {
@@ -45,3 +46,7 @@ func withCSBucket(cmd *cobra.Command) {
 func withIndexBucket(cmd *cobra.Command) {
 	cmd.Flags().StringVar(&indexBucket, "index-bucket", string(dbutils.AccountsHistoryBucket), string(dbutils.AccountsHistoryBucket)+" for account and "+string(dbutils.StorageHistoryBucket)+" for storage")
 }
+
+func withReadonly(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&readonly, "readonly", false, "open the database in the read-only mode, so it can't be modified by accident and can be opened by several readers at once")
+}
@@ -0,0 +1,51 @@
+package commands
+
+import (
+	"path/filepath"
+
+	"github.com/ledgerwatch/turbo-geth/cmd/state/gethimport"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+	"github.com/spf13/cobra"
+)
+
+var (
+	gethdata    string
+	ancientdata string
+	stateMode   string
+)
+
+func init() {
+	withChaindata(importGethCmd)
+	importGethCmd.Flags().StringVar(&gethdata, "gethdata", "", "path to the chaindata directory of go-ethereum (LevelDB)")
+	importGethCmd.Flags().StringVar(&ancientdata, "ancient", "", "path to the freezer of ancient blocks of go-ethereum (default = <gethdata>/ancient)")
+	importGethCmd.Flags().StringVar(&stateMode, "state", gethimport.StateFlatten, gethimport.StateFlatten+" to copy the latest state persisted by geth, "+gethimport.StateReplay+" to commit the genesis state and execute the blocks by the staged sync")
+	must(importGethCmd.MarkFlagRequired("gethdata"))
+	must(importGethCmd.MarkFlagDirname("gethdata"))
+	rootCmd.AddCommand(importGethCmd)
+}
+
+var importGethCmd = &cobra.Command{
+	Use:   "importGeth",
+	Short: "Imports the chaindata of a stopped go-ethereum node (blocks, receipts and state) into the turbo-geth database. Can be restarted after interruption",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		src, err := gethimport.OpenLevelDB(gethdata)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		if ancientdata == "" {
+			ancientdata = filepath.Join(gethdata, "ancient")
+		}
+		freezer, err := gethimport.OpenFreezer(ancientdata)
+		if err != nil {
+			return err
+		}
+		defer freezer.Close()
+		db, err := ethdb.NewBoltDatabase(chaindata)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		return gethimport.Import(gethimport.NewChain(src, freezer), db, stateMode)
+	},
+}
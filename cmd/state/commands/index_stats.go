@@ -9,6 +9,7 @@ func init() {
 	withChaindata(indexStatsCmd)
 	withStatsfile(indexStatsCmd)
 	withIndexBucket(indexStatsCmd)
+	withReadonly(indexStatsCmd)
 	rootCmd.AddCommand(indexStatsCmd)
 }
 
@@ -19,6 +20,6 @@ var indexStatsCmd = &cobra.Command{
 		if statsfile == "stateless.csv" {
 			statsfile = ""
 		}
-		return stats.IndexStats(chaindata, []byte(indexBucket), statsfile)
+		return stats.IndexStats(chaindata, []byte(indexBucket), statsfile, readonly)
 	},
 }
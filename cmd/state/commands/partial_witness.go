@@ -0,0 +1,31 @@
+package commands
+
+import (
+	"github.com/ledgerwatch/turbo-geth/cmd/state/stateless"
+	"github.com/spf13/cobra"
+)
+
+var (
+	retainFile    string
+	witnessOutput string
+	binaryTrie    bool
+)
+
+func init() {
+	withChaindata(partialWitnessCmd)
+	partialWitnessCmd.Flags().StringVar(&retainFile, "retainFile", "", "file listing the accounts (address) and storage items (address and storage key) to cover, one per line")
+	partialWitnessCmd.Flags().StringVar(&witnessOutput, "output", "witness.bin", "path to the file where to write the serialised witness")
+	partialWitnessCmd.Flags().BoolVar(&binaryTrie, "binary", false, "produce the witness for the binary trie (experimental)")
+	must(partialWitnessCmd.MarkFlagRequired("retainFile"))
+	must(partialWitnessCmd.MarkFlagFilename("retainFile", ""))
+	must(partialWitnessCmd.MarkFlagFilename("output", "bin"))
+	rootCmd.AddCommand(partialWitnessCmd)
+}
+
+var partialWitnessCmd = &cobra.Command{
+	Use:   "partialWitness",
+	Short: "Produces the witness of the current state covering only the accounts and storage items listed in the file",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return stateless.PartialWitness(chaindata, retainFile, witnessOutput, binaryTrie)
+	},
+}
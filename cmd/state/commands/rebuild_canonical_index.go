@@ -0,0 +1,38 @@
+package commands
+
+import (
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/core/rawdb"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+	"github.com/ledgerwatch/turbo-geth/log"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	withChaindata(rebuildCanonicalIndexCmd)
+	rootCmd.AddCommand(rebuildCanonicalIndexCmd)
+}
+
+var rebuildCanonicalIndexCmd = &cobra.Command{
+	Use:   "rebuildCanonicalIndex",
+	Short: "Restores the canonical number->hash index from the headers and their total difficulties, choosing the heaviest chain",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		db, err := ethdb.NewBoltDatabase(chaindata)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		report, err := rawdb.RebuildCanonicalIndex(db)
+		if err != nil {
+			return err
+		}
+		log.Info("Canonical index rebuilt", "head", report.Head, "hash", report.HeadHash, "td", report.HeadTd,
+			"written", report.Written, "deleted", report.Deleted)
+		if headBlock := rawdb.ReadHeadBlockHash(db); headBlock != (common.Hash{}) {
+			if number := rawdb.ReadHeaderNumber(db, headBlock); number == nil || rawdb.ReadCanonicalHash(db, *number) != headBlock {
+				log.Warn("Head block is not canonical anymore, the state has to be unwound", "hash", headBlock)
+			}
+		}
+		return nil
+	},
+}
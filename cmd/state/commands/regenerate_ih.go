@@ -0,0 +1,38 @@
+package commands
+
+import (
+	"github.com/ledgerwatch/turbo-geth/cmd/state/generate"
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/common/debug"
+	"github.com/spf13/cobra"
+)
+
+var (
+	ihDatadir string
+	ihDepth   int
+	ihRoot    string
+	ihWitness bool
+)
+
+func init() {
+	withChaindata(regenerateIHCmd)
+	regenerateIHCmd.Flags().StringVar(&ihDatadir, "datadir", "", "directory for the temporary files of the sorting (default: the directory of the chaindata)")
+	regenerateIHCmd.Flags().IntVar(&ihDepth, "depth", 0, "keep only the hashes of the prefixes up to this number of nibbles, counted from the storage root for the storage (0 = all)")
+	regenerateIHCmd.Flags().StringVar(&ihRoot, "root", "", "expected state root (hex), the regeneration fails if the computed root is different")
+	regenerateIHCmd.Flags().BoolVar(&ihWitness, "trie-witness-len", false, "also rebuild the witness lengths of the sub-tries, as the node with --trie-witness-len keeps them")
+	must(regenerateIHCmd.MarkFlagDirname("datadir"))
+	rootCmd.AddCommand(regenerateIHCmd)
+}
+
+var regenerateIHCmd = &cobra.Command{
+	Use:   "regenerateIH",
+	Short: "Drops and rebuilds the intermediate trie hashes from the current state",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var root common.Hash
+		if ihRoot != "" {
+			root = common.HexToHash(ihRoot)
+		}
+		debug.OverrideTrackWitnessSize(ihWitness)
+		return generate.RegenerateIntermediateHashes(chaindata, ihDatadir, ihDepth, root)
+	},
+}
@@ -0,0 +1,32 @@
+package commands
+
+import (
+	"os"
+
+	"github.com/ledgerwatch/turbo-geth/cmd/state/stateless"
+	"github.com/spf13/cobra"
+)
+
+var (
+	tracer     string
+	tracefile  string
+	verifyRoot bool
+)
+
+func init() {
+	withBlock(replayBlockCmd)
+	withChaindata(replayBlockCmd)
+	withReadonly(replayBlockCmd)
+	replayBlockCmd.Flags().StringVar(&tracer, "tracer", "none", "EVM tracer attached to the execution: json or none")
+	replayBlockCmd.Flags().StringVar(&tracefile, "tracefile", "", "path to the file where the trace is written. If omitted, the trace is written to stderr")
+	replayBlockCmd.Flags().BoolVar(&verifyRoot, "verifyRoot", true, "unwind the current state to the parent block (without committing) to verify the state root. If turned off, the historical state is used and the changesets are verified instead")
+	rootCmd.AddCommand(replayBlockCmd)
+}
+
+var replayBlockCmd = &cobra.Command{
+	Use:   "replayBlock",
+	Short: "Re-executes a single historical block and prints the report of divergences from the stored root and receipts",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return stateless.ReplayBlock(genesis, chaindata, block, tracer, tracefile, verifyRoot, readonly, os.Stdout)
+	},
+}
@@ -0,0 +1,128 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	"github.com/ledgerwatch/turbo-geth/core"
+	"github.com/ledgerwatch/turbo-geth/core/rawdb"
+	"github.com/ledgerwatch/turbo-geth/eth/downloader"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+	"github.com/spf13/cobra"
+)
+
+var (
+	stageName          string
+	txAddressIndexKeep uint64
+)
+
+func init() {
+	withChaindata(stagesListCmd)
+	stagesCmd.AddCommand(stagesListCmd)
+
+	for _, cmd := range []*cobra.Command{stagesRunCmd, stagesResetCmd} {
+		withChaindata(cmd)
+		cmd.Flags().StringVar(&stageName, "stage", "", "name of the stage, see `stages list`")
+		must(cmd.MarkFlagRequired("stage"))
+		stagesCmd.AddCommand(cmd)
+	}
+	stagesRunCmd.Flags().Uint64Var(&txAddressIndexKeep, "txaddrindex.keep", 0, "number of the last blocks kept in the transaction address index (0 = all)")
+
+	rootCmd.AddCommand(stagesCmd)
+}
+
+var stagesCmd = &cobra.Command{
+	Use:   "stages",
+	Short: "Inspects and runs the stages of the staged sync",
+}
+
+var stagesListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "Prints the progress and the pending unwind point of every stage",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		db, err := ethdb.NewBoltDatabase(chaindata)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 1, ' ', 0)
+		fmt.Fprintf(w, "stage\tprogress\tunwind to\t\n")
+		for stage := downloader.Headers; stage < downloader.Finish; stage++ {
+			progress, err := downloader.GetStageProgress(db, stage)
+			if err != nil {
+				return err
+			}
+			unwindPoint, err := downloader.GetStageUnwind(db, stage)
+			if err != nil {
+				return err
+			}
+			unwind := "-"
+			if unwindPoint > 0 {
+				unwind = fmt.Sprintf("%d", unwindPoint)
+			}
+			fmt.Fprintf(w, "%s\t%d\t%s\t\n", stage, progress, unwind)
+		}
+		return w.Flush()
+	},
+}
+
+var stagesRunCmd = &cobra.Command{
+	Use:   "run",
+	Short: "Does the pending unwinds and runs the stage forward, only the stages after the execution can be run outside of the node",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return withDatabaseStages(func(stagedSync *downloader.StageRunner, stage downloader.SyncStage) error {
+			if err := stagedSync.Unwind(); err != nil {
+				return err
+			}
+			return stagedSync.RunStage(stage)
+		})
+	},
+}
+
+var stagesResetCmd = &cobra.Command{
+	Use:   "reset",
+	Short: "Unwinds the stage and the stages after it to the genesis, so that the next sync redoes them, only the stages after the execution can be reset outside of the node",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return withDatabaseStages(func(stagedSync *downloader.StageRunner, stage downloader.SyncStage) error {
+			return stagedSync.ResetStage(stage)
+		})
+	},
+}
+
+// withDatabaseStages declares the stages of the database (see downloader.DatabaseStages), all of them enabled
+func withDatabaseStages(f func(stagedSync *downloader.StageRunner, stage downloader.SyncStage) error) error {
+	stage, err := downloader.ParseSyncStage(stageName)
+	if err != nil {
+		return err
+	}
+	db, err := ethdb.NewBoltDatabase(chaindata)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	version, err := rawdb.ReadStateSchemaVersion(db)
+	if err != nil {
+		return err
+	}
+	switch version {
+	case rawdb.HashedStateSchema:
+		core.UsePlainStateExecution = false
+	case rawdb.PlainStateSchema, rawdb.UnhashedStateSchema:
+		core.UsePlainStateExecution = true
+	default:
+		return fmt.Errorf("unknown state schema version %d", version)
+	}
+	genesis := rawdb.ReadCanonicalHash(db, 0)
+	config := rawdb.ReadChainConfig(db, genesis)
+	if config == nil {
+		return fmt.Errorf("chain config of genesis %x not found", genesis)
+	}
+	stages := downloader.DatabaseStages(db, filepath.Dir(chaindata), config, true, true, txAddressIndexKeep)
+	stagedSync, err := downloader.NewStagedSync(db, stages...)
+	if err != nil {
+		return err
+	}
+	return f(stagedSync, stage)
+}
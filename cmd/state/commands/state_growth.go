@@ -6,7 +6,6 @@ import (
 	"path"
 	"time"
 
-	"github.com/ledgerwatch/bolt"
 	"github.com/ledgerwatch/turbo-geth/cmd/state/stateless"
 	"github.com/ledgerwatch/turbo-geth/ethdb"
 	"github.com/spf13/cobra"
@@ -19,7 +18,7 @@ func init() {
 		RunE: func(cmd *cobra.Command, args []string) error {
 			ctx := cmd.Context()
 
-			localDb, err := bolt.Open(file()+"_sg", 0600, &bolt.Options{})
+			localDb, err := ethdb.NewBolt().Path(file() + "_sg").Open(ctx)
 			if err != nil {
 				panic(err)
 			}
@@ -0,0 +1,29 @@
+package commands
+
+import (
+	"github.com/ledgerwatch/turbo-geth/core/state"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	withChaindata(stateSizeCmd)
+	rootCmd.AddCommand(stateSizeCmd)
+}
+
+var stateSizeCmd = &cobra.Command{
+	Use:   "stateSize",
+	Short: "Computes the totals of the state size by walking the state buckets, and stores them, so that they are maintained incrementally from then on",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		db, err := ethdb.NewBoltDatabase(chaindata)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		size, err := state.ComputeStateSize(db)
+		if err != nil {
+			return err
+		}
+		return state.WriteStateSize(db, size)
+	},
+}
@@ -17,6 +17,7 @@ func init() {
 	if err := verifySnapshotCmd.MarkFlagRequired("snapshot"); err != nil {
 		panic(err)
 	}
+	withReadonly(verifySnapshotCmd)
 	rootCmd.AddCommand(verifySnapshotCmd)
 }
 
@@ -24,7 +25,7 @@ var verifySnapshotCmd = &cobra.Command{
 	Use:   "verifySnapshot",
 	Short: "Verifies snapshots made by the 'stateless' action",
 	RunE: func(cmd *cobra.Command, args []string) error {
-		stateless.VerifySnapshot(snapshotPath)
+		stateless.VerifySnapshot(snapshotPath, readonly)
 		return nil
 	},
 }
@@ -0,0 +1,34 @@
+package commands
+
+import (
+	"github.com/ledgerwatch/turbo-geth/cmd/state/stateless"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+	"github.com/spf13/cobra"
+)
+
+var (
+	benchBlocks string
+	benchOutput string
+)
+
+func init() {
+	witnessBenchCmd.Flags().StringVar(&benchBlocks, "blocks", "blocks.rlp", "RLP file with the consecutive blocks of the corpus (as written by geth export, may be gzipped)")
+	witnessBenchCmd.Flags().StringVar(&statefile, "statefile", "state", "path to the state snapshot taken after the parent of the first block (it is not modified)")
+	witnessBenchCmd.Flags().StringVar(&benchOutput, "output", "", "path to the JSON report (default: stdout)")
+	must(witnessBenchCmd.MarkFlagFilename("blocks", "rlp", "gz"))
+	must(witnessBenchCmd.MarkFlagFilename("statefile", ""))
+	must(witnessBenchCmd.MarkFlagFilename("output", "json"))
+
+	rootCmd.AddCommand(witnessBenchCmd)
+}
+
+var witnessBenchCmd = &cobra.Command{
+	Use:   "witness-bench",
+	Short: "Generates the witnesses for the corpus of blocks and reports their sizes and generation times as JSON",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		createDb := func(path string) (ethdb.Database, error) {
+			return ethdb.NewBoltDatabase(path)
+		}
+		return stateless.WitnessBench(cmd.Context(), benchBlocks, statefile, benchOutput, createDb)
+	},
+}
@@ -0,0 +1,29 @@
+package commands
+
+import (
+	"os"
+
+	"github.com/ledgerwatch/turbo-geth/cmd/state/stats"
+	"github.com/ledgerwatch/turbo-geth/eth/mgr"
+	"github.com/spf13/cobra"
+)
+
+var (
+	bytesPerWitness uint64
+	ticksPerCycle   uint64
+)
+
+func init() {
+	withChaindata(witnessLenStatsCmd)
+	witnessLenStatsCmd.Flags().Uint64Var(&bytesPerWitness, "bytesPerWitness", mgr.BytesPerWitness, "size of the witness the state is sliced into")
+	witnessLenStatsCmd.Flags().Uint64Var(&ticksPerCycle, "ticksPerCycle", mgr.TicksPerCycle, "number of the ticks the state is covered in")
+	rootCmd.AddCommand(witnessLenStatsCmd)
+}
+
+var witnessLenStatsCmd = &cobra.Command{
+	Use:   "witnessLenStats",
+	Short: "Histogram of the witness sizes by trie depth, boundaries of the witness slices and recommended MGR parameters",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return stats.WitnessLenStatsReport(cmd.Context(), chaindata, bytesPerWitness, ticksPerCycle, os.Stdout)
+	},
+}
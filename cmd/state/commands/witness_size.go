@@ -0,0 +1,35 @@
+package commands
+
+import (
+	"github.com/ledgerwatch/turbo-geth/cmd/state/stateless"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+	"github.com/spf13/cobra"
+)
+
+var (
+	witnessPrefixLen int
+	witnessTop       int
+)
+
+func init() {
+	withBlock(witnessSizeCmd)
+	withBlocksource(witnessSizeCmd)
+
+	witnessSizeCmd.Flags().StringVar(&statefile, "statefile", "state", "path to the state snapshot taken after the previous block (it is not modified)")
+	witnessSizeCmd.Flags().IntVar(&witnessPrefixLen, "prefixLen", 2, "number of nibbles of the key prefix to group witness contributions by")
+	witnessSizeCmd.Flags().IntVar(&witnessTop, "top", 50, "how many top accounts and prefixes to print (0 - all)")
+	must(witnessSizeCmd.MarkFlagFilename("statefile", ""))
+
+	rootCmd.AddCommand(witnessSizeCmd)
+}
+
+var witnessSizeCmd = &cobra.Command{
+	Use:   "witnessSize",
+	Short: "Explains why the witness of a block is large: contribution of every account and key prefix",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		createDb := func(path string) (ethdb.Database, error) {
+			return ethdb.NewBoltDatabase(path)
+		}
+		return stateless.WitnessSize(cmd.Context(), block, blockSource, statefile, witnessPrefixLen, witnessTop, createDb)
+	},
+}
@@ -0,0 +1,28 @@
+package generate
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/eth/downloader"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+)
+
+// RegenerateIntermediateHashes rebuilds the intermediate hashes of the database, the temporary files of the sorting
+// are written next to the database unless datadir is given
+func RegenerateIntermediateHashes(chaindata string, datadir string, depth int, expectedRoot common.Hash) error {
+	db, err := ethdb.NewBoltDatabase(chaindata)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if datadir == "" {
+		datadir = filepath.Dir(chaindata)
+	}
+	if err = downloader.RegenerateIntermediateHashes(db, datadir, depth, expectedRoot); err != nil {
+		return err
+	}
+	fmt.Println("Intermediate hashes are successfully regenerated")
+	return nil
+}
@@ -0,0 +1,295 @@
+package gethimport
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+	"github.com/ledgerwatch/turbo-geth/core/rawdb"
+	"github.com/ledgerwatch/turbo-geth/core/types"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+	"github.com/ledgerwatch/turbo-geth/log"
+	"github.com/ledgerwatch/turbo-geth/rlp"
+)
+
+// Keys of the go-ethereum database schema (core/rawdb/schema.go of go-ethereum)
+var (
+	gethHeadBlockKey       = []byte("LastBlock")
+	gethHeaderPrefix       = []byte("h") // headerPrefix + num (uint64 big endian) + hash -> header
+	gethHeaderTDSuffix     = []byte("t") // headerPrefix + num (uint64 big endian) + hash + headerTDSuffix -> td
+	gethHeaderHashSuffix   = []byte("n") // headerPrefix + num (uint64 big endian) + headerHashSuffix -> hash
+	gethHeaderNumberPrefix = []byte("H") // headerNumberPrefix + hash -> num (uint64 big endian)
+	gethBodyPrefix         = []byte("b") // blockBodyPrefix + num (uint64 big endian) + hash -> block body
+	gethReceiptsPrefix     = []byte("r") // blockReceiptsPrefix + num (uint64 big endian) + hash -> block receipts
+	gethConfigPrefix       = []byte("ethereum-config-")
+)
+
+// importBlocksProgressKey is the key in dbutils.DatabaseInfoBucket, under which the last imported block is stored
+var importBlocksProgressKey = []byte("GethImportBlocks")
+
+// Chain reads the canonical chain of a go-ethereum node, looking first in the freezer, then in the LevelDB
+type Chain struct {
+	src     Source
+	freezer *Freezer
+}
+
+func NewChain(src Source, freezer *Freezer) *Chain {
+	return &Chain{src: src, freezer: freezer}
+}
+
+func gethNumber(number uint64) []byte {
+	var enc [8]byte
+	binary.BigEndian.PutUint64(enc[:], number)
+	return enc[:]
+}
+
+func gethBlockKey(prefix []byte, number uint64, hash common.Hash) []byte {
+	return append(append(append([]byte{}, prefix...), gethNumber(number)...), hash[:]...)
+}
+
+func (c *Chain) read(table string, key []byte, number uint64) ([]byte, error) {
+	if c.freezer != nil {
+		v, err := c.freezer.Retrieve(table, number)
+		if err != nil {
+			return nil, err
+		}
+		if v != nil {
+			return v, nil
+		}
+	}
+	return c.src.Get(key)
+}
+
+// CanonicalHash returns the hash of the canonical block, or the empty hash if there is no such block
+func (c *Chain) CanonicalHash(number uint64) (common.Hash, error) {
+	key := append(append(append([]byte{}, gethHeaderPrefix...), gethNumber(number)...), gethHeaderHashSuffix...)
+	v, err := c.read(freezerHashTable, key, number)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	return common.BytesToHash(v), nil
+}
+
+// HeadBlock returns the number and the hash of the head block of the go-ethereum node
+func (c *Chain) HeadBlock() (uint64, common.Hash, error) {
+	v, err := c.src.Get(gethHeadBlockKey)
+	if err != nil {
+		return 0, common.Hash{}, err
+	}
+	if len(v) != common.HashLength {
+		return 0, common.Hash{}, errors.New("head block not found, is it a geth chaindata?")
+	}
+	hash := common.BytesToHash(v)
+	enc, err := c.src.Get(append(append([]byte{}, gethHeaderNumberPrefix...), hash[:]...))
+	if err != nil {
+		return 0, common.Hash{}, err
+	}
+	if len(enc) != 8 {
+		return 0, common.Hash{}, fmt.Errorf("number of the head block %x not found", hash)
+	}
+	return binary.BigEndian.Uint64(enc), hash, nil
+}
+
+// Header returns the RLP encoded header of the block
+func (c *Chain) Header(number uint64, hash common.Hash) ([]byte, error) {
+	return c.read(freezerHeaderTable, gethBlockKey(gethHeaderPrefix, number, hash), number)
+}
+
+// Body returns the RLP encoded body of the block
+func (c *Chain) Body(number uint64, hash common.Hash) ([]byte, error) {
+	return c.read(freezerBodiesTable, gethBlockKey(gethBodyPrefix, number, hash), number)
+}
+
+// Receipts returns the RLP encoded receipts of the block, in the storage format
+func (c *Chain) Receipts(number uint64, hash common.Hash) ([]byte, error) {
+	return c.read(freezerReceiptTable, gethBlockKey(gethReceiptsPrefix, number, hash), number)
+}
+
+// Td returns the RLP encoded total difficulty of the block
+func (c *Chain) Td(number uint64, hash common.Hash) ([]byte, error) {
+	return c.read(freezerDifficultyTable, append(gethBlockKey(gethHeaderPrefix, number, hash), gethHeaderTDSuffix...), number)
+}
+
+// ChainConfig returns the JSON encoded chain config stored by go-ethereum for the genesis
+func (c *Chain) ChainConfig(genesis common.Hash) ([]byte, error) {
+	return c.src.Get(append(append([]byte{}, gethConfigPrefix...), genesis[:]...))
+}
+
+// ReadHeader returns the decoded header of the canonical block
+func (c *Chain) ReadHeader(number uint64) (*types.Header, error) {
+	hash, err := c.CanonicalHash(number)
+	if err != nil {
+		return nil, err
+	}
+	if hash == (common.Hash{}) {
+		return nil, fmt.Errorf("canonical block %d not found", number)
+	}
+	data, err := c.Header(number, hash)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("header of block %d (%x) not found", number, hash)
+	}
+	header := new(types.Header)
+	if err := rlp.DecodeBytes(data, header); err != nil {
+		return nil, fmt.Errorf("decoding header of block %d: %w", number, err)
+	}
+	return header, nil
+}
+
+// LatestState returns the header of the newest canonical block, not higher than the head, whose state trie
+// is present in the database. go-ethereum only persists the tries of some recent blocks on shutdown
+func (c *Chain) LatestState(head uint64) (*types.Header, error) {
+	for number := head; ; number-- {
+		header, err := c.ReadHeader(number)
+		if err != nil {
+			return nil, err
+		}
+		root, err := c.src.Get(header.Root[:])
+		if err != nil {
+			return nil, err
+		}
+		if len(root) > 0 {
+			return header, nil
+		}
+		if number == 0 {
+			return nil, errors.New("no state trie found, the database has probably been synced in the light mode")
+		}
+	}
+}
+
+// ReadImportBlocksProgress returns the last imported block, ok == false if no blocks have been imported
+func ReadImportBlocksProgress(db ethdb.Getter) (number uint64, ok bool, err error) {
+	v, err := db.Get(dbutils.DatabaseInfoBucket, importBlocksProgressKey)
+	if err != nil && !errors.Is(err, ethdb.ErrKeyNotFound) {
+		return 0, false, err
+	}
+	if len(v) != 8 {
+		return 0, false, nil
+	}
+	return binary.BigEndian.Uint64(v), true, nil
+}
+
+// ImportBlocks copies the headers, the bodies, the total difficulties and the receipts of the canonical blocks
+// up to the block to (including) into the turbo-geth database, and indexes their transactions and logs.
+// The progress is saved with every committed batch, so the import continues after the last imported block
+// when restarted. The head pointers of the database are not modified.
+func ImportBlocks(chain *Chain, db ethdb.Database, to uint64) error {
+	var from uint64
+	progress, ok, err := ReadImportBlocksProgress(db)
+	if err != nil {
+		return err
+	}
+	if ok {
+		from = progress + 1
+	}
+	if from > to {
+		log.Info("Blocks are already imported", "up to", progress)
+		return nil
+	}
+	log.Info("Importing blocks", "from", from, "to", to)
+	batch := db.NewBatch()
+	defer batch.Rollback()
+	for number := from; number <= to; number++ {
+		if err := importBlock(chain, batch, number); err != nil {
+			return err
+		}
+		if batch.BatchSize() >= batch.IdealBatchSize() || number == to {
+			if err := batch.Put(dbutils.DatabaseInfoBucket, importBlocksProgressKey, gethNumber(number)); err != nil {
+				return err
+			}
+			if _, err := batch.Commit(); err != nil {
+				return err
+			}
+			log.Info("Imported blocks", "up to", number, "of", to)
+		}
+	}
+	return nil
+}
+
+func importBlock(chain *Chain, db ethdb.DbWithPendingMutations, number uint64) error {
+	hash, err := chain.CanonicalHash(number)
+	if err != nil {
+		return err
+	}
+	if hash == (common.Hash{}) {
+		return fmt.Errorf("canonical block %d not found", number)
+	}
+	headerRLP, err := chain.Header(number, hash)
+	if err != nil {
+		return err
+	}
+	header := new(types.Header)
+	if err := rlp.DecodeBytes(headerRLP, header); err != nil {
+		return fmt.Errorf("decoding header of block %d: %w", number, err)
+	}
+	if header.Hash() != hash {
+		return fmt.Errorf("header of block %d has hash %x, expected %x", number, header.Hash(), hash)
+	}
+	bodyRLP, err := chain.Body(number, hash)
+	if err != nil {
+		return err
+	}
+	body := new(types.Body)
+	if err := rlp.DecodeBytes(bodyRLP, body); err != nil {
+		return fmt.Errorf("decoding body of block %d: %w", number, err)
+	}
+	tdRLP, err := chain.Td(number, hash)
+	if err != nil {
+		return err
+	}
+	td := new(big.Int)
+	if err := rlp.DecodeBytes(tdRLP, td); err != nil {
+		return fmt.Errorf("decoding total difficulty of block %d: %w", number, err)
+	}
+	receiptsRLP, err := chain.Receipts(number, hash)
+	if err != nil {
+		return err
+	}
+	var storageReceipts []*types.ReceiptForStorage
+	if err := rlp.DecodeBytes(receiptsRLP, &storageReceipts); err != nil {
+		return fmt.Errorf("decoding receipts of block %d: %w", number, err)
+	}
+	receipts := make(types.Receipts, len(storageReceipts))
+	for i, receipt := range storageReceipts {
+		receipts[i] = (*types.Receipt)(receipt)
+	}
+
+	rawdb.WriteHeader(context.Background(), db, header)
+	rawdb.WriteBodyRLP(context.Background(), db, hash, number, bodyRLP)
+	rawdb.WriteTd(db, hash, number, td)
+	rawdb.WriteReceipts(db, hash, number, receipts)
+	rawdb.WriteCanonicalHash(db, hash, number)
+	rawdb.WriteTxLookupEntries(db, types.NewBlockWithHeader(header).WithBody(body.Transactions, body.Uncles))
+	return rawdb.WriteLogIndex(db, number, receipts)
+}
+
+// ImportChainConfig copies the chain config of go-ethereum, if it has been stored there
+func ImportChainConfig(chain *Chain, db ethdb.Database) error {
+	genesis, err := chain.CanonicalHash(0)
+	if err != nil {
+		return err
+	}
+	config, err := chain.ChainConfig(genesis)
+	if err != nil {
+		return err
+	}
+	if len(config) == 0 {
+		log.Warn("Chain config not found in geth database", "genesis", genesis)
+		return nil
+	}
+	existing, err := db.Get(dbutils.ConfigPrefix, genesis[:])
+	if err != nil && !errors.Is(err, ethdb.ErrKeyNotFound) {
+		return err
+	}
+	if bytes.Equal(existing, config) {
+		return nil
+	}
+	return db.Put(dbutils.ConfigPrefix, genesis[:], config)
+}
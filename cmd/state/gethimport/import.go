@@ -0,0 +1,120 @@
+// Package gethimport converts the chaindata of a go-ethereum node (LevelDB and the freezer of ancient blocks)
+// into the turbo-geth database, so that a node migrating from go-ethereum does not need to sync from scratch
+package gethimport
+
+import (
+	"fmt"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/core"
+	"github.com/ledgerwatch/turbo-geth/core/rawdb"
+	"github.com/ledgerwatch/turbo-geth/eth/downloader"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+	"github.com/ledgerwatch/turbo-geth/log"
+	"github.com/ledgerwatch/turbo-geth/params"
+)
+
+// Ways to obtain the state
+const (
+	// StateFlatten copies the latest state persisted by go-ethereum, walking its state trie
+	StateFlatten = "flatten"
+	// StateReplay only commits the genesis state, leaving the execution of the imported blocks to the staged sync
+	StateReplay = "replay"
+)
+
+// Import copies the canonical chain of go-ethereum into the database and obtains the state according to the mode.
+// It can be restarted after interruption, the already imported blocks and accounts are skipped
+func Import(chain *Chain, db ethdb.Database, stateMode string) error {
+	head, headHash, err := chain.HeadBlock()
+	if err != nil {
+		return err
+	}
+	log.Info("Geth head block", "number", head, "hash", headHash)
+	if err := ImportChainConfig(chain, db); err != nil {
+		return err
+	}
+	switch stateMode {
+	case StateFlatten:
+		return importFlatten(chain, db, head)
+	case StateReplay:
+		return importReplay(chain, db, head, headHash)
+	default:
+		return fmt.Errorf("unknown state import mode %q, expected %s or %s", stateMode, StateFlatten, StateReplay)
+	}
+}
+
+func importFlatten(chain *Chain, db ethdb.Database, head uint64) error {
+	header, err := chain.LatestState(head)
+	if err != nil {
+		return err
+	}
+	number := header.Number.Uint64()
+	if number != head {
+		log.Warn("State of the head block is not persisted, importing up to the latest persisted state", "number", number, "root", header.Root)
+	}
+	if err := ImportBlocks(chain, db, number); err != nil {
+		return err
+	}
+	if _, err := FlattenState(chain.src, db, header.Root); err != nil {
+		return err
+	}
+	hash := header.Hash()
+	// The state is only present for the last block, there is no history (changesets and their indices) below it
+	for stage := downloader.Headers; stage < downloader.Finish; stage++ {
+		if err := downloader.SaveStageProgress(db, stage, number); err != nil {
+			return err
+		}
+	}
+	rawdb.WriteHeadHeaderHash(db, hash)
+	rawdb.WriteHeadBlockHash(db, hash)
+	rawdb.WriteHeadFastBlockHash(db, hash)
+	log.Info("Import finished", "head", number, "hash", hash)
+	return nil
+}
+
+func importReplay(chain *Chain, db ethdb.Database, head uint64, headHash common.Hash) error {
+	genesisHash, err := chain.CanonicalHash(0)
+	if err != nil {
+		return err
+	}
+	if rawdb.ReadCanonicalHash(db, 0) == (common.Hash{}) {
+		genesis := knownGenesis(genesisHash)
+		if genesis == nil {
+			return fmt.Errorf("genesis %x is not of a known network, its state can only be imported with --state=%s", genesisHash, StateFlatten)
+		}
+		block, _, err := genesis.Commit(db, true /* history */)
+		if err != nil {
+			return err
+		}
+		if block.Hash() != genesisHash {
+			return fmt.Errorf("committed genesis %x does not match the geth genesis %x", block.Hash(), genesisHash)
+		}
+	}
+	if err := ImportBlocks(chain, db, head); err != nil {
+		return err
+	}
+	// The head block stays at the genesis, the staged sync continues with recovering the senders and executing the blocks
+	for _, stage := range []downloader.SyncStage{downloader.Headers, downloader.Bodies, downloader.LogIndex} {
+		if err := downloader.SaveStageProgress(db, stage, head); err != nil {
+			return err
+		}
+	}
+	rawdb.WriteHeadHeaderHash(db, headHash)
+	log.Info("Import finished, start the node with the staged sync to execute the blocks", "head", head, "hash", headHash)
+	return nil
+}
+
+func knownGenesis(hash common.Hash) *core.Genesis {
+	switch hash {
+	case params.MainnetGenesisHash:
+		return core.DefaultGenesisBlock()
+	case params.RopstenGenesisHash:
+		return core.DefaultRopstenGenesisBlock()
+	case params.RinkebyGenesisHash:
+		return core.DefaultRinkebyGenesisBlock()
+	case params.GoerliGenesisHash:
+		return core.DefaultGoerliGenesisBlock()
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,202 @@
+package gethimport
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang/snappy"
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+	"github.com/ledgerwatch/turbo-geth/core/state"
+	"github.com/ledgerwatch/turbo-geth/core/types/accounts"
+	"github.com/ledgerwatch/turbo-geth/crypto"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+	"github.com/ledgerwatch/turbo-geth/rlp"
+	"github.com/ledgerwatch/turbo-geth/trie"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type mapSource map[string][]byte
+
+func (s mapSource) Get(key []byte) ([]byte, error) {
+	return s[string(key)], nil
+}
+
+// putNode stores the node the way go-ethereum does, under its hash, and returns the hash
+func (s mapSource) putNode(t *testing.T, node interface{}) common.Hash {
+	enc, err := rlp.EncodeToBytes(node)
+	require.NoError(t, err)
+	hash := crypto.Keccak256Hash(enc)
+	s[string(hash[:])] = enc
+	return hash
+}
+
+func hexToCompact(nibbles []byte, leaf bool) []byte {
+	var flag byte
+	if leaf {
+		flag = 2
+	}
+	var compact []byte
+	if len(nibbles)%2 == 1 {
+		compact = append(compact, (flag|1)<<4|nibbles[0])
+		nibbles = nibbles[1:]
+	} else {
+		compact = append(compact, flag<<4)
+	}
+	for i := 0; i < len(nibbles); i += 2 {
+		compact = append(compact, nibbles[i]<<4|nibbles[i+1])
+	}
+	return compact
+}
+
+func TestFlattenState(t *testing.T) {
+	src := make(mapSource)
+	code := []byte{0x60, 0x00, 0x60, 0x00, 0xf3}
+	codeHash := crypto.Keccak256Hash(code)
+	src[string(append(common.CopyBytes(gethCodePrefix), codeHash[:]...))] = code
+
+	// Storage trie of the contract with a single item
+	keyHash := crypto.Keccak256Hash([]byte{1})
+	storageValue, err := rlp.EncodeToBytes([]byte{0x01, 0x02})
+	require.NoError(t, err)
+	storageRoot := src.putNode(t, []interface{}{hexToCompact(keybytesToHex(keyHash[:]), true), storageValue})
+
+	// Account trie: a full node with the contract under the nibble 1 and a plain account under the nibble 2
+	contractHash := common.HexToHash("0x1a00000000000000000000000000000000000000000000000000000000000001")
+	accountHash := common.HexToHash("0x2b00000000000000000000000000000000000000000000000000000000000002")
+	contractRLP, err := rlp.EncodeToBytes(&gethAccount{Nonce: 1, Balance: big.NewInt(100), Root: storageRoot, CodeHash: codeHash[:]})
+	require.NoError(t, err)
+	accountRLP, err := rlp.EncodeToBytes(&gethAccount{Nonce: 3, Balance: big.NewInt(5), Root: trie.EmptyRoot, CodeHash: trie.EmptyCodeHash[:]})
+	require.NoError(t, err)
+	full := make([]interface{}, 17)
+	for i := range full {
+		full[i] = []byte{}
+	}
+	contractLeaf := src.putNode(t, []interface{}{hexToCompact(keybytesToHex(contractHash[:])[1:], true), contractRLP})
+	accountLeaf := src.putNode(t, []interface{}{hexToCompact(keybytesToHex(accountHash[:])[1:], true), accountRLP})
+	full[1] = contractLeaf[:]
+	full[2] = accountLeaf[:]
+	root := src.putNode(t, full)
+
+	db := ethdb.NewMemDatabase()
+	count, err := FlattenState(src, db, root)
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	var contract accounts.Account
+	enc, err := db.Get(dbutils.CurrentStateBucket, contractHash[:])
+	require.NoError(t, err)
+	require.NoError(t, contract.DecodeForStorage(enc))
+	assert.Equal(t, uint64(1), contract.Nonce)
+	assert.Equal(t, uint64(100), contract.Balance.Uint64())
+	assert.Equal(t, codeHash, contract.CodeHash)
+	assert.Equal(t, uint64(state.FirstContractIncarnation), contract.Incarnation)
+
+	var account accounts.Account
+	enc, err = db.Get(dbutils.CurrentStateBucket, accountHash[:])
+	require.NoError(t, err)
+	require.NoError(t, account.DecodeForStorage(enc))
+	assert.Equal(t, uint64(3), account.Nonce)
+	assert.Equal(t, uint64(5), account.Balance.Uint64())
+	assert.Equal(t, uint64(state.NonContractIncarnation), account.Incarnation)
+
+	// The storage roots are not kept in the accounts, the flattened state hashes to the root of the trie
+	subTries, err := trie.NewSubTrieLoader(0).LoadFromFlatDB(db, trie.NewRetainList(0), [][]byte{nil}, []int{0}, false)
+	require.NoError(t, err)
+	assert.Equal(t, []common.Hash{root}, subTries.Hashes)
+
+	v, err := db.Get(dbutils.CurrentStateBucket, dbutils.GenerateCompositeStorageKey(contractHash, contract.Incarnation, keyHash))
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x01, 0x02}, v)
+	v, err = db.Get(dbutils.CodeBucket, codeHash[:])
+	require.NoError(t, err)
+	assert.Equal(t, code, v)
+	v, err = db.Get(dbutils.ContractCodeBucket, dbutils.GenerateStoragePrefix(contractHash[:], contract.Incarnation))
+	require.NoError(t, err)
+	assert.Equal(t, codeHash[:], v)
+
+	// Interrupted import continues after the last imported account
+	db2 := ethdb.NewMemDatabase()
+	require.NoError(t, db2.Put(dbutils.DatabaseInfoBucket, importStateProgressKey, append(common.CopyBytes(root[:]), contractHash[:]...)))
+	count, err = FlattenState(src, db2, root)
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+	_, err = db2.Get(dbutils.CurrentStateBucket, contractHash[:])
+	assert.Equal(t, ethdb.ErrKeyNotFound, err)
+	_, err = db2.Get(dbutils.CurrentStateBucket, accountHash[:])
+	assert.NoError(t, err)
+
+	// Import of a different root is refused, the partially imported state would be corrupted
+	_, err = FlattenState(src, db2, storageRoot)
+	assert.Error(t, err)
+}
+
+// writeFreezerTable writes the items the way go-ethereum does, starting a new data file every two items
+func writeFreezerTable(t *testing.T, dir, name string, noSnappy bool, items [][]byte) {
+	idxExt, dataExt := "cidx", "cdat"
+	if noSnappy {
+		idxExt, dataExt = "ridx", "rdat"
+	}
+	index := make([]byte, indexEntrySize)
+	var data []byte
+	var filenum uint16
+	for i, item := range items {
+		if i > 0 && i%2 == 0 {
+			require.NoError(t, ioutil.WriteFile(filepath.Join(dir, fmt.Sprintf("%s.%04d.%s", name, filenum, dataExt)), data, 0644))
+			filenum++
+			data = nil
+		}
+		if !noSnappy {
+			item = snappy.Encode(nil, item)
+		}
+		data = append(data, item...)
+		var entry [indexEntrySize]byte
+		binary.BigEndian.PutUint16(entry[:2], filenum)
+		binary.BigEndian.PutUint32(entry[2:], uint32(len(data)))
+		index = append(index, entry[:]...)
+	}
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, fmt.Sprintf("%s.%04d.%s", name, filenum, dataExt)), data, 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name+"."+idxExt), index, 0644))
+}
+
+func TestFreezerRetrieve(t *testing.T) {
+	dir, err := ioutil.TempDir("", "geth-ancient")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	items := [][]byte{[]byte("item0"), []byte("item1 is longer"), []byte("item2"), []byte("3")}
+	for name, noSnappy := range freezerNoSnappy {
+		tableItems := items
+		if name == freezerReceiptTable {
+			// Crashed geth may leave the tables of different lengths
+			tableItems = items[:3]
+		}
+		writeFreezerTable(t, dir, name, noSnappy, tableItems)
+	}
+
+	freezer, err := OpenFreezer(dir)
+	require.NoError(t, err)
+	defer freezer.Close()
+	assert.Equal(t, uint64(3), freezer.Items())
+	for _, table := range []string{freezerHashTable, freezerHeaderTable} {
+		for i := 0; i < 3; i++ {
+			v, err := freezer.Retrieve(table, uint64(i))
+			require.NoError(t, err)
+			assert.Equal(t, items[i], v, "table %s, item %d", table, i)
+		}
+		v, err := freezer.Retrieve(table, 3)
+		require.NoError(t, err)
+		assert.Nil(t, v)
+	}
+
+	// No freezer at all
+	empty, err := OpenFreezer(filepath.Join(dir, "missing"))
+	require.NoError(t, err)
+	assert.Equal(t, uint64(0), empty.Items())
+}
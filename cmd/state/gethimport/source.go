@@ -0,0 +1,214 @@
+package gethimport
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/golang/snappy"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+)
+
+// Source gives read access to the key-value store of a go-ethereum node (its chaindata directory).
+// Get returns nil, nil if the key is not present
+type Source interface {
+	Get(key []byte) ([]byte, error)
+}
+
+// LevelDBSource is the Source backed by the LevelDB database of go-ethereum, opened in the read-only mode
+type LevelDBSource struct {
+	db *leveldb.DB
+}
+
+// OpenLevelDB opens the chaindata directory of go-ethereum. The node using it must be stopped
+func OpenLevelDB(path string) (*LevelDBSource, error) {
+	db, err := leveldb.OpenFile(path, &opt.Options{ReadOnly: true})
+	if err != nil {
+		return nil, fmt.Errorf("opening geth chaindata %s: %w", path, err)
+	}
+	return &LevelDBSource{db: db}, nil
+}
+
+func (s *LevelDBSource) Get(key []byte) ([]byte, error) {
+	v, err := s.db.Get(key, nil)
+	if errors.Is(err, leveldb.ErrNotFound) {
+		return nil, nil
+	}
+	return v, err
+}
+
+func (s *LevelDBSource) Close() error {
+	return s.db.Close()
+}
+
+// Tables of the go-ethereum freezer, and whether they are stored without the snappy compression
+const (
+	freezerHeaderTable     = "headers"
+	freezerHashTable       = "hashes"
+	freezerBodiesTable     = "bodies"
+	freezerReceiptTable    = "receipts"
+	freezerDifficultyTable = "diffs"
+)
+
+var freezerNoSnappy = map[string]bool{
+	freezerHeaderTable:     false,
+	freezerHashTable:       true,
+	freezerBodiesTable:     false,
+	freezerReceiptTable:    false,
+	freezerDifficultyTable: true,
+}
+
+// indexEntrySize is the size of an entry of the freezer table index: 2 bytes of the data file number
+// followed by 4 bytes of the offset of the end of the item in that file
+const indexEntrySize = 6
+
+// Freezer reads the "ancient" blocks, which go-ethereum moves out of the LevelDB into the append-only flat files
+// (chaindata/ancient by default). Only the reading of the items is supported
+type Freezer struct {
+	tables map[string]*freezerTable
+	items  uint64 // Number of blocks present in all the tables
+}
+
+type freezerTable struct {
+	name     string
+	dir      string
+	noSnappy bool
+	index    *os.File
+	files    map[uint32]*os.File
+	items    uint64
+}
+
+// OpenFreezer opens the freezer tables in the directory. If the directory does not exist,
+// an empty freezer is returned, so all the blocks are expected to be in the LevelDB
+func OpenFreezer(dir string) (*Freezer, error) {
+	f := &Freezer{tables: make(map[string]*freezerTable)}
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return f, nil
+	}
+	for name, noSnappy := range freezerNoSnappy {
+		table, err := openFreezerTable(dir, name, noSnappy)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		f.tables[name] = table
+	}
+	// The tables may be of different lengths after a crash of geth, only the blocks present in all of them are usable
+	first := true
+	for _, table := range f.tables {
+		if first || table.items < f.items {
+			f.items = table.items
+		}
+		first = false
+	}
+	return f, nil
+}
+
+func openFreezerTable(dir, name string, noSnappy bool) (*freezerTable, error) {
+	idxName := name + ".cidx"
+	if noSnappy {
+		idxName = name + ".ridx"
+	}
+	index, err := os.Open(filepath.Join(dir, idxName))
+	if err != nil {
+		return nil, err
+	}
+	stat, err := index.Stat()
+	if err != nil {
+		index.Close()
+		return nil, err
+	}
+	t := &freezerTable{name: name, dir: dir, noSnappy: noSnappy, index: index, files: make(map[uint32]*os.File)}
+	// The first index entry does not correspond to any item, it is only the start of the data of the item 0
+	if entries := uint64(stat.Size() / indexEntrySize); entries > 0 {
+		t.items = entries - 1
+	}
+	return t, nil
+}
+
+// Items returns the number of blocks, which can be read from the freezer
+func (f *Freezer) Items() uint64 {
+	return f.items
+}
+
+// Retrieve returns the item number of the table (e.g. the header of the block number), or nil if it has not been frozen
+func (f *Freezer) Retrieve(table string, number uint64) ([]byte, error) {
+	if number >= f.items {
+		return nil, nil
+	}
+	t, ok := f.tables[table]
+	if !ok {
+		return nil, fmt.Errorf("unknown freezer table %s", table)
+	}
+	return t.retrieve(number)
+}
+
+func (f *Freezer) Close() {
+	for _, t := range f.tables {
+		t.close()
+	}
+}
+
+func (t *freezerTable) readIndexEntry(i uint64) (filenum uint32, offset uint32, err error) {
+	var buf [indexEntrySize]byte
+	if _, err := t.index.ReadAt(buf[:], int64(i*indexEntrySize)); err != nil {
+		return 0, 0, err
+	}
+	return uint32(binary.BigEndian.Uint16(buf[:2])), binary.BigEndian.Uint32(buf[2:]), nil
+}
+
+func (t *freezerTable) retrieve(item uint64) ([]byte, error) {
+	startFile, startOffset, err := t.readIndexEntry(item)
+	if err != nil {
+		return nil, fmt.Errorf("reading index of freezer table %s: %w", t.name, err)
+	}
+	endFile, endOffset, err := t.readIndexEntry(item + 1)
+	if err != nil {
+		return nil, fmt.Errorf("reading index of freezer table %s: %w", t.name, err)
+	}
+	// Items never span several data files: when the file changes, the item starts at the beginning of the new one
+	if startFile != endFile {
+		startOffset = 0
+	}
+	if endOffset < startOffset {
+		return nil, fmt.Errorf("corrupted index of freezer table %s at item %d", t.name, item)
+	}
+	file, err := t.dataFile(endFile)
+	if err != nil {
+		return nil, err
+	}
+	blob := make([]byte, endOffset-startOffset)
+	if _, err := file.ReadAt(blob, int64(startOffset)); err != nil {
+		return nil, fmt.Errorf("reading freezer table %s, item %d: %w", t.name, item, err)
+	}
+	if t.noSnappy {
+		return blob, nil
+	}
+	return snappy.Decode(nil, blob)
+}
+
+func (t *freezerTable) dataFile(num uint32) (*os.File, error) {
+	if f, ok := t.files[num]; ok {
+		return f, nil
+	}
+	ext := "cdat"
+	if t.noSnappy {
+		ext = "rdat"
+	}
+	f, err := os.Open(filepath.Join(t.dir, fmt.Sprintf("%s.%04d.%s", t.name, num, ext)))
+	if err != nil {
+		return nil, err
+	}
+	t.files[num] = f
+	return f, nil
+}
+
+func (t *freezerTable) close() {
+	t.index.Close()
+	for _, f := range t.files {
+		f.Close()
+	}
+}
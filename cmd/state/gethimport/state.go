@@ -0,0 +1,325 @@
+package gethimport
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/holiman/uint256"
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+	"github.com/ledgerwatch/turbo-geth/core/state"
+	"github.com/ledgerwatch/turbo-geth/core/types/accounts"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+	"github.com/ledgerwatch/turbo-geth/log"
+	"github.com/ledgerwatch/turbo-geth/rlp"
+	"github.com/ledgerwatch/turbo-geth/trie"
+)
+
+// gethCodePrefix is the prefix of the contract code keys since go-ethereum 1.9.17, before it the code was
+// stored under the bare code hash
+var gethCodePrefix = []byte("c")
+
+// importStateProgressKey is the key in dbutils.DatabaseInfoBucket, under which the state root being imported
+// and the hash of the last imported account are stored
+var importStateProgressKey = []byte("GethImportState")
+
+// gethAccount is the consensus encoding of the accounts in the state trie of go-ethereum
+type gethAccount struct {
+	Nonce    uint64
+	Balance  *big.Int
+	Root     common.Hash
+	CodeHash []byte
+}
+
+// trieWalker visits the leaves of a Merkle Patricia trie stored by go-ethereum (nodes keyed by their hashes),
+// in the order of their keys
+type trieWalker struct {
+	src    Source
+	resume []byte // Nibbles of the key of the last visited leaf, the leaves up to it (including) are skipped
+	onLeaf func(key []byte, value []byte) error
+}
+
+// walkTrie visits the leaves of the trie with the given root hash, the keys are passed to onLeaf as bytes
+func walkTrie(src Source, root common.Hash, resume []byte, onLeaf func(key []byte, value []byte) error) error {
+	if root == trie.EmptyRoot {
+		return nil
+	}
+	w := &trieWalker{src: src, onLeaf: onLeaf}
+	if resume != nil {
+		w.resume = keybytesToHex(resume)
+	}
+	return w.walkRef(rlpString(root[:]), nil)
+}
+
+// rlpString encodes the bytes as an RLP string, which is how the hash references appear in the trie nodes
+func rlpString(b []byte) []byte {
+	enc, _ := rlp.EncodeToBytes(b)
+	return enc
+}
+
+// skip reports whether all the leaves under the path have already been visited
+func (w *trieWalker) skip(path []byte) bool {
+	if w.resume == nil {
+		return false
+	}
+	if len(path) >= len(w.resume) {
+		return bytes.Compare(path, w.resume) <= 0
+	}
+	return bytes.Compare(path, w.resume[:len(path)]) < 0
+}
+
+// walkRef visits the node referenced from its parent, either by hash (RLP string) or embedded (RLP list)
+func (w *trieWalker) walkRef(ref []byte, path []byte) error {
+	kind, content, _, err := rlp.Split(ref)
+	if err != nil {
+		return err
+	}
+	switch {
+	case kind == rlp.List:
+		return w.walkNode(ref, path)
+	case len(content) == 0:
+		return nil
+	case len(content) == common.HashLength:
+		node, err := w.src.Get(content)
+		if err != nil {
+			return err
+		}
+		if len(node) == 0 {
+			return fmt.Errorf("trie node %x not found at path %x", content, path)
+		}
+		return w.walkNode(node, path)
+	default:
+		return fmt.Errorf("invalid reference to trie node at path %x: %x", path, ref)
+	}
+}
+
+func (w *trieWalker) walkNode(node []byte, path []byte) error {
+	elems, _, err := rlp.SplitList(node)
+	if err != nil {
+		return fmt.Errorf("decoding trie node at path %x: %w", path, err)
+	}
+	count, err := rlp.CountValues(elems)
+	if err != nil {
+		return fmt.Errorf("decoding trie node at path %x: %w", path, err)
+	}
+	switch count {
+	case 17:
+		rest := elems
+		for i := 0; i < 16; i++ {
+			_, _, next, err := rlp.Split(rest)
+			if err != nil {
+				return err
+			}
+			ref := rest[:len(rest)-len(next)]
+			rest = next
+			childPath := append(append([]byte{}, path...), byte(i))
+			if w.skip(childPath) {
+				continue
+			}
+			if err := w.walkRef(ref, childPath); err != nil {
+				return err
+			}
+		}
+		// The value slot of the full node is never used, since all the keys of the state tries have the same length
+		return nil
+	case 2:
+		compactKey, rest, err := rlp.SplitString(elems)
+		if err != nil {
+			return err
+		}
+		nibbles, isLeaf := compactToHex(compactKey)
+		childPath := append(append([]byte{}, path...), nibbles...)
+		if w.skip(childPath) {
+			return nil
+		}
+		if !isLeaf {
+			_, _, next, err := rlp.Split(rest)
+			if err != nil {
+				return err
+			}
+			return w.walkRef(rest[:len(rest)-len(next)], childPath)
+		}
+		value, _, err := rlp.SplitString(rest)
+		if err != nil {
+			return err
+		}
+		if len(childPath)%2 != 0 {
+			return fmt.Errorf("leaf with odd key length at path %x", childPath)
+		}
+		return w.onLeaf(hexToKeybytes(childPath), value)
+	default:
+		return fmt.Errorf("invalid number of elements in trie node at path %x: %d", path, count)
+	}
+}
+
+// compactToHex decodes the hex-prefix encoding of the key part of a short node
+func compactToHex(compact []byte) (nibbles []byte, isLeaf bool) {
+	if len(compact) == 0 {
+		return nil, false
+	}
+	flag := compact[0] >> 4
+	isLeaf = flag&2 != 0
+	if flag&1 != 0 {
+		nibbles = append(nibbles, compact[0]&0x0f)
+	}
+	for _, b := range compact[1:] {
+		nibbles = append(nibbles, b>>4, b&0x0f)
+	}
+	return nibbles, isLeaf
+}
+
+func keybytesToHex(key []byte) []byte {
+	nibbles := make([]byte, 2*len(key))
+	for i, b := range key {
+		nibbles[2*i] = b >> 4
+		nibbles[2*i+1] = b & 0x0f
+	}
+	return nibbles
+}
+
+func hexToKeybytes(nibbles []byte) []byte {
+	key := make([]byte, len(nibbles)/2)
+	for i := range key {
+		key[i] = nibbles[2*i]<<4 | nibbles[2*i+1]
+	}
+	return key
+}
+
+func readImportStateProgress(db ethdb.Getter) (root common.Hash, lastAccount []byte, err error) {
+	v, err := db.Get(dbutils.DatabaseInfoBucket, importStateProgressKey)
+	if err != nil && !errors.Is(err, ethdb.ErrKeyNotFound) {
+		return common.Hash{}, nil, err
+	}
+	if len(v) < common.HashLength {
+		return common.Hash{}, nil, nil
+	}
+	root = common.BytesToHash(v[:common.HashLength])
+	if len(v) > common.HashLength {
+		lastAccount = common.CopyBytes(v[common.HashLength:])
+	}
+	return root, lastAccount, nil
+}
+
+// FlattenState writes the state of go-ethereum with the given root into the turbo-geth state buckets
+// (dbutils.CurrentStateBucket, dbutils.ContractCodeBucket and dbutils.CodeBucket). The accounts are visited
+// in the order of their hashes, and the last imported account is saved with every committed batch,
+// so the flattening continues after it when restarted with the same root. Returns the number of imported accounts
+func FlattenState(src Source, db ethdb.Database, root common.Hash) (int, error) {
+	progressRoot, lastAccount, err := readImportStateProgress(db)
+	if err != nil {
+		return 0, err
+	}
+	if progressRoot != (common.Hash{}) && progressRoot != root {
+		return 0, fmt.Errorf("import of the state %x was interrupted, but the state %x is imported now; remove the partially imported database", progressRoot, root)
+	}
+	if lastAccount != nil {
+		log.Info("Resuming state import", "root", root, "after account", common.BytesToHash(lastAccount))
+	} else {
+		log.Info("Importing state", "root", root)
+	}
+
+	batch := db.NewBatch()
+	defer batch.Rollback()
+	var accountCount, storageCount int
+	var last []byte
+	err = walkTrie(src, root, lastAccount, func(addrHash []byte, value []byte) error {
+		var ga gethAccount
+		if err := rlp.DecodeBytes(value, &ga); err != nil {
+			return fmt.Errorf("decoding account %x: %w", addrHash, err)
+		}
+		account := accounts.NewAccount()
+		account.Initialised = true
+		account.Nonce = ga.Nonce
+		if ga.Balance != nil {
+			balance, overflow := uint256.FromBig(ga.Balance)
+			if overflow {
+				return fmt.Errorf("balance of account %x overflows", addrHash)
+			}
+			account.Balance.Set(balance)
+		}
+		account.Root = ga.Root
+		account.CodeHash = common.BytesToHash(ga.CodeHash)
+		hash := common.BytesToHash(addrHash)
+		if account.Root != trie.EmptyRoot || account.CodeHash != trie.EmptyCodeHash {
+			account.Incarnation = state.FirstContractIncarnation
+			if err := importCode(src, batch, hash, &account); err != nil {
+				return err
+			}
+			if err := walkTrie(src, account.Root, nil, func(keyHash []byte, value []byte) error {
+				// Storage values are RLP encoded in the leaves
+				v, _, err := rlp.SplitString(value)
+				if err != nil {
+					return fmt.Errorf("decoding storage item %x: %w", keyHash, err)
+				}
+				storageCount++
+				if err := batch.Put(dbutils.CurrentStateBucket, dbutils.GenerateCompositeStorageKey(hash, account.Incarnation, common.BytesToHash(keyHash)), common.CopyBytes(v)); err != nil {
+					return err
+				}
+				// Storage of the large contracts does not fit into one batch. The progress is not advanced
+				// until the whole account is imported, so its storage is rewritten after a restart
+				if batch.BatchSize() >= batch.IdealBatchSize() {
+					if _, err := batch.Commit(); err != nil {
+						return err
+					}
+				}
+				return nil
+			}); err != nil {
+				return fmt.Errorf("importing storage of account %x: %w", addrHash, err)
+			}
+		}
+		data := make([]byte, account.EncodingLengthForStorage())
+		account.EncodeForStorage(data)
+		if err := batch.Put(dbutils.CurrentStateBucket, common.CopyBytes(addrHash), data); err != nil {
+			return err
+		}
+		accountCount++
+		last = common.CopyBytes(addrHash)
+		if batch.BatchSize() >= batch.IdealBatchSize() {
+			if err := batch.Put(dbutils.DatabaseInfoBucket, importStateProgressKey, append(common.CopyBytes(root[:]), last...)); err != nil {
+				return err
+			}
+			if _, err := batch.Commit(); err != nil {
+				return err
+			}
+			log.Info("Imported accounts", "count", accountCount, "storage items", storageCount, "last", hash)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if last != nil {
+		if err := batch.Put(dbutils.DatabaseInfoBucket, importStateProgressKey, append(common.CopyBytes(root[:]), last...)); err != nil {
+			return 0, err
+		}
+	}
+	if _, err := batch.Commit(); err != nil {
+		return 0, err
+	}
+	log.Info("State imported", "accounts", accountCount, "storage items", storageCount)
+	return accountCount, nil
+}
+
+func importCode(src Source, db ethdb.Putter, addrHash common.Hash, account *accounts.Account) error {
+	if account.CodeHash == trie.EmptyCodeHash {
+		return nil
+	}
+	code, err := src.Get(append(append([]byte{}, gethCodePrefix...), account.CodeHash[:]...))
+	if err != nil {
+		return err
+	}
+	if len(code) == 0 {
+		if code, err = src.Get(account.CodeHash[:]); err != nil {
+			return err
+		}
+	}
+	if len(code) == 0 {
+		return fmt.Errorf("code %x of account %x not found", account.CodeHash, addrHash)
+	}
+	if err := db.Put(dbutils.CodeBucket, account.CodeHash[:], code); err != nil {
+		return err
+	}
+	return db.Put(dbutils.ContractCodeBucket, dbutils.GenerateStoragePrefix(addrHash[:], account.Incarnation), account.CodeHash[:])
+}
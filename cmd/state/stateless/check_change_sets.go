@@ -21,7 +21,7 @@ import (
 
 // CheckChangeSets re-executes historical transactions in read-only mode
 // and checks that their outputs match the database ChangeSets.
-func CheckChangeSets(genesis *core.Genesis, blockNum uint64, chaindata string, historyfile string, nocheck bool) error {
+func CheckChangeSets(genesis *core.Genesis, blockNum uint64, chaindata string, historyfile string, nocheck bool, readOnly bool) error {
 	if len(historyfile) == 0 {
 		historyfile = chaindata
 	}
@@ -36,14 +36,14 @@ func CheckChangeSets(genesis *core.Genesis, blockNum uint64, chaindata string, h
 		interruptCh <- true
 	}()
 
-	chainDb, err := ethdb.NewBoltDatabase(chaindata)
+	chainDb, err := ethdb.OpenBoltDatabase(chaindata, readOnly)
 	if err != nil {
 		return err
 	}
 	defer chainDb.Close()
 	historyDb := chainDb
 	if chaindata != historyfile {
-		historyDb, err = ethdb.NewBoltDatabase(historyfile)
+		historyDb, err = ethdb.OpenBoltDatabase(historyfile, readOnly)
 		if err != nil {
 			return err
 		}
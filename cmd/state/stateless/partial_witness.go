@@ -0,0 +1,215 @@
+package stateless
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+	"github.com/ledgerwatch/turbo-geth/core/rawdb"
+	"github.com/ledgerwatch/turbo-geth/core/types/accounts"
+	"github.com/ledgerwatch/turbo-geth/crypto"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+	"github.com/ledgerwatch/turbo-geth/trie"
+)
+
+// RetainFile is a trie.RetainDecider for the accounts and storage items listed in a file, one per line:
+//
+//	<address>               - the account together with its code and the whole storage
+//	<address> <storage key> - the single storage item (and the account on the path to it)
+//
+// Empty lines and the lines starting with # are ignored
+type RetainFile struct {
+	paths    *trie.RetainList // Keys of the listed items, the nodes on the paths to them are retained
+	prefixes [][]byte         // Hex (or binary) keys of the listed accounts, the nodes under them are retained
+	accounts []common.Hash    // Hashes of the listed accounts
+	codes    map[common.Hash]struct{}
+}
+
+// ReadRetainFile parses the file, see RetainFile. With binary the prefixes are produced for the binary trie
+func ReadRetainFile(path string, binary bool) (*RetainFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	rf := &RetainFile{paths: trie.NewRetainList(0), codes: make(map[common.Hash]struct{})}
+	if binary {
+		rf.paths = trie.NewBinaryRetainList(0)
+	}
+	scanner := bufio.NewScanner(f)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) > 2 || !common.IsHexAddress(fields[0]) {
+			return nil, fmt.Errorf("line %d: expected address and optional storage key, got %q", lineNum, line)
+		}
+		addrHash := crypto.Keccak256Hash(common.HexToAddress(fields[0]).Bytes())
+		if len(fields) == 1 {
+			rf.paths.AddKey(addrHash[:])
+			if binary {
+				rf.prefixes = append(rf.prefixes, keyToBin(addrHash[:]))
+			} else {
+				rf.prefixes = append(rf.prefixes, keyToHex(addrHash[:]))
+			}
+			rf.accounts = append(rf.accounts, addrHash)
+			continue
+		}
+		keyBytes := common.FromHex(fields[1])
+		if len(keyBytes) > common.HashLength {
+			return nil, fmt.Errorf("line %d: storage key %s is longer than %d bytes", lineNum, fields[1], common.HashLength)
+		}
+		keyHash := crypto.Keccak256Hash(common.BytesToHash(keyBytes).Bytes())
+		rf.paths.AddKey(append(common.CopyBytes(addrHash[:]), keyHash[:]...))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+// Retain implements trie.RetainDecider
+func (rf *RetainFile) Retain(prefix []byte) bool {
+	if rf.paths.Retain(prefix) {
+		return true
+	}
+	for _, p := range rf.prefixes {
+		if bytes.HasPrefix(prefix, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsCodeTouched implements trie.RetainDecider
+func (rf *RetainFile) IsCodeTouched(codeHash common.Hash) bool {
+	_, ok := rf.codes[codeHash]
+	return ok
+}
+
+// Rewind prepares the decider for another pass over the trie
+func (rf *RetainFile) Rewind() {
+	rf.paths.Rewind()
+}
+
+func keyToHex(key []byte) []byte {
+	hex := make([]byte, 2*len(key))
+	for i, b := range key {
+		hex[2*i] = b / 16
+		hex[2*i+1] = b % 16
+	}
+	return hex
+}
+
+func keyToBin(key []byte) []byte {
+	bin := make([]byte, 8*len(key))
+	for i, b := range key {
+		for j := 0; j < 8; j++ {
+			bin[8*i+j] = (b >> (7 - uint(j))) & 1
+		}
+	}
+	return bin
+}
+
+// PartialWitness produces the witness of the current state, which covers exactly the accounts and storage items
+// listed in the retain file (see RetainFile), and writes it into the output file. The rest of the state
+// is represented by the hashes only. With binary the witness is produced for the binary trie of the same state,
+// which lets compare the witness sizes of the two encodings
+func PartialWitness(chaindata string, retainFile string, output string, binary bool) error {
+	db, err := ethdb.OpenBoltDatabase(chaindata, true)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	rf, err := ReadRetainFile(retainFile, binary)
+	if err != nil {
+		return err
+	}
+
+	loader := trie.NewFlatDbSubTrieLoader()
+	loader.SetBinary(binary)
+	if err = loader.Reset(db, rf, [][]byte{nil}, []int{0}, false); err != nil {
+		return err
+	}
+	subTries, err := loader.LoadSubTries()
+	if err != nil {
+		return err
+	}
+	root := subTries.Hashes[0]
+	// The headers commit to the hexary trie only
+	if head := rawdb.ReadHeadHeaderHash(db); !binary && head != (common.Hash{}) {
+		if number := rawdb.ReadHeaderNumber(db, head); number != nil {
+			if header := rawdb.ReadHeader(db, head, *number); header != nil && header.Root != root {
+				fmt.Printf("Warning: state root %x differs from the root of the head header %d: %x\n", root, *number, header.Root)
+			}
+		}
+	}
+	var t *trie.Trie
+	if binary {
+		t = trie.NewBinary(root)
+	} else {
+		t = trie.New(root)
+	}
+	if err = t.HookSubTries(subTries, [][]byte{nil}); err != nil {
+		return err
+	}
+
+	// Attach the code of the listed contracts
+	for _, addrHash := range rf.accounts {
+		enc, err1 := db.Get(dbutils.CurrentStateBucket, addrHash[:])
+		if err1 != nil {
+			if errors.Is(err1, ethdb.ErrKeyNotFound) {
+				continue
+			}
+			return err1
+		}
+		var acc accounts.Account
+		if err1 = acc.DecodeForStorage(enc); err1 != nil {
+			return err1
+		}
+		if acc.Incarnation == 0 {
+			continue
+		}
+		codeHash, err1 := db.Get(dbutils.ContractCodeBucket, dbutils.GenerateStoragePrefix(addrHash[:], acc.Incarnation))
+		if err1 != nil {
+			return fmt.Errorf("code hash of %x: %w", addrHash, err1)
+		}
+		code, err1 := db.Get(dbutils.CodeBucket, codeHash)
+		if err1 != nil {
+			return fmt.Errorf("code %x: %w", codeHash, err1)
+		}
+		if err1 = t.UpdateAccountCode(addrHash[:], code); err1 != nil {
+			return err1
+		}
+		rf.codes[common.BytesToHash(codeHash)] = struct{}{}
+	}
+
+	rf.Rewind()
+	witness, err := t.ExtractWitness(false, rf)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	stats, err := witness.WriteTo(w)
+	if err != nil {
+		return err
+	}
+	if err = w.Flush(); err != nil {
+		return err
+	}
+	fmt.Printf("Witness for state root %x written into %s: %d bytes, of them %d code bytes, %d hash bytes\n",
+		root, output, stats.BlockWitnessSize(), stats.CodesSize(), stats.HashesSize())
+	return nil
+}
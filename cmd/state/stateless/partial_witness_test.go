@@ -0,0 +1,117 @@
+package stateless
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+	"github.com/ledgerwatch/turbo-geth/core/rawdb"
+	"github.com/ledgerwatch/turbo-geth/crypto"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+	"github.com/ledgerwatch/turbo-geth/trie"
+)
+
+func writeRetainFile(t *testing.T, dir string, content string) string {
+	path := filepath.Join(dir, "retain.txt")
+	require.NoError(t, ioutil.WriteFile(path, []byte(content), 0600))
+	return path
+}
+
+func TestReadRetainFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "retain-file")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	account := common.HexToAddress("0x71562b71999873DB5b286dF957af199Ec94617F7")
+	contract := common.HexToAddress("0x0000000000000000000000000000000000000001")
+	accountHash := crypto.Keccak256Hash(account.Bytes())
+	contractHash := crypto.Keccak256Hash(contract.Bytes())
+	keyHash := crypto.Keccak256Hash(common.HexToHash("0x05").Bytes())
+
+	path := writeRetainFile(t, dir, "# the whole account\n"+account.Hex()+"\n\n  "+contract.Hex()+" 0x05  \n")
+	rf, err := ReadRetainFile(path, false)
+	require.NoError(t, err)
+	assert.Equal(t, []common.Hash{accountHash}, rf.accounts)
+
+	// The nodes under the listed account are retained, under the storage item only the path to it
+	accountHex := keyToHex(accountHash[:])
+	assert.True(t, rf.Retain(accountHex[:1]))
+	assert.True(t, rf.Retain(accountHex))
+	assert.True(t, rf.Retain(append(common.CopyBytes(accountHex), 0x0, 0x1)))
+	contractHex := keyToHex(contractHash[:])
+	rf.Rewind()
+	assert.True(t, rf.Retain(append(common.CopyBytes(contractHex), keyToHex(keyHash[:])[:3]...)))
+	otherKey := keyToHex(crypto.Keccak256(common.HexToHash("0x06").Bytes()))
+	rf.Rewind()
+	assert.False(t, rf.Retain(append(common.CopyBytes(contractHex), otherKey[0]^1)))
+
+	rf, err = ReadRetainFile(path, true)
+	require.NoError(t, err)
+	accountBin := keyToBin(accountHash[:])
+	assert.Len(t, accountBin, 8*common.HashLength)
+	assert.True(t, rf.Retain(append(common.CopyBytes(accountBin), 1, 0, 1)))
+
+	for _, invalid := range []string{
+		"0x01\n",
+		account.Hex() + " 0x05 0x06\n",
+		account.Hex() + " 0x" + common.Bytes2Hex(make([]byte, common.HashLength+1)) + "\n",
+	} {
+		_, err = ReadRetainFile(writeRetainFile(t, dir, invalid), false)
+		assert.Error(t, err, "%q", invalid)
+	}
+}
+
+func TestPartialWitness(t *testing.T) {
+	dir, err := ioutil.TempDir("", "partial-witness")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "chaindata")
+	gspec := replayTestChain(t, path)
+
+	var sender common.Address
+	for address := range gspec.Alloc {
+		sender = address
+	}
+	retainFile := writeRetainFile(t, dir, sender.Hex()+"\n")
+	output := filepath.Join(dir, "witness.bin")
+	require.NoError(t, PartialWitness(path, retainFile, output, false))
+
+	db, err := ethdb.NewBoltDatabase(path)
+	require.NoError(t, err)
+	defer db.Close()
+	head := rawdb.ReadHeadHeaderHash(db)
+	header := rawdb.ReadHeader(db, head, *rawdb.ReadHeaderNumber(db, head))
+	require.NotNil(t, header)
+
+	f, err := os.Open(output)
+	require.NoError(t, err)
+	defer f.Close()
+	witness, err := trie.NewWitnessFromReader(f, false)
+	require.NoError(t, err)
+	tr, err := trie.BuildTrieFromWitness(witness, false, false)
+	require.NoError(t, err)
+	assert.Equal(t, header.Root, tr.Hash())
+
+	acc, ok := tr.GetAccount(crypto.Keccak256(sender.Bytes()))
+	assert.True(t, ok)
+	require.NotNil(t, acc)
+	assert.Equal(t, uint64(6), acc.Nonce)
+	// Some of the accounts, which are not listed, are only represented by the hashes of their subtries
+	var hashed int
+	require.NoError(t, db.Walk(dbutils.CurrentStateBucket, nil, 0, func(k, _ []byte) (bool, error) {
+		if len(k) != common.HashLength {
+			return true, nil
+		}
+		if _, ok := tr.GetAccount(k); !ok {
+			hashed++
+		}
+		return true, nil
+	}))
+	assert.NotZero(t, hashed)
+}
@@ -0,0 +1,341 @@
+package stateless
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/common/changeset"
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+	"github.com/ledgerwatch/turbo-geth/common/hexutil"
+	"github.com/ledgerwatch/turbo-geth/consensus"
+	"github.com/ledgerwatch/turbo-geth/consensus/ethash"
+	"github.com/ledgerwatch/turbo-geth/consensus/misc"
+	"github.com/ledgerwatch/turbo-geth/core"
+	"github.com/ledgerwatch/turbo-geth/core/rawdb"
+	"github.com/ledgerwatch/turbo-geth/core/state"
+	"github.com/ledgerwatch/turbo-geth/core/types"
+	"github.com/ledgerwatch/turbo-geth/core/vm"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+	"github.com/ledgerwatch/turbo-geth/log"
+	"github.com/ledgerwatch/turbo-geth/params"
+)
+
+// ReplayDivergence is a single mismatch between the re-executed block and the data stored in the database
+type ReplayDivergence struct {
+	Field    string       `json:"field"`
+	TxIndex  *int         `json:"txIndex,omitempty"`
+	TxHash   *common.Hash `json:"txHash,omitempty"`
+	Expected interface{}  `json:"expected"`
+	Actual   interface{}  `json:"actual"`
+}
+
+// ReplayReport is the machine-readable result of ReplayBlock
+type ReplayReport struct {
+	Block        uint64             `json:"block"`
+	Hash         common.Hash        `json:"hash"`
+	Transactions int                `json:"transactions"`
+	RootVerified bool               `json:"rootVerified"` // false if the block was executed on top of the historical state, which has no trie
+	Match        bool               `json:"match"`
+	Divergences  []ReplayDivergence `json:"divergences"`
+}
+
+func (r *ReplayReport) diverge(field string, expected, actual interface{}) {
+	r.Divergences = append(r.Divergences, ReplayDivergence{Field: field, Expected: expected, Actual: actual})
+}
+
+func (r *ReplayReport) divergeTx(field string, txIndex int, txHash common.Hash, expected, actual interface{}) {
+	r.Divergences = append(r.Divergences, ReplayDivergence{Field: field, TxIndex: &txIndex, TxHash: &txHash, Expected: expected, Actual: actual})
+}
+
+// ReplayBlock re-executes a single historical block, optionally with the EVM tracer attached, and compares
+// the results (gas used, receipts, changesets and, if verifyRoot is set, the state root) with the stored ones.
+// If verifyRoot is set, the current state is unwound to the parent of the block in a batch that is never committed,
+// which is required to compute the root, but is expensive for the blocks far behind the head.
+// Otherwise the pre-state is read from the history (GetAsOf). The report is written to out as JSON.
+func ReplayBlock(genesis *core.Genesis, chaindata string, blockNum uint64, tracer string, tracefile string, verifyRoot bool, readOnly bool, out io.Writer) error {
+	if blockNum == 0 {
+		return fmt.Errorf("genesis block cannot be replayed")
+	}
+	chainDb, err := ethdb.OpenBoltDatabase(chaindata, readOnly)
+	if err != nil {
+		return err
+	}
+	defer chainDb.Close()
+
+	chainConfig := genesis.Config
+	engine := ethash.NewFaker()
+	chain := &replayChainContext{db: chainDb, engine: engine}
+	block := rawdb.ReadBlock(chainDb, rawdb.ReadCanonicalHash(chainDb, blockNum), blockNum)
+	if block == nil {
+		return fmt.Errorf("block %d not found", blockNum)
+	}
+
+	vmConfig := vm.Config{}
+	switch tracer {
+	case "", "none":
+	case "json":
+		traceOut := io.Writer(os.Stderr)
+		if tracefile != "" {
+			f, err := os.Create(tracefile)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			traceOut = f
+		}
+		vmConfig.Debug = true
+		vmConfig.Tracer = vm.NewJSONLogger(&vm.LogConfig{}, traceOut)
+	default:
+		return fmt.Errorf("unknown tracer %q, supported: json, none", tracer)
+	}
+
+	report := &ReplayReport{Block: blockNum, Hash: block.Hash(), Transactions: len(block.Transactions()), RootVerified: verifyRoot}
+	var receipts types.Receipts
+	var usedGas uint64
+	if verifyRoot {
+		receipts, usedGas, err = replayWithTrie(chainDb, chain, chainConfig, engine, block, vmConfig, report)
+	} else {
+		receipts, usedGas, err = replayWithHistory(chainDb, chain, chainConfig, block, vmConfig, report)
+	}
+	if err != nil {
+		return err
+	}
+	compareReceipts(report, chainDb, chainConfig, block, receipts, usedGas, verifyRoot)
+
+	report.Match = len(report.Divergences) == 0
+	log.Info("Block replayed", "block", blockNum, "match", report.Match, "divergences", len(report.Divergences))
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
+
+// replayChainContext reads the headers straight from the database, so that the block is replayed
+// without BlockChain, which writes into the database when it is created
+type replayChainContext struct {
+	db     ethdb.Getter
+	engine consensus.Engine
+}
+
+func (c *replayChainContext) Engine() consensus.Engine {
+	return c.engine
+}
+
+func (c *replayChainContext) GetHeader(hash common.Hash, number uint64) *types.Header {
+	return rawdb.ReadHeader(c.db, hash, number)
+}
+
+// replayWithTrie executes the block the same way BlockChain does, on top of the current state
+// unwound to the parent block. The batch with the unwound state is discarded
+func replayWithTrie(chainDb *ethdb.BoltDatabase, chain core.ChainContext, chainConfig *params.ChainConfig, engine *ethash.Ethash,
+	block *types.Block, vmConfig vm.Config, report *ReplayReport,
+) (types.Receipts, uint64, error) {
+	headHash := rawdb.ReadHeadBlockHash(chainDb)
+	headNumber := rawdb.ReadHeaderNumber(chainDb, headHash)
+	if headNumber == nil {
+		return nil, 0, fmt.Errorf("head block %x not found", headHash)
+	}
+	head := rawdb.ReadHeader(chainDb, headHash, *headNumber)
+	if head == nil {
+		return nil, 0, fmt.Errorf("head header %d %x not found", *headNumber, headHash)
+	}
+	if *headNumber < block.NumberU64() {
+		return nil, 0, fmt.Errorf("block %d is ahead of the current state at block %d", block.NumberU64(), *headNumber)
+	}
+	batch := chainDb.NewBatch()
+	defer batch.Rollback()
+
+	tds := state.NewTrieDbState(head.Root, batch, *headNumber)
+	if err := tds.UnwindTo(block.NumberU64() - 1); err != nil {
+		return nil, 0, fmt.Errorf("unwinding to block %d: %w", block.NumberU64()-1, err)
+	}
+	tds.SetBlockNr(block.NumberU64())
+	ibs := state.New(tds)
+	header := block.Header()
+	gp := new(core.GasPool).AddGas(block.GasLimit())
+	var usedGas uint64
+	var receipts types.Receipts
+	if chainConfig.DAOForkSupport && chainConfig.DAOForkBlock != nil && chainConfig.DAOForkBlock.Cmp(block.Number()) == 0 {
+		misc.ApplyDAOHardFork(ibs)
+	}
+	// The same steps as StateProcessor.PreProcess and PostProcess, which need BlockChain
+	tds.StartNewBuffer()
+	for i, tx := range block.Transactions() {
+		ibs.Prepare(tx.Hash(), block.Hash(), i)
+		receipt, err := core.ApplyTransaction(chainConfig, chain, nil, gp, ibs, tds.TrieStateWriter(), header, tx, &usedGas, vmConfig)
+		if err != nil {
+			return nil, 0, fmt.Errorf("tx %x failed: %w", tx.Hash(), err)
+		}
+		receipts = append(receipts, receipt)
+		if !chainConfig.IsByzantium(header.Number) {
+			tds.StartNewBuffer()
+		}
+	}
+	engine.Finalize(chainConfig, header, ibs, block.Transactions(), block.Uncles())
+	ctx := chainConfig.WithEIPsFlags(context.Background(), header.Number)
+	if err := ibs.FinalizeTx(ctx, tds.TrieStateWriter()); err != nil {
+		return nil, 0, err
+	}
+	if _, err := tds.ResolveStateTrie(false, false); err != nil {
+		return nil, 0, err
+	}
+	root, err := tds.CalcTrieRoots(false)
+	if err != nil {
+		return nil, 0, err
+	}
+	roots, err := tds.UpdateStateTrie()
+	if err != nil {
+		return nil, 0, err
+	}
+	if !chainConfig.IsByzantium(header.Number) {
+		for i, receipt := range receipts {
+			receipt.PostState = roots[i].Bytes()
+		}
+	}
+	if root != block.Root() {
+		report.diverge("root", block.Root(), root)
+	}
+	return receipts, usedGas, nil
+}
+
+// replayWithHistory executes the block on top of the historical state, which allows to compare
+// the produced changesets with the stored ones, but not the state root
+func replayWithHistory(chainDb *ethdb.BoltDatabase, chain core.ChainContext, chainConfig *params.ChainConfig,
+	block *types.Block, vmConfig vm.Config, report *ReplayReport,
+) (types.Receipts, uint64, error) {
+	header := block.Header()
+	ibs := state.New(state.NewDbState(chainDb, block.NumberU64()-1))
+	noOpWriter := state.NewNoopWriter()
+	csw := state.NewChangeSetWriter()
+	gp := new(core.GasPool).AddGas(block.GasLimit())
+	var usedGas uint64
+	var receipts types.Receipts
+	if chainConfig.DAOForkSupport && chainConfig.DAOForkBlock != nil && chainConfig.DAOForkBlock.Cmp(block.Number()) == 0 {
+		misc.ApplyDAOHardFork(ibs)
+	}
+	for i, tx := range block.Transactions() {
+		ibs.Prepare(tx.Hash(), block.Hash(), i)
+		receipt, err := core.ApplyTransaction(chainConfig, chain, nil, gp, ibs, noOpWriter, header, tx, &usedGas, vmConfig)
+		if err != nil {
+			return nil, 0, fmt.Errorf("tx %x failed: %w", tx.Hash(), err)
+		}
+		receipts = append(receipts, receipt)
+	}
+	if _, err := ethash.NewFullFaker().FinalizeAndAssemble(chainConfig, header, ibs, block.Transactions(), block.Uncles(), receipts); err != nil {
+		return nil, 0, fmt.Errorf("finalize of block %d failed: %w", block.NumberU64(), err)
+	}
+	ctx := chainConfig.WithEIPsFlags(context.Background(), header.Number)
+	if err := ibs.CommitBlock(ctx, csw); err != nil {
+		return nil, 0, fmt.Errorf("commiting block %d failed: %w", block.NumberU64(), err)
+	}
+
+	accountChanges, err := csw.GetAccountChanges()
+	if err != nil {
+		return nil, 0, err
+	}
+	expectedAccountChanges, err := changeset.EncodeAccounts(accountChanges)
+	if err != nil {
+		return nil, 0, err
+	}
+	dbAccountChanges, err := chainDb.GetChangeSetByBlock(dbutils.AccountsHistoryBucket, block.NumberU64())
+	if err != nil {
+		return nil, 0, err
+	}
+	if !bytes.Equal(dbAccountChanges, expectedAccountChanges) {
+		report.diverge("accountChanges", hexutil.Bytes(dbAccountChanges), hexutil.Bytes(expectedAccountChanges))
+	}
+
+	storageChanges, err := csw.GetStorageChanges()
+	if err != nil {
+		return nil, 0, err
+	}
+	expectedStorageChanges := make([]byte, 0)
+	if storageChanges.Len() > 0 {
+		if expectedStorageChanges, err = changeset.EncodeStorage(storageChanges); err != nil {
+			return nil, 0, err
+		}
+	}
+	dbStorageChanges, err := chainDb.GetChangeSetByBlock(dbutils.StorageHistoryBucket, block.NumberU64())
+	if err != nil {
+		return nil, 0, err
+	}
+	if !bytes.Equal(dbStorageChanges, expectedStorageChanges) {
+		report.diverge("storageChanges", hexutil.Bytes(dbStorageChanges), hexutil.Bytes(expectedStorageChanges))
+	}
+	return receipts, usedGas, nil
+}
+
+// compareReceipts checks the gas used, the receipts root and the bloom against the header, and
+// the individual receipts against the stored ones (if the receipts were stored)
+func compareReceipts(report *ReplayReport, chainDb *ethdb.BoltDatabase, chainConfig *params.ChainConfig, block *types.Block, receipts types.Receipts, usedGas uint64, verifyRoot bool) {
+	header := block.Header()
+	if usedGas != header.GasUsed {
+		report.diverge("gasUsed", hexutil.Uint64(header.GasUsed), hexutil.Uint64(usedGas))
+	}
+	if bloom := types.CreateBloom(receipts); bloom != header.Bloom {
+		report.diverge("logsBloom", header.Bloom, bloom)
+	}
+	// Before Byzantium receipts contain intermediate roots, which are only computed with the trie
+	if verifyRoot || chainConfig.IsByzantium(header.Number) {
+		if receiptHash := types.DeriveSha(receipts); receiptHash != header.ReceiptHash {
+			report.diverge("receiptsRoot", header.ReceiptHash, receiptHash)
+		}
+	}
+
+	stored := rawdb.ReadReceipts(chainDb, block.Hash(), block.NumberU64(), chainConfig)
+	if stored == nil {
+		return
+	}
+	if len(stored) != len(receipts) {
+		report.diverge("receipts", len(stored), len(receipts))
+		return
+	}
+	for i, expected := range stored {
+		actual := receipts[i]
+		txHash := block.Transactions()[i].Hash()
+		if expected.Status != actual.Status {
+			report.divergeTx("status", i, txHash, hexutil.Uint64(expected.Status), hexutil.Uint64(actual.Status))
+		}
+		if expected.CumulativeGasUsed != actual.CumulativeGasUsed {
+			report.divergeTx("cumulativeGasUsed", i, txHash, hexutil.Uint64(expected.CumulativeGasUsed), hexutil.Uint64(actual.CumulativeGasUsed))
+		}
+		if expected.GasUsed != actual.GasUsed {
+			report.divergeTx("gasUsed", i, txHash, hexutil.Uint64(expected.GasUsed), hexutil.Uint64(actual.GasUsed))
+		}
+		if verifyRoot && !bytes.Equal(expected.PostState, actual.PostState) {
+			report.divergeTx("root", i, txHash, hexutil.Bytes(expected.PostState), hexutil.Bytes(actual.PostState))
+		}
+		if expected.ContractAddress != actual.ContractAddress {
+			report.divergeTx("contractAddress", i, txHash, expected.ContractAddress, actual.ContractAddress)
+		}
+		if expected.Bloom != actual.Bloom {
+			report.divergeTx("logsBloom", i, txHash, expected.Bloom, actual.Bloom)
+		}
+		if len(expected.Logs) != len(actual.Logs) {
+			report.divergeTx("logs", i, txHash, len(expected.Logs), len(actual.Logs))
+			continue
+		}
+		for j, l := range expected.Logs {
+			al := actual.Logs[j]
+			if l.Address != al.Address || !equalTopics(l.Topics, al.Topics) || !bytes.Equal(l.Data, al.Data) {
+				report.divergeTx(fmt.Sprintf("logs[%d]", j), i, txHash, l, al)
+			}
+		}
+	}
+}
+
+func equalTopics(a, b []common.Hash) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,116 @@
+package stateless
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/consensus/ethash"
+	"github.com/ledgerwatch/turbo-geth/core"
+	"github.com/ledgerwatch/turbo-geth/core/rawdb"
+	"github.com/ledgerwatch/turbo-geth/core/types"
+	"github.com/ledgerwatch/turbo-geth/core/vm"
+	"github.com/ledgerwatch/turbo-geth/crypto"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+	"github.com/ledgerwatch/turbo-geth/params"
+)
+
+// replayTestChain writes the chain of 3 blocks with the value transfers into the Bolt database at the path
+func replayTestChain(t *testing.T, path string) *core.Genesis {
+	key, _ := crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+	address := crypto.PubkeyToAddress(key.PublicKey)
+	gspec := &core.Genesis{
+		Config: params.TestChainConfig,
+		Alloc:  core.GenesisAlloc{address: {Balance: big.NewInt(1000000000)}},
+	}
+	signer := types.NewEIP155Signer(gspec.Config.ChainID)
+
+	db, err := ethdb.NewBoltDatabase(path)
+	require.NoError(t, err)
+	defer db.Close()
+	genesis := gspec.MustCommit(db)
+	engine := ethash.NewFaker()
+	blockchain, err := core.NewBlockChain(db, nil, gspec.Config, engine, vm.Config{}, nil, nil)
+	require.NoError(t, err)
+	defer blockchain.Stop()
+	blockchain.EnableReceipts(true)
+
+	blocks, _ := core.GenerateChain(context.Background(), gspec.Config, genesis, engine, db.MemCopy(), 3, func(i int, block *core.BlockGen) {
+		for j := 0; j <= i; j++ {
+			tx, err := types.SignTx(types.NewTransaction(block.TxNonce(address), common.Address{byte(i), byte(j)}, big.NewInt(1), 21000, new(big.Int), nil), signer, key)
+			require.NoError(t, err)
+			block.AddTx(tx)
+		}
+	})
+	_, err = blockchain.InsertChain(context.Background(), blocks)
+	require.NoError(t, err)
+	return gspec
+}
+
+func replayTestBlock(t *testing.T, gspec *core.Genesis, path string, blockNum uint64, verifyRoot bool) *ReplayReport {
+	var out bytes.Buffer
+	require.NoError(t, ReplayBlock(gspec, path, blockNum, "", "", verifyRoot, false, &out))
+	var report ReplayReport
+	require.NoError(t, json.Unmarshal(out.Bytes(), &report))
+	return &report
+}
+
+func TestReplayBlock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replay-block")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "chaindata")
+	gspec := replayTestChain(t, path)
+
+	for _, verifyRoot := range []bool{false, true} {
+		report := replayTestBlock(t, gspec, path, 2, verifyRoot)
+		assert.Equal(t, uint64(2), report.Block)
+		assert.Equal(t, 2, report.Transactions)
+		assert.Equal(t, verifyRoot, report.RootVerified)
+		assert.True(t, report.Match, "verifyRoot=%t", verifyRoot)
+		assert.Empty(t, report.Divergences, "verifyRoot=%t", verifyRoot)
+	}
+}
+
+func TestReplayBlockDivergence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replay-block")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "chaindata")
+	gspec := replayTestChain(t, path)
+
+	// The stored receipt of the second transaction claims a different gas
+	db, err := ethdb.NewBoltDatabase(path)
+	require.NoError(t, err)
+	hash := rawdb.ReadCanonicalHash(db, 2)
+	receipts := rawdb.ReadReceipts(db, hash, 2, gspec.Config)
+	require.Len(t, receipts, 2)
+	receipts[1].CumulativeGasUsed++
+	rawdb.WriteReceipts(db, hash, 2, receipts)
+	db.Close()
+
+	report := replayTestBlock(t, gspec, path, 2, false)
+	assert.False(t, report.Match)
+	// The gas used by the transaction is derived from the cumulative gas of the stored receipts
+	require.Len(t, report.Divergences, 2)
+	for i, expected := range []ReplayDivergence{
+		{Field: "cumulativeGasUsed", Expected: "0xa411", Actual: "0xa410"},
+		{Field: "gasUsed", Expected: "0x5209", Actual: "0x5208"},
+	} {
+		d := report.Divergences[i]
+		assert.Equal(t, expected.Field, d.Field)
+		require.NotNil(t, d.TxIndex)
+		assert.Equal(t, 1, *d.TxIndex)
+		assert.Equal(t, expected.Expected, d.Expected)
+		assert.Equal(t, expected.Actual, d.Actual)
+	}
+}
@@ -116,7 +116,7 @@ func (isa IntSorterAddr) Swap(i, j int) {
 
 var ReportsProgressBucket = []byte("reports_progress")
 
-func commit(k []byte, tx *bolt.Tx, data interface{}) {
+func commit(k []byte, tx ethdb.Tx, data interface{}) {
 	//defer func(t time.Time) { fmt.Println("Commit:", time.Since(t)) }(time.Now())
 	var buf bytes.Buffer
 
@@ -129,13 +129,12 @@ func commit(k []byte, tx *bolt.Tx, data interface{}) {
 	}
 }
 
-func restore(k []byte, tx *bolt.Tx, data interface{}) {
+func restore(k []byte, tx ethdb.Tx, data interface{}) {
 	//defer func(t time.Time) { fmt.Println("Restore:", time.Since(t)) }(time.Now())
-	reportsProgress, err := tx.CreateBucketIfNotExists(ReportsProgressBucket, false)
-	if err != nil {
+	if err := tx.CreateBucket(ReportsProgressBucket); err != nil {
 		panic(err)
 	}
-	v, _ := reportsProgress.Get(k)
+	v, _ := tx.Bucket(ReportsProgressBucket).Get(k)
 	if v == nil {
 		return
 	}
@@ -159,19 +158,18 @@ type StateGrowth1Reporter struct {
 	remoteDB       ethdb.KV `codec:"-"`
 }
 
-func NewStateGrowth1Reporter(ctx context.Context, remoteDB ethdb.KV, localDB *bolt.DB) *StateGrowth1Reporter {
+func NewStateGrowth1Reporter(ctx context.Context, remoteDB ethdb.KV, localDB ethdb.KV) *StateGrowth1Reporter {
 	var LastTimestampsBucket = []byte("sg1_accounts_last_timestamps")
 	var ProgressKey = []byte("state_growth_1")
 
 	var err error
-	var localTx *bolt.Tx
-	var lastTimestamps *bolt.Bucket
+	var localTx ethdb.Tx
 
-	if localTx, err = localDB.Begin(true); err != nil {
+	if localTx, err = localDB.Begin(ctx, true); err != nil {
 		panic(err)
 	}
 
-	if lastTimestamps, err = localTx.CreateBucketIfNotExists(LastTimestampsBucket, false); err != nil {
+	if err = localTx.CreateBucket(LastTimestampsBucket); err != nil {
 		panic(err)
 	}
 
@@ -180,15 +178,15 @@ func NewStateGrowth1Reporter(ctx context.Context, remoteDB ethdb.KV, localDB *bo
 		HistoryKey:       []byte{},
 		AccountKey:       []byte{},
 		MaxTimestamp:     0,
-		lastTimestamps:   typedbucket.NewUint64(lastTimestamps),
+		lastTimestamps:   typedbucket.NewUint64(localTx.Bucket(LastTimestampsBucket)),
 		CreationsByBlock: make(map[uint64]int),
 	}
 	rep.commit = func(ctx context.Context) {
 		commit(ProgressKey, localTx, rep)
-		if err = localTx.Commit(); err != nil {
+		if err = localTx.Commit(ctx); err != nil {
 			panic(err)
 		}
-		localTx, err = localDB.Begin(true)
+		localTx, err = localDB.Begin(ctx, true)
 		if err != nil {
 			panic(err)
 		}
@@ -379,25 +377,23 @@ type StateGrowth2Reporter struct {
 	rollback func(ctx context.Context)
 }
 
-func NewStateGrowth2Reporter(ctx context.Context, remoteDB ethdb.KV, localDB *bolt.DB) *StateGrowth2Reporter {
+func NewStateGrowth2Reporter(ctx context.Context, remoteDB ethdb.KV, localDB ethdb.KV) *StateGrowth2Reporter {
 	var LastTimestampsBucket = []byte("sg2_accounts_last_timestamps")
 	var CreationsByBlockBucket = []byte("sg2_creations_by_block")
 	var ProgressKey = []byte("state_growth_2")
 
 	var err error
-	var localTx *bolt.Tx
-	var lastTimestamps *bolt.Bucket
-	var creationsByBlock *bolt.Bucket
+	var localTx ethdb.Tx
 
-	if localTx, err = localDB.Begin(true); err != nil {
+	if localTx, err = localDB.Begin(ctx, true); err != nil {
 		panic(err)
 	}
 
-	if lastTimestamps, err = localTx.CreateBucketIfNotExists(LastTimestampsBucket, false); err != nil {
+	if err = localTx.CreateBucket(LastTimestampsBucket); err != nil {
 		panic(err)
 	}
 
-	if creationsByBlock, err = localTx.CreateBucketIfNotExists(CreationsByBlockBucket, false); err != nil {
+	if err = localTx.CreateBucket(CreationsByBlockBucket); err != nil {
 		panic(err)
 	}
 
@@ -406,15 +402,15 @@ func NewStateGrowth2Reporter(ctx context.Context, remoteDB ethdb.KV, localDB *bo
 		HistoryKey:       []byte{},
 		StorageKey:       []byte{},
 		MaxTimestamp:     0,
-		lastTimestamps:   typedbucket.NewUint64(lastTimestamps),
-		creationsByBlock: typedbucket.NewInt(creationsByBlock),
+		lastTimestamps:   typedbucket.NewUint64(localTx.Bucket(LastTimestampsBucket)),
+		creationsByBlock: typedbucket.NewInt(localTx.Bucket(CreationsByBlockBucket)),
 	}
 	rep.commit = func(ctx context.Context) {
 		commit(ProgressKey, localTx, rep)
-		if err = localTx.Commit(); err != nil {
+		if err = localTx.Commit(ctx); err != nil {
 			panic(err)
 		}
-		if localTx, err = localDB.Begin(true); err != nil {
+		if localTx, err = localDB.Begin(ctx, true); err != nil {
 			panic(err)
 		}
 
@@ -618,18 +614,17 @@ type GasLimitReporter struct {
 	rollback func(ctx context.Context)
 }
 
-func NewGasLimitReporter(ctx context.Context, remoteDB ethdb.KV, localDB *bolt.DB) *GasLimitReporter {
+func NewGasLimitReporter(ctx context.Context, remoteDB ethdb.KV, localDB ethdb.KV) *GasLimitReporter {
 	var MainHashesBucket = []byte("gl_main_hashes")
 	var ProgressKey = []byte("gas_limit")
 
 	var err error
-	var localTx *bolt.Tx
-	var mainHashes *bolt.Bucket
+	var localTx ethdb.Tx
 
-	if localTx, err = localDB.Begin(true); err != nil {
+	if localTx, err = localDB.Begin(ctx, true); err != nil {
 		panic(err)
 	}
-	if mainHashes, err = localTx.CreateBucketIfNotExists(MainHashesBucket, false); err != nil {
+	if err = localTx.CreateBucket(MainHashesBucket); err != nil {
 		panic(err)
 	}
 
@@ -637,14 +632,14 @@ func NewGasLimitReporter(ctx context.Context, remoteDB ethdb.KV, localDB *bolt.D
 		remoteDB:         remoteDB,
 		HeaderPrefixKey1: []byte{},
 		HeaderPrefixKey2: []byte{},
-		mainHashes:       typedbucket.NewUint64(mainHashes),
+		mainHashes:       typedbucket.NewUint64(localTx.Bucket(MainHashesBucket)),
 	}
 	rep.commit = func(ctx context.Context) {
 		commit(ProgressKey, localTx, rep)
-		if err = localTx.Commit(); err != nil {
+		if err = localTx.Commit(ctx); err != nil {
 			panic(err)
 		}
-		if localTx, err = localDB.Begin(true); err != nil {
+		if localTx, err = localDB.Begin(ctx, true); err != nil {
 			panic(err)
 		}
 
@@ -730,7 +725,14 @@ beginTx:
 			}
 		}
 
-		fmt.Println("Preloaded: ", r.mainHashes.Stats().KeyN)
+		var preloaded int
+		if err := r.mainHashes.ForEach(func(_ []byte, _ uint64) error {
+			preloaded++
+			return nil
+		}); err != nil {
+			return err
+		}
+		fmt.Println("Preloaded: ", preloaded)
 		i = 0
 		for k, v, err := c.Seek(r.HeaderPrefixKey2); k != nil || err != nil; k, v, err = c.Next() {
 			if err != nil {
@@ -1955,7 +1957,8 @@ func makeSha3Preimages(blockNum uint64) {
 	//ethDb, err := ethdb.NewBoltDatabase("/Users/alexeyakhunov/Library/Ethereum/geth/chaindata")
 	check(err)
 	defer ethDb.Close()
-	f, err := bolt.Open("/Volumes/tb4/turbo-geth/sha3preimages", 0600, &bolt.Options{})
+	ctx := context.Background()
+	f, err := ethdb.NewBolt().Path("/Volumes/tb4/turbo-geth/sha3preimages").Open(ctx)
 	check(err)
 	defer f.Close()
 	bucket := []byte("sha3")
@@ -1964,14 +1967,14 @@ func makeSha3Preimages(blockNum uint64) {
 	bc, err := core.NewBlockChain(ethDb, nil, chainConfig, ethash.NewFaker(), vmConfig, nil, nil)
 	check(err)
 	interrupt := false
-	tx, err := f.Begin(true)
+	tx, err := f.Begin(ctx, true)
 	if err != nil {
 		panic(err)
 	}
-	b, err := tx.CreateBucketIfNotExists(bucket, false)
-	if err != nil {
+	if err = tx.CreateBucket(bucket); err != nil {
 		panic(err)
 	}
+	b := tx.Bucket(bucket)
 	for !interrupt {
 		block := bc.GetBlockByNumber(blockNum)
 		if block == nil {
@@ -2006,17 +2009,17 @@ func makeSha3Preimages(blockNum uint64) {
 		blockNum++
 		if blockNum%100 == 0 {
 			fmt.Printf("Processed %dK blocks\n", blockNum/1000)
-			if err := tx.Commit(); err != nil {
+			if err := tx.Commit(ctx); err != nil {
 				panic(err)
 			}
-			tx, err = f.Begin(true)
+			tx, err = f.Begin(ctx, true)
 			if err != nil {
 				panic(err)
 			}
-			b, err = tx.CreateBucketIfNotExists(bucket, false)
-			if err != nil {
+			if err = tx.CreateBucket(bucket); err != nil {
 				panic(err)
 			}
+			b = tx.Bucket(bucket)
 		}
 		// Check for interrupts
 		select {
@@ -2025,7 +2028,7 @@ func makeSha3Preimages(blockNum uint64) {
 		default:
 		}
 	}
-	if err := tx.Commit(); err != nil {
+	if err := tx.Commit(ctx); err != nil {
 		panic(err)
 	}
 	fmt.Printf("Next time specify -block %d\n", blockNum)
@@ -2,7 +2,6 @@ package stateless
 
 import (
 	"bytes"
-	"encoding/binary"
 	"fmt"
 	"os"
 	"time"
@@ -128,23 +127,16 @@ func (bw *bucketWriter) printStats() {
 
 func (bw *bucketWriter) walker(k, v []byte) (bool, error) {
 	if bw.pending == nil {
-		bw.pending = bw.db.NewBatch()
+		bw.pending = ethdb.NewBatchWithPolicy(bw.db, ethdb.CommitPolicy{
+			MaxBytes: 100000,
+			OnCommit: func(uint64) { bw.printStats() },
+		})
 	}
 
+	bw.written++
 	if err := bw.pending.Put(bw.bucket, common.CopyBytes(k), common.CopyBytes(v)); err != nil {
 		return false, err
 	}
-	bw.written++
-
-	if bw.pending.BatchSize() >= 100000 {
-		if _, err := bw.pending.Commit(); err != nil {
-			return false, err
-		}
-
-		bw.printStats()
-
-		bw.pending = bw.db.NewBatch()
-	}
 
 	return true, nil
 }
@@ -369,7 +361,7 @@ func checkRoots(stateDb ethdb.Database, rootHash common.Hash, blockNum uint64) {
 			sl := trie.NewSubTrieLoader(blockNum)
 			contractPrefix := make([]byte, common.HashLength+common.IncarnationLength)
 			copy(contractPrefix, addrHash[:])
-			binary.BigEndian.PutUint64(contractPrefix[common.HashLength:], ^account.Incarnation)
+			dbutils.EncodeIncarnation(contractPrefix[common.HashLength:], account.Incarnation)
 			rl := trie.NewRetainList(0)
 			subTries, err := sl.LoadSubTries(stateDb, blockNum, rl, [][]byte{contractPrefix}, []int{8 * len(contractPrefix)}, false)
 			if err != nil {
@@ -414,8 +406,8 @@ func StateSnapshot(blockNum uint64) error {
 	return nil
 }
 
-func VerifySnapshot(path string) {
-	ethDb, err := ethdb.NewBoltDatabase(path)
+func VerifySnapshot(path string, readOnly bool) {
+	ethDb, err := ethdb.OpenBoltDatabase(path, readOnly)
 	check(err)
 	defer ethDb.Close()
 	hash := rawdb.ReadHeadBlockHash(ethDb)
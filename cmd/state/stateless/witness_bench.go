@@ -0,0 +1,220 @@
+package stateless
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/consensus/ethash"
+	"github.com/ledgerwatch/turbo-geth/consensus/misc"
+	"github.com/ledgerwatch/turbo-geth/core"
+	"github.com/ledgerwatch/turbo-geth/core/state"
+	"github.com/ledgerwatch/turbo-geth/core/types"
+	"github.com/ledgerwatch/turbo-geth/core/vm"
+	"github.com/ledgerwatch/turbo-geth/params"
+	"github.com/ledgerwatch/turbo-geth/trie"
+)
+
+// WitnessBenchBlock is the witness of one block of the corpus
+type WitnessBenchBlock struct {
+	Block     uint64 `json:"block"`
+	Size      uint64 `json:"size"`      // bytes of the serialised witness
+	Hashes    uint64 `json:"hashes"`    // bytes taken by the hashes
+	Codes     uint64 `json:"codes"`     // bytes taken by the contract codes
+	Structure uint64 `json:"structure"` // bytes taken by the structure of the trie
+	TimeNs    int64  `json:"timeNs"`    // resolution of the trie, extraction and serialisation of the witness
+}
+
+// WitnessBenchSummary aggregates the witnesses of the corpus
+type WitnessBenchSummary struct {
+	Blocks      int    `json:"blocks"`
+	TotalSize   uint64 `json:"totalSize"`
+	SizeP50     uint64 `json:"sizeP50"`
+	SizeP90     uint64 `json:"sizeP90"`
+	SizeP99     uint64 `json:"sizeP99"`
+	SizeMax     uint64 `json:"sizeMax"`
+	TotalTimeNs int64  `json:"totalTimeNs"`
+	TimeP50Ns   int64  `json:"timeP50Ns"`
+	TimeP90Ns   int64  `json:"timeP90Ns"`
+	TimeP99Ns   int64  `json:"timeP99Ns"`
+	TimeMaxNs   int64  `json:"timeMaxNs"`
+}
+
+// WitnessBenchReport is the machine-readable output of WitnessBench, to be compared between the revisions
+type WitnessBenchReport struct {
+	PreRoot common.Hash         `json:"preRoot"`
+	Summary WitnessBenchSummary `json:"summary"`
+	Blocks  []WitnessBenchBlock `json:"blocks"`
+}
+
+// WitnessBench executes the consecutive blocks from the RLP file (as written by `geth export`) on top of the state
+// snapshot (statefile must contain the state after the parent of the first block, it is not modified),
+// generates the witness of every block and writes the sizes and the generation times as JSON into output
+// (stdout if empty). It is meant to catch the regressions in HashBuilder and RetainDecider on a fixed corpus
+func WitnessBench(ctx context.Context, blocksFile string, statefile string, output string, createDb CreateDbFunc) error {
+	blockProvider, err := NewBlockProviderFromExportFile(blocksFile)
+	if err != nil {
+		return err
+	}
+	defer blockProvider.Close()
+
+	stateDb, err := createDb(statefile)
+	if err != nil {
+		return err
+	}
+	defer stateDb.Close()
+
+	chainConfig := params.MainnetChainConfig
+	vmConfig := vm.Config{}
+	engine := ethash.NewFullFaker()
+
+	batch := stateDb.NewBatch()
+	defer batch.Rollback()
+
+	var report WitnessBenchReport
+	var tds *state.TrieDbState
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+		block, err1 := blockProvider.NextBlock()
+		if err1 != nil {
+			return err1
+		}
+		if block == nil {
+			break
+		}
+		blockNum := block.NumberU64()
+		if tds == nil {
+			if blockNum < 1 {
+				return fmt.Errorf("the corpus cannot start with the genesis block")
+			}
+			// The root of the snapshot, the header of the parent is not in the corpus
+			loader := trie.NewSubTrieLoader(blockNum - 1)
+			subTries, err2 := loader.LoadFromFlatDB(stateDb, trie.NewRetainList(0), [][]byte{nil}, []int{0}, false)
+			if err2 != nil {
+				return err2
+			}
+			report.PreRoot = subTries.Hashes[0]
+			tds = state.NewTrieDbState(report.PreRoot, batch, blockNum-1)
+			tds.SetResolveReads(true)
+			tds.SetNoHistory(true)
+		} else if blockNum != tds.GetBlockNr()+1 {
+			return fmt.Errorf("the corpus is not consecutive: block %d follows block %d", blockNum, tds.GetBlockNr())
+		}
+
+		statedb := state.New(tds)
+		gp := new(core.GasPool).AddGas(block.GasLimit())
+		usedGas := new(uint64)
+		header := block.Header()
+		tds.StartNewBuffer()
+		var receipts types.Receipts
+		if chainConfig.DAOForkSupport && chainConfig.DAOForkBlock != nil && chainConfig.DAOForkBlock.Cmp(block.Number()) == 0 {
+			misc.ApplyDAOHardFork(statedb)
+		}
+		for i, tx := range block.Transactions() {
+			statedb.Prepare(tx.Hash(), block.Hash(), i)
+			receipt, err2 := core.ApplyTransaction(chainConfig, blockProvider, nil, gp, statedb, tds.TrieStateWriter(), header, tx, usedGas, vmConfig)
+			if err2 != nil {
+				return fmt.Errorf("tx %x failed: %w", tx.Hash(), err2)
+			}
+			if !chainConfig.IsByzantium(header.Number) {
+				tds.StartNewBuffer()
+			}
+			receipts = append(receipts, receipt)
+		}
+		if _, err = engine.FinalizeAndAssemble(chainConfig, header, statedb, block.Transactions(), block.Uncles(), receipts); err != nil {
+			return fmt.Errorf("finalize of block %d failed: %w", blockNum, err)
+		}
+		blockCtx := chainConfig.WithEIPsFlags(ctx, header.Number)
+		if err = statedb.FinalizeTx(blockCtx, tds.TrieStateWriter()); err != nil {
+			return fmt.Errorf("finalizeTx of block %d failed: %w", blockNum, err)
+		}
+
+		start := time.Now()
+		if _, err = tds.ResolveStateTrie(false, false); err != nil {
+			return err
+		}
+		bw, err1 := tds.ExtractWitness(false, false /* is binary */)
+		if err1 != nil {
+			return fmt.Errorf("extracting witness for block %d failed: %w", blockNum, err1)
+		}
+		var buf bytes.Buffer
+		stats, err1 := bw.WriteTo(&buf)
+		if err1 != nil {
+			return fmt.Errorf("serialising witness for block %d failed: %w", blockNum, err1)
+		}
+		report.Blocks = append(report.Blocks, WitnessBenchBlock{
+			Block:     blockNum,
+			Size:      stats.BlockWitnessSize(),
+			Hashes:    stats.HashesSize(),
+			Codes:     stats.CodesSize(),
+			Structure: stats.StructureSize(),
+			TimeNs:    time.Since(start).Nanoseconds(),
+		})
+
+		roots, err1 := tds.UpdateStateTrie()
+		if err1 != nil {
+			return err1
+		}
+		if root := roots[len(roots)-1]; root != block.Root() {
+			return fmt.Errorf("root hash does not match for block %d, expected %x, was %x", blockNum, block.Root(), root)
+		}
+		tds.SetBlockNr(blockNum)
+		if err = statedb.CommitBlock(blockCtx, tds.DbStateWriter()); err != nil {
+			return fmt.Errorf("commiting block %d failed: %w", blockNum, err)
+		}
+	}
+	if len(report.Blocks) == 0 {
+		return fmt.Errorf("no blocks in %s", blocksFile)
+	}
+	report.Summary = summariseWitnessBench(report.Blocks)
+
+	var w io.Writer = os.Stdout
+	if output != "" {
+		f, err1 := os.Create(output)
+		if err1 != nil {
+			return err1
+		}
+		defer f.Close()
+		w = f
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(&report)
+}
+
+func summariseWitnessBench(blocks []WitnessBenchBlock) WitnessBenchSummary {
+	s := WitnessBenchSummary{Blocks: len(blocks)}
+	sizes := make([]uint64, len(blocks))
+	times := make([]int64, len(blocks))
+	for i, b := range blocks {
+		sizes[i] = b.Size
+		times[i] = b.TimeNs
+		s.TotalSize += b.Size
+		s.TotalTimeNs += b.TimeNs
+	}
+	sort.Slice(sizes, func(i, j int) bool { return sizes[i] < sizes[j] })
+	sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+	s.SizeP50, s.SizeP90, s.SizeP99 = sizes[percentileIndex(len(sizes), 50)], sizes[percentileIndex(len(sizes), 90)], sizes[percentileIndex(len(sizes), 99)]
+	s.TimeP50Ns, s.TimeP90Ns, s.TimeP99Ns = times[percentileIndex(len(times), 50)], times[percentileIndex(len(times), 90)], times[percentileIndex(len(times), 99)]
+	s.SizeMax, s.TimeMaxNs = sizes[len(sizes)-1], times[len(times)-1]
+	return s
+}
+
+// percentileIndex is the index of the p-th percentile in the sorted slice of n elements (nearest-rank method)
+func percentileIndex(n int, p int) int {
+	rank := (p*n + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return rank - 1
+}
@@ -0,0 +1,28 @@
+package stateless
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSummariseWitnessBench(t *testing.T) {
+	var blocks []WitnessBenchBlock
+	for i := 100; i >= 1; i-- {
+		blocks = append(blocks, WitnessBenchBlock{Block: uint64(i), Size: uint64(i * 10), TimeNs: int64(i)})
+	}
+	s := summariseWitnessBench(blocks)
+	assert.Equal(t, 100, s.Blocks)
+	assert.Equal(t, uint64(50500), s.TotalSize)
+	assert.Equal(t, uint64(500), s.SizeP50)
+	assert.Equal(t, uint64(900), s.SizeP90)
+	assert.Equal(t, uint64(990), s.SizeP99)
+	assert.Equal(t, uint64(1000), s.SizeMax)
+	assert.Equal(t, int64(5050), s.TotalTimeNs)
+	assert.Equal(t, int64(50), s.TimeP50Ns)
+	assert.Equal(t, int64(100), s.TimeMaxNs)
+
+	s = summariseWitnessBench(blocks[:1])
+	assert.Equal(t, uint64(1000), s.SizeP50)
+	assert.Equal(t, uint64(1000), s.SizeP99)
+}
@@ -0,0 +1,122 @@
+package stateless
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/consensus/ethash"
+	"github.com/ledgerwatch/turbo-geth/consensus/misc"
+	"github.com/ledgerwatch/turbo-geth/core"
+	"github.com/ledgerwatch/turbo-geth/core/state"
+	"github.com/ledgerwatch/turbo-geth/core/types"
+	"github.com/ledgerwatch/turbo-geth/core/vm"
+	"github.com/ledgerwatch/turbo-geth/params"
+	"github.com/ledgerwatch/turbo-geth/trie"
+)
+
+// WitnessSize executes a single block on top of the state snapshot (statefile must contain the state after blockNum-1),
+// extracts the block witness and prints which accounts and key prefixes contribute most to its size.
+// The snapshot itself is not modified.
+func WitnessSize(ctx context.Context, blockNum uint64, blockSourceURI string, statefile string, prefixLen int, top int, createDb CreateDbFunc) error {
+	if blockNum < 1 {
+		return fmt.Errorf("block number must be at least 1")
+	}
+	blockProvider, err := BlockProviderForURI(blockSourceURI, createDb)
+	if err != nil {
+		return err
+	}
+	defer blockProvider.Close()
+
+	stateDb, err := createDb(statefile)
+	if err != nil {
+		return err
+	}
+	defer stateDb.Close()
+
+	var preRoot common.Hash
+	if blockNum == 1 {
+		genesisBlock, _, _, err1 := core.DefaultGenesisBlock().ToBlock(nil, false)
+		if err1 != nil {
+			return err1
+		}
+		preRoot = genesisBlock.Header().Root
+	} else {
+		if err = blockProvider.FastFwd(blockNum - 1); err != nil {
+			return err
+		}
+		parent, err1 := blockProvider.NextBlock()
+		if err1 != nil {
+			return err1
+		}
+		if parent == nil {
+			return fmt.Errorf("block %d not found", blockNum-1)
+		}
+		preRoot = parent.Root()
+	}
+
+	if err = blockProvider.FastFwd(blockNum); err != nil {
+		return err
+	}
+	block, err := blockProvider.NextBlock()
+	if err != nil {
+		return err
+	}
+	if block == nil {
+		return fmt.Errorf("block %d not found", blockNum)
+	}
+
+	chainConfig := params.MainnetChainConfig
+	vmConfig := vm.Config{}
+	engine := ethash.NewFullFaker()
+
+	batch := stateDb.NewBatch()
+	defer batch.Rollback()
+	tds := state.NewTrieDbState(preRoot, batch, blockNum-1)
+	tds.SetResolveReads(true)
+	tds.SetNoHistory(true)
+
+	statedb := state.New(tds)
+	gp := new(core.GasPool).AddGas(block.GasLimit())
+	usedGas := new(uint64)
+	header := block.Header()
+	tds.StartNewBuffer()
+	var receipts types.Receipts
+	if chainConfig.DAOForkSupport && chainConfig.DAOForkBlock != nil && chainConfig.DAOForkBlock.Cmp(block.Number()) == 0 {
+		misc.ApplyDAOHardFork(statedb)
+	}
+	for i, tx := range block.Transactions() {
+		statedb.Prepare(tx.Hash(), block.Hash(), i)
+		receipt, err1 := core.ApplyTransaction(chainConfig, blockProvider, nil, gp, statedb, tds.TrieStateWriter(), header, tx, usedGas, vmConfig)
+		if err1 != nil {
+			return fmt.Errorf("tx %x failed: %w", tx.Hash(), err1)
+		}
+		if !chainConfig.IsByzantium(header.Number) {
+			tds.StartNewBuffer()
+		}
+		receipts = append(receipts, receipt)
+	}
+	if _, err = engine.FinalizeAndAssemble(chainConfig, header, statedb, block.Transactions(), block.Uncles(), receipts); err != nil {
+		return fmt.Errorf("finalize of block %d failed: %w", blockNum, err)
+	}
+	if err = statedb.FinalizeTx(chainConfig.WithEIPsFlags(ctx, header.Number), tds.TrieStateWriter()); err != nil {
+		return fmt.Errorf("finalizeTx of block %d failed: %w", blockNum, err)
+	}
+	if _, err = tds.ResolveStateTrie(false, false); err != nil {
+		return err
+	}
+
+	tracer := trie.NewWitnessSizeTracer(prefixLen)
+	if _, err = tds.ExtractWitnessWithSizeTracer(tracer); err != nil {
+		return err
+	}
+
+	fmt.Printf("Block %d, pre-state root %x\n", blockNum, preRoot)
+	return tracer.WriteTo(os.Stdout, top, func(addrHash []byte) string {
+		if addr := tds.GetKey(addrHash); len(addr) == common.AddressLength {
+			return common.BytesToAddress(addr).Hex()
+		}
+		return ""
+	})
+}
@@ -0,0 +1,74 @@
+package stats
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/ledgerwatch/turbo-geth/core/rawdb"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+)
+
+// ChangeSetStats writes the number of the account and storage changes and the size of the changesets per block
+// in the range [from, to] (to = 0 - up to the head block) into the csv file, for the "changes per block" charts.
+// The summaries of the changesets are used (see ethdb.ChangeSetSummary), the changesets are only decoded
+// for the blocks written before the summaries
+func ChangeSetStats(chaindata string, from, to uint64, statsFile string, readOnly bool) error {
+	db, err := ethdb.OpenBoltDatabase(chaindata, readOnly)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if to == 0 {
+		head := rawdb.ReadHeadBlockHash(db)
+		number := rawdb.ReadHeaderNumber(db, head)
+		if number == nil {
+			return fmt.Errorf("head block %x not found", head)
+		}
+		to = *number
+	}
+
+	f, err := os.Create(statsFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := csv.NewWriter(f)
+	if err = w.Write([]string{"block", "accounts", "storage", "bytes"}); err != nil {
+		return err
+	}
+	startTime := time.Now()
+	var blocks, accounts, storage, bytes uint64
+	for blockNr := from; blockNr <= to; blockNr++ {
+		if blockNr%100_000 == 0 {
+			fmt.Printf("Processed %dK blocks, %s\n", blockNr/1000, time.Since(startTime))
+		}
+		s, ok, err := ethdb.ReadChangeSetSummary(db, blockNr)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		blocks++
+		accounts += uint64(s.AccountChanges)
+		storage += uint64(s.StorageChanges)
+		bytes += s.Bytes()
+		if err = w.Write([]string{
+			strconv.FormatUint(blockNr, 10),
+			strconv.FormatUint(uint64(s.AccountChanges), 10),
+			strconv.FormatUint(uint64(s.StorageChanges), 10),
+			strconv.FormatUint(s.Bytes(), 10),
+		}); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	if err = w.Error(); err != nil {
+		return err
+	}
+	fmt.Printf("Blocks with changesets: %d, account changes: %d, storage changes: %d, bytes: %d\n", blocks, accounts, storage, bytes)
+	return nil
+}
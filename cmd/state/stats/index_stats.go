@@ -14,8 +14,8 @@ import (
 	"time"
 )
 
-func IndexStats(chaindata string, indexBucket []byte, statsFile string) error {
-	db, err := ethdb.NewBoltDatabase(chaindata)
+func IndexStats(chaindata string, indexBucket []byte, statsFile string, readOnly bool) error {
+	db, err := ethdb.OpenBoltDatabase(chaindata, readOnly)
 	if err != nil {
 		return err
 	}
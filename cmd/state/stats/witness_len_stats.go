@@ -0,0 +1,240 @@
+package stats
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+)
+
+// WitnessLenDepth aggregates the entries of dbutils.IntermediateTrieWitnessLenBucket at one depth of the trie
+type WitnessLenDepth struct {
+	Depth    int // nibbles, the storage prefixes are counted from the root of the account trie
+	Prefixes uint64
+	Total    uint64
+	Max      uint64
+}
+
+// WitnessSliceBoundary is the sub-trie, within or after which the cumulative witness size of the state
+// reaches a multiple of the bytes per witness
+type WitnessSliceBoundary struct {
+	Prefix []byte // key of dbutils.IntermediateTrieWitnessLenBucket
+	Depth  int
+	Offset uint64 // cumulative witness size before the sub-trie
+	Size   uint64 // witness size of the sub-trie
+	Inside bool   // the boundary falls within the sub-trie, which has no deeper prefixes to split it at
+}
+
+// WitnessLenStats is the distribution of the witness sizes over the prefixes of the state trie.
+// The sizes of the sub-tries, which have no entries (they are only kept for the branch nodes, see
+// debug.IsTrackWitnessSizeEnabled), are not accounted, so the state witness size is the lower bound
+type WitnessLenStats struct {
+	Depths           []WitnessLenDepth
+	StateWitnessSize uint64 // sum over the top prefixes, the ones not under any other prefix
+	BytesPerWitness  uint64
+	Boundaries       []WitnessSliceBoundary
+}
+
+// CollectWitnessLenStats builds the histogram of the witness sizes by depth and finds the prefixes, at which
+// the state is sliced into the witnesses of bytesPerWitness: the walk takes the sub-trie as a whole while it fits
+// into the current slice and descends into it otherwise, so the boundaries fall at the deepest prefixes available
+func CollectWitnessLenStats(ctx context.Context, db ethdb.KV, bytesPerWitness uint64) (*WitnessLenStats, error) {
+	if bytesPerWitness == 0 {
+		return nil, fmt.Errorf("bytesPerWitness must be positive")
+	}
+	s := &WitnessLenStats{BytesPerWitness: bytesPerWitness}
+	if err := db.View(ctx, func(tx ethdb.Tx) error {
+		if err := s.collectDepths(tx.Bucket(dbutils.IntermediateTrieWitnessLenBucket).Cursor()); err != nil {
+			return err
+		}
+		return s.collectBoundaries(tx.Bucket(dbutils.IntermediateTrieWitnessLenBucket).Cursor())
+	}); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *WitnessLenStats) collectDepths(c ethdb.Cursor) error {
+	depths := make(map[int]*WitnessLenDepth)
+	var top []byte
+	for k, v, err := c.First(); k != nil || err != nil; k, v, err = c.Next() {
+		if err != nil {
+			return err
+		}
+		size, ok := witnessLen(v)
+		if !ok {
+			continue
+		}
+		d := witnessLenDepth(k)
+		if depths[d] == nil {
+			depths[d] = &WitnessLenDepth{Depth: d}
+		}
+		depths[d].Prefixes++
+		depths[d].Total += size
+		if size > depths[d].Max {
+			depths[d].Max = size
+		}
+		if top == nil || !bytes.HasPrefix(k, top) {
+			top = common.CopyBytes(k)
+			s.StateWitnessSize += size
+		}
+	}
+	for _, d := range depths {
+		s.Depths = append(s.Depths, *d)
+	}
+	sort.Slice(s.Depths, func(i, j int) bool { return s.Depths[i].Depth < s.Depths[j].Depth })
+	return nil
+}
+
+func (s *WitnessLenStats) collectBoundaries(c ethdb.Cursor) error {
+	var offset uint64
+	next := s.BytesPerWitness
+	k, v, err := c.First()
+	for k != nil || err != nil {
+		if err != nil {
+			return err
+		}
+		size, ok := witnessLen(v)
+		if !ok {
+			k, v, err = c.Next()
+			continue
+		}
+		prefix := common.CopyBytes(k)
+		k, v, err = c.Next()
+		if offset+size > next && k != nil && bytes.HasPrefix(k, prefix) {
+			// Does not fit, the slice continues inside the sub-trie
+			continue
+		}
+		if offset+size >= next {
+			s.Boundaries = append(s.Boundaries, WitnessSliceBoundary{
+				Prefix: prefix,
+				Depth:  witnessLenDepth(prefix),
+				Offset: offset,
+				Size:   size,
+				Inside: offset+size > next,
+			})
+			for next <= offset+size {
+				next += s.BytesPerWitness
+			}
+		}
+		offset += size
+		// Skip the sub-trie taken as a whole
+		if k != nil && bytes.HasPrefix(k, prefix) {
+			after, ok := nextPrefix(prefix)
+			if !ok {
+				break
+			}
+			k, v, err = c.Seek(after)
+		}
+	}
+	return nil
+}
+
+// Recommend returns the MGR parameters (see eth/mgr), with which one tick of the cycle covers one witness:
+// ticks per cycle for the bytes per witness of the stats, and bytes per witness for ticksPerCycle. The bytes per
+// witness are not below the largest sub-trie, within which the boundaries fall
+func (s *WitnessLenStats) Recommend(ticksPerCycle uint64) (recTicksPerCycle, recBytesPerWitness uint64) {
+	recTicksPerCycle = 1
+	for recTicksPerCycle*s.BytesPerWitness < s.StateWitnessSize {
+		recTicksPerCycle <<= 1
+	}
+	recBytesPerWitness = (s.StateWitnessSize + ticksPerCycle - 1) / ticksPerCycle
+	for _, b := range s.Boundaries {
+		if b.Inside && b.Size > recBytesPerWitness {
+			recBytesPerWitness = b.Size
+		}
+	}
+	return recTicksPerCycle, recBytesPerWitness
+}
+
+// Print writes the histogram, the boundaries of the slices and the recommended MGR parameters
+func (s *WitnessLenStats) Print(w io.Writer, ticksPerCycle uint64) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprint(tw, "depth\tprefixes\ttotal\tmax\tavg\t\n")
+	for _, d := range s.Depths {
+		fmt.Fprintf(tw, "%d\t%d\t%d\t%d\t%d\t\n", d.Depth, d.Prefixes, d.Total, d.Max, d.Total/d.Prefixes)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "State witness size: %d bytes\n", s.StateWitnessSize)
+	if len(s.Boundaries) == 0 {
+		fmt.Fprintf(w, "The state fits into one witness of %d bytes\n", s.BytesPerWitness)
+	} else {
+		depths := make([]int, len(s.Boundaries))
+		var inside int
+		var largest uint64
+		for i, b := range s.Boundaries {
+			depths[i] = b.Depth
+			if b.Inside {
+				inside++
+				if b.Size > largest {
+					largest = b.Size
+				}
+			}
+		}
+		sort.Ints(depths)
+		fmt.Fprintf(w, "Boundaries of the witnesses of %d bytes: %d, depth min %d, median %d, max %d\n",
+			s.BytesPerWitness, len(s.Boundaries), depths[0], depths[len(depths)/2], depths[len(depths)-1])
+		fmt.Fprintf(w, "Boundaries inside the indivisible sub-tries: %d, the largest is %d bytes\n", inside, largest)
+	}
+	recTicks, recBytes := s.Recommend(ticksPerCycle)
+	fmt.Fprintf(w, "Recommended: TicksPerCycle=%d for BytesPerWitness=%d, BytesPerWitness=%d for TicksPerCycle=%d\n",
+		recTicks, s.BytesPerWitness, recBytes, ticksPerCycle)
+	return nil
+}
+
+// WitnessLenStatsReport prints the stats of the witness sizes of the database, it must have been built with the
+// witness sizes tracked (--trie-witness-len)
+func WitnessLenStatsReport(ctx context.Context, chaindata string, bytesPerWitness, ticksPerCycle uint64, w io.Writer) error {
+	if ticksPerCycle == 0 {
+		return fmt.Errorf("ticksPerCycle must be positive")
+	}
+	db, err := ethdb.OpenBoltDatabase(chaindata, true)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	s, err := CollectWitnessLenStats(ctx, db.AbstractKV(), bytesPerWitness)
+	if err != nil {
+		return err
+	}
+	if len(s.Depths) == 0 {
+		return fmt.Errorf("no witness sizes in %s, the database must be built with --trie-witness-len", dbutils.IntermediateTrieWitnessLenBucket)
+	}
+	return s.Print(w, ticksPerCycle)
+}
+
+func witnessLen(v []byte) (uint64, bool) {
+	if len(v) != 8 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint64(v), true
+}
+
+// witnessLenDepth returns the depth in nibbles of the key, the incarnation of the storage prefixes is not counted
+func witnessLenDepth(k []byte) int {
+	if len(k) >= common.HashLength+common.IncarnationLength {
+		return 2 * (len(k) - common.IncarnationLength)
+	}
+	return 2 * len(k)
+}
+
+// nextPrefix returns the first key after all the keys starting with the prefix, false if there is none
+func nextPrefix(prefix []byte) ([]byte, bool) {
+	next := common.CopyBytes(prefix)
+	for i := len(next) - 1; i >= 0; i-- {
+		if next[i] != 255 {
+			next[i]++
+			return next[:i+1], true
+		}
+	}
+	return nil, false
+}
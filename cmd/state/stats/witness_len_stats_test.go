@@ -0,0 +1,50 @@
+package stats
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+)
+
+func TestWitnessLenStats(t *testing.T) {
+	db := ethdb.NewMemDatabase()
+	defer db.Close()
+	for k, size := range map[string]uint64{
+		"\x01":     150,
+		"\x01\x10": 60,
+		"\x01\x20": 80,
+		"\x02":     30,
+		"\x03":     250,
+	} {
+		v := make([]byte, 8)
+		binary.BigEndian.PutUint64(v, size)
+		require.NoError(t, db.Put(dbutils.IntermediateTrieWitnessLenBucket, []byte(k), v))
+	}
+
+	s, err := CollectWitnessLenStats(context.Background(), db.AbstractKV(), 100)
+	require.NoError(t, err)
+	assert.Equal(t, []WitnessLenDepth{
+		{Depth: 2, Prefixes: 3, Total: 430, Max: 250},
+		{Depth: 4, Prefixes: 2, Total: 140, Max: 80},
+	}, s.Depths)
+	assert.Equal(t, uint64(430), s.StateWitnessSize)
+	assert.Equal(t, []WitnessSliceBoundary{
+		{Prefix: []byte{0x01, 0x20}, Depth: 4, Offset: 60, Size: 80, Inside: true},
+		{Prefix: []byte{0x03}, Depth: 2, Offset: 170, Size: 250, Inside: true},
+	}, s.Boundaries)
+
+	ticks, bytesPerWitness := s.Recommend(4)
+	assert.Equal(t, uint64(8), ticks)
+	assert.Equal(t, uint64(250), bytesPerWitness)
+
+	var out bytes.Buffer
+	require.NoError(t, s.Print(&out, 4))
+	assert.Contains(t, out.String(), "Recommended: TicksPerCycle=8 for BytesPerWitness=100, BytesPerWitness=250 for TicksPerCycle=4")
+}
@@ -20,8 +20,8 @@ type Walker interface {
 	Find(k []byte) ([]byte, error)
 }
 
-func CheckEnc(chaindata string) error {
-	db, err := ethdb.NewBoltDatabase(chaindata)
+func CheckEnc(chaindata string, readOnly bool) error {
+	db, err := ethdb.OpenBoltDatabase(chaindata, readOnly)
 	if err != nil {
 		return err
 	}
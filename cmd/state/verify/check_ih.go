@@ -0,0 +1,120 @@
+package verify
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+	"github.com/ledgerwatch/turbo-geth/trie"
+)
+
+// BadIntermediateHash is the entry of dbutils.IntermediateTrieHashBucket, which does not match the state
+type BadIntermediateHash struct {
+	Key      []byte
+	Stored   common.Hash
+	Computed common.Hash // trie.EmptyRoot if there is no state under the prefix (dangling entry)
+}
+
+// Dangling tells whether there is no state under the prefix of the entry
+func (b BadIntermediateHash) Dangling() bool {
+	return b.Computed == trie.EmptyRoot
+}
+
+// IntermediateHashesReport is the result of CheckIntermediateHashesOf
+type IntermediateHashesReport struct {
+	Entries int // All entries in dbutils.IntermediateTrieHashBucket
+	Checked int // Entries, the hashes of which were recomputed
+	Bad     []BadIntermediateHash
+	Deleted int
+}
+
+// CheckIntermediateHashes verifies the intermediate trie hashes against the hashes recomputed from the current state.
+// Only every sample-th entry is checked if sample > 1. With fix, the bad entries are deleted,
+// the deleted hashes are recomputed from the state when needed
+func CheckIntermediateHashes(chaindata string, sample int, fix bool) error {
+	db, err := ethdb.OpenBoltDatabase(chaindata, !fix)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	startTime := time.Now()
+	report, err := CheckIntermediateHashesOf(db, sample, fix)
+	if err != nil {
+		return err
+	}
+	for _, b := range report.Bad {
+		if b.Dangling() {
+			fmt.Printf("Dangling intermediate hash: prefix %x, hash %x\n", b.Key, b.Stored)
+		} else {
+			fmt.Printf("Wrong intermediate hash: prefix %x, stored %x, computed %x\n", b.Key, b.Stored, b.Computed)
+		}
+	}
+	fmt.Printf("Checked %d of %d intermediate hashes in %s, %d bad, %d deleted\n",
+		report.Checked, report.Entries, time.Since(startTime), len(report.Bad), report.Deleted)
+	if len(report.Bad) > report.Deleted {
+		return fmt.Errorf("found %d bad intermediate hashes", len(report.Bad))
+	}
+	fmt.Println("Check was succesful")
+	return nil
+}
+
+// CheckIntermediateHashesOf walks dbutils.IntermediateTrieHashBucket and recomputes the hash of every sample-th entry
+// from the range of the state under its prefix (without the use of the intermediate hashes). The entries, which
+// differ from the recomputed hashes or have no state under their prefixes, are reported, and deleted with fix
+// (together with their witness lengths). The recomputation reads the whole range, so the entries of the short
+// prefixes are expensive to check
+func CheckIntermediateHashesOf(db ethdb.Database, sample int, fix bool) (*IntermediateHashesReport, error) {
+	if sample < 1 {
+		sample = 1
+	}
+	report := &IntermediateHashesReport{}
+	loader := trie.NewFlatDbSubTrieLoader()
+	receiver := trie.NewDefaultReceiver()
+	if err := db.Walk(dbutils.IntermediateTrieHashBucket, nil, 0, func(k, v []byte) (bool, error) {
+		report.Entries++
+		if (report.Entries-1)%sample != 0 {
+			return true, nil
+		}
+		report.Checked++
+		// Retaining everything makes the loader skip the intermediate hashes
+		if err := loader.Reset(db, trie.NewRetainRange(nil, nil), [][]byte{k}, []int{8 * len(k)}, false); err != nil {
+			return false, err
+		}
+		receiver.Reset(trie.NewRetainList(0), false)
+		loader.SetStreamReceiver(receiver)
+		subTries, err := loader.LoadSubTries()
+		if err != nil {
+			return false, fmt.Errorf("loading the state under %x: %w", k, err)
+		}
+		computed := trie.EmptyRoot
+		if len(subTries.Hashes) > 0 && subTries.Hashes[0] != (common.Hash{}) {
+			computed = subTries.Hashes[0]
+		}
+		if stored := common.BytesToHash(v); stored != computed {
+			report.Bad = append(report.Bad, BadIntermediateHash{Key: common.CopyBytes(k), Stored: stored, Computed: computed})
+		}
+		return true, nil
+	}); err != nil {
+		return nil, err
+	}
+	if !fix || len(report.Bad) == 0 {
+		return report, nil
+	}
+	batch := db.NewBatch()
+	defer batch.Rollback()
+	for _, b := range report.Bad {
+		if err := batch.Delete(dbutils.IntermediateTrieHashBucket, b.Key); err != nil {
+			return nil, err
+		}
+		if err := batch.Delete(dbutils.IntermediateTrieWitnessLenBucket, b.Key); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := batch.Commit(); err != nil {
+		return nil, err
+	}
+	report.Deleted = len(report.Bad)
+	return report, nil
+}
@@ -0,0 +1,141 @@
+package verify
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+	"github.com/ledgerwatch/turbo-geth/core/types/accounts"
+	"github.com/ledgerwatch/turbo-geth/crypto"
+	"github.com/ledgerwatch/turbo-geth/eth/downloader"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+	"github.com/ledgerwatch/turbo-geth/trie"
+)
+
+// generateStateWithIntermediateHashes writes the accounts (every tenth with storage) and the intermediate hashes
+// of their trie, returns the state root
+func generateStateWithIntermediateHashes(t *testing.T, db ethdb.Database, datadir string) common.Hash {
+	for i := 0; i < 200; i++ {
+		addrHash := crypto.Keccak256Hash([]byte(fmt.Sprintf("account-%d", i)))
+		acc := accounts.NewAccount()
+		acc.Initialised = true
+		acc.Balance.SetUint64(uint64(i + 1))
+		if i%10 == 0 {
+			acc.Incarnation = 1
+			for j := 0; j < 30; j++ {
+				loc := crypto.Keccak256Hash([]byte(fmt.Sprintf("location-%d", j)))
+				require.NoError(t, db.Put(dbutils.CurrentStateBucket, dbutils.GenerateCompositeStorageKey(addrHash, acc.Incarnation, loc), []byte{byte(j + 1)}))
+			}
+		}
+		value := make([]byte, acc.EncodingLengthForStorage())
+		acc.EncodeForStorage(value)
+		require.NoError(t, db.Put(dbutils.CurrentStateBucket, addrHash[:], value))
+	}
+	root := loadRoot(t, db)
+	require.NoError(t, downloader.RegenerateIntermediateHashes(db, datadir, 0, root))
+	return root
+}
+
+func loadRoot(t *testing.T, db ethdb.Database) common.Hash {
+	loader := trie.NewFlatDbSubTrieLoader()
+	require.NoError(t, loader.Reset(db, trie.NewRetainList(0), [][]byte{nil}, []int{0}, false))
+	subTries, err := loader.LoadSubTries()
+	require.NoError(t, err)
+	return subTries.Hashes[0]
+}
+
+// corruptIntermediateHashes changes the hash of the first account entry and adds the entry without the state under it,
+// returns their keys
+func corruptIntermediateHashes(t *testing.T, db ethdb.Database) (wrong []byte, dangling []byte) {
+	require.NoError(t, db.Walk(dbutils.IntermediateTrieHashBucket, nil, 0, func(k, _ []byte) (bool, error) {
+		if len(k) < common.HashLength {
+			wrong = common.CopyBytes(k)
+			return false, nil
+		}
+		return true, nil
+	}))
+	require.NotNil(t, wrong)
+	require.NoError(t, db.Put(dbutils.IntermediateTrieHashBucket, wrong, common.Hash{1}.Bytes()))
+	dangling = []byte{0xff, 0xff, 0xff, 0xff}
+	require.NoError(t, db.Put(dbutils.IntermediateTrieHashBucket, dangling, common.Hash{2}.Bytes()))
+	return wrong, dangling
+}
+
+func TestCheckIntermediateHashesOf(t *testing.T) {
+	datadir, err := ioutil.TempDir("", "check-ih")
+	require.NoError(t, err)
+	defer os.RemoveAll(datadir)
+	db := ethdb.NewMemDatabase()
+	defer db.Close()
+	root := generateStateWithIntermediateHashes(t, db, datadir)
+
+	report, err := CheckIntermediateHashesOf(db, 1, false)
+	require.NoError(t, err)
+	assert.True(t, report.Entries > 0)
+	assert.Equal(t, report.Entries, report.Checked)
+	assert.Empty(t, report.Bad)
+
+	sampled, err := CheckIntermediateHashesOf(db, 3, false)
+	require.NoError(t, err)
+	assert.Equal(t, report.Entries, sampled.Entries)
+	assert.Equal(t, (report.Entries+2)/3, sampled.Checked)
+
+	wrong, dangling := corruptIntermediateHashes(t, db)
+	report, err = CheckIntermediateHashesOf(db, 1, false)
+	require.NoError(t, err)
+	require.Len(t, report.Bad, 2)
+	assert.Equal(t, 0, report.Deleted)
+	for _, b := range report.Bad {
+		switch {
+		case assert.ObjectsAreEqual(wrong, b.Key):
+			assert.False(t, b.Dangling())
+			assert.Equal(t, common.Hash{1}, b.Stored)
+		case assert.ObjectsAreEqual(dangling, b.Key):
+			assert.True(t, b.Dangling())
+			assert.Equal(t, common.Hash{2}, b.Stored)
+		default:
+			t.Errorf("unexpected bad entry %x", b.Key)
+		}
+	}
+	// The loader uses the wrong hash
+	assert.NotEqual(t, root, loadRoot(t, db))
+
+	report, err = CheckIntermediateHashesOf(db, 1, true)
+	require.NoError(t, err)
+	assert.Len(t, report.Bad, 2)
+	assert.Equal(t, 2, report.Deleted)
+	for _, k := range [][]byte{wrong, dangling} {
+		ok, err := db.Has(dbutils.IntermediateTrieHashBucket, k)
+		require.NoError(t, err)
+		assert.False(t, ok, "%x", k)
+	}
+	report, err = CheckIntermediateHashesOf(db, 1, false)
+	require.NoError(t, err)
+	assert.Empty(t, report.Bad)
+	// The deleted hashes are recomputed from the state
+	assert.Equal(t, root, loadRoot(t, db))
+}
+
+func TestCheckIntermediateHashes(t *testing.T) {
+	datadir, err := ioutil.TempDir("", "check-ih")
+	require.NoError(t, err)
+	defer os.RemoveAll(datadir)
+	chaindata := filepath.Join(datadir, "chaindata")
+	db, err := ethdb.NewBoltDatabase(chaindata)
+	require.NoError(t, err)
+	generateStateWithIntermediateHashes(t, db, datadir)
+	corruptIntermediateHashes(t, db)
+	db.Close()
+
+	assert.Error(t, CheckIntermediateHashes(chaindata, 1, false))
+	// The bad entries are deleted, so the check succeeds
+	assert.NoError(t, CheckIntermediateHashes(chaindata, 1, true))
+	assert.NoError(t, CheckIntermediateHashes(chaindata, 1, false))
+}
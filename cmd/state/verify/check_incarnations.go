@@ -0,0 +1,134 @@
+package verify
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+	"github.com/ledgerwatch/turbo-geth/core/rawdb"
+	"github.com/ledgerwatch/turbo-geth/core/types/accounts"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+	"github.com/ledgerwatch/turbo-geth/log"
+)
+
+// OrphanStorage is the storage of one incarnation of a contract, which matches neither the current
+// incarnation of the account nor any of its incarnations recorded in dbutils.IncarnationHistoryBucket
+type OrphanStorage struct {
+	AddrHash    common.Hash
+	Incarnation uint64
+	Items       int
+}
+
+// IncarnationsReport is the result of CheckStorageIncarnations
+type IncarnationsReport struct {
+	StorageItems    int // All storage items in dbutils.CurrentStateBucket
+	HistoricalItems int // Items of the previous incarnations, left in the state after the self-destructs
+	Orphans         []OrphanStorage
+}
+
+// CheckIncarnations verifies that the incarnation of every storage key in dbutils.CurrentStateBucket
+// is either the current incarnation of the owning account, or one of its historical incarnations.
+// Storage items failing the check are reported as orphans
+func CheckIncarnations(chaindata string) error {
+	db, err := ethdb.OpenBoltDatabase(chaindata, true)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	startTime := time.Now()
+	report, err := CheckStorageIncarnations(db)
+	if err != nil {
+		return err
+	}
+	for _, o := range report.Orphans {
+		fmt.Printf("Orphan storage: addrHash %x, incarnation %d, %d items\n", o.AddrHash, o.Incarnation, o.Items)
+	}
+	fmt.Printf("Checked %d storage items in %s, %d of the previous incarnations, %d orphan prefixes\n",
+		report.StorageItems, time.Since(startTime), report.HistoricalItems, len(report.Orphans))
+	if len(report.Orphans) > 0 {
+		return fmt.Errorf("found storage of %d unknown incarnations", len(report.Orphans))
+	}
+	fmt.Println("Check was succesful")
+	return nil
+}
+
+// CheckStorageIncarnations walks dbutils.CurrentStateBucket and matches the incarnations of the storage items
+// against the accounts. The historical incarnations are only known if the incarnation index has been generated
+func CheckStorageIncarnations(db ethdb.Getter) (*IncarnationsReport, error) {
+	indexed := false
+	if err := db.Walk(dbutils.IncarnationHistoryBucket, nil, 0, func(_, _ []byte) (bool, error) {
+		indexed = true
+		return false, nil
+	}); err != nil {
+		return nil, err
+	}
+	if !indexed {
+		log.Warn("Incarnation index is empty, storage of all the previous incarnations will be reported as orphan")
+	}
+
+	report := &IncarnationsReport{}
+	var (
+		accHash        []byte // The last account seen, storage items follow their accounts
+		accIncarnation uint64
+		prefix         []byte // The last storage prefix seen: addrHash + incarnation
+		historical     bool
+		orphan         *OrphanStorage
+	)
+	if err := db.Walk(dbutils.CurrentStateBucket, nil, 0, func(k, v []byte) (bool, error) {
+		if len(k) == common.HashLength {
+			var acc accounts.Account
+			if err := acc.DecodeForStorage(v); err != nil {
+				return false, fmt.Errorf("decoding account %x: %w", k, err)
+			}
+			accHash = common.CopyBytes(k)
+			accIncarnation = acc.Incarnation
+			return true, nil
+		}
+		if len(k) != common.HashLength+common.IncarnationLength+common.HashLength {
+			return true, nil
+		}
+		report.StorageItems++
+		storagePrefix := k[:common.HashLength+common.IncarnationLength]
+		if !bytes.Equal(storagePrefix, prefix) {
+			prefix = common.CopyBytes(storagePrefix)
+			orphan = nil
+			addrHash, incarnation := dbutils.ParseStoragePrefix(prefix)
+			historical = false
+			if !bytes.Equal(accHash, addrHash[:]) || accIncarnation != incarnation {
+				var err error
+				if historical, err = isHistoricalIncarnation(db, addrHash, incarnation); err != nil {
+					return false, err
+				}
+				if !historical {
+					report.Orphans = append(report.Orphans, OrphanStorage{AddrHash: addrHash, Incarnation: incarnation})
+					orphan = &report.Orphans[len(report.Orphans)-1]
+				}
+			}
+		}
+		if orphan != nil {
+			orphan.Items++
+		}
+		if historical {
+			report.HistoricalItems++
+		}
+		return true, nil
+	}); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+func isHistoricalIncarnation(db ethdb.Getter, addrHash common.Hash, incarnation uint64) (bool, error) {
+	events, err := rawdb.ReadIncarnationEvents(db, addrHash)
+	if err != nil {
+		return false, err
+	}
+	for _, ev := range events {
+		if ev.Incarnation == incarnation {
+			return true, nil
+		}
+	}
+	return false, nil
+}
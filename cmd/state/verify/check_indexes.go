@@ -12,8 +12,8 @@ import (
 	"time"
 )
 
-func CheckIndex(chaindata string, changeSetBucket []byte, indexBucket []byte) error {
-	db, err := ethdb.NewBoltDatabase(chaindata)
+func CheckIndex(chaindata string, changeSetBucket []byte, indexBucket []byte, readOnly bool) error {
+	db, err := ethdb.OpenBoltDatabase(chaindata, readOnly)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -36,6 +36,8 @@ import (
 	"github.com/ledgerwatch/turbo-geth/accounts"
 	"github.com/ledgerwatch/turbo-geth/accounts/keystore"
 	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+	"github.com/ledgerwatch/turbo-geth/common/debug"
 	"github.com/ledgerwatch/turbo-geth/common/fdlimit"
 	"github.com/ledgerwatch/turbo-geth/consensus"
 	"github.com/ledgerwatch/turbo-geth/consensus/clique"
@@ -48,6 +50,7 @@ import (
 	"github.com/ledgerwatch/turbo-geth/eth/downloader"
 	"github.com/ledgerwatch/turbo-geth/eth/gasprice"
 	"github.com/ledgerwatch/turbo-geth/ethdb"
+	"github.com/ledgerwatch/turbo-geth/ethdb/remote/remotedbserver"
 	"github.com/ledgerwatch/turbo-geth/ethstats"
 	"github.com/ledgerwatch/turbo-geth/graphql"
 	"github.com/ledgerwatch/turbo-geth/log"
@@ -62,6 +65,7 @@ import (
 	"github.com/ledgerwatch/turbo-geth/p2p/netutil"
 	"github.com/ledgerwatch/turbo-geth/params"
 	"github.com/ledgerwatch/turbo-geth/rpc"
+	"github.com/ledgerwatch/turbo-geth/trie"
 	"github.com/spf13/cobra"
 	"github.com/urfave/cli"
 )
@@ -149,6 +153,35 @@ var (
 		Name:  "datadir.ancient",
 		Usage: "Data directory for ancient chain segments (default = inside chaindata)",
 	}
+	HistoryDataDirFlag = DirectoryFlag{
+		Name:  "datadir.history",
+		Usage: "Data directory for history and changesets of the chain database, i.e. on a slower disk (default = inside chaindata)",
+	}
+	BoltMmapSizeFlag = cli.IntFlag{
+		Name:  "bolt.mmapsize",
+		Usage: "Initial size of the memory map of the Bolt databases in megabytes, readers don't block the writer until the database outgrows it (0 = Bolt default)",
+	}
+	BoltFreelistFlag = cli.StringFlag{
+		Name:  "bolt.freelist",
+		Usage: `Freelist type of the Bolt databases ("array" or "map", map is faster on the large databases)`,
+		Value: "array",
+	}
+	BoltNoSyncFlag = cli.BoolFlag{
+		Name:  "bolt.nosync",
+		Usage: "Don't fsync Bolt commits until the initial sync completes (turbo import mode). A crash of the OS during the import can corrupt the database",
+	}
+	BoltScrubIntervalFlag = cli.DurationFlag{
+		Name:  "bolt.scrub.interval",
+		Usage: "Maintain the checksums of the chain data buckets and verify the next key range of them every interval, to detect silent disk corruption (0 = disabled)",
+	}
+	DatabaseCodecsFlag = cli.StringFlag{
+		Name:  "db.codecs",
+		Usage: `Compress the values of the buckets created from now on, comma separated bucket=codec pairs, e.g. "bodies=snappy,receipts=snappy" (buckets: bodies, receipts, account-changesets, storage-changesets; codecs: snappy, gzip, zstd in the builds with it). The buckets with the data keep it uncompressed`,
+	}
+	CodeColdDaysFlag = cli.IntFlag{
+		Name:  "code.cold.days",
+		Usage: "Move the contract code, which has not been read for this number of days, to the separate cold store database, and back on the next read (0 = disabled)",
+	}
 	KeyStoreDirFlag = DirectoryFlag{
 		Name:  "keystore",
 		Usage: "Directory for the keystore (default = inside the datadir)",
@@ -245,6 +278,14 @@ var (
 		Usage: `Time of tick`,
 		Value: time.Second * 2,
 	}
+	HistoryRetentionFullFlag = cli.Uint64Flag{
+		Name:  "history.retention.full",
+		Usage: "Keep the full (account and storage) history for this number of the last blocks (0 = forever)",
+	}
+	HistoryRetentionAccountsFlag = cli.Uint64Flag{
+		Name:  "history.retention.accounts",
+		Usage: "Keep the account history for this number of the last blocks, nothing older is kept (0 = forever)",
+	}
 	TxLookupLimitFlag = cli.Int64Flag{
 		Name:  "txlookuplimit",
 		Usage: "Number of recent blocks to maintain transactions index by-hash for (default = index all blocks)",
@@ -428,6 +469,42 @@ var (
 		Name:  "trie-cache-gens",
 		Usage: "Number of trie node generations to keep in memory",
 	}
+	StageLogIntervalFlag = cli.Uint64Flag{
+		Name:  "stage-log-interval",
+		Usage: "Number of blocks after which the time spent in the block processing stages is logged (0 = disabled)",
+		Value: state.StageLogInterval,
+	}
+	AccountFilterFlag = cli.Uint64Flag{
+		Name:  "account-filter",
+		Usage: "Expected number of accounts in the Bloom filter used to skip the lookups of non-existent accounts during execution (0 = disabled)",
+	}
+	ExecutionPrefetchFlag = cli.IntFlag{
+		Name:  "execution-prefetch",
+		Usage: "Number of blocks read and prepared ahead of the executed one during the staged sync (0 = disabled)",
+		Value: downloader.ExecutionPrefetchDepth,
+	}
+	CodeCacheFlag = cli.IntFlag{
+		Name:  "code-cache",
+		Usage: "Megabytes of memory allocated to the contract code cache shared by all state readers (0 = disabled)",
+		Value: state.CodeCacheSize / 1024 / 1024,
+	}
+	TrieCacheAccountsFlag = cli.Uint64Flag{
+		Name:  "trie-cache-accounts",
+		Usage: "Separate limit for the size of the account trie nodes and code kept in memory (0 = shared limit)",
+	}
+	TrieCacheStorageFlag = cli.Uint64Flag{
+		Name:  "trie-cache-storage",
+		Usage: "Separate limit for the size of the storage trie nodes kept in memory (0 = shared limit)",
+	}
+	TrieEvictionPolicyFlag = cli.StringFlag{
+		Name:  "trie-eviction-policy",
+		Usage: `Storage trie nodes to evict first: "oldest" generations, or whole cold storage tries from the smallest ("size")`,
+		Value: trie.EvictOldest.String(),
+	}
+	TrieWitnessLenFlag = cli.BoolFlag{
+		Name:  "trie-witness-len",
+		Usage: "Keep the witness lengths of the sub-tries next to the intermediate hashes, to estimate the witness sizes",
+	}
 	StorageModeFlag = cli.StringFlag{
 		Name: "storage-mode",
 		Usage: `Configures the storage mode of the app:
@@ -442,6 +519,66 @@ var (
 		Usage: "When to switch from full to archive sync",
 		Value: 1024,
 	}
+	FlatHashingFlag = cli.BoolFlag{
+		Name:  "flat-hashing",
+		Usage: "Compute state roots by streaming the database instead of keeping the state trie in memory (commits after every block)",
+	}
+	HashingWorkersFlag = cli.IntFlag{
+		Name:  "hashing-workers",
+		Usage: "Number of goroutines hashing the large subtries of the state trie concurrently (0 = serial hashing)",
+	}
+	RetainListBudgetFlag = cli.Uint64Flag{
+		Name:  "retain-list-budget",
+		Usage: "Memory limit (MB) of the read/change sets of a block kept for the witnesses, above it they are spilled to the database (0 = unlimited)",
+	}
+	HistoryCommitWindowFlag = cli.Uint64Flag{
+		Name:  "history-commit-window",
+		Usage: "Number of blocks, the updates of the history indexes of which are buffered and written at once, e.g. 100 during the sync (0 = every block). The recent history of the pending blocks is not visible until they are written",
+	}
+	SplitStateFlag = cli.BoolFlag{
+		Name:  "splitstate",
+		Usage: "Keep the accounts and the storage of the current state in separate buckets, the existing state is moved on the start (Bolt only, not reversible)",
+	}
+	StorageChangeSetV2Flag = cli.BoolFlag{
+		Name:  "storage-changeset-v2",
+		Usage: "Convert the storage changesets to the v2 layout, which groups the changes by contract, and write them in it. The interrupted conversion resumes on the next start (not reversible, the older versions can't read the converted database)",
+	}
+	AsyncCommitFlag = cli.BoolFlag{
+		Name:  "async-commit",
+		Usage: "Commit the inserted blocks into the database in the background, while the next blocks are processed (ignored with --flat-hashing)",
+	}
+	HistoryIndexAllowFlag = cli.StringFlag{
+		Name:  "history-index-allow",
+		Usage: "Comma separated list of addresses, the history of which is indexed (the others are not available to the historical queries, their changesets are still kept)",
+	}
+	HistoryIndexDenyFlag = cli.StringFlag{
+		Name:  "history-index-deny",
+		Usage: "Comma separated list of addresses, the history of which is not indexed (the changesets are still kept)",
+	}
+	HistoryIndexRebuildFlag = cli.BoolFlag{
+		Name:  "history-index-rebuild",
+		Usage: "Rebuild the history indexes from the changesets, if --history-index-allow or --history-index-deny includes the accounts, which were not indexed (otherwise the start fails)",
+	}
+	TxAddressIndexFlag = cli.BoolFlag{
+		Name:  "txaddrindex",
+		Usage: "Index the transactions by the addresses of their senders and recipients (staged sync only), the existing blocks are indexed on the next sync cycle",
+	}
+	TxAddressIndexKeepFlag = cli.Uint64Flag{
+		Name:  "txaddrindex.keep",
+		Usage: "Number of the last blocks, the transactions of which are kept in the transaction address index (0 = all)",
+	}
+	PinnedStorageFlag = cli.StringFlag{
+		Name:  "pinned-storage",
+		Usage: "Comma separated list of contracts which storage tries are always kept fully resolved in memory (never evicted)",
+	}
+	HotAccountsFlag = cli.StringFlag{
+		Name:  "hot-accounts",
+		Usage: "File with the addresses (one per line) of the accounts which are preloaded into the caches and never evicted from the account trie",
+	}
+	TraceAccountsFlag = cli.StringFlag{
+		Name:  "trace-accounts",
+		Usage: "Comma separated list of addresses which accounts are logged every time they are decoded by the state readers",
+	}
 	DatabaseFlag = cli.StringFlag{
 		Name:  "database",
 		Usage: "Which database software to use? Currently supported values: badger & bolt",
@@ -452,6 +589,31 @@ var (
 		Usage: "network address (for example, localhost:9999) to start remote database server on",
 		Value: "",
 	}
+	RemoteDbMaxConnectionsFlag = cli.Uint64Flag{
+		Name:  "remote-db-max-connections",
+		Usage: "Maximum number of the remote database connections served at the same time (0 = no limit)",
+		Value: remotedbserver.DefaultServerOpts.MaxConnections,
+	}
+	RemoteDbMaxTxsPerHostFlag = cli.IntFlag{
+		Name:  "remote-db-max-txs-per-host",
+		Usage: "Maximum number of the remote database transactions open at the same time by all the connections from one host (0 = no limit)",
+		Value: remotedbserver.DefaultServerOpts.MaxTxsPerHost,
+	}
+	RemoteDbMaxCursorsPerTxFlag = cli.IntFlag{
+		Name:  "remote-db-max-cursors-per-tx",
+		Usage: "Maximum number of the cursors of one remote database transaction (0 = no limit)",
+		Value: remotedbserver.DefaultServerOpts.MaxCursorsPerTx,
+	}
+	RemoteDbIdleTimeoutFlag = cli.DurationFlag{
+		Name:  "remote-db-idle-timeout",
+		Usage: "Time after which the idle remote database transaction is rolled back and its connection closed",
+		Value: remotedbserver.DefaultServerOpts.IdleTimeout,
+	}
+	RemoteDbGrpcListenAddress = cli.StringFlag{
+		Name:  "remote-db-grpc-listen-addr",
+		Usage: "network address (for example, localhost:9090) to start gRPC remote database server on",
+		Value: "",
+	}
 	// Miner settings
 	MiningEnabledFlag = cli.BoolFlag{
 		Name:  "mine",
@@ -519,6 +681,10 @@ var (
 		Name:  "vmdebug",
 		Usage: "Record information useful for VM and contract debugging",
 	}
+	VMBatchStorageReadsFlag = cli.BoolFlag{
+		Name:  "vm.batchstoragereads",
+		Usage: "Execute the transactions speculatively first, to read their storage in batches",
+	}
 	InsecureUnlockAllowedFlag = cli.BoolFlag{
 		Name:  "allow-insecure-unlock",
 		Usage: "Allow insecure account unlocking when account-related RPCs are exposed by http",
@@ -714,6 +880,10 @@ var (
 		Name:  "metrics.expensive",
 		Usage: "Enable expensive metrics collection and reporting",
 	}
+	MetricsDatabaseStatsIntervalFlag = cli.DurationFlag{
+		Name:  "metrics.dbstats.interval",
+		Usage: "How often to sample the sizes of the database buckets into the metrics and the database (0 = disabled)",
+	}
 	MetricsEnableInfluxDBFlag = cli.BoolFlag{
 		Name:  "metrics.influxdb",
 		Usage: "Enable metrics export/push to an external InfluxDB database",
@@ -811,6 +981,38 @@ func setNodeKey(ctx *cli.Context, cfg *p2p.Config) {
 	}
 }
 
+// setBoltOptions configures the tunable options of the Bolt databases
+func setBoltOptions(ctx *cli.Context, cfg *node.Config) {
+	if ctx.GlobalIsSet(BoltMmapSizeFlag.Name) {
+		cfg.BoltOptions.InitialMmapSize = ctx.GlobalInt(BoltMmapSizeFlag.Name) * 1024 * 1024
+	}
+	if ctx.GlobalIsSet(BoltFreelistFlag.Name) {
+		switch freelist := ctx.GlobalString(BoltFreelistFlag.Name); freelist {
+		case "array":
+			cfg.BoltOptions.FreelistMap = false
+		case "map":
+			cfg.BoltOptions.FreelistMap = true
+		default:
+			Fatalf("Invalid %s %q, expected \"array\" or \"map\"", BoltFreelistFlag.Name, freelist)
+		}
+	}
+	if ctx.GlobalIsSet(BoltNoSyncFlag.Name) {
+		cfg.BoltOptions.NoSync = ctx.GlobalBool(BoltNoSyncFlag.Name)
+	}
+}
+
+// setBucketCodecs enables the compression of the buckets, see dbutils.BucketCodecs
+func setBucketCodecs(ctx *cli.Context) {
+	if !ctx.GlobalIsSet(DatabaseCodecsFlag.Name) {
+		return
+	}
+	codecs, err := dbutils.ParseBucketCodecs(ctx.GlobalString(DatabaseCodecsFlag.Name))
+	if err != nil {
+		Fatalf("Invalid %s: %v", DatabaseCodecsFlag.Name, err)
+	}
+	dbutils.BucketCodecs = codecs
+}
+
 // setNodeUserIdent creates the user identifier from CLI flags.
 func setNodeUserIdent(ctx *cli.Context, cfg *node.Config) {
 	if identity := ctx.GlobalString(IdentityFlag.Name); len(identity) > 0 {
@@ -1026,6 +1228,16 @@ func setWS(ctx *cli.Context, cfg *node.Config) {
 // read-only interface to the databae
 func setRemoteDb(ctx *cli.Context, cfg *node.Config) {
 	cfg.RemoteDbListenAddress = ctx.GlobalString(RemoteDbListenAddress.Name)
+	if ctx.GlobalIsSet(RemoteDbMaxConnectionsFlag.Name) || ctx.GlobalIsSet(RemoteDbMaxTxsPerHostFlag.Name) ||
+		ctx.GlobalIsSet(RemoteDbMaxCursorsPerTxFlag.Name) || ctx.GlobalIsSet(RemoteDbIdleTimeoutFlag.Name) {
+		cfg.RemoteDbServerOpts = &remotedbserver.ServerOpts{
+			MaxConnections:  ctx.GlobalUint64(RemoteDbMaxConnectionsFlag.Name),
+			MaxTxsPerHost:   ctx.GlobalInt(RemoteDbMaxTxsPerHostFlag.Name),
+			MaxCursorsPerTx: ctx.GlobalInt(RemoteDbMaxCursorsPerTxFlag.Name),
+			IdleTimeout:     ctx.GlobalDuration(RemoteDbIdleTimeoutFlag.Name),
+		}
+	}
+	cfg.RemoteDbGrpcListenAddress = ctx.GlobalString(RemoteDbGrpcListenAddress.Name)
 }
 
 // setIPC creates an IPC path configuration from the set command line flags,
@@ -1248,6 +1460,12 @@ func SetNodeConfig(ctx *cli.Context, cfg *node.Config) {
 	setDataDir(ctx, cfg)
 	setSmartCard(ctx, cfg)
 
+	if ctx.GlobalIsSet(HistoryDataDirFlag.Name) {
+		cfg.HistoryDataDir = ctx.GlobalString(HistoryDataDirFlag.Name)
+	}
+	setBoltOptions(ctx, cfg)
+	setBucketCodecs(ctx)
+
 	if ctx.GlobalIsSet(ExternalSignerFlag.Name) {
 		cfg.ExternalSigner = ctx.GlobalString(ExternalSignerFlag.Name)
 	}
@@ -1439,6 +1657,22 @@ func setMiner(ctx *cli.Context, cfg *miner.Config) {
 	}
 }
 
+// setTraceAccounts enables the tracing of the accounts listed in --trace-accounts by the state readers
+func setTraceAccounts(ctx *cli.Context) {
+	if !ctx.GlobalIsSet(TraceAccountsFlag.Name) {
+		return
+	}
+	var traced []common.Address
+	for _, entry := range strings.Split(ctx.GlobalString(TraceAccountsFlag.Name), ",") {
+		entry = strings.TrimSpace(entry)
+		if !common.IsHexAddress(entry) {
+			Fatalf("Invalid address in --%s: %s", TraceAccountsFlag.Name, entry)
+		}
+		traced = append(traced, common.HexToAddress(entry))
+	}
+	state.TraceAccounts(traced...)
+}
+
 func setWhitelist(ctx *cli.Context, cfg *eth.Config) {
 	whitelist := ctx.GlobalString(WhitelistFlag.Name)
 	if whitelist == "" {
@@ -1503,6 +1737,22 @@ func CheckExclusive(ctx *cli.Context, args ...interface{}) {
 	}
 }
 
+// addressesFromFlag parses the comma separated list of addresses, nil if the flag is not set
+func addressesFromFlag(ctx *cli.Context, flag cli.StringFlag) []common.Address {
+	if !ctx.GlobalIsSet(flag.Name) {
+		return nil
+	}
+	var addresses []common.Address
+	for _, entry := range strings.Split(ctx.GlobalString(flag.Name), ",") {
+		entry = strings.TrimSpace(entry)
+		if !common.IsHexAddress(entry) {
+			Fatalf("Invalid address in --%s: %s", flag.Name, entry)
+		}
+		addresses = append(addresses, common.HexToAddress(entry))
+	}
+	return addresses
+}
+
 // SetEthConfig applies eth-related command line flags to the config.
 func SetEthConfig(ctx *cli.Context, stack *node.Node, cfg *eth.Config) {
 	// Avoid conflicting network flags
@@ -1537,6 +1787,9 @@ func SetEthConfig(ctx *cli.Context, stack *node.Node, cfg *eth.Config) {
 	if ctx.GlobalIsSet(AncientFlag.Name) {
 		cfg.DatabaseFreezer = ctx.GlobalString(AncientFlag.Name)
 	}
+	cfg.DatabaseStatsInterval = ctx.GlobalDuration(MetricsDatabaseStatsIntervalFlag.Name)
+	cfg.DatabaseScrubInterval = ctx.GlobalDuration(BoltScrubIntervalFlag.Name)
+	cfg.CodeColdDays = ctx.GlobalInt(CodeColdDaysFlag.Name)
 
 	// todo uncomment after fix pruning
 	//cfg.Pruning = ctx.GlobalBool(GCModePruningFlag.Name)
@@ -1544,6 +1797,12 @@ func SetEthConfig(ctx *cli.Context, stack *node.Node, cfg *eth.Config) {
 	cfg.BlocksBeforePruning = ctx.GlobalUint64(GCModeLimitFlag.Name)
 	cfg.BlocksToPrune = ctx.GlobalUint64(GCModeBlockToPruneFlag.Name)
 	cfg.PruningTimeout = ctx.GlobalDuration(GCModeTickTimeout.Name)
+	cfg.HistoryRetentionFull = ctx.GlobalUint64(HistoryRetentionFullFlag.Name)
+	cfg.HistoryRetentionAccounts = ctx.GlobalUint64(HistoryRetentionAccountsFlag.Name)
+	retention := ethdb.HistoryRetention{FullBlocks: cfg.HistoryRetentionFull, AccountBlocks: cfg.HistoryRetentionAccounts}
+	if err := retention.Validate(); err != nil {
+		Fatalf("Invalid history retention: %v", err)
+	}
 
 	cfg.DownloadOnly = ctx.GlobalBoolT(DownloadOnlyFlag.Name)
 
@@ -1554,6 +1813,37 @@ func SetEthConfig(ctx *cli.Context, stack *node.Node, cfg *eth.Config) {
 
 	cfg.StorageMode = mode
 	cfg.ArchiveSyncInterval = ctx.GlobalInt(ArchiveSyncInterval.Name)
+	cfg.FlatHashing = ctx.GlobalBool(FlatHashingFlag.Name)
+	cfg.HashingWorkers = ctx.GlobalInt(HashingWorkersFlag.Name)
+	cfg.RetainListBudget = ctx.GlobalUint64(RetainListBudgetFlag.Name)
+	cfg.HistoryCommitWindow = ctx.GlobalUint64(HistoryCommitWindowFlag.Name)
+	cfg.SplitState = ctx.GlobalBool(SplitStateFlag.Name)
+	cfg.StorageChangeSetV2 = ctx.GlobalBool(StorageChangeSetV2Flag.Name)
+	cfg.AsyncCommit = ctx.GlobalBool(AsyncCommitFlag.Name)
+	CheckExclusive(ctx, HistoryIndexAllowFlag, HistoryIndexDenyFlag)
+	cfg.HistoryIndexAllow = addressesFromFlag(ctx, HistoryIndexAllowFlag)
+	cfg.HistoryIndexDeny = addressesFromFlag(ctx, HistoryIndexDenyFlag)
+	cfg.HistoryIndexRebuild = ctx.GlobalBool(HistoryIndexRebuildFlag.Name)
+	cfg.TxAddressIndex = ctx.GlobalBool(TxAddressIndexFlag.Name)
+	cfg.TxAddressIndexKeep = ctx.GlobalUint64(TxAddressIndexKeepFlag.Name)
+	if ctx.GlobalIsSet(PinnedStorageFlag.Name) {
+		for _, entry := range strings.Split(ctx.GlobalString(PinnedStorageFlag.Name), ",") {
+			entry = strings.TrimSpace(entry)
+			if !common.IsHexAddress(entry) {
+				Fatalf("Invalid address in --%s: %s", PinnedStorageFlag.Name, entry)
+			}
+			cfg.PinnedStorage = append(cfg.PinnedStorage, common.HexToAddress(entry))
+		}
+	}
+	if ctx.GlobalIsSet(HotAccountsFlag.Name) {
+		hot, err := state.ReadHotAccountsFile(ctx.GlobalString(HotAccountsFlag.Name))
+		if err != nil {
+			Fatalf("Could not read --%s: %v", HotAccountsFlag.Name, err)
+		}
+		cfg.HotAccounts = hot
+	}
+
+	setTraceAccounts(ctx)
 
 	if ctx.GlobalIsSet(CacheFlag.Name) || ctx.GlobalIsSet(CacheTrieFlag.Name) {
 		cfg.TrieCleanCache = ctx.GlobalInt(CacheFlag.Name) * ctx.GlobalInt(CacheTrieFlag.Name) / 100
@@ -1568,6 +1858,9 @@ func SetEthConfig(ctx *cli.Context, stack *node.Node, cfg *eth.Config) {
 		// TODO(fjl): force-enable this in --dev mode
 		cfg.EnablePreimageRecording = ctx.GlobalBool(VMEnableDebugFlag.Name)
 	}
+	if ctx.GlobalIsSet(VMBatchStorageReadsFlag.Name) {
+		cfg.BatchStorageReads = ctx.GlobalBool(VMBatchStorageReadsFlag.Name)
+	}
 
 	if ctx.GlobalIsSet(EWASMInterpreterFlag.Name) {
 		cfg.EWASMInterpreter = ctx.GlobalString(EWASMInterpreterFlag.Name)
@@ -1644,6 +1937,34 @@ func SetEthConfig(ctx *cli.Context, stack *node.Node, cfg *eth.Config) {
 	if gen := ctx.GlobalInt(TrieCacheGenFlag.Name); gen > 0 {
 		state.MaxTrieCacheSize = uint64(gen)
 	}
+	if ctx.GlobalIsSet(StageLogIntervalFlag.Name) {
+		state.StageLogInterval = ctx.GlobalUint64(StageLogIntervalFlag.Name)
+	}
+	if ctx.GlobalIsSet(AccountFilterFlag.Name) {
+		state.AccountFilterCapacity = ctx.GlobalUint64(AccountFilterFlag.Name)
+	}
+	if ctx.GlobalIsSet(ExecutionPrefetchFlag.Name) {
+		downloader.ExecutionPrefetchDepth = ctx.GlobalInt(ExecutionPrefetchFlag.Name)
+	}
+	if ctx.GlobalIsSet(CodeCacheFlag.Name) {
+		state.CodeCacheSize = ctx.GlobalInt(CodeCacheFlag.Name) * 1024 * 1024
+	}
+	if ctx.GlobalIsSet(TrieCacheAccountsFlag.Name) {
+		state.MaxAccountTrieCacheSize = ctx.GlobalUint64(TrieCacheAccountsFlag.Name)
+	}
+	if ctx.GlobalIsSet(TrieCacheStorageFlag.Name) {
+		state.MaxStorageTrieCacheSize = ctx.GlobalUint64(TrieCacheStorageFlag.Name)
+	}
+	if ctx.GlobalIsSet(TrieEvictionPolicyFlag.Name) {
+		policy, err := trie.ParseEvictionPolicy(ctx.GlobalString(TrieEvictionPolicyFlag.Name))
+		if err != nil {
+			Fatalf("Option %q: %v", TrieEvictionPolicyFlag.Name, err)
+		}
+		state.TrieEvictionPolicy = policy
+	}
+	if ctx.GlobalIsSet(TrieWitnessLenFlag.Name) {
+		debug.OverrideTrackWitnessSize(ctx.GlobalBool(TrieWitnessLenFlag.Name))
+	}
 }
 
 // setDNSDiscoveryDefaults configures DNS discovery with the given URL if
@@ -18,8 +18,14 @@
 package utils
 
 import (
+	"flag"
 	"reflect"
 	"testing"
+
+	"github.com/urfave/cli"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/core/state"
 )
 
 func Test_SplitTagsFlag(t *testing.T) {
@@ -62,3 +68,21 @@ func Test_SplitTagsFlag(t *testing.T) {
 		})
 	}
 }
+
+func TestSetTraceAccounts(t *testing.T) {
+	set := flag.NewFlagSet("test", flag.ContinueOnError)
+	set.String(TraceAccountsFlag.Name, "", "")
+	if err := set.Parse([]string{"--" + TraceAccountsFlag.Name, "0xdAC17F958D2ee523a2206206994597C13D831ec7, 0x3f5CE5FBFe3E9af3971dD833D26bA9b5C936f0bE"}); err != nil {
+		t.Fatal(err)
+	}
+	defer state.TraceAccounts()
+	setTraceAccounts(cli.NewContext(cli.NewApp(), set, nil))
+
+	want := []common.Address{
+		common.HexToAddress("0x3f5CE5FBFe3E9af3971dD833D26bA9b5C936f0bE"),
+		common.HexToAddress("0xdAC17F958D2ee523a2206206994597C13D831ec7"),
+	}
+	if got := state.TracedAccounts(); !reflect.DeepEqual(got, want) {
+		t.Errorf("traced accounts = %x, want %x", got, want)
+	}
+}
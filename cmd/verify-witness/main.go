@@ -0,0 +1,65 @@
+// verify-witness checks the block witnesses produced by turbo-geth against the state roots, without the node.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/trie"
+)
+
+var (
+	root   = flag.String("root", "", "expected state root (hex)")
+	binary = flag.Bool("binary", false, "the witness is of the binary trie")
+)
+
+func init() {
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage:", os.Args[0], "-root <hash> [-binary] [filename]")
+		flag.PrintDefaults()
+		fmt.Fprintln(os.Stderr, `
+Verifies the serialized block witness from the given file against the state root.
+If the filename is omitted, the witness is read from stdin.`)
+	}
+}
+
+func main() {
+	flag.Parse()
+
+	if *root == "" {
+		fmt.Fprintln(os.Stderr, "Error: -root is required")
+		flag.Usage()
+		os.Exit(2)
+	}
+	var r io.Reader
+	switch flag.NArg() {
+	case 0:
+		r = os.Stdin
+	case 1:
+		fd, err := os.Open(flag.Arg(0))
+		if err != nil {
+			die(err)
+		}
+		defer fd.Close()
+		r = fd
+	default:
+		fmt.Fprintln(os.Stderr, "Error: too many arguments")
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	witness, err := trie.VerifyWitness(bufio.NewReader(r), common.HexToHash(*root), *binary)
+	if err != nil {
+		die(err)
+	}
+	fmt.Printf("Witness is valid, root %s, %d operators\n", *root, len(witness.Operators))
+}
+
+func die(args ...interface{}) {
+	fmt.Fprintln(os.Stderr, args...)
+	os.Exit(1)
+}
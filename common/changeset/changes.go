@@ -0,0 +1,85 @@
+package changeset
+
+import (
+	"fmt"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+	"github.com/ledgerwatch/turbo-geth/core/types/accounts"
+	"github.com/ledgerwatch/turbo-geth/crypto"
+)
+
+// AccountChange is a decoded entry of the hashed account changeset
+type AccountChange struct {
+	AddrHash common.Hash
+	Original *accounts.Account // The account before the change, nil if it did not exist
+}
+
+// StorageChange is a decoded entry of the hashed storage changeset
+type StorageChange struct {
+	AddrHash    common.Hash
+	Incarnation uint64
+	KeyHash     common.Hash
+	Original    []byte // The value before the change, empty if the slot was not set
+}
+
+// AddressFilter selects the changes of the given accounts, so that the changes of the other accounts
+// are skipped without decoding. nil filter selects all the changes
+type AddressFilter map[common.Hash]struct{}
+
+// NewAddressFilter creates the filter selecting the changes of the addresses
+func NewAddressFilter(addresses ...common.Address) AddressFilter {
+	filter := make(AddressFilter, len(addresses))
+	for _, address := range addresses {
+		filter[crypto.Keccak256Hash(address[:])] = struct{}{}
+	}
+	return filter
+}
+
+// AddHash adds the account with the address hash to the filter
+func (af AddressFilter) AddHash(addrHash common.Hash) {
+	af[addrHash] = struct{}{}
+}
+
+func (af AddressFilter) accepts(addrHash []byte) bool {
+	if af == nil {
+		return true
+	}
+	_, ok := af[common.BytesToHash(addrHash)]
+	return ok
+}
+
+// WalkAccounts iterates the changes of the accounts selected by the filter, decoding the original accounts
+func (b AccountChangeSetBytes) WalkAccounts(filter AddressFilter, f func(change AccountChange) error) error {
+	return walkAccountChangeSet(b, common.HashLength, func(k, v []byte) error {
+		if !filter.accepts(k) {
+			return nil
+		}
+		change := AccountChange{AddrHash: common.BytesToHash(k)}
+		if len(v) > 0 {
+			change.Original = new(accounts.Account)
+			if err := change.Original.DecodeForStorage(v); err != nil {
+				return fmt.Errorf("decoding account %x: %w", k, err)
+			}
+		}
+		return f(change)
+	})
+}
+
+// WalkStorage iterates the storage changes of the accounts selected by the filter.
+// The contracts rejected by the filter are skipped without decoding their values
+func (b StorageChangeSetBytes) WalkStorage(filter AddressFilter, f func(change StorageChange) error) error {
+	var accepts func([]byte) bool
+	if filter != nil {
+		accepts = filter.accepts
+	}
+	return walkStorageChangeSet(b, common.HashLength, accepts, func(k, v []byte) error {
+		addrHash, incarnation := dbutils.ParseStoragePrefix(k[:common.HashLength+common.IncarnationLength])
+		return f(StorageChange{
+			AddrHash:    addrHash,
+			Incarnation: incarnation,
+			KeyHash:     common.BytesToHash(k[common.HashLength+common.IncarnationLength:]),
+			Original:    v,
+		})
+	})
+}
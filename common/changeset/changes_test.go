@@ -0,0 +1,103 @@
+package changeset
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+	"github.com/ledgerwatch/turbo-geth/core/types/accounts"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testAddress(i int) common.Address {
+	return common.HexToAddress(fmt.Sprintf("0xBe828AD8B538D1D691891F6c725dEdc5989abBc%d", i))
+}
+
+func TestWalkAccounts(t *testing.T) {
+	acc := accounts.NewAccount()
+	acc.Nonce = 5
+	acc.Balance.SetUint64(100)
+	enc := make([]byte, acc.EncodingLengthForStorage())
+	acc.EncodeForStorage(enc)
+
+	ch := NewAccountChangeSet()
+	addrHash0, _ := common.HashData(testAddress(0).Bytes())
+	addrHash1, _ := common.HashData(testAddress(1).Bytes())
+	require.NoError(t, ch.Add(addrHash0[:], enc))
+	require.NoError(t, ch.Add(addrHash1[:], []byte{}))
+	b, err := EncodeAccounts(ch)
+	require.NoError(t, err)
+
+	var changes []AccountChange
+	require.NoError(t, AccountChangeSetBytes(b).WalkAccounts(nil, func(change AccountChange) error {
+		changes = append(changes, change)
+		return nil
+	}))
+	require.Len(t, changes, 2)
+	for _, change := range changes {
+		switch change.AddrHash {
+		case addrHash0:
+			require.NotNil(t, change.Original)
+			assert.Equal(t, uint64(5), change.Original.Nonce)
+			assert.Equal(t, uint64(100), change.Original.Balance.Uint64())
+		case addrHash1:
+			assert.Nil(t, change.Original)
+		default:
+			t.Errorf("unexpected change of %x", change.AddrHash)
+		}
+	}
+
+	changes = nil
+	require.NoError(t, AccountChangeSetBytes(b).WalkAccounts(NewAddressFilter(testAddress(1)), func(change AccountChange) error {
+		changes = append(changes, change)
+		return nil
+	}))
+	require.Len(t, changes, 1)
+	assert.Equal(t, addrHash1, changes[0].AddrHash)
+}
+
+func TestWalkStorage(t *testing.T) {
+	ch := NewStorageChangeSet()
+	incarnations := []uint64{1, 5, 2}
+	for i, inc := range incarnations {
+		for j := 0; j < 3; j++ {
+			require.NoError(t, ch.Add(getTestDataAtIndex(i, j, inc, hashKeyGenerator), hashValueGenerator(i*10+j)))
+		}
+	}
+	b, err := EncodeStorage(ch)
+	require.NoError(t, err)
+
+	count := 0
+	require.NoError(t, StorageChangeSetBytes(b).WalkStorage(nil, func(change StorageChange) error {
+		count++
+		return nil
+	}))
+	assert.Equal(t, 9, count)
+
+	// Only the contract with the non-default incarnation is selected
+	addrHash, _ := common.HashData(testAddress(1).Bytes())
+	filter := make(AddressFilter)
+	filter.AddHash(addrHash)
+	var changes []StorageChange
+	require.NoError(t, StorageChangeSetBytes(b).WalkStorage(filter, func(change StorageChange) error {
+		changes = append(changes, change)
+		return nil
+	}))
+	require.Len(t, changes, 3)
+	for _, change := range changes {
+		assert.Equal(t, addrHash, change.AddrHash)
+		assert.Equal(t, uint64(5), change.Incarnation)
+		k := dbutils.GenerateCompositeStorageKey(change.AddrHash, change.Incarnation, change.KeyHash)
+		v, err := StorageChangeSetBytes(b).Find(k)
+		require.NoError(t, err)
+		assert.Equal(t, v, change.Original)
+	}
+
+	// The filter not matching any contract
+	require.NoError(t, StorageChangeSetBytes(b).WalkStorage(NewAddressFilter(testAddress(7)), func(change StorageChange) error {
+		t.Errorf("unexpected change of %x", change.AddrHash)
+		return nil
+	}))
+}
@@ -89,6 +89,10 @@ func (s *ChangeSet) Equals(s2 *ChangeSet) bool {
 
 // Encoded Method
 
+// Len returns the number of the accounts of the account changeset, or the number of the contracts of the storage one
 func Len(b []byte) int {
+	if IsStorageV2(b) {
+		return int(binary.BigEndian.Uint32(b[1:storageV2HeaderLen]))
+	}
 	return int(binary.BigEndian.Uint32(b[0:4]))
 }
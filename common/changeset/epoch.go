@@ -0,0 +1,104 @@
+package changeset
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// EpochSize is the number of consecutive blocks, which changesets are merged into one epoch by the compaction
+const EpochSize uint64 = 1000
+
+const epochHeaderLen = 4
+const epochEntryLen = 8 + 4
+
+// EncodeEpoch merges the changesets of the blocks of an epoch into one value, blockNrs have to be sorted.
+// Layout: number of blocks (uint32), then for every block its number (uint64) and the end offset of its changeset
+// in the data (uint32), then the data - concatenated changesets. Every changeset stays recoverable by the offsets
+func EncodeEpoch(blockNrs []uint64, changeSets [][]byte) ([]byte, error) {
+	if len(blockNrs) != len(changeSets) {
+		return nil, fmt.Errorf("epoch: %d block numbers for %d changesets", len(blockNrs), len(changeSets))
+	}
+	dataLen := 0
+	for i, cs := range changeSets {
+		if i > 0 && blockNrs[i] <= blockNrs[i-1] {
+			return nil, fmt.Errorf("epoch: block numbers are not sorted: %d after %d", blockNrs[i], blockNrs[i-1])
+		}
+		dataLen += len(cs)
+	}
+	// The offsets and the number of blocks are uint32
+	if uint64(dataLen) > math.MaxUint32 || uint64(len(blockNrs)) > math.MaxUint32 {
+		return nil, fmt.Errorf("epoch: %d changesets of %d bytes overflow the offsets", len(blockNrs), dataLen)
+	}
+	headerLen := epochHeaderLen + epochEntryLen*len(blockNrs)
+	buf := make([]byte, headerLen+dataLen)
+	binary.BigEndian.PutUint32(buf, uint32(len(blockNrs)))
+	offset := 0
+	for i, cs := range changeSets {
+		entry := buf[epochHeaderLen+i*epochEntryLen:]
+		binary.BigEndian.PutUint64(entry, blockNrs[i])
+		copy(buf[headerLen+offset:], cs)
+		offset += len(cs)
+		binary.BigEndian.PutUint32(entry[8:], uint32(offset))
+	}
+	return buf, nil
+}
+
+// EpochBytes is the encoded epoch, see EncodeEpoch
+type EpochBytes []byte
+
+func (e EpochBytes) numOfBlocks() (int, error) {
+	if len(e) < epochHeaderLen {
+		return 0, fmt.Errorf("epoch: too short: %d bytes", len(e))
+	}
+	n := int(binary.BigEndian.Uint32(e))
+	if len(e) < epochHeaderLen+n*epochEntryLen {
+		return 0, fmt.Errorf("epoch: too short for %d blocks: %d bytes", n, len(e))
+	}
+	return n, nil
+}
+
+func (e EpochBytes) entry(n, i int) (blockNr uint64, changeSet []byte) {
+	headerLen := epochHeaderLen + n*epochEntryLen
+	entry := e[epochHeaderLen+i*epochEntryLen:]
+	var from uint32
+	if i > 0 {
+		from = binary.BigEndian.Uint32(e[epochHeaderLen+(i-1)*epochEntryLen+8:])
+	}
+	to := binary.BigEndian.Uint32(entry[8:])
+	return binary.BigEndian.Uint64(entry), e[headerLen+int(from) : headerLen+int(to)]
+}
+
+// Find returns the changeset of the block, or false if the epoch does not contain the block
+func (e EpochBytes) Find(blockNr uint64) ([]byte, bool, error) {
+	n, err := e.numOfBlocks()
+	if err != nil {
+		return nil, false, err
+	}
+	i := sort.Search(n, func(i int) bool {
+		return binary.BigEndian.Uint64(e[epochHeaderLen+i*epochEntryLen:]) >= blockNr
+	})
+	if i == n {
+		return nil, false, nil
+	}
+	nr, cs := e.entry(n, i)
+	if nr != blockNr {
+		return nil, false, nil
+	}
+	return cs, true, nil
+}
+
+// Walk calls f for the changesets of the blocks in the order of block numbers
+func (e EpochBytes) Walk(f func(blockNr uint64, changeSet []byte) error) error {
+	n, err := e.numOfBlocks()
+	if err != nil {
+		return err
+	}
+	for i := 0; i < n; i++ {
+		if err := f(e.entry(n, i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package changeset
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEpochEncoding(t *testing.T) {
+	blockNrs := []uint64{1000, 1001, 1500, 1999}
+	changeSets := [][]byte{{1, 2, 3}, {}, {4}, bytes.Repeat([]byte{5}, 100)}
+	enc, err := EncodeEpoch(blockNrs, changeSets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	epoch := EpochBytes(enc)
+	for i, blockNr := range blockNrs {
+		cs, ok, err := epoch.Find(blockNr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			t.Fatalf("block %d not found", blockNr)
+		}
+		if !bytes.Equal(cs, changeSets[i]) {
+			t.Errorf("block %d: expected %x, got %x", blockNr, changeSets[i], cs)
+		}
+	}
+	for _, blockNr := range []uint64{999, 1002, 2000} {
+		if _, ok, err := epoch.Find(blockNr); err != nil || ok {
+			t.Errorf("block %d: expected not found, got %v, %v", blockNr, ok, err)
+		}
+	}
+	i := 0
+	if err := epoch.Walk(func(blockNr uint64, cs []byte) error {
+		if blockNr != blockNrs[i] || !bytes.Equal(cs, changeSets[i]) {
+			t.Errorf("walk %d: expected block %d %x, got block %d %x", i, blockNrs[i], changeSets[i], blockNr, cs)
+		}
+		i++
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if i != len(blockNrs) {
+		t.Errorf("walked %d blocks, expected %d", i, len(blockNrs))
+	}
+
+	if _, err := EncodeEpoch([]uint64{2, 1}, [][]byte{{}, {}}); err == nil {
+		t.Error("expected error for unsorted block numbers")
+	}
+}
@@ -10,6 +10,11 @@ const (
 	DefaultIncarnation = uint64(1)
 )
 
+// StorageV2 - whether the storage changesets are written in the v2 layout, grouping the changes by contract
+// (see IsStorageV2). Off until the changesets of the database are converted to it, see
+// migrations.ApplyStorageChangeSetV2. Both layouts are read regardless
+var StorageV2 = false
+
 var (
 	ErrNotFound      = errors.New("not found")
 	errIncorrectData = errors.New("empty prepared data")
@@ -25,10 +30,24 @@ func NewStorageChangeSet() *ChangeSet {
 	}
 }
 
+// EncodeStorage encodes the changeset in the layout of the database, see StorageV2
 func EncodeStorage(s *ChangeSet) ([]byte, error) {
+	if StorageV2 {
+		return EncodeStorageV2(s)
+	}
+	return EncodeStorageV1(s)
+}
+
+// EncodeStorageV1 encodes the changeset in the v1 layout
+func EncodeStorageV1(s *ChangeSet) ([]byte, error) {
 	return encodeStorage(s, common.HashLength)
 }
 
+// EncodeStorageV2 encodes the changeset in the v2 layout, grouping the changes by contract (see IsStorageV2)
+func EncodeStorageV2(s *ChangeSet) ([]byte, error) {
+	return encodeStorageV2(s, common.HashLength)
+}
+
 func DecodeStorage(b []byte) (*ChangeSet, error) {
 	cs := NewStorageChangeSet()
 	err := decodeStorage(b, common.HashLength, cs)
@@ -41,7 +60,7 @@ func DecodeStorage(b []byte) (*ChangeSet, error) {
 type StorageChangeSetBytes []byte
 
 func (b StorageChangeSetBytes) Walk(f func(k, v []byte) error) error {
-	return walkStorageChangeSet(b, common.HashLength, f)
+	return walkStorageChangeSet(b, common.HashLength, nil, f)
 }
 
 func (b StorageChangeSetBytes) Find(k []byte) ([]byte, error) {
@@ -52,6 +71,12 @@ func (b StorageChangeSetBytes) FindWithoutIncarnation(addrHashToFind []byte, key
 	return findWithoutIncarnationInStorageChangeSet(b, common.HashLength, addrHashToFind, keyHashToFind)
 }
 
+// WalkContract iterates the changes of the contract with the address hash, without decoding the other contracts
+// of the v2 changesets
+func (b StorageChangeSetBytes) WalkContract(addrHash []byte, f func(k, v []byte) error) error {
+	return walkContractInStorageChangeSet(b, common.HashLength, addrHash, f)
+}
+
 /* Plain changesets (key is a common.Address) */
 
 func NewStorageChangeSetPlain() *ChangeSet {
@@ -61,10 +86,24 @@ func NewStorageChangeSetPlain() *ChangeSet {
 	}
 }
 
+// EncodeStoragePlain encodes the changeset in the layout of the database, see StorageV2
 func EncodeStoragePlain(s *ChangeSet) ([]byte, error) {
+	if StorageV2 {
+		return EncodeStoragePlainV2(s)
+	}
+	return EncodeStoragePlainV1(s)
+}
+
+// EncodeStoragePlainV1 encodes the changeset in the v1 layout
+func EncodeStoragePlainV1(s *ChangeSet) ([]byte, error) {
 	return encodeStorage(s, common.AddressLength)
 }
 
+// EncodeStoragePlainV2 encodes the changeset in the v2 layout, grouping the changes by contract (see IsStorageV2)
+func EncodeStoragePlainV2(s *ChangeSet) ([]byte, error) {
+	return encodeStorageV2(s, common.AddressLength)
+}
+
 func DecodeStoragePlain(b []byte) (*ChangeSet, error) {
 	cs := NewStorageChangeSetPlain()
 	err := decodeStorage(b, common.AddressLength, cs)
@@ -77,7 +116,7 @@ func DecodeStoragePlain(b []byte) (*ChangeSet, error) {
 type StorageChangeSetPlainBytes []byte
 
 func (b StorageChangeSetPlainBytes) Walk(f func(k, v []byte) error) error {
-	return walkStorageChangeSet(b, common.AddressLength, f)
+	return walkStorageChangeSet(b, common.AddressLength, nil, f)
 }
 
 func (b StorageChangeSetPlainBytes) Find(k []byte) ([]byte, error) {
@@ -87,3 +126,9 @@ func (b StorageChangeSetPlainBytes) Find(k []byte) ([]byte, error) {
 func (b StorageChangeSetPlainBytes) FindWithoutIncarnation(addressToFind []byte, keyToFind []byte) ([]byte, error) {
 	return findWithoutIncarnationInStorageChangeSet(b, common.AddressLength, addressToFind, keyToFind)
 }
+
+// WalkContract iterates the changes of the contract with the address, without decoding the other contracts
+// of the v2 changesets
+func (b StorageChangeSetPlainBytes) WalkContract(address []byte, f func(k, v []byte) error) error {
+	return walkContractInStorageChangeSet(b, common.AddressLength, address, f)
+}
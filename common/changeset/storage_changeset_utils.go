@@ -7,6 +7,7 @@ import (
 	"sort"
 
 	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
 )
 
 /**
@@ -28,7 +29,8 @@ numOfUint32Values uint16
 
 */
 
-// encodeStorage encodes a storage changeset into a stream of bytes
+// encodeStorage encodes a storage changeset into a stream of bytes of the v1 layout, the v2 layout is written
+// by encodeStorageV2 (see storage_changeset_v2.go), both are read
 // storage changesets use composite length
 // provided `keyPrefixLen` is a length of the 1st part of the key
 // - for hashed changesets it is common.HashLength (key: hash + incarnation + hash)
@@ -58,7 +60,7 @@ func encodeStorage(s *ChangeSet, keyPrefixLen uint32) ([]byte, error) {
 	currentKey := -1
 	for i, change := range s.Changes {
 		addrBytes := change.Key[0:keyPrefixLen] // hash or raw address
-		incarnation := ^binary.BigEndian.Uint64(change.Key[keyPrefixLen:])
+		incarnation := dbutils.DecodeIncarnation(change.Key[keyPrefixLen:])
 		keyBytes := change.Key[keyPrefixLen+common.IncarnationLength : keyPrefixLen+common.HashLength+common.IncarnationLength] // hash or raw key
 		//found new contract address
 		if i == 0 || !bytes.Equal(currentContract.AddrBytes, addrBytes) || currentContract.Incarnation != incarnation {
@@ -68,7 +70,7 @@ func encodeStorage(s *ChangeSet, keyPrefixLen uint32) ([]byte, error) {
 			//add to incarnations part only if it's not default
 			if incarnation != DefaultIncarnation {
 				binary.BigEndian.PutUint32(b[0:], uint32(currentKey))
-				binary.BigEndian.PutUint64(b[4:], ^incarnation)
+				dbutils.EncodeIncarnation(b[4:], incarnation)
 				notDefaultIncarnationsBytes = append(notDefaultIncarnationsBytes, b...)
 				nonDefaultIncarnationCounter++
 			}
@@ -181,6 +183,9 @@ func encodeStorage(s *ChangeSet, keyPrefixLen uint32) ([]byte, error) {
 // decodeStorage decodes a stream of bytes to a storage changeset using
 // specified `keyPrefixLen` (see `encodeStorage` in this file for explanation)
 func decodeStorage(b []byte, keyPrefixLen int, cs *ChangeSet) error {
+	if IsStorageV2(b) {
+		return decodeStorageV2(b, keyPrefixLen, cs)
+	}
 	numOfUniqueElements := int(binary.BigEndian.Uint32(b))
 	if numOfUniqueElements == 0 {
 		return nil
@@ -202,7 +207,7 @@ func decodeStorage(b []byte, keyPrefixLen int, cs *ChangeSet) error {
 	if numOfNotDefaultIncarnations > 0 {
 		for i := 0; i < numOfNotDefaultIncarnations; i++ {
 			id := binary.BigEndian.Uint32(b[incarnationsStart+i*12:])
-			keys[id].Incarnation = ^binary.BigEndian.Uint64(b[incarnationsStart+i*12+4:])
+			keys[id].Incarnation = dbutils.DecodeIncarnation(b[incarnationsStart+i*12+4:])
 		}
 	}
 
@@ -222,7 +227,7 @@ func decodeStorage(b []byte, keyPrefixLen int, cs *ChangeSet) error {
 		for i := range v.Keys {
 			k := make([]byte, keyPrefixLen+common.IncarnationLength+common.HashLength)
 			copy(k[:keyPrefixLen], v.AddrBytes)
-			binary.BigEndian.PutUint64(k[keyPrefixLen:], ^v.Incarnation)
+			dbutils.EncodeIncarnation(k[keyPrefixLen:], v.Incarnation)
 			copy(k[keyPrefixLen+common.IncarnationLength:keyPrefixLen+common.HashLength+common.IncarnationLength], v.Keys[i])
 			val, innerErr := findValue(b[valsInfoStart:], id)
 			if innerErr != nil {
@@ -283,10 +288,15 @@ func findValue(b []byte, i int) ([]byte, error) {
 	return b[valsPointer+lenOfValStart : valsPointer+lenOfValEnd], nil
 }
 
-func walkStorageChangeSet(b []byte, keyPrefixLen int, f func(k, v []byte) error) error {
+// walkStorageChangeSet iterates the storage changeset, the keys are composite: address (hash) + incarnation + key hash.
+// If the filter is not nil, the contracts it rejects are skipped without decoding their values
+func walkStorageChangeSet(b []byte, keyPrefixLen int, filter func(addrBytes []byte) bool, f func(k, v []byte) error) error {
 	if len(b) == 0 {
 		return nil
 	}
+	if IsStorageV2(b) {
+		return walkStorageChangeSetV2(b, keyPrefixLen, filter, f)
+	}
 
 	if len(b) < 4 {
 		return fmt.Errorf("decode: input too short (%d bytes)", len(b))
@@ -303,7 +313,7 @@ func walkStorageChangeSet(b []byte, keyPrefixLen int, f func(k, v []byte) error)
 	notDefaultIncarnations := make(map[uint32]uint64, numOfNotDefaultIncarnations)
 	if numOfNotDefaultIncarnations > 0 {
 		for i := 0; i < numOfNotDefaultIncarnations; i++ {
-			notDefaultIncarnations[binary.BigEndian.Uint32(b[incarnatonsStart+i*12:])] = ^binary.BigEndian.Uint64(b[incarnatonsStart+i*12+4:])
+			notDefaultIncarnations[binary.BigEndian.Uint32(b[incarnatonsStart+i*12:])] = dbutils.DecodeIncarnation(b[incarnatonsStart+i*12+4:])
 		}
 	}
 
@@ -325,6 +335,11 @@ func walkStorageChangeSet(b []byte, keyPrefixLen int, f func(k, v []byte) error)
 		}
 		endKeys = int(binary.BigEndian.Uint32(b[4+(i+1)*(keyPrefixLen)+i*4:]))
 		addrBytes := b[4+i*(keyPrefixLen)+i*4:] // hash or raw address
+		if filter != nil && !filter(addrBytes[:keyPrefixLen]) {
+			id += endKeys - startKeys
+			addressHashID++
+			continue
+		}
 		incarnation := DefaultIncarnation
 		if inc, ok := notDefaultIncarnations[addressHashID]; ok {
 			incarnation = inc
@@ -332,7 +347,7 @@ func walkStorageChangeSet(b []byte, keyPrefixLen int, f func(k, v []byte) error)
 
 		for j := startKeys; j < endKeys; j++ {
 			copy(k[:keyPrefixLen], addrBytes[:keyPrefixLen])
-			binary.BigEndian.PutUint64(k[keyPrefixLen:], ^incarnation)
+			dbutils.EncodeIncarnation(k[keyPrefixLen:], incarnation)
 			copy(k[keyPrefixLen+common.IncarnationLength:keyPrefixLen+common.HashLength+common.IncarnationLength], b[keysStart+j*common.HashLength:])
 			val, innerErr := findValue(b[valsInfoStart:], id)
 			if innerErr != nil {
@@ -351,6 +366,10 @@ func walkStorageChangeSet(b []byte, keyPrefixLen int, f func(k, v []byte) error)
 }
 
 func findInStorageChangeSet(b []byte, keyPrefixLen int, k []byte) ([]byte, error) {
+	if IsStorageV2(b) {
+		incarnation := dbutils.DecodeIncarnation(k[keyPrefixLen:])
+		return findInStorageChangeSetV2(b, keyPrefixLen, k[:keyPrefixLen], &incarnation, k[keyPrefixLen+common.IncarnationLength:keyPrefixLen+common.HashLength+common.IncarnationLength])
+	}
 	return doSearch(
 		b,
 		keyPrefixLen,
@@ -360,6 +379,9 @@ func findInStorageChangeSet(b []byte, keyPrefixLen int, k []byte) ([]byte, error
 }
 
 func findWithoutIncarnationInStorageChangeSet(b []byte, keyPrefixLen int, addrBytesToFind []byte, keyBytesToFind []byte) ([]byte, error) {
+	if IsStorageV2(b) {
+		return findInStorageChangeSetV2(b, keyPrefixLen, addrBytesToFind, nil, keyBytesToFind)
+	}
 	return doSearch(
 		b,
 		keyPrefixLen,
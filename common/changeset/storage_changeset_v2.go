@@ -0,0 +1,336 @@
+package changeset
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+)
+
+/**
+The v2 layout groups the changes by contract, so that the changes of one contract are found without decoding the others:
+
+version byte (storageChangeSetV2)
+numOfContracts uint32
+[]{
+	addrBytes [keyPrefixLen]byte
+	endOfContract uint32 - end of the section of the contract, counted from the start of the sections
+}
+numOfNotDefaultIncarnations uint32
+[]{
+	contractID uint32
+	incarnation [8]byte
+}
+[]{ - sections of the contracts
+	numOfKeys uint32
+	width uint8 - 1, 2 or 4 bytes per the end of a value
+	keys [numOfKeys]common.Hash
+	endsOfValues [numOfKeys]width - accumulating lengths of the values of the contract
+	values
+}
+*/
+
+// storageChangeSetV2 is the first byte of the storage changesets of the v2 layout. The changesets of the v1 layout
+// start with the number of the contracts (uint32), which never gets that high
+const storageChangeSetV2 = 0xff
+
+const storageV2HeaderLen = 1 + 4
+
+// IsStorageV2 tells whether the storage changeset is encoded in the v2 layout, grouping the changes by contract
+func IsStorageV2(b []byte) bool {
+	return len(b) > 0 && b[0] == storageChangeSetV2
+}
+
+// encodeStorageV2 encodes a storage changeset in the v2 layout, the keys are composite as in encodeStorage
+func encodeStorageV2(s *ChangeSet, keyPrefixLen int) ([]byte, error) {
+	sort.Sort(s)
+	var contracts []contractKeys
+	for _, change := range s.Changes {
+		addrBytes := change.Key[:keyPrefixLen]
+		incarnation := dbutils.DecodeIncarnation(change.Key[keyPrefixLen:])
+		keyBytes := change.Key[keyPrefixLen+common.IncarnationLength : keyPrefixLen+common.IncarnationLength+common.HashLength]
+		last := len(contracts) - 1
+		if last < 0 || !bytes.Equal(contracts[last].AddrBytes, addrBytes) || contracts[last].Incarnation != incarnation {
+			contracts = append(contracts, contractKeys{AddrBytes: addrBytes, Incarnation: incarnation})
+			last++
+		}
+		contracts[last].Keys = append(contracts[last].Keys, keyBytes)
+		contracts[last].Vals = append(contracts[last].Vals, change.Value)
+	}
+	if len(contracts) == 0 {
+		return nil, errIncorrectData
+	}
+
+	sections := make([][]byte, len(contracts))
+	incarnations := make([]byte, 4)
+	var numOfNotDefaultIncarnations uint32
+	for i, contract := range contracts {
+		sections[i] = encodeContractSection(contract)
+		if contract.Incarnation != DefaultIncarnation {
+			var b [4 + common.IncarnationLength]byte
+			binary.BigEndian.PutUint32(b[:], uint32(i))
+			dbutils.EncodeIncarnation(b[4:], contract.Incarnation)
+			incarnations = append(incarnations, b[:]...)
+			numOfNotDefaultIncarnations++
+		}
+	}
+	binary.BigEndian.PutUint32(incarnations, numOfNotDefaultIncarnations)
+
+	buf := bytes.NewBuffer(make([]byte, 0, storageV2HeaderLen+len(contracts)*(keyPrefixLen+4)+len(incarnations)))
+	buf.WriteByte(storageChangeSetV2)
+	var uint32Arr [4]byte
+	binary.BigEndian.PutUint32(uint32Arr[:], uint32(len(contracts)))
+	buf.Write(uint32Arr[:])
+	var end int
+	for i, contract := range contracts {
+		end += len(sections[i])
+		buf.Write(contract.AddrBytes)
+		binary.BigEndian.PutUint32(uint32Arr[:], uint32(end))
+		buf.Write(uint32Arr[:])
+	}
+	buf.Write(incarnations)
+	for _, section := range sections {
+		buf.Write(section)
+	}
+	return buf.Bytes(), nil
+}
+
+func encodeContractSection(contract contractKeys) []byte {
+	var lengthOfValues int
+	for _, v := range contract.Vals {
+		lengthOfValues += len(v)
+	}
+	width := 4
+	switch {
+	case lengthOfValues <= 255:
+		width = 1
+	case lengthOfValues <= 65535:
+		width = 2
+	}
+	n := len(contract.Keys)
+	section := make([]byte, 5+n*(common.HashLength+width), 5+n*(common.HashLength+width)+lengthOfValues)
+	binary.BigEndian.PutUint32(section, uint32(n))
+	section[4] = byte(width)
+	ends := section[5+n*common.HashLength:]
+	var end int
+	for j, key := range contract.Keys {
+		copy(section[5+j*common.HashLength:], key)
+		end += len(contract.Vals[j])
+		putWidth(ends[j*width:], width, end)
+	}
+	for _, v := range contract.Vals {
+		section = append(section, v...)
+	}
+	return section
+}
+
+func putWidth(b []byte, width int, v int) {
+	switch width {
+	case 1:
+		b[0] = byte(v)
+	case 2:
+		binary.BigEndian.PutUint16(b, uint16(v))
+	default:
+		binary.BigEndian.PutUint32(b, uint32(v))
+	}
+}
+
+func readWidth(b []byte, width int) int {
+	switch width {
+	case 1:
+		return int(b[0])
+	case 2:
+		return int(binary.BigEndian.Uint16(b))
+	default:
+		return int(binary.BigEndian.Uint32(b))
+	}
+}
+
+// storageV2 gives access to the dictionary and to the sections of the contracts of the v2 changeset
+type storageV2 struct {
+	b             []byte
+	keyPrefixLen  int
+	numContracts  int
+	sectionsStart int
+	incarnations  map[int]uint64
+}
+
+func parseStorageV2(b []byte, keyPrefixLen int) (*storageV2, error) {
+	if len(b) < storageV2HeaderLen {
+		return nil, fmt.Errorf("decode: input too short (%d bytes)", len(b))
+	}
+	s := &storageV2{b: b, keyPrefixLen: keyPrefixLen, numContracts: int(binary.BigEndian.Uint32(b[1:]))}
+	incarnationsInfo := storageV2HeaderLen + s.numContracts*(keyPrefixLen+4)
+	if len(b) < incarnationsInfo+4 {
+		return nil, fmt.Errorf("decode: input too short for %d contracts (%d bytes)", s.numContracts, len(b))
+	}
+	numOfNotDefaultIncarnations := int(binary.BigEndian.Uint32(b[incarnationsInfo:]))
+	incarnationsStart := incarnationsInfo + 4
+	s.sectionsStart = incarnationsStart + numOfNotDefaultIncarnations*(4+common.IncarnationLength)
+	if len(b) < s.sectionsStart {
+		return nil, fmt.Errorf("decode: input too short for %d incarnations (%d bytes)", numOfNotDefaultIncarnations, len(b))
+	}
+	if numOfNotDefaultIncarnations > 0 {
+		s.incarnations = make(map[int]uint64, numOfNotDefaultIncarnations)
+		for i := 0; i < numOfNotDefaultIncarnations; i++ {
+			entry := b[incarnationsStart+i*(4+common.IncarnationLength):]
+			s.incarnations[int(binary.BigEndian.Uint32(entry))] = dbutils.DecodeIncarnation(entry[4:])
+		}
+	}
+	return s, nil
+}
+
+func (s *storageV2) addrBytes(i int) []byte {
+	start := storageV2HeaderLen + i*(s.keyPrefixLen+4)
+	return s.b[start : start+s.keyPrefixLen]
+}
+
+func (s *storageV2) incarnation(i int) uint64 {
+	if inc, ok := s.incarnations[i]; ok {
+		return inc
+	}
+	return DefaultIncarnation
+}
+
+// section returns the keys, the ends of the values and the values of the contract
+func (s *storageV2) section(i int) (n int, width int, keys []byte, ends []byte, values []byte, err error) {
+	var start int
+	if i > 0 {
+		start = int(binary.BigEndian.Uint32(s.b[storageV2HeaderLen+i*(s.keyPrefixLen+4)-4:]))
+	}
+	end := int(binary.BigEndian.Uint32(s.b[storageV2HeaderLen+i*(s.keyPrefixLen+4)+s.keyPrefixLen:]))
+	if s.sectionsStart+end > len(s.b) || start+5 > end {
+		return 0, 0, nil, nil, nil, fmt.Errorf("decode: section of contract %d is out of bounds", i)
+	}
+	section := s.b[s.sectionsStart+start : s.sectionsStart+end]
+	n = int(binary.BigEndian.Uint32(section))
+	width = int(section[4])
+	valuesStart := 5 + n*(common.HashLength+width)
+	if valuesStart > len(section) {
+		return 0, 0, nil, nil, nil, fmt.Errorf("decode: section of contract %d is too short", i)
+	}
+	return n, width, section[5 : 5+n*common.HashLength], section[5+n*common.HashLength : valuesStart], section[valuesStart:], nil
+}
+
+func value(width int, ends, values []byte, j int) []byte {
+	var start int
+	if j > 0 {
+		start = readWidth(ends[(j-1)*width:], width)
+	}
+	return values[start:readWidth(ends[j*width:], width)]
+}
+
+// walkContract calls f for the changes of the i-th contract, with the composite keys
+func (s *storageV2) walkContract(i int, k []byte, f func(k, v []byte) error) error {
+	n, width, keys, ends, values, err := s.section(i)
+	if err != nil {
+		return err
+	}
+	copy(k, s.addrBytes(i))
+	dbutils.EncodeIncarnation(k[s.keyPrefixLen:], s.incarnation(i))
+	for j := 0; j < n; j++ {
+		copy(k[s.keyPrefixLen+common.IncarnationLength:], keys[j*common.HashLength:(j+1)*common.HashLength])
+		if err := f(k, value(width, ends, values, j)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// findContracts returns the range of the contracts with the address (one per incarnation)
+func (s *storageV2) findContracts(addrBytes []byte) (int, int) {
+	from := sort.Search(s.numContracts, func(i int) bool {
+		return bytes.Compare(s.addrBytes(i), addrBytes) >= 0
+	})
+	to := from
+	for to < s.numContracts && bytes.Equal(s.addrBytes(to), addrBytes) {
+		to++
+	}
+	return from, to
+}
+
+// find looks the key up in the i-th contract
+func (s *storageV2) find(i int, keyBytes []byte) ([]byte, bool, error) {
+	n, width, keys, ends, values, err := s.section(i)
+	if err != nil {
+		return nil, false, err
+	}
+	j := sort.Search(n, func(j int) bool {
+		return bytes.Compare(keys[j*common.HashLength:(j+1)*common.HashLength], keyBytes) >= 0
+	})
+	if j == n || !bytes.Equal(keys[j*common.HashLength:(j+1)*common.HashLength], keyBytes) {
+		return nil, false, nil
+	}
+	return value(width, ends, values, j), true, nil
+}
+
+func decodeStorageV2(b []byte, keyPrefixLen int, cs *ChangeSet) error {
+	cs.Changes = cs.Changes[:0]
+	return walkStorageChangeSetV2(b, keyPrefixLen, nil, func(k, v []byte) error {
+		cs.Changes = append(cs.Changes, Change{Key: common.CopyBytes(k), Value: v})
+		return nil
+	})
+}
+
+func walkStorageChangeSetV2(b []byte, keyPrefixLen int, filter func(addrBytes []byte) bool, f func(k, v []byte) error) error {
+	s, err := parseStorageV2(b, keyPrefixLen)
+	if err != nil {
+		return err
+	}
+	k := make([]byte, keyPrefixLen+common.IncarnationLength+common.HashLength)
+	for i := 0; i < s.numContracts; i++ {
+		if filter != nil && !filter(s.addrBytes(i)) {
+			continue
+		}
+		if err := s.walkContract(i, k, f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// walkContractInStorageChangeSet calls f for the changes of the contract (of all its incarnations) in the changeset.
+// The v2 changesets find the contract by binary search, the v1 ones are walked with the filter
+func walkContractInStorageChangeSet(b []byte, keyPrefixLen int, addrBytes []byte, f func(k, v []byte) error) error {
+	if !IsStorageV2(b) {
+		return walkStorageChangeSet(b, keyPrefixLen, func(a []byte) bool { return bytes.Equal(a, addrBytes) }, f)
+	}
+	s, err := parseStorageV2(b, keyPrefixLen)
+	if err != nil {
+		return err
+	}
+	from, to := s.findContracts(addrBytes)
+	k := make([]byte, keyPrefixLen+common.IncarnationLength+common.HashLength)
+	for i := from; i < to; i++ {
+		if err := s.walkContract(i, k, f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// findInStorageChangeSetV2 looks the key up in the contracts with the address, only in the incarnation
+// if it is not nil, otherwise in the first incarnation, which changed the key
+func findInStorageChangeSetV2(b []byte, keyPrefixLen int, addrBytes []byte, incarnation *uint64, keyBytes []byte) ([]byte, error) {
+	s, err := parseStorageV2(b, keyPrefixLen)
+	if err != nil {
+		return nil, err
+	}
+	from, to := s.findContracts(addrBytes)
+	for i := from; i < to; i++ {
+		if incarnation != nil && s.incarnation(i) != *incarnation {
+			continue
+		}
+		v, ok, err := s.find(i, keyBytes)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return v, nil
+		}
+	}
+	return nil, ErrNotFound
+}
@@ -0,0 +1,137 @@
+package changeset
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStorageV2(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		cs       func() *ChangeSet
+		keyGen   func(common.Address, uint64, common.Hash) []byte
+		prefix   int
+		encodeV1 encodeFunc
+		encodeV2 encodeFunc
+		decode   decodeFunc
+		bytes    func([]byte) csStorageBytes
+	}{
+		{"hashed", NewStorageChangeSet, hashKeyGenerator, common.HashLength, EncodeStorageV1, EncodeStorageV2, DecodeStorage, getHashedBytes},
+		{"plain", NewStorageChangeSetPlain, plainKeyGenerator, common.AddressLength, EncodeStoragePlainV1, EncodeStoragePlainV2, DecodeStoragePlain, getPlainBytes},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			cs := tc.cs()
+			// The second contract has two incarnations, the values are of all the widths
+			for i := 0; i < 3; i++ {
+				for j := 0; j < 20*(i+1); j++ {
+					require.NoError(t, cs.Add(getTestDataAtIndex(i, j, defaultIncarnation, tc.keyGen), bytes.Repeat([]byte{byte(j)}, j%33)))
+				}
+			}
+			for j := 0; j < 3000; j++ {
+				require.NoError(t, cs.Add(getTestDataAtIndex(1, j, defaultIncarnation+1, tc.keyGen), hashValueGenerator(j)))
+			}
+
+			v1, err := tc.encodeV1(cs)
+			require.NoError(t, err)
+			v2, err := tc.encodeV2(cs)
+			require.NoError(t, err)
+			assert.False(t, IsStorageV2(v1))
+			assert.True(t, IsStorageV2(v2))
+			assert.Equal(t, Len(v1), Len(v2))
+
+			// Both layouts are read the same way
+			cs1, err := tc.decode(v1)
+			require.NoError(t, err)
+			cs2, err := tc.decode(v2)
+			require.NoError(t, err)
+			assert.Equal(t, cs1.Changes, cs2.Changes)
+			var walked []Change
+			require.NoError(t, tc.bytes(v2).Walk(func(k, v []byte) error {
+				walked = append(walked, Change{common.CopyBytes(k), v})
+				return nil
+			}))
+			assert.Equal(t, cs1.Changes, walked)
+			for _, change := range cs.Changes {
+				v, err := tc.bytes(v2).Find(change.Key)
+				require.NoError(t, err)
+				assert.Equal(t, change.Value, v)
+			}
+			missing := getTestDataAtIndex(5, 0, defaultIncarnation, tc.keyGen)
+			_, err = tc.bytes(v2).Find(missing)
+			assert.Equal(t, ErrNotFound, err)
+			_, err = tc.bytes(v2).FindWithoutIncarnation(missing[:tc.prefix], missing[tc.prefix+common.IncarnationLength:])
+			assert.Equal(t, ErrNotFound, err)
+
+			// The changes of one contract, of both incarnations
+			contract := getTestDataAtIndex(1, 0, defaultIncarnation, tc.keyGen)[:tc.prefix]
+			for _, b := range [][]byte{v1, v2} {
+				var n int
+				walkContract := func(k, v []byte) error {
+					assert.Equal(t, contract, k[:tc.prefix])
+					n++
+					return nil
+				}
+				if tc.prefix == common.HashLength {
+					require.NoError(t, StorageChangeSetBytes(b).WalkContract(contract, walkContract))
+				} else {
+					require.NoError(t, StorageChangeSetPlainBytes(b).WalkContract(contract, walkContract))
+				}
+				assert.Equal(t, 40+3000, n)
+			}
+		})
+	}
+}
+
+func BenchmarkStorageV2(b *testing.B) {
+	// 100 contracts with 20 changes each, the changes of one contract are looked up
+	cs := NewStorageChangeSet()
+	for i := 0; i < 100; i++ {
+		for j := 0; j < 20; j++ {
+			if err := cs.Add(getTestDataAtIndex(i, j, defaultIncarnation, hashKeyGenerator), hashValueGenerator(j)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+	change := cs.Changes[len(cs.Changes)/2]
+	contract := change.Key[:common.HashLength]
+	for _, layout := range []struct {
+		name   string
+		encode encodeFunc
+	}{
+		{"v1", EncodeStorageV1},
+		{"v2", EncodeStorageV2},
+	} {
+		enc, err := layout.encode(cs)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Run(layout.name+"/encode", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := layout.encode(cs); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run(layout.name+"/find", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := StorageChangeSetBytes(enc).Find(change.Key); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run(layout.name+"/walk contract", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if err := StorageChangeSetBytes(enc).WalkContract(contract, func(k, v []byte) error {
+					return nil
+				}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
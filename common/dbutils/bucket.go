@@ -37,6 +37,11 @@ var (
 	//value - storage value(common.hash)
 	CurrentStateBucket = []byte("CST")
 
+	// CurrentStateAccountsBucket and CurrentStateStorageBucket keep the accounts and the storage items of
+	// CurrentStateBucket in the split state schema (see SplitStateKey), the keys and the values are the same
+	CurrentStateAccountsBucket = []byte("CSTA")
+	CurrentStateStorageBucket  = []byte("CSTS")
+
 	//current
 	//key - key + encoded timestamp(block number)
 	//value - account for storage(old/original value)
@@ -66,6 +71,11 @@ var (
 	//value - incarnation of account when it was last deleted
 	IncarnationMapBucket = []byte("incarnationMap")
 
+	// IncarnationHistoryBucket - blocks at which accounts were created and destroyed (incarnation boundaries)
+	//key - addrHash + block number (8 bytes, big endian) + 1 byte (0 - destroyed, 1 - created)
+	//value - incarnation of the created or destroyed account
+	IncarnationHistoryBucket = []byte("hIN")
+
 	//AccountChangeSetBucket keeps changesets of accounts
 	// key - encoded timestamp(block number)
 	// value - encoded ChangeSet{k - addrHash v - account(encoded).
@@ -76,12 +86,53 @@ var (
 	// value - encoded ChangeSet{k - compositeKey(for storage) v - originalValue(common.Hash)}.
 	StorageChangeSetBucket = []byte("SCS")
 
+	// AccountChangeSetEpochBucket keeps changesets of accounts of old blocks, merged into epochs (see ethdb.CompactChangeSets)
+	// key - encoded timestamp(epoch number)
+	// value - changesets of the blocks of the epoch, see changeset.EncodeEpoch
+	AccountChangeSetEpochBucket = []byte("ACSE")
+
+	// StorageChangeSetEpochBucket keeps changesets of storage of old blocks, merged into epochs (see ethdb.CompactChangeSets)
+	// key - encoded timestamp(epoch number)
+	// value - changesets of the blocks of the epoch, see changeset.EncodeEpoch
+	StorageChangeSetEpochBucket = []byte("SCSE")
+
+	// ReceiptsDictionaryBucket - dictionaries of the log addresses and topics of the epochs (changeset.EpochSize blocks),
+	// with which the receipts of the old blocks are stored (see rawdb.CompactReceipts)
+	// key - epoch number (uint64 big endian)
+	// value - types.ReceiptsDictionary
+	ReceiptsDictionaryBucket = []byte("RDIC")
+
+	// LogTopicIndexBucket - blocks, logs of which contain the topic (see rawdb.WriteLogIndex)
+	// key - topic + chunk suffix, the same as in AccountsHistoryBucket
+	// value - dbutils.HistoryIndexBytes of the block numbers
+	LogTopicIndexBucket = []byte("LTI")
+
+	// LogAddressIndexBucket - blocks, logs of which were emitted by the address (see rawdb.WriteLogIndex)
+	// key - address + chunk suffix
+	// value - dbutils.HistoryIndexBytes of the block numbers
+	LogAddressIndexBucket = []byte("LAI")
+
+	// TxAddressIndexBucket - blocks with the transactions sent by or to the address (see rawdb.WriteTxAddressIndex)
+	// key - address + chunk suffix
+	// value - dbutils.HistoryIndexBytes of the block numbers
+	TxAddressIndexBucket = []byte("TAI")
+
+	// BlockWitnessBucket - the witnesses of the blocks, served by eth_getWitness (see rawdb.WriteBlockWitness)
+	// key - block number (uint64 big endian) + block hash
+	// value - serialised trie.Witness
+	BlockWitnessBucket = []byte("BWIT")
+
 	// some_prefix_of(hash_of_address_of_account) => hash_of_subtrie
 	IntermediateTrieHashBucket = []byte("iTh")
 
 	// some_prefix_of(hash_of_address_of_account) => estimated_number_of_witness_bytes
 	IntermediateTrieWitnessLenBucket = []byte("iTw")
 
+	// BucketCodecsBucket - the codecs, with which the values of the buckets are compressed (see BucketCodecs)
+	//key - bucket name
+	//value - codec (1 byte)
+	BucketCodecsBucket = []byte("codecs")
+
 	// DatabaseInfoBucket is used to store information about data layout.
 	DatabaseInfoBucket = []byte("DBINFO")
 
@@ -140,23 +191,126 @@ var (
 	StorageModeThinHistory = []byte("smThinHistory")
 	//StorageModeIntermediateTrieHash - does IntermediateTrieHash feature enabled
 	StorageModeIntermediateTrieHash = []byte("smIntermediateTrieHash")
+	//StateSchemaVersionKey - layout of the current state: hashed keys or plain addresses, see rawdb.ReadStateSchemaVersion
+	StateSchemaVersionKey = []byte("stateSchemaVersion")
+
+	// HistoryJournalBucket - write-ahead journal of the history writes of SplitDatabase, kept in the state database
+	//key - index of the record (4 bytes, big endian)
+	//value - bucket, key and value of the history record, see ethdb.encodeJournalRecord
+	HistoryJournalBucket = []byte("WAL")
 
 	// Progress of sync stages
 	SyncStageProgress = []byte("SSP")
 	// Position to where to unwind sync stages
 	SyncStageUnwind = []byte("SSU")
+
+	// DatabaseStatsBucket - periodic samples of the bucket sizes, see ethdb.StatsSampler
+	//key - length of the bucket name (1 byte) + bucket name + sampling time (unix seconds, 8 bytes)
+	//value - number of keys (8 bytes) + allocated bytes (8 bytes)
+	DatabaseStatsBucket = []byte("DBSTATS")
+
+	// BucketChecksumsBucket - checksums of the key ranges of the buckets, maintained by the writes (see ethdb.RangeChecksum)
+	//key - length of the bucket name (1 byte) + bucket name + first byte of the keys of the range
+	//value - sum of keccak256 of the records modulo 2^256 (32 bytes) + number of the records (8 bytes)
+	BucketChecksumsBucket = []byte("DBCS")
+
+	// ChangeSetSummaryBucket - the number of the changes and the size of the changesets of the block (see ethdb.ChangeSetSummary)
+	//key - block number (8 bytes, big endian)
+	//value - account changes (4 bytes) + storage changes (4 bytes) + account changeset size (4 bytes) + storage changeset size (4 bytes)
+	ChangeSetSummaryBucket = []byte("CSSUM")
+
+	// CodeAccessBucket - the last day, on which the contract code was read, for the cold storage tier of the code (see state.ColdCodeTier)
+	//key - code hash
+	//value - unix time of the day (8 bytes)
+	CodeAccessBucket = []byte("CODEAT")
+
+	// UnwindProgressKey tracks the state unwind split into several commits (see state.TrieDbState.UnwindToBatched)
+	//value - target block of the unwind (8 bytes) + block reached by the last committed step (8 bytes)
+	UnwindProgressKey = []byte("UnwindProgress")
+
+	// AccountHistoryPrunedToKey and StorageHistoryPrunedToKey (in DatabaseInfoBucket) - the last block, changesets and history
+	// of which have been deleted by the history retention policy (see ethdb.PruneHistory)
+	//value - block number (8 bytes, big endian)
+	AccountHistoryPrunedToKey = []byte("AccountHistoryPrunedTo")
+	StorageHistoryPrunedToKey = []byte("StorageHistoryPrunedTo")
+
+	// FlatStateSyncProgressKey tracks the download of the state by ranges (see downloader.FlatStateSync)
+	//value - state root (32 bytes) + first hashed key of the next range (32 bytes, absent when the download is complete)
+	FlatStateSyncProgressKey = []byte("FlatStateSync")
+
+	// StateRangeProgressBucket - prefix ranges of the state, which have been received and verified (see downloader.StateRangeProgress)
+	//key - "r" for the state root, "a" + nibbles of the account prefix, "s" + address hash + nibbles of the storage prefix
+	//value - state root for "r", otherwise 1 byte
+	StateRangeProgressBucket = []byte("SRP")
+
+	// RetainListSpillBucket - read/change sets of the current block, which did not fit into the memory budget (see trie.RetainListBuilder)
+	//key - 0 + account hash, or 1 + account hash + storage key hash
+	//value - 1 byte
+	RetainListSpillBucket = []byte("RLS")
+
+	// HistoryIndexJournalKey (in DatabaseInfoBucket) - the first block, the updates of the history indexes of which
+	// are buffered and not written yet (see state.HistoryIndexCoalescer)
+	//value - block number (8 bytes, big endian)
+	HistoryIndexJournalKey = []byte("HistoryIndexJournal")
+
+	// SplitStateKey (in DatabaseInfoBucket) - present if the entries of CurrentStateBucket are kept in
+	// CurrentStateAccountsBucket and CurrentStateStorageBucket (see ethdb.IsSplitState)
+	//value - 1 byte
+	SplitStateKey = []byte("SplitState")
+
+	// HistoryIndexFilterKey (in DatabaseInfoBucket) - the accounts, the history of which is indexed, if not all of them
+	// (see ethdb.HistoryIndexFilter)
+	//value - 1 byte (0 - allowlist, 1 - denylist) + sorted account hashes (32 bytes each)
+	HistoryIndexFilterKey = []byte("HistoryIndexFilter")
+
+	// StorageChangeSetV2Key (in DatabaseInfoBucket) - present if the storage changesets are converted to the v2 layout
+	// and written in it (see changeset.StorageV2)
+	//value - 1 byte
+	StorageChangeSetV2Key = []byte("StorageChangeSetV2")
+
+	// StorageChangeSetV2ProgressKey (in DatabaseInfoBucket) tracks the conversion of the storage changesets
+	// to the v2 layout (see migrations.ConvertStorageChangeSetsToV2)
+	//value - index of the converted bucket (1 byte) + the key, from which the conversion resumes
+	StorageChangeSetV2ProgressKey = []byte("StorageChangeSetV2Progress")
 )
 
+// ColdBuckets - history and changesets. They are appended once per block and rarely read,
+// so can be stored on a slower disk (see --datadir.history)
+var ColdBuckets = [][]byte{
+	AccountsHistoryBucket,
+	StorageHistoryBucket,
+	IncarnationHistoryBucket,
+	AccountChangeSetBucket,
+	StorageChangeSetBucket,
+	AccountChangeSetEpochBucket,
+	StorageChangeSetEpochBucket,
+	PlainAccountChangeSetBucket,
+	PlainStorageChangeSetBucket,
+}
+
 var Buckets = [][]byte{
 	CurrentStateBucket,
+	CurrentStateAccountsBucket,
+	CurrentStateStorageBucket,
 	AccountsHistoryBucket,
 	StorageHistoryBucket,
 	CodeBucket,
 	ContractCodeBucket,
+	CodeAccessBucket,
+	IncarnationHistoryBucket,
 	AccountChangeSetBucket,
 	StorageChangeSetBucket,
+	AccountChangeSetEpochBucket,
+	StorageChangeSetEpochBucket,
+	ChangeSetSummaryBucket,
+	ReceiptsDictionaryBucket,
+	LogTopicIndexBucket,
+	LogAddressIndexBucket,
+	TxAddressIndexBucket,
+	BlockWitnessBucket,
 	IntermediateTrieHashBucket,
 	IntermediateTrieWitnessLenBucket,
+	BucketCodecsBucket,
 	DatabaseVerisionKey,
 	HeadHeaderKey,
 	HeadBlockKey,
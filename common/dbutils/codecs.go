@@ -0,0 +1,81 @@
+package dbutils
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Codec is the compression of the values of a bucket, see BucketCodecs
+type Codec byte
+
+const (
+	IdentityCodec Codec = iota
+	SnappyCodec
+	GzipCodec
+	ZstdCodec
+)
+
+func (c Codec) String() string {
+	switch c {
+	case IdentityCodec:
+		return "identity"
+	case SnappyCodec:
+		return "snappy"
+	case GzipCodec:
+		return "gzip"
+	case ZstdCodec:
+		return "zstd"
+	default:
+		return fmt.Sprintf("codec(%d)", byte(c))
+	}
+}
+
+// BucketCodecs - the codecs, with which the values of the buckets are compressed in the new databases. Empty
+// by default, the compression is enabled with ParseBucketCodecs (the --db.codecs flag). The codec of a bucket
+// is recorded in BucketCodecsBucket when the database is opened for writing and the bucket is empty, the buckets
+// with the data written before keep IdentityCodec. The values are always read with the recorded codec, so changing
+// this map only affects the new databases. The codecs are applied by the KV of every backend
+var BucketCodecs = map[string]Codec{}
+
+// CompressibleBuckets - the buckets, which can be compressed, by the names used in ParseBucketCodecs
+var CompressibleBuckets = map[string][]byte{
+	"bodies":             BlockBodyPrefix,
+	"receipts":           BlockReceiptsPrefix,
+	"account-changesets": AccountChangeSetBucket,
+	"storage-changesets": StorageChangeSetBucket,
+}
+
+// ParseBucketCodecs parses the comma separated list of the bucket=codec pairs, e.g. "bodies=snappy,receipts=zstd",
+// the buckets are named as in CompressibleBuckets
+func ParseBucketCodecs(spec string) (map[string]Codec, error) {
+	codecs := make(map[string]Codec)
+	for _, pair := range strings.Split(spec, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		parts := strings.Split(pair, "=")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid bucket codec %q, expected bucket=codec", pair)
+		}
+		bucket, ok := CompressibleBuckets[strings.TrimSpace(parts[0])]
+		if !ok {
+			return nil, fmt.Errorf("bucket %q can't be compressed", parts[0])
+		}
+		codec, err := parseCodec(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return nil, err
+		}
+		codecs[string(bucket)] = codec
+	}
+	return codecs, nil
+}
+
+func parseCodec(name string) (Codec, error) {
+	for _, c := range []Codec{IdentityCodec, SnappyCodec, GzipCodec, ZstdCodec} {
+		if c.String() == name {
+			return c, nil
+		}
+	}
+	return IdentityCodec, fmt.Errorf("unknown codec %q", name)
+}
@@ -0,0 +1,27 @@
+package dbutils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseBucketCodecs(t *testing.T) {
+	codecs, err := ParseBucketCodecs("bodies=snappy, receipts=zstd,,storage-changesets=identity")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]Codec{
+		string(BlockBodyPrefix):        SnappyCodec,
+		string(BlockReceiptsPrefix):    ZstdCodec,
+		string(StorageChangeSetBucket): IdentityCodec,
+	}, codecs)
+
+	codecs, err = ParseBucketCodecs("")
+	require.NoError(t, err)
+	assert.Empty(t, codecs)
+
+	for _, invalid := range []string{"bodies", "headers=snappy", "bodies=lz4", "bodies=snappy=gzip"} {
+		_, err = ParseBucketCodecs(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
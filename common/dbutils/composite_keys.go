@@ -131,11 +131,23 @@ func PlainParseCompositeStorageKey(compositeKey []byte) (common.Address, uint64,
 	return addr, inc, key
 }
 
+// EncodeIncarnation writes the incarnation into the first common.IncarnationLength bytes of buf.
+// Incarnations are stored inverted, so that the storage of the latest incarnation of a contract
+// goes first, and 0 (non-contract) is encoded as 0xff..ff, after all the real incarnations
+func EncodeIncarnation(buf []byte, incarnation uint64) {
+	binary.BigEndian.PutUint64(buf, ^incarnation)
+}
+
+// DecodeIncarnation reads the incarnation written by EncodeIncarnation
+func DecodeIncarnation(buf []byte) uint64 {
+	return ^binary.BigEndian.Uint64(buf)
+}
+
 // AddrHash + incarnation + StorageHashPrefix
 func GenerateCompositeStoragePrefix(addressHash []byte, incarnation uint64, storageHashPrefix []byte) []byte {
 	key := make([]byte, common.HashLength+8+len(storageHashPrefix))
 	copy(key, addressHash)
-	binary.BigEndian.PutUint64(key[common.HashLength:], ^incarnation)
+	EncodeIncarnation(key[common.HashLength:], incarnation)
 	copy(key[common.HashLength+8:], storageHashPrefix)
 	return key
 }
@@ -144,7 +156,7 @@ func GenerateCompositeStoragePrefix(addressHash []byte, incarnation uint64, stor
 func GenerateStoragePrefix(addressHash []byte, incarnation uint64) []byte {
 	prefix := make([]byte, common.HashLength+8)
 	copy(prefix, addressHash)
-	binary.BigEndian.PutUint64(prefix[common.HashLength:], ^incarnation)
+	EncodeIncarnation(prefix[common.HashLength:], incarnation)
 	return prefix
 }
 
@@ -152,21 +164,21 @@ func GenerateStoragePrefix(addressHash []byte, incarnation uint64) []byte {
 func PlainGenerateStoragePrefix(address common.Address, incarnation uint64) []byte {
 	prefix := make([]byte, common.AddressLength+8)
 	copy(prefix, address[:])
-	binary.BigEndian.PutUint64(prefix[common.AddressLength:], ^incarnation)
+	EncodeIncarnation(prefix[common.AddressLength:], incarnation)
 	return prefix
 }
 
 func PlainParseStoragePrefix(prefix []byte) (common.Address, uint64) {
 	var addr common.Address
 	copy(addr[:], prefix[:common.AddressLength])
-	inc := ^binary.BigEndian.Uint64(prefix[common.AddressLength : common.AddressLength+common.IncarnationLength])
+	inc := DecodeIncarnation(prefix[common.AddressLength : common.AddressLength+common.IncarnationLength])
 	return addr, inc
 }
 
 func ParseStoragePrefix(prefix []byte) (common.Hash, uint64) {
 	var addrHash common.Hash
 	copy(addrHash[:], prefix[:common.HashLength])
-	inc := ^binary.BigEndian.Uint64(prefix[common.HashLength : common.HashLength+common.IncarnationLength])
+	inc := DecodeIncarnation(prefix[common.HashLength : common.HashLength+common.IncarnationLength])
 	return addrHash, inc
 }
 
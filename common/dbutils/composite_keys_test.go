@@ -1,6 +1,7 @@
 package dbutils
 
 import (
+	"bytes"
 	"testing"
 
 	"github.com/ledgerwatch/turbo-geth/common"
@@ -94,3 +95,18 @@ func TestParseCompositeStorageKey(t *testing.T) {
 	assert.Equal(t, expectedIncarnation, incarnation, "incarnation should be extracted")
 	assert.Equal(t, expectedKey, key, "key should be extracted")
 }
+
+func TestIncarnationEncoding(t *testing.T) {
+	var buf [common.IncarnationLength]byte
+	for _, inc := range []uint64{0, 1, 2, 1 << 40} {
+		EncodeIncarnation(buf[:], inc)
+		assert.Equal(t, inc, DecodeIncarnation(buf[:]))
+	}
+
+	// Storage of the later incarnations goes first
+	addrHash := common.HexToHash("0x1234")
+	assert.Equal(t, -1, bytes.Compare(GenerateStoragePrefix(addrHash[:], 2), GenerateStoragePrefix(addrHash[:], 1)))
+	h, inc := ParseStoragePrefix(GenerateStoragePrefix(addrHash[:], 7))
+	assert.Equal(t, addrHash, h)
+	assert.Equal(t, uint64(7), inc)
+}
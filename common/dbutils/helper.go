@@ -42,6 +42,17 @@ func ChangeSetByIndexBucket(b []byte) []byte {
 	panic("wrong bucket")
 }
 
+// ChangeSetEpochBucket returns the bucket of the epochs of the changeset bucket
+func ChangeSetEpochBucket(b []byte) []byte {
+	if bytes.Equal(b, AccountChangeSetBucket) {
+		return AccountChangeSetEpochBucket
+	}
+	if bytes.Equal(b, StorageChangeSetBucket) {
+		return StorageChangeSetEpochBucket
+	}
+	panic("wrong bucket")
+}
+
 // Cmp - like bytes.Compare, but nil - means "bucket over" and has highest order.
 func Cmp(k1, k2 []byte) int {
 	if k1 == nil && k2 == nil {
@@ -162,6 +162,11 @@ func CurrentChunkKey(key []byte) []byte {
 func IndexChunkKey(key []byte, blockNumber uint64) []byte {
 	var blockNumBytes []byte // make([]byte, len(key)+8)
 	switch len(key) {
+	case common.AddressLength:
+		// address of the log index
+		blockNumBytes = make([]byte, common.AddressLength+8)
+		copy(blockNumBytes, key)
+		binary.BigEndian.PutUint64(blockNumBytes[common.AddressLength:], blockNumber)
 	case common.HashLength:
 		blockNumBytes = make([]byte, common.HashLength+8)
 		copy(blockNumBytes, key)
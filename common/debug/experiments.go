@@ -54,14 +54,21 @@ func IsBlockCompressionEnabled() bool {
 	return compressBlocks
 }
 
-var (
-	trackWitnessSize       bool
-	getTrackWitnessSizeLen sync.Once
-)
+// atomic: 1 if the witness lengths are tracked
+var trackWitnessSize uint32
 
+// IsTrackWitnessSizeEnabled tells whether the witness lengths of the sub-tries are kept in
+// dbutils.IntermediateTrieWitnessLenBucket next to the intermediate hashes. Disabled by default,
+// the node enables it with the --trie-witness-len flag
 func IsTrackWitnessSizeEnabled() bool {
-	getTrackWitnessSizeLen.Do(func() {
-		_, trackWitnessSize = os.LookupEnv("TRACK_WITNESS_SIZE")
-	})
-	return trackWitnessSize
+	return atomic.LoadUint32(&trackWitnessSize) == 1
+}
+
+// OverrideTrackWitnessSize enables or disables the tracking of the witness lengths
+func OverrideTrackWitnessSize(val bool) {
+	if val {
+		atomic.StoreUint32(&trackWitnessSize, 1)
+	} else {
+		atomic.StoreUint32(&trackWitnessSize, 0)
+	}
 }
@@ -485,6 +485,33 @@ func (c *Clique) verifySeal(chain consensus.ChainReader, header *types.Header, p
 	return nil
 }
 
+// ReadWitnessState implements consensus.WitnessStateReader. The seal of the header is verified against
+// the voting snapshot of the parent, which is loaded from the checkpoint or recomputed from the headers
+// the same way verifySeal does it. The snapshot itself is not a part of the state, so the accounts of its
+// signers and of the address voted on by the header are read, which puts them into the block witness
+// for the stateless verification of the signer and the vote.
+func (c *Clique) ReadWitnessState(chain consensus.ChainReader, header *types.Header, reader state.StateReader) error {
+	number := header.Number.Uint64()
+	if number == 0 {
+		return errUnknownBlock
+	}
+	snap, err := c.snapshot(chain, number-1, header.ParentHash, nil)
+	if err != nil {
+		return err
+	}
+	for _, signer := range snap.signers() {
+		if _, err := reader.ReadAccountData(signer); err != nil {
+			return err
+		}
+	}
+	if header.Coinbase != (common.Address{}) {
+		if _, err := reader.ReadAccountData(header.Coinbase); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // Prepare implements consensus.Engine, preparing all the consensus fields of the
 // header for running the transactions on top.
 func (c *Clique) Prepare(chain consensus.ChainReader, header *types.Header) error {
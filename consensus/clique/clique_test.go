@@ -19,15 +19,19 @@ package clique
 import (
 	"context"
 	"math/big"
+	"sort"
 	"testing"
 
 	"github.com/ledgerwatch/turbo-geth/common"
 	"github.com/ledgerwatch/turbo-geth/core"
+	"github.com/ledgerwatch/turbo-geth/core/state"
 	"github.com/ledgerwatch/turbo-geth/core/types"
 	"github.com/ledgerwatch/turbo-geth/core/vm"
 	"github.com/ledgerwatch/turbo-geth/crypto"
 	"github.com/ledgerwatch/turbo-geth/ethdb"
 	"github.com/ledgerwatch/turbo-geth/params"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 // This test case is a repro of an annoying bug that took us forever to catch.
@@ -114,3 +118,91 @@ func TestReimportMirroredState(t *testing.T) {
 		t.Fatalf("chain head mismatch: have %d, want %d", head, 3)
 	}
 }
+
+func TestWitnessStateReader(t *testing.T) {
+	// Initialize a Clique chain with two signers, one of them seals the block. The block transfers between
+	// the accounts, which are not read by the header verification
+	var (
+		db       = ethdb.NewMemDatabase()
+		key, _   = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		addr     = crypto.PubkeyToAddress(key.PublicKey)
+		idle     = common.HexToAddress("0x0000000000000000000000000000000000000100")
+		voted    = common.HexToAddress("0x0000000000000000000000000000000000001000")
+		txKey, _ = crypto.HexToECDSA("8a1f9a8f95be41cd7ccb6168179afb4504aefe388d1e14474d32c45c72ce7b7a")
+		sender   = crypto.PubkeyToAddress(txKey.PublicKey)
+		engine   = New(params.AllCliqueProtocolChanges.Clique, db)
+		signer   = new(types.HomesteadSigner)
+		signers  = []common.Address{addr, idle}
+	)
+	genspec := &core.Genesis{
+		ExtraData: make([]byte, extraVanity+len(signers)*common.AddressLength+extraSeal),
+		Alloc: map[common.Address]core.GenesisAccount{
+			addr:   {Balance: big.NewInt(1)},
+			idle:   {Balance: big.NewInt(2)},
+			voted:  {Balance: big.NewInt(3)},
+			sender: {Balance: big.NewInt(1000000)},
+		},
+	}
+	others := make([]common.Address, 64)
+	for i := range others {
+		others[i] = common.BigToAddress(big.NewInt(int64(0x2000 + i)))
+		genspec.Alloc[others[i]] = core.GenesisAccount{Balance: big.NewInt(4)}
+	}
+	sort.Sort(signersAscending(signers))
+	for i, s := range signers {
+		copy(genspec.ExtraData[extraVanity+i*common.AddressLength:], s[:])
+	}
+	genesis := genspec.MustCommit(db)
+
+	chain, err := core.NewBlockChain(db, nil, params.AllCliqueProtocolChanges, engine, vm.Config{}, nil, nil)
+	require.NoError(t, err)
+	defer chain.Stop()
+
+	blocks, _ := core.GenerateChain(context.Background(), params.AllCliqueProtocolChanges, genesis, engine, db.MemCopy(), 1, func(i int, block *core.BlockGen) {
+		// The block 1 is in turn for the second signer
+		if signers[1] == addr {
+			block.SetDifficulty(diffInTurn)
+		} else {
+			block.SetDifficulty(diffNoTurn)
+		}
+		block.SetCoinbase(voted)
+		tx, err := types.SignTx(types.NewTransaction(block.TxNonce(sender), others[0], big.NewInt(1), params.TxGas, nil, nil), signer, txKey)
+		if err != nil {
+			panic(err)
+		}
+		block.AddTxWithChain(chain, tx)
+	})
+	header := blocks[0].Header()
+	header.Extra = make([]byte, extraVanity+extraSeal)
+	sig, err := crypto.Sign(SealHash(header).Bytes(), key)
+	require.NoError(t, err)
+	copy(header.Extra[len(header.Extra)-extraSeal:], sig)
+	require.NoError(t, engine.VerifyHeader(chain, header, true))
+	block := blocks[0].WithSeal(header)
+
+	tds := state.NewTrieDbState(genesis.Root(), db, genesis.NumberU64())
+	tds.SetResolveReads(true)
+	_, _, _, root, err := core.NewStateProcessor(params.AllCliqueProtocolChanges, chain, engine).PreProcess(block, state.New(tds), tds, vm.Config{})
+	require.NoError(t, err)
+	require.Equal(t, block.Root(), root)
+	w, err := tds.ExtractWitness(false, false /* is binary */)
+	require.NoError(t, err)
+	s, err := state.NewStateless(genesis.Root(), w, genesis.NumberU64(), false, false /* is binary */)
+	require.NoError(t, err)
+
+	// The signer, which doesn't seal the block, and the voted address are in the witness
+	acc, err := s.ReadAccountData(idle)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), acc.Balance.Uint64())
+	acc, err = s.ReadAccountData(voted)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(3), acc.Balance.Uint64())
+	// The accounts the block doesn't touch are not
+	var missing int
+	for _, other := range others[1:] {
+		if _, err := s.ReadAccountData(other); err != nil {
+			missing++
+		}
+	}
+	assert.NotZero(t, missing)
+}
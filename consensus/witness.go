@@ -0,0 +1,25 @@
+package consensus
+
+import (
+	"github.com/ledgerwatch/turbo-geth/core/state"
+	"github.com/ledgerwatch/turbo-geth/core/types"
+)
+
+// WitnessStateReader is implemented by the engines whose header verification depends on the state
+// (e.g. signer snapshots or validator sets kept in the contract storage on clique and aura chains).
+// The engine performs the same reads as the verification of the header does, so that they are
+// included in the block witness and the stateless verification of the block has all the data it needs.
+type WitnessStateReader interface {
+	// ReadWitnessState reads the parts of the state the verification of the header depends on.
+	// The reader observes the state before the execution of the block.
+	ReadWitnessState(chain ChainReader, header *types.Header, reader state.StateReader) error
+}
+
+// ReadWitnessState lets the engine read the state for the block witness, if the engine implements WitnessStateReader.
+// For all other engines it does nothing
+func ReadWitnessState(engine Engine, chain ChainReader, header *types.Header, reader state.StateReader) error {
+	if wr, ok := engine.(WitnessStateReader); ok {
+		return wr.ReadWitnessState(chain, header, reader)
+	}
+	return nil
+}
@@ -127,6 +127,13 @@ type CacheConfig struct {
 	ArchiveSyncInterval uint64
 	DownloadOnly        bool
 	NoHistory           bool
+	FlatHashing         bool             // Compute state roots from the database, without the trie cache (requires commit after every block)
+	PinnedStorage       []common.Address // Contracts which storage tries are always fully resolved and never evicted
+	HotAccounts         []common.Address // Accounts which are preloaded and never evicted from the account trie, see state.TrieDbState.SetHotAccounts
+	HashingWorkers      int              // Number of goroutines hashing the state trie concurrently (0 = serial hashing)
+	RetainListBudget    uint64           // Memory limit (MB) of the read/change sets of a block kept for the witnesses, above it they are spilled to the database (0 = unlimited)
+	HistoryCommitWindow uint64           // Number of blocks, the updates of the history indexes of which are written at once (0 = every block)
+	AsyncCommit         bool             // Commit the inserted blocks in the background, while the next blocks are processed (not with FlatHashing)
 }
 
 // BlockChain represents the canonical chain given a database with a genesis
@@ -148,25 +155,29 @@ type BlockChain struct {
 	cacheConfig *CacheConfig        // Cache configuration for pruning
 
 	db            ethdb.DbWithPendingMutations // Low level persistent database to store final content in
+	spillDb       ethdb.Database               // Database under the batch of db, the retain lists above the budget are spilled there
 	triegc        *prque.Prque                 // Priority queue mapping block numbers to tries to gc
 	gcproc        time.Duration                // Accumulates canonical block processing for trie dumping
 	txLookupLimit uint64
 
-	hc            *HeaderChain
-	rmLogsFeed    event.Feed
-	chainFeed     event.Feed
-	chainSideFeed event.Feed
-	chainHeadFeed event.Feed
-	logsFeed      event.Feed
-	blockProcFeed event.Feed
-	scope         event.SubscriptionScope
-	genesisBlock  *types.Block
+	hc               *HeaderChain
+	rmLogsFeed       event.Feed
+	chainFeed        event.Feed
+	chainSideFeed    event.Feed
+	chainHeadFeed    event.Feed
+	logsFeed         event.Feed
+	blockProcFeed    event.Feed
+	stateChangesFeed event.Feed
+	scope            event.SubscriptionScope
+	genesisBlock     *types.Block
 
 	chainmu sync.RWMutex // blockchain insertion lock
 
-	currentBlock     atomic.Value // Current head of the block chain
-	committedBlock   atomic.Value // Committed head of the block chain
-	currentFastBlock atomic.Value // Current head of the fast-sync chain (may be above the block chain!)
+	currentBlock       atomic.Value // Current head of the block chain
+	committedBlock     atomic.Value // Committed head of the block chain
+	pendingBlock       *types.Block // Head of the block chain committed in the background, see settleCommit
+	pendingCommitStart time.Time    // Start of the commit running in the background
+	currentFastBlock   atomic.Value // Current head of the fast-sync chain (may be above the block chain!)
 
 	trieDbState   *state.TrieDbState
 	bodyCache     *lru.Cache // Cache for the most recent block bodies
@@ -199,6 +210,8 @@ type BlockChain struct {
 	enablePreimages     bool // Whether we store preimages into the database
 	resolveReads        bool
 	pruner              Pruner
+	pinnedStorage       []common.Address // Contracts which storage tries are pinned in the trie cache, see state.TrieDbState.PinStorage
+	unhashedState       bool             // The state is kept under the plain addresses only (see rawdb.UnhashedStateSchema), there is no trie
 }
 
 // NewBlockChain returns a fully initialised block chain using information
@@ -233,6 +246,7 @@ func NewBlockChain(db ethdb.Database, cacheConfig *CacheConfig, chainConfig *par
 		chainConfig:         chainConfig,
 		cacheConfig:         cacheConfig,
 		db:                  cdb,
+		spillDb:             db,
 		triegc:              prque.New(nil),
 		quit:                make(chan struct{}),
 		shouldPreserve:      shouldPreserve,
@@ -248,12 +262,17 @@ func NewBlockChain(db ethdb.Database, cacheConfig *CacheConfig, chainConfig *par
 		enableTxLookupIndex: true,
 		enableReceipts:      false,
 		enablePreimages:     true,
+		pinnedStorage:       append([]common.Address{}, cacheConfig.PinnedStorage...),
 	}
 	bc.validator = NewBlockValidator(chainConfig, bc, engine)
 	bc.prefetcher = newStatePrefetcher(chainConfig, bc, engine)
 	bc.processor = NewStateProcessor(chainConfig, bc, engine)
 
-	var err error
+	schema, err := rawdb.ReadStateSchemaVersion(db)
+	if err != nil {
+		return nil, err
+	}
+	bc.unhashedState = schema == rawdb.UnhashedStateSchema
 	bc.hc, err = NewHeaderChain(cdb, chainConfig, engine, bc.getProcInterrupt)
 	if err != nil {
 		return nil, err
@@ -357,7 +376,22 @@ func (bc *BlockChain) EnablePreimages(ep bool) {
 }
 
 func (bc *BlockChain) GetTrieDbState() (*state.TrieDbState, error) {
+	if bc.unhashedState {
+		return nil, ErrUnhashedState
+	}
 	if bc.trieDbState == nil && !bc.cacheConfig.DownloadOnly {
+		target, reached, unwinding, err := state.ReadUnwindProgress(bc.db)
+		if err != nil {
+			return nil, err
+		}
+		if unwinding {
+			trieDbState, err := bc.resumeUnwind(target, reached)
+			if err != nil {
+				return nil, err
+			}
+			bc.setTrieDbState(trieDbState)
+			return bc.trieDbState, nil
+		}
 		currentBlockNr := bc.CurrentBlock().NumberU64()
 		trieDbState, err := bc.GetTrieDbStateByBlock(bc.CurrentBlock().Header().Root, currentBlockNr)
 		if err != nil {
@@ -369,8 +403,44 @@ func (bc *BlockChain) GetTrieDbState() (*state.TrieDbState, error) {
 	return bc.trieDbState, nil
 }
 
+// resumeUnwind completes the unwind of the state interrupted after some of its steps have been committed
+// (see state.TrieDbState.UnwindToBatched), and makes the block the state is unwound to the head block
+func (bc *BlockChain) resumeUnwind(target, reached uint64) (*state.TrieDbState, error) {
+	log.Warn("Resuming interrupted unwind", "from", reached, "to", target)
+	reachedHeader := bc.GetHeaderByNumber(reached)
+	if reachedHeader == nil {
+		return nil, fmt.Errorf("resuming unwind: header %d not found", reached)
+	}
+	targetBlock := bc.GetBlockByNumber(target)
+	if targetBlock == nil {
+		return nil, fmt.Errorf("resuming unwind: block %d not found", target)
+	}
+	tds, err := bc.GetTrieDbStateByBlock(reachedHeader.Root, reached)
+	if err != nil {
+		return nil, err
+	}
+	if err = tds.UnwindToBatched(target); err != nil {
+		bc.db.Rollback()
+		return nil, fmt.Errorf("resuming unwind: %w", err)
+	}
+	if root := tds.LastRoot(); root != targetBlock.Root() {
+		bc.db.Rollback()
+		return nil, fmt.Errorf("resuming unwind: wrong root %x, expected %x", root, targetBlock.Root())
+	}
+	bc.writeHeadBlock(targetBlock)
+	if _, err = bc.db.Commit(); err != nil {
+		bc.db.Rollback()
+		return nil, err
+	}
+	bc.committedBlock.Store(targetBlock)
+	return tds, nil
+}
+
 func (bc *BlockChain) setTrieDbState(trieDbState *state.TrieDbState) {
 	log.Warn("trieDbState has been changed", "isNil", trieDbState == nil, "callers", debug.Callers(20))
+	if trieDbState != nil {
+		trieDbState.SetCollectChanges(true)
+	}
 	bc.trieDbState = trieDbState
 }
 
@@ -381,6 +451,26 @@ func (bc *BlockChain) GetTrieDbStateByBlock(root common.Hash, blockNr uint64) (*
 		tds.SetNoHistory(bc.NoHistory())
 		tds.SetResolveReads(bc.resolveReads)
 		tds.EnablePreimages(bc.enablePreimages)
+		tds.SetFlatHashing(bc.cacheConfig.FlatHashing)
+		tds.SetHashingWorkers(bc.cacheConfig.HashingWorkers)
+		tds.SetRetainListBudget(bc.cacheConfig.RetainListBudget*1024*1024, bc.spillDb)
+		if !bc.NoHistory() {
+			// The updates of the history indexes buffered by the previous state are lost, if it was not flushed
+			if err := state.RecoverHistoryIndex(bc.db, blockNr); err != nil {
+				return nil, fmt.Errorf("recovering the history index: %w", err)
+			}
+			tds.SetHistoryCommitWindow(bc.cacheConfig.HistoryCommitWindow)
+		}
+		for _, address := range bc.pinnedStorage {
+			if err := tds.PinStorage(address); err != nil {
+				return nil, fmt.Errorf("pinning storage of %x: %w", address, err)
+			}
+		}
+		if len(bc.cacheConfig.HotAccounts) > 0 {
+			if err := tds.SetHotAccounts(bc.cacheConfig.HotAccounts); err != nil {
+				return nil, fmt.Errorf("preloading hot accounts: %w", err)
+			}
+		}
 
 		log.Info("Creation complete.")
 		return tds, nil
@@ -388,6 +478,47 @@ func (bc *BlockChain) GetTrieDbStateByBlock(root common.Hash, blockNr uint64) (*
 	return bc.trieDbState, nil
 }
 
+// PinStorage keeps the storage trie of the contract fully resolved in the trie cache, excluding it from the eviction
+func (bc *BlockChain) PinStorage(address common.Address) error {
+	bc.chainmu.Lock()
+	defer bc.chainmu.Unlock()
+	for _, a := range bc.pinnedStorage {
+		if a == address {
+			return nil
+		}
+	}
+	if bc.trieDbState != nil {
+		if err := bc.trieDbState.PinStorage(address); err != nil {
+			return err
+		}
+	}
+	bc.pinnedStorage = append(bc.pinnedStorage, address)
+	return nil
+}
+
+// UnpinStorage makes the storage trie of the contract subject to the eviction again
+func (bc *BlockChain) UnpinStorage(address common.Address) error {
+	bc.chainmu.Lock()
+	defer bc.chainmu.Unlock()
+	for i, a := range bc.pinnedStorage {
+		if a == address {
+			bc.pinnedStorage = append(bc.pinnedStorage[:i], bc.pinnedStorage[i+1:]...)
+			break
+		}
+	}
+	if bc.trieDbState != nil {
+		return bc.trieDbState.UnpinStorage(address)
+	}
+	return nil
+}
+
+// PinnedStorage returns the contracts which storage tries are pinned
+func (bc *BlockChain) PinnedStorage() []common.Address {
+	bc.chainmu.RLock()
+	defer bc.chainmu.RUnlock()
+	return append([]common.Address{}, bc.pinnedStorage...)
+}
+
 func (bc *BlockChain) getProcInterrupt() bool {
 	return atomic.LoadInt32(&bc.procInterrupt) == 1
 }
@@ -1203,6 +1334,9 @@ func (bc *BlockChain) InsertReceiptChain(blockChain types.Blocks, receiptChain [
 			// Write all the data out into the database
 			rawdb.WriteBody(context.Background(), batch, block.Hash(), block.NumberU64(), block.Body())
 			rawdb.WriteReceipts(batch, block.Hash(), block.NumberU64(), receiptChain[i])
+			if err := rawdb.WriteLogIndex(batch, block.NumberU64(), receiptChain[i]); err != nil {
+				return 0, err
+			}
 			if bc.enableTxLookupIndex {
 				rawdb.WriteTxLookupEntries(batch, block)
 			}
@@ -1338,15 +1472,24 @@ func (bc *BlockChain) writeBlockWithState(ctx context.Context, block *types.Bloc
 		if err := blockWriter.WriteChangeSets(); err != nil {
 			return NonStatTy, err
 		}
+		if err := blockWriter.WriteStateSize(); err != nil {
+			return NonStatTy, err
+		}
 		// Optionally write history
 		if !bc.NoHistory() {
 			if err := blockWriter.WriteHistory(); err != nil {
 				return NonStatTy, err
 			}
+			if err := blockWriter.WriteIncarnationIndex(); err != nil {
+				return NonStatTy, err
+			}
 		}
 	}
 	if bc.enableReceipts && !bc.cacheConfig.DownloadOnly && execute {
 		rawdb.WriteReceipts(bc.db, block.Hash(), block.NumberU64(), receipts)
+		if err := rawdb.WriteLogIndex(bc.db, block.NumberU64(), receipts); err != nil {
+			return NonStatTy, err
+		}
 	}
 
 	// If the total difficulty is higher than our known, add it to the canonical chain
@@ -1517,7 +1660,7 @@ func (bc *BlockChain) InsertChain(ctx context.Context, chain types.Blocks) (int,
 // racey behaviour. If a sidechain import is in progress, and the historic state
 // is imported, but then new canon-head is added before the actual sidechain
 // completes, then the historic state could be pruned again
-func (bc *BlockChain) insertChain(ctx context.Context, chain types.Blocks, verifySeals bool, execute bool) (int, error) {
+func (bc *BlockChain) insertChain(ctx context.Context, chain types.Blocks, verifySeals bool, execute bool) (inserted int, err error) {
 	log.Info("Inserting chain",
 		"start", chain[0].NumberU64(), "end", chain[len(chain)-1].NumberU64(),
 		"current", bc.CurrentBlock().Number().Uint64(), "currentHeader", bc.CurrentHeader().Number.Uint64())
@@ -1640,7 +1783,40 @@ func (bc *BlockChain) insertChain(ctx context.Context, chain types.Blocks, verif
 	}
 
 	var k int
-	var committedK int
+	var committedK, pendingK int
+	// settle waits for the blocks committed in the background, see settleCommit
+	settle := func() error {
+		if bc.pendingBlock == nil {
+			return nil
+		}
+		if err := bc.settleCommit(); err != nil {
+			return err
+		}
+		committedK = pendingK
+		return nil
+	}
+	// The blocks committed in the background are not inserted until their commit succeeds
+	defer func() {
+		if bc.pendingBlock == nil {
+			return
+		}
+		settleErr := settle()
+		if settleErr == nil {
+			if err == nil {
+				inserted = committedK + 1
+			}
+			return
+		}
+		log.Error("Could not commit chainDb in the background", "error", settleErr)
+		bc.rollbackDb()
+		bc.setTrieDbState(nil)
+		if bc.committedBlock.Load() != nil {
+			bc.currentBlock.Store(bc.committedBlock.Load())
+		}
+		if err == nil {
+			inserted, err = committedK+1, settleErr
+		}
+	}()
 	// Iterate over the blocks and insert when the verifier permits
 	for i, block := range chain {
 		start := time.Now()
@@ -1729,38 +1905,46 @@ func (bc *BlockChain) insertChain(ctx context.Context, chain types.Blocks, verif
 		}
 		readBlockNr := parentNumber
 		var root common.Hash
-		if bc.trieDbState == nil && !bc.cacheConfig.DownloadOnly && execute {
+		// The blocks of the unhashed state are executed on the plain state, see executeUnhashed
+		trieExecution := !bc.cacheConfig.DownloadOnly && execute && !bc.unhashedState
+		if bc.trieDbState == nil && trieExecution {
 			if _, err = bc.GetTrieDbState(); err != nil {
 				return k, err
 			}
 		}
 
-		if !bc.cacheConfig.DownloadOnly && execute {
+		if trieExecution {
 			root = bc.trieDbState.LastRoot()
 		}
+		if bc.unhashedState && !bc.cacheConfig.DownloadOnly && execute && parent.Hash() != bc.CurrentBlock().Hash() {
+			return k, ErrUnhashedStateReorg
+		}
 
 		var parentRoot common.Hash
 		if parent != nil {
 			parentRoot = parent.Root()
 		}
 
-		if parent != nil && root != parentRoot && !bc.cacheConfig.DownloadOnly && execute {
+		if parent != nil && root != parentRoot && trieExecution {
 			log.Info("Rewinding from", "block", bc.CurrentBlock().NumberU64(), "to block", readBlockNr,
 				"root", root.String(), "parentRoot", parentRoot.String())
 
-			if _, err = bc.db.Commit(); err != nil {
+			if err = settle(); err == nil {
+				_, err = bc.db.Commit()
+			}
+			if err != nil {
 				log.Error("Could not commit chainDb before rewinding", "error", err)
+				bc.rollbackDb()
 				if bc.committedBlock.Load() != nil {
 					bc.currentBlock.Store(bc.committedBlock.Load())
 				}
-				bc.db.Rollback()
 				bc.setTrieDbState(nil)
 				return k, err
 			}
 			bc.committedBlock.Store(bc.currentBlock.Load())
 
-			if err = bc.trieDbState.UnwindTo(readBlockNr); err != nil {
-				bc.db.Rollback()
+			if err = bc.trieDbState.UnwindToBatched(readBlockNr); err != nil {
+				bc.rollbackDb()
 				log.Error("Could not rewind", "error", err)
 				bc.setTrieDbState(nil)
 				return k, err
@@ -1769,20 +1953,20 @@ func (bc *BlockChain) insertChain(ctx context.Context, chain types.Blocks, verif
 			root := bc.trieDbState.LastRoot()
 			if root != parentRoot {
 				log.Error("Incorrect rewinding", "root", fmt.Sprintf("%x", root), "expected", fmt.Sprintf("%x", parentRoot))
-				bc.db.Rollback()
+				bc.rollbackDb()
 				bc.setTrieDbState(nil)
 				return k, fmt.Errorf("incorrect rewinding: wrong root %x, expected %x", root, parentRoot)
 			}
 			currentBlock := bc.CurrentBlock()
 			if err = bc.reorg(currentBlock, parent); err != nil {
-				bc.db.Rollback()
+				bc.rollbackDb()
 				bc.setTrieDbState(nil)
 				return k, err
 			}
 
 			if _, err = bc.db.Commit(); err != nil {
 				log.Error("Could not commit chainDb after rewinding", "error", err)
-				bc.db.Rollback()
+				bc.rollbackDb()
 				bc.setTrieDbState(nil)
 				if bc.committedBlock.Load() != nil {
 					bc.currentBlock.Store(bc.committedBlock.Load())
@@ -1794,10 +1978,17 @@ func (bc *BlockChain) insertChain(ctx context.Context, chain types.Blocks, verif
 		}
 
 		var stateDB *state.IntraBlockState
+		var stateChanges *state.StateChanges
 		var receipts types.Receipts
 		var usedGas uint64
 		var logs []*types.Log
-		if !bc.cacheConfig.DownloadOnly && execute {
+		if bc.unhashedState && !bc.cacheConfig.DownloadOnly && execute {
+			receipts, logs, usedGas, err = bc.executeUnhashed(block)
+			if err != nil {
+				bc.rollbackBadBlock(block, receipts, err, false /* reuseTrieDbState */)
+				return k, err
+			}
+		} else if trieExecution {
 			stateDB = state.New(bc.trieDbState)
 			// Process block using the parent state as reference point.
 			receipts, logs, usedGas, root, err = bc.processor.PreProcess(block, stateDB, bc.trieDbState, bc.vmConfig)
@@ -1825,13 +2016,14 @@ func (bc *BlockChain) insertChain(ctx context.Context, chain types.Blocks, verif
 				bc.rollbackBadBlock(block, receipts, err, reuseTrieDbState)
 				return k, err
 			}
+			stateChanges = bc.trieDbState.TakeChanges()
 		}
 		proctime := time.Since(start)
 
 		// Write the block to the chain and get the status.
 		status, err := bc.writeBlockWithState(ctx, block, receipts, logs, stateDB, bc.trieDbState, false, execute)
 		if err != nil {
-			bc.db.Rollback()
+			bc.rollbackDb()
 			bc.setTrieDbState(nil)
 			if bc.committedBlock.Load() != nil {
 				bc.currentBlock.Store(bc.committedBlock.Load())
@@ -1847,6 +2039,9 @@ func (bc *BlockChain) insertChain(ctx context.Context, chain types.Blocks, verif
 				"root", block.Root())
 
 			lastCanon = block
+			if stateChanges != nil {
+				bc.stateChangesFeed.Send(StateChangesEvent{Block: block, Changes: stateChanges})
+			}
 
 			// Only count canonical blocks for GC processing time
 			bc.gcproc += proctime
@@ -1867,25 +2062,50 @@ func (bc *BlockChain) insertChain(ctx context.Context, chain types.Blocks, verif
 		}
 		stats.processed++
 		stats.usedGas += usedGas
-		toCommit := stats.needToCommit(chain, bc.db, i)
+		// In the flat hashing mode state roots are computed from the committed data only
+		toCommit := stats.needToCommit(chain, bc.db, i) || bc.cacheConfig.FlatHashing
 		stats.report(chain, i, bc.db, toCommit)
 		if toCommit {
 			var written uint64
-			if written, err = bc.db.Commit(); err != nil {
+			// The last block is committed synchronously, so the chain is written once it is inserted
+			committer, async := bc.db.(ethdb.AsyncCommitter)
+			async = async && bc.cacheConfig.AsyncCommit && !bc.cacheConfig.FlatHashing && i < len(chain)-1
+			commitStart := time.Now()
+			if err = settle(); err == nil {
+				// The background commit is timed when it is waited for, see settleCommit
+				commitStart = time.Now()
+				if async {
+					err = committer.CommitAsync()
+				} else {
+					written, err = bc.db.Commit()
+				}
+			}
+			if err != nil {
 				log.Error("Could not commit chainDb", "error", err)
-				bc.db.Rollback()
+				bc.rollbackDb()
 				bc.setTrieDbState(nil)
 				if bc.committedBlock.Load() != nil {
 					bc.currentBlock.Store(bc.committedBlock.Load())
 				}
 				return k, err
 			}
-			bc.committedBlock.Store(bc.currentBlock.Load())
-			committedK = k
-			if bc.trieDbState != nil {
-				bc.trieDbState.EvictTries(false)
+			if async {
+				bc.pendingBlock, bc.pendingCommitStart = bc.CurrentBlock(), commitStart
+				pendingK = k
+			} else {
+				bc.committedBlock.Store(bc.currentBlock.Load())
+				committedK = k
+				// The tries are resolved from the committed data only, so the nodes are evicted
+				// after the synchronous commits, when none of the writes are pending
+				if bc.trieDbState != nil {
+					bc.trieDbState.RecordCommit(commitStart)
+					bc.trieDbState.EvictTries(false)
+				}
 			}
-			log.Info("Database", "size", bc.db.DiskSize(), "written", written)
+			log.Info("Database", "size", bc.db.DiskSize(), "written", written, "async", async)
+		}
+		if bc.trieDbState != nil && stateDB != nil {
+			bc.trieDbState.BlockProcessed()
 		}
 	}
 
@@ -1895,7 +2115,9 @@ func (bc *BlockChain) insertChain(ctx context.Context, chain types.Blocks, verif
 // statsReportLimit is the time limit during import and export after which we
 // always print out progress. This avoids the user wondering what's going on.
 const statsReportLimit = 8 * time.Second
-const commitLimit = 60 * time.Second
+
+// commitLimit is the time after which the inserted blocks are committed even if the batch is not full
+var commitLimit = 60 * time.Second
 
 func (st *insertStats) needToCommit(chain []*types.Block, db ethdb.DbWithPendingMutations, index int) bool {
 	var (
@@ -2174,8 +2396,85 @@ Callers: %v
 `, bc.chainConfig, block.Number(), block.Hash(), receiptString, err, debug.Callers(20)))
 }
 
-func (bc *BlockChain) rollbackBadBlock(block *types.Block, receipts types.Receipts, err error, reuseTrieDbState bool) {
+// settleCommit waits for the commit of the inserted blocks running in the background,
+// and marks its head committed if it succeeds. The commit is timed from its start up to here
+func (bc *BlockChain) settleCommit() error {
+	if bc.pendingBlock == nil {
+		return nil
+	}
+	block := bc.pendingBlock
+	bc.pendingBlock = nil
+	if err := bc.db.(ethdb.AsyncCommitter).WaitCommit(); err != nil {
+		return err
+	}
+	if bc.trieDbState != nil {
+		bc.trieDbState.RecordCommit(bc.pendingCommitStart)
+	}
+	bc.committedBlock.Store(block)
+	return nil
+}
+
+// WithCommittedState commits the pending writes of the block chain and runs f with the block insertion paused,
+// so that f can rewrite the records of the database (e.g. the history index), which the insertion appends to
+func (bc *BlockChain) WithCommittedState(f func() error) error {
+	bc.chainmu.Lock()
+	defer bc.chainmu.Unlock()
+	if err := bc.settleCommit(); err != nil {
+		return err
+	}
+	if _, err := bc.db.Commit(); err != nil {
+		return err
+	}
+	bc.committedBlock.Store(bc.currentBlock.Load())
+	return f()
+}
+
+// rollbackDb discards the writes, which are not committed. The commit running in the background holds the blocks
+// which are already verified, so it is finished first rather than undone by the rollback, and the committed head,
+// to which the chain is rolled back, matches the database
+func (bc *BlockChain) rollbackDb() {
+	if err := bc.settleCommit(); err != nil {
+		log.Error("Could not commit chainDb in the background", "error", err)
+	}
 	bc.db.Rollback()
+}
+
+// executeUnhashed executes the block on the plain state (see rawdb.UnhashedStateSchema), and checks the root
+// computed from the plain state with the writes of the block. Only the blocks extending the head are executed
+func (bc *BlockChain) executeUnhashed(block *types.Block) (types.Receipts, []*types.Log, uint64, error) {
+	reader := state.NewPlainStateReader(bc.db)
+	writer := state.NewPlainStateWriter(bc.db, bc.db, block.NumberU64())
+	receipts, err := ExecuteBlockEuphemerally(bc.chainConfig, &bc.vmConfig, bc, bc.engine, block, reader, writer)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	var logs []*types.Log
+	var usedGas uint64
+	for _, receipt := range receipts {
+		logs = append(logs, receipt.Logs...)
+		usedGas = receipt.CumulativeGasUsed
+	}
+	if block.GasUsed() != usedGas {
+		return receipts, nil, 0, fmt.Errorf("invalid gas used (remote: %d local: %d)", block.GasUsed(), usedGas)
+	}
+	// The receipts before Byzantium have the intermediate roots, which are not computed without the trie
+	if bc.chainConfig.IsByzantium(block.Number()) {
+		if err = bc.Validator().ValidateReceipts(block, receipts); err != nil {
+			return receipts, nil, 0, err
+		}
+	}
+	root, err := state.PlainStateRoot(bc.db, "")
+	if err != nil {
+		return receipts, nil, 0, err
+	}
+	if root != block.Root() {
+		return receipts, nil, 0, fmt.Errorf("invalid merkle root (remote: %x local: %x)", block.Root(), root)
+	}
+	return receipts, logs, usedGas, nil
+}
+
+func (bc *BlockChain) rollbackBadBlock(block *types.Block, receipts types.Receipts, err error, reuseTrieDbState bool) {
+	bc.rollbackDb()
 	if reuseTrieDbState {
 		bc.setTrieDbState(bc.trieDbState.WithNewBuffer())
 	} else {
@@ -2399,6 +2698,11 @@ func (bc *BlockChain) SubscribeChainEvent(ch chan<- ChainEvent) event.Subscripti
 	return bc.scope.Track(bc.chainFeed.Subscribe(ch))
 }
 
+// SubscribeStateChangesEvent registers a subscription of StateChangesEvent.
+func (bc *BlockChain) SubscribeStateChangesEvent(ch chan<- StateChangesEvent) event.Subscription {
+	return bc.scope.Track(bc.stateChangesFeed.Subscribe(ch))
+}
+
 // SubscribeChainHeadEvent registers a subscription of ChainHeadEvent.
 func (bc *BlockChain) SubscribeChainHeadEvent(ch chan<- ChainHeadEvent) event.Subscription {
 	return bc.scope.Track(bc.chainHeadFeed.Subscribe(ch))
@@ -2424,6 +2728,12 @@ func (bc *BlockChain) ChainDb() ethdb.Database {
 	return bc.db
 }
 
+// UnhashedState tells whether the state is kept under the plain addresses only (see rawdb.UnhashedStateSchema),
+// the blocks of such chains are executed without the trie
+func (bc *BlockChain) UnhashedState() bool {
+	return bc.unhashedState
+}
+
 func (bc *BlockChain) NoHistory() bool {
 	return bc.cacheConfig.NoHistory
 }
@@ -2498,7 +2808,7 @@ func (bc *BlockChain) waitJobs() {
 }
 
 // ExecuteBlockEuphemerally runs a block from provided stateReader and
-// writes the result to the provided stateWriter
+// writes the result to the provided stateWriter, returns the receipts of the block
 func ExecuteBlockEuphemerally(
 	chainConfig *params.ChainConfig,
 	vmConfig *vm.Config,
@@ -2507,7 +2817,7 @@ func ExecuteBlockEuphemerally(
 	block *types.Block,
 	stateReader state.StateReader,
 	stateWriter state.WriterWithChangeSets,
-) error {
+) (types.Receipts, error) {
 	ibs := state.New(stateReader)
 	header := block.Header()
 	var receipts types.Receipts
@@ -2522,7 +2832,7 @@ func ExecuteBlockEuphemerally(
 		ibs.Prepare(tx.Hash(), block.Hash(), i)
 		receipt, err := ApplyTransaction(chainConfig, chainContext, nil, gp, ibs, noop, header, tx, usedGas, *vmConfig)
 		if err != nil {
-			return fmt.Errorf("tx %x failed: %v", tx.Hash(), err)
+			return nil, fmt.Errorf("tx %x failed: %v", tx.Hash(), err)
 		}
 		receipts = append(receipts, receipt)
 	}
@@ -2530,23 +2840,23 @@ func ExecuteBlockEuphemerally(
 	if chainConfig.IsByzantium(header.Number) {
 		receiptSha := types.DeriveSha(receipts)
 		if receiptSha != block.Header().ReceiptHash {
-			return fmt.Errorf("mismatched receipt headers for block %d", block.NumberU64())
+			return nil, fmt.Errorf("mismatched receipt headers for block %d", block.NumberU64())
 		}
 	}
 
 	// Finalize the block, applying any consensus engine specific extras (e.g. block rewards)
 	if _, err := engine.FinalizeAndAssemble(chainConfig, header, ibs, block.Transactions(), block.Uncles(), receipts); err != nil {
-		return fmt.Errorf("finalize of block %d failed: %v", block.NumberU64(), err)
+		return nil, fmt.Errorf("finalize of block %d failed: %v", block.NumberU64(), err)
 	}
 
 	ctx := chainConfig.WithEIPsFlags(context.Background(), header.Number)
 	if err := ibs.CommitBlock(ctx, stateWriter); err != nil {
-		return fmt.Errorf("commiting block %d failed: %v", block.NumberU64(), err)
+		return nil, fmt.Errorf("commiting block %d failed: %v", block.NumberU64(), err)
 	}
 
 	if err := stateWriter.WriteChangeSets(); err != nil {
-		return fmt.Errorf("writing changesets for block %d failed: %v", block.NumberU64(), err)
+		return nil, fmt.Errorf("writing changesets for block %d failed: %v", block.NumberU64(), err)
 	}
 
-	return nil
+	return receipts, nil
 }
@@ -3218,3 +3218,200 @@ func TestInitThenFailCreateContract(t *testing.T) {
 		}
 	}
 }
+
+func TestStateChangesEvent(t *testing.T) {
+	var (
+		db        = ethdb.NewMemDatabase()
+		key, _    = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		address   = crypto.PubkeyToAddress(key.PublicKey)
+		recipient = common.Address{0xaa}
+		gspec     = &Genesis{
+			Config: &params.ChainConfig{ChainID: big.NewInt(1), HomesteadBlock: new(big.Int), EIP150Block: new(big.Int), EIP155Block: new(big.Int)},
+			Alloc:  GenesisAlloc{address: {Balance: big.NewInt(1000000000)}},
+		}
+		genesis = gspec.MustCommit(db)
+		signer  = types.NewEIP155Signer(gspec.Config.ChainID)
+	)
+	blockchain, err := NewBlockChain(db, nil, gspec.Config, ethash.NewFaker(), vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer blockchain.Stop()
+
+	events := make(chan StateChangesEvent, 10)
+	sub := blockchain.SubscribeStateChangesEvent(events)
+	defer sub.Unsubscribe()
+
+	ctx := blockchain.WithContext(context.Background(), big.NewInt(genesis.Number().Int64()+1))
+	blocks, _ := GenerateChain(ctx, gspec.Config, genesis, ethash.NewFaker(), db.MemCopy(), 2, func(i int, block *BlockGen) {
+		tx, err1 := types.SignTx(types.NewTransaction(block.TxNonce(address), recipient, big.NewInt(int64(i+1)), 21000, new(big.Int), nil), signer, key)
+		if err1 != nil {
+			t.Fatal(err1)
+		}
+		block.AddTx(tx)
+	})
+	if _, err = blockchain.InsertChain(context.Background(), blocks); err != nil {
+		t.Fatal(err)
+	}
+
+	senderHash := crypto.Keccak256Hash(address[:])
+	recipientHash := crypto.Keccak256Hash(recipient[:])
+	expectedBalance := uint64(0)
+	for i, block := range blocks {
+		var ev StateChangesEvent
+		select {
+		case ev = <-events:
+		case <-time.After(time.Second):
+			t.Fatalf("no state changes event for block %d", block.NumberU64())
+		}
+		if ev.Block.Hash() != block.Hash() {
+			t.Fatalf("expected event for block %d, got %d", block.NumberU64(), ev.Block.NumberU64())
+		}
+		if _, ok := ev.Changes.Accounts[senderHash]; !ok {
+			t.Errorf("block %d: sender is not in the changes", block.NumberU64())
+		}
+		expectedBalance += uint64(i + 1)
+		acc, ok := ev.Changes.Accounts[recipientHash]
+		if !ok || acc == nil {
+			t.Fatalf("block %d: recipient is not in the changes", block.NumberU64())
+		}
+		if acc.Balance.Uint64() != expectedBalance {
+			t.Errorf("block %d: expected recipient balance %d, got %d", block.NumberU64(), expectedBalance, acc.Balance.Uint64())
+		}
+	}
+}
+
+// Tests that the blocks of the unhashed state are executed on the plain state
+func TestInsertChainUnhashedState(t *testing.T) {
+	var (
+		key, _    = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		address   = crypto.PubkeyToAddress(key.PublicKey)
+		recipient = common.Address{1}
+		config    = &params.ChainConfig{ChainID: big.NewInt(1), HomesteadBlock: new(big.Int), EIP150Block: new(big.Int), EIP155Block: new(big.Int), EIP158Block: new(big.Int), ByzantiumBlock: new(big.Int)}
+		gspec     = &Genesis{Config: config, Alloc: GenesisAlloc{address: {Balance: big.NewInt(1000000000)}}}
+		hashedDb  = ethdb.NewMemDatabase()
+		genesis   = gspec.MustCommit(hashedDb)
+	)
+	hashedChain, err := NewBlockChain(hashedDb, nil, config, ethash.NewFaker(), vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hashedChain.Stop()
+	signer := types.NewEIP155Signer(config.ChainID)
+	blocks, _ := GenerateChain(hashedChain.WithContext(context.Background(), big.NewInt(1)), config, genesis, ethash.NewFaker(), hashedDb.MemCopy(), 3, func(i int, block *BlockGen) {
+		tx, err := types.SignTx(types.NewTransaction(block.TxNonce(address), recipient, big.NewInt(1000), params.TxGas, nil, nil), signer, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		block.AddTx(tx)
+	})
+
+	// The root of the genesis does not depend on the layout of the state
+	db := ethdb.NewMemDatabase()
+	unhashed := *gspec
+	unhashed.UnhashedState = true
+	if block := unhashed.MustCommit(db); block.Hash() != genesis.Hash() {
+		t.Fatalf("genesis of the unhashed state %x, want %x", block.Hash(), genesis.Hash())
+	}
+	blockchain, err := NewBlockChain(db, nil, config, ethash.NewFaker(), vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer blockchain.Stop()
+	if _, err = blockchain.GetTrieDbState(); err != ErrUnhashedState {
+		t.Errorf("trie of the unhashed state: got %v, want %v", err, ErrUnhashedState)
+	}
+	if n, err := blockchain.InsertChain(context.Background(), blocks); err != nil {
+		t.Fatalf("failed to insert block %d: %v", n, err)
+	}
+	if head := blockchain.CurrentBlock(); head.Hash() != blocks[2].Hash() {
+		t.Errorf("head %d, want %d", head.NumberU64(), blocks[2].NumberU64())
+	}
+	acc, err := state.NewPlainStateReader(db).ReadAccountData(recipient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if acc == nil || acc.Balance.Uint64() != 3000 {
+		t.Errorf("recipient account %v, want the balance 3000", acc)
+	}
+	if err = db.Walk(dbutils.CurrentStateBucket, nil, 0, func(k, _ []byte) (bool, error) {
+		t.Errorf("unexpected hashed state entry %x", k)
+		return false, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Tests that the blocks committed in the background, including the reorg, leave the same database
+// as the synchronous commits
+func TestAsyncCommit(t *testing.T) {
+	defer func(limit time.Duration) { commitLimit = limit }(commitLimit)
+	// Every block is committed, all but the last ones of the inserted chains in the background
+	commitLimit = 0
+
+	newChain := func(asyncCommit bool) (ethdb.Database, *BlockChain, *types.Block) {
+		db := ethdb.NewMemDatabase()
+		genesis, _, err := new(Genesis).Commit(db, true /* history */)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cacheConfig := &CacheConfig{
+			TrieCleanLimit: 256,
+			TrieDirtyLimit: 256,
+			TrieTimeLimit:  5 * time.Minute,
+			AsyncCommit:    asyncCommit,
+		}
+		blockchain, err := NewBlockChain(db, cacheConfig, params.AllEthashProtocolChanges, ethash.NewFaker(), vm.Config{}, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return db, blockchain, genesis
+	}
+
+	genDb, genChain, genesis := newChain(false)
+	ctx := genChain.WithContext(context.Background(), big.NewInt(genesis.Number().Int64()+1))
+	blocks := makeBlockChain(ctx, genesis, 8, ethash.NewFaker(), genDb.MemCopy(), canonicalSeed)
+	if _, err := genChain.InsertChain(context.Background(), blocks[:3]); err != nil {
+		t.Fatal(err)
+	}
+	fork := makeBlockChain(ctx, blocks[2], 10, ethash.NewFaker(), genDb.MemCopy(), forkSeed)
+	genChain.Stop()
+
+	insert := func(asyncCommit bool) ethdb.Database {
+		db, blockchain, _ := newChain(asyncCommit)
+		defer blockchain.Stop()
+		for _, chain := range []types.Blocks{blocks, fork} {
+			n, err := blockchain.InsertChain(context.Background(), chain)
+			if err != nil {
+				t.Fatalf("async %t: failed to insert the chain: %v", asyncCommit, err)
+			}
+			if n != len(chain) {
+				t.Fatalf("async %t: inserted %d blocks, expected %d", asyncCommit, n, len(chain))
+			}
+			if head := chain[len(chain)-1]; blockchain.CurrentBlock().Hash() != head.Hash() || rawdb.ReadHeadBlockHash(db) != head.Hash() {
+				t.Fatalf("async %t: head %d, expected %d", asyncCommit, blockchain.CurrentBlock().NumberU64(), head.NumberU64())
+			}
+		}
+		return db
+	}
+	syncDb, asyncDb := insert(false), insert(true)
+
+	for number := uint64(0); number <= fork[len(fork)-1].NumberU64(); number++ {
+		if syncHash, asyncHash := rawdb.ReadCanonicalHash(syncDb, number), rawdb.ReadCanonicalHash(asyncDb, number); syncHash != asyncHash {
+			t.Errorf("canonical block %d: %x with the async commit, %x without", number, asyncHash, syncHash)
+		}
+	}
+	for _, bucket := range [][]byte{dbutils.CurrentStateBucket, dbutils.AccountChangeSetBucket, dbutils.AccountsHistoryBucket} {
+		walk := func(db ethdb.Database) map[string]string {
+			m := make(map[string]string)
+			if err := db.Walk(bucket, nil, 0, func(k, v []byte) (bool, error) {
+				m[string(k)] = string(v)
+				return true, nil
+			}); err != nil {
+				t.Fatal(err)
+			}
+			return m
+		}
+		assert.Equal(t, walk(syncDb), walk(asyncDb), "bucket %s", bucket)
+	}
+}
@@ -27,6 +27,14 @@ var (
 
 	// ErrNoGenesis is returned when there is no Genesis Block.
 	ErrNoGenesis = errors.New("genesis not found in chain")
+
+	// ErrUnhashedState is returned when the trie of the state is requested, but the state is kept
+	// under the plain addresses only (see rawdb.UnhashedStateSchema)
+	ErrUnhashedState = errors.New("the state is kept under the plain addresses only, there is no trie")
+
+	// ErrUnhashedStateReorg is returned when the blocks not extending the head are inserted into the chain
+	// of the unhashed state, which has no trie to unwind
+	ErrUnhashedStateReorg = errors.New("reorgs are not supported by the unhashed state")
 )
 
 // List of evm-call-message pre-checking errors. All state transtion messages will
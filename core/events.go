@@ -18,6 +18,7 @@ package core
 
 import (
 	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/core/state"
 	"github.com/ledgerwatch/turbo-geth/core/types"
 )
 
@@ -41,3 +42,10 @@ type ChainSideEvent struct {
 }
 
 type ChainHeadEvent struct{ Block *types.Block }
+
+// StateChangesEvent is posted when a block has been executed and inserted into the canonical chain,
+// it contains the accounts and storage items changed by the block
+type StateChangesEvent struct {
+	Block   *types.Block
+	Changes *state.StateChanges
+}
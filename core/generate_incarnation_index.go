@@ -0,0 +1,183 @@
+package core
+
+import (
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/common/changeset"
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+	"github.com/ledgerwatch/turbo-geth/core/rawdb"
+	"github.com/ledgerwatch/turbo-geth/crypto"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+	"github.com/ledgerwatch/turbo-geth/log"
+)
+
+// pendingAccountChange - the last change of the account seen in the changesets,
+// value of the account after the change is not known yet
+type pendingAccountChange struct {
+	blockNum uint64
+	before   []byte
+}
+
+// GenerateIncarnationIndex walks account changesets starting from the block `from` and records blocks at which accounts
+// were created and destroyed into dbutils.IncarnationHistoryBucket.
+// Changeset holds the value of the account before the block, and the value after the block is taken
+// from the next changeset of this account, or, for the last change, via GetAsOf. So the account history index
+// must be already generated for the processed blocks. The plain state has no history index, there the value
+// after the last change is found in the following changesets, or in the current state (see plainValuesAfter).
+// The plain changesets are keyed by the addresses, the index is keyed by their hashes in both layouts.
+// Generation is idempotent, so can be restarted from any block after interruption.
+func GenerateIncarnationIndex(db ethdb.Database, from uint64, plainState bool) error {
+	const batchSize = 1000000
+	csBucket, walkChangeSet := accountChangeSets(plainState)
+	pending := make(map[string]pendingAccountChange)
+	batch := db.NewBatch()
+	defer batch.Rollback()
+
+	// flush resolves values after the pending changes as of the end of blockNum
+	flush := func(blockNum uint64) error {
+		var plainAfter map[string][]byte
+		if plainState {
+			var err error
+			if plainAfter, err = plainValuesAfter(db, pending, blockNum+1); err != nil {
+				return err
+			}
+		}
+		for k, change := range pending {
+			var after []byte
+			if plainState {
+				after = plainAfter[k]
+			} else {
+				var err error
+				after, err = db.GetAsOf(dbutils.CurrentStateBucket, dbutils.AccountsHistoryBucket, []byte(k), blockNum+1)
+				if err != nil && !ethdb.IsNotFound(err) {
+					return err
+				}
+			}
+			if err := rawdb.WriteIncarnationChange(batch, indexKey([]byte(k), plainState), change.blockNum, change.before, after); err != nil {
+				return err
+			}
+		}
+		pending = make(map[string]pendingAccountChange)
+		_, err := batch.Commit()
+		return err
+	}
+
+	log.Info("Incarnation index generation started", "from", from, "plainState", plainState)
+	lastBlock := from
+	next := from
+	for {
+		stop := true
+		processed := false
+		if err := ethdb.WalkChangeSets(db, csBucket, next, func(blockNum uint64, v []byte) (bool, error) {
+			if len(pending) > batchSize {
+				// blockNum is not processed yet, continue from it after the flush
+				next = blockNum
+				stop = false
+				return false, nil
+			}
+			if err := walkChangeSet(v, func(k, before []byte) error {
+				if prev, ok := pending[string(k)]; ok {
+					if err := rawdb.WriteIncarnationChange(batch, indexKey(k, plainState), prev.blockNum, prev.before, before); err != nil {
+						return err
+					}
+				}
+				pending[string(k)] = pendingAccountChange{blockNum: blockNum, before: common.CopyBytes(before)}
+				return nil
+			}); err != nil {
+				return false, err
+			}
+			lastBlock = blockNum
+			processed = true
+			return true, nil
+		}); err != nil {
+			return err
+		}
+		if processed {
+			if err := flush(lastBlock); err != nil {
+				return err
+			}
+			log.Info("Committed incarnation index batch", "up to block", lastBlock)
+		}
+		if stop {
+			break
+		}
+	}
+	log.Info("Incarnation index generation finished", "last block", lastBlock)
+	return nil
+}
+
+// accountChangeSets returns the bucket of the account changesets of the layout, and the walker of its changesets
+func accountChangeSets(plainState bool) ([]byte, func(cs []byte, f func(k, v []byte) error) error) {
+	if plainState {
+		return dbutils.PlainAccountChangeSetBucket, func(cs []byte, f func(k, v []byte) error) error {
+			return changeset.AccountChangeSetPlainBytes(cs).Walk(f)
+		}
+	}
+	return dbutils.AccountChangeSetBucket, func(cs []byte, f func(k, v []byte) error) error {
+		return changeset.AccountChangeSetBytes(cs).Walk(f)
+	}
+}
+
+// indexKey converts the key of the changeset into the hash of the address
+func indexKey(k []byte, plainState bool) common.Hash {
+	if plainState {
+		return crypto.Keccak256Hash(k)
+	}
+	return common.BytesToHash(k)
+}
+
+// plainValuesAfter finds the values of the accounts of the plain state as of the beginning of the block `from`.
+// The value is the one recorded by the first changeset of the account starting from that block,
+// or, if the account did not change since, the value in the current state
+func plainValuesAfter(db ethdb.Database, pending map[string]pendingAccountChange, from uint64) (map[string][]byte, error) {
+	values := make(map[string][]byte, len(pending))
+	if err := ethdb.WalkChangeSets(db, dbutils.PlainAccountChangeSetBucket, from, func(_ uint64, v []byte) (bool, error) {
+		if err := changeset.AccountChangeSetPlainBytes(v).Walk(func(address, before []byte) error {
+			if _, ok := pending[string(address)]; !ok {
+				return nil
+			}
+			if _, ok := values[string(address)]; !ok {
+				values[string(address)] = common.CopyBytes(before)
+			}
+			return nil
+		}); err != nil {
+			return false, err
+		}
+		return len(values) < len(pending), nil
+	}); err != nil {
+		return nil, err
+	}
+	for address := range pending {
+		if _, ok := values[address]; ok {
+			continue
+		}
+		v, err := db.Get(dbutils.PlainStateBucket, []byte(address))
+		if err != nil && !ethdb.IsNotFound(err) {
+			return nil, err
+		}
+		values[address] = v
+	}
+	return values, nil
+}
+
+// TruncateIncarnationIndex removes creations and destructions which happened after the unwindPoint
+func TruncateIncarnationIndex(db ethdb.Database, unwindPoint uint64, plainState bool) error {
+	csBucket, walkChangeSet := accountChangeSets(plainState)
+	keys := make(map[common.Hash]struct{})
+	if err := ethdb.WalkChangeSets(db, csBucket, unwindPoint+1, func(_ uint64, v []byte) (bool, error) {
+		if err := walkChangeSet(v, func(k, _ []byte) error {
+			keys[indexKey(k, plainState)] = struct{}{}
+			return nil
+		}); err != nil {
+			return false, err
+		}
+		return true, nil
+	}); err != nil {
+		return err
+	}
+	for addrHash := range keys {
+		if err := rawdb.DeleteIncarnationEventsAfter(db, addrHash, unwindPoint); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/common/changeset"
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+	"github.com/ledgerwatch/turbo-geth/core/rawdb"
+	"github.com/ledgerwatch/turbo-geth/core/types/accounts"
+	"github.com/ledgerwatch/turbo-geth/crypto"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateIncarnationIndex(t *testing.T) {
+	db := ethdb.NewMemDatabase()
+	contract := common.HexToHash("0x01")
+	eoa := common.HexToHash("0x02")
+
+	encode := func(incarnation uint64, balance uint64) []byte {
+		acc := accounts.NewAccount()
+		acc.Incarnation = incarnation
+		acc.Balance.SetUint64(balance)
+		v := make([]byte, acc.EncodingLengthForStorage())
+		acc.EncodeForStorage(v)
+		return v
+	}
+	writeChangeSet := func(blockNum uint64, changes map[common.Hash][]byte) {
+		cs := changeset.NewAccountChangeSet()
+		for k, v := range changes {
+			require.NoError(t, cs.Add(common.CopyBytes(k[:]), v))
+		}
+		b, err := changeset.EncodeAccounts(cs)
+		require.NoError(t, err)
+		require.NoError(t, db.Put(dbutils.AccountChangeSetBucket, dbutils.EncodeTimestamp(blockNum), b))
+	}
+
+	// block 1: contract created, eoa received balance
+	// block 3: contract self-destructed, eoa balance changed
+	// block 5: contract created again with the next incarnation
+	writeChangeSet(1, map[common.Hash][]byte{contract: {}, eoa: {}})
+	writeChangeSet(3, map[common.Hash][]byte{contract: encode(1, 0), eoa: encode(0, 100)})
+	writeChangeSet(5, map[common.Hash][]byte{contract: {}})
+	require.NoError(t, db.Put(dbutils.CurrentStateBucket, contract[:], encode(2, 0)))
+	require.NoError(t, db.Put(dbutils.CurrentStateBucket, eoa[:], encode(0, 200)))
+
+	require.NoError(t, GenerateIncarnationIndex(db, 0, false))
+
+	events, err := rawdb.ReadIncarnationEvents(db, contract)
+	require.NoError(t, err)
+	assert.Equal(t, []rawdb.IncarnationEvent{
+		{BlockNumber: 1, Created: true, Incarnation: 1},
+		{BlockNumber: 3, Created: false, Incarnation: 1},
+		{BlockNumber: 5, Created: true, Incarnation: 2},
+	}, events)
+
+	events, err = rawdb.ReadIncarnationEvents(db, eoa)
+	require.NoError(t, err)
+	assert.Equal(t, []rawdb.IncarnationEvent{{BlockNumber: 1, Created: true, Incarnation: 0}}, events)
+
+	created, ok, err := rawdb.ReadCreationBlock(db, contract)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, uint64(5), created)
+
+	for block, expected := range map[uint64]uint64{1: 0, 2: 1, 3: 1, 4: 0, 6: 2} {
+		incarnation, err := rawdb.ReadIncarnationAsOf(db, contract, block)
+		require.NoError(t, err)
+		assert.Equal(t, expected, incarnation, "block %d", block)
+	}
+
+	// generation is idempotent
+	require.NoError(t, GenerateIncarnationIndex(db, 3, false))
+	events, err = rawdb.ReadIncarnationEvents(db, contract)
+	require.NoError(t, err)
+	assert.Equal(t, 3, len(events))
+
+	require.NoError(t, TruncateIncarnationIndex(db, 4, false))
+	events, err = rawdb.ReadIncarnationEvents(db, contract)
+	require.NoError(t, err)
+	assert.Equal(t, []rawdb.IncarnationEvent{
+		{BlockNumber: 1, Created: true, Incarnation: 1},
+		{BlockNumber: 3, Created: false, Incarnation: 1},
+	}, events)
+}
+
+func TestGenerateIncarnationIndexPlain(t *testing.T) {
+	db := ethdb.NewMemDatabase()
+	contract := common.HexToAddress("0x01")
+	contractHash := crypto.Keccak256Hash(contract[:])
+
+	encode := func(incarnation uint64) []byte {
+		acc := accounts.NewAccount()
+		acc.Incarnation = incarnation
+		v := make([]byte, acc.EncodingLengthForStorage())
+		acc.EncodeForStorage(v)
+		return v
+	}
+	writeChangeSet := func(blockNum uint64, before []byte) {
+		cs := changeset.NewAccountChangeSetPlain()
+		require.NoError(t, cs.Add(common.CopyBytes(contract[:]), before))
+		b, err := changeset.EncodeAccountsPlain(cs)
+		require.NoError(t, err)
+		require.NoError(t, db.Put(dbutils.PlainAccountChangeSetBucket, dbutils.EncodeTimestamp(blockNum), b))
+	}
+
+	// block 1: contract created, block 3: self-destructed, block 5: created again
+	writeChangeSet(1, []byte{})
+	writeChangeSet(3, encode(1))
+	writeChangeSet(5, []byte{})
+	require.NoError(t, db.Put(dbutils.PlainStateBucket, contract[:], encode(2)))
+
+	require.NoError(t, GenerateIncarnationIndex(db, 0, true))
+	events, err := rawdb.ReadIncarnationEvents(db, contractHash)
+	require.NoError(t, err)
+	assert.Equal(t, []rawdb.IncarnationEvent{
+		{BlockNumber: 1, Created: true, Incarnation: 1},
+		{BlockNumber: 3, Created: false, Incarnation: 1},
+		{BlockNumber: 5, Created: true, Incarnation: 2},
+	}, events)
+
+	// the values after the flushed blocks come from the following changesets, then from the current state
+	pending := map[string]pendingAccountChange{string(contract[:]): {blockNum: 1}}
+	values, err := plainValuesAfter(db, pending, 2)
+	require.NoError(t, err)
+	assert.Equal(t, encode(1), values[string(contract[:])])
+	values, err = plainValuesAfter(db, pending, 6)
+	require.NoError(t, err)
+	assert.Equal(t, encode(2), values[string(contract[:])])
+
+	require.NoError(t, TruncateIncarnationIndex(db, 2, true))
+	events, err = rawdb.ReadIncarnationEvents(db, contractHash)
+	require.NoError(t, err)
+	assert.Equal(t, []rawdb.IncarnationEvent{{BlockNumber: 1, Created: true, Incarnation: 1}}, events)
+}
@@ -20,8 +20,9 @@ func NewIndexGenerator(db ethdb.Database) *IndexGenerator {
 }
 
 type IndexGenerator struct {
-	db    ethdb.Database
-	cache map[string][]IndexWithKey
+	db     ethdb.Database
+	cache  map[string][]IndexWithKey
+	filter ethdb.HistoryIndexFilter // The accounts excluded from the indexes are skipped, see ethdb.HistoryIndexFilter
 }
 
 type IndexWithKey struct {
@@ -30,12 +31,15 @@ type IndexWithKey struct {
 
 func (ig *IndexGenerator) changeSetWalker(blockNum uint64, indexBucket []byte) func([]byte, []byte) error {
 	return func(k, v []byte) error {
+		if !ig.filter.Indexed(k) {
+			return nil
+		}
 		cacheKey := k
 		indexes, ok := ig.cache[string(cacheKey)]
 		if !ok || len(indexes) == 0 {
 
 			indexBytes, err := ig.db.GetIndexChunk(indexBucket, k, blockNum)
-			if err != nil && err != ethdb.ErrKeyNotFound {
+			if err != nil && !errors.Is(err, ethdb.ErrKeyNotFound) {
 				return err
 			}
 			var index dbutils.HistoryIndexBytes
@@ -70,6 +74,11 @@ func (ig *IndexGenerator) changeSetWalker(blockNum uint64, indexBucket []byte) f
 
 func (ig *IndexGenerator) GenerateIndex(from uint64, changeSetBucket []byte, indexBucket []byte, walkerAdapter func([]byte) ChangesetWalker, commitHook func(db ethdb.Database, blockNum uint64) error) error {
 	batchSize := 1000000
+	filter, err := ethdb.ReadHistoryIndexFilter(ig.db)
+	if err != nil {
+		return err
+	}
+	ig.filter = filter
 	//addrHash - > index or addhash + last block for full chunk contracts
 	ig.cache = make(map[string][]IndexWithKey, batchSize)
 
@@ -104,11 +113,11 @@ func (ig *IndexGenerator) GenerateIndex(from uint64, changeSetBucket []byte, ind
 	}
 
 	var blockNum uint64
-	currentKey := dbutils.EncodeTimestamp(from)
+	next := from
 	for {
 		stop := true
-		err := ig.db.Walk(changeSetBucket, currentKey, 0, func(k, v []byte) (b bool, e error) {
-			blockNum, _ = dbutils.DecodeTimestamp(k)
+		err := ethdb.WalkChangeSets(ig.db, changeSetBucket, next, func(blockNr uint64, v []byte) (b bool, e error) {
+			blockNum = blockNr
 
 			err := walkerAdapter(v).Walk(ig.changeSetWalker(blockNum, indexBucket))
 			if err != nil {
@@ -116,7 +125,7 @@ func (ig *IndexGenerator) GenerateIndex(from uint64, changeSetBucket []byte, ind
 			}
 
 			if len(ig.cache) > batchSize {
-				currentKey = common.CopyBytes(k)
+				next = blockNum
 				stop = false
 				return false, nil
 			}
@@ -223,12 +232,9 @@ func (ig *IndexGenerator) Truncate(timestampTo uint64, changeSetBucket []byte, i
 
 func (ig *IndexGenerator) DropIndex(bucket []byte) error {
 	//todo add truncate to all db
-	if bolt, ok := ig.db.(*ethdb.BoltDatabase); ok {
+	if db, ok := ig.db.(interface{ DeleteBucket([]byte) error }); ok {
 		log.Warn("Remove bucket", "bucket", string(bucket))
-		err := bolt.DeleteBucket(bucket)
-		if err != nil {
-			return err
-		}
+		return db.DeleteBucket(bucket)
 	}
 	return errors.New("imposible to drop")
 }
@@ -0,0 +1,59 @@
+package core
+
+import (
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/core/rawdb"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+	"github.com/ledgerwatch/turbo-geth/log"
+)
+
+// GenerateLogIndex backfills the log index (see rawdb.WriteLogIndex) from the receipts of the canonical blocks,
+// starting after the block, up to which the index is already complete. It stops at the first canonical block
+// without receipts, and returns the last indexed block.
+// Generation is idempotent, so can be restarted after interruption.
+func GenerateLogIndex(db ethdb.Database) (uint64, error) {
+	var from uint64
+	progress, ok, err := rawdb.ReadLogIndexProgress(db)
+	if err != nil {
+		return 0, err
+	}
+	if ok {
+		from = progress + 1
+	}
+	log.Info("Log index generation started", "from", from)
+	batch := db.NewBatch()
+	defer batch.Rollback()
+	blockNum := from
+	for ; ; blockNum++ {
+		hash := rawdb.ReadCanonicalHash(db, blockNum)
+		if hash == (common.Hash{}) {
+			break
+		}
+		receipts := rawdb.ReadRawReceipts(db, hash, blockNum)
+		if receipts == nil {
+			break
+		}
+		if err := rawdb.WriteLogIndex(batch, blockNum, receipts); err != nil {
+			return 0, err
+		}
+		if batch.BatchSize() >= batch.IdealBatchSize() {
+			if _, err := batch.Commit(); err != nil {
+				return 0, err
+			}
+			log.Info("Committed log index batch", "up to block", blockNum)
+		}
+	}
+	if _, err := batch.Commit(); err != nil {
+		return 0, err
+	}
+	progress, ok, err = rawdb.ReadLogIndexProgress(db)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		log.Info("Log index generation finished, no receipts found")
+		return 0, nil
+	}
+	log.Info("Log index generation finished", "last block", progress)
+	return progress, nil
+}
@@ -0,0 +1,75 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+	"github.com/ledgerwatch/turbo-geth/core/rawdb"
+	"github.com/ledgerwatch/turbo-geth/core/types"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateLogIndex(t *testing.T) {
+	db := ethdb.NewMemDatabase()
+	addr1 := common.HexToAddress("0x01")
+	addr2 := common.HexToAddress("0x02")
+	topic1 := common.HexToHash("0x11")
+	topic2 := common.HexToHash("0x22")
+
+	// Logs of addr1 with topic1 every 2 blocks, of addr2 with topic2 every 700 blocks,
+	// so that the index of addr1 spans several chunks
+	const numBlocks = 3000
+	for i := uint64(0); i < numBlocks; i++ {
+		var receipts types.Receipts
+		if i%2 == 0 {
+			receipts = append(receipts, &types.Receipt{Logs: []*types.Log{{Address: addr1, Topics: []common.Hash{topic1}}}})
+		}
+		if i%700 == 0 {
+			receipts = append(receipts, &types.Receipt{Logs: []*types.Log{{Address: addr2, Topics: []common.Hash{topic1, topic2}}}})
+		}
+		hash := common.BytesToHash(dbutils.EncodeTimestamp(i))
+		rawdb.WriteCanonicalHash(db, hash, i)
+		rawdb.WriteReceipts(db, hash, i, receipts)
+	}
+
+	lastBlock, err := GenerateLogIndex(db)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(numBlocks-1), lastBlock)
+
+	blocks, err := rawdb.ReadLogIndex(db, dbutils.LogAddressIndexBucket, addr2[:], 0, numBlocks)
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{0, 700, 1400, 2100, 2800}, blocks)
+
+	blocks, err = rawdb.ReadLogIndex(db, dbutils.LogTopicIndexBucket, topic2[:], 701, 2100)
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{1400, 2100}, blocks)
+
+	blocks, err = rawdb.ReadLogIndex(db, dbutils.LogTopicIndexBucket, topic1[:], 1995, 2003)
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{1996, 1998, 2000, 2002}, blocks)
+
+	blocks, err = rawdb.ReadLogIndex(db, dbutils.LogAddressIndexBucket, addr1[:], 0, numBlocks)
+	require.NoError(t, err)
+	assert.Equal(t, numBlocks/2, len(blocks))
+
+	// Re-running is a no-op, the new blocks are appended
+	hash := common.BytesToHash(dbutils.EncodeTimestamp(numBlocks))
+	rawdb.WriteCanonicalHash(db, hash, numBlocks)
+	rawdb.WriteReceipts(db, hash, numBlocks, types.Receipts{{Logs: []*types.Log{{Address: addr2}}}})
+	lastBlock, err = GenerateLogIndex(db)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(numBlocks), lastBlock)
+	blocks, err = rawdb.ReadLogIndex(db, dbutils.LogAddressIndexBucket, addr2[:], 2000, numBlocks)
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{2100, 2800, numBlocks}, blocks)
+
+	// Blocks, which are not contiguous with the indexed ones, do not advance the progress
+	require.NoError(t, rawdb.WriteLogIndex(db, numBlocks+2, nil))
+	progress, ok, err := rawdb.ReadLogIndexProgress(db)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, uint64(numBlocks), progress)
+}
@@ -0,0 +1,71 @@
+package core
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/core/rawdb"
+	"github.com/ledgerwatch/turbo-geth/core/types"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+	"github.com/ledgerwatch/turbo-geth/log"
+	"github.com/ledgerwatch/turbo-geth/params"
+)
+
+// GenerateTxAddressIndex backfills the transaction address index (see rawdb.WriteTxAddressIndex) from the bodies
+// of the canonical blocks, starting after the block, up to which the index is already complete. The senders are taken
+// from the bodies, and recovered from the signatures if the bodies do not have them. It stops at the first canonical
+// block without the body, and returns the last indexed block.
+// Generation is idempotent, so can be restarted after interruption.
+func GenerateTxAddressIndex(db ethdb.Database, config *params.ChainConfig) (uint64, error) {
+	var from uint64
+	progress, ok, err := rawdb.ReadTxAddressIndexProgress(db)
+	if err != nil {
+		return 0, err
+	}
+	if ok {
+		from = progress + 1
+	}
+	log.Info("Transaction address index generation started", "from", from)
+	batch := db.NewBatch()
+	defer batch.Rollback()
+	for blockNum := from; ; blockNum++ {
+		hash := rawdb.ReadCanonicalHash(db, blockNum)
+		if hash == (common.Hash{}) {
+			break
+		}
+		body := rawdb.ReadBody(db, hash, blockNum)
+		if body == nil {
+			break
+		}
+		signer := types.MakeSigner(config, new(big.Int).SetUint64(blockNum))
+		senders := make([]common.Address, len(body.Transactions))
+		for i, tx := range body.Transactions {
+			if senders[i], err = types.Sender(signer, tx); err != nil {
+				return 0, fmt.Errorf("recovering sender of tx %d in block %d: %w", i, blockNum, err)
+			}
+		}
+		if err := rawdb.WriteTxAddressIndex(batch, blockNum, body.Transactions, senders); err != nil {
+			return 0, err
+		}
+		if batch.BatchSize() >= batch.IdealBatchSize() {
+			if _, err := batch.Commit(); err != nil {
+				return 0, err
+			}
+			log.Info("Committed transaction address index batch", "up to block", blockNum)
+		}
+	}
+	if _, err := batch.Commit(); err != nil {
+		return 0, err
+	}
+	progress, ok, err = rawdb.ReadTxAddressIndexProgress(db)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		log.Info("Transaction address index generation finished, no bodies found")
+		return 0, nil
+	}
+	log.Info("Transaction address index generation finished", "last block", progress)
+	return progress, nil
+}
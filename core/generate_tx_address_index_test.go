@@ -0,0 +1,100 @@
+package core
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+	"github.com/ledgerwatch/turbo-geth/core/rawdb"
+	"github.com/ledgerwatch/turbo-geth/core/types"
+	"github.com/ledgerwatch/turbo-geth/crypto"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+	"github.com/ledgerwatch/turbo-geth/params"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateTxAddressIndex(t *testing.T) {
+	db := ethdb.NewMemDatabase()
+	key, _ := crypto.GenerateKey()
+	sender := crypto.PubkeyToAddress(key.PublicKey)
+	addr1 := common.HexToAddress("0x01")
+	addr2 := common.HexToAddress("0x02")
+	signer := types.HomesteadSigner{}
+
+	writeBlock := func(number uint64, salt byte, recipients ...common.Address) {
+		var txs types.Transactions
+		for i, to := range recipients {
+			tx, err := types.SignTx(types.NewTransaction(number*10+uint64(i), to, nil, 21000, nil, nil), signer, key)
+			require.NoError(t, err)
+			txs = append(txs, tx)
+		}
+		hash := common.BytesToHash(append(dbutils.EncodeTimestamp(number), salt))
+		rawdb.WriteCanonicalHash(db, hash, number)
+		rawdb.WriteBody(context.Background(), db, hash, number, &types.Body{Transactions: txs})
+	}
+
+	// Transactions to addr1 every 2 blocks, to addr2 every 700 blocks,
+	// so that the index of addr1 and of the sender spans several chunks
+	const numBlocks = 3000
+	for i := uint64(0); i < numBlocks; i++ {
+		var recipients []common.Address
+		if i%2 == 0 {
+			recipients = append(recipients, addr1)
+		}
+		if i%700 == 0 {
+			recipients = append(recipients, addr2)
+		}
+		writeBlock(i, 0, recipients...)
+	}
+
+	lastBlock, err := GenerateTxAddressIndex(db, params.TestChainConfig)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(numBlocks-1), lastBlock)
+
+	blocks, err := rawdb.ReadTxAddressIndex(db, addr2, 0, numBlocks)
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{0, 700, 1400, 2100, 2800}, blocks)
+	blocks, err = rawdb.ReadTxAddressIndex(db, sender, 1995, 2003)
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{1996, 1998, 2000, 2002}, blocks)
+	blocks, err = rawdb.ReadTxAddressIndex(db, addr1, 0, numBlocks)
+	require.NoError(t, err)
+	assert.Equal(t, numBlocks/2, len(blocks))
+
+	// After the unwind, the blocks of the new chain replace the abandoned ones in the current chunks of their addresses,
+	// the abandoned block 2800 is left in the index of addr2 as a candidate
+	require.NoError(t, rawdb.WriteTxAddressIndexProgress(db, 2799))
+	writeBlock(2800, 1, addr1)
+	writeBlock(2801, 1, addr2)
+	for i := uint64(2802); i < numBlocks; i++ {
+		writeBlock(i, 1)
+	}
+	lastBlock, err = GenerateTxAddressIndex(db, params.TestChainConfig)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(numBlocks-1), lastBlock)
+	blocks, err = rawdb.ReadTxAddressIndex(db, addr2, 2000, numBlocks)
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{2100, 2800, 2801}, blocks)
+	blocks, err = rawdb.ReadTxAddressIndex(db, addr1, 2796, numBlocks)
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{2796, 2798, 2800}, blocks)
+
+	// Only the chunks entirely below the boundary are pruned
+	chunks, err := rawdb.PruneTxAddressIndex(db, 2500)
+	require.NoError(t, err)
+	assert.Equal(t, 2, chunks) // the first chunks of addr1 and of the sender
+	pruned, err := rawdb.ReadTxAddressIndexPruned(db)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2500), pruned)
+	blocks, err = rawdb.ReadTxAddressIndex(db, addr2, 0, numBlocks)
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{0, 700, 1400, 2100, 2800, 2801}, blocks)
+	blocks, err = rawdb.ReadTxAddressIndex(db, addr1, 0, 1000)
+	require.NoError(t, err)
+	assert.Empty(t, blocks)
+	blocks, err = rawdb.ReadTxAddressIndex(db, addr1, 2500, numBlocks)
+	require.NoError(t, err)
+	assert.Equal(t, 151, len(blocks))
+}
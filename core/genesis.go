@@ -64,6 +64,10 @@ type Genesis struct {
 	Coinbase   common.Address      `json:"coinbase"`
 	Alloc      GenesisAlloc        `json:"alloc"      gencodec:"required"`
 
+	// UnhashedState keeps the state of the chain under the plain addresses and storage keys only
+	// (see rawdb.UnhashedStateSchema), the nodes have to run with the plain state execution
+	UnhashedState bool `json:"unhashedState,omitempty"`
+
 	// These fields are used for consensus tests. Please don't use them
 	// in actual genesis blocks.
 	Number     uint64      `json:"number"`
@@ -148,10 +152,10 @@ func (e *GenesisMismatchError) Error() string {
 // SetupGenesisBlock writes or updates the genesis block in db.
 // The block that will be used is:
 //
-//                          genesis == nil       genesis != nil
-//                       +------------------------------------------
-//     db has no genesis |  main-net default  |  genesis
-//     db has genesis    |  from DB           |  genesis (if compatible)
+//	                     genesis == nil       genesis != nil
+//	                  +------------------------------------------
+//	db has no genesis |  main-net default  |  genesis
+//	db has genesis    |  from DB           |  genesis (if compatible)
 //
 // The stored chain configuration will be updated if it is compatible (i.e. does not
 // specify a fork block below the local head block). In case of a conflict, the
@@ -316,7 +320,7 @@ func (g *Genesis) CommitGenesisState(db ethdb.Database, history bool) (*types.Bl
 		return nil, statedb, fmt.Errorf("can't commit genesis block with number > 0")
 	}
 	tds.SetBlockNr(0)
-	if UsePlainStateExecution {
+	if UsePlainStateExecution || g.UnhashedState {
 		blockWriter := tds.PlainStateWriter()
 		if err := statedb.CommitBlock(context.Background(), blockWriter); err != nil {
 			return nil, statedb, fmt.Errorf("cannot write state: %v", err)
@@ -325,6 +329,11 @@ func (g *Genesis) CommitGenesisState(db ethdb.Database, history bool) (*types.Bl
 		if err := blockWriter.WriteChangeSets(); err != nil {
 			return nil, statedb, fmt.Errorf("cannot write change sets: %v", err)
 		}
+		if g.UnhashedState {
+			if err := rawdb.WriteStateSchemaVersion(batch, rawdb.UnhashedStateSchema); err != nil {
+				return nil, statedb, err
+			}
+		}
 	} else {
 		blockWriter := tds.DbStateWriter()
 		if err := statedb.CommitBlock(context.Background(), blockWriter); err != nil {
@@ -334,6 +343,9 @@ func (g *Genesis) CommitGenesisState(db ethdb.Database, history bool) (*types.Bl
 		if err := blockWriter.WriteChangeSets(); err != nil {
 			return nil, statedb, fmt.Errorf("cannot write change sets: %v", err)
 		}
+		if err := blockWriter.WriteStateSize(); err != nil {
+			return nil, statedb, fmt.Errorf("cannot write state size: %v", err)
+		}
 		// Optionally write history
 		if history {
 			if err := blockWriter.WriteHistory(); err != nil {
@@ -364,6 +376,9 @@ func (g *Genesis) Commit(db ethdb.Database, history bool) (*types.Block, *state.
 	rawdb.WriteTd(db, block.Hash(), block.NumberU64(), g.Difficulty)
 	rawdb.WriteBlock(context.Background(), db, block)
 	rawdb.WriteReceipts(db, block.Hash(), block.NumberU64(), nil)
+	if err := rawdb.WriteLogIndex(db, block.NumberU64(), nil); err != nil {
+		return nil, nil, err
+	}
 	rawdb.WriteCanonicalHash(db, block.Hash(), block.NumberU64())
 	rawdb.WriteHeadBlockHash(db, block.Hash())
 	rawdb.WriteHeadFastBlockHash(db, block.Hash())
@@ -17,6 +17,7 @@
 package core
 
 import (
+	"bytes"
 	"context"
 	"math/big"
 	"reflect"
@@ -24,6 +25,7 @@ import (
 
 	"github.com/davecgh/go-spew/spew"
 	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
 	"github.com/ledgerwatch/turbo-geth/consensus/ethash"
 	"github.com/ledgerwatch/turbo-geth/core/rawdb"
 	"github.com/ledgerwatch/turbo-geth/core/state"
@@ -169,3 +171,60 @@ func TestSetupGenesis(t *testing.T) {
 		}
 	}
 }
+
+func TestUnhashedStateGenesis(t *testing.T) {
+	db := ethdb.NewMemDatabase()
+	defer db.Close()
+	contract := common.HexToAddress("0x0000000000000000000000000000000000001000")
+	genesis := &Genesis{
+		Config: params.TestChainConfig,
+		Alloc: GenesisAlloc{
+			common.HexToAddress("0x0000000000000000000000000000000000002000"): {Balance: big.NewInt(1000)},
+			contract: {
+				Balance: big.NewInt(1),
+				Code:    []byte{0x60, 0x00},
+				Storage: map[common.Hash]common.Hash{
+					common.HexToHash("0x01"): common.HexToHash("0x2a"),
+					common.HexToHash("0x02"): common.HexToHash("0x2b"),
+				},
+			},
+		},
+		UnhashedState: true,
+	}
+	block, _, err := genesis.Commit(db, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	version, err := rawdb.ReadStateSchemaVersion(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != rawdb.UnhashedStateSchema {
+		t.Errorf("wrong state schema, got %d, want %d", version, rawdb.UnhashedStateSchema)
+	}
+	if err = db.Walk(dbutils.CurrentStateBucket, nil, 0, func(k, _ []byte) (bool, error) {
+		t.Errorf("unexpected hashed state entry %x", k)
+		return true, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	root, err := state.PlainStateRoot(db, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root != block.Root() {
+		t.Errorf("wrong plain state root, got %x, want %x", root, block.Root())
+	}
+	reader, err := state.NewCurrentStateReader(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := common.HexToHash("0x01")
+	v, err := reader.ReadAccountStorage(contract, 1, &key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(v, []byte{0x2a}) {
+		t.Errorf("wrong storage value, got %x, want 2a", v)
+	}
+}
@@ -0,0 +1,120 @@
+package core
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ledgerwatch/turbo-geth/common/changeset"
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+	"github.com/ledgerwatch/turbo-geth/log"
+)
+
+// DefaultHistoryPruneInterval is how often HistoryPruner checks the head of the chain
+const DefaultHistoryPruneInterval = 10 * time.Minute
+
+// PrunedChain is the block chain, the history of which is pruned. The history index is rewritten with the block
+// insertion, which appends to it, paused (see BlockChain.WithCommittedState)
+type PrunedChain interface {
+	BlockChainer
+	WithCommittedState(f func() error) error
+}
+
+// HistoryPruner enforces the history retention policy in the background: it deletes the storage
+// and the account changesets and history, which fall out of the retention windows as the chain grows
+type HistoryPruner struct {
+	db        ethdb.Database
+	chain     PrunedChain
+	retention ethdb.HistoryRetention
+	interval  time.Duration
+	quit      chan struct{}
+	wg        sync.WaitGroup
+}
+
+// NewHistoryPruner creates the pruner, checking the head of the chain every interval
+func NewHistoryPruner(db ethdb.Database, chain PrunedChain, retention ethdb.HistoryRetention, interval time.Duration) *HistoryPruner {
+	return &HistoryPruner{
+		db:        db,
+		chain:     chain,
+		retention: retention,
+		interval:  interval,
+		quit:      make(chan struct{}),
+	}
+}
+
+// Start launches the background pruning
+func (p *HistoryPruner) Start() {
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+		for {
+			if cb := p.chain.CurrentBlock(); cb != nil {
+				if err := p.Prune(cb.NumberU64()); err != nil {
+					log.Warn("Pruning of the history failed", "err", err)
+				}
+			}
+			select {
+			case <-ticker.C:
+			case <-p.quit:
+				return
+			}
+		}
+	}()
+}
+
+// Stop terminates the background pruning and waits for the current pruning to complete
+func (p *HistoryPruner) Stop() {
+	close(p.quit)
+	p.wg.Wait()
+}
+
+// Prune deletes the history outside of the retention windows for the given head of the chain.
+// The history is pruned one epoch of the changesets at a time, so that the insertion is not paused for long
+func (p *HistoryPruner) Prune(head uint64) error {
+	storageTo, storageOk, accountTo, accountOk := p.retention.PruneTargets(head)
+	if storageOk {
+		if err := p.pruneBucket(dbutils.StorageHistoryBucket, storageTo); err != nil {
+			return err
+		}
+	}
+	if accountOk {
+		if err := p.pruneBucket(dbutils.AccountsHistoryBucket, accountTo); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *HistoryPruner) pruneBucket(hBucket []byte, to uint64) error {
+	prunedTo, pruned, err := ethdb.ReadHistoryPrunedTo(p.db, hBucket)
+	if err != nil {
+		return err
+	}
+	var from uint64
+	if pruned {
+		if to <= prunedTo {
+			return nil
+		}
+		from = prunedTo + 1
+	}
+	for stepFrom := from; stepFrom <= to; {
+		stepTo := (stepFrom/changeset.EpochSize+1)*changeset.EpochSize - 1
+		if stepTo > to {
+			stepTo = to
+		}
+		select {
+		case <-p.quit:
+			return nil
+		default:
+		}
+		if err := p.chain.WithCommittedState(func() error {
+			return ethdb.PruneHistory(p.db, hBucket, stepTo)
+		}); err != nil {
+			return err
+		}
+		stepFrom = stepTo + 1
+	}
+	return nil
+}
@@ -17,9 +17,12 @@
 package rawdb
 
 import (
+	"errors"
+
 	"github.com/ledgerwatch/turbo-geth/common"
 	"github.com/ledgerwatch/turbo-geth/common/dbutils"
 	"github.com/ledgerwatch/turbo-geth/core/types/accounts"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
 )
 
 // ReadAccount reading account object from multiple buckets of db
@@ -27,6 +30,9 @@ func ReadAccount(db DatabaseReader, addrHash common.Hash, acc *accounts.Account)
 	addrHashBytes := addrHash[:]
 	enc, err := db.Get(dbutils.CurrentStateBucket, addrHashBytes)
 	if err != nil {
+		if errors.Is(err, ethdb.ErrKeyNotFound) {
+			return false, nil
+		}
 		return false, err
 	}
 	if err = acc.DecodeForStorage(enc); err != nil {
@@ -0,0 +1,139 @@
+// Copyright 2020 The turbo-geth Authors
+// This file is part of the turbo-geth library.
+//
+// The turbo-geth library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The turbo-geth library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the turbo-geth library. If not, see <http://www.gnu.org/licenses/>.
+
+package rawdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+	"github.com/ledgerwatch/turbo-geth/rlp"
+)
+
+// CanonicalIndexRebuild is the report of RebuildCanonicalIndex
+type CanonicalIndexRebuild struct {
+	Head     uint64
+	HeadHash common.Hash
+	HeadTd   *big.Int
+	Written  int // Numbers, the canonical hash of which was missing or wrong
+	Deleted  int // Canonical hashes above the head
+}
+
+// heaviestHeader finds the header with the highest total difficulty. Of the headers with the same total difficulty,
+// the lowest one is taken, and of the same height, the one with the smallest hash, so the choice is deterministic
+func heaviestHeader(db ethdb.Database) (number uint64, hash common.Hash, td *big.Int, err error) {
+	if err = db.Walk(dbutils.HeaderPrefix, nil, 0, func(k, v []byte) (bool, error) {
+		if !dbutils.IsHeaderTDKey(k) {
+			return true, nil
+		}
+		n := binary.BigEndian.Uint64(k[:common.BlockNumberLength])
+		h := common.BytesToHash(k[common.BlockNumberLength : common.BlockNumberLength+common.HashLength])
+		t := new(big.Int)
+		if err := rlp.DecodeBytes(v, t); err != nil {
+			return false, fmt.Errorf("total difficulty of block %d %x: %w", n, h, err)
+		}
+		if td != nil {
+			if cmp := t.Cmp(td); cmp < 0 || (cmp == 0 && (n > number || (n == number && bytes.Compare(h[:], hash[:]) >= 0))) {
+				return true, nil
+			}
+		}
+		// The total difficulty without the header does not make the chain
+		if !HasHeader(db, h, n) {
+			return true, nil
+		}
+		number, hash, td = n, h, t
+		return true, nil
+	}); err != nil {
+		return 0, common.Hash{}, nil, err
+	}
+	if td == nil {
+		return 0, common.Hash{}, nil, fmt.Errorf("no headers with the total difficulty")
+	}
+	return number, hash, td, nil
+}
+
+// RebuildCanonicalIndex restores the canonical number->hash index (and the hash->number mapping of the canonical
+// headers) from the headers and their total difficulty records, in case it has been lost or corrupted, i.e. by
+// a copy or migration script. The canonical chain is the one of the header with the highest total difficulty,
+// followed by the parent hashes down to the genesis, and it becomes the head header. The head block and the state
+// are not touched, the caller has to check that the head block is still canonical
+func RebuildCanonicalIndex(db ethdb.Database) (*CanonicalIndexRebuild, error) {
+	number, hash, td, err := heaviestHeader(db)
+	if err != nil {
+		return nil, err
+	}
+	report := &CanonicalIndexRebuild{Head: number, HeadHash: hash, HeadTd: td}
+
+	batch := db.NewBatch()
+	defer batch.Rollback()
+	commit := func() error {
+		_, err := batch.Commit()
+		return err
+	}
+	for {
+		header := ReadHeader(db, hash, number)
+		if header == nil {
+			return report, fmt.Errorf("header %d %x of the canonical chain not found", number, hash)
+		}
+		// The batch keeps the slices, hash is overwritten by the next iteration
+		if ReadCanonicalHash(db, number) != hash {
+			if err = batch.Put(dbutils.HeaderPrefix, dbutils.HeaderHashKey(number), common.CopyBytes(hash[:])); err != nil {
+				return report, err
+			}
+			report.Written++
+		}
+		if n := ReadHeaderNumber(db, hash); n == nil || *n != number {
+			if err = batch.Put(dbutils.HeaderNumberPrefix, common.CopyBytes(hash[:]), dbutils.EncodeBlockNumber(number)); err != nil {
+				return report, err
+			}
+		}
+		if batch.BatchSize() >= batch.IdealBatchSize() {
+			if err = commit(); err != nil {
+				return report, err
+			}
+		}
+		if number == 0 {
+			break
+		}
+		number, hash = number-1, header.ParentHash
+	}
+
+	// The canonical hashes above the head belong to the lighter chain
+	var above [][]byte
+	if err = db.Walk(dbutils.HeaderPrefix, dbutils.EncodeBlockNumber(report.Head+1), 0, func(k, _ []byte) (bool, error) {
+		if dbutils.IsHeaderHashKey(k) {
+			above = append(above, common.CopyBytes(k))
+		}
+		return true, nil
+	}); err != nil {
+		return report, err
+	}
+	for _, k := range above {
+		if err = batch.Delete(dbutils.HeaderPrefix, k); err != nil {
+			return report, err
+		}
+	}
+	report.Deleted = len(above)
+	if err = batch.Put(dbutils.HeadHeaderKey, dbutils.HeadHeaderKey, report.HeadHash[:]); err != nil {
+		return report, err
+	}
+	return report, commit()
+}
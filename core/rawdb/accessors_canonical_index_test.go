@@ -0,0 +1,93 @@
+// Copyright 2020 The turbo-geth Authors
+// This file is part of the turbo-geth library.
+//
+// The turbo-geth library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The turbo-geth library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the turbo-geth library. If not, see <http://www.gnu.org/licenses/>.
+
+package rawdb
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/core/types"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+)
+
+func TestRebuildCanonicalIndex(t *testing.T) {
+	db := ethdb.NewMemDatabase()
+	defer db.Close()
+	// The chain of the headers with the given difficulties, from the parent
+	makeChain := func(parent *types.Header, parentTd *big.Int, difficulties []int64, extra byte) []*types.Header {
+		var chain []*types.Header
+		td := new(big.Int).Set(parentTd)
+		for _, d := range difficulties {
+			header := &types.Header{Difficulty: big.NewInt(d), Extra: []byte{extra}}
+			if parent != nil {
+				header.ParentHash = parent.Hash()
+				header.Number = new(big.Int).Add(parent.Number, common.Big1)
+			} else {
+				header.Number = new(big.Int)
+			}
+			td.Add(td, header.Difficulty)
+			WriteHeader(context.Background(), db, header)
+			WriteTd(db, header.Hash(), header.Number.Uint64(), td)
+			chain = append(chain, header)
+			parent = header
+		}
+		return chain
+	}
+	// The longer chain is lighter than the fork
+	light := makeChain(nil, new(big.Int), []int64{1, 1, 1, 1, 1}, 0)
+	heavy := makeChain(light[1], big.NewInt(2), []int64{5, 5}, 1)
+
+	// The corrupted index: the missing, wrong and stale entries
+	for _, h := range light {
+		WriteCanonicalHash(db, h.Hash(), h.Number.Uint64())
+	}
+	DeleteCanonicalHash(db, 1)
+	WriteCanonicalHash(db, common.Hash{0xff}, 0)
+
+	report, err := RebuildCanonicalIndex(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Head != 3 || report.HeadHash != heavy[1].Hash() || report.HeadTd.Cmp(big.NewInt(12)) != 0 {
+		t.Fatalf("head %d %x, td %v", report.Head, report.HeadHash, report.HeadTd)
+	}
+	if report.Written != 4 || report.Deleted != 1 {
+		t.Errorf("written %d, deleted %d, expected 4 and 1", report.Written, report.Deleted)
+	}
+	canonical := append(light[:2:2], heavy...)
+	for _, h := range canonical {
+		if got := ReadHeaderByNumber(db, h.Number.Uint64()); got == nil || got.Hash() != h.Hash() {
+			t.Errorf("canonical header %d: got %v", h.Number, got)
+		}
+	}
+	if h := ReadHeaderByNumber(db, 4); h != nil {
+		t.Errorf("canonical header above the head: %x", h.Hash())
+	}
+	if h := ReadHeaderByHash(db, light[3].Hash()); h == nil {
+		t.Errorf("non-canonical header not found by hash")
+	}
+	if head := ReadHeadHeaderHash(db); head != heavy[1].Hash() {
+		t.Errorf("head header %x, expected %x", head, heavy[1].Hash())
+	}
+
+	// The rebuild of the intact index changes nothing
+	if report, err = RebuildCanonicalIndex(db); err != nil || report.Written != 0 || report.Deleted != 0 {
+		t.Fatalf("repeated rebuild: written %d, deleted %d, %v", report.Written, report.Deleted, err)
+	}
+}
@@ -394,6 +394,14 @@ func ReadRawReceipts(db DatabaseReader, hash common.Hash, number uint64) types.R
 	if len(data) == 0 {
 		return nil
 	}
+	if types.IsDictionaryEncodedReceipts(data) {
+		receipts, err := readReceiptsWithDictionary(db, number, data)
+		if err != nil {
+			log.Error("Invalid dictionary encoded receipts", "hash", hash, "number", number, "err", err)
+			return nil
+		}
+		return receipts
+	}
 	// Convert the receipts from their storage form to their internal representation
 	storageReceipts := []*types.ReceiptForStorage{}
 	if err := rlp.DecodeBytes(data, &storageReceipts); err != nil {
@@ -574,6 +582,24 @@ func ReadBlockByHash(db DatabaseReader, hash common.Hash) *types.Block {
 	return ReadBlock(db, hash, *number)
 }
 
+// ReadHeaderByNumber retrieves the header of the canonical block
+func ReadHeaderByNumber(db DatabaseReader, number uint64) *types.Header {
+	hash := ReadCanonicalHash(db, number)
+	if hash == (common.Hash{}) {
+		return nil
+	}
+	return ReadHeader(db, hash, number)
+}
+
+// ReadHeaderByHash retrieves the header of any known block, canonical or not
+func ReadHeaderByHash(db DatabaseReader, hash common.Hash) *types.Header {
+	number := ReadHeaderNumber(db, hash)
+	if number == nil {
+		return nil
+	}
+	return ReadHeader(db, hash, *number)
+}
+
 // FIXME: implement in Turbo-Geth
 // WriteAncientBlock writes entire block data into ancient store and returns the total written size.
 func WriteAncientBlock(db DatabaseWriter, block *types.Block, receipts types.Receipts, td *big.Int) int {
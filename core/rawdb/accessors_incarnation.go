@@ -0,0 +1,241 @@
+// Copyright 2020 The turbo-geth Authors
+// This file is part of the turbo-geth library.
+//
+// The turbo-geth library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The turbo-geth library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the turbo-geth library. If not, see <http://www.gnu.org/licenses/>.
+
+package rawdb
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+	"github.com/ledgerwatch/turbo-geth/core/types/accounts"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+)
+
+// IncarnationEvent is a creation or a destruction of an account at some block
+type IncarnationEvent struct {
+	BlockNumber uint64
+	Created     bool   // false - account was destroyed (self-destructed or removed as empty)
+	Incarnation uint64 // incarnation of the created or of the destroyed account
+}
+
+const incarnationEventKeyLen = common.HashLength + 8 + 1
+
+func incarnationEventKey(addrHash common.Hash, ev IncarnationEvent) []byte {
+	key := make([]byte, incarnationEventKeyLen)
+	copy(key, addrHash[:])
+	binary.BigEndian.PutUint64(key[common.HashLength:], ev.BlockNumber)
+	// within the same block destruction goes before creation
+	if ev.Created {
+		key[common.HashLength+8] = 1
+	}
+	return key
+}
+
+// WriteIncarnationEvent records creation or destruction of the account
+func WriteIncarnationEvent(db DatabaseWriter, addrHash common.Hash, ev IncarnationEvent) error {
+	var v [8]byte
+	binary.BigEndian.PutUint64(v[:], ev.Incarnation)
+	return db.Put(dbutils.IncarnationHistoryBucket, incarnationEventKey(addrHash, ev), v[:])
+}
+
+// WriteIncarnationChange compares the encoded values of the account before and after the block,
+// and records the creation and the destruction made by the block
+func WriteIncarnationChange(db DatabaseWriter, addrHash common.Hash, blockNum uint64, before, after []byte) error {
+	var prev, next *accounts.Account
+	if len(before) > 0 {
+		prev = new(accounts.Account)
+		if err := prev.DecodeForStorage(before); err != nil {
+			return err
+		}
+	}
+	if len(after) > 0 {
+		next = new(accounts.Account)
+		if err := next.DecodeForStorage(after); err != nil {
+			return err
+		}
+	}
+	switch {
+	case prev == nil && next == nil:
+		return nil
+	case prev == nil:
+		return WriteIncarnationEvent(db, addrHash, IncarnationEvent{BlockNumber: blockNum, Created: true, Incarnation: next.Incarnation})
+	case next == nil:
+		return WriteIncarnationEvent(db, addrHash, IncarnationEvent{BlockNumber: blockNum, Created: false, Incarnation: prev.Incarnation})
+	case prev.Incarnation != next.Incarnation:
+		// Contract re-created in the same block, or deployed to the address which already had balance
+		if prev.Incarnation > 0 {
+			if err := WriteIncarnationEvent(db, addrHash, IncarnationEvent{BlockNumber: blockNum, Created: false, Incarnation: prev.Incarnation}); err != nil {
+				return err
+			}
+		}
+		return WriteIncarnationEvent(db, addrHash, IncarnationEvent{BlockNumber: blockNum, Created: true, Incarnation: next.Incarnation})
+	}
+	return nil
+}
+
+// ReadIncarnationEvents returns all creations and destructions of the account, ordered by block number
+func ReadIncarnationEvents(db ethdb.Getter, addrHash common.Hash) ([]IncarnationEvent, error) {
+	var events []IncarnationEvent
+	if err := db.Walk(dbutils.IncarnationHistoryBucket, addrHash[:], 8*common.HashLength, func(k, v []byte) (bool, error) {
+		ev, err := decodeIncarnationEvent(k, v)
+		if err != nil {
+			return false, err
+		}
+		events = append(events, ev)
+		return true, nil
+	}); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+func decodeIncarnationEvent(k, v []byte) (IncarnationEvent, error) {
+	if len(k) != incarnationEventKeyLen || len(v) != 8 {
+		return IncarnationEvent{}, fmt.Errorf("invalid incarnation history record %x: %x", k, v)
+	}
+	return IncarnationEvent{
+		BlockNumber: binary.BigEndian.Uint64(k[common.HashLength:]),
+		Created:     k[common.HashLength+8] == 1,
+		Incarnation: binary.BigEndian.Uint64(v),
+	}, nil
+}
+
+// walkIncarnationEventsBefore calls the walker for the events of the account which happened before the block,
+// the latest first, until the walker returns false. The Bolt cursor seeks to addrHash||blockNumber and steps back,
+// the other databases read the events forward
+func walkIncarnationEventsBefore(db ethdb.Getter, addrHash common.Hash, blockNumber uint64, walker func(ev IncarnationEvent) bool) error {
+	seek := make([]byte, common.HashLength+8)
+	copy(seek, addrHash[:])
+	binary.BigEndian.PutUint64(seek[common.HashLength:], blockNumber)
+
+	if hasKV, ok := db.(ethdb.HasAbstractKV); ok {
+		reversed := true
+		if err := hasKV.AbstractKV().View(context.Background(), func(tx ethdb.Tx) error {
+			if exists, err := tx.ExistsBucket(dbutils.IncarnationHistoryBucket); err != nil || !exists {
+				return err
+			}
+			c := tx.Bucket(dbutils.IncarnationHistoryBucket).Cursor()
+			reverse, ok := c.(ethdb.ReverseCursor)
+			if !ok {
+				reversed = false
+				return nil
+			}
+			if _, _, err := c.Seek(seek); err != nil {
+				return err
+			}
+			for k, v, err := reverse.Prev(); k != nil || err != nil; k, v, err = reverse.Prev() {
+				if err != nil {
+					return err
+				}
+				if !bytes.HasPrefix(k, addrHash[:]) {
+					return nil
+				}
+				ev, err := decodeIncarnationEvent(k, v)
+				if err != nil {
+					return err
+				}
+				if !walker(ev) {
+					return nil
+				}
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		if reversed {
+			return nil
+		}
+	}
+
+	var events []IncarnationEvent
+	if err := db.Walk(dbutils.IncarnationHistoryBucket, addrHash[:], 8*common.HashLength, func(k, v []byte) (bool, error) {
+		if bytes.Compare(k, seek) >= 0 {
+			return false, nil
+		}
+		ev, err := decodeIncarnationEvent(k, v)
+		if err != nil {
+			return false, err
+		}
+		events = append(events, ev)
+		return true, nil
+	}); err != nil {
+		return err
+	}
+	for i := len(events) - 1; i >= 0; i-- {
+		if !walker(events[i]) {
+			break
+		}
+	}
+	return nil
+}
+
+// ReadCreationBlock returns the block at which the account was created the last time.
+// ok == false if the index does not know about creation of the account
+func ReadCreationBlock(db ethdb.Getter, addrHash common.Hash) (blockNumber uint64, ok bool, err error) {
+	// The destructions are rare, so the creation is found a few steps back from the end of the account's events
+	err = walkIncarnationEventsBefore(db, addrHash, math.MaxUint64, func(ev IncarnationEvent) bool {
+		if ev.Created {
+			blockNumber, ok = ev.BlockNumber, true
+		}
+		return !ev.Created
+	})
+	if err != nil {
+		return 0, false, err
+	}
+	return blockNumber, ok, nil
+}
+
+// ReadIncarnationAsOf returns incarnation of the account at the beginning of the given block
+// (the same semantics as GetAsOf). It returns 0 if the account did not exist at that time,
+// or was not a contract, or the index does not know about it.
+func ReadIncarnationAsOf(db ethdb.Getter, addrHash common.Hash, blockNumber uint64) (uint64, error) {
+	// Only the last event before the block matters
+	var incarnation uint64
+	if err := walkIncarnationEventsBefore(db, addrHash, blockNumber, func(ev IncarnationEvent) bool {
+		if ev.Created {
+			incarnation = ev.Incarnation
+		}
+		return false
+	}); err != nil {
+		return 0, err
+	}
+	return incarnation, nil
+}
+
+// DeleteIncarnationEventsAfter removes events of the account which happened after the given block
+func DeleteIncarnationEventsAfter(db ethdb.Database, addrHash common.Hash, blockNumber uint64) error {
+	startKey := make([]byte, common.HashLength+8)
+	copy(startKey, addrHash[:])
+	binary.BigEndian.PutUint64(startKey[common.HashLength:], blockNumber+1)
+	var keys [][]byte
+	if err := db.Walk(dbutils.IncarnationHistoryBucket, startKey, 8*common.HashLength, func(k, _ []byte) (bool, error) {
+		keys = append(keys, common.CopyBytes(k))
+		return true, nil
+	}); err != nil {
+		return err
+	}
+	for _, k := range keys {
+		if err := db.Delete(dbutils.IncarnationHistoryBucket, k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+// Copyright 2020 The turbo-geth Authors
+// This file is part of the turbo-geth library.
+//
+// The turbo-geth library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The turbo-geth library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the turbo-geth library. If not, see <http://www.gnu.org/licenses/>.
+
+package rawdb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+)
+
+func TestReadIncarnationAsOf(t *testing.T) {
+	badgerDB, err := ethdb.NewEphemeralBadger()
+	require.NoError(t, err)
+	defer badgerDB.Close()
+	boltDB := ethdb.NewMemDatabase()
+	defer boltDB.Close()
+
+	// The neighbouring accounts check that the cursor does not step into the events of the other accounts
+	before := common.HexToHash("0x01")
+	contract := common.HexToHash("0x02")
+	after := common.HexToHash("0x03")
+	never := common.HexToHash("0x04")
+
+	// Bolt steps back with the cursor, Badger reads the events forward
+	for name, db := range map[string]ethdb.Database{"bolt": boltDB, "badger": badgerDB} {
+		for _, e := range []struct {
+			addrHash common.Hash
+			ev       IncarnationEvent
+		}{
+			{before, IncarnationEvent{BlockNumber: 1, Created: true, Incarnation: 7}},
+			// created at block 2, destroyed at block 4, re-created in the same block 6, created again at block 8
+			{contract, IncarnationEvent{BlockNumber: 2, Created: true, Incarnation: 1}},
+			{contract, IncarnationEvent{BlockNumber: 4, Created: false, Incarnation: 1}},
+			{contract, IncarnationEvent{BlockNumber: 6, Created: true, Incarnation: 2}},
+			{contract, IncarnationEvent{BlockNumber: 6, Created: false, Incarnation: 2}},
+			{contract, IncarnationEvent{BlockNumber: 6, Created: true, Incarnation: 3}},
+			{contract, IncarnationEvent{BlockNumber: 7, Created: false, Incarnation: 3}},
+			{contract, IncarnationEvent{BlockNumber: 8, Created: true, Incarnation: 4}},
+			{after, IncarnationEvent{BlockNumber: 1, Created: true, Incarnation: 9}},
+		} {
+			require.NoError(t, WriteIncarnationEvent(db, e.addrHash, e.ev))
+		}
+
+		for block, expected := range map[uint64]uint64{0: 0, 2: 0, 3: 1, 4: 1, 5: 0, 6: 0, 7: 3, 8: 0, 9: 4, 100: 4} {
+			incarnation, err := ReadIncarnationAsOf(db, contract, block)
+			require.NoError(t, err)
+			assert.Equal(t, expected, incarnation, "%s: block %d", name, block)
+		}
+		incarnation, err := ReadIncarnationAsOf(db, before, 100)
+		require.NoError(t, err)
+		assert.Equal(t, uint64(7), incarnation, name)
+		incarnation, err = ReadIncarnationAsOf(db, never, 100)
+		require.NoError(t, err)
+		assert.Equal(t, uint64(0), incarnation, name)
+
+		created, ok, err := ReadCreationBlock(db, contract)
+		require.NoError(t, err)
+		assert.True(t, ok, name)
+		assert.Equal(t, uint64(8), created, name)
+		// the last event is a destruction, the creation is found before it
+		require.NoError(t, DeleteIncarnationEventsAfter(db, contract, 7))
+		created, ok, err = ReadCreationBlock(db, contract)
+		require.NoError(t, err)
+		assert.True(t, ok, name)
+		assert.Equal(t, uint64(6), created, name)
+		_, ok, err = ReadCreationBlock(db, never)
+		require.NoError(t, err)
+		assert.False(t, ok, name)
+	}
+}
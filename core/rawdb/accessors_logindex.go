@@ -0,0 +1,147 @@
+// Copyright 2020 The turbo-geth Authors
+// This file is part of the turbo-geth library.
+//
+// The turbo-geth library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The turbo-geth library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the turbo-geth library. If not, see <http://www.gnu.org/licenses/>.
+
+package rawdb
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+	"github.com/ledgerwatch/turbo-geth/core/types"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+)
+
+// logIndexProgressKey is the key in dbutils.DatabaseInfoBucket, under which the last block is stored,
+// such that the log index covers all the blocks from genesis up to it
+var logIndexProgressKey = []byte("LogIndexProgress")
+
+// WriteLogIndex adds the block to the log index of every topic and every address found in the logs of its receipts.
+// Re-writing a block (i.e. after a reorg) drops the blocks from the current chunks of its keys, which are not below it,
+// but the blocks of the abandoned forks are not removed from the other keys, so the blocks found in the index
+// are only candidates, which have to be checked against the receipts.
+func WriteLogIndex(db ethdb.GetterPutter, number uint64, receipts types.Receipts) error {
+	topics := make(map[common.Hash]struct{})
+	addresses := make(map[common.Address]struct{})
+	for _, receipt := range receipts {
+		for _, log := range receipt.Logs {
+			addresses[log.Address] = struct{}{}
+			for _, topic := range log.Topics {
+				topics[topic] = struct{}{}
+			}
+		}
+	}
+	for topic := range topics {
+		if err := appendLogIndex(db, dbutils.LogTopicIndexBucket, topic[:], number); err != nil {
+			return err
+		}
+	}
+	for addr := range addresses {
+		if err := appendLogIndex(db, dbutils.LogAddressIndexBucket, addr[:], number); err != nil {
+			return err
+		}
+	}
+	progress, ok, err := ReadLogIndexProgress(db)
+	if err != nil {
+		return err
+	}
+	if (!ok && number == 0) || (ok && progress+1 == number) {
+		return WriteLogIndexProgress(db, number)
+	}
+	return nil
+}
+
+// appendLogIndex appends the block number to the current chunk of the key, the same way as the history index is built.
+// The blocks of the current chunk, which are not below the re-written block, belong to the abandoned chain and are dropped
+func appendLogIndex(db ethdb.GetterPutter, bucket, key []byte, number uint64) error {
+	currentChunkKey := dbutils.CurrentChunkKey(key)
+	indexBytes, err := db.Get(bucket, currentChunkKey)
+	if err != nil && !errors.Is(err, ethdb.ErrKeyNotFound) {
+		return err
+	}
+	var index dbutils.HistoryIndexBytes
+	if len(indexBytes) == 0 {
+		index = dbutils.NewHistoryIndex()
+	} else {
+		index = dbutils.WrapHistoryIndex(common.CopyBytes(indexBytes))
+		if last, ok := index.LastElement(); ok && last >= number {
+			if number == 0 {
+				index = dbutils.NewHistoryIndex()
+			} else {
+				index = index.TruncateGreater(number - 1)
+			}
+		}
+		if dbutils.CheckNewIndexChunk(index, number) {
+			// Chunk overflow, the "old" current chunk goes under the key derived from its last element
+			indexKey, err := index.Key(key)
+			if err != nil {
+				return err
+			}
+			if err := db.Put(bucket, indexKey, index); err != nil {
+				return err
+			}
+			index = dbutils.NewHistoryIndex()
+		}
+	}
+	index = index.Append(number, false)
+	return db.Put(bucket, currentChunkKey, index)
+}
+
+// ReadLogIndexProgress returns the last block, up to which the log index is complete.
+// ok == false if no blocks have been indexed
+func ReadLogIndexProgress(db ethdb.Getter) (number uint64, ok bool, err error) {
+	v, err := db.Get(dbutils.DatabaseInfoBucket, logIndexProgressKey)
+	if err != nil && !errors.Is(err, ethdb.ErrKeyNotFound) {
+		return 0, false, err
+	}
+	if len(v) != 8 {
+		return 0, false, nil
+	}
+	return binary.BigEndian.Uint64(v), true, nil
+}
+
+// WriteLogIndexProgress moves the progress of the log index, i.e. back to the unwind point,
+// so that the following blocks are re-indexed
+func WriteLogIndexProgress(db ethdb.Putter, number uint64) error {
+	var v [8]byte
+	binary.BigEndian.PutUint64(v[:], number)
+	return db.Put(dbutils.DatabaseInfoBucket, logIndexProgressKey, v[:])
+}
+
+// ReadLogIndex returns the blocks in the range [from, to], logs of which may contain the topic (for dbutils.LogTopicIndexBucket)
+// or may be emitted by the address (for dbutils.LogAddressIndexBucket), in the ascending order
+func ReadLogIndex(db ethdb.Getter, bucket, key []byte, from, to uint64) ([]uint64, error) {
+	var blocks []uint64
+	if err := db.Walk(bucket, dbutils.IndexChunkKey(key, from), 8*len(key), func(_, v []byte) (bool, error) {
+		numbers, _, err := dbutils.WrapHistoryIndex(v).Decode()
+		if err != nil {
+			return false, err
+		}
+		for _, n := range numbers {
+			if n > to {
+				return false, nil
+			}
+			if n >= from {
+				blocks = append(blocks, n)
+			}
+		}
+		return true, nil
+	}); err != nil {
+		return nil, err
+	}
+	return blocks, nil
+}
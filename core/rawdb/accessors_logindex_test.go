@@ -0,0 +1,72 @@
+// Copyright 2020 The turbo-geth Authors
+// This file is part of the turbo-geth library.
+//
+// The turbo-geth library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The turbo-geth library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the turbo-geth library. If not, see <http://www.gnu.org/licenses/>.
+
+package rawdb
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+	"github.com/ledgerwatch/turbo-geth/core/types"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+)
+
+// Tests that the block re-written after a reorg replaces the blocks of the abandoned chain in the log index
+func TestLogIndexReorg(t *testing.T) {
+	db := ethdb.NewMemDatabase()
+	defer db.Close()
+	oldAddr := common.HexToAddress("0x01")
+	newAddr := common.HexToAddress("0x02")
+	receipts := func(addrs ...common.Address) types.Receipts {
+		var logs []*types.Log
+		for _, addr := range addrs {
+			logs = append(logs, &types.Log{Address: addr})
+		}
+		return types.Receipts{&types.Receipt{Logs: logs}}
+	}
+	for number := uint64(0); number <= 3; number++ {
+		if err := WriteLogIndex(db, number, receipts(oldAddr)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Unwind to the block 1, the new chain has the logs of both addresses in the block 2
+	if err := WriteLogIndexProgress(db, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteLogIndex(db, 2, receipts(oldAddr, newAddr)); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteLogIndex(db, 3, receipts(newAddr)); err != nil {
+		t.Fatal(err)
+	}
+	if blocks, err := ReadLogIndex(db, dbutils.LogAddressIndexBucket, oldAddr[:], 0, 10); err != nil {
+		t.Fatal(err)
+	} else if want := []uint64{0, 1, 2}; !reflect.DeepEqual(blocks, want) {
+		t.Errorf("old address blocks: got %v, want %v", blocks, want)
+	}
+	if blocks, err := ReadLogIndex(db, dbutils.LogAddressIndexBucket, newAddr[:], 0, 10); err != nil {
+		t.Fatal(err)
+	} else if want := []uint64{2, 3}; !reflect.DeepEqual(blocks, want) {
+		t.Errorf("new address blocks: got %v, want %v", blocks, want)
+	}
+	if progress, ok, err := ReadLogIndexProgress(db); err != nil {
+		t.Fatal(err)
+	} else if !ok || progress != 3 {
+		t.Errorf("progress: got %d (%t), want 3", progress, ok)
+	}
+}
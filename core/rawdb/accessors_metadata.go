@@ -18,10 +18,12 @@ package rawdb
 
 import (
 	"encoding/json"
+	"errors"
 
 	"github.com/ledgerwatch/turbo-geth/common/dbutils"
 
 	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
 	"github.com/ledgerwatch/turbo-geth/log"
 	"github.com/ledgerwatch/turbo-geth/params"
 	"github.com/ledgerwatch/turbo-geth/rlp"
@@ -97,3 +99,33 @@ func WritePreimages(db DatabaseWriter, preimages map[common.Hash][]byte) {
 	dbutils.PreimageCounter.Inc(int64(len(preimages)))
 	dbutils.PreimageHitCounter.Inc(int64(len(preimages)))
 }
+
+// Layouts of the current state, recorded in the database
+const (
+	// HashedStateSchema keeps the accounts and the storage under the hashes of the addresses and the keys
+	HashedStateSchema uint8 = 0
+	// PlainStateSchema keeps the accounts and the storage under the plain addresses and keys,
+	// the hashed state is only updated to compute the state roots
+	PlainStateSchema uint8 = 1
+	// UnhashedStateSchema keeps the accounts and the storage only under the plain addresses and keys, the state
+	// roots are computed by hashing them on the fly. It is chosen at genesis (see core.Genesis.UnhashedState) and is
+	// meant for the private chains, which do not need the hashed keys to spread the state evenly
+	UnhashedStateSchema uint8 = 2
+)
+
+// ReadStateSchemaVersion retrieves the layout of the current state, the databases without the record use HashedStateSchema
+func ReadStateSchemaVersion(db DatabaseReader) (uint8, error) {
+	enc, err := db.Get(dbutils.DatabaseInfoBucket, dbutils.StateSchemaVersionKey)
+	if err != nil && !errors.Is(err, ethdb.ErrKeyNotFound) {
+		return 0, err
+	}
+	if len(enc) == 0 {
+		return HashedStateSchema, nil
+	}
+	return enc[0], nil
+}
+
+// WriteStateSchemaVersion stores the layout of the current state
+func WriteStateSchemaVersion(db DatabaseWriter, version uint8) error {
+	return db.Put(dbutils.DatabaseInfoBucket, dbutils.StateSchemaVersionKey, []byte{version})
+}
@@ -0,0 +1,194 @@
+// Copyright 2020 The turbo-geth Authors
+// This file is part of the turbo-geth library.
+//
+// The turbo-geth library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The turbo-geth library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the turbo-geth library. If not, see <http://www.gnu.org/licenses/>.
+
+package rawdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/common/changeset"
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+	"github.com/ledgerwatch/turbo-geth/core/types"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+	"github.com/ledgerwatch/turbo-geth/rlp"
+
+	lru "github.com/hashicorp/golang-lru"
+)
+
+// receiptsCompactionProgressKey is the key in dbutils.DatabaseInfoBucket, under which the first epoch
+// not yet compacted by CompactReceipts is stored
+var receiptsCompactionProgressKey = []byte("ReceiptsCompactionProgress")
+
+// ReceiptsCompactionStats is the size report of CompactReceipts
+type ReceiptsCompactionStats struct {
+	Epochs       int   // Number of the compacted epochs
+	Blocks       int   // Number of the blocks with the re-encoded receipts
+	Before       int64 // Size of the receipts before the re-encoding, in bytes
+	After        int64 // Size of the re-encoded receipts, in bytes
+	Dictionaries int64 // Growth of the dictionaries, in bytes
+}
+
+// readReceiptsDictionary reads the dictionary of the epoch, or returns nil if there is none
+func readReceiptsDictionary(db DatabaseReader, epoch uint64) (*types.ReceiptsDictionary, []byte, error) {
+	enc, err := db.Get(dbutils.ReceiptsDictionaryBucket, dbutils.EncodeBlockNumber(epoch))
+	if err != nil && !errors.Is(err, ethdb.ErrKeyNotFound) {
+		return nil, nil, err
+	}
+	if len(enc) == 0 {
+		return nil, nil, nil
+	}
+	d, err := types.DecodeReceiptsDictionary(enc)
+	if err != nil {
+		return nil, nil, err
+	}
+	return d, enc, nil
+}
+
+// receiptsDictionaryCacheLimit is the number of the decoded dictionaries kept by readReceiptsWithDictionary
+const receiptsDictionaryCacheLimit = 16
+
+// receiptsDictionaryCache is epoch => *cachedReceiptsDictionary
+var receiptsDictionaryCache, _ = lru.New(receiptsDictionaryCacheLimit)
+
+// cachedReceiptsDictionary is the decoded dictionary with its encoding, which tells whether the dictionary
+// in the database is still the same: it grows with the compaction, and the databases differ in tests
+type cachedReceiptsDictionary struct {
+	enc []byte
+	d   *types.ReceiptsDictionary
+}
+
+// readReceiptsWithDictionary decodes the receipts of the block, which have been re-encoded by CompactReceipts.
+// Comparing the encoding of the dictionary with the cached one is much cheaper than decoding it
+func readReceiptsWithDictionary(db DatabaseReader, number uint64, data []byte) (types.Receipts, error) {
+	epoch := number / changeset.EpochSize
+	enc, err := db.Get(dbutils.ReceiptsDictionaryBucket, dbutils.EncodeBlockNumber(epoch))
+	if err != nil && !errors.Is(err, ethdb.ErrKeyNotFound) {
+		return nil, err
+	}
+	if len(enc) == 0 {
+		return nil, fmt.Errorf("missing receipts dictionary of epoch %d", epoch)
+	}
+	if cached, ok := receiptsDictionaryCache.Get(epoch); ok && bytes.Equal(cached.(*cachedReceiptsDictionary).enc, enc) {
+		return types.DecodeReceiptsWithDictionary(data, cached.(*cachedReceiptsDictionary).d)
+	}
+	d, err := types.DecodeReceiptsDictionary(enc)
+	if err != nil {
+		return nil, err
+	}
+	receiptsDictionaryCache.Add(epoch, &cachedReceiptsDictionary{enc: common.CopyBytes(enc), d: d})
+	return types.DecodeReceiptsWithDictionary(data, d)
+}
+
+// CompactReceipts re-encodes the receipts of the complete epochs (changeset.EpochSize blocks) below toBlock with
+// the dictionaries of the log addresses and topics of the epochs (see types.ReceiptsDictionary), which are repeated
+// across the chain. ReadRawReceipts decodes them transparently, and the receipts written later, i.e. for the blocks
+// of another fork, keep the usual encoding. The receipts without logs are not re-encoded, as they gain nothing.
+// The compaction continues from the epoch where the previous one stopped
+func CompactReceipts(db ethdb.Database, toBlock uint64) (*ReceiptsCompactionStats, error) {
+	stats := &ReceiptsCompactionStats{}
+	var epoch uint64
+	v, err := db.Get(dbutils.DatabaseInfoBucket, receiptsCompactionProgressKey)
+	if err != nil && !errors.Is(err, ethdb.ErrKeyNotFound) {
+		return stats, err
+	}
+	if len(v) == 8 {
+		epoch = binary.BigEndian.Uint64(v)
+	}
+	for ; (epoch+1)*changeset.EpochSize <= toBlock; epoch++ {
+		if err := compactReceiptsEpoch(db, epoch, stats); err != nil {
+			return stats, err
+		}
+	}
+	return stats, nil
+}
+
+func compactReceiptsEpoch(db ethdb.Database, epoch uint64, stats *ReceiptsCompactionStats) error {
+	d, dictEnc, err := readReceiptsDictionary(db, epoch)
+	if err != nil {
+		return err
+	}
+	if d == nil {
+		d = types.NewReceiptsDictionary()
+	}
+	dictLen := d.Len()
+	epochEnd := (epoch + 1) * changeset.EpochSize
+	var keys, values [][]byte
+	if err = db.Walk(dbutils.BlockReceiptsPrefix, dbutils.EncodeBlockNumber(epoch*changeset.EpochSize), 0, func(k, v []byte) (bool, error) {
+		number := binary.BigEndian.Uint64(k[:8])
+		if number >= epochEnd {
+			return false, nil
+		}
+		if types.IsDictionaryEncodedReceipts(v) {
+			return true, nil
+		}
+		var storageReceipts []*types.ReceiptForStorage
+		if err := rlp.DecodeBytes(v, &storageReceipts); err != nil {
+			return false, fmt.Errorf("receipts of block %d: %w", number, err)
+		}
+		receipts := make(types.Receipts, len(storageReceipts))
+		var logs int
+		for i, r := range storageReceipts {
+			receipts[i] = (*types.Receipt)(r)
+			logs += len(r.Logs)
+		}
+		if logs == 0 {
+			return true, nil
+		}
+		enc, err := types.EncodeReceiptsWithDictionary(receipts, d)
+		if err != nil {
+			return false, err
+		}
+		keys = append(keys, common.CopyBytes(k))
+		values = append(values, enc)
+		stats.Blocks++
+		stats.Before += int64(len(v))
+		stats.After += int64(len(enc))
+		return true, nil
+	}); err != nil {
+		return err
+	}
+
+	batch := db.NewBatch()
+	defer batch.Rollback()
+	// The dictionary is written before the receipts referring to it
+	if d.Len() > dictLen {
+		enc, err := d.Bytes()
+		if err != nil {
+			return err
+		}
+		if err = batch.Put(dbutils.ReceiptsDictionaryBucket, dbutils.EncodeBlockNumber(epoch), enc); err != nil {
+			return err
+		}
+		stats.Dictionaries += int64(len(enc) - len(dictEnc))
+	}
+	for i, k := range keys {
+		if err = batch.Put(dbutils.BlockReceiptsPrefix, k, values[i]); err != nil {
+			return err
+		}
+	}
+	if err = batch.Put(dbutils.DatabaseInfoBucket, receiptsCompactionProgressKey, dbutils.EncodeBlockNumber(epoch+1)); err != nil {
+		return err
+	}
+	if _, err = batch.Commit(); err != nil {
+		return err
+	}
+	stats.Epochs++
+	return nil
+}
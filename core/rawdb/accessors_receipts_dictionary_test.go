@@ -0,0 +1,125 @@
+// Copyright 2020 The turbo-geth Authors
+// This file is part of the turbo-geth library.
+//
+// The turbo-geth library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The turbo-geth library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the turbo-geth library. If not, see <http://www.gnu.org/licenses/>.
+
+package rawdb
+
+import (
+	"testing"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/common/changeset"
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+	"github.com/ledgerwatch/turbo-geth/core/types"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+)
+
+func TestCompactReceipts(t *testing.T) {
+	db := ethdb.NewMemDatabase()
+	defer db.Close()
+	token := common.BytesToAddress([]byte{0x11, 0x22})
+	transfer := common.Hash{0xdd, 0xf2}
+	receiptsOf := func(number uint64) types.Receipts {
+		receipts := types.Receipts{
+			{Status: types.ReceiptStatusSuccessful, CumulativeGasUsed: number, Logs: []*types.Log{
+				{Address: token, Topics: []common.Hash{transfer, common.BigToHash(common.Big1)}, Data: []byte{byte(number)}},
+				{Address: common.BytesToAddress([]byte{byte(number)}), Topics: []common.Hash{transfer}},
+			}},
+			{Status: types.ReceiptStatusFailed, CumulativeGasUsed: number + 1},
+		}
+		for _, r := range receipts {
+			r.Bloom = types.CreateBloom(types.Receipts{r})
+		}
+		return receipts
+	}
+	blocks := []struct {
+		number uint64
+		hash   common.Hash
+	}{
+		{5, common.Hash{1}},
+		{500, common.Hash{2}},
+		{500, common.Hash{3}}, // Another fork
+		{1200, common.Hash{4}},
+		{2100, common.Hash{5}}, // The incomplete epoch
+	}
+	for _, b := range blocks {
+		WriteReceipts(db, b.hash, b.number, receiptsOf(b.number))
+	}
+	WriteReceipts(db, common.Hash{6}, 6, types.Receipts{{Status: types.ReceiptStatusSuccessful, CumulativeGasUsed: 6}})
+
+	stats, err := CompactReceipts(db, 2100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Epochs != 2 || stats.Blocks != 4 {
+		t.Fatalf("compacted %d epochs, %d blocks, expected 2 and 4", stats.Epochs, stats.Blocks)
+	}
+	if stats.After+stats.Dictionaries >= stats.Before {
+		t.Errorf("compaction does not save space: %d bytes before, %d after with %d of dictionaries", stats.Before, stats.After, stats.Dictionaries)
+	}
+	for _, b := range blocks {
+		data, err := db.Get(dbutils.BlockReceiptsPrefix, dbutils.BlockReceiptsKey(b.number, b.hash))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if compacted := types.IsDictionaryEncodedReceipts(data); compacted != (b.number < 2000) {
+			t.Errorf("block %d: compacted %t", b.number, compacted)
+		}
+		if err := checkReceiptsRLP(ReadRawReceipts(db, b.hash, b.number), receiptsOf(b.number)); err != nil {
+			t.Errorf("block %d: %v", b.number, err)
+		}
+	}
+	// The receipts without logs are left as they are
+	if data, _ := db.Get(dbutils.BlockReceiptsPrefix, dbutils.BlockReceiptsKey(6, common.Hash{6})); types.IsDictionaryEncodedReceipts(data) {
+		t.Errorf("receipts without logs are compacted")
+	}
+
+	// The compaction continues from the next epoch
+	if stats, err = CompactReceipts(db, 2100); err != nil || stats.Epochs != 0 {
+		t.Fatalf("repeated compaction: %d epochs, %v", stats.Epochs, err)
+	}
+	if stats, err = CompactReceipts(db, 3000); err != nil || stats.Epochs != 1 || stats.Blocks != 1 {
+		t.Fatalf("next compaction: %d epochs, %d blocks, %v", stats.Epochs, stats.Blocks, err)
+	}
+	if err := checkReceiptsRLP(ReadRawReceipts(db, common.Hash{5}, 2100), receiptsOf(2100)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestReceiptsDictionaryCache(t *testing.T) {
+	// The dictionaries of the same epoch differ between the databases, the cached one must not be used for the other
+	receiptsOf := func(address byte) types.Receipts {
+		receipts := types.Receipts{{Status: types.ReceiptStatusSuccessful, CumulativeGasUsed: 1, Logs: []*types.Log{
+			{Address: common.BytesToAddress([]byte{address}), Topics: []common.Hash{{address}}},
+		}}}
+		receipts[0].Bloom = types.CreateBloom(receipts)
+		return receipts
+	}
+	dbs := []ethdb.Database{ethdb.NewMemDatabase(), ethdb.NewMemDatabase()}
+	for i, db := range dbs {
+		defer db.Close()
+		WriteReceipts(db, common.Hash{1}, 1, receiptsOf(byte(i+1)))
+		if _, err := CompactReceipts(db, changeset.EpochSize); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for round := 0; round < 2; round++ {
+		for i, db := range dbs {
+			if err := checkReceiptsRLP(ReadRawReceipts(db, common.Hash{1}, 1), receiptsOf(byte(i+1))); err != nil {
+				t.Errorf("database %d, round %d: %v", i, round, err)
+			}
+		}
+	}
+}
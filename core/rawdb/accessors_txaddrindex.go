@@ -0,0 +1,143 @@
+// Copyright 2020 The turbo-geth Authors
+// This file is part of the turbo-geth library.
+//
+// The turbo-geth library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The turbo-geth library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the turbo-geth library. If not, see <http://www.gnu.org/licenses/>.
+
+package rawdb
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+	"github.com/ledgerwatch/turbo-geth/core/types"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+)
+
+var (
+	// txAddressIndexProgressKey is the key in dbutils.DatabaseInfoBucket, under which the last block is stored,
+	// such that the transaction address index covers all the blocks from genesis (or from the pruned block) up to it
+	txAddressIndexProgressKey = []byte("TxAddressIndexProgress")
+	// txAddressIndexPrunedKey is the key in dbutils.DatabaseInfoBucket, under which the first block is stored,
+	// which is guaranteed to be kept in the index by PruneTxAddressIndex
+	txAddressIndexPrunedKey = []byte("TxAddressIndexPruned")
+)
+
+// WriteTxAddressIndex adds the block to the transaction address index of every sender and every recipient
+// of its transactions (senders are the recovered senders of the transactions, in the same order).
+// As the log index, the index is append-only, so the blocks found in it are only candidates, which have to be checked
+// against the transactions of the canonical blocks. Re-writing the blocks after the progress has been moved back
+// (see WriteTxAddressIndexProgress) replaces the blocks above them in the current chunks of their addresses
+func WriteTxAddressIndex(db ethdb.GetterPutter, number uint64, txs types.Transactions, senders []common.Address) error {
+	addresses := make(map[common.Address]struct{})
+	for i, tx := range txs {
+		if i < len(senders) {
+			addresses[senders[i]] = struct{}{}
+		}
+		if to := tx.To(); to != nil {
+			addresses[*to] = struct{}{}
+		}
+	}
+	for addr := range addresses {
+		if err := appendLogIndex(db, dbutils.TxAddressIndexBucket, addr[:], number); err != nil {
+			return err
+		}
+	}
+	progress, ok, err := ReadTxAddressIndexProgress(db)
+	if err != nil {
+		return err
+	}
+	if (!ok && number == 0) || (ok && progress+1 == number) {
+		return WriteTxAddressIndexProgress(db, number)
+	}
+	return nil
+}
+
+// ReadTxAddressIndexProgress returns the last block, up to which the transaction address index is complete.
+// ok == false if no blocks have been indexed
+func ReadTxAddressIndexProgress(db ethdb.Getter) (number uint64, ok bool, err error) {
+	return readTxAddressIndexBlock(db, txAddressIndexProgressKey)
+}
+
+// WriteTxAddressIndexProgress moves the progress of the transaction address index, i.e. back to the unwind point,
+// so that the following blocks are re-indexed
+func WriteTxAddressIndexProgress(db ethdb.Putter, number uint64) error {
+	var v [8]byte
+	binary.BigEndian.PutUint64(v[:], number)
+	return db.Put(dbutils.DatabaseInfoBucket, txAddressIndexProgressKey, v[:])
+}
+
+// ReadTxAddressIndexPruned returns the first block, which is kept in the transaction address index,
+// 0 if the index has never been pruned
+func ReadTxAddressIndexPruned(db ethdb.Getter) (uint64, error) {
+	number, _, err := readTxAddressIndexBlock(db, txAddressIndexPrunedKey)
+	return number, err
+}
+
+func readTxAddressIndexBlock(db ethdb.Getter, key []byte) (uint64, bool, error) {
+	v, err := db.Get(dbutils.DatabaseInfoBucket, key)
+	if err != nil && !errors.Is(err, ethdb.ErrKeyNotFound) {
+		return 0, false, err
+	}
+	if len(v) != 8 {
+		return 0, false, nil
+	}
+	return binary.BigEndian.Uint64(v), true, nil
+}
+
+// ReadTxAddressIndex returns the blocks in the range [from, to], which may contain the transactions
+// sent by or to the address, in the ascending order
+func ReadTxAddressIndex(db ethdb.Getter, addr common.Address, from, to uint64) ([]uint64, error) {
+	return ReadLogIndex(db, dbutils.TxAddressIndexBucket, addr[:], from, to)
+}
+
+// PruneTxAddressIndex removes the chunks of the transaction address index, all the blocks of which are below
+// the given one, and returns the number of the removed chunks. The chunks, which span the boundary, are kept whole
+func PruneTxAddressIndex(db ethdb.Database, before uint64) (int, error) {
+	pruned, err := ReadTxAddressIndexPruned(db)
+	if err != nil {
+		return 0, err
+	}
+	if before <= pruned {
+		return 0, nil
+	}
+	var keys [][]byte
+	if err := db.Walk(dbutils.TxAddressIndexBucket, nil, 0, func(k, v []byte) (bool, error) {
+		last := binary.BigEndian.Uint64(k[len(k)-8:])
+		if last == ^uint64(0) {
+			var ok bool
+			if last, ok = dbutils.WrapHistoryIndex(v).LastElement(); !ok {
+				return true, nil
+			}
+		}
+		if last < before {
+			keys = append(keys, common.CopyBytes(k))
+		}
+		return true, nil
+	}); err != nil {
+		return 0, err
+	}
+	for _, k := range keys {
+		if err := db.Delete(dbutils.TxAddressIndexBucket, k); err != nil {
+			return 0, err
+		}
+	}
+	var v [8]byte
+	binary.BigEndian.PutUint64(v[:], before)
+	if err := db.Put(dbutils.DatabaseInfoBucket, txAddressIndexPrunedKey, v[:]); err != nil {
+		return 0, err
+	}
+	return len(keys), nil
+}
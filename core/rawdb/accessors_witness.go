@@ -0,0 +1,39 @@
+// Copyright 2020 The turbo-geth Authors
+// This file is part of the turbo-geth library.
+//
+// The turbo-geth library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The turbo-geth library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the turbo-geth library. If not, see <http://www.gnu.org/licenses/>.
+
+package rawdb
+
+import (
+	"errors"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+)
+
+// ReadBlockWitness returns the serialised witness of the block, nil if it is not stored
+func ReadBlockWitness(db DatabaseReader, hash common.Hash, number uint64) ([]byte, error) {
+	witness, err := db.Get(dbutils.BlockWitnessBucket, dbutils.HeaderKey(number, hash))
+	if err != nil && !errors.Is(err, ethdb.ErrKeyNotFound) {
+		return nil, err
+	}
+	return witness, nil
+}
+
+// WriteBlockWitness stores the serialised witness of the block
+func WriteBlockWitness(db DatabaseWriter, hash common.Hash, number uint64, witness []byte) error {
+	return db.Put(dbutils.BlockWitnessBucket, dbutils.HeaderKey(number, hash), witness)
+}
@@ -0,0 +1,63 @@
+package state
+
+import (
+	"github.com/ledgerwatch/turbo-geth/common"
+)
+
+// AccessAccount marks the account as touched in the block, and returns whether it was touched before
+// (warm, in the terms of the access gas pricing). The mark is journaled, so it is removed
+// if the call frame, which made it, is reverted
+func (sdb *IntraBlockState) AccessAccount(addr common.Address) bool {
+	sdb.Lock()
+	defer sdb.Unlock()
+	return sdb.accessAccount(addr)
+}
+
+// AccessSlot marks the storage item and its account as touched in the block, and returns whether
+// they were touched before. The marks are journaled as in AccessAccount
+func (sdb *IntraBlockState) AccessSlot(addr common.Address, key common.Hash) (accountWarm bool, slotWarm bool) {
+	sdb.Lock()
+	defer sdb.Unlock()
+	accountWarm = sdb.accessAccount(addr)
+	slots := sdb.accessed[addr]
+	if _, slotWarm = slots[key]; slotWarm {
+		return accountWarm, true
+	}
+	if slots == nil {
+		slots = make(map[common.Hash]struct{})
+		sdb.accessed[addr] = slots
+	}
+	slots[key] = struct{}{}
+	sdb.journal.append(accessSlotChange{account: &addr, key: key})
+	return accountWarm, false
+}
+
+// AccountAccessed tells whether the account was touched in the block, without marking it
+func (sdb *IntraBlockState) AccountAccessed(addr common.Address) bool {
+	sdb.Lock()
+	defer sdb.Unlock()
+	_, ok := sdb.accessed[addr]
+	return ok
+}
+
+// SlotAccessed tells whether the storage item was touched in the block, without marking it
+func (sdb *IntraBlockState) SlotAccessed(addr common.Address, key common.Hash) bool {
+	sdb.Lock()
+	defer sdb.Unlock()
+	_, ok := sdb.accessed[addr][key]
+	return ok
+}
+
+// do not lock!!!
+func (sdb *IntraBlockState) accessAccount(addr common.Address) bool {
+	if _, ok := sdb.accessed[addr]; ok {
+		return true
+	}
+	if sdb.accessed == nil {
+		sdb.accessed = make(map[common.Address]map[common.Hash]struct{})
+	}
+	// The slots are allocated on the first access of a storage item
+	sdb.accessed[addr] = nil
+	sdb.journal.append(accessAccountChange{account: &addr})
+	return false
+}
@@ -0,0 +1,54 @@
+package state
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+)
+
+func TestAccessSetRevert(t *testing.T) {
+	db := ethdb.NewMemDatabase()
+	defer db.Close()
+	ibs := New(NewDbStateReader(db))
+	addr1, addr2 := common.HexToAddress("0x01"), common.HexToAddress("0x02")
+	key1, key2 := common.HexToHash("0x01"), common.HexToHash("0x02")
+
+	assert.False(t, ibs.AccessAccount(addr1))
+	assert.True(t, ibs.AccessAccount(addr1))
+	accountWarm, slotWarm := ibs.AccessSlot(addr1, key1)
+	assert.True(t, accountWarm)
+	assert.False(t, slotWarm)
+
+	snapshot := ibs.Snapshot()
+	accountWarm, slotWarm = ibs.AccessSlot(addr2, key1)
+	assert.False(t, accountWarm)
+	assert.False(t, slotWarm)
+	_, slotWarm = ibs.AccessSlot(addr1, key2)
+	assert.False(t, slotWarm)
+	_, slotWarm = ibs.AccessSlot(addr1, key1)
+	assert.True(t, slotWarm)
+	ibs.RevertToSnapshot(snapshot)
+
+	// The marks of the reverted frame are gone, the earlier ones stay
+	assert.True(t, ibs.AccountAccessed(addr1))
+	assert.True(t, ibs.SlotAccessed(addr1, key1))
+	assert.False(t, ibs.SlotAccessed(addr1, key2))
+	assert.False(t, ibs.AccountAccessed(addr2))
+	assert.False(t, ibs.SlotAccessed(addr2, key1))
+}
+
+func TestAccessSetReset(t *testing.T) {
+	db := ethdb.NewMemDatabase()
+	defer db.Close()
+	ibs := New(NewDbStateReader(db))
+	addr := common.HexToAddress("0x01")
+	key := common.HexToHash("0x01")
+
+	ibs.AccessSlot(addr, key)
+	assert.NoError(t, ibs.Reset())
+	assert.False(t, ibs.AccountAccessed(addr))
+	assert.False(t, ibs.SlotAccessed(addr, key))
+}
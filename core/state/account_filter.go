@@ -0,0 +1,177 @@
+package state
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/steakknife/bloomfilter"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+	"github.com/ledgerwatch/turbo-geth/log"
+	"github.com/ledgerwatch/turbo-geth/metrics"
+)
+
+var (
+	accountFilterTestMeter = metrics.NewRegisteredMeter("state/accountfilter/test", nil)
+	accountFilterMissMeter = metrics.NewRegisteredMeter("state/accountfilter/miss", nil)
+)
+
+// AccountFilterCapacity is the expected number of accounts in the Bloom filter of existing accounts,
+// 0 disables the filter
+var AccountFilterCapacity uint64
+
+// AccountFilterFalsePositiveRate is the target false positive rate of the account filter at full capacity
+var AccountFilterFalsePositiveRate = 0.01
+
+// AccountFilterKey is the key in dbutils.DatabaseInfoBucket, under which the account filter is persisted
+var AccountFilterKey = []byte("AccountFilter")
+
+var errAccountFilterMismatch = errors.New("account filter does not match the state")
+
+// accountFilterHasher is a wrapper around the hashed address to satisfy the interface of the bloom library,
+// the hash of the address is already uniformly distributed, so its prefix is used as a 64 bit hash
+type accountFilterHasher []byte
+
+func (f accountFilterHasher) Write(p []byte) (n int, err error) { panic("not implemented") }
+func (f accountFilterHasher) Sum(b []byte) []byte               { panic("not implemented") }
+func (f accountFilterHasher) Reset()                            { panic("not implemented") }
+func (f accountFilterHasher) BlockSize() int                    { panic("not implemented") }
+func (f accountFilterHasher) Size() int                         { return 8 }
+func (f accountFilterHasher) Sum64() uint64                     { return binary.BigEndian.Uint64(f) }
+
+// AccountFilter is a Bloom filter over the hashed addresses of the accounts in CurrentStateBucket.
+// Negative answers are exact, so the readers can skip the database for the accounts which don't exist (i.e. new EOAs).
+// Accounts are never removed from the filter, deleted accounts only increase the false positive rate.
+type AccountFilter struct {
+	mu      sync.RWMutex
+	bloom   *bloomfilter.Filter
+	blockNr uint64 // block, the state of which the filter reflects
+}
+
+// NewAccountFilter creates an empty filter sized for the given number of accounts
+func NewAccountFilter(capacity uint64, falsePositiveRate float64) (*AccountFilter, error) {
+	bloom, err := bloomfilter.NewOptimal(capacity, falsePositiveRate)
+	if err != nil {
+		return nil, err
+	}
+	return &AccountFilter{bloom: bloom}, nil
+}
+
+// Add registers an existing account
+func (f *AccountFilter) Add(addrHash common.Hash) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.bloom.Add(accountFilterHasher(addrHash[:]))
+}
+
+// MayContain returns false if the account definitely does not exist
+func (f *AccountFilter) MayContain(addrHash common.Hash) bool {
+	accountFilterTestMeter.Mark(1)
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if f.bloom.Contains(accountFilterHasher(addrHash[:])) {
+		return true
+	}
+	accountFilterMissMeter.Mark(1)
+	return false
+}
+
+// SetBlockNr marks the filter as reflecting the state after the given block
+func (f *AccountFilter) SetBlockNr(blockNr uint64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.blockNr = blockNr
+}
+
+// Save persists the filter together with its block number, it has to be written
+// into the same batch as the state of that block
+func (f *AccountFilter) Save(db ethdb.Putter) error {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	enc, err := f.bloom.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	v := make([]byte, 8+len(enc))
+	binary.BigEndian.PutUint64(v, f.blockNr)
+	copy(v[8:], enc)
+	return db.Put(dbutils.DatabaseInfoBucket, AccountFilterKey, v)
+}
+
+// LoadAccountFilter loads the persisted filter, it fails if the filter was saved for a different block
+// (i.e. the state has been unwound or modified without the filter)
+func LoadAccountFilter(db ethdb.Getter, blockNr uint64) (*AccountFilter, error) {
+	v, err := db.Get(dbutils.DatabaseInfoBucket, AccountFilterKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(v) < 8 {
+		return nil, fmt.Errorf("%w: malformed record", errAccountFilterMismatch)
+	}
+	if saved := binary.BigEndian.Uint64(v); saved != blockNr {
+		return nil, fmt.Errorf("%w: saved for block %d, state is at block %d", errAccountFilterMismatch, saved, blockNr)
+	}
+	bloom := new(bloomfilter.Filter)
+	if err := bloom.UnmarshalBinary(v[8:]); err != nil {
+		return nil, err
+	}
+	return &AccountFilter{bloom: bloom, blockNr: blockNr}, nil
+}
+
+// UnwindAccountFilter keeps the filter persisted for the block `from` valid for the state unwound to the block `to`.
+// The accounts restored by the unwind are added to it, the deleted ones are kept, because the filter may have
+// extra members. The filter, which is missing or saved for a different block, is left to OpenAccountFilter to rebuild
+func UnwindAccountFilter(db ethdb.Getter, batch ethdb.Putter, from, to uint64, restored []common.Hash) error {
+	f, err := LoadAccountFilter(db, from)
+	if err != nil {
+		if errors.Is(err, errAccountFilterMismatch) || ethdb.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	for _, addrHash := range restored {
+		f.bloom.Add(accountFilterHasher(addrHash[:]))
+	}
+	f.blockNr = to
+	return f.Save(batch)
+}
+
+// RebuildAccountFilter creates the filter from the accounts of CurrentStateBucket
+func RebuildAccountFilter(db ethdb.Getter, blockNr uint64, capacity uint64, falsePositiveRate float64) (*AccountFilter, error) {
+	f, err := NewAccountFilter(capacity, falsePositiveRate)
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	var count int
+	if err := db.Walk(dbutils.CurrentStateBucket, nil, 0, func(k, _ []byte) (bool, error) {
+		if len(k) == common.HashLength {
+			f.bloom.Add(accountFilterHasher(k))
+			count++
+		}
+		return true, nil
+	}); err != nil {
+		return nil, err
+	}
+	f.blockNr = blockNr
+	log.Info("Account filter rebuilt", "accounts", count, "block", blockNr, "elapsed", common.PrettyDuration(time.Since(start)))
+	return f, nil
+}
+
+// OpenAccountFilter loads the persisted filter, or rebuilds it if it is missing or does not match the state at blockNr
+func OpenAccountFilter(db ethdb.Getter, blockNr uint64, capacity uint64, falsePositiveRate float64) (*AccountFilter, error) {
+	f, err := LoadAccountFilter(db, blockNr)
+	if err == nil {
+		return f, nil
+	}
+	if !errors.Is(err, errAccountFilterMismatch) && !ethdb.IsNotFound(err) {
+		return nil, err
+	}
+	log.Info("Rebuilding account filter", "reason", err)
+	return RebuildAccountFilter(db, blockNr, capacity, falsePositiveRate)
+}
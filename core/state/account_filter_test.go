@@ -0,0 +1,84 @@
+package state
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/core/types/accounts"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+)
+
+func TestAccountFilter(t *testing.T) {
+	db := ethdb.NewMemDatabase()
+	defer db.Close()
+
+	filter, err := NewAccountFilter(1000, 0.01)
+	require.NoError(t, err)
+
+	existing := common.HexToAddress("0x1234")
+	w := NewDbStateWriter(db, db, 1)
+	w.SetAccountFilter(filter)
+	require.NoError(t, w.UpdateAccountData(context.Background(), existing, &accounts.Account{}, &accounts.Account{Nonce: 1}))
+
+	r := NewDbStateReader(db)
+	r.SetAccountFilter(filter)
+	acc, err := r.ReadAccountData(existing)
+	require.NoError(t, err)
+	require.NotNil(t, acc)
+	assert.Equal(t, uint64(1), acc.Nonce)
+
+	// account written bypassing the filter is not visible through it
+	hidden := common.HexToAddress("0x5678")
+	require.NoError(t, NewDbStateWriter(db, db, 1).UpdateAccountData(context.Background(), hidden, &accounts.Account{}, &accounts.Account{Nonce: 2}))
+	acc, err = r.ReadAccountData(hidden)
+	require.NoError(t, err)
+	assert.Nil(t, acc, "absent in the filter, should not be looked up")
+
+	// persistence
+	filter.SetBlockNr(1)
+	require.NoError(t, filter.Save(db))
+	loaded, err := LoadAccountFilter(db, 1)
+	require.NoError(t, err)
+	existingHash, _ := common.HashData(existing[:])
+	hiddenHash, _ := common.HashData(hidden[:])
+	assert.True(t, loaded.MayContain(existingHash))
+
+	// mismatch leads to rebuild from the database
+	_, err = LoadAccountFilter(db, 2)
+	assert.True(t, errors.Is(err, errAccountFilterMismatch))
+	rebuilt, err := OpenAccountFilter(db, 2, 1000, 0.01)
+	require.NoError(t, err)
+	assert.True(t, rebuilt.MayContain(existingHash))
+	assert.True(t, rebuilt.MayContain(hiddenHash))
+}
+
+func TestUnwindAccountFilter(t *testing.T) {
+	db := ethdb.NewMemDatabase()
+	defer db.Close()
+
+	kept, _ := common.HashData([]byte{1})
+	restored, _ := common.HashData([]byte{2})
+	filter, err := NewAccountFilter(1000, 0.01)
+	require.NoError(t, err)
+	filter.Add(kept)
+	filter.SetBlockNr(10)
+	require.NoError(t, filter.Save(db))
+
+	require.NoError(t, UnwindAccountFilter(db, db, 10, 5, []common.Hash{restored}))
+	_, err = LoadAccountFilter(db, 10)
+	assert.True(t, errors.Is(err, errAccountFilterMismatch))
+	unwound, err := LoadAccountFilter(db, 5)
+	require.NoError(t, err)
+	assert.True(t, unwound.MayContain(kept), "deleted accounts stay in the filter")
+	assert.True(t, unwound.MayContain(restored))
+
+	// The filter saved for a different block is left for the rebuild
+	require.NoError(t, UnwindAccountFilter(db, db, 10, 3, nil))
+	_, err = LoadAccountFilter(db, 3)
+	assert.True(t, errors.Is(err, errAccountFilterMismatch))
+}
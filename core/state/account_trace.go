@@ -0,0 +1,81 @@
+package state
+
+import (
+	"bytes"
+	"sort"
+	"sync"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/core/types/accounts"
+	"github.com/ledgerwatch/turbo-geth/log"
+)
+
+// Accounts which decoding by the state readers is logged, see TraceAccounts
+var tracedAccounts struct {
+	sync.RWMutex
+	byHash map[common.Hash]common.Address
+}
+
+// TraceAccounts makes the state readers log every decoded account with the given address,
+// instead of printing all the accounts to stdout. Calling it without arguments disables the tracing
+func TraceAccounts(addrs ...common.Address) {
+	byHash := make(map[common.Hash]common.Address, len(addrs))
+	for _, addr := range addrs {
+		addrHash, err := common.HashData(addr[:])
+		if err != nil {
+			log.Error("Could not hash traced address", "address", addr, "err", err)
+			continue
+		}
+		byHash[addrHash] = addr
+	}
+	tracedAccounts.Lock()
+	defer tracedAccounts.Unlock()
+	if len(byHash) == 0 {
+		byHash = nil
+	}
+	tracedAccounts.byHash = byHash
+}
+
+// TracedAccounts returns the addresses passed to TraceAccounts, sorted
+func TracedAccounts() []common.Address {
+	tracedAccounts.RLock()
+	defer tracedAccounts.RUnlock()
+	addrs := make([]common.Address, 0, len(tracedAccounts.byHash))
+	for _, addr := range tracedAccounts.byHash {
+		addrs = append(addrs, addr)
+	}
+	sort.Slice(addrs, func(i, j int) bool { return bytes.Compare(addrs[i][:], addrs[j][:]) < 0 })
+	return addrs
+}
+
+// traceAccountByHash logs the decoded account (nil if not found) if its address is traced
+func traceAccountByHash(source string, addrHash common.Hash, acc *accounts.Account) {
+	tracedAccounts.RLock()
+	addr, ok := tracedAccounts.byHash[addrHash]
+	tracedAccounts.RUnlock()
+	if !ok {
+		return
+	}
+	if acc == nil {
+		log.Info("Traced account not found", "source", source, "address", addr)
+		return
+	}
+	log.Info("Traced account", "source", source, "address", addr,
+		"nonce", acc.Nonce, "balance", acc.Balance.ToBig().String(), "incarnation", acc.Incarnation,
+		"codeHash", acc.CodeHash, "root", acc.Root)
+}
+
+// traceAccount is traceAccountByHash for the readers which know the address
+func traceAccount(source string, addr common.Address, acc *accounts.Account) {
+	tracedAccounts.RLock()
+	enabled := tracedAccounts.byHash != nil
+	tracedAccounts.RUnlock()
+	if !enabled {
+		return
+	}
+	addrHash, err := common.HashData(addr[:])
+	if err != nil {
+		return
+	}
+	traceAccountByHash(source, addrHash, acc)
+}
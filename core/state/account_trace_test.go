@@ -0,0 +1,60 @@
+package state
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/core/types/accounts"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+	"github.com/ledgerwatch/turbo-geth/log"
+)
+
+func TestTraceAccounts(t *testing.T) {
+	db := ethdb.NewMemDatabase()
+	defer db.Close()
+
+	traced := common.HexToAddress("0x1234")
+	other := common.HexToAddress("0x5678")
+	absent := common.HexToAddress("0x9abc")
+	acc := accounts.NewAccount()
+	acc.Nonce = 3
+	acc.Balance.SetUint64(100)
+	w := NewPlainStateWriter(db, db, 0)
+	require.NoError(t, w.UpdateAccountData(context.Background(), traced, &accounts.Account{}, &acc))
+	require.NoError(t, w.UpdateAccountData(context.Background(), other, &accounts.Account{}, &acc))
+
+	var records []*log.Record
+	defer log.Root().SetHandler(log.Root().GetHandler())
+	log.Root().SetHandler(log.FuncHandler(func(r *log.Record) error {
+		records = append(records, r)
+		return nil
+	}))
+
+	TraceAccounts(traced, absent)
+	defer TraceAccounts()
+	require.Equal(t, []common.Address{traced, absent}, TracedAccounts())
+
+	r := NewPlainStateReader(db)
+	for _, addr := range []common.Address{traced, other, absent} {
+		_, err := r.ReadAccountData(addr)
+		require.NoError(t, err)
+	}
+	require.Len(t, records, 2)
+	require.Equal(t, "Traced account", records[0].Msg)
+	require.Equal(t, []interface{}{"source", "PlainStateReader", "address", traced,
+		"nonce", uint64(3), "balance", "100", "incarnation", uint64(0),
+		"codeHash", acc.CodeHash, "root", acc.Root}, records[0].Ctx)
+	require.Equal(t, "Traced account not found", records[1].Msg)
+	require.Equal(t, []interface{}{"source", "PlainStateReader", "address", absent}, records[1].Ctx)
+
+	// Disabled tracing does not log anything
+	records = nil
+	TraceAccounts()
+	require.Empty(t, TracedAccounts())
+	_, err := r.ReadAccountData(traced)
+	require.NoError(t, err)
+	require.Empty(t, records)
+}
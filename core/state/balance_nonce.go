@@ -0,0 +1,35 @@
+package state
+
+import (
+	"github.com/holiman/uint256"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+)
+
+var (
+	_ BalanceNonceReader = (*DbStateReader)(nil)
+	_ BalanceNonceReader = (*PlainStateReader)(nil)
+)
+
+// BalanceNonceReader is implemented by the state readers, which can read the balance and the nonce of an account
+// (all that the transaction pool and the checks of the gas payments need) without decoding the whole account
+type BalanceNonceReader interface {
+	// ReadAccountBalanceNonce sets the balance and returns the nonce of the account, exists is false
+	// (and the balance is zero) if there is no such account
+	ReadAccountBalanceNonce(address common.Address, balance *uint256.Int) (nonce uint64, exists bool, err error)
+}
+
+// ReadAccountBalanceNonce reads the balance and the nonce of the account, via BalanceNonceReader if the reader
+// implements it, otherwise from the account decoded by ReadAccountData
+func ReadAccountBalanceNonce(r StateReader, address common.Address, balance *uint256.Int) (nonce uint64, exists bool, err error) {
+	if br, ok := r.(BalanceNonceReader); ok {
+		return br.ReadAccountBalanceNonce(address, balance)
+	}
+	acc, err := r.ReadAccountData(address)
+	if err != nil || acc == nil {
+		balance.Clear()
+		return 0, false, err
+	}
+	balance.Set(&acc.Balance)
+	return acc.Nonce, true, nil
+}
@@ -0,0 +1,48 @@
+package state
+
+import (
+	"context"
+	"testing"
+
+	"github.com/holiman/uint256"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/core/types/accounts"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+)
+
+func TestReadAccountBalanceNonce(t *testing.T) {
+	db := ethdb.NewMemDatabase()
+	defer db.Close()
+	addr := common.HexToAddress("0x1234")
+	acc := accounts.NewAccount()
+	acc.Initialised = true
+	acc.Nonce = 7
+	acc.Balance.SetUint64(1000)
+	acc.Incarnation = FirstContractIncarnation
+	acc.CodeHash = common.HexToHash("0xc0de")
+	empty := accounts.NewAccount()
+	require.NoError(t, NewDbStateWriter(db, db, 1).UpdateAccountData(context.Background(), addr, &empty, &acc))
+
+	balance := uint256.NewInt().SetUint64(1)
+	nonce, exists, err := NewDbStateReader(db).ReadAccountBalanceNonce(addr, balance)
+	require.NoError(t, err)
+	assert.True(t, exists)
+	assert.Equal(t, acc.Nonce, nonce)
+	assert.Equal(t, acc.Balance.Uint64(), balance.Uint64())
+
+	nonce, exists, err = NewDbStateReader(db).ReadAccountBalanceNonce(common.HexToAddress("0x5678"), balance)
+	require.NoError(t, err)
+	assert.False(t, exists)
+	assert.Equal(t, uint64(0), nonce)
+	assert.True(t, balance.IsZero())
+
+	// The readers without the fast path decode the whole account
+	nonce, exists, err = ReadAccountBalanceNonce(struct{ StateReader }{NewDbStateReader(db)}, addr, balance)
+	require.NoError(t, err)
+	assert.True(t, exists)
+	assert.Equal(t, acc.Nonce, nonce)
+	assert.Equal(t, acc.Balance.Uint64(), balance.Uint64())
+}
@@ -157,6 +157,15 @@ func (w *ChangeSetWriter) WriteAccountStorage(ctx context.Context, address commo
 	return nil
 }
 
+// DeleteStorage records the removal of the storage item, given by the composite key in the format of the changeset,
+// together with the account. The original value recorded by WriteAccountStorage takes precedence
+func (w *ChangeSetWriter) DeleteStorage(address common.Address, compositeKey, original []byte) {
+	if _, ok := w.storageChanges[string(compositeKey)]; !ok {
+		w.storageChanges[string(compositeKey)] = original
+	}
+	w.storageChanged[address] = true
+}
+
 func (w *ChangeSetWriter) CreateContract(address common.Address) error {
 	return nil
 }
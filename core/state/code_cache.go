@@ -0,0 +1,90 @@
+package state
+
+import (
+	"sync"
+
+	"github.com/VictoriaMetrics/fastcache"
+	"golang.org/x/sync/singleflight"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+	"github.com/ledgerwatch/turbo-geth/metrics"
+)
+
+var (
+	codeCacheHitMeter  = metrics.NewRegisteredMeter("state/codecache/hit", nil)
+	codeCacheMissMeter = metrics.NewRegisteredMeter("state/codecache/miss", nil)
+)
+
+// CodeCacheSize is the size in bytes of the process-wide cache of contract code (see SharedCodeCache), 0 disables the cache.
+// It has to be set before the first state read
+var CodeCacheSize = 64 * 1024 * 1024
+
+// Larger entries are not supported by fastcache.Set, such code (only possible before EIP-170) is always read from the database
+const maxCachedCodeSize = 64*1024 - common.HashLength - 4
+
+const codeCacheShards = 16
+
+// CodeCache is the cache of contract code keyed by code hash. Code is immutable, so the cache is never invalidated,
+// and it is shared by all the state readers and copies of TrieDbState. Lookups only take the locks of the fastcache buckets,
+// concurrent loads of the same code are merged, so that the database is read once
+type CodeCache struct {
+	cache *fastcache.Cache
+	loads [codeCacheShards]singleflight.Group
+}
+
+// NewCodeCache creates the code cache bounded by maxBytes
+func NewCodeCache(maxBytes int) *CodeCache {
+	return &CodeCache{cache: fastcache.New(maxBytes)}
+}
+
+var sharedCodeCache struct {
+	once  sync.Once
+	cache *CodeCache
+}
+
+// SharedCodeCache returns the process-wide code cache, or nil if it is disabled
+func SharedCodeCache() *CodeCache {
+	sharedCodeCache.once.Do(func() {
+		if CodeCacheSize > 0 {
+			sharedCodeCache.cache = NewCodeCache(CodeCacheSize)
+		}
+	})
+	return sharedCodeCache.cache
+}
+
+// Code returns the code with the given hash, loading it from db on a miss. The returned slice must not be modified
+func (c *CodeCache) Code(db ethdb.Getter, codeHash common.Hash) ([]byte, error) {
+	if code, ok := c.cache.HasGet(nil, codeHash[:]); ok {
+		codeCacheHitMeter.Mark(1)
+		return code, nil
+	}
+	codeCacheMissMeter.Mark(1)
+	v, err, _ := c.loads[codeHash[0]%codeCacheShards].Do(string(codeHash[:]), func() (interface{}, error) {
+		code, err := getCode(db, codeHash)
+		if err != nil {
+			return nil, err
+		}
+		if len(code) <= maxCachedCodeSize {
+			c.cache.Set(codeHash[:], code)
+		}
+		return code, nil
+	})
+	if err != nil {
+		// The merged load might have used a different database (i.e. the one without the pending batch),
+		// so the failure is only trusted when it comes from our own database
+		return getCode(db, codeHash)
+	}
+	return v.([]byte), nil
+}
+
+// readCode reads the code through the shared code cache, if it is enabled, and notes the read for the cold storage tier
+func readCode(db ethdb.Getter, codeHash common.Hash) ([]byte, error) {
+	if t := coldCodeTierInUse(); t != nil {
+		t.touch(codeHash)
+	}
+	if c := SharedCodeCache(); c != nil {
+		return c.Code(db, codeHash)
+	}
+	return getCode(db, codeHash)
+}
@@ -0,0 +1,94 @@
+package state
+
+import (
+	"bytes"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+	"github.com/ledgerwatch/turbo-geth/crypto"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+)
+
+// countingGetter counts the reads of the code bucket, and blocks them until released
+type countingGetter struct {
+	ethdb.Getter
+	codeReads int32
+	release   chan struct{}
+}
+
+func (g *countingGetter) Get(bucket, key []byte) ([]byte, error) {
+	if bytes.Equal(bucket, dbutils.CodeBucket) {
+		atomic.AddInt32(&g.codeReads, 1)
+		<-g.release
+	}
+	return g.Getter.Get(bucket, key)
+}
+
+func TestCodeCacheSingleLoad(t *testing.T) {
+	db := ethdb.NewMemDatabase()
+	code := bytes.Repeat([]byte{0x60}, 20000)
+	codeHash := crypto.Keccak256Hash(code)
+	if err := db.Put(dbutils.CodeBucket, codeHash[:], code); err != nil {
+		t.Fatal(err)
+	}
+	getter := &countingGetter{Getter: db, release: make(chan struct{})}
+	cache := NewCodeCache(32 * 1024 * 1024)
+
+	const readers = 8
+	var wg sync.WaitGroup
+	results := make([][]byte, readers)
+	errs := make([]error, readers)
+	wg.Add(readers)
+	for i := 0; i < readers; i++ {
+		i := i
+		go func() {
+			defer wg.Done()
+			results[i], errs[i] = cache.Code(getter, codeHash)
+		}()
+	}
+	close(getter.release)
+	wg.Wait()
+
+	for i := 0; i < readers; i++ {
+		if errs[i] != nil {
+			t.Fatal(errs[i])
+		}
+		if !bytes.Equal(results[i], code) {
+			t.Fatalf("reader %d got wrong code", i)
+		}
+	}
+	if reads := atomic.LoadInt32(&getter.codeReads); reads != 1 {
+		t.Errorf("expected the code to be read from the database once, got %d reads", reads)
+	}
+
+	// Served from the cache, even for another database
+	cached, err := cache.Code(ethdb.NewMemDatabase(), codeHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(cached, code) {
+		t.Errorf("wrong cached code")
+	}
+}
+
+func TestCodeCacheMissingCode(t *testing.T) {
+	cache := NewCodeCache(32 * 1024 * 1024)
+	codeHash := crypto.Keccak256Hash([]byte{0x60, 0x00})
+	if _, err := cache.Code(ethdb.NewMemDatabase(), codeHash); err == nil {
+		t.Fatal("expected error for the missing code")
+	}
+	// Missing code is not cached
+	db := ethdb.NewMemDatabase()
+	if err := db.Put(dbutils.CodeBucket, codeHash[:], []byte{0x60, 0x00}); err != nil {
+		t.Fatal(err)
+	}
+	code, err := cache.Code(db, codeHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(code, []byte{0x60, 0x00}) {
+		t.Errorf("wrong code %x", code)
+	}
+}
@@ -0,0 +1,345 @@
+package state
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+	"github.com/ledgerwatch/turbo-geth/crypto"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+	"github.com/ledgerwatch/turbo-geth/log"
+	"github.com/ledgerwatch/turbo-geth/metrics"
+)
+
+var (
+	coldCodeDemoteMeter  = metrics.NewRegisteredMeter("state/coldcode/demote", nil)
+	coldCodePromoteMeter = metrics.NewRegisteredMeter("state/coldcode/promote", nil)
+)
+
+// DefaultColdCodeInterval is how often ColdCodeTier looks for the code to move to the cold store
+const DefaultColdCodeInterval = time.Hour
+
+const day = 24 * time.Hour
+
+// coldCodeStub replaces the code moved to the cold store in dbutils.CodeBucket. It is only taken for the stub
+// under the hash of other code, so it can not be mistaken for the code that happens to be the same bytes
+var coldCodeStub = []byte{0xfe, 'c', 'o', 'l', 'd'}
+
+// ErrColdCodeTierDisabled is returned for the code moved to the cold store, when the tier is not in use
+var ErrColdCodeTierDisabled = errors.New("code has been moved to the cold store, which is not in use")
+
+func isColdCodeStub(codeHash common.Hash, code []byte) bool {
+	return bytes.Equal(code, coldCodeStub) && crypto.Keccak256Hash(code) != codeHash
+}
+
+// ColdCodeTier moves the contract code, which has not been read for a number of days, from dbutils.CodeBucket to
+// the secondary (cold) database, leaving the stub in its place. The code is moved back on the next read.
+// It saves the space of the dead contracts in the archive nodes, at the cost of the slower first read of them.
+// The reads are noted in memory and written to dbutils.CodeAccessBucket once per interval, with the precision of a day
+type ColdCodeTier struct {
+	db       ethdb.Database // Database of dbutils.CodeBucket
+	cold     ethdb.Database // Code moved out of db, under the same keys
+	after    time.Duration
+	interval time.Duration
+	now      func() time.Time
+
+	accessMu sync.Mutex
+	accessed map[common.Hash]struct{} // Read since the last flush
+
+	moveMu sync.Mutex // Keeps the promotions from interleaving with the demotions
+
+	quit chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewColdCodeTier creates the tier, moving the code unread for the given number of days to cold every interval
+func NewColdCodeTier(db, cold ethdb.Database, days int, interval time.Duration) *ColdCodeTier {
+	return &ColdCodeTier{
+		db:       db,
+		cold:     cold,
+		after:    time.Duration(days) * day,
+		interval: interval,
+		now:      time.Now,
+		accessed: make(map[common.Hash]struct{}),
+		quit:     make(chan struct{}),
+	}
+}
+
+var coldCodeTier atomic.Value // *ColdCodeTier
+
+// UseColdCodeTier makes the state readers fetch the code moved to the cold store through t, nil stops it.
+// It has to be set before the first state read, if the database has the code moved to the cold store
+func UseColdCodeTier(t *ColdCodeTier) {
+	coldCodeTier.Store(t)
+}
+
+func coldCodeTierInUse() *ColdCodeTier {
+	t, _ := coldCodeTier.Load().(*ColdCodeTier)
+	return t
+}
+
+// Start launches the background moving of the code
+func (t *ColdCodeTier) Start() {
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+		ticker := time.NewTicker(t.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-t.quit:
+				return
+			}
+			if moved, err := t.Demote(); err != nil {
+				log.Warn("Moving of the code to the cold store failed", "err", err)
+			} else if moved > 0 {
+				log.Info("Moved the code to the cold store", "contracts", moved)
+			}
+		}
+	}()
+}
+
+// Stop terminates the background moving, waits for the current run to complete and writes the pending reads
+func (t *ColdCodeTier) Stop() {
+	close(t.quit)
+	t.wg.Wait()
+	if err := t.flushAccessed(); err != nil {
+		log.Warn("Writing of the code reads failed", "err", err)
+	}
+}
+
+func (t *ColdCodeTier) today() []byte {
+	now := t.now().Unix()
+	var v [8]byte
+	binary.BigEndian.PutUint64(v[:], uint64(now-now%int64(day/time.Second)))
+	return v[:]
+}
+
+// touch notes the read of the code
+func (t *ColdCodeTier) touch(codeHash common.Hash) {
+	t.accessMu.Lock()
+	t.accessed[codeHash] = struct{}{}
+	t.accessMu.Unlock()
+}
+
+func (t *ColdCodeTier) flushAccessed() error {
+	t.accessMu.Lock()
+	accessed := t.accessed
+	t.accessed = make(map[common.Hash]struct{})
+	t.accessMu.Unlock()
+	if len(accessed) == 0 {
+		return nil
+	}
+	today := t.today()
+	batch := t.db.NewBatch()
+	defer batch.Rollback()
+	for codeHash := range accessed {
+		if err := batch.Put(dbutils.CodeAccessBucket, common.CopyBytes(codeHash[:]), today); err != nil {
+			return err
+		}
+	}
+	_, err := batch.Commit()
+	return err
+}
+
+// promote returns the code of the stub from the cold store and moves it back to the database
+func (t *ColdCodeTier) promote(codeHash common.Hash) ([]byte, error) {
+	t.moveMu.Lock()
+	defer t.moveMu.Unlock()
+	// The code could have been promoted by the concurrent read
+	if code, err := t.db.Get(dbutils.CodeBucket, codeHash[:]); err == nil && !isColdCodeStub(codeHash, code) {
+		return code, nil
+	}
+	code, err := t.cold.Get(dbutils.CodeBucket, codeHash[:])
+	if err != nil {
+		return nil, fmt.Errorf("code %x in the cold store: %w", codeHash, err)
+	}
+	if err = t.db.Put(dbutils.CodeBucket, codeHash[:], code); err != nil {
+		return nil, err
+	}
+	if err = t.cold.Delete(dbutils.CodeBucket, codeHash[:]); err != nil {
+		log.Warn("Could not delete the promoted code from the cold store", "hash", codeHash, "err", err)
+	}
+	coldCodePromoteMeter.Mark(1)
+	return code, nil
+}
+
+// Demote moves the code unread for the number of days of the tier to the cold store and returns the number of
+// the moved contracts. The code, which has never been seen read, is taken as read today
+func (t *ColdCodeTier) Demote() (int, error) {
+	if err := t.flushAccessed(); err != nil {
+		return 0, err
+	}
+	now := t.now()
+	lastRead := make(map[common.Hash]time.Time)
+	if err := t.db.Walk(dbutils.CodeAccessBucket, nil, 0, func(k, v []byte) (bool, error) {
+		if len(v) == 8 {
+			lastRead[common.BytesToHash(k)] = time.Unix(int64(binary.BigEndian.Uint64(v)), 0)
+		}
+		return true, nil
+	}); err != nil {
+		return 0, err
+	}
+	var unseen, cold []common.Hash
+	if err := t.db.Walk(dbutils.CodeBucket, nil, 0, func(k, v []byte) (bool, error) {
+		// The stubs, and the code equal to the stub, which would not be smaller in the cold store
+		if bytes.Equal(v, coldCodeStub) {
+			return true, nil
+		}
+		codeHash := common.BytesToHash(k)
+		if read, ok := lastRead[codeHash]; !ok {
+			unseen = append(unseen, codeHash)
+		} else if now.Sub(read) >= t.after {
+			cold = append(cold, codeHash)
+		}
+		return true, nil
+	}); err != nil {
+		return 0, err
+	}
+
+	if len(unseen) > 0 {
+		today := t.today()
+		batch := t.db.NewBatch()
+		defer batch.Rollback()
+		for _, codeHash := range unseen {
+			if err := batch.Put(dbutils.CodeAccessBucket, common.CopyBytes(codeHash[:]), today); err != nil {
+				return 0, err
+			}
+		}
+		if _, err := batch.Commit(); err != nil {
+			return 0, err
+		}
+	}
+	for i, codeHash := range cold {
+		select {
+		case <-t.quit:
+			return i, nil
+		default:
+		}
+		if err := t.demote(codeHash); err != nil {
+			return i, err
+		}
+	}
+	return len(cold), nil
+}
+
+// demote moves the code to the cold store. The code is written there before it is replaced by the stub,
+// so that it is not lost, if the node stops in the middle
+func (t *ColdCodeTier) demote(codeHash common.Hash) error {
+	t.moveMu.Lock()
+	defer t.moveMu.Unlock()
+	// Read since the walk
+	t.accessMu.Lock()
+	_, read := t.accessed[codeHash]
+	t.accessMu.Unlock()
+	if read {
+		return nil
+	}
+	code, err := t.db.Get(dbutils.CodeBucket, codeHash[:])
+	if err != nil {
+		return err
+	}
+	if err = t.cold.Put(dbutils.CodeBucket, codeHash[:], code); err != nil {
+		return err
+	}
+	if err = t.db.Put(dbutils.CodeBucket, codeHash[:], common.CopyBytes(coldCodeStub)); err != nil {
+		return err
+	}
+	if err = t.db.Delete(dbutils.CodeAccessBucket, codeHash[:]); err != nil {
+		return err
+	}
+	coldCodeDemoteMeter.Mark(1)
+	return nil
+}
+
+// getCode reads the code from the database, fetching it from the cold store if it has been moved there
+func getCode(db ethdb.Getter, codeHash common.Hash) ([]byte, error) {
+	code, err := db.Get(dbutils.CodeBucket, codeHash[:])
+	if err != nil || !isColdCodeStub(codeHash, code) {
+		return code, err
+	}
+	t := coldCodeTierInUse()
+	if t == nil {
+		return nil, fmt.Errorf("%w: %x", ErrColdCodeTierDisabled, codeHash)
+	}
+	return t.promote(codeHash)
+}
+
+// PeekCode reads the code like the state readers, but the code in the cold store is left there and its read is not
+// noted, so that the bulk readers (i.e. the state dump) do not bring all the code back
+func PeekCode(db ethdb.Getter, codeHash common.Hash) ([]byte, error) {
+	code, err := db.Get(dbutils.CodeBucket, codeHash[:])
+	if err != nil || !isColdCodeStub(codeHash, code) {
+		return code, err
+	}
+	t := coldCodeTierInUse()
+	if t == nil {
+		return nil, fmt.Errorf("%w: %x", ErrColdCodeTierDisabled, codeHash)
+	}
+	return t.cold.Get(dbutils.CodeBucket, codeHash[:])
+}
+
+// ColdCodeKV serves the code moved to the cold store through the transactions of kv, e.g. to the remote readers.
+// The reads of CodeBucket by the key resolve the stubs without moving the code back, the cursors see the stubs
+func ColdCodeKV(kv ethdb.KV, cold ethdb.Getter) ethdb.KV {
+	return &coldCodeKV{KV: kv, cold: cold}
+}
+
+type coldCodeKV struct {
+	ethdb.KV
+	cold ethdb.Getter
+}
+
+func (kv *coldCodeKV) View(ctx context.Context, f func(tx ethdb.Tx) error) error {
+	return kv.KV.View(ctx, func(tx ethdb.Tx) error {
+		return f(&coldCodeTx{Tx: tx, cold: kv.cold})
+	})
+}
+
+func (kv *coldCodeKV) Update(ctx context.Context, f func(tx ethdb.Tx) error) error {
+	return kv.KV.Update(ctx, func(tx ethdb.Tx) error {
+		return f(&coldCodeTx{Tx: tx, cold: kv.cold})
+	})
+}
+
+func (kv *coldCodeKV) Begin(ctx context.Context, writable bool) (ethdb.Tx, error) {
+	tx, err := kv.KV.Begin(ctx, writable)
+	if err != nil {
+		return nil, err
+	}
+	return &coldCodeTx{Tx: tx, cold: kv.cold}, nil
+}
+
+type coldCodeTx struct {
+	ethdb.Tx
+	cold ethdb.Getter
+}
+
+func (tx *coldCodeTx) Bucket(name []byte) ethdb.Bucket {
+	b := tx.Tx.Bucket(name)
+	if b == nil || !bytes.Equal(name, dbutils.CodeBucket) {
+		return b
+	}
+	return &coldCodeBucket{Bucket: b, cold: tx.cold}
+}
+
+type coldCodeBucket struct {
+	ethdb.Bucket
+	cold ethdb.Getter
+}
+
+func (b *coldCodeBucket) Get(key []byte) ([]byte, error) {
+	code, err := b.Bucket.Get(key)
+	if err != nil || len(key) != common.HashLength || !isColdCodeStub(common.BytesToHash(key), code) {
+		return code, err
+	}
+	return b.cold.Get(dbutils.CodeBucket, key)
+}
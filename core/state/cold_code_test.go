@@ -0,0 +1,115 @@
+package state
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+	"github.com/ledgerwatch/turbo-geth/crypto"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+)
+
+func TestColdCodeTier(t *testing.T) {
+	db, cold := ethdb.NewMemDatabase(), ethdb.NewMemDatabase()
+	defer db.Close()
+	defer cold.Close()
+	codes := [][]byte{[]byte("cold code tier: called"), []byte("cold code tier: dead")}
+	hashes := make([]common.Hash, len(codes))
+	for i, code := range codes {
+		hashes[i] = crypto.Keccak256Hash(code)
+		if err := db.Put(dbutils.CodeBucket, hashes[i][:], code); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The code equal to the stub is not taken for the stub
+	stubHash := crypto.Keccak256Hash(coldCodeStub)
+	if err := db.Put(dbutils.CodeBucket, stubHash[:], coldCodeStub); err != nil {
+		t.Fatal(err)
+	}
+
+	tier := NewColdCodeTier(db, cold, 30, time.Hour)
+	now := time.Unix(1600000000, 0)
+	tier.now = func() time.Time { return now }
+	UseColdCodeTier(tier)
+	defer UseColdCodeTier(nil)
+	demote := func(expected int) {
+		t.Helper()
+		if moved, err := tier.Demote(); err != nil || moved != expected {
+			t.Fatalf("moved %d, expected %d, %v", moved, expected, err)
+		}
+	}
+
+	demote(0) // The clock of the code not seen read starts
+	now = now.Add(20 * day)
+	if code, err := readCode(db, hashes[0]); err != nil || !bytes.Equal(code, codes[0]) {
+		t.Fatalf("read code %q, %v", code, err)
+	}
+	demote(0)
+	now = now.Add(11 * day)
+	demote(1)
+	if v, _ := db.Get(dbutils.CodeBucket, hashes[0][:]); !bytes.Equal(v, codes[0]) {
+		t.Errorf("code read 11 days ago is moved: %q", v)
+	}
+	if v, _ := db.Get(dbutils.CodeBucket, hashes[1][:]); !isColdCodeStub(hashes[1], v) {
+		t.Errorf("dead code is not replaced by the stub: %q", v)
+	}
+	if code, err := readCode(db, stubHash); err != nil || !bytes.Equal(code, coldCodeStub) {
+		t.Errorf("code equal to the stub %q, %v", code, err)
+	}
+
+	// The bulk reads leave the code in the cold store
+	if code, err := PeekCode(db, hashes[1]); err != nil || !bytes.Equal(code, codes[1]) {
+		t.Fatalf("peeked code %q, %v", code, err)
+	}
+	if code, err := readCode(db, hashes[1]); err != nil || !bytes.Equal(code, codes[1]) {
+		t.Fatalf("read cold code %q, %v", code, err)
+	}
+	if v, _ := db.Get(dbutils.CodeBucket, hashes[1][:]); !bytes.Equal(v, codes[1]) {
+		t.Errorf("read code is not promoted: %q", v)
+	}
+	if has, _ := cold.Has(dbutils.CodeBucket, hashes[1][:]); has {
+		t.Errorf("promoted code is left in the cold store")
+	}
+
+	UseColdCodeTier(nil)
+	if err := db.Put(dbutils.CodeBucket, hashes[1][:], coldCodeStub); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := getCode(db, hashes[1]); !errors.Is(err, ErrColdCodeTierDisabled) {
+		t.Errorf("code in the cold store without the tier: %v", err)
+	}
+}
+
+func TestColdCodeKV(t *testing.T) {
+	db, cold := ethdb.NewMemDatabase(), ethdb.NewMemDatabase()
+	defer db.Close()
+	defer cold.Close()
+	code := []byte("cold code kv")
+	codeHash := crypto.Keccak256Hash(code)
+	if err := db.Put(dbutils.CodeBucket, codeHash[:], coldCodeStub); err != nil {
+		t.Fatal(err)
+	}
+	if err := cold.Put(dbutils.CodeBucket, codeHash[:], code); err != nil {
+		t.Fatal(err)
+	}
+	// The remote readers get the code without the tier in use, the code stays in the cold store
+	if err := ColdCodeKV(db.AbstractKV(), cold).View(context.Background(), func(tx ethdb.Tx) error {
+		v, err := tx.Bucket(dbutils.CodeBucket).Get(codeHash[:])
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(v, code) {
+			t.Errorf("code %q", v)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := db.Get(dbutils.CodeBucket, codeHash[:]); !isColdCodeStub(codeHash, v) {
+		t.Errorf("code is promoted: %q", v)
+	}
+}
@@ -21,12 +21,14 @@ import (
 	"bytes"
 	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"runtime"
 	"sort"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/holiman/uint256"
 
@@ -44,6 +46,17 @@ var _ StateWriter = (*TrieStateWriter)(nil)
 // MaxTrieCacheSize is the trie cache size limit after which to evict trie nodes from memory.
 var MaxTrieCacheSize = uint64(1024 * 1024)
 
+// MaxAccountTrieCacheSize and MaxStorageTrieCacheSize are the separate limits for the account trie (with the code)
+// and for the storage tries. When any of them is set, the classes are evicted independently, and the unset limit
+// defaults to MaxTrieCacheSize
+var (
+	MaxAccountTrieCacheSize uint64
+	MaxStorageTrieCacheSize uint64
+)
+
+// TrieEvictionPolicy is the choice of the storage trie nodes to evict first, see trie.EvictionPolicy
+var TrieEvictionPolicy = trie.EvictOldest
+
 const (
 	//FirstContractIncarnation - first incarnation for contract accounts. After 1 it increases by 1.
 	FirstContractIncarnation = 1
@@ -202,6 +215,15 @@ func (b *Buffer) merge(other *Buffer) {
 	}
 }
 
+// copy returns the buffer with the copies of the collections and of the updated accounts
+func (b *Buffer) copy() *Buffer {
+	c := &Buffer{}
+	c.initialise()
+	c.merge(b)
+	c.detachAccounts()
+	return c
+}
+
 // TrieDbState implements StateReader by wrapping a trie and a database, where trie acts as a cache for the database
 type TrieDbState struct {
 	t                 *trie.Trie
@@ -214,13 +236,20 @@ type TrieDbState struct {
 	historical        bool
 	noHistory         bool
 	resolveReads      bool
+	flatHashing       bool // Compute state roots from the database instead of the in-memory trie
 	retainListBuilder *trie.RetainListBuilder
+	historyIndex      *HistoryIndexCoalescer // Buffers the updates of the history indexes, see SetHistoryCommitWindow
+	historyFilter     *historyFilterCache
 	tp                *trie.Eviction
 	newStream         trie.Stream
 	hashBuilder       *trie.HashBuilder
 	loader            *trie.SubTrieLoader
 	pw                *PreimageWriter
 	incarnationMap    map[common.Address]uint64 // Temporary map of incarnation for the cases when contracts are deleted and recreated within 1 block
+	stages            StageTimings              // Time spent in the stages of block processing since the last summary
+	collectChanges    bool                      // Keep the changes applied by UpdateStateTrie, see TakeChanges
+	changes           *StateChanges
+	hotAccounts       map[common.Hash]struct{} // Accounts kept resolved in the trie, see SetHotAccounts. Replaced, never modified
 }
 
 func NewTrieDbState(root common.Hash, db ethdb.Database, blockNr uint64) *TrieDbState {
@@ -233,6 +262,7 @@ func NewTrieDbState(root common.Hash, db ethdb.Database, blockNr uint64) *TrieDb
 		db:                db,
 		blockNr:           blockNr,
 		retainListBuilder: trie.NewRetainListBuilder(),
+		historyFilter:     &historyFilterCache{},
 		tp:                tp,
 		pw:                &PreimageWriter{db: db, savePreimages: true},
 		hashBuilder:       trie.NewHashBuilder(false),
@@ -259,28 +289,114 @@ func (tds *TrieDbState) SetResolveReads(rr bool) {
 	tds.resolveReads = rr
 }
 
+// ResolveReads tells whether the reads are recorded for the generation of the block witnesses
+func (tds *TrieDbState) ResolveReads() bool {
+	return tds.resolveReads
+}
+
 func (tds *TrieDbState) SetNoHistory(nh bool) {
 	tds.noHistory = nh
 }
 
+// SetFlatHashing switches computation of the state roots to the "flat hashing" mode, see updateTrieRootsFlat
+func (tds *TrieDbState) SetFlatHashing(fh bool) {
+	tds.flatHashing = fh
+}
+
+// SetHashingWorkers makes the computation of the state roots hash the large subtries concurrently,
+// see trie.Trie.SetHashingWorkers
+func (tds *TrieDbState) SetHashingWorkers(workers int) {
+	tds.tMu.Lock()
+	defer tds.tMu.Unlock()
+	tds.t.SetHashingWorkers(workers)
+}
+
+// SetRetainListBudget limits the memory taken by the read/change sets of a block (for the witnesses) to the given
+// number of bytes, the rest is moved into the spill database until the witness is extracted. 0 means unlimited
+func (tds *TrieDbState) SetRetainListBudget(budget uint64, spill ethdb.Database) {
+	tds.retainListBuilder.SetBudget(budget, spill)
+}
+
+// SetHistoryCommitWindow makes the block writers buffer the updates of the history indexes of the given number
+// of blocks and write them at once, see HistoryIndexCoalescer. 0 or 1 means writing them with every block
+func (tds *TrieDbState) SetHistoryCommitWindow(window uint64) {
+	if window <= 1 {
+		tds.historyIndex = nil
+		return
+	}
+	tds.historyIndex = NewHistoryIndexCoalescer(window)
+}
+
+// FlushHistoryIndex writes the buffered updates of the history indexes into the database
+func (tds *TrieDbState) FlushHistoryIndex() error {
+	if tds.historyIndex == nil {
+		return nil
+	}
+	return tds.historyIndex.Flush(tds.db)
+}
+
+// getAsOf reads the history, including the buffered updates of the history indexes
+func (tds *TrieDbState) getAsOf(bucket, hBucket, key []byte, timestamp uint64) ([]byte, error) {
+	if tds.historyIndex != nil {
+		return tds.historyIndex.GetAsOf(tds.db, bucket, hBucket, key, timestamp)
+	}
+	return tds.db.GetAsOf(bucket, hBucket, key, timestamp)
+}
+
+// Copy returns the state, which can be modified independently of the original one. The resolved part of the trie
+// and the uncommitted buffers are copied, so the copy does not start cold, and the updates of either state
+// are not visible in the other
 func (tds *TrieDbState) Copy() *TrieDbState {
 	tds.tMu.Lock()
-	tcopy := *tds.t
+	tcopy := tds.t.Copy()
+	hotAccounts := tds.hotAccounts
 	tds.tMu.Unlock()
 
 	n := tds.getBlockNr()
 	tp := trie.NewEviction()
 	tp.SetBlockNumber(n)
+	for _, addrHash := range tds.tp.Pinned() {
+		tp.Pin(addrHash)
+	}
+	tp.SetHotAccounts(hotAccountHashes(hotAccounts))
+
+	buffers := make([]*Buffer, len(tds.buffers))
+	var currentBuffer *Buffer
+	for i, b := range tds.buffers {
+		buffers[i] = b.copy()
+		if b == tds.currentBuffer {
+			currentBuffer = buffers[i]
+		}
+	}
+	var aggregateBuffer *Buffer
+	if tds.aggregateBuffer != nil {
+		aggregateBuffer = tds.aggregateBuffer.copy()
+	}
+	incarnationMap := make(map[common.Address]uint64, len(tds.incarnationMap))
+	for addr, incarnation := range tds.incarnationMap {
+		incarnationMap[addr] = incarnation
+	}
 
 	cpy := TrieDbState{
-		t:              &tcopy,
-		tMu:            new(sync.Mutex),
-		db:             tds.db,
-		blockNr:        n,
-		tp:             tp,
-		pw:             &PreimageWriter{db: tds.db, savePreimages: true},
-		hashBuilder:    trie.NewHashBuilder(false),
-		incarnationMap: make(map[common.Address]uint64),
+		t:                 tcopy,
+		tMu:               new(sync.Mutex),
+		db:                tds.db,
+		blockNr:           n,
+		buffers:           buffers,
+		aggregateBuffer:   aggregateBuffer,
+		currentBuffer:     currentBuffer,
+		historical:        tds.historical,
+		noHistory:         tds.noHistory,
+		resolveReads:      tds.resolveReads,
+		flatHashing:       tds.flatHashing,
+		retainListBuilder: trie.NewRetainListBuilder(),
+		historyFilter:     tds.historyFilter,
+		tp:                tp,
+		pw:                &PreimageWriter{db: tds.db, savePreimages: true},
+		hashBuilder:       trie.NewHashBuilder(false),
+		incarnationMap:    incarnationMap,
+		collectChanges:    tds.collectChanges,
+		hotAccounts:       hotAccounts,
 	}
 
 	cpy.t.AddObserver(tp)
@@ -332,11 +448,15 @@ func (tds *TrieDbState) WithNewBuffer() *TrieDbState {
 		historical:        tds.historical,
 		noHistory:         tds.noHistory,
 		resolveReads:      tds.resolveReads,
+		flatHashing:       tds.flatHashing,
 		retainListBuilder: tds.retainListBuilder,
+		historyFilter:     tds.historyFilter,
 		tp:                tds.tp,
 		pw:                tds.pw,
 		hashBuilder:       trie.NewHashBuilder(false),
 		incarnationMap:    make(map[common.Address]uint64),
+		collectChanges:    tds.collectChanges,
+		hotAccounts:       tds.hotAccounts,
 	}
 	tds.tMu.Unlock()
 
@@ -367,7 +487,18 @@ func (tds *TrieDbState) UpdateStateTrie() ([]common.Hash, error) {
 	tds.tMu.Lock()
 	defer tds.tMu.Unlock()
 
-	roots, err := tds.updateTrieRoots(true)
+	defer tds.recordStage(&tds.stages.TrieUpdate, trieUpdateStageTimer, time.Now())
+
+	var roots []common.Hash
+	var err error
+	if tds.flatHashing {
+		roots, err = tds.updateTrieRootsFlat()
+	} else {
+		roots, err = tds.updateTrieRoots(true)
+	}
+	if tds.collectChanges && err == nil && tds.aggregateBuffer != nil {
+		tds.changes = tds.aggregateBuffer.changes()
+	}
 	tds.clearUpdates()
 	return roots, err
 }
@@ -406,8 +537,13 @@ func (tds *TrieDbState) buildStorageReads() common.StorageKeys {
 // modifications of the contract's storage. In such case, no storage
 // item updates would be inclided.
 func (tds *TrieDbState) buildStorageWrites() (common.StorageKeys, [][]byte) {
+	return tds.aggregateBuffer.storageWrites()
+}
+
+// storageWrites returns sorted keys and values of the storage updates of the buffer
+func (b *Buffer) storageWrites() (common.StorageKeys, [][]byte) {
 	storageTouches := common.StorageKeys{}
-	for addrHash, m := range tds.aggregateBuffer.storageUpdates {
+	for addrHash, m := range b.storageUpdates {
 		for keyHash := range m {
 			var storageKey common.StorageKey
 			copy(storageKey[:], addrHash[:])
@@ -422,7 +558,7 @@ func (tds *TrieDbState) buildStorageWrites() (common.StorageKeys, [][]byte) {
 	for i, storageKey := range storageTouches {
 		copy(addrHash[:], storageKey[:])
 		copy(keyHash[:], storageKey[common.HashLength:])
-		values[i] = tds.aggregateBuffer.storageUpdates[addrHash][keyHash]
+		values[i] = b.storageUpdates[addrHash][keyHash]
 	}
 	return storageTouches, values
 }
@@ -528,6 +664,8 @@ func (tds *TrieDbState) resolveCodeTouches(
 	}
 
 	if !firstRequest {
+		// The code moved to the cold store is read back, see ColdCodeTier
+		tds.loader.SetCodeReader(readCode)
 		if _, err := loadFunc(tds.loader, nil, nil, nil); err != nil {
 			return err
 		}
@@ -587,6 +725,7 @@ func (tds *TrieDbState) ExtractTouches() (accountTouches [][]byte, storageTouche
 }
 
 func (tds *TrieDbState) resolveStateTrieWithFunc(loadFunc trie.LoadFunc) error {
+	start := time.Now()
 	// Aggregating the current buffer, if any
 	if tds.currentBuffer != nil {
 		if tds.aggregateBuffer == nil {
@@ -595,17 +734,25 @@ func (tds *TrieDbState) resolveStateTrieWithFunc(loadFunc trie.LoadFunc) error {
 		}
 		tds.aggregateBuffer.merge(tds.currentBuffer)
 	}
+	tds.recordStage(&tds.stages.Aggregate, aggregateStageTimer, start)
 	if tds.aggregateBuffer == nil {
 		return nil
 	}
+	if tds.flatHashing {
+		// Nothing to resolve, the roots are computed directly from the database
+		return nil
+	}
 
 	tds.tMu.Lock()
 	defer tds.tMu.Unlock()
 
 	// Prepare (resolve) storage tries so that actual modifications can proceed without database access
+	start = time.Now()
 	storageTouches := tds.buildStorageReads()
+	tds.recordStage(&tds.stages.StorageTouches, storageTouchesStageTimer, start)
 
 	// Prepare (resolve) accounts trie so that actual modifications can proceed without database access
+	start = time.Now()
 	accountTouches := tds.buildAccountReads()
 
 	// Prepare (resolve) contract code reads so that actual modifications can proceed without database access
@@ -613,7 +760,10 @@ func (tds *TrieDbState) resolveStateTrieWithFunc(loadFunc trie.LoadFunc) error {
 
 	// Prepare (resolve) contract code size reads so that actual modifications can proceed without database access
 	codeSizeTouches := tds.buildCodeSizeTouches()
+	tds.recordStage(&tds.stages.AccountTouches, accountTouchesStageTimer, start)
 
+	start = time.Now()
+	defer tds.recordStage(&tds.stages.Resolve, resolveStageTimer, start)
 	var err error
 	if err = tds.resolveAccountAndStorageTouches(accountTouches, storageTouches, loadFunc); err != nil {
 		return err
@@ -638,6 +788,9 @@ func (tds *TrieDbState) resolveStateTrieWithFunc(loadFunc trie.LoadFunc) error {
 // ResolveStateTrie resolves parts of the state trie that would be necessary for any updates
 // (and reads, if `resolveReads` is set).
 func (tds *TrieDbState) ResolveStateTrie(extractWitnesses bool, trace bool) ([]*trie.Witness, error) {
+	if extractWitnesses && tds.flatHashing {
+		return nil, errFlatHashingWitness
+	}
 	var witnesses []*trie.Witness
 
 	loadFunc := func(loader *trie.SubTrieLoader, rl *trie.RetainList, dbPrefixes [][]byte, fixedbits []int) (trie.SubTries, error) {
@@ -688,16 +841,17 @@ func (tds *TrieDbState) ResolveStateTrieStateless(database trie.WitnessStorage)
 func (tds *TrieDbState) CalcTrieRoots(trace bool) (common.Hash, error) {
 	tds.tMu.Lock()
 	defer tds.tMu.Unlock()
+	defer tds.recordStage(&tds.stages.RootHash, rootHashStageTimer, time.Now())
 
 	// Retrive the list of inserted/updated/deleted storage items (keys and values)
 	storageKeys, sValues := tds.buildStorageWrites()
 	if trace {
-		fmt.Printf("len(storageKeys)=%d, len(sValues)=%d\n", len(storageKeys), len(sValues))
+		log.Trace("Storage writes", "keys", len(storageKeys), "values", len(sValues))
 	}
 	// Retrive the list of inserted/updated/deleted accounts (keys and values)
 	accountKeys, aValues, aCodes := tds.buildAccountWrites()
 	if trace {
-		fmt.Printf("len(accountKeys)=%d, len(aValues)=%d\n", len(accountKeys), len(aValues))
+		log.Trace("Account writes", "keys", len(accountKeys), "values", len(aValues))
 	}
 	var hb *trie.HashBuilder
 	if trace {
@@ -824,6 +978,10 @@ func (tds *TrieDbState) GetBlockNr() uint64 {
 
 func (tds *TrieDbState) UnwindTo(blockNr uint64) error {
 	//fmt.Printf("Unwind from block %d to block %d\n", tds.blockNr, blockNr)
+	// The unwinding of the history indexes expects the unwound blocks there
+	if err := tds.FlushHistoryIndex(); err != nil {
+		return err
+	}
 	tds.StartNewBuffer()
 	b := tds.currentBuffer
 
@@ -831,6 +989,7 @@ func (tds *TrieDbState) UnwindTo(blockNr uint64) error {
 	if err != nil {
 		return err
 	}
+	var sizeDelta StateSize
 	for key, value := range accountMap {
 		var addrHash common.Hash
 		copy(addrHash[:], []byte(key))
@@ -845,11 +1004,17 @@ func (tds *TrieDbState) UnwindTo(blockNr uint64) error {
 					copy(acc.CodeHash[:], codeHash)
 				}
 			}
+			if err := sizeDelta.UnwindAccount(tds.db, addrHash[:], &acc); err != nil {
+				return err
+			}
 			b.accountUpdates[addrHash] = &acc
 			if err := rawdb.WriteAccount(tds.db, addrHash, acc); err != nil {
 				return err
 			}
 		} else {
+			if err := sizeDelta.UnwindAccount(tds.db, addrHash[:], nil); err != nil {
+				return err
+			}
 			b.accountUpdates[addrHash] = nil
 			if err := rawdb.DeleteAccount(tds.db, addrHash); err != nil {
 				return err
@@ -873,6 +1038,9 @@ func (tds *TrieDbState) UnwindTo(blockNr uint64) error {
 			b.storageReads[addrHash] = m1
 		}
 		m1[keyHash] = struct{}{}
+		if err := sizeDelta.UnwindStorage(tds.db, []byte(key)[:common.HashLength+common.IncarnationLength+common.HashLength], value); err != nil {
+			return err
+		}
 		if len(value) > 0 {
 			m[keyHash] = value
 			if err := tds.db.Put(dbutils.CurrentStateBucket, []byte(key)[:common.HashLength+common.IncarnationLength+common.HashLength], value); err != nil {
@@ -885,13 +1053,20 @@ func (tds *TrieDbState) UnwindTo(blockNr uint64) error {
 			}
 		}
 	}
+	if err := UpdateStateSize(tds.db, &sizeDelta, false); err != nil {
+		return err
+	}
 	if _, err := tds.ResolveStateTrie(false, false); err != nil {
 		return err
 	}
 
 	tds.tMu.Lock()
 	defer tds.tMu.Unlock()
-	if _, err := tds.updateTrieRoots(false); err != nil {
+	if tds.flatHashing {
+		if _, err := tds.updateTrieRootsFlat(); err != nil {
+			return err
+		}
+	} else if _, err := tds.updateTrieRoots(false); err != nil {
 		return err
 	}
 	for i := tds.blockNr; i > blockNr; i-- {
@@ -899,9 +1074,17 @@ func (tds *TrieDbState) UnwindTo(blockNr uint64) error {
 			return err
 		}
 	}
+	if err := ethdb.TruncateCompactedChangeSets(tds.db, blockNr+1); err != nil {
+		return err
+	}
 	if err := tds.truncateHistory(blockNr, accountMap, storageMap); err != nil {
 		return err
 	}
+	for key := range accountMap {
+		if err := rawdb.DeleteIncarnationEventsAfter(tds.db, common.BytesToHash([]byte(key)), blockNr); err != nil {
+			return err
+		}
+	}
 	tds.clearUpdates()
 	tds.setBlockNr(blockNr)
 	return nil
@@ -910,11 +1093,11 @@ func (tds *TrieDbState) UnwindTo(blockNr uint64) error {
 func (tds *TrieDbState) deleteTimestamp(timestamp uint64) error {
 	changeSetKey := dbutils.EncodeTimestamp(timestamp)
 	changedAccounts, err := tds.db.Get(dbutils.AccountChangeSetBucket, changeSetKey)
-	if err != nil && err != ethdb.ErrKeyNotFound {
+	if err != nil && !errors.Is(err, ethdb.ErrKeyNotFound) {
 		return err
 	}
 	changedStorage, err := tds.db.Get(dbutils.StorageChangeSetBucket, changeSetKey)
-	if err != nil && err != ethdb.ErrKeyNotFound {
+	if err != nil && !errors.Is(err, ethdb.ErrKeyNotFound) {
 		return err
 	}
 	if len(changedAccounts) > 0 {
@@ -927,7 +1110,7 @@ func (tds *TrieDbState) deleteTimestamp(timestamp uint64) error {
 			return err
 		}
 	}
-	return nil
+	return ethdb.DeleteChangeSetSummary(tds.db, timestamp)
 }
 
 func (tds *TrieDbState) truncateHistory(timestampTo uint64, accountMap map[string][]byte, storageMap map[string][]byte) error {
@@ -1009,7 +1192,7 @@ func (tds *TrieDbState) truncateHistory(timestampTo uint64, accountMap map[strin
 }
 
 func (tds *TrieDbState) readAccountDataByHash(addrHash common.Hash) (*accounts.Account, error) {
-	if acc, ok := tds.GetAccount(addrHash); ok {
+	if acc, ok := tds.getAccountMetered(addrHash); ok {
 		return acc, nil
 	}
 
@@ -1018,9 +1201,9 @@ func (tds *TrieDbState) readAccountDataByHash(addrHash common.Hash) (*accounts.A
 	var enc []byte
 	var a accounts.Account
 	if tds.historical {
-		enc, err = tds.db.GetAsOf(dbutils.CurrentStateBucket, dbutils.AccountsHistoryBucket, addrHash[:], tds.blockNr+1)
-		if err != nil {
-			enc = nil
+		enc, err = tds.getAsOf(dbutils.CurrentStateBucket, dbutils.AccountsHistoryBucket, addrHash[:], tds.blockNr+1)
+		if err != nil && !errors.Is(err, ethdb.ErrKeyNotFound) {
+			return nil, err
 		}
 		if len(enc) == 0 {
 			return nil, nil
@@ -1040,6 +1223,8 @@ func (tds *TrieDbState) readAccountDataByHash(addrHash common.Hash) (*accounts.A
 		codeHash, err := tds.db.Get(dbutils.ContractCodeBucket, dbutils.GenerateStoragePrefix(addrHash[:], a.Incarnation))
 		if err == nil {
 			a.CodeHash = common.BytesToHash(codeHash)
+		} else if !errors.Is(err, ethdb.ErrKeyNotFound) {
+			return nil, err
 		} else {
 			log.Error("Get code hash is incorrect", "err", err)
 		}
@@ -1063,7 +1248,11 @@ func (tds *TrieDbState) ReadAccountData(address common.Address) (*accounts.Accou
 		tds.currentBuffer.accountReads[addrHash] = struct{}{}
 	}
 
-	return tds.readAccountDataByHash(addrHash)
+	acc, err := tds.readAccountDataByHash(addrHash)
+	if err == nil {
+		traceAccountByHash("TrieDbState", addrHash, acc)
+	}
+	return acc, err
 }
 
 func (tds *TrieDbState) GetKey(shaKey []byte) []byte {
@@ -1076,15 +1265,8 @@ func (tds *TrieDbState) ReadAccountStorage(address common.Address, incarnation u
 	if err != nil {
 		return nil, err
 	}
-	if tds.currentBuffer != nil {
-		if _, ok := tds.currentBuffer.deleted[addrHash]; ok {
-			return nil, nil
-		}
-	}
-	if tds.aggregateBuffer != nil {
-		if _, ok := tds.aggregateBuffer.deleted[addrHash]; ok {
-			return nil, nil
-		}
+	if tds.deletedInBuffers(addrHash) {
+		return nil, nil
 	}
 	seckey, err := tds.pw.HashKey(key, false /*save*/)
 	if err != nil {
@@ -1092,12 +1274,7 @@ func (tds *TrieDbState) ReadAccountStorage(address common.Address, incarnation u
 	}
 
 	if tds.resolveReads {
-		m, ok := tds.currentBuffer.storageReads[addrHash]
-		if !ok {
-			m = make(map[common.Hash]struct{})
-			tds.currentBuffer.storageReads[addrHash] = m
-		}
-		m[seckey] = struct{}{}
+		tds.recordStorageRead(addrHash, seckey)
 	}
 
 	tds.tMu.Lock()
@@ -1106,15 +1283,15 @@ func (tds *TrieDbState) ReadAccountStorage(address common.Address, incarnation u
 	if !ok {
 		// Not present in the trie, try database
 		if tds.historical {
-			enc, err = tds.db.GetAsOf(dbutils.CurrentStateBucket, dbutils.StorageHistoryBucket, dbutils.GenerateCompositeStorageKey(addrHash, incarnation, seckey), tds.blockNr)
-			if err != nil {
-				enc = nil
-			}
+			enc, err = tds.getAsOf(dbutils.CurrentStateBucket, dbutils.StorageHistoryBucket, dbutils.GenerateCompositeStorageKey(addrHash, incarnation, seckey), tds.blockNr+1)
 		} else {
 			enc, err = tds.db.Get(dbutils.CurrentStateBucket, dbutils.GenerateCompositeStorageKey(addrHash, incarnation, seckey))
-			if err != nil {
-				enc = nil
+		}
+		if err != nil {
+			if !errors.Is(err, ethdb.ErrKeyNotFound) {
+				return nil, err
 			}
+			enc = nil
 		}
 	}
 	return enc, nil
@@ -1125,7 +1302,7 @@ func (tds *TrieDbState) ReadCodeByHash(codeHash common.Hash) (code []byte, err e
 		return nil, nil
 	}
 
-	code, err = tds.db.Get(dbutils.CodeBucket, codeHash[:])
+	code, err = readCode(tds.db, codeHash)
 	if tds.resolveReads {
 		// we have to be careful, because the code might change
 		// during the block executuion, so we are always
@@ -1160,19 +1337,14 @@ func (tds *TrieDbState) ReadAccountCode(address common.Address, codeHash common.
 	if cached, ok := tds.readAccountCodeFromTrie(addrHash[:]); ok {
 		code, err = cached, nil
 	} else {
-		code, err = tds.db.Get(dbutils.CodeBucket, codeHash[:])
+		code, err = readCode(tds.db, codeHash)
 	}
 	if tds.resolveReads {
 		addrHash, err1 := common.HashData(address[:])
 		if err1 != nil {
 			return nil, err
 		}
-		tds.currentBuffer.accountReads[addrHash] = struct{}{}
-		// we have to be careful, because the code might change
-		// during the block executuion, so we are always
-		// storing the latest code hash
-		tds.currentBuffer.codeReads[addrHash] = codeHash
-		tds.retainListBuilder.ReadCode(codeHash)
+		tds.recordCodeRead(addrHash, codeHash)
 	}
 	return code, err
 }
@@ -1187,7 +1359,7 @@ func (tds *TrieDbState) ReadAccountCodeSize(address common.Address, codeHash com
 		codeSize, err = cached, nil
 	} else {
 		var code []byte
-		code, err = tds.db.Get(dbutils.CodeBucket, codeHash[:])
+		code, err = readCode(tds.db, codeHash)
 		if err != nil {
 			return 0, err
 		}
@@ -1198,17 +1370,103 @@ func (tds *TrieDbState) ReadAccountCodeSize(address common.Address, codeHash com
 		if err1 != nil {
 			return 0, err
 		}
-		tds.currentBuffer.accountReads[addrHash] = struct{}{}
-		// we have to be careful, because the code might change
-		// during the block executuion, so we are always
-		// storing the latest code hash
-		tds.currentBuffer.codeSizeReads[addrHash] = codeHash
-		// FIXME: support codeSize in witnesses if makes sense
-		tds.retainListBuilder.ReadCode(codeHash)
+		tds.recordCodeSizeRead(addrHash, codeHash)
 	}
 	return codeSize, nil
 }
 
+func (tds *TrieDbState) deletedInBuffers(addrHash common.Hash) bool {
+	if tds.currentBuffer != nil {
+		if _, ok := tds.currentBuffer.deleted[addrHash]; ok {
+			return true
+		}
+	}
+	if tds.aggregateBuffer != nil {
+		if _, ok := tds.aggregateBuffer.deleted[addrHash]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (tds *TrieDbState) recordStorageRead(addrHash, seckey common.Hash) {
+	m, ok := tds.currentBuffer.storageReads[addrHash]
+	if !ok {
+		m = make(map[common.Hash]struct{})
+		tds.currentBuffer.storageReads[addrHash] = m
+	}
+	m[seckey] = struct{}{}
+}
+
+func (tds *TrieDbState) recordCodeRead(addrHash, codeHash common.Hash) {
+	tds.currentBuffer.accountReads[addrHash] = struct{}{}
+	// we have to be careful, because the code might change
+	// during the block executuion, so we are always
+	// storing the latest code hash
+	tds.currentBuffer.codeReads[addrHash] = codeHash
+	tds.retainListBuilder.ReadCode(codeHash)
+}
+
+func (tds *TrieDbState) recordCodeSizeRead(addrHash, codeHash common.Hash) {
+	tds.currentBuffer.accountReads[addrHash] = struct{}{}
+	// we have to be careful, because the code might change
+	// during the block executuion, so we are always
+	// storing the latest code hash
+	tds.currentBuffer.codeSizeReads[addrHash] = codeHash
+	// FIXME: support codeSize in witnesses if makes sense
+	tds.retainListBuilder.ReadCode(codeHash)
+}
+
+// RecordAccountRead records the read of the account, as ReadAccountData does when the reads are resolved
+func (tds *TrieDbState) RecordAccountRead(address common.Address) error {
+	addrHash, err := common.HashData(address[:])
+	if err != nil {
+		return err
+	}
+	tds.currentBuffer.accountReads[addrHash] = struct{}{}
+	return nil
+}
+
+// RecordStorageRead records the read of the storage item, as ReadAccountStorage does when the reads are resolved
+func (tds *TrieDbState) RecordStorageRead(address common.Address, key *common.Hash) error {
+	addrHash, err := tds.pw.HashAddress(address, false /*save*/)
+	if err != nil {
+		return err
+	}
+	if tds.deletedInBuffers(addrHash) {
+		return nil
+	}
+	seckey, err := tds.pw.HashKey(key, false /*save*/)
+	if err != nil {
+		return err
+	}
+	tds.recordStorageRead(addrHash, seckey)
+	return nil
+}
+
+// RecordCodeRead records the read of the code, as ReadAccountCode does when the reads are resolved
+func (tds *TrieDbState) RecordCodeRead(address common.Address, codeHash common.Hash) error {
+	if bytes.Equal(codeHash[:], emptyCodeHash) {
+		return nil
+	}
+	addrHash, err := common.HashData(address[:])
+	if err != nil {
+		return err
+	}
+	tds.recordCodeRead(addrHash, codeHash)
+	return nil
+}
+
+// RecordCodeSizeRead records the read of the code size, as ReadAccountCodeSize does when the reads are resolved
+func (tds *TrieDbState) RecordCodeSizeRead(address common.Address, codeHash common.Hash) error {
+	addrHash, err := common.HashData(address[:])
+	if err != nil {
+		return err
+	}
+	tds.recordCodeSizeRead(addrHash, codeHash)
+	return nil
+}
+
 func (tds *TrieDbState) ReadAccountIncarnation(address common.Address) (uint64, error) {
 	if inc, ok := tds.incarnationMap[address]; ok {
 		return inc, nil
@@ -1234,19 +1492,15 @@ func (tds *TrieDbState) EvictTries(print bool) {
 	strict := print
 	tds.incarnationMap = make(map[common.Address]uint64)
 	if print {
-		trieSize := tds.t.TrieSize()
-		fmt.Println("") // newline for better formatting
-		fmt.Printf("[Before] Actual nodes size: %d, accounted size: %d\n", trieSize, tds.tp.TotalSize())
+		log.Trace("Before eviction", "actual nodes size", tds.t.TrieSize(), "accounted size", tds.tp.TotalSize())
 	}
 
 	if strict {
 		actualAccounts := uint64(tds.t.NumberOfAccounts())
-		fmt.Println("number of leaves: ", actualAccounts)
 		accountedAccounts := tds.tp.NumberOf()
 		if actualAccounts != accountedAccounts {
 			panic(fmt.Errorf("account number mismatch: trie=%v eviction=%v", actualAccounts, accountedAccounts))
 		}
-		fmt.Printf("checking number --> ok\n")
 
 		actualSize := uint64(tds.t.TrieSize())
 		accountedSize := tds.tp.TotalSize()
@@ -1254,19 +1508,29 @@ func (tds *TrieDbState) EvictTries(print bool) {
 		if actualSize != accountedSize {
 			panic(fmt.Errorf("account size mismatch: trie=%v eviction=%v", actualSize, accountedSize))
 		}
-		fmt.Printf("checking size --> ok\n")
+		log.Trace("Eviction accounting checked", "leaves", actualAccounts, "size", actualSize)
 	}
 
-	tds.tp.EvictToFitSize(tds.t, MaxTrieCacheSize)
+	tds.tp.SetPolicy(TrieEvictionPolicy)
+	if MaxAccountTrieCacheSize > 0 || MaxStorageTrieCacheSize > 0 {
+		accountsBudget, storageBudget := MaxAccountTrieCacheSize, MaxStorageTrieCacheSize
+		if accountsBudget == 0 {
+			accountsBudget = MaxTrieCacheSize
+		}
+		if storageBudget == 0 {
+			storageBudget = MaxTrieCacheSize
+		}
+		tds.tp.EvictToFitBudgets(tds.t, accountsBudget, storageBudget)
+	} else {
+		tds.tp.EvictToFitSize(tds.t, MaxTrieCacheSize)
+	}
 
 	if strict {
 		actualAccounts := uint64(tds.t.NumberOfAccounts())
-		fmt.Println("number of leaves: ", actualAccounts)
 		accountedAccounts := tds.tp.NumberOf()
 		if actualAccounts != accountedAccounts {
 			panic(fmt.Errorf("after eviction account number mismatch: trie=%v eviction=%v", actualAccounts, accountedAccounts))
 		}
-		fmt.Printf("checking number --> ok\n")
 
 		actualSize := uint64(tds.t.TrieSize())
 		accountedSize := tds.tp.TotalSize()
@@ -1274,24 +1538,22 @@ func (tds *TrieDbState) EvictTries(print bool) {
 		if actualSize != accountedSize {
 			panic(fmt.Errorf("after eviction account size mismatch: trie=%v eviction=%v", actualSize, accountedSize))
 		}
-		fmt.Printf("checking size --> ok\n")
+		log.Trace("Eviction accounting checked after eviction", "leaves", actualAccounts, "size", actualSize)
 	}
 
 	if print {
-		trieSize := tds.t.TrieSize()
-		fmt.Printf("[After] Actual nodes size: %d, accounted size: %d\n", trieSize, tds.tp.TotalSize())
+		log.Trace("After eviction", "actual nodes size", tds.t.TrieSize(), "accounted size", tds.tp.TotalSize(), "leaves", tds.t.NumberOfAccounts())
+	}
 
-		actualAccounts := uint64(tds.t.NumberOfAccounts())
-		fmt.Println("number of leaves: ", actualAccounts)
+	// The hot accounts are not evicted, but might have been lost with the unwound blocks
+	if err := tds.resolveHotAccounts(); err != nil {
+		log.Warn("Could not resolve the hot accounts", "err", err)
 	}
 
 	var m runtime.MemStats
 	runtime.ReadMemStats(&m)
-	log.Info("Memory", "nodes size", tds.tp.TotalSize(), "hashes", tds.t.HashMapSize(),
+	log.Info("Memory", "nodes size", tds.tp.TotalSize(), "accounts", tds.tp.AccountsSize(), "storage", tds.tp.StorageSize(), "hashes", tds.t.HashMapSize(),
 		"alloc", int(m.Alloc/1024), "sys", int(m.Sys/1024), "numGC", int(m.NumGC))
-	if print {
-		fmt.Printf("Eviction done. Nodes size: %d, alloc: %d, sys: %d, numGC: %d\n", tds.tp.TotalSize(), int(m.Alloc/1024), int(m.Sys/1024), int(m.NumGC))
-	}
 }
 
 func (tds *TrieDbState) TrieStateWriter() *TrieStateWriter {
@@ -1300,7 +1562,7 @@ func (tds *TrieDbState) TrieStateWriter() *TrieStateWriter {
 
 // DbStateWriter creates a writer that is designed to write changes into the database batch
 func (tds *TrieDbState) DbStateWriter() *DbStateWriter {
-	return &DbStateWriter{blockNr: tds.blockNr, stateDb: tds.db, changeDb: tds.db, pw: tds.pw, csw: NewChangeSetWriter()}
+	return &DbStateWriter{blockNr: tds.blockNr, stateDb: tds.db, changeDb: tds.db, pw: tds.pw, csw: NewChangeSetWriter(), historyIndex: tds.historyIndex, historyFilter: tds.historyFilter}
 }
 
 // DbStateWriter creates a writer that is designed to write changes into the database batch
@@ -1381,6 +1643,9 @@ func (tsw *TrieStateWriter) WriteAccountStorage(_ context.Context, address commo
 // ExtractWitness produces block witness for the block just been processed, in a serialised form
 func (tds *TrieDbState) ExtractWitness(trace bool, isBinary bool) (*trie.Witness, error) {
 	rs := tds.retainListBuilder.Build(isBinary)
+	if err := tds.retainListBuilder.Err(); err != nil {
+		return nil, err
+	}
 
 	return tds.makeBlockWitness(trace, rs, isBinary)
 }
@@ -1388,10 +1653,36 @@ func (tds *TrieDbState) ExtractWitness(trace bool, isBinary bool) (*trie.Witness
 // ExtractWitness produces block witness for the block just been processed, in a serialised form
 func (tds *TrieDbState) ExtractWitnessForPrefix(prefix []byte, trace bool, isBinary bool) (*trie.Witness, error) {
 	rs := tds.retainListBuilder.Build(isBinary)
+	if err := tds.retainListBuilder.Err(); err != nil {
+		return nil, err
+	}
 
 	return tds.makeBlockWitnessForPrefix(prefix, trace, rs, isBinary)
 }
 
+// ExtractWitnessForAccounts is ExtractWitness limited to the given accounts (account hashes) and their storage
+func (tds *TrieDbState) ExtractWitnessForAccounts(addrHashes []common.Hash, trace bool, isBinary bool) (*trie.Witness, error) {
+	rs := tds.retainListBuilder.BuildForAccounts(isBinary, addrHashes)
+	if err := tds.retainListBuilder.Err(); err != nil {
+		return nil, err
+	}
+
+	return tds.makeBlockWitness(trace, rs, isBinary)
+}
+
+// ExtractWitnessWithSizeTracer produces block witness for the block just been processed,
+// and reports contribution of every account and key prefix to its size to the tracer
+func (tds *TrieDbState) ExtractWitnessWithSizeTracer(tracer *trie.WitnessSizeTracer) (*trie.Witness, error) {
+	rs := tds.retainListBuilder.Build(false)
+	if err := tds.retainListBuilder.Err(); err != nil {
+		return nil, err
+	}
+
+	tds.tMu.Lock()
+	defer tds.tMu.Unlock()
+	return tds.t.ExtractWitnessWithSizeTracer(false, rs, tracer)
+}
+
 func (tds *TrieDbState) makeBlockWitnessForPrefix(prefix []byte, trace bool, rl trie.RetainDecider, isBinary bool) (*trie.Witness, error) {
 	tds.tMu.Lock()
 	defer tds.tMu.Unlock()
@@ -42,6 +42,7 @@ import (
 	"github.com/ledgerwatch/turbo-geth/crypto"
 	"github.com/ledgerwatch/turbo-geth/ethdb"
 	"github.com/ledgerwatch/turbo-geth/params"
+	"github.com/ledgerwatch/turbo-geth/trie"
 )
 
 // Create revival problem
@@ -336,6 +337,14 @@ func TestReorgOverSelfDestruct(t *testing.T) {
 	if st.Exist(contractAddress) {
 		t.Error("expected contractAddress to not exist at the block 3", contractAddress.String())
 	}
+	contractHash := crypto.Keccak256Hash(contractAddress[:])
+	events, err := rawdb.ReadIncarnationEvents(db, contractHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || !events[0].Created || events[0].BlockNumber != 1 || events[1].Created || events[1].BlockNumber != 3 {
+		t.Fatalf("expected creation at the block 1 and destruction at the block 3, got %+v", events)
+	}
 
 	fmt.Println("-------Reorg")
 	// REORG of block 2 and 3, and insert new (empty) BLOCK 2, 3, and 4
@@ -346,6 +355,12 @@ func TestReorgOverSelfDestruct(t *testing.T) {
 	if !st.Exist(contractAddress) {
 		t.Error("expected contractAddress to exist at the block 4", contractAddress.String())
 	}
+	if events, err = rawdb.ReadIncarnationEvents(db, contractHash); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || !events[0].Created || events[0].BlockNumber != 1 {
+		t.Fatalf("expected only the creation at the block 1 after the reorg, got %+v", events)
+	}
 
 	// Reload blockchain from the database
 	blockchain, err = core.NewBlockChain(db, nil, gspec.Config, engine, vm.Config{}, nil, nil)
@@ -1317,3 +1332,260 @@ func TestCacheCodeSizeInTrie(t *testing.T) {
 	assert.NoError(t, err, "you can still receive code size even with empty DB")
 	assert.Equal(t, len(code), codeSize2, "code size should be received even with empty DB")
 }
+
+func TestFlatHashing(t *testing.T) {
+	contract := common.HexToAddress("0x71dd1027069078091B3ca48093B00E4735B20624")
+	eoa1 := common.HexToAddress("0x01")
+	eoa2 := common.HexToAddress("0x02")
+	key1, key2, key3 := common.HexToHash("0x01"), common.HexToHash("0x02"), common.HexToHash("0x03")
+	// Enough accounts for the branch nodes below the root, which get the intermediate hashes
+	var many []common.Address
+	for i := 0; i < 300; i++ {
+		many = append(many, common.BigToAddress(big.NewInt(int64(0x1000+i))))
+	}
+
+	// Every block consists of transactions, every transaction changes the state
+	blocks := [][]func(ibs *state.IntraBlockState){
+		{
+			func(ibs *state.IntraBlockState) {
+				for _, address := range many {
+					ibs.AddBalance(address, uint256.NewInt().SetUint64(1))
+				}
+			},
+			func(ibs *state.IntraBlockState) {
+				ibs.CreateAccount(contract, true)
+				ibs.SetCode(contract, []byte{0x01, 0x02, 0x03})
+				ibs.SetState(contract, &key1, *uint256.NewInt().SetUint64(1))
+				ibs.SetState(contract, &key2, *uint256.NewInt().SetUint64(2))
+			},
+			func(ibs *state.IntraBlockState) {
+				ibs.AddBalance(eoa1, uint256.NewInt().SetUint64(100))
+			},
+		},
+		{
+			func(ibs *state.IntraBlockState) {
+				ibs.SetState(contract, &key1, *uint256.NewInt())
+				ibs.SetState(contract, &key3, *uint256.NewInt().SetUint64(3))
+				ibs.AddBalance(eoa2, uint256.NewInt().SetUint64(5))
+				for _, address := range many[:10] {
+					ibs.AddBalance(address, uint256.NewInt().SetUint64(1))
+				}
+			},
+		},
+		{
+			func(ibs *state.IntraBlockState) {
+				ibs.Suicide(contract)
+			},
+			func(ibs *state.IntraBlockState) {
+				ibs.AddBalance(eoa1, uint256.NewInt().SetUint64(1))
+			},
+		},
+		{
+			func(ibs *state.IntraBlockState) {
+				ibs.CreateAccount(contract, true)
+				ibs.SetState(contract, &key2, *uint256.NewInt().SetUint64(4))
+			},
+		},
+	}
+
+	run := func(flat bool) ([]common.Hash, ethdb.Database) {
+		db := ethdb.NewMemDatabase()
+		tds := state.NewTrieDbState(common.Hash{}, db, 0)
+		tds.SetFlatHashing(flat)
+		ctx := context.Background()
+		var roots []common.Hash
+		for i, txs := range blocks {
+			blockNr := uint64(i + 1)
+			tds.SetBlockNr(blockNr)
+			ibs := state.New(tds)
+			for _, tx := range txs {
+				tds.StartNewBuffer()
+				tx(ibs)
+				if err := ibs.FinalizeTx(ctx, tds.TrieStateWriter()); err != nil {
+					t.Fatal(err)
+				}
+			}
+			blockRoots, err := tds.ComputeTrieRoots()
+			if err != nil {
+				t.Fatal(err)
+			}
+			roots = append(roots, blockRoots...)
+			if err := ibs.CommitBlock(ctx, tds.DbStateWriter()); err != nil {
+				t.Fatal(err)
+			}
+		}
+		assert.Equal(t, roots[len(roots)-1], tds.LastRoot())
+		return roots, db
+	}
+
+	expected, _ := run(false)
+	roots, db := run(true)
+	assert.Equal(t, expected, roots)
+
+	// The intermediate hashes are written back after the invalidation
+	var ihs int
+	assert.NoError(t, db.Walk(dbutils.IntermediateTrieHashBucket, nil, 0, func(k, v []byte) (bool, error) {
+		ihs++
+		return true, nil
+	}))
+	assert.NotZero(t, ihs)
+
+	// The database alone, with the intermediate hashes, gives the same root
+	root, err := trie.HashFlatWithModifications(db, nil, nil, nil, nil, nil, nil, nil, false)
+	assert.NoError(t, err)
+	assert.Equal(t, roots[len(roots)-1], root)
+}
+
+func TestForEachStorage(t *testing.T) {
+	contract := common.HexToAddress("0x71dd1027069078091B3ca48093B00E4735B20624")
+	keys := make([]common.Hash, 6)
+	for i := range keys {
+		keys[i] = common.BigToHash(big.NewInt(int64(i)))
+	}
+	ctx := context.Background()
+	db := ethdb.NewMemDatabase()
+	batch := db.NewBatch()
+	tds := state.NewTrieDbState(common.Hash{}, batch, 1)
+
+	collect := func(it state.StorageIterator, start []byte, maxResults int) map[common.Hash]uint64 {
+		result := make(map[common.Hash]uint64)
+		err := it.ForEachStorage(contract, start, func(key, seckey common.Hash, value uint256.Int) bool {
+			h, err := common.HashData(keys[value.Uint64()][:])
+			assert.NoError(t, err)
+			assert.Equal(t, h, seckey, "value of the test storage item is the index of its key")
+			result[seckey] = value.Uint64()
+			return true
+		}, maxResults)
+		assert.NoError(t, err)
+		return result
+	}
+	expected := func(idx ...int) map[common.Hash]uint64 {
+		result := make(map[common.Hash]uint64)
+		for _, i := range idx {
+			h, err := common.HashData(keys[i][:])
+			assert.NoError(t, err)
+			result[h] = uint64(i)
+		}
+		return result
+	}
+
+	// Block 1 is committed into the database
+	tds.StartNewBuffer()
+	ibs := state.New(tds)
+	ibs.CreateAccount(contract, true)
+	for i := 1; i <= 3; i++ {
+		ibs.SetState(contract, &keys[i], *uint256.NewInt().SetUint64(uint64(i)))
+	}
+	assert.NoError(t, ibs.FinalizeTx(ctx, tds.TrieStateWriter()))
+	_, err := tds.ComputeTrieRoots()
+	assert.NoError(t, err)
+	assert.NoError(t, ibs.CommitBlock(ctx, tds.DbStateWriter()))
+	_, err = batch.Commit()
+	assert.NoError(t, err)
+	assert.Equal(t, expected(1, 2, 3), collect(tds, nil, 100))
+
+	// Block 2 is only written into the batch
+	tds.SetBlockNr(2)
+	tds.StartNewBuffer()
+	ibs = state.New(tds)
+	ibs.SetState(contract, &keys[1], *uint256.NewInt())
+	ibs.SetState(contract, &keys[4], *uint256.NewInt().SetUint64(4))
+	assert.NoError(t, ibs.FinalizeTx(ctx, tds.TrieStateWriter()))
+	// Buffered in TrieDbState
+	assert.Equal(t, expected(2, 3, 4), collect(tds, nil, 100))
+
+	// Not yet finalized in IntraBlockState
+	ibs.SetState(contract, &keys[2], *uint256.NewInt())
+	ibs.SetState(contract, &keys[5], *uint256.NewInt().SetUint64(5))
+	assert.Equal(t, expected(3, 4, 5), collect(ibs, nil, 100))
+	assert.Equal(t, 2, len(collect(ibs, nil, 2)))
+	assert.NoError(t, ibs.FinalizeTx(ctx, tds.TrieStateWriter()))
+
+	_, err = tds.ComputeTrieRoots()
+	assert.NoError(t, err)
+	assert.NoError(t, ibs.CommitBlock(ctx, tds.DbStateWriter()))
+	// Pending in the batch
+	assert.Equal(t, expected(3, 4, 5), collect(tds, nil, 100))
+	assert.Equal(t, 1, len(collect(tds, nil, 1)))
+}
+
+func TestStageTimings(t *testing.T) {
+	db := ethdb.NewMemDatabase()
+	defer db.Close()
+	tds := state.NewTrieDbState(common.Hash{}, db, 0)
+	defer func(interval uint64) { state.StageLogInterval = interval }(state.StageLogInterval)
+	state.StageLogInterval = 2
+
+	tds.StartNewBuffer()
+	w := tds.TrieStateWriter()
+	addr := common.HexToAddress("0x1234")
+	if err := w.UpdateAccountData(context.Background(), addr, &accounts.Account{}, &accounts.Account{Nonce: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tds.ResolveStateTrie(false, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tds.CalcTrieRoots(false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tds.UpdateStateTrie(); err != nil {
+		t.Fatal(err)
+	}
+
+	tds.BlockProcessed()
+	st := tds.StageTimings()
+	assert.Equal(t, uint64(1), st.Blocks)
+	assert.Equal(t, st.Aggregate+st.StorageTouches+st.AccountTouches+st.Resolve+st.TrieUpdate+st.RootHash, st.Total())
+
+	tds.BlockProcessed()
+	assert.Equal(t, state.StageTimings{}, tds.StageTimings(), "timings should be reset after the summary")
+}
+
+func TestTrieDbStateCopy(t *testing.T) {
+	addrA := common.HexToAddress("0x71dd1027069078091B3ca48093B00E4735B20624")
+	addrB := common.HexToAddress("0x21dd1027069078091B3ca48093B00E4735B20625")
+	addrHashA, addrHashB := crypto.Keccak256(addrA[:]), crypto.Keccak256(addrB[:])
+
+	db := ethdb.NewMemDatabase()
+	tds := state.NewTrieDbState(common.Hash{}, db, 0)
+	intraBlockState := state.New(tds)
+	ctx := context.Background()
+	tds.StartNewBuffer()
+	intraBlockState.AddBalance(addrA, uint256.NewInt().SetUint64(1))
+	assert.NoError(t, intraBlockState.FinalizeTx(ctx, tds.TrieStateWriter()))
+	_, err := tds.ComputeTrieRoots()
+	assert.NoError(t, err)
+
+	// Uncommitted update, which has to be carried over to the copy
+	tds.StartNewBuffer()
+	intraBlockState.AddBalance(addrA, uint256.NewInt().SetUint64(1))
+	assert.NoError(t, intraBlockState.FinalizeTx(ctx, tds.TrieStateWriter()))
+
+	cpy := tds.Copy()
+
+	// Updates after the copy must not cross over
+	intraBlockState.AddBalance(addrA, uint256.NewInt().SetUint64(3))
+	assert.NoError(t, intraBlockState.FinalizeTx(ctx, tds.TrieStateWriter()))
+	cpy.StartNewBuffer()
+	cpyState := state.New(cpy)
+	cpyState.AddBalance(addrB, uint256.NewInt().SetUint64(3))
+	assert.NoError(t, cpyState.FinalizeTx(ctx, cpy.TrieStateWriter()))
+
+	_, err = tds.ComputeTrieRoots()
+	assert.NoError(t, err)
+	_, err = cpy.ComputeTrieRoots()
+	assert.NoError(t, err)
+
+	acc, ok := tds.Trie().GetAccount(addrHashA)
+	assert.True(t, ok)
+	assert.Equal(t, uint64(5), acc.Balance.Uint64())
+	acc, _ = tds.Trie().GetAccount(addrHashB)
+	assert.Nil(t, acc, "the account created in the copy must not appear in the original")
+
+	acc, ok = cpy.Trie().GetAccount(addrHashA)
+	assert.True(t, ok)
+	assert.Equal(t, uint64(2), acc.Balance.Uint64())
+	acc, ok = cpy.Trie().GetAccount(addrHashB)
+	assert.True(t, ok)
+	assert.Equal(t, uint64(3), acc.Balance.Uint64())
+}
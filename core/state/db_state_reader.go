@@ -3,8 +3,10 @@ package state
 import (
 	"bytes"
 	"encoding/binary"
+	"sort"
 
 	"github.com/VictoriaMetrics/fastcache"
+	"github.com/holiman/uint256"
 
 	"github.com/ledgerwatch/turbo-geth/common"
 	"github.com/ledgerwatch/turbo-geth/common/dbutils"
@@ -14,16 +16,17 @@ import (
 
 // Implements StateReader by wrapping database only, without trie
 type DbStateReader struct {
-	db             ethdb.Getter
-	accountCache   *fastcache.Cache
-	storageCache   *fastcache.Cache
-	codeCache      *fastcache.Cache
-	codeSizeCache  *fastcache.Cache
+	db            ethdb.Getter
+	accountCache  *fastcache.Cache
+	storageCache  *fastcache.Cache
+	codeCache     *fastcache.Cache
+	codeSizeCache *fastcache.Cache
+	accountFilter *AccountFilter
 }
 
 func NewDbStateReader(db ethdb.Getter) *DbStateReader {
 	return &DbStateReader{
-		db:             db,
+		db: db,
 	}
 }
 
@@ -31,6 +34,11 @@ func (dbr *DbStateReader) SetAccountCache(accountCache *fastcache.Cache) {
 	dbr.accountCache = accountCache
 }
 
+// SetAccountFilter makes the reader skip the database lookups of the accounts which are definitely absent
+func (dbr *DbStateReader) SetAccountFilter(accountFilter *AccountFilter) {
+	dbr.accountFilter = accountFilter
+}
+
 func (dbr *DbStateReader) SetStorageCache(storageCache *fastcache.Cache) {
 	dbr.storageCache = storageCache
 }
@@ -43,7 +51,7 @@ func (dbr *DbStateReader) SetCodeSizeCache(codeSizeCache *fastcache.Cache) {
 	dbr.codeSizeCache = codeSizeCache
 }
 
-func (dbr *DbStateReader) ReadAccountData(address common.Address) (*accounts.Account, error) {
+func (dbr *DbStateReader) readAccountEnc(address common.Address) ([]byte, error) {
 	var enc []byte
 	var ok bool
 	if dbr.accountCache != nil {
@@ -52,6 +60,9 @@ func (dbr *DbStateReader) ReadAccountData(address common.Address) (*accounts.Acc
 	if !ok {
 		var err error
 		if addrHash, err1 := common.HashData(address[:]); err1 == nil {
+			if dbr.accountFilter != nil && !dbr.accountFilter.MayContain(addrHash) {
+				return nil, nil
+			}
 			enc, err = dbr.db.Get(dbutils.CurrentStateBucket, addrHash[:])
 		} else {
 			return nil, err1
@@ -63,16 +74,37 @@ func (dbr *DbStateReader) ReadAccountData(address common.Address) (*accounts.Acc
 	if !ok && dbr.accountCache != nil {
 		dbr.accountCache.Set(address[:], enc)
 	}
+	return enc, nil
+}
+
+func (dbr *DbStateReader) ReadAccountData(address common.Address) (*accounts.Account, error) {
+	enc, err := dbr.readAccountEnc(address)
+	if err != nil {
+		return nil, err
+	}
 	if enc == nil {
+		traceAccount("DbStateReader", address, nil)
 		return nil, nil
 	}
 	acc := &accounts.Account{}
 	if err := acc.DecodeForStorage(enc); err != nil {
 		return nil, err
 	}
+	traceAccount("DbStateReader", address, acc)
 	return acc, nil
 }
 
+// ReadAccountBalanceNonce implements BalanceNonceReader
+func (dbr *DbStateReader) ReadAccountBalanceNonce(address common.Address, balance *uint256.Int) (uint64, bool, error) {
+	enc, err := dbr.readAccountEnc(address)
+	if err != nil || enc == nil {
+		balance.Clear()
+		return 0, false, err
+	}
+	nonce, err := accounts.DecodeBalanceNonceForStorage(enc, balance)
+	return nonce, err == nil, err
+}
+
 func (dbr *DbStateReader) ReadAccountStorage(address common.Address, incarnation uint64, key *common.Hash) ([]byte, error) {
 	addrHash, err := common.HashData(address[:])
 	if err != nil {
@@ -98,6 +130,49 @@ func (dbr *DbStateReader) ReadAccountStorage(address common.Address, incarnation
 	return enc, nil
 }
 
+// ReadStorageBatch reads the storage items in the order of their keys in the database, so that the neighbouring
+// items are read from the same pages
+func (dbr *DbStateReader) ReadStorageBatch(promises []StoragePromise) ([][]byte, error) {
+	values := make([][]byte, len(promises))
+	positions := make(map[string][]int, len(promises))
+	var keys [][]byte
+	for i, p := range promises {
+		addrHash, err := common.HashData(p.Address[:])
+		if err != nil {
+			return nil, err
+		}
+		seckey, err := common.HashData(p.Key[:])
+		if err != nil {
+			return nil, err
+		}
+		compositeKey := dbutils.GenerateCompositeStorageKey(addrHash, p.Incarnation, seckey)
+		if dbr.storageCache != nil {
+			if enc, ok := dbr.storageCache.HasGet(nil, compositeKey); ok {
+				values[i] = enc
+				continue
+			}
+		}
+		if _, ok := positions[string(compositeKey)]; !ok {
+			keys = append(keys, compositeKey)
+		}
+		positions[string(compositeKey)] = append(positions[string(compositeKey)], i)
+	}
+	sort.Slice(keys, func(i, j int) bool { return bytes.Compare(keys[i], keys[j]) < 0 })
+	for _, k := range keys {
+		enc, err := dbr.db.Get(dbutils.CurrentStateBucket, k)
+		if err != nil && !entryNotFound(err) {
+			return nil, err
+		}
+		if dbr.storageCache != nil {
+			dbr.storageCache.Set(k, enc)
+		}
+		for _, i := range positions[string(k)] {
+			values[i] = enc
+		}
+	}
+	return values, nil
+}
+
 func (dbr *DbStateReader) ReadAccountCode(address common.Address, codeHash common.Hash) ([]byte, error) {
 	if bytes.Equal(codeHash[:], emptyCodeHash) {
 		return nil, nil
@@ -107,7 +182,7 @@ func (dbr *DbStateReader) ReadAccountCode(address common.Address, codeHash commo
 			return code, nil
 		}
 	}
-	code, err := dbr.db.Get(dbutils.CodeBucket, codeHash[:])
+	code, err := readCode(dbr.db, codeHash)
 	if dbr.codeCache != nil && len(code) <= 1024 {
 		dbr.codeCache.Set(address[:], code)
 	}
@@ -129,7 +204,7 @@ func (dbr *DbStateReader) ReadAccountCodeSize(address common.Address, codeHash c
 		}
 	}
 	var code []byte
-	code, err = dbr.db.Get(dbutils.CodeBucket, codeHash[:])
+	code, err = readCode(dbr.db, codeHash)
 	if err != nil {
 		return 0, err
 	}
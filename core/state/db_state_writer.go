@@ -3,7 +3,8 @@ package state
 import (
 	"context"
 	"encoding/binary"
-	"fmt"
+	"errors"
+	"sync"
 
 	"github.com/VictoriaMetrics/fastcache"
 	"github.com/holiman/uint256"
@@ -21,30 +22,63 @@ var _ WriterWithChangeSets = (*DbStateWriter)(nil)
 
 func NewDbStateWriter(stateDb, changeDb ethdb.Database, blockNr uint64) *DbStateWriter {
 	return &DbStateWriter{
-		stateDb:        stateDb,
-		changeDb:       changeDb,
-		blockNr:        blockNr,
-		pw:             &PreimageWriter{db: stateDb, savePreimages: false},
-		csw:            NewChangeSetWriter(),
+		stateDb:  stateDb,
+		changeDb: changeDb,
+		blockNr:  blockNr,
+		pw:       &PreimageWriter{db: stateDb, savePreimages: false},
+		csw:      NewChangeSetWriter(),
 	}
 }
 
 type DbStateWriter struct {
-	stateDb        ethdb.Database
-	changeDb       ethdb.Database
-	pw             *PreimageWriter
-	blockNr        uint64
-	csw            *ChangeSetWriter
-	accountCache   *fastcache.Cache
-	storageCache   *fastcache.Cache
-	codeCache      *fastcache.Cache
-	codeSizeCache  *fastcache.Cache
+	stateDb       ethdb.Database
+	changeDb      ethdb.Database
+	pw            *PreimageWriter
+	blockNr       uint64
+	csw           *ChangeSetWriter
+	accountCache  *fastcache.Cache
+	storageCache  *fastcache.Cache
+	codeCache     *fastcache.Cache
+	codeSizeCache *fastcache.Cache
+	accountFilter *AccountFilter
+	size          StateSize // Changes of the state size made by the writer, see WriteStateSize
+	historyIndex  *HistoryIndexCoalescer
+	historyFilter *historyFilterCache // The filter of the history indexes shared by the writers of the blocks
+}
+
+// historyFilterCache keeps the filter of the history indexes (see ethdb.HistoryIndexFilter) read once.
+// The filter is only changed on the start, see migrations.ApplyHistoryIndexFilter
+type historyFilterCache struct {
+	mu     sync.Mutex
+	filter ethdb.HistoryIndexFilter
+	read   bool
+}
+
+func (c *historyFilterCache) get(db ethdb.Getter) (ethdb.HistoryIndexFilter, error) {
+	if c == nil {
+		return ethdb.ReadHistoryIndexFilter(db)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.read {
+		filter, err := ethdb.ReadHistoryIndexFilter(db)
+		if err != nil {
+			return nil, err
+		}
+		c.filter, c.read = filter, true
+	}
+	return c.filter, nil
 }
 
 func (dsw *DbStateWriter) SetAccountCache(accountCache *fastcache.Cache) {
 	dsw.accountCache = accountCache
 }
 
+// SetAccountFilter makes the writer register the written accounts in the filter
+func (dsw *DbStateWriter) SetAccountFilter(accountFilter *AccountFilter) {
+	dsw.accountFilter = accountFilter
+}
+
 func (dsw *DbStateWriter) SetStorageCache(storageCache *fastcache.Cache) {
 	dsw.storageCache = storageCache
 }
@@ -89,9 +123,13 @@ func (dsw *DbStateWriter) UpdateAccountData(ctx context.Context, address common.
 	if err := dsw.stateDb.Put(dbutils.CurrentStateBucket, addrHash[:], value); err != nil {
 		return err
 	}
+	dsw.size.ChangeAccount(original, account)
 	if dsw.accountCache != nil {
 		dsw.accountCache.Set(address[:], value)
 	}
+	if dsw.accountFilter != nil {
+		dsw.accountFilter.Add(addrHash)
+	}
 	return nil
 }
 
@@ -106,7 +144,11 @@ func (dsw *DbStateWriter) DeleteAccount(ctx context.Context, address common.Addr
 	if err := rawdb.DeleteAccount(dsw.stateDb, addrHash); err != nil {
 		return err
 	}
+	dsw.size.ChangeAccount(original, nil)
 	if original.Incarnation > 0 {
+		if err := dsw.deleteAccountStorage(address, addrHash, original.Incarnation); err != nil {
+			return err
+		}
 		var b [8]byte
 		binary.BigEndian.PutUint64(b[:], original.Incarnation)
 		if err := dsw.stateDb.Put(dbutils.IncarnationMapBucket, address[:], b[:]); err != nil {
@@ -127,10 +169,32 @@ func (dsw *DbStateWriter) DeleteAccount(ctx context.Context, address common.Addr
 	return nil
 }
 
+// deleteAccountStorage removes the storage of the self-destructed contract. The removed items are recorded in the
+// changeset, so that the unwinding restores them, and the history lookups find them
+func (dsw *DbStateWriter) deleteAccountStorage(address common.Address, addrHash common.Hash, incarnation uint64) error {
+	prefix := dbutils.GenerateStoragePrefix(addrHash[:], incarnation)
+	return ethdb.DeleteRange(dsw.stateDb, dbutils.CurrentStateBucket, prefix, 8*len(prefix), func(k, v []byte) error {
+		dsw.csw.DeleteStorage(address, k, v)
+		dsw.size.ChangeStorage(v, nil)
+		if dsw.storageCache != nil {
+			dsw.storageCache.Set(k, nil)
+		}
+		return nil
+	})
+}
+
 func (dsw *DbStateWriter) UpdateAccountCode(address common.Address, incarnation uint64, codeHash common.Hash, code []byte) error {
 	if err := dsw.csw.UpdateAccountCode(address, incarnation, codeHash, code); err != nil {
 		return err
 	}
+	// The code is shared by the contracts with the same code hash, only new code changes the size of the state
+	hasCode, err := dsw.stateDb.Has(dbutils.CodeBucket, codeHash[:])
+	if err != nil {
+		return err
+	}
+	if !hasCode {
+		dsw.size.AddCode(code)
+	}
 	//save contract code mapping
 	if err := dsw.stateDb.Put(dbutils.CodeBucket, codeHash[:], code); err != nil {
 		return err
@@ -177,6 +241,7 @@ func (dsw *DbStateWriter) WriteAccountStorage(ctx context.Context, address commo
 	compositeKey := dbutils.GenerateCompositeStorageKey(addrHash, incarnation, seckey)
 
 	v := value.Bytes()
+	dsw.size.ChangeStorage(original.Bytes(), v)
 	if dsw.storageCache != nil {
 		dsw.storageCache.Set(compositeKey, v)
 	}
@@ -226,14 +291,64 @@ func (dsw *DbStateWriter) WriteChangeSets() error {
 			return err
 		}
 	}
+	return ethdb.WriteChangeSetSummary(dsw.changeDb, dsw.blockNr, ethdb.ChangeSetSummary{
+		AccountChanges: uint32(accountChanges.Len()),
+		StorageChanges: uint32(storageChanges.Len()),
+		AccountBytes:   uint32(len(accountSerialised)),
+		StorageBytes:   uint32(len(storageSerialized)),
+	})
+}
+
+// WriteIncarnationIndex records the creations and the destructions of the accounts made by the block
+// (see rawdb.WriteIncarnationChange), comparing the values of the changeset with the written ones
+func (dsw *DbStateWriter) WriteIncarnationIndex() error {
+	accountChanges, err := dsw.csw.GetAccountChanges()
+	if err != nil {
+		return err
+	}
+	for _, change := range accountChanges.Changes {
+		after, err := dsw.stateDb.Get(dbutils.CurrentStateBucket, change.Key)
+		if err != nil && !errors.Is(err, ethdb.ErrKeyNotFound) {
+			return err
+		}
+		if err := rawdb.WriteIncarnationChange(dsw.changeDb, common.BytesToHash(change.Key), dsw.blockNr, change.Value, after); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// WriteStateSize adds the changes of the state size made by the writer to the persisted totals (see ReadStateSize).
+// The totals start being tracked with the genesis state, for the databases created before that,
+// they have to be computed by ComputeStateSize
+func (dsw *DbStateWriter) WriteStateSize() error {
+	if err := UpdateStateSize(dsw.stateDb, &dsw.size, dsw.blockNr == 0); err != nil {
+		return err
+	}
+	dsw.size = StateSize{}
+	return nil
+}
+
+// WriteHistory updates the history indexes with the changes of the block, or buffers the updates
+// if the writer has the coalescer (see HistoryIndexCoalescer). The accounts excluded by the filter
+// (see ethdb.HistoryIndexFilter) are not indexed
 func (dsw *DbStateWriter) WriteHistory() error {
+	filter, err := dsw.historyFilter.get(dsw.changeDb)
+	if err != nil {
+		return err
+	}
 	accountChanges, err := dsw.csw.GetAccountChanges()
 	if err != nil {
 		return err
 	}
+	accountChanges = indexedChanges(filter, accountChanges)
+	if dsw.historyIndex != nil {
+		storageChanges, err := dsw.csw.GetStorageChanges()
+		if err != nil {
+			return err
+		}
+		return dsw.historyIndex.Add(dsw.changeDb, dsw.blockNr, accountChanges, indexedChanges(filter, storageChanges))
+	}
 	err = dsw.writeIndex(accountChanges, dbutils.AccountsHistoryBucket)
 	if err != nil {
 		return err
@@ -243,7 +358,7 @@ func (dsw *DbStateWriter) WriteHistory() error {
 	if err != nil {
 		return err
 	}
-	err = dsw.writeIndex(storageChanges, dbutils.StorageHistoryBucket)
+	err = dsw.writeIndex(indexedChanges(filter, storageChanges), dbutils.StorageHistoryBucket)
 	if err != nil {
 		return err
 	}
@@ -253,38 +368,9 @@ func (dsw *DbStateWriter) WriteHistory() error {
 
 func (dsw *DbStateWriter) writeIndex(changes *changeset.ChangeSet, bucket []byte) error {
 	for _, change := range changes.Changes {
-		currentChunkKey := dbutils.IndexChunkKey(change.Key, ^uint64(0))
-		indexBytes, err := dsw.changeDb.Get(bucket, currentChunkKey)
-		if err != nil && err != ethdb.ErrKeyNotFound {
-			return fmt.Errorf("find chunk failed: %w", err)
-		}
-		v := dsw.blockNr
-
-		var index dbutils.HistoryIndexBytes
-		if len(indexBytes) == 0 {
-			index = dbutils.NewHistoryIndex()
-		} else if dbutils.CheckNewIndexChunk(indexBytes, v) {
-			// Chunk overflow, need to write the "old" current chunk under its key derived from the last element
-			index = dbutils.WrapHistoryIndex(indexBytes)
-			indexKey, err := index.Key(change.Key)
-			if err != nil {
-				return err
-			}
-			// Flush the old chunk
-			if err := dsw.changeDb.Put(bucket, indexKey, index); err != nil {
-				return err
-			}
-			// Start a new chunk
-			index = dbutils.NewHistoryIndex()
-		} else {
-			index = dbutils.WrapHistoryIndex(indexBytes)
-		}
-		index = index.Append(v, len(change.Value) == 0)
-
-		if err := dsw.changeDb.Put(bucket, currentChunkKey, index); err != nil {
+		if err := appendHistoryIndex(dsw.changeDb, bucket, change.Key, []historyIndexUpdate{{dsw.blockNr, len(change.Value) == 0}}); err != nil {
 			return err
 		}
 	}
-
 	return nil
 }
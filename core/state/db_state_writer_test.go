@@ -0,0 +1,123 @@
+package state
+
+import (
+	"context"
+	"testing"
+
+	"github.com/holiman/uint256"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+	"github.com/ledgerwatch/turbo-geth/core/types/accounts"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+)
+
+func TestDeleteAccountStorage(t *testing.T) {
+	db := ethdb.NewMemDatabase()
+	defer db.Close()
+	ctx := context.Background()
+
+	contract := common.HexToAddress("0x1234")
+	other := common.HexToAddress("0x5678")
+	keys := []common.Hash{common.HexToHash("0x01"), common.HexToHash("0x02")}
+	zero := uint256.NewInt()
+
+	// The storage is not committed into the database when the contract self-destructs
+	batch := db.NewBatch()
+	w := NewDbStateWriter(batch, batch, 0)
+	contractAccount := accounts.NewAccount()
+	contractAccount.Initialised = true
+	contractAccount.Incarnation = FirstContractIncarnation
+	for i, key := range keys {
+		key := key
+		require.NoError(t, w.WriteAccountStorage(ctx, contract, contractAccount.Incarnation, &key, zero, uint256.NewInt().SetUint64(uint64(i+1))))
+		require.NoError(t, w.WriteAccountStorage(ctx, other, contractAccount.Incarnation, &key, zero, uint256.NewInt().SetUint64(uint64(i+1))))
+	}
+	require.NoError(t, w.UpdateAccountData(ctx, contract, &accounts.Account{}, &contractAccount))
+	require.NoError(t, w.UpdateAccountData(ctx, other, &accounts.Account{}, &contractAccount))
+	require.NoError(t, w.WriteStateSize())
+
+	w = NewDbStateWriter(batch, batch, 1)
+	require.NoError(t, w.DeleteAccount(ctx, contract, &contractAccount))
+	require.NoError(t, w.WriteStateSize())
+	_, err := batch.Commit()
+	require.NoError(t, err)
+
+	addrHash, err := common.HashData(contract[:])
+	require.NoError(t, err)
+	prefix := dbutils.GenerateStoragePrefix(addrHash[:], contractAccount.Incarnation)
+	require.NoError(t, db.Walk(dbutils.CurrentStateBucket, prefix, 8*len(prefix), func(k, _ []byte) (bool, error) {
+		t.Errorf("storage item %x is not deleted", k)
+		return true, nil
+	}))
+
+	// The removed items are in the changeset with their last values
+	changes, err := w.csw.GetStorageChanges()
+	require.NoError(t, err)
+	require.Len(t, changes.Changes, len(keys))
+	for _, c := range changes.Changes {
+		assert.Equal(t, prefix, c.Key[:len(prefix)])
+		assert.NotEmpty(t, c.Value)
+	}
+
+	size, err := ReadStateSize(db)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), size.Accounts)
+	assert.Equal(t, int64(len(keys)), size.StorageSlots, "only the storage of the other contract is left")
+	computed, err := ComputeStateSize(db)
+	require.NoError(t, err)
+	assert.Equal(t, computed, size)
+}
+
+func TestPlainDeleteAccountStorage(t *testing.T) {
+	db := ethdb.NewMemDatabase()
+	defer db.Close()
+	ctx := context.Background()
+
+	contract := common.HexToAddress("0x1234")
+	other := common.HexToAddress("0x5678")
+	keys := []common.Hash{common.HexToHash("0x01"), common.HexToHash("0x02")}
+	zero := uint256.NewInt()
+
+	batch := db.NewBatch()
+	w := NewPlainStateWriter(batch, batch, 0)
+	contractAccount := accounts.NewAccount()
+	contractAccount.Initialised = true
+	contractAccount.Incarnation = FirstContractIncarnation
+	for i, key := range keys {
+		key := key
+		require.NoError(t, w.WriteAccountStorage(ctx, contract, contractAccount.Incarnation, &key, zero, uint256.NewInt().SetUint64(uint64(i+1))))
+		require.NoError(t, w.WriteAccountStorage(ctx, other, contractAccount.Incarnation, &key, zero, uint256.NewInt().SetUint64(uint64(i+1))))
+	}
+	require.NoError(t, w.UpdateAccountData(ctx, contract, &accounts.Account{}, &contractAccount))
+	require.NoError(t, w.UpdateAccountData(ctx, other, &accounts.Account{}, &contractAccount))
+
+	w = NewPlainStateWriter(batch, batch, 1)
+	require.NoError(t, w.DeleteAccount(ctx, contract, &contractAccount))
+	_, err := batch.Commit()
+	require.NoError(t, err)
+
+	prefix := dbutils.PlainGenerateStoragePrefix(contract, contractAccount.Incarnation)
+	require.NoError(t, db.Walk(dbutils.PlainStateBucket, prefix, 8*len(prefix), func(k, _ []byte) (bool, error) {
+		t.Errorf("storage item %x is not deleted", k)
+		return true, nil
+	}))
+	otherPrefix := dbutils.PlainGenerateStoragePrefix(other, contractAccount.Incarnation)
+	var left int
+	require.NoError(t, db.Walk(dbutils.PlainStateBucket, otherPrefix, 8*len(otherPrefix), func(_, _ []byte) (bool, error) {
+		left++
+		return true, nil
+	}))
+	assert.Equal(t, len(keys), left, "the storage of the other contract is kept")
+
+	// The removed items are in the changeset with their last values
+	changes, err := w.csw.GetStorageChanges()
+	require.NoError(t, err)
+	require.Len(t, changes.Changes, len(keys))
+	for _, c := range changes.Changes {
+		assert.Equal(t, prefix, c.Key[:len(prefix)])
+		assert.NotEmpty(t, c.Value)
+	}
+}
@@ -19,12 +19,12 @@ package state
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 
 	"github.com/ledgerwatch/turbo-geth/common"
 	"github.com/ledgerwatch/turbo-geth/common/dbutils"
 	"github.com/ledgerwatch/turbo-geth/common/hexutil"
-	"github.com/ledgerwatch/turbo-geth/core/types/accounts"
 	"github.com/ledgerwatch/turbo-geth/crypto"
 	"github.com/ledgerwatch/turbo-geth/ethdb"
 )
@@ -126,41 +126,20 @@ func (d *Dumper) dump(c collector, excludeCode, excludeStorage, _ bool, start []
 	var addrHashList []common.Hash
 	c.onRoot(emptyHash) // We do not calculate the root
 
-	var acc accounts.Account
-	numberOfResults := 0
-	err = d.db.WalkAsOf(dbutils.CurrentStateBucket, dbutils.AccountsHistoryBucket, start, 0, d.blockNumber+1, func(k, v []byte) (bool, error) {
-		if maxResults > 0 && numberOfResults >= maxResults {
-			if nextKey == nil {
-				nextKey = make([]byte, len(k))
-			}
-			copy(nextKey, k)
-			return false, nil
-		}
-
-		if len(k) > 32 {
-			return true, nil
-		}
-		var err error
-		if err = acc.DecodeForStorage(v); err != nil {
-			return false, fmt.Errorf("decoding %x for %x: %v", v, k, err)
-		}
-		account := DumpAccount{
-			Balance:  acc.Balance.ToBig().String(),
-			Nonce:    acc.Nonce,
+	var page []HistoricalAccount
+	if page, nextKey, err = AccountRangeAsOf(d.db, d.blockNumber, start, maxResults); err != nil {
+		return nil, err
+	}
+	for _, a := range page {
+		accountList = append(accountList, &DumpAccount{
+			Balance:  a.Account.Balance.ToBig().String(),
+			Nonce:    a.Account.Nonce,
 			Root:     common.Bytes2Hex(emptyHash[:]), // We cannot provide historical storage hash
 			CodeHash: common.Bytes2Hex(emptyCodeHash[:]),
 			Storage:  make(map[string]string),
-		}
-		accountList = append(accountList, &account)
-		addrHashList = append(addrHashList, common.BytesToHash(k))
-		incarnationList = append(incarnationList, acc.Incarnation)
-
-		numberOfResults++
-
-		return true, nil
-	})
-	if err != nil {
-		return nil, err
+		})
+		addrHashList = append(addrHashList, a.AddrHash)
+		incarnationList = append(incarnationList, a.Account.Incarnation)
 	}
 
 	for i, addrHash := range addrHashList {
@@ -175,7 +154,7 @@ func (d *Dumper) dump(c collector, excludeCode, excludeStorage, _ bool, start []
 		if incarnation > 0 {
 			var codeHash []byte
 			codeHash, err = d.db.Get(dbutils.ContractCodeBucket, storagePrefix)
-			if err != nil && err != ethdb.ErrKeyNotFound {
+			if err != nil && !errors.Is(err, ethdb.ErrKeyNotFound) {
 				return nil, fmt.Errorf("getting code hash for %x: %v", addrHash, err)
 			}
 			if codeHash != nil {
@@ -185,7 +164,7 @@ func (d *Dumper) dump(c collector, excludeCode, excludeStorage, _ bool, start []
 			}
 			if !excludeCode && codeHash != nil && !bytes.Equal(emptyCodeHash[:], codeHash) {
 				var code []byte
-				if code, err = d.db.Get(dbutils.CodeBucket, codeHash); err != nil {
+				if code, err = PeekCode(d.db, common.BytesToHash(codeHash)); err != nil {
 					return nil, err
 				}
 				account.Code = common.Bytes2Hex(code)
@@ -0,0 +1,248 @@
+package state
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/VictoriaMetrics/fastcache"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/core/types/accounts"
+	"github.com/ledgerwatch/turbo-geth/metrics"
+)
+
+// ErrStateReaderTimeout is returned (wrapped) by the layer of FallbackStateReader, which did not answer in time
+var ErrStateReaderTimeout = errors.New("state reader timeout")
+
+// StateReaderLayer is one layer of FallbackStateReader
+type StateReaderLayer struct {
+	Name   string // Name of the layer in the metrics: state/fallback/<name>/{hit,miss,error,timeout,read}
+	Reader StateReader
+	// Time after which the read is abandoned and the next layer is tried, 0 means no limit.
+	// With the limit, the reader has to be safe for concurrent use, because the abandoned read may still be running
+	Timeout time.Duration
+}
+
+type fallbackLayer struct {
+	StateReaderLayer
+	hitMeter     metrics.Meter
+	missMeter    metrics.Meter
+	errorMeter   metrics.Meter
+	timeoutMeter metrics.Meter
+	readTimer    metrics.Timer
+}
+
+// FallbackStateReader implements StateReader by trying the layers in order, i.e. the local flat DB and then the remote
+// KV endpoint, so that "thin" RPC daemons only need to keep the hot state locally. The layer, which does not have
+// the item, fails or times out, passes the read to the next one, and the answer of the last layer is final.
+// The items found are kept in the optional cache, which is consulted first, see SetCache
+type FallbackStateReader struct {
+	layers []*fallbackLayer
+	cache  *fastcache.Cache
+
+	cacheHitMeter  metrics.Meter
+	cacheMissMeter metrics.Meter
+}
+
+// NewFallbackStateReader creates the reader of the layers, there has to be at least one
+func NewFallbackStateReader(layers ...StateReaderLayer) *FallbackStateReader {
+	if len(layers) == 0 {
+		panic("no state reader layers")
+	}
+	r := &FallbackStateReader{
+		cacheHitMeter:  metrics.GetOrRegisterMeter("state/fallback/cache/hit", nil),
+		cacheMissMeter: metrics.GetOrRegisterMeter("state/fallback/cache/miss", nil),
+	}
+	for _, l := range layers {
+		prefix := "state/fallback/" + l.Name + "/"
+		r.layers = append(r.layers, &fallbackLayer{
+			StateReaderLayer: l,
+			hitMeter:         metrics.GetOrRegisterMeter(prefix+"hit", nil),
+			missMeter:        metrics.GetOrRegisterMeter(prefix+"miss", nil),
+			errorMeter:       metrics.GetOrRegisterMeter(prefix+"error", nil),
+			timeoutMeter:     metrics.GetOrRegisterMeter(prefix+"timeout", nil),
+			readTimer:        metrics.GetOrRegisterTimer(prefix+"read", nil),
+		})
+	}
+	return r
+}
+
+// SetCache sets the cache of the items found in the layers, nil disables it. The accounts and the storage
+// are not invalidated by the reader, the cache has to be reset when the state it reads moves to another block
+func (r *FallbackStateReader) SetCache(cache *fastcache.Cache) {
+	r.cache = cache
+}
+
+// The cache keys are told apart by their lengths: the address of the account (20 bytes), the code hash (32 bytes)
+// and the address with the incarnation and the storage key (60 bytes)
+func storageCacheKey(address common.Address, incarnation uint64, key *common.Hash) []byte {
+	k := make([]byte, common.AddressLength+8+common.HashLength)
+	copy(k, address[:])
+	binary.BigEndian.PutUint64(k[common.AddressLength:], incarnation)
+	copy(k[common.AddressLength+8:], key[:])
+	return k
+}
+
+func (r *FallbackStateReader) cacheGet(k []byte) ([]byte, bool) {
+	if r.cache == nil {
+		return nil, false
+	}
+	v, ok := r.cache.HasGet(nil, k)
+	if ok {
+		r.cacheHitMeter.Mark(1)
+	} else {
+		r.cacheMissMeter.Mark(1)
+	}
+	return v, ok
+}
+
+func (r *FallbackStateReader) cacheSet(k, v []byte) {
+	if r.cache != nil {
+		r.cache.Set(k, v)
+	}
+}
+
+// layerRead reads from one layer, returning the value and whether the layer has it
+type layerRead func(StateReader) (interface{}, bool, error)
+
+// read tries the layers until one of them has the value. The value is nil when none of the layers has it,
+// the error is only returned when the last layer fails
+func (r *FallbackStateReader) read(f layerRead) (interface{}, error) {
+	var err error
+	for i, l := range r.layers {
+		var v interface{}
+		var found bool
+		if v, found, err = l.read(f); err != nil {
+			l.errorMeter.Mark(1)
+			continue
+		}
+		if found {
+			l.hitMeter.Mark(1)
+			return v, nil
+		}
+		l.missMeter.Mark(1)
+		if i == len(r.layers)-1 {
+			return nil, nil
+		}
+	}
+	return nil, err
+}
+
+func (l *fallbackLayer) read(f layerRead) (interface{}, bool, error) {
+	defer l.readTimer.UpdateSince(time.Now())
+	if l.Timeout == 0 {
+		return f(l.Reader)
+	}
+	type result struct {
+		v     interface{}
+		found bool
+		err   error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		v, found, err := f(l.Reader)
+		ch <- result{v, found, err}
+	}()
+	timer := time.NewTimer(l.Timeout)
+	defer timer.Stop()
+	select {
+	case res := <-ch:
+		return res.v, res.found, res.err
+	case <-timer.C:
+		l.timeoutMeter.Mark(1)
+		return nil, false, fmt.Errorf("%w: layer %s, %v", ErrStateReaderTimeout, l.Name, l.Timeout)
+	}
+}
+
+func (r *FallbackStateReader) ReadAccountData(address common.Address) (*accounts.Account, error) {
+	if enc, ok := r.cacheGet(address[:]); ok {
+		acc := &accounts.Account{}
+		if err := acc.DecodeForStorage(enc); err != nil {
+			return nil, err
+		}
+		return acc, nil
+	}
+	v, err := r.read(func(sr StateReader) (interface{}, bool, error) {
+		acc, err := sr.ReadAccountData(address)
+		return acc, acc != nil, err
+	})
+	if err != nil || v == nil {
+		return nil, err
+	}
+	acc := v.(*accounts.Account)
+	if r.cache != nil {
+		enc := make([]byte, acc.EncodingLengthForStorage())
+		acc.EncodeForStorage(enc)
+		r.cacheSet(address[:], enc)
+	}
+	return acc, nil
+}
+
+func (r *FallbackStateReader) ReadAccountStorage(address common.Address, incarnation uint64, key *common.Hash) ([]byte, error) {
+	cacheKey := storageCacheKey(address, incarnation, key)
+	if enc, ok := r.cacheGet(cacheKey); ok {
+		return enc, nil
+	}
+	v, err := r.read(func(sr StateReader) (interface{}, bool, error) {
+		enc, err := sr.ReadAccountStorage(address, incarnation, key)
+		return enc, len(enc) > 0, err
+	})
+	if err != nil || v == nil {
+		return nil, err
+	}
+	enc := v.([]byte)
+	r.cacheSet(cacheKey, enc)
+	return enc, nil
+}
+
+func (r *FallbackStateReader) ReadAccountCode(address common.Address, codeHash common.Hash) ([]byte, error) {
+	if bytes.Equal(codeHash[:], emptyCodeHash) {
+		return nil, nil
+	}
+	if code, ok := r.cacheGet(codeHash[:]); ok {
+		return code, nil
+	}
+	v, err := r.read(func(sr StateReader) (interface{}, bool, error) {
+		code, err := sr.ReadAccountCode(address, codeHash)
+		return code, len(code) > 0, err
+	})
+	if err != nil || v == nil {
+		return nil, err
+	}
+	code := v.([]byte)
+	if len(code) <= maxCachedCodeSize {
+		r.cacheSet(codeHash[:], code)
+	}
+	return code, nil
+}
+
+func (r *FallbackStateReader) ReadAccountCodeSize(address common.Address, codeHash common.Hash) (int, error) {
+	if bytes.Equal(codeHash[:], emptyCodeHash) {
+		return 0, nil
+	}
+	if code, ok := r.cacheGet(codeHash[:]); ok {
+		return len(code), nil
+	}
+	v, err := r.read(func(sr StateReader) (interface{}, bool, error) {
+		size, err := sr.ReadAccountCodeSize(address, codeHash)
+		return size, size > 0, err
+	})
+	if err != nil || v == nil {
+		return 0, err
+	}
+	return v.(int), nil
+}
+
+func (r *FallbackStateReader) ReadAccountIncarnation(address common.Address) (uint64, error) {
+	v, err := r.read(func(sr StateReader) (interface{}, bool, error) {
+		inc, err := sr.ReadAccountIncarnation(address)
+		return inc, inc > 0, err
+	})
+	if err != nil || v == nil {
+		return 0, err
+	}
+	return v.(uint64), nil
+}
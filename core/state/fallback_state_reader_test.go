@@ -0,0 +1,116 @@
+package state
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/fastcache"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+	"github.com/ledgerwatch/turbo-geth/core/types/accounts"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+)
+
+// stubStateReader delays or fails the reads of the embedded reader and counts them
+type stubStateReader struct {
+	StateReader
+	delay time.Duration
+	err   error
+	reads int
+}
+
+func (r *stubStateReader) ReadAccountData(address common.Address) (*accounts.Account, error) {
+	r.reads++
+	time.Sleep(r.delay)
+	if r.err != nil {
+		return nil, r.err
+	}
+	return r.StateReader.ReadAccountData(address)
+}
+
+func (r *stubStateReader) ReadAccountStorage(address common.Address, incarnation uint64, key *common.Hash) ([]byte, error) {
+	r.reads++
+	time.Sleep(r.delay)
+	if r.err != nil {
+		return nil, r.err
+	}
+	return r.StateReader.ReadAccountStorage(address, incarnation, key)
+}
+
+func putAccount(t *testing.T, db ethdb.Putter, address common.Address, nonce uint64) {
+	addrHash, err := common.HashData(address[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	acc := accounts.NewAccount()
+	acc.Nonce = nonce
+	acc.Incarnation = 1
+	v := make([]byte, acc.EncodingLengthForStorage())
+	acc.EncodeForStorage(v)
+	if err = db.Put(dbutils.CurrentStateBucket, addrHash[:], v); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFallbackStateReader(t *testing.T) {
+	local, remote := ethdb.NewMemDatabase(), ethdb.NewMemDatabase()
+	defer local.Close()
+	defer remote.Close()
+	hot, cold, absent := common.Address{1}, common.Address{2}, common.Address{3}
+	putAccount(t, local, hot, 1)
+	putAccount(t, remote, hot, 1)
+	putAccount(t, remote, cold, 2)
+	key := common.Hash{1}
+	addrHash, _ := common.HashData(cold[:])
+	seckey, _ := common.HashData(key[:])
+	if err := remote.Put(dbutils.CurrentStateBucket, dbutils.GenerateCompositeStorageKey(addrHash, 1, seckey), []byte{0x42}); err != nil {
+		t.Fatal(err)
+	}
+
+	localReader := &stubStateReader{StateReader: NewDbStateReader(local)}
+	remoteReader := &stubStateReader{StateReader: NewDbStateReader(remote)}
+	r := NewFallbackStateReader(
+		StateReaderLayer{Name: "test-local", Reader: localReader},
+		StateReaderLayer{Name: "test-remote", Reader: remoteReader, Timeout: 50 * time.Millisecond},
+	)
+	r.SetCache(fastcache.New(1024 * 1024))
+
+	for _, tc := range []struct {
+		address common.Address
+		nonce   uint64
+		remote  int
+	}{{hot, 1, 0}, {cold, 2, 1}, {cold, 2, 1}} { // The second read of the cold account comes from the cache
+		acc, err := r.ReadAccountData(tc.address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if acc == nil || acc.Nonce != tc.nonce {
+			t.Fatalf("account %x: got %v, expected nonce %d", tc.address, acc, tc.nonce)
+		}
+		if remoteReader.reads != tc.remote {
+			t.Fatalf("account %x: %d remote reads, expected %d", tc.address, remoteReader.reads, tc.remote)
+		}
+	}
+	// The answer of the last layer is final
+	if acc, err := r.ReadAccountData(absent); err != nil || acc != nil {
+		t.Fatalf("absent account: got %v, %v", acc, err)
+	}
+	if v, err := r.ReadAccountStorage(cold, 1, &key); err != nil || !bytes.Equal(v, []byte{0x42}) {
+		t.Fatalf("storage: got %x, %v", v, err)
+	}
+
+	// The failing local layer passes the read on
+	r.SetCache(nil)
+	localReader.err = errors.New("local failure")
+	if acc, err := r.ReadAccountData(hot); err != nil || acc == nil {
+		t.Fatalf("failed over account: got %v, %v", acc, err)
+	}
+	// The failure of the last layer is returned
+	remoteReader.delay = time.Second
+	if _, err := r.ReadAccountData(hot); !errors.Is(err, ErrStateReaderTimeout) {
+		t.Fatalf("expected the timeout, got %v", err)
+	}
+}
@@ -0,0 +1,184 @@
+package state
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+	"github.com/ledgerwatch/turbo-geth/core/rawdb"
+	"github.com/ledgerwatch/turbo-geth/core/types/accounts"
+	"github.com/ledgerwatch/turbo-geth/trie"
+)
+
+var errFlatHashingWitness = errors.New("witnesses can not be extracted in the flat hashing mode")
+
+// updateTrieRootsFlat is the "flat hashing" counterpart of updateTrieRoots. Instead of applying the updates
+// to the in-memory trie, it streams the sorted union of CurrentStateBucket and the buffered updates through
+// HashBuilder (see trie.HashFlatWithModifications), so the memory used does not depend on the size of the state.
+// The in-memory trie is replaced by the root hash node.
+// The database must contain the state as of the beginning of the buffered updates, committed into Bolt,
+// i.e. the batch has to be committed after every block.
+func (tds *TrieDbState) updateTrieRootsFlat() ([]common.Hash, error) {
+	accountUpdates := tds.aggregateBuffer.accountUpdates
+	roots := make([]common.Hash, len(tds.buffers))
+	// Roots for every buffer (pre-Byzantium receipts need them) are computed from the accumulated updates
+	cumulative := &Buffer{}
+	cumulative.initialise()
+	var branches []flatBranchHash
+	for i, b := range tds.buffers {
+		cumulative.merge(b)
+		wiped := cumulative.wipedAccounts()
+		// Storage roots go first, they become part of the accounts written into the database
+		for addrHash := range b.storageUpdates {
+			root, err := tds.flatStorageRoot(cumulative, addrHash, wiped)
+			if err != nil {
+				return nil, err
+			}
+			if account, ok := b.accountUpdates[addrHash]; ok && account != nil {
+				account.Root = root
+			}
+			if account, ok := accountUpdates[addrHash]; ok && account != nil {
+				account.Root = root
+			}
+		}
+		aKeys, aValues := cumulative.accountWrites()
+		sKeys, sValues := cumulative.storageWrites()
+		var ihObserver func(prefix []byte, incarnation uint64, hash []byte, witnessLen uint64)
+		if i == len(tds.buffers)-1 {
+			// The branch nodes of the final state, written once the database is not read by the hashing
+			ihObserver = func(prefix []byte, incarnation uint64, hash []byte, witnessLen uint64) {
+				if len(prefix) > 0 && len(prefix)%2 == 0 {
+					branches = append(branches, flatBranchHash{common.CopyBytes(prefix), incarnation, common.BytesToHash(hash), witnessLen})
+				}
+			}
+		}
+		root, err := trie.HashFlatWithModifications(tds.db, nil, aKeys, aValues, sKeys, sValues, wiped, ihObserver, false)
+		if err != nil {
+			return nil, err
+		}
+		roots[i] = root
+	}
+	if err := tds.invalidateIntermediateHashes(cumulative); err != nil {
+		return nil, err
+	}
+	ih := NewIntermediateHashes(tds.db, tds.db)
+	for _, b := range branches {
+		ih.WillUnloadBranchNode(b.prefix, b.hash, b.incarnation, b.witnessLen)
+	}
+	if len(roots) > 0 {
+		tds.t = trie.New(roots[len(roots)-1])
+		tds.t.AddObserver(tds.tp)
+		tds.t.AddObserver(NewIntermediateHashes(tds.db, tds.db))
+	}
+	return roots, nil
+}
+
+// flatBranchHash is the branch node hashed by updateTrieRootsFlat, which becomes the intermediate hash
+type flatBranchHash struct {
+	prefix      []byte // In nibbles
+	incarnation uint64
+	hash        common.Hash
+	witnessLen  uint64
+}
+
+// flatStorageRoot computes the root of the storage trie of the account after the updates in the buffer
+func (tds *TrieDbState) flatStorageRoot(b *Buffer, addrHash common.Hash, wiped map[common.Hash]struct{}) (common.Hash, error) {
+	incarnation, err := tds.flatIncarnation(b, addrHash)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	m := b.storageUpdates[addrHash]
+	sKeys := make(common.StorageKeys, 0, len(m))
+	for keyHash := range m {
+		var storageKey common.StorageKey
+		copy(storageKey[:], addrHash[:])
+		copy(storageKey[common.HashLength:], keyHash[:])
+		sKeys = append(sKeys, storageKey)
+	}
+	sort.Sort(sKeys)
+	sValues := make([][]byte, len(sKeys))
+	for i, storageKey := range sKeys {
+		sValues[i] = m[common.BytesToHash(storageKey[common.HashLength:])]
+	}
+	return trie.HashFlatWithModifications(tds.db, dbutils.GenerateStoragePrefix(addrHash[:], incarnation), nil, nil, sKeys, sValues, wiped, nil, false)
+}
+
+// flatIncarnation returns the incarnation of the account after the updates in the buffer
+func (tds *TrieDbState) flatIncarnation(b *Buffer, addrHash common.Hash) (uint64, error) {
+	if account, ok := b.accountUpdates[addrHash]; ok && account != nil {
+		return account.Incarnation, nil
+	}
+	var account accounts.Account
+	if ok, err := rawdb.ReadAccount(tds.db, addrHash, &account); err != nil || !ok {
+		return 0, err
+	}
+	return account.Incarnation, nil
+}
+
+// invalidateIntermediateHashes removes the intermediate hashes along the paths of the updated keys,
+// the same way IntermediateHashes.BranchNodeLoaded does when the in-memory trie gets modified.
+// The branch nodes still on the paths get their hashes back from the hashing of the final state afterwards
+func (tds *TrieDbState) invalidateIntermediateHashes(b *Buffer) error {
+	ihKeys := make(map[string]struct{})
+	addAccountPrefixes := func(addrHash common.Hash) {
+		for l := 1; l < common.HashLength; l++ {
+			ihKeys[string(addrHash[:l])] = struct{}{}
+		}
+	}
+	for addrHash := range b.accountUpdates {
+		addAccountPrefixes(addrHash)
+	}
+	for addrHash, m := range b.storageUpdates {
+		addAccountPrefixes(addrHash)
+		incarnation, err := tds.flatIncarnation(b, addrHash)
+		if err != nil {
+			return err
+		}
+		for keyHash := range m {
+			for l := 0; l < common.HashLength; l++ {
+				ihKeys[string(dbutils.GenerateCompositeStoragePrefix(addrHash[:], incarnation, keyHash[:l]))] = struct{}{}
+			}
+		}
+	}
+	for k := range ihKeys {
+		if err := tds.db.Delete(dbutils.IntermediateTrieHashBucket, []byte(k)); err != nil {
+			return err
+		}
+		if err := tds.db.Delete(dbutils.IntermediateTrieWitnessLenBucket, []byte(k)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// wipedAccounts returns the accounts which storage found in the database is not valid anymore
+func (b *Buffer) wipedAccounts() map[common.Hash]struct{} {
+	wiped := make(map[common.Hash]struct{}, len(b.deleted)+len(b.created))
+	for addrHash := range b.deleted {
+		wiped[addrHash] = struct{}{}
+	}
+	for addrHash := range b.created {
+		wiped[addrHash] = struct{}{}
+	}
+	for addrHash, account := range b.accountUpdates {
+		if account == nil {
+			wiped[addrHash] = struct{}{}
+		}
+	}
+	return wiped
+}
+
+// accountWrites returns sorted keys and values of the account updates of the buffer, nil value means deletion
+func (b *Buffer) accountWrites() (common.Hashes, []*accounts.Account) {
+	aKeys := make(common.Hashes, 0, len(b.accountUpdates))
+	for addrHash := range b.accountUpdates {
+		aKeys = append(aKeys, addrHash)
+	}
+	sort.Sort(aKeys)
+	aValues := make([]*accounts.Account, len(aKeys))
+	for i, addrHash := range aKeys {
+		aValues[i] = b.accountUpdates[addrHash]
+	}
+	return aKeys, aValues
+}
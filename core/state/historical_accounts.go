@@ -0,0 +1,117 @@
+package state
+
+import (
+	"fmt"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+	"github.com/ledgerwatch/turbo-geth/core/types/accounts"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+)
+
+// DefaultHistoricalAccountsPage is the number of the accounts HistoricalAccountIterator reads at once
+const DefaultHistoricalAccountsPage = 1024
+
+// HistoricalAccount is the account as of a block, together with its address hash
+type HistoricalAccount struct {
+	AddrHash common.Hash
+	Account  accounts.Account
+}
+
+// AccountRangeAsOf returns up to maxResults (all if it is not positive) accounts, which existed after the execution
+// of the block, in the order of their address hashes, starting from the (prefix of) address hash `start`.
+// The accounts changed after the block are taken from the account changesets, the rest from the flat state.
+// next is the key to continue from, it is nil when there are no more accounts
+func AccountRangeAsOf(db ethdb.Getter, blockNumber uint64, start []byte, maxResults int) (result []HistoricalAccount, next []byte, err error) {
+	err = db.WalkAsOf(dbutils.CurrentStateBucket, dbutils.AccountsHistoryBucket, start, 0, blockNumber+1, func(k, v []byte) (bool, error) {
+		if len(k) != common.HashLength {
+			return true, nil
+		}
+		if maxResults > 0 && len(result) >= maxResults {
+			next = common.CopyBytes(k)
+			return false, nil
+		}
+		var acc accounts.Account
+		if err := acc.DecodeForStorage(v); err != nil {
+			return false, fmt.Errorf("decoding %x for %x: %w", v, k, err)
+		}
+		result = append(result, HistoricalAccount{AddrHash: common.BytesToHash(k), Account: acc})
+		return true, nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return result, next, nil
+}
+
+// HistoricalAccountIterator iterates over the accounts as of the block (see AccountRangeAsOf). The accounts are read
+// in pages, so no database transaction is held open between the calls to Next
+type HistoricalAccountIterator struct {
+	db          ethdb.Getter
+	blockNumber uint64
+	pageSize    int
+
+	page []HistoricalAccount
+	pos  int
+	next []byte // Key of the next page
+	done bool   // The last page has been read
+	err  error
+}
+
+// NewHistoricalAccountIterator creates the iterator starting from the (prefix of) address hash `start`,
+// pageSize not above zero means DefaultHistoricalAccountsPage
+func NewHistoricalAccountIterator(db ethdb.Getter, blockNumber uint64, start []byte, pageSize int) *HistoricalAccountIterator {
+	if pageSize <= 0 {
+		pageSize = DefaultHistoricalAccountsPage
+	}
+	return &HistoricalAccountIterator{
+		db:          db,
+		blockNumber: blockNumber,
+		pageSize:    pageSize,
+		pos:         -1,
+		next:        common.CopyBytes(start),
+	}
+}
+
+// Next moves to the next account, returning false at the end or on the error, see Err
+func (it *HistoricalAccountIterator) Next() bool {
+	if it.err != nil {
+		return false
+	}
+	it.pos++
+	for it.pos >= len(it.page) {
+		if it.done {
+			return false
+		}
+		if it.page, it.next, it.err = AccountRangeAsOf(it.db, it.blockNumber, it.next, it.pageSize); it.err != nil {
+			return false
+		}
+		it.pos = 0
+		it.done = it.next == nil
+	}
+	return true
+}
+
+// AddrHash returns the address hash of the current account
+func (it *HistoricalAccountIterator) AddrHash() common.Hash {
+	return it.page[it.pos].AddrHash
+}
+
+// Account returns the current account
+func (it *HistoricalAccountIterator) Account() *accounts.Account {
+	return &it.page[it.pos].Account
+}
+
+// NextKey returns the key to resume the iteration from after the current account, which is nil at the end.
+// It lets the callers paginate their own results
+func (it *HistoricalAccountIterator) NextKey() []byte {
+	if it.pos+1 < len(it.page) {
+		return common.CopyBytes(it.page[it.pos+1].AddrHash[:])
+	}
+	return common.CopyBytes(it.next)
+}
+
+// Err returns the error, which has stopped the iteration
+func (it *HistoricalAccountIterator) Err() error {
+	return it.err
+}
@@ -0,0 +1,86 @@
+package state
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/core/types/accounts"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+)
+
+func TestHistoricalAccounts(t *testing.T) {
+	db := ethdb.NewMemDatabase()
+	defer db.Close()
+	ctx := context.Background()
+	addrs := []common.Address{{1}, {2}, {3}, {4}}
+	empty := accounts.NewAccount()
+	accs := make([]accounts.Account, len(addrs))
+	for i := range accs {
+		accs[i] = accounts.NewAccount()
+		accs[i].Initialised = true
+		accs[i].Nonce = uint64(i + 1)
+	}
+
+	// Block 1 creates the first three accounts, block 2 changes the first one, deletes the second one
+	// and creates the fourth one
+	w := NewDbStateWriter(db, db, 1)
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, w.UpdateAccountData(ctx, addrs[i], &empty, &accs[i]))
+	}
+	assert.NoError(t, w.WriteChangeSets())
+	assert.NoError(t, w.WriteHistory())
+	w = NewDbStateWriter(db, db, 2)
+	changed := accs[0].SelfCopy()
+	changed.Nonce = 10
+	assert.NoError(t, w.UpdateAccountData(ctx, addrs[0], &accs[0], changed))
+	assert.NoError(t, w.DeleteAccount(ctx, addrs[1], &accs[1]))
+	assert.NoError(t, w.UpdateAccountData(ctx, addrs[3], &empty, &accs[3]))
+	assert.NoError(t, w.WriteChangeSets())
+	assert.NoError(t, w.WriteHistory())
+
+	nonces := func(block uint64, pageSize int) map[common.Hash]uint64 {
+		result := make(map[common.Hash]uint64)
+		it := NewHistoricalAccountIterator(db, block, nil, pageSize)
+		var prev []byte
+		for it.Next() {
+			addrHash := it.AddrHash()
+			if prev != nil && string(prev) >= string(addrHash[:]) {
+				t.Errorf("block %d: %x after %x", block, addrHash, prev)
+			}
+			prev = addrHash[:]
+			result[addrHash] = it.Account().Nonce
+		}
+		assert.NoError(t, it.Err())
+		return result
+	}
+	hashOf := func(i int) common.Hash {
+		h, err := common.HashData(addrs[i][:])
+		assert.NoError(t, err)
+		return h
+	}
+	for _, pageSize := range []int{1, 2, 0} {
+		assert.Empty(t, nonces(0, pageSize), "page size %d", pageSize)
+		assert.Equal(t, map[common.Hash]uint64{hashOf(0): 1, hashOf(1): 2, hashOf(2): 3}, nonces(1, pageSize), "page size %d", pageSize)
+		assert.Equal(t, map[common.Hash]uint64{hashOf(0): 10, hashOf(2): 3, hashOf(3): 4}, nonces(2, pageSize), "page size %d", pageSize)
+	}
+
+	// The pages resume from the next key
+	var all []common.Hash
+	var start []byte
+	for {
+		page, next, err := AccountRangeAsOf(db, 1, start, 2)
+		assert.NoError(t, err)
+		assert.True(t, len(page) <= 2)
+		for _, a := range page {
+			all = append(all, a.AddrHash)
+		}
+		if next == nil {
+			break
+		}
+		start = next
+	}
+	assert.Len(t, all, 3)
+}
@@ -0,0 +1,226 @@
+package state
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/ledgerwatch/turbo-geth/common/changeset"
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+	"github.com/ledgerwatch/turbo-geth/log"
+)
+
+type historyIndexUpdate struct {
+	blockNr    uint64
+	emptyValue bool
+}
+
+// HistoryIndexCoalescer buffers the updates of the history indexes (dbutils.AccountsHistoryBucket and
+// dbutils.StorageHistoryBucket) of a window of blocks, and writes them at once, so that the index chunk of a key,
+// which changes in many blocks of the window, is read and written once instead of once per block.
+// The changesets of the blocks are written as usual, and serve as the journal: the first block of the pending
+// window is recorded (dbutils.HistoryIndexJournalKey) together with its changeset, and the updates lost with
+// the process are restored from the changesets by RecoverHistoryIndex.
+// The history of the blocks of the pending window is not visible to the readers of the index until the flush
+// (except for GetAsOf of the coalescer), so the coalescing is meant for the sync rather than for serving
+// the recent history
+type HistoryIndexCoalescer struct {
+	window  uint64
+	from    uint64 // First block of the pending updates
+	blocks  uint64 // Number of blocks with the pending updates
+	account map[string][]historyIndexUpdate
+	storage map[string][]historyIndexUpdate
+}
+
+// NewHistoryIndexCoalescer creates the coalescer flushing the updates of every window blocks
+func NewHistoryIndexCoalescer(window uint64) *HistoryIndexCoalescer {
+	return &HistoryIndexCoalescer{
+		window:  window,
+		account: make(map[string][]historyIndexUpdate),
+		storage: make(map[string][]historyIndexUpdate),
+	}
+}
+
+// Add buffers the updates of the indexes made by the changes of the block, and flushes them into the database
+// if the window is complete. The blocks have to be added in the ascending order
+func (c *HistoryIndexCoalescer) Add(db ethdb.Database, blockNr uint64, accountChanges, storageChanges *changeset.ChangeSet) error {
+	if c.blocks == 0 {
+		c.from = blockNr
+		v := make([]byte, 8)
+		binary.BigEndian.PutUint64(v, blockNr)
+		if err := db.Put(dbutils.DatabaseInfoBucket, dbutils.HistoryIndexJournalKey, v); err != nil {
+			return err
+		}
+	}
+	c.blocks++
+	for _, change := range accountChanges.Changes {
+		c.account[string(change.Key)] = append(c.account[string(change.Key)], historyIndexUpdate{blockNr, len(change.Value) == 0})
+	}
+	for _, change := range storageChanges.Changes {
+		c.storage[string(change.Key)] = append(c.storage[string(change.Key)], historyIndexUpdate{blockNr, len(change.Value) == 0})
+	}
+	if c.blocks >= c.window {
+		return c.Flush(db)
+	}
+	return nil
+}
+
+// Pending returns the number of blocks, the index updates of which are not written yet
+func (c *HistoryIndexCoalescer) Pending() uint64 {
+	return c.blocks
+}
+
+// GetAsOf is ethdb.Getter.GetAsOf, which sees the pending updates: the pending window is flushed first
+// if the key has changed in it at or after the timestamp
+func (c *HistoryIndexCoalescer) GetAsOf(db ethdb.Database, bucket, hBucket, key []byte, timestamp uint64) ([]byte, error) {
+	var pending map[string][]historyIndexUpdate
+	switch {
+	case bytes.Equal(hBucket, dbutils.AccountsHistoryBucket):
+		pending = c.account
+	case bytes.Equal(hBucket, dbutils.StorageHistoryBucket):
+		pending = c.storage
+	}
+	if updates := pending[string(key)]; len(updates) > 0 && updates[len(updates)-1].blockNr >= timestamp {
+		if err := c.Flush(db); err != nil {
+			return nil, err
+		}
+	}
+	return db.GetAsOf(bucket, hBucket, key, timestamp)
+}
+
+// Flush writes the pending updates into the indexes and clears the journal
+func (c *HistoryIndexCoalescer) Flush(db ethdb.Database) error {
+	if c.blocks == 0 {
+		return nil
+	}
+	if err := writeHistoryIndexUpdates(db, dbutils.AccountsHistoryBucket, c.account); err != nil {
+		return err
+	}
+	if err := writeHistoryIndexUpdates(db, dbutils.StorageHistoryBucket, c.storage); err != nil {
+		return err
+	}
+	if err := db.Delete(dbutils.DatabaseInfoBucket, dbutils.HistoryIndexJournalKey); err != nil {
+		return err
+	}
+	c.blocks = 0
+	c.account = make(map[string][]historyIndexUpdate)
+	c.storage = make(map[string][]historyIndexUpdate)
+	return nil
+}
+
+// RecoverHistoryIndex writes the updates of the indexes, which were buffered by HistoryIndexCoalescer
+// but not flushed before the process stopped, restoring them from the changesets of the journaled blocks
+// up to the block to (the block of the committed state). The blocks without the changesets change nothing
+func RecoverHistoryIndex(db ethdb.Database, to uint64) error {
+	v, err := db.Get(dbutils.DatabaseInfoBucket, dbutils.HistoryIndexJournalKey)
+	if err != nil && !errors.Is(err, ethdb.ErrKeyNotFound) {
+		return err
+	}
+	if len(v) != 8 {
+		return nil
+	}
+	from := binary.BigEndian.Uint64(v)
+	filter, err := ethdb.ReadHistoryIndexFilter(db)
+	if err != nil {
+		return err
+	}
+	c := NewHistoryIndexCoalescer(^uint64(0)) // Flushed at the end
+	for blockNr := from; blockNr <= to; blockNr++ {
+		accountEnc, err := ethdb.GetChangeSetByBlock(db, dbutils.AccountsHistoryBucket, blockNr)
+		if err != nil {
+			return err
+		}
+		accountChanges := changeset.NewAccountChangeSet()
+		if accountEnc != nil {
+			if accountChanges, err = changeset.DecodeAccounts(accountEnc); err != nil {
+				return fmt.Errorf("account changes of block %d: %w", blockNr, err)
+			}
+		}
+		storageChanges := changeset.NewStorageChangeSet()
+		storageEnc, err := ethdb.GetChangeSetByBlock(db, dbutils.StorageHistoryBucket, blockNr)
+		if err != nil {
+			return err
+		}
+		if storageEnc != nil {
+			if storageChanges, err = changeset.DecodeStorage(storageEnc); err != nil {
+				return fmt.Errorf("storage changes of block %d: %w", blockNr, err)
+			}
+		}
+		if accountEnc == nil && storageEnc == nil {
+			continue
+		}
+		if err = c.Add(db, blockNr, indexedChanges(filter, accountChanges), indexedChanges(filter, storageChanges)); err != nil {
+			return err
+		}
+	}
+	if c.Pending() > 0 {
+		log.Warn("Restoring the history index from the changesets", "from", from, "to", to)
+	}
+	if err := c.Flush(db); err != nil {
+		return err
+	}
+	// The journal of the window, none of the blocks of which were committed
+	return db.Delete(dbutils.DatabaseInfoBucket, dbutils.HistoryIndexJournalKey)
+}
+
+// indexedChanges returns the changes of the accounts, the history of which is indexed (see ethdb.HistoryIndexFilter)
+func indexedChanges(filter ethdb.HistoryIndexFilter, changes *changeset.ChangeSet) *changeset.ChangeSet {
+	if len(filter) == 0 {
+		return changes
+	}
+	indexed := &changeset.ChangeSet{}
+	for _, change := range changes.Changes {
+		if filter.Indexed(change.Key) {
+			indexed.Changes = append(indexed.Changes, change)
+		}
+	}
+	return indexed
+}
+
+func writeHistoryIndexUpdates(db ethdb.Database, bucket []byte, pending map[string][]historyIndexUpdate) error {
+	keys := make([]string, 0, len(pending))
+	for key := range pending {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if err := appendHistoryIndex(db, bucket, []byte(key), pending[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// appendHistoryIndex adds the blocks (in the ascending order) to the index of the key, the chunks, which
+// overflow, are written under the keys derived from their last blocks
+func appendHistoryIndex(db ethdb.Database, bucket, key []byte, updates []historyIndexUpdate) error {
+	currentChunkKey := dbutils.IndexChunkKey(key, ^uint64(0))
+	indexBytes, err := db.Get(bucket, currentChunkKey)
+	if err != nil && !errors.Is(err, ethdb.ErrKeyNotFound) {
+		return fmt.Errorf("find chunk failed: %w", err)
+	}
+	var index dbutils.HistoryIndexBytes
+	if len(indexBytes) == 0 {
+		index = dbutils.NewHistoryIndex()
+	} else {
+		index = dbutils.WrapHistoryIndex(indexBytes)
+	}
+	for _, u := range updates {
+		if dbutils.CheckNewIndexChunk(index, u.blockNr) {
+			// Chunk overflow, need to write the "old" current chunk under its key derived from the last element
+			indexKey, err := index.Key(key)
+			if err != nil {
+				return err
+			}
+			if err := db.Put(bucket, indexKey, index); err != nil {
+				return err
+			}
+			index = dbutils.NewHistoryIndex()
+		}
+		index = index.Append(u.blockNr, u.emptyValue)
+	}
+	return db.Put(bucket, currentChunkKey, index)
+}
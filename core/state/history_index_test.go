@@ -0,0 +1,107 @@
+package state
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/holiman/uint256"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+	"github.com/ledgerwatch/turbo-geth/core/types/accounts"
+	"github.com/ledgerwatch/turbo-geth/crypto"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+)
+
+// writeHistoryBlocks changes the balances of some of the accounts and a storage item of the contract in every block
+func writeHistoryBlocks(t *testing.T, db ethdb.Database, blocks uint64, historyIndex *HistoryIndexCoalescer) {
+	ctx := context.Background()
+	contract := common.HexToAddress("0xc0")
+	location := common.HexToHash("0x01")
+	for blockNr := uint64(1); blockNr <= blocks; blockNr++ {
+		w := NewDbStateWriter(db, db, blockNr)
+		w.historyIndex = historyIndex
+		for i := uint64(0); i < 4; i++ {
+			if blockNr%(i+1) != 0 {
+				continue
+			}
+			original := accounts.NewAccount()
+			if blockNr > i+1 {
+				original.Initialised = true
+				original.Balance.SetUint64(blockNr - i - 1)
+			}
+			acc := accounts.NewAccount()
+			acc.Initialised = true
+			acc.Balance.SetUint64(blockNr)
+			require.NoError(t, w.UpdateAccountData(ctx, common.Address{byte(i + 1)}, &original, &acc))
+		}
+		var prev, value uint256.Int
+		prev.SetUint64(blockNr - 1)
+		value.SetUint64(blockNr)
+		require.NoError(t, w.WriteAccountStorage(ctx, contract, FirstContractIncarnation, &location, &prev, &value))
+		require.NoError(t, w.WriteChangeSets())
+		require.NoError(t, w.WriteHistory())
+	}
+}
+
+func readHistoryIndexes(t *testing.T, db ethdb.Database) map[string]string {
+	indexes := make(map[string]string)
+	for _, bucket := range [][]byte{dbutils.AccountsHistoryBucket, dbutils.StorageHistoryBucket} {
+		require.NoError(t, db.Walk(bucket, nil, 0, func(k, v []byte) (bool, error) {
+			indexes[fmt.Sprintf("%s %x", bucket, k)] = fmt.Sprintf("%x", v)
+			return true, nil
+		}))
+	}
+	return indexes
+}
+
+func TestHistoryIndexCoalescer(t *testing.T) {
+	const blocks = 10
+	db := ethdb.NewMemDatabase()
+	defer db.Close()
+	writeHistoryBlocks(t, db, blocks, nil)
+	expected := readHistoryIndexes(t, db)
+	require.NotEmpty(t, expected)
+
+	coalescedDb := ethdb.NewMemDatabase()
+	defer coalescedDb.Close()
+	c := NewHistoryIndexCoalescer(4)
+	writeHistoryBlocks(t, coalescedDb, blocks, c)
+	assert.Equal(t, uint64(blocks%4), c.Pending())
+	// The pending updates are seen by GetAsOf of the coalescer
+	addrHash := crypto.Keccak256(common.Address{1}.Bytes())
+	want, err := db.GetAsOf(dbutils.CurrentStateBucket, dbutils.AccountsHistoryBucket, addrHash, blocks-1)
+	require.NoError(t, err)
+	got, err := c.GetAsOf(coalescedDb, dbutils.CurrentStateBucket, dbutils.AccountsHistoryBucket, addrHash, blocks-1)
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+	assert.Equal(t, uint64(0), c.Pending(), "flushed for the read")
+	require.NoError(t, c.Flush(coalescedDb))
+	assert.Equal(t, expected, readHistoryIndexes(t, coalescedDb))
+	_, err = coalescedDb.Get(dbutils.DatabaseInfoBucket, dbutils.HistoryIndexJournalKey)
+	assert.Error(t, err, "the journal is cleared by the flush")
+
+	// The pending updates are restored from the changesets
+	crashedDb := ethdb.NewMemDatabase()
+	defer crashedDb.Close()
+	writeHistoryBlocks(t, crashedDb, blocks, NewHistoryIndexCoalescer(4))
+	require.NoError(t, RecoverHistoryIndex(crashedDb, blocks))
+	assert.Equal(t, expected, readHistoryIndexes(t, crashedDb))
+	require.NoError(t, RecoverHistoryIndex(crashedDb, blocks))
+	assert.Equal(t, expected, readHistoryIndexes(t, crashedDb), "the recovery is done once")
+
+	// The blocks without the account changes do not stop the recovery
+	gappedDb := ethdb.NewMemDatabase()
+	defer gappedDb.Close()
+	writeHistoryBlocks(t, gappedDb, blocks, NewHistoryIndexCoalescer(4))
+	require.NoError(t, gappedDb.Delete(dbutils.AccountChangeSetBucket, dbutils.EncodeTimestamp(blocks-1)))
+	require.NoError(t, RecoverHistoryIndex(gappedDb, blocks))
+	index, err := gappedDb.Get(dbutils.AccountsHistoryBucket, dbutils.CurrentChunkKey(addrHash))
+	require.NoError(t, err)
+	changeBlock, _, ok := dbutils.WrapHistoryIndex(index).Search(blocks)
+	assert.True(t, ok)
+	assert.Equal(t, uint64(blocks), changeBlock, "the block after the gap is restored")
+}
@@ -604,3 +604,49 @@ func TestUnwindTruncateHistory(t *testing.T) {
 		}
 	}
 }
+
+func TestHistoricalTrieDbState(t *testing.T) {
+	db := ethdb.NewMemDatabase()
+	defer db.Close()
+	ctx := context.Background()
+	addr := common.HexToAddress("0x1234")
+	key := common.HexToHash("0x01")
+
+	empty := accounts.NewAccount()
+	prev := accounts.NewAccount()
+	for blockNr := uint64(1); blockNr <= 2; blockNr++ {
+		acc := accounts.NewAccount()
+		acc.Initialised = true
+		acc.Incarnation = FirstContractIncarnation
+		acc.Nonce = blockNr
+		w := NewDbStateWriter(db, db, blockNr)
+		if blockNr == 1 {
+			assert.NoError(t, w.UpdateAccountData(ctx, addr, &empty, &acc))
+		} else {
+			assert.NoError(t, w.UpdateAccountData(ctx, addr, &prev, &acc))
+		}
+		assert.NoError(t, w.WriteAccountStorage(ctx, addr, acc.Incarnation, &key, uint256.NewInt().SetUint64(blockNr-1), uint256.NewInt().SetUint64(blockNr)))
+		assert.NoError(t, w.WriteChangeSets())
+		assert.NoError(t, w.WriteHistory())
+		prev = acc
+	}
+
+	for blockNr := uint64(0); blockNr <= 2; blockNr++ {
+		// The root is not in the database, so that everything is read from the history
+		tds := NewTrieDbState(common.HexToHash("0x01"), db, blockNr)
+		tds.SetHistorical(true)
+		acc, err := tds.ReadAccountData(addr)
+		assert.NoError(t, err)
+		enc, err := tds.ReadAccountStorage(addr, FirstContractIncarnation, &key)
+		assert.NoError(t, err)
+		if blockNr == 0 {
+			assert.Nil(t, acc)
+			assert.Empty(t, enc)
+			continue
+		}
+		if assert.NotNil(t, acc) {
+			assert.Equal(t, blockNr, acc.Nonce)
+		}
+		assert.Equal(t, blockNr, uint256.NewInt().SetBytes(enc).Uint64(), "storage as of block %d", blockNr)
+	}
+}
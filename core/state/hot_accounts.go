@@ -0,0 +1,146 @@
+package state
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/core/rawdb"
+	"github.com/ledgerwatch/turbo-geth/core/types/accounts"
+	"github.com/ledgerwatch/turbo-geth/log"
+	"github.com/ledgerwatch/turbo-geth/metrics"
+	"github.com/ledgerwatch/turbo-geth/trie"
+)
+
+var (
+	hotAccountHitMeter  = metrics.NewRegisteredMeter("state/hotaccounts/hit", nil)
+	hotAccountMissMeter = metrics.NewRegisteredMeter("state/hotaccounts/miss", nil)
+)
+
+// ReadHotAccountsFile reads the addresses of the hot accounts (see TrieDbState.SetHotAccounts), one per line.
+// Empty lines and the lines starting with # are skipped
+func ReadHotAccountsFile(path string) ([]common.Address, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var addresses []common.Address
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		entry := strings.TrimSpace(scanner.Text())
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		if !common.IsHexAddress(entry) {
+			return nil, fmt.Errorf("%s:%d: invalid address %q", path, line, entry)
+		}
+		addresses = append(addresses, common.HexToAddress(entry))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return addresses, nil
+}
+
+// SetHotAccounts replaces the hot accounts. They are resolved in the account trie and their code is loaded
+// into the shared code cache. EvictTries does not evict them, and resolves them again if they have been lost
+// otherwise (i.e. by an unwind). The reads of the hot accounts are counted by the hit and miss meters
+func (tds *TrieDbState) SetHotAccounts(addresses []common.Address) error {
+	hot := make(map[common.Hash]struct{}, len(addresses))
+	addrHashes := make([][]byte, 0, len(addresses))
+	for _, address := range addresses {
+		addrHash, err := common.HashData(address[:])
+		if err != nil {
+			return err
+		}
+		if _, ok := hot[addrHash]; ok {
+			continue
+		}
+		hot[addrHash] = struct{}{}
+		addrHashes = append(addrHashes, common.CopyBytes(addrHash[:]))
+	}
+	tds.tMu.Lock()
+	tds.hotAccounts = hot
+	tds.tp.SetHotAccounts(addrHashes)
+	err := tds.resolveHotAccounts()
+	tds.tMu.Unlock()
+	if err != nil {
+		return err
+	}
+	return tds.preloadHotCode(hot)
+}
+
+// resolveHotAccounts loads the hot accounts missing in the in-memory trie, their storage tries stay unresolved.
+// It must be called with tMu held
+func (tds *TrieDbState) resolveHotAccounts() error {
+	if tds.flatHashing || len(tds.hotAccounts) == 0 {
+		return nil
+	}
+	rl := trie.NewRetainList(0)
+	for addrHash := range tds.hotAccounts {
+		rl.AddKey(common.CopyBytes(addrHash[:]))
+	}
+	dbPrefixes, fixedbits, hooks := tds.t.FindSubTriesToLoad(rl)
+	if len(dbPrefixes) == 0 {
+		return nil
+	}
+	rl.Rewind()
+	loader := trie.NewSubTrieLoader(tds.blockNr)
+	subTries, err := loader.LoadSubTries(tds.db, tds.blockNr, rl, dbPrefixes, fixedbits, false)
+	if err != nil {
+		return err
+	}
+	if err := tds.t.HookSubTries(subTries, hooks); err != nil {
+		for i, hash := range subTries.Hashes {
+			log.Error("Info for error", "dbPrefix", fmt.Sprintf("%x", dbPrefixes[i]), "fixedbits", fixedbits[i], "hash", hash)
+		}
+		return err
+	}
+	return nil
+}
+
+// preloadHotCode reads the code of the hot contracts into the shared code cache
+func (tds *TrieDbState) preloadHotCode(hot map[common.Hash]struct{}) error {
+	if SharedCodeCache() == nil {
+		return nil
+	}
+	for addrHash := range hot {
+		var acc accounts.Account
+		if ok, err := rawdb.ReadAccount(tds.db, addrHash, &acc); err != nil {
+			return err
+		} else if !ok || acc.IsEmptyCodeHash() {
+			continue
+		}
+		if _, err := readCode(tds.db, acc.CodeHash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getAccountMetered is GetAccount, which marks the hit or the miss of the trie for the hot accounts
+func (tds *TrieDbState) getAccountMetered(addrHash common.Hash) (*accounts.Account, bool) {
+	tds.tMu.Lock()
+	defer tds.tMu.Unlock()
+	acc, ok := tds.t.GetAccount(addrHash[:])
+	if _, hot := tds.hotAccounts[addrHash]; hot {
+		if ok {
+			hotAccountHitMeter.Mark(1)
+		} else {
+			hotAccountMissMeter.Mark(1)
+		}
+	}
+	return acc, ok
+}
+
+// hotAccountHashes returns the hashes of the hot accounts in the form accepted by trie.Eviction
+func hotAccountHashes(hot map[common.Hash]struct{}) [][]byte {
+	addrHashes := make([][]byte, 0, len(hot))
+	for addrHash := range hot {
+		addrHashes = append(addrHashes, common.CopyBytes(addrHash[:]))
+	}
+	return addrHashes
+}
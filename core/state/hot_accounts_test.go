@@ -0,0 +1,47 @@
+package state
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+)
+
+func TestReadHotAccountsFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hot-accounts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "hot.txt")
+	content := "# exchanges\n0x3f5CE5FBFe3E9af3971dD833D26bA9b5C936f0bE\n\n  0xdAC17F958D2ee523a2206206994597C13D831ec7  \n"
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	addresses, err := ReadHotAccountsFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []common.Address{
+		common.HexToAddress("0x3f5CE5FBFe3E9af3971dD833D26bA9b5C936f0bE"),
+		common.HexToAddress("0xdAC17F958D2ee523a2206206994597C13D831ec7"),
+	}
+	if len(addresses) != len(expected) {
+		t.Fatalf("expected %d addresses, got %d", len(expected), len(addresses))
+	}
+	for i := range expected {
+		if addresses[i] != expected[i] {
+			t.Errorf("address %d: expected %x, got %x", i, expected[i], addresses[i])
+		}
+	}
+
+	if err := ioutil.WriteFile(path, []byte("0x3f5CE5FBFe3E9af3971dD833D26bA9b5C936f0bE\nexchange\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadHotAccountsFile(path); err == nil {
+		t.Error("expected an error for the invalid address")
+	}
+}
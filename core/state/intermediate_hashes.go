@@ -38,16 +38,7 @@ func (ih *IntermediateHashes) WillUnloadBranchNode(prefixAsNibbles []byte, nodeH
 
 	InsertCounter.Inc(1)
 
-	buf := pool.GetBuffer(keyBufferSize)
-	defer pool.PutBuffer(buf)
-	trie.CompressNibbles(prefixAsNibbles, &buf.B)
-
-	var key []byte
-	if len(buf.B) >= common.HashLength {
-		key = dbutils.GenerateCompositeStoragePrefix(buf.B[:common.HashLength], incarnation, buf.B[common.HashLength:])
-	} else {
-		key = common.CopyBytes(buf.B)
-	}
+	key := intermediateHashKey(prefixAsNibbles, incarnation)
 
 	lenBytes := make([]byte, 8)
 	binary.BigEndian.PutUint64(lenBytes, witnessLen)
@@ -55,10 +46,13 @@ func (ih *IntermediateHashes) WillUnloadBranchNode(prefixAsNibbles []byte, nodeH
 	if err := ih.putter.Put(dbutils.IntermediateTrieHashBucket, key, common.CopyBytes(nodeHash[:])); err != nil {
 		log.Warn("could not put intermediate trie hash", "err", err)
 	}
+	// The witness length of the previous hash is not left behind when the tracking is disabled
 	if debug.IsTrackWitnessSizeEnabled() {
 		if err := ih.putter.Put(dbutils.IntermediateTrieWitnessLenBucket, key, lenBytes); err != nil {
 			log.Warn("could not put intermediate trie data len", "err", err)
 		}
+	} else if err := ih.deleter.Delete(dbutils.IntermediateTrieWitnessLenBucket, key); err != nil {
+		log.Warn("could not delete intermediate trie data len", "err", err)
 	}
 }
 
@@ -69,24 +63,25 @@ func (ih *IntermediateHashes) BranchNodeLoaded(prefixAsNibbles []byte, incarnati
 	}
 	DeleteCounter.Inc(1)
 
-	buf := pool.GetBuffer(keyBufferSize)
-	defer pool.PutBuffer(buf)
-	trie.CompressNibbles(prefixAsNibbles, &buf.B)
-
-	var key []byte
-	if len(buf.B) >= common.HashLength {
-		key = dbutils.GenerateCompositeStoragePrefix(buf.B[:common.HashLength], incarnation, buf.B[common.HashLength:])
-	} else {
-		key = common.CopyBytes(buf.B)
-	}
+	key := intermediateHashKey(prefixAsNibbles, incarnation)
 
 	if err := ih.deleter.Delete(dbutils.IntermediateTrieHashBucket, key); err != nil {
 		log.Warn("could not delete intermediate trie hash", "err", err)
 	}
-	if debug.IsTrackWitnessSizeEnabled() {
-		if err := ih.deleter.Delete(dbutils.IntermediateTrieWitnessLenBucket, key); err != nil {
-			log.Warn("could not delete intermediate trie hash", "err", err)
-		}
+	if err := ih.deleter.Delete(dbutils.IntermediateTrieWitnessLenBucket, key); err != nil {
+		log.Warn("could not delete intermediate trie data len", "err", err)
 	}
 
 }
+
+// intermediateHashKey returns the key of dbutils.IntermediateTrieHashBucket of the branch node at the prefix
+func intermediateHashKey(prefixAsNibbles []byte, incarnation uint64) []byte {
+	buf := pool.GetBuffer(keyBufferSize)
+	defer pool.PutBuffer(buf)
+	trie.CompressNibbles(prefixAsNibbles, &buf.B)
+
+	if len(buf.B) >= common.HashLength {
+		return dbutils.GenerateCompositeStoragePrefix(buf.B[:common.HashLength], incarnation, buf.B[common.HashLength:])
+	}
+	return common.CopyBytes(buf.B)
+}
@@ -0,0 +1,72 @@
+package state
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+	"github.com/ledgerwatch/turbo-geth/common/hexutil"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+)
+
+// IntermediateHashEntry is an entry of dbutils.IntermediateTrieHashBucket together with the witness length
+// of the sub-trie from dbutils.IntermediateTrieWitnessLenBucket
+type IntermediateHashEntry struct {
+	Prefix     hexutil.Bytes   `json:"prefix"`     // Compressed nibbles, see IntermediateHashes
+	Hash       common.Hash     `json:"hash"`       // Root hash of the sub-trie under the prefix
+	WitnessLen *hexutil.Uint64 `json:"witnessLen"` // nil if the witness lengths are not tracked (--trie-witness-len)
+}
+
+// IntermediateHashesRange is a page of the entries of dbutils.IntermediateTrieHashBucket
+type IntermediateHashesRange struct {
+	Entries []IntermediateHashEntry `json:"entries"`
+	Next    hexutil.Bytes           `json:"next"` // Key to continue from, nil if there are no more entries under the prefix
+}
+
+// ReadIntermediateHashesRange returns up to maxResult entries of dbutils.IntermediateTrieHashBucket,
+// whose keys start with the prefix, beginning from the key start (inclusive). It lets the tools planning
+// the witness slices walk the intermediate hashes without the direct access to the database
+func ReadIntermediateHashesRange(ctx context.Context, db ethdb.KV, prefix, start []byte, maxResult int) (IntermediateHashesRange, error) {
+	if maxResult <= 0 {
+		return IntermediateHashesRange{}, fmt.Errorf("maxResult must be positive, got %d", maxResult)
+	}
+	var result IntermediateHashesRange
+	if err := db.View(ctx, func(tx ethdb.Tx) error {
+		lens := tx.Bucket(dbutils.IntermediateTrieWitnessLenBucket)
+		c := tx.Bucket(dbutils.IntermediateTrieHashBucket).Cursor().Prefix(prefix)
+
+		var k, v []byte
+		var err error
+		if bytes.Compare(start, prefix) > 0 {
+			k, v, err = c.Seek(start)
+		} else {
+			k, v, err = c.First()
+		}
+		for ; k != nil || err != nil; k, v, err = c.Next() {
+			if err != nil {
+				return err
+			}
+			if len(result.Entries) == maxResult {
+				result.Next = common.CopyBytes(k)
+				return nil
+			}
+			entry := IntermediateHashEntry{Prefix: common.CopyBytes(k), Hash: common.BytesToHash(v)}
+			witnessLen, err := lens.Get(k)
+			if err != nil {
+				return err
+			}
+			if len(witnessLen) == 8 {
+				l := hexutil.Uint64(binary.BigEndian.Uint64(witnessLen))
+				entry.WitnessLen = &l
+			}
+			result.Entries = append(result.Entries, entry)
+		}
+		return nil
+	}); err != nil {
+		return IntermediateHashesRange{}, err
+	}
+	return result, nil
+}
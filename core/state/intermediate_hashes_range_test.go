@@ -0,0 +1,60 @@
+package state
+
+import (
+	"context"
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+)
+
+func TestReadIntermediateHashesRange(t *testing.T) {
+	db := ethdb.NewMemDatabase()
+	defer db.Close()
+	ctx := context.Background()
+
+	keys := [][]byte{{0x01}, {0x01, 0x02}, {0x01, 0x03}, {0x01, 0x03, 0x04}, {0x02}}
+	for i, k := range keys {
+		require.NoError(t, db.Put(dbutils.IntermediateTrieHashBucket, k, common.BytesToHash([]byte{byte(i + 1)}).Bytes()))
+	}
+	var witnessLen [8]byte
+	binary.BigEndian.PutUint64(witnessLen[:], 100)
+	require.NoError(t, db.Put(dbutils.IntermediateTrieWitnessLenBucket, keys[1], witnessLen[:]))
+
+	res, err := ReadIntermediateHashesRange(ctx, db.AbstractKV(), []byte{0x01}, nil, 2)
+	require.NoError(t, err)
+	require.Len(t, res.Entries, 2)
+	assert.Equal(t, keys[0], []byte(res.Entries[0].Prefix))
+	assert.Equal(t, common.BytesToHash([]byte{1}), res.Entries[0].Hash)
+	assert.Nil(t, res.Entries[0].WitnessLen)
+	assert.Equal(t, keys[1], []byte(res.Entries[1].Prefix))
+	require.NotNil(t, res.Entries[1].WitnessLen)
+	assert.Equal(t, uint64(100), uint64(*res.Entries[1].WitnessLen))
+	assert.Equal(t, keys[2], []byte(res.Next))
+
+	// The next page ends at the end of the prefix, the entries outside of it are not returned
+	res, err = ReadIntermediateHashesRange(ctx, db.AbstractKV(), []byte{0x01}, res.Next, 2)
+	require.NoError(t, err)
+	require.Len(t, res.Entries, 2)
+	assert.Equal(t, keys[2], []byte(res.Entries[0].Prefix))
+	assert.Equal(t, keys[3], []byte(res.Entries[1].Prefix))
+	assert.Nil(t, res.Next)
+
+	// The start before the prefix is the same as no start
+	res, err = ReadIntermediateHashesRange(ctx, db.AbstractKV(), []byte{0x01, 0x03}, []byte{0x01}, 10)
+	require.NoError(t, err)
+	require.Len(t, res.Entries, 2)
+	assert.Equal(t, keys[2], []byte(res.Entries[0].Prefix))
+
+	res, err = ReadIntermediateHashesRange(ctx, db.AbstractKV(), nil, nil, 10)
+	require.NoError(t, err)
+	assert.Len(t, res.Entries, len(keys))
+
+	_, err = ReadIntermediateHashesRange(ctx, db.AbstractKV(), nil, nil, 0)
+	assert.Error(t, err)
+}
@@ -99,6 +99,19 @@ type IntraBlockState struct {
 	nextRevisionID int
 	tracer         StateTracer
 	trace          bool
+
+	// Storage promises mode, see SetStoragePromises
+	storagePromises bool
+	promises        []StoragePromise
+	promised        map[StoragePromise]struct{}
+	// The tracer and the read recording of the state reader, suspended in the storage promises mode
+	suspendedTracer   StateTracer
+	suspendedTrace    bool
+	suspendedRecorder ReadRecorder
+	unrecorded        *unrecordedReads
+
+	// Accounts and storage items touched in the block, see AccessAccount
+	accessed map[common.Address]map[common.Hash]struct{}
 }
 
 // Create a new state from a given trie
@@ -160,6 +173,9 @@ func (sdb *IntraBlockState) Reset() error {
 	sdb.logs = make(map[common.Hash][]*types.Log)
 	sdb.logSize = 0
 	sdb.preimages = make(map[common.Hash][]byte)
+	sdb.accessed = nil
+	sdb.promises = nil
+	sdb.promised = nil
 	sdb.clearJournalAndRefund()
 	return nil
 }
@@ -346,6 +362,7 @@ func (sdb *IntraBlockState) GetCodeSize(addr common.Address) int {
 		return 0
 	}
 	if stateObject.code != nil {
+		sdb.recordCodeSizeRead(stateObject)
 		return len(stateObject.code)
 	}
 	len, err := sdb.stateReader.ReadAccountCodeSize(addr, common.BytesToHash(stateObject.CodeHash()))
@@ -602,6 +619,7 @@ var nullValue = common.Big0
 func (sdb *IntraBlockState) getStateObject(addr common.Address) (stateObject *stateObject) {
 	// Prefer 'live' objects.
 	if obj := sdb.stateObjects[addr]; obj != nil {
+		sdb.recordAccountRead(addr)
 		if obj.deleted {
 			return nil
 		}
@@ -610,6 +628,7 @@ func (sdb *IntraBlockState) getStateObject(addr common.Address) (stateObject *st
 
 	// Load the object from the database.
 	if _, ok := sdb.nilAccounts[addr]; ok {
+		sdb.recordAccountRead(addr)
 		return nil
 	}
 	account, err := sdb.stateReader.ReadAccountData(addr)
@@ -617,6 +636,9 @@ func (sdb *IntraBlockState) getStateObject(addr common.Address) (stateObject *st
 		sdb.setErrorUnsafe(err)
 		return nil
 	}
+	if sdb.suspendedRecorder != nil {
+		sdb.unrecorded.accounts[addr] = struct{}{}
+	}
 	if account == nil {
 		sdb.nilAccounts[addr] = struct{}{}
 		return nil
@@ -149,6 +149,15 @@ type (
 	touchChange struct {
 		account *common.Address
 	}
+
+	// Changes to the set of the touched accounts and storage items.
+	accessAccountChange struct {
+		account *common.Address
+	}
+	accessSlotChange struct {
+		account *common.Address
+		key     common.Hash
+	}
 )
 
 func (ch createObjectChange) revert(s *IntraBlockState) {
@@ -250,3 +259,19 @@ func (ch addPreimageChange) revert(s *IntraBlockState) {
 func (ch addPreimageChange) dirtied() *common.Address {
 	return nil
 }
+
+func (ch accessAccountChange) revert(s *IntraBlockState) {
+	delete(s.accessed, *ch.account)
+}
+
+func (ch accessAccountChange) dirtied() *common.Address {
+	return nil
+}
+
+func (ch accessSlotChange) revert(s *IntraBlockState) {
+	delete(s.accessed[*ch.account], ch.key)
+}
+
+func (ch accessSlotChange) dirtied() *common.Address {
+	return nil
+}
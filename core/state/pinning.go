@@ -0,0 +1,84 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/log"
+	"github.com/ledgerwatch/turbo-geth/trie"
+)
+
+var errFlatHashingPinning = errors.New("storage tries can not be pinned in the flat hashing mode")
+
+// PinStorage resolves the storage trie of the account fully and keeps it in memory,
+// EvictTries does not evict it (and the path to the account) until UnpinStorage is called.
+// It is meant for heavily used contracts, which storage would otherwise be re-resolved every few blocks
+func (tds *TrieDbState) PinStorage(address common.Address) error {
+	if tds.flatHashing {
+		return errFlatHashingPinning
+	}
+	addrHash, err := common.HashData(address[:])
+	if err != nil {
+		return err
+	}
+	tds.tMu.Lock()
+	defer tds.tMu.Unlock()
+	// The storage which failed to resolve is not pinned, otherwise it would never be evicted
+	if err := tds.resolveStorageTrie(addrHash); err != nil {
+		return err
+	}
+	tds.tp.Pin(addrHash[:])
+	return nil
+}
+
+// UnpinStorage makes the storage trie of the account subject to EvictTries again
+func (tds *TrieDbState) UnpinStorage(address common.Address) error {
+	addrHash, err := common.HashData(address[:])
+	if err != nil {
+		return err
+	}
+	tds.tMu.Lock()
+	defer tds.tMu.Unlock()
+	tds.tp.Unpin(addrHash[:])
+	return nil
+}
+
+// PinnedStorage returns the hashes of the accounts which storage tries are pinned
+func (tds *TrieDbState) PinnedStorage() []common.Hash {
+	tds.tMu.Lock()
+	defer tds.tMu.Unlock()
+	pinned := tds.tp.Pinned()
+	addrHashes := make([]common.Hash, len(pinned))
+	for i, addrHash := range pinned {
+		addrHashes[i] = common.BytesToHash(addrHash)
+	}
+	return addrHashes
+}
+
+// resolveStorageTrie loads all the parts of the storage trie of the account missing in the in-memory trie
+func (tds *TrieDbState) resolveStorageTrie(addrHash common.Hash) error {
+	var nibbles = make([]byte, 2*len(addrHash))
+	for i, b := range addrHash[:] {
+		nibbles[i*2] = b / 16
+		nibbles[i*2+1] = b % 16
+	}
+	// Everything under the account is retained, as well as the path to it
+	rr := trie.NewRetainRange(nibbles, nibbles)
+	dbPrefixes, fixedbits, hooks := tds.t.FindSubTriesToLoad(rr)
+	if len(dbPrefixes) == 0 {
+		return nil
+	}
+	loader := trie.NewSubTrieLoader(tds.blockNr)
+	subTries, err := loader.LoadSubTries(tds.db, tds.blockNr, rr, dbPrefixes, fixedbits, false)
+	if err != nil {
+		return err
+	}
+	if err := tds.t.HookSubTries(subTries, hooks); err != nil {
+		for i, hash := range subTries.Hashes {
+			log.Error("Info for error", "dbPrefix", fmt.Sprintf("%x", dbPrefixes[i]), "fixedbits", fixedbits[i], "hash", hash)
+		}
+		return err
+	}
+	return nil
+}
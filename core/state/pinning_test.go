@@ -0,0 +1,35 @@
+package state
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+	"github.com/ledgerwatch/turbo-geth/trie"
+)
+
+func TestPinStorage(t *testing.T) {
+	db := ethdb.NewMemDatabase()
+	defer db.Close()
+	address := common.HexToAddress("0x01")
+	addrHash, err := common.HashData(address[:])
+	require.NoError(t, err)
+
+	tds := NewTrieDbState(trie.EmptyRoot, db, 0)
+	require.NoError(t, tds.PinStorage(address))
+	assert.Equal(t, []common.Hash{addrHash}, tds.PinnedStorage())
+	require.NoError(t, tds.UnpinStorage(address))
+	assert.Empty(t, tds.PinnedStorage())
+}
+
+func TestPinStorageResolveFailure(t *testing.T) {
+	db := ethdb.NewMemDatabase()
+	defer db.Close()
+	// The root is not in the database, so the storage can't be resolved
+	tds := NewTrieDbState(common.HexToHash("0x01"), db, 0)
+	assert.Error(t, tds.PinStorage(common.HexToAddress("0x01")))
+	assert.Empty(t, tds.PinnedStorage(), "the storage which failed to resolve must stay evictable")
+}
@@ -3,10 +3,13 @@ package state
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"github.com/VictoriaMetrics/fastcache"
+	"github.com/holiman/uint256"
 
 	"github.com/ledgerwatch/turbo-geth/common"
 	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+	"github.com/ledgerwatch/turbo-geth/core/rawdb"
 	"github.com/ledgerwatch/turbo-geth/core/types/accounts"
 	"github.com/ledgerwatch/turbo-geth/ethdb"
 )
@@ -30,6 +33,20 @@ func NewPlainStateReader(db ethdb.Getter) *PlainStateReader {
 	}
 }
 
+// NewCurrentStateReader returns the reader of the current state in the layout recorded in the database,
+// see rawdb.ReadStateSchemaVersion. With the plain layout the accounts are looked up by the addresses directly,
+// without hashing them
+func NewCurrentStateReader(db ethdb.Getter) (StateReader, error) {
+	version, err := rawdb.ReadStateSchemaVersion(db)
+	if err != nil {
+		return nil, err
+	}
+	if version == rawdb.PlainStateSchema || version == rawdb.UnhashedStateSchema {
+		return NewPlainStateReader(db), nil
+	}
+	return NewDbStateReader(db), nil
+}
+
 func (r *PlainStateReader) SetAccountCache(accountCache *fastcache.Cache) {
 	r.accountCache = accountCache
 }
@@ -46,7 +63,7 @@ func (r *PlainStateReader) SetCodeSizeCache(codeSizeCache *fastcache.Cache) {
 	r.codeSizeCache = codeSizeCache
 }
 
-func (r *PlainStateReader) ReadAccountData(address common.Address) (*accounts.Account, error) {
+func (r *PlainStateReader) readAccountEnc(address common.Address) ([]byte, error) {
 	var enc []byte
 	var ok bool
 	if r.accountCache != nil {
@@ -62,16 +79,37 @@ func (r *PlainStateReader) ReadAccountData(address common.Address) (*accounts.Ac
 	if !ok && r.accountCache != nil {
 		r.accountCache.Set(address[:], enc)
 	}
+	return enc, nil
+}
+
+func (r *PlainStateReader) ReadAccountData(address common.Address) (*accounts.Account, error) {
+	enc, err := r.readAccountEnc(address)
+	if err != nil {
+		return nil, err
+	}
 	if enc == nil {
+		traceAccount("PlainStateReader", address, nil)
 		return nil, nil
 	}
 	acc := &accounts.Account{}
 	if err := acc.DecodeForStorage(enc); err != nil {
 		return nil, err
 	}
+	traceAccount("PlainStateReader", address, acc)
 	return acc, nil
 }
 
+// ReadAccountBalanceNonce implements BalanceNonceReader
+func (r *PlainStateReader) ReadAccountBalanceNonce(address common.Address, balance *uint256.Int) (uint64, bool, error) {
+	enc, err := r.readAccountEnc(address)
+	if err != nil || enc == nil {
+		balance.Clear()
+		return 0, false, err
+	}
+	nonce, err := accounts.DecodeBalanceNonceForStorage(enc, balance)
+	return nonce, err == nil, err
+}
+
 func (r *PlainStateReader) ReadAccountStorage(address common.Address, incarnation uint64, key *common.Hash) ([]byte, error) {
 	compositeKey := dbutils.PlainGenerateCompositeStorageKey(address, incarnation, *key)
 	if r.storageCache != nil {
@@ -95,7 +133,7 @@ func (r *PlainStateReader) ReadAccountCode(address common.Address, codeHash comm
 			return code, nil
 		}
 	}
-	code, err := r.db.Get(dbutils.CodeBucket, codeHash[:])
+	code, err := readCode(r.db, codeHash)
 	if r.codeCache != nil && len(code) <= 1024 {
 		r.codeCache.Set(address[:], code)
 	}
@@ -116,7 +154,7 @@ func (r *PlainStateReader) ReadAccountCodeSize(address common.Address, codeHash
 			return int(binary.BigEndian.Uint32(b)), nil
 		}
 	}
-	code, err := r.db.Get(dbutils.CodeBucket, codeHash[:])
+	code, err := readCode(r.db, codeHash)
 	if err != nil {
 		return 0, err
 	}
@@ -139,5 +177,5 @@ func (r *PlainStateReader) ReadAccountIncarnation(address common.Address) (uint6
 }
 
 func entryNotFound(err error) bool {
-	return err == ethdb.ErrKeyNotFound
+	return errors.Is(err, ethdb.ErrKeyNotFound)
 }
@@ -0,0 +1,305 @@
+package state
+
+import (
+	"bufio"
+	"bytes"
+	"container/heap"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+	"github.com/ledgerwatch/turbo-geth/log"
+	"github.com/ledgerwatch/turbo-geth/trie"
+)
+
+// plainStateRootBufferSize is the size of the hashed entries sorted in memory by PlainStateRoot,
+// the larger states are sorted in the files
+var plainStateRootBufferSize = 256 * 1024 * 1024
+
+// PlainStateRoot computes the state root from the plain state (dbutils.PlainStateBucket), hashing the addresses
+// and the storage keys on the fly. It is used by the databases of rawdb.UnhashedStateSchema, which keep no hashed
+// state to load the trie from. The hashed entries are sorted in memory up to plainStateRootBufferSize, the rest
+// are sorted in the files of tmpdir (the default temporary directory if empty) and streamed into the trie loader.
+// The pending writes of the batches are included (see ethdb.MergedWalker)
+func PlainStateRoot(db ethdb.Getter, tmpdir string) (common.Hash, error) {
+	walk := db.Walk
+	if casted, ok := db.(ethdb.MergedWalker); ok {
+		walk = casted.WalkMerged
+	}
+	var buffer hashedEntries
+	var files []string
+	defer func() {
+		for _, file := range files {
+			if err := os.Remove(file); err != nil {
+				log.Warn("Could not remove the file of the hashed state", "file", file, "err", err)
+			}
+		}
+	}()
+	if err := walk(dbutils.PlainStateBucket, nil, 0, func(k, v []byte) (bool, error) {
+		hashedKey, err := hashedStateKey(k)
+		if err != nil {
+			return false, err
+		}
+		buffer.put(hashedKey, common.CopyBytes(v))
+		if buffer.size >= plainStateRootBufferSize {
+			file, err := buffer.flush(tmpdir)
+			if err != nil {
+				return false, err
+			}
+			files = append(files, file)
+		}
+		return true, nil
+	}); err != nil {
+		return common.Hash{}, err
+	}
+
+	var c trie.LoaderCursor
+	if len(files) == 0 {
+		sort.Sort(&buffer)
+		c = &hashedEntriesCursor{entries: buffer.entries}
+	} else {
+		if len(buffer.entries) > 0 {
+			file, err := buffer.flush(tmpdir)
+			if err != nil {
+				return common.Hash{}, err
+			}
+			files = append(files, file)
+		}
+		fc, err := newHashedFilesCursor(files)
+		if err != nil {
+			return common.Hash{}, err
+		}
+		defer fc.close()
+		c = fc
+	}
+	loader := trie.NewFlatDbSubTrieLoader()
+	if err := loader.Reset(db, trie.NewRetainList(0), [][]byte{nil}, []int{0}, false); err != nil {
+		return common.Hash{}, err
+	}
+	subTries, err := loader.LoadSubTriesFromCursor(c)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	if fc, ok := c.(*hashedFilesCursor); ok && fc.err != nil {
+		return common.Hash{}, fc.err
+	}
+	return subTries.Hashes[0], nil
+}
+
+// hashedStateKey converts the key of dbutils.PlainStateBucket into the key of dbutils.CurrentStateBucket
+func hashedStateKey(k []byte) ([]byte, error) {
+	switch len(k) {
+	case common.AddressLength:
+		addrHash, err := common.HashData(k)
+		if err != nil {
+			return nil, err
+		}
+		return addrHash[:], nil
+	case common.AddressLength + common.IncarnationLength + common.HashLength:
+		addrHash, err := common.HashData(k[:common.AddressLength])
+		if err != nil {
+			return nil, err
+		}
+		keyHash, err := common.HashData(k[common.AddressLength+common.IncarnationLength:])
+		if err != nil {
+			return nil, err
+		}
+		hashedKey := make([]byte, common.HashLength+common.IncarnationLength+common.HashLength)
+		copy(hashedKey, addrHash[:])
+		copy(hashedKey[common.HashLength:], k[common.AddressLength:common.AddressLength+common.IncarnationLength])
+		copy(hashedKey[common.HashLength+common.IncarnationLength:], keyHash[:])
+		return hashedKey, nil
+	}
+	return nil, fmt.Errorf("unexpected key in the plain state: %x", k)
+}
+
+type hashedEntry struct {
+	k, v []byte
+}
+
+// hashedEntries is the buffer of the entries of the hashed state, sorted by the keys before they are read
+type hashedEntries struct {
+	entries []hashedEntry
+	size    int
+}
+
+func (b *hashedEntries) Len() int           { return len(b.entries) }
+func (b *hashedEntries) Less(i, j int) bool { return bytes.Compare(b.entries[i].k, b.entries[j].k) < 0 }
+func (b *hashedEntries) Swap(i, j int)      { b.entries[i], b.entries[j] = b.entries[j], b.entries[i] }
+
+func (b *hashedEntries) put(k, v []byte) {
+	b.entries = append(b.entries, hashedEntry{k, v})
+	b.size += len(k) + len(v)
+}
+
+// flush sorts the entries and writes them into the new file of tmpdir, the buffer is emptied
+func (b *hashedEntries) flush(tmpdir string) (string, error) {
+	sort.Sort(b)
+	f, err := ioutil.TempFile(tmpdir, "tg-plain-state-root-")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close() //nolint:errcheck
+	w := bufio.NewWriter(f)
+	var lenBuf [binary.MaxVarintLen64]byte
+	for _, e := range b.entries {
+		for _, field := range [][]byte{e.k, e.v} {
+			n := binary.PutUvarint(lenBuf[:], uint64(len(field)))
+			if _, err = w.Write(lenBuf[:n]); err != nil {
+				return f.Name(), err
+			}
+			if _, err = w.Write(field); err != nil {
+				return f.Name(), err
+			}
+		}
+	}
+	if err = w.Flush(); err != nil {
+		return f.Name(), err
+	}
+	b.entries = b.entries[:0]
+	b.size = 0
+	return f.Name(), nil
+}
+
+// hashedEntriesCursor reads the sorted entries kept in memory
+type hashedEntriesCursor struct {
+	entries []hashedEntry
+	i       int
+}
+
+func (c *hashedEntriesCursor) SeekTo(seek []byte) ([]byte, []byte) {
+	for c.i < len(c.entries) && bytes.Compare(c.entries[c.i].k, seek) < 0 {
+		c.i++
+	}
+	return c.current()
+}
+
+func (c *hashedEntriesCursor) Next() ([]byte, []byte) {
+	if c.i < len(c.entries) {
+		c.i++
+	}
+	return c.current()
+}
+
+func (c *hashedEntriesCursor) current() ([]byte, []byte) {
+	if c.i >= len(c.entries) {
+		return nil, nil
+	}
+	return c.entries[c.i].k, c.entries[c.i].v
+}
+
+// hashedFilesCursor merges the sorted files written by hashedEntries.flush, remembering the first error
+type hashedFilesCursor struct {
+	files   []*os.File
+	readers []*bufio.Reader
+	heap    hashedFilesHeap
+	k, v    []byte
+	err     error
+}
+
+type hashedFilesHeapItem struct {
+	hashedEntry
+	reader int
+}
+
+type hashedFilesHeap []hashedFilesHeapItem
+
+func (h hashedFilesHeap) Len() int            { return len(h) }
+func (h hashedFilesHeap) Less(i, j int) bool  { return bytes.Compare(h[i].k, h[j].k) < 0 }
+func (h hashedFilesHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *hashedFilesHeap) Push(x interface{}) { *h = append(*h, x.(hashedFilesHeapItem)) }
+func (h *hashedFilesHeap) Pop() interface{} {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
+}
+
+func newHashedFilesCursor(names []string) (*hashedFilesCursor, error) {
+	c := &hashedFilesCursor{}
+	for _, name := range names {
+		f, err := os.Open(name)
+		if err != nil {
+			c.close()
+			return nil, err
+		}
+		c.files = append(c.files, f)
+		c.readers = append(c.readers, bufio.NewReader(f))
+	}
+	for i := range c.readers {
+		if err := c.push(i); err != nil {
+			c.close()
+			return nil, err
+		}
+	}
+	c.pop()
+	return c, nil
+}
+
+// push reads the next entry of the file into the heap
+func (c *hashedFilesCursor) push(i int) error {
+	k, err := readHashedField(c.readers[i])
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	v, err := readHashedField(c.readers[i])
+	if err != nil {
+		return fmt.Errorf("reading the value of %x: %w", k, err)
+	}
+	heap.Push(&c.heap, hashedFilesHeapItem{hashedEntry{k, v}, i})
+	return nil
+}
+
+// pop makes the least entry of the heap current and replaces it with the next entry of its file
+func (c *hashedFilesCursor) pop() {
+	if c.heap.Len() == 0 {
+		c.k, c.v = nil, nil
+		return
+	}
+	item := heap.Pop(&c.heap).(hashedFilesHeapItem)
+	c.k, c.v = item.k, item.v
+	if err := c.push(item.reader); err != nil && c.err == nil {
+		c.err = err
+	}
+}
+
+func (c *hashedFilesCursor) SeekTo(seek []byte) ([]byte, []byte) {
+	for c.k != nil && bytes.Compare(c.k, seek) < 0 {
+		c.pop()
+	}
+	return c.k, c.v
+}
+
+func (c *hashedFilesCursor) Next() ([]byte, []byte) {
+	if c.k != nil {
+		c.pop()
+	}
+	return c.k, c.v
+}
+
+func (c *hashedFilesCursor) close() {
+	for _, f := range c.files {
+		f.Close() //nolint:errcheck
+	}
+}
+
+func readHashedField(r *bufio.Reader) ([]byte, error) {
+	l, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	field := make([]byte, l)
+	if _, err = io.ReadFull(r, field); err != nil {
+		return nil, err
+	}
+	return field, nil
+}
@@ -0,0 +1,56 @@
+package state
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+	"github.com/ledgerwatch/turbo-geth/core/rawdb"
+	"github.com/ledgerwatch/turbo-geth/core/types/accounts"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlainStateRootSpilled(t *testing.T) {
+	db := ethdb.NewMemDatabase()
+	defer db.Close()
+	for i := 0; i < 50; i++ {
+		address := common.BytesToAddress([]byte(fmt.Sprintf("account %d", i)))
+		acc := accounts.NewAccount()
+		acc.Balance.SetUint64(uint64(i + 1))
+		if i%2 == 0 {
+			acc.Incarnation = FirstContractIncarnation
+			for j := 0; j < 5; j++ {
+				key := common.BytesToHash([]byte(fmt.Sprintf("key %d", j)))
+				require.NoError(t, db.Put(dbutils.PlainStateBucket, dbutils.PlainGenerateCompositeStorageKey(address, acc.Incarnation, key), []byte{byte(j + 1)}))
+			}
+		}
+		require.NoError(t, rawdb.PlainWriteAccount(db, address, acc))
+	}
+	root, err := PlainStateRoot(db, "")
+	require.NoError(t, err)
+
+	// The storage of the previous incarnations and of the deleted contracts is not a part of the state
+	key := common.BytesToHash([]byte("key 0"))
+	require.NoError(t, db.Put(dbutils.PlainStateBucket, dbutils.PlainGenerateCompositeStorageKey(common.BytesToAddress([]byte("account 0")), FirstContractIncarnation+1, key), []byte{0x2a}))
+	require.NoError(t, db.Put(dbutils.PlainStateBucket, dbutils.PlainGenerateCompositeStorageKey(common.BytesToAddress([]byte("deleted")), FirstContractIncarnation, key), []byte{0x2a}))
+	withStale, err := PlainStateRoot(db, "")
+	require.NoError(t, err)
+	assert.Equal(t, root, withStale)
+
+	tmpdir, err := ioutil.TempDir("", "plain-state-root")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpdir)
+	defer func(size int) { plainStateRootBufferSize = size }(plainStateRootBufferSize)
+	plainStateRootBufferSize = 500
+	spilled, err := PlainStateRoot(db, tmpdir)
+	require.NoError(t, err)
+	assert.Equal(t, root, spilled)
+	files, err := ioutil.ReadDir(tmpdir)
+	require.NoError(t, err)
+	assert.Empty(t, files)
+}
@@ -105,6 +105,9 @@ func (w *PlainStateWriter) DeleteAccount(ctx context.Context, address common.Add
 		return err
 	}
 	if original.Incarnation > 0 {
+		if err := w.deleteAccountStorage(address, original.Incarnation); err != nil {
+			return err
+		}
 		var b [8]byte
 		binary.BigEndian.PutUint64(b[:], original.Incarnation)
 		if err := w.stateDb.Put(dbutils.IncarnationMapBucket, address[:], b[:]); err != nil {
@@ -114,6 +117,19 @@ func (w *PlainStateWriter) DeleteAccount(ctx context.Context, address common.Add
 	return nil
 }
 
+// deleteAccountStorage removes the storage of the self-destructed contract, recording the removed items
+// in the changeset, as DbStateWriter does
+func (w *PlainStateWriter) deleteAccountStorage(address common.Address, incarnation uint64) error {
+	prefix := dbutils.PlainGenerateStoragePrefix(address, incarnation)
+	return ethdb.DeleteRange(w.stateDb, dbutils.PlainStateBucket, prefix, 8*len(prefix), func(k, v []byte) error {
+		w.csw.DeleteStorage(address, k, v)
+		if w.storageCache != nil {
+			w.storageCache.Set(k, nil)
+		}
+		return nil
+	})
+}
+
 func (w *PlainStateWriter) WriteAccountStorage(ctx context.Context, address common.Address, incarnation uint64, key *common.Hash, original, value *uint256.Int) error {
 	if err := w.csw.WriteAccountStorage(ctx, address, incarnation, key, original, value); err != nil {
 		return err
@@ -171,5 +187,10 @@ func (w *PlainStateWriter) WriteChangeSets() error {
 			return err
 		}
 	}
-	return nil
+	return ethdb.WriteChangeSetSummary(w.changeDb, w.blockNumber, ethdb.ChangeSetSummary{
+		AccountChanges: uint32(accountChanges.Len()),
+		StorageChanges: uint32(storageChanges.Len()),
+		AccountBytes:   uint32(len(accountSerialised)),
+		StorageBytes:   uint32(len(storageSerialized)),
+	})
 }
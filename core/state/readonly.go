@@ -19,7 +19,7 @@ package state
 import (
 	"bytes"
 	"context"
-	"encoding/binary"
+	"errors"
 	"fmt"
 	"math/big"
 
@@ -86,7 +86,7 @@ func (dbs *DbState) ForEachStorage(addr common.Address, start []byte, cb func(ke
 		log.Error("Error decoding account", "error", err)
 		return err
 	}
-	binary.BigEndian.PutUint64(s[common.HashLength:], ^acc.Incarnation)
+	dbutils.EncodeIncarnation(s[common.HashLength:], acc.Incarnation)
 	copy(s[common.HashLength+common.IncarnationLength:], start)
 	var lastSecKey common.Hash
 	overrideCounter := 0
@@ -190,13 +190,18 @@ func (dbs *DbState) ReadAccountData(address common.Address) (*accounts.Account,
 		return nil, err
 	}
 	enc, err := dbs.db.GetAsOf(dbutils.CurrentStateBucket, dbutils.AccountsHistoryBucket, addrHash[:], dbs.blockNr+1)
+	if errors.Is(err, ethdb.ErrHistoryPruned) {
+		return nil, err
+	}
 	if err != nil || enc == nil || len(enc) == 0 {
+		traceAccountByHash("DbState", addrHash, nil)
 		return nil, nil
 	}
 	var acc accounts.Account
 	if err := acc.DecodeForStorage(enc); err != nil {
 		return nil, err
 	}
+	traceAccountByHash("DbState", addrHash, &acc)
 	return &acc, nil
 }
 
@@ -213,6 +218,9 @@ func (dbs *DbState) ReadAccountStorage(address common.Address, incarnation uint6
 
 	compositeKey := dbutils.GenerateCompositeStorageKey(addrHash, incarnation, keyHash)
 	enc, err := dbs.db.GetAsOf(dbutils.CurrentStateBucket, dbutils.StorageHistoryBucket, compositeKey, dbs.blockNr+1)
+	if errors.Is(err, ethdb.ErrHistoryPruned) {
+		return nil, err
+	}
 	if err != nil || enc == nil {
 		return nil, nil
 	}
@@ -223,7 +231,7 @@ func (dbs *DbState) ReadAccountCode(address common.Address, codeHash common.Hash
 	if bytes.Equal(codeHash[:], emptyCodeHash) {
 		return nil, nil
 	}
-	return dbs.db.Get(dbutils.CodeBucket, codeHash[:])
+	return readCode(dbs.db, codeHash)
 }
 
 func (dbs *DbState) ReadAccountCodeSize(address common.Address, codeHash common.Hash) (int, error) {
@@ -296,7 +304,7 @@ func (dbs *DbState) WalkStorageRange(addrHash common.Hash, prefix trie.Keybytes,
 	startkey := make([]byte, common.HashLength+common.IncarnationLength+common.HashLength)
 	copy(startkey, addrHash[:])
 	// TODO: [Issue 99] Support incarnations
-	binary.BigEndian.PutUint64(startkey[common.HashLength:], ^uint64(1))
+	dbutils.EncodeIncarnation(startkey[common.HashLength:], 1)
 	copy(startkey[common.HashLength+common.IncarnationLength:], prefix.Data)
 
 	fixedbits := (common.HashLength + common.IncarnationLength + len(prefix.Data)) * 8
@@ -0,0 +1,66 @@
+package state
+
+import (
+	"bytes"
+	"errors"
+	"math"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+	"github.com/ledgerwatch/turbo-geth/core/types/accounts"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+)
+
+// AccountAsOf reconstructs the account and its storage (keyed by the hashes of the locations) as of the end of the block,
+// rewinding the current state of the account by its changes in the later blocks, see ethdb.RewindAccount.
+// Unlike the unwound TrieDbState (see UnwindTo), nothing is written and the rest of the state is not read,
+// so it suits the queries of the single accounts. Returns nil account if it did not exist
+func AccountAsOf(db ethdb.Getter, addrHash common.Hash, blockNr uint64) (*accounts.Account, map[common.Hash][]byte, error) {
+	accountMap, storageMap, err := ethdb.RewindAccount(db, addrHash, math.MaxUint64, blockNr)
+	if err != nil {
+		return nil, nil, err
+	}
+	enc, changed := accountMap[string(addrHash[:])]
+	if !changed {
+		if enc, err = db.Get(dbutils.CurrentStateBucket, addrHash[:]); err != nil && !errors.Is(err, ethdb.ErrKeyNotFound) {
+			return nil, nil, err
+		}
+	}
+	if len(enc) == 0 {
+		return nil, nil, nil
+	}
+	var acc accounts.Account
+	if err = acc.DecodeForStorage(enc); err != nil {
+		return nil, nil, err
+	}
+	prefix := dbutils.GenerateStoragePrefix(addrHash[:], acc.Incarnation)
+	// Fetch the code hash
+	if acc.Incarnation > 0 && acc.IsEmptyCodeHash() {
+		if codeHash, err := db.Get(dbutils.ContractCodeBucket, prefix); err == nil {
+			copy(acc.CodeHash[:], codeHash)
+		}
+	}
+
+	storage := make(map[common.Hash][]byte)
+	if acc.Incarnation > 0 {
+		if err = db.Walk(dbutils.CurrentStateBucket, prefix, 8*len(prefix), func(k, v []byte) (bool, error) {
+			storage[common.BytesToHash(k[len(prefix):])] = common.CopyBytes(v)
+			return true, nil
+		}); err != nil {
+			return nil, nil, err
+		}
+	}
+	for key, value := range storageMap {
+		// The changes of the other incarnations do not belong to the storage of the account
+		if !bytes.HasPrefix([]byte(key), prefix) {
+			continue
+		}
+		keyHash := common.BytesToHash([]byte(key)[len(prefix):])
+		if len(value) > 0 {
+			storage[keyHash] = value
+		} else {
+			delete(storage, keyHash)
+		}
+	}
+	return &acc, storage, nil
+}
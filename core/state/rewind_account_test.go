@@ -0,0 +1,70 @@
+package state
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/holiman/uint256"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/crypto"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+)
+
+func TestAccountAsOf(t *testing.T) {
+	db := ethdb.NewMemDatabase()
+	defer db.Close()
+	addr := common.HexToAddress("0x1234567890")
+	addrHash := crypto.Keccak256Hash(addr[:])
+	generateBlocksForUnwind(t, db.NewBatch(), addr, 10)
+
+	for _, blockNr := range []uint64{1, 5, 10} {
+		acc, storage, err := AccountAsOf(db, addrHash, blockNr)
+		require.NoError(t, err)
+		require.NotNil(t, acc)
+		assert.Equal(t, blockNr, acc.Balance.Uint64())
+		assert.Equal(t, uint64(FirstContractIncarnation), acc.Incarnation)
+		assert.Len(t, storage, int(blockNr))
+		for l := uint64(1); l <= blockNr; l++ {
+			var location common.Hash
+			location.SetBytes(big.NewInt(int64(l)).Bytes())
+			assert.Equal(t, big.NewInt(int64(l)).Bytes(), storage[crypto.Keccak256Hash(location[:])], "block %d location %d", blockNr, l)
+		}
+	}
+
+	// The contract is created in the first block, over the empty account
+	acc, storage, err := AccountAsOf(db, addrHash, 0)
+	require.NoError(t, err)
+	require.NotNil(t, acc)
+	assert.Equal(t, uint64(0), acc.Balance.Uint64())
+	assert.Equal(t, uint64(0), acc.Incarnation)
+	assert.Empty(t, storage)
+
+	acc, storage, err = AccountAsOf(db, crypto.Keccak256Hash([]byte("absent")), 5)
+	require.NoError(t, err)
+	assert.Nil(t, acc)
+	assert.Nil(t, storage)
+}
+
+func TestHistoricalForEachStorage(t *testing.T) {
+	db := ethdb.NewMemDatabase()
+	defer db.Close()
+	addr := common.HexToAddress("0x1234567890")
+	roots := generateBlocksForUnwind(t, db.NewBatch(), addr, 10)
+
+	tds := NewTrieDbState(roots[5], db, 5)
+	tds.SetHistorical(true)
+	values := make(map[common.Hash]uint64)
+	require.NoError(t, tds.ForEachStorage(addr, nil, func(key, seckey common.Hash, value uint256.Int) bool {
+		values[seckey] = value.Uint64()
+		return true
+	}, 100))
+	assert.Len(t, values, 5)
+	for l := uint64(1); l <= 5; l++ {
+		var location common.Hash
+		location.SetBytes(big.NewInt(int64(l)).Bytes())
+		assert.Equal(t, l, values[crypto.Keccak256Hash(location[:])], "location %d", l)
+	}
+}
@@ -0,0 +1,74 @@
+package state
+
+import (
+	"time"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/log"
+	"github.com/ledgerwatch/turbo-geth/metrics"
+)
+
+var (
+	aggregateStageTimer      = metrics.NewRegisteredTimer("state/stages/aggregate", nil)
+	storageTouchesStageTimer = metrics.NewRegisteredTimer("state/stages/storagetouches", nil)
+	accountTouchesStageTimer = metrics.NewRegisteredTimer("state/stages/accounttouches", nil)
+	resolveStageTimer        = metrics.NewRegisteredTimer("state/stages/resolve", nil)
+	trieUpdateStageTimer     = metrics.NewRegisteredTimer("state/stages/trieupdate", nil)
+	rootHashStageTimer       = metrics.NewRegisteredTimer("state/stages/roothash", nil)
+	commitStageTimer         = metrics.NewRegisteredTimer("state/stages/commit", nil)
+)
+
+// StageLogInterval is the number of blocks after which the summary of the time spent in the block processing stages is logged
+var StageLogInterval = uint64(1000)
+
+// StageTimings is the time spent by TrieDbState in the stages of block processing
+type StageTimings struct {
+	Blocks         uint64
+	Aggregate      time.Duration // merging of the current buffer into the aggregate buffer
+	StorageTouches time.Duration // building the lists of touched storage items
+	AccountTouches time.Duration // building the lists of touched accounts and code
+	Resolve        time.Duration // loading the touched parts of the tries from the database
+	TrieUpdate     time.Duration // applying the updates to the tries
+	RootHash       time.Duration // computing the state root
+	Commit         time.Duration // committing the batch to the database
+}
+
+// Total is the time spent in all stages
+func (st StageTimings) Total() time.Duration {
+	return st.Aggregate + st.StorageTouches + st.AccountTouches + st.Resolve + st.TrieUpdate + st.RootHash + st.Commit
+}
+
+func (tds *TrieDbState) recordStage(d *time.Duration, timer metrics.Timer, start time.Time) {
+	elapsed := time.Since(start)
+	*d += elapsed
+	timer.Update(elapsed)
+}
+
+// RecordCommit adds the time spent committing the database batch with the state updates
+func (tds *TrieDbState) RecordCommit(start time.Time) {
+	tds.recordStage(&tds.stages.Commit, commitStageTimer, start)
+}
+
+// StageTimings returns the time spent in the stages since the last summary
+func (tds *TrieDbState) StageTimings() StageTimings {
+	return tds.stages
+}
+
+// BlockProcessed counts the processed block, and logs the summary of stage timings every StageLogInterval blocks
+func (tds *TrieDbState) BlockProcessed() {
+	tds.stages.Blocks++
+	if StageLogInterval == 0 || tds.stages.Blocks < StageLogInterval {
+		return
+	}
+	st := tds.stages
+	log.Info("Block processing stages", "blocks", st.Blocks, "number", tds.blockNr,
+		"aggregate", common.PrettyDuration(st.Aggregate),
+		"storage touches", common.PrettyDuration(st.StorageTouches),
+		"account touches", common.PrettyDuration(st.AccountTouches),
+		"resolve", common.PrettyDuration(st.Resolve),
+		"trie update", common.PrettyDuration(st.TrieUpdate),
+		"root hash", common.PrettyDuration(st.RootHash),
+		"commit", common.PrettyDuration(st.Commit),
+		"total", common.PrettyDuration(st.Total()))
+	tds.stages = StageTimings{}
+}
@@ -0,0 +1,49 @@
+package state
+
+import (
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/core/types/accounts"
+)
+
+// StateChanges is the set of accounts and storage items changed by a block, taken from the aggregate buffer of TrieDbState
+// before it is cleared, so the subscribers do not need to read the changesets from the database.
+// The maps are shared between the subscribers and must not be modified
+type StateChanges struct {
+	Accounts   map[common.Hash]*accounts.Account      // by address hash, nil for the deleted accounts
+	Storage    map[common.Hash]map[common.Hash][]byte // by address hash and key hash, nil values are deletions
+	Code       map[common.Hash][]byte                 // by address hash
+	Destructed map[common.Hash]struct{}               // accounts, which storage prior to the block is gone (self-destructed or re-created)
+}
+
+// changes hands over the maps of the buffer, it must not be used afterwards
+func (b *Buffer) changes() *StateChanges {
+	destructed := make(map[common.Hash]struct{}, len(b.deleted)+len(b.created))
+	for addrHash := range b.deleted {
+		destructed[addrHash] = struct{}{}
+	}
+	for addrHash := range b.created {
+		destructed[addrHash] = struct{}{}
+	}
+	return &StateChanges{
+		Accounts:   b.accountUpdates,
+		Storage:    b.storageUpdates,
+		Code:       b.codeUpdates,
+		Destructed: destructed,
+	}
+}
+
+// SetCollectChanges makes UpdateStateTrie keep the changes of the state, see TakeChanges
+func (tds *TrieDbState) SetCollectChanges(collect bool) {
+	tds.collectChanges = collect
+	if !collect {
+		tds.changes = nil
+	}
+}
+
+// TakeChanges returns the state changes applied to the trie by the last UpdateStateTrie (nil if they were not collected),
+// and forgets them
+func (tds *TrieDbState) TakeChanges() *StateChanges {
+	changes := tds.changes
+	tds.changes = nil
+	return changes
+}
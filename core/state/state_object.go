@@ -173,6 +173,7 @@ func (so *stateObject) GetCommittedState(key *common.Hash, out *uint256.Int) {
 	{
 		value, cached := so.originStorage[*key]
 		if cached {
+			so.db.recordStorageRead(so, key)
 			*out = value
 			return
 		}
@@ -181,6 +182,11 @@ func (so *stateObject) GetCommittedState(key *common.Hash, out *uint256.Int) {
 		out.Clear()
 		return
 	}
+	if so.db.storagePromises {
+		so.db.promiseStorage(so.address, so.data.GetIncarnation(), key)
+		out.Clear()
+		return
+	}
 	// Load from DB in case it is missing.
 	enc, err := so.db.stateReader.ReadAccountStorage(so.address, so.data.GetIncarnation(), key)
 	if err != nil {
@@ -317,6 +323,7 @@ func (so *stateObject) Address() common.Address {
 // Code returns the contract code associated with this object, if any.
 func (so *stateObject) Code() []byte {
 	if so.code != nil {
+		so.db.recordCodeRead(so)
 		return so.code
 	}
 	if bytes.Equal(so.CodeHash(), emptyCodeHash) {
@@ -326,6 +333,9 @@ func (so *stateObject) Code() []byte {
 	if err != nil {
 		so.setError(fmt.Errorf("can't load code hash %x: %v", so.CodeHash(), err))
 	}
+	if so.db.suspendedRecorder != nil {
+		so.db.unrecorded.code[so.address] = struct{}{}
+	}
 	so.code = code
 	return code
 }
@@ -0,0 +1,219 @@
+package state
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+	"github.com/ledgerwatch/turbo-geth/core/types/accounts"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+	"github.com/ledgerwatch/turbo-geth/log"
+	"github.com/ledgerwatch/turbo-geth/metrics"
+)
+
+var (
+	stateAccountsGauge  = metrics.NewRegisteredGauge("state/size/accounts", nil)
+	stateContractsGauge = metrics.NewRegisteredGauge("state/size/contracts", nil)
+	stateStorageGauge   = metrics.NewRegisteredGauge("state/size/storage", nil)
+	stateCodeGauge      = metrics.NewRegisteredGauge("state/size/code", nil)
+	stateBytesGauge     = metrics.NewRegisteredGauge("state/size/bytes", nil)
+)
+
+// StateSizeKey is the key in dbutils.DatabaseInfoBucket, under which the running totals of the state size are persisted
+var StateSizeKey = []byte("StateSize")
+
+// ErrStateSizeNotTracked is returned when the totals have never been computed for the database
+var ErrStateSizeNotTracked = errors.New("state size is not tracked, it has to be computed first (`state stateSize`)")
+
+const (
+	stateSizeFields     = 5
+	accountKeyLength    = common.HashLength
+	storageKeyLength    = common.HashLength + common.IncarnationLength + common.HashLength
+	stateSizeEncodedLen = 8 * stateSizeFields
+)
+
+// StateSize holds the totals describing the size of the flat state: the accounts and the storage items
+// in dbutils.CurrentStateBucket, and the contract code in dbutils.CodeBucket.
+// It is also used for the changes of the totals, which are then negative for the removals
+type StateSize struct {
+	Accounts     int64 `json:"accounts"`
+	Contracts    int64 `json:"contracts"`    // Accounts with non-zero incarnation
+	StorageSlots int64 `json:"storageSlots"` // Storage items, including the ones of the self-destructed incarnations
+	CodeBytes    int64 `json:"codeBytes"`    // Size of the distinct contract codes
+	StateBytes   int64 `json:"stateBytes"`   // Size of the keys and the values of dbutils.CurrentStateBucket
+}
+
+// ChangeAccount accounts for the replacement of the original account (nil or not initialised if it did not exist)
+// by the account (nil if it is deleted)
+func (s *StateSize) ChangeAccount(original, account *accounts.Account) {
+	if original != nil && original.Initialised {
+		s.Accounts--
+		s.StateBytes -= int64(accountKeyLength + original.EncodingLengthForStorage())
+		if original.Incarnation > 0 {
+			s.Contracts--
+		}
+	}
+	if account != nil {
+		s.Accounts++
+		s.StateBytes += int64(accountKeyLength + account.EncodingLengthForStorage())
+		if account.Incarnation > 0 {
+			s.Contracts++
+		}
+	}
+}
+
+// ChangeStorage accounts for the replacement of the storage item, empty value means that it does not exist
+func (s *StateSize) ChangeStorage(original, value []byte) {
+	if len(original) > 0 {
+		s.StorageSlots--
+		s.StateBytes -= int64(storageKeyLength + len(original))
+	}
+	if len(value) > 0 {
+		s.StorageSlots++
+		s.StateBytes += int64(storageKeyLength + len(value))
+	}
+}
+
+// AddCode accounts for the code, which has not been present in the database
+func (s *StateSize) AddCode(code []byte) {
+	s.CodeBytes += int64(len(code))
+}
+
+// UnwindAccount accounts for the unwinding of the account, which replaces the account currently in the database
+// by the restored one (nil if the account is deleted)
+func (s *StateSize) UnwindAccount(db ethdb.Getter, addrHash []byte, restored *accounts.Account) error {
+	enc, err := db.Get(dbutils.CurrentStateBucket, addrHash)
+	if err != nil && !errors.Is(err, ethdb.ErrKeyNotFound) {
+		return err
+	}
+	var current *accounts.Account
+	if len(enc) > 0 {
+		current = new(accounts.Account)
+		if err := current.DecodeForStorage(enc); err != nil {
+			return err
+		}
+	}
+	s.ChangeAccount(current, restored)
+	return nil
+}
+
+// UnwindStorage accounts for the unwinding of the storage item, which replaces the value currently in the database
+// by the restored one (empty if the item is deleted)
+func (s *StateSize) UnwindStorage(db ethdb.Getter, compositeKey []byte, restored []byte) error {
+	current, err := db.Get(dbutils.CurrentStateBucket, compositeKey)
+	if err != nil && !errors.Is(err, ethdb.ErrKeyNotFound) {
+		return err
+	}
+	s.ChangeStorage(current, restored)
+	return nil
+}
+
+func (s *StateSize) add(delta *StateSize) {
+	s.Accounts += delta.Accounts
+	s.Contracts += delta.Contracts
+	s.StorageSlots += delta.StorageSlots
+	s.CodeBytes += delta.CodeBytes
+	s.StateBytes += delta.StateBytes
+}
+
+func (s *StateSize) fields() []*int64 {
+	return []*int64{&s.Accounts, &s.Contracts, &s.StorageSlots, &s.CodeBytes, &s.StateBytes}
+}
+
+func (s *StateSize) encode() []byte {
+	v := make([]byte, stateSizeEncodedLen)
+	for i, f := range s.fields() {
+		binary.BigEndian.PutUint64(v[8*i:], uint64(*f))
+	}
+	return v
+}
+
+func (s *StateSize) updateMetrics() {
+	stateAccountsGauge.Update(s.Accounts)
+	stateContractsGauge.Update(s.Contracts)
+	stateStorageGauge.Update(s.StorageSlots)
+	stateCodeGauge.Update(s.CodeBytes)
+	stateBytesGauge.Update(s.StateBytes)
+}
+
+// ReadStateSize returns the persisted totals, or ErrStateSizeNotTracked
+func ReadStateSize(db ethdb.Getter) (*StateSize, error) {
+	v, err := db.Get(dbutils.DatabaseInfoBucket, StateSizeKey)
+	if err != nil && !errors.Is(err, ethdb.ErrKeyNotFound) {
+		return nil, err
+	}
+	if len(v) == 0 {
+		return nil, ErrStateSizeNotTracked
+	}
+	if len(v) != stateSizeEncodedLen {
+		return nil, fmt.Errorf("state size value must be of length %d, got %d", stateSizeEncodedLen, len(v))
+	}
+	s := new(StateSize)
+	for i, f := range s.fields() {
+		*f = int64(binary.BigEndian.Uint64(v[8*i:]))
+	}
+	return s, nil
+}
+
+// WriteStateSize persists the totals and updates the metrics
+func WriteStateSize(db ethdb.Putter, s *StateSize) error {
+	if err := db.Put(dbutils.DatabaseInfoBucket, StateSizeKey, s.encode()); err != nil {
+		return err
+	}
+	s.updateMetrics()
+	return nil
+}
+
+// UpdateStateSize adds the delta to the persisted totals. If the totals are not tracked, they start being tracked
+// from zero when init is true (the state is being created from scratch), otherwise the delta is ignored
+func UpdateStateSize(db ethdb.GetterPutter, delta *StateSize, init bool) error {
+	s, err := ReadStateSize(db)
+	if errors.Is(err, ErrStateSizeNotTracked) {
+		if !init {
+			return nil
+		}
+		s, err = new(StateSize), nil
+	}
+	if err != nil {
+		return err
+	}
+	s.add(delta)
+	return WriteStateSize(db, s)
+}
+
+// ComputeStateSize walks the state buckets to compute the totals from scratch
+func ComputeStateSize(db ethdb.Getter) (*StateSize, error) {
+	start := time.Now()
+	s := new(StateSize)
+	if err := db.Walk(dbutils.CurrentStateBucket, nil, 0, func(k, v []byte) (bool, error) {
+		switch len(k) {
+		case accountKeyLength:
+			var acc accounts.Account
+			if err := acc.DecodeForStorage(v); err != nil {
+				return false, fmt.Errorf("decoding account %x: %w", k, err)
+			}
+			s.Accounts++
+			if acc.Incarnation > 0 {
+				s.Contracts++
+			}
+		case storageKeyLength:
+			s.StorageSlots++
+		}
+		s.StateBytes += int64(len(k) + len(v))
+		return true, nil
+	}); err != nil {
+		return nil, err
+	}
+	if err := db.Walk(dbutils.CodeBucket, nil, 0, func(_, v []byte) (bool, error) {
+		s.CodeBytes += int64(len(v))
+		return true, nil
+	}); err != nil {
+		return nil, err
+	}
+	log.Info("State size computed", "accounts", s.Accounts, "contracts", s.Contracts, "storage", s.StorageSlots,
+		"code", common.StorageSize(s.CodeBytes), "state", common.StorageSize(s.StateBytes), "elapsed", common.PrettyDuration(time.Since(start)))
+	return s, nil
+}
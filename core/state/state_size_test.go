@@ -0,0 +1,113 @@
+package state
+
+import (
+	"context"
+	"testing"
+
+	"github.com/holiman/uint256"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+	"github.com/ledgerwatch/turbo-geth/core/types/accounts"
+	"github.com/ledgerwatch/turbo-geth/crypto"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+)
+
+func TestStateSize(t *testing.T) {
+	db := ethdb.NewMemDatabase()
+	defer db.Close()
+	ctx := context.Background()
+
+	// The totals are not tracked for the existing state, which was not created from the genesis
+	w := NewDbStateWriter(db, db, 5)
+	require.NoError(t, w.UpdateAccountData(ctx, common.HexToAddress("0x01"), &accounts.Account{}, &accounts.Account{Nonce: 1}))
+	require.NoError(t, w.WriteStateSize())
+	_, err := ReadStateSize(db)
+	assert.Equal(t, ErrStateSizeNotTracked, err)
+	require.NoError(t, db.Delete(dbutils.CurrentStateBucket, crypto.Keccak256(common.HexToAddress("0x01").Bytes())))
+
+	eoa := common.HexToAddress("0x1234")
+	contract := common.HexToAddress("0x5678")
+	code := []byte{0x60, 0x01, 0x60, 0x02}
+	codeHash := crypto.Keccak256Hash(code)
+	keys := []common.Hash{common.HexToHash("0x01"), common.HexToHash("0x02")}
+	zero := uint256.NewInt()
+
+	// Genesis
+	w = NewDbStateWriter(db, db, 0)
+	eoaAccount := accounts.NewAccount()
+	eoaAccount.Initialised = true
+	eoaAccount.Balance.SetUint64(1000)
+	require.NoError(t, w.UpdateAccountData(ctx, eoa, &accounts.Account{}, &eoaAccount))
+	contractAccount := accounts.NewAccount()
+	contractAccount.Initialised = true
+	contractAccount.Incarnation = FirstContractIncarnation
+	contractAccount.CodeHash = codeHash
+	require.NoError(t, w.UpdateAccountCode(contract, contractAccount.Incarnation, codeHash, code))
+	for i, key := range keys {
+		key := key
+		require.NoError(t, w.WriteAccountStorage(ctx, contract, contractAccount.Incarnation, &key, zero, uint256.NewInt().SetUint64(uint64(i+1))))
+	}
+	require.NoError(t, w.UpdateAccountData(ctx, contract, &accounts.Account{}, &contractAccount))
+	require.NoError(t, w.WriteStateSize())
+
+	size, err := ReadStateSize(db)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), size.Accounts)
+	assert.Equal(t, int64(1), size.Contracts)
+	assert.Equal(t, int64(2), size.StorageSlots)
+	assert.Equal(t, int64(len(code)), size.CodeBytes)
+	computed, err := ComputeStateSize(db)
+	require.NoError(t, err)
+	assert.Equal(t, computed, size)
+
+	// Block 1: the storage item is cleared, the other one is changed, the EOA is deleted,
+	// a new contract with the same code is created
+	w = NewDbStateWriter(db, db, 1)
+	key := keys[0]
+	require.NoError(t, w.WriteAccountStorage(ctx, contract, contractAccount.Incarnation, &key, uint256.NewInt().SetUint64(1), zero))
+	key = keys[1]
+	require.NoError(t, w.WriteAccountStorage(ctx, contract, contractAccount.Incarnation, &key, uint256.NewInt().SetUint64(2), uint256.NewInt().SetUint64(0x10000)))
+	require.NoError(t, w.DeleteAccount(ctx, eoa, &eoaAccount))
+	clone := common.HexToAddress("0x9abc")
+	require.NoError(t, w.UpdateAccountCode(clone, FirstContractIncarnation, codeHash, code))
+	require.NoError(t, w.UpdateAccountData(ctx, clone, &accounts.Account{}, &contractAccount))
+	require.NoError(t, w.WriteStateSize())
+
+	size, err = ReadStateSize(db)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), size.Accounts)
+	assert.Equal(t, int64(2), size.Contracts)
+	assert.Equal(t, int64(1), size.StorageSlots)
+	assert.Equal(t, int64(len(code)), size.CodeBytes, "the code is shared")
+	computed, err = ComputeStateSize(db)
+	require.NoError(t, err)
+	assert.Equal(t, computed, size)
+
+	// Unwinding the block restores the totals of the genesis
+	var delta StateSize
+	contractHash, err := common.HashData(contract[:])
+	require.NoError(t, err)
+	eoaHash, err := common.HashData(eoa[:])
+	require.NoError(t, err)
+	cloneHash, err := common.HashData(clone[:])
+	require.NoError(t, err)
+	for i, key := range keys {
+		keyHash, err := common.HashData(key[:])
+		require.NoError(t, err)
+		compositeKey := dbutils.GenerateCompositeStorageKey(contractHash, contractAccount.Incarnation, keyHash)
+		restored := []byte{byte(i + 1)}
+		require.NoError(t, delta.UnwindStorage(db, compositeKey, restored))
+		require.NoError(t, db.Put(dbutils.CurrentStateBucket, compositeKey, restored))
+	}
+	require.NoError(t, delta.UnwindAccount(db, eoaHash[:], &eoaAccount))
+	require.NoError(t, delta.UnwindAccount(db, cloneHash[:], nil))
+	require.NoError(t, UpdateStateSize(db, &delta, false))
+	size, err = ReadStateSize(db)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), size.Accounts)
+	assert.Equal(t, int64(1), size.Contracts)
+	assert.Equal(t, int64(2), size.StorageSlots)
+}
@@ -0,0 +1,370 @@
+// Package stateexport writes the state at a block into a deterministic flat file, and loads such files into
+// the in-memory databases, so that the tests and the benchmarks can run against the realistic state checked in
+// as a fixture.
+//
+// The file is the stream of RLP items: the Header, the chunks of the sorted entries of the exported buckets
+// (in the order of Header.Buckets, up to Header.ChunkSize entries each, a chunk never spans two buckets),
+// and the Footer with the hashes of all the chunks and their Merkle root. The same state is always exported
+// into the same bytes, so the fixtures can be compared by their Footer.ChunksRoot
+package stateexport
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+	"github.com/ledgerwatch/turbo-geth/core/rawdb"
+	"github.com/ledgerwatch/turbo-geth/core/state"
+	"github.com/ledgerwatch/turbo-geth/crypto"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+	"github.com/ledgerwatch/turbo-geth/log"
+	"github.com/ledgerwatch/turbo-geth/rlp"
+)
+
+// Format identifies the state export files, Version is the version of their layout
+const (
+	Format  = "turbo-geth state export"
+	Version = 1
+)
+
+// DefaultChunkSize is the number of the entries in one chunk
+const DefaultChunkSize = 4096
+
+// Header describes the exported state
+type Header struct {
+	Format    string
+	Version   uint
+	Block     uint64
+	BlockHash common.Hash
+	Root      common.Hash // State root of the block, which the loaded state is expected to have
+	ChunkSize uint64
+	Buckets   [][]byte // Exported buckets, in the order of their chunks
+}
+
+// Footer ends the file
+type Footer struct {
+	ChunkHashes []common.Hash // keccak256 of the RLP encoding of every chunk
+	ChunksRoot  common.Hash   // see MerkleRoot
+	Entries     uint64
+}
+
+// chunk is the sorted run of the entries of one bucket
+type chunk struct {
+	Bucket uint // Index in Header.Buckets
+	Keys   [][]byte
+	Values [][]byte
+}
+
+// exportedBuckets are the buckets of the hashed state: the accounts together with the storage,
+// the code hashes of the contracts and the codes
+var exportedBuckets = [][]byte{dbutils.CurrentStateBucket, dbutils.ContractCodeBucket, dbutils.CodeBucket}
+
+// ExportFile writes the state at the block into the file with DefaultChunkSize, the file is gzipped if its name
+// ends with .gz
+func ExportFile(db ethdb.Database, fn string, block uint64) (footer *Footer, err error) {
+	fh, err := os.OpenFile(fn, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if closeErr := fh.Close(); err == nil && closeErr != nil {
+			footer, err = nil, closeErr
+		}
+	}()
+	if !strings.HasSuffix(fn, ".gz") {
+		return Export(db, fh, block, DefaultChunkSize)
+	}
+	gw := gzip.NewWriter(fh)
+	if footer, err = Export(db, gw, block, DefaultChunkSize); err != nil {
+		gw.Close()
+		return nil, err
+	}
+	// The gzip footer is written by Close
+	if err := gw.Close(); err != nil {
+		return nil, err
+	}
+	return footer, nil
+}
+
+// Export writes the state after the execution of the canonical block. The state of the earlier blocks
+// is reconstructed from the history, so the database has to keep it
+func Export(db ethdb.Database, w io.Writer, block uint64, chunkSize int) (*Footer, error) {
+	if chunkSize <= 0 {
+		return nil, fmt.Errorf("invalid chunk size %d", chunkSize)
+	}
+	hash := rawdb.ReadCanonicalHash(db, block)
+	if hash == (common.Hash{}) {
+		return nil, fmt.Errorf("canonical hash of block %d not found", block)
+	}
+	header := rawdb.ReadHeader(db, hash, block)
+	if header == nil {
+		return nil, fmt.Errorf("header of block %d %x not found", block, hash)
+	}
+	if err := rlp.Encode(w, &Header{
+		Format:    Format,
+		Version:   Version,
+		Block:     block,
+		BlockHash: hash,
+		Root:      header.Root,
+		ChunkSize: uint64(chunkSize),
+		Buckets:   exportedBuckets,
+	}); err != nil {
+		return nil, err
+	}
+	cw := &chunkWriter{w: w, chunkSize: chunkSize}
+	log.Info("Exporting state", "block", block, "root", header.Root)
+
+	// The accounts and their storage, the storage keys follow the keys of their accounts
+	var codeKeys [][]byte
+	codeHashes := make(map[common.Hash]struct{})
+	it := state.NewHistoricalAccountIterator(db, block, nil, 0)
+	for it.Next() {
+		addrHash, acc := it.AddrHash(), it.Account()
+		enc := make([]byte, acc.EncodingLengthForStorage())
+		acc.EncodeForStorage(enc)
+		if err := cw.add(0, common.CopyBytes(addrHash[:]), enc); err != nil {
+			return nil, err
+		}
+		if acc.Incarnation == 0 {
+			continue
+		}
+		storagePrefix := dbutils.GenerateStoragePrefix(addrHash[:], acc.Incarnation)
+		if err := db.WalkAsOf(dbutils.CurrentStateBucket, dbutils.StorageHistoryBucket, storagePrefix, 8*len(storagePrefix), block+1, func(sk, sv []byte) (bool, error) {
+			// The walk returns the keys without the incarnation
+			if len(sv) == 0 {
+				return true, nil
+			}
+			return true, cw.add(0, dbutils.GenerateCompositeStorageKey(addrHash, acc.Incarnation, common.BytesToHash(sk[common.HashLength:])), sv)
+		}); err != nil {
+			return nil, fmt.Errorf("walking storage of %x: %w", addrHash, err)
+		}
+		codeHash, err := db.Get(dbutils.ContractCodeBucket, storagePrefix)
+		if err != nil && !errors.Is(err, ethdb.ErrKeyNotFound) {
+			return nil, err
+		}
+		if len(codeHash) > 0 {
+			codeKeys = append(codeKeys, storagePrefix)
+			codeHashes[common.BytesToHash(codeHash)] = struct{}{}
+		}
+	}
+	if err := it.Err(); err != nil {
+		return nil, err
+	}
+
+	// The code hashes of the contracts, in the order of the accounts
+	for _, k := range codeKeys {
+		codeHash, err := db.Get(dbutils.ContractCodeBucket, k)
+		if err != nil {
+			return nil, err
+		}
+		if err := cw.add(1, k, codeHash); err != nil {
+			return nil, err
+		}
+	}
+
+	// The codes, once per code hash
+	hashes := make(common.Hashes, 0, len(codeHashes))
+	for h := range codeHashes {
+		hashes = append(hashes, h)
+	}
+	sort.Sort(hashes)
+	for _, h := range hashes {
+		code, err := state.PeekCode(db, h)
+		if err != nil {
+			return nil, fmt.Errorf("code %x: %w", h, err)
+		}
+		if err := cw.add(2, h[:], code); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := cw.flush(); err != nil {
+		return nil, err
+	}
+	footer := &Footer{ChunkHashes: cw.hashes, ChunksRoot: MerkleRoot(cw.hashes), Entries: cw.entries}
+	if err := rlp.Encode(w, footer); err != nil {
+		return nil, err
+	}
+	log.Info("State exported", "block", block, "entries", footer.Entries, "chunks", len(footer.ChunkHashes), "chunks root", footer.ChunksRoot)
+	return footer, nil
+}
+
+// chunkWriter cuts the entries into the chunks and hashes them
+type chunkWriter struct {
+	w         io.Writer
+	chunkSize int
+	current   chunk
+	hashes    []common.Hash
+	entries   uint64
+}
+
+func (cw *chunkWriter) add(bucket uint, k, v []byte) error {
+	if len(cw.current.Keys) > 0 && (cw.current.Bucket != bucket || len(cw.current.Keys) == cw.chunkSize) {
+		if err := cw.flush(); err != nil {
+			return err
+		}
+	}
+	cw.current.Bucket = bucket
+	cw.current.Keys = append(cw.current.Keys, common.CopyBytes(k))
+	cw.current.Values = append(cw.current.Values, common.CopyBytes(v))
+	cw.entries++
+	return nil
+}
+
+func (cw *chunkWriter) flush() error {
+	if len(cw.current.Keys) == 0 {
+		return nil
+	}
+	enc, err := rlp.EncodeToBytes(&cw.current)
+	if err != nil {
+		return err
+	}
+	if _, err := cw.w.Write(enc); err != nil {
+		return err
+	}
+	cw.hashes = append(cw.hashes, crypto.Keccak256Hash(enc))
+	cw.current = chunk{}
+	return nil
+}
+
+// MerkleRoot is the root of the binary Merkle tree over the hashes of the chunks, the odd node of the level
+// is carried over to the next level as is. The root of no chunks is the empty hash
+func MerkleRoot(hashes []common.Hash) common.Hash {
+	if len(hashes) == 0 {
+		return common.Hash{}
+	}
+	level := append([]common.Hash(nil), hashes...)
+	for len(level) > 1 {
+		next := level[:0]
+		for i := 0; i < len(level); i += 2 {
+			if i+1 == len(level) {
+				next = append(next, level[i])
+				continue
+			}
+			next = append(next, crypto.Keccak256Hash(level[i][:], level[i+1][:]))
+		}
+		level = next
+	}
+	return level[0]
+}
+
+// LoadMemDatabase loads the state export file (gunzipped if its name ends with .gz) into the new in-memory database
+func LoadMemDatabase(fn string) (*ethdb.BoltDatabase, *Header, error) {
+	fh, err := os.Open(fn)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer fh.Close()
+	var r io.Reader = fh
+	if strings.HasSuffix(fn, ".gz") {
+		if r, err = gzip.NewReader(fh); err != nil {
+			return nil, nil, err
+		}
+	}
+	db := ethdb.NewMemDatabase()
+	header, err := Load(db, r)
+	if err != nil {
+		db.Close()
+		return nil, nil, err
+	}
+	return db, header, nil
+}
+
+// Load writes the exported state into the database and checks the hashes of the chunks against the footer.
+// The entries are written as they are read, so the database is left with the partial state if the check fails.
+// The state root is not checked, it is returned in the header
+func Load(db ethdb.Database, r io.Reader) (*Header, error) {
+	stream := rlp.NewStream(r, 0)
+	var header Header
+	if err := stream.Decode(&header); err != nil {
+		return nil, fmt.Errorf("decoding header: %w", err)
+	}
+	if header.Format != Format {
+		return nil, fmt.Errorf("not a state export file, format %q", header.Format)
+	}
+	if header.Version != Version {
+		return nil, fmt.Errorf("unsupported version %d of state export, expected %d", header.Version, Version)
+	}
+	batch := db.NewBatch()
+	defer batch.Rollback()
+	var hashes []common.Hash
+	var entries uint64
+	var lastBucket uint
+	var lastKey []byte
+	for {
+		raw, err := stream.Raw()
+		if err != nil {
+			return nil, fmt.Errorf("decoding chunk %d: %w", len(hashes), err)
+		}
+		content, _, err := rlp.SplitList(raw)
+		if err != nil {
+			return nil, fmt.Errorf("decoding chunk %d: %w", len(hashes), err)
+		}
+		// The first field of the chunk is the index of the bucket, of the footer - the list of the chunk hashes
+		if kind, _, _, err := rlp.Split(content); err != nil {
+			return nil, fmt.Errorf("decoding chunk %d: %w", len(hashes), err)
+		} else if kind == rlp.List {
+			var footer Footer
+			if err := rlp.DecodeBytes(raw, &footer); err != nil {
+				return nil, fmt.Errorf("decoding footer: %w", err)
+			}
+			if err := checkFooter(&footer, hashes, entries); err != nil {
+				return nil, err
+			}
+			break
+		}
+		var c chunk
+		if err := rlp.DecodeBytes(raw, &c); err != nil {
+			return nil, fmt.Errorf("decoding chunk %d: %w", len(hashes), err)
+		}
+		if int(c.Bucket) >= len(header.Buckets) || len(c.Keys) != len(c.Values) || len(c.Keys) == 0 || uint64(len(c.Keys)) > header.ChunkSize {
+			return nil, fmt.Errorf("malformed chunk %d", len(hashes))
+		}
+		for i, k := range c.Keys {
+			// The entries are sorted within the bucket, the buckets go in the order of the header
+			if c.Bucket < lastBucket || (c.Bucket == lastBucket && lastKey != nil && bytes.Compare(k, lastKey) <= 0) {
+				return nil, fmt.Errorf("chunk %d is out of order at key %x", len(hashes), k)
+			}
+			lastBucket, lastKey = c.Bucket, k
+			if err := batch.Put(header.Buckets[c.Bucket], k, c.Values[i]); err != nil {
+				return nil, err
+			}
+		}
+		entries += uint64(len(c.Keys))
+		hashes = append(hashes, crypto.Keccak256Hash(raw))
+		if batch.BatchSize() >= batch.IdealBatchSize() {
+			if _, err := batch.Commit(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if _, err := batch.Commit(); err != nil {
+		return nil, err
+	}
+	return &header, nil
+}
+
+func checkFooter(footer *Footer, hashes []common.Hash, entries uint64) error {
+	if len(footer.ChunkHashes) != len(hashes) {
+		return fmt.Errorf("footer lists %d chunks, file has %d", len(footer.ChunkHashes), len(hashes))
+	}
+	for i, h := range hashes {
+		if footer.ChunkHashes[i] != h {
+			return fmt.Errorf("hash of chunk %d mismatch: %x, footer has %x", i, h, footer.ChunkHashes[i])
+		}
+	}
+	if root := MerkleRoot(hashes); root != footer.ChunksRoot {
+		return fmt.Errorf("chunks root mismatch: %x, footer has %x", root, footer.ChunksRoot)
+	}
+	if footer.Entries != entries {
+		return fmt.Errorf("footer counts %d entries, file has %d", footer.Entries, entries)
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package stateexport
+
+import (
+	"bytes"
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+	"github.com/ledgerwatch/turbo-geth/core/rawdb"
+	"github.com/ledgerwatch/turbo-geth/core/types"
+	"github.com/ledgerwatch/turbo-geth/core/types/accounts"
+	"github.com/ledgerwatch/turbo-geth/crypto"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportLoad(t *testing.T) {
+	db := ethdb.NewMemDatabase()
+	defer db.Close()
+	// The database with the exported entries only
+	expected := ethdb.NewMemDatabase()
+	defer expected.Close()
+	put := func(bucket, k, v []byte) {
+		require.NoError(t, db.Put(bucket, k, v))
+		require.NoError(t, expected.Put(bucket, k, v))
+	}
+
+	code := []byte{0x60, 0x01, 0x60, 0x02, 0x01}
+	codeHash := crypto.Keccak256Hash(code)
+	for i := byte(1); i <= 5; i++ {
+		addrHash := common.Hash{i}
+		acc := accounts.NewAccount()
+		acc.Balance.SetUint64(uint64(i) * 1000)
+		acc.Nonce = uint64(i)
+		if i%2 == 1 {
+			acc.Incarnation = 1
+			acc.CodeHash = codeHash
+			for j := byte(1); j <= 3; j++ {
+				put(dbutils.CurrentStateBucket, dbutils.GenerateCompositeStorageKey(addrHash, 1, common.Hash{j}), []byte{i, j})
+			}
+			put(dbutils.ContractCodeBucket, dbutils.GenerateStoragePrefix(addrHash[:], 1), codeHash[:])
+			// The storage of the previous incarnation is not a part of the state
+			require.NoError(t, db.Put(dbutils.CurrentStateBucket, dbutils.GenerateCompositeStorageKey(addrHash, 2, common.Hash{1}), []byte{0xff}))
+		}
+		v := make([]byte, acc.EncodingLengthForStorage())
+		acc.EncodeForStorage(v)
+		put(dbutils.CurrentStateBucket, addrHash[:], v)
+	}
+	put(dbutils.CodeBucket, codeHash[:], code)
+
+	header := &types.Header{Number: big.NewInt(0), Root: common.Hash{0xaa}}
+	rawdb.WriteHeader(context.Background(), db, header)
+	rawdb.WriteCanonicalHash(db, header.Hash(), 0)
+
+	// The export is deterministic
+	var buf, buf2 bytes.Buffer
+	footer, err := Export(db, &buf, 0, 4)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(5+9+3+1), footer.Entries)
+	assert.Equal(t, 4+1+1, len(footer.ChunkHashes)) // 14 state entries, 3 contract code hashes, 1 code
+	_, err = Export(db, &buf2, 0, 4)
+	require.NoError(t, err)
+	assert.Equal(t, buf.Bytes(), buf2.Bytes())
+
+	loaded := ethdb.NewMemDatabase()
+	defer loaded.Close()
+	h, err := Load(loaded, bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	assert.Equal(t, header.Root, h.Root)
+	assert.Equal(t, header.Hash(), h.BlockHash)
+	loadedHash, err := loaded.ContentHash()
+	require.NoError(t, err)
+	expectedHash, err := expected.ContentHash()
+	require.NoError(t, err)
+	assert.Equal(t, expectedHash, loadedHash)
+
+	// The tampered chunk does not match its hash
+	tampered := bytes.Replace(buf.Bytes(), code, []byte{0x60, 0x01, 0x60, 0x03, 0x01}, 1)
+	_, err = Load(ethdb.NewMemDatabase(), bytes.NewReader(tampered))
+	assert.Error(t, err)
+}
+
+func TestMerkleRoot(t *testing.T) {
+	h1, h2, h3 := common.Hash{1}, common.Hash{2}, common.Hash{3}
+	assert.Equal(t, common.Hash{}, MerkleRoot(nil))
+	assert.Equal(t, h1, MerkleRoot([]common.Hash{h1}))
+	h12 := crypto.Keccak256Hash(h1[:], h2[:])
+	assert.Equal(t, h12, MerkleRoot([]common.Hash{h1, h2}))
+	assert.Equal(t, crypto.Keccak256Hash(h12[:], h3[:]), MerkleRoot([]common.Hash{h1, h2, h3}))
+}
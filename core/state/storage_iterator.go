@@ -0,0 +1,219 @@
+package state
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+
+	"github.com/holiman/uint256"
+	"github.com/petar/GoLLRB/llrb"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+	"github.com/ledgerwatch/turbo-geth/core/types/accounts"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+)
+
+// StorageIterator is implemented by the state readers, which can enumerate the storage of a contract
+// in the order of the hashed keys, starting from the (prefix of) hashed key `start`.
+// cb is called for up to maxResults non-zero items, iteration stops when cb returns false
+type StorageIterator interface {
+	ForEachStorage(addr common.Address, start []byte, cb func(key, seckey common.Hash, value uint256.Int) bool, maxResults int) error
+}
+
+var _ StorageIterator = (*DbState)(nil)
+var _ StorageIterator = (*TrieDbState)(nil)
+var _ StorageIterator = (*IntraBlockState)(nil)
+
+var errStorageIteratorNotSupported = errors.New("state reader does not support storage iteration")
+
+// overlayStorage calls cb for up to maxResults non-zero items of the underlying storage (sorted by seckey),
+// replaced by the overrides (zero value means deletion). The underlying iteration is asked for the number
+// of items that is enough to fill maxResults even if every override deletes one of them
+func overlayStorage(
+	underlying func(limit int, f func(item *storageItem)) error,
+	overrides map[common.Hash]*storageItem,
+	start []byte,
+	resolveKey func(item *storageItem) error,
+	cb func(key, seckey common.Hash, value uint256.Int) bool,
+	maxResults int,
+) error {
+	st := llrb.New()
+	for seckey, item := range overrides {
+		if bytes.Compare(seckey[:], start) >= 0 {
+			st.ReplaceOrInsert(item)
+		}
+	}
+	if underlying != nil {
+		if err := underlying(maxResults+st.Len(), func(item *storageItem) {
+			// InsertNoReplace would keep both items, the override has to hide the underlying one
+			if st.Get(item) == nil {
+				st.ReplaceOrInsert(item)
+			}
+		}); err != nil {
+			return err
+		}
+	}
+	results := 0
+	var innerErr error
+	st.AscendGreaterOrEqual(&storageItem{seckey: common.BytesToHash(start)}, func(i llrb.Item) bool {
+		item := i.(*storageItem)
+		if item.value.IsZero() {
+			return true
+		}
+		if resolveKey != nil {
+			if innerErr = resolveKey(item); innerErr != nil {
+				return false
+			}
+		}
+		results++
+		return cb(item.key, item.seckey, item.value) && results < maxResults
+	})
+	return innerErr
+}
+
+// bufferedStorage returns the storage updates of the account, not yet applied to the trie (nil values are deletions),
+// and whether the storage found in the database is not valid anymore (the account was deleted or re-created).
+// Account is returned if it has been updated too
+func (tds *TrieDbState) bufferedStorage(addrHash common.Hash) (updates map[common.Hash][]byte, wiped bool, account *accounts.Account, accountUpdated bool) {
+	updates = make(map[common.Hash][]byte)
+	for _, b := range []*Buffer{tds.aggregateBuffer, tds.currentBuffer} {
+		if b == nil {
+			continue
+		}
+		_, deleted := b.deleted[addrHash]
+		_, created := b.created[addrHash]
+		if deleted || created {
+			wiped = true
+			updates = make(map[common.Hash][]byte)
+		}
+		for keyHash, v := range b.storageUpdates[addrHash] {
+			updates[keyHash] = v
+		}
+		if a, ok := b.accountUpdates[addrHash]; ok {
+			account, accountUpdated = a, true
+		}
+	}
+	return updates, wiped, account, accountUpdated
+}
+
+// ForEachStorage enumerates the current storage of the contract: records of CurrentStateBucket
+// (prefixed by addrHash+incarnation), replaced by the updates buffered in TrieDbState and in the pending batch.
+// The historical TrieDbState rewinds the storage of the contract instead, see AccountAsOf.
+// Keys without preimages are passed to cb as empty hashes
+func (tds *TrieDbState) ForEachStorage(addr common.Address, start []byte, cb func(key, seckey common.Hash, value uint256.Int) bool, maxResults int) error {
+	addrHash, err := tds.pw.HashAddress(addr, false /*save*/)
+	if err != nil {
+		return err
+	}
+	updates, wiped, account, accountUpdated := tds.bufferedStorage(addrHash)
+	if !accountUpdated {
+		if account, err = tds.readAccountDataByHash(addrHash); err != nil {
+			return err
+		}
+	}
+	if account == nil {
+		return nil
+	}
+	overrides := make(map[common.Hash]*storageItem, len(updates))
+	for keyHash, v := range updates {
+		item := &storageItem{seckey: keyHash}
+		item.value.SetBytes(v)
+		overrides[keyHash] = item
+	}
+
+	var underlying func(limit int, f func(item *storageItem)) error
+	if !wiped && tds.historical {
+		underlying = func(limit int, f func(item *storageItem)) error {
+			_, storage, err := AccountAsOf(tds.db, addrHash, tds.blockNr)
+			if err != nil {
+				return err
+			}
+			items := make([]*storageItem, 0, len(storage))
+			for keyHash, v := range storage {
+				if len(v) == 0 || bytes.Compare(keyHash[:], start) < 0 {
+					continue
+				}
+				item := &storageItem{seckey: keyHash}
+				item.value.SetBytes(v)
+				items = append(items, item)
+			}
+			sort.Slice(items, func(i, j int) bool { return bytes.Compare(items[i].seckey[:], items[j].seckey[:]) < 0 })
+			for i := 0; i < len(items) && i < limit; i++ {
+				f(items[i])
+			}
+			return nil
+		}
+	} else if !wiped {
+		underlying = func(limit int, f func(item *storageItem)) error {
+			prefix := dbutils.GenerateStoragePrefix(addrHash[:], account.Incarnation)
+			startkey := append(common.CopyBytes(prefix), start...)
+			walk := tds.db.Walk
+			if casted, ok := tds.db.(ethdb.MergedWalker); ok {
+				walk = casted.WalkMerged
+			}
+			n := 0
+			return walk(dbutils.CurrentStateBucket, startkey, 8*len(prefix), func(k, v []byte) (bool, error) {
+				if len(v) == 0 || len(k) != len(prefix)+common.HashLength {
+					return true, nil
+				}
+				item := &storageItem{seckey: common.BytesToHash(k[len(prefix):])}
+				item.value.SetBytes(v)
+				f(item)
+				n++
+				return n < limit, nil
+			})
+		}
+	}
+	resolveKey := func(item *storageItem) error {
+		preimage, err := tds.db.Get(dbutils.PreimagePrefix, item.seckey[:])
+		if err != nil {
+			if errors.Is(err, ethdb.ErrKeyNotFound) {
+				return nil
+			}
+			return err
+		}
+		copy(item.key[:], preimage)
+		return nil
+	}
+	return overlayStorage(underlying, overrides, start, resolveKey, cb, maxResults)
+}
+
+// ForEachStorage enumerates the storage of the contract as the transactions see it: the storage of the state reader
+// (it has to implement StorageIterator), replaced by the writes not yet flushed to the state writer.
+func (sdb *IntraBlockState) ForEachStorage(addr common.Address, start []byte, cb func(key, seckey common.Hash, value uint256.Int) bool, maxResults int) error {
+	sdb.Lock()
+	defer sdb.Unlock()
+
+	so := sdb.getStateObject(addr)
+	if so == nil {
+		return nil
+	}
+	storage, readerStorage := so.dirtyStorage, !so.created
+	if so.fakeStorage != nil {
+		storage, readerStorage = so.fakeStorage, false
+	}
+	overrides := make(map[common.Hash]*storageItem, len(storage))
+	for key, value := range storage {
+		seckey, err := common.HashData(key[:])
+		if err != nil {
+			return err
+		}
+		overrides[seckey] = &storageItem{key: key, seckey: seckey, value: value}
+	}
+
+	var underlying func(limit int, f func(item *storageItem)) error
+	if readerStorage {
+		reader, ok := sdb.stateReader.(StorageIterator)
+		if !ok {
+			return errStorageIteratorNotSupported
+		}
+		underlying = func(limit int, f func(item *storageItem)) error {
+			return reader.ForEachStorage(addr, start, func(key, seckey common.Hash, value uint256.Int) bool {
+				f(&storageItem{key: key, seckey: seckey, value: value})
+				return true
+			}, limit)
+		}
+	}
+	return overlayStorage(underlying, overrides, start, nil, cb, maxResults)
+}
@@ -0,0 +1,233 @@
+package state
+
+import (
+	"github.com/holiman/uint256"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+)
+
+// StoragePromise is the storage item, which was read during the execution in the storage promises mode
+// (see IntraBlockState.SetStoragePromises), but is not loaded from the database yet
+type StoragePromise struct {
+	Address     common.Address
+	Incarnation uint64
+	Key         common.Hash
+}
+
+// StorageBatchReader is implemented by the state readers, which load a number of storage items
+// in one pass faster than one by one
+type StorageBatchReader interface {
+	// ReadStorageBatch returns the values of the storage items, in the order of the promises
+	ReadStorageBatch(promises []StoragePromise) ([][]byte, error)
+}
+
+// ReadRecorder is implemented by the state readers, which record the reads for the block witnesses
+// (see TrieDbState.SetResolveReads). The Record methods record the reads without performing them
+type ReadRecorder interface {
+	ResolveReads() bool
+	SetResolveReads(rr bool)
+	RecordAccountRead(address common.Address) error
+	RecordStorageRead(address common.Address, key *common.Hash) error
+	RecordCodeRead(address common.Address, codeHash common.Hash) error
+	RecordCodeSizeRead(address common.Address, codeHash common.Hash) error
+}
+
+// unrecordedReads keeps the reads performed in the storage promises mode, which the ReadRecorder did not see.
+// They are recorded when the actual execution uses them, so that the recorded reads do not depend on the mode
+type unrecordedReads struct {
+	accounts map[common.Address]struct{}
+	storage  map[common.Address]map[common.Hash]struct{}
+	code     map[common.Address]struct{}
+}
+
+// SetStoragePromises switches the storage promises mode. In this mode the reads of the storage items, which are
+// not cached by the state objects yet, return zero values and are recorded, so that they can be loaded later
+// in one batch by ResolveStoragePromises. The execution in this mode is speculative: the values of the storage
+// may affect the control flow, so the execution has to be reverted and repeated after the resolution.
+// The tracer and the read recording of the state reader are suspended in this mode
+func (sdb *IntraBlockState) SetStoragePromises(enabled bool) {
+	sdb.Lock()
+	defer sdb.Unlock()
+	if sdb.storagePromises == enabled {
+		return
+	}
+	sdb.storagePromises = enabled
+	if !enabled {
+		sdb.tracer, sdb.trace = sdb.suspendedTracer, sdb.suspendedTrace
+		sdb.suspendedTracer, sdb.suspendedTrace = nil, false
+		sdb.resumeRecorder()
+		return
+	}
+	sdb.suspendedTracer, sdb.suspendedTrace = sdb.tracer, sdb.trace
+	sdb.tracer, sdb.trace = nil, false
+	sdb.suspendRecorder()
+}
+
+// do not lock!!!
+func (sdb *IntraBlockState) suspendRecorder() {
+	r, ok := sdb.stateReader.(ReadRecorder)
+	if !ok || !r.ResolveReads() {
+		return
+	}
+	r.SetResolveReads(false)
+	sdb.suspendedRecorder = r
+	if sdb.unrecorded == nil {
+		sdb.unrecorded = &unrecordedReads{
+			accounts: make(map[common.Address]struct{}),
+			storage:  make(map[common.Address]map[common.Hash]struct{}),
+			code:     make(map[common.Address]struct{}),
+		}
+	}
+}
+
+// do not lock!!!
+func (sdb *IntraBlockState) resumeRecorder() {
+	if sdb.suspendedRecorder != nil {
+		sdb.suspendedRecorder.SetResolveReads(true)
+		sdb.suspendedRecorder = nil
+	}
+}
+
+// recorder returns the ReadRecorder, which has to see the reads performed in the storage promises mode
+// do not lock!!!
+func (sdb *IntraBlockState) recorder() ReadRecorder {
+	if sdb.unrecorded == nil || sdb.storagePromises {
+		return nil
+	}
+	r, ok := sdb.stateReader.(ReadRecorder)
+	if !ok || !r.ResolveReads() {
+		return nil
+	}
+	return r
+}
+
+// do not lock!!!
+func (sdb *IntraBlockState) recordAccountRead(addr common.Address) {
+	r := sdb.recorder()
+	if r == nil {
+		return
+	}
+	if _, ok := sdb.unrecorded.accounts[addr]; !ok {
+		return
+	}
+	delete(sdb.unrecorded.accounts, addr)
+	if err := r.RecordAccountRead(addr); err != nil {
+		sdb.setErrorUnsafe(err)
+	}
+}
+
+// do not lock!!!
+func (sdb *IntraBlockState) recordStorageRead(so *stateObject, key *common.Hash) {
+	r := sdb.recorder()
+	if r == nil {
+		return
+	}
+	keys := sdb.unrecorded.storage[so.address]
+	if _, ok := keys[*key]; !ok {
+		return
+	}
+	delete(keys, *key)
+	if err := r.RecordStorageRead(so.address, key); err != nil {
+		so.setError(err)
+	}
+}
+
+// do not lock!!!
+func (sdb *IntraBlockState) recordCodeRead(so *stateObject) {
+	r := sdb.recorder()
+	if r == nil {
+		return
+	}
+	if _, ok := sdb.unrecorded.code[so.address]; !ok {
+		return
+	}
+	delete(sdb.unrecorded.code, so.address)
+	if err := r.RecordCodeRead(so.address, so.data.CodeHash); err != nil {
+		so.setError(err)
+	}
+}
+
+// recordCodeSizeRead keeps the code unrecorded, because the code size reads are recorded separately
+// do not lock!!!
+func (sdb *IntraBlockState) recordCodeSizeRead(so *stateObject) {
+	r := sdb.recorder()
+	if r == nil {
+		return
+	}
+	if _, ok := sdb.unrecorded.code[so.address]; !ok {
+		return
+	}
+	if err := r.RecordCodeSizeRead(so.address, so.data.CodeHash); err != nil {
+		sdb.setErrorUnsafe(err)
+	}
+}
+
+// do not lock!!!
+func (sdb *IntraBlockState) promiseStorage(address common.Address, incarnation uint64, key *common.Hash) {
+	p := StoragePromise{Address: address, Incarnation: incarnation, Key: *key}
+	if _, ok := sdb.promised[p]; ok {
+		return
+	}
+	if sdb.promised == nil {
+		sdb.promised = make(map[StoragePromise]struct{})
+	}
+	sdb.promised[p] = struct{}{}
+	sdb.promises = append(sdb.promises, p)
+}
+
+// ResolveStoragePromises loads the storage items recorded in the storage promises mode (in one batch, if the state
+// reader is a StorageBatchReader) into the state objects, and returns their number. The items of the state objects,
+// which were reverted or recreated since, are skipped and will be read again by the next execution
+func (sdb *IntraBlockState) ResolveStoragePromises() (int, error) {
+	sdb.Lock()
+	defer sdb.Unlock()
+	promises := sdb.promises
+	sdb.promises = nil
+	sdb.promised = nil
+	if len(promises) == 0 {
+		return 0, nil
+	}
+	// The resolved items are recorded when the actual execution reads them
+	if !sdb.storagePromises {
+		sdb.suspendRecorder()
+		defer sdb.resumeRecorder()
+	}
+	var values [][]byte
+	if br, ok := sdb.stateReader.(StorageBatchReader); ok {
+		var err error
+		if values, err = br.ReadStorageBatch(promises); err != nil {
+			return 0, err
+		}
+	} else {
+		values = make([][]byte, len(promises))
+		for i := range promises {
+			enc, err := sdb.stateReader.ReadAccountStorage(promises[i].Address, promises[i].Incarnation, &promises[i].Key)
+			if err != nil {
+				return 0, err
+			}
+			values[i] = enc
+		}
+	}
+	for i, p := range promises {
+		so := sdb.stateObjects[p.Address]
+		if so == nil || so.created || so.data.GetIncarnation() != p.Incarnation {
+			continue
+		}
+		if _, ok := so.originStorage[p.Key]; ok {
+			continue
+		}
+		var value uint256.Int
+		value.SetBytes(values[i])
+		so.originStorage[p.Key] = value
+		so.blockOriginStorage[p.Key] = value
+		if sdb.suspendedRecorder != nil {
+			keys, ok := sdb.unrecorded.storage[p.Address]
+			if !ok {
+				keys = make(map[common.Hash]struct{})
+				sdb.unrecorded.storage[p.Address] = keys
+			}
+			keys[p.Key] = struct{}{}
+		}
+	}
+	return len(promises), nil
+}
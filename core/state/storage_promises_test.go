@@ -0,0 +1,63 @@
+package state
+
+import (
+	"context"
+	"testing"
+
+	"github.com/holiman/uint256"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/core/types/accounts"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+)
+
+func TestStoragePromises(t *testing.T) {
+	db := ethdb.NewMemDatabase()
+	defer db.Close()
+	ctx := context.Background()
+
+	contract := common.HexToAddress("0x1234")
+	keys := []common.Hash{common.HexToHash("0x01"), common.HexToHash("0x02"), common.HexToHash("0x03")}
+	w := NewDbStateWriter(db, db, 0)
+	contractAccount := accounts.NewAccount()
+	contractAccount.Initialised = true
+	contractAccount.Incarnation = FirstContractIncarnation
+	for i, key := range keys[:2] {
+		key := key
+		require.NoError(t, w.WriteAccountStorage(ctx, contract, contractAccount.Incarnation, &key, uint256.NewInt(), uint256.NewInt().SetUint64(uint64(i+1))))
+	}
+	require.NoError(t, w.UpdateAccountData(ctx, contract, &accounts.Account{}, &contractAccount))
+
+	ibs := New(NewDbStateReader(db))
+	var value uint256.Int
+	ibs.SetStoragePromises(true)
+	for i := range keys {
+		ibs.GetState(contract, &keys[i], &value)
+		assert.True(t, value.IsZero(), "the promised values are zero")
+	}
+	// The same item is promised once
+	ibs.GetState(contract, &keys[0], &value)
+	ibs.SetStoragePromises(false)
+
+	resolved, err := ibs.ResolveStoragePromises()
+	require.NoError(t, err)
+	assert.Equal(t, len(keys), resolved)
+	for i := range keys {
+		ibs.GetCommittedState(contract, &keys[i], &value)
+		if i < 2 {
+			assert.Equal(t, uint64(i+1), value.Uint64())
+		} else {
+			assert.True(t, value.IsZero())
+		}
+	}
+
+	// The resolved values are cached, nothing is promised again
+	ibs.SetStoragePromises(true)
+	ibs.GetState(contract, &keys[1], &value)
+	assert.Equal(t, uint64(2), value.Uint64())
+	resolved, err = ibs.ResolveStoragePromises()
+	require.NoError(t, err)
+	assert.Equal(t, 0, resolved)
+}
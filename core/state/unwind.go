@@ -0,0 +1,100 @@
+package state
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+	"github.com/ledgerwatch/turbo-geth/log"
+)
+
+// UnwindToBatched unwinds the state to the block blockNr, like UnwindTo, but instead of accumulating all the changes
+// of a deep reorg in one transaction, it splits the unwind into steps, the changesets of each step taking about
+// IdealBatchSize, and commits the database (which must be a batch) after every step but the last one.
+// Every commit includes the progress record, so an interrupted unwind can be completed later, see ReadUnwindProgress.
+// The changes of the last step are left in the batch to be committed by the caller together with its own changes
+func (tds *TrieDbState) UnwindToBatched(blockNr uint64) error {
+	batch, ok := tds.db.(ethdb.DbWithPendingMutations)
+	if !ok {
+		return fmt.Errorf("batched unwind requires a batch, got %T", tds.db)
+	}
+	return tds.unwindToBatched(batch, blockNr, batch.IdealBatchSize())
+}
+
+func (tds *TrieDbState) unwindToBatched(batch ethdb.DbWithPendingMutations, blockNr uint64, stepSize int) error {
+	steps, err := unwindSteps(batch, tds.blockNr, blockNr, stepSize)
+	if err != nil {
+		return err
+	}
+	for i, step := range steps {
+		if err = tds.UnwindTo(step); err != nil {
+			return fmt.Errorf("unwinding to block %d: %w", step, err)
+		}
+		if i == len(steps)-1 {
+			break
+		}
+		if err = writeUnwindProgress(batch, blockNr, step); err != nil {
+			return err
+		}
+		if _, err = batch.Commit(); err != nil {
+			return fmt.Errorf("committing unwind to block %d: %w", step, err)
+		}
+		log.Info("Unwind progress", "block", step, "target", blockNr)
+	}
+	return batch.Delete(dbutils.UnwindProgressKey, dbutils.UnwindProgressKey)
+}
+
+// unwindSteps splits the unwind from the block from to the block to into the steps, so that the changesets
+// of the blocks unwound in each step take about stepSize bytes. The blocks reached by the steps are returned,
+// the last one is always to
+func unwindSteps(db ethdb.Getter, from, to uint64, stepSize int) ([]uint64, error) {
+	// The changesets of the compacted epochs are walked too, see ethdb.CompactChangeSets
+	sizes := make(map[uint64]int)
+	for _, bucket := range [][]byte{dbutils.AccountChangeSetBucket, dbutils.StorageChangeSetBucket} {
+		if err := ethdb.WalkChangeSets(db, bucket, to+1, func(blockNr uint64, changes []byte) (bool, error) {
+			if blockNr > from {
+				return false, nil
+			}
+			sizes[blockNr] += len(changes)
+			return true, nil
+		}); err != nil {
+			return nil, err
+		}
+	}
+	var steps []uint64
+	size := 0
+	for blockNr := from; blockNr > to; blockNr-- {
+		size += sizes[blockNr]
+		if size >= stepSize && blockNr-1 > to {
+			steps = append(steps, blockNr-1)
+			size = 0
+		}
+	}
+	return append(steps, to), nil
+}
+
+// ReadUnwindProgress returns the target block of the interrupted UnwindToBatched and the block
+// the state has been unwound to. ok is false if there is no unwind to resume.
+// To complete the unwind, UnwindToBatched(target) needs to be called on the state opened at the block reached
+func ReadUnwindProgress(db ethdb.Getter) (target, reached uint64, ok bool, err error) {
+	v, err := db.Get(dbutils.UnwindProgressKey, dbutils.UnwindProgressKey)
+	if err != nil && !errors.Is(err, ethdb.ErrKeyNotFound) {
+		return 0, 0, false, err
+	}
+	if len(v) == 0 {
+		return 0, 0, false, nil
+	}
+	if len(v) != 16 {
+		return 0, 0, false, fmt.Errorf("unwind progress of unexpected length %d", len(v))
+	}
+	return binary.BigEndian.Uint64(v), binary.BigEndian.Uint64(v[8:]), true, nil
+}
+
+func writeUnwindProgress(db ethdb.Putter, target, reached uint64) error {
+	v := make([]byte, 16)
+	binary.BigEndian.PutUint64(v, target)
+	binary.BigEndian.PutUint64(v[8:], reached)
+	return db.Put(dbutils.UnwindProgressKey, dbutils.UnwindProgressKey, v)
+}
@@ -0,0 +1,209 @@
+package state
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/holiman/uint256"
+	"github.com/ledgerwatch/bolt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/common/changeset"
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+	"github.com/ledgerwatch/turbo-geth/core/types/accounts"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+)
+
+var errCommitFailed = errors.New("commit failed")
+
+// failingBatch fails the commit with the given number, simulating a crash in the middle of the unwind
+type failingBatch struct {
+	ethdb.DbWithPendingMutations
+	commits int
+	failAt  int
+}
+
+func (b *failingBatch) Commit() (uint64, error) {
+	b.commits++
+	if b.commits == b.failAt {
+		return 0, errCommitFailed
+	}
+	return b.DbWithPendingMutations.Commit()
+}
+
+// KV lets the trie loader read the batch, like it reads the wrapped mutation
+func (b *failingBatch) KV() *bolt.DB {
+	return b.DbWithPendingMutations.(ethdb.HasKV).KV()
+}
+
+// generateBlocksForUnwind writes the blocks 1..blocks, the balance of the contract matching the block number,
+// and every block adding a storage item. The state roots of the blocks are returned
+func generateBlocksForUnwind(t *testing.T, batch ethdb.DbWithPendingMutations, addr common.Address, blocks uint64) []common.Hash {
+	tds := NewTrieDbState(common.Hash{}, batch, 1)
+	ctx := context.Background()
+	acc1 := accounts.NewAccount()
+	acc := &acc1
+	acc.Initialised = true
+	roots := make([]common.Hash, blocks+1)
+	for blockNumber := uint64(1); blockNumber <= blocks; blockNumber++ {
+		tds.StartNewBuffer()
+		newAcc := acc.SelfCopy()
+		newAcc.Balance.SetUint64(blockNumber)
+		tds.SetBlockNr(blockNumber)
+		txWriter := tds.TrieStateWriter()
+		blockWriter := tds.DbStateWriter()
+		if blockNumber == 1 {
+			require.NoError(t, txWriter.CreateContract(addr))
+			newAcc.Incarnation = FirstContractIncarnation
+		}
+		var oldValue, newValue uint256.Int
+		newValue.SetUint64(blockNumber)
+		var location common.Hash
+		location.SetBytes(big.NewInt(int64(blockNumber)).Bytes())
+		require.NoError(t, txWriter.WriteAccountStorage(ctx, addr, newAcc.Incarnation, &location, &oldValue, &newValue))
+		require.NoError(t, txWriter.UpdateAccountData(ctx, addr, acc, newAcc))
+		_, err := tds.ComputeTrieRoots()
+		require.NoError(t, err)
+		if blockNumber == 1 {
+			require.NoError(t, blockWriter.CreateContract(addr))
+		}
+		require.NoError(t, blockWriter.WriteAccountStorage(ctx, addr, newAcc.Incarnation, &location, &oldValue, &newValue))
+		require.NoError(t, blockWriter.UpdateAccountData(ctx, addr, acc, newAcc))
+		require.NoError(t, blockWriter.WriteChangeSets())
+		require.NoError(t, blockWriter.WriteHistory())
+		_, err = batch.Commit()
+		require.NoError(t, err)
+		roots[blockNumber] = tds.LastRoot()
+		acc = newAcc
+	}
+	return roots
+}
+
+func checkUnwoundState(t *testing.T, tds *TrieDbState, addr common.Address, blockNr uint64) {
+	a, err := tds.ReadAccountData(addr)
+	require.NoError(t, err)
+	assert.Equal(t, blockNr, a.Balance.Uint64())
+	for l := uint64(1); l <= 2*blockNr; l++ {
+		var location common.Hash
+		location.SetBytes(big.NewInt(int64(l)).Bytes())
+		enc, err := tds.ReadAccountStorage(addr, a.Incarnation, &location)
+		require.NoError(t, err)
+		if l <= blockNr {
+			assert.NotEmpty(t, enc, "location %d", l)
+		} else {
+			assert.Empty(t, enc, "location %d", l)
+		}
+	}
+}
+
+func TestUnwindSteps(t *testing.T) {
+	db := ethdb.NewMemDatabase()
+	defer db.Close()
+	generateBlocksForUnwind(t, db.NewBatch(), common.HexToAddress("0x1234567890"), 20)
+
+	steps, err := unwindSteps(db, 20, 10, 1)
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{19, 18, 17, 16, 15, 14, 13, 12, 11, 10}, steps)
+
+	steps, err = unwindSteps(db, 20, 10, 1<<20)
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{10}, steps)
+
+	steps, err = unwindSteps(db, 10, 10, 1)
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{10}, steps)
+}
+
+func TestUnwindBatched(t *testing.T) {
+	db := ethdb.NewMemDatabase()
+	defer db.Close()
+	batch := db.NewBatch()
+	addr := common.HexToAddress("0x1234567890")
+	roots := generateBlocksForUnwind(t, batch, addr, 100)
+
+	tds := NewTrieDbState(roots[100], batch, 100)
+	require.NoError(t, tds.unwindToBatched(batch, 50, 1))
+	// The last step is left for the caller to commit
+	_, _, ok, err := ReadUnwindProgress(db)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	_, err = batch.Commit()
+	require.NoError(t, err)
+
+	_, _, ok, err = ReadUnwindProgress(db)
+	require.NoError(t, err)
+	assert.False(t, ok)
+	assert.Equal(t, roots[50], tds.LastRoot())
+	checkUnwoundState(t, NewTrieDbState(roots[50], db, 50), addr, 50)
+}
+
+func TestUnwindBatchedResume(t *testing.T) {
+	db := ethdb.NewMemDatabase()
+	defer db.Close()
+	addr := common.HexToAddress("0x1234567890")
+	roots := generateBlocksForUnwind(t, db.NewBatch(), addr, 100)
+
+	// Reorg fails on the third commit, after the state has been unwound to the block 98 and committed
+	batch := &failingBatch{DbWithPendingMutations: db.NewBatch(), failAt: 3}
+	tds := NewTrieDbState(roots[100], batch, 100)
+	err := tds.unwindToBatched(batch, 50, 1)
+	assert.True(t, errors.Is(err, errCommitFailed))
+	batch.Rollback()
+
+	target, reached, ok, err := ReadUnwindProgress(db)
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, uint64(50), target)
+	assert.Equal(t, uint64(98), reached)
+	checkUnwoundState(t, NewTrieDbState(roots[reached], db, reached), addr, reached)
+
+	// Resume the unwind from the state committed last
+	resumeBatch := db.NewBatch()
+	tds = NewTrieDbState(roots[reached], resumeBatch, reached)
+	require.NoError(t, tds.UnwindToBatched(target))
+	_, err = resumeBatch.Commit()
+	require.NoError(t, err)
+
+	_, _, ok, err = ReadUnwindProgress(db)
+	require.NoError(t, err)
+	assert.False(t, ok)
+	assert.Equal(t, roots[50], tds.LastRoot())
+	checkUnwoundState(t, NewTrieDbState(roots[50], db, 50), addr, 50)
+}
+
+func TestUnwindCompactedChangeSets(t *testing.T) {
+	db := ethdb.NewMemDatabase()
+	defer db.Close()
+	addr := common.HexToAddress("0x1234567890")
+	batch := db.NewBatch()
+	roots := generateBlocksForUnwind(t, batch, addr, changeset.EpochSize+50)
+	epochs, err := ethdb.CompactChangeSets(db, changeset.EpochSize)
+	require.NoError(t, err)
+	require.Equal(t, 2, epochs)
+
+	// The unwind crosses the end of the compacted epoch
+	unwindTo := changeset.EpochSize - 10
+	steps, err := unwindSteps(db, changeset.EpochSize+50, unwindTo, 1)
+	require.NoError(t, err)
+	assert.Len(t, steps, 60)
+	tds := NewTrieDbState(roots[changeset.EpochSize+50], batch, changeset.EpochSize+50)
+	require.NoError(t, tds.UnwindTo(unwindTo))
+	_, err = batch.Commit()
+	require.NoError(t, err)
+	assert.Equal(t, roots[unwindTo], tds.LastRoot())
+	checkUnwoundState(t, NewTrieDbState(roots[unwindTo], db, unwindTo), addr, unwindTo)
+
+	// The changesets of the unwound blocks are removed from the epoch too, the ones of the blocks before are kept
+	for _, csBucket := range [][]byte{dbutils.AccountChangeSetBucket, dbutils.StorageChangeSetBucket} {
+		var last uint64
+		require.NoError(t, ethdb.WalkChangeSets(db, csBucket, 0, func(blockNr uint64, _ []byte) (bool, error) {
+			last = blockNr
+			return true, nil
+		}))
+		assert.Equal(t, unwindTo, last, string(csBucket))
+	}
+}
@@ -131,8 +131,14 @@ func (p *StateProcessor) PreProcess(block *types.Block, ibs *state.IntraBlockSta
 	if p.config.DAOForkSupport && p.config.DAOForkBlock != nil && p.config.DAOForkBlock.Cmp(block.Number()) == 0 {
 		misc.ApplyDAOHardFork(ibs)
 	}
-	// Iterate over and process the individual transactions
 	tds.StartNewBuffer()
+	if tds.ResolveReads() {
+		// Include the state read by the header verification of the engine into the witness
+		if err = consensus.ReadWitnessState(p.engine, p.bc, header, tds); err != nil {
+			return
+		}
+	}
+	// Iterate over and process the individual transactions
 	for i, tx := range block.Transactions() {
 		txHash := tx.Hash()
 		ibs.Prepare(txHash, block.Hash(), i)
@@ -221,6 +227,11 @@ func ApplyTransaction(config *params.ChainConfig, bc ChainContext, author *commo
 	ctx := config.WithEIPsFlags(context.Background(), header.Number)
 	// Create a new context to be used in the EVM environment
 	context := NewEVMContext(msg, header, bc, author)
+	if cfg.BatchStorageReads {
+		if err = batchStorageReads(config, context, statedb, msg, gp, cfg); err != nil {
+			return nil, err
+		}
+	}
 	// Create a new environment which holds all relevant information
 	// about the transaction and calling mechanisms.
 	vmenv := vm.NewEVM(context, statedb, config, cfg)
@@ -250,3 +261,33 @@ func ApplyTransaction(config *params.ChainConfig, bc ChainContext, author *commo
 	receipt.Bloom = types.CreateBloom(types.Receipts{receipt})
 	return receipt, err
 }
+
+// maxStoragePromiseRounds limits the number of the speculative executions of a transaction,
+// after which the rest of its storage is read one item at a time
+const maxStoragePromiseRounds = 4
+
+// batchStorageReads executes the message speculatively in the storage promises mode of the state
+// (see state.IntraBlockState.SetStoragePromises) and loads the storage read by it in batches, until
+// the execution does not read anything new. The changes of the speculative executions are reverted,
+// and their reads are neither traced nor recorded for the block witness
+func batchStorageReads(config *params.ChainConfig, context vm.Context, statedb *state.IntraBlockState, msg Message, gp *GasPool, cfg vm.Config) error {
+	cfg.Debug = false
+	cfg.Tracer = nil
+	for round := 0; round < maxStoragePromiseRounds; round++ {
+		snapshot := statedb.Snapshot()
+		gas := *gp
+		statedb.SetStoragePromises(true)
+		// The invalid transactions are reported by the actual execution
+		_, _ = ApplyMessage(vm.NewEVM(context, statedb, config, cfg), msg, &gas)
+		statedb.SetStoragePromises(false)
+		statedb.RevertToSnapshot(snapshot)
+		resolved, err := statedb.ResolveStoragePromises()
+		if err != nil {
+			return err
+		}
+		if resolved == 0 {
+			return nil
+		}
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package core
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/consensus"
+	"github.com/ledgerwatch/turbo-geth/consensus/ethash"
+	"github.com/ledgerwatch/turbo-geth/core/state"
+	"github.com/ledgerwatch/turbo-geth/core/types"
+	"github.com/ledgerwatch/turbo-geth/core/vm"
+	"github.com/ledgerwatch/turbo-geth/crypto"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+	"github.com/ledgerwatch/turbo-geth/params"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// signerEngine reads the list of signers from the contract storage during the header verification
+type signerEngine struct {
+	consensus.Engine
+	contract common.Address
+	slot     common.Hash
+}
+
+func (e *signerEngine) ReadWitnessState(chain consensus.ChainReader, header *types.Header, reader state.StateReader) error {
+	acc, err := reader.ReadAccountData(e.contract)
+	if err != nil {
+		return err
+	}
+	_, err = reader.ReadAccountStorage(e.contract, acc.Incarnation, &e.slot)
+	return err
+}
+
+func TestWitnessStateReader(t *testing.T) {
+	var (
+		db       = ethdb.NewMemDatabase()
+		contract = common.HexToAddress("0x0000000000000000000000000000000000001000")
+		other    = common.HexToAddress("0x0000000000000000000000000000000000002000")
+		// The hashes of the neighbours share the first nibble with the contract and the other account,
+		// otherwise their leaves hang off the root branch and are a part of every witness
+		neighbours = []common.Address{
+			common.HexToAddress("0x000000000000000000000000000000000000300a"),
+			common.HexToAddress("0x0000000000000000000000000000000000003003"),
+		}
+		slot    = common.HexToHash("0x01")
+		signers = common.HexToHash("0x0102030405")
+		gspec   = &Genesis{
+			Config: params.TestChainConfig,
+			Alloc: GenesisAlloc{
+				contract:      {Balance: big.NewInt(1), Code: []byte{0x00}, Storage: map[common.Hash]common.Hash{slot: signers}},
+				other:         {Balance: big.NewInt(2)},
+				neighbours[0]: {Balance: big.NewInt(3)},
+				neighbours[1]: {Balance: big.NewInt(4)},
+			},
+		}
+		genesis = gspec.MustCommit(db)
+	)
+	blockchain, err := NewBlockChain(db, nil, gspec.Config, ethash.NewFaker(), vm.Config{}, nil, nil)
+	require.NoError(t, err)
+	defer blockchain.Stop()
+	blocks, _ := GenerateChain(blockchain.WithContext(context.Background(), big.NewInt(1)), gspec.Config, genesis, ethash.NewFaker(), db.MemCopy(), 1, nil)
+
+	witness := func(engine consensus.Engine) *state.Stateless {
+		tds := state.NewTrieDbState(genesis.Root(), db, genesis.NumberU64())
+		tds.SetResolveReads(true)
+		_, _, _, root, err := NewStateProcessor(gspec.Config, blockchain, engine).PreProcess(blocks[0], state.New(tds), tds, vm.Config{})
+		require.NoError(t, err)
+		require.Equal(t, blocks[0].Root(), root)
+		w, err := tds.ExtractWitness(false, false /* is binary */)
+		require.NoError(t, err)
+		s, err := state.NewStateless(genesis.Root(), w, genesis.NumberU64(), false, false /* is binary */)
+		require.NoError(t, err)
+		return s
+	}
+
+	// The reads of the engine are not a part of the witness of the empty block by default
+	s := witness(ethash.NewFaker())
+	_, err = s.ReadAccountData(contract)
+	assert.Error(t, err)
+
+	s = witness(&signerEngine{Engine: ethash.NewFaker(), contract: contract, slot: slot})
+	acc, err := s.ReadAccountData(contract)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), acc.Balance.Uint64())
+	v, err := s.ReadAccountStorage(contract, acc.Incarnation, &slot)
+	require.NoError(t, err)
+	assert.Equal(t, signers.Bytes(), common.LeftPadBytes(v, 32))
+	_, err = s.ReadAccountData(other)
+	assert.Error(t, err)
+}
+
+// readTracer collects the accounts read by the intra block state
+type readTracer struct {
+	reads map[common.Address]struct{}
+}
+
+func (rt *readTracer) CaptureAccountRead(account common.Address) error {
+	rt.reads[account] = struct{}{}
+	return nil
+}
+
+func (rt *readTracer) CaptureAccountWrite(account common.Address) error {
+	return nil
+}
+
+func TestBatchStorageReads(t *testing.T) {
+	var (
+		db       = ethdb.NewMemDatabase()
+		key, _   = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		sender   = crypto.PubkeyToAddress(key.PublicKey)
+		coinbase = common.HexToAddress("0x0000000000000000000000000000000000003000")
+		contract = common.HexToAddress("0x0000000000000000000000000000000000001000")
+		// Reads the slot 3 if the slot 0 is set, and the slot 1 and the balance of 0x2000 otherwise.
+		// The speculative execution, which sees the zero value of the slot 0, reads both
+		code = []byte{
+			0x60, 0x00, 0x54, 0x60, 0x0f, 0x57, // PUSH1 0 SLOAD PUSH1 15 JUMPI
+			0x60, 0x01, 0x54, 0x50, 0x61, 0x20, 0x00, 0x31, 0x00, // PUSH1 1 SLOAD POP PUSH2 0x2000 BALANCE STOP
+			0x5b, 0x60, 0x03, 0x54, 0x00, // JUMPDEST PUSH1 3 SLOAD STOP
+		}
+		gspec = &Genesis{
+			Config: params.TestChainConfig,
+			Alloc: GenesisAlloc{
+				sender: {Balance: big.NewInt(1000000000)},
+				contract: {Balance: big.NewInt(0), Code: code, Storage: map[common.Hash]common.Hash{
+					common.HexToHash("0x00"): common.HexToHash("0x01"),
+					common.HexToHash("0x01"): common.HexToHash("0x05"),
+					common.HexToHash("0x03"): common.HexToHash("0x07"),
+				}},
+			},
+		}
+		genesis = gspec.MustCommit(db)
+		header  = &types.Header{ParentHash: genesis.Hash(), Number: big.NewInt(1), GasLimit: genesis.GasLimit(), Difficulty: big.NewInt(1), Time: genesis.Time() + 10}
+		signer  = types.MakeSigner(gspec.Config, header.Number)
+	)
+	tx, err := types.SignTx(types.NewTransaction(0, contract, big.NewInt(0), 100000, big.NewInt(1), nil), signer, key)
+	require.NoError(t, err)
+
+	type result struct {
+		receipt *types.Receipt
+		traced  map[common.Address]struct{}
+		touches [2][][]byte
+	}
+	apply := func(batchStorageReads bool) result {
+		tds := state.NewTrieDbState(genesis.Root(), db, genesis.NumberU64())
+		tds.SetResolveReads(true)
+		tds.StartNewBuffer()
+		ibs := state.New(tds)
+		tracer := &readTracer{reads: make(map[common.Address]struct{})}
+		ibs.SetTracer(tracer)
+		ibs.Prepare(tx.Hash(), common.Hash{}, 0)
+		var usedGas uint64
+		receipt, err := ApplyTransaction(gspec.Config, nil, &coinbase, new(GasPool).AddGas(header.GasLimit), ibs, tds.TrieStateWriter(), header, tx, &usedGas, vm.Config{BatchStorageReads: batchStorageReads})
+		require.NoError(t, err)
+		_, err = tds.ResolveStateTrie(false, false)
+		require.NoError(t, err)
+		accountTouches, storageTouches := tds.ExtractTouches()
+		return result{receipt: receipt, traced: tracer.reads, touches: [2][][]byte{accountTouches, storageTouches}}
+	}
+
+	plain, batched := apply(false), apply(true)
+	assert.Equal(t, plain.receipt, batched.receipt)
+	assert.Equal(t, plain.traced, batched.traced)
+	assert.Equal(t, plain.touches, batched.touches)
+}
@@ -1,6 +1,7 @@
 package accounts
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"math/bits"
@@ -431,6 +432,14 @@ func (a *Account) Reset() {
 	copy(a.CodeHash[:], emptyCodeHash[:])
 }
 
+// storageFieldsMask is the set of the fields known to EncodeForStorage. The other bits of the field set are reserved
+// for the future changes of the encoding, so the decoder rejects them instead of misreading the account
+const storageFieldsMask = 1 | 2 | 4 | 8
+
+// ErrUnknownStorageFields is returned by DecodeForStorage for the encoding of the account which has the reserved bits
+// of the field set, or the bytes after the known fields, i.e. the encoding written by a different version of the format
+var ErrUnknownStorageFields = errors.New("unknown fields in the account storage encoding")
+
 func (a *Account) DecodeForStorage(enc []byte) error {
 	a.Reset()
 
@@ -441,13 +450,14 @@ func (a *Account) DecodeForStorage(enc []byte) error {
 	var fieldSet = enc[0]
 	var pos = 1
 
-	if fieldSet&1 > 0 {
-		decodeLength := int(enc[pos])
+	if fieldSet&^storageFieldsMask != 0 {
+		return fmt.Errorf("%w: field set %08b", ErrUnknownStorageFields, fieldSet)
+	}
 
-		if len(enc) < pos+decodeLength+1 {
-			return fmt.Errorf(
-				"malformed CBOR for Account.Nonce: %s, Length %d",
-				enc[pos+1:], decodeLength)
+	if fieldSet&1 > 0 {
+		decodeLength, err := decodeFieldLengthForStorage(enc, pos, "Nonce", 8)
+		if err != nil {
+			return err
 		}
 
 		a.Nonce = bytesToUint64(enc[pos+1 : pos+decodeLength+1])
@@ -455,12 +465,9 @@ func (a *Account) DecodeForStorage(enc []byte) error {
 	}
 
 	if fieldSet&2 > 0 {
-		decodeLength := int(enc[pos])
-
-		if len(enc) < pos+decodeLength+1 {
-			return fmt.Errorf(
-				"malformed CBOR for Account.Nonce: %s, Length %d",
-				enc[pos+1:], decodeLength)
+		decodeLength, err := decodeFieldLengthForStorage(enc, pos, "Balance", 32)
+		if err != nil {
+			return err
 		}
 
 		a.Balance.SetBytes(enc[pos+1 : pos+decodeLength+1])
@@ -468,12 +475,9 @@ func (a *Account) DecodeForStorage(enc []byte) error {
 	}
 
 	if fieldSet&4 > 0 {
-		decodeLength := int(enc[pos])
-
-		if len(enc) < pos+decodeLength+1 {
-			return fmt.Errorf(
-				"malformed CBOR for Account.Incarnation: %s, Length %d",
-				enc[pos+1:], decodeLength)
+		decodeLength, err := decodeFieldLengthForStorage(enc, pos, "Incarnation", 8)
+		if err != nil {
+			return err
 		}
 
 		a.Incarnation = bytesToUint64(enc[pos+1 : pos+decodeLength+1])
@@ -481,26 +485,80 @@ func (a *Account) DecodeForStorage(enc []byte) error {
 	}
 
 	if fieldSet&8 > 0 {
-		decodeLength := int(enc[pos])
+		decodeLength, err := decodeFieldLengthForStorage(enc, pos, "CodeHash", 32)
+		if err != nil {
+			return err
+		}
 
 		if decodeLength != 32 {
 			return fmt.Errorf("codehash should be 32 bytes long, got %d instead",
 				decodeLength)
 		}
 
-		if len(enc) < pos+decodeLength+1 {
-			return fmt.Errorf(
-				"malformed CBOR for Account.CodeHash: %s, Length %d",
-				enc[pos+1:], decodeLength)
-		}
-
 		a.CodeHash.SetBytes(enc[pos+1 : pos+decodeLength+1])
 		pos += decodeLength + 1
 	}
 
+	if pos < len(enc) {
+		return fmt.Errorf("%w: %d bytes after the field set %08b", ErrUnknownStorageFields, len(enc)-pos, fieldSet)
+	}
 	return nil
 }
 
+// DecodeBalanceNonceForStorage decodes only the nonce and the balance of the account encoded by EncodeForStorage,
+// the fields following them (incarnation, code hash) are not parsed. The balance is set to zero for the empty encoding
+func DecodeBalanceNonceForStorage(enc []byte, balance *uint256.Int) (nonce uint64, err error) {
+	balance.Clear()
+	if len(enc) == 0 {
+		return 0, nil
+	}
+
+	var fieldSet = enc[0]
+	var pos = 1
+
+	if fieldSet&^storageFieldsMask != 0 {
+		return 0, fmt.Errorf("%w: field set %08b", ErrUnknownStorageFields, fieldSet)
+	}
+
+	if fieldSet&1 > 0 {
+		decodeLength, err := decodeFieldLengthForStorage(enc, pos, "Nonce", 8)
+		if err != nil {
+			return 0, err
+		}
+
+		nonce = bytesToUint64(enc[pos+1 : pos+decodeLength+1])
+		pos += decodeLength + 1
+	}
+
+	if fieldSet&2 > 0 {
+		decodeLength, err := decodeFieldLengthForStorage(enc, pos, "Balance", 32)
+		if err != nil {
+			return 0, err
+		}
+
+		balance.SetBytes(enc[pos+1 : pos+decodeLength+1])
+	}
+
+	return nonce, nil
+}
+
+// decodeFieldLengthForStorage reads the length of the field at pos, and checks that the field fits into enc
+func decodeFieldLengthForStorage(enc []byte, pos int, field string, maxLength int) (int, error) {
+	if len(enc) <= pos {
+		return 0, fmt.Errorf("malformed CBOR for Account.%s: missing length", field)
+	}
+	decodeLength := int(enc[pos])
+	if decodeLength > maxLength {
+		return 0, fmt.Errorf("malformed CBOR for Account.%s: Length %d is above %d", field, decodeLength, maxLength)
+	}
+	if len(enc) < pos+decodeLength+1 {
+		return 0, fmt.Errorf(
+			"malformed CBOR for Account.%s: %x, Length %d",
+			field, enc[pos+1:], decodeLength)
+	}
+	return decodeLength, nil
+}
+
 func (a *Account) SelfCopy() *Account {
 	newAcc := NewAccount()
 	newAcc.Copy(a)
@@ -394,3 +394,34 @@ func BenchmarkIsEmptyRoot(b *testing.B) {
 
 	fmt.Fprint(ioutil.Discard, isEmpty)
 }
+
+func BenchmarkDecodingBalanceNonce(b *testing.B) {
+	acc := &Account{
+		Initialised: true,
+		Nonce:       2,
+		Balance:     *new(uint256.Int).SetUint64(1000),
+		Incarnation: 1,
+		CodeHash:    common.BytesToHash(crypto.Keccak256([]byte{1, 2, 3})),
+	}
+	enc := make([]byte, acc.EncodingLengthForStorage())
+	acc.EncodeForStorage(enc)
+
+	b.Run("Account", func(b *testing.B) {
+		b.ReportAllocs()
+		var decoded Account
+		for i := 0; i < b.N; i++ {
+			if err := decoded.DecodeForStorage(enc); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("BalanceNonce", func(b *testing.B) {
+		b.ReportAllocs()
+		var balance uint256.Int
+		for i := 0; i < b.N; i++ {
+			if _, err := DecodeBalanceNonceForStorage(enc, &balance); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
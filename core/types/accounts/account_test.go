@@ -1,6 +1,9 @@
 package accounts
 
 import (
+	"bytes"
+	"errors"
+	"math/rand"
 	"testing"
 
 	"github.com/holiman/uint256"
@@ -192,3 +195,150 @@ func isAccountsEqual(t *testing.T, src, dst Account) {
 		t.Fatal("cant decode the account Version", src.Incarnation, dst.Incarnation)
 	}
 }
+
+func randomAccount(rnd *rand.Rand) Account {
+	a := NewAccount()
+	a.Initialised = true
+	if rnd.Intn(2) == 0 {
+		a.Nonce = rnd.Uint64() >> uint(rnd.Intn(64))
+	}
+	balance := make([]byte, rnd.Intn(33))
+	rnd.Read(balance)
+	a.Balance.SetBytes(balance)
+	if rnd.Intn(2) == 0 {
+		a.Incarnation = rnd.Uint64() >> uint(rnd.Intn(64))
+	}
+	if rnd.Intn(2) == 0 {
+		rnd.Read(a.Root[:])
+	}
+	if rnd.Intn(2) == 0 {
+		rnd.Read(a.CodeHash[:])
+	}
+	return a
+}
+
+func TestAccountEncodingRoundTrip(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 10000; i++ {
+		a := randomAccount(rnd)
+
+		encodedAccount := make([]byte, a.EncodingLengthForStorage())
+		a.EncodeForStorage(encodedAccount)
+		var decodedAccount Account
+		if err := decodedAccount.DecodeForStorage(encodedAccount); err != nil {
+			t.Fatal("cant decode the account", err, encodedAccount)
+		}
+		isAccountsEqual(t, a, decodedAccount)
+		var balance uint256.Int
+		nonce, err := DecodeBalanceNonceForStorage(encodedAccount, &balance)
+		if err != nil {
+			t.Fatal("cant decode the balance and the nonce", err, encodedAccount)
+		}
+		if nonce != a.Nonce || !balance.Eq(&a.Balance) {
+			t.Fatal("cant decode the balance and the nonce", a.Nonce, nonce, a.Balance.Uint64(), balance.Uint64())
+		}
+
+		// The encoding for hashing does not include the incarnation
+		encodedAccount = make([]byte, a.EncodingLengthForHashing())
+		a.EncodeForHashing(encodedAccount)
+		decodedAccount = Account{}
+		if err := decodedAccount.DecodeForHashing(encodedAccount); err != nil {
+			t.Fatal("cant decode the account", err, encodedAccount)
+		}
+		decodedAccount.Incarnation = a.Incarnation
+		isAccountsEqual(t, a, decodedAccount)
+		if decodedAccount.Root != a.Root {
+			t.Fatal("cant decode the account Root", a.Root, decodedAccount.Root)
+		}
+	}
+}
+
+func TestDecodeForStorageMalformed(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 10000; i++ {
+		a := randomAccount(rnd)
+		encodedAccount := make([]byte, a.EncodingLengthForStorage())
+		a.EncodeForStorage(encodedAccount)
+		if encodedAccount[0] == 0 {
+			continue
+		}
+		// Every truncation of the encoding misses some of the fields
+		for l := 1; l < len(encodedAccount); l++ {
+			var decodedAccount Account
+			if err := decodedAccount.DecodeForStorage(encodedAccount[:l]); err == nil {
+				t.Fatalf("truncated encoding %x is decoded", encodedAccount[:l])
+			}
+		}
+	}
+
+	// Arbitrary input must not make the decoder panic
+	for i := 0; i < 10000; i++ {
+		enc := make([]byte, rnd.Intn(64))
+		rnd.Read(enc)
+		var decodedAccount Account
+		_ = decodedAccount.DecodeForStorage(enc)
+	}
+
+	var decodedAccount Account
+	if err := decodedAccount.DecodeForStorage([]byte{0x10}); err == nil {
+		t.Fatal("reserved bits of the field set must be rejected")
+	}
+}
+
+func TestDecodeForStorageFormat(t *testing.T) {
+	codeHash := crypto.Keccak256Hash([]byte{1, 2, 3})
+	for _, tt := range []struct {
+		name        string
+		enc         []byte
+		nonce       uint64
+		balance     uint64
+		incarnation uint64
+		codeHash    common.Hash
+		unknown     bool
+	}{
+		// The encodings written by the existing databases
+		{name: "empty", enc: []byte{}, codeHash: emptyCodeHash},
+		{name: "no fields", enc: []byte{0x00}, codeHash: emptyCodeHash},
+		{name: "nonce", enc: []byte{0x01, 1, 0x05}, nonce: 5, codeHash: emptyCodeHash},
+		{name: "balance", enc: []byte{0x02, 2, 0x03, 0xe8}, balance: 1000, codeHash: emptyCodeHash},
+		{name: "nonce and incarnation", enc: []byte{0x05, 1, 0x02, 1, 0x04}, nonce: 2, incarnation: 4, codeHash: emptyCodeHash},
+		{name: "all fields", enc: append([]byte{0x0f, 1, 0x02, 2, 0x03, 0xe8, 1, 0x04, 32}, codeHash[:]...),
+			nonce: 2, balance: 1000, incarnation: 4, codeHash: codeHash},
+		// The encodings of the other versions of the format
+		{name: "storage size bit", enc: []byte{0x11, 1, 0x05, 1, 0x20}, unknown: true},
+		{name: "reserved bit only", enc: []byte{0x20}, unknown: true},
+		{name: "highest bit", enc: []byte{0x82, 2, 0x03, 0xe8}, unknown: true},
+		{name: "all bits", enc: []byte{0xff}, unknown: true},
+		{name: "trailing bytes", enc: []byte{0x01, 1, 0x05, 0x00}, unknown: true},
+		{name: "trailing field", enc: append([]byte{0x0f, 1, 0x02, 2, 0x03, 0xe8, 1, 0x04, 32}, append(codeHash[:], 1, 0x20)...), unknown: true},
+	} {
+		var a Account
+		err := a.DecodeForStorage(tt.enc)
+		if tt.unknown {
+			if !errors.Is(err, ErrUnknownStorageFields) {
+				t.Errorf("%s: expected ErrUnknownStorageFields, got %v", tt.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if a.Nonce != tt.nonce || a.Balance.Uint64() != tt.balance || a.Incarnation != tt.incarnation || a.CodeHash != tt.codeHash {
+			t.Errorf("%s: decoded %+v", tt.name, a)
+		}
+		// The existing encodings are written the same way
+		if len(tt.enc) > 0 {
+			enc := make([]byte, a.EncodingLengthForStorage())
+			a.EncodeForStorage(enc)
+			if !bytes.Equal(enc, tt.enc) {
+				t.Errorf("%s: encoded %x, expected %x", tt.name, enc, tt.enc)
+			}
+		}
+	}
+
+	var balance uint256.Int
+	if _, err := DecodeBalanceNonceForStorage([]byte{0x82, 2, 0x03, 0xe8}, &balance); !errors.Is(err, ErrUnknownStorageFields) {
+		t.Errorf("expected ErrUnknownStorageFields for the reserved bit, got %v", err)
+	}
+}
@@ -0,0 +1,174 @@
+package types
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/rlp"
+)
+
+// receiptsDictionaryFormat is the first byte of the receipts encoded with the dictionary. The receipts
+// of the storage encoding (see ReceiptForStorage) are an RLP list, which starts with 0xc0 or above
+const receiptsDictionaryFormat = 0x01
+
+// ReceiptsDictionary is the dictionary of the log addresses and topics, with which the receipts of the blocks
+// of one epoch are stored (see EncodeReceiptsWithDictionary), so that the repeated items take a few bytes each.
+// The dictionary is append-only, so the receipts, which have been encoded with it, stay valid
+type ReceiptsDictionary struct {
+	Addresses []common.Address
+	Topics    []common.Hash
+
+	// The indices are only needed to encode the receipts, so they are built on the first encoding
+	addressIdx map[common.Address]uint64
+	topicIdx   map[common.Hash]uint64
+}
+
+type receiptsDictionaryRLP struct {
+	Addresses []common.Address
+	Topics    []common.Hash
+}
+
+// dictStoredReceiptRLP is the storedReceiptRLP with the logs referring to the dictionary
+type dictStoredReceiptRLP struct {
+	PostStateOrStatus []byte
+	CumulativeGasUsed uint64
+	Logs              []dictStoredLogRLP
+}
+
+type dictStoredLogRLP struct {
+	Address uint64
+	Topics  []uint64
+	Data    []byte
+}
+
+// NewReceiptsDictionary creates the empty dictionary
+func NewReceiptsDictionary() *ReceiptsDictionary {
+	return &ReceiptsDictionary{
+		addressIdx: make(map[common.Address]uint64),
+		topicIdx:   make(map[common.Hash]uint64),
+	}
+}
+
+// DecodeReceiptsDictionary decodes the dictionary written by Bytes
+func DecodeReceiptsDictionary(b []byte) (*ReceiptsDictionary, error) {
+	var dec receiptsDictionaryRLP
+	if err := rlp.DecodeBytes(b, &dec); err != nil {
+		return nil, fmt.Errorf("decoding receipts dictionary: %w", err)
+	}
+	return &ReceiptsDictionary{Addresses: dec.Addresses, Topics: dec.Topics}, nil
+}
+
+// index builds the indices of the decoded dictionary
+func (d *ReceiptsDictionary) index() {
+	if d.addressIdx != nil {
+		return
+	}
+	d.addressIdx = make(map[common.Address]uint64, len(d.Addresses))
+	for i, a := range d.Addresses {
+		d.addressIdx[a] = uint64(i)
+	}
+	d.topicIdx = make(map[common.Hash]uint64, len(d.Topics))
+	for i, t := range d.Topics {
+		d.topicIdx[t] = uint64(i)
+	}
+}
+
+// Bytes encodes the dictionary
+func (d *ReceiptsDictionary) Bytes() ([]byte, error) {
+	return rlp.EncodeToBytes(&receiptsDictionaryRLP{Addresses: d.Addresses, Topics: d.Topics})
+}
+
+// Len returns the number of the addresses and the topics in the dictionary
+func (d *ReceiptsDictionary) Len() int {
+	return len(d.Addresses) + len(d.Topics)
+}
+
+// address returns the index of the address, adding it if it is new
+func (d *ReceiptsDictionary) address(a common.Address) uint64 {
+	if i, ok := d.addressIdx[a]; ok {
+		return i
+	}
+	i := uint64(len(d.Addresses))
+	d.Addresses = append(d.Addresses, a)
+	d.addressIdx[a] = i
+	return i
+}
+
+// topic returns the index of the topic, adding it if it is new
+func (d *ReceiptsDictionary) topic(t common.Hash) uint64 {
+	if i, ok := d.topicIdx[t]; ok {
+		return i
+	}
+	i := uint64(len(d.Topics))
+	d.Topics = append(d.Topics, t)
+	d.topicIdx[t] = i
+	return i
+}
+
+// IsDictionaryEncodedReceipts tells whether the stored receipts have been encoded with the dictionary
+func IsDictionaryEncodedReceipts(b []byte) bool {
+	return len(b) > 0 && b[0] == receiptsDictionaryFormat
+}
+
+// EncodeReceiptsWithDictionary encodes the storage fields of the receipts (the same as ReceiptForStorage does),
+// replacing the addresses and the topics of the logs with their indices in the dictionary, which gets the new ones
+func EncodeReceiptsWithDictionary(receipts Receipts, d *ReceiptsDictionary) ([]byte, error) {
+	d.index()
+	enc := make([]dictStoredReceiptRLP, len(receipts))
+	for i, r := range receipts {
+		enc[i] = dictStoredReceiptRLP{
+			PostStateOrStatus: r.statusEncoding(),
+			CumulativeGasUsed: r.CumulativeGasUsed,
+			Logs:              make([]dictStoredLogRLP, len(r.Logs)),
+		}
+		for j, log := range r.Logs {
+			l := dictStoredLogRLP{Address: d.address(log.Address), Topics: make([]uint64, len(log.Topics)), Data: log.Data}
+			for k, topic := range log.Topics {
+				l.Topics[k] = d.topic(topic)
+			}
+			enc[i].Logs[j] = l
+		}
+	}
+	var buf bytes.Buffer
+	buf.WriteByte(receiptsDictionaryFormat)
+	if err := rlp.Encode(&buf, enc); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// DecodeReceiptsWithDictionary decodes the receipts encoded by EncodeReceiptsWithDictionary. As with ReceiptForStorage,
+// only the storage fields are populated
+func DecodeReceiptsWithDictionary(b []byte, d *ReceiptsDictionary) (Receipts, error) {
+	if !IsDictionaryEncodedReceipts(b) {
+		return nil, fmt.Errorf("receipts are not encoded with the dictionary")
+	}
+	var dec []dictStoredReceiptRLP
+	if err := rlp.DecodeBytes(b[1:], &dec); err != nil {
+		return nil, err
+	}
+	receipts := make(Receipts, len(dec))
+	for i, stored := range dec {
+		r := &Receipt{CumulativeGasUsed: stored.CumulativeGasUsed, Logs: make([]*Log, len(stored.Logs))}
+		if err := r.setStatus(stored.PostStateOrStatus); err != nil {
+			return nil, err
+		}
+		for j, l := range stored.Logs {
+			if l.Address >= uint64(len(d.Addresses)) {
+				return nil, fmt.Errorf("receipt %d, log %d: address %d is out of the dictionary of %d", i, j, l.Address, len(d.Addresses))
+			}
+			log := &Log{Address: d.Addresses[l.Address], Topics: make([]common.Hash, len(l.Topics)), Data: l.Data}
+			for k, t := range l.Topics {
+				if t >= uint64(len(d.Topics)) {
+					return nil, fmt.Errorf("receipt %d, log %d: topic %d is out of the dictionary of %d", i, j, t, len(d.Topics))
+				}
+				log.Topics[k] = d.Topics[t]
+			}
+			r.Logs[j] = log
+		}
+		r.Bloom = CreateBloom(Receipts{r})
+		receipts[i] = r
+	}
+	return receipts, nil
+}
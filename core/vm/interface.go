@@ -62,6 +62,15 @@ type IntraBlockState interface {
 	RevertToSnapshot(int)
 	Snapshot() int
 
+	// AccessAccount marks the account as touched in the block, and reports whether it was touched before.
+	// The marks made by the reverted call frames are removed
+	AccessAccount(common.Address) bool
+	// AccessSlot marks the storage item and its account as touched in the block, and reports whether
+	// the account and the item were touched before
+	AccessSlot(common.Address, common.Hash) (accountWarm bool, slotWarm bool)
+	AccountAccessed(common.Address) bool
+	SlotAccessed(common.Address, common.Hash) bool
+
 	AddLog(*types.Log)
 	AddPreimage(common.Hash, []byte)
 }
@@ -31,6 +31,7 @@ type Config struct {
 	Tracer                  Tracer // Opcode logger
 	NoRecursion             bool   // Disables call, callcode, delegate call and create
 	EnablePreimageRecording bool   // Enables recording of SHA3/keccak preimages
+	BatchStorageReads       bool   // Executes the transactions speculatively first, to read their storage in batches
 
 	EWASMInterpreter string // External EWASM interpreter options
 	EVMInterpreter   string // External EVM interpreter options
@@ -80,6 +80,13 @@ func (api *PublicEthereumAPI) ChainId() hexutil.Uint64 {
 	return (hexutil.Uint64)(chainID.Uint64())
 }
 
+// ChainSizeStats returns the running totals of the state size: the number of accounts, contracts and storage items,
+// the size of the contract code and of the flat state. They are maintained while the blocks are executed,
+// so answering does not require walking the state
+func (api *PublicEthereumAPI) ChainSizeStats() (*state.StateSize, error) {
+	return state.ReadStateSize(api.e.ChainDb())
+}
+
 // PublicMinerAPI provides an API to control the miner.
 // It offers only methods that operate on data that pose no security risk when it is publicly accessible.
 type PublicMinerAPI struct {
@@ -210,6 +217,46 @@ func (api *PrivateAdminAPI) ExportChain(file string, first *uint64, last *uint64
 	return true, nil
 }
 
+// PinStorage keeps the storage trie of the contract fully resolved in memory,
+// it is not evicted from the trie cache until UnpinStorage is called.
+func (api *PrivateAdminAPI) PinStorage(address common.Address) (bool, error) {
+	if err := api.eth.BlockChain().PinStorage(address); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// UnpinStorage makes the storage trie of the contract subject to the eviction again.
+func (api *PrivateAdminAPI) UnpinStorage(address common.Address) (bool, error) {
+	if err := api.eth.BlockChain().UnpinStorage(address); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// PinnedStorage returns the contracts which storage tries are pinned.
+func (api *PrivateAdminAPI) PinnedStorage() []common.Address {
+	return api.eth.BlockChain().PinnedStorage()
+}
+
+// SyncFlatState starts the download of the state with the given root from the firehose peers,
+// the head of which is the given block. The download continues after a restart with the same root.
+func (api *PrivateAdminAPI) SyncFlatState(block common.Hash, root common.Hash) (bool, error) {
+	if err := api.eth.protocolManager.flatStateSync.start(block, root); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// SyncChangeSets starts the download of the changesets of the canonical blocks from..to from the firehose peers.
+// The changesets are not committed to by the headers, so they are only as good as the peers serving them.
+func (api *PrivateAdminAPI) SyncChangeSets(from, to uint64) (bool, error) {
+	if err := api.eth.protocolManager.changeSetsSync.start(from, to); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
 func hasAllBlocks(chain *core.BlockChain, bs []*types.Block) bool {
 	for _, b := range bs {
 		if !chain.HasBlock(b.Hash(), b.NumberU64()) {
@@ -441,62 +488,248 @@ func StorageRangeAt(dbstate *state.DbState, contractAddress common.Address, star
 //
 // With one parameter, returns the list of accounts modified in the specified block.
 func (api *PrivateDebugAPI) GetModifiedAccountsByNumber(startNum uint64, endNum *uint64) ([]common.Address, error) {
+	startBlock, endBlock, err := api.modifiedAccountsRangeByNumber(startNum, endNum)
+	if err != nil {
+		return nil, err
+	}
+	return ethdb.GetModifiedAccounts(api.eth.blockchain.ChainDb(), startBlock.NumberU64()+1, endBlock.NumberU64())
+}
+
+// GetModifiedAccountsByHash returns all accounts that have changed between the
+// two blocks specified. A change is defined as a difference in nonce, balance,
+// code hash, or storage hash.
+//
+// With one parameter, returns the list of accounts modified in the specified block.
+// The blocks have to be in the canonical chain, because only its changesets are stored.
+func (api *PrivateDebugAPI) GetModifiedAccountsByHash(startHash common.Hash, endHash *common.Hash) ([]common.Address, error) {
+	startBlock, endBlock, err := api.modifiedAccountsRangeByHash(startHash, endHash)
+	if err != nil {
+		return nil, err
+	}
+	return ethdb.GetModifiedAccounts(api.eth.blockchain.ChainDb(), startBlock.NumberU64()+1, endBlock.NumberU64())
+}
+
+// ModifiedAccountsResult is the result of a debug_getModifiedAccountsRangeByNumber
+// and debug_getModifiedAccountsRangeByHash API calls.
+type ModifiedAccountsResult struct {
+	Accounts []common.Address `json:"accounts"`
+	NextKey  *common.Hash     `json:"nextKey"` // nil if there are no more accounts
+}
+
+// GetModifiedAccountsRangeByNumber is GetModifiedAccountsByNumber with pagination: it returns up to maxResult accounts,
+// ordered by the hashes of the addresses and starting from the hash keyStart. The next page starts from NextKey.
+func (api *PrivateDebugAPI) GetModifiedAccountsRangeByNumber(startNum uint64, endNum *uint64, keyStart hexutil.Bytes, maxResult int) (ModifiedAccountsResult, error) {
+	startBlock, endBlock, err := api.modifiedAccountsRangeByNumber(startNum, endNum)
+	if err != nil {
+		return ModifiedAccountsResult{}, err
+	}
+	return api.getModifiedAccountsRange(startBlock, endBlock, keyStart, maxResult)
+}
+
+// GetModifiedAccountsRangeByHash is GetModifiedAccountsByHash with pagination, see GetModifiedAccountsRangeByNumber.
+func (api *PrivateDebugAPI) GetModifiedAccountsRangeByHash(startHash common.Hash, endHash *common.Hash, keyStart hexutil.Bytes, maxResult int) (ModifiedAccountsResult, error) {
+	startBlock, endBlock, err := api.modifiedAccountsRangeByHash(startHash, endHash)
+	if err != nil {
+		return ModifiedAccountsResult{}, err
+	}
+	return api.getModifiedAccountsRange(startBlock, endBlock, keyStart, maxResult)
+}
+
+func (api *PrivateDebugAPI) getModifiedAccountsRange(startBlock, endBlock *types.Block, keyStart hexutil.Bytes, maxResult int) (ModifiedAccountsResult, error) {
+	if maxResult <= 0 {
+		return ModifiedAccountsResult{}, fmt.Errorf("maxResult must be positive, got %d", maxResult)
+	}
+	accounts, next, err := ethdb.GetModifiedAccountsRange(api.eth.blockchain.ChainDb(), startBlock.NumberU64()+1, endBlock.NumberU64(), keyStart, maxResult)
+	if err != nil {
+		return ModifiedAccountsResult{}, err
+	}
+	return ModifiedAccountsResult{Accounts: accounts, NextKey: next}, nil
+}
+
+// GetAccountHistory returns the blocks in the range [fromBlock, toBlock], in which the account changed,
+// in the ascending order. At most limit blocks are returned (0 - no limit).
+func (api *PrivateDebugAPI) GetAccountHistory(address common.Address, fromBlock, toBlock uint64, limit int) ([]uint64, error) {
+	return ethdb.GetAccountHistory(api.eth.ChainDb(), address, fromBlock, toBlock, limit)
+}
+
+// GetStorageHistory returns the blocks in the range [fromBlock, toBlock], in which the storage item of the contract
+// changed, in the ascending order. At most limit blocks are returned (0 - no limit).
+func (api *PrivateDebugAPI) GetStorageHistory(address common.Address, slot common.Hash, fromBlock, toBlock uint64, limit int) ([]uint64, error) {
+	return ethdb.GetStorageHistory(api.eth.ChainDb(), address, slot, fromBlock, toBlock, limit)
+}
+
+// AccountAtResult is the result of a debug_accountAt API call
+type AccountAtResult struct {
+	Balance     *hexutil.Big                `json:"balance"`
+	Nonce       hexutil.Uint64              `json:"nonce"`
+	CodeHash    common.Hash                 `json:"codeHash"`
+	Incarnation hexutil.Uint64              `json:"incarnation"`
+	Storage     map[common.Hash]common.Hash `json:"storage"` // Keyed by the hashes of the locations
+}
+
+// AccountAt returns the account and its storage as of the end of the block, rewound from the current state
+// by the changes of this account only (see state.AccountAsOf). Returns nil if the account did not exist.
+func (api *PrivateDebugAPI) AccountAt(address common.Address, blockNr uint64) (*AccountAtResult, error) {
+	addrHash, err := common.HashData(address[:])
+	if err != nil {
+		return nil, err
+	}
+	acc, storage, err := state.AccountAsOf(api.eth.ChainDb(), addrHash, blockNr)
+	if err != nil || acc == nil {
+		return nil, err
+	}
+	result := &AccountAtResult{
+		Balance:     (*hexutil.Big)(acc.Balance.ToBig()),
+		Nonce:       hexutil.Uint64(acc.Nonce),
+		CodeHash:    acc.CodeHash,
+		Incarnation: hexutil.Uint64(acc.Incarnation),
+		Storage:     make(map[common.Hash]common.Hash, len(storage)),
+	}
+	for keyHash, v := range storage {
+		result.Storage[keyHash] = common.BytesToHash(v)
+	}
+	return result, nil
+}
+
+// IncarnationAtResult is the result of a debug_incarnationAt API call
+type IncarnationAtResult struct {
+	Incarnation   hexutil.Uint64  `json:"incarnation"`   // 0 - the account did not exist or was not a contract
+	CreationBlock *hexutil.Uint64 `json:"creationBlock"` // The last creation of the account, nil if it is not in the index
+}
+
+// IncarnationAt returns the incarnation of the account at the beginning of the block, and the block at which
+// the account was created the last time, as recorded in the incarnation index (see rawdb.ReadIncarnationAsOf)
+func (api *PrivateDebugAPI) IncarnationAt(address common.Address, blockNr uint64) (*IncarnationAtResult, error) {
+	addrHash, err := common.HashData(address[:])
+	if err != nil {
+		return nil, err
+	}
+	incarnation, err := rawdb.ReadIncarnationAsOf(api.eth.ChainDb(), addrHash, blockNr)
+	if err != nil {
+		return nil, err
+	}
+	result := &IncarnationAtResult{Incarnation: hexutil.Uint64(incarnation)}
+	creationBlock, ok, err := rawdb.ReadCreationBlock(api.eth.ChainDb(), addrHash)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		result.CreationBlock = (*hexutil.Uint64)(&creationBlock)
+	}
+	return result, nil
+}
+
+// BlockChangeSetSummary is the result of a debug_changeSetSummaries API call, see ethdb.ChangeSetSummary
+type BlockChangeSetSummary struct {
+	Block          hexutil.Uint64 `json:"block"`
+	AccountChanges hexutil.Uint64 `json:"accountChanges"`
+	StorageChanges hexutil.Uint64 `json:"storageChanges"`
+	Bytes          hexutil.Uint64 `json:"bytes"` // Size of the encoded changesets
+}
+
+// maxChangeSetSummaries is the largest range of blocks of a debug_changeSetSummaries call
+const maxChangeSetSummaries = 100000
+
+// ChangeSetSummaries returns the number of the account and storage changes and the size of the changesets
+// of the blocks in the range [fromBlock, toBlock]. The blocks without the changesets are skipped.
+func (api *PrivateDebugAPI) ChangeSetSummaries(fromBlock, toBlock uint64) ([]BlockChangeSetSummary, error) {
+	if toBlock < fromBlock {
+		return nil, fmt.Errorf("toBlock %d is before fromBlock %d", toBlock, fromBlock)
+	}
+	if toBlock-fromBlock >= maxChangeSetSummaries {
+		return nil, fmt.Errorf("range of %d blocks is too large, the limit is %d", toBlock-fromBlock+1, maxChangeSetSummaries)
+	}
+	summaries := []BlockChangeSetSummary{}
+	for blockNr := fromBlock; blockNr <= toBlock; blockNr++ {
+		s, ok, err := ethdb.ReadChangeSetSummary(api.eth.ChainDb(), blockNr)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			summaries = append(summaries, BlockChangeSetSummary{
+				Block:          hexutil.Uint64(blockNr),
+				AccountChanges: hexutil.Uint64(s.AccountChanges),
+				StorageChanges: hexutil.Uint64(s.StorageChanges),
+				Bytes:          hexutil.Uint64(s.Bytes()),
+			})
+		}
+	}
+	return summaries, nil
+}
+
+// IntermediateHashesRange returns up to maxResult intermediate hashes of the state trie, whose keys start with the prefix,
+// beginning from the key keyStart, together with the witness lengths of their sub-tries. The next page starts from Next.
+func (api *PrivateDebugAPI) IntermediateHashesRange(ctx context.Context, prefix hexutil.Bytes, keyStart hexutil.Bytes, maxResult int) (state.IntermediateHashesRange, error) {
+	hasKV, ok := api.eth.ChainDb().(ethdb.HasAbstractKV)
+	if !ok {
+		return state.IntermediateHashesRange{}, errors.New("the database does not support the key-value interface")
+	}
+	return state.ReadIntermediateHashesRange(ctx, hasKV.AbstractKV(), prefix, keyStart, maxResult)
+}
+
+func (api *PrivateDebugAPI) modifiedAccountsRangeByNumber(startNum uint64, endNum *uint64) (*types.Block, *types.Block, error) {
 	var startBlock, endBlock *types.Block
 
 	startBlock = api.eth.blockchain.GetBlockByNumber(startNum)
 	if startBlock == nil {
-		return nil, fmt.Errorf("start block %x not found", startNum)
+		return nil, nil, fmt.Errorf("start block %x not found", startNum)
 	}
 
 	if endNum == nil {
 		endBlock = startBlock
 		startBlock = api.eth.blockchain.GetBlockByHash(startBlock.ParentHash())
 		if startBlock == nil {
-			return nil, fmt.Errorf("block %x has no parent", endBlock.Number())
+			return nil, nil, fmt.Errorf("block %x has no parent", endBlock.Number())
 		}
 	} else {
 		endBlock = api.eth.blockchain.GetBlockByNumber(*endNum)
 		if endBlock == nil {
-			return nil, fmt.Errorf("end block %d not found", *endNum)
+			return nil, nil, fmt.Errorf("end block %d not found", *endNum)
 		}
 	}
-	return api.getModifiedAccounts(startBlock, endBlock)
+	if err := checkModifiedAccountsRange(startBlock, endBlock); err != nil {
+		return nil, nil, err
+	}
+	return startBlock, endBlock, nil
 }
 
-// GetModifiedAccountsByHash returns all accounts that have changed between the
-// two blocks specified. A change is defined as a difference in nonce, balance,
-// code hash, or storage hash.
-//
-// With one parameter, returns the list of accounts modified in the specified block.
-func (api *PrivateDebugAPI) GetModifiedAccountsByHash(startHash common.Hash, endHash *common.Hash) ([]common.Address, error) {
+func (api *PrivateDebugAPI) modifiedAccountsRangeByHash(startHash common.Hash, endHash *common.Hash) (*types.Block, *types.Block, error) {
 	var startBlock, endBlock *types.Block
 	startBlock = api.eth.blockchain.GetBlockByHash(startHash)
 	if startBlock == nil {
-		return nil, fmt.Errorf("start block %x not found", startHash)
+		return nil, nil, fmt.Errorf("start block %x not found", startHash)
 	}
 
 	if endHash == nil {
 		endBlock = startBlock
 		startBlock = api.eth.blockchain.GetBlockByHash(startBlock.ParentHash())
 		if startBlock == nil {
-			return nil, fmt.Errorf("block %x has no parent", endBlock.Number())
+			return nil, nil, fmt.Errorf("block %x has no parent", endBlock.Number())
 		}
 	} else {
 		endBlock = api.eth.blockchain.GetBlockByHash(*endHash)
 		if endBlock == nil {
-			return nil, fmt.Errorf("end block %x not found", *endHash)
+			return nil, nil, fmt.Errorf("end block %x not found", *endHash)
+		}
+	}
+	// Changesets are stored by block number, for the canonical blocks only
+	db := api.eth.blockchain.ChainDb()
+	for _, block := range []*types.Block{startBlock, endBlock} {
+		if rawdb.ReadCanonicalHash(db, block.NumberU64()) != block.Hash() {
+			return nil, nil, fmt.Errorf("block %x (%d) is not in the canonical chain", block.Hash(), block.NumberU64())
 		}
 	}
-	return api.getModifiedAccounts(startBlock, endBlock)
+	if err := checkModifiedAccountsRange(startBlock, endBlock); err != nil {
+		return nil, nil, err
+	}
+	return startBlock, endBlock, nil
 }
 
-func (api *PrivateDebugAPI) getModifiedAccounts(startBlock, endBlock *types.Block) ([]common.Address, error) {
+func checkModifiedAccountsRange(startBlock, endBlock *types.Block) error {
 	startNum := startBlock.NumberU64()
 	endNum := endBlock.NumberU64()
 	if startNum >= endNum {
-		return nil, fmt.Errorf("start block height (%d) must be less than end block height (%d)", startNum, endNum)
+		return fmt.Errorf("start block height (%d) must be less than end block height (%d)", startNum, endNum)
 	}
-
-	dirty, err := ethdb.GetModifiedAccounts(api.eth.blockchain.ChainDb(), startNum, endNum)
-	return dirty, err
+	return nil
 }
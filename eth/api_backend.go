@@ -155,6 +155,14 @@ func (b *EthAPIBackend) StateAndHeaderByNumber(ctx context.Context, blockNr rpc.
 	if header == nil {
 		return nil, nil, errors.New("header not found")
 	}
+	if blockNr == rpc.LatestBlockNumber {
+		// The current state is looked up by the addresses directly in the plain layout
+		reader, err := state.NewCurrentStateReader(b.eth.chainDb)
+		if err != nil {
+			return nil, nil, err
+		}
+		return state.New(reader), header, nil
+	}
 	ds := state.NewDbState(b.eth.chainDb, bn)
 	stateDb := state.New(ds)
 	return stateDb, header, nil
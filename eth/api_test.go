@@ -20,6 +20,7 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"math/big"
 	"reflect"
 	"sort"
 	"strconv"
@@ -29,9 +30,14 @@ import (
 	"github.com/holiman/uint256"
 
 	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/consensus/ethash"
+	"github.com/ledgerwatch/turbo-geth/core"
 	"github.com/ledgerwatch/turbo-geth/core/state"
+	"github.com/ledgerwatch/turbo-geth/core/types"
+	"github.com/ledgerwatch/turbo-geth/core/vm"
 	"github.com/ledgerwatch/turbo-geth/crypto"
 	"github.com/ledgerwatch/turbo-geth/ethdb"
+	"github.com/ledgerwatch/turbo-geth/params"
 	"github.com/ledgerwatch/turbo-geth/trie"
 )
 
@@ -248,3 +254,118 @@ func TestStorageRangeAt(t *testing.T) {
 		})
 	}
 }
+
+func TestGetModifiedAccounts(t *testing.T) {
+	var (
+		db      = ethdb.NewMemDatabase()
+		key, _  = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		address = crypto.PubkeyToAddress(key.PublicKey)
+		gspec   = &core.Genesis{
+			Config: &params.ChainConfig{ChainID: big.NewInt(1), HomesteadBlock: new(big.Int), EIP150Block: new(big.Int), EIP155Block: new(big.Int)},
+			Alloc:  core.GenesisAlloc{address: {Balance: big.NewInt(1000000000)}},
+		}
+		genesis  = gspec.MustCommit(db)
+		engine   = ethash.NewFaker()
+		coinbase = common.Address{}
+		signer   = types.NewEIP155Signer(gspec.Config.ChainID)
+	)
+	blockchain, err := core.NewBlockChain(db, nil, gspec.Config, engine, vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer blockchain.Stop()
+	ctx := blockchain.WithContext(context.Background(), big.NewInt(genesis.Number().Int64()+1))
+
+	// Block i of each chain sends 1 wei to the address {prefix+i}
+	transfers := func(prefix byte) func(int, *core.BlockGen) {
+		return func(i int, block *core.BlockGen) {
+			tx, err := types.SignTx(types.NewTransaction(block.TxNonce(address), common.Address{prefix + byte(i)}, big.NewInt(1), 21000, new(big.Int), nil), signer, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			block.AddTx(tx)
+		}
+	}
+	blocks, _ := core.GenerateChain(ctx, gspec.Config, genesis, engine, db.MemCopy(), 3, transfers(0xa0))
+	// The longer fork replaces all the blocks of the first chain
+	forkBlocks, _ := core.GenerateChain(ctx, gspec.Config, genesis, engine, db.MemCopy(), 4, transfers(0xb0))
+
+	if _, err = blockchain.InsertChain(context.Background(), blocks); err != nil {
+		t.Fatal(err)
+	}
+	api := NewPrivateDebugAPI(&Ethereum{blockchain: blockchain})
+
+	check := func(name string, got []common.Address, err error, want ...common.Address) {
+		t.Helper()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if len(got) != len(want) {
+			t.Fatalf("%s: expected %d accounts, got %x", name, len(want), got)
+		}
+		gotSet := make(map[common.Address]struct{}, len(got))
+		for _, addr := range got {
+			gotSet[addr] = struct{}{}
+		}
+		for _, addr := range want {
+			if _, ok := gotSet[addr]; !ok {
+				t.Fatalf("%s: expected account %x, got %x", name, addr, got)
+			}
+		}
+	}
+
+	got, err := api.GetModifiedAccountsByNumber(1, nil)
+	check("block 1", got, err, address, coinbase, common.Address{0xa0})
+	end := uint64(3)
+	got, err = api.GetModifiedAccountsByNumber(0, &end)
+	check("blocks 1-3", got, err, address, coinbase, common.Address{0xa0}, common.Address{0xa1}, common.Address{0xa2})
+	got, err = api.GetModifiedAccountsByHash(blocks[1].Hash(), nil)
+	check("block 2 by hash", got, err, address, coinbase, common.Address{0xa1})
+
+	// Pagination
+	first, err := api.GetModifiedAccountsRangeByNumber(0, &end, nil, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(first.Accounts) != 2 || first.NextKey == nil {
+		t.Fatalf("expected 2 accounts and the next key in the first page, got %x, %v", first.Accounts, first.NextKey)
+	}
+	second, err := api.GetModifiedAccountsRangeByNumber(0, &end, first.NextKey[:], 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(second.Accounts) != 2 || second.NextKey == nil {
+		t.Fatalf("expected 2 accounts and the next key in the second page, got %x, %v", second.Accounts, second.NextKey)
+	}
+	third, err := api.GetModifiedAccountsRangeByNumber(0, &end, second.NextKey[:], 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if third.NextKey != nil {
+		t.Fatalf("expected no more pages, got the next key %x", *third.NextKey)
+	}
+	pages := append(append(append([]common.Address{}, first.Accounts...), second.Accounts...), third.Accounts...)
+	check("all pages", pages, nil, address, coinbase, common.Address{0xa0}, common.Address{0xa1}, common.Address{0xa2})
+
+	// Reorg to the longer fork, the changesets of the replaced blocks are gone
+	if _, err = blockchain.InsertChain(context.Background(), forkBlocks); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = api.GetModifiedAccountsByHash(blocks[1].Hash(), nil); err == nil {
+		t.Fatal("expected error for the block, which is not in the canonical chain anymore")
+	}
+	endHash := blocks[2].Hash()
+	if _, err = api.GetModifiedAccountsByHash(genesis.Hash(), &endHash); err == nil {
+		t.Fatal("expected error for the end block, which is not in the canonical chain anymore")
+	}
+	if _, err = api.GetModifiedAccountsRangeByHash(blocks[0].Hash(), nil, nil, 10); err == nil {
+		t.Fatal("expected error for the block, which is not in the canonical chain anymore")
+	}
+	got, err = api.GetModifiedAccountsByNumber(1, nil)
+	check("block 1 after reorg", got, err, address, coinbase, common.Address{0xb0})
+	got, err = api.GetModifiedAccountsByHash(forkBlocks[1].Hash(), nil)
+	check("block 2 by hash after reorg", got, err, address, coinbase, common.Address{0xb1})
+	end = 4
+	got, err = api.GetModifiedAccountsByNumber(2, &end)
+	check("blocks 3-4 after reorg", got, err, address, coinbase, common.Address{0xb2}, common.Address{0xb3})
+}
@@ -23,7 +23,6 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
-	"math/big"
 	"os"
 	"runtime"
 	"sync"
@@ -80,10 +79,9 @@ type txTraceResult struct {
 // blockTraceTask represents a single block trace task when an entire chain is
 // being traced.
 type blockTraceTask struct {
-	tds     *state.TrieDbState
-	block   *types.Block     // Block to trace the transactions from
-	rootref common.Hash      // Trie root reference held for this task
-	results []*txTraceResult // Trace results procudes by the task
+	tds     *state.TrieDbState // Historical state of the parent block
+	block   *types.Block       // Block to trace the transactions from
+	results []*txTraceResult   // Trace results procudes by the task
 }
 
 // blockTraceResult represets the results of tracing a single block when an entire
@@ -146,32 +144,8 @@ func (api *PrivateDebugAPI) traceChain(ctx context.Context, start, end *types.Bl
 		return &rpc.Subscription{}, rpc.ErrNotificationsUnsupported
 	}
 	sub := notifier.CreateSubscription()
-
-	// Ensure we have a valid starting state before doing any work
 	origin := start.NumberU64()
 
-	if number := start.NumberU64(); number > 0 {
-		start = api.eth.blockchain.GetBlock(start.ParentHash(), start.NumberU64()-1)
-		if start == nil {
-			return nil, fmt.Errorf("parent block #%d not found", number-1)
-		}
-	}
-	tds := state.NewTrieDbState(start.Root(), api.eth.ChainDb(), start.NumberU64())
-	// If the starting state is missing, allow some number of blocks to be re-executed
-	reexec := defaultTraceReexec
-	if config != nil && config.Reexec != nil {
-		reexec = *config.Reexec
-	}
-	// Find the most recent block that has the state available
-	for i := uint64(0); i < reexec; i++ {
-		start = api.eth.blockchain.GetBlock(start.ParentHash(), start.NumberU64()-1)
-		if start == nil {
-			break
-		}
-		tds = state.NewTrieDbState(start.Root(), api.eth.ChainDb(), start.NumberU64())
-	}
-	// If we still don't have the state available, bail out
-	statedb := state.New(tds)
 	// Execute all the transaction contained within the chain concurrently for each block
 	blocks := int(end.NumberU64() - origin)
 
@@ -228,7 +202,6 @@ func (api *PrivateDebugAPI) traceChain(ctx context.Context, start, end *types.Bl
 			number uint64
 			traced uint64
 			failed error
-			proot  common.Hash
 		)
 		// Ensure everything is properly cleaned up on any exit path
 		defer func() {
@@ -245,7 +218,8 @@ func (api *PrivateDebugAPI) traceChain(ctx context.Context, start, end *types.Bl
 			}
 			close(results)
 		}()
-		// Feed all the blocks both into the tracer, as well as fast process concurrently
+		// Feed all the blocks into the tracers, the state of every block is read from the history,
+		// so the blocks are traced independently and nothing is reexecuted or written
 		for number = start.NumberU64() + 1; number <= end.NumberU64(); number++ {
 			// Stop tracing if interruption was requested
 			select {
@@ -255,53 +229,29 @@ func (api *PrivateDebugAPI) traceChain(ctx context.Context, start, end *types.Bl
 			}
 			// Print progress logs if long enough time elapsed
 			if time.Since(logged) > 8*time.Second {
-				if number > origin {
-					log.Info("Tracing chain segment", "start", origin, "end", end.NumberU64(), "current", number, "transactions", traced, "elapsed", time.Since(begin), "database", api.eth.ChainDb().DiskSize())
-				} else {
-					log.Info("Preparing state for chain trace", "block", number, "start", origin, "elapsed", time.Since(begin))
-				}
+				log.Info("Tracing chain segment", "start", origin, "end", end.NumberU64(), "current", number, "transactions", traced, "elapsed", time.Since(begin))
 				logged = time.Now()
 			}
-			// Retrieve the next block to trace
+			// Retrieve the next block to trace and the state it is executed on
 			block := api.eth.blockchain.GetBlockByNumber(number)
 			if block == nil {
 				failed = fmt.Errorf("block #%d not found", number)
 				break
 			}
-			// Send the block over to the concurrent tracers (if not in the fast-forward phase)
-			if number > origin {
-				txs := block.Transactions()
-
-				select {
-				case tasks <- &blockTraceTask{tds: tds.Copy(), block: block, rootref: proot, results: make([]*txTraceResult, len(txs))}:
-				case <-notifier.Closed():
-					return
-				}
-				traced += uint64(len(txs))
-			}
-			// Generate the next state snapshot fast without tracing
-			processor := api.eth.blockchain.Processor()
-			receipts, _, _, _, err := processor.PreProcess(block, statedb, tds, vm.Config{})
-			if err != nil {
-				failed = err
-				break
-			}
-			err = processor.PostProcess(block, tds, receipts)
-			if err != nil {
-				failed = err
+			parent := api.eth.blockchain.GetBlock(block.ParentHash(), number-1)
+			if parent == nil {
+				failed = fmt.Errorf("parent block #%d not found", number-1)
 				break
 			}
-			// Finalize the state so any modifications are written to the trie
-			ctx := api.eth.blockchain.Config().WithEIPsFlags(context.Background(), big.NewInt(int64(number)))
-			tds.SetBlockNr(number)
-			err = statedb.CommitBlock(ctx, tds.DbStateWriter())
-			if err != nil {
-				failed = err
-				break
-			}
-			proot = tds.LastRoot()
+			_, tds := ComputeHistoricalState(api.eth.ChainDb(), parent)
+			txs := block.Transactions()
 
-			// TODO(karalabe): Do we need the preimages? Won't they accumulate too much?
+			select {
+			case tasks <- &blockTraceTask{tds: tds, block: block, results: make([]*txTraceResult, len(txs))}:
+			case <-notifier.Closed():
+				return
+			}
+			traced += uint64(len(txs))
 		}
 	}()
 
@@ -623,7 +573,7 @@ func (api *PrivateDebugAPI) TraceTransaction(ctx context.Context, hash common.Ha
 	if tx == nil {
 		return nil, fmt.Errorf("transaction %#x not found", hash)
 	}
-	msg, vmctx, statedb, _, err := ComputeTxEnv(ctx, api.eth.blockchain, api.eth.blockchain.Config(), api.eth.blockchain, api.eth.ChainDb(), blockHash, index)
+	msg, vmctx, statedb, err := ComputeHistoricalTxEnv(ctx, api.eth.blockchain, api.eth.blockchain.Config(), api.eth.blockchain, api.eth.ChainDb(), blockHash, index)
 	if err != nil {
 		return nil, err
 	}
@@ -704,19 +654,60 @@ type BlockGetter interface {
 // computeTxEnv returns the execution environment of a certain transaction.
 func ComputeTxEnv(ctx context.Context, blockGetter BlockGetter, cfg *params.ChainConfig, chain core.ChainContext, chainDb ethdb.Getter, blockHash common.Hash, txIndex uint64) (core.Message, vm.Context, *state.IntraBlockState, *state.DbState, error) {
 	// Create the parent state database
+	block, parent, err := blockAndParent(blockGetter, blockHash)
+	if err != nil {
+		return nil, vm.Context{}, nil, nil, err
+	}
+	statedb, dbstate := ComputeIntraBlockState(chainDb, parent)
+	msg, vmctx, err := replayTxs(ctx, cfg, chain, block, statedb, dbstate, txIndex)
+	if err != nil {
+		return nil, vm.Context{}, nil, nil, err
+	}
+	return msg, vmctx, statedb, dbstate, nil
+}
+
+// ComputeHistoricalState returns the state as of the end of the block, read from the history
+// (see TrieDbState.SetHistorical), so that no archive trie is needed. The modifications are
+// kept in memory, if written with the TrieStateWriter of the returned TrieDbState
+func ComputeHistoricalState(chainDb ethdb.Database, block *types.Block) (*state.IntraBlockState, *state.TrieDbState) {
+	tds := state.NewTrieDbState(block.Root(), chainDb, block.NumberU64())
+	tds.SetHistorical(true)
+	tds.SetNoHistory(true)
+	return state.New(tds), tds
+}
+
+// ComputeHistoricalTxEnv returns the execution environment of a certain transaction on top of
+// the historical state of the parent block, with the preceding transactions of the block replayed
+func ComputeHistoricalTxEnv(ctx context.Context, blockGetter BlockGetter, cfg *params.ChainConfig, chain core.ChainContext, chainDb ethdb.Database, blockHash common.Hash, txIndex uint64) (core.Message, vm.Context, *state.IntraBlockState, error) {
+	block, parent, err := blockAndParent(blockGetter, blockHash)
+	if err != nil {
+		return nil, vm.Context{}, nil, err
+	}
+	statedb, tds := ComputeHistoricalState(chainDb, parent)
+	msg, vmctx, err := replayTxs(ctx, cfg, chain, block, statedb, tds.TrieStateWriter(), txIndex)
+	if err != nil {
+		return nil, vm.Context{}, nil, err
+	}
+	return msg, vmctx, statedb, nil
+}
+
+func blockAndParent(blockGetter BlockGetter, blockHash common.Hash) (*types.Block, *types.Block, error) {
 	block := blockGetter.GetBlockByHash(blockHash)
 	if block == nil {
-		return nil, vm.Context{}, nil, nil, fmt.Errorf("block %x not found", blockHash)
+		return nil, nil, fmt.Errorf("block %x not found", blockHash)
 	}
 	parent := blockGetter.GetBlock(block.ParentHash(), block.NumberU64()-1)
 	if parent == nil {
-		return nil, vm.Context{}, nil, nil, fmt.Errorf("parent %x not found", block.ParentHash())
+		return nil, nil, fmt.Errorf("parent %x not found", block.ParentHash())
 	}
+	return block, parent, nil
+}
 
-	statedb, dbstate := ComputeIntraBlockState(chainDb, parent)
-
+// replayTxs executes the transactions of the block preceding the one with the given index on top of
+// the state of the parent block, and returns the message and the context of the transaction
+func replayTxs(ctx context.Context, cfg *params.ChainConfig, chain core.ChainContext, block *types.Block, statedb *state.IntraBlockState, stateWriter state.StateWriter, txIndex uint64) (core.Message, vm.Context, error) {
 	if txIndex == 0 && len(block.Transactions()) == 0 {
-		return nil, vm.Context{}, statedb, dbstate, nil
+		return nil, vm.Context{}, nil
 	}
 	// Recompute transactions up to the target index.
 	signer := types.MakeSigner(cfg, block.Number())
@@ -725,23 +716,23 @@ func ComputeTxEnv(ctx context.Context, blockGetter BlockGetter, cfg *params.Chai
 		select {
 		default:
 		case <-ctx.Done():
-			return nil, vm.Context{}, nil, nil, ctx.Err()
+			return nil, vm.Context{}, ctx.Err()
 		}
 
 		// Assemble the transaction call message and return if the requested offset
 		msg, _ := tx.AsMessage(signer)
 		EVMcontext := core.NewEVMContext(msg, block.Header(), chain, nil)
 		if idx == int(txIndex) {
-			return msg, EVMcontext, statedb, dbstate, nil
+			return msg, EVMcontext, nil
 		}
 		// Not yet the searched for transaction, execute on top of the current state
 		vmenv := vm.NewEVM(EVMcontext, statedb, cfg, vm.Config{})
 		if _, err := core.ApplyMessage(vmenv, msg, new(core.GasPool).AddGas(tx.Gas())); err != nil {
-			return nil, vm.Context{}, nil, nil, fmt.Errorf("transaction %x failed: %v", tx.Hash(), err)
+			return nil, vm.Context{}, fmt.Errorf("transaction %x failed: %v", tx.Hash(), err)
 		}
 		// Ensure any modifications are committed to the state
 		// Only delete empty objects if EIP158/161 (a.k.a Spurious Dragon) is in effect
-		_ = statedb.FinalizeTx(vmenv.ChainConfig().WithEIPsFlags(context.Background(), block.Number()), dbstate)
+		_ = statedb.FinalizeTx(vmenv.ChainConfig().WithEIPsFlags(context.Background(), block.Number()), stateWriter)
 	}
-	return nil, vm.Context{}, nil, nil, fmt.Errorf("transaction index %d out of range for block %x", txIndex, blockHash)
+	return nil, vm.Context{}, fmt.Errorf("transaction index %d out of range for block %x", txIndex, block.Hash())
 }
@@ -0,0 +1,244 @@
+package eth
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/common/hexutil"
+	"github.com/ledgerwatch/turbo-geth/core"
+	"github.com/ledgerwatch/turbo-geth/core/rawdb"
+	"github.com/ledgerwatch/turbo-geth/core/state"
+	"github.com/ledgerwatch/turbo-geth/core/types"
+	"github.com/ledgerwatch/turbo-geth/core/vm"
+	"github.com/ledgerwatch/turbo-geth/crypto"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+	"github.com/ledgerwatch/turbo-geth/rpc"
+	"github.com/ledgerwatch/turbo-geth/trie"
+)
+
+// maxWitnessUnwind limits how many blocks back from the head the state is unwound
+// by debug_getWitness to generate the witness of a block
+const maxWitnessUnwind = 64
+
+// WitnessOptions are the optional parameters of eth_getWitness and debug_getWitness
+type WitnessOptions struct {
+	Accounts  []common.Address `json:"accounts"`  // If not empty, the witness only proves these accounts and their storage
+	ChunkSize int              `json:"chunkSize"` // If positive, the serialised witness is split into the chunks of this size
+	Chunk     int              `json:"chunk"`     // Index of the chunk to return
+}
+
+// WitnessResult is the serialised block witness, or a chunk of it, returned by eth_getWitness and debug_getWitness
+type WitnessResult struct {
+	BlockHash   common.Hash    `json:"blockHash"`
+	BlockNumber hexutil.Uint64 `json:"blockNumber"`
+	Size        hexutil.Uint64 `json:"size"`   // Size of the whole serialised witness
+	Chunk       hexutil.Uint64 `json:"chunk"`  // Index of the returned chunk
+	Chunks      hexutil.Uint64 `json:"chunks"` // Number of the chunks
+	Witness     hexutil.Bytes  `json:"witness"`
+}
+
+// GetWitness returns the witness of the block, which a stateless client needs to execute it: the parts of the state
+// trie before the block, read or modified by the block, together with the contract codes.
+// The witness is served from the stored witnesses (dbutils.BlockWitnessBucket), the witness of the block without one
+// can be generated by debug_getWitness.
+// Large witnesses can be fetched in chunks, by requesting the chunks 0..Chunks-1 with the same ChunkSize.
+func (api *PublicEthereumAPI) GetWitness(ctx context.Context, blockNrOrHash rpc.BlockNumberOrHash, options *WitnessOptions) (*WitnessResult, error) {
+	if options == nil {
+		options = &WitnessOptions{}
+	}
+	if err := checkWitnessOptions(options); err != nil {
+		return nil, err
+	}
+	block, err := api.e.APIBackend.BlockByNumberOrHash(ctx, blockNrOrHash)
+	if err != nil {
+		return nil, err
+	}
+	if block == nil {
+		return nil, errors.New("block not found")
+	}
+	serialised, err := rawdb.ReadBlockWitness(api.e.ChainDb(), block.Hash(), block.NumberU64())
+	if err != nil {
+		return nil, err
+	}
+	if serialised == nil {
+		return nil, fmt.Errorf("witness of block %d %x is not stored, it can be generated by debug_getWitness", block.NumberU64(), block.Hash())
+	}
+	if len(options.Accounts) > 0 {
+		if serialised, err = filterWitness(serialised, options.Accounts); err != nil {
+			return nil, fmt.Errorf("filtering the witness of block %d: %w", block.NumberU64(), err)
+		}
+	}
+	return witnessResult(block, serialised, options)
+}
+
+// GetWitness generates the witness of the block by re-executing it on top of the state unwound to its parent,
+// so only the canonical blocks not deeper than maxWitnessUnwind blocks from the head are supported.
+// Nothing is written into the database, the witness is not stored either
+func (api *PrivateDebugAPI) GetWitness(ctx context.Context, blockNrOrHash rpc.BlockNumberOrHash, options *WitnessOptions) (*WitnessResult, error) {
+	if options == nil {
+		options = &WitnessOptions{}
+	}
+	if err := checkWitnessOptions(options); err != nil {
+		return nil, err
+	}
+	block, err := api.eth.APIBackend.BlockByNumberOrHash(ctx, blockNrOrHash)
+	if err != nil {
+		return nil, err
+	}
+	if block == nil {
+		return nil, errors.New("block not found")
+	}
+	witness, err := api.eth.generateWitness(block)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if _, err = witness.WriteTo(&buf); err != nil {
+		return nil, err
+	}
+	serialised := buf.Bytes()
+	if len(options.Accounts) > 0 {
+		if serialised, err = filterWitness(serialised, options.Accounts); err != nil {
+			return nil, fmt.Errorf("filtering the witness of block %d: %w", block.NumberU64(), err)
+		}
+	}
+	return witnessResult(block, serialised, options)
+}
+
+func checkWitnessOptions(options *WitnessOptions) error {
+	if options.ChunkSize < 0 || options.Chunk < 0 {
+		return fmt.Errorf("chunkSize and chunk must not be negative")
+	}
+	if options.ChunkSize == 0 && options.Chunk > 0 {
+		return fmt.Errorf("chunk %d requested without chunkSize", options.Chunk)
+	}
+	return nil
+}
+
+// witnessResult returns the requested chunk of the serialised witness
+func witnessResult(block *types.Block, serialised []byte, options *WitnessOptions) (*WitnessResult, error) {
+	result := &WitnessResult{
+		BlockHash:   block.Hash(),
+		BlockNumber: hexutil.Uint64(block.NumberU64()),
+		Size:        hexutil.Uint64(len(serialised)),
+		Chunks:      1,
+		Witness:     serialised,
+	}
+	if options.ChunkSize > 0 {
+		chunks := (len(serialised) + options.ChunkSize - 1) / options.ChunkSize
+		if options.Chunk >= chunks {
+			return nil, fmt.Errorf("chunk %d is out of range, the witness has %d chunks", options.Chunk, chunks)
+		}
+		from := options.Chunk * options.ChunkSize
+		to := from + options.ChunkSize
+		if to > len(serialised) {
+			to = len(serialised)
+		}
+		result.Chunk = hexutil.Uint64(options.Chunk)
+		result.Chunks = hexutil.Uint64(chunks)
+		result.Witness = serialised[from:to]
+	}
+	return result, nil
+}
+
+// filterWitness rebuilds the trie of the serialised witness and extracts the witness of the given accounts
+// and their storage from it, the rest of the trie is replaced by the hashes
+func filterWitness(serialised []byte, accounts []common.Address) ([]byte, error) {
+	witness, err := trie.NewWitnessFromReader(bytes.NewReader(serialised), false)
+	if err != nil {
+		return nil, err
+	}
+	t, err := trie.BuildTrieFromWitness(witness, false /* is binary */, false)
+	if err != nil {
+		return nil, err
+	}
+	rd := &accountsRetainDecider{codes: make(map[common.Hash]struct{})}
+	for _, address := range accounts {
+		addrHash := crypto.Keccak256Hash(address[:])
+		hex := make([]byte, 2*len(addrHash))
+		for i, b := range addrHash {
+			hex[2*i] = b / 16
+			hex[2*i+1] = b % 16
+		}
+		rd.hexes = append(rd.hexes, hex)
+		if acc, ok := t.GetAccount(addrHash[:]); ok && acc != nil && !acc.IsEmptyCodeHash() {
+			rd.codes[acc.CodeHash] = struct{}{}
+		}
+	}
+	filtered, err := t.ExtractWitness(false, rd)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if _, err = filtered.WriteTo(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// accountsRetainDecider retains the paths to the given accounts (hex encoded hashes) and everything beneath them
+type accountsRetainDecider struct {
+	hexes [][]byte
+	codes map[common.Hash]struct{}
+}
+
+func (rd *accountsRetainDecider) Retain(prefix []byte) bool {
+	for _, hex := range rd.hexes {
+		if len(prefix) <= len(hex) && bytes.HasPrefix(hex, prefix) || bytes.HasPrefix(prefix, hex) {
+			return true
+		}
+	}
+	return false
+}
+
+func (rd *accountsRetainDecider) IsCodeTouched(codeHash common.Hash) bool {
+	_, ok := rd.codes[codeHash]
+	return ok
+}
+
+// generateWitness re-executes the canonical block on top of the state unwound to its parent, collecting the reads.
+// The unwinding and the execution are done in the batch over the read-only view of the database (see
+// ethdb.NewReadOnlyDatabase), which is discarded afterwards. The state root of the execution and the unchanged head
+// make sure that the chain has not advanced under the generation
+func (s *Ethereum) generateWitness(block *types.Block) (*trie.Witness, error) {
+	bc := s.blockchain
+	number := block.NumberU64()
+	if number == 0 {
+		return nil, errors.New("genesis block has no witness")
+	}
+	if bc.GetCanonicalHash(number) != block.Hash() {
+		return nil, fmt.Errorf("block %d %x is not canonical", number, block.Hash())
+	}
+	head := bc.CurrentBlock()
+	if head.NumberU64() < number {
+		return nil, fmt.Errorf("block %d is not processed yet, head is %d", number, head.NumberU64())
+	}
+	if head.NumberU64()-number >= maxWitnessUnwind {
+		return nil, fmt.Errorf("block %d is too old, witnesses are generated for the last %d blocks only", number, maxWitnessUnwind)
+	}
+
+	batch := ethdb.NewReadOnlyDatabase(s.chainDb).NewBatch()
+	defer batch.Rollback()
+	tds := state.NewTrieDbState(head.Root(), batch, head.NumberU64())
+	if head.NumberU64() > number-1 {
+		if err := tds.UnwindTo(number - 1); err != nil {
+			return nil, fmt.Errorf("unwinding to block %d: %w", number-1, err)
+		}
+	}
+	tds.SetResolveReads(true)
+	ibs := state.New(tds)
+	_, _, _, root, err := core.NewStateProcessor(bc.Config(), bc, s.engine).PreProcess(block, ibs, tds, vm.Config{})
+	if err != nil {
+		return nil, fmt.Errorf("re-executing block %d: %w", number, err)
+	}
+	if root != block.Root() {
+		return nil, fmt.Errorf("state root mismatch after re-executing block %d: %x, expected %x", number, root, block.Root())
+	}
+	if bc.CurrentBlock().Hash() != head.Hash() {
+		return nil, fmt.Errorf("the head has changed while generating the witness of block %d, try again", number)
+	}
+	return tds.ExtractWitness(false, false /* is binary */)
+}
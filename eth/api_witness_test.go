@@ -0,0 +1,86 @@
+package eth
+
+import (
+	"bytes"
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/consensus/ethash"
+	"github.com/ledgerwatch/turbo-geth/core"
+	"github.com/ledgerwatch/turbo-geth/core/types"
+	"github.com/ledgerwatch/turbo-geth/core/vm"
+	"github.com/ledgerwatch/turbo-geth/crypto"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+	"github.com/ledgerwatch/turbo-geth/params"
+	"github.com/ledgerwatch/turbo-geth/rpc"
+	"github.com/ledgerwatch/turbo-geth/trie"
+)
+
+func TestGenerateWitness(t *testing.T) {
+	var (
+		db      = ethdb.NewMemDatabase()
+		key, _  = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		address = crypto.PubkeyToAddress(key.PublicKey)
+		gspec   = &core.Genesis{
+			Config: params.TestChainConfig,
+			Alloc:  core.GenesisAlloc{address: {Balance: big.NewInt(1000000000)}},
+		}
+		genesis = gspec.MustCommit(db)
+		engine  = ethash.NewFaker()
+		signer  = types.NewEIP155Signer(gspec.Config.ChainID)
+	)
+	defer db.Close()
+	blockchain, err := core.NewBlockChain(db, nil, gspec.Config, engine, vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer blockchain.Stop()
+	blocks, _ := core.GenerateChain(context.Background(), gspec.Config, genesis, engine, db.MemCopy(), 3, func(i int, block *core.BlockGen) {
+		tx, err := types.SignTx(types.NewTransaction(block.TxNonce(address), common.Address{byte(i + 1)}, big.NewInt(1000), 21000, new(big.Int), nil), signer, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		block.AddTx(tx)
+	})
+	if _, err = blockchain.InsertChain(context.Background(), blocks); err != nil {
+		t.Fatal(err)
+	}
+	before, err := db.ContentHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Ethereum{blockchain: blockchain, chainDb: db, engine: engine}
+	s.APIBackend = &EthAPIBackend{eth: s}
+	result, err := NewPrivateDebugAPI(s).GetWitness(context.Background(), rpc.BlockNumberOrHashWithNumber(2), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := trie.NewWitnessFromReader(bytes.NewReader(result.Witness), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr, err := trie.BuildTrieFromWitness(decoded, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The sender as of the parent block, before the transaction of the block
+	acc, ok := tr.GetAccount(crypto.Keccak256(address[:]))
+	if !ok || acc == nil || acc.Nonce != 1 {
+		t.Fatalf("the sender is not in the witness as of block 1: %+v", acc)
+	}
+
+	// Neither the unwinding and the execution nor the witness are written into the database
+	after, err := db.ContentHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if before != after {
+		t.Fatalf("the database has been modified by the generation of the witness")
+	}
+	if blockchain.CurrentBlock().Hash() != blocks[2].Hash() {
+		t.Fatalf("the head has changed")
+	}
+}
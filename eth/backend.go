@@ -21,6 +21,7 @@ import (
 	"errors"
 	"fmt"
 	"math/big"
+	"os"
 	"reflect"
 	"runtime"
 	"sync"
@@ -37,6 +38,7 @@ import (
 	"github.com/ledgerwatch/turbo-geth/core"
 	"github.com/ledgerwatch/turbo-geth/core/bloombits"
 	"github.com/ledgerwatch/turbo-geth/core/rawdb"
+	"github.com/ledgerwatch/turbo-geth/core/state"
 	"github.com/ledgerwatch/turbo-geth/core/types"
 	"github.com/ledgerwatch/turbo-geth/core/vm"
 	"github.com/ledgerwatch/turbo-geth/eth/downloader"
@@ -79,7 +81,12 @@ type Ethereum struct {
 	dialCandiates   enode.Iterator
 
 	// DB interfaces
-	chainDb ethdb.Database // Block chain database
+	chainDb      ethdb.Database      // Block chain database
+	statsSampler *ethdb.StatsSampler // Periodic sampling of the bucket sizes, nil if disabled
+	scrubber     *ethdb.Scrubber     // Periodic verification of the bucket checksums, nil if disabled
+	coldCode     *state.ColdCodeTier // Moving of the unread contract code to the cold store, nil if disabled
+	coldCodeDb   ethdb.Database      // Cold store of the contract code, nil if disabled
+	pruner       *core.HistoryPruner // Enforcement of the history retention policy, nil in the archive mode
 
 	eventMux       *event.TypeMux
 	engine         consensus.Engine
@@ -144,9 +151,38 @@ func New(ctx *node.ServiceContext, config *Config) (*Ethereum, error) {
 	if err != nil {
 		return nil, err
 	}
+	if config.DatabaseScrubInterval > 0 {
+		// Before any writes, so that they maintain the checksums
+		if boltDb, ok := chainDb.(*ethdb.BoltDatabase); ok {
+			boltDb.EnableBucketChecksums(ethdb.DefaultChecksumBuckets...)
+		} else {
+			log.Warn("Checksums of the database buckets are only supported for Bolt")
+		}
+	}
+	var coldCode *state.ColdCodeTier
+	var coldCodeDb ethdb.Database
+	if config.CodeColdDays > 0 || coldCodeExists(ctx) {
+		// Before any state reads, so that the code already in the cold store is found, even if no more code is moved there
+		if coldCodeDb, err = ctx.OpenDatabase("coldcode"); err != nil {
+			return nil, err
+		}
+		coldCode = state.NewColdCodeTier(chainDb, coldCodeDb, config.CodeColdDays, state.DefaultColdCodeInterval)
+		state.UseColdCodeTier(coldCode)
+	}
 	if ctx.Config.RemoteDbListenAddress != "" {
 		if casted, ok := chainDb.(ethdb.HasAbstractKV); ok {
-			remotedbserver.StartDeprecated(casted.AbstractKV(), ctx.Config.RemoteDbListenAddress)
+			opts := remotedbserver.DefaultServerOpts
+			if ctx.Config.RemoteDbServerOpts != nil {
+				opts = *ctx.Config.RemoteDbServerOpts
+			}
+			remotedbserver.StartDeprecatedWithOpts(remoteKV(casted, coldCodeDb), ctx.Config.RemoteDbListenAddress, opts)
+		}
+	}
+	if ctx.Config.RemoteDbGrpcListenAddress != "" {
+		if casted, ok := chainDb.(ethdb.HasAbstractKV); ok {
+			if _, err = remotedbserver.StartGrpc(remoteKV(casted, coldCodeDb), ctx.Config.RemoteDbGrpcListenAddress); err != nil {
+				return nil, err
+			}
 		}
 	}
 
@@ -169,6 +205,8 @@ func New(ctx *node.ServiceContext, config *Config) (*Ethereum, error) {
 		etherbase:         config.Miner.Etherbase,
 		bloomRequests:     make(chan chan *bloombits.Retrieval),
 		bloomIndexer:      NewBloomIndexer(chainDb, params.BloomBitsBlocks, params.BloomConfirms),
+		coldCode:          coldCode,
+		coldCodeDb:        coldCodeDb,
 	}
 
 	log.Info("Initialising Ethereum protocol", "versions", ProtocolVersions, "network", config.NetworkID)
@@ -211,10 +249,23 @@ func New(ctx *node.ServiceContext, config *Config) (*Ethereum, error) {
 	if err != nil {
 		return nil, err
 	}
+	if err = migrations.ApplyStateSchema(chainDb, core.UsePlainStateExecution); err != nil {
+		return nil, err
+	}
+	if err = migrations.ApplySplitState(chainDb, config.SplitState); err != nil {
+		return nil, err
+	}
+	if err = migrations.ApplyStorageChangeSetV2(chainDb, config.StorageChangeSetV2); err != nil {
+		return nil, err
+	}
+	if err = migrations.ApplyHistoryIndexFilter(chainDb, config.HistoryIndexAllow, config.HistoryIndexDeny, config.HistoryIndexRebuild); err != nil {
+		return nil, err
+	}
 
 	var (
 		vmConfig = vm.Config{
 			EnablePreimageRecording: config.EnablePreimageRecording,
+			BatchStorageReads:       config.BatchStorageReads,
 			EWASMInterpreter:        config.EWASMInterpreter,
 			EVMInterpreter:          config.EVMInterpreter,
 		}
@@ -230,6 +281,13 @@ func New(ctx *node.ServiceContext, config *Config) (*Ethereum, error) {
 			DownloadOnly:        config.DownloadOnly,
 			NoHistory:           !config.StorageMode.History,
 			ArchiveSyncInterval: uint64(config.ArchiveSyncInterval),
+			FlatHashing:         config.FlatHashing,
+			PinnedStorage:       config.PinnedStorage,
+			HotAccounts:         config.HotAccounts,
+			HashingWorkers:      config.HashingWorkers,
+			RetainListBudget:    config.RetainListBudget,
+			HistoryCommitWindow: config.HistoryCommitWindow,
+			AsyncCommit:         config.AsyncCommit,
 		}
 	)
 	eth.blockchain, err = core.NewBlockChain(chainDb, cacheConfig, chainConfig, eth.engine, vmConfig, eth.shouldPreserve, &config.TxLookupLimit)
@@ -274,6 +332,7 @@ func New(ctx *node.ServiceContext, config *Config) (*Ethereum, error) {
 	}
 
 	eth.protocolManager.SetDataDir(ctx.Config.DataDir)
+	eth.protocolManager.SetTxAddressIndex(config.TxAddressIndex, config.TxAddressIndexKeep)
 
 	if config.SyncMode != downloader.StagedSync {
 		eth.miner = miner.New(eth, &config.Miner, chainConfig, eth.EventMux(), eth.engine, eth.isLocalBlock)
@@ -297,6 +356,27 @@ func New(ctx *node.ServiceContext, config *Config) (*Ethereum, error) {
 	return eth, nil
 }
 
+// coldCodeExists tells whether the cold store of the contract code has been created by the earlier runs
+func coldCodeExists(ctx *node.ServiceContext) bool {
+	if ctx.Config.DataDir == "" {
+		return false
+	}
+	for _, name := range []string{"coldcode", "coldcode_badger"} {
+		if _, err := os.Stat(ctx.ResolvePath(name)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteKV is the KV served to the remote readers, the code moved to the cold store is read from coldCodeDb
+func remoteKV(db ethdb.HasAbstractKV, coldCodeDb ethdb.Database) ethdb.KV {
+	if coldCodeDb == nil {
+		return db.AbstractKV()
+	}
+	return state.ColdCodeKV(db.AbstractKV(), coldCodeDb)
+}
+
 func makeExtraData(extra []byte) []byte {
 	if len(extra) == 0 {
 		// create default extradata
@@ -615,6 +695,29 @@ func (s *Ethereum) Start(srvr *p2p.Server) error {
 	// Start the bloom bits servicing goroutines
 	s.startBloomHandlers(params.BloomBitsBlocks)
 
+	if s.config.DatabaseStatsInterval > 0 {
+		if hasKV, ok := s.chainDb.(ethdb.HasKV); ok {
+			s.statsSampler = ethdb.NewStatsSampler(hasKV, s.config.DatabaseStatsInterval, ethdb.DefaultStatsRetention)
+			s.statsSampler.Start()
+		} else {
+			log.Warn("Sampling of the database stats is only supported for Bolt")
+		}
+	}
+	if s.config.DatabaseScrubInterval > 0 {
+		if boltDb, ok := s.chainDb.(*ethdb.BoltDatabase); ok {
+			s.scrubber = ethdb.NewScrubber(boltDb, ethdb.DefaultChecksumBuckets, s.config.DatabaseScrubInterval)
+			s.scrubber.Start()
+		}
+	}
+	if s.coldCode != nil && s.config.CodeColdDays > 0 {
+		s.coldCode.Start()
+	}
+	retention := ethdb.HistoryRetention{FullBlocks: s.config.HistoryRetentionFull, AccountBlocks: s.config.HistoryRetentionAccounts}
+	if retention.Enabled() {
+		s.pruner = core.NewHistoryPruner(s.chainDb, s.blockchain, retention, core.DefaultHistoryPruneInterval)
+		s.pruner.Start()
+	}
+
 	// Start the RPC service
 	s.netRPCService = ethapi.NewPublicNetAPI(srvr, s.NetVersion())
 
@@ -650,6 +753,20 @@ func (s *Ethereum) Stop() error {
 	s.miner.Stop()
 	s.blockchain.Stop()
 	s.engine.Close()
+	if s.statsSampler != nil {
+		s.statsSampler.Stop()
+	}
+	if s.scrubber != nil {
+		s.scrubber.Stop()
+	}
+	if s.pruner != nil {
+		s.pruner.Stop()
+	}
+	if s.coldCode != nil {
+		s.coldCode.Stop()
+		state.UseColdCodeTier(nil)
+		s.coldCodeDb.Close()
+	}
 	s.chainDb.Close()
 	s.eventMux.Stop()
 	return nil
@@ -685,10 +802,10 @@ func setStorageModeIfNotExist(db ethdb.Database, sm StorageMode) error {
 
 func setModeOnEmpty(db ethdb.Database, key []byte, currentValue bool) error {
 	_, err := db.Get(dbutils.DatabaseInfoBucket, key)
-	if err != nil && err != ethdb.ErrKeyNotFound {
+	if err != nil && !errors.Is(err, ethdb.ErrKeyNotFound) {
 		return err
 	}
-	if err == ethdb.ErrKeyNotFound {
+	if errors.Is(err, ethdb.ErrKeyNotFound) {
 		val := []byte{}
 		if currentValue {
 			val = []byte{1}
@@ -708,31 +825,31 @@ func GetStorageModeFromDB(db ethdb.Database) (StorageMode, error) {
 		err error
 	)
 	v, err = db.Get(dbutils.DatabaseInfoBucket, dbutils.StorageModeHistory)
-	if err != nil && err != ethdb.ErrKeyNotFound {
+	if err != nil && !errors.Is(err, ethdb.ErrKeyNotFound) {
 		return StorageMode{}, err
 	}
 	sm.History = len(v) > 0
 
 	v, err = db.Get(dbutils.DatabaseInfoBucket, dbutils.StorageModePreImages)
-	if err != nil && err != ethdb.ErrKeyNotFound {
+	if err != nil && !errors.Is(err, ethdb.ErrKeyNotFound) {
 		return StorageMode{}, err
 	}
 	sm.Preimages = len(v) > 0
 
 	v, err = db.Get(dbutils.DatabaseInfoBucket, dbutils.StorageModeReceipts)
-	if err != nil && err != ethdb.ErrKeyNotFound {
+	if err != nil && !errors.Is(err, ethdb.ErrKeyNotFound) {
 		return StorageMode{}, err
 	}
 	sm.Receipts = len(v) > 0
 
 	v, err = db.Get(dbutils.DatabaseInfoBucket, dbutils.StorageModeTxIndex)
-	if err != nil && err != ethdb.ErrKeyNotFound {
+	if err != nil && !errors.Is(err, ethdb.ErrKeyNotFound) {
 		return StorageMode{}, err
 	}
 	sm.TxIndex = len(v) > 0
 
 	v, err = db.Get(dbutils.DatabaseInfoBucket, dbutils.StorageModeThinHistory)
-	if err != nil && err != ethdb.ErrKeyNotFound {
+	if err != nil && !errors.Is(err, ethdb.ErrKeyNotFound) {
 		return StorageMode{}, err
 	}
 
@@ -18,6 +18,7 @@ package eth
 
 import (
 	"context"
+	"errors"
 	"time"
 
 	"github.com/ledgerwatch/turbo-geth/common/dbutils"
@@ -64,7 +65,7 @@ func (eth *Ethereum) startBloomHandlers(sectionSize uint64) {
 					task.Bitsets = make([][]byte, len(task.Sections))
 					for i, section := range task.Sections {
 						head := rawdb.ReadCanonicalHash(eth.chainDb, (section+1)*sectionSize-1)
-						if compVector, err := rawdb.ReadBloomBits(eth.chainDb, task.Bit, section, head); err == nil || err == ethdb.ErrKeyNotFound {
+						if compVector, err := rawdb.ReadBloomBits(eth.chainDb, task.Bit, section, head); err == nil || errors.Is(err, ethdb.ErrKeyNotFound) {
 							// If bloombits are empty when compressed, the corresponding records are missing
 							if blob, err := bitutil.DecompressBytes(compVector, int(sectionSize/8)); err == nil {
 								task.Bitsets[i] = blob
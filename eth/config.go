@@ -159,6 +159,20 @@ type Config struct {
 	// download them
 	DownloadOnly        bool
 	ArchiveSyncInterval int
+	FlatHashing         bool             // Compute state roots by streaming the database instead of the trie cache
+	PinnedStorage       []common.Address // Contracts which storage tries are always kept resolved in the trie cache
+	HotAccounts         []common.Address // Accounts preloaded into the caches and always kept resolved in the account trie
+	HashingWorkers      int              // Number of goroutines hashing the state trie concurrently
+	RetainListBudget    uint64           // Memory limit (MB) of the read/change sets of a block, above which they are spilled to the database
+	HistoryCommitWindow uint64           // Number of blocks, the updates of the history indexes of which are written at once
+	SplitState          bool             // Keep the accounts and the storage of the current state in separate buckets
+	StorageChangeSetV2  bool             // Convert the storage changesets to the v2 layout, grouping the changes by contract (not reversible)
+	AsyncCommit         bool             // Commit the inserted blocks in the background, while the next blocks are processed
+	HistoryIndexAllow   []common.Address // Accounts, the history of which is indexed (empty - all of them)
+	HistoryIndexDeny    []common.Address // Accounts, the history of which is not indexed
+	HistoryIndexRebuild bool             // Rebuild the history indexes, if the filter includes the accounts, which were not indexed
+	TxAddressIndex      bool             // Index the transactions by the addresses of their senders and recipients (staged sync)
+	TxAddressIndexKeep  uint64           // Number of the last blocks kept in the transaction address index (0 - all)
 	BlocksBeforePruning uint64
 	BlocksToPrune       uint64
 	PruningTimeout      time.Duration
@@ -183,6 +197,15 @@ type Config struct {
 	DatabaseCache      int
 	DatabaseFreezer    string
 
+	DatabaseStatsInterval time.Duration // How often to sample the sizes of the database buckets, 0 - never
+	DatabaseScrubInterval time.Duration // How often to scrub the next key range of the checksummed buckets, 0 - no checksums
+
+	CodeColdDays int // Number of days, after which the unread contract code is moved to the cold store (see state.ColdCodeTier), 0 - never
+
+	// History retention policy (see ethdb.HistoryRetention), 0 - keep forever
+	HistoryRetentionFull     uint64 // Number of the last blocks, for which the storage history is kept
+	HistoryRetentionAccounts uint64 // Number of the last blocks, for which the account history is kept
+
 	TrieCleanCache int
 	TrieDirtyCache int
 	TrieTimeout    time.Duration
@@ -203,6 +226,9 @@ type Config struct {
 	// Enables tracking of SHA3 preimages in the VM
 	EnablePreimageRecording bool
 
+	// Executes the transactions speculatively first, to read their storage in batches
+	BatchStorageReads bool
+
 	// Miscellaneous options
 	DocRoot string `toml:"-"`
 
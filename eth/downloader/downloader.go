@@ -158,6 +158,9 @@ type Downloader struct {
 	// generate history index, disable/enable pruning
 	history bool
 	datadir string
+	// index the transactions by address, keeping the last txAddressIndexKeep blocks (0 - all)
+	txAddressIndex     bool
+	txAddressIndexKeep uint64
 }
 
 // LightChain encapsulates functions required to synchronise a light chain.
@@ -271,6 +274,13 @@ func (d *Downloader) SetDataDir(datadir string) {
 	d.datadir = datadir
 }
 
+// SetTxAddressIndex enables the staged sync stage indexing the transactions by the addresses of their senders
+// and recipients, pruned to the last keep blocks (0 - not pruned)
+func (d *Downloader) SetTxAddressIndex(enabled bool, keep uint64) {
+	d.txAddressIndex = enabled
+	d.txAddressIndexKeep = keep
+}
+
 // Progress retrieves the synchronisation boundaries, specifically the origin
 // block where synchronisation started at (may have failed/suspended); the block
 // or header sync is currently at; and the latest known block which the sync targets.
@@ -1665,6 +1675,7 @@ func splitAroundPivot(pivot uint64, results []*fetchResult) (p *fetchResult, bef
 func (d *Downloader) commitPivotBlock(result *fetchResult) error {
 	block := types.NewBlockWithHeader(result.Header).WithBody(result.Transactions, result.Uncles)
 	log.Debug("Committing fast sync pivot as new head", "number", block.Number(), "hash", block.Hash())
+	defer ethdb.PauseGC(d.stateDB)()
 
 	// Commit the pivot block as the new head, will require full sync from here on
 	if _, err := d.blockchain.InsertReceiptChain([]*types.Block{block}, []types.Receipts{result.Receipts}, d.ancientLimit); err != nil {
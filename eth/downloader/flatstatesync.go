@@ -0,0 +1,293 @@
+package downloader
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+	"github.com/ledgerwatch/turbo-geth/core/state"
+	"github.com/ledgerwatch/turbo-geth/core/types/accounts"
+	"github.com/ledgerwatch/turbo-geth/crypto"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+	"github.com/ledgerwatch/turbo-geth/log"
+	"github.com/ledgerwatch/turbo-geth/trie"
+)
+
+// FlatStateRangeWitness creates the witness of the range of accounts (together with their storage and code)
+// of the current state, starting from the position from. A position is the hashed key of an account, optionally
+// followed by the hashed key of its storage item, then the range starts inside the storage of the account.
+// The range ends, when it has at least maxLeaves entries of dbutils.CurrentStateBucket or maxBytes bytes
+// of them and of the code, so the large storage is split between the ranges. Returns the root of the state
+// and the position of the last entry of the range, last == nil if the range reaches the end of the state
+func FlatStateRangeWitness(db ethdb.Database, from []byte, maxLeaves, maxBytes int) (w *trie.Witness, root common.Hash, last []byte, err error) {
+	startKey, err := flatStateRangeStart(db, from)
+	if err != nil {
+		return nil, common.Hash{}, nil, err
+	}
+	var leaves, size int
+	var full bool
+	last = common.CopyBytes(from[:common.HashLength])
+	var lastStorage []byte                // The last storage item of the last account, nil - the account itself
+	codes := make(map[common.Hash][]byte) // addrHash => code
+	if err = db.Walk(dbutils.CurrentStateBucket, startKey, 0, func(k, v []byte) (bool, error) {
+		if len(k) == common.HashLength {
+			if full {
+				// The range ends with the whole previous account
+				lastStorage = nil
+				return false, nil
+			}
+			last, lastStorage = common.CopyBytes(k), nil
+			var acc accounts.Account
+			if err1 := acc.DecodeForStorage(v); err1 != nil {
+				return false, err1
+			}
+			if acc.Incarnation > 0 && acc.CodeHash != trie.EmptyCodeHash {
+				codeHash, err1 := db.Get(dbutils.ContractCodeBucket, dbutils.GenerateStoragePrefix(k, acc.Incarnation))
+				if err1 != nil {
+					return false, fmt.Errorf("code hash of %x: %w", k, err1)
+				}
+				code, err1 := state.PeekCode(db, common.BytesToHash(codeHash))
+				if err1 != nil {
+					return false, fmt.Errorf("code %x: %w", codeHash, err1)
+				}
+				codes[common.BytesToHash(k)] = code
+				size += len(code)
+			}
+		} else {
+			// The range ends inside the storage of the account, after at least one of its items
+			if full && lastStorage != nil {
+				return false, nil
+			}
+			lastStorage = common.CopyBytes(k[common.HashLength+common.IncarnationLength:])
+		}
+		leaves++
+		size += len(k) + len(v)
+		full = leaves >= maxLeaves || size >= maxBytes
+		return true, nil
+	}); err != nil {
+		return nil, common.Hash{}, nil, err
+	}
+	if !full {
+		last, lastStorage = nil, nil
+	}
+	if lastStorage != nil {
+		last = append(last, lastStorage...)
+	}
+
+	rr := trie.NewRetainAccountRange(from, last)
+	loader := trie.NewFlatDbSubTrieLoader()
+	if err = loader.Reset(db, rr, [][]byte{nil}, []int{0}, false); err != nil {
+		return nil, common.Hash{}, nil, err
+	}
+	subTries, err := loader.LoadSubTries()
+	if err != nil {
+		return nil, common.Hash{}, nil, err
+	}
+	root = subTries.Hashes[0]
+	t := trie.New(root)
+	if err = t.HookSubTries(subTries, [][]byte{nil}); err != nil {
+		return nil, common.Hash{}, nil, err
+	}
+	for addrHash, code := range codes {
+		if err = t.UpdateAccountCode(addrHash[:], code); err != nil {
+			return nil, common.Hash{}, nil, err
+		}
+		rr.AddCodeTouch(crypto.Keccak256Hash(code))
+	}
+	if w, err = t.ExtractWitness(false, rr); err != nil {
+		return nil, common.Hash{}, nil, err
+	}
+	return w, root, last, nil
+}
+
+// flatStateRangeStart returns the key of dbutils.CurrentStateBucket, from which the range starts. Inside the storage
+// of the account, the key includes the incarnation of the account
+func flatStateRangeStart(db ethdb.Getter, from []byte) ([]byte, error) {
+	switch len(from) {
+	case common.HashLength:
+		return from, nil
+	case 2 * common.HashLength:
+		enc, err := db.Get(dbutils.CurrentStateBucket, from[:common.HashLength])
+		if err != nil {
+			return nil, fmt.Errorf("account %x of the range: %w", from[:common.HashLength], err)
+		}
+		var acc accounts.Account
+		if err = acc.DecodeForStorage(enc); err != nil {
+			return nil, err
+		}
+		return dbutils.GenerateCompositeStorageKey(common.BytesToHash(from[:common.HashLength]), acc.Incarnation, common.BytesToHash(from[common.HashLength:])), nil
+	default:
+		return nil, fmt.Errorf("range has to start with a hashed key, optionally followed by a hashed storage key, got %x", from)
+	}
+}
+
+// FlatStateSync downloads the state with the given root by the consecutive ranges of the hashed keys
+// (see FlatStateRangeWitness), verifying every range against the root and writing it into the state buckets.
+// The progress is saved with every range, so the download continues after a restart with the same root
+type FlatStateSync struct {
+	db   ethdb.Database
+	root common.Hash
+	next []byte // The first hashed key of the next range
+	done bool
+
+	accounts, storage int
+}
+
+// NewFlatStateSync creates the download of the state, resuming the interrupted one
+func NewFlatStateSync(db ethdb.Database, root common.Hash) (*FlatStateSync, error) {
+	s := &FlatStateSync{db: db, root: root, next: make([]byte, common.HashLength)}
+	v, err := db.Get(dbutils.DatabaseInfoBucket, dbutils.FlatStateSyncProgressKey)
+	if err != nil && !errors.Is(err, ethdb.ErrKeyNotFound) {
+		return nil, err
+	}
+	if len(v) < common.HashLength {
+		return s, nil
+	}
+	if progressRoot := common.BytesToHash(v[:common.HashLength]); progressRoot != root {
+		return nil, fmt.Errorf("download of the state %x was interrupted, but the state %x is downloaded now; remove the partially downloaded database", progressRoot, root)
+	}
+	if len(v) == common.HashLength {
+		s.done = true
+	} else {
+		s.next = common.CopyBytes(v[common.HashLength:])
+		log.Info("Resuming state download", "root", root, "from", fmt.Sprintf("%x", s.next))
+	}
+	return s, nil
+}
+
+// Root is the root of the downloaded state
+func (s *FlatStateSync) Root() common.Hash {
+	return s.root
+}
+
+// Next returns the position (see FlatStateRangeWitness), from which the range is requested next
+func (s *FlatStateSync) Next() []byte {
+	return s.next
+}
+
+// Done tells whether the whole state is downloaded
+func (s *FlatStateSync) Done() bool {
+	return s.done
+}
+
+// Deliver verifies the witness of the range from..last (see FlatStateRangeWitness) and writes its accounts,
+// storage and code. from has to be the key returned by Next
+func (s *FlatStateSync) Deliver(from, last []byte, w *trie.Witness) error {
+	if s.done {
+		return errors.New("state download is complete")
+	}
+	if !bytes.Equal(from, s.next) {
+		return fmt.Errorf("unexpected range from %x, expected from %x", from, s.next)
+	}
+	if last != nil && (len(last) != common.HashLength && len(last) != 2*common.HashLength || comparePositions(last, from) < 0) {
+		return fmt.Errorf("invalid end of the range from %x: %x", from, last)
+	}
+	batch := s.db.NewBatch()
+	defer batch.Rollback()
+	receiver := &flatStateWriter{batch: batch}
+	if err := trie.VerifyRangeWitness(w, s.root, from, last, receiver); err != nil {
+		return fmt.Errorf("range from %x: %w", from, err)
+	}
+
+	progress := common.CopyBytes(s.root[:])
+	next := nextRangePosition(last)
+	if next != nil {
+		progress = append(progress, next...)
+	}
+	if err := batch.Put(dbutils.DatabaseInfoBucket, dbutils.FlatStateSyncProgressKey, progress); err != nil {
+		return err
+	}
+	if _, err := batch.Commit(); err != nil {
+		return err
+	}
+	s.accounts += receiver.accounts
+	s.storage += receiver.storage
+	if next == nil {
+		s.done = true
+		log.Info("State downloaded", "root", s.root, "accounts", s.accounts, "storage items", s.storage)
+	} else {
+		s.next = next
+		log.Info("Downloaded state range", "accounts", s.accounts, "storage items", s.storage, "next", fmt.Sprintf("%x", next))
+	}
+	return nil
+}
+
+// Finish rebuilds the intermediate hashes of the downloaded state, checking its root once more
+func (s *FlatStateSync) Finish(datadir string) error {
+	if !s.done {
+		return fmt.Errorf("state download is not complete, next range from %x", s.next)
+	}
+	return RegenerateIntermediateHashes(s.db, datadir, 0, s.root)
+}
+
+// nextRangePosition returns the position following the last one of the range, nil if there are no more keys.
+// After the last storage item of the account the next account follows
+func nextRangePosition(last []byte) []byte {
+	if len(last) == 2*common.HashLength {
+		if next := nextHashedKey(last[common.HashLength:]); next != nil {
+			return append(common.CopyBytes(last[:common.HashLength]), next...)
+		}
+		last = last[:common.HashLength]
+	}
+	return nextHashedKey(last)
+}
+
+// comparePositions compares the positions of the ranges, the account without the storage key
+// stands for all of its storage
+func comparePositions(a, b []byte) int {
+	m := len(a)
+	if m > len(b) {
+		m = len(b)
+	}
+	return bytes.Compare(a[:m], b[:m])
+}
+
+// nextHashedKey returns the key following the last key of the range, nil if there are no more keys
+func nextHashedKey(last []byte) []byte {
+	if last == nil {
+		return nil
+	}
+	next := common.CopyBytes(last)
+	for i := len(next) - 1; i >= 0; i-- {
+		next[i]++
+		if next[i] != 0 {
+			return next
+		}
+	}
+	return nil
+}
+
+// flatStateWriter writes the verified range into the state buckets. The accounts with the storage or code
+// get the first incarnation, as in a state, which has never been unwound
+type flatStateWriter struct {
+	batch             ethdb.DbWithPendingMutations
+	accounts, storage int
+}
+
+func (fw *flatStateWriter) Account(addrHash common.Hash, acc *accounts.Account, code []byte) error {
+	var account accounts.Account
+	account.Copy(acc)
+	account.Incarnation = 0
+	if account.Root != trie.EmptyRoot || account.CodeHash != trie.EmptyCodeHash {
+		account.Incarnation = state.FirstContractIncarnation
+	}
+	if code != nil {
+		if err := fw.batch.Put(dbutils.CodeBucket, common.CopyBytes(account.CodeHash[:]), common.CopyBytes(code)); err != nil {
+			return err
+		}
+		if err := fw.batch.Put(dbutils.ContractCodeBucket, dbutils.GenerateStoragePrefix(addrHash[:], account.Incarnation), common.CopyBytes(account.CodeHash[:])); err != nil {
+			return err
+		}
+	}
+	data := make([]byte, account.EncodingLengthForStorage())
+	account.EncodeForStorage(data)
+	fw.accounts++
+	return fw.batch.Put(dbutils.CurrentStateBucket, common.CopyBytes(addrHash[:]), data)
+}
+
+func (fw *flatStateWriter) Storage(addrHash common.Hash, keyHash common.Hash, value []byte) error {
+	fw.storage++
+	return fw.batch.Put(dbutils.CurrentStateBucket, dbutils.GenerateCompositeStorageKey(addrHash, state.FirstContractIncarnation, keyHash), common.CopyBytes(value))
+}
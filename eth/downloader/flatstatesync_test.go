@@ -0,0 +1,171 @@
+package downloader
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+	"github.com/ledgerwatch/turbo-geth/core/types/accounts"
+	"github.com/ledgerwatch/turbo-geth/crypto"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+	"github.com/ledgerwatch/turbo-geth/trie"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// addContractCode attaches the code to the accounts with the storage created by generateHashedState
+func addContractCode(t *testing.T, db ethdb.Database) {
+	for i := 0; i < 300; i += 20 {
+		addrHash := crypto.Keccak256Hash([]byte(fmt.Sprintf("account-%d", i)))
+		enc, err := db.Get(dbutils.CurrentStateBucket, addrHash[:])
+		require.NoError(t, err)
+		var acc accounts.Account
+		require.NoError(t, acc.DecodeForStorage(enc))
+		code := []byte(fmt.Sprintf("code-%d", i))
+		acc.CodeHash = crypto.Keccak256Hash(code)
+		require.NoError(t, db.Put(dbutils.CodeBucket, acc.CodeHash[:], code))
+		require.NoError(t, db.Put(dbutils.ContractCodeBucket, dbutils.GenerateStoragePrefix(addrHash[:], acc.Incarnation), acc.CodeHash[:]))
+		value := make([]byte, acc.EncodingLengthForStorage())
+		acc.EncodeForStorage(value)
+		require.NoError(t, db.Put(dbutils.CurrentStateBucket, addrHash[:], value))
+	}
+}
+
+func bucketContent(t *testing.T, db ethdb.Database, bucket []byte) map[string]string {
+	content := make(map[string]string)
+	require.NoError(t, db.Walk(bucket, nil, 0, func(k, v []byte) (bool, error) {
+		content[string(k)] = string(v)
+		return true, nil
+	}))
+	return content
+}
+
+func TestFlatStateSync(t *testing.T) {
+	src := ethdb.NewMemDatabase()
+	defer src.Close()
+	generateHashedState(t, src)
+	addContractCode(t, src)
+	root := loadStateRoot(t, src)
+
+	dst := ethdb.NewMemDatabase()
+	defer dst.Close()
+	datadir := getDataDir()
+	defer os.RemoveAll(datadir)
+
+	fss, err := NewFlatStateSync(dst, root)
+	require.NoError(t, err)
+	var ranges int
+	for !fss.Done() {
+		from := fss.Next()
+		w, wRoot, last, err := FlatStateRangeWitness(src, from, 200, 1<<20)
+		require.NoError(t, err)
+		assert.Equal(t, root, wRoot)
+		require.NoError(t, fss.Deliver(from, last, w))
+		ranges++
+		if ranges == 3 {
+			// Resume the interrupted download
+			fss, err = NewFlatStateSync(dst, root)
+			require.NoError(t, err)
+			assert.Equal(t, nextRangePosition(last), fss.Next())
+		}
+	}
+	assert.True(t, ranges > 3, "the state has to be downloaded in several ranges, got %d", ranges)
+	require.NoError(t, fss.Finish(datadir))
+
+	for _, bucket := range [][]byte{dbutils.CurrentStateBucket, dbutils.ContractCodeBucket, dbutils.CodeBucket} {
+		assert.Equal(t, bucketContent(t, src, bucket), bucketContent(t, dst, bucket), "bucket %s", bucket)
+	}
+	assert.Equal(t, root, loadStateRoot(t, dst))
+
+	// The completed download is not repeated, another state is not downloaded into the same database
+	fss, err = NewFlatStateSync(dst, root)
+	require.NoError(t, err)
+	assert.True(t, fss.Done())
+	_, err = NewFlatStateSync(dst, common.Hash{1})
+	assert.Error(t, err)
+}
+
+func TestFlatStateSyncStorageRanges(t *testing.T) {
+	src := ethdb.NewMemDatabase()
+	defer src.Close()
+	generateHashedState(t, src)
+	addContractCode(t, src)
+	root := loadStateRoot(t, src)
+
+	dst := ethdb.NewMemDatabase()
+	defer dst.Close()
+	datadir := getDataDir()
+	defer os.RemoveAll(datadir)
+
+	fss, err := NewFlatStateSync(dst, root)
+	require.NoError(t, err)
+	var ranges, resumed, rejected int
+	for !fss.Done() {
+		from := fss.Next()
+		if len(from) == 2*common.HashLength {
+			resumed++
+		}
+		// Smaller than the storage of the contracts, so the ranges end inside of it
+		w, _, last, err := FlatStateRangeWitness(src, from, 20, 1<<20)
+		require.NoError(t, err)
+		if len(last) == 2*common.HashLength && last[common.HashLength] < 0x80 {
+			// The witness does not prove the rest of the storage of the last account (the neighbouring
+			// leaves are in the witness, so the range has to end far enough from the end of the storage)
+			err = fss.Deliver(from, last[:common.HashLength], w)
+			assert.True(t, errors.Is(err, trie.ErrRangeWitness), "got %v", err)
+			rejected++
+		}
+		require.NoError(t, fss.Deliver(from, last, w))
+		ranges++
+		if ranges%5 == 0 {
+			fss, err = NewFlatStateSync(dst, root)
+			require.NoError(t, err)
+			assert.Equal(t, nextRangePosition(last), fss.Next())
+		}
+	}
+	assert.True(t, resumed > 0, "no range resumed inside the storage")
+	assert.True(t, rejected > 0, "no incomplete storage range was checked")
+	require.NoError(t, fss.Finish(datadir))
+	for _, bucket := range [][]byte{dbutils.CurrentStateBucket, dbutils.ContractCodeBucket, dbutils.CodeBucket} {
+		assert.Equal(t, bucketContent(t, src, bucket), bucketContent(t, dst, bucket), "bucket %s", bucket)
+	}
+
+	// The byte limit splits the storage as well
+	_, _, last, err := FlatStateRangeWitness(src, make([]byte, common.HashLength), 1<<20, 1000)
+	require.NoError(t, err)
+	require.NotNil(t, last)
+}
+
+func TestFlatStateSyncRejectsIncompleteRange(t *testing.T) {
+	src := ethdb.NewMemDatabase()
+	defer src.Close()
+	generateHashedState(t, src)
+	root := loadStateRoot(t, src)
+
+	dst := ethdb.NewMemDatabase()
+	defer dst.Close()
+	fss, err := NewFlatStateSync(dst, root)
+	require.NoError(t, err)
+	from := fss.Next()
+	w, _, last, err := FlatStateRangeWitness(src, from, 100, 1<<20)
+	require.NoError(t, err)
+	require.NotNil(t, last)
+
+	// The witness does not prove the accounts after the last one
+	err = fss.Deliver(from, nil, w)
+	assert.True(t, errors.Is(err, trie.ErrRangeWitness), "got %v", err)
+	// The witness of another state
+	fss2, err := NewFlatStateSync(ethdb.NewMemDatabase(), common.Hash{1})
+	require.NoError(t, err)
+	err = fss2.Deliver(from, last, w)
+	assert.True(t, errors.Is(err, trie.ErrWitnessRootMismatch), "got %v", err)
+
+	// Nothing is written by the rejected ranges
+	assert.Empty(t, bucketContent(t, dst, dbutils.CurrentStateBucket))
+	assert.Equal(t, from, fss.Next())
+	require.NoError(t, fss.Deliver(from, last, w))
+	assert.NotEmpty(t, bucketContent(t, dst, dbutils.CurrentStateBucket))
+}
@@ -0,0 +1,239 @@
+// Copyright 2020 The turbo-geth Authors
+// This file is part of the turbo-geth library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package downloader
+
+import (
+	"fmt"
+
+	"github.com/ledgerwatch/turbo-geth/core"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+	"github.com/ledgerwatch/turbo-geth/log"
+	"github.com/ledgerwatch/turbo-geth/params"
+)
+
+// Stage is one stage of the staged sync, see StageRunner. Forward brings the stage up to the progress of the previous
+// stages, Unwind rolls it back to the unwind point and clears the unwind point (see SaveStageUnwind).
+// The progress of the stage is persisted under its ID (see SaveStageProgress)
+type Stage interface {
+	ID() SyncStage
+	Description() string
+	// DisabledReason tells how to enable the stage, which is then skipped by the forward runs, empty if it is enabled
+	DisabledReason() string
+	Forward() error
+	Unwind(unwindPoint uint64) error
+}
+
+// StageFuncs is the Stage made of the functions, the stage without UnwindFunc can not be unwound
+type StageFuncs struct {
+	StageID     SyncStage
+	Name        string
+	Disabled    string
+	ForwardFunc func() error
+	UnwindFunc  func(unwindPoint uint64) error
+}
+
+func (s *StageFuncs) ID() SyncStage          { return s.StageID }
+func (s *StageFuncs) Description() string    { return s.Name }
+func (s *StageFuncs) DisabledReason() string { return s.Disabled }
+func (s *StageFuncs) Forward() error         { return s.ForwardFunc() }
+
+func (s *StageFuncs) Unwind(unwindPoint uint64) error {
+	if s.UnwindFunc == nil {
+		return fmt.Errorf("stage %s can not be unwound", s.StageID)
+	}
+	return s.UnwindFunc(unwindPoint)
+}
+
+// StageRunner runs the stages forward in the order of their declaration, which is the order of their IDs,
+// and unwinds them in the reverse order. The first stage (the headers) finds the reorgs and marks the following
+// stages to be unwound (see UnwindAllStages), so their pending unwinds are done right after it
+type StageRunner struct {
+	db     ethdb.Database
+	stages []Stage
+}
+
+// NewStagedSync creates the sync of the stages, which have to be declared in the order of their IDs
+func NewStagedSync(db ethdb.Database, stages ...Stage) (*StageRunner, error) {
+	for i := 1; i < len(stages); i++ {
+		if stages[i].ID() <= stages[i-1].ID() {
+			return nil, fmt.Errorf("stage %s is declared after stage %s", stages[i].ID(), stages[i-1].ID())
+		}
+	}
+	return &StageRunner{db: db, stages: stages}, nil
+}
+
+// Stages returns the stages in the order of their declaration
+func (s *StageRunner) Stages() []Stage {
+	return s.stages
+}
+
+func (s *StageRunner) stageIndex(id SyncStage) (int, error) {
+	for i, stage := range s.stages {
+		if stage.ID() == id {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("stage %s is not declared", id)
+}
+
+// Run runs all the enabled stages forward, doing the pending unwinds after the first stage
+func (s *StageRunner) Run() error {
+	for i, stage := range s.stages {
+		prefix := fmt.Sprintf("Sync stage %d/%d.", i+1, len(s.stages))
+		if reason := stage.DisabledReason(); reason != "" {
+			log.Info(fmt.Sprintf("%s %s is disabled. %s", prefix, stage.Description(), reason))
+		} else {
+			log.Info(fmt.Sprintf("%s %s...", prefix, stage.Description()))
+			if err := stage.Forward(); err != nil {
+				return err
+			}
+			log.Info(fmt.Sprintf("%s %s... Complete!", prefix, stage.Description()))
+		}
+		if i == 0 {
+			log.Info("Checking for unwinding...")
+			if err := s.Unwind(); err != nil {
+				return err
+			}
+			log.Info("Checking for unwinding... Complete!")
+		}
+	}
+	return nil
+}
+
+// RunStage runs one stage forward, even if it is disabled
+func (s *StageRunner) RunStage(id SyncStage) error {
+	i, err := s.stageIndex(id)
+	if err != nil {
+		return err
+	}
+	return s.stages[i].Forward()
+}
+
+// Unwind does the pending unwinds of the stages (see GetStageUnwind) in the reverse order.
+// The garbage collection of the database is paused meanwhile
+func (s *StageRunner) Unwind() error {
+	defer ethdb.PauseGC(s.db)()
+	for i := len(s.stages) - 1; i >= 0; i-- {
+		stage := s.stages[i]
+		unwindPoint, err := GetStageUnwind(s.db, stage.ID())
+		if err != nil {
+			return err
+		}
+		if unwindPoint == 0 {
+			continue
+		}
+		if err = stage.Unwind(unwindPoint); err != nil {
+			return fmt.Errorf("error unwinding stage: %s: %w", stage.ID(), err)
+		}
+	}
+	return nil
+}
+
+// ResetStage unwinds the stage and all the stages after it to the genesis, in the reverse order,
+// so that the next run redoes them from scratch
+func (s *StageRunner) ResetStage(id SyncStage) error {
+	first, err := s.stageIndex(id)
+	if err != nil {
+		return err
+	}
+	defer ethdb.PauseGC(s.db)()
+	for i := len(s.stages) - 1; i >= first; i-- {
+		stage := s.stages[i]
+		progress, err := GetStageProgress(s.db, stage.ID())
+		if err != nil {
+			return err
+		}
+		if progress == 0 {
+			continue
+		}
+		log.Info("Resetting stage", "stage", stage.ID(), "progress", progress)
+		if err = stage.Unwind(0); err != nil {
+			return fmt.Errorf("error resetting stage: %s: %w", stage.ID(), err)
+		}
+		if err = SaveStageProgress(s.db, stage.ID(), 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DatabaseStages declares the stages after the execution, which only need the database, so they can be run
+// outside of the node. The history indices are only enabled with the history, the transaction address index
+// only if txAddressIndex is set
+func DatabaseStages(db ethdb.Database, datadir string, config *params.ChainConfig, history, txAddressIndex bool, txAddressIndexKeep uint64) []Stage {
+	var historyDisabled, txAddressIndexDisabled string
+	if !history {
+		historyDisabled = "Enable by adding `h` to --storage-mode"
+	}
+	if !txAddressIndex {
+		txAddressIndexDisabled = "Enable by --txaddrindex"
+	}
+	return []Stage{
+		&StageFuncs{
+			StageID: HashCheck,
+			Name:    "Validating final hash",
+			ForwardFunc: func() error {
+				syncHeadNumber, err := GetStageProgress(db, Execution)
+				if err != nil {
+					return err
+				}
+				return spawnCheckFinalHashStage(db, syncHeadNumber, datadir)
+			},
+			UnwindFunc: func(unwindPoint uint64) error { return unwindHashCheckStage(unwindPoint, db) },
+		},
+		&StageFuncs{
+			StageID:     AccountHistoryIndex,
+			Name:        "Generating account history index",
+			Disabled:    historyDisabled,
+			ForwardFunc: func() error { return spawnAccountHistoryIndex(db, datadir, core.UsePlainStateExecution) },
+			UnwindFunc: func(unwindPoint uint64) error {
+				return unwindAccountHistoryIndex(unwindPoint, db, core.UsePlainStateExecution)
+			},
+		},
+		&StageFuncs{
+			StageID:     StorageHistoryIndex,
+			Name:        "Generating storage history index",
+			Disabled:    historyDisabled,
+			ForwardFunc: func() error { return spawnStorageHistoryIndex(db, datadir, core.UsePlainStateExecution) },
+			UnwindFunc: func(unwindPoint uint64) error {
+				return unwindStorageHistoryIndex(unwindPoint, db, core.UsePlainStateExecution)
+			},
+		},
+		&StageFuncs{
+			StageID:     IncarnationHistoryIndex,
+			Name:        "Generating incarnation history index",
+			Disabled:    historyDisabled,
+			ForwardFunc: func() error { return spawnIncarnationHistoryIndex(db, core.UsePlainStateExecution) },
+			UnwindFunc: func(unwindPoint uint64) error {
+				return unwindIncarnationHistoryIndex(unwindPoint, db, core.UsePlainStateExecution)
+			},
+		},
+		&StageFuncs{
+			StageID:     LogIndex,
+			Name:        "Generating log index",
+			ForwardFunc: func() error { return spawnLogIndex(db) },
+			UnwindFunc:  func(unwindPoint uint64) error { return unwindLogIndex(unwindPoint, db) },
+		},
+		&StageFuncs{
+			StageID:     TxAddressIndex,
+			Name:        "Generating transaction address index",
+			Disabled:    txAddressIndexDisabled,
+			ForwardFunc: func() error { return spawnTxAddressIndex(db, config, txAddressIndexKeep) },
+			UnwindFunc:  func(unwindPoint uint64) error { return unwindTxAddressIndex(unwindPoint, db) },
+		},
+	}
+}
@@ -0,0 +1,84 @@
+package downloader
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+)
+
+func TestStagedSyncOrder(t *testing.T) {
+	db := ethdb.NewMemDatabase()
+	defer db.Close()
+	var calls []string
+	stage := func(id SyncStage, disabled string) Stage {
+		return &StageFuncs{
+			StageID:  id,
+			Name:     id.String(),
+			Disabled: disabled,
+			ForwardFunc: func() error {
+				calls = append(calls, "forward "+id.String())
+				return SaveStageProgress(db, id, 10)
+			},
+			UnwindFunc: func(unwindPoint uint64) error {
+				calls = append(calls, fmt.Sprintf("unwind %s to %d", id, unwindPoint))
+				if err := SaveStageUnwind(db, id, 0); err != nil {
+					return err
+				}
+				return SaveStageProgress(db, id, unwindPoint)
+			},
+		}
+	}
+	if _, err := NewStagedSync(db, stage(Execution, ""), stage(Senders, "")); err == nil {
+		t.Fatal("stages out of order are accepted")
+	}
+	s, err := NewStagedSync(db, stage(Headers, ""), stage(Senders, ""), stage(Execution, ""), stage(LogIndex, "disabled"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = s.Run(); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"forward headers", "forward senders", "forward execution"}
+	if !reflect.DeepEqual(calls, expected) {
+		t.Fatalf("first run: %q, expected %q", calls, expected)
+	}
+
+	// The reorg found by the headers stage unwinds the rest in the reverse order
+	calls = nil
+	if err = UnwindAllStages(db, 5); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Run(); err != nil {
+		t.Fatal(err)
+	}
+	expected = []string{"forward headers", "unwind execution to 5", "unwind senders to 5", "forward senders", "forward execution"}
+	if !reflect.DeepEqual(calls, expected) {
+		t.Fatalf("run with unwind: %q, expected %q", calls, expected)
+	}
+
+	calls = nil
+	if err = s.ResetStage(Senders); err != nil {
+		t.Fatal(err)
+	}
+	expected = []string{"unwind execution to 0", "unwind senders to 0"}
+	if !reflect.DeepEqual(calls, expected) {
+		t.Fatalf("reset: %q, expected %q", calls, expected)
+	}
+	for _, id := range []SyncStage{Senders, Execution} {
+		if progress, err := GetStageProgress(db, id); err != nil || progress != 0 {
+			t.Errorf("stage %s progress %d after the reset, %v", id, progress, err)
+		}
+	}
+	if progress, _ := GetStageProgress(db, Headers); progress != 10 {
+		t.Errorf("headers progress %d after the reset of the later stage", progress)
+	}
+	if err = s.RunStage(LogIndex); err != nil {
+		t.Fatal(err)
+	}
+	if name := calls[len(calls)-1]; name != "forward log_index" {
+		t.Errorf("disabled stage is not run explicitly: %q", calls)
+	}
+}
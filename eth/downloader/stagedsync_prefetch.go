@@ -0,0 +1,98 @@
+package downloader
+
+import (
+	"sync"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+	"github.com/ledgerwatch/turbo-geth/core"
+	"github.com/ledgerwatch/turbo-geth/core/types"
+	"github.com/ledgerwatch/turbo-geth/crypto"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+	"github.com/ledgerwatch/turbo-geth/params"
+)
+
+// ExecutionPrefetchDepth is the number of blocks ahead of the executed one, which are read, decoded and have their
+// senders recovered in the background during the Execution stage (0 = disabled)
+var ExecutionPrefetchDepth = 16
+
+// blockPrefetcher reads the blocks following the executed one, recovers the senders of their transactions
+// (unless the Senders stage has done it already) and reads the accounts of the senders and the recipients,
+// so that the database pages are warm by the time the block is executed. At most depth blocks are kept in memory.
+// The accounts are not put into the caches of the state readers, because the prefetcher only sees the committed state
+type blockPrefetcher struct {
+	config   *params.ChainConfig
+	getBlock func(number uint64) *types.Block
+	db       ethdb.Getter
+	blocks   chan *types.Block
+	quit     chan struct{}
+	wg       sync.WaitGroup
+}
+
+func newBlockPrefetcher(config *params.ChainConfig, getBlock func(number uint64) *types.Block, db ethdb.Getter, from uint64, depth int) *blockPrefetcher {
+	p := &blockPrefetcher{
+		config:   config,
+		getBlock: getBlock,
+		db:       db,
+		blocks:   make(chan *types.Block, depth),
+		quit:     make(chan struct{}),
+	}
+	p.wg.Add(1)
+	go p.run(from)
+	return p
+}
+
+func (p *blockPrefetcher) run(from uint64) {
+	defer p.wg.Done()
+	defer close(p.blocks)
+	for number := from; ; number++ {
+		block := p.getBlock(number)
+		if block == nil {
+			return
+		}
+		p.prepare(block)
+		select {
+		case p.blocks <- block:
+		case <-p.quit:
+			return
+		}
+	}
+}
+
+func (p *blockPrefetcher) prepare(block *types.Block) {
+	signer := types.MakeSigner(p.config, block.Number())
+	for _, tx := range block.Transactions() {
+		select {
+		case <-p.quit:
+			return
+		default:
+		}
+		// The sender is cached in the transaction, the errors are reported by the execution
+		if from, err := types.Sender(signer, tx); err == nil {
+			p.warmAccount(from)
+		}
+		if to := tx.To(); to != nil {
+			p.warmAccount(*to)
+		}
+	}
+}
+
+func (p *blockPrefetcher) warmAccount(address common.Address) {
+	if core.UsePlainStateExecution {
+		_, _ = p.db.Get(dbutils.PlainStateBucket, address[:])
+		return
+	}
+	addrHash := crypto.Keccak256(address[:])
+	_, _ = p.db.Get(dbutils.CurrentStateBucket, addrHash)
+}
+
+// next returns the next block, nil when there are no more blocks
+func (p *blockPrefetcher) next() *types.Block {
+	return <-p.blocks
+}
+
+// stop terminates the prefetching and waits for the background goroutine to exit
+func (p *blockPrefetcher) stop() {
+	close(p.quit)
+	p.wg.Wait()
+}
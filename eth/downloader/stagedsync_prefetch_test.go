@@ -0,0 +1,58 @@
+package downloader
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/core/types"
+	"github.com/ledgerwatch/turbo-geth/crypto"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+	"github.com/ledgerwatch/turbo-geth/params"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBlockPrefetcher(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	sender := crypto.PubkeyToAddress(key.PublicKey)
+	config := params.TestChainConfig
+	signer := types.MakeSigner(config, big.NewInt(1))
+
+	blocks := make(map[uint64]*types.Block)
+	for number := uint64(1); number <= 10; number++ {
+		tx, err1 := types.SignTx(types.NewTransaction(number, common.Address{1}, big.NewInt(1), 21000, big.NewInt(1), nil), signer, key)
+		require.NoError(t, err1)
+		// The transactions come without the cached senders, as if the Senders stage has not run
+		require.False(t, tx.HasFrom())
+		blocks[number] = types.NewBlock(&types.Header{Number: new(big.Int).SetUint64(number)}, []*types.Transaction{tx}, nil, nil)
+	}
+	getBlock := func(number uint64) *types.Block {
+		return blocks[number]
+	}
+
+	p := newBlockPrefetcher(config, getBlock, ethdb.NewMemDatabase(), 3, 2)
+	defer p.stop()
+	for number := uint64(3); number <= 10; number++ {
+		block := p.next()
+		require.NotNil(t, block)
+		assert.Equal(t, number, block.NumberU64())
+		tx := block.Transactions()[0]
+		assert.True(t, tx.HasFrom())
+		from, err1 := types.Sender(signer, tx)
+		require.NoError(t, err1)
+		assert.Equal(t, sender, from)
+	}
+	assert.Nil(t, p.next())
+}
+
+func TestBlockPrefetcherStop(t *testing.T) {
+	getBlock := func(number uint64) *types.Block {
+		return types.NewBlock(&types.Header{Number: new(big.Int).SetUint64(number)}, nil, nil, nil)
+	}
+	p := newBlockPrefetcher(params.TestChainConfig, getBlock, ethdb.NewMemDatabase(), 1, 4)
+	assert.Equal(t, uint64(1), p.next().NumberU64())
+	// The prefetcher blocked on the full channel exits
+	p.stop()
+}
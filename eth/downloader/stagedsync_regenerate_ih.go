@@ -0,0 +1,162 @@
+package downloader
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"sort"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+	"github.com/ledgerwatch/turbo-geth/common/debug"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+	"github.com/ledgerwatch/turbo-geth/log"
+	"github.com/ledgerwatch/turbo-geth/trie"
+)
+
+// RegenerateIntermediateHashes drops dbutils.IntermediateTrieHashBucket (and dbutils.IntermediateTrieWitnessLenBucket)
+// and rebuilds them from scratch, by streaming dbutils.CurrentStateBucket through the hash builder and collecting
+// the hashes of the branch nodes. Only the prefixes of at most depth nibbles are kept, for the storage the depth
+// counts from the storage root (depth <= 0 = all the prefixes). The hashes are sorted in the temporary files
+// in datadir before being written, so the memory use does not depend on the size of the state.
+// If expectedRoot is not empty, the computed state root is checked against it
+func RegenerateIntermediateHashes(db ethdb.Database, datadir string, depth int, expectedRoot common.Hash) error {
+	if err := clearIntermediateHashes(db); err != nil {
+		return err
+	}
+
+	hashes := newBucketCollector(datadir, dbutils.IntermediateTrieHashBucket)
+	defer hashes.close()
+	var witnessLens *bucketCollector
+	if debug.IsTrackWitnessSizeEnabled() {
+		witnessLens = newBucketCollector(datadir, dbutils.IntermediateTrieWitnessLenBucket)
+		defer witnessLens.close()
+	}
+
+	var collectErr error
+	var keyBuf []byte
+	loader := trie.NewFlatDbSubTrieLoader()
+	loader.SetIntermediateHashObserver(func(prefix []byte, incarnation uint64, hash []byte, witnessLen uint64) {
+		// only put to bucket prefixes with even number of nibbles, see state.IntermediateHashes
+		if collectErr != nil || len(prefix) == 0 || len(prefix)%2 == 1 {
+			return
+		}
+		if depth > 0 {
+			if l := len(prefix); (l < 2*common.HashLength && l > depth) || (l >= 2*common.HashLength && l-2*common.HashLength > depth) {
+				return
+			}
+		}
+		trie.CompressNibbles(prefix, &keyBuf)
+		var key []byte
+		if len(keyBuf) >= common.HashLength {
+			key = dbutils.GenerateCompositeStoragePrefix(keyBuf[:common.HashLength], incarnation, keyBuf[common.HashLength:])
+		} else {
+			key = common.CopyBytes(keyBuf)
+		}
+		if collectErr = hashes.collect(key, common.CopyBytes(hash)); collectErr != nil {
+			return
+		}
+		if witnessLens != nil {
+			lenBytes := make([]byte, 8)
+			binary.BigEndian.PutUint64(lenBytes, witnessLen)
+			collectErr = witnessLens.collect(key, lenBytes)
+		}
+	})
+	if err := loader.Reset(db, trie.NewRetainList(0), [][]byte{nil}, []int{0}, false); err != nil {
+		return err
+	}
+	log.Info("Regenerating intermediate hashes", "depth", depth)
+	subTries, err := loader.LoadSubTries()
+	if err != nil {
+		return err
+	}
+	if collectErr != nil {
+		return collectErr
+	}
+	if len(subTries.Hashes) != 1 {
+		return fmt.Errorf("expected 1 hash, got %d", len(subTries.Hashes))
+	}
+	if expectedRoot != (common.Hash{}) && subTries.Hashes[0] != expectedRoot {
+		return fmt.Errorf("wrong trie root: %x, expected: %x", subTries.Hashes[0], expectedRoot)
+	}
+
+	if err := hashes.load(db); err != nil {
+		return err
+	}
+	if witnessLens != nil {
+		if err := witnessLens.load(db); err != nil {
+			return err
+		}
+	}
+	log.Info("Regenerated intermediate hashes", "root", subTries.Hashes[0].Hex())
+	return nil
+}
+
+// clearIntermediateHashes drops the buckets of the intermediate hashes, so that the loader reads the whole state
+func clearIntermediateHashes(db ethdb.Database) error {
+	hasKV, ok := db.(ethdb.HasAbstractKV)
+	if !ok {
+		return fmt.Errorf("regeneration of the intermediate hashes is not supported for %T", db)
+	}
+	return hasKV.AbstractKV().Update(context.Background(), func(tx ethdb.Tx) error {
+		for _, bucket := range [][]byte{dbutils.IntermediateTrieHashBucket, dbutils.IntermediateTrieWitnessLenBucket} {
+			if err := tx.DropBucket(bucket); err != nil {
+				return err
+			}
+			if err := tx.CreateBucket(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// bucketCollector accumulates the entries of the bucket in the sortable buffer, flushing it to the temporary files
+// when it grows over the optimal size, and then merges the files into the bucket
+type bucketCollector struct {
+	datadir string
+	bucket  []byte
+	buffer  *sortableBuffer
+	files   []string
+}
+
+func newBucketCollector(datadir string, bucket []byte) *bucketCollector {
+	return &bucketCollector{datadir: datadir, bucket: bucket, buffer: newSortableBuffer()}
+}
+
+func (c *bucketCollector) collect(k, v []byte) error {
+	c.buffer.Put(k, v)
+	if c.buffer.Size() >= c.buffer.OptimalSize {
+		return c.flush()
+	}
+	return nil
+}
+
+func (c *bucketCollector) flush() error {
+	bufferSize := c.buffer.Size()
+	sort.Sort(c.buffer)
+	file, err := c.buffer.FlushToDisk(c.datadir)
+	if err != nil {
+		return err
+	}
+	if len(file) > 0 {
+		c.files = append(c.files, file)
+		log.Info("Intermediate hashes / created a buffer file", "bucket", string(c.bucket), "name", file, "size", bufferSize)
+	}
+	return nil
+}
+
+func (c *bucketCollector) load(db ethdb.Database) error {
+	if err := c.flush(); err != nil {
+		return err
+	}
+	if len(c.files) == 0 {
+		return nil
+	}
+	return mergeTempFilesIntoBucket(db, c.files, c.bucket)
+}
+
+func (c *bucketCollector) close() {
+	deleteFiles(c.files)
+	c.files = nil
+}
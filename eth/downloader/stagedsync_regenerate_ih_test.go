@@ -0,0 +1,86 @@
+package downloader
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+	"github.com/ledgerwatch/turbo-geth/core/types/accounts"
+	"github.com/ledgerwatch/turbo-geth/crypto"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+	"github.com/ledgerwatch/turbo-geth/trie"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func generateHashedState(t *testing.T, db ethdb.Database) {
+	for i := 0; i < 300; i++ {
+		addrHash := crypto.Keccak256Hash([]byte(fmt.Sprintf("account-%d", i)))
+		acc := accounts.NewAccount()
+		acc.Initialised = true
+		acc.Balance.SetUint64(uint64(i + 1))
+		if i%10 == 0 {
+			acc.Incarnation = 1
+			for j := 0; j < 50; j++ {
+				loc := crypto.Keccak256Hash([]byte(fmt.Sprintf("location-%d", j)))
+				require.NoError(t, db.Put(dbutils.CurrentStateBucket, dbutils.GenerateCompositeStorageKey(addrHash, acc.Incarnation, loc), []byte{byte(j + 1)}))
+			}
+		}
+		value := make([]byte, acc.EncodingLengthForStorage())
+		acc.EncodeForStorage(value)
+		require.NoError(t, db.Put(dbutils.CurrentStateBucket, addrHash[:], value))
+	}
+}
+
+func loadStateRoot(t *testing.T, db ethdb.Database) common.Hash {
+	loader := trie.NewFlatDbSubTrieLoader()
+	require.NoError(t, loader.Reset(db, trie.NewRetainList(0), [][]byte{nil}, []int{0}, false))
+	subTries, err := loader.LoadSubTries()
+	require.NoError(t, err)
+	require.Len(t, subTries.Hashes, 1)
+	return subTries.Hashes[0]
+}
+
+func TestRegenerateIntermediateHashes(t *testing.T) {
+	db := ethdb.NewMemDatabase()
+	defer db.Close()
+	datadir := getDataDir()
+	defer os.RemoveAll(datadir)
+	generateHashedState(t, db)
+	expectedRoot := loadStateRoot(t, db)
+	// A stale record, which has to be dropped
+	require.NoError(t, db.Put(dbutils.IntermediateTrieHashBucket, []byte{0xff}, common.Hash{1}.Bytes()))
+	require.NoError(t, RegenerateIntermediateHashes(db, datadir, 0, expectedRoot))
+
+	var accountKeys, storageKeys int
+	require.NoError(t, db.Walk(dbutils.IntermediateTrieHashBucket, nil, 0, func(k, v []byte) (bool, error) {
+		assert.NotEqual(t, []byte{0xff}, k)
+		assert.Len(t, v, common.HashLength)
+		if len(k) >= common.HashLength {
+			storageKeys++
+		} else {
+			accountKeys++
+		}
+		return true, nil
+	}))
+	assert.True(t, accountKeys > 0)
+	assert.True(t, storageKeys > 0)
+	// The loader skips the sub-tries covered by the regenerated hashes and gets the same root
+	assert.Equal(t, expectedRoot, loadStateRoot(t, db))
+
+	// Only the top of the trie is kept with the limited depth
+	require.NoError(t, RegenerateIntermediateHashes(db, datadir, 2, expectedRoot))
+	require.NoError(t, db.Walk(dbutils.IntermediateTrieHashBucket, nil, 0, func(k, v []byte) (bool, error) {
+		if len(k) >= common.HashLength {
+			assert.True(t, len(k) <= common.HashLength+8+1, "storage key %x", k)
+		} else {
+			assert.Len(t, k, 1)
+		}
+		return true, nil
+	}))
+	assert.Equal(t, expectedRoot, loadStateRoot(t, db))
+
+	assert.Error(t, RegenerateIntermediateHashes(db, datadir, 0, common.Hash{1}))
+}
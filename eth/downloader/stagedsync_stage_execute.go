@@ -15,6 +15,7 @@ import (
 	"github.com/ledgerwatch/turbo-geth/core"
 	"github.com/ledgerwatch/turbo-geth/core/rawdb"
 	"github.com/ledgerwatch/turbo-geth/core/state"
+	"github.com/ledgerwatch/turbo-geth/core/types"
 	"github.com/ledgerwatch/turbo-geth/core/types/accounts"
 	"github.com/ledgerwatch/turbo-geth/ethdb"
 	"github.com/ledgerwatch/turbo-geth/log"
@@ -70,7 +71,6 @@ func (l *progressLogger) Stop() {
 }
 
 const StateBatchSize = 50 * 1024 * 1024 // 50 Mb
-const ChangeBatchSize = 1024 * 2014     // 1 Mb
 
 func spawnExecuteBlocksStage(stateDB ethdb.Database, blockchain BlockChain) (uint64, error) {
 	lastProcessedBlockNumber, err := GetStageProgress(stateDB, Execution)
@@ -93,8 +93,11 @@ func spawnExecuteBlocksStage(stateDB ethdb.Database, blockchain BlockChain) (uin
 			return lastProcessedBlockNumber, err
 		}
 	*/
-	stateBatch := stateDB.NewBatch()
-	changeBatch := stateDB.NewBatch()
+	// The batches are committed between the blocks only, when the state batch reaches its size.
+	// The changesets are committed together with the state and the progress (see ethdb.CommitTogether),
+	// a crash can't leave the state of the blocks without their changesets, which the unwinding needs
+	stateBatch := ethdb.NewBatchWithPolicy(stateDB, ethdb.CommitPolicy{MaxBytes: StateBatchSize, AtCheckpoints: true})
+	changeBatch := ethdb.NewBatchWithPolicy(stateDB, ethdb.CommitPolicy{MaxBytes: StateBatchSize, AtCheckpoints: true})
 
 	progressLogger := NewProgressLogger(logInterval, stateBatch)
 	progressLogger.Start(&nextBlockNumber)
@@ -105,13 +108,30 @@ func spawnExecuteBlocksStage(stateDB ethdb.Database, blockchain BlockChain) (uin
 	codeCache := fastcache.New(32 * 1024 * 1024)     // 32 Mb (the minimum)
 	codeSizeCache := fastcache.New(32 * 1024 * 1024) // 32 Mb (the minimum)
 
+	var accountFilter *state.AccountFilter
+	if state.AccountFilterCapacity > 0 && !core.UsePlainStateExecution {
+		if accountFilter, err = state.OpenAccountFilter(stateDB, lastProcessedBlockNumber, state.AccountFilterCapacity, state.AccountFilterFalsePositiveRate); err != nil {
+			return 0, err
+		}
+	}
+
 	chainConfig := blockchain.Config()
 	engine := blockchain.Engine()
 	vmConfig := blockchain.GetVMConfig()
+	var prefetcher *blockPrefetcher
+	if ExecutionPrefetchDepth > 0 {
+		prefetcher = newBlockPrefetcher(chainConfig, blockchain.GetBlockByNumber, stateDB, lastProcessedBlockNumber+1, ExecutionPrefetchDepth)
+		defer prefetcher.stop()
+	}
 	for {
 		blockNum := atomic.LoadUint64(&nextBlockNumber)
 
-		block := blockchain.GetBlockByNumber(blockNum)
+		var block *types.Block
+		if prefetcher != nil {
+			block = prefetcher.next()
+		} else {
+			block = blockchain.GetBlockByNumber(blockNum)
+		}
 		if block == nil {
 			break
 		}
@@ -138,20 +158,27 @@ func spawnExecuteBlocksStage(stateDB ethdb.Database, blockchain BlockChain) (uin
 			hashStateReader.SetStorageCache(storageCache)
 			hashStateReader.SetCodeCache(codeCache)
 			hashStateReader.SetCodeSizeCache(codeSizeCache)
+			hashStateReader.SetAccountFilter(accountFilter)
 			stateReader = hashStateReader
 			hashedStateWriter := state.NewDbStateWriter(stateBatch, changeBatch, blockNum)
 			hashedStateWriter.SetAccountCache(accountCache)
 			hashedStateWriter.SetStorageCache(storageCache)
 			hashedStateWriter.SetCodeCache(codeCache)
 			hashedStateWriter.SetCodeSizeCache(codeSizeCache)
+			hashedStateWriter.SetAccountFilter(accountFilter)
 			stateWriter = hashedStateWriter
 		}
 
 		// where the magic happens
-		err = core.ExecuteBlockEuphemerally(chainConfig, vmConfig, blockchain, engine, block, stateReader, stateWriter)
+		_, err = core.ExecuteBlockEuphemerally(chainConfig, vmConfig, blockchain, engine, block, stateReader, stateWriter)
 		if err != nil {
 			return 0, err
 		}
+		if hashedStateWriter, ok := stateWriter.(*state.DbStateWriter); ok {
+			if err = hashedStateWriter.WriteStateSize(); err != nil {
+				return 0, err
+			}
+		}
 
 		if err = SaveStageProgress(stateBatch, Execution, blockNum); err != nil {
 			return 0, err
@@ -159,18 +186,13 @@ func spawnExecuteBlocksStage(stateDB ethdb.Database, blockchain BlockChain) (uin
 
 		atomic.AddUint64(&nextBlockNumber, 1)
 
-		if stateBatch.BatchSize() >= StateBatchSize {
+		if ethdb.CommitDue(stateBatch) {
 			start := time.Now()
-			if _, err = stateBatch.Commit(); err != nil {
+			if _, err = ethdb.CommitTogether(stateBatch, changeBatch); err != nil {
 				return 0, err
 			}
 			log.Info("State batch committed", "in", time.Since(start))
 		}
-		if changeBatch.BatchSize() >= ChangeBatchSize {
-			if _, err = changeBatch.Commit(); err != nil {
-				return 0, err
-			}
-		}
 		/*
 			if blockNum-profileNumber == 100000 {
 				// Flush the profiler
@@ -178,13 +200,15 @@ func spawnExecuteBlocksStage(stateDB ethdb.Database, blockchain BlockChain) (uin
 			}
 		*/
 	}
-	_, err = stateBatch.Commit()
-	if err != nil {
-		return atomic.LoadUint64(&nextBlockNumber) - 1, fmt.Errorf("sync Execute: failed to write state batch commit: %v", err)
+	if accountFilter != nil {
+		accountFilter.SetBlockNr(atomic.LoadUint64(&nextBlockNumber) - 1)
+		if err = accountFilter.Save(stateBatch); err != nil {
+			return atomic.LoadUint64(&nextBlockNumber) - 1, fmt.Errorf("sync Execute: failed to save account filter: %v", err)
+		}
 	}
-	_, err = changeBatch.Commit()
+	_, err = ethdb.CommitTogether(stateBatch, changeBatch)
 	if err != nil {
-		return atomic.LoadUint64(&nextBlockNumber) - 1, fmt.Errorf("sync Execute: failed to write change batch commit: %v", err)
+		return atomic.LoadUint64(&nextBlockNumber) - 1, fmt.Errorf("sync Execute: failed to write state and change batches commit: %v", err)
 	}
 	return atomic.LoadUint64(&nextBlockNumber) - 1 /* the last processed block */, nil
 }
@@ -227,24 +251,48 @@ func unwindExecutionStage(unwindPoint uint64, stateDB ethdb.Database) error {
 	if err2 != nil {
 		return fmt.Errorf("unwind Execution: getting rewind data: %v", err)
 	}
+	// The state size is only tracked for the hashed state
+	var sizeDelta state.StateSize
+	trackSize := !core.UsePlainStateExecution
+	// The accounts restored by the unwind are added to the account filter, see state.UnwindAccountFilter
+	updateFilter := state.AccountFilterCapacity > 0 && !core.UsePlainStateExecution
+	var restored []common.Hash
 	for key, value := range accountMap {
 		if len(value) > 0 {
+			if updateFilter {
+				restored = append(restored, common.BytesToHash([]byte(key)))
+			}
 			var acc accounts.Account
 			if err = acc.DecodeForStorage(value); err != nil {
 				return err
 			}
 			// Fetch the code hash
 			recoverCodeHashFunc(&acc, stateDB, key)
+			if trackSize {
+				if err = sizeDelta.UnwindAccount(mutation, []byte(key), &acc); err != nil {
+					return err
+				}
+			}
 			if err = writeAccountFunc(mutation, key, acc); err != nil {
 				return err
 			}
 		} else {
+			if trackSize {
+				if err = sizeDelta.UnwindAccount(mutation, []byte(key), nil); err != nil {
+					return err
+				}
+			}
 			if err = deleteAccountFunc(mutation, key); err != nil {
 				return err
 			}
 		}
 	}
 	for key, value := range storageMap {
+		if trackSize {
+			if err = sizeDelta.UnwindStorage(mutation, []byte(key)[:storageKeyLength], value); err != nil {
+				return err
+			}
+		}
 		if len(value) > 0 {
 			if err = mutation.Put(stateBucket, []byte(key)[:storageKeyLength], value); err != nil {
 				return err
@@ -261,6 +309,22 @@ func unwindExecutionStage(unwindPoint uint64, stateDB ethdb.Database) error {
 			return err
 		}
 	}
+	// The plain changesets are not compacted
+	if !core.UsePlainStateExecution {
+		if err = ethdb.TruncateCompactedChangeSets(mutation, unwindPoint+1); err != nil {
+			return fmt.Errorf("unwind Execution: compacted changesets: %v", err)
+		}
+	}
+	if trackSize {
+		if err = state.UpdateStateSize(mutation, &sizeDelta, false); err != nil {
+			return err
+		}
+	}
+	if updateFilter {
+		if err = state.UnwindAccountFilter(stateDB, mutation, lastProcessedBlockNumber, unwindPoint, restored); err != nil {
+			return fmt.Errorf("unwind Execution: account filter: %v", err)
+		}
+	}
 	err = SaveStageUnwind(mutation, Execution, 0)
 	if err != nil {
 		return fmt.Errorf("unwind Execution: reset: %v", err)
@@ -371,5 +435,5 @@ func deleteChangeSets(batch ethdb.Deleter, timestamp uint64, accountBucket, stor
 	if err := batch.Delete(storageBucket, changeSetKey); err != nil {
 		return err
 	}
-	return nil
+	return ethdb.DeleteChangeSetSummary(batch, timestamp)
 }
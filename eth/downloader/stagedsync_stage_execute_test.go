@@ -1,11 +1,25 @@
 package downloader
 
 import (
+	"context"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"os/exec"
+	"path/filepath"
 	"testing"
 
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/common/changeset"
 	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+	"github.com/ledgerwatch/turbo-geth/consensus/ethash"
 	"github.com/ledgerwatch/turbo-geth/core"
+	"github.com/ledgerwatch/turbo-geth/core/state"
+	"github.com/ledgerwatch/turbo-geth/core/types"
+	"github.com/ledgerwatch/turbo-geth/core/vm"
+	"github.com/ledgerwatch/turbo-geth/crypto"
 	"github.com/ledgerwatch/turbo-geth/ethdb"
+	"github.com/ledgerwatch/turbo-geth/params"
 )
 
 func TestUnwindExecutionStageHashedStatic(t *testing.T) {
@@ -127,3 +141,215 @@ func TestUnwindExecutionStagePlainWithCodeChanges(t *testing.T) {
 
 	compareCurrentState(t, initialDb, mutation, dbutils.PlainStateBucket, dbutils.PlainContractCodeBucket)
 }
+
+func TestUnwindExecutionStageHashedAccountFilter(t *testing.T) {
+	defer func(capacity uint64) { state.AccountFilterCapacity = capacity }(state.AccountFilterCapacity)
+	state.AccountFilterCapacity = 1000
+	defer func(plain bool) { core.UsePlainStateExecution = plain }(core.UsePlainStateExecution)
+	core.UsePlainStateExecution = false
+
+	initialDb := ethdb.NewMemDatabase()
+	generateBlocks(t, 1, 50, hashedWriterGen(initialDb), changeCodeWithIncarnations)
+
+	mutation := ethdb.NewMemDatabase()
+	generateBlocks(t, 1, 100, hashedWriterGen(mutation), changeCodeWithIncarnations)
+	if err := SaveStageProgress(mutation, Execution, 100); err != nil {
+		t.Fatalf("error while saving progress: %v", err)
+	}
+	filter, err := state.RebuildAccountFilter(mutation, 100, state.AccountFilterCapacity, state.AccountFilterFalsePositiveRate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = filter.Save(mutation); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = unwindExecutionStage(50, mutation); err != nil {
+		t.Fatalf("error while unwinding state: %v", err)
+	}
+	// The filter is kept without the rebuild, and covers the accounts of the unwound state
+	unwound, err := state.LoadAccountFilter(mutation, 50)
+	if err != nil {
+		t.Fatalf("account filter is not unwound: %v", err)
+	}
+	if err = initialDb.Walk(dbutils.CurrentStateBucket, nil, 0, func(k, _ []byte) (bool, error) {
+		if len(k) == common.HashLength && !unwound.MayContain(common.BytesToHash(k)) {
+			t.Errorf("account %x is missing in the filter", k)
+		}
+		return true, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+var (
+	crashTestKey, _  = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+	crashTestAddress = crypto.PubkeyToAddress(crashTestKey.PublicKey)
+	crashTestGenesis = &core.Genesis{
+		Config: params.TestChainConfig,
+		Alloc:  core.GenesisAlloc{crashTestAddress: {Balance: big.NewInt(1000000000)}},
+	}
+)
+
+// crashingDatabase kills the process right after the first write into the database
+type crashingDatabase struct {
+	*ethdb.BoltDatabase
+}
+
+func (db crashingDatabase) MultiPut(tuples ...[]byte) (uint64, error) {
+	written, err := db.BoltDatabase.MultiPut(tuples...)
+	if err == nil {
+		os.Exit(3)
+	}
+	return written, err
+}
+
+// TestExecutionStageCrash runs the execution stage in the child process, which is killed after the first commit
+// of the stage, and checks that the state in the database is covered by the changesets, so it can be unwound
+func TestExecutionStageCrash(t *testing.T) {
+	if dir := os.Getenv("EXECUTION_STAGE_CRASH_DIR"); dir != "" {
+		executionStageCrashChild(dir)
+		return
+	}
+	defer func(plain bool) { core.UsePlainStateExecution = plain }(core.UsePlainStateExecution)
+	core.UsePlainStateExecution = false
+
+	dir, err := ioutil.TempDir("", "execution-crash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	chainDb, err := ethdb.NewBoltDatabase(filepath.Join(dir, "chaindata"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chainDb.Close()
+	genesis := crashTestGenesis.MustCommit(chainDb)
+	engine := ethash.NewFaker()
+	blockchain, err := core.NewBlockChain(chainDb, nil, crashTestGenesis.Config, engine, vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer := types.NewEIP155Signer(crashTestGenesis.Config.ChainID)
+	blocks, _ := core.GenerateChain(context.Background(), crashTestGenesis.Config, genesis, engine, chainDb.MemCopy(), 3, func(i int, block *core.BlockGen) {
+		tx, err := types.SignTx(types.NewTransaction(block.TxNonce(crashTestAddress), common.Address{byte(i + 1)}, big.NewInt(1000), 21000, new(big.Int), nil), signer, crashTestKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		block.AddTx(tx)
+	})
+	if _, err = blockchain.InsertChain(context.Background(), blocks); err != nil {
+		t.Fatal(err)
+	}
+	expected, err := state.NewDbStateReader(chainDb).ReadAccountData(crashTestAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blockchain.Stop()
+	chainDb.Close()
+
+	stateDb, err := ethdb.NewBoltDatabase(filepath.Join(dir, "statedata"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	crashTestGenesis.MustCommit(stateDb)
+	stateDb.Close()
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestExecutionStageCrash$")
+	cmd.Env = append(os.Environ(), "EXECUTION_STAGE_CRASH_DIR="+dir)
+	out, err := cmd.CombinedOutput()
+	if exitErr, ok := err.(*exec.ExitError); !ok || exitErr.ExitCode() != 3 {
+		t.Fatalf("the stage is not killed at the commit: %v\n%s", err, out)
+	}
+
+	stateDb, err = ethdb.NewBoltDatabase(filepath.Join(dir, "statedata"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stateDb.Close()
+	progress, err := GetStageProgress(stateDb, Execution)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if progress != uint64(len(blocks)) {
+		t.Fatalf("expected the progress %d, got %d", len(blocks), progress)
+	}
+	for blockNum := uint64(1); blockNum <= progress; blockNum++ {
+		if ok, err := stateDb.Has(dbutils.AccountChangeSetBucket, dbutils.EncodeTimestamp(blockNum)); err != nil || !ok {
+			t.Errorf("the changeset of the block %d is not written with the state: %v", blockNum, err)
+		}
+	}
+	acc, err := state.NewDbStateReader(stateDb).ReadAccountData(crashTestAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if acc == nil || acc.Nonce != expected.Nonce || acc.Balance.Cmp(&expected.Balance) != 0 {
+		t.Fatalf("expected the account %+v, got %+v", expected, acc)
+	}
+
+	if err = unwindExecutionStage(0, stateDb); err != nil {
+		t.Fatalf("error while unwinding state: %v", err)
+	}
+	acc, err = state.NewDbStateReader(stateDb).ReadAccountData(crashTestAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if acc == nil || acc.Nonce != 0 || acc.Balance.ToBig().Cmp(crashTestGenesis.Alloc[crashTestAddress].Balance) != 0 {
+		t.Fatalf("the account is not unwound to the genesis: %+v", acc)
+	}
+}
+
+func executionStageCrashChild(dir string) {
+	chainDb, err := ethdb.NewBoltDatabase(filepath.Join(dir, "chaindata"))
+	if err != nil {
+		os.Exit(1)
+	}
+	blockchain, err := core.NewBlockChain(chainDb, nil, crashTestGenesis.Config, ethash.NewFaker(), vm.Config{}, nil, nil)
+	if err != nil {
+		os.Exit(1)
+	}
+	stateDb, err := ethdb.NewBoltDatabase(filepath.Join(dir, "statedata"))
+	if err != nil {
+		os.Exit(1)
+	}
+	// The stage only returns if it doesn't write anything
+	_, _ = spawnExecuteBlocksStage(crashingDatabase{stateDb}, blockchain)
+	os.Exit(1)
+}
+
+func TestUnwindExecutionStageCompactedChangeSets(t *testing.T) {
+	defer func(plain bool) { core.UsePlainStateExecution = plain }(core.UsePlainStateExecution)
+	core.UsePlainStateExecution = false
+
+	unwindPoint := changeset.EpochSize - 10
+	initialDb := ethdb.NewMemDatabase()
+	generateBlocks(t, 1, unwindPoint, hashedWriterGen(initialDb), changeCodeWithIncarnations)
+
+	mutation := ethdb.NewMemDatabase()
+	generateBlocks(t, 1, changeset.EpochSize+50, hashedWriterGen(mutation), changeCodeWithIncarnations)
+	if err := SaveStageProgress(mutation, Execution, changeset.EpochSize+50); err != nil {
+		t.Fatalf("error while saving progress: %v", err)
+	}
+	if _, err := ethdb.CompactChangeSets(mutation, changeset.EpochSize); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := unwindExecutionStage(unwindPoint, mutation); err != nil {
+		t.Fatalf("error while unwinding state: %v", err)
+	}
+	compareCurrentState(t, initialDb, mutation, dbutils.CurrentStateBucket, dbutils.ContractCodeBucket)
+	// The changesets of the unwound blocks are removed from the compacted epoch
+	if _, err := ethdb.CompactChangeSets(initialDb, changeset.EpochSize); err != nil {
+		t.Fatal(err)
+	}
+	for _, csBucket := range [][]byte{dbutils.AccountChangeSetBucket, dbutils.StorageChangeSetBucket} {
+		compareCurrentState(t, initialDb, mutation, csBucket, dbutils.ChangeSetEpochBucket(csBucket))
+		if err := ethdb.WalkChangeSets(mutation, csBucket, unwindPoint+1, func(blockNr uint64, _ []byte) (bool, error) {
+			t.Errorf("the changeset of the unwound block %d is left in %s", blockNr, csBucket)
+			return true, nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
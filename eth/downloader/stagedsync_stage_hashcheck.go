@@ -15,6 +15,7 @@ import (
 	"github.com/ledgerwatch/turbo-geth/common/dbutils"
 	"github.com/ledgerwatch/turbo-geth/core"
 	"github.com/ledgerwatch/turbo-geth/core/rawdb"
+	"github.com/ledgerwatch/turbo-geth/core/state"
 	"github.com/ledgerwatch/turbo-geth/ethdb"
 	"github.com/ledgerwatch/turbo-geth/log"
 	"github.com/ledgerwatch/turbo-geth/trie"
@@ -37,6 +38,13 @@ func spawnCheckFinalHashStage(stateDB ethdb.Database, syncHeadNumber uint64, dat
 		return nil
 	}
 
+	schema, err := rawdb.ReadStateSchemaVersion(stateDB)
+	if err != nil {
+		return err
+	}
+	if schema == rawdb.UnhashedStateSchema {
+		return checkPlainStateRoot(stateDB, syncHeadNumber, datadir)
+	}
 
 	if core.UsePlainStateExecution {
 		err = promoteHashedState(stateDB, hashProgress, datadir)
@@ -72,6 +80,24 @@ func spawnCheckFinalHashStage(stateDB ethdb.Database, syncHeadNumber uint64, dat
 	return SaveStageProgress(stateDB, HashCheck, blockNr)
 }
 
+// checkPlainStateRoot validates the root hash of the chain, which keeps no hashed state, by hashing the plain state
+func checkPlainStateRoot(stateDB ethdb.Database, syncHeadNumber uint64, datadir string) error {
+	hash := rawdb.ReadCanonicalHash(stateDB, syncHeadNumber)
+	header := rawdb.ReadHeader(stateDB, hash, syncHeadNumber)
+	if header == nil {
+		return fmt.Errorf("no header of the sync head %d", syncHeadNumber)
+	}
+	log.Info("Validating root hash of the plain state", "block", syncHeadNumber, "blockRoot", header.Root.Hex())
+	root, err := state.PlainStateRoot(stateDB, datadir)
+	if err != nil {
+		return errors.Wrap(err, "checking root hash failed")
+	}
+	if root != header.Root {
+		return fmt.Errorf("wrong trie root: %x, expected (from header): %x", root, header.Root)
+	}
+	return SaveStageProgress(stateDB, HashCheck, syncHeadNumber)
+}
+
 func unwindHashCheckStage(unwindPoint uint64, stateDB ethdb.Database) error {
 	// Currently it does not require unwinding because it does not create any Intemediate Hash records
 	// and recomputes the state root from scratch
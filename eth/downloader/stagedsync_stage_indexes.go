@@ -5,6 +5,7 @@ import (
 	"bytes"
 	"container/heap"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -110,12 +111,19 @@ func mergeFilesIntoBucket(bufferFileNames []string, db ethdb.Database, bucket []
 		}
 	}
 	// By now, the heap has one element for each buffer file
-	batch := db.NewBatch()
+	var k []byte
+	batch := ethdb.NewBatchWithPolicy(db, ethdb.CommitPolicy{
+		OnCommit: func(written uint64) {
+			runtime.ReadMemStats(&m)
+			log.Info("Commited index batch", "bucket", string(bucket), "written", written, "current key", fmt.Sprintf("%x...", k[:4]),
+				"alloc", int(m.Alloc/1024), "sys", int(m.Sys/1024), "numGC", int(m.NumGC))
+		},
+	})
 	var nbytes [8]byte
 	for h.Len() > 0 {
 		element := (heap.Pop(h)).(HeapElem)
 		reader := readers[element.timeIdx]
-		k := element.key
+		k = element.key
 		// Read number of items for this key
 		var count int
 		if n, err := io.ReadFull(reader, nbytes[:]); err == nil && n == 8 {
@@ -134,7 +142,7 @@ func mergeFilesIntoBucket(bufferFileNames []string, db ethdb.Database, bucket []
 			blockNr := b &^ emptyValBit
 			currentChunkKey := dbutils.IndexChunkKey(k, ^uint64(0))
 			indexBytes, err1 := batch.Get(bucket, currentChunkKey)
-			if err1 != nil && err1 != ethdb.ErrKeyNotFound {
+			if err1 != nil && !errors.Is(err1, ethdb.ErrKeyNotFound) {
 				return fmt.Errorf("find chunk failed: %w", err1)
 			}
 			var index dbutils.HistoryIndexBytes
@@ -161,15 +169,6 @@ func mergeFilesIntoBucket(bufferFileNames []string, db ethdb.Database, bucket []
 			if err := batch.Put(bucket, currentChunkKey, index); err != nil {
 				return err
 			}
-			batchSize := batch.BatchSize()
-			if batchSize > batch.IdealBatchSize() {
-				if _, err := batch.Commit(); err != nil {
-					return err
-				}
-				runtime.ReadMemStats(&m)
-				log.Info("Commited index batch", "bucket", string(bucket), "size", common.StorageSize(batchSize), "current key", fmt.Sprintf("%x...", k[:4]),
-					"alloc", int(m.Alloc/1024), "sys", int(m.Sys/1024), "numGC", int(m.NumGC))
-			}
 		}
 		// Try to read the next key (reuse the element)
 		if n, err := io.ReadFull(reader, element.key); err == nil && n == keyLength {
@@ -341,6 +340,59 @@ func spawnStorageHistoryIndex(db ethdb.Database, datadir string, plainState bool
 	return nil
 }
 
+func spawnIncarnationHistoryIndex(db ethdb.Database, plainState bool) error {
+	var blockNum uint64
+	if lastProcessedBlockNumber, err := GetStageProgress(db, IncarnationHistoryIndex); err == nil {
+		if lastProcessedBlockNumber > 0 {
+			blockNum = lastProcessedBlockNumber + 1
+		}
+	} else {
+		return fmt.Errorf("reading incarnation history process: %v", err)
+	}
+	// All changesets up to the executed block are available
+	executedBlockNumber, err := GetStageProgress(db, Execution)
+	if err != nil {
+		return fmt.Errorf("reading execution process: %v", err)
+	}
+	if blockNum > executedBlockNumber {
+		return nil
+	}
+	if !plainState {
+		if err := checkAccountHistoryIndex(db, blockNum); err != nil {
+			return err
+		}
+	}
+	if err := core.GenerateIncarnationIndex(db, blockNum, plainState); err != nil {
+		return err
+	}
+	return SaveStageProgress(db, IncarnationHistoryIndex, executedBlockNumber)
+}
+
+// checkAccountHistoryIndex returns an error if the account history index misses some changesets starting from the block,
+// the incarnation index of the hashed state reads the accounts after their last changes via GetAsOf.
+// The blocks without changesets are not indexed, so the index is behind only if there are changesets after its progress
+func checkAccountHistoryIndex(db ethdb.Getter, from uint64) error {
+	indexed, err := GetStageProgress(db, AccountHistoryIndex)
+	if err != nil {
+		return fmt.Errorf("reading account history process: %v", err)
+	}
+	if from <= indexed {
+		from = indexed + 1
+	}
+	var notIndexed uint64
+	var found bool
+	if err := ethdb.WalkChangeSets(db, dbutils.AccountChangeSetBucket, from, func(blockNum uint64, _ []byte) (bool, error) {
+		notIndexed, found = blockNum, true
+		return false, nil
+	}); err != nil {
+		return err
+	}
+	if found {
+		return fmt.Errorf("account history index is behind: indexed up to block %d, changeset of block %d is not indexed", indexed, notIndexed)
+	}
+	return nil
+}
+
 func unwindAccountHistoryIndex(unwindPoint uint64, db ethdb.Database, plainState bool) error {
 	ig := core.NewIndexGenerator(db)
 	return ig.Truncate(unwindPoint, dbutils.AccountChangeSetBucket, dbutils.AccountsHistoryBucket, walkerFactory(dbutils.AccountChangeSetBucket, plainState))
@@ -351,6 +403,16 @@ func unwindStorageHistoryIndex(unwindPoint uint64, db ethdb.Database, plainState
 	return ig.Truncate(unwindPoint, dbutils.StorageChangeSetBucket, dbutils.StorageHistoryBucket, walkerFactory(dbutils.StorageChangeSetBucket, plainState))
 }
 
+func unwindIncarnationHistoryIndex(unwindPoint uint64, db ethdb.Database, plainState bool) error {
+	if err := core.TruncateIncarnationIndex(db, unwindPoint, plainState); err != nil {
+		return err
+	}
+	if err := SaveStageUnwind(db, IncarnationHistoryIndex, 0); err != nil {
+		return err
+	}
+	return SaveStageProgress(db, IncarnationHistoryIndex, unwindPoint)
+}
+
 func walkerFactory(csBucket []byte, plainState bool) func(bytes []byte) core.ChangesetWalker {
 	switch {
 	case bytes.Equal(csBucket, dbutils.AccountChangeSetBucket) && !plainState:
@@ -1,7 +1,13 @@
 package downloader
 
 import (
+	"strings"
 	"testing"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/common/changeset"
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
 )
 
 func TestName(t *testing.T) {
@@ -13,3 +19,57 @@ func TestName(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestIncarnationHistoryIndexChecksAccountIndex(t *testing.T) {
+	db := ethdb.NewMemDatabase()
+	defer db.Close()
+	for blockNum := uint64(1); blockNum <= 3; blockNum++ {
+		cs := changeset.NewAccountChangeSet()
+		if err := cs.Add(common.HexToHash("0x01").Bytes(), []byte{}); err != nil {
+			t.Fatal(err)
+		}
+		v, err := changeset.EncodeAccounts(cs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Put(dbutils.AccountChangeSetBucket, dbutils.EncodeTimestamp(blockNum), v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := SaveStageProgress(db, Execution, 3); err != nil {
+		t.Fatal(err)
+	}
+
+	// The changesets of the blocks 2 and 3 are not indexed, the accounts after them can't be read via GetAsOf
+	if err := SaveStageProgress(db, AccountHistoryIndex, 1); err != nil {
+		t.Fatal(err)
+	}
+	err := spawnIncarnationHistoryIndex(db, false)
+	if err == nil || !strings.Contains(err.Error(), "changeset of block 2 is not indexed") {
+		t.Fatalf("expected the error about the account history index, got %v", err)
+	}
+	if progress, _ := GetStageProgress(db, IncarnationHistoryIndex); progress != 0 {
+		t.Errorf("progress of the failed stage: %d", progress)
+	}
+
+	if err := SaveStageProgress(db, AccountHistoryIndex, 3); err != nil {
+		t.Fatal(err)
+	}
+	if err := spawnIncarnationHistoryIndex(db, false); err != nil {
+		t.Fatal(err)
+	}
+	if progress, _ := GetStageProgress(db, IncarnationHistoryIndex); progress != 3 {
+		t.Errorf("wrong progress: %d", progress)
+	}
+
+	// The blocks without changesets don't need the index
+	if err := SaveStageProgress(db, Execution, 5); err != nil {
+		t.Fatal(err)
+	}
+	if err := spawnIncarnationHistoryIndex(db, false); err != nil {
+		t.Fatal(err)
+	}
+	if progress, _ := GetStageProgress(db, IncarnationHistoryIndex); progress != 5 {
+		t.Errorf("wrong progress: %d", progress)
+	}
+}
@@ -0,0 +1,45 @@
+package downloader
+
+import (
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/core"
+	"github.com/ledgerwatch/turbo-geth/core/rawdb"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+	"github.com/ledgerwatch/turbo-geth/log"
+)
+
+// spawnLogIndex backfills the log index for the blocks, receipts of which were written before the index existed,
+// then re-encodes the receipts of the epochs completed since with the dictionaries (see rawdb.CompactReceipts)
+func spawnLogIndex(db ethdb.Database) error {
+	lastBlock, err := core.GenerateLogIndex(db)
+	if err != nil {
+		return err
+	}
+	stats, err := rawdb.CompactReceipts(db, lastBlock)
+	if err != nil {
+		return err
+	}
+	if stats.Epochs > 0 {
+		log.Info("Compacted receipts", "epochs", stats.Epochs, "blocks", stats.Blocks,
+			"before", common.StorageSize(stats.Before), "after", common.StorageSize(stats.After), "dictionaries", common.StorageSize(stats.Dictionaries))
+	}
+	return SaveStageProgress(db, LogIndex, lastBlock)
+}
+
+// unwindLogIndex moves the progress of the index back, so that the blocks of the new chain are re-indexed,
+// the blocks of the abandoned chain, which are left in the index, are filtered out by checking the receipts
+func unwindLogIndex(unwindPoint uint64, db ethdb.Database) error {
+	progress, ok, err := rawdb.ReadLogIndexProgress(db)
+	if err != nil {
+		return err
+	}
+	if ok && progress > unwindPoint {
+		if err := rawdb.WriteLogIndexProgress(db, unwindPoint); err != nil {
+			return err
+		}
+	}
+	if err := SaveStageUnwind(db, LogIndex, 0); err != nil {
+		return err
+	}
+	return SaveStageProgress(db, LogIndex, unwindPoint)
+}
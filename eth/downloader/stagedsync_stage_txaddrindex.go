@@ -0,0 +1,54 @@
+package downloader
+
+import (
+	"github.com/ledgerwatch/turbo-geth/core"
+	"github.com/ledgerwatch/turbo-geth/core/rawdb"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+	"github.com/ledgerwatch/turbo-geth/log"
+	"github.com/ledgerwatch/turbo-geth/params"
+)
+
+// txAddressIndexPruneStep is the number of blocks, by which the pruning boundary has to advance
+// before the index is pruned again, every pruning walks the whole index
+const txAddressIndexPruneStep = 100000
+
+// spawnTxAddressIndex indexes the transactions of the new blocks (and of the blocks written before the index was enabled)
+// by their senders and recipients, and prunes the index to the last keep blocks (0 - the index is not pruned)
+func spawnTxAddressIndex(db ethdb.Database, config *params.ChainConfig, keep uint64) error {
+	lastBlock, err := core.GenerateTxAddressIndex(db, config)
+	if err != nil {
+		return err
+	}
+	if keep > 0 && lastBlock >= keep {
+		pruned, err := rawdb.ReadTxAddressIndexPruned(db)
+		if err != nil {
+			return err
+		}
+		if before := lastBlock - keep + 1; before >= pruned+txAddressIndexPruneStep {
+			chunks, err := rawdb.PruneTxAddressIndex(db, before)
+			if err != nil {
+				return err
+			}
+			log.Info("Pruned transaction address index", "before block", before, "chunks", chunks)
+		}
+	}
+	return SaveStageProgress(db, TxAddressIndex, lastBlock)
+}
+
+// unwindTxAddressIndex moves the progress of the index back, so that the blocks of the new chain are re-indexed,
+// the blocks of the abandoned chain, which are left in the index, are filtered out by checking the transactions
+func unwindTxAddressIndex(unwindPoint uint64, db ethdb.Database) error {
+	progress, ok, err := rawdb.ReadTxAddressIndexProgress(db)
+	if err != nil {
+		return err
+	}
+	if ok && progress > unwindPoint {
+		if err := rawdb.WriteTxAddressIndexProgress(db, unwindPoint); err != nil {
+			return err
+		}
+	}
+	if err := SaveStageUnwind(db, TxAddressIndex, 0); err != nil {
+		return err
+	}
+	return SaveStageProgress(db, TxAddressIndex, unwindPoint)
+}
@@ -18,7 +18,9 @@ package downloader
 
 import (
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"strings"
 
 	"github.com/ledgerwatch/turbo-geth/common/dbutils"
 	"github.com/ledgerwatch/turbo-geth/ethdb"
@@ -28,20 +30,54 @@ import (
 type SyncStage byte
 
 const (
-	Headers   SyncStage = iota // Headers are downloaded, their Proof-Of-Work validity and chaining is verified
-	Bodies                     // Block bodies are downloaded, TxHash and UncleHash are getting verified
-	Senders                    // "From" recovered from signatures, bodies re-written
-	Execution                  // Executing each block w/o buildinf a trie
-	HashCheck                  // Checking the root hash
-	AccountHistoryIndex        // Generating history index for accounts
-	StorageHistoryIndex        // Generating history index for storage
-	Finish                     // Nominal stage after all other stages
+	Headers                 SyncStage = iota // Headers are downloaded, their Proof-Of-Work validity and chaining is verified
+	Bodies                                   // Block bodies are downloaded, TxHash and UncleHash are getting verified
+	Senders                                  // "From" recovered from signatures, bodies re-written
+	Execution                                // Executing each block w/o buildinf a trie
+	HashCheck                                // Checking the root hash
+	AccountHistoryIndex                      // Generating history index for accounts
+	StorageHistoryIndex                      // Generating history index for storage
+	IncarnationHistoryIndex                  // Generating index of blocks at which accounts were created and destroyed
+	LogIndex                                 // Generating index of blocks by the topics and the addresses of their logs
+	TxAddressIndex                           // Generating index of blocks by the senders and the recipients of their transactions (optional)
+	Finish                                   // Nominal stage after all other stages
 )
 
+var syncStageNames = []string{
+	Headers:                 "headers",
+	Bodies:                  "bodies",
+	Senders:                 "senders",
+	Execution:               "execution",
+	HashCheck:               "hashcheck",
+	AccountHistoryIndex:     "account_history_index",
+	StorageHistoryIndex:     "storage_history_index",
+	IncarnationHistoryIndex: "incarnation_history_index",
+	LogIndex:                "log_index",
+	TxAddressIndex:          "tx_address_index",
+	Finish:                  "finish",
+}
+
+func (s SyncStage) String() string {
+	if int(s) < len(syncStageNames) {
+		return syncStageNames[s]
+	}
+	return fmt.Sprintf("stage%d", s)
+}
+
+// ParseSyncStage returns the stage by its name, see SyncStage.String
+func ParseSyncStage(name string) (SyncStage, error) {
+	for s, n := range syncStageNames {
+		if n == name {
+			return SyncStage(s), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown sync stage %q, expected one of %s", name, strings.Join(syncStageNames, ", "))
+}
+
 // GetStageProcess retrieves saved progress of given sync stage from the database
 func GetStageProgress(db ethdb.Getter, stage SyncStage) (uint64, error) {
 	v, err := db.Get(dbutils.SyncStageProgress, []byte{byte(stage)})
-	if err != nil && err != ethdb.ErrKeyNotFound {
+	if err != nil && !errors.Is(err, ethdb.ErrKeyNotFound) {
 		return 0, err
 	}
 	if len(v) == 0 {
@@ -88,7 +124,7 @@ func UnwindAllStages(db ethdb.GetterPutter, unwindPoint uint64) error {
 // point and be redone
 func GetStageUnwind(db ethdb.Getter, stage SyncStage) (uint64, error) {
 	v, err := db.Get(dbutils.SyncStageUnwind, []byte{byte(stage)})
-	if err != nil && err != ethdb.ErrKeyNotFound {
+	if err != nil && !errors.Is(err, ethdb.ErrKeyNotFound) {
 		return 0, err
 	}
 	if len(v) == 0 {
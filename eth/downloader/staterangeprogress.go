@@ -0,0 +1,146 @@
+package downloader
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+	"github.com/ledgerwatch/turbo-geth/core/types/accounts"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+	"github.com/ledgerwatch/turbo-geth/trie"
+)
+
+var (
+	rangeProgressRootKey = []byte("r")
+	rangeProgressAccount = byte('a')
+	rangeProgressStorage = byte('s')
+)
+
+// StateRangeProgress verifies the prefix ranges of the state received from the peers (the leaves of the range
+// together with its boundary proof, see trie.RangeProof) against the root of the pivot state, and records
+// the verified ranges in dbutils.StateRangeProgressBucket, so that an interrupted download requests
+// exactly the missing ranges after a restart. The prefixes are in nibbles
+type StateRangeProgress struct {
+	db   ethdb.Database
+	root common.Hash
+}
+
+// NewStateRangeProgress opens the progress of the download of the state with the given root
+func NewStateRangeProgress(db ethdb.Database, root common.Hash) (*StateRangeProgress, error) {
+	v, err := db.Get(dbutils.StateRangeProgressBucket, rangeProgressRootKey)
+	if err != nil && !errors.Is(err, ethdb.ErrKeyNotFound) {
+		return nil, err
+	}
+	if v == nil {
+		if err = db.Put(dbutils.StateRangeProgressBucket, rangeProgressRootKey, common.CopyBytes(root[:])); err != nil {
+			return nil, err
+		}
+	} else if progressRoot := common.BytesToHash(v); progressRoot != root {
+		return nil, fmt.Errorf("download of the state %x was interrupted, but the state %x is downloaded now; remove the partially downloaded database", progressRoot, root)
+	}
+	return &StateRangeProgress{db: db, root: root}, nil
+}
+
+// VerifyAccounts checks the accounts (sorted by the hashed keys) of the prefix range, and marks the range
+// as complete in the batch, into which the caller writes the accounts
+func (p *StateRangeProgress) VerifyAccounts(batch ethdb.Putter, prefix []byte, keys []common.Hash, accs []*accounts.Account, proof *trie.RangeProof) error {
+	if len(keys) != len(accs) {
+		return fmt.Errorf("%d keys and %d accounts", len(keys), len(accs))
+	}
+	v, err := trie.NewRangeVerifier(p.root, prefix, proof)
+	if err != nil {
+		return err
+	}
+	for i, key := range keys {
+		if err = v.AddAccount(key, accs[i]); err != nil {
+			return err
+		}
+	}
+	if err = v.Finish(); err != nil {
+		return err
+	}
+	return batch.Put(dbutils.StateRangeProgressBucket, accountRangeKey(prefix), []byte{1})
+}
+
+// VerifyStorage checks the storage items (sorted by the hashed keys) of the prefix range of the account against
+// its storage root (taken from the verified account), and marks the range as complete in the batch,
+// into which the caller writes the storage
+func (p *StateRangeProgress) VerifyStorage(batch ethdb.Putter, addrHash common.Hash, storageRoot common.Hash, prefix []byte, keys []common.Hash, values [][]byte, proof *trie.RangeProof) error {
+	if len(keys) != len(values) {
+		return fmt.Errorf("%d keys and %d values", len(keys), len(values))
+	}
+	v, err := trie.NewRangeVerifier(storageRoot, prefix, proof)
+	if err != nil {
+		return err
+	}
+	for i, key := range keys {
+		if err = v.AddStorage(key, values[i]); err != nil {
+			return err
+		}
+	}
+	if err = v.Finish(); err != nil {
+		return fmt.Errorf("storage of %x: %w", addrHash, err)
+	}
+	return batch.Put(dbutils.StateRangeProgressBucket, storageRangeKey(addrHash, prefix), []byte{1})
+}
+
+// MissingAccountRanges returns the smallest set of the prefixes, which cover the accounts not verified yet
+func (p *StateRangeProgress) MissingAccountRanges() ([][]byte, error) {
+	return p.missing([]byte{rangeProgressAccount})
+}
+
+// MissingStorageRanges returns the smallest set of the prefixes, which cover the storage of the account not verified yet
+func (p *StateRangeProgress) MissingStorageRanges(addrHash common.Hash) ([][]byte, error) {
+	return p.missing(storageRangeKey(addrHash, nil))
+}
+
+func (p *StateRangeProgress) missing(keyPrefix []byte) ([][]byte, error) {
+	var complete [][]byte
+	if err := p.db.Walk(dbutils.StateRangeProgressBucket, keyPrefix, 8*len(keyPrefix), func(k, _ []byte) (bool, error) {
+		complete = append(complete, common.CopyBytes(k[len(keyPrefix):]))
+		return true, nil
+	}); err != nil {
+		return nil, err
+	}
+	return missingPrefixes(complete, nil), nil
+}
+
+// missingPrefixes returns the prefixes under the given one, which are not covered by the sorted complete prefixes
+func missingPrefixes(complete [][]byte, prefix []byte) [][]byte {
+	// The first complete prefix, which is not less than the prefix
+	i := sort.Search(len(complete), func(i int) bool { return bytes.Compare(complete[i], prefix) >= 0 })
+	// The prefix is covered by itself or by one of its own prefixes, which sorts before it
+	if i < len(complete) && bytes.Equal(complete[i], prefix) {
+		return nil
+	}
+	for l := len(prefix) - 1; l >= 0; l-- {
+		j := sort.Search(len(complete), func(j int) bool { return bytes.Compare(complete[j], prefix[:l]) >= 0 })
+		if j < len(complete) && bytes.Equal(complete[j], prefix[:l]) {
+			return nil
+		}
+	}
+	if i == len(complete) || !bytes.HasPrefix(complete[i], prefix) || len(prefix) == 2*common.HashLength {
+		return [][]byte{append([]byte{}, prefix...)}
+	}
+	// Some of the ranges under the prefix are complete
+	var missing [][]byte
+	for nibble := byte(0); nibble < 16; nibble++ {
+		missing = append(missing, missingPrefixes(complete, append(common.CopyBytes(prefix), nibble))...)
+	}
+	return missing
+}
+
+func accountRangeKey(prefix []byte) []byte {
+	return append([]byte{rangeProgressAccount}, prefix...)
+}
+
+func storageRangeKey(addrHash common.Hash, prefix []byte) []byte {
+	k := make([]byte, 1+common.HashLength+len(prefix))
+	k[0] = rangeProgressStorage
+	copy(k[1:], addrHash[:])
+	copy(k[1+common.HashLength:], prefix)
+	return k
+}
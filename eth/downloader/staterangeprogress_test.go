@@ -0,0 +1,113 @@
+package downloader
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/core/types/accounts"
+	"github.com/ledgerwatch/turbo-geth/crypto"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+	"github.com/ledgerwatch/turbo-geth/trie"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMissingPrefixes(t *testing.T) {
+	assert.Equal(t, [][]byte{{}}, missingPrefixes(nil, nil))
+	assert.Nil(t, missingPrefixes([][]byte{{}}, nil))
+
+	missing := missingPrefixes([][]byte{{0x1, 0x2}, {0x3}}, nil)
+	expected := [][]byte{{0x0}, {0x1, 0x0}, {0x1, 0x1}}
+	for n := byte(0x3); n < 16; n++ {
+		expected = append(expected, []byte{0x1, n})
+	}
+	expected = append(expected, []byte{0x2})
+	for n := byte(0x4); n < 16; n++ {
+		expected = append(expected, []byte{n})
+	}
+	assert.Equal(t, expected, missing)
+}
+
+func TestStateRangeProgress(t *testing.T) {
+	tr := trie.New(trie.EmptyRoot)
+	var keys []common.Hash
+	accs := make(map[common.Hash]*accounts.Account)
+	for i := 0; i < 100; i++ {
+		addrHash := crypto.Keccak256Hash([]byte(fmt.Sprintf("account-%d", i)))
+		acc := accounts.NewAccount()
+		acc.Initialised = true
+		acc.Balance.SetUint64(uint64(i + 1))
+		tr.UpdateAccount(addrHash[:], &acc)
+		keys = append(keys, addrHash)
+		accs[addrHash] = &acc
+	}
+	sort.Slice(keys, func(i, j int) bool { return bytes.Compare(keys[i][:], keys[j][:]) < 0 })
+	root := tr.Hash()
+
+	// The accounts of the range and the boundary accounts of the proof
+	rangeOf := func(nibble byte, proof *trie.RangeProof) ([]common.Hash, []*accounts.Account) {
+		boundary := make(map[common.Hash]struct{})
+		for _, k := range proof.Boundary {
+			boundary[k] = struct{}{}
+		}
+		var rangeKeys []common.Hash
+		var rangeAccs []*accounts.Account
+		for _, k := range keys {
+			if _, ok := boundary[k]; ok || k[0]>>4 == nibble {
+				rangeKeys = append(rangeKeys, k)
+				rangeAccs = append(rangeAccs, accs[k])
+			}
+		}
+		return rangeKeys, rangeAccs
+	}
+
+	db := ethdb.NewMemDatabase()
+	defer db.Close()
+	p, err := NewStateRangeProgress(db, root)
+	require.NoError(t, err)
+	missing, err := p.MissingAccountRanges()
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{{}}, missing)
+
+	for nibble := byte(0); nibble < 8; nibble++ {
+		proof, err1 := tr.AccountRangeProof([]byte{nibble})
+		require.NoError(t, err1)
+		rangeKeys, rangeAccs := rangeOf(nibble, proof)
+		require.NoError(t, p.VerifyAccounts(db, []byte{nibble}, rangeKeys, rangeAccs, proof))
+	}
+
+	// The range with a missing account is not marked as complete
+	proof, err := tr.AccountRangeProof([]byte{0x8})
+	require.NoError(t, err)
+	rangeKeys, rangeAccs := rangeOf(0x8, proof)
+	for i := range rangeKeys {
+		if rangeKeys[i][0]>>4 == 0x8 {
+			rangeKeys = append(rangeKeys[:i:i], rangeKeys[i+1:]...)
+			rangeAccs = append(rangeAccs[:i:i], rangeAccs[i+1:]...)
+			break
+		}
+	}
+	assert.Error(t, p.VerifyAccounts(db, []byte{0x8}, rangeKeys, rangeAccs, proof))
+
+	// The download is resumed from the missing ranges
+	p, err = NewStateRangeProgress(db, root)
+	require.NoError(t, err)
+	missing, err = p.MissingAccountRanges()
+	require.NoError(t, err)
+	var expected [][]byte
+	for nibble := byte(0x8); nibble < 16; nibble++ {
+		expected = append(expected, []byte{nibble})
+	}
+	assert.Equal(t, expected, missing)
+
+	// The storage ranges are tracked separately
+	missing, err = p.MissingStorageRanges(keys[0])
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{{}}, missing)
+
+	_, err = NewStateRangeProgress(db, common.Hash{1})
+	assert.Error(t, err)
+}
@@ -20,10 +20,13 @@ import (
 	"context"
 	"errors"
 	"math/big"
+	"sort"
 
 	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
 	"github.com/ledgerwatch/turbo-geth/core"
 	"github.com/ledgerwatch/turbo-geth/core/bloombits"
+	"github.com/ledgerwatch/turbo-geth/core/rawdb"
 	"github.com/ledgerwatch/turbo-geth/core/types"
 	"github.com/ledgerwatch/turbo-geth/ethdb"
 	"github.com/ledgerwatch/turbo-geth/event"
@@ -142,18 +145,37 @@ func (f *Filter) Logs(ctx context.Context) ([]*types.Log, error) {
 	if f.end == -1 {
 		end = head
 	}
-	// Gather all indexed logs, and finish with non indexed ones
+	// Gather the logs of the blocks covered by the log index, then all bloom indexed logs,
+	// and finish with non indexed ones
 	var (
 		logs []*types.Log
 		err  error
 	)
+	progress, ok, err := rawdb.ReadLogIndexProgress(f.db)
+	if err != nil {
+		return nil, err
+	}
+	if ok && progress >= uint64(f.begin) {
+		indexEnd := end
+		if progress < end {
+			indexEnd = progress
+		}
+		if logs, err = f.logIndexLogs(ctx, indexEnd); err != nil {
+			return logs, err
+		}
+		if f.begin > int64(end) {
+			return logs, nil
+		}
+	}
 	size, sections := f.backend.BloomStatus()
 	if indexed := sections * size; indexed > uint64(f.begin) {
+		var found []*types.Log
 		if indexed > end {
-			logs, err = f.indexedLogs(ctx, end)
+			found, err = f.indexedLogs(ctx, end)
 		} else {
-			logs, err = f.indexedLogs(ctx, indexed-1)
+			found, err = f.indexedLogs(ctx, indexed-1)
 		}
+		logs = append(logs, found...)
 		if err != nil {
 			return logs, err
 		}
@@ -210,6 +232,106 @@ func (f *Filter) indexedLogs(ctx context.Context, end uint64) ([]*types.Log, err
 	}
 }
 
+// logIndexLogs returns the logs matching the filter criteria, using the log index to find
+// the candidate blocks. It leaves the range to the other methods if the filter has no criteria
+func (f *Filter) logIndexLogs(ctx context.Context, end uint64) ([]*types.Log, error) {
+	candidates, ok, err := f.logIndexCandidates(end)
+	if err != nil || !ok {
+		return nil, err
+	}
+	var logs []*types.Log
+	for _, number := range candidates {
+		if err := ctx.Err(); err != nil {
+			return logs, err
+		}
+		header, err := f.backend.HeaderByNumber(ctx, rpc.BlockNumber(number))
+		if header == nil || err != nil {
+			return logs, err
+		}
+		// The index may contain blocks of the abandoned forks, so the logs are always checked
+		found, err := f.checkMatches(ctx, header)
+		if err != nil {
+			return logs, err
+		}
+		logs = append(logs, found...)
+	}
+	f.begin = int64(end) + 1
+	return logs, nil
+}
+
+// logIndexCandidates intersects the blocks of the addresses with the blocks of every topic position.
+// Topics are not indexed by position, so the result is a superset of the matching blocks.
+// ok == false if neither addresses nor topics are restricted
+func (f *Filter) logIndexCandidates(end uint64) (candidates []uint64, ok bool, err error) {
+	if len(f.addresses) > 0 {
+		keys := make([][]byte, len(f.addresses))
+		for i, addr := range f.addresses {
+			keys[i] = addr.Bytes()
+		}
+		if candidates, err = f.readLogIndexUnion(dbutils.LogAddressIndexBucket, keys, end); err != nil {
+			return nil, false, err
+		}
+		ok = true
+	}
+	for _, sub := range f.topics {
+		if len(sub) == 0 {
+			continue
+		}
+		keys := make([][]byte, len(sub))
+		for i, topic := range sub {
+			keys[i] = topic.Bytes()
+		}
+		blocks, err := f.readLogIndexUnion(dbutils.LogTopicIndexBucket, keys, end)
+		if err != nil {
+			return nil, false, err
+		}
+		if ok {
+			candidates = intersectSorted(candidates, blocks)
+		} else {
+			candidates = blocks
+			ok = true
+		}
+	}
+	return candidates, ok, nil
+}
+
+// readLogIndexUnion returns the sorted blocks, found in the index of any of the keys
+func (f *Filter) readLogIndexUnion(bucket []byte, keys [][]byte, end uint64) ([]uint64, error) {
+	seen := make(map[uint64]struct{})
+	var union []uint64
+	for _, key := range keys {
+		blocks, err := rawdb.ReadLogIndex(f.db, bucket, key, uint64(f.begin), end)
+		if err != nil {
+			return nil, err
+		}
+		for _, b := range blocks {
+			if _, ok := seen[b]; !ok {
+				seen[b] = struct{}{}
+				union = append(union, b)
+			}
+		}
+	}
+	sort.Slice(union, func(i, j int) bool { return union[i] < union[j] })
+	return union, nil
+}
+
+func intersectSorted(a, b []uint64) []uint64 {
+	var res []uint64
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			res = append(res, a[i])
+			i++
+			j++
+		}
+	}
+	return res
+}
+
 // unindexedLogs returns the logs matching the filter criteria based on raw block
 // iteration and bloom matching.
 func (f *Filter) unindexedLogs(ctx context.Context, end uint64) ([]*types.Log, error) {
@@ -16,7 +16,7 @@ var FirehoseName = "frh" // Parity only supports 3 letter capabilities
 var FirehoseVersions = []uint{1}
 
 // FirehoseLengths are the number of implemented message corresponding to different protocol versions.
-var FirehoseLengths = []uint64{12}
+var FirehoseLengths = []uint64{16}
 
 // FirehoseMaxMsgSize is the maximum cap on the size of a message.
 const FirehoseMaxMsgSize = 10 * 1024 * 1024
@@ -24,20 +24,33 @@ const FirehoseMaxMsgSize = 10 * 1024 * 1024
 // MaxLeavesPerPrefix is the maximum number of leaves allowed per prefix.
 const MaxLeavesPerPrefix = 4096
 
+// maxFlatStateServes is the maximum number of the state ranges built at the same time for all the peers.
+const maxFlatStateServes = 4
+
+// maxFlatStateWitnessSize is the maximum size of the witness of a state range, leaving the room for the rest of the message.
+const maxFlatStateWitnessSize = FirehoseMaxMsgSize - 4096
+
+// MaxChangeSetsServe is the maximum number of blocks, the changesets of which are served in one response.
+const MaxChangeSetsServe = 128
+
 // Firehose protocol message codes
 const (
-	GetStateRangesCode   = 0x00
-	StateRangesCode      = 0x01
-	GetStorageRangesCode = 0x02
-	StorageRangesCode    = 0x03
-	GetStateNodesCode    = 0x04
-	StateNodesCode       = 0x05
-	GetStorageNodesCode  = 0x06
-	StorageNodesCode     = 0x07
-	GetBytecodeCode      = 0x08
-	BytecodeCode         = 0x09
-	GetStorageSizesCode  = 0x0a
-	StorageSizesCode     = 0x0b
+	GetStateRangesCode    = 0x00
+	StateRangesCode       = 0x01
+	GetStorageRangesCode  = 0x02
+	StorageRangesCode     = 0x03
+	GetStateNodesCode     = 0x04
+	StateNodesCode        = 0x05
+	GetStorageNodesCode   = 0x06
+	StorageNodesCode      = 0x07
+	GetBytecodeCode       = 0x08
+	BytecodeCode          = 0x09
+	GetStorageSizesCode   = 0x0a
+	StorageSizesCode      = 0x0b
+	GetFlatStateRangeCode = 0x0c
+	FlatStateRangeCode    = 0x0d
+	GetChangeSetsCode     = 0x0e
+	ChangeSetsCode        = 0x0f
 )
 
 // Status of Firehose results.
@@ -55,6 +68,8 @@ const (
 type firehosePeer struct {
 	*p2p.Peer
 	rw p2p.MsgReadWriter
+
+	servingFlatState int32 // 1 while a state range is built for the peer, see serveFlatStateRange
 }
 
 type accountLeaf struct {
@@ -133,8 +148,50 @@ type bytecodeMsg struct {
 	Code [][]byte
 }
 
+type getFlatStateRangeMsg struct {
+	ID    uint64
+	Block common.Hash
+	From  []byte // position of the start of the range, see downloader.FlatStateRangeWitness
+}
+
+type flatStateRangeMsg struct {
+	ID              uint64
+	Status          Status
+	Last            []byte // position of the end of the range, empty if the range reaches the end of the state
+	Witness         []byte // serialised witness of the range, see downloader.FlatStateRangeWitness
+	AvailableBlocks []common.Hash
+}
+
+type getChangeSetsMsg struct {
+	ID     uint64
+	Blocks []common.Hash
+}
+
+type blockChangeSets struct {
+	Status         Status
+	AccountChanges []byte // encoded account changeset of the block, see changeset.EncodeAccounts
+	StorageChanges []byte // encoded storage changeset of the block, empty if the block does not change the storage
+}
+
+type changeSetsMsg struct {
+	ID         uint64
+	ChangeSets []blockChangeSets // indexing matches getChangeSetsMsg request, the tail is cut by the size limit of the response
+}
+
 // SendByteCode sends a BytecodeCode message.
 func (p *firehosePeer) SendByteCode(id uint64, data [][]byte) error {
 	msg := bytecodeMsg{ID: id, Code: data}
 	return p2p.Send(p.rw, BytecodeCode, msg)
 }
+
+// RequestFlatStateRange sends a GetFlatStateRangeCode message.
+func (p *firehosePeer) RequestFlatStateRange(id uint64, block common.Hash, from []byte) error {
+	msg := getFlatStateRangeMsg{ID: id, Block: block, From: from}
+	return p2p.Send(p.rw, GetFlatStateRangeCode, msg)
+}
+
+// RequestChangeSets sends a GetChangeSetsCode message.
+func (p *firehosePeer) RequestChangeSets(id uint64, blocks []common.Hash) error {
+	msg := getChangeSetsMsg{ID: id, Blocks: blocks}
+	return p2p.Send(p.rw, GetChangeSetsCode, msg)
+}
@@ -18,41 +18,61 @@ import (
 // MarshalTOML marshals as TOML.
 func (c Config) MarshalTOML() (interface{}, error) {
 	type Config struct {
-		Genesis                 *core.Genesis `toml:",omitempty"`
-		NetworkID               uint64
-		SyncMode                downloader.SyncMode
-		DiscoveryURLs           []string
-		Pruning               bool
-		NoPrefetch              bool
-		TxLookupLimit           uint64                 `toml:",omitempty"`
-		Whitelist               map[uint64]common.Hash `toml:"-"`
-		LightIngress            int                    `toml:",omitempty"`
-		LightEgress             int                    `toml:",omitempty"`
-		StorageMode             string
-		ArchiveSyncInterval     int
-		LightServ               int `toml:",omitempty"`
-		LightPeers              int `toml:",omitempty"`
-		OnlyAnnounce            bool
-		SkipBcVersionCheck      bool `toml:"-"`
-		DatabaseHandles         int  `toml:"-"`
-		DatabaseCache           int
-		DatabaseFreezer         string
-		TrieCleanCache          int
-		TrieDirtyCache          int
-		TrieTimeout             time.Duration
-		Miner                   miner.Config
-		Ethash                  ethash.Config
-		TxPool                  core.TxPoolConfig
-		GPO                     gasprice.Config
-		EnablePreimageRecording bool
-		DocRoot                 string `toml:"-"`
-		EWASMInterpreter        string
-		EVMInterpreter          string
-		RPCGasCap               *big.Int                       `toml:",omitempty"`
-		Checkpoint              *params.TrustedCheckpoint      `toml:",omitempty"`
-		CheckpointOracle        *params.CheckpointOracleConfig `toml:",omitempty"`
-		OverrideIstanbul        *big.Int                       `toml:",omitempty"`
-		OverrideMuirGlacier     *big.Int                       `toml:",omitempty"`
+		Genesis                  *core.Genesis `toml:",omitempty"`
+		NetworkID                uint64
+		SyncMode                 downloader.SyncMode
+		DiscoveryURLs            []string
+		Pruning                  bool
+		NoPrefetch               bool
+		TxLookupLimit            uint64                 `toml:",omitempty"`
+		Whitelist                map[uint64]common.Hash `toml:"-"`
+		LightIngress             int                    `toml:",omitempty"`
+		LightEgress              int                    `toml:",omitempty"`
+		StorageMode              string
+		ArchiveSyncInterval      int
+		FlatHashing              bool
+		PinnedStorage            []common.Address
+		HotAccounts              []common.Address
+		HashingWorkers           int
+		RetainListBudget         uint64
+		HistoryCommitWindow      uint64
+		SplitState               bool
+		StorageChangeSetV2       bool
+		AsyncCommit              bool
+		HistoryIndexAllow        []common.Address
+		HistoryIndexDeny         []common.Address
+		HistoryIndexRebuild      bool
+		TxAddressIndex           bool
+		TxAddressIndexKeep       uint64
+		LightServ                int `toml:",omitempty"`
+		LightPeers               int `toml:",omitempty"`
+		OnlyAnnounce             bool
+		SkipBcVersionCheck       bool `toml:"-"`
+		DatabaseHandles          int  `toml:"-"`
+		DatabaseCache            int
+		DatabaseFreezer          string
+		DatabaseStatsInterval    time.Duration
+		DatabaseScrubInterval    time.Duration
+		CodeColdDays             int
+		HistoryRetentionFull     uint64
+		HistoryRetentionAccounts uint64
+		TrieCleanCache           int
+		TrieDirtyCache           int
+		TrieTimeout              time.Duration
+		Miner                    miner.Config
+		Ethash                   ethash.Config
+		TxPool                   core.TxPoolConfig
+		GPO                      gasprice.Config
+		EnablePreimageRecording  bool
+		BatchStorageReads        bool
+		DocRoot                  string `toml:"-"`
+		EWASMInterpreter         string
+		EVMInterpreter           string
+		RPCGasCap                *big.Int                       `toml:",omitempty"`
+		Checkpoint               *params.TrustedCheckpoint      `toml:",omitempty"`
+		CheckpointOracle         *params.CheckpointOracleConfig `toml:",omitempty"`
+		OverrideIstanbul         *big.Int                       `toml:",omitempty"`
+		OverrideMuirGlacier      *big.Int                       `toml:",omitempty"`
 	}
 	var enc Config
 	enc.Genesis = c.Genesis
@@ -65,6 +85,20 @@ func (c Config) MarshalTOML() (interface{}, error) {
 	enc.Whitelist = c.Whitelist
 	enc.StorageMode = c.StorageMode.ToString()
 	enc.ArchiveSyncInterval = c.ArchiveSyncInterval
+	enc.FlatHashing = c.FlatHashing
+	enc.PinnedStorage = c.PinnedStorage
+	enc.HotAccounts = c.HotAccounts
+	enc.HashingWorkers = c.HashingWorkers
+	enc.RetainListBudget = c.RetainListBudget
+	enc.HistoryCommitWindow = c.HistoryCommitWindow
+	enc.SplitState = c.SplitState
+	enc.StorageChangeSetV2 = c.StorageChangeSetV2
+	enc.AsyncCommit = c.AsyncCommit
+	enc.HistoryIndexAllow = c.HistoryIndexAllow
+	enc.HistoryIndexDeny = c.HistoryIndexDeny
+	enc.HistoryIndexRebuild = c.HistoryIndexRebuild
+	enc.TxAddressIndex = c.TxAddressIndex
+	enc.TxAddressIndexKeep = c.TxAddressIndexKeep
 	enc.LightServ = c.LightServ
 	enc.LightIngress = c.LightIngress
 	enc.LightEgress = c.LightEgress
@@ -73,6 +107,11 @@ func (c Config) MarshalTOML() (interface{}, error) {
 	enc.DatabaseHandles = c.DatabaseHandles
 	enc.DatabaseCache = c.DatabaseCache
 	enc.DatabaseFreezer = c.DatabaseFreezer
+	enc.DatabaseStatsInterval = c.DatabaseStatsInterval
+	enc.DatabaseScrubInterval = c.DatabaseScrubInterval
+	enc.CodeColdDays = c.CodeColdDays
+	enc.HistoryRetentionFull = c.HistoryRetentionFull
+	enc.HistoryRetentionAccounts = c.HistoryRetentionAccounts
 	enc.TrieCleanCache = c.TrieCleanCache
 	enc.TrieDirtyCache = c.TrieDirtyCache
 	enc.TrieTimeout = c.TrieTimeout
@@ -81,6 +120,7 @@ func (c Config) MarshalTOML() (interface{}, error) {
 	enc.TxPool = c.TxPool
 	enc.GPO = c.GPO
 	enc.EnablePreimageRecording = c.EnablePreimageRecording
+	enc.BatchStorageReads = c.BatchStorageReads
 	enc.DocRoot = c.DocRoot
 	enc.EWASMInterpreter = c.EWASMInterpreter
 	enc.EVMInterpreter = c.EVMInterpreter
@@ -93,41 +133,61 @@ func (c Config) MarshalTOML() (interface{}, error) {
 // UnmarshalTOML unmarshals from TOML.
 func (c *Config) UnmarshalTOML(unmarshal func(interface{}) error) error {
 	type Config struct {
-		Genesis                 *core.Genesis `toml:",omitempty"`
-		NetworkID               *uint64
-		SyncMode                *downloader.SyncMode
-		DiscoveryURLs           []string
-		Pruning               *bool
-		NoPrefetch              *bool
-		TxLookupLimit           *uint64                `toml:",omitempty"`
-		Whitelist               map[uint64]common.Hash `toml:"-"`
-		LightIngress            *int                   `toml:",omitempty"`
-		LightEgress             *int                   `toml:",omitempty"`
-		Mode                    *string
-		ArchiveSyncInterval     *int
-		LightServ               *int `toml:",omitempty"`
-		LightPeers              *int `toml:",omitempty"`
-		OnlyAnnounce            *bool
-		SkipBcVersionCheck      *bool `toml:"-"`
-		DatabaseHandles         *int  `toml:"-"`
-		DatabaseCache           *int
-		DatabaseFreezer         *string
-		TrieCleanCache          *int
-		TrieDirtyCache          *int
-		TrieTimeout             *time.Duration
-		Miner                   *miner.Config
-		Ethash                  *ethash.Config
-		TxPool                  *core.TxPoolConfig
-		GPO                     *gasprice.Config
-		EnablePreimageRecording *bool
-		DocRoot                 *string `toml:"-"`
-		EWASMInterpreter        *string
-		EVMInterpreter          *string
-		RPCGasCap               *big.Int                       `toml:",omitempty"`
-		Checkpoint              *params.TrustedCheckpoint      `toml:",omitempty"`
-		CheckpointOracle        *params.CheckpointOracleConfig `toml:",omitempty"`
-		OverrideIstanbul        *big.Int                       `toml:",omitempty"`
-		OverrideMuirGlacier     *big.Int                       `toml:",omitempty"`
+		Genesis                  *core.Genesis `toml:",omitempty"`
+		NetworkID                *uint64
+		SyncMode                 *downloader.SyncMode
+		DiscoveryURLs            []string
+		Pruning                  *bool
+		NoPrefetch               *bool
+		TxLookupLimit            *uint64                `toml:",omitempty"`
+		Whitelist                map[uint64]common.Hash `toml:"-"`
+		LightIngress             *int                   `toml:",omitempty"`
+		LightEgress              *int                   `toml:",omitempty"`
+		Mode                     *string
+		ArchiveSyncInterval      *int
+		FlatHashing              *bool
+		PinnedStorage            []common.Address
+		HotAccounts              []common.Address
+		HashingWorkers           *int
+		RetainListBudget         *uint64
+		HistoryCommitWindow      *uint64
+		SplitState               *bool
+		StorageChangeSetV2       *bool
+		AsyncCommit              *bool
+		HistoryIndexAllow        []common.Address
+		HistoryIndexDeny         []common.Address
+		HistoryIndexRebuild      *bool
+		TxAddressIndex           *bool
+		TxAddressIndexKeep       *uint64
+		LightServ                *int `toml:",omitempty"`
+		LightPeers               *int `toml:",omitempty"`
+		OnlyAnnounce             *bool
+		SkipBcVersionCheck       *bool `toml:"-"`
+		DatabaseHandles          *int  `toml:"-"`
+		DatabaseCache            *int
+		DatabaseFreezer          *string
+		DatabaseStatsInterval    *time.Duration
+		DatabaseScrubInterval    *time.Duration
+		CodeColdDays             *int
+		HistoryRetentionFull     *uint64
+		HistoryRetentionAccounts *uint64
+		TrieCleanCache           *int
+		TrieDirtyCache           *int
+		TrieTimeout              *time.Duration
+		Miner                    *miner.Config
+		Ethash                   *ethash.Config
+		TxPool                   *core.TxPoolConfig
+		GPO                      *gasprice.Config
+		EnablePreimageRecording  *bool
+		BatchStorageReads        *bool
+		DocRoot                  *string `toml:"-"`
+		EWASMInterpreter         *string
+		EVMInterpreter           *string
+		RPCGasCap                *big.Int                       `toml:",omitempty"`
+		Checkpoint               *params.TrustedCheckpoint      `toml:",omitempty"`
+		CheckpointOracle         *params.CheckpointOracleConfig `toml:",omitempty"`
+		OverrideIstanbul         *big.Int                       `toml:",omitempty"`
+		OverrideMuirGlacier      *big.Int                       `toml:",omitempty"`
 	}
 	var dec Config
 	if err := unmarshal(&dec); err != nil {
@@ -167,6 +227,48 @@ func (c *Config) UnmarshalTOML(unmarshal func(interface{}) error) error {
 	if dec.ArchiveSyncInterval != nil {
 		c.ArchiveSyncInterval = *dec.ArchiveSyncInterval
 	}
+	if dec.FlatHashing != nil {
+		c.FlatHashing = *dec.FlatHashing
+	}
+	if dec.PinnedStorage != nil {
+		c.PinnedStorage = dec.PinnedStorage
+	}
+	if dec.HotAccounts != nil {
+		c.HotAccounts = dec.HotAccounts
+	}
+	if dec.HashingWorkers != nil {
+		c.HashingWorkers = *dec.HashingWorkers
+	}
+	if dec.RetainListBudget != nil {
+		c.RetainListBudget = *dec.RetainListBudget
+	}
+	if dec.HistoryCommitWindow != nil {
+		c.HistoryCommitWindow = *dec.HistoryCommitWindow
+	}
+	if dec.SplitState != nil {
+		c.SplitState = *dec.SplitState
+	}
+	if dec.StorageChangeSetV2 != nil {
+		c.StorageChangeSetV2 = *dec.StorageChangeSetV2
+	}
+	if dec.AsyncCommit != nil {
+		c.AsyncCommit = *dec.AsyncCommit
+	}
+	if dec.HistoryIndexAllow != nil {
+		c.HistoryIndexAllow = dec.HistoryIndexAllow
+	}
+	if dec.HistoryIndexDeny != nil {
+		c.HistoryIndexDeny = dec.HistoryIndexDeny
+	}
+	if dec.HistoryIndexRebuild != nil {
+		c.HistoryIndexRebuild = *dec.HistoryIndexRebuild
+	}
+	if dec.TxAddressIndex != nil {
+		c.TxAddressIndex = *dec.TxAddressIndex
+	}
+	if dec.TxAddressIndexKeep != nil {
+		c.TxAddressIndexKeep = *dec.TxAddressIndexKeep
+	}
 	if dec.LightServ != nil {
 		c.LightServ = *dec.LightServ
 	}
@@ -191,6 +293,21 @@ func (c *Config) UnmarshalTOML(unmarshal func(interface{}) error) error {
 	if dec.DatabaseFreezer != nil {
 		c.DatabaseFreezer = *dec.DatabaseFreezer
 	}
+	if dec.DatabaseStatsInterval != nil {
+		c.DatabaseStatsInterval = *dec.DatabaseStatsInterval
+	}
+	if dec.DatabaseScrubInterval != nil {
+		c.DatabaseScrubInterval = *dec.DatabaseScrubInterval
+	}
+	if dec.CodeColdDays != nil {
+		c.CodeColdDays = *dec.CodeColdDays
+	}
+	if dec.HistoryRetentionFull != nil {
+		c.HistoryRetentionFull = *dec.HistoryRetentionFull
+	}
+	if dec.HistoryRetentionAccounts != nil {
+		c.HistoryRetentionAccounts = *dec.HistoryRetentionAccounts
+	}
 	if dec.TrieCleanCache != nil {
 		c.TrieCleanCache = *dec.TrieCleanCache
 	}
@@ -215,6 +332,9 @@ func (c *Config) UnmarshalTOML(unmarshal func(interface{}) error) error {
 	if dec.EnablePreimageRecording != nil {
 		c.EnablePreimageRecording = *dec.EnablePreimageRecording
 	}
+	if dec.BatchStorageReads != nil {
+		c.BatchStorageReads = *dec.BatchStorageReads
+	}
 	if dec.DocRoot != nil {
 		c.DocRoot = *dec.DocRoot
 	}
@@ -19,7 +19,6 @@ package eth
 import (
 	"bytes"
 	"context"
-	"encoding/binary"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -31,9 +30,11 @@ import (
 	"time"
 
 	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
 	"github.com/ledgerwatch/turbo-geth/consensus"
 	"github.com/ledgerwatch/turbo-geth/core"
 	"github.com/ledgerwatch/turbo-geth/core/forkid"
+	"github.com/ledgerwatch/turbo-geth/core/rawdb"
 	"github.com/ledgerwatch/turbo-geth/core/types"
 	"github.com/ledgerwatch/turbo-geth/core/types/accounts"
 	"github.com/ledgerwatch/turbo-geth/core/vm"
@@ -99,15 +100,21 @@ type ProtocolManager struct {
 	txsyncCh chan *txsync
 	quitSync chan struct{}
 
-	chainSync *chainSyncer
-	wg        sync.WaitGroup
-	peerWG    sync.WaitGroup
+	chainSync       *chainSyncer
+	flatStateSync   *flatStateSyncer
+	flatStateServes chan struct{} // Slots of the state ranges served at the same time, see serveFlatStateRange
+	changeSetsSync  *changeSetsSyncer
+	wg              sync.WaitGroup
+	peerWG          sync.WaitGroup
 
 	// Test fields or hooks
 	broadcastTxAnnouncesOnly bool // Testing field, disable transaction propagation
 
-	mode downloader.SyncMode // Sync mode passed from the command line
+	mode    downloader.SyncMode // Sync mode passed from the command line
 	datadir string
+
+	txAddressIndex     bool
+	txAddressIndexKeep uint64
 }
 
 // NewProtocolManager returns a new Ethereum sub protocol manager. The Ethereum sub protocol manages peers capable
@@ -170,6 +177,14 @@ func (manager *ProtocolManager) SetDataDir(datadir string) {
 	}
 }
 
+func (manager *ProtocolManager) SetTxAddressIndex(enabled bool, keep uint64) {
+	manager.txAddressIndex = enabled
+	manager.txAddressIndexKeep = keep
+	if manager.downloader != nil {
+		manager.downloader.SetTxAddressIndex(enabled, keep)
+	}
+}
+
 func initPm(manager *ProtocolManager, txpool txPool, engine consensus.Engine, blockchain *core.BlockChain, chaindb ethdb.Database) {
 	sm, err := GetStorageModeFromDB(chaindb)
 	if err != nil {
@@ -178,6 +193,7 @@ func initPm(manager *ProtocolManager, txpool txPool, engine consensus.Engine, bl
 	// Construct the different synchronisation mechanisms
 	manager.downloader = downloader.New(manager.checkpointNumber, chaindb, nil /*stateBloom */, manager.eventMux, blockchain, nil, manager.removePeer, sm.History)
 	manager.downloader.SetDataDir(manager.datadir)
+	manager.downloader.SetTxAddressIndex(manager.txAddressIndex, manager.txAddressIndexKeep)
 
 	// Construct the fetcher (short sync)
 	validator := func(header *types.Header) error {
@@ -225,6 +241,9 @@ func initPm(manager *ProtocolManager, txpool txPool, engine consensus.Engine, bl
 		manager.txFetcher = fetcher.NewTxFetcher(txpool.Has, txpool.AddRemotes, fetchTx)
 	}
 	manager.chainSync = newChainSyncer(manager)
+	manager.flatStateSync = newFlatStateSyncer(manager)
+	manager.flatStateServes = make(chan struct{}, maxFlatStateServes)
+	manager.changeSetsSync = newChangeSetsSyncer(manager)
 }
 
 func (pm *ProtocolManager) makeFirehoseProtocol() p2p.Protocol {
@@ -242,6 +261,10 @@ func (pm *ProtocolManager) makeFirehoseProtocol() p2p.Protocol {
 			default:
 				pm.wg.Add(1)
 				defer pm.wg.Done()
+				pm.flatStateSync.register(peer)
+				defer pm.flatStateSync.unregister(peer)
+				pm.changeSetsSync.register(peer)
+				defer pm.changeSetsSync.unregister(peer)
 				return pm.handleFirehose(peer)
 			}
 		},
@@ -1199,7 +1222,7 @@ func (pm *ProtocolManager) handleFirehoseMsg(p *firehosePeer) error {
 				for i := 0; i < n && responseSize < softResponseLimit; i++ {
 					contractPrefix := make([]byte, common.HashLength+common.IncarnationLength)
 					copy(contractPrefix, addrHash.Bytes())
-					binary.BigEndian.PutUint64(contractPrefix[common.HashLength:], ^uint64(1))
+					dbutils.EncodeIncarnation(contractPrefix[common.HashLength:], 1)
 					// TODO [Issue 99] support incarnations
 					//storagePrefix := req.Prefixes[i]
 					//rr := tr.NewResolveRequest(contractPrefix, storagePrefix.ToHex(), storagePrefix.Nibbles())
@@ -1288,11 +1311,126 @@ func (pm *ProtocolManager) handleFirehoseMsg(p *firehosePeer) error {
 	case StorageSizesCode:
 		return errResp(ErrNotImplemented, "Not implemented yet")
 
+	case GetFlatStateRangeCode:
+		msgStream := rlp.NewStream(msg.Payload, uint64(msg.Size))
+		var request getFlatStateRangeMsg
+		if err := msgStream.Decode(&request); err != nil {
+			return errResp(ErrDecode, "msg %v: %v", msg, err)
+		}
+		if len(request.From) != common.HashLength && len(request.From) != 2*common.HashLength {
+			return errResp(ErrDecode, "invalid start of the state range %x", request.From)
+		}
+		// The witness takes a while to build, the other messages of the peer are handled meanwhile.
+		// One range per peer is served at a time, the requests arriving meanwhile get no data
+		if !atomic.CompareAndSwapInt32(&p.servingFlatState, 0, 1) {
+			return p2p.Send(p.rw, FlatStateRangeCode, flatStateRangeMsg{ID: request.ID, Status: NoData, AvailableBlocks: pm.blockchain.AvailableBlocks()})
+		}
+		pm.wg.Add(1)
+		go func() {
+			defer pm.wg.Done()
+			defer atomic.StoreInt32(&p.servingFlatState, 0)
+			if err := pm.serveFlatStateRange(p, &request); err != nil {
+				p.Log().Debug("Failed to serve the state range", "from", fmt.Sprintf("%x", request.From), "err", err)
+			}
+		}()
+		return nil
+
+	case FlatStateRangeCode:
+		msgStream := rlp.NewStream(msg.Payload, uint64(msg.Size))
+		var response flatStateRangeMsg
+		if err := msgStream.Decode(&response); err != nil {
+			return errResp(ErrDecode, "msg %v: %v", msg, err)
+		}
+		pm.flatStateSync.deliver(p, &response)
+		return nil
+
+	case GetChangeSetsCode:
+		msgStream := rlp.NewStream(msg.Payload, uint64(msg.Size))
+		var request getChangeSetsMsg
+		if err := msgStream.Decode(&request); err != nil {
+			return errResp(ErrDecode, "msg %v: %v", msg, err)
+		}
+
+		var response changeSetsMsg
+		response.ID = request.ID
+		var bytes int
+		for _, hash := range request.Blocks {
+			if bytes >= softResponseLimit || len(response.ChangeSets) >= MaxChangeSetsServe {
+				break
+			}
+			entry := blockChangeSets{Status: NoData}
+			// The changesets of the side chains are not stored
+			if number := rawdb.ReadHeaderNumber(pm.chaindb, hash); number != nil && rawdb.ReadCanonicalHash(pm.chaindb, *number) == hash {
+				accountChanges, err := ethdb.GetChangeSetByBlock(pm.chaindb, dbutils.AccountsHistoryBucket, *number)
+				if err != nil {
+					return err
+				}
+				storageChanges, err := ethdb.GetChangeSetByBlock(pm.chaindb, dbutils.StorageHistoryBucket, *number)
+				if err != nil {
+					return err
+				}
+				if accountChanges != nil {
+					entry = blockChangeSets{Status: OK, AccountChanges: accountChanges, StorageChanges: storageChanges}
+					bytes += len(accountChanges) + len(storageChanges)
+				}
+			}
+			response.ChangeSets = append(response.ChangeSets, entry)
+		}
+
+		return p2p.Send(p.rw, ChangeSetsCode, response)
+
+	case ChangeSetsCode:
+		msgStream := rlp.NewStream(msg.Payload, uint64(msg.Size))
+		var response changeSetsMsg
+		if err := msgStream.Decode(&response); err != nil {
+			return errResp(ErrDecode, "msg %v: %v", msg, err)
+		}
+		pm.changeSetsSync.deliver(p, &response)
+		return nil
+
 	default:
 		return errResp(ErrInvalidMsgCode, "%v", msg.Code)
 	}
 }
 
+// serveFlatStateRange sends the witness of the requested range of the state (see downloader.FlatStateRangeWitness).
+// If the witness does not fit into a message, it is built again for the smaller range
+func (pm *ProtocolManager) serveFlatStateRange(p *firehosePeer, request *getFlatStateRangeMsg) error {
+	select {
+	case pm.flatStateServes <- struct{}{}:
+		defer func() { <-pm.flatStateServes }()
+	case <-pm.quitSync:
+		return errors.New("quitting")
+	}
+	response := flatStateRangeMsg{ID: request.ID, Status: NoData}
+	// The flat buckets hold the state of the head only
+	if head := pm.blockchain.CurrentBlock(); head != nil && head.Hash() == request.Block {
+		for maxBytes := softResponseLimit; maxBytes > 0; maxBytes /= 2 {
+			w, root, last, err := downloader.FlatStateRangeWitness(pm.chaindb, request.From, MaxLeavesPerPrefix, maxBytes)
+			if err != nil {
+				return err
+			}
+			// The head could have moved while the range was read
+			if root != head.Root() {
+				break
+			}
+			var buf bytes.Buffer
+			if _, err = w.WriteTo(&buf); err != nil {
+				return err
+			}
+			if buf.Len() > maxFlatStateWitnessSize {
+				continue
+			}
+			response.Status, response.Last, response.Witness = OK, last, buf.Bytes()
+			break
+		}
+	}
+	if response.Status != OK {
+		response.AvailableBlocks = pm.blockchain.AvailableBlocks()
+	}
+	return p2p.Send(p.rw, FlatStateRangeCode, response)
+}
+
 func (pm *ProtocolManager) handleDebugMsg(p *debugPeer) error {
 	msg, readErr := p.rw.ReadMsg()
 	if readErr != nil {
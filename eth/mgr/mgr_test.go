@@ -1,9 +1,17 @@
 package mgr_test
 
 import (
+	"bytes"
+	"math/big"
 	"testing"
 
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+	"github.com/ledgerwatch/turbo-geth/core/types/accounts"
+	"github.com/ledgerwatch/turbo-geth/crypto"
 	"github.com/ledgerwatch/turbo-geth/eth/mgr"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+	"github.com/ledgerwatch/turbo-geth/trie"
 	"github.com/stretchr/testify/require"
 )
 
@@ -49,3 +57,88 @@ func TestScheduleProperties(t *testing.T) {
 	}
 
 }
+
+func TestGenerateWitnessesPreservesOrder(t *testing.T) {
+	require := require.New(t)
+	tr, slices := testTrieSlices(t, 1000)
+
+	serial, err := mgr.GenerateWitnesses(tr, slices, 1)
+	require.NoError(err)
+	parallel, err := mgr.GenerateWitnesses(tr, slices, 4)
+	require.NoError(err)
+	require.Equal(len(slices), len(parallel))
+	for i := range slices {
+		var expected, actual bytes.Buffer
+		_, err = serial[i].WriteTo(&expected)
+		require.NoError(err)
+		_, err = parallel[i].WriteTo(&actual)
+		require.NoError(err)
+		require.Equal(expected.Bytes(), actual.Bytes(), "witness of slice %d (%s)", i, slices[i])
+	}
+}
+
+func testTrieSlices(t *testing.T, accountsNum uint64) (*trie.Trie, []mgr.StateSlice) {
+	require := require.New(t)
+	db := ethdb.NewMemDatabase()
+	for i := uint64(0); i < accountsNum; i++ {
+		acc := accounts.NewAccount()
+		acc.Initialised = true
+		acc.Balance.SetUint64(i + 1)
+		value := make([]byte, acc.EncodingLengthForStorage())
+		acc.EncodeForStorage(value)
+		addrHash := crypto.Keccak256(new(big.Int).SetUint64(i).Bytes())
+		require.NoError(db.Put(dbutils.CurrentStateBucket, addrHash, value))
+	}
+
+	loader := trie.NewSubTrieLoader(0)
+	rs := trie.NewRetainList(0)
+	rs.AddHex([]byte{})
+	subTries, err := loader.LoadSubTries(db, 0, rs, [][]byte{nil}, []int{0}, false)
+	require.NoError(err)
+	tr := trie.New(common.Hash{})
+	require.NoError(tr.HookSubTries(subTries, [][]byte{nil}))
+
+	stateSize := tr.EstimateWitnessSize([]byte{})
+	schedule := mgr.NewStateSchedule(stateSize, 0, 4*mgr.BlocksPerTick-1)
+	var slices []mgr.StateSlice
+	for _, tick := range schedule.Ticks {
+		tickSlices, err := mgr.TickStateSlices(db, tr, tick)
+		require.NoError(err)
+		slices = append(slices, tickSlices...)
+	}
+	return tr, slices
+}
+
+func TestWitnessCache(t *testing.T) {
+	require := require.New(t)
+	tr, slices := testTrieSlices(t, 1000)
+	witnesses, err := mgr.GenerateWitnesses(tr, slices, 1)
+	require.NoError(err)
+
+	cache := mgr.NewWitnessCache(len(slices))
+	for pass := 0; pass < 2; pass++ {
+		chunks, err := cache.GenerateWitnesses(tr, slices, 4)
+		require.NoError(err)
+		require.Equal(distinctSlices(slices), cache.Len())
+		for i := range slices {
+			var expected bytes.Buffer
+			_, err = witnesses[i].WriteTo(&expected)
+			require.NoError(err)
+			require.Equal(expected.Bytes(), chunks[i], "witness of slice %d (%s), pass %d", i, slices[i], pass)
+		}
+	}
+
+	// The witnesses of another root replace the cached ones
+	other, otherSlices := testTrieSlices(t, 10)
+	_, err = cache.GenerateWitnesses(other, otherSlices, 1)
+	require.NoError(err)
+	require.Equal(distinctSlices(otherSlices), cache.Len())
+}
+
+func distinctSlices(slices []mgr.StateSlice) int {
+	distinct := make(map[string]struct{})
+	for _, slice := range slices {
+		distinct[slice.String()] = struct{}{}
+	}
+	return len(distinct)
+}
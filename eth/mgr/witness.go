@@ -0,0 +1,90 @@
+package mgr
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+	"github.com/ledgerwatch/turbo-geth/metrics"
+	"github.com/ledgerwatch/turbo-geth/trie"
+)
+
+var (
+	sliceResolveTimer = metrics.NewRegisteredTimer("mgr/slice/resolve", nil)
+	sliceWitnessTimer = metrics.NewRegisteredTimer("mgr/slice/witness", nil)
+)
+
+// TickStateSlices converts the state size slices of the tick into the state slices. It resolves the slices into the trie,
+// and computes the hashes of the trie, so that afterwards the witnesses can be extracted from it concurrently (see GenerateWitnesses)
+func TickStateSlices(db ethdb.Database, tr *trie.Trie, tick Tick) ([]StateSlice, error) {
+	slices := make([]StateSlice, len(tick.StateSizeSlices))
+	for i, ss := range tick.StateSizeSlices {
+		start := time.Now()
+		stateSlice, err := StateSizeSlice2StateSlice(db, tr, ss)
+		if err != nil {
+			return nil, err
+		}
+		sliceResolveTimer.UpdateSince(start)
+		slices[i] = stateSlice
+	}
+	// Hashing caches the references in the nodes, after this witness extraction does not modify the trie
+	tr.Hash()
+	return slices, nil
+}
+
+// GenerateWitnesses extracts the witnesses of the slices using up to concurrency workers.
+// The slices have to be resolved into the trie (see TickStateSlices), and the trie must not be modified
+// until GenerateWitnesses returns. Witnesses are returned in the order of slices
+func GenerateWitnesses(tr *trie.Trie, slices []StateSlice, concurrency int) ([]*trie.Witness, error) {
+	witnesses := make([]*trie.Witness, len(slices))
+	if err := forEachSlice(len(slices), concurrency, func(i int) (err error) {
+		witnesses[i], err = sliceWitness(tr, slices[i])
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	return witnesses, nil
+}
+
+// forEachSlice calls f for the indices 0..n-1 using up to concurrency workers, and returns the first error by index
+func forEachSlice(n int, concurrency int, f func(i int) error) error {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	if concurrency > n {
+		concurrency = n
+	}
+	errs := make([]error, n)
+	indices := make(chan int, n)
+	for i := 0; i < n; i++ {
+		indices <- i
+	}
+	close(indices)
+
+	var wg sync.WaitGroup
+	wg.Add(concurrency)
+	for w := 0; w < concurrency; w++ {
+		go func() {
+			defer wg.Done()
+			for i := range indices {
+				errs[i] = f(i)
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sliceWitness(tr *trie.Trie, slice StateSlice) (*trie.Witness, error) {
+	start := time.Now()
+	defer sliceWitnessTimer.UpdateSince(start)
+	retain := trie.NewRetainRange(common.CopyBytes(slice.From), common.CopyBytes(slice.To))
+	return tr.ExtractWitness(false, retain)
+}
@@ -0,0 +1,110 @@
+package mgr
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sync"
+
+	lru "github.com/hashicorp/golang-lru"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/crypto"
+	"github.com/ledgerwatch/turbo-geth/metrics"
+	"github.com/ledgerwatch/turbo-geth/trie"
+)
+
+var (
+	witnessCacheHitMeter  = metrics.NewRegisteredMeter("mgr/witness/cache/hit", nil)
+	witnessCacheMissMeter = metrics.NewRegisteredMeter("mgr/witness/cache/miss", nil)
+)
+
+type witnessCacheKey struct {
+	root   common.Hash
+	retain common.Hash // Hash of the retained prefixes, see retainHash
+}
+
+// WitnessCache keeps the serialized witnesses of the recently served slices, keyed by the state root and the retained
+// range, so that the witnesses, which are requested again in the following ticks or by the other peers, are not rebuilt.
+// The cache holds the witnesses of one root only, and is purged once the witnesses of another root are generated
+type WitnessCache struct {
+	lock  sync.Mutex
+	root  common.Hash
+	cache *lru.Cache
+}
+
+// NewWitnessCache creates the cache of up to size serialized witnesses
+func NewWitnessCache(size int) *WitnessCache {
+	if size < 1 {
+		size = 1
+	}
+	cache, _ := lru.New(size)
+	return &WitnessCache{cache: cache}
+}
+
+// Len returns the number of the cached witnesses
+func (c *WitnessCache) Len() int {
+	return c.cache.Len()
+}
+
+// GenerateWitnesses works like GenerateWitnesses of the package, but returns the serialized witnesses, and extracts
+// only the ones missing in the cache. The returned slices are shared with the cache and must not be modified
+func (c *WitnessCache) GenerateWitnesses(tr *trie.Trie, slices []StateSlice, concurrency int) ([][]byte, error) {
+	// The hashes are cached in the nodes by TickStateSlices, so this does not modify the trie
+	root := tr.Hash()
+	c.lock.Lock()
+	if root != c.root {
+		c.cache.Purge()
+		c.root = root
+	}
+	c.lock.Unlock()
+
+	chunks := make([][]byte, len(slices))
+	var missing []int
+	for i, slice := range slices {
+		if v, ok := c.cache.Get(witnessCacheKey{root, retainHash(slice.From, slice.To)}); ok {
+			witnessCacheHitMeter.Mark(1)
+			chunks[i] = v.([]byte)
+		} else {
+			witnessCacheMissMeter.Mark(1)
+			missing = append(missing, i)
+		}
+	}
+	if err := forEachSlice(len(missing), concurrency, func(j int) error {
+		i := missing[j]
+		witness, err := sliceWitness(tr, slices[i])
+		if err != nil {
+			return err
+		}
+		var buf bytes.Buffer
+		if _, err = witness.WriteTo(&buf); err != nil {
+			return err
+		}
+		chunks[i] = buf.Bytes()
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	// Another root could be served meanwhile, the witnesses of the stale one are not kept
+	if root == c.root {
+		for _, i := range missing {
+			c.cache.Add(witnessCacheKey{root, retainHash(slices[i].From, slices[i].To)}, chunks[i])
+		}
+	}
+	return chunks, nil
+}
+
+// retainHash hashes the retained prefixes, every one of them preceded by its length, so that the different lists
+// of the prefixes do not collide
+func retainHash(prefixes ...[]byte) common.Hash {
+	var buf []byte
+	for _, prefix := range prefixes {
+		var length [4]byte
+		binary.BigEndian.PutUint32(length[:], uint32(len(prefix)))
+		buf = append(buf, length[:]...)
+		buf = append(buf, prefix...)
+	}
+	return crypto.Keccak256Hash(buf)
+}
@@ -25,6 +25,7 @@ import (
 	"github.com/ledgerwatch/turbo-geth/common"
 	"github.com/ledgerwatch/turbo-geth/core/types"
 	"github.com/ledgerwatch/turbo-geth/eth/downloader"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
 	"github.com/ledgerwatch/turbo-geth/log"
 	"github.com/ledgerwatch/turbo-geth/p2p/enode"
 )
@@ -325,6 +326,10 @@ func (pm *ProtocolManager) doSync(op *chainSyncOp) error {
 		// for non-checkpointed (number = 0) private networks.
 		if head.Time() >= uint64(time.Now().AddDate(0, -1, 0).Unix()) {
 			atomic.StoreUint32(&pm.acceptTxs, 1)
+			// Initial sync is complete, the commits of the database opened with --bolt.nosync have to be durable again
+			if err := ethdb.EnableSync(pm.chaindb); err != nil {
+				log.Error("Failed to turn on the database sync", "err", err)
+			}
 		}
 	}
 
@@ -0,0 +1,275 @@
+package eth
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/common/changeset"
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+	"github.com/ledgerwatch/turbo-geth/core/rawdb"
+	"github.com/ledgerwatch/turbo-geth/core/types"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+	"github.com/ledgerwatch/turbo-geth/log"
+	"github.com/ledgerwatch/turbo-geth/p2p"
+)
+
+// changeSetsRequestTimeout is how long a firehose peer has to respond with the changesets
+const changeSetsRequestTimeout = 30 * time.Second
+
+var errChangeSetsSyncRunning = errors.New("changesets download is already running")
+
+// invalidChangeSetsError is returned by changeSetsSyncer.write for the changesets, which do not match the block
+type invalidChangeSetsError struct {
+	err error
+}
+
+func (e *invalidChangeSetsError) Error() string { return e.err.Error() }
+func (e *invalidChangeSetsError) Unwrap() error { return e.err }
+
+type changeSetsResponse struct {
+	peer string
+	msg  *changeSetsMsg
+}
+
+// changeSetsSyncer downloads the changesets of the canonical blocks from the firehose peers, so that the history
+// indexes can be built from them (see the history index stages of the staged sync) without the execution
+// of the blocks. The blocks, the changesets of which are already in the database, are skipped
+type changeSetsSyncer struct {
+	pm *ProtocolManager
+
+	lock      sync.Mutex
+	peers     map[string]*firehosePeer
+	running   bool
+	reqID     uint64
+	responses chan changeSetsResponse
+}
+
+func newChangeSetsSyncer(pm *ProtocolManager) *changeSetsSyncer {
+	return &changeSetsSyncer{
+		pm:        pm,
+		peers:     make(map[string]*firehosePeer),
+		responses: make(chan changeSetsResponse, 1),
+	}
+}
+
+func (s *changeSetsSyncer) register(p *firehosePeer) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.peers[p.ID().String()] = p
+}
+
+func (s *changeSetsSyncer) unregister(p *firehosePeer) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.peers, p.ID().String())
+}
+
+// deliver passes the response to the running download, the unrequested responses are dropped
+func (s *changeSetsSyncer) deliver(p *firehosePeer, msg *changeSetsMsg) {
+	s.lock.Lock()
+	running := s.running
+	s.lock.Unlock()
+	if !running {
+		return
+	}
+	select {
+	case s.responses <- changeSetsResponse{peer: p.ID().String(), msg: msg}:
+	default:
+	}
+}
+
+// start launches the download of the changesets of the canonical blocks from..to (inclusive) in the background
+func (s *changeSetsSyncer) start(from, to uint64) error {
+	if from > to {
+		return fmt.Errorf("empty range of blocks %d..%d", from, to)
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.running {
+		return errChangeSetsSyncRunning
+	}
+	s.running = true
+	s.pm.wg.Add(1)
+	go func() {
+		defer s.pm.wg.Done()
+		if err := s.sync(from, to); err != nil {
+			log.Error("Changesets download failed", "from", from, "to", to, "err", err)
+		} else {
+			log.Info("Changesets downloaded", "from", from, "to", to)
+		}
+		s.lock.Lock()
+		s.running = false
+		s.lock.Unlock()
+	}()
+	return nil
+}
+
+func (s *changeSetsSyncer) sync(from, to uint64) error {
+	useless := make(map[string]struct{}) // Peers, which failed to respond or served the invalid changesets
+	for number := from; number <= to; {
+		// The next batch of the blocks without the changesets
+		var hashes []common.Hash
+		var numbers []uint64
+		for ; number <= to && len(hashes) < MaxChangeSetsServe; number++ {
+			hash := rawdb.ReadCanonicalHash(s.pm.chaindb, number)
+			if hash == (common.Hash{}) {
+				return fmt.Errorf("block %d is not in the canonical chain", number)
+			}
+			cs, err := ethdb.GetChangeSetByBlock(s.pm.chaindb, dbutils.AccountsHistoryBucket, number)
+			if err != nil {
+				return err
+			}
+			if cs == nil {
+				hashes = append(hashes, hash)
+				numbers = append(numbers, number)
+			}
+		}
+		// Peers, which do not have the changesets of the first requested block. The peers with the pruned
+		// history may still serve the later blocks, so they are asked again once the download moves on
+		missed := make(map[string]struct{})
+		for len(hashes) > 0 {
+			p := s.pickPeer(useless, missed)
+			if p == nil {
+				return fmt.Errorf("no firehose peers serving the changesets of block %d", numbers[0])
+			}
+			msg, err := s.request(p, hashes)
+			if err != nil {
+				p.Log().Debug("Changesets request failed", "err", err)
+				useless[p.ID().String()] = struct{}{}
+				continue
+			}
+			written, err := s.write(hashes, numbers, msg.ChangeSets)
+			var invalid *invalidChangeSetsError
+			if errors.As(err, &invalid) {
+				p.Log().Warn("Invalid changesets", "err", err)
+				useless[p.ID().String()] = struct{}{}
+				p.Disconnect(p2p.DiscUselessPeer)
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if written == 0 {
+				missed[p.ID().String()] = struct{}{}
+				continue
+			}
+			hashes, numbers = hashes[written:], numbers[written:]
+			missed = make(map[string]struct{})
+		}
+	}
+	return nil
+}
+
+// write verifies and stores the changesets served for the first blocks of the request, and returns the number
+// of these blocks. The changesets served for the blocks are only written if all of them are valid
+func (s *changeSetsSyncer) write(hashes []common.Hash, numbers []uint64, entries []blockChangeSets) (int, error) {
+	if len(entries) > len(numbers) {
+		return 0, &invalidChangeSetsError{fmt.Errorf("%d changesets for %d blocks", len(entries), len(numbers))}
+	}
+	var served int
+	for i, entry := range entries {
+		if entry.Status != OK {
+			break
+		}
+		if err := s.verify(hashes[i], numbers[i], entry); err != nil {
+			return 0, err
+		}
+		served++
+	}
+	if served == 0 {
+		return 0, nil
+	}
+	batch := s.pm.chaindb.NewBatch()
+	defer batch.Rollback()
+	for i, entry := range entries[:served] {
+		key := dbutils.EncodeTimestamp(numbers[i])
+		if err := batch.Put(dbutils.AccountChangeSetBucket, key, entry.AccountChanges); err != nil {
+			return 0, err
+		}
+		if len(entry.StorageChanges) > 0 {
+			if err := batch.Put(dbutils.StorageChangeSetBucket, key, entry.StorageChanges); err != nil {
+				return 0, err
+			}
+		}
+	}
+	if _, err := batch.Commit(); err != nil {
+		return 0, err
+	}
+	return served, nil
+}
+
+// verify checks the changesets served for the block. The changesets are not committed to by the headers,
+// so only their encoding and the accounts, which the block is known to change, are checked: the senders
+// of the transactions (their nonces are incremented) must be in the account changes
+func (s *changeSetsSyncer) verify(hash common.Hash, number uint64, entry blockChangeSets) error {
+	accountChanges, err := changeset.DecodeAccounts(entry.AccountChanges)
+	if err != nil {
+		return &invalidChangeSetsError{fmt.Errorf("account changes of block %d: %w", number, err)}
+	}
+	if len(entry.StorageChanges) > 0 {
+		if _, err = changeset.DecodeStorage(entry.StorageChanges); err != nil {
+			return &invalidChangeSetsError{fmt.Errorf("storage changes of block %d: %w", number, err)}
+		}
+	}
+	block := rawdb.ReadBlock(s.pm.chaindb, hash, number)
+	if block == nil {
+		return fmt.Errorf("body of block %d is missing", number)
+	}
+	changed := accountChanges.ChangedKeys()
+	signer := types.MakeSigner(s.pm.blockchain.Config(), block.Number())
+	for _, tx := range block.Transactions() {
+		sender, err := types.Sender(signer, tx)
+		if err != nil {
+			return fmt.Errorf("sender of transaction %x in block %d: %w", tx.Hash(), number, err)
+		}
+		addrHash, err := common.HashData(sender[:])
+		if err != nil {
+			return err
+		}
+		if _, ok := changed[string(addrHash[:])]; !ok {
+			return &invalidChangeSetsError{fmt.Errorf("account changes of block %d miss the sender %x", number, sender)}
+		}
+	}
+	return nil
+}
+
+func (s *changeSetsSyncer) pickPeer(useless, missed map[string]struct{}) *firehosePeer {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for id, p := range s.peers {
+		if _, ok := useless[id]; ok {
+			continue
+		}
+		if _, ok := missed[id]; !ok {
+			return p
+		}
+	}
+	return nil
+}
+
+func (s *changeSetsSyncer) request(p *firehosePeer, blocks []common.Hash) (*changeSetsMsg, error) {
+	s.lock.Lock()
+	s.reqID++
+	id := s.reqID
+	s.lock.Unlock()
+	if err := p.RequestChangeSets(id, blocks); err != nil {
+		return nil, err
+	}
+	timeout := time.NewTimer(changeSetsRequestTimeout)
+	defer timeout.Stop()
+	for {
+		select {
+		case resp := <-s.responses:
+			if resp.peer == p.ID().String() && resp.msg.ID == id {
+				return resp.msg, nil
+			}
+		case <-timeout.C:
+			return nil, errors.New("timeout")
+		case <-s.pm.quitSync:
+			return nil, errors.New("quitting")
+		}
+	}
+}
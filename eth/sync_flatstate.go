@@ -0,0 +1,167 @@
+package eth
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/eth/downloader"
+	"github.com/ledgerwatch/turbo-geth/log"
+	"github.com/ledgerwatch/turbo-geth/p2p"
+	"github.com/ledgerwatch/turbo-geth/trie"
+)
+
+// flatStateRequestTimeout is how long a firehose peer has to respond with a range of the state
+const flatStateRequestTimeout = 30 * time.Second
+
+var errFlatStateSyncRunning = errors.New("state download is already running")
+
+type flatStateResponse struct {
+	peer string
+	msg  *flatStateRangeMsg
+}
+
+// flatStateSyncer downloads the state of the pivot block from the firehose peers by ranges (see downloader.FlatStateSync).
+// Only the peers, the head of which is the pivot block, can serve the ranges, because the flat buckets hold
+// the state of the head only
+type flatStateSyncer struct {
+	pm *ProtocolManager
+
+	lock      sync.Mutex
+	peers     map[string]*firehosePeer
+	running   bool
+	reqID     uint64
+	responses chan flatStateResponse
+}
+
+func newFlatStateSyncer(pm *ProtocolManager) *flatStateSyncer {
+	return &flatStateSyncer{
+		pm:        pm,
+		peers:     make(map[string]*firehosePeer),
+		responses: make(chan flatStateResponse, 1),
+	}
+}
+
+func (s *flatStateSyncer) register(p *firehosePeer) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.peers[p.ID().String()] = p
+}
+
+func (s *flatStateSyncer) unregister(p *firehosePeer) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.peers, p.ID().String())
+}
+
+// deliver passes the response to the running download, the unrequested responses are dropped
+func (s *flatStateSyncer) deliver(p *firehosePeer, msg *flatStateRangeMsg) {
+	s.lock.Lock()
+	running := s.running
+	s.lock.Unlock()
+	if !running {
+		return
+	}
+	select {
+	case s.responses <- flatStateResponse{peer: p.ID().String(), msg: msg}:
+	default:
+	}
+}
+
+// start launches the download of the state with the given root of the pivot block in the background
+func (s *flatStateSyncer) start(block common.Hash, root common.Hash) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.running {
+		return errFlatStateSyncRunning
+	}
+	s.running = true
+	s.pm.wg.Add(1)
+	go func() {
+		defer s.pm.wg.Done()
+		if err := s.sync(block, root); err != nil {
+			log.Error("State download failed", "block", block, "root", root, "err", err)
+		}
+		s.lock.Lock()
+		s.running = false
+		s.lock.Unlock()
+	}()
+	return nil
+}
+
+func (s *flatStateSyncer) sync(block common.Hash, root common.Hash) error {
+	fss, err := downloader.NewFlatStateSync(s.pm.chaindb, root)
+	if err != nil {
+		return err
+	}
+	useless := make(map[string]struct{}) // Peers, which do not serve the pivot block
+	for !fss.Done() {
+		p := s.pickPeer(useless)
+		if p == nil {
+			return fmt.Errorf("no firehose peers serving the state of block %x", block)
+		}
+		from := fss.Next()
+		msg, err := s.request(p, block, from)
+		if err != nil {
+			p.Log().Debug("State range request failed", "err", err)
+			useless[p.ID().String()] = struct{}{}
+			continue
+		}
+		if msg.Status != OK {
+			useless[p.ID().String()] = struct{}{}
+			continue
+		}
+		w, err := trie.NewWitnessFromReader(bytes.NewReader(msg.Witness), false /* trace */)
+		if err == nil {
+			var last []byte
+			if len(msg.Last) > 0 {
+				last = msg.Last
+			}
+			err = fss.Deliver(from, last, w)
+		}
+		if err != nil {
+			p.Log().Warn("Invalid state range", "err", err)
+			useless[p.ID().String()] = struct{}{}
+			p.Disconnect(p2p.DiscUselessPeer)
+		}
+	}
+	return fss.Finish(s.pm.datadir)
+}
+
+func (s *flatStateSyncer) pickPeer(useless map[string]struct{}) *firehosePeer {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for id, p := range s.peers {
+		if _, ok := useless[id]; !ok {
+			return p
+		}
+	}
+	return nil
+}
+
+func (s *flatStateSyncer) request(p *firehosePeer, block common.Hash, from []byte) (*flatStateRangeMsg, error) {
+	s.lock.Lock()
+	s.reqID++
+	id := s.reqID
+	s.lock.Unlock()
+	if err := p.RequestFlatStateRange(id, block, from); err != nil {
+		return nil, err
+	}
+	timeout := time.NewTimer(flatStateRequestTimeout)
+	defer timeout.Stop()
+	for {
+		select {
+		case resp := <-s.responses:
+			if resp.peer == p.ID().String() && resp.msg.ID == id {
+				return resp.msg, nil
+			}
+		case <-timeout.C:
+			return nil, errors.New("timeout")
+		case <-s.pm.quitSync:
+			return nil, errors.New("quitting")
+		}
+	}
+}
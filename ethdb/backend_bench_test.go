@@ -0,0 +1,215 @@
+//go:build !js
+// +build !js
+
+package ethdb
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"testing"
+	"text/tabwriter"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/common/changeset"
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+)
+
+var backendComparison = flag.Bool("backend-comparison", false, "print the timings of the state workloads on Bolt and Badger")
+
+const (
+	benchAccounts       = 50000 // Accounts in the state before every workload
+	benchAccountSize    = 70    // Typical size of the account encoded for the storage
+	benchMultiPutSize   = 10000 // Storage items written by one MultiPut
+	benchChangeSetSize  = 200   // Accounts changed in one block
+	benchPrefixScanBits = 8     // The scans walk over 1/256 of the accounts
+)
+
+type benchBackend struct {
+	name string
+	open func() (Database, func())
+}
+
+var benchBackends = []benchBackend{
+	{"bolt", func() (Database, func()) { return newTestBoltDB() }},
+	{"badger", func() (Database, func()) { return newTestBadgerDB() }},
+}
+
+// benchWorkload runs b.N operations of the workload over the state filled with benchAccounts accounts
+type benchWorkload struct {
+	name string
+	run  func(b *testing.B, db Database, accounts [][]byte)
+}
+
+var benchWorkloads = []benchWorkload{
+	{"random-get", benchRandomGet},
+	{"prefix-scan", benchPrefixScan},
+	{"multiput", benchMultiPut},
+	{"changesets", benchChangeSets},
+}
+
+func BenchmarkBackends(b *testing.B) {
+	for _, w := range benchWorkloads {
+		w := w
+		for _, backend := range benchBackends {
+			backend := backend
+			b.Run(w.name+"/"+backend.name, func(b *testing.B) {
+				runBenchWorkload(b, backend, w)
+			})
+		}
+	}
+}
+
+// TestBackendComparison prints the table of the workload timings of the backends side by side, run it with
+// go test ./ethdb -run TestBackendComparison -backend-comparison
+func TestBackendComparison(t *testing.T) {
+	if !*backendComparison {
+		t.Skip("enabled by -backend-comparison")
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprint(w, "workload\t")
+	for _, backend := range benchBackends {
+		fmt.Fprintf(w, "%s ns/op\t%s allocs/op\t", backend.name, backend.name)
+	}
+	fmt.Fprintf(w, "%s/%s\t\n", benchBackends[1].name, benchBackends[0].name)
+	for _, workload := range benchWorkloads {
+		fmt.Fprintf(w, "%s\t", workload.name)
+		var nsPerOp []int64
+		for _, backend := range benchBackends {
+			r := testing.Benchmark(func(b *testing.B) {
+				runBenchWorkload(b, backend, workload)
+			})
+			nsPerOp = append(nsPerOp, r.NsPerOp())
+			fmt.Fprintf(w, "%d\t%d\t", r.NsPerOp(), r.AllocsPerOp())
+		}
+		if nsPerOp[0] > 0 {
+			fmt.Fprintf(w, "%.2f\t\n", float64(nsPerOp[1])/float64(nsPerOp[0]))
+		} else {
+			fmt.Fprint(w, "-\t\n")
+		}
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func runBenchWorkload(b *testing.B, backend benchBackend, w benchWorkload) {
+	db, remove := backend.open()
+	defer remove()
+	accounts := fillBenchAccounts(b, db)
+	b.ReportAllocs()
+	b.ResetTimer()
+	w.run(b, db, accounts)
+}
+
+// fillBenchAccounts writes the accounts with the random hashed keys, and returns the keys in the sorted order
+func fillBenchAccounts(b *testing.B, db Database) [][]byte {
+	rnd := rand.New(rand.NewSource(1))
+	accounts := make([][]byte, benchAccounts)
+	for i := range accounts {
+		accounts[i] = make([]byte, common.HashLength)
+		rnd.Read(accounts[i])
+	}
+	sort.Slice(accounts, func(i, j int) bool { return bytes.Compare(accounts[i], accounts[j]) < 0 })
+	tuples := make([][]byte, 0, 3*len(accounts))
+	for _, k := range accounts {
+		v := make([]byte, benchAccountSize)
+		rnd.Read(v)
+		tuples = append(tuples, dbutils.CurrentStateBucket, k, v)
+	}
+	if _, err := db.MultiPut(tuples...); err != nil {
+		b.Fatal(err)
+	}
+	return accounts
+}
+
+func benchRandomGet(b *testing.B, db Database, accounts [][]byte) {
+	rnd := rand.New(rand.NewSource(2))
+	for i := 0; i < b.N; i++ {
+		if _, err := db.Get(dbutils.CurrentStateBucket, accounts[rnd.Intn(len(accounts))]); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func benchPrefixScan(b *testing.B, db Database, accounts [][]byte) {
+	rnd := rand.New(rand.NewSource(3))
+	for i := 0; i < b.N; i++ {
+		var n int
+		startkey := make([]byte, common.HashLength)
+		startkey[0] = byte(rnd.Intn(256))
+		if err := db.Walk(dbutils.CurrentStateBucket, startkey, benchPrefixScanBits, func(k, v []byte) (bool, error) {
+			n++
+			return true, nil
+		}); err != nil {
+			b.Fatal(err)
+		}
+		if n == 0 {
+			b.Fatalf("no accounts with the prefix %x", startkey[0])
+		}
+	}
+}
+
+func benchMultiPut(b *testing.B, db Database, accounts [][]byte) {
+	rnd := rand.New(rand.NewSource(4))
+	keys := make([][]byte, benchMultiPutSize)
+	values := make([][]byte, benchMultiPutSize)
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		for j := range keys {
+			// The storage items of the existing accounts
+			keys[j] = make([]byte, common.HashLength+common.IncarnationLength+common.HashLength)
+			copy(keys[j], accounts[rnd.Intn(len(accounts))])
+			rnd.Read(keys[j][common.HashLength+common.IncarnationLength:])
+			values[j] = make([]byte, 1+rnd.Intn(common.HashLength))
+			rnd.Read(values[j])
+		}
+		sort.Sort(keysAndValues{keys, values})
+		tuples := make([][]byte, 0, 3*len(keys))
+		for j := range keys {
+			tuples = append(tuples, dbutils.CurrentStateBucket, keys[j], values[j])
+		}
+		b.StartTimer()
+		if _, err := db.MultiPut(tuples...); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func benchChangeSets(b *testing.B, db Database, accounts [][]byte) {
+	rnd := rand.New(rand.NewSource(5))
+	for i := 0; i < b.N; i++ {
+		cs := changeset.NewAccountChangeSet()
+		// Distinct accounts spread over the state
+		step := len(accounts) / benchChangeSetSize
+		offset := rnd.Intn(step)
+		for j := 0; j < benchChangeSetSize; j++ {
+			v := make([]byte, benchAccountSize)
+			rnd.Read(v)
+			if err := cs.Add(accounts[offset+j*step], v); err != nil {
+				b.Fatal(err)
+			}
+		}
+		enc, err := changeset.EncodeAccounts(cs)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if err = db.Put(dbutils.AccountChangeSetBucket, dbutils.EncodeTimestamp(uint64(i)), enc); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+type keysAndValues struct {
+	keys, values [][]byte
+}
+
+func (kv keysAndValues) Len() int           { return len(kv.keys) }
+func (kv keysAndValues) Less(i, j int) bool { return bytes.Compare(kv.keys[i], kv.keys[j]) < 0 }
+func (kv keysAndValues) Swap(i, j int) {
+	kv.keys[i], kv.keys[j] = kv.keys[j], kv.keys[i]
+	kv.values[i], kv.values[j] = kv.values[j], kv.values[i]
+}
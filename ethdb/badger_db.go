@@ -18,6 +18,7 @@ package ethdb
 
 import (
 	"bytes"
+	"errors"
 	"io/ioutil"
 	"os"
 	"runtime"
@@ -38,11 +39,11 @@ const gcPeriod = 35 * time.Minute
 // BadgerDatabase is a wrapper over BadgerDb,
 // compatible with the Database interface.
 type BadgerDatabase struct {
-	db       *badger.DB   // BadgerDB instance
-	log      log.Logger   // Contextual logger tracking the database path
-	tmpDir   string       // Temporary data directory
-	gcTicker *time.Ticker // Garbage Collector
-	id       uint64
+	db     *badger.DB // BadgerDB instance
+	log    log.Logger // Contextual logger tracking the database path
+	tmpDir string     // Temporary data directory
+	gc     *badgerGC  // Garbage Collector
+	id     uint64
 }
 
 // NewBadgerDatabase returns a BadgerDB wrapper.
@@ -61,22 +62,15 @@ func NewBadgerDatabase(dir string) (*BadgerDatabase, error) {
 		return nil, err
 	}
 
-	ticker := time.NewTicker(gcPeriod)
+	gc := newBadgerGC(db, DefaultBadgerGCConfig, logger)
 	// Start GC in backround
-	go func() {
-		for range ticker.C {
-			err := db.RunValueLogGC(0.5)
-			if err != badger.ErrNoRewrite {
-				logger.Info("Badger GC run", "err", err)
-			}
-		}
-	}()
+	gc.start()
 
 	return &BadgerDatabase{
-		db:       db,
-		log:      logger,
-		gcTicker: ticker,
-		id:       id(),
+		db:  db,
+		log: logger,
+		gc:  gc,
+		id:  id(),
 	}, nil
 }
 
@@ -103,9 +97,7 @@ func NewEphemeralBadger() (*BadgerDatabase, error) {
 
 // Close closes the database.
 func (db *BadgerDatabase) Close() {
-	if db.gcTicker != nil {
-		db.gcTicker.Stop()
-	}
+	db.gc.stop()
 
 	if err := db.db.Close(); err == nil {
 		db.log.Info("Database closed")
@@ -136,16 +128,18 @@ func keyWithoutBucket(key, bucket []byte) []byte {
 
 // Delete removes a single entry.
 func (db *BadgerDatabase) Delete(bucket, key []byte) error {
-	return db.db.Update(func(txn *badger.Txn) error {
+	db.gc.wrote(len(key))
+	return badgerErr(db.db.Update(func(txn *badger.Txn) error {
 		return txn.Delete(bucketKey(bucket, key))
-	})
+	}))
 }
 
 // Put inserts or updates a single entry.
 func (db *BadgerDatabase) Put(bucket, key []byte, value []byte) error {
-	return db.db.Update(func(txn *badger.Txn) error {
+	db.gc.wrote(len(key) + len(value))
+	return badgerErr(db.db.Update(func(txn *badger.Txn) error {
 		return txn.Set(bucketKey(bucket, key), value)
-	})
+	}))
 }
 
 // Get returns the value for a given key if it's present.
@@ -159,10 +153,10 @@ func (db *BadgerDatabase) Get(bucket, key []byte) ([]byte, error) {
 		val, err = item.ValueCopy(nil)
 		return err
 	})
-	if err == badger.ErrKeyNotFound {
-		return nil, ErrKeyNotFound
+	if err != nil {
+		return nil, badgerErr(err)
 	}
-	return val, err
+	return val, nil
 }
 
 func (db *BadgerDatabase) GetIndexChunk(bucket, key []byte, timestamp uint64) ([]byte, error) {
@@ -182,49 +176,30 @@ func (db *BadgerDatabase) GetIndexChunk(bucket, key []byte, timestamp uint64) ([
 		}
 		return badger.ErrKeyNotFound
 	})
-	if err == badger.ErrKeyNotFound {
-		return nil, ErrKeyNotFound
+	if err != nil {
+		return nil, badgerErr(err)
 	}
-	return val, err
+	return val, nil
 }
 
 // GetAsOf returns the value valid as of a given timestamp.
 func (db *BadgerDatabase) GetAsOf(bucket, hBucket, key []byte, timestamp uint64) ([]byte, error) {
-	composite, _ := dbutils.CompositeKeySuffix(key, timestamp)
 	var dat []byte
 	err := db.db.View(func(tx *badger.Txn) error {
-		{ // first look in the historical bucket
-			it := tx.NewIterator(badger.DefaultIteratorOptions)
-			defer it.Close()
-			it.Seek(bucketKey(hBucket, composite))
-
-			if it.ValidForPrefix(bucketKey(hBucket, key)) {
-				var err2 error
-				dat, err2 = it.Item().ValueCopy(nil)
-				return err2
-			}
-		}
-
-		{ // fall back to the current bucket
-			item, err2 := tx.Get(bucketKey(bucket, key))
-			if err2 != nil {
-				return err2
-			}
-			dat, err2 = item.ValueCopy(nil)
-			return err2
-		}
+		var err error
+		dat, err = getAsOfWith(badgerHistoryReader{txn: tx}, bucket, hBucket, key, timestamp)
+		return err
 	})
-
-	if err == badger.ErrKeyNotFound {
-		return dat, ErrKeyNotFound
+	if err != nil {
+		return nil, badgerErr(err)
 	}
-	return dat, err
+	return dat, nil
 }
 
 // Has indicates whether a key exists in the database.
 func (db *BadgerDatabase) Has(bucket, key []byte) (bool, error) {
 	_, err := db.Get(bucket, key)
-	if err == ErrKeyNotFound {
+	if errors.Is(err, ErrKeyNotFound) {
 		return false, nil
 	}
 	return err == nil, err
@@ -340,6 +315,16 @@ func (db *BadgerDatabase) MultiWalk(bucket []byte, startkeys [][]byte, fixedbits
 	return err
 }
 
+// PauseGC implements GCPauser
+func (db *BadgerDatabase) PauseGC() {
+	db.gc.pause()
+}
+
+// ResumeGC implements GCPauser
+func (db *BadgerDatabase) ResumeGC() {
+	db.gc.resume()
+}
+
 // MultiPut inserts or updates multiple entries.
 // Entries are passed as an array:
 // bucket0, key0, val0, bucket1, key1, val1, ...
@@ -353,13 +338,14 @@ func (db *BadgerDatabase) MultiPut(triplets ...[]byte) (uint64, error) {
 			if err := tx.Set(bucketKey(bucket, key), val); err != nil {
 				return err
 			}
+			db.gc.wrote(len(key) + len(val))
 		}
 		return nil
 	})
 	if err != nil {
-		return 0, err
+		return 0, badgerErr(err)
 	}
-	return uint64(l / 3), err
+	return uint64(l / 3), nil
 }
 
 func (db *BadgerDatabase) NewBatch() DbWithPendingMutations {
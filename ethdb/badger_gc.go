@@ -0,0 +1,230 @@
+package ethdb
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dgraph-io/badger/v2"
+	"github.com/ledgerwatch/turbo-geth/log"
+	"github.com/ledgerwatch/turbo-geth/metrics"
+)
+
+var (
+	badgerGCRuns          = metrics.NewRegisteredCounter("db/badger/gc/runs", nil)
+	badgerGCRewrites      = metrics.NewRegisteredCounter("db/badger/gc/rewrites", nil)
+	badgerGCDeferred      = metrics.NewRegisteredCounter("db/badger/gc/deferred", nil) // due, but the database was busy or paused
+	badgerGCTimer         = metrics.NewRegisteredTimer("db/badger/gc/duration", nil)
+	badgerGCVlogSize      = metrics.NewRegisteredGauge("db/badger/gc/vlog", nil)
+	badgerGCAmplification = metrics.NewRegisteredGaugeFloat64("db/badger/gc/amplification", nil)
+)
+
+// BadgerGCConfig tunes the background garbage collection of the Badger value log
+type BadgerGCConfig struct {
+	CheckPeriod     time.Duration // how often the value log is looked at
+	IdleTimeout     time.Duration // the database is idle, when nothing has been written for this long
+	MaxPeriod       time.Duration // the collection is attempted at least this often, even if the value log has not grown
+	MinGrowth       int64         // growth of the value log since the last collection, which makes the next one due
+	MaxRewrites     int           // value log files rewritten by one collection
+	RewriteInterval time.Duration // pause between the rewrites, which limits the rate of the collection
+
+	DiscardRatio float64 // see badger.DB.RunValueLogGC
+	// With the write amplification (the value log growth per byte written) above MaxAmplification, the garbage
+	// piles up faster, and the files with less of it (AmplifiedDiscardRatio) are rewritten as well
+	MaxAmplification      float64
+	AmplifiedDiscardRatio float64
+}
+
+// DefaultBadgerGCConfig is used by the Badger databases unless configured otherwise
+var DefaultBadgerGCConfig = BadgerGCConfig{
+	CheckPeriod:     time.Minute,
+	IdleTimeout:     30 * time.Second,
+	MaxPeriod:       gcPeriod,
+	MinGrowth:       1 << 30,
+	MaxRewrites:     16,
+	RewriteInterval: 10 * time.Second,
+
+	DiscardRatio:          0.5,
+	MaxAmplification:      2,
+	AmplifiedDiscardRatio: 0.25,
+}
+
+// GCPauser is implemented by the databases, which collect the garbage in the background
+type GCPauser interface {
+	// PauseGC stops the collection until ResumeGC, waiting for the running rewrite to finish. The pauses nest
+	PauseGC()
+	ResumeGC()
+}
+
+// PauseGC pauses the background garbage collection of the database, if it has one, until the returned function
+// is called. It is used around the critical sections, like the unwinds, which should not share the disk with it
+func PauseGC(db interface{}) (resume func()) {
+	if p, ok := db.(GCPauser); ok {
+		p.PauseGC()
+		return p.ResumeGC
+	}
+	return func() {}
+}
+
+// badgerGC runs the value log garbage collection, when the value log has grown enough (or MaxPeriod has passed)
+// and nothing is being written (no blocks are processed), rewriting at most MaxRewrites files at a time.
+// All the methods are safe to call on nil, for the databases without the collection
+type badgerGC struct {
+	db     *badger.DB
+	config BadgerGCConfig
+	log    log.Logger
+
+	written   int64 // bytes written since the last check, atomic
+	lastWrite int64 // unix nanoseconds, atomic
+	paused    int32 // atomic
+	rewriteMu sync.Mutex
+
+	lastVlog int64 // value log size at the last check
+	gcVlog   int64 // value log size after the last collection
+	lastGC   time.Time
+
+	quit chan struct{}
+	done chan struct{}
+}
+
+func newBadgerGC(db *badger.DB, config BadgerGCConfig, logger log.Logger) *badgerGC {
+	_, vlog := db.Size()
+	return &badgerGC{
+		db:       db,
+		config:   config,
+		log:      logger,
+		lastVlog: vlog,
+		gcVlog:   vlog,
+		lastGC:   time.Now(),
+		quit:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+func (gc *badgerGC) start() {
+	go func() {
+		defer close(gc.done)
+		ticker := time.NewTicker(gc.config.CheckPeriod)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-gc.quit:
+				return
+			case now := <-ticker.C:
+				gc.check(now)
+			}
+		}
+	}()
+}
+
+// stop waits for the running rewrite, the database can be closed afterwards
+func (gc *badgerGC) stop() {
+	if gc == nil {
+		return
+	}
+	close(gc.quit)
+	<-gc.done
+}
+
+// wrote accounts the bytes written to the database, the collection waits until the writes stop
+func (gc *badgerGC) wrote(n int) {
+	if gc == nil {
+		return
+	}
+	atomic.AddInt64(&gc.written, int64(n))
+	atomic.StoreInt64(&gc.lastWrite, time.Now().UnixNano())
+}
+
+func (gc *badgerGC) pause() {
+	if gc == nil {
+		return
+	}
+	atomic.AddInt32(&gc.paused, 1)
+	gc.rewriteMu.Lock()
+	gc.rewriteMu.Unlock() //nolint:staticcheck
+}
+
+func (gc *badgerGC) resume() {
+	if gc == nil {
+		return
+	}
+	atomic.AddInt32(&gc.paused, -1)
+}
+
+func (gc *badgerGC) idle(now time.Time) bool {
+	if atomic.LoadInt32(&gc.paused) > 0 {
+		return false
+	}
+	return now.Sub(time.Unix(0, atomic.LoadInt64(&gc.lastWrite))) >= gc.config.IdleTimeout
+}
+
+// check updates the metrics, and runs the collection if it is due and the database is idle
+func (gc *badgerGC) check(now time.Time) {
+	_, vlog := gc.db.Size()
+	written := atomic.SwapInt64(&gc.written, 0)
+	var amplification float64
+	if written > 0 {
+		amplification = float64(vlog-gc.lastVlog) / float64(written)
+	}
+	gc.lastVlog = vlog
+	badgerGCVlogSize.Update(vlog)
+	badgerGCAmplification.Update(amplification)
+
+	due, ratio := gc.plan(now, vlog, amplification)
+	if !due {
+		return
+	}
+	if !gc.idle(now) {
+		badgerGCDeferred.Inc(1)
+		return
+	}
+	gc.collect(ratio)
+	gc.lastGC = time.Now()
+	_, gc.gcVlog = gc.db.Size()
+	gc.lastVlog = gc.gcVlog
+}
+
+// plan decides whether the collection is due, and with which discard ratio
+func (gc *badgerGC) plan(now time.Time, vlog int64, amplification float64) (bool, float64) {
+	if vlog-gc.gcVlog < gc.config.MinGrowth && now.Sub(gc.lastGC) < gc.config.MaxPeriod {
+		return false, 0
+	}
+	if amplification > gc.config.MaxAmplification {
+		return true, gc.config.AmplifiedDiscardRatio
+	}
+	return true, gc.config.DiscardRatio
+}
+
+// collect rewrites the value log files one by one, while there is the garbage to collect and the database is idle
+func (gc *badgerGC) collect(ratio float64) {
+	defer badgerGCTimer.UpdateSince(time.Now())
+	badgerGCRuns.Inc(1)
+	var rewrites int
+	for rewrites < gc.config.MaxRewrites {
+		if rewrites > 0 {
+			select {
+			case <-gc.quit:
+				return
+			case <-time.After(gc.config.RewriteInterval):
+			}
+		}
+		gc.rewriteMu.Lock()
+		if !gc.idle(time.Now()) {
+			gc.rewriteMu.Unlock()
+			break
+		}
+		err := gc.db.RunValueLogGC(ratio)
+		gc.rewriteMu.Unlock()
+		if err != nil {
+			if err != badger.ErrNoRewrite {
+				gc.log.Warn("Badger GC failed", "err", err)
+			}
+			break
+		}
+		rewrites++
+		badgerGCRewrites.Inc(1)
+	}
+	if rewrites > 0 {
+		gc.log.Info("Badger GC run", "rewrites", rewrites, "discardRatio", ratio)
+	}
+}
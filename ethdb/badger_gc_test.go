@@ -0,0 +1,62 @@
+package ethdb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ledgerwatch/turbo-geth/log"
+)
+
+func TestBadgerGCPlan(t *testing.T) {
+	db, remove := newTestBadgerDB()
+	defer remove()
+	config := DefaultBadgerGCConfig
+	gc := newBadgerGC(db.db, config, log.New())
+	now := gc.lastGC
+
+	due, _ := gc.plan(now.Add(time.Minute), gc.gcVlog+config.MinGrowth-1, 1)
+	assert.False(t, due)
+	due, ratio := gc.plan(now.Add(time.Minute), gc.gcVlog+config.MinGrowth, 1)
+	assert.True(t, due)
+	assert.Equal(t, config.DiscardRatio, ratio)
+	due, ratio = gc.plan(now.Add(config.MaxPeriod), gc.gcVlog, 0)
+	assert.True(t, due)
+	assert.Equal(t, config.DiscardRatio, ratio)
+	due, ratio = gc.plan(now.Add(config.MaxPeriod), gc.gcVlog, config.MaxAmplification+1)
+	assert.True(t, due)
+	assert.Equal(t, config.AmplifiedDiscardRatio, ratio)
+}
+
+func TestBadgerGCIdle(t *testing.T) {
+	db, remove := newTestBadgerDB()
+	defer remove()
+	config := DefaultBadgerGCConfig
+	gc := newBadgerGC(db.db, config, log.New())
+	// gc is not started, so the database is closed with its own one
+	dbGC := db.gc
+	db.gc = gc
+	defer func() { db.gc = dbGC }()
+
+	now := time.Now()
+	assert.True(t, gc.idle(now))
+	assert.NoError(t, db.Put(testBucket, []byte("key"), []byte("value")))
+	assert.False(t, gc.idle(time.Now()))
+	assert.True(t, gc.idle(time.Now().Add(config.IdleTimeout)))
+
+	resume := PauseGC(db)
+	assert.False(t, gc.idle(time.Now().Add(config.IdleTimeout)))
+	// The pauses nest
+	PauseGC(db)()
+	assert.False(t, gc.idle(time.Now().Add(config.IdleTimeout)))
+	resume()
+	assert.True(t, gc.idle(time.Now().Add(config.IdleTimeout)))
+
+	// The collection is deferred while busy
+	assert.NoError(t, db.Put(testBucket, []byte("key"), []byte("other")))
+	lastGC := time.Now().Add(-config.MaxPeriod)
+	gc.lastGC = lastGC
+	gc.check(time.Now())
+	assert.Equal(t, lastGC, gc.lastGC)
+}
@@ -0,0 +1,151 @@
+package ethdb
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/ledgerwatch/bolt"
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/log"
+)
+
+// BoltBucketReport describes how well the pages of one bucket are utilised
+type BoltBucketReport struct {
+	Name          []byte
+	Keys          int
+	Depth         int
+	BranchPages   int
+	LeafPages     int
+	OverflowPages int // pages allocated for the values (and branches) larger than one page
+	Alloc         int // bytes allocated for the branch and leaf pages
+	Inuse         int // bytes actually used in the branch and leaf pages
+}
+
+// FillRatio is the share of the allocated bytes actually used, 1 for the empty buckets
+func (r BoltBucketReport) FillRatio() float64 {
+	if r.Alloc == 0 {
+		return 1
+	}
+	return float64(r.Inuse) / float64(r.Alloc)
+}
+
+// BoltFileReport describes the page utilisation of the Bolt file
+type BoltFileReport struct {
+	FileSize      int64
+	PageSize      int
+	FreePages     int // pages in the freelist, available for the reuse
+	PendingPages  int // pages freed by the transactions still referenced by the readers
+	FreelistInuse int // bytes taken by the freelist itself
+	Buckets       []BoltBucketReport
+}
+
+// ReportBoltPages opens the Bolt file in read-only mode and collects per-bucket page utilisation,
+// which shows how much space is left behind by the deleted records (i.e. pruned history)
+func ReportBoltPages(path string) (*BoltFileReport, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(path, 0600, &bolt.Options{ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	stats := db.Stats()
+	report := &BoltFileReport{
+		FileSize:      fi.Size(),
+		PageSize:      db.Info().PageSize,
+		FreePages:     stats.FreePageN,
+		PendingPages:  stats.PendingPageN,
+		FreelistInuse: stats.FreelistInuse,
+	}
+	if err := db.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			bs := b.Stats()
+			report.Buckets = append(report.Buckets, BoltBucketReport{
+				Name:          common.CopyBytes(name),
+				Keys:          bs.KeyN,
+				Depth:         bs.Depth,
+				BranchPages:   bs.BranchPageN,
+				LeafPages:     bs.LeafPageN,
+				OverflowPages: bs.BranchOverflowN + bs.LeafOverflowN,
+				Alloc:         bs.BranchAlloc + bs.LeafAlloc,
+				Inuse:         bs.BranchInuse + bs.LeafInuse,
+			})
+			return nil
+		})
+	}); err != nil {
+		return nil, boltErr(err)
+	}
+	return report, nil
+}
+
+// CompactBolt rebuilds the Bolt file `from` into the new file `to`, bucket by bucket.
+// Records are inserted in the key order, so the pages of the new file are filled up, and the free pages
+// of the original file are not copied. `batchSize` limits the size (in bytes) of the data written by one transaction.
+// The original file is not modified, replacing it with the compacted one is up to the operator.
+func CompactBolt(from, to string, batchSize int) error {
+	if _, err := os.Stat(to); err == nil {
+		return fmt.Errorf("destination file already exists: %s", to)
+	}
+	src, err := bolt.Open(from, 0600, &bolt.Options{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := bolt.Open(to, 0600, &bolt.Options{KeysPrefixCompressionDisable: true})
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	logEvery := time.NewTicker(30 * time.Second)
+	defer logEvery.Stop()
+
+	return boltErr(src.View(func(srcTx *bolt.Tx) error {
+		return srcTx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			name = common.CopyBytes(name)
+			var pairs [][]byte
+			var size, total int
+			flush := func() error {
+				err := dst.Update(func(dstTx *bolt.Tx) error {
+					dstBucket, err := dstTx.CreateBucketIfNotExists(name, false)
+					if err != nil {
+						return err
+					}
+					if len(pairs) == 0 {
+						return nil
+					}
+					// The records are appended in the key order, so the split pages are not written into again
+					dstBucket.FillPercent = 1.0
+					return dstBucket.MultiPut(pairs...)
+				})
+				pairs, size = pairs[:0], 0
+				return err
+			}
+			c := b.Cursor()
+			for k, v := c.First(); k != nil; k, v = c.Next() {
+				pairs = append(pairs, common.CopyBytes(k), common.CopyBytes(v))
+				size += len(k) + len(v)
+				total++
+				if size >= batchSize {
+					if err := flush(); err != nil {
+						return err
+					}
+				}
+				select {
+				default:
+				case <-logEvery.C:
+					log.Info("Compacting", "bucket", string(name), "records", total)
+				}
+			}
+			if err := flush(); err != nil {
+				return err
+			}
+			log.Info("Bucket compacted", "bucket", string(name), "records", total)
+			return nil
+		})
+	}))
+}
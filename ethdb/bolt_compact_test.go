@@ -0,0 +1,67 @@
+package ethdb
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompactBolt(t *testing.T) {
+	dirname, err := ioutil.TempDir(os.TempDir(), "ethdb_compact_test_")
+	require.NoError(t, err)
+	defer os.RemoveAll(dirname)
+	from, to := path.Join(dirname, "from"), path.Join(dirname, "to")
+
+	db, err := NewBoltDatabase(from)
+	require.NoError(t, err)
+	value := make([]byte, 100)
+	for i := uint64(0); i < 10000; i++ {
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, i)
+		require.NoError(t, db.Put(dbutils.AccountChangeSetBucket, key, value))
+	}
+	// Deleted history leaves half-empty pages behind
+	for i := uint64(0); i < 10000; i += 2 {
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, i)
+		require.NoError(t, db.Delete(dbutils.AccountChangeSetBucket, key))
+	}
+	db.Close()
+
+	before, err := ReportBoltPages(from)
+	require.NoError(t, err)
+	require.NoError(t, CompactBolt(from, to, 64*1024))
+	after, err := ReportBoltPages(to)
+	require.NoError(t, err)
+
+	bucketReport := func(report *BoltFileReport) BoltBucketReport {
+		for _, b := range report.Buckets {
+			if string(b.Name) == string(dbutils.AccountChangeSetBucket) {
+				return b
+			}
+		}
+		t.Fatalf("bucket not found in the report")
+		return BoltBucketReport{}
+	}
+	assert.Equal(t, 5000, bucketReport(after).Keys)
+	assert.Equal(t, len(before.Buckets), len(after.Buckets))
+	assert.True(t, bucketReport(after).FillRatio() > bucketReport(before).FillRatio())
+	assert.True(t, after.FileSize <= before.FileSize)
+
+	assert.Error(t, CompactBolt(from, to, 64*1024), "existing destination must not be overwritten")
+
+	compacted, err := NewBoltDatabase(to)
+	require.NoError(t, err)
+	defer compacted.Close()
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, 1)
+	v, err := compacted.Get(dbutils.AccountChangeSetBucket, key)
+	require.NoError(t, err)
+	assert.Equal(t, value, v)
+}
@@ -20,14 +20,13 @@ package ethdb
 import (
 	"bytes"
 	"context"
-	"encoding/binary"
-	"fmt"
 	"os"
 	"path"
+	"sync/atomic"
+	"time"
 
 	"github.com/ledgerwatch/bolt"
 	"github.com/ledgerwatch/turbo-geth/common"
-	"github.com/ledgerwatch/turbo-geth/common/changeset"
 	"github.com/ledgerwatch/turbo-geth/common/dbutils"
 	"github.com/ledgerwatch/turbo-geth/core/types/accounts"
 	"github.com/ledgerwatch/turbo-geth/log"
@@ -37,6 +36,9 @@ var OpenFileLimit = 64
 
 const HeapSize = 512 * 1024 * 1024
 
+// readOnlyOpenTimeout is how long the read-only opening waits for the writer to release the database file
+const readOnlyOpenTimeout = 5 * time.Second
+
 // BoltDatabase is a wrapper over BoltDb,
 // compatible with the Database interface.
 type BoltDatabase struct {
@@ -44,11 +46,37 @@ type BoltDatabase struct {
 	log log.Logger // Contextual logger tracking the database path
 	id  uint64
 
+	noSync uint32 // 1 while the commits are not fsync-ed, see BoltOptions.NoSync
+
 	stopNetInterface context.CancelFunc
 	netAddr          string
+
+	checksums map[string]struct{} // Buckets, the checksums of which are maintained by the writes, see EnableBucketChecksums
 }
 
-// NewBoltDatabase returns a BoltDB wrapper.
+// BoltOptions are the tunable options of Bolt, the zero values keep the defaults of Bolt
+type BoltOptions struct {
+	// InitialMmapSize is the initial size of the memory map in bytes. Remapping, when the database outgrows the map,
+	// waits for all the read transactions to finish, so a large enough map keeps the readers from blocking the writer
+	InitialMmapSize int
+	// FreelistMap selects the hashmap freelist instead of the array one, it is faster on the large fragmented databases
+	FreelistMap bool
+	// NoSync skips fsync of the commits, for the bulk import of the chain ("turbo import" mode).
+	// A crash of the OS loses the recent commits and can corrupt the database, so the sync has to be turned on
+	// once the initial sync completes, see EnableSync
+	NoSync bool
+}
+
+func (o BoltOptions) apply(opts *bolt.Options) {
+	if o.InitialMmapSize > 0 {
+		opts.InitialMmapSize = o.InitialMmapSize
+	}
+	if o.FreelistMap {
+		opts.FreelistType = bolt.FreelistMapType
+	}
+}
+
+// NewWrapperBoltDatabase returns a BoltDB wrapper over the already opened database
 func NewWrapperBoltDatabase(db *bolt.DB) *BoltDatabase {
 	logger := log.New()
 	return &BoltDatabase{
@@ -60,47 +88,120 @@ func NewWrapperBoltDatabase(db *bolt.DB) *BoltDatabase {
 
 // NewBoltDatabase returns a BoltDB wrapper.
 func NewBoltDatabase(file string) (*BoltDatabase, error) {
+	return openBoltDatabase(file, false, BoltOptions{})
+}
+
+// NewBoltDatabaseWithOptions returns a BoltDB wrapper, opened with the given options
+func NewBoltDatabaseWithOptions(file string, options BoltOptions) (*BoltDatabase, error) {
+	return openBoltDatabase(file, false, options)
+}
+
+// OpenBoltDatabase returns a BoltDB wrapper. In the read-only mode the file is locked in the shared mode,
+// so it can be opened by several readers at once, but not while a writer (i.e. the node) holds it, in which case
+// opening fails after readOnlyOpenTimeout. The missing buckets are not created, and the writes fail with ErrTxReadOnly
+func OpenBoltDatabase(file string, readOnly bool) (*BoltDatabase, error) {
+	return openBoltDatabase(file, readOnly, BoltOptions{})
+}
+
+func openBoltDatabase(file string, readOnly bool, options BoltOptions) (*BoltDatabase, error) {
 	logger := log.New("database", file)
 
-	// Create necessary directories
-	if err := os.MkdirAll(path.Dir(file), os.ModePerm); err != nil {
+	opts := &bolt.Options{KeysPrefixCompressionDisable: true}
+	options.apply(opts)
+	if readOnly {
+		opts.ReadOnly = true
+		opts.Timeout = readOnlyOpenTimeout
+	} else if err := os.MkdirAll(path.Dir(file), os.ModePerm); err != nil { // Create necessary directories
 		return nil, err
 	}
 	// Open the db and recover any potential corruptions
-	db, errOpen := bolt.Open(file, 0600, &bolt.Options{KeysPrefixCompressionDisable: true})
+	db, errOpen := bolt.Open(file, 0600, opts)
 	// (Re)check for errors and abort if opening of the db failed
 	if errOpen != nil {
 		return nil, errOpen
 	}
 
-	if err := db.Update(func(tx *bolt.Tx) error {
-		for _, bucket := range dbutils.Buckets {
-			if _, err := tx.CreateBucketIfNotExists(bucket, false); err != nil {
-				return err
+	if !readOnly {
+		if err := db.Update(func(tx *bolt.Tx) error {
+			for _, bucket := range dbutils.Buckets {
+				if _, err := tx.CreateBucketIfNotExists(bucket, false); err != nil {
+					return err
+				}
 			}
+			return recordBoltBucketCodecs(tx)
+		}); err != nil {
+			return nil, err
 		}
-		return nil
-	}); err != nil {
-		return nil, err
 	}
 
-	return &BoltDatabase{
+	boltDb := &BoltDatabase{
 		db:  db,
 		log: logger,
 		id:  id(),
-	}, nil
+	}
+	if options.NoSync && !readOnly {
+		logger.Warn("Commits are not synced to disk until the initial sync completes")
+		db.NoSync = true
+		db.NoGrowSync = true
+		boltDb.noSync = 1
+	}
+	return boltDb, nil
+}
+
+// SetNoSync turns fsync of the commits off or on. Turning it on flushes the commits made without fsync
+func (db *BoltDatabase) SetNoSync(noSync bool) error {
+	var v uint32
+	if noSync {
+		v = 1
+	}
+	if atomic.LoadUint32(&db.noSync) == v {
+		return nil
+	}
+	// In the write transaction, so that no commit is running while the flags change
+	if err := db.db.Update(func(tx *bolt.Tx) error {
+		db.db.NoSync = noSync
+		db.db.NoGrowSync = noSync
+		return nil
+	}); err != nil {
+		return err
+	}
+	atomic.StoreUint32(&db.noSync, v)
+	if noSync {
+		return nil
+	}
+	db.log.Info("Commits are synced to disk again")
+	return db.db.Sync()
+}
+
+// EnableSync turns fsync of the commits back on for the databases opened with BoltOptions.NoSync,
+// it is a no-op for the other databases
+func EnableSync(db Database) error {
+	if s, ok := db.(interface{ SetNoSync(bool) error }); ok {
+		return s.SetNoSync(false)
+	}
+	return nil
 }
 
 // Put inserts or updates a single entry.
 func (db *BoltDatabase) Put(bucket, key []byte, value []byte) error {
 	err := db.db.Update(func(tx *bolt.Tx) error {
-		b, err := tx.CreateBucketIfNotExists(bucket, false)
+		b, err := tx.CreateBucketIfNotExists(stateBucket(tx, bucket, key), false)
 		if err != nil {
 			return err
 		}
+		codec, err := bucketCodec(tx, bucket)
+		if err != nil {
+			return err
+		}
+		if value, err = encodeValue(codec, value); err != nil {
+			return err
+		}
+		if err = db.updateChecksums(tx, bucket, b, [][]byte{key, value}); err != nil {
+			return err
+		}
 		return b.Put(key, value)
 	})
-	return err
+	return boltErr(err)
 }
 
 func (db *BoltDatabase) MultiPut(tuples ...[]byte) (uint64, error) {
@@ -110,18 +211,33 @@ func (db *BoltDatabase) MultiPut(tuples ...[]byte) (uint64, error) {
 			bucketEnd := bucketStart
 			for ; bucketEnd < len(tuples) && bytes.Equal(tuples[bucketEnd], tuples[bucketStart]); bucketEnd += 3 {
 			}
-			b, err := tx.CreateBucketIfNotExists(tuples[bucketStart], false)
+			l := (bucketEnd - bucketStart) / 3
+			codec, err := bucketCodec(tx, tuples[bucketStart])
 			if err != nil {
 				return err
 			}
-			l := (bucketEnd - bucketStart) / 3
 			pairs := make([][]byte, 2*l)
 			for i := 0; i < l; i++ {
 				pairs[2*i] = tuples[bucketStart+3*i+1]
-				pairs[2*i+1] = tuples[bucketStart+3*i+2]
+				if pairs[2*i+1], err = encodeValue(codec, tuples[bucketStart+3*i+2]); err != nil {
+					return err
+				}
 			}
-			if err := b.MultiPut(pairs...); err != nil {
-				return err
+			if bytes.Equal(tuples[bucketStart], dbutils.CurrentStateBucket) && IsSplitState(tx) {
+				if err := multiPutStateShards(tx, pairs); err != nil {
+					return err
+				}
+			} else {
+				b, err := tx.CreateBucketIfNotExists(tuples[bucketStart], false)
+				if err != nil {
+					return err
+				}
+				if err := db.updateChecksums(tx, tuples[bucketStart], b, pairs); err != nil {
+					return err
+				}
+				if err := b.MultiPut(pairs...); err != nil {
+					return err
+				}
 			}
 			bucketStart = bucketEnd
 		}
@@ -129,7 +245,7 @@ func (db *BoltDatabase) MultiPut(tuples ...[]byte) (uint64, error) {
 		return nil
 	})
 	if err != nil {
-		return 0, err
+		return 0, boltErr(err)
 	}
 	return uint64(savedTx.Stats().Write), nil
 }
@@ -160,7 +276,7 @@ func (t MultiPutTuples) Swap(i, j int) {
 func (db *BoltDatabase) Has(bucket, key []byte) (bool, error) {
 	var has bool
 	err := db.db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket(bucket)
+		b := tx.Bucket(stateBucket(tx, bucket, key))
 		if b == nil {
 			has = false
 		} else {
@@ -169,7 +285,7 @@ func (db *BoltDatabase) Has(bucket, key []byte) (bool, error) {
 		}
 		return nil
 	})
-	return has, err
+	return has, boltErr(err)
 }
 
 func (db *BoltDatabase) DiskSize() int64 {
@@ -181,20 +297,31 @@ func (db *BoltDatabase) Get(bucket, key []byte) ([]byte, error) {
 	// Retrieve the key and increment the miss counter if not found
 	var dat []byte
 	err := db.db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket(bucket)
+		b := tx.Bucket(stateBucket(tx, bucket, key))
 		if b != nil {
 			v, _ := b.Get(key)
 			if v != nil {
+				codec, err := bucketCodec(tx, bucket)
+				if err != nil {
+					return err
+				}
+				if codec != nil {
+					dat, err = decodeValue(codec, v)
+					return err
+				}
 				dat = make([]byte, len(v))
 				copy(dat, v)
 			}
 		}
 		return nil
 	})
+	if err != nil {
+		return nil, boltErr(err)
+	}
 	if dat == nil {
 		return nil, ErrKeyNotFound
 	}
-	return dat, err
+	return dat, nil
 }
 
 // GetIndexChunk returns proper index chunk or return error if index is not created.
@@ -214,10 +341,13 @@ func (db *BoltDatabase) GetIndexChunk(bucket, key []byte, timestamp uint64) ([]b
 		}
 		return nil
 	})
+	if err != nil {
+		return nil, boltErr(err)
+	}
 	if dat == nil {
 		return nil, ErrKeyNotFound
 	}
-	return dat, err
+	return dat, nil
 }
 
 // getChangeSetByBlockNoLock returns changeset by block and bucket
@@ -226,12 +356,18 @@ func (db *BoltDatabase) GetChangeSetByBlock(hBucket []byte, timestamp uint64) ([
 
 	var dat []byte
 	err := db.db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket(dbutils.ChangeSetByIndexBucket(hBucket))
-		if b == nil {
-			return nil
+		get := boltHistoryReader{historyTx: tx}.historyGet
+		csBucket := dbutils.ChangeSetByIndexBucket(hBucket)
+		v, err := get(csBucket, key)
+		if err != nil {
+			return err
+		}
+		if v == nil {
+			// The changesets of the old blocks might have been compacted into epochs
+			if v, err = changeSetFromEpoch(get, csBucket, timestamp); err != nil {
+				return err
+			}
 		}
-
-		v, _ := b.Get(key)
 		if v != nil {
 			dat = make([]byte, len(v))
 			copy(dat, v)
@@ -239,7 +375,7 @@ func (db *BoltDatabase) GetChangeSetByBlock(hBucket []byte, timestamp uint64) ([
 		return nil
 	})
 	if err != nil {
-		return nil, err
+		return nil, boltErr(err)
 	}
 	return dat, nil
 }
@@ -248,26 +384,17 @@ func (db *BoltDatabase) GetChangeSetByBlock(hBucket []byte, timestamp uint64) ([
 func (db *BoltDatabase) GetAsOf(bucket, hBucket, key []byte, timestamp uint64) ([]byte, error) {
 	var dat []byte
 	err := db.db.View(func(tx *bolt.Tx) error {
-		v, err := BoltDBFindByHistory(tx, hBucket, key, timestamp)
-		if err != nil {
-			log.Debug("BoltDB BoltDBFindByHistory err", "err", err)
-		} else {
-			dat = make([]byte, len(v))
-			copy(dat, v)
-			return nil
-		}
-		{
-			v, _ := tx.Bucket(bucket).Get(key)
-			if v == nil {
-				return ErrKeyNotFound
-			}
-
-			dat = make([]byte, len(v))
-			copy(dat, v)
-			return nil
-		}
+		var err error
+		dat, err = getAsOf(tx, tx, bucket, hBucket, key, timestamp)
+		return err
 	})
-	return dat, err
+	return dat, boltErr(err)
+}
+
+// getAsOf looks up the value in the history first, then in the current state.
+// State and history buckets may live in different databases, see SplitDatabase
+func getAsOf(stateTx, historyTx *bolt.Tx, bucket, hBucket, key []byte, timestamp uint64) ([]byte, error) {
+	return getAsOfWith(boltHistoryReader{stateTx: stateTx, historyTx: historyTx}, bucket, hBucket, key, timestamp)
 }
 
 func HackAddRootToAccountBytes(accNoRoot []byte, root []byte) (accWithRoot []byte, err error) {
@@ -294,11 +421,10 @@ func Bytesmask(fixedbits int) (fixedbytes int, mask byte) {
 func (db *BoltDatabase) Walk(bucket, startkey []byte, fixedbits int, walker func(k, v []byte) (bool, error)) error {
 	fixedbytes, mask := Bytesmask(fixedbits)
 	err := db.db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket(bucket)
-		if b == nil {
-			return nil
+		c, err := bucketCursor(tx, bucket)
+		if c == nil || err != nil {
+			return err
 		}
-		c := b.Cursor()
 		k, v := c.Seek(startkey)
 		for k != nil && len(k) >= fixedbytes && (fixedbits == 0 || bytes.Equal(k[:fixedbytes-1], startkey[:fixedbytes-1]) && (k[fixedbytes-1]&mask) == (startkey[fixedbytes-1]&mask)) {
 			goOn, err := walker(k, v)
@@ -310,7 +436,7 @@ func (db *BoltDatabase) Walk(bucket, startkey []byte, fixedbits int, walker func
 			}
 			k, v = c.Next()
 		}
-		return nil
+		return cursorErr(c)
 	})
 	return err
 }
@@ -321,11 +447,10 @@ func (db *BoltDatabase) MultiWalk(bucket []byte, startkeys [][]byte, fixedbits [
 	fixedbytes, mask := Bytesmask(fixedbits[rangeIdx])
 	startkey := startkeys[rangeIdx]
 	err := db.db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket(bucket)
-		if b == nil {
-			return nil
+		c, err := bucketCursor(tx, bucket)
+		if c == nil || err != nil {
+			return err
 		}
-		c := b.Cursor()
 		k, v := c.Seek(startkey)
 		for k != nil {
 			// Adjust rangeIdx if needed
@@ -345,7 +470,7 @@ func (db *BoltDatabase) MultiWalk(bucket []byte, startkeys [][]byte, fixedbits [
 					if cmp < 0 {
 						k, v = c.SeekTo(startkey)
 						if k == nil {
-							return nil
+							return cursorErr(c)
 						}
 					} else if cmp > 0 {
 						rangeIdx++
@@ -364,304 +489,50 @@ func (db *BoltDatabase) MultiWalk(bucket []byte, startkeys [][]byte, fixedbits [
 			}
 			k, v = c.Next()
 		}
-		return nil
+		return cursorErr(c)
 	})
 	return err
 }
 
-func (db *BoltDatabase) walkAsOfThinAccounts(startkey []byte, fixedbits int, timestamp uint64, walker func(k []byte, v []byte) (bool, error)) error {
-	fixedbytes, mask := Bytesmask(fixedbits)
-	err := db.db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket(dbutils.CurrentStateBucket)
-		if b == nil {
-			return fmt.Errorf("currentStateBucket not found")
-		}
-		hB := tx.Bucket(dbutils.AccountsHistoryBucket)
-		if hB == nil {
-			return fmt.Errorf("accountsHistoryBucket not found")
-		}
-		csB := tx.Bucket(dbutils.AccountChangeSetBucket)
-		if csB == nil {
-			return fmt.Errorf("accountChangeBucket not found")
-		}
-		//for state
-		mainCursor := b.Cursor()
-		//for historic data
-		historyCursor := newSplitCursor(
-			hB,
-			startkey,
-			fixedbits,
-			common.HashLength,   /* part1end */
-			common.HashLength,   /* part2start */
-			common.HashLength+8, /* part3start */
-		)
-		k, v := mainCursor.Seek(startkey)
-		for k != nil && len(k) > common.HashLength {
-			k, v = mainCursor.Next()
-		}
-		hK, tsEnc, _, hV := historyCursor.Seek()
-		for hK != nil && binary.BigEndian.Uint64(tsEnc) < timestamp {
-			hK, tsEnc, _, hV = historyCursor.Next()
-		}
-		goOn := true
-		var err error
-		for goOn {
-			//exit or next conditions
-			if k != nil && fixedbits > 0 && !bytes.Equal(k[:fixedbytes-1], startkey[:fixedbytes-1]) {
-				k = nil
-			}
-			if k != nil && fixedbits > 0 && (k[fixedbytes-1]&mask) != (startkey[fixedbytes-1]&mask) {
-				k = nil
-			}
-			var cmp int
-			if k == nil {
-				if hK == nil {
-					break
-				} else {
-					cmp = 1
-				}
-			} else if hK == nil {
-				cmp = -1
-			} else {
-				cmp = bytes.Compare(k, hK)
-			}
-			if cmp < 0 {
-				goOn, err = walker(k, v)
-			} else {
-				index := dbutils.WrapHistoryIndex(hV)
-				if changeSetBlock, set, ok := index.Search(timestamp); ok {
-					// set == true if this change was from empty record (non-existent account) to non-empty
-					// In such case, we do not need to examine changeSet and simply skip the record
-					if !set {
-						// Extract value from the changeSet
-						csKey := dbutils.EncodeTimestamp(changeSetBlock)
-						changeSetData, _ := csB.Get(csKey)
-						if changeSetData == nil {
-							return fmt.Errorf("could not find ChangeSet record for index entry %d (query timestamp %d)", changeSetBlock, timestamp)
-						}
-						data, err1 := changeset.AccountChangeSetBytes(changeSetData).FindLast(hK)
-						if err1 != nil {
-							return fmt.Errorf("could not find key %x in the ChangeSet record for index entry %d (query timestamp %d)",
-								hK,
-								changeSetBlock,
-								timestamp,
-							)
-						}
-						if len(data) > 0 { // Skip accounts did not exist
-							goOn, err = walker(hK, data)
-						}
-					}
-				} else if cmp == 0 {
-					goOn, err = walker(k, v)
-				}
-			}
-			if goOn {
-				if cmp <= 0 {
-					k, v = mainCursor.Next()
-					for k != nil && len(k) > common.HashLength {
-						k, v = mainCursor.Next()
-					}
-				}
-				if cmp >= 0 {
-					hK0 := hK
-					for hK != nil && (bytes.Equal(hK0, hK) || binary.BigEndian.Uint64(tsEnc) < timestamp) {
-						hK, tsEnc, _, hV = historyCursor.Next()
-					}
-				}
-			}
-		}
-		return err
-	})
-	return err
-}
-
-// splitCursor implements cursor with two keys
-// it is used to ignore incarnations in the middle
-// of composite storage key, but without
-// reconstructing the key
-// Instead, the key is split into two parts and
-// functions `Seek` and `Next` deliver both
-// parts as well as the corresponding value
-type splitCursor struct {
-	c          *bolt.Cursor // Unlerlying bolt cursor
-	startkey   []byte       // Starting key (also contains bits that need to be preserved)
-	matchBytes int
-	mask       uint8
-	part1end   int // Position in the key where the first part ends
-	part2start int // Position in the key where the second part starts
-	part3start int // Position in the key where the third part starts
-}
-
-func newSplitCursor(b *bolt.Bucket, startkey []byte, matchBits int, part1end, part2start, part3start int) *splitCursor {
-	var sc splitCursor
-	sc.c = b.Cursor()
-	sc.startkey = startkey
-	sc.part1end = part1end
-	sc.part2start = part2start
-	sc.part3start = part3start
-	sc.matchBytes, sc.mask = Bytesmask(matchBits)
-	return &sc
-}
-
-func (sc *splitCursor) matchKey(k []byte) bool {
+// matchFixedBits tells whether the key has the same fixed bits as the start key, see Bytesmask
+func matchFixedBits(k, startkey []byte, matchBytes int, mask byte) bool {
 	if k == nil {
 		return false
 	}
-	if sc.matchBytes == 0 {
+	if matchBytes == 0 {
 		return true
 	}
-	if len(k) < sc.matchBytes {
+	if len(k) < matchBytes {
 		return false
 	}
-	if !bytes.Equal(k[:sc.matchBytes-1], sc.startkey[:sc.matchBytes-1]) {
+	if !bytes.Equal(k[:matchBytes-1], startkey[:matchBytes-1]) {
 		return false
 	}
-	return (k[sc.matchBytes-1] & sc.mask) == (sc.startkey[sc.matchBytes-1] & sc.mask)
-}
-
-func (sc *splitCursor) Seek() (key1, key2, key3, val []byte) {
-	k, v := sc.c.Seek(sc.startkey)
-	if !sc.matchKey(k) {
-		return nil, nil, nil, nil
-	}
-	return k[:sc.part1end], k[sc.part2start:sc.part3start], k[sc.part3start:], v
-}
-
-func (sc *splitCursor) Next() (key1, key2, key3, val []byte) {
-	k, v := sc.c.Next()
-	if !sc.matchKey(k) {
-		return nil, nil, nil, nil
-	}
-	return k[:sc.part1end], k[sc.part2start:sc.part3start], k[sc.part3start:], v
-}
-
-func (db *BoltDatabase) walkAsOfThinStorage(startkey []byte, fixedbits int, timestamp uint64, walker func(k1, k2, v []byte) (bool, error)) error {
-	err := db.db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket(dbutils.CurrentStateBucket)
-		if b == nil {
-			return fmt.Errorf("storageBucket not found")
-		}
-		hB := tx.Bucket(dbutils.StorageHistoryBucket)
-		if hB == nil {
-			return fmt.Errorf("storageHistoryBucket not found")
-		}
-		csB := tx.Bucket(dbutils.StorageChangeSetBucket)
-		if csB == nil {
-			return fmt.Errorf("storageChangeBucket not found")
-		}
-		startkeyNoInc := make([]byte, len(startkey)-common.IncarnationLength)
-		copy(startkeyNoInc, startkey[:common.HashLength])
-		copy(startkeyNoInc[common.HashLength:], startkey[common.HashLength+common.IncarnationLength:])
-		//for storage
-		mainCursor := newSplitCursor(
-			b,
-			startkey,
-			fixedbits,
-			common.HashLength, /* part1end */
-			common.HashLength+common.IncarnationLength,                   /* part2start */
-			common.HashLength+common.IncarnationLength+common.HashLength, /* part3start */
-		)
-		//for historic data
-		historyCursor := newSplitCursor(
-			hB,
-			startkeyNoInc,
-			fixedbits-8*common.IncarnationLength,
-			common.HashLength,   /* part1end */
-			common.HashLength,   /* part2start */
-			common.HashLength*2, /* part3start */
-		)
-		addrHash, keyHash, _, v := mainCursor.Seek()
-		hAddrHash, hKeyHash, tsEnc, hV := historyCursor.Seek()
-		for hKeyHash != nil && binary.BigEndian.Uint64(tsEnc) < timestamp {
-			hAddrHash, hKeyHash, tsEnc, hV = historyCursor.Next()
-		}
-		goOn := true
-		var err error
-		for goOn {
-			var cmp int
-			if keyHash == nil {
-				if hKeyHash == nil {
-					break
-				} else {
-					cmp = 1
-				}
-			} else if hKeyHash == nil {
-				cmp = -1
-			} else {
-				cmp = bytes.Compare(keyHash, hKeyHash)
-			}
-			if cmp < 0 {
-				goOn, err = walker(addrHash, keyHash, v)
-			} else {
-				index := dbutils.WrapHistoryIndex(hV)
-				if changeSetBlock, set, ok := index.Search(timestamp); ok {
-					// set == true if this change was from empty record (non-existent storage item) to non-empty
-					// In such case, we do not need to examine changeSet and simply skip the record
-					if !set {
-						// Extract value from the changeSet
-						csKey := dbutils.EncodeTimestamp(changeSetBlock)
-						changeSetData, _ := csB.Get(csKey)
-						if changeSetData == nil {
-							return fmt.Errorf("could not find ChangeSet record for index entry %d (query timestamp %d)", changeSetBlock, timestamp)
-						}
-						data, err1 := changeset.StorageChangeSetBytes(changeSetData).FindWithoutIncarnation(hAddrHash, hKeyHash)
-						if err1 != nil {
-							return fmt.Errorf("could not find key %x%x in the ChangeSet record for index entry %d (query timestamp %d): %v",
-								hAddrHash, hKeyHash,
-								changeSetBlock,
-								timestamp,
-								err1,
-							)
-						}
-						if len(data) > 0 { // Skip deleted entries
-							goOn, err = walker(hAddrHash, hKeyHash, data)
-						}
-					}
-				} else if cmp == 0 {
-					goOn, err = walker(addrHash, keyHash, v)
-				}
-			}
-			if goOn {
-				if cmp <= 0 {
-					addrHash, keyHash, _, v = mainCursor.Next()
-				}
-				if cmp >= 0 {
-					hKeyHash0 := hKeyHash
-					for hKeyHash != nil && (bytes.Equal(hKeyHash0, hKeyHash) || binary.BigEndian.Uint64(tsEnc) < timestamp) {
-						hAddrHash, hKeyHash, tsEnc, hV = historyCursor.Next()
-					}
-				}
-			}
-		}
-		return err
-	})
-	return err
+	return (k[matchBytes-1] & mask) == (startkey[matchBytes-1] & mask)
 }
 
+// WalkAsOf walks over the state as of the given timestamp, see WalkAsOfTx
 func (db *BoltDatabase) WalkAsOf(bucket, hBucket, startkey []byte, fixedbits int, timestamp uint64, walker func(k []byte, v []byte) (bool, error)) error {
-	//fmt.Printf("WalkAsOf %x %x %x %d %d\n", bucket, hBucket, startkey, fixedbits, timestamp)
-	if bytes.Equal(bucket, dbutils.CurrentStateBucket) && bytes.Equal(hBucket, dbutils.AccountsHistoryBucket) {
-		return db.walkAsOfThinAccounts(startkey, fixedbits, timestamp, walker)
-	} else if bytes.Equal(bucket, dbutils.CurrentStateBucket) && bytes.Equal(hBucket, dbutils.StorageHistoryBucket) {
-		return db.walkAsOfThinStorage(startkey, fixedbits, timestamp, func(k1, k2, v []byte) (bool, error) {
-			return walker(append(common.CopyBytes(k1), k2...), v)
-		})
-	}
-	panic("Not implemented for arbitrary buckets")
+	return db.AbstractKV().View(context.Background(), func(tx Tx) error {
+		return WalkAsOfTx(tx, bucket, hBucket, startkey, fixedbits, timestamp, walker)
+	})
 }
 
 // Delete deletes the key from the queue and database
 func (db *BoltDatabase) Delete(bucket, key []byte) error {
 	// Execute the actual operation
 	err := db.db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket(bucket)
+		b := tx.Bucket(stateBucket(tx, bucket, key))
 		if b != nil {
+			if err := db.updateChecksums(tx, bucket, b, [][]byte{key, nil}); err != nil {
+				return err
+			}
 			return b.Delete(key)
 		} else {
 			return nil
 		}
 	})
-	return err
+	return boltErr(err)
 }
 
 func (db *BoltDatabase) DeleteBucket(bucket []byte) error {
@@ -669,12 +540,14 @@ func (db *BoltDatabase) DeleteBucket(bucket []byte) error {
 		if err := tx.DeleteBucket(bucket); err != nil {
 			return err
 		}
-		return nil
+		return deleteChecksums(tx, bucket)
 	})
-	return err
+	return boltErr(err)
 }
 
 func (db *BoltDatabase) Close() {
+	forgetBucketCodecs(db.db)
+	forgetSplitState(db.db)
 	if err := db.db.Close(); err == nil {
 		db.log.Info("Database closed")
 	} else {
@@ -682,10 +555,14 @@ func (db *BoltDatabase) Close() {
 	}
 }
 
+// Keys returns the pairs of the bucket and the key of all the data, except the codecs recorded by the database itself
 func (db *BoltDatabase) Keys() ([][]byte, error) {
 	var keys [][]byte
 	err := db.db.View(func(tx *bolt.Tx) error {
 		return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			if bytes.Equal(name, dbutils.BucketCodecsBucket) {
+				return nil
+			}
 			var nameCopy = make([]byte, len(name))
 			copy(nameCopy, name)
 			return b.ForEach(func(k, _ []byte) error {
@@ -697,9 +574,9 @@ func (db *BoltDatabase) Keys() ([][]byte, error) {
 		})
 	})
 	if err != nil {
-		return nil, err
+		return nil, boltErr(err)
 	}
-	return keys, err
+	return keys, nil
 }
 
 func (db *BoltDatabase) KV() *bolt.DB {
@@ -707,7 +584,7 @@ func (db *BoltDatabase) KV() *bolt.DB {
 }
 
 func (db *BoltDatabase) AbstractKV() KV {
-	return &BoltKV{bolt: db.db}
+	return withCodecs(&BoltKV{bolt: db.db})
 }
 
 func (db *BoltDatabase) NewBatch() DbWithPendingMutations {
@@ -750,75 +627,9 @@ func NewDatabaseWithFreezer(db Database, dir, suffix string) (Database, error) {
 }
 
 func BoltDBFindByHistory(tx *bolt.Tx, hBucket []byte, key []byte, timestamp uint64) ([]byte, error) {
-	//check
-	hB := tx.Bucket(hBucket)
-	if hB == nil {
-		return nil, ErrKeyNotFound
-	}
-	var keyF []byte
-	if bytes.Equal(dbutils.StorageHistoryBucket, hBucket) {
-		keyF = make([]byte, len(key)-common.IncarnationLength)
-		copy(keyF, key[:common.HashLength])
-		copy(keyF[common.HashLength:], key[common.HashLength+common.IncarnationLength:])
-	} else {
-		keyF = common.CopyBytes(key)
-	}
-
-	c := hB.Cursor()
-	k, v := c.Seek(dbutils.IndexChunkKey(key, timestamp))
-	if !bytes.HasPrefix(k, keyF) {
-		return nil, ErrKeyNotFound
-	}
-	index := dbutils.WrapHistoryIndex(v)
-
-	changeSetBlock, set, ok := index.Search(timestamp)
-	if !ok {
-		return nil, ErrKeyNotFound
-	}
-	// set == true if this change was from empty record (non-existent account) to non-empty
-	// In such case, we do not need to examine changeSet and return empty data
-	if set {
-		return []byte{}, nil
-	}
-	csB := tx.Bucket(dbutils.ChangeSetByIndexBucket(hBucket))
-	if csB == nil {
-		return nil, ErrKeyNotFound
-	}
-
-	csKey := dbutils.EncodeTimestamp(changeSetBlock)
-	changeSetData, _ := csB.Get(csKey)
-
-	var (
-		data []byte
-		err  error
-	)
-	switch {
-	case bytes.Equal(dbutils.AccountsHistoryBucket, hBucket):
-		data, err = changeset.AccountChangeSetBytes(changeSetData).FindLast(key)
-	case bytes.Equal(dbutils.StorageHistoryBucket, hBucket):
-		data, err = changeset.StorageChangeSetBytes(changeSetData).FindWithoutIncarnation(key[:common.HashLength], key[common.HashLength+common.IncarnationLength:])
-	}
-	if err != nil {
-		return nil, ErrKeyNotFound
-	}
-
-	//restore codehash
-	if bytes.Equal(dbutils.AccountsHistoryBucket, hBucket) {
-		var acc accounts.Account
-		if err := acc.DecodeForStorage(data); err != nil {
-			return nil, err
-		}
-		if acc.Incarnation > 0 && acc.IsEmptyCodeHash() {
-			codeBucket := tx.Bucket(dbutils.ContractCodeBucket)
-			codeHash, _ := codeBucket.Get(dbutils.GenerateStoragePrefix(key, acc.Incarnation))
-			if len(codeHash) > 0 {
-				acc.CodeHash = common.BytesToHash(codeHash)
-			}
-			data = make([]byte, acc.EncodingLengthForStorage())
-			acc.EncodeForStorage(data)
-		}
-		return data, nil
-	}
+	return findByHistory(tx, tx, hBucket, key, timestamp)
+}
 
-	return data, nil
+func findByHistory(stateTx, historyTx *bolt.Tx, hBucket []byte, key []byte, timestamp uint64) ([]byte, error) {
+	return findByHistoryWith(boltHistoryReader{stateTx: stateTx, historyTx: historyTx}, hBucket, key, timestamp)
 }
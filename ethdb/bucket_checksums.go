@@ -0,0 +1,340 @@
+package ethdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/holiman/uint256"
+	"github.com/ledgerwatch/bolt"
+	"golang.org/x/crypto/sha3"
+
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+	"github.com/ledgerwatch/turbo-geth/log"
+	"github.com/ledgerwatch/turbo-geth/metrics"
+)
+
+var (
+	scrubRangesMeter     = metrics.NewRegisteredMeter("db/scrub/ranges", nil)
+	scrubMismatchCounter = metrics.NewRegisteredCounter("db/scrub/mismatch", nil)
+)
+
+// DefaultChecksumBuckets are the buckets of the chain data, the checksums of which are maintained by default
+var DefaultChecksumBuckets = [][]byte{
+	dbutils.HeaderPrefix,
+	dbutils.BlockBodyPrefix,
+	dbutils.BlockReceiptsPrefix,
+	dbutils.AccountChangeSetBucket,
+	dbutils.StorageChangeSetBucket,
+}
+
+// RangeChecksum is the checksum of the records of a bucket, keys of which start with the same byte (the empty key
+// belongs to the range 0). It is the sum of keccak256 of the records modulo 2^256, so every write updates it
+// without re-reading the rest of the range. The values are taken as they are stored, i.e. encoded by the bucket codec
+type RangeChecksum struct {
+	Sum   uint256.Int
+	Count uint64
+}
+
+func (c RangeChecksum) String() string {
+	return fmt.Sprintf("%x (%d records)", c.Sum.Bytes32(), c.Count)
+}
+
+func checksumRange(k []byte) byte {
+	if len(k) == 0 {
+		return 0
+	}
+	return k[0]
+}
+
+func checksumKey(bucket []byte, r byte) []byte {
+	return append(bucketStatsPrefix(bucket), r)
+}
+
+func recordHash(k, v []byte) *uint256.Int {
+	h := sha3.NewLegacyKeccak256()
+	var l [4]byte
+	binary.BigEndian.PutUint32(l[:], uint32(len(k)))
+	h.Write(l[:])
+	h.Write(k)
+	h.Write(v)
+	return new(uint256.Int).SetBytes(h.Sum(nil))
+}
+
+func (c *RangeChecksum) add(k, v []byte) {
+	c.Sum.Add(&c.Sum, recordHash(k, v))
+	c.Count++
+}
+
+func (c *RangeChecksum) remove(k, v []byte) {
+	c.Sum.Sub(&c.Sum, recordHash(k, v))
+	c.Count--
+}
+
+func (c *RangeChecksum) encode() []byte {
+	v := make([]byte, 40)
+	sum := c.Sum.Bytes32()
+	copy(v, sum[:])
+	binary.BigEndian.PutUint64(v[32:], c.Count)
+	return v
+}
+
+func decodeRangeChecksum(v []byte) (*RangeChecksum, error) {
+	if len(v) != 40 {
+		return nil, fmt.Errorf("invalid range checksum length %d", len(v))
+	}
+	c := &RangeChecksum{Count: binary.BigEndian.Uint64(v[32:])}
+	c.Sum.SetBytes(v[:32])
+	return c, nil
+}
+
+// ChecksumMismatch is the key range, the records of which do not match the checksum maintained by the writes
+type ChecksumMismatch struct {
+	Bucket   []byte
+	Range    byte
+	Expected RangeChecksum // Maintained by the writes
+	Actual   RangeChecksum // Recomputed from the records
+}
+
+func (m *ChecksumMismatch) String() string {
+	return fmt.Sprintf("bucket %s, range %02x: expected %s, got %s", m.Bucket, m.Range, m.Expected, m.Actual)
+}
+
+// EnableBucketChecksums makes the writes through the Database methods of db (but not the writes in the KV transactions)
+// maintain the checksums of the key ranges of the buckets. A range gets its checksum when it is scrubbed for the first
+// time (see ScrubRange), the writes only update the existing checksums. It has to be called before the writes.
+// The state bucket is not supported, because it may be split into the shards
+func (db *BoltDatabase) EnableBucketChecksums(buckets ...[]byte) {
+	db.checksums = make(map[string]struct{}, len(buckets))
+	for _, bucket := range buckets {
+		if bytes.Equal(bucket, dbutils.CurrentStateBucket) {
+			db.log.Warn("Checksums of the state bucket are not supported")
+			continue
+		}
+		db.checksums[string(bucket)] = struct{}{}
+	}
+}
+
+// updateChecksums updates the checksums of the ranges, to which the keys of the pairs (key, value as stored,
+// nil for the deletion) belong. It has to be called before the pairs are written into b, which is nil
+// if the bucket does not exist yet
+func (db *BoltDatabase) updateChecksums(tx *bolt.Tx, bucket []byte, b *bolt.Bucket, pairs [][]byte) error {
+	if _, ok := db.checksums[string(bucket)]; !ok {
+		return nil
+	}
+	cb := tx.Bucket(dbutils.BucketChecksumsBucket)
+	if cb == nil {
+		return nil
+	}
+	ranges := make(map[byte]*RangeChecksum)
+	for i := 0; i < len(pairs); i += 2 {
+		k, v := pairs[i], pairs[i+1]
+		r := checksumRange(k)
+		c, ok := ranges[r]
+		if !ok {
+			enc, _ := cb.Get(checksumKey(bucket, r))
+			if enc != nil {
+				var err error
+				if c, err = decodeRangeChecksum(enc); err != nil {
+					return fmt.Errorf("bucket %s, range %02x: %w", bucket, r, err)
+				}
+			}
+			ranges[r] = c
+		}
+		if c == nil {
+			// The range has not been scrubbed yet
+			continue
+		}
+		if b != nil {
+			if old, _ := b.Get(k); old != nil {
+				c.remove(k, old)
+			}
+		}
+		if v != nil {
+			c.add(k, v)
+		}
+	}
+	for r, c := range ranges {
+		if c == nil {
+			continue
+		}
+		if err := cb.Put(checksumKey(bucket, r), c.encode()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteChecksums deletes the checksums of the deleted bucket
+func deleteChecksums(tx *bolt.Tx, bucket []byte) error {
+	cb := tx.Bucket(dbutils.BucketChecksumsBucket)
+	if cb == nil {
+		return nil
+	}
+	for r := 0; r < 256; r++ {
+		if err := cb.Delete(checksumKey(bucket, byte(r))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func computeRangeChecksum(tx *bolt.Tx, bucket []byte, r byte) (*RangeChecksum, error) {
+	c := &RangeChecksum{}
+	b := tx.Bucket(bucket)
+	if b == nil {
+		return c, nil
+	}
+	cursor := b.Cursor()
+	var k, v []byte
+	if r == 0 {
+		k, v = cursor.First()
+	} else {
+		k, v = cursor.Seek([]byte{r})
+	}
+	for ; k != nil && checksumRange(k) == r; k, v = cursor.Next() {
+		c.add(k, v)
+	}
+	return c, nil
+}
+
+// ScrubRange recomputes the checksum of the key range of the bucket and compares it with the one maintained
+// by the writes, returning the mismatch or nil. The range, which has no checksum yet, gets the recomputed one
+func (db *BoltDatabase) ScrubRange(bucket []byte, r byte) (*ChecksumMismatch, error) {
+	var mismatch *ChecksumMismatch
+	recorded := false
+	// The checksum and the records are read in the same transaction, in which the writes leave them consistent
+	if err := db.db.View(func(tx *bolt.Tx) error {
+		cb := tx.Bucket(dbutils.BucketChecksumsBucket)
+		if cb == nil {
+			return nil
+		}
+		enc, _ := cb.Get(checksumKey(bucket, r))
+		if enc == nil {
+			return nil
+		}
+		recorded = true
+		expected, err := decodeRangeChecksum(enc)
+		if err != nil {
+			return err
+		}
+		actual, err := computeRangeChecksum(tx, bucket, r)
+		if err != nil {
+			return err
+		}
+		if *actual != *expected {
+			mismatch = &ChecksumMismatch{Bucket: bucket, Range: r, Expected: *expected, Actual: *actual}
+		}
+		return nil
+	}); err != nil {
+		return nil, boltErr(err)
+	}
+	if recorded {
+		return mismatch, nil
+	}
+	return nil, db.RecordRangeChecksum(bucket, r)
+}
+
+// RecordRangeChecksum recomputes the checksum of the key range of the bucket and stores it, replacing the one
+// maintained by the writes, i.e. after the mismatch has been investigated
+func (db *BoltDatabase) RecordRangeChecksum(bucket []byte, r byte) error {
+	return boltErr(db.db.Update(func(tx *bolt.Tx) error {
+		c, err := computeRangeChecksum(tx, bucket, r)
+		if err != nil {
+			return err
+		}
+		cb, err := tx.CreateBucketIfNotExists(dbutils.BucketChecksumsBucket, false)
+		if err != nil {
+			return err
+		}
+		return cb.Put(checksumKey(bucket, r), c.encode())
+	}))
+}
+
+// ScrubBuckets scrubs all the key ranges of the buckets (see ScrubRange), calling onMismatch for every mismatch
+func (db *BoltDatabase) ScrubBuckets(buckets [][]byte, onMismatch func(*ChecksumMismatch) error) error {
+	for _, bucket := range buckets {
+		for r := 0; r < 256; r++ {
+			mismatch, err := db.ScrubRange(bucket, byte(r))
+			if err != nil {
+				return fmt.Errorf("scrubbing bucket %s, range %02x: %w", bucket, r, err)
+			}
+			if mismatch == nil {
+				continue
+			}
+			if err = onMismatch(mismatch); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Scrubber scrubs one key range of the buckets every interval (see ScrubRange), cycling through all of them,
+// so that the silent corruption of the records on disk is detected independently of the state root.
+// The mismatches are logged and counted by the metric db/scrub/mismatch
+type Scrubber struct {
+	db       *BoltDatabase
+	buckets  [][]byte
+	interval time.Duration
+	next     int // Index of the next range across all the buckets
+	quit     chan struct{}
+	wg       sync.WaitGroup
+}
+
+// NewScrubber creates the scrubber of the buckets, the checksums of which have to be enabled by EnableBucketChecksums
+func NewScrubber(db *BoltDatabase, buckets [][]byte, interval time.Duration) *Scrubber {
+	return &Scrubber{
+		db:       db,
+		buckets:  buckets,
+		interval: interval,
+		quit:     make(chan struct{}),
+	}
+}
+
+// Start launches the background scrubbing
+func (s *Scrubber) Start() {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-s.quit:
+				return
+			}
+			if err := s.Step(); err != nil {
+				log.Warn("Scrubbing of the database failed", "err", err)
+			}
+		}
+	}()
+}
+
+// Stop terminates the background scrubbing and waits for the current range to complete
+func (s *Scrubber) Stop() {
+	close(s.quit)
+	s.wg.Wait()
+}
+
+// Step scrubs the next key range
+func (s *Scrubber) Step() error {
+	if len(s.buckets) == 0 {
+		return nil
+	}
+	bucket, r := s.buckets[s.next/256], byte(s.next%256)
+	s.next = (s.next + 1) % (256 * len(s.buckets))
+	mismatch, err := s.db.ScrubRange(bucket, r)
+	if err != nil {
+		return err
+	}
+	scrubRangesMeter.Mark(1)
+	if mismatch != nil {
+		scrubMismatchCounter.Inc(1)
+		log.Error("Database records do not match the checksum", "bucket", string(bucket), "range", fmt.Sprintf("%02x", r), "expected", mismatch.Expected, "actual", mismatch.Actual)
+	}
+	return nil
+}
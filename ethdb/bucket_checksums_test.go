@@ -0,0 +1,68 @@
+package ethdb
+
+import (
+	"testing"
+
+	"github.com/ledgerwatch/bolt"
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBucketChecksums(t *testing.T) {
+	db := NewMemDatabase()
+	defer db.Close()
+	bucket := dbutils.BlockBodyPrefix
+	db.EnableBucketChecksums(bucket)
+
+	require.NoError(t, db.Put(bucket, []byte{1, 1}, []byte("a")))
+	require.NoError(t, db.Put(bucket, []byte{2, 1}, []byte("b")))
+	scrubAll := func() []*ChecksumMismatch {
+		var mismatches []*ChecksumMismatch
+		require.NoError(t, db.ScrubBuckets([][]byte{bucket}, func(m *ChecksumMismatch) error {
+			mismatches = append(mismatches, m)
+			return nil
+		}))
+		return mismatches
+	}
+	// The first scrub records the checksums
+	assert.Empty(t, scrubAll())
+
+	// The writes keep the checksums up to date
+	require.NoError(t, db.Put(bucket, []byte{1, 1}, []byte("c")))
+	require.NoError(t, db.Put(bucket, []byte{1, 2}, []byte("d")))
+	require.NoError(t, db.Delete(bucket, []byte{2, 1}))
+	batch := db.NewBatch()
+	require.NoError(t, batch.Put(bucket, []byte{3, 1}, []byte("e")))
+	require.NoError(t, batch.Delete(bucket, []byte{1, 2}))
+	_, err := batch.Commit()
+	require.NoError(t, err)
+	assert.Empty(t, scrubAll())
+
+	// The corruption bypassing the writes is detected
+	require.NoError(t, db.KV().Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).Put([]byte{1, 1}, []byte("x"))
+	}))
+	mismatches := scrubAll()
+	if assert.Len(t, mismatches, 1) {
+		assert.Equal(t, byte(1), mismatches[0].Range)
+		assert.Equal(t, uint64(1), mismatches[0].Actual.Count)
+	}
+	require.NoError(t, db.RecordRangeChecksum(bucket, 1))
+	assert.Empty(t, scrubAll())
+
+	// The background scrubber cycles through the ranges
+	require.NoError(t, db.KV().Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).Delete([]byte{3, 1})
+	}))
+	s := NewScrubber(db, [][]byte{bucket}, 0)
+	for i := 0; i < 256; i++ {
+		require.NoError(t, s.Step())
+	}
+	mismatch, err := db.ScrubRange(bucket, 3)
+	require.NoError(t, err)
+	if assert.NotNil(t, mismatch) {
+		assert.Equal(t, uint64(0), mismatch.Actual.Count)
+		assert.Equal(t, uint64(1), mismatch.Expected.Count)
+	}
+}
@@ -0,0 +1,258 @@
+package ethdb
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"sync"
+	"time"
+
+	"github.com/ledgerwatch/bolt"
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+	"github.com/ledgerwatch/turbo-geth/log"
+	"github.com/ledgerwatch/turbo-geth/metrics"
+)
+
+// DefaultStatsRetention is how long the samples of the bucket sizes are kept in dbutils.DatabaseStatsBucket
+const DefaultStatsRetention = 30 * 24 * time.Hour
+
+// BucketStatsSample is the size of one bucket at the moment of sampling
+type BucketStatsSample struct {
+	Bucket []byte
+	Time   time.Time
+	Keys   uint64
+	Size   uint64 // bytes allocated for the branch and leaf pages of the bucket
+}
+
+// SampleBucketStats collects the sizes of all the buckets, except dbutils.DatabaseStatsBucket itself.
+// It visits all the pages of the database, so it takes a while for the large databases
+func SampleBucketStats(db *bolt.DB, now time.Time) ([]BucketStatsSample, error) {
+	var samples []BucketStatsSample
+	if err := db.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			if bytes.Equal(name, dbutils.DatabaseStatsBucket) {
+				return nil
+			}
+			bs := b.Stats()
+			samples = append(samples, BucketStatsSample{
+				Bucket: common.CopyBytes(name),
+				Time:   now,
+				Keys:   uint64(bs.KeyN),
+				Size:   uint64(bs.BranchAlloc + bs.LeafAlloc),
+			})
+			return nil
+		})
+	}); err != nil {
+		return nil, boltErr(err)
+	}
+	return samples, nil
+}
+
+func bucketStatsPrefix(bucket []byte) []byte {
+	prefix := make([]byte, 1+len(bucket))
+	prefix[0] = byte(len(bucket))
+	copy(prefix[1:], bucket)
+	return prefix
+}
+
+// WriteBucketStats persists the samples into dbutils.DatabaseStatsBucket
+func WriteBucketStats(db KV, samples []BucketStatsSample) error {
+	return db.Update(context.Background(), func(tx Tx) error {
+		if err := tx.CreateBucket(dbutils.DatabaseStatsBucket); err != nil {
+			return err
+		}
+		b := tx.Bucket(dbutils.DatabaseStatsBucket)
+		for _, s := range samples {
+			k := append(bucketStatsPrefix(s.Bucket), make([]byte, 8)...)
+			binary.BigEndian.PutUint64(k[len(k)-8:], uint64(s.Time.Unix()))
+			v := make([]byte, 16)
+			binary.BigEndian.PutUint64(v, s.Keys)
+			binary.BigEndian.PutUint64(v[8:], s.Size)
+			if err := b.Put(k, v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ReadBucketStats returns the persisted samples of the bucket, ordered by time
+func ReadBucketStats(db KV, bucket []byte) ([]BucketStatsSample, error) {
+	var samples []BucketStatsSample
+	prefix := bucketStatsPrefix(bucket)
+	if err := db.View(context.Background(), func(tx Tx) error {
+		if exists, err := tx.ExistsBucket(dbutils.DatabaseStatsBucket); err != nil || !exists {
+			return err
+		}
+		return tx.Bucket(dbutils.DatabaseStatsBucket).Cursor().Prefix(prefix).Walk(func(k, v []byte) (bool, error) {
+			if len(k) != len(prefix)+8 || len(v) != 16 {
+				return true, nil
+			}
+			samples = append(samples, BucketStatsSample{
+				Bucket: common.CopyBytes(bucket),
+				Time:   time.Unix(int64(binary.BigEndian.Uint64(k[len(prefix):])), 0),
+				Keys:   binary.BigEndian.Uint64(v),
+				Size:   binary.BigEndian.Uint64(v[8:]),
+			})
+			return true, nil
+		})
+	}); err != nil {
+		return nil, err
+	}
+	return samples, nil
+}
+
+// PruneBucketStats deletes the samples taken before the given time
+func PruneBucketStats(db KV, before time.Time) error {
+	return db.Update(context.Background(), func(tx Tx) error {
+		if exists, err := tx.ExistsBucket(dbutils.DatabaseStatsBucket); err != nil || !exists {
+			return err
+		}
+		b := tx.Bucket(dbutils.DatabaseStatsBucket)
+		var toDelete [][]byte
+		if err := b.Cursor().Walk(func(k, _ []byte) (bool, error) {
+			if len(k) < 9 || int64(binary.BigEndian.Uint64(k[len(k)-8:])) < before.Unix() {
+				toDelete = append(toDelete, common.CopyBytes(k))
+			}
+			return true, nil
+		}); err != nil {
+			return err
+		}
+		for _, k := range toDelete {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// StatsSampler periodically samples the sizes of the buckets, persists the samples into dbutils.DatabaseStatsBucket
+// and exposes them as the metrics db/bucket/<name>/keys, db/bucket/<name>/size and db/bucket/<name>/growth
+// (bytes per hour since the previous sample), which give the trend lines of the state, history and index growth.
+// The buckets of the history database (see HasHistoryKV) are sampled from it, the samples are persisted into the main one
+type StatsSampler struct {
+	db        HasKV
+	kv        KV
+	interval  time.Duration
+	retention time.Duration
+	last      map[string]BucketStatsSample // The previous sample of every bucket
+	quit      chan struct{}
+	wg        sync.WaitGroup
+}
+
+// NewStatsSampler creates the sampler, taking the samples every interval and keeping them for the retention period
+func NewStatsSampler(db HasKV, interval, retention time.Duration) *StatsSampler {
+	return &StatsSampler{
+		db:        db,
+		kv:        &BoltKV{bolt: db.KV()},
+		interval:  interval,
+		retention: retention,
+		last:      make(map[string]BucketStatsSample),
+		quit:      make(chan struct{}),
+	}
+}
+
+// Start launches the background sampling
+func (s *StatsSampler) Start() {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+		for {
+			if err := s.Sample(time.Now()); err != nil {
+				log.Warn("Sampling of the database stats failed", "err", err)
+			}
+			select {
+			case <-ticker.C:
+			case <-s.quit:
+				return
+			}
+		}
+	}()
+}
+
+// Stop terminates the background sampling and waits for the current sample to complete
+func (s *StatsSampler) Stop() {
+	close(s.quit)
+	s.wg.Wait()
+}
+
+// Sample takes the sizes of the buckets, updates the metrics and persists the samples
+func (s *StatsSampler) Sample(now time.Time) error {
+	samples, err := s.sampleBuckets(now)
+	if err != nil {
+		return err
+	}
+	for _, sample := range samples {
+		prev, ok := s.last[string(sample.Bucket)]
+		if !ok {
+			// The previous sample of the node run before the restart
+			persisted, err := ReadBucketStats(s.kv, sample.Bucket)
+			if err != nil {
+				return err
+			}
+			if len(persisted) > 0 {
+				prev, ok = persisted[len(persisted)-1], true
+			}
+		}
+		name := "db/bucket/" + bucketMetricName(sample.Bucket)
+		metrics.GetOrRegisterGauge(name+"/keys", nil).Update(int64(sample.Keys))
+		metrics.GetOrRegisterGauge(name+"/size", nil).Update(int64(sample.Size))
+		if ok && sample.Time.After(prev.Time) {
+			growth := float64(int64(sample.Size)-int64(prev.Size)) / sample.Time.Sub(prev.Time).Hours()
+			metrics.GetOrRegisterGauge(name+"/growth", nil).Update(int64(growth))
+		}
+		s.last[string(sample.Bucket)] = sample
+	}
+	if err = WriteBucketStats(s.kv, samples); err != nil {
+		return err
+	}
+	if s.retention > 0 {
+		return PruneBucketStats(s.kv, now.Add(-s.retention))
+	}
+	return nil
+}
+
+// sampleBuckets samples every bucket in the database keeping it, see BoltKVOf
+func (s *StatsSampler) sampleBuckets(now time.Time) ([]BucketStatsSample, error) {
+	samples, err := SampleBucketStats(s.db.KV(), now)
+	if err != nil {
+		return nil, err
+	}
+	split, ok := s.db.(HasHistoryKV)
+	if !ok {
+		return samples, nil
+	}
+	var merged []BucketStatsSample
+	for _, sample := range samples {
+		if !split.IsHistoryBucket(sample.Bucket) {
+			merged = append(merged, sample)
+		}
+	}
+	historySamples, err := SampleBucketStats(split.HistoryKV(), now)
+	if err != nil {
+		return nil, err
+	}
+	for _, sample := range historySamples {
+		if split.IsHistoryBucket(sample.Bucket) {
+			merged = append(merged, sample)
+		}
+	}
+	return merged, nil
+}
+
+// bucketMetricName replaces the characters not allowed in the metric names
+func bucketMetricName(bucket []byte) string {
+	name := make([]byte, len(bucket))
+	for i, c := range bucket {
+		if (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c == '_' {
+			name[i] = c
+		} else {
+			name[i] = '_'
+		}
+	}
+	return string(name)
+}
@@ -0,0 +1,87 @@
+package ethdb
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+	"github.com/ledgerwatch/turbo-geth/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatsSampler(t *testing.T) {
+	enabled := metrics.Enabled
+	metrics.Enabled = true
+	defer func() { metrics.Enabled = enabled }()
+
+	dirname, err := ioutil.TempDir(os.TempDir(), "ethdb_stats_test_")
+	require.NoError(t, err)
+	defer os.RemoveAll(dirname)
+	db, err := NewBoltDatabase(path.Join(dirname, "db"))
+	require.NoError(t, err)
+	defer db.Close()
+
+	put := func(from, to uint64) {
+		value := make([]byte, 100)
+		for i := from; i < to; i++ {
+			key := make([]byte, 8)
+			binary.BigEndian.PutUint64(key, i)
+			require.NoError(t, db.Put(dbutils.AccountChangeSetBucket, key, value))
+		}
+	}
+	put(0, 1000)
+	start := time.Unix(1000000, 0)
+	sampler := NewStatsSampler(db, time.Hour, 3*time.Hour)
+	require.NoError(t, sampler.Sample(start))
+	put(1000, 5000)
+	require.NoError(t, sampler.Sample(start.Add(time.Hour)))
+
+	samples, err := ReadBucketStats(db.AbstractKV(), dbutils.AccountChangeSetBucket)
+	require.NoError(t, err)
+	require.Len(t, samples, 2)
+	assert.Equal(t, uint64(1000), samples[0].Keys)
+	assert.Equal(t, uint64(5000), samples[1].Keys)
+	assert.True(t, samples[1].Size > samples[0].Size)
+	assert.Equal(t, start.Add(time.Hour).Unix(), samples[1].Time.Unix())
+
+	name := "db/bucket/" + bucketMetricName(dbutils.AccountChangeSetBucket)
+	assert.Equal(t, int64(5000), metrics.GetOrRegisterGauge(name+"/keys", nil).Value())
+	assert.Equal(t, int64(samples[1].Size-samples[0].Size), metrics.GetOrRegisterGauge(name+"/growth", nil).Value())
+
+	// The sampler after the restart continues from the persisted samples, the old samples are pruned
+	sampler = NewStatsSampler(db, time.Hour, 3*time.Hour)
+	require.NoError(t, sampler.Sample(start.Add(5*time.Hour)))
+	samples, err = ReadBucketStats(db.AbstractKV(), dbutils.AccountChangeSetBucket)
+	require.NoError(t, err)
+	require.Len(t, samples, 1)
+	assert.Equal(t, int64(0), metrics.GetOrRegisterGauge(name+"/growth", nil).Value())
+}
+
+func TestStatsSamplerSplit(t *testing.T) {
+	main, history := NewMemDatabase(), NewMemDatabase()
+	db := NewSplitDatabase(main, history, dbutils.ColdBuckets)
+	defer db.Close()
+	value := make([]byte, 100)
+	for i := uint64(0); i < 1000; i++ {
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, i)
+		require.NoError(t, db.Put(dbutils.AccountChangeSetBucket, key, value))
+	}
+	require.NoError(t, NewStatsSampler(db, time.Hour, 0).Sample(time.Unix(1000000, 0)))
+
+	// The history bucket is sampled from the history database, the samples are kept in the main one
+	samples, err := ReadBucketStats(&BoltKV{bolt: main.KV()}, dbutils.AccountChangeSetBucket)
+	require.NoError(t, err)
+	require.Len(t, samples, 1)
+	assert.Equal(t, uint64(1000), samples[0].Keys)
+}
+
+func TestBucketMetricName(t *testing.T) {
+	assert.Equal(t, "secure_key_", bucketMetricName(dbutils.PreimagePrefix))
+	assert.Equal(t, "iTh", bucketMetricName(dbutils.IntermediateTrieHashBucket))
+}
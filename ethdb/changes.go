@@ -0,0 +1,25 @@
+package ethdb
+
+import (
+	"github.com/ledgerwatch/turbo-geth/common/changeset"
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+)
+
+// AccountChanges iterates the account changes of the block, selected by the filter (nil selects all of them).
+// The changesets compacted into epochs are found as well, see GetChangeSetByBlock
+func AccountChanges(db Getter, blockNr uint64, filter changeset.AddressFilter, f func(change changeset.AccountChange) error) error {
+	v, err := GetChangeSetByBlock(db, dbutils.AccountsHistoryBucket, blockNr)
+	if err != nil {
+		return err
+	}
+	return changeset.AccountChangeSetBytes(v).WalkAccounts(filter, f)
+}
+
+// StorageChanges iterates the storage changes of the block, selected by the filter (nil selects all of them)
+func StorageChanges(db Getter, blockNr uint64, filter changeset.AddressFilter, f func(change changeset.StorageChange) error) error {
+	v, err := GetChangeSetByBlock(db, dbutils.StorageHistoryBucket, blockNr)
+	if err != nil {
+		return err
+	}
+	return changeset.StorageChangeSetBytes(v).WalkStorage(filter, f)
+}
@@ -0,0 +1,59 @@
+package ethdb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/common/changeset"
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+)
+
+func TestChangesCompacted(t *testing.T) {
+	addrHash := common.HexToHash("0x11")
+	keyHash := common.HexToHash("0x22")
+	db, remove := newTestBoltDB()
+	defer remove()
+
+	accountChanges := changeset.NewAccountChangeSet()
+	require.NoError(t, accountChanges.Add(addrHash[:], encodeTestAccount(1)))
+	accountBytes, err := changeset.EncodeAccounts(accountChanges)
+	require.NoError(t, err)
+	storageChanges := changeset.NewStorageChangeSet()
+	require.NoError(t, storageChanges.Add(dbutils.GenerateCompositeStorageKey(addrHash, 1, keyHash), []byte{1}))
+	storageBytes, err := changeset.EncodeStorage(storageChanges)
+	require.NoError(t, err)
+	for _, blockNr := range []uint64{5, 2500} {
+		require.NoError(t, db.Put(dbutils.AccountChangeSetBucket, dbutils.EncodeTimestamp(blockNr), accountBytes))
+		require.NoError(t, db.Put(dbutils.StorageChangeSetBucket, dbutils.EncodeTimestamp(blockNr), storageBytes))
+	}
+	_, err = CompactChangeSets(db, 2000)
+	require.NoError(t, err)
+
+	// The block 5 is in the epoch, the block 2500 is not compacted
+	for _, blockNr := range []uint64{5, 2500} {
+		var accounts []common.Hash
+		require.NoError(t, AccountChanges(db, blockNr, nil, func(change changeset.AccountChange) error {
+			accounts = append(accounts, change.AddrHash)
+			return nil
+		}))
+		assert.Equal(t, []common.Hash{addrHash}, accounts, blockNr)
+
+		var slots []common.Hash
+		require.NoError(t, StorageChanges(db, blockNr, nil, func(change changeset.StorageChange) error {
+			assert.Equal(t, addrHash, change.AddrHash)
+			assert.Equal(t, uint64(1), change.Incarnation)
+			slots = append(slots, change.KeyHash)
+			return nil
+		}))
+		assert.Equal(t, []common.Hash{keyHash}, slots, blockNr)
+	}
+
+	// No changes in the other blocks of the epoch
+	require.NoError(t, AccountChanges(db, 6, nil, func(change changeset.AccountChange) error {
+		t.Errorf("unexpected change %x", change.AddrHash)
+		return nil
+	}))
+}
@@ -0,0 +1,196 @@
+package ethdb
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/common/changeset"
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+	"github.com/ledgerwatch/turbo-geth/log"
+)
+
+// CompactChangeSets merges the per-block changesets of the complete epochs (changeset.EpochSize blocks) below toBlock
+// into one value per epoch, so that the old history, which is rarely queried at the block granularity, takes far fewer keys.
+// GetAsOf, GetChangeSetByBlock and WalkChangeSets read the compacted changesets transparently, the unwinds
+// remove the unwound blocks from the epochs with TruncateCompactedChangeSets.
+// It returns the number of compacted epochs
+func CompactChangeSets(db Database, toBlock uint64) (int, error) {
+	var epochs int
+	for _, csBucket := range [][]byte{dbutils.AccountChangeSetBucket, dbutils.StorageChangeSetBucket} {
+		n, err := compactChangeSets(db, csBucket, toBlock)
+		epochs += n
+		if err != nil {
+			return epochs, err
+		}
+	}
+	return epochs, nil
+}
+
+func compactChangeSets(db Database, csBucket []byte, toBlock uint64) (int, error) {
+	epochBucket := dbutils.ChangeSetEpochBucket(csBucket)
+	var epochs int
+	var from uint64
+	for {
+		first, found, err := firstChangeSetBlock(db, csBucket, from)
+		if err != nil || !found {
+			return epochs, err
+		}
+		epoch := first / changeset.EpochSize
+		epochEnd := (epoch + 1) * changeset.EpochSize
+		if epochEnd > toBlock {
+			return epochs, nil
+		}
+		epochKey := dbutils.EncodeTimestamp(epoch)
+
+		// Merge with the previous compaction of the epoch, if any
+		byBlock := make(map[uint64][]byte)
+		existing, err := db.Get(epochBucket, epochKey)
+		if err != nil && !IsNotFound(err) {
+			return epochs, err
+		}
+		if existing != nil {
+			if err = changeset.EpochBytes(existing).Walk(func(blockNr uint64, cs []byte) error {
+				byBlock[blockNr] = common.CopyBytes(cs)
+				return nil
+			}); err != nil {
+				return epochs, err
+			}
+		}
+		var compacted [][]byte
+		if err = db.Walk(csBucket, dbutils.EncodeTimestamp(first), 0, func(k, v []byte) (bool, error) {
+			blockNr, _ := dbutils.DecodeTimestamp(k)
+			if blockNr >= epochEnd {
+				return false, nil
+			}
+			byBlock[blockNr] = common.CopyBytes(v)
+			compacted = append(compacted, common.CopyBytes(k))
+			return true, nil
+		}); err != nil {
+			return epochs, err
+		}
+
+		blockNrs := make([]uint64, 0, len(byBlock))
+		for blockNr := range byBlock {
+			blockNrs = append(blockNrs, blockNr)
+		}
+		sort.Slice(blockNrs, func(i, j int) bool { return blockNrs[i] < blockNrs[j] })
+		changeSets := make([][]byte, len(blockNrs))
+		for i, blockNr := range blockNrs {
+			changeSets[i] = byBlock[blockNr]
+		}
+		enc, err := changeset.EncodeEpoch(blockNrs, changeSets)
+		if err != nil {
+			return epochs, err
+		}
+
+		batch := db.NewBatch()
+		if err = batch.Put(epochBucket, epochKey, enc); err != nil {
+			batch.Rollback()
+			return epochs, err
+		}
+		for _, k := range compacted {
+			if err = batch.Delete(csBucket, k); err != nil {
+				batch.Rollback()
+				return epochs, err
+			}
+		}
+		if _, err = batch.Commit(); err != nil {
+			return epochs, err
+		}
+		log.Info("Compacted changesets", "bucket", string(csBucket), "epoch", epoch, "blocks", len(compacted))
+		epochs++
+		from = epochEnd
+	}
+}
+
+// TruncateCompactedChangeSets removes the changesets of the blocks starting from the given one from the epochs
+// compacted by CompactChangeSets, the epochs left without blocks are deleted. The unwinds call it next to the deletion
+// of the per-block changesets, otherwise the changesets of the unwound blocks would stay in the epochs
+func TruncateCompactedChangeSets(db Database, from uint64) error {
+	for _, csBucket := range [][]byte{dbutils.AccountChangeSetBucket, dbutils.StorageChangeSetBucket} {
+		epochBucket := dbutils.ChangeSetEpochBucket(csBucket)
+		var keys, values [][]byte
+		if err := db.Walk(epochBucket, dbutils.EncodeTimestamp(from/changeset.EpochSize), 0, func(k, v []byte) (bool, error) {
+			var blockNrs []uint64
+			var changeSets [][]byte
+			var truncated bool
+			if err := changeset.EpochBytes(v).Walk(func(blockNr uint64, cs []byte) error {
+				if blockNr >= from {
+					truncated = true
+					return nil
+				}
+				blockNrs = append(blockNrs, blockNr)
+				changeSets = append(changeSets, common.CopyBytes(cs))
+				return nil
+			}); err != nil {
+				return false, err
+			}
+			if !truncated {
+				return true, nil
+			}
+			keys = append(keys, common.CopyBytes(k))
+			if len(blockNrs) == 0 {
+				values = append(values, nil)
+				return true, nil
+			}
+			enc, err := changeset.EncodeEpoch(blockNrs, changeSets)
+			values = append(values, enc)
+			return err == nil, err
+		}); err != nil {
+			return err
+		}
+		for i, k := range keys {
+			var err error
+			if values[i] == nil {
+				err = db.Delete(epochBucket, k)
+			} else {
+				err = db.Put(epochBucket, k, values[i])
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// firstChangeSetBlock returns the first block starting from the given one, which has a (not compacted) changeset
+func firstChangeSetBlock(db Getter, csBucket []byte, from uint64) (uint64, bool, error) {
+	var first uint64
+	var found bool
+	err := db.Walk(csBucket, dbutils.EncodeTimestamp(from), 0, func(k, _ []byte) (bool, error) {
+		first, _ = dbutils.DecodeTimestamp(k)
+		found = true
+		return false, nil
+	})
+	return first, found, err
+}
+
+// WalkChangeSets calls the walker for the changesets of the blocks starting from the given one, in the order
+// of the block numbers, including the changesets compacted into epochs (see CompactChangeSets). The compacted
+// epochs precede the blocks, which keep their changesets under their own keys. The plain changesets are not compacted
+func WalkChangeSets(db Getter, csBucket []byte, from uint64, walker func(blockNr uint64, changeSet []byte) (bool, error)) error {
+	next := from
+	if bytes.Equal(csBucket, dbutils.AccountChangeSetBucket) || bytes.Equal(csBucket, dbutils.StorageChangeSetBucket) {
+		goOn := true
+		if err := db.Walk(dbutils.ChangeSetEpochBucket(csBucket), dbutils.EncodeTimestamp(from/changeset.EpochSize), 0, func(_, v []byte) (bool, error) {
+			err := changeset.EpochBytes(v).Walk(func(blockNr uint64, cs []byte) error {
+				if !goOn || blockNr < next {
+					return nil
+				}
+				var err error
+				goOn, err = walker(blockNr, cs)
+				next = blockNr + 1
+				return err
+			})
+			return goOn, err
+		}); err != nil || !goOn {
+			return err
+		}
+	}
+	return db.Walk(csBucket, dbutils.EncodeTimestamp(next), 0, func(k, v []byte) (bool, error) {
+		blockNr, _ := dbutils.DecodeTimestamp(k)
+		return walker(blockNr, v)
+	})
+}
@@ -0,0 +1,112 @@
+package ethdb
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/ledgerwatch/turbo-geth/common/changeset"
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+)
+
+// ChangeSetSummary is the number of the changes and the size of the changesets of a block. It is written together
+// with the changesets into dbutils.ChangeSetSummaryBucket, so the changes per block can be charted without
+// decoding the changesets. It stays after the changesets are pruned by the history retention policy
+type ChangeSetSummary struct {
+	AccountChanges uint32
+	StorageChanges uint32
+	AccountBytes   uint32 // Size of the encoded account changeset
+	StorageBytes   uint32 // Size of the encoded storage changeset
+}
+
+const changeSetSummaryLen = 16
+
+// Bytes returns the size of the changesets of the block
+func (s ChangeSetSummary) Bytes() uint64 {
+	return uint64(s.AccountBytes) + uint64(s.StorageBytes)
+}
+
+func (s ChangeSetSummary) encode() []byte {
+	v := make([]byte, changeSetSummaryLen)
+	binary.BigEndian.PutUint32(v, s.AccountChanges)
+	binary.BigEndian.PutUint32(v[4:], s.StorageChanges)
+	binary.BigEndian.PutUint32(v[8:], s.AccountBytes)
+	binary.BigEndian.PutUint32(v[12:], s.StorageBytes)
+	return v
+}
+
+func decodeChangeSetSummary(v []byte) (ChangeSetSummary, bool) {
+	if len(v) != changeSetSummaryLen {
+		return ChangeSetSummary{}, false
+	}
+	return ChangeSetSummary{
+		AccountChanges: binary.BigEndian.Uint32(v),
+		StorageChanges: binary.BigEndian.Uint32(v[4:]),
+		AccountBytes:   binary.BigEndian.Uint32(v[8:]),
+		StorageBytes:   binary.BigEndian.Uint32(v[12:]),
+	}, true
+}
+
+// WriteChangeSetSummary writes the summary of the changesets of the block
+func WriteChangeSetSummary(db Putter, blockNr uint64, s ChangeSetSummary) error {
+	return db.Put(dbutils.ChangeSetSummaryBucket, dbutils.EncodeBlockNumber(blockNr), s.encode())
+}
+
+// DeleteChangeSetSummary deletes the summary of the changesets of the unwound block
+func DeleteChangeSetSummary(db Deleter, blockNr uint64) error {
+	return db.Delete(dbutils.ChangeSetSummaryBucket, dbutils.EncodeBlockNumber(blockNr))
+}
+
+// ReadChangeSetSummary returns the summary of the changesets of the block. For the blocks written before the summaries,
+// it is computed from the changesets (hashed or plain, whichever are present), ok is false if there are none of them
+func ReadChangeSetSummary(db Getter, blockNr uint64) (s ChangeSetSummary, ok bool, err error) {
+	v, err := db.Get(dbutils.ChangeSetSummaryBucket, dbutils.EncodeBlockNumber(blockNr))
+	if err != nil && !errors.Is(err, ErrKeyNotFound) {
+		return s, false, err
+	}
+	if s, ok = decodeChangeSetSummary(v); ok {
+		return s, true, nil
+	}
+	return SummarizeChangeSets(db, blockNr)
+}
+
+// SummarizeChangeSets computes the summary of the block by decoding its changesets
+func SummarizeChangeSets(db Getter, blockNr uint64) (s ChangeSetSummary, ok bool, err error) {
+	key := dbutils.EncodeTimestamp(blockNr)
+	accountBucket, storageBucket := dbutils.AccountChangeSetBucket, dbutils.StorageChangeSetBucket
+	plain := false
+	accounts, err := db.Get(accountBucket, key)
+	if errors.Is(err, ErrKeyNotFound) {
+		accountBucket, storageBucket = dbutils.PlainAccountChangeSetBucket, dbutils.PlainStorageChangeSetBucket
+		plain = true
+		accounts, err = db.Get(accountBucket, key)
+	}
+	if errors.Is(err, ErrKeyNotFound) {
+		return s, false, nil
+	}
+	if err != nil {
+		return s, false, err
+	}
+	storage, err := db.Get(storageBucket, key)
+	if err != nil && !errors.Is(err, ErrKeyNotFound) {
+		return s, false, err
+	}
+	s.AccountBytes, s.StorageBytes = uint32(len(accounts)), uint32(len(storage))
+	if len(accounts) > 0 {
+		s.AccountChanges = uint32(changeset.Len(accounts))
+	}
+	if len(storage) > 0 {
+		count := func(_, _ []byte) error {
+			s.StorageChanges++
+			return nil
+		}
+		if plain {
+			err = changeset.StorageChangeSetPlainBytes(storage).Walk(count)
+		} else {
+			err = changeset.StorageChangeSetBytes(storage).Walk(count)
+		}
+		if err != nil {
+			return s, false, err
+		}
+	}
+	return s, true, nil
+}
@@ -0,0 +1,54 @@
+package ethdb
+
+import (
+	"testing"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/common/changeset"
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChangeSetSummary(t *testing.T) {
+	db := NewMemDatabase()
+	defer db.Close()
+
+	accounts := changeset.NewAccountChangeSet()
+	storage := changeset.NewStorageChangeSet()
+	for i := byte(1); i <= 3; i++ {
+		addrHash := common.Hash{i}
+		require.NoError(t, accounts.Add(addrHash[:], []byte{i}))
+		for j := byte(0); j < i; j++ {
+			require.NoError(t, storage.Add(dbutils.GenerateCompositeStorageKey(addrHash, 1, common.Hash{j}), []byte{j + 1}))
+		}
+	}
+	accountBytes, err := changeset.EncodeAccounts(accounts)
+	require.NoError(t, err)
+	storageBytes, err := changeset.EncodeStorage(storage)
+	require.NoError(t, err)
+	require.NoError(t, db.Put(dbutils.AccountChangeSetBucket, dbutils.EncodeTimestamp(5), accountBytes))
+	require.NoError(t, db.Put(dbutils.StorageChangeSetBucket, dbutils.EncodeTimestamp(5), storageBytes))
+	expected := ChangeSetSummary{AccountChanges: 3, StorageChanges: 6, AccountBytes: uint32(len(accountBytes)), StorageBytes: uint32(len(storageBytes))}
+
+	// Computed from the changesets written before the summaries
+	s, ok, err := ReadChangeSetSummary(db, 5)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, expected, s)
+	_, ok, err = ReadChangeSetSummary(db, 6)
+	require.NoError(t, err)
+	require.False(t, ok, "block without the changesets")
+
+	// The written summary is read without the changesets
+	require.NoError(t, WriteChangeSetSummary(db, 6, ChangeSetSummary{AccountChanges: 1, AccountBytes: 40}))
+	s, ok, err = ReadChangeSetSummary(db, 6)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, ChangeSetSummary{AccountChanges: 1, AccountBytes: 40}, s)
+	require.Equal(t, uint64(40), s.Bytes())
+
+	require.NoError(t, DeleteChangeSetSummary(db, 6))
+	_, ok, err = ReadChangeSetSummary(db, 6)
+	require.NoError(t, err)
+	require.False(t, ok, "deleted summary")
+}
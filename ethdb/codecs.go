@@ -0,0 +1,236 @@
+package ethdb
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sync"
+
+	"github.com/golang/snappy"
+	"github.com/ledgerwatch/bolt"
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+	"github.com/ledgerwatch/turbo-geth/log"
+)
+
+// ErrCodecNotSupported is returned for the buckets compressed with the codec, which is not registered in this build
+var ErrCodecNotSupported = errors.New("codec not supported")
+
+// ValueCodec compresses the values of the buckets, see dbutils.BucketCodecs.
+// Decode returns the value, which does not share the memory with the encoded one
+type ValueCodec interface {
+	Encode(value []byte) ([]byte, error)
+	Decode(enc []byte) ([]byte, error)
+}
+
+var (
+	codecsLock sync.RWMutex
+	codecs     = map[dbutils.Codec]ValueCodec{
+		dbutils.SnappyCodec: snappyCodec{},
+		dbutils.GzipCodec:   gzipCodec{},
+	}
+)
+
+// RegisterCodec makes the codec available to the databases, the codecs with the external dependencies
+// register themselves in the builds, which have them (see codecs_zstd.go)
+func RegisterCodec(id dbutils.Codec, codec ValueCodec) {
+	codecsLock.Lock()
+	defer codecsLock.Unlock()
+	codecs[id] = codec
+}
+
+// lookupCodec returns the registered codec, nil for dbutils.IdentityCodec
+func lookupCodec(id dbutils.Codec) (ValueCodec, error) {
+	if id == dbutils.IdentityCodec {
+		return nil, nil
+	}
+	codecsLock.RLock()
+	defer codecsLock.RUnlock()
+	codec, ok := codecs[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrCodecNotSupported, id)
+	}
+	return codec, nil
+}
+
+type snappyCodec struct{}
+
+func (snappyCodec) Encode(value []byte) ([]byte, error) {
+	return snappy.Encode(nil, value), nil
+}
+
+func (snappyCodec) Decode(enc []byte) ([]byte, error) {
+	return snappy.Decode(nil, enc)
+}
+
+type gzipCodec struct{}
+
+func (gzipCodec) Encode(value []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(value); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gzipCodec) Decode(enc []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(enc))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+// bucketCodecs caches the codecs recorded in the databases (keyed by *bolt.DB), they only change
+// when the database is opened for writing, see recordBucketCodecs
+var bucketCodecs sync.Map
+
+// recordBucketCodecs records the codecs of dbutils.BucketCodecs for the empty buckets, which have none recorded yet.
+// The buckets with the data written before are recorded with IdentityCodec. The bodies compressed by the COMPRESS_BLOCKS
+// experiment are stored in the uncompressed bucket, and decoded by rawdb
+func recordBucketCodecs(tx Tx) error {
+	if err := tx.CreateBucket(dbutils.BucketCodecsBucket); err != nil {
+		return err
+	}
+	records := tx.Bucket(dbutils.BucketCodecsBucket)
+	for bucket, codec := range dbutils.BucketCodecs {
+		if v, err := records.Get([]byte(bucket)); err != nil {
+			return err
+		} else if len(v) > 0 {
+			continue
+		}
+		if exists, err := tx.ExistsBucket([]byte(bucket)); err != nil {
+			return err
+		} else if exists {
+			k, _, err := tx.Bucket([]byte(bucket)).Cursor().First()
+			if err != nil {
+				return err
+			}
+			if k != nil {
+				codec = dbutils.IdentityCodec
+			}
+		}
+		if _, err := lookupCodec(codec); err != nil {
+			return fmt.Errorf("bucket %s: %w", bucket, err)
+		}
+		if err := records.Put([]byte(bucket), []byte{byte(codec)}); err != nil {
+			return err
+		}
+		if codec != dbutils.IdentityCodec {
+			log.Info("Values of the bucket are compressed", "bucket", bucket, "codec", codec)
+		}
+	}
+	return nil
+}
+
+// recordBoltBucketCodecs records the codecs in the Bolt transaction, see recordBucketCodecs
+func recordBoltBucketCodecs(tx *bolt.Tx) error {
+	if err := recordBucketCodecs(&boltTx{ctx: context.Background(), bolt: tx}); err != nil {
+		return err
+	}
+	bucketCodecs.Delete(tx.DB())
+	return nil
+}
+
+// forgetBucketCodecs drops the cached codecs of the closed database
+func forgetBucketCodecs(db *bolt.DB) {
+	bucketCodecs.Delete(db)
+}
+
+// bucketCodec returns the codec of the values of the bucket, nil if they are not compressed
+func bucketCodec(tx *bolt.Tx, bucket []byte) (ValueCodec, error) {
+	var recorded map[string]dbutils.Codec
+	if v, ok := bucketCodecs.Load(tx.DB()); ok {
+		recorded = v.(map[string]dbutils.Codec)
+	} else {
+		recorded = make(map[string]dbutils.Codec)
+		if records := tx.Bucket(dbutils.BucketCodecsBucket); records != nil {
+			if err := records.ForEach(func(k, v []byte) error {
+				if len(v) > 0 {
+					recorded[string(k)] = dbutils.Codec(v[0])
+				}
+				return nil
+			}); err != nil {
+				return nil, err
+			}
+		}
+		bucketCodecs.Store(tx.DB(), recorded)
+	}
+	return lookupCodec(recorded[string(bucket)])
+}
+
+func encodeValue(codec ValueCodec, value []byte) ([]byte, error) {
+	if codec == nil || value == nil {
+		return value, nil
+	}
+	return codec.Encode(value)
+}
+
+func decodeValue(codec ValueCodec, enc []byte) ([]byte, error) {
+	if codec == nil || enc == nil {
+		return enc, nil
+	}
+	v, err := codec.Decode(enc)
+	if err != nil {
+		return nil, err
+	}
+	if v == nil {
+		// The empty value is not the missing one
+		v = []byte{}
+	}
+	return v, nil
+}
+
+// codecCursor decodes the values of the compressed bucket. The cursor stops at the value, which cannot be decoded,
+// the error is returned by Err
+type codecCursor struct {
+	BoltCursor
+	codec ValueCodec
+	err   error
+}
+
+func (c *codecCursor) decode(k, v []byte) ([]byte, []byte) {
+	if k == nil || c.err != nil {
+		return nil, nil
+	}
+	if v, c.err = decodeValue(c.codec, v); c.err != nil {
+		c.err = fmt.Errorf("value of %x: %w", k, c.err)
+		return nil, nil
+	}
+	return k, v
+}
+
+func (c *codecCursor) First() ([]byte, []byte) {
+	return c.decode(c.BoltCursor.First())
+}
+
+func (c *codecCursor) Seek(seek []byte) ([]byte, []byte) {
+	return c.decode(c.BoltCursor.Seek(seek))
+}
+
+func (c *codecCursor) SeekTo(seek []byte) ([]byte, []byte) {
+	return c.decode(c.BoltCursor.SeekTo(seek))
+}
+
+func (c *codecCursor) Next() ([]byte, []byte) {
+	return c.decode(c.BoltCursor.Next())
+}
+
+func (c *codecCursor) Err() error {
+	return c.err
+}
+
+// cursorErr returns the error, which stopped the cursor (see codecCursor)
+func cursorErr(c BoltCursor) error {
+	if cc, ok := c.(*codecCursor); ok {
+		return cc.Err()
+	}
+	return nil
+}
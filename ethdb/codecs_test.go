@@ -0,0 +1,186 @@
+package ethdb
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/golang/snappy"
+	"github.com/ledgerwatch/bolt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+)
+
+func rawValue(t *testing.T, db *bolt.DB, bucket, key []byte) []byte {
+	var v []byte
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		v, _ = tx.Bucket(bucket).Get(key)
+		v = append([]byte(nil), v...)
+		return nil
+	}))
+	return v
+}
+
+// setBucketCodecs compresses the buckets of the databases opened after it, returns the function restoring the codecs
+func setBucketCodecs(codec dbutils.Codec) func() {
+	saved := dbutils.BucketCodecs
+	dbutils.BucketCodecs = make(map[string]dbutils.Codec)
+	for _, bucket := range dbutils.CompressibleBuckets {
+		dbutils.BucketCodecs[string(bucket)] = codec
+	}
+	return func() {
+		dbutils.BucketCodecs = saved
+	}
+}
+
+func TestBucketCodecs(t *testing.T) {
+	value := bytes.Repeat([]byte("changeset"), 100)
+	key := dbutils.EncodeTimestamp(5)
+
+	t.Run("default", func(t *testing.T) {
+		db := NewMemDatabase()
+		defer db.Close()
+		require.NoError(t, db.Put(dbutils.BlockBodyPrefix, key, value))
+		require.NoError(t, db.AbstractKV().Update(context.Background(), func(tx Tx) error {
+			return tx.Bucket(dbutils.AccountChangeSetBucket).Put(key, value)
+		}))
+		assert.Equal(t, value, rawValue(t, db.KV(), dbutils.BlockBodyPrefix, key))
+		assert.Equal(t, value, rawValue(t, db.KV(), dbutils.AccountChangeSetBucket, key))
+	})
+
+	t.Run("database", func(t *testing.T) {
+		defer setBucketCodecs(dbutils.SnappyCodec)()
+		db := NewMemDatabase()
+		defer db.Close()
+		require.NoError(t, db.Put(dbutils.AccountChangeSetBucket, key, value))
+		_, err := db.MultiPut(dbutils.StorageChangeSetBucket, key, value)
+		require.NoError(t, err)
+
+		for _, bucket := range [][]byte{dbutils.AccountChangeSetBucket, dbutils.StorageChangeSetBucket} {
+			raw := rawValue(t, db.KV(), bucket, key)
+			assert.True(t, len(raw) < len(value))
+			decoded, err := snappy.Decode(nil, raw)
+			require.NoError(t, err)
+			assert.Equal(t, value, decoded)
+
+			v, err := db.Get(bucket, key)
+			require.NoError(t, err)
+			assert.Equal(t, value, v)
+			require.NoError(t, db.Walk(bucket, nil, 0, func(k, v []byte) (bool, error) {
+				assert.Equal(t, value, v)
+				return true, nil
+			}))
+		}
+		v, err := db.GetChangeSetByBlock(dbutils.AccountsHistoryBucket, 5)
+		require.NoError(t, err)
+		assert.Equal(t, value, v)
+
+		// Not designated buckets are stored as is
+		require.NoError(t, db.Put(dbutils.HeaderPrefix, key, value))
+		assert.Equal(t, value, rawValue(t, db.KV(), dbutils.HeaderPrefix, key))
+	})
+
+	t.Run("kv", func(t *testing.T) {
+		defer setBucketCodecs(dbutils.SnappyCodec)()
+		db := NewMemDatabase()
+		defer db.Close()
+		kv := db.AbstractKV()
+		ctx := context.Background()
+		require.NoError(t, kv.Update(ctx, func(tx Tx) error {
+			return tx.Bucket(dbutils.BlockBodyPrefix).Put(key, value)
+		}))
+		assert.Equal(t, snappy.Encode(nil, value), rawValue(t, db.KV(), dbutils.BlockBodyPrefix, key))
+		require.NoError(t, kv.View(ctx, func(tx Tx) error {
+			v, err := tx.Bucket(dbutils.BlockBodyPrefix).Get(key)
+			require.NoError(t, err)
+			assert.Equal(t, value, v)
+			k, v, err := tx.Bucket(dbutils.BlockBodyPrefix).Cursor().First()
+			require.NoError(t, err)
+			assert.Equal(t, key, k)
+			assert.Equal(t, value, v)
+			return nil
+		}))
+
+		// The restored values are encoded again
+		require.NoError(t, kv.Update(ctx, func(tx Tx) error {
+			sp, err := tx.Savepoint()
+			require.NoError(t, err)
+			require.NoError(t, tx.Bucket(dbutils.BlockBodyPrefix).Put(key, []byte("other")))
+			return tx.RollbackTo(sp)
+		}))
+		assert.Equal(t, snappy.Encode(nil, value), rawValue(t, db.KV(), dbutils.BlockBodyPrefix, key))
+	})
+
+	t.Run("existing data", func(t *testing.T) {
+		defer setBucketCodecs(dbutils.SnappyCodec)()
+		db := NewMemDatabase()
+		defer db.Close()
+		// The bucket written before the codecs were recorded
+		require.NoError(t, db.KV().Update(func(tx *bolt.Tx) error {
+			if err := tx.Bucket(dbutils.BucketCodecsBucket).Delete(dbutils.BlockReceiptsPrefix); err != nil {
+				return err
+			}
+			return tx.Bucket(dbutils.BlockReceiptsPrefix).Put(key, value)
+		}))
+		require.NoError(t, db.KV().Update(recordBoltBucketCodecs))
+
+		assert.Equal(t, []byte{byte(dbutils.IdentityCodec)}, rawValue(t, db.KV(), dbutils.BucketCodecsBucket, dbutils.BlockReceiptsPrefix))
+		v, err := db.Get(dbutils.BlockReceiptsPrefix, key)
+		require.NoError(t, err)
+		assert.Equal(t, value, v)
+	})
+
+	t.Run("badger", func(t *testing.T) {
+		defer setBucketCodecs(dbutils.GzipCodec)()
+		ctx := context.Background()
+		kv, err := NewBadger().InMem().Open(ctx)
+		require.NoError(t, err)
+		defer kv.Close()
+		require.NoError(t, kv.Update(ctx, func(tx Tx) error {
+			return tx.Bucket(dbutils.BlockReceiptsPrefix).Put(key, value)
+		}))
+		require.NoError(t, kv.View(ctx, func(tx Tx) error {
+			v, err := tx.Bucket(dbutils.BlockReceiptsPrefix).Get(key)
+			require.NoError(t, err)
+			assert.Equal(t, value, v)
+			c := tx.Bucket(dbutils.BlockReceiptsPrefix).Cursor()
+			k, v, err := c.First()
+			require.NoError(t, err)
+			assert.Equal(t, key, k)
+			assert.Equal(t, value, v)
+			nc := tx.Bucket(dbutils.BlockReceiptsPrefix).Cursor().NoValues()
+			k, size, err := nc.First()
+			require.NoError(t, err)
+			assert.Equal(t, key, k)
+			assert.True(t, int(size) < len(value), "the size of the compressed value")
+			v, err = nc.UnsafeValue()
+			require.NoError(t, err)
+			assert.Equal(t, value, v)
+			return nil
+		}))
+		// The backend keeps the compressed value
+		require.NoError(t, kv.(*codecKV).KV.View(ctx, func(tx Tx) error {
+			raw, err := tx.Bucket(dbutils.BlockReceiptsPrefix).Get(key)
+			require.NoError(t, err)
+			assert.True(t, len(raw) < len(value))
+			decoded, err := gzipCodec{}.Decode(raw)
+			require.NoError(t, err)
+			assert.Equal(t, value, decoded)
+			return nil
+		}))
+	})
+
+	t.Run("not supported", func(t *testing.T) {
+		db := NewMemDatabase()
+		defer db.Close()
+		require.NoError(t, db.KV().Update(func(tx *bolt.Tx) error {
+			return tx.Bucket(dbutils.BucketCodecsBucket).Put(dbutils.BlockReceiptsPrefix, []byte{0xff})
+		}))
+		forgetBucketCodecs(db.KV())
+		err := db.Put(dbutils.BlockReceiptsPrefix, key, value)
+		assert.True(t, errors.Is(err, ErrCodecNotSupported))
+	})
+}
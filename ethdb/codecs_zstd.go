@@ -0,0 +1,24 @@
+//go:build cgo
+// +build cgo
+
+package ethdb
+
+import (
+	"github.com/DataDog/zstd"
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+)
+
+// zstd is only available with cgo, the databases with the zstd buckets cannot be opened by the builds without it
+func init() {
+	RegisterCodec(dbutils.ZstdCodec, zstdCodec{})
+}
+
+type zstdCodec struct{}
+
+func (zstdCodec) Encode(value []byte) ([]byte, error) {
+	return zstd.Compress(nil, value)
+}
+
+func (zstdCodec) Decode(enc []byte) ([]byte, error) {
+	return zstd.Decompress(nil, enc)
+}
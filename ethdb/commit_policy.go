@@ -0,0 +1,74 @@
+package ethdb
+
+import (
+	"time"
+)
+
+// CommitPolicy defines when a batch (see NewBatchWithPolicy) commits itself into the underlying database.
+// Thresholds are checked after every write, zero value of a threshold means "no limit".
+// Auto-commit breaks atomicity of the batch: it is for long-running writers (stages, migrations),
+// which can safely restart from the last saved progress.
+type CommitPolicy struct {
+	MaxKeys  int           // number of pending keys
+	MaxBytes int           // size of pending data, 0 - IdealBatchSize of the underlying database
+	MaxAge   time.Duration // time since the last commit, checked only on writes
+
+	// AtCheckpoints disables the commits after the writes, for the writers which data is consistent only
+	// at some points (i.e. between the blocks). Such writers check CommitDue at these points and commit themselves
+	AtCheckpoints bool
+
+	// OnCommit is called after every automatic commit, i.e. to log progress
+	OnCommit func(written uint64)
+}
+
+// NewBatchWithPolicy creates a batch over db which commits itself according to the policy.
+// Explicit Commit is still required at the end to flush the remaining data.
+func NewBatchWithPolicy(db Database, policy CommitPolicy) DbWithPendingMutations {
+	if policy.MaxBytes == 0 {
+		policy.MaxBytes = db.IdealBatchSize()
+	}
+	return &mutation{
+		db:         db,
+		puts:       newPuts(),
+		policy:     &policy,
+		lastCommit: time.Now(),
+	}
+}
+
+// CommitDue reports whether the batch created by NewBatchWithPolicy has reached any of the thresholds of its policy.
+// It is always false for the other batches
+func CommitDue(batch DbWithPendingMutations) bool {
+	m, ok := batch.(*mutation)
+	if !ok {
+		return false
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.mustCommit()
+}
+
+// mustCommit must be called under the lock
+func (m *mutation) mustCommit() bool {
+	if m.policy == nil || m.db == nil || m.puts.Len() == 0 {
+		return false
+	}
+	p := m.policy
+	return (p.MaxKeys > 0 && m.puts.Len() >= p.MaxKeys) ||
+		(p.MaxBytes > 0 && m.puts.Size() >= p.MaxBytes) ||
+		(p.MaxAge > 0 && time.Since(m.lastCommit) >= p.MaxAge)
+}
+
+// autoCommit must be called under the lock
+func (m *mutation) autoCommit() error {
+	if m.policy == nil || m.policy.AtCheckpoints || !m.mustCommit() {
+		return nil
+	}
+	written, err := m.commitNoLock()
+	if err != nil {
+		return err
+	}
+	if m.policy.OnCommit != nil {
+		m.policy.OnCommit(written)
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package ethdb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCommitPolicy(t *testing.T) {
+	bucket := []byte("B")
+
+	t.Run("max keys", func(t *testing.T) {
+		db := NewMemDatabase()
+		defer db.Close()
+		commits := 0
+		batch := NewBatchWithPolicy(db, CommitPolicy{MaxKeys: 3, OnCommit: func(uint64) { commits++ }})
+		for i := byte(0); i < 7; i++ {
+			require.NoError(t, batch.Put(bucket, []byte{i}, []byte{i}))
+		}
+		assert.Equal(t, 2, commits)
+		assert.Equal(t, 1, batch.(*mutation).puts.Len())
+		_, err := db.Get(bucket, []byte{5})
+		assert.NoError(t, err)
+		_, err = db.Get(bucket, []byte{6})
+		assert.True(t, IsNotFound(err))
+
+		_, err = batch.Commit()
+		require.NoError(t, err)
+		_, err = db.Get(bucket, []byte{6})
+		assert.NoError(t, err)
+	})
+
+	t.Run("max bytes defaults to IdealBatchSize", func(t *testing.T) {
+		db := NewMemDatabase()
+		defer db.Close()
+		batch := NewBatchWithPolicy(db, CommitPolicy{})
+		assert.Equal(t, db.IdealBatchSize(), batch.IdealBatchSize())
+
+		batch = NewBatchWithPolicy(db, CommitPolicy{MaxBytes: 100})
+		assert.Equal(t, 100, batch.IdealBatchSize())
+		require.NoError(t, batch.Put(bucket, []byte("k1"), make([]byte, 10)))
+		assert.NotEqual(t, 0, batch.BatchSize())
+		require.NoError(t, batch.Put(bucket, []byte("k2"), make([]byte, 100)))
+		assert.Equal(t, 0, batch.BatchSize())
+	})
+
+	t.Run("max age", func(t *testing.T) {
+		db := NewMemDatabase()
+		defer db.Close()
+		batch := NewBatchWithPolicy(db, CommitPolicy{MaxAge: time.Hour})
+		require.NoError(t, batch.Put(bucket, []byte("k1"), []byte("v1")))
+		assert.NotEqual(t, 0, batch.BatchSize())
+
+		batch.(*mutation).lastCommit = time.Now().Add(-2 * time.Hour)
+		require.NoError(t, batch.Delete(bucket, []byte("k2")))
+		assert.Equal(t, 0, batch.BatchSize())
+		v, err := db.Get(bucket, []byte("k1"))
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("v1"), v)
+	})
+
+	t.Run("at checkpoints", func(t *testing.T) {
+		db := NewMemDatabase()
+		defer db.Close()
+		commits := 0
+		batch := NewBatchWithPolicy(db, CommitPolicy{MaxKeys: 2, AtCheckpoints: true, OnCommit: func(uint64) { commits++ }})
+		require.NoError(t, batch.Put(bucket, []byte("k1"), []byte("v1")))
+		assert.False(t, CommitDue(batch))
+		require.NoError(t, batch.Put(bucket, []byte("k2"), []byte("v2")))
+		require.NoError(t, batch.Put(bucket, []byte("k3"), []byte("v3")))
+		assert.True(t, CommitDue(batch))
+		assert.Equal(t, 0, commits)
+		assert.Equal(t, 3, batch.(*mutation).puts.Len())
+		_, err := db.Get(bucket, []byte("k1"))
+		assert.True(t, IsNotFound(err))
+
+		_, err = batch.Commit()
+		require.NoError(t, err)
+		assert.False(t, CommitDue(batch))
+		assert.False(t, CommitDue(db.NewBatch()))
+	})
+}
+
+// multiPutCounter counts the transactions written into the database
+type multiPutCounter struct {
+	*BoltDatabase
+	multiPuts int
+}
+
+func (db *multiPutCounter) MultiPut(tuples ...[]byte) (uint64, error) {
+	db.multiPuts++
+	return db.BoltDatabase.MultiPut(tuples...)
+}
+
+func TestCommitTogether(t *testing.T) {
+	bucket := []byte("B")
+	db := &multiPutCounter{BoltDatabase: NewMemDatabase()}
+	defer db.Close()
+	policy := CommitPolicy{AtCheckpoints: true}
+	first := NewBatchWithPolicy(db, policy)
+	second := NewBatchWithPolicy(db, policy)
+	require.NoError(t, first.Put(bucket, []byte{1}, []byte{1}))
+	require.NoError(t, first.Put(bucket, []byte{2}, []byte{1}))
+	require.NoError(t, second.Put(bucket, []byte{2}, []byte{2}))
+	require.NoError(t, second.Put(bucket, []byte{3}, []byte{2}))
+
+	written, err := CommitTogether(first, second)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(3), written)
+	assert.Equal(t, 1, db.multiPuts, "the batches are written in one transaction")
+	for k, expected := range map[byte]byte{1: 1, 2: 2, 3: 2} {
+		v, err := db.Get(bucket, []byte{k})
+		require.NoError(t, err)
+		assert.Equal(t, []byte{expected}, v, "key %d", k)
+	}
+	assert.Equal(t, 0, first.BatchSize())
+	assert.Equal(t, 0, second.BatchSize())
+
+	// Nothing to write
+	_, err = CommitTogether(first, second)
+	require.NoError(t, err)
+	assert.Equal(t, 1, db.multiPuts)
+
+	other := NewMemDatabase()
+	defer other.Close()
+	_, err = CommitTogether(first, other.NewBatch())
+	assert.Error(t, err)
+}
@@ -20,6 +20,7 @@ package ethdb
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -29,6 +30,10 @@ import (
 	"testing"
 
 	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/common/changeset"
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+	"github.com/ledgerwatch/turbo-geth/ethdb/remote"
+	"github.com/ledgerwatch/turbo-geth/ethdb/remote/remotekv"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -99,8 +104,8 @@ func testPutGet(db MinDatabase, t *testing.T) {
 	}
 
 	_, err := db.Get(testBucket, []byte("non-exist-key"))
-	if err == nil {
-		t.Fatalf("expect to return a not found error")
+	if !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("expect to return a not found error, got %v", err)
 	}
 
 	for _, v := range testValues {
@@ -286,3 +291,380 @@ func testWalk(db Database, t *testing.T) {
 
 	assert.Equal(t, keysInRange, gotKeys)
 }
+
+func TestRemoteErr(t *testing.T) {
+	// the sentinel is restored by the code, the message is kept
+	err := remoteErr(fmt.Errorf("could not decode errorMessage for CmdBucket: %w", &remote.Error{Code: remotekv.ErrorCode_BUCKET_NOT_FOUND, Message: "bucket b1 is missing"}))
+	assert.True(t, errors.Is(err, ErrBucketNotFound))
+	assert.False(t, errors.Is(err, ErrKeyNotFound))
+	assert.Contains(t, err.Error(), "b1")
+
+	// the message, which looks like the sentinel, is not parsed
+	err = remoteErr(&remote.Error{Message: ErrKeyNotFound.Error()})
+	assert.False(t, IsNotFound(err))
+
+	other := fmt.Errorf("connection reset")
+	assert.Equal(t, other, remoteErr(other))
+	assert.NoError(t, remoteErr(nil))
+}
+
+func TestBoltDB_GetAsOfMissingBucket(t *testing.T) {
+	db, remove := newTestBoltDB()
+	defer remove()
+
+	_, err := db.GetAsOf([]byte("NoSuchBucket"), []byte("NoSuchHistory"), common.HexToHash("0x11").Bytes(), 1)
+	assert.True(t, errors.Is(err, ErrBucketNotFound))
+	assert.True(t, IsNotFound(err))
+}
+
+func TestGetAsOf(t *testing.T) {
+	addrHash := common.HexToHash("0x11").Bytes()
+	accOld, accNew := encodeTestAccount(1), encodeTestAccount(2)
+	cs := changeset.NewAccountChangeSet()
+	assert.NoError(t, cs.Add(addrHash, accOld))
+	csBytes, err := changeset.EncodeAccounts(cs)
+	assert.NoError(t, err)
+
+	// account has been changed at block 5
+	fill := func(db Putter) {
+		assert.NoError(t, db.Put(dbutils.CurrentStateBucket, addrHash, accNew))
+		assert.NoError(t, db.Put(dbutils.AccountChangeSetBucket, dbutils.EncodeTimestamp(5), csBytes))
+		assert.NoError(t, db.Put(dbutils.AccountsHistoryBucket, dbutils.CurrentChunkKey(addrHash), dbutils.NewHistoryIndex().Append(5, false)))
+	}
+	check := func(name string, db Getter) {
+		v, err := db.GetAsOf(dbutils.CurrentStateBucket, dbutils.AccountsHistoryBucket, addrHash, 3)
+		assert.NoError(t, err, name)
+		assert.Equal(t, accOld, v, name)
+		v, err = db.GetAsOf(dbutils.CurrentStateBucket, dbutils.AccountsHistoryBucket, addrHash, 6)
+		assert.NoError(t, err, name)
+		assert.Equal(t, accNew, v, name)
+		_, err = db.GetAsOf(dbutils.CurrentStateBucket, dbutils.AccountsHistoryBucket, common.HexToHash("0x22").Bytes(), 3)
+		assert.True(t, errors.Is(err, ErrKeyNotFound), name)
+	}
+
+	boltDB, remove := newTestBoltDB()
+	defer remove()
+	fill(boltDB)
+	check("bolt", boltDB)
+	check("kv", NewRemoteBoltDatabase(boltDB.AbstractKV()))
+
+	badgerDB, removeBadger := newTestBadgerDB()
+	defer removeBadger()
+	fill(badgerDB)
+	check("badger", badgerDB)
+}
+
+func TestWalkAsOf(t *testing.T) {
+	changed, created, unchanged := common.HexToHash("0x11"), common.HexToHash("0x22"), common.HexToHash("0x33")
+	accOld, accNew, accCreated, accUnchanged := encodeTestAccount(1), encodeTestAccount(2), encodeTestAccount(3), encodeTestAccount(4)
+	keyHash := common.HexToHash("0x44")
+	storageKey := dbutils.GenerateCompositeStorageKey(changed, 1, keyHash)
+	accountCS := changeset.NewAccountChangeSet()
+	assert.NoError(t, accountCS.Add(changed[:], accOld))
+	assert.NoError(t, accountCS.Add(created[:], []byte{}))
+	accountCSBytes, err := changeset.EncodeAccounts(accountCS)
+	assert.NoError(t, err)
+	storageCS := changeset.NewStorageChangeSet()
+	assert.NoError(t, storageCS.Add(storageKey, []byte{1}))
+	storageCSBytes, err := changeset.EncodeStorage(storageCS)
+	assert.NoError(t, err)
+
+	// the accounts and the storage item have been changed at block 5
+	fill := func(db Putter) {
+		assert.NoError(t, db.Put(dbutils.CurrentStateBucket, changed[:], accNew))
+		assert.NoError(t, db.Put(dbutils.CurrentStateBucket, storageKey, []byte{2}))
+		assert.NoError(t, db.Put(dbutils.CurrentStateBucket, created[:], accCreated))
+		assert.NoError(t, db.Put(dbutils.CurrentStateBucket, unchanged[:], accUnchanged))
+		assert.NoError(t, db.Put(dbutils.AccountChangeSetBucket, dbutils.EncodeTimestamp(5), accountCSBytes))
+		assert.NoError(t, db.Put(dbutils.StorageChangeSetBucket, dbutils.EncodeTimestamp(5), storageCSBytes))
+		assert.NoError(t, db.Put(dbutils.AccountsHistoryBucket, dbutils.CurrentChunkKey(changed[:]), dbutils.NewHistoryIndex().Append(5, false)))
+		assert.NoError(t, db.Put(dbutils.AccountsHistoryBucket, dbutils.CurrentChunkKey(created[:]), dbutils.NewHistoryIndex().Append(5, true)))
+		assert.NoError(t, db.Put(dbutils.StorageHistoryBucket, dbutils.CurrentChunkKey(storageKey), dbutils.NewHistoryIndex().Append(5, false)))
+	}
+	walk := func(db Getter, hBucket, startkey []byte, fixedbits int, timestamp uint64) map[string][]byte {
+		walked := make(map[string][]byte)
+		assert.NoError(t, db.WalkAsOf(dbutils.CurrentStateBucket, hBucket, startkey, fixedbits, timestamp, func(k, v []byte) (bool, error) {
+			walked[string(k)] = common.CopyBytes(v)
+			return true, nil
+		}))
+		return walked
+	}
+	check := func(name string, db Getter) {
+		assert.Equal(t, map[string][]byte{string(changed[:]): accOld, string(unchanged[:]): accUnchanged},
+			walk(db, dbutils.AccountsHistoryBucket, nil, 0, 3), name)
+		assert.Equal(t, map[string][]byte{string(changed[:]): accNew, string(created[:]): accCreated, string(unchanged[:]): accUnchanged},
+			walk(db, dbutils.AccountsHistoryBucket, nil, 0, 6), name)
+		prefix := dbutils.GenerateStoragePrefix(changed[:], 1)
+		storageKeyNoInc := string(append(changed.Bytes(), keyHash[:]...))
+		assert.Equal(t, map[string][]byte{storageKeyNoInc: {1}}, walk(db, dbutils.StorageHistoryBucket, prefix, 8*len(prefix), 3), name)
+		assert.Equal(t, map[string][]byte{storageKeyNoInc: {2}}, walk(db, dbutils.StorageHistoryBucket, prefix, 8*len(prefix), 6), name)
+	}
+
+	boltDB, remove := newTestBoltDB()
+	defer remove()
+	fill(boltDB)
+	check("bolt", boltDB)
+	check("kv", NewRemoteBoltDatabase(boltDB.AbstractKV()))
+}
+
+func TestGetAsOfCompactedChangeSets(t *testing.T) {
+	addrHash := common.HexToHash("0x11").Bytes()
+	acc1, acc2, acc3 := encodeTestAccount(1), encodeTestAccount(2), encodeTestAccount(3)
+	changeSetOf := func(acc []byte) []byte {
+		cs := changeset.NewAccountChangeSet()
+		assert.NoError(t, cs.Add(addrHash, acc))
+		csBytes, err := changeset.EncodeAccounts(cs)
+		assert.NoError(t, err)
+		return csBytes
+	}
+	cs5, cs1500 := changeSetOf(acc1), changeSetOf(acc2)
+
+	// account has been changed at blocks 5, 1500 and 2500
+	db, remove := newTestBoltDB()
+	defer remove()
+	assert.NoError(t, db.Put(dbutils.CurrentStateBucket, addrHash, acc3))
+	assert.NoError(t, db.Put(dbutils.AccountChangeSetBucket, dbutils.EncodeTimestamp(5), cs5))
+	assert.NoError(t, db.Put(dbutils.AccountChangeSetBucket, dbutils.EncodeTimestamp(1500), cs1500))
+	assert.NoError(t, db.Put(dbutils.AccountChangeSetBucket, dbutils.EncodeTimestamp(2500), changeSetOf(acc3)))
+	index := dbutils.NewHistoryIndex().Append(5, false).Append(1500, false).Append(2500, false)
+	assert.NoError(t, db.Put(dbutils.AccountsHistoryBucket, dbutils.CurrentChunkKey(addrHash), index))
+
+	epochs, err := CompactChangeSets(db, 2000)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, epochs)
+
+	for _, tc := range []struct {
+		timestamp uint64
+		expected  []byte
+	}{{3, acc1}, {6, acc2}, {1600, acc3}, {3000, acc3}} {
+		v, err := db.GetAsOf(dbutils.CurrentStateBucket, dbutils.AccountsHistoryBucket, addrHash, tc.timestamp)
+		assert.NoError(t, err, tc.timestamp)
+		assert.Equal(t, tc.expected, v, tc.timestamp)
+	}
+	v, err := db.GetChangeSetByBlock(dbutils.AccountsHistoryBucket, 5)
+	assert.NoError(t, err)
+	assert.Equal(t, cs5, v)
+	// The same through any database
+	for _, blockNr := range []uint64{5, 1500, 2500} {
+		expected, err1 := db.GetChangeSetByBlock(dbutils.AccountsHistoryBucket, blockNr)
+		assert.NoError(t, err1)
+		v, err = GetChangeSetByBlock(db.NewBatch(), dbutils.AccountsHistoryBucket, blockNr)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, v, blockNr)
+	}
+	v, err = GetChangeSetByBlock(db, dbutils.AccountsHistoryBucket, 6)
+	assert.NoError(t, err)
+	assert.Nil(t, v)
+
+	var blockNrs []uint64
+	assert.NoError(t, db.Walk(dbutils.AccountChangeSetBucket, nil, 0, func(k, _ []byte) (bool, error) {
+		blockNr, _ := dbutils.DecodeTimestamp(k)
+		blockNrs = append(blockNrs, blockNr)
+		return true, nil
+	}))
+	assert.Equal(t, []uint64{2500}, blockNrs)
+	// The epoch-aware walk sees the compacted changesets
+	for _, tc := range []struct {
+		from     uint64
+		expected []uint64
+	}{{0, []uint64{5, 1500, 2500}}, {6, []uint64{1500, 2500}}, {1501, []uint64{2500}}} {
+		blockNrs = nil
+		assert.NoError(t, WalkChangeSets(db, dbutils.AccountChangeSetBucket, tc.from, func(blockNr uint64, _ []byte) (bool, error) {
+			blockNrs = append(blockNrs, blockNr)
+			return true, nil
+		}))
+		assert.Equal(t, tc.expected, blockNrs, tc.from)
+	}
+	blockNrs = nil
+	assert.NoError(t, WalkChangeSets(db, dbutils.AccountChangeSetBucket, 0, func(blockNr uint64, cs []byte) (bool, error) {
+		blockNrs = append(blockNrs, blockNr)
+		assert.Equal(t, cs5, cs)
+		return false, nil
+	}))
+	assert.Equal(t, []uint64{5}, blockNrs)
+
+	// Compaction is idempotent
+	epochs, err = CompactChangeSets(db, 2000)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, epochs)
+}
+
+func TestPruneHistory(t *testing.T) {
+	addrHash := common.HexToHash("0x11").Bytes()
+	acc1, acc2, acc3, acc4, acc5 := encodeTestAccount(1), encodeTestAccount(2), encodeTestAccount(3), encodeTestAccount(4), encodeTestAccount(5)
+	changeSetOf := func(acc []byte) []byte {
+		cs := changeset.NewAccountChangeSet()
+		assert.NoError(t, cs.Add(addrHash, acc))
+		csBytes, err := changeset.EncodeAccounts(cs)
+		assert.NoError(t, err)
+		return csBytes
+	}
+
+	// account has been changed at blocks 5, 1500, 2500 and 2600, the changesets of the first epoch are compacted
+	db, remove := newTestBoltDB()
+	defer remove()
+	assert.NoError(t, db.Put(dbutils.CurrentStateBucket, addrHash, acc5))
+	assert.NoError(t, db.Put(dbutils.AccountChangeSetBucket, dbutils.EncodeTimestamp(5), changeSetOf(acc1)))
+	assert.NoError(t, db.Put(dbutils.AccountChangeSetBucket, dbutils.EncodeTimestamp(1500), changeSetOf(acc2)))
+	assert.NoError(t, db.Put(dbutils.AccountChangeSetBucket, dbutils.EncodeTimestamp(2500), changeSetOf(acc3)))
+	assert.NoError(t, db.Put(dbutils.AccountChangeSetBucket, dbutils.EncodeTimestamp(2600), changeSetOf(acc4)))
+	index := dbutils.NewHistoryIndex().Append(5, false).Append(1500, false)
+	assert.NoError(t, db.Put(dbutils.AccountsHistoryBucket, dbutils.IndexChunkKey(addrHash, 1500), index))
+	index = dbutils.NewHistoryIndex().Append(2500, false).Append(2600, false)
+	assert.NoError(t, db.Put(dbutils.AccountsHistoryBucket, dbutils.CurrentChunkKey(addrHash), index))
+	_, err := CompactChangeSets(db, 1000)
+	assert.NoError(t, err)
+
+	assert.NoError(t, PruneHistory(db, dbutils.AccountsHistoryBucket, 2500))
+	prunedTo, ok, err := ReadHistoryPrunedTo(db, dbutils.AccountsHistoryBucket)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, uint64(2500), prunedTo)
+
+	for _, timestamp := range []uint64{3, 1600, 2500} {
+		_, err = db.GetAsOf(dbutils.CurrentStateBucket, dbutils.AccountsHistoryBucket, addrHash, timestamp)
+		assert.True(t, errors.Is(err, ErrHistoryPruned), timestamp)
+		var prunedErr *HistoryPrunedError
+		assert.True(t, errors.As(err, &prunedErr), timestamp)
+	}
+	v, err := db.GetAsOf(dbutils.CurrentStateBucket, dbutils.AccountsHistoryBucket, addrHash, 2501)
+	assert.NoError(t, err)
+	assert.Equal(t, acc4, v)
+	v, err = db.GetAsOf(dbutils.CurrentStateBucket, dbutils.AccountsHistoryBucket, addrHash, 2601)
+	assert.NoError(t, err)
+	assert.Equal(t, acc5, v)
+	// The storage history is not affected
+	_, err = db.GetAsOf(dbutils.CurrentStateBucket, dbutils.StorageHistoryBucket, dbutils.GenerateCompositeStorageKey(common.BytesToHash(addrHash), 1, common.Hash{}), 3)
+	assert.False(t, errors.Is(err, ErrHistoryPruned))
+
+	var blockNrs []uint64
+	assert.NoError(t, db.Walk(dbutils.AccountChangeSetBucket, nil, 0, func(k, _ []byte) (bool, error) {
+		blockNr, _ := dbutils.DecodeTimestamp(k)
+		blockNrs = append(blockNrs, blockNr)
+		return true, nil
+	}))
+	assert.Equal(t, []uint64{2600}, blockNrs)
+	var epochs int
+	assert.NoError(t, db.Walk(dbutils.AccountChangeSetEpochBucket, nil, 0, func(k, _ []byte) (bool, error) {
+		epochs++
+		return true, nil
+	}))
+	assert.Equal(t, 0, epochs)
+	var chunks [][]byte
+	assert.NoError(t, db.Walk(dbutils.AccountsHistoryBucket, nil, 0, func(k, v []byte) (bool, error) {
+		assert.Equal(t, dbutils.CurrentChunkKey(addrHash), k)
+		chunks = append(chunks, common.CopyBytes(v))
+		return true, nil
+	}))
+	assert.Equal(t, [][]byte{dbutils.NewHistoryIndex().Append(2600, false)}, chunks)
+
+	// Pruning is idempotent
+	assert.NoError(t, PruneHistory(db, dbutils.AccountsHistoryBucket, 2000))
+	prunedTo, _, err = ReadHistoryPrunedTo(db, dbutils.AccountsHistoryBucket)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2500), prunedTo)
+}
+
+func TestHistoryRetention(t *testing.T) {
+	r := HistoryRetention{FullBlocks: 100, AccountBlocks: 1000}
+	assert.NoError(t, r.Validate())
+	storageTo, storageOk, _, accountOk := r.PruneTargets(500)
+	assert.True(t, storageOk)
+	assert.Equal(t, uint64(400), storageTo)
+	assert.False(t, accountOk)
+	_, _, accountTo, accountOk := r.PruneTargets(1500)
+	assert.True(t, accountOk)
+	assert.Equal(t, uint64(500), accountTo)
+
+	assert.False(t, HistoryRetention{}.Enabled())
+	assert.Error(t, HistoryRetention{FullBlocks: 1000, AccountBlocks: 100}.Validate())
+	assert.Error(t, HistoryRetention{AccountBlocks: 100}.Validate())
+	assert.NoError(t, HistoryRetention{FullBlocks: 100}.Validate())
+}
+
+func TestReadOnlyBoltDatabase(t *testing.T) {
+	dirname, err := ioutil.TempDir(os.TempDir(), "ethdb_test_")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dirname)
+	file := path.Join(dirname, "db")
+
+	db, err := NewBoltDatabase(file)
+	assert.NoError(t, err)
+	assert.NoError(t, db.Put(dbutils.CurrentStateBucket, []byte("key"), []byte("value")))
+	db.Close()
+
+	db, err = OpenBoltDatabase(file, true)
+	assert.NoError(t, err)
+	defer db.Close()
+	v, err := db.Get(dbutils.CurrentStateBucket, []byte("key"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("value"), v)
+
+	err = db.Put(dbutils.CurrentStateBucket, []byte("key"), []byte("value2"))
+	assert.True(t, errors.Is(err, ErrTxReadOnly), err)
+	batch := db.NewBatch()
+	assert.NoError(t, batch.Put(dbutils.CurrentStateBucket, []byte("key"), []byte("value2")))
+	_, err = batch.Commit()
+	assert.True(t, errors.Is(err, ErrTxReadOnly), err)
+
+	v, err = db.Get(dbutils.CurrentStateBucket, []byte("key"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("value"), v)
+}
+
+func TestReadOnlyDatabase(t *testing.T) {
+	db := NewMemDatabase()
+	defer db.Close()
+	assert.NoError(t, db.Put(dbutils.CurrentStateBucket, []byte("key"), []byte("value")))
+	ro := NewReadOnlyDatabase(db)
+
+	err := ro.Put(dbutils.CurrentStateBucket, []byte("key"), []byte("value2"))
+	assert.True(t, errors.Is(err, ErrTxReadOnly), err)
+	err = ro.Delete(dbutils.CurrentStateBucket, []byte("key"))
+	assert.True(t, errors.Is(err, ErrTxReadOnly), err)
+
+	// The batch sees its own writes on top of the database, but can't commit them
+	batch := ro.NewBatch()
+	assert.NoError(t, batch.Put(dbutils.CurrentStateBucket, []byte("key"), []byte("value2")))
+	assert.NoError(t, batch.Put(dbutils.CurrentStateBucket, []byte("key2"), []byte("value3")))
+	v, err := batch.Get(dbutils.CurrentStateBucket, []byte("key"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("value2"), v)
+	_, err = batch.Commit()
+	assert.True(t, errors.Is(err, ErrTxReadOnly), err)
+	batch.Rollback()
+
+	v, err = db.Get(dbutils.CurrentStateBucket, []byte("key"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("value"), v)
+	_, err = db.Get(dbutils.CurrentStateBucket, []byte("key2"))
+	assert.True(t, errors.Is(err, ErrKeyNotFound), err)
+}
+
+func TestBoltNoSync(t *testing.T) {
+	dirname, err := ioutil.TempDir(os.TempDir(), "ethdb_test_")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dirname)
+	file := path.Join(dirname, "db")
+
+	db, err := NewBoltDatabaseWithOptions(file, BoltOptions{NoSync: true, FreelistMap: true, InitialMmapSize: 16 * 1024 * 1024})
+	assert.NoError(t, err)
+	assert.True(t, db.db.NoSync)
+	assert.NoError(t, db.Put(dbutils.CurrentStateBucket, []byte("key"), []byte("value")))
+
+	assert.NoError(t, EnableSync(db))
+	assert.False(t, db.db.NoSync)
+	assert.False(t, db.db.NoGrowSync)
+	// Repeated calls are no-op
+	assert.NoError(t, EnableSync(db))
+	assert.NoError(t, db.Put(dbutils.CurrentStateBucket, []byte("key2"), []byte("value2")))
+	db.Close()
+
+	db, err = NewBoltDatabase(file)
+	assert.NoError(t, err)
+	defer db.Close()
+	assert.False(t, db.db.NoSync)
+	v, err := db.Get(dbutils.CurrentStateBucket, []byte("key"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("value"), v)
+}
@@ -0,0 +1,46 @@
+package ethdb
+
+import (
+	"github.com/ledgerwatch/turbo-geth/common"
+)
+
+// deleteRangeBatchSize is the number of the records DeleteRange walks over before deleting them
+var deleteRangeBatchSize = 10000
+
+// DeleteRange deletes all the records of the bucket matching the Walk parameters. The pending writes of the
+// batches are taken into account (see MergedWalker). The records are walked and deleted in the portions
+// of deleteRangeBatchSize, so the large ranges are not collected in memory. onDelete, if not nil, is called
+// by the walk for every record before it is deleted, with the last value of the record. It must not write
+// into the bucket
+func DeleteRange(db Database, bucket, startkey []byte, fixedbits int, onDelete func(k, v []byte) error) error {
+	walk := db.Walk
+	if casted, ok := db.(MergedWalker); ok {
+		walk = casted.WalkMerged
+	}
+	start := startkey
+	for {
+		keys := make([][]byte, 0, deleteRangeBatchSize)
+		if err := walk(bucket, start, fixedbits, func(k, v []byte) (bool, error) {
+			k = common.CopyBytes(k)
+			if onDelete != nil {
+				if err := onDelete(k, common.CopyBytes(v)); err != nil {
+					return false, err
+				}
+			}
+			keys = append(keys, k)
+			return len(keys) < deleteRangeBatchSize, nil
+		}); err != nil {
+			return err
+		}
+		for _, k := range keys {
+			if err := db.Delete(bucket, k); err != nil {
+				return err
+			}
+		}
+		if len(keys) < deleteRangeBatchSize {
+			return nil
+		}
+		// The walk resumes right after the last deleted key, which has the fixed bits of startkey
+		start = append(keys[len(keys)-1], 0)
+	}
+}
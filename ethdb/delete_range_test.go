@@ -0,0 +1,71 @@
+package ethdb
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// deleteRecorder records the deletes in the order with the calls of onDelete
+type deleteRecorder struct {
+	*BoltDatabase
+	ops *[]string
+}
+
+func (db deleteRecorder) Delete(bucket, key []byte) error {
+	*db.ops = append(*db.ops, "delete "+string(key))
+	return db.BoltDatabase.Delete(bucket, key)
+}
+
+func TestDeleteRange(t *testing.T) {
+	defer func(size int) { deleteRangeBatchSize = size }(deleteRangeBatchSize)
+	deleteRangeBatchSize = 3
+
+	bucket := dbutils.CurrentStateBucket
+	db := NewMemDatabase()
+	defer db.Close()
+	for i := 0; i < 8; i++ {
+		require.NoError(t, db.Put(bucket, []byte(fmt.Sprintf("b%d", i)), []byte{byte(i)}))
+	}
+	require.NoError(t, db.Put(bucket, []byte("a"), []byte{10}))
+	require.NoError(t, db.Put(bucket, []byte("c"), []byte{11}))
+
+	var ops []string
+	require.NoError(t, DeleteRange(deleteRecorder{db, &ops}, bucket, []byte("b"), 8, func(k, v []byte) error {
+		ops = append(ops, fmt.Sprintf("walk %s %d", k, v[0]))
+		return nil
+	}))
+	// The records are deleted after every portion of the walk
+	assert.Equal(t, []string{
+		"walk b0 0", "walk b1 1", "walk b2 2", "delete b0", "delete b1", "delete b2",
+		"walk b3 3", "walk b4 4", "walk b5 5", "delete b3", "delete b4", "delete b5",
+		"walk b6 6", "walk b7 7", "delete b6", "delete b7",
+	}, ops)
+	var left []string
+	require.NoError(t, db.Walk(bucket, nil, 0, func(k, _ []byte) (bool, error) {
+		left = append(left, string(k))
+		return true, nil
+	}))
+	assert.Equal(t, []string{"a", "c"}, left)
+
+	// The pending writes of the batch are deleted too
+	batch := db.NewBatch()
+	for i := 0; i < 5; i++ {
+		require.NoError(t, batch.Put(bucket, []byte(fmt.Sprintf("b%d", i)), []byte{byte(i)}))
+	}
+	require.NoError(t, db.Put(bucket, []byte("b5"), []byte{5}))
+	var deleted int
+	require.NoError(t, DeleteRange(batch, bucket, []byte("b"), 8, func(_, _ []byte) error {
+		deleted++
+		return nil
+	}))
+	assert.Equal(t, 6, deleted)
+	_, err := batch.Commit()
+	require.NoError(t, err)
+	ok, err := db.Has(bucket, []byte("b5"))
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
@@ -0,0 +1,113 @@
+// Copyright 2020 The turbo-geth authors
+// This file is part of the turbo-geth library.
+//
+// The turbo-geth library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The turbo-geth library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the turbo-geth library. If not, see <http://www.gnu.org/licenses/>.
+
+package ethdb
+
+import (
+	"errors"
+
+	"github.com/dgraph-io/badger/v2"
+	"github.com/ledgerwatch/bolt"
+
+	"github.com/ledgerwatch/turbo-geth/ethdb/remote"
+	"github.com/ledgerwatch/turbo-geth/ethdb/remote/remotekv"
+)
+
+// Errors shared by all database providers (Bolt, Badger, Remote).
+// Provider-specific errors are translated into these, and context is attached by wrapping,
+// so callers must check them with errors.Is instead of comparing directly.
+var (
+	// ErrKeyNotFound is returned when key isn't found in the database.
+	ErrKeyNotFound = errors.New("db: key not found")
+
+	// ErrBucketNotFound is returned when bucket doesn't exist in the database.
+	ErrBucketNotFound = errors.New("db: bucket not found")
+
+	// ErrTxReadOnly is returned on attempt to modify data in read-only transaction or database.
+	ErrTxReadOnly = errors.New("db: transaction is read-only")
+
+	// ErrClosed is returned when database or transaction is already closed.
+	ErrClosed = errors.New("db: closed")
+)
+
+// IsNotFound returns true if err means that the key or the bucket is absent,
+// as opposed to a failure of the database itself.
+func IsNotFound(err error) bool {
+	return errors.Is(err, ErrKeyNotFound) || errors.Is(err, ErrBucketNotFound)
+}
+
+// boltErr translates errors of the bolt library into ethdb errors
+func boltErr(err error) error {
+	switch err {
+	case nil:
+		return nil
+	case bolt.ErrBucketNotFound:
+		return ErrBucketNotFound
+	case bolt.ErrTxNotWritable, bolt.ErrDatabaseReadOnly:
+		return ErrTxReadOnly
+	case bolt.ErrTxClosed, bolt.ErrDatabaseNotOpen:
+		return ErrClosed
+	default:
+		return err
+	}
+}
+
+// badgerErr translates errors of the badger library into ethdb errors
+func badgerErr(err error) error {
+	switch err {
+	case nil:
+		return nil
+	case badger.ErrKeyNotFound:
+		return ErrKeyNotFound
+	case badger.ErrReadOnlyTxn:
+		return ErrTxReadOnly
+	case badger.ErrDiscardedTxn:
+		return ErrClosed
+	default:
+		return err
+	}
+}
+
+// remoteErr restores ethdb errors received from the remote server by their codes, see remote.Error
+func remoteErr(err error) error {
+	var remoteError *remote.Error
+	if !errors.As(err, &remoteError) {
+		return err
+	}
+	var sentinel error
+	switch remoteError.Code {
+	case remotekv.ErrorCode_KEY_NOT_FOUND:
+		sentinel = ErrKeyNotFound
+	case remotekv.ErrorCode_BUCKET_NOT_FOUND:
+		sentinel = ErrBucketNotFound
+	case remotekv.ErrorCode_TX_READ_ONLY:
+		sentinel = ErrTxReadOnly
+	case remotekv.ErrorCode_CLOSED:
+		sentinel = ErrClosed
+	default:
+		return err
+	}
+	return &wrappedErr{sentinel: sentinel, msg: err.Error()}
+}
+
+// wrappedErr carries the original message of the remote error and matches the sentinel via errors.Is
+type wrappedErr struct {
+	sentinel error
+	msg      string
+}
+
+func (e *wrappedErr) Error() string { return e.msg }
+func (e *wrappedErr) Unwrap() error { return e.sentinel }
@@ -0,0 +1,282 @@
+package ethdb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/dgraph-io/badger/v2"
+	"github.com/ledgerwatch/bolt"
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/common/changeset"
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+	"github.com/ledgerwatch/turbo-geth/core/types/accounts"
+)
+
+// historyReader is the access to the state and history buckets needed by the historical lookups,
+// so that the same semantics (history index chunks, changesets by timestamp) apply to every backend.
+// Getters return nil values for the absent keys, seek returns nil key if there are no more keys in the bucket
+type historyReader interface {
+	stateGet(bucket, key []byte) ([]byte, error)
+	historyGet(bucket, key []byte) ([]byte, error)
+	historySeek(bucket, seek []byte) ([]byte, []byte, error)
+}
+
+// getAsOfWith looks up the value in the history first, then in the current state
+func getAsOfWith(r historyReader, bucket, hBucket, key []byte, timestamp uint64) ([]byte, error) {
+	if err := checkHistoryRetention(r, hBucket, timestamp); err != nil {
+		return nil, err
+	}
+	// Without the index the current state would be returned as the historical one
+	if err := checkHistoryIndexed(r, hBucket, key); err != nil {
+		return nil, err
+	}
+	v, err := findByHistoryWith(r, hBucket, key, timestamp)
+	if err == nil {
+		return common.CopyBytes(v), nil
+	}
+	if !errors.Is(err, ErrKeyNotFound) {
+		return nil, err
+	}
+	v, err = r.stateGet(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	if v == nil {
+		return nil, ErrKeyNotFound
+	}
+	return common.CopyBytes(v), nil
+}
+
+// findByHistoryWith finds the history index chunk of the key, containing the first change after the timestamp,
+// and returns the value from the changeset of that change (the value before the change)
+func findByHistoryWith(r historyReader, hBucket []byte, key []byte, timestamp uint64) ([]byte, error) {
+	var keyF []byte
+	if bytes.Equal(dbutils.StorageHistoryBucket, hBucket) {
+		keyF = make([]byte, len(key)-common.IncarnationLength)
+		copy(keyF, key[:common.HashLength])
+		copy(keyF[common.HashLength:], key[common.HashLength+common.IncarnationLength:])
+	} else {
+		keyF = common.CopyBytes(key)
+	}
+
+	k, v, err := r.historySeek(hBucket, dbutils.IndexChunkKey(key, timestamp))
+	if err != nil {
+		return nil, err
+	}
+	if k == nil || !bytes.HasPrefix(k, keyF) {
+		return nil, ErrKeyNotFound
+	}
+	index := dbutils.WrapHistoryIndex(v)
+
+	changeSetBlock, set, ok := index.Search(timestamp)
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	// set == true if this change was from empty record (non-existent account) to non-empty
+	// In such case, we do not need to examine changeSet and return empty data
+	if set {
+		return []byte{}, nil
+	}
+	csBucket := dbutils.ChangeSetByIndexBucket(hBucket)
+	changeSetData, err := r.historyGet(csBucket, dbutils.EncodeTimestamp(changeSetBlock))
+	if err != nil {
+		return nil, err
+	}
+	if changeSetData == nil {
+		// The changesets of the old blocks might have been compacted into epochs
+		if changeSetData, err = changeSetFromEpoch(r.historyGet, csBucket, changeSetBlock); err != nil {
+			return nil, err
+		}
+	}
+	if changeSetData == nil {
+		return nil, ErrKeyNotFound
+	}
+
+	var data []byte
+	switch {
+	case bytes.Equal(dbutils.AccountsHistoryBucket, hBucket):
+		data, err = changeset.AccountChangeSetBytes(changeSetData).FindLast(key)
+	case bytes.Equal(dbutils.StorageHistoryBucket, hBucket):
+		data, err = changeset.StorageChangeSetBytes(changeSetData).FindWithoutIncarnation(key[:common.HashLength], key[common.HashLength+common.IncarnationLength:])
+	}
+	if err != nil {
+		return nil, ErrKeyNotFound
+	}
+
+	//restore codehash
+	if bytes.Equal(dbutils.AccountsHistoryBucket, hBucket) {
+		var acc accounts.Account
+		if err := acc.DecodeForStorage(data); err != nil {
+			return nil, err
+		}
+		if acc.Incarnation > 0 && acc.IsEmptyCodeHash() {
+			codeHash, err := r.stateGet(dbutils.ContractCodeBucket, dbutils.GenerateStoragePrefix(key, acc.Incarnation))
+			if err != nil && !errors.Is(err, ErrBucketNotFound) {
+				return nil, err
+			}
+			if len(codeHash) > 0 {
+				acc.CodeHash = common.BytesToHash(codeHash)
+			}
+			data = make([]byte, acc.EncodingLengthForStorage())
+			acc.EncodeForStorage(data)
+		}
+		return data, nil
+	}
+
+	return data, nil
+}
+
+// changeSetFromEpoch returns the changeset of the block from the compacted epoch (see CompactChangeSets),
+// or nil if the block has not been compacted
+func changeSetFromEpoch(get func(bucket, key []byte) ([]byte, error), csBucket []byte, blockNr uint64) ([]byte, error) {
+	v, err := get(dbutils.ChangeSetEpochBucket(csBucket), dbutils.EncodeTimestamp(blockNr/changeset.EpochSize))
+	if err != nil || v == nil {
+		return nil, err
+	}
+	cs, ok, err := changeset.EpochBytes(v).Find(blockNr)
+	if err != nil || !ok {
+		return nil, err
+	}
+	return cs, nil
+}
+
+// GetChangeSetByBlock is BoltDatabase.GetChangeSetByBlock for any database, it returns nil if the block
+// has no changeset (the history is not stored or has been pruned)
+func GetChangeSetByBlock(db Getter, hBucket []byte, blockNr uint64) ([]byte, error) {
+	get := func(bucket, key []byte) ([]byte, error) {
+		v, err := db.Get(bucket, key)
+		if errors.Is(err, ErrKeyNotFound) || errors.Is(err, ErrBucketNotFound) {
+			return nil, nil
+		}
+		return v, err
+	}
+	csBucket := dbutils.ChangeSetByIndexBucket(hBucket)
+	v, err := get(csBucket, dbutils.EncodeTimestamp(blockNr))
+	if err != nil || v != nil {
+		return v, err
+	}
+	return changeSetFromEpoch(get, csBucket, blockNr)
+}
+
+// boltHistoryReader - state and history buckets may live in different databases, see SplitDatabase
+type boltHistoryReader struct {
+	stateTx, historyTx *bolt.Tx
+}
+
+func (r boltHistoryReader) stateGet(bucket, key []byte) ([]byte, error) {
+	bucket = stateBucket(r.stateTx, bucket, key)
+	b := r.stateTx.Bucket(bucket)
+	if b == nil {
+		return nil, fmt.Errorf("%w: %s", ErrBucketNotFound, bucket)
+	}
+	v, _ := b.Get(key)
+	return v, nil
+}
+
+func (r boltHistoryReader) historyGet(bucket, key []byte) ([]byte, error) {
+	b := r.historyTx.Bucket(bucket)
+	if b == nil {
+		return nil, nil
+	}
+	codec, err := bucketCodec(r.historyTx, bucket)
+	if err != nil {
+		return nil, err
+	}
+	v, _ := b.Get(key)
+	return decodeValue(codec, v)
+}
+
+func (r boltHistoryReader) historySeek(bucket, seek []byte) ([]byte, []byte, error) {
+	b := r.historyTx.Bucket(bucket)
+	if b == nil {
+		return nil, nil, nil
+	}
+	codec, err := bucketCodec(r.historyTx, bucket)
+	if err != nil {
+		return nil, nil, err
+	}
+	k, v := b.Cursor().Seek(seek)
+	v, err = decodeValue(codec, v)
+	return k, v, err
+}
+
+// kvHistoryReader works over the abstract KV, so the historical lookups are available
+// on any KV implementation, including the remote ones
+type kvHistoryReader struct {
+	tx Tx
+}
+
+func (r kvHistoryReader) stateGet(bucket, key []byte) ([]byte, error) {
+	if bytes.Equal(bucket, dbutils.CurrentStateBucket) {
+		split, err := IsSplitStateTx(r.tx)
+		if err != nil {
+			return nil, err
+		}
+		if split {
+			bucket = StateShard(key)
+		}
+	}
+	if exists, err := r.tx.ExistsBucket(bucket); err != nil {
+		return nil, err
+	} else if !exists {
+		return nil, fmt.Errorf("%w: %s", ErrBucketNotFound, bucket)
+	}
+	return r.tx.Bucket(bucket).Get(key)
+}
+
+func (r kvHistoryReader) historyGet(bucket, key []byte) ([]byte, error) {
+	if exists, err := r.tx.ExistsBucket(bucket); err != nil || !exists {
+		return nil, err
+	}
+	return r.tx.Bucket(bucket).Get(key)
+}
+
+func (r kvHistoryReader) historySeek(bucket, seek []byte) ([]byte, []byte, error) {
+	if exists, err := r.tx.ExistsBucket(bucket); err != nil || !exists {
+		return nil, nil, err
+	}
+	return r.tx.Bucket(bucket).Cursor().Seek(seek)
+}
+
+// GetAsOfTx returns the value valid as of a given timestamp, reading through the transaction of the abstract KV
+func GetAsOfTx(tx Tx, bucket, hBucket, key []byte, timestamp uint64) ([]byte, error) {
+	return getAsOfWith(kvHistoryReader{tx: tx}, bucket, hBucket, key, timestamp)
+}
+
+// badgerHistoryReader uses the key layout of BadgerDatabase (see bucketKey). The values are copied,
+// badger keeps them valid only inside the callback of item.Value
+type badgerHistoryReader struct {
+	txn *badger.Txn
+}
+
+func (r badgerHistoryReader) stateGet(bucket, key []byte) ([]byte, error) {
+	item, err := r.txn.Get(bucketKey(bucket, key))
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return item.ValueCopy(nil)
+}
+
+func (r badgerHistoryReader) historyGet(bucket, key []byte) ([]byte, error) {
+	return r.stateGet(bucket, key)
+}
+
+func (r badgerHistoryReader) historySeek(bucket, seek []byte) ([]byte, []byte, error) {
+	it := r.txn.NewIterator(badger.DefaultIteratorOptions)
+	defer it.Close()
+	prefix := bucketKey(bucket, nil)
+	it.Seek(bucketKey(bucket, seek))
+	if !it.ValidForPrefix(prefix) {
+		return nil, nil, nil
+	}
+	item := it.Item()
+	v, err := item.ValueCopy(nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	return item.KeyCopy(nil)[len(prefix):], v, nil
+}
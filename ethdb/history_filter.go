@@ -0,0 +1,177 @@
+package ethdb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+)
+
+// ErrHistoryNotIndexed is returned (wrapped into HistoryNotIndexedError) by GetAsOf for the accounts,
+// the history of which is excluded from the indexes by HistoryIndexFilter
+var ErrHistoryNotIndexed = errors.New("history not indexed")
+
+// HistoryNotIndexedError tells which key is excluded from the history index
+type HistoryNotIndexedError struct {
+	Bucket []byte // dbutils.AccountsHistoryBucket or dbutils.StorageHistoryBucket
+	Key    []byte
+}
+
+func (e *HistoryNotIndexedError) Error() string {
+	return fmt.Sprintf("%v: %s history of %x is excluded by the filter", ErrHistoryNotIndexed, e.Bucket, e.Key)
+}
+
+func (e *HistoryNotIndexedError) Unwrap() error {
+	return ErrHistoryNotIndexed
+}
+
+const (
+	historyIndexAllow byte = 0
+	historyIndexDeny  byte = 1
+)
+
+// HistoryIndexFilter restricts the history indexes (dbutils.AccountsHistoryBucket and dbutils.StorageHistoryBucket)
+// to the listed accounts (allowlist), or to all but the listed accounts (denylist). The changesets of all the accounts
+// are kept, so the unwinds are not affected. The filter is the encoded form kept in the database
+// (see dbutils.HistoryIndexFilterKey), the empty filter indexes all the accounts
+type HistoryIndexFilter []byte
+
+// NewHistoryIndexFilter creates the filter indexing only the history of the addresses, or all but them if deny is set
+func NewHistoryIndexFilter(addresses []common.Address, deny bool) (HistoryIndexFilter, error) {
+	hashes := make([]common.Hash, len(addresses))
+	for i, address := range addresses {
+		h, err := common.HashData(address[:])
+		if err != nil {
+			return nil, err
+		}
+		hashes[i] = h
+	}
+	sort.Slice(hashes, func(i, j int) bool { return bytes.Compare(hashes[i][:], hashes[j][:]) < 0 })
+	f := make(HistoryIndexFilter, 1, 1+len(hashes)*common.HashLength)
+	if deny {
+		f[0] = historyIndexDeny
+	}
+	for i, h := range hashes {
+		if i > 0 && h == hashes[i-1] {
+			continue
+		}
+		f = append(f, h[:]...)
+	}
+	return f, nil
+}
+
+// Indexed tells whether the history of the key is indexed. The key is the account hash or the storage key
+// starting with it, or their plain forms starting with the address (see dbutils.PlainStateBucket)
+func (f HistoryIndexFilter) Indexed(key []byte) bool {
+	if len(f) == 0 {
+		return true
+	}
+	switch len(key) {
+	case common.AddressLength, common.AddressLength + common.HashLength, common.AddressLength + common.IncarnationLength + common.HashLength:
+		addrHash, err := common.HashData(key[:common.AddressLength])
+		if err != nil {
+			return true
+		}
+		return f.listed(addrHash[:]) != f.deny()
+	}
+	if len(key) < common.HashLength {
+		return true
+	}
+	return f.listed(key[:common.HashLength]) != f.deny()
+}
+
+// Covers tells whether all the accounts indexed by the filter g are indexed by f
+func (f HistoryIndexFilter) Covers(g HistoryIndexFilter) bool {
+	switch {
+	case len(f) == 0:
+		return true
+	case len(g) == 0:
+		return false
+	case !f.deny() && !g.deny():
+		return f.hashesContain(g)
+	case f.deny() && g.deny():
+		return g.hashesContain(f)
+	case !f.deny():
+		// The denylist indexes the unlisted accounts, which are countless
+		return false
+	}
+	// The denied accounts must not be in the allowlist
+	for i := 0; i < f.len(); i++ {
+		if g.listed(f.hash(i)) {
+			return false
+		}
+	}
+	return true
+}
+
+func (f HistoryIndexFilter) deny() bool {
+	return f[0] == historyIndexDeny
+}
+
+func (f HistoryIndexFilter) len() int {
+	return (len(f) - 1) / common.HashLength
+}
+
+func (f HistoryIndexFilter) hash(i int) []byte {
+	return f[1+i*common.HashLength : 1+(i+1)*common.HashLength]
+}
+
+func (f HistoryIndexFilter) listed(addrHash []byte) bool {
+	n := f.len()
+	i := sort.Search(n, func(i int) bool { return bytes.Compare(f.hash(i), addrHash) >= 0 })
+	return i < n && bytes.Equal(f.hash(i), addrHash)
+}
+
+// hashesContain tells whether the accounts listed by g are listed by f
+func (f HistoryIndexFilter) hashesContain(g HistoryIndexFilter) bool {
+	for i := 0; i < g.len(); i++ {
+		if !f.listed(g.hash(i)) {
+			return false
+		}
+	}
+	return true
+}
+
+// ReadHistoryIndexFilter returns the filter of the history indexes, empty if all the accounts are indexed
+func ReadHistoryIndexFilter(db Getter) (HistoryIndexFilter, error) {
+	v, err := db.Get(dbutils.DatabaseInfoBucket, dbutils.HistoryIndexFilterKey)
+	if err != nil && !errors.Is(err, ErrKeyNotFound) {
+		return nil, err
+	}
+	return HistoryIndexFilter(common.CopyBytes(v)), nil
+}
+
+// WriteHistoryIndexFilter replaces the filter of the history indexes, the empty filter removes it.
+// The indexes are not rebuilt: the history of the accounts, which become indexed, starts with the next block.
+// Returns whether the filter has changed
+func WriteHistoryIndexFilter(db MinDatabase, f HistoryIndexFilter) (bool, error) {
+	v, err := db.Get(dbutils.DatabaseInfoBucket, dbutils.HistoryIndexFilterKey)
+	if err != nil && !errors.Is(err, ErrKeyNotFound) {
+		return false, err
+	}
+	if bytes.Equal(v, f) {
+		return false, nil
+	}
+	if len(f) == 0 {
+		return true, db.Delete(dbutils.DatabaseInfoBucket, dbutils.HistoryIndexFilterKey)
+	}
+	return true, db.Put(dbutils.DatabaseInfoBucket, dbutils.HistoryIndexFilterKey, f)
+}
+
+// checkHistoryIndexed returns HistoryNotIndexedError if the history of the key is excluded from the index
+func checkHistoryIndexed(r historyReader, hBucket, key []byte) error {
+	v, err := r.stateGet(dbutils.DatabaseInfoBucket, dbutils.HistoryIndexFilterKey)
+	if err != nil {
+		if errors.Is(err, ErrBucketNotFound) {
+			return nil
+		}
+		return err
+	}
+	if !HistoryIndexFilter(v).Indexed(key) {
+		return &HistoryNotIndexedError{Bucket: hBucket, Key: common.CopyBytes(key)}
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package ethdb
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+	"github.com/ledgerwatch/turbo-geth/crypto"
+)
+
+func TestHistoryIndexFilter(t *testing.T) {
+	a1, a2, a3 := common.HexToAddress("0x1"), common.HexToAddress("0x2"), common.HexToAddress("0x3")
+	h1, h2, h3 := crypto.Keccak256(a1[:]), crypto.Keccak256(a2[:]), crypto.Keccak256(a3[:])
+	storageKey := append(append(common.CopyBytes(h2), make([]byte, common.IncarnationLength)...), make([]byte, common.HashLength)...)
+
+	allow, err := NewHistoryIndexFilter([]common.Address{a2, a1, a2}, false)
+	require.NoError(t, err)
+	assert.Equal(t, 1+2*common.HashLength, len(allow))
+	assert.True(t, allow.Indexed(h1))
+	assert.True(t, allow.Indexed(h2))
+	assert.True(t, allow.Indexed(storageKey))
+	assert.False(t, allow.Indexed(h3))
+
+	deny, err := NewHistoryIndexFilter([]common.Address{a2}, true)
+	require.NoError(t, err)
+	assert.True(t, deny.Indexed(h1))
+	assert.False(t, deny.Indexed(h2))
+	assert.False(t, deny.Indexed(storageKey))
+	assert.True(t, deny.Indexed(h3))
+
+	assert.True(t, HistoryIndexFilter(nil).Indexed(h3))
+
+	// The plain keys are filtered by the hashes of the addresses
+	plainStorageKey := dbutils.PlainGenerateCompositeStorageKey(a2, 1, common.Hash{})
+	assert.True(t, allow.Indexed(a1[:]))
+	assert.True(t, allow.Indexed(plainStorageKey))
+	assert.False(t, allow.Indexed(a3[:]))
+	assert.False(t, deny.Indexed(plainStorageKey))
+	assert.False(t, deny.Indexed(append(common.CopyBytes(a2[:]), make([]byte, common.HashLength)...)))
+}
+
+func TestHistoryIndexFilterCovers(t *testing.T) {
+	a1, a2, a3 := common.HexToAddress("0x1"), common.HexToAddress("0x2"), common.HexToAddress("0x3")
+	filter := func(deny bool, addresses ...common.Address) HistoryIndexFilter {
+		f, err := NewHistoryIndexFilter(addresses, deny)
+		require.NoError(t, err)
+		return f
+	}
+	for i, tt := range []struct {
+		f, g   HistoryIndexFilter
+		covers bool
+	}{
+		{nil, filter(false, a1), true},
+		{filter(false, a1), nil, false},
+		{filter(false, a1, a2), filter(false, a2), true},
+		{filter(false, a2), filter(false, a1, a2), false},
+		{filter(true, a1), filter(true, a1, a2), true},
+		{filter(true, a1, a2), filter(true, a1), false},
+		{filter(false, a1, a2, a3), filter(true, a1), false},
+		{filter(true, a1), filter(false, a2, a3), true},
+		{filter(true, a1), filter(false, a1, a2), false},
+	} {
+		assert.Equal(t, tt.covers, tt.f.Covers(tt.g), "case %d", i)
+	}
+}
+
+func TestGetAsOfNotIndexed(t *testing.T) {
+	db := NewMemDatabase()
+	defer db.Close()
+	a1, a2 := common.HexToAddress("0x1"), common.HexToAddress("0x2")
+	h1, h2 := crypto.Keccak256(a1[:]), crypto.Keccak256(a2[:])
+	require.NoError(t, db.Put(dbutils.CurrentStateBucket, h1, []byte{1}))
+	require.NoError(t, db.Put(dbutils.CurrentStateBucket, h2, []byte{2}))
+
+	filter, err := NewHistoryIndexFilter([]common.Address{a1}, false)
+	require.NoError(t, err)
+	changed, err := WriteHistoryIndexFilter(db, filter)
+	require.NoError(t, err)
+	assert.True(t, changed)
+	changed, err = WriteHistoryIndexFilter(db, filter)
+	require.NoError(t, err)
+	assert.False(t, changed)
+
+	v, err := db.GetAsOf(dbutils.CurrentStateBucket, dbutils.AccountsHistoryBucket, h1, 1)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{1}, v)
+
+	_, err = db.GetAsOf(dbutils.CurrentStateBucket, dbutils.AccountsHistoryBucket, h2, 1)
+	assert.True(t, errors.Is(err, ErrHistoryNotIndexed))
+	var notIndexed *HistoryNotIndexedError
+	require.True(t, errors.As(err, &notIndexed))
+	assert.Equal(t, h2, notIndexed.Key)
+
+	// Without the filter everything is indexed again
+	changed, err = WriteHistoryIndexFilter(db, nil)
+	require.NoError(t, err)
+	assert.True(t, changed)
+	stored, err := ReadHistoryIndexFilter(db)
+	require.NoError(t, err)
+	assert.Empty(t, stored)
+	v, err = db.GetAsOf(dbutils.CurrentStateBucket, dbutils.AccountsHistoryBucket, h2, 1)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{2}, v)
+}
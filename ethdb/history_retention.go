@@ -0,0 +1,263 @@
+package ethdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/common/changeset"
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+	"github.com/ledgerwatch/turbo-geth/log"
+)
+
+// ErrHistoryPruned is returned (wrapped into HistoryPrunedError) by GetAsOf for the blocks,
+// the history of which has been deleted by the retention policy
+var ErrHistoryPruned = errors.New("history pruned")
+
+// HistoryPrunedError tells which history has been pruned up to which block
+type HistoryPrunedError struct {
+	Bucket    []byte // dbutils.AccountsHistoryBucket or dbutils.StorageHistoryBucket
+	Timestamp uint64 // The requested block
+	PrunedTo  uint64 // The history of the blocks up to (and including) this one is deleted
+}
+
+func (e *HistoryPrunedError) Error() string {
+	return fmt.Sprintf("%v: %s history is only available after block %d, requested %d", ErrHistoryPruned, e.Bucket, e.PrunedTo, e.Timestamp)
+}
+
+func (e *HistoryPrunedError) Unwrap() error {
+	return ErrHistoryPruned
+}
+
+// HistoryRetention is the policy of keeping the history: the full history (accounts and storage) is kept
+// for the last FullBlocks blocks, only the account history - for the last AccountBlocks blocks,
+// and nothing older than that. Zero means keeping the corresponding history forever (archive mode)
+type HistoryRetention struct {
+	FullBlocks    uint64
+	AccountBlocks uint64
+}
+
+// Enabled tells whether any history is going to be pruned
+func (r HistoryRetention) Enabled() bool {
+	return r.FullBlocks > 0 || r.AccountBlocks > 0
+}
+
+// Validate checks that the account history is not kept shorter than the storage history
+func (r HistoryRetention) Validate() error {
+	if r.AccountBlocks > 0 && r.AccountBlocks < r.FullBlocks {
+		return fmt.Errorf("account history retention (%d blocks) must not be shorter than the full history retention (%d blocks)", r.AccountBlocks, r.FullBlocks)
+	}
+	if r.AccountBlocks > 0 && r.FullBlocks == 0 {
+		return fmt.Errorf("storage history cannot be kept forever when the account history is pruned")
+	}
+	return nil
+}
+
+// PruneTargets returns the last blocks, the storage and the account history of which has to be deleted
+// with the given head of the chain. ok == false if there is nothing to delete
+func (r HistoryRetention) PruneTargets(head uint64) (storageTo uint64, storageOk bool, accountTo uint64, accountOk bool) {
+	if r.FullBlocks > 0 && head > r.FullBlocks {
+		storageTo, storageOk = head-r.FullBlocks, true
+	}
+	if r.AccountBlocks > 0 && head > r.AccountBlocks {
+		accountTo, accountOk = head-r.AccountBlocks, true
+	}
+	return storageTo, storageOk, accountTo, accountOk
+}
+
+func historyPrunedToKey(hBucket []byte) []byte {
+	if bytes.Equal(hBucket, dbutils.StorageHistoryBucket) {
+		return dbutils.StorageHistoryPrunedToKey
+	}
+	return dbutils.AccountHistoryPrunedToKey
+}
+
+// ReadHistoryPrunedTo returns the last block, the history of which has been deleted by PruneHistory.
+// ok == false if the history has never been pruned
+func ReadHistoryPrunedTo(db Getter, hBucket []byte) (uint64, bool, error) {
+	v, err := db.Get(dbutils.DatabaseInfoBucket, historyPrunedToKey(hBucket))
+	if err != nil && !errors.Is(err, ErrKeyNotFound) {
+		return 0, false, err
+	}
+	if len(v) != 8 {
+		return 0, false, nil
+	}
+	return binary.BigEndian.Uint64(v), true, nil
+}
+
+// checkHistoryRetention returns HistoryPrunedError if the history required to look up the value
+// as of the timestamp has been pruned
+func checkHistoryRetention(r historyReader, hBucket []byte, timestamp uint64) error {
+	v, err := r.stateGet(dbutils.DatabaseInfoBucket, historyPrunedToKey(hBucket))
+	if err != nil {
+		if errors.Is(err, ErrBucketNotFound) {
+			return nil
+		}
+		return err
+	}
+	if len(v) != 8 {
+		return nil
+	}
+	// The value as of the timestamp is in the changeset of the first change at or after the timestamp
+	if prunedTo := binary.BigEndian.Uint64(v); timestamp <= prunedTo {
+		return &HistoryPrunedError{Bucket: hBucket, Timestamp: timestamp, PrunedTo: prunedTo}
+	}
+	return nil
+}
+
+// PruneHistory deletes the changesets (including the compacted epochs, see CompactChangeSets) of the blocks
+// up to toBlock (inclusive) and removes these blocks from the history index (dbutils.AccountsHistoryBucket
+// or dbutils.StorageHistoryBucket). The boundary is recorded first, so that GetAsOf returns HistoryPrunedError
+// instead of the incomplete history while the pruning is in progress
+func PruneHistory(db Database, hBucket []byte, toBlock uint64) error {
+	prunedTo, pruned, err := ReadHistoryPrunedTo(db, hBucket)
+	if err != nil {
+		return err
+	}
+	var from uint64
+	if pruned {
+		if toBlock <= prunedTo {
+			return nil
+		}
+		from = prunedTo + 1
+	}
+	v := make([]byte, 8)
+	binary.BigEndian.PutUint64(v, toBlock)
+	if err = db.Put(dbutils.DatabaseInfoBucket, historyPrunedToKey(hBucket), v); err != nil {
+		return err
+	}
+
+	csBucket := dbutils.ChangeSetByIndexBucket(hBucket)
+	// One epoch at a time, so that the keys of the changed accounts fit into memory
+	for epoch := from / changeset.EpochSize; epoch*changeset.EpochSize <= toBlock; epoch++ {
+		stepFrom, stepTo := epoch*changeset.EpochSize, (epoch+1)*changeset.EpochSize-1
+		if stepFrom < from {
+			stepFrom = from
+		}
+		if stepTo > toBlock {
+			stepTo = toBlock
+		}
+		if err = pruneHistoryStep(db, hBucket, csBucket, epoch, stepFrom, stepTo); err != nil {
+			return err
+		}
+	}
+	log.Info("Pruned history", "bucket", string(hBucket), "from", from, "to", toBlock)
+	return nil
+}
+
+func pruneHistoryStep(db Database, hBucket, csBucket []byte, epoch, from, to uint64) error {
+	keys := make(map[string]struct{})
+	collect := func(cs []byte) error {
+		walker := func(k, _ []byte) error {
+			keys[string(dbutils.CompositeKeyWithoutIncarnation(k))] = struct{}{}
+			return nil
+		}
+		if bytes.Equal(csBucket, dbutils.StorageChangeSetBucket) {
+			return changeset.StorageChangeSetBytes(cs).Walk(walker)
+		}
+		return changeset.AccountChangeSetBytes(cs).Walk(walker)
+	}
+
+	var csKeys [][]byte
+	if err := db.Walk(csBucket, dbutils.EncodeTimestamp(from), 0, func(k, v []byte) (bool, error) {
+		blockNr, _ := dbutils.DecodeTimestamp(k)
+		if blockNr > to {
+			return false, nil
+		}
+		csKeys = append(csKeys, common.CopyBytes(k))
+		return true, collect(v)
+	}); err != nil {
+		return err
+	}
+	epochBucket := dbutils.ChangeSetEpochBucket(csBucket)
+	epochKey := dbutils.EncodeTimestamp(epoch)
+	epochData, err := db.Get(epochBucket, epochKey)
+	if err != nil && !IsNotFound(err) {
+		return err
+	}
+	if epochData != nil {
+		if err = changeset.EpochBytes(epochData).Walk(func(blockNr uint64, cs []byte) error {
+			if blockNr < from || blockNr > to {
+				return nil
+			}
+			return collect(cs)
+		}); err != nil {
+			return err
+		}
+	}
+
+	batch := db.NewBatch()
+	defer batch.Rollback()
+	for k := range keys {
+		if err = trimHistoryIndex(db, batch, hBucket, []byte(k), to); err != nil {
+			return err
+		}
+		if batch.BatchSize() >= batch.IdealBatchSize() {
+			if _, err = batch.Commit(); err != nil {
+				return err
+			}
+		}
+	}
+	for _, k := range csKeys {
+		if err = batch.Delete(csBucket, k); err != nil {
+			return err
+		}
+	}
+	// The epoch is deleted once all of its blocks are pruned
+	if epochData != nil && to == (epoch+1)*changeset.EpochSize-1 {
+		if err = batch.Delete(epochBucket, epochKey); err != nil {
+			return err
+		}
+	}
+	_, err = batch.Commit()
+	return err
+}
+
+// trimHistoryIndex removes the blocks up to toBlock from the history index of the key (without the incarnation).
+// The chunks consisting of such blocks only are deleted, the first chunk after them is rewritten
+func trimHistoryIndex(db Getter, batch DbWithPendingMutations, hBucket, key []byte, toBlock uint64) error {
+	var toDelete [][]byte
+	var rewriteKey, rewriteValue []byte
+	startkey := make([]byte, len(key)+8)
+	copy(startkey, key)
+	if err := db.Walk(hBucket, startkey, 8*len(key), func(k, v []byte) (bool, error) {
+		index := dbutils.WrapHistoryIndex(v)
+		last, ok := index.LastElement()
+		if !ok {
+			return false, nil
+		}
+		if last <= toBlock {
+			toDelete = append(toDelete, common.CopyBytes(k))
+			return true, nil
+		}
+		numbers, sets, err := index.Decode()
+		if err != nil {
+			return false, err
+		}
+		if numbers[0] > toBlock {
+			return false, nil
+		}
+		trimmed := dbutils.NewHistoryIndex()
+		for i, n := range numbers {
+			if n > toBlock {
+				trimmed = trimmed.Append(n, sets[i])
+			}
+		}
+		// The last element is kept, so is the key of the chunk
+		rewriteKey, rewriteValue = common.CopyBytes(k), trimmed
+		return false, nil
+	}); err != nil {
+		return err
+	}
+	for _, k := range toDelete {
+		if err := batch.Delete(hBucket, k); err != nil {
+			return err
+		}
+	}
+	if rewriteKey != nil {
+		return batch.Put(hBucket, rewriteKey, rewriteValue)
+	}
+	return nil
+}
@@ -24,9 +24,6 @@ import (
 
 // DESCRIBED: For info on database buckets see docs/programmers_guide/db_walkthrough.MD
 
-// ErrKeyNotFound is returned when key isn't found in the database.
-var ErrKeyNotFound = errors.New("db: key not found")
-
 // Putter wraps the database write operations.
 type Putter interface {
 	// Put inserts or updates a single entry.
@@ -124,6 +121,22 @@ type HasKV interface {
 	KV() *bolt.DB
 }
 
+// HasHistoryKV is implemented by the databases keeping some buckets in the second Bolt database, see SplitDatabase.
+// The users of KV have to read such buckets from HistoryKV, or use the abstract KV, which routes them
+type HasHistoryKV interface {
+	HasKV
+	HistoryKV() *bolt.DB
+	IsHistoryBucket(bucket []byte) bool
+}
+
+// BoltKVOf returns the Bolt database keeping the bucket
+func BoltKVOf(db HasKV, bucket []byte) *bolt.DB {
+	if split, ok := db.(HasHistoryKV); ok && split.IsHistoryBucket(bucket) {
+		return split.HistoryKV()
+	}
+	return db.KV()
+}
+
 type HasAbstractKV interface {
 	AbstractKV() KV
 }
@@ -132,4 +145,10 @@ type HasNetInterface interface {
 	DB() Database
 }
 
+// MergedWalker is implemented by the batches, which can walk over the database together with the pending writes
+type MergedWalker interface {
+	// WalkMerged is Walk, which also sees the writes not yet committed into the database
+	WalkMerged(bucket, startkey []byte, fixedbits int, walker func([]byte, []byte) (bool, error)) error
+}
+
 var errNotSupported = errors.New("not supported")
@@ -4,8 +4,14 @@ import (
 	"context"
 )
 
+// KV is the key-value storage with the managed transactions. The context of the transaction is checked by every
+// operation and every move of the cursors, so the cancellation interrupts even the long walks
 type KV interface {
+	// View runs f in the read-only transaction. Once ctx is done, the operations of the transaction fail,
+	// and View returns ctx.Err(), even if f has not reported the failure
 	View(ctx context.Context, f func(tx Tx) error) (err error)
+	// Update runs f in the writable transaction and commits it if f succeeds. The transaction is rolled back,
+	// and Update returns ctx.Err(), if ctx is done before f returns
 	Update(ctx context.Context, f func(tx Tx) error) (err error)
 	Close()
 
@@ -14,6 +20,17 @@ type KV interface {
 
 type Tx interface {
 	Bucket(name []byte) Bucket
+	// CreateBucket creates the bucket, unless it exists already
+	CreateBucket(name []byte) error
+	// ExistsBucket tells whether the bucket exists
+	ExistsBucket(name []byte) (bool, error)
+	// DropBucket deletes the bucket together with its contents, dropping of the missing bucket does nothing
+	DropBucket(name []byte) error
+	// Savepoint marks the current state of the transaction, the writes made after it can be undone by RollbackTo
+	// without aborting the whole transaction. The backends without the nested transactions emulate the savepoints
+	Savepoint() (Savepoint, error)
+	// RollbackTo undoes the writes made after the savepoint. The savepoint stays valid, the later ones are discarded
+	RollbackTo(sp Savepoint) error
 
 	Commit(ctx context.Context) error
 	Rollback() error
@@ -37,13 +54,28 @@ type Cursor interface {
 	SeekTo(seek []byte) ([]byte, []byte, error)
 	Next() ([]byte, []byte, error)
 	Walk(walker func(k, v []byte) (bool, error)) error
+
+	// UnsafeValue returns the value at the current position without copying it. The value is only valid
+	// until the next move of the cursor or the end of the transaction, and must not be modified
+	UnsafeValue() ([]byte, error)
+}
+
+// ReverseCursor is implemented by the cursors, which can step back (the local Bolt ones).
+// Prev moves to the entry preceding the current one, or to the last entry if the cursor has passed the end
+type ReverseCursor interface {
+	Prev() ([]byte, []byte, error)
 }
 
 type NoValuesCursor interface {
 	First() ([]byte, uint32, error)
 	Seek(seek []byte) ([]byte, uint32, error)
+	SeekTo(seek []byte) ([]byte, uint32, error)
 	Next() ([]byte, uint32, error)
 	Walk(walker func(k []byte, vSize uint32) (bool, error)) error
+
+	// UnsafeValue reads the value at the current position only when it is needed, see Cursor.UnsafeValue.
+	// The remote cursors do not receive the values, so they fetch it with a separate request
+	UnsafeValue() ([]byte, error)
 }
 
 type DbProvider uint8
@@ -53,3 +85,12 @@ const (
 	Badger
 	Remote
 )
+
+// txCtxErr is the result of the function of the managed transaction: ctx.Err() takes precedence over its error,
+// so that the transaction cancelled midway is rolled back and reports the cancellation, not the failed operation
+func txCtxErr(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	return err
+}
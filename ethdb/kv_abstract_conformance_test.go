@@ -0,0 +1,573 @@
+package ethdb_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+	"github.com/ledgerwatch/turbo-geth/ethdb/remote/remotedbserver"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// kvProvider is a KV under test: the data is written into `write`, and is read back through `read`,
+// which is the same database for the local providers, and a client of it for the remote ones
+type kvProvider struct {
+	name  string
+	write ethdb.KV
+	read  ethdb.KV
+}
+
+func (p kvProvider) local() bool {
+	return p.write == p.read
+}
+
+// setupKVProviders opens every KV provider, the returned function closes them
+func setupKVProviders(t *testing.T) ([]kvProvider, func()) {
+	ctx := context.Background()
+
+	boltDB := ethdb.NewBolt().InMem().MustOpen(ctx)
+	badgerDB := ethdb.NewBadger().InMem().MustOpen(ctx)
+	remoteServerDB := ethdb.NewBolt().InMem().MustOpen(ctx)
+	grpcServerDB := ethdb.NewBolt().InMem().MustOpen(ctx)
+
+	grpcListener := bufconn.Listen(1024 * 1024)
+	grpcServer := remotedbserver.NewGrpcServer(grpcServerDB)
+	go func() {
+		_ = grpcServer.Serve(grpcListener)
+	}()
+	grpcDB := ethdb.NewGrpcRemote().Path("bufnet").DialOptions(grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+		return grpcListener.Dial()
+	})).MustOpen(ctx)
+
+	serverIn, clientOut := io.Pipe()
+	clientIn, serverOut := io.Pipe()
+	remoteDB := ethdb.NewRemote().InMem(clientIn, clientOut).MustOpen(ctx)
+	serverCtx, serverCancel := context.WithCancel(ctx)
+	go func() {
+		_ = remotedbserver.Server(serverCtx, remoteServerDB, serverIn, serverOut, nil)
+	}()
+
+	providers := []kvProvider{
+		{name: "bolt", write: boltDB, read: boltDB},
+		{name: "badger", write: badgerDB, read: badgerDB},
+		{name: "remote", write: remoteServerDB, read: remoteDB},
+		{name: "grpc", write: grpcServerDB, read: grpcDB},
+	}
+	return providers, func() {
+		remoteDB.Close()
+		grpcDB.Close()
+		grpcServer.Stop()
+		serverIn.Close()
+		serverOut.Close()
+		clientIn.Close()
+		clientOut.Close()
+		serverCancel()
+		for _, p := range providers {
+			p.write.Close()
+		}
+	}
+}
+
+func TestKVConformance(t *testing.T) {
+	providers, closeAll := setupKVProviders(t)
+	defer closeAll()
+
+	for _, p := range providers {
+		p := p
+		t.Run("key order "+p.name, func(t *testing.T) {
+			testKeyOrder(t, p)
+		})
+		t.Run("seek deleted "+p.name, func(t *testing.T) {
+			testSeekDeleted(t, p)
+		})
+		t.Run("bucket collisions "+p.name, func(t *testing.T) {
+			testBucketCollisions(t, p)
+		})
+		t.Run("large values "+p.name, func(t *testing.T) {
+			testLargeValues(t, p)
+		})
+		t.Run("bucket management "+p.name, func(t *testing.T) {
+			testBucketManagement(t, p)
+		})
+		t.Run("unsafe values "+p.name, func(t *testing.T) {
+			testUnsafeValues(t, p)
+		})
+		t.Run("not found "+p.name, func(t *testing.T) {
+			testNotFound(t, p)
+		})
+		if !p.local() {
+			// Remote providers are read-only and don't support managed transactions
+			continue
+		}
+		t.Run("empty values "+p.name, func(t *testing.T) {
+			testEmptyValues(t, p.write)
+		})
+		t.Run("snapshot isolation "+p.name, func(t *testing.T) {
+			testSnapshotIsolation(t, p.write)
+		})
+		t.Run("concurrent readers "+p.name, func(t *testing.T) {
+			testConcurrentReaders(t, p.write)
+		})
+		t.Run("savepoints "+p.name, func(t *testing.T) {
+			testSavepoints(t, p.write)
+		})
+	}
+}
+
+func putAll(t *testing.T, db ethdb.KV, bucket []byte, pairs ...[]byte) {
+	require.NoError(t, db.Update(context.Background(), func(tx ethdb.Tx) error {
+		b := tx.Bucket(bucket)
+		for i := 0; i < len(pairs); i += 2 {
+			if err := b.Put(pairs[i], pairs[i+1]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+}
+
+// collect walks the cursor from the seek key (from the first key if seek is nil), copying the keys
+func collect(t *testing.T, c ethdb.Cursor, seek []byte) [][]byte {
+	var keys [][]byte
+	var k []byte
+	var err error
+	if seek == nil {
+		k, _, err = c.First()
+	} else {
+		k, _, err = c.Seek(seek)
+	}
+	for ; k != nil; k, _, err = c.Next() {
+		require.NoError(t, err)
+		keys = append(keys, copyBytes(k))
+	}
+	require.NoError(t, err)
+	return keys
+}
+
+func copyBytes(b []byte) []byte {
+	return append([]byte{}, b...)
+}
+
+func testKeyOrder(t *testing.T, p kvProvider) {
+	bucket := dbutils.CurrentStateBucket
+	putAll(t, p.write, bucket,
+		[]byte{0xff}, []byte{1},
+		[]byte{0x01, 0x00}, []byte{2},
+		[]byte{0x01}, []byte{3},
+		[]byte{0x00, 0xff, 0xff}, []byte{4},
+		[]byte{0x01, 0x00, 0x00}, []byte{5},
+		[]byte{0xff, 0x00}, []byte{6},
+	)
+	expected := [][]byte{{0x00, 0xff, 0xff}, {0x01}, {0x01, 0x00}, {0x01, 0x00, 0x00}, {0xff}, {0xff, 0x00}}
+
+	require.NoError(t, p.read.View(context.Background(), func(tx ethdb.Tx) error {
+		b := tx.Bucket(bucket)
+		assert.Equal(t, expected, collect(t, b.Cursor(), nil))
+		assert.Equal(t, expected[1:], collect(t, b.Cursor(), []byte{0x00, 0xff, 0xff, 0x00}))
+		assert.Equal(t, expected[4:], collect(t, b.Cursor(), []byte{0x01, 0x00, 0x00, 0x00}))
+		assert.Equal(t, [][]byte{{0x01}, {0x01, 0x00}, {0x01, 0x00, 0x00}}, collect(t, b.Cursor().Prefix([]byte{0x01}), nil))
+
+		// Next after the end keeps returning nil key
+		c := b.Cursor()
+		k, _, err := c.Seek([]byte{0xff, 0x00})
+		require.NoError(t, err)
+		assert.Equal(t, []byte{0xff, 0x00}, k)
+		for i := 0; i < 2; i++ {
+			k, _, err = c.Next()
+			require.NoError(t, err)
+			assert.Nil(t, k)
+		}
+		return nil
+	}))
+}
+
+func testSeekDeleted(t *testing.T, p kvProvider) {
+	bucket := dbutils.ContractCodeBucket
+	putAll(t, p.write, bucket,
+		[]byte{1}, []byte{1},
+		[]byte{2}, []byte{2},
+		[]byte{2, 1}, []byte{2},
+		[]byte{3}, []byte{3},
+	)
+	require.NoError(t, p.write.Update(context.Background(), func(tx ethdb.Tx) error {
+		b := tx.Bucket(bucket)
+		require.NoError(t, b.Delete([]byte{2}))
+		require.NoError(t, b.Delete([]byte{2, 1}))
+		if p.local() {
+			// Deletes are visible to the cursors of the same transaction
+			assert.Equal(t, [][]byte{{3}}, collect(t, b.Cursor(), []byte{2}))
+		}
+		return nil
+	}))
+	require.NoError(t, p.read.View(context.Background(), func(tx ethdb.Tx) error {
+		b := tx.Bucket(bucket)
+		assert.Equal(t, [][]byte{{3}}, collect(t, b.Cursor(), []byte{2}))
+		assert.Equal(t, [][]byte{{1}, {3}}, collect(t, b.Cursor(), nil))
+		v, err := b.Get([]byte{2})
+		require.NoError(t, err)
+		assert.Nil(t, v)
+
+		k, vSize, err := b.Cursor().NoValues().Seek([]byte{1, 0})
+		require.NoError(t, err)
+		assert.Equal(t, []byte{3}, k)
+		assert.Equal(t, uint32(1), vSize)
+		return nil
+	}))
+}
+
+func testBucketCollisions(t *testing.T, p kvProvider) {
+	// Name of the first bucket is the prefix of the name of the second one
+	short, long := dbutils.HeaderPrefix, dbutils.AccountsHistoryBucket
+	require.True(t, bytes.HasPrefix(long, short))
+	suffix := long[len(short):]
+
+	putAll(t, p.write, short, []byte{1}, []byte("short"))
+	putAll(t, p.write, long, []byte{1}, []byte("long"), []byte{2}, []byte("long"))
+
+	require.NoError(t, p.read.View(context.Background(), func(tx ethdb.Tx) error {
+		b := tx.Bucket(short)
+		assert.Equal(t, [][]byte{{1}}, collect(t, b.Cursor(), nil))
+		v, err := b.Get(append(copyBytes(suffix), 1))
+		require.NoError(t, err)
+		assert.Nil(t, v)
+		assert.Nil(t, collect(t, b.Cursor(), suffix))
+
+		b = tx.Bucket(long)
+		assert.Equal(t, [][]byte{{1}, {2}}, collect(t, b.Cursor(), nil))
+		v, err = b.Get([]byte{1})
+		require.NoError(t, err)
+		assert.Equal(t, []byte("long"), v)
+		return nil
+	}))
+}
+
+func testBucketManagement(t *testing.T, p kvProvider) {
+	ctx := context.Background()
+	name := []byte("conformanceBucket")
+	exists := func() bool {
+		var ok bool
+		require.NoError(t, p.read.View(ctx, func(tx ethdb.Tx) error {
+			var err error
+			ok, err = tx.ExistsBucket(name)
+			return err
+		}))
+		return ok
+	}
+
+	assert.False(t, exists())
+	require.NoError(t, p.write.Update(ctx, func(tx ethdb.Tx) error {
+		if err := tx.CreateBucket(name); err != nil {
+			return err
+		}
+		// Creation of the existing bucket
+		if err := tx.CreateBucket(name); err != nil {
+			return err
+		}
+		return tx.Bucket(name).Put([]byte{1}, []byte{1})
+	}))
+	assert.True(t, exists())
+
+	if !p.local() {
+		err := p.read.View(ctx, func(tx ethdb.Tx) error {
+			return tx.DropBucket(name)
+		})
+		assert.True(t, errors.Is(err, ethdb.ErrTxReadOnly))
+	}
+
+	require.NoError(t, p.write.Update(ctx, func(tx ethdb.Tx) error {
+		if err := tx.DropBucket(name); err != nil {
+			return err
+		}
+		// Dropping of the missing bucket
+		return tx.DropBucket(name)
+	}))
+	assert.False(t, exists())
+}
+
+// testNotFound checks that the missing keys and buckets are reported by the shared errors of ethdb,
+// which the remote providers restore from the codes sent by the server
+func testNotFound(t *testing.T, p kvProvider) {
+	bucket := dbutils.CurrentStateBucket
+	missingBucket := []byte("conformanceMissingBucket")
+	putAll(t, p.write, bucket, []byte{1}, []byte{1})
+
+	db := ethdb.NewRemoteBoltDatabase(p.read)
+	_, err := db.Get(bucket, []byte{2})
+	assert.True(t, errors.Is(err, ethdb.ErrKeyNotFound), "%v", err)
+	assert.True(t, ethdb.IsNotFound(err))
+
+	_, err = db.Get(missingBucket, []byte{1})
+	assert.True(t, ethdb.IsNotFound(err), "%v", err)
+
+	if p.local() {
+		return
+	}
+	// Remote providers open the bucket on the server side, so its absence comes over the wire
+	assert.True(t, errors.Is(err, ethdb.ErrBucketNotFound), "%v", err)
+	err = p.read.View(context.Background(), func(tx ethdb.Tx) error {
+		_, _, err := tx.Bucket(missingBucket).Cursor().First()
+		return err
+	})
+	assert.True(t, errors.Is(err, ethdb.ErrBucketNotFound), "%v", err)
+	assert.True(t, ethdb.IsNotFound(err))
+}
+
+func testUnsafeValues(t *testing.T, p kvProvider) {
+	bucket := dbutils.LogAddressIndexBucket
+	putAll(t, p.write, bucket,
+		[]byte{1}, []byte{10},
+		[]byte{2}, []byte{20},
+		[]byte{3}, bytes.Repeat([]byte{30}, 4096),
+	)
+
+	require.NoError(t, p.read.View(context.Background(), func(tx ethdb.Tx) error {
+		b := tx.Bucket(bucket)
+		c := b.Cursor()
+		for k, v, err := c.First(); k != nil; k, v, err = c.Next() {
+			require.NoError(t, err)
+			unsafeV, err := c.UnsafeValue()
+			require.NoError(t, err)
+			assert.Equal(t, v, unsafeV)
+		}
+		v, err := c.UnsafeValue()
+		require.NoError(t, err)
+		assert.Nil(t, v)
+
+		// The values are read only when asked for
+		nc := b.Cursor().NoValues()
+		k, vSize, err := nc.SeekTo([]byte{2})
+		require.NoError(t, err)
+		assert.Equal(t, []byte{2}, k)
+		assert.Equal(t, uint32(1), vSize)
+		v, err = nc.UnsafeValue()
+		require.NoError(t, err)
+		assert.Equal(t, []byte{20}, v)
+		k, vSize, err = nc.Next()
+		require.NoError(t, err)
+		assert.Equal(t, []byte{3}, k)
+		assert.Equal(t, uint32(4096), vSize)
+		v, err = nc.UnsafeValue()
+		require.NoError(t, err)
+		assert.Equal(t, bytes.Repeat([]byte{30}, 4096), v)
+		k, _, err = nc.Next()
+		require.NoError(t, err)
+		assert.Nil(t, k)
+		v, err = nc.UnsafeValue()
+		require.NoError(t, err)
+		assert.Nil(t, v)
+		return nil
+	}))
+}
+
+func testLargeValues(t *testing.T, p kvProvider) {
+	bucket := dbutils.CodeBucket
+	large := make([]byte, 1024*1024+7)
+	for i := range large {
+		large[i] = byte(i % 251)
+	}
+	putAll(t, p.write, bucket, []byte{1}, large, []byte{2}, []byte{2})
+
+	require.NoError(t, p.read.View(context.Background(), func(tx ethdb.Tx) error {
+		b := tx.Bucket(bucket)
+		v, err := b.Get([]byte{1})
+		require.NoError(t, err)
+		assert.Equal(t, large, v)
+
+		c := b.Cursor()
+		k, v, err := c.First()
+		require.NoError(t, err)
+		assert.Equal(t, []byte{1}, k)
+		assert.Equal(t, large, v)
+		k, v, err = c.Next()
+		require.NoError(t, err)
+		assert.Equal(t, []byte{2}, k)
+		assert.Equal(t, []byte{2}, v)
+
+		k, vSize, err := b.Cursor().NoValues().First()
+		require.NoError(t, err)
+		assert.Equal(t, []byte{1}, k)
+		assert.Equal(t, uint32(len(large)), vSize)
+		return nil
+	}))
+}
+
+func testEmptyValues(t *testing.T, db ethdb.KV) {
+	bucket := dbutils.IncarnationHistoryBucket
+	putAll(t, db, bucket, []byte{1}, []byte{})
+	require.NoError(t, db.View(context.Background(), func(tx ethdb.Tx) error {
+		b := tx.Bucket(bucket)
+		v, err := b.Get([]byte{1})
+		require.NoError(t, err)
+		assert.NotNil(t, v)
+		assert.Equal(t, 0, len(v))
+		v, err = b.Get([]byte{2})
+		require.NoError(t, err)
+		assert.Nil(t, v)
+		return nil
+	}))
+}
+
+func testSnapshotIsolation(t *testing.T, db ethdb.KV) {
+	ctx := context.Background()
+	bucket := dbutils.IntermediateTrieHashBucket
+	putAll(t, db, bucket, []byte{1}, []byte{1})
+
+	require.NoError(t, db.View(ctx, func(tx ethdb.Tx) error {
+		b := tx.Bucket(bucket)
+		v, err := b.Get([]byte{1})
+		require.NoError(t, err)
+		assert.Equal(t, []byte{1}, v)
+
+		// The writer commits while the read transaction is open
+		done := make(chan error)
+		go func() {
+			done <- db.Update(ctx, func(tx ethdb.Tx) error {
+				b := tx.Bucket(bucket)
+				if err := b.Put([]byte{1}, []byte{2}); err != nil {
+					return err
+				}
+				return b.Put([]byte{2}, []byte{2})
+			})
+		}()
+		require.NoError(t, <-done)
+
+		v, err = b.Get([]byte{1})
+		require.NoError(t, err)
+		assert.Equal(t, []byte{1}, v)
+		assert.Equal(t, [][]byte{{1}}, collect(t, b.Cursor(), nil))
+		return nil
+	}))
+
+	require.NoError(t, db.View(ctx, func(tx ethdb.Tx) error {
+		b := tx.Bucket(bucket)
+		v, err := b.Get([]byte{1})
+		require.NoError(t, err)
+		assert.Equal(t, []byte{2}, v)
+		assert.Equal(t, [][]byte{{1}, {2}}, collect(t, b.Cursor(), nil))
+		return nil
+	}))
+}
+
+// testConcurrentReaders checks that the readers never see the partially committed writes:
+// the writer always changes both keys to the same value in one transaction
+func testConcurrentReaders(t *testing.T, db ethdb.KV) {
+	ctx := context.Background()
+	bucket := dbutils.IntermediateTrieWitnessLenBucket
+	const writes = 100
+	const readers = 4
+
+	encode := func(i uint64) []byte {
+		var v [8]byte
+		binary.BigEndian.PutUint64(v[:], i)
+		return v[:]
+	}
+	putAll(t, db, bucket, []byte{1}, encode(0), []byte{2}, encode(0))
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	errs := make(chan error, readers)
+	for r := 0; r < readers; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				if err := db.View(ctx, func(tx ethdb.Tx) error {
+					b := tx.Bucket(bucket)
+					v1, err := b.Get([]byte{1})
+					if err != nil {
+						return err
+					}
+					v2, err := b.Get([]byte{2})
+					if err != nil {
+						return err
+					}
+					if !bytes.Equal(v1, v2) {
+						return fmt.Errorf("inconsistent snapshot: %x != %x", v1, v2)
+					}
+					return nil
+				}); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+
+	for i := uint64(1); i <= writes; i++ {
+		require.NoError(t, db.Update(ctx, func(tx ethdb.Tx) error {
+			b := tx.Bucket(bucket)
+			if err := b.Put([]byte{1}, encode(i)); err != nil {
+				return err
+			}
+			return b.Put([]byte{2}, encode(i))
+		}))
+	}
+	close(stop)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		assert.NoError(t, err)
+	}
+}
+
+func testSavepoints(t *testing.T, db ethdb.KV) {
+	ctx := context.Background()
+	bucket := dbutils.CurrentStateBucket
+	putAll(t, db, bucket, []byte("a"), []byte("1"), []byte("b"), []byte("2"))
+	// Badger allows only one cursor in the write transaction, so the values are read by Get
+	values := func(tx ethdb.Tx) []string {
+		var entries []string
+		for _, k := range []string{"a", "b", "c", "d", "e", "f"} {
+			v, err := tx.Bucket(bucket).Get([]byte(k))
+			require.NoError(t, err)
+			if v != nil {
+				entries = append(entries, k+"="+string(v))
+			}
+		}
+		return entries
+	}
+
+	require.NoError(t, db.Update(ctx, func(tx ethdb.Tx) error {
+		b := tx.Bucket(bucket)
+		sp1, err := tx.Savepoint()
+		require.NoError(t, err)
+		require.NoError(t, b.Put([]byte("a"), []byte("3")))
+		require.NoError(t, b.Delete([]byte("b")))
+		require.NoError(t, b.Put([]byte("c"), []byte("4")))
+		sp2, err := tx.Savepoint()
+		require.NoError(t, err)
+		require.NoError(t, b.Put([]byte("d"), []byte("5")))
+		require.NoError(t, tx.DropBucket(bucket))
+
+		require.NoError(t, tx.RollbackTo(sp2))
+		assert.Equal(t, []string{"a=3", "c=4"}, values(tx))
+		require.NoError(t, tx.RollbackTo(sp1))
+		assert.Equal(t, []string{"a=1", "b=2"}, values(tx))
+		// The later savepoint is discarded by the rollback to the earlier one
+		assert.True(t, errors.Is(tx.RollbackTo(sp2), ethdb.ErrInvalidSavepoint))
+		// The savepoint stays valid after the rollback
+		require.NoError(t, tx.Bucket(bucket).Put([]byte("e"), []byte("6")))
+		require.NoError(t, tx.RollbackTo(sp1))
+		return tx.Bucket(bucket).Put([]byte("f"), []byte("7"))
+	}))
+	require.NoError(t, db.View(ctx, func(tx ethdb.Tx) error {
+		assert.Equal(t, []string{"a=1", "b=2", "f=7"}, values(tx))
+		return nil
+	}))
+}
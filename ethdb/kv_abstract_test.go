@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"testing"
 	"time"
 
@@ -13,6 +14,8 @@ import (
 	"github.com/ledgerwatch/turbo-geth/ethdb/remote/remotedbserver"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/test/bufconn"
 )
 
 func TestManagedTx(t *testing.T) {
@@ -22,8 +25,15 @@ func TestManagedTx(t *testing.T) {
 		ethdb.NewBolt().InMem().MustOpen(ctx),
 		ethdb.NewBolt().InMem().MustOpen(ctx), // for remote db
 		ethdb.NewBadger().InMem().MustOpen(ctx),
+		ethdb.NewBolt().InMem().MustOpen(ctx), // for gRPC remote db
 	}
 
+	grpcListener := bufconn.Listen(1024 * 1024)
+	grpcServer := remotedbserver.NewGrpcServer(writeDBs[3])
+	go func() {
+		_ = grpcServer.Serve(grpcListener)
+	}()
+
 	serverIn, clientOut := io.Pipe()
 	clientIn, serverOut := io.Pipe()
 
@@ -31,6 +41,9 @@ func TestManagedTx(t *testing.T) {
 		writeDBs[0],
 		ethdb.NewRemote().InMem(clientIn, clientOut).MustOpen(ctx),
 		writeDBs[2],
+		ethdb.NewGrpcRemote().Path("bufnet").DialOptions(grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+			return grpcListener.Dial()
+		})).MustOpen(ctx),
 	}
 
 	serverCtx, serverCancel := context.WithCancel(ctx)
@@ -45,6 +58,7 @@ func TestManagedTx(t *testing.T) {
 		for _, db := range readDBs {
 			db.Close()
 		}
+		grpcServer.Stop()
 
 		serverIn.Close()
 		serverOut.Close()
@@ -76,12 +90,25 @@ func TestManagedTx(t *testing.T) {
 		t.Run("NoValues iterator "+msg, func(t *testing.T) {
 			testNoValuesIterator(t, db)
 		})
+		t.Run("filter "+msg, func(t *testing.T) {
+			testPrefixFilter(t, db)
+		})
+		if db == readDBs[3] {
+			// the other cursors do not implement MatchBits yet
+			t.Run("match bits "+msg, func(t *testing.T) {
+				testMatchBits(t, db)
+			})
+		}
+		// the last one, the remote db drops the connection of the cancelled transaction
 		t.Run("ctx cancel "+msg, func(t *testing.T) {
-			t.Skip("probably need enable after go 1.4")
 			testCtxCancel(t, db)
 		})
-		t.Run("filter "+msg, func(t *testing.T) {
-			testPrefixFilter(t, db)
+	}
+
+	for _, db := range []ethdb.KV{writeDBs[0], writeDBs[2]} {
+		db := db
+		t.Run(fmt.Sprintf("update ctx cancel %T", db), func(t *testing.T) {
+			testUpdateCtxCancel(t, db)
 		})
 	}
 }
@@ -142,23 +169,96 @@ func testPrefixFilter(t *testing.T, db ethdb.KV) {
 	}
 
 }
+
+func testMatchBits(t *testing.T, db ethdb.KV) {
+	if err := db.View(context.Background(), func(tx ethdb.Tx) error {
+		b := tx.Bucket(dbutils.CurrentStateBucket)
+		// 0x08 and 0x09 share the first 5 bits with 0x08, the keys before them do not
+		var keys [][]byte
+		if err := b.Cursor().Prefix([]byte{0x08}).MatchBits(5).Walk(func(k, _ []byte) (bool, error) {
+			keys = append(keys, k)
+			return true, nil
+		}); err != nil {
+			return err
+		}
+		assert.Equal(t, [][]byte{{8}, {9}}, keys)
+
+		keys = nil
+		if err := b.Cursor().Prefix([]byte{0x08}).MatchBits(5).NoValues().Walk(func(k []byte, _ uint32) (bool, error) {
+			keys = append(keys, k)
+			return true, nil
+		}); err != nil {
+			return err
+		}
+		assert.Equal(t, [][]byte{{8}, {9}}, keys)
+
+		k, _, err := b.Cursor().Prefix([]byte{0x08}).MatchBits(5).Seek([]byte{9})
+		assert.NoError(t, err)
+		assert.Equal(t, []byte{9}, k)
+		return nil
+	}); err != nil {
+		assert.NoError(t, err)
+	}
+}
+
 func testCtxCancel(t *testing.T, db ethdb.KV) {
-	assert := assert.New(t)
-	cancelableCtx, cancel := context.WithTimeout(context.Background(), time.Microsecond)
+	// The walks, which would never end by themselves, are interrupted by the deadline
+	deadlineCtx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
 	defer cancel()
-
-	if err := db.View(cancelableCtx, func(tx ethdb.Tx) error {
+	err := db.View(deadlineCtx, func(tx ethdb.Tx) error {
 		c := tx.Bucket(dbutils.CurrentStateBucket).Cursor()
 		for {
-			for k, _, err := c.First(); k != nil || err != nil; k, _, err = c.Next() {
-				if err != nil {
-					return err
-				}
+			if err := c.Walk(func(_, _ []byte) (bool, error) {
+				return true, nil
+			}); err != nil {
+				return err
 			}
 		}
-	}); err != nil {
-		assert.True(errors.Is(context.DeadlineExceeded, err))
-	}
+	})
+	require.True(t, errors.Is(err, context.DeadlineExceeded), "unexpected error: %v", err)
+
+	// View reports the cancellation, even if f ignores the failed moves of the cursor
+	cancelableCtx, cancel := context.WithCancel(context.Background())
+	err = db.View(cancelableCtx, func(tx ethdb.Tx) error {
+		c := tx.Bucket(dbutils.CurrentStateBucket).Cursor().NoValues()
+		k, _, err := c.First()
+		require.NoError(t, err)
+		require.NotNil(t, k)
+		cancel()
+		_, _, err = c.Next()
+		assert.True(t, errors.Is(err, context.Canceled), "unexpected error: %v", err)
+		return nil
+	})
+	require.True(t, errors.Is(err, context.Canceled), "unexpected error: %v", err)
+
+	// f is not run with the cancelled context
+	err = db.View(cancelableCtx, func(tx ethdb.Tx) error {
+		t.Error("the transaction is started with the cancelled context")
+		return nil
+	})
+	require.True(t, errors.Is(err, context.Canceled), "unexpected error: %v", err)
+}
+
+func testUpdateCtxCancel(t *testing.T, db ethdb.KV) {
+	ctx, cancel := context.WithCancel(context.Background())
+	err := db.Update(ctx, func(tx ethdb.Tx) error {
+		if err := tx.Bucket(dbutils.CurrentStateBucket).Put([]byte{0xff}, []byte{1}); err != nil {
+			return err
+		}
+		cancel()
+		return nil
+	})
+	require.True(t, errors.Is(err, context.Canceled), "unexpected error: %v", err)
+
+	// The transaction cancelled midway is rolled back
+	require.NoError(t, db.View(context.Background(), func(tx ethdb.Tx) error {
+		v, err := tx.Bucket(dbutils.CurrentStateBucket).Get([]byte{0xff})
+		if err != nil && !errors.Is(err, ethdb.ErrKeyNotFound) {
+			return err
+		}
+		assert.Nil(t, v)
+		return nil
+	}))
 }
 
 func testNoValuesIterator(t *testing.T, db ethdb.KV) {
@@ -3,7 +3,6 @@ package ethdb
 import (
 	"context"
 	"runtime"
-	"time"
 
 	"github.com/dgraph-io/badger/v2"
 	"github.com/ledgerwatch/turbo-geth/log"
@@ -43,23 +42,19 @@ func (opts badgerOpts) Open(ctx context.Context) (KV, error) {
 		return nil, err
 	}
 
-	ticker := time.NewTicker(gcPeriod)
-	// Start GC in backround
-	go func() {
-		for range ticker.C {
-			err := db.RunValueLogGC(0.5)
-			if err != badger.ErrNoRewrite {
-				logger.Info("Badger GC run", "err", err)
-			}
-		}
-	}()
+	var gc *badgerGC
+	if !opts.Badger.InMemory {
+		gc = newBadgerGC(db, DefaultBadgerGCConfig, logger)
+		// Start GC in backround
+		gc.start()
+	}
 
-	return &badgerDB{
-		opts:     opts,
-		badger:   db,
-		log:      logger,
-		gcTicker: ticker, // Garbage Collector
-	}, nil
+	return openCodecs(ctx, &badgerDB{
+		opts:   opts,
+		badger: db,
+		log:    logger,
+		gc:     gc, // Garbage Collector
+	}, opts.Badger.ReadOnly)
 }
 
 func (opts badgerOpts) MustOpen(ctx context.Context) KV {
@@ -71,10 +66,10 @@ func (opts badgerOpts) MustOpen(ctx context.Context) KV {
 }
 
 type badgerDB struct {
-	opts     badgerOpts
-	badger   *badger.DB
-	gcTicker *time.Ticker
-	log      log.Logger
+	opts   badgerOpts
+	badger *badger.DB
+	gc     *badgerGC
+	log    log.Logger
 }
 
 func NewBadger() badgerOpts {
@@ -84,9 +79,7 @@ func NewBadger() badgerOpts {
 // Close closes BoltKV
 // All transactions must be closed before closing the database.
 func (db *badgerDB) Close() {
-	if db.gcTicker != nil {
-		db.gcTicker.Stop()
-	}
+	db.gc.stop()
 	if err := db.badger.Close(); err != nil {
 		db.log.Warn("failed to close badger DB", "err", err)
 	} else {
@@ -94,6 +87,16 @@ func (db *badgerDB) Close() {
 	}
 }
 
+// PauseGC implements GCPauser
+func (db *badgerDB) PauseGC() {
+	db.gc.pause()
+}
+
+// ResumeGC implements GCPauser
+func (db *badgerDB) ResumeGC() {
+	db.gc.resume()
+}
+
 func (db *badgerDB) Begin(ctx context.Context, writable bool) (Tx, error) {
 	return &badgerTx{
 		db:     db,
@@ -108,11 +111,13 @@ type badgerTx struct {
 
 	badger          *badger.Txn
 	badgerIterators []*badger.Iterator
+	journal         undoJournal
 }
 
 type badgerBucket struct {
 	tx *badgerTx
 
+	name    []byte
 	prefix  []byte
 	nameLen uint
 }
@@ -125,6 +130,7 @@ type badgerCursor struct {
 	badgerOpts badger.IteratorOptions
 
 	badger *badger.Iterator
+	item   *badger.Item // The item at the current position, nil past the end
 
 	k   []byte
 	v   []byte
@@ -132,32 +138,101 @@ type badgerCursor struct {
 }
 
 func (db *badgerDB) View(ctx context.Context, f func(tx Tx) error) (err error) {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	t := &badgerTx{db: db, ctx: ctx}
-	return db.badger.View(func(tx *badger.Txn) error {
+	return badgerErr(db.badger.View(func(tx *badger.Txn) error {
 		defer t.cleanup()
 		t.badger = tx
-		return f(t)
-	})
+		return txCtxErr(ctx, f(t))
+	}))
 }
 
 func (db *badgerDB) Update(ctx context.Context, f func(tx Tx) error) (err error) {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	t := &badgerTx{db: db, ctx: ctx}
-	return db.badger.Update(func(tx *badger.Txn) error {
+	return badgerErr(db.badger.Update(func(tx *badger.Txn) error {
 		defer t.cleanup()
 		t.badger = tx
-		return f(t)
-	})
+		return txCtxErr(ctx, f(t))
+	}))
 }
 
 func (tx *badgerTx) Bucket(name []byte) Bucket {
-	b := badgerBucket{tx: tx, nameLen: uint(len(name))}
-	b.prefix = name
+	// the separator prevents collisions of the buckets, names of which are prefixes of each other (i.e. "h" and "hAT")
+	b := badgerBucket{tx: tx, name: name, prefix: bucketKey(name, nil)}
+	b.nameLen = uint(len(b.prefix))
 	return b
 }
 
+// CreateBucket does nothing, because the buckets of Badger are the key prefixes
+func (tx *badgerTx) CreateBucket(name []byte) error {
+	return nil
+}
+
+// ExistsBucket tells whether there are keys in the bucket, the empty buckets do not exist in Badger
+func (tx *badgerTx) ExistsBucket(name []byte) (bool, error) {
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = false
+	opts.Prefix = bucketKey(name, nil)
+	it := tx.badger.NewIterator(opts)
+	defer it.Close()
+	it.Rewind()
+	return it.Valid(), nil
+}
+
+// DropBucket deletes all the keys of the bucket. The deletions are a part of the transaction,
+// so the large buckets can exceed the size limit of the transaction (badger.ErrTxnTooBig)
+func (tx *badgerTx) DropBucket(name []byte) error {
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = false
+	opts.Prefix = bucketKey(name, nil)
+	it := tx.badger.NewIterator(opts)
+	var keys [][]byte
+	for it.Rewind(); it.Valid(); it.Next() {
+		keys = append(keys, it.Item().KeyCopy(nil))
+		if tx.journal.recording() {
+			v, err := it.Item().ValueCopy(nil)
+			if err != nil {
+				it.Close()
+				return badgerErr(err)
+			}
+			tx.journal.record(name, keys[len(keys)-1][len(opts.Prefix):], v, true)
+		}
+	}
+	it.Close()
+	for _, k := range keys {
+		if err := tx.badger.Delete(k); err != nil {
+			return badgerErr(err)
+		}
+	}
+	return nil
+}
+
+// Savepoint is emulated by recording the previous values of the written keys, see undoJournal
+func (tx *badgerTx) Savepoint() (Savepoint, error) {
+	return tx.journal.savepoint(), nil
+}
+
+func (tx *badgerTx) RollbackTo(sp Savepoint) error {
+	return tx.journal.rollbackTo(sp, restoreKV(tx))
+}
+
+// key returns the key with the bucket prefix in a new slice, so that the keys of the concurrent
+// operations and the prefixes of the cursors never share the underlying array
+func (b badgerBucket) key(key []byte) []byte {
+	k := make([]byte, int(b.nameLen)+len(key))
+	copy(k, b.prefix[:b.nameLen])
+	copy(k[b.nameLen:], key)
+	return k
+}
+
 func (tx *badgerTx) Commit(ctx context.Context) error {
 	tx.cleanup()
-	return tx.badger.Commit()
+	return badgerErr(tx.badger.Commit())
 }
 
 func (tx *badgerTx) Rollback() error {
@@ -173,7 +248,7 @@ func (tx *badgerTx) cleanup() {
 }
 
 func (c *badgerCursor) Prefix(v []byte) Cursor {
-	c.prefix = append(c.bucket.prefix[:c.bucket.nameLen], v...)
+	c.prefix = c.bucket.key(v)
 	c.badgerOpts.Prefix = c.prefix
 	return c
 }
@@ -200,12 +275,20 @@ func (b badgerBucket) Get(key []byte) (val []byte, err error) {
 	}
 
 	var item *badger.Item
-	b.prefix = append(b.prefix[:b.nameLen], key...)
-	item, err = b.tx.badger.Get(b.prefix)
-	if item != nil {
-		val, err = item.ValueCopy(nil) // can improve this by using pool
+	item, err = b.tx.badger.Get(b.key(key))
+	if err == badger.ErrKeyNotFound {
+		// same as Bolt and Remote: absent key is not an error on this level
+		return nil, nil
 	}
-	return val, err
+	if err != nil {
+		return nil, badgerErr(err)
+	}
+	val, err = item.ValueCopy(nil) // can improve this by using pool
+	if err == nil && val == nil {
+		// same as Bolt: empty value of the existing key is not nil
+		val = []byte{}
+	}
+	return val, badgerErr(err)
 }
 
 func (b badgerBucket) Put(key []byte, value []byte) error {
@@ -215,8 +298,13 @@ func (b badgerBucket) Put(key []byte, value []byte) error {
 	default:
 	}
 
-	b.prefix = append(b.prefix[:b.nameLen], key...)
-	return b.tx.badger.Set(b.prefix, value)
+	if b.tx.journal.recording() {
+		if err := b.recordPrevious(key); err != nil {
+			return err
+		}
+	}
+	b.tx.db.gc.wrote(len(key) + len(value))
+	return badgerErr(b.tx.badger.Set(b.key(key), value))
 }
 
 func (b badgerBucket) Delete(key []byte) error {
@@ -226,13 +314,27 @@ func (b badgerBucket) Delete(key []byte) error {
 	default:
 	}
 
-	b.prefix = append(b.prefix[:b.nameLen], key...)
-	return b.tx.badger.Delete(b.prefix)
+	if b.tx.journal.recording() {
+		if err := b.recordPrevious(key); err != nil {
+			return err
+		}
+	}
+	b.tx.db.gc.wrote(len(key))
+	return badgerErr(b.tx.badger.Delete(b.key(key)))
+}
+
+func (b badgerBucket) recordPrevious(key []byte) error {
+	v, err := b.Get(key)
+	if err != nil {
+		return err
+	}
+	b.tx.journal.record(b.name, key, v, v != nil)
+	return nil
 }
 
 func (b badgerBucket) Cursor() Cursor {
 	c := &badgerCursor{bucket: b, ctx: b.tx.ctx, badgerOpts: badger.DefaultIteratorOptions}
-	c.prefix = append(c.prefix, b.prefix[:b.nameLen]...) // set bucket
+	c.prefix = b.key(nil) // set bucket
 	c.badgerOpts.Prefix = c.prefix
 	return c
 }
@@ -255,15 +357,21 @@ func (c *badgerCursor) initCursor() {
 }
 
 func (c *badgerCursor) First() ([]byte, []byte, error) {
+	select {
+	case <-c.ctx.Done():
+		return nil, nil, c.ctx.Err()
+	default:
+	}
+
 	c.initCursor()
 
 	c.badger.Rewind()
 	if !c.badger.Valid() {
-		c.k = nil
+		c.k, c.item = nil, nil
 		return c.k, c.v, c.err
 	}
 	item := c.badger.Item()
-	c.k = item.Key()[c.bucket.nameLen:]
+	c.k, c.item = item.Key()[c.bucket.nameLen:], item
 	if c.badgerOpts.PrefetchValues {
 		c.v, c.err = item.ValueCopy(c.v) // bech show: using .ValueCopy on same buffer has same speed as item.Value()
 	}
@@ -279,13 +387,13 @@ func (c *badgerCursor) Seek(seek []byte) ([]byte, []byte, error) {
 
 	c.initCursor()
 
-	c.badger.Seek(append(c.bucket.prefix[:c.bucket.nameLen], seek...))
+	c.badger.Seek(c.bucket.key(seek))
 	if !c.badger.Valid() {
-		c.k = nil
+		c.k, c.item = nil, nil
 		return c.k, c.v, c.err
 	}
 	item := c.badger.Item()
-	c.k = item.Key()[c.bucket.nameLen:]
+	c.k, c.item = item.Key()[c.bucket.nameLen:], item
 	if c.badgerOpts.PrefetchValues {
 		c.v, c.err = item.ValueCopy(c.v)
 	}
@@ -303,13 +411,19 @@ func (c *badgerCursor) Next() ([]byte, []byte, error) {
 	default:
 	}
 
+	c.initCursor()
+	// badger iterator can't move past the end, Bolt returns nil key there
+	if !c.badger.Valid() {
+		c.k, c.item = nil, nil
+		return c.k, nil, c.err
+	}
 	c.badger.Next()
 	if !c.badger.Valid() {
-		c.k = nil
+		c.k, c.item = nil, nil
 		return c.k, c.v, c.err
 	}
 	item := c.badger.Item()
-	c.k = item.Key()[c.bucket.nameLen:]
+	c.k, c.item = item.Key()[c.bucket.nameLen:], item
 	if c.badgerOpts.PrefetchValues {
 		c.v, c.err = item.ValueCopy(c.v)
 	}
@@ -333,6 +447,15 @@ func (c *badgerCursor) Walk(walker func(k, v []byte) (bool, error)) error {
 	return nil
 }
 
+// UnsafeValue reads the value of the current item, which the cursors opened with NoValues do not read.
+// The value is copied: badger keeps it valid only inside the callback of item.Value
+func (c *badgerCursor) UnsafeValue() ([]byte, error) {
+	if c.item == nil {
+		return nil, c.err
+	}
+	return c.item.ValueCopy(nil)
+}
+
 type badgerNoValuesCursor struct {
 	badgerCursor
 }
@@ -354,14 +477,20 @@ func (c *badgerNoValuesCursor) Walk(walker func(k []byte, vSize uint32) (bool, e
 }
 
 func (c *badgerNoValuesCursor) First() ([]byte, uint32, error) {
+	select {
+	case <-c.ctx.Done():
+		return nil, 0, c.ctx.Err()
+	default:
+	}
+
 	c.initCursor()
 	c.badger.Rewind()
 	if !c.badger.Valid() {
-		c.k = nil
+		c.k, c.item = nil, nil
 		return c.k, 0, c.err
 	}
 	item := c.badger.Item()
-	c.k = item.Key()[c.bucket.nameLen:]
+	c.k, c.item = item.Key()[c.bucket.nameLen:], item
 	return c.k, uint32(item.ValueSize()), c.err
 }
 
@@ -374,13 +503,13 @@ func (c *badgerNoValuesCursor) Seek(seek []byte) ([]byte, uint32, error) {
 
 	c.initCursor()
 
-	c.badger.Seek(append(c.bucket.prefix[:c.bucket.nameLen], seek...))
+	c.badger.Seek(c.bucket.key(seek))
 	if !c.badger.Valid() {
-		c.k = nil
+		c.k, c.item = nil, nil
 		return c.k, 0, c.err
 	}
 	item := c.badger.Item()
-	c.k = item.Key()[c.bucket.nameLen:]
+	c.k, c.item = item.Key()[c.bucket.nameLen:], item
 
 	return c.k, uint32(item.ValueSize()), c.err
 }
@@ -396,12 +525,17 @@ func (c *badgerNoValuesCursor) Next() ([]byte, uint32, error) {
 	default:
 	}
 
+	c.initCursor()
+	if !c.badger.Valid() {
+		c.k, c.item = nil, nil
+		return c.k, 0, c.err
+	}
 	c.badger.Next()
 	if !c.badger.Valid() {
-		c.k = nil
+		c.k, c.item = nil, nil
 		return c.k, 0, c.err
 	}
 	item := c.badger.Item()
-	c.k = item.Key()[c.bucket.nameLen:]
+	c.k, c.item = item.Key()[c.bucket.nameLen:], item
 	return c.k, uint32(item.ValueSize()), c.err
 }
@@ -3,6 +3,7 @@ package ethdb
 import (
 	"bytes"
 	"context"
+	"fmt"
 
 	"github.com/ledgerwatch/bolt"
 	"github.com/ledgerwatch/turbo-geth/common/dbutils"
@@ -10,8 +11,9 @@ import (
 )
 
 type boltOpts struct {
-	Bolt *bolt.Options
-	path string
+	Bolt   *bolt.Options
+	path   string
+	noSync bool
 }
 
 type BoltKV struct {
@@ -23,14 +25,17 @@ type boltTx struct {
 	ctx context.Context
 	db  *BoltKV
 
-	bolt *bolt.Tx
+	bolt    *bolt.Tx
+	journal undoJournal
 }
 
 type boltBucket struct {
 	tx *boltTx
 
 	bolt    *bolt.Bucket
+	name    []byte
 	nameLen uint
+	split   bool // The bucket is CurrentStateBucket kept in the shards, see IsSplitState
 }
 
 type boltCursor struct {
@@ -38,7 +43,7 @@ type boltCursor struct {
 	bucket boltBucket
 	prefix []byte
 
-	bolt *bolt.Cursor
+	bolt BoltCursor
 
 	k   []byte
 	v   []byte
@@ -54,8 +59,10 @@ func (opts boltOpts) InMem() boltOpts {
 	return opts
 }
 
+// ReadOnly opens the database without creating the missing buckets, writes fail with ErrTxReadOnly
 func (opts boltOpts) ReadOnly() boltOpts {
 	opts.Bolt.ReadOnly = true
+	opts.Bolt.Timeout = readOnlyOpenTimeout
 	return opts
 }
 
@@ -64,27 +71,51 @@ func (opts boltOpts) Path(path string) boltOpts {
 	return opts
 }
 
+// MmapSize sets the initial size of the memory map, see BoltOptions.InitialMmapSize
+func (opts boltOpts) MmapSize(size int) boltOpts {
+	opts.Bolt.InitialMmapSize = size
+	return opts
+}
+
+// FreelistMap selects the hashmap freelist, see BoltOptions.FreelistMap
+func (opts boltOpts) FreelistMap() boltOpts {
+	opts.Bolt.FreelistType = bolt.FreelistMapType
+	return opts
+}
+
+// NoSync skips fsync of the commits, see BoltOptions.NoSync
+func (opts boltOpts) NoSync() boltOpts {
+	opts.noSync = true
+	return opts
+}
+
 func (opts boltOpts) Open(ctx context.Context) (db KV, err error) {
 	boltDB, err := bolt.Open(opts.path, 0600, opts.Bolt)
 	if err != nil {
 		return nil, err
 	}
-	if err := boltDB.Update(func(tx *bolt.Tx) error {
-		for _, name := range dbutils.Buckets {
-			_, createErr := tx.CreateBucketIfNotExists(name, false)
-			if createErr != nil {
-				return createErr
+	if !opts.Bolt.ReadOnly {
+		if err := boltDB.Update(func(tx *bolt.Tx) error {
+			for _, name := range dbutils.Buckets {
+				_, createErr := tx.CreateBucketIfNotExists(name, false)
+				if createErr != nil {
+					return createErr
+				}
 			}
+			return recordBoltBucketCodecs(tx)
+		}); err != nil {
+			return nil, err
 		}
-		return nil
-	}); err != nil {
-		return nil, err
 	}
-	return &BoltKV{
+	if opts.noSync {
+		boltDB.NoSync = true
+		boltDB.NoGrowSync = true
+	}
+	return withCodecs(&BoltKV{
 		opts: opts,
 		bolt: boltDB,
 		log:  log.New("bolt_db", opts.path),
-	}, nil
+	}), nil
 }
 
 func (opts boltOpts) MustOpen(ctx context.Context) KV {
@@ -96,12 +127,16 @@ func (opts boltOpts) MustOpen(ctx context.Context) KV {
 }
 
 func NewBolt() boltOpts {
-	return boltOpts{Bolt: bolt.DefaultOptions}
+	// copy, because the options are modified by the builder methods
+	boltOptions := *bolt.DefaultOptions
+	return boltOpts{Bolt: &boltOptions}
 }
 
 // Close closes BoltKV
 // All transactions must be closed before closing the database.
 func (db *BoltKV) Close() {
+	forgetBucketCodecs(db.bolt)
+	forgetSplitState(db.bolt)
 	if err := db.bolt.Close(); err != nil {
 		db.log.Warn("failed to close bolt DB", "err", err)
 	} else {
@@ -113,43 +148,84 @@ func (db *BoltKV) Begin(ctx context.Context, writable bool) (Tx, error) {
 	var err error
 	t := &boltTx{db: db, ctx: ctx}
 	t.bolt, err = db.bolt.Begin(writable)
-	return t, err
+	return t, boltErr(err)
 }
 
 func (db *BoltKV) View(ctx context.Context, f func(tx Tx) error) (err error) {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	t := &boltTx{db: db, ctx: ctx}
-	return db.bolt.View(func(tx *bolt.Tx) error {
+	return boltErr(db.bolt.View(func(tx *bolt.Tx) error {
 		t.bolt = tx
-		return f(t)
-	})
+		return txCtxErr(ctx, f(t))
+	}))
 }
 
 func (db *BoltKV) Update(ctx context.Context, f func(tx Tx) error) (err error) {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	t := &boltTx{db: db, ctx: ctx}
-	return db.bolt.Update(func(tx *bolt.Tx) error {
+	return boltErr(db.bolt.Update(func(tx *bolt.Tx) error {
 		t.bolt = tx
-		return f(t)
-	})
+		return txCtxErr(ctx, f(t))
+	}))
 }
 
 func (tx *boltTx) Commit(ctx context.Context) error {
-	return tx.bolt.Commit()
+	return boltErr(tx.bolt.Commit())
 }
 
 func (tx *boltTx) Rollback() error {
-	return tx.bolt.Rollback()
+	return boltErr(tx.bolt.Rollback())
 }
 
 func (tx *boltTx) Yield() {
 	tx.bolt.Yield()
 }
 
+// Bucket opens the bucket, the entries of CurrentStateBucket are routed into the shards in the split state schema
 func (tx *boltTx) Bucket(name []byte) Bucket {
-	b := boltBucket{tx: tx, nameLen: uint(len(name))}
+	b := boltBucket{tx: tx, name: name, nameLen: uint(len(name))}
 	b.bolt = tx.bolt.Bucket(name)
+	b.split = bytes.Equal(name, dbutils.CurrentStateBucket) && IsSplitState(tx.bolt)
 	return b
 }
 
+func (tx *boltTx) CreateBucket(name []byte) error {
+	_, err := tx.bolt.CreateBucketIfNotExists(name, false)
+	return boltErr(err)
+}
+
+func (tx *boltTx) ExistsBucket(name []byte) (bool, error) {
+	return tx.bolt.Bucket(name) != nil, nil
+}
+
+func (tx *boltTx) DropBucket(name []byte) error {
+	if tx.bolt.Bucket(name) == nil {
+		return nil
+	}
+	if tx.journal.recording() {
+		if err := tx.bolt.Bucket(name).ForEach(func(k, v []byte) error {
+			tx.journal.record(name, k, v, true)
+			return nil
+		}); err != nil {
+			return boltErr(err)
+		}
+	}
+	return boltErr(tx.bolt.DeleteBucket(name))
+}
+
+// Savepoint is emulated by recording the previous values of the written keys, see undoJournal
+func (tx *boltTx) Savepoint() (Savepoint, error) {
+	return tx.journal.savepoint(), nil
+}
+
+func (tx *boltTx) RollbackTo(sp Savepoint) error {
+	return tx.journal.rollbackTo(sp, restoreKV(tx))
+}
+
 func (c *boltCursor) Prefix(v []byte) Cursor {
 	c.prefix = v
 	return c
@@ -174,8 +250,13 @@ func (b boltBucket) Get(key []byte) (val []byte, err error) {
 		return nil, b.tx.ctx.Err()
 	default:
 	}
+	// Like in Badger, which has no buckets, the missing bucket has no keys
+	bucket := b.shard(key)
+	if bucket == nil {
+		return nil, nil
+	}
 
-	val, _ = b.bolt.Get(key)
+	val, _ = bucket.Get(key)
 	return val, err
 }
 
@@ -185,7 +266,10 @@ func (b boltBucket) Put(key []byte, value []byte) error {
 		return b.tx.ctx.Err()
 	default:
 	}
-	return b.bolt.Put(key, value)
+	if b.tx.journal.recording() {
+		b.recordPrevious(key)
+	}
+	return boltErr(b.shard(key).Put(key, value))
 }
 
 func (b boltBucket) Delete(key []byte) error {
@@ -195,21 +279,53 @@ func (b boltBucket) Delete(key []byte) error {
 	default:
 	}
 
-	return b.bolt.Delete(key)
+	if b.tx.journal.recording() {
+		b.recordPrevious(key)
+	}
+	return boltErr(b.shard(key).Delete(key))
+}
+
+func (b boltBucket) recordPrevious(key []byte) {
+	v, _ := b.shard(key).Get(key)
+	b.tx.journal.record(b.name, key, v, v != nil)
+}
+
+// shard returns the Bolt bucket keeping the key, see StateShard
+func (b boltBucket) shard(key []byte) *bolt.Bucket {
+	if b.split {
+		return b.tx.bolt.Bucket(StateShard(key))
+	}
+	return b.bolt
 }
 
 func (b boltBucket) Cursor() Cursor {
-	return &boltCursor{bucket: b, ctx: b.tx.ctx, bolt: b.bolt.Cursor()}
+	return &boltCursor{bucket: b, ctx: b.tx.ctx, bolt: b.cursor()}
+}
+
+// cursor opens the Bolt cursor over the bucket, merging the shards of the split state
+func (b boltBucket) cursor() BoltCursor {
+	if b.split {
+		if c := StateCursor(b.tx.bolt); c != nil {
+			return c
+		}
+	}
+	return b.bolt.Cursor()
 }
 
 func (c *boltCursor) initCursor() {
 	if c.bolt != nil {
 		return
 	}
-	c.bolt = c.bucket.bolt.Cursor()
+	c.bolt = c.bucket.cursor()
 }
 
 func (c *boltCursor) First() ([]byte, []byte, error) {
+	select {
+	case <-c.ctx.Done():
+		return nil, nil, c.ctx.Err()
+	default:
+	}
+
 	if len(c.prefix) == 0 {
 		c.k, c.v = c.bolt.First()
 		return c.k, c.v, nil
@@ -259,11 +375,40 @@ func (c *boltCursor) Next() ([]byte, []byte, error) {
 
 	c.k, c.v = c.bolt.Next()
 	if len(c.prefix) != 0 && !bytes.HasPrefix(c.k, c.prefix) {
-		return nil, nil, nil
+		c.k, c.v = nil, nil
 	}
 	return c.k, c.v, nil
 }
 
+// Prev steps back, see ReverseCursor. The cursors with the prefix can't tell the end of the prefix from the end of the bucket,
+// so they don't step back
+func (c *boltCursor) Prev() ([]byte, []byte, error) {
+	select {
+	case <-c.ctx.Done():
+		return nil, nil, c.ctx.Err()
+	default:
+	}
+
+	reverse, ok := c.bolt.(interface {
+		Prev() ([]byte, []byte)
+		Last() ([]byte, []byte)
+	})
+	if !ok || len(c.prefix) != 0 {
+		return nil, nil, fmt.Errorf("cursor of the bucket %s can't step back", c.bucket.name)
+	}
+	if c.k == nil {
+		c.k, c.v = reverse.Last()
+	} else {
+		c.k, c.v = reverse.Prev()
+	}
+	return c.k, c.v, nil
+}
+
+// UnsafeValue returns the value from the memory-mapped file, the moves of the cursor do not copy it either
+func (c *boltCursor) UnsafeValue() ([]byte, error) {
+	return c.v, nil
+}
+
 func (c *boltCursor) Walk(walker func(k, v []byte) (bool, error)) error {
 	for k, v, err := c.First(); k != nil || err != nil; k, v, err = c.Next() {
 		if err != nil {
@@ -297,6 +442,12 @@ func (c *noValuesBoltCursor) Walk(walker func(k []byte, vSize uint32) (bool, err
 }
 
 func (c *noValuesBoltCursor) First() ([]byte, uint32, error) {
+	select {
+	case <-c.ctx.Done():
+		return nil, 0, c.ctx.Err()
+	default:
+	}
+
 	if len(c.prefix) == 0 {
 		c.k, c.v = c.bolt.First()
 		return c.k, uint32(len(c.v)), nil
@@ -323,6 +474,20 @@ func (c *noValuesBoltCursor) Seek(seek []byte) ([]byte, uint32, error) {
 	return c.k, uint32(len(c.v)), nil
 }
 
+func (c *noValuesBoltCursor) SeekTo(seek []byte) ([]byte, uint32, error) {
+	select {
+	case <-c.ctx.Done():
+		return nil, 0, c.ctx.Err()
+	default:
+	}
+
+	c.k, c.v = c.bolt.SeekTo(seek)
+	if len(c.prefix) != 0 && !bytes.HasPrefix(c.k, c.prefix) {
+		c.k, c.v = nil, nil
+	}
+	return c.k, uint32(len(c.v)), nil
+}
+
 func (c *noValuesBoltCursor) Next() ([]byte, uint32, error) {
 	select {
 	case <-c.ctx.Done():
@@ -332,7 +497,7 @@ func (c *noValuesBoltCursor) Next() ([]byte, uint32, error) {
 
 	c.k, c.v = c.bolt.Next()
 	if len(c.prefix) != 0 && !bytes.HasPrefix(c.k, c.prefix) {
-		return nil, 0, nil
+		c.k, c.v = nil, nil
 	}
 	return c.k, uint32(len(c.v)), nil
 }
@@ -0,0 +1,267 @@
+package ethdb
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+)
+
+// codecKV compresses the values of the buckets with the codecs recorded in the database (see dbutils.BucketCodecs),
+// over the KV of any backend. The values are encoded by Put, and decoded by Get and the cursors. The recorded codecs
+// are read by the first transaction, they only change when the database is opened for writing, see openCodecs
+type codecKV struct {
+	KV
+	mu     sync.Mutex
+	codecs map[string]dbutils.Codec // nil until read
+}
+
+type codecTx struct {
+	Tx
+	db *codecKV
+}
+
+type codecBucket struct {
+	Bucket
+	codec ValueCodec
+	err   error // Codec of the bucket is not supported
+}
+
+type kvCodecCursor struct {
+	Cursor
+	bucket codecBucket
+	v      []byte // Decoded value at the cursor, see UnsafeValue
+}
+
+// kvCodecReverseCursor is kvCodecCursor over the cursor, which steps back
+type kvCodecReverseCursor struct {
+	*kvCodecCursor
+}
+
+type kvCodecNoValuesCursor struct {
+	NoValuesCursor
+	bucket codecBucket
+}
+
+// openCodecs records the codecs of the new buckets, unless the database is read-only, and wraps the opened KV
+func openCodecs(ctx context.Context, kv KV, readOnly bool) (KV, error) {
+	if !readOnly {
+		if err := kv.Update(ctx, recordBucketCodecs); err != nil {
+			kv.Close()
+			return nil, err
+		}
+	}
+	return withCodecs(kv), nil
+}
+
+// withCodecs wraps the KV, so that the values of the compressed buckets are encoded and decoded
+func withCodecs(kv KV) KV {
+	return &codecKV{KV: kv}
+}
+
+func (db *codecKV) View(ctx context.Context, f func(tx Tx) error) error {
+	return db.KV.View(ctx, func(tx Tx) error {
+		return f(&codecTx{Tx: tx, db: db})
+	})
+}
+
+func (db *codecKV) Update(ctx context.Context, f func(tx Tx) error) error {
+	return db.KV.Update(ctx, func(tx Tx) error {
+		return f(&codecTx{Tx: tx, db: db})
+	})
+}
+
+func (db *codecKV) Begin(ctx context.Context, writable bool) (Tx, error) {
+	tx, err := db.KV.Begin(ctx, writable)
+	if err != nil {
+		return nil, err
+	}
+	return &codecTx{Tx: tx, db: db}, nil
+}
+
+// PauseGC implements GCPauser for the backends with the garbage collection
+func (db *codecKV) PauseGC() {
+	if p, ok := db.KV.(GCPauser); ok {
+		p.PauseGC()
+	}
+}
+
+// ResumeGC implements GCPauser
+func (db *codecKV) ResumeGC() {
+	if p, ok := db.KV.(GCPauser); ok {
+		p.ResumeGC()
+	}
+}
+
+// codec returns the codec of the bucket, nil if its values are not compressed. The recorded codecs are read
+// in the transaction on the first call
+func (db *codecKV) codec(tx Tx, bucket []byte) (ValueCodec, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.codecs == nil {
+		recorded := make(map[string]dbutils.Codec)
+		if exists, err := tx.ExistsBucket(dbutils.BucketCodecsBucket); err != nil {
+			return nil, err
+		} else if exists {
+			if err := tx.Bucket(dbutils.BucketCodecsBucket).Cursor().Walk(func(k, v []byte) (bool, error) {
+				if len(v) > 0 {
+					recorded[string(k)] = dbutils.Codec(v[0])
+				}
+				return true, nil
+			}); err != nil {
+				return nil, err
+			}
+		}
+		db.codecs = recorded
+	}
+	return lookupCodec(db.codecs[string(bucket)])
+}
+
+// Yield lets the backends, which support it, release the transaction for a moment, see Yieldable
+func (tx *codecTx) Yield() {
+	if y, ok := tx.Tx.(interface{ Yield() }); ok {
+		y.Yield()
+	}
+}
+
+func (tx *codecTx) Bucket(name []byte) Bucket {
+	b := tx.Tx.Bucket(name)
+	codec, err := tx.db.codec(tx.Tx, name)
+	if codec == nil && err == nil {
+		return b
+	}
+	return codecBucket{Bucket: b, codec: codec, err: err}
+}
+
+func (b codecBucket) Get(key []byte) ([]byte, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	v, err := b.Bucket.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	return decodeValue(b.codec, v)
+}
+
+func (b codecBucket) Put(key []byte, value []byte) error {
+	if b.err != nil {
+		return b.err
+	}
+	value, err := encodeValue(b.codec, value)
+	if err != nil {
+		return err
+	}
+	return b.Bucket.Put(key, value)
+}
+
+func (b codecBucket) Cursor() Cursor {
+	c := &kvCodecCursor{Cursor: b.Bucket.Cursor(), bucket: b}
+	if _, ok := c.Cursor.(ReverseCursor); ok {
+		return kvCodecReverseCursor{c}
+	}
+	return c
+}
+
+func (c *kvCodecCursor) decode(k, v []byte, err error) ([]byte, []byte, error) {
+	c.v = nil
+	if err != nil || k == nil {
+		return k, v, err
+	}
+	if c.bucket.err != nil {
+		return nil, nil, c.bucket.err
+	}
+	if c.v, err = decodeValue(c.bucket.codec, v); err != nil {
+		return nil, nil, fmt.Errorf("value of %x: %w", k, err)
+	}
+	return k, c.v, nil
+}
+
+func (c *kvCodecCursor) Prefix(v []byte) Cursor {
+	c.Cursor = c.Cursor.Prefix(v)
+	return c
+}
+
+func (c *kvCodecCursor) MatchBits(n uint) Cursor {
+	c.Cursor = c.Cursor.MatchBits(n)
+	return c
+}
+
+func (c *kvCodecCursor) Prefetch(v uint) Cursor {
+	c.Cursor = c.Cursor.Prefetch(v)
+	return c
+}
+
+// NoValues returns the cursor, which reports the sizes of the compressed values
+func (c *kvCodecCursor) NoValues() NoValuesCursor {
+	return &kvCodecNoValuesCursor{NoValuesCursor: c.Cursor.NoValues(), bucket: c.bucket}
+}
+
+func (c *kvCodecCursor) First() ([]byte, []byte, error) {
+	return c.decode(c.Cursor.First())
+}
+
+func (c *kvCodecCursor) Seek(seek []byte) ([]byte, []byte, error) {
+	return c.decode(c.Cursor.Seek(seek))
+}
+
+func (c *kvCodecCursor) SeekTo(seek []byte) ([]byte, []byte, error) {
+	return c.decode(c.Cursor.SeekTo(seek))
+}
+
+func (c *kvCodecCursor) Next() ([]byte, []byte, error) {
+	return c.decode(c.Cursor.Next())
+}
+
+func (c *kvCodecCursor) Walk(walker func(k, v []byte) (bool, error)) error {
+	for k, v, err := c.First(); k != nil || err != nil; k, v, err = c.Next() {
+		if err != nil {
+			return err
+		}
+		ok, err := walker(k, v)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return nil
+		}
+	}
+	return nil
+}
+
+// UnsafeValue returns the decoded value, which is in the new memory
+func (c *kvCodecCursor) UnsafeValue() ([]byte, error) {
+	return c.v, nil
+}
+
+func (c kvCodecReverseCursor) Prefix(v []byte) Cursor {
+	c.kvCodecCursor.Prefix(v)
+	return c
+}
+
+func (c kvCodecReverseCursor) MatchBits(n uint) Cursor {
+	c.kvCodecCursor.MatchBits(n)
+	return c
+}
+
+func (c kvCodecReverseCursor) Prefetch(v uint) Cursor {
+	c.kvCodecCursor.Prefetch(v)
+	return c
+}
+
+func (c kvCodecReverseCursor) Prev() ([]byte, []byte, error) {
+	return c.decode(c.Cursor.(ReverseCursor).Prev())
+}
+
+// UnsafeValue decodes the value at the current position
+func (c *kvCodecNoValuesCursor) UnsafeValue() ([]byte, error) {
+	if c.bucket.err != nil {
+		return nil, c.bucket.err
+	}
+	v, err := c.NoValuesCursor.UnsafeValue()
+	if err != nil {
+		return nil, err
+	}
+	return decodeValue(c.bucket.codec, v)
+}
@@ -0,0 +1,380 @@
+package ethdb
+
+import (
+	"bytes"
+	"context"
+	"sort"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+)
+
+// OverlayTx is a read-your-writes layer over a transaction. The writes are kept in memory, while Get and
+// the cursors of its buckets see them merged in the key order with the contents of the underlying buckets.
+// It lets, i.e., compute the state root including the pending changes before writing them into the database.
+// Commit applies the pending writes to the underlying (writable) transaction and commits it
+type OverlayTx struct {
+	tx         Tx
+	puts       *puts
+	journal    undoJournal // Previous pending writes of the keys, see Savepoint
+	savepoints []overlaySavepoint
+}
+
+// overlaySavepoint pairs the savepoint of the pending writes with the savepoint of the underlying transaction,
+// which covers the buckets dropped in it
+type overlaySavepoint struct {
+	pending, tx Savepoint
+}
+
+// NewOverlayTx creates the overlay without pending writes
+func NewOverlayTx(tx Tx) *OverlayTx {
+	return &OverlayTx{tx: tx, puts: newPuts()}
+}
+
+// Bucket implements Tx
+func (o *OverlayTx) Bucket(name []byte) Bucket {
+	return &overlayBucket{o: o, name: common.CopyBytes(name), b: o.tx.Bucket(name)}
+}
+
+// CreateBucket implements Tx, the bucket is created in the underlying transaction immediately
+func (o *OverlayTx) CreateBucket(name []byte) error {
+	return o.tx.CreateBucket(name)
+}
+
+// ExistsBucket implements Tx, the bucket with the pending puts exists
+func (o *OverlayTx) ExistsBucket(name []byte) (bool, error) {
+	for _, v := range o.puts.mp[string(name)] {
+		if v != nil {
+			return true, nil
+		}
+	}
+	return o.tx.ExistsBucket(name)
+}
+
+// DropBucket implements Tx, discarding the pending writes of the bucket and dropping it in the underlying transaction
+func (o *OverlayTx) DropBucket(name []byte) error {
+	if o.journal.recording() {
+		for k, v := range o.puts.mp[string(name)] {
+			o.journal.record(name, []byte(k), v, true)
+		}
+	}
+	o.puts.dropBucket(name)
+	return o.tx.DropBucket(name)
+}
+
+// Savepoint implements Tx. The rollback restores the pending writes, while the buckets dropped after the savepoint
+// are restored by the savepoint of the underlying transaction, in which they are dropped immediately
+func (o *OverlayTx) Savepoint() (Savepoint, error) {
+	txSavepoint, err := o.tx.Savepoint()
+	if err != nil {
+		return 0, err
+	}
+	sp := o.journal.savepoint()
+	o.savepoints = append(o.savepoints, overlaySavepoint{pending: sp, tx: txSavepoint})
+	return sp, nil
+}
+
+// RollbackTo implements Tx
+func (o *OverlayTx) RollbackTo(sp Savepoint) error {
+	i := len(o.savepoints) - 1
+	for i >= 0 && o.savepoints[i].pending != sp {
+		i--
+	}
+	if i < 0 {
+		return ErrInvalidSavepoint
+	}
+	if err := o.journal.rollbackTo(sp, func(e *undoEntry) error {
+		if e.existed {
+			o.puts.set(e.bucket, e.key, e.value)
+		} else {
+			o.puts.unset(e.bucket, e.key)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	if err := o.tx.RollbackTo(o.savepoints[i].tx); err != nil {
+		return err
+	}
+	o.savepoints = o.savepoints[:i+1]
+	return nil
+}
+
+func (o *OverlayTx) recordPending(bucket, key []byte) {
+	v, ok := o.puts.get(bucket, key)
+	o.journal.record(bucket, key, v, ok)
+}
+
+// PendingSize returns the size of the pending writes
+func (o *OverlayTx) PendingSize() int {
+	return o.puts.Size()
+}
+
+// Commit implements Tx, applying the pending writes in the key order
+func (o *OverlayTx) Commit(ctx context.Context) error {
+	buckets := make([]string, 0, len(o.puts.mp))
+	for name := range o.puts.mp {
+		buckets = append(buckets, name)
+	}
+	sort.Strings(buckets)
+	for _, name := range buckets {
+		if err := o.tx.CreateBucket([]byte(name)); err != nil {
+			return err
+		}
+		b := o.tx.Bucket([]byte(name))
+		pending := o.puts.mp[name]
+		for _, k := range sortedPendingKeys(pending) {
+			if v := pending[k]; v == nil {
+				if err := b.Delete([]byte(k)); err != nil {
+					return err
+				}
+			} else if err := b.Put([]byte(k), v); err != nil {
+				return err
+			}
+		}
+	}
+	o.puts = newPuts()
+	o.journal, o.savepoints = undoJournal{}, nil
+	return o.tx.Commit(ctx)
+}
+
+// Rollback implements Tx, discarding the pending writes
+func (o *OverlayTx) Rollback() error {
+	o.puts = newPuts()
+	o.journal, o.savepoints = undoJournal{}, nil
+	return o.tx.Rollback()
+}
+
+func sortedPendingKeys(pending putsBucket) []string {
+	keys := make([]string, 0, len(pending))
+	for k := range pending {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+type overlayBucket struct {
+	o    *OverlayTx
+	name []byte
+	b    Bucket
+}
+
+func (b *overlayBucket) Get(key []byte) ([]byte, error) {
+	if v, ok := b.o.puts.get(b.name, key); ok {
+		return v, nil
+	}
+	return b.b.Get(key)
+}
+
+func (b *overlayBucket) Put(key []byte, value []byte) error {
+	if value == nil {
+		value = []byte{}
+	}
+	if b.o.journal.recording() {
+		b.o.recordPending(b.name, key)
+	}
+	b.o.puts.set(b.name, common.CopyBytes(key), common.CopyBytes(value))
+	return nil
+}
+
+func (b *overlayBucket) Delete(key []byte) error {
+	if b.o.journal.recording() {
+		b.o.recordPending(b.name, key)
+	}
+	b.o.puts.Delete(b.name, common.CopyBytes(key))
+	return nil
+}
+
+// Cursor returns the merge cursor, which sees the writes made before its creation
+func (b *overlayBucket) Cursor() Cursor {
+	pending := b.o.puts.mp[string(b.name)]
+	return &overlayCursor{c: b.b.Cursor(), pending: sortedPendingKeys(pending), values: pending}
+}
+
+// overlayCursor merges the pending writes with the entries of the underlying cursor,
+// the pending entries replace the underlying ones with the same keys, the deleted entries are skipped
+type overlayCursor struct {
+	c         Cursor
+	pending   []string // sorted keys of the pending writes, including the deletions
+	values    putsBucket
+	prefix    []byte
+	i         int // Position in pending
+	dbK, dbV  []byte
+	inPending bool // The current entry is the pending one
+}
+
+func (c *overlayCursor) Prefix(v []byte) Cursor {
+	c.prefix = v
+	c.c = c.c.Prefix(v)
+	return c
+}
+
+func (c *overlayCursor) MatchBits(n uint) Cursor {
+	c.c = c.c.MatchBits(n)
+	return c
+}
+
+func (c *overlayCursor) Prefetch(v uint) Cursor {
+	c.c = c.c.Prefetch(v)
+	return c
+}
+
+func (c *overlayCursor) NoValues() NoValuesCursor {
+	return &overlayNoValuesCursor{overlayCursor: c}
+}
+
+// pendingKey returns the key of the pending entry at the current position, nil if there are no more of them
+func (c *overlayCursor) pendingKey() []byte {
+	if c.i >= len(c.pending) {
+		return nil
+	}
+	k := []byte(c.pending[c.i])
+	if len(c.prefix) > 0 && !bytes.HasPrefix(k, c.prefix) {
+		return nil
+	}
+	return k
+}
+
+// skipPending moves past the pending entry, together with the underlying entry with the same key
+func (c *overlayCursor) skipPending(pk []byte) error {
+	c.i++
+	if c.dbK != nil && bytes.Equal(c.dbK, pk) {
+		var err error
+		c.dbK, c.dbV, err = c.c.Next()
+		return err
+	}
+	return nil
+}
+
+// current chooses the entry with the smaller key out of the pending and the underlying ones
+func (c *overlayCursor) current() ([]byte, []byte, error) {
+	for {
+		pk := c.pendingKey()
+		if pk == nil || (c.dbK != nil && bytes.Compare(c.dbK, pk) < 0) {
+			c.inPending = false
+			return c.dbK, c.dbV, nil
+		}
+		if v := c.values[c.pending[c.i]]; v != nil {
+			c.inPending = true
+			return pk, v, nil
+		}
+		// Deleted by the overlay
+		if err := c.skipPending(pk); err != nil {
+			return nil, nil, err
+		}
+	}
+}
+
+func (c *overlayCursor) seekPending(seek []byte) {
+	if bytes.Compare(seek, c.prefix) < 0 {
+		seek = c.prefix
+	}
+	c.i = sort.SearchStrings(c.pending, string(seek))
+}
+
+func (c *overlayCursor) First() ([]byte, []byte, error) {
+	var err error
+	if c.dbK, c.dbV, err = c.c.First(); err != nil {
+		return nil, nil, err
+	}
+	c.seekPending(nil)
+	return c.current()
+}
+
+func (c *overlayCursor) Seek(seek []byte) ([]byte, []byte, error) {
+	var err error
+	if c.dbK, c.dbV, err = c.c.Seek(seek); err != nil {
+		return nil, nil, err
+	}
+	c.seekPending(seek)
+	return c.current()
+}
+
+func (c *overlayCursor) SeekTo(seek []byte) ([]byte, []byte, error) {
+	var err error
+	if c.dbK, c.dbV, err = c.c.SeekTo(seek); err != nil {
+		return nil, nil, err
+	}
+	c.seekPending(seek)
+	return c.current()
+}
+
+func (c *overlayCursor) Next() ([]byte, []byte, error) {
+	if c.inPending {
+		if err := c.skipPending(c.pendingKey()); err != nil {
+			return nil, nil, err
+		}
+	} else if c.dbK != nil {
+		var err error
+		if c.dbK, c.dbV, err = c.c.Next(); err != nil {
+			return nil, nil, err
+		}
+	}
+	return c.current()
+}
+
+func (c *overlayCursor) Walk(walker func(k, v []byte) (bool, error)) error {
+	for k, v, err := c.First(); k != nil || err != nil; k, v, err = c.Next() {
+		if err != nil {
+			return err
+		}
+		ok, err := walker(k, v)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return nil
+		}
+	}
+	return nil
+}
+
+func (c *overlayCursor) UnsafeValue() ([]byte, error) {
+	if c.inPending {
+		return c.values[c.pending[c.i]], nil
+	}
+	if c.dbK == nil {
+		return nil, nil
+	}
+	return c.dbV, nil
+}
+
+type overlayNoValuesCursor struct {
+	*overlayCursor
+}
+
+func (c *overlayNoValuesCursor) First() ([]byte, uint32, error) {
+	k, v, err := c.overlayCursor.First()
+	return k, uint32(len(v)), err
+}
+
+func (c *overlayNoValuesCursor) Seek(seek []byte) ([]byte, uint32, error) {
+	k, v, err := c.overlayCursor.Seek(seek)
+	return k, uint32(len(v)), err
+}
+
+func (c *overlayNoValuesCursor) SeekTo(seek []byte) ([]byte, uint32, error) {
+	k, v, err := c.overlayCursor.SeekTo(seek)
+	return k, uint32(len(v)), err
+}
+
+func (c *overlayNoValuesCursor) Next() ([]byte, uint32, error) {
+	k, v, err := c.overlayCursor.Next()
+	return k, uint32(len(v)), err
+}
+
+func (c *overlayNoValuesCursor) Walk(walker func(k []byte, vSize uint32) (bool, error)) error {
+	for k, vSize, err := c.First(); k != nil || err != nil; k, vSize, err = c.Next() {
+		if err != nil {
+			return err
+		}
+		ok, err := walker(k, vSize)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return nil
+		}
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package ethdb_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOverlayTx(t *testing.T) {
+	ctx := context.Background()
+	db := ethdb.NewBolt().InMem().MustOpen(ctx)
+	defer db.Close()
+	bucket := dbutils.CurrentStateBucket
+
+	require.NoError(t, db.Update(ctx, func(tx ethdb.Tx) error {
+		b := tx.Bucket(bucket)
+		for _, k := range []string{"a1", "a3", "a5", "b1"} {
+			if err := b.Put([]byte(k), []byte("db"+k)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+
+	collect := func(c ethdb.Cursor) []string {
+		var entries []string
+		require.NoError(t, c.Walk(func(k, v []byte) (bool, error) {
+			entries = append(entries, string(k)+"="+string(v))
+			return true, nil
+		}))
+		return entries
+	}
+
+	tx, err := db.Begin(ctx, true)
+	require.NoError(t, err)
+	overlay := ethdb.NewOverlayTx(tx)
+	b := overlay.Bucket(bucket)
+	require.NoError(t, b.Put([]byte("a0"), []byte("new")))
+	require.NoError(t, b.Put([]byte("a3"), []byte("upd")))
+	require.NoError(t, b.Delete([]byte("a5")))
+	require.NoError(t, b.Put([]byte("a6"), []byte("new")))
+	require.NoError(t, b.Delete([]byte("b1")))
+	require.NoError(t, b.Delete([]byte("c0"))) // Deletion of the missing key
+
+	// Reads see the pending writes
+	v, err := b.Get([]byte("a3"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("upd"), v)
+	v, err = b.Get([]byte("a5"))
+	require.NoError(t, err)
+	assert.Nil(t, v)
+	v, err = b.Get([]byte("a1"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("dba1"), v)
+
+	assert.Equal(t, []string{"a0=new", "a1=dba1", "a3=upd", "a6=new"}, collect(b.Cursor()))
+	assert.Equal(t, []string{"a0=new", "a1=dba1", "a3=upd", "a6=new"}, collect(b.Cursor().Prefix([]byte("a"))))
+	assert.Empty(t, collect(b.Cursor().Prefix([]byte("b"))))
+
+	c := b.Cursor()
+	k, v, err := c.Seek([]byte("a2"))
+	require.NoError(t, err)
+	assert.Equal(t, "a3", string(k))
+	assert.Equal(t, "upd", string(v))
+	k, _, err = c.Next()
+	require.NoError(t, err)
+	assert.Equal(t, "a6", string(k))
+	k, _, err = c.Next()
+	require.NoError(t, err)
+	assert.Nil(t, k)
+
+	var sizes []uint32
+	require.NoError(t, b.Cursor().NoValues().Walk(func(k []byte, vSize uint32) (bool, error) {
+		sizes = append(sizes, vSize)
+		return true, nil
+	}))
+	assert.Equal(t, []uint32{3, 4, 3, 3}, sizes)
+
+	// The underlying bucket is only modified by the commit
+	require.NoError(t, overlay.Commit(ctx))
+	require.NoError(t, db.View(ctx, func(tx ethdb.Tx) error {
+		assert.Equal(t, []string{"a0=new", "a1=dba1", "a3=upd", "a6=new"}, collect(tx.Bucket(bucket).Cursor()))
+		return nil
+	}))
+}
+
+func TestOverlayTxSavepoints(t *testing.T) {
+	ctx := context.Background()
+	db := ethdb.NewBolt().InMem().MustOpen(ctx)
+	defer db.Close()
+	bucket := dbutils.CurrentStateBucket
+	require.NoError(t, db.Update(ctx, func(tx ethdb.Tx) error {
+		return tx.Bucket(bucket).Put([]byte("a1"), []byte("db"))
+	}))
+
+	tx, err := db.Begin(ctx, true)
+	require.NoError(t, err)
+	overlay := ethdb.NewOverlayTx(tx)
+	b := overlay.Bucket(bucket)
+	require.NoError(t, b.Put([]byte("a2"), []byte("new")))
+	sp, err := overlay.Savepoint()
+	require.NoError(t, err)
+	size := overlay.PendingSize()
+	require.NoError(t, b.Put([]byte("a2"), []byte("upd")))
+	require.NoError(t, b.Put([]byte("a3"), []byte("new")))
+	require.NoError(t, overlay.DropBucket(bucket))
+
+	require.NoError(t, overlay.RollbackTo(sp))
+	assert.Equal(t, size, overlay.PendingSize())
+	v, err := b.Get([]byte("a2"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("new"), v)
+	v, err = b.Get([]byte("a3"))
+	require.NoError(t, err)
+	assert.Nil(t, v)
+	// The dropped bucket is restored in the underlying transaction
+	v, err = overlay.Bucket(bucket).Get([]byte("a1"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("db"), v)
+	require.NoError(t, overlay.Rollback())
+}
@@ -2,6 +2,7 @@ package ethdb
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 
@@ -57,6 +58,18 @@ func (opts remoteOpts) Path(path string) remoteOpts {
 	return opts
 }
 
+// MaxConnections sets the size of the pool of connections to the server
+func (opts remoteOpts) MaxConnections(n uint64) remoteOpts {
+	opts.Remote.MaxConnections = n
+	return opts
+}
+
+// ViewRetries sets how many times the read-only transactions are retried, when they fail to begin on the broken connection
+func (opts remoteOpts) ViewRetries(n int) remoteOpts {
+	opts.Remote.ViewRetries = n
+	return opts
+}
+
 //
 //
 // Example text code:
@@ -119,15 +132,18 @@ func (db *remoteDB) Begin(ctx context.Context, writable bool) (Tx, error) {
 }
 
 func (db *remoteDB) View(ctx context.Context, f func(tx Tx) error) (err error) {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	t := &remoteTx{db: db, ctx: ctx}
-	return db.remote.View(ctx, func(tx *remote.Tx) error {
+	return remoteErr(db.remote.View(ctx, func(tx *remote.Tx) error {
 		t.remote = tx
-		return f(t)
-	})
+		return txCtxErr(ctx, f(t))
+	}))
 }
 
 func (db *remoteDB) Update(ctx context.Context, f func(tx Tx) error) (err error) {
-	return fmt.Errorf("remote db provider doesn't support .Update method")
+	return fmt.Errorf("%w: remote db provider doesn't support .Update method", ErrTxReadOnly)
 }
 
 func (tx *remoteTx) Commit(ctx context.Context) error {
@@ -144,6 +160,34 @@ func (tx *remoteTx) Bucket(name []byte) Bucket {
 	return b
 }
 
+func (tx *remoteTx) CreateBucket(name []byte) error {
+	return ErrTxReadOnly
+}
+
+func (tx *remoteTx) ExistsBucket(name []byte) (bool, error) {
+	// The remote bucket is opened by the first operation on it
+	_, err := tx.remote.Bucket(name).Get([]byte{})
+	if err = remoteErr(err); errors.Is(err, ErrBucketNotFound) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (tx *remoteTx) DropBucket(name []byte) error {
+	return ErrTxReadOnly
+}
+
+// Savepoint does nothing, the remote transactions are read-only
+func (tx *remoteTx) Savepoint() (Savepoint, error) {
+	return 0, nil
+}
+
+func (tx *remoteTx) RollbackTo(sp Savepoint) error {
+	return nil
+}
+
 func (tx *remoteTx) cleanup() {
 	// nothing to cleanup
 }
@@ -169,15 +213,22 @@ func (c *remoteCursor) NoValues() NoValuesCursor {
 
 func (b remoteBucket) Get(key []byte) (val []byte, err error) {
 	val, err = b.remote.Get(key)
-	return val, err
+	return val, remoteErr(err)
+}
+
+// GetRange reads up to length bytes of the value starting from the offset, and the size of the whole value,
+// so that only the needed part of a large value is sent over the wire
+func (b remoteBucket) GetRange(key []byte, offset, length uint32) (val []byte, valueSize uint32, err error) {
+	val, valueSize, err = b.remote.GetRange(key, offset, length)
+	return val, valueSize, remoteErr(err)
 }
 
 func (b remoteBucket) Put(key []byte, value []byte) error {
-	panic("not supported")
+	return ErrTxReadOnly
 }
 
 func (b remoteBucket) Delete(key []byte) error {
-	panic("not supported")
+	return ErrTxReadOnly
 }
 
 func (b remoteBucket) Cursor() Cursor {
@@ -187,21 +238,25 @@ func (b remoteBucket) Cursor() Cursor {
 
 func (c *remoteCursor) First() ([]byte, []byte, error) {
 	c.k, c.v, c.err = c.remote.First()
+	c.err = remoteErr(c.err)
 	return c.k, c.v, c.err
 }
 
 func (c *remoteCursor) Seek(seek []byte) ([]byte, []byte, error) {
 	c.k, c.v, c.err = c.remote.Seek(seek)
+	c.err = remoteErr(c.err)
 	return c.k, c.v, c.err
 }
 
 func (c *remoteCursor) SeekTo(seek []byte) ([]byte, []byte, error) {
 	c.k, c.v, c.err = c.remote.SeekTo(seek)
+	c.err = remoteErr(c.err)
 	return c.k, c.v, c.err
 }
 
 func (c *remoteCursor) Next() ([]byte, []byte, error) {
 	c.k, c.v, c.err = c.remote.Next()
+	c.err = remoteErr(c.err)
 	return c.k, c.v, c.err
 }
 
@@ -221,6 +276,17 @@ func (c *remoteCursor) Walk(walker func(k, v []byte) (bool, error)) error {
 	return nil
 }
 
+func (c *remoteCursor) UnsafeValue() ([]byte, error) {
+	return c.v, c.err
+}
+
+func (c *remoteNoValuesCursor) UnsafeValue() ([]byte, error) {
+	if c.k == nil || c.err != nil {
+		return nil, c.err
+	}
+	return c.bucket.Get(c.k)
+}
+
 func (c *remoteNoValuesCursor) Walk(walker func(k []byte, vSize uint32) (bool, error)) error {
 	for k, vSize, err := c.First(); k != nil || err != nil; k, vSize, err = c.Next() {
 		if err != nil {
@@ -240,17 +306,24 @@ func (c *remoteNoValuesCursor) Walk(walker func(k []byte, vSize uint32) (bool, e
 func (c *remoteNoValuesCursor) First() ([]byte, uint32, error) {
 	var vSize uint32
 	c.k, vSize, c.err = c.remote.FirstKey()
+	c.err = remoteErr(c.err)
 	return c.k, vSize, c.err
 }
 
 func (c *remoteNoValuesCursor) Seek(seek []byte) ([]byte, uint32, error) {
 	var vSize uint32
 	c.k, vSize, c.err = c.remote.SeekKey(seek)
+	c.err = remoteErr(c.err)
 	return c.k, vSize, c.err
 }
 
+func (c *remoteNoValuesCursor) SeekTo(seek []byte) ([]byte, uint32, error) {
+	return c.Seek(seek)
+}
+
 func (c *remoteNoValuesCursor) Next() ([]byte, uint32, error) {
 	var vSize uint32
 	c.k, vSize, c.err = c.remote.NextKey()
+	c.err = remoteErr(c.err)
 	return c.k, vSize, c.err
 }
@@ -0,0 +1,467 @@
+package ethdb
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/ethdb/remote"
+	"github.com/ledgerwatch/turbo-geth/ethdb/remote/remotekv"
+	"github.com/ledgerwatch/turbo-geth/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/keepalive"
+)
+
+type grpcRemoteOpts struct {
+	addr     string
+	dialOpts []grpc.DialOption
+}
+
+type grpcRemoteDB struct {
+	opts   grpcRemoteOpts
+	conn   *grpc.ClientConn
+	remote remotekv.KVClient
+	log    log.Logger
+}
+
+type grpcRemoteTx struct {
+	ctx    context.Context
+	cancel context.CancelFunc // Cancels the context of the stream
+	db     *grpcRemoteDB
+	stream remotekv.KV_TxClient
+	mu     sync.Mutex // request and response must not interleave
+}
+
+type grpcRemoteBucket struct {
+	tx   *grpcRemoteTx
+	name []byte
+}
+
+type grpcRemoteCursor struct {
+	ctx         context.Context
+	bucket      grpcRemoteBucket
+	prefix      []byte
+	matchBits   uint // Number of the leading bits of the prefix, which the keys must match, 0 - whole prefix
+	prefetch    uint32
+	noValues    bool
+	id          uint32
+	opened      bool
+	cache       []*remotekv.Pair
+	cacheIdx    int
+	endOfCursor bool // the last pair in the cache is the end of the cursor
+	k, v        []byte
+	err         error
+}
+
+type grpcRemoteNoValuesCursor struct {
+	*grpcRemoteCursor
+}
+
+func (opts grpcRemoteOpts) Path(addr string) grpcRemoteOpts {
+	opts.addr = addr
+	return opts
+}
+
+// DialOptions adds options to grpc.Dial, i.e. grpc.WithContextDialer for in-memory connections in tests
+func (opts grpcRemoteOpts) DialOptions(dialOpts ...grpc.DialOption) grpcRemoteOpts {
+	opts.dialOpts = append(opts.dialOpts, dialOpts...)
+	return opts
+}
+
+func (opts grpcRemoteOpts) Open(ctx context.Context) (KV, error) {
+	dialOpts := append([]grpc.DialOption{
+		grpc.WithInsecure(),
+		grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:                5 * time.Minute,
+			Timeout:             10 * time.Second,
+			PermitWithoutStream: true,
+		}),
+	}, opts.dialOpts...)
+	conn, err := grpc.DialContext(ctx, opts.addr, dialOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to remote db: %w, addr=%s", err, opts.addr)
+	}
+	return &grpcRemoteDB{
+		opts:   opts,
+		conn:   conn,
+		remote: remotekv.NewKVClient(conn),
+		log:    log.New("remote_db", opts.addr),
+	}, nil
+}
+
+func (opts grpcRemoteOpts) MustOpen(ctx context.Context) KV {
+	db, err := opts.Open(ctx)
+	if err != nil {
+		panic(err)
+	}
+	return db
+}
+
+// NewGrpcRemote - client of the gRPC variant of the remote database protocol,
+// served by remotedbserver.StartGrpc
+func NewGrpcRemote() grpcRemoteOpts {
+	return grpcRemoteOpts{}
+}
+
+// Close closes the connection
+// All transactions must be closed before closing the database.
+func (db *grpcRemoteDB) Close() {
+	if err := db.conn.Close(); err != nil {
+		db.log.Warn("failed to close remote DB", "err", err)
+	} else {
+		db.log.Info("remote database closed")
+	}
+}
+
+func (db *grpcRemoteDB) Begin(ctx context.Context, writable bool) (Tx, error) {
+	if writable {
+		return nil, fmt.Errorf("%w: remote db provider doesn't support writable transactions", ErrTxReadOnly)
+	}
+	streamCtx, cancel := context.WithCancel(ctx)
+	stream, err := db.remote.Tx(streamCtx)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return &grpcRemoteTx{ctx: ctx, cancel: cancel, db: db, stream: stream}, nil
+}
+
+func (db *grpcRemoteDB) View(ctx context.Context, f func(tx Tx) error) (err error) {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	tx, err := db.Begin(ctx, false)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil && err == nil {
+			err = rollbackErr
+		}
+	}()
+	return txCtxErr(ctx, f(tx))
+}
+
+func (db *grpcRemoteDB) Update(ctx context.Context, f func(tx Tx) error) (err error) {
+	return fmt.Errorf("%w: remote db provider doesn't support .Update method", ErrTxReadOnly)
+}
+
+func (tx *grpcRemoteTx) Commit(ctx context.Context) error {
+	return ErrTxReadOnly
+}
+
+// Rollback closes the stream, server rolls back the transaction. CloseSend only half-closes the stream,
+// so its context is cancelled too, otherwise the stream is not released until the server responds
+func (tx *grpcRemoteTx) Rollback() error {
+	defer tx.cancel()
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	if tx.stream == nil {
+		return nil
+	}
+	err := tx.stream.CloseSend()
+	tx.stream = nil
+	return err
+}
+
+// roundTrip sends the request and waits for the response to it
+func (tx *grpcRemoteTx) roundTrip(req *remotekv.TxRequest) (*remotekv.TxResponse, error) {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	if tx.stream == nil {
+		return nil, ErrClosed
+	}
+	if err := tx.stream.Send(req); err != nil {
+		return nil, err
+	}
+	resp, err := tx.stream.Recv()
+	if err != nil {
+		return nil, err
+	}
+	if resp.Error != "" {
+		return nil, remoteErr(&remote.Error{Code: resp.ErrorCode, Message: resp.Error})
+	}
+	return resp, nil
+}
+
+func (tx *grpcRemoteTx) Bucket(name []byte) Bucket {
+	return grpcRemoteBucket{tx: tx, name: name}
+}
+
+func (tx *grpcRemoteTx) CreateBucket(name []byte) error {
+	return ErrTxReadOnly
+}
+
+func (tx *grpcRemoteTx) ExistsBucket(name []byte) (bool, error) {
+	_, err := tx.roundTrip(&remotekv.TxRequest{Op: remotekv.Op_GET, BucketName: name, Key: []byte{}})
+	if errors.Is(err, ErrBucketNotFound) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (tx *grpcRemoteTx) DropBucket(name []byte) error {
+	return ErrTxReadOnly
+}
+
+// Savepoint does nothing, the remote transactions are read-only
+func (tx *grpcRemoteTx) Savepoint() (Savepoint, error) {
+	return 0, nil
+}
+
+func (tx *grpcRemoteTx) RollbackTo(sp Savepoint) error {
+	return nil
+}
+
+func (b grpcRemoteBucket) Get(key []byte) (val []byte, err error) {
+	resp, err := b.tx.roundTrip(&remotekv.TxRequest{Op: remotekv.Op_GET, BucketName: b.name, Key: key})
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Pairs) == 0 {
+		return nil, nil
+	}
+	return resp.Pairs[0].Value, nil
+}
+
+func (b grpcRemoteBucket) Put(key []byte, value []byte) error {
+	return ErrTxReadOnly
+}
+
+func (b grpcRemoteBucket) Delete(key []byte) error {
+	return ErrTxReadOnly
+}
+
+func (b grpcRemoteBucket) Cursor() Cursor {
+	return &grpcRemoteCursor{ctx: b.tx.ctx, bucket: b, prefetch: uint32(remote.DefaultCursorBatchSize)}
+}
+
+func (c *grpcRemoteCursor) Prefix(v []byte) Cursor {
+	c.prefix = v
+	return c
+}
+
+// MatchBits limits the cursor to the keys matching the first n bits of the prefix. The server filters the keys
+// by the whole bytes of the prefix, the remaining bits are checked on the client side
+func (c *grpcRemoteCursor) MatchBits(n uint) Cursor {
+	c.matchBits = n
+	return c
+}
+
+// serverPrefix returns the prefix of the keys filtered by the server
+func (c *grpcRemoteCursor) serverPrefix() []byte {
+	if c.matchBits == 0 || int(c.matchBits/8) >= len(c.prefix) {
+		return c.prefix
+	}
+	return c.prefix[:c.matchBits/8]
+}
+
+// matches tells whether the key matches the bits of the prefix set by MatchBits
+func (c *grpcRemoteCursor) matches(k []byte) bool {
+	if c.matchBits == 0 || len(k) == 0 {
+		return true
+	}
+	matchBytes, mask := Bytesmask(int(c.matchBits))
+	if matchBytes > len(c.prefix) {
+		matchBytes, mask = len(c.prefix), 0xff
+	}
+	return matchFixedBits(k, c.prefix, matchBytes, mask)
+}
+
+// seekBits moves the seek key to the first key matching the bits of the prefix, the server positions the cursor
+// by the whole bytes only and the keys before it would end the cursor in checkBits
+func (c *grpcRemoteCursor) seekBits(seek []byte) []byte {
+	if c.matchBits == 0 {
+		return seek
+	}
+	matchBytes, mask := Bytesmask(int(c.matchBits))
+	if matchBytes > len(c.prefix) {
+		matchBytes, mask = len(c.prefix), 0xff
+	}
+	start := common.CopyBytes(c.prefix[:matchBytes])
+	if matchBytes > 0 {
+		start[matchBytes-1] &= mask
+	}
+	if bytes.Compare(seek, start) < 0 {
+		return start
+	}
+	return seek
+}
+
+func (c *grpcRemoteCursor) Prefetch(v uint) Cursor {
+	c.prefetch = uint32(v)
+	return c
+}
+
+func (c *grpcRemoteCursor) NoValues() NoValuesCursor {
+	c.noValues = true
+	return &grpcRemoteNoValuesCursor{grpcRemoteCursor: c}
+}
+
+// fetch moves the cursor on the server side, the server sends up to `prefetch` pairs back
+func (c *grpcRemoteCursor) fetch(op remotekv.Op, seek []byte) (*remotekv.Pair, error) {
+	select {
+	case <-c.ctx.Done():
+		return nil, c.ctx.Err()
+	default:
+	}
+
+	if !c.opened {
+		resp, err := c.bucket.tx.roundTrip(&remotekv.TxRequest{
+			Op:         remotekv.Op_OPEN_CURSOR,
+			BucketName: c.bucket.name,
+			Key:        c.serverPrefix(),
+			NoValues:   c.noValues,
+		})
+		if err != nil {
+			return nil, err
+		}
+		c.id = resp.Cursor
+		c.opened = true
+	}
+	resp, err := c.bucket.tx.roundTrip(&remotekv.TxRequest{Op: op, Cursor: c.id, Key: seek, Count: c.prefetch})
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Pairs) == 0 {
+		return nil, fmt.Errorf("remote cursor returned no pairs for %s", op)
+	}
+	c.cache = resp.Pairs
+	c.cacheIdx = 0
+	c.endOfCursor = len(resp.Pairs[len(resp.Pairs)-1].Key) == 0
+	return c.checkBits(c.cache[0]), nil
+}
+
+// checkBits ends the cursor at the first key, which does not match the bits of the prefix. The keys are sorted,
+// so none of the following keys match either
+func (c *grpcRemoteCursor) checkBits(pair *remotekv.Pair) *remotekv.Pair {
+	if c.matches(pair.Key) {
+		return pair
+	}
+	c.cache = nil
+	c.endOfCursor = true
+	return &remotekv.Pair{}
+}
+
+// next checks the context itself, the moves within the prefetched pairs make no round trips, which would fail
+// on the cancelled stream
+func (c *grpcRemoteCursor) next() (*remotekv.Pair, error) {
+	select {
+	case <-c.ctx.Done():
+		return nil, c.ctx.Err()
+	default:
+	}
+
+	if c.cacheIdx+1 < len(c.cache) {
+		c.cacheIdx++
+		return c.checkBits(c.cache[c.cacheIdx]), nil
+	}
+	if c.endOfCursor {
+		return &remotekv.Pair{}, nil
+	}
+	return c.fetch(remotekv.Op_NEXT, nil)
+}
+
+func (c *grpcRemoteCursor) set(pair *remotekv.Pair, err error) ([]byte, []byte, error) {
+	c.k, c.v, c.err = nil, nil, err
+	if err == nil && len(pair.Key) > 0 {
+		c.k, c.v = pair.Key, pair.Value
+	}
+	return c.k, c.v, c.err
+}
+
+func (c *grpcRemoteCursor) First() ([]byte, []byte, error) {
+	if c.matchBits > 0 {
+		return c.Seek(nil)
+	}
+	return c.set(c.fetch(remotekv.Op_FIRST, nil))
+}
+
+func (c *grpcRemoteCursor) Seek(seek []byte) ([]byte, []byte, error) {
+	return c.set(c.fetch(remotekv.Op_SEEK, c.seekBits(seek)))
+}
+
+func (c *grpcRemoteCursor) SeekTo(seek []byte) ([]byte, []byte, error) {
+	return c.Seek(seek)
+}
+
+func (c *grpcRemoteCursor) Next() ([]byte, []byte, error) {
+	return c.set(c.next())
+}
+
+func (c *grpcRemoteCursor) Walk(walker func(k, v []byte) (bool, error)) error {
+	for k, v, err := c.First(); k != nil || err != nil; k, v, err = c.Next() {
+		if err != nil {
+			return err
+		}
+		ok, err := walker(k, v)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return nil
+		}
+	}
+	return nil
+}
+
+func (c *grpcRemoteCursor) UnsafeValue() ([]byte, error) {
+	return c.v, c.err
+}
+
+func (c *grpcRemoteNoValuesCursor) UnsafeValue() ([]byte, error) {
+	if c.k == nil || c.err != nil {
+		return nil, c.err
+	}
+	return c.bucket.Get(c.k)
+}
+
+func (c *grpcRemoteNoValuesCursor) setKey(pair *remotekv.Pair, err error) ([]byte, uint32, error) {
+	c.k, c.v, c.err = nil, nil, err
+	if err != nil || len(pair.Key) == 0 {
+		return nil, 0, err
+	}
+	c.k = pair.Key
+	return c.k, pair.ValueSize, nil
+}
+
+func (c *grpcRemoteNoValuesCursor) First() ([]byte, uint32, error) {
+	if c.matchBits > 0 {
+		return c.Seek(nil)
+	}
+	return c.setKey(c.fetch(remotekv.Op_FIRST, nil))
+}
+
+func (c *grpcRemoteNoValuesCursor) Seek(seek []byte) ([]byte, uint32, error) {
+	return c.setKey(c.fetch(remotekv.Op_SEEK, c.seekBits(seek)))
+}
+
+func (c *grpcRemoteNoValuesCursor) SeekTo(seek []byte) ([]byte, uint32, error) {
+	return c.Seek(seek)
+}
+
+func (c *grpcRemoteNoValuesCursor) Next() ([]byte, uint32, error) {
+	return c.setKey(c.next())
+}
+
+func (c *grpcRemoteNoValuesCursor) Walk(walker func(k []byte, vSize uint32) (bool, error)) error {
+	for k, vSize, err := c.First(); k != nil || err != nil; k, vSize, err = c.Next() {
+		if err != nil {
+			return err
+		}
+		ok, err := walker(k, vSize)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return nil
+		}
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package ethdb
+
+import (
+	"errors"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+)
+
+// Savepoint marks the state of the transaction, to which it can be rolled back by Tx.RollbackTo
+type Savepoint int
+
+// ErrInvalidSavepoint is returned by Tx.RollbackTo for the savepoint, which was not taken in the transaction
+// or was discarded by the rollback to an earlier savepoint
+var ErrInvalidSavepoint = errors.New("invalid savepoint")
+
+// undoEntry is the state of the key before the write
+type undoEntry struct {
+	bucket  []byte
+	key     []byte
+	value   []byte
+	existed bool
+}
+
+// undoJournal emulates the savepoints for the backends, which do not support the nested transactions.
+// Once a savepoint is taken, the previous states of the written keys are recorded until the end of the transaction,
+// and the rollback restores them in the reverse order. The buckets created after the savepoint stay in place
+type undoJournal struct {
+	entries   []undoEntry
+	marks     []int // Positions in entries, at which the savepoints were taken
+	replaying bool
+}
+
+func (j *undoJournal) savepoint() Savepoint {
+	j.marks = append(j.marks, len(j.entries))
+	return Savepoint(len(j.entries))
+}
+
+// recording tells whether the writes need to be recorded
+func (j *undoJournal) recording() bool {
+	return len(j.marks) > 0 && !j.replaying
+}
+
+func (j *undoJournal) record(bucket, key, value []byte, existed bool) {
+	j.entries = append(j.entries, undoEntry{
+		bucket:  common.CopyBytes(bucket),
+		key:     common.CopyBytes(key),
+		value:   common.CopyBytes(value),
+		existed: existed,
+	})
+}
+
+// rollbackTo undoes the writes made after the savepoint with the restore function, the savepoint stays valid,
+// the savepoints taken after it are discarded
+func (j *undoJournal) rollbackTo(sp Savepoint, restore func(e *undoEntry) error) error {
+	i := len(j.marks) - 1
+	for i >= 0 && j.marks[i] != int(sp) {
+		i--
+	}
+	if i < 0 {
+		return ErrInvalidSavepoint
+	}
+	j.replaying = true
+	defer func() { j.replaying = false }()
+	for k := len(j.entries) - 1; k >= int(sp); k-- {
+		if err := restore(&j.entries[k]); err != nil {
+			return err
+		}
+		j.entries = j.entries[:k]
+	}
+	j.marks = j.marks[:i+1]
+	return nil
+}
+
+// restoreKV puts the recorded value back into the bucket of the transaction, or deletes the key, which was absent
+func restoreKV(tx Tx) func(e *undoEntry) error {
+	return func(e *undoEntry) error {
+		if !e.existed {
+			return tx.Bucket(e.bucket).Delete(e.key)
+		}
+		if err := tx.CreateBucket(e.bucket); err != nil {
+			return err
+		}
+		return tx.Bucket(e.bucket).Put(e.key, e.value)
+	}
+}
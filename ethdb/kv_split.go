@@ -0,0 +1,237 @@
+package ethdb
+
+import (
+	"context"
+	"encoding/binary"
+
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+)
+
+// splitKV is the abstract KV of SplitDatabase. Its transactions span both databases and route the buckets by name,
+// so the users of the abstract KV (i.e. the remote servers and the history readers) see all the buckets.
+// The writes into the history buckets are journaled in the state database, like the ones of SplitDatabase.MultiPut
+type splitKV struct {
+	d             *SplitDatabase
+	main, history KV
+}
+
+type splitTx struct {
+	d             *SplitDatabase
+	main, history Tx
+	writable      bool
+	journaled     uint32 // Number of the history writes journaled by the transaction
+	savepoints    []splitSavepoint
+}
+
+type splitSavepoint struct {
+	main, history Savepoint
+	journaled     uint32
+}
+
+// journalingBucket is the history bucket of the writable transaction, which journals the writes before doing them
+type journalingBucket struct {
+	Bucket
+	tx   *splitTx
+	name []byte
+}
+
+// AbstractKV returns the abstract KV over both databases
+func (d *SplitDatabase) AbstractKV() KV {
+	return &splitKV{d: d, main: d.main.AbstractKV(), history: d.history.AbstractKV()}
+}
+
+// View reads the history left in the journal by the failed write after the journal is replayed, see settleJournal
+func (kv *splitKV) View(ctx context.Context, f func(tx Tx) error) error {
+	if err := kv.d.settleJournal(); err != nil {
+		return err
+	}
+	return kv.main.View(ctx, func(mainTx Tx) error {
+		return kv.history.View(ctx, func(historyTx Tx) error {
+			return f(&splitTx{d: kv.d, main: mainTx, history: historyTx})
+		})
+	})
+}
+
+func (kv *splitKV) Update(ctx context.Context, f func(tx Tx) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	tx, err := kv.Begin(ctx, true)
+	if err != nil {
+		return err
+	}
+	if err := txCtxErr(ctx, f(tx)); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// Close does nothing, the databases are closed by SplitDatabase.Close
+func (kv *splitKV) Close() {}
+
+// Begin opens the transactions in both databases. The writable transaction holds the lock of the journal
+// until it is committed or rolled back, so it does not interleave with SplitDatabase.MultiPut
+func (kv *splitKV) Begin(ctx context.Context, writable bool) (Tx, error) {
+	if writable {
+		kv.d.journalMu.Lock()
+		if kv.d.isJournalDirty() {
+			if err := kv.d.recoverJournal(); err != nil {
+				kv.d.journalMu.Unlock()
+				return nil, err
+			}
+		}
+	}
+	mainTx, err := kv.main.Begin(ctx, writable)
+	if err != nil {
+		if writable {
+			kv.d.journalMu.Unlock()
+		}
+		return nil, err
+	}
+	historyTx, err := kv.history.Begin(ctx, writable)
+	if err != nil {
+		_ = mainTx.Rollback()
+		if writable {
+			kv.d.journalMu.Unlock()
+		}
+		return nil, err
+	}
+	return &splitTx{d: kv.d, main: mainTx, history: historyTx, writable: writable}, nil
+}
+
+func (tx *splitTx) route(name []byte) Tx {
+	if tx.d.route(name) == tx.d.history {
+		return tx.history
+	}
+	return tx.main
+}
+
+func (tx *splitTx) Bucket(name []byte) Bucket {
+	b := tx.route(name).Bucket(name)
+	if tx.writable && tx.d.route(name) == tx.d.history {
+		return &journalingBucket{Bucket: b, tx: tx, name: name}
+	}
+	return b
+}
+
+func (tx *splitTx) CreateBucket(name []byte) error {
+	return tx.route(name).CreateBucket(name)
+}
+
+func (tx *splitTx) ExistsBucket(name []byte) (bool, error) {
+	return tx.route(name).ExistsBucket(name)
+}
+
+// DropBucket journals the deletion of every key of the history bucket, before dropping it
+func (tx *splitTx) DropBucket(name []byte) error {
+	if tx.d.route(name) != tx.d.history {
+		return tx.main.DropBucket(name)
+	}
+	exists, err := tx.history.ExistsBucket(name)
+	if err != nil || !exists {
+		return err
+	}
+	if err := tx.history.Bucket(name).Cursor().Walk(func(k, _ []byte) (bool, error) {
+		return true, tx.journal(name, k, nil)
+	}); err != nil {
+		return err
+	}
+	return tx.history.DropBucket(name)
+}
+
+// journal records the history write in the state transaction, see encodeJournalRecord
+func (tx *splitTx) journal(bucket, key, value []byte) error {
+	if tx.journaled == 0 {
+		if err := tx.main.CreateBucket(dbutils.HistoryJournalBucket); err != nil {
+			return err
+		}
+	}
+	idx := make([]byte, 4)
+	binary.BigEndian.PutUint32(idx, tx.journaled)
+	if err := tx.main.Bucket(dbutils.HistoryJournalBucket).Put(idx, encodeJournalRecord(bucket, key, value)); err != nil {
+		return err
+	}
+	tx.journaled++
+	return nil
+}
+
+func (tx *splitTx) Savepoint() (Savepoint, error) {
+	mainSavepoint, err := tx.main.Savepoint()
+	if err != nil {
+		return 0, err
+	}
+	historySavepoint, err := tx.history.Savepoint()
+	if err != nil {
+		return 0, err
+	}
+	tx.savepoints = append(tx.savepoints, splitSavepoint{main: mainSavepoint, history: historySavepoint, journaled: tx.journaled})
+	return Savepoint(len(tx.savepoints) - 1), nil
+}
+
+// RollbackTo undoes the writes in both databases, the journal records are undone together with the state writes
+func (tx *splitTx) RollbackTo(sp Savepoint) error {
+	if int(sp) < 0 || int(sp) >= len(tx.savepoints) {
+		return ErrInvalidSavepoint
+	}
+	s := tx.savepoints[sp]
+	if err := tx.main.RollbackTo(s.main); err != nil {
+		return err
+	}
+	if err := tx.history.RollbackTo(s.history); err != nil {
+		return err
+	}
+	tx.journaled = s.journaled
+	tx.savepoints = tx.savepoints[:sp+1]
+	return nil
+}
+
+// Commit commits the state transaction together with the journal first, so the history writes are replayed
+// by SplitDatabase.RecoverJournal, if the history transaction fails to commit
+func (tx *splitTx) Commit(ctx context.Context) error {
+	if !tx.writable {
+		return tx.Rollback()
+	}
+	defer tx.d.journalMu.Unlock()
+	if err := tx.main.Commit(ctx); err != nil {
+		_ = tx.history.Rollback()
+		return err
+	}
+	if tx.journaled == 0 {
+		return tx.history.Commit(ctx)
+	}
+	tx.d.setJournalDirty(true)
+	if err := tx.history.Commit(ctx); err != nil {
+		return err
+	}
+	if err := tx.d.trimJournal(); err != nil {
+		return err
+	}
+	tx.d.setJournalDirty(false)
+	return nil
+}
+
+func (tx *splitTx) Rollback() error {
+	if tx.writable {
+		defer tx.d.journalMu.Unlock()
+	}
+	err := tx.history.Rollback()
+	if mainErr := tx.main.Rollback(); err == nil {
+		err = mainErr
+	}
+	return err
+}
+
+func (b *journalingBucket) Put(key []byte, value []byte) error {
+	if err := b.tx.journal(b.name, key, value); err != nil {
+		return err
+	}
+	return b.Bucket.Put(key, value)
+}
+
+func (b *journalingBucket) Delete(key []byte) error {
+	if err := b.tx.journal(b.name, key, nil); err != nil {
+		return err
+	}
+	return b.Bucket.Delete(key)
+}
@@ -38,7 +38,7 @@ func NewMemDatabase() *BoltDatabase {
 				return err
 			}
 		}
-		return nil
+		return recordBoltBucketCodecs(tx)
 	}); err != nil {
 		panic(err)
 	}
@@ -67,7 +67,7 @@ func NewMemDatabase2() (*BoltDatabase, *bolt.DB) {
 				return err
 			}
 		}
-		return nil
+		return recordBoltBucketCodecs(tx)
 	}); err != nil {
 		panic(err)
 	}
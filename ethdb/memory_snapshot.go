@@ -0,0 +1,165 @@
+package ethdb
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+	"golang.org/x/crypto/sha3"
+)
+
+// MemSnapshot is a copy of the contents of the buckets (dbutils.Buckets) of a database, taken by SnapshotKV.
+// The copy is kept in the in-memory Bolt database and is read through the KV interface like any other backend.
+// It is meant for the tests working with NewMemDatabase: the database can be reset to the snapshot between
+// the test cases with RestoreKV, and the whole contents can be compared by Hash
+type MemSnapshot struct {
+	KV
+}
+
+// SnapshotKV copies the contents of the buckets of the database into the in-memory Bolt database.
+// The snapshot has to be closed
+func SnapshotKV(ctx context.Context, db KV) (*MemSnapshot, error) {
+	kv, err := NewBolt().InMem().Open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err = copyBuckets(ctx, db, kv); err != nil {
+		kv.Close()
+		return nil, err
+	}
+	return &MemSnapshot{KV: kv}, nil
+}
+
+// RestoreKV replaces the contents of the buckets of the database by the contents of the snapshot, in one transaction
+func RestoreKV(ctx context.Context, db KV, s *MemSnapshot) error {
+	return copyBuckets(ctx, s.KV, db)
+}
+
+// copyBuckets replaces the buckets of dst by the buckets of src. The buckets of dst are dropped and created again,
+// so the old entries are not deleted one by one. The values are copied decoded, and compressed by the codecs
+// recorded in dst, which are not replaced
+func copyBuckets(ctx context.Context, src, dst KV) error {
+	return src.View(ctx, func(srcTx Tx) error {
+		return dst.Update(ctx, func(dstTx Tx) error {
+			for _, name := range dbutils.Buckets {
+				if bytes.Equal(name, dbutils.BucketCodecsBucket) {
+					continue
+				}
+				if err := dstTx.DropBucket(name); err != nil {
+					return err
+				}
+				if err := dstTx.CreateBucket(name); err != nil {
+					return err
+				}
+				b := dstTx.Bucket(name)
+				if err := srcTx.Bucket(name).Cursor().Walk(func(k, v []byte) (bool, error) {
+					return true, b.Put(common.CopyBytes(k), common.CopyBytes(v))
+				}); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	})
+}
+
+// Snapshot copies the contents of the buckets, see SnapshotKV
+func (db *BoltDatabase) Snapshot() (*MemSnapshot, error) {
+	return SnapshotKV(context.Background(), db.AbstractKV())
+}
+
+// Restore replaces the contents of the buckets by the contents of the snapshot, see RestoreKV
+func (db *BoltDatabase) Restore(s *MemSnapshot) error {
+	return RestoreKV(context.Background(), db.AbstractKV(), s)
+}
+
+// Hash returns the hash of the contents of the snapshot, see ContentHashKV
+func (s *MemSnapshot) Hash() common.Hash {
+	h, err := ContentHashKV(context.Background(), s.KV)
+	if err != nil {
+		// The in-memory database can only fail if it is closed
+		panic(err)
+	}
+	return h
+}
+
+// Equal reports whether the snapshots have the same contents
+func (s *MemSnapshot) Equal(other *MemSnapshot) bool {
+	var equal = true
+	if err := s.View(context.Background(), func(tx Tx) error {
+		return other.View(context.Background(), func(otherTx Tx) error {
+			for _, name := range dbutils.Buckets {
+				c, otherC := tx.Bucket(name).Cursor(), otherTx.Bucket(name).Cursor()
+				k, v, err := c.First()
+				if err != nil {
+					return err
+				}
+				otherK, otherV, err := otherC.First()
+				if err != nil {
+					return err
+				}
+				for k != nil || otherK != nil {
+					if !bytes.Equal(k, otherK) || !bytes.Equal(v, otherV) || (k == nil) != (otherK == nil) {
+						equal = false
+						return nil
+					}
+					if k, v, err = c.Next(); err != nil {
+						return err
+					}
+					if otherK, otherV, err = otherC.Next(); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		})
+	}); err != nil {
+		return false
+	}
+	return equal
+}
+
+// ContentHashKV returns the hash of the contents of the buckets (dbutils.Buckets) of the database. Empty buckets
+// do not contribute to it, so two databases with the same entries have the same hash regardless of the set
+// of the created buckets. The values are hashed decoded, and the recorded codecs are left out
+func ContentHashKV(ctx context.Context, db KV) (common.Hash, error) {
+	h := sha3.NewLegacyKeccak256()
+	var l [5]byte
+	// The tag tells the name of the bucket from the key of the entry
+	write := func(tag byte, b []byte) {
+		l[0] = tag
+		binary.BigEndian.PutUint32(l[1:], uint32(len(b)))
+		h.Write(l[:])
+		h.Write(b)
+	}
+	if err := db.View(ctx, func(tx Tx) error {
+		for _, name := range dbutils.Buckets {
+			if bytes.Equal(name, dbutils.BucketCodecsBucket) {
+				continue
+			}
+			var written bool
+			if err := tx.Bucket(name).Cursor().Walk(func(k, v []byte) (bool, error) {
+				if !written {
+					write(0, name)
+					written = true
+				}
+				write(1, k)
+				write(2, v)
+				return true, nil
+			}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return common.Hash{}, err
+	}
+	return common.BytesToHash(h.Sum(nil)), nil
+}
+
+// ContentHash returns the hash of the contents of the buckets of the database, see ContentHashKV
+func (db *BoltDatabase) ContentHash() (common.Hash, error) {
+	return ContentHashKV(context.Background(), db.AbstractKV())
+}
@@ -0,0 +1,107 @@
+package ethdb
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemSnapshot(t *testing.T) {
+	db := NewMemDatabase()
+	defer db.Close()
+	emptyHash, err := db.ContentHash()
+	require.NoError(t, err)
+
+	require.NoError(t, db.Put(dbutils.CurrentStateBucket, []byte("a"), []byte("1")))
+	require.NoError(t, db.Put(dbutils.CurrentStateBucket, []byte("b"), []byte("2")))
+	require.NoError(t, db.Put(dbutils.CodeBucket, []byte("a"), []byte("3")))
+	snapshot, err := db.Snapshot()
+	require.NoError(t, err)
+	defer snapshot.Close()
+	assert.NotEqual(t, emptyHash, snapshot.Hash())
+
+	// The same contents written in a different order into another database have the same hash
+	other := NewMemDatabase()
+	defer other.Close()
+	require.NoError(t, other.Put(dbutils.CodeBucket, []byte("a"), []byte("3")))
+	require.NoError(t, other.Put(dbutils.CurrentStateBucket, []byte("b"), []byte("2")))
+	require.NoError(t, other.Put(dbutils.CurrentStateBucket, []byte("a"), []byte("1")))
+	otherSnapshot, err := other.Snapshot()
+	require.NoError(t, err)
+	defer otherSnapshot.Close()
+	assert.Equal(t, snapshot.Hash(), otherSnapshot.Hash())
+	assert.True(t, snapshot.Equal(otherSnapshot))
+
+	// The same key and value in a different bucket is a different content
+	require.NoError(t, other.Delete(dbutils.CodeBucket, []byte("a")))
+	require.NoError(t, other.Put(dbutils.ContractCodeBucket, []byte("a"), []byte("3")))
+	otherHash, err := other.ContentHash()
+	require.NoError(t, err)
+	assert.NotEqual(t, snapshot.Hash(), otherHash)
+
+	// Modifications are undone by the restore
+	require.NoError(t, db.Delete(dbutils.CurrentStateBucket, []byte("a")))
+	require.NoError(t, db.Put(dbutils.CurrentStateBucket, []byte("b"), []byte("22")))
+	require.NoError(t, db.Put(dbutils.HeaderPrefix, []byte("c"), []byte("4")))
+	modifiedHash, err := db.ContentHash()
+	require.NoError(t, err)
+	assert.NotEqual(t, snapshot.Hash(), modifiedHash)
+
+	require.NoError(t, db.Restore(snapshot))
+	restoredHash, err := db.ContentHash()
+	require.NoError(t, err)
+	assert.Equal(t, snapshot.Hash(), restoredHash)
+	v, err := db.Get(dbutils.CurrentStateBucket, []byte("a"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("1"), v)
+	_, err = db.Get(dbutils.HeaderPrefix, []byte("c"))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// The snapshot can be restored repeatedly
+	require.NoError(t, db.Put(dbutils.CodeBucket, []byte("a"), []byte("33")))
+	require.NoError(t, db.Restore(snapshot))
+	v, err = db.Get(dbutils.CodeBucket, []byte("a"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("3"), v)
+}
+
+func TestMemSnapshotKV(t *testing.T) {
+	ctx := context.Background()
+	db := NewMemDatabase()
+	defer db.Close()
+	require.NoError(t, db.Put(dbutils.CurrentStateBucket, []byte("a"), []byte("1")))
+	require.NoError(t, db.Put(dbutils.CodeBucket, []byte("b"), []byte("2")))
+	snapshot, err := db.Snapshot()
+	require.NoError(t, err)
+	defer snapshot.Close()
+
+	// The snapshot is read like any other database, and is not affected by the later writes
+	require.NoError(t, db.Put(dbutils.CurrentStateBucket, []byte("a"), []byte("11")))
+	require.NoError(t, snapshot.View(ctx, func(tx Tx) error {
+		v, err := tx.Bucket(dbutils.CurrentStateBucket).Get([]byte("a"))
+		require.NoError(t, err)
+		assert.Equal(t, []byte("1"), v)
+		return nil
+	}))
+
+	// The snapshot is restored into the other backend
+	badger := NewBadger().InMem().MustOpen(ctx)
+	defer badger.Close()
+	require.NoError(t, badger.Update(ctx, func(tx Tx) error {
+		return tx.Bucket(dbutils.HeaderPrefix).Put([]byte("c"), []byte("3"))
+	}))
+	require.NoError(t, RestoreKV(ctx, badger, snapshot))
+	restoredHash, err := ContentHashKV(ctx, badger)
+	require.NoError(t, err)
+	assert.Equal(t, snapshot.Hash(), restoredHash)
+
+	badgerSnapshot, err := SnapshotKV(ctx, badger)
+	require.NoError(t, err)
+	defer badgerSnapshot.Close()
+	assert.True(t, snapshot.Equal(badgerSnapshot))
+	require.NoError(t, db.Restore(snapshot))
+	assert.True(t, badgerSnapshot.Equal(snapshot))
+}
@@ -5,6 +5,7 @@ import (
 	"sort"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/ledgerwatch/bolt"
 	"github.com/ledgerwatch/turbo-geth/common"
@@ -14,6 +15,12 @@ type mutation struct {
 	puts *puts // Map buckets to map[key]value
 	mu   sync.RWMutex
 	db   Database
+
+	inFlight  *inFlightCommit // The commit running in the background, see CommitAsync
+	commitErr error           // Error of the background commit, returned until the rollback
+
+	policy     *CommitPolicy // nil - commit only explicitly
+	lastCommit time.Time
 }
 
 func (m *mutation) KV() *bolt.DB {
@@ -24,6 +31,21 @@ func (m *mutation) KV() *bolt.DB {
 	}
 }
 
+// HistoryKV returns the history database of the underlying SplitDatabase, see HasHistoryKV
+func (m *mutation) HistoryKV() *bolt.DB {
+	if casted, ok := m.db.(HasHistoryKV); ok {
+		return casted.HistoryKV()
+	}
+	return nil
+}
+
+func (m *mutation) IsHistoryBucket(bucket []byte) bool {
+	if casted, ok := m.db.(HasHistoryKV); ok {
+		return casted.IsHistoryBucket(bucket)
+	}
+	return false
+}
+
 func (m *mutation) AbstractKV() KV {
 	if casted, ok := m.db.(HasAbstractKV); ok {
 		return casted.AbstractKV()
@@ -34,7 +56,10 @@ func (m *mutation) AbstractKV() KV {
 func (m *mutation) getMem(bucket, key []byte) ([]byte, bool) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
-	return m.puts.get(bucket, key)
+	if value, ok := m.puts.get(bucket, key); ok {
+		return value, true
+	}
+	return m.inFlight.get(bucket, key)
 }
 
 // Can only be called from the worker thread
@@ -55,6 +80,9 @@ func (m *mutation) getNoLock(bucket, key []byte) ([]byte, error) {
 	if value, ok := m.puts.get(bucket, key); ok {
 		return value, nil
 	}
+	if value, ok := m.inFlight.get(bucket, key); ok {
+		return value, nil
+	}
 	if m.db != nil {
 		return m.db.Get(bucket, key)
 	}
@@ -71,7 +99,10 @@ func (m *mutation) GetIndexChunk(bucket, key []byte, timestamp uint64) ([]byte,
 func (m *mutation) hasMem(bucket, key []byte) bool {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
-	_, ok := m.puts.get(bucket, key)
+	if _, ok := m.puts.get(bucket, key); ok {
+		return true
+	}
+	_, ok := m.inFlight.get(bucket, key)
 	return ok
 }
 
@@ -97,7 +128,7 @@ func (m *mutation) Put(bucket, key []byte, value []byte) error {
 	defer m.mu.Unlock()
 
 	m.puts.set(bucket, key, value)
-	return nil
+	return m.autoCommit()
 }
 
 func (m *mutation) MultiPut(tuples ...[]byte) (uint64, error) {
@@ -107,7 +138,7 @@ func (m *mutation) MultiPut(tuples ...[]byte) (uint64, error) {
 	for i := 0; i < l; i += 3 {
 		m.puts.set(tuples[i], tuples[i+1], tuples[i+2])
 	}
-	return 0, nil
+	return 0, m.autoCommit()
 }
 
 func (m *mutation) BatchSize() int {
@@ -117,7 +148,11 @@ func (m *mutation) BatchSize() int {
 }
 
 // IdealBatchSize defines the size of the data batches should ideally add in one write.
+// If the batch has a commit policy, its MaxBytes is returned.
 func (m *mutation) IdealBatchSize() int {
+	if m.policy != nil {
+		return m.policy.MaxBytes
+	}
 	return m.db.IdealBatchSize()
 }
 
@@ -147,7 +182,7 @@ func (m *mutation) Delete(bucket, key []byte) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	m.puts.Delete(bucket, key)
-	return nil
+	return m.autoCommit()
 }
 
 func (m *mutation) Commit() (uint64, error) {
@@ -156,28 +191,87 @@ func (m *mutation) Commit() (uint64, error) {
 	}
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	tuples := make(MultiPutTuples, 0, m.puts.Len()*3)
-	for bucketStr, bt := range m.puts.mp {
-		bucketB := []byte(bucketStr)
-		for key := range bt {
-			value, _ := bt.GetStr(key)
-			tuples = append(tuples, bucketB, []byte(key), value)
-		}
-	}
-	sort.Sort(tuples)
+	return m.commitNoLock()
+}
 
-	written, err := m.db.MultiPut(tuples...)
+func (m *mutation) commitNoLock() (uint64, error) {
+	// The mutations are written after the ones of the background commit
+	if err := m.waitCommitNoLock(); err != nil {
+		return 0, err
+	}
+	written, err := m.db.MultiPut(sortedTuples(m.puts)...)
 	if err != nil {
 		return 0, fmt.Errorf("db.MultiPut failed: %w", err)
 	}
 
 	m.puts = newPuts()
+	m.lastCommit = time.Now()
 	return written, nil
 }
 
+// CommitTogether writes the pending mutations of the batches created over the same database by one MultiPut,
+// i.e. in one transaction of Bolt and Badger (SplitDatabase journals the history part, see split_db_journal.go),
+// so a crash leaves either all of them or none. The later batches win if they write the same keys
+func CommitTogether(batches ...DbWithPendingMutations) (uint64, error) {
+	merged := newPuts()
+	var db Database
+	for _, batch := range batches {
+		m, ok := batch.(*mutation)
+		if !ok {
+			return 0, fmt.Errorf("%T can't be committed together with other batches", batch)
+		}
+		if db == nil {
+			db = m.db
+		} else if m.db != db {
+			return 0, fmt.Errorf("batches over different databases can't be committed together")
+		}
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if err := m.waitCommitNoLock(); err != nil {
+			return 0, err
+		}
+		for bucket, bt := range m.puts.mp {
+			for key, value := range bt {
+				merged.set([]byte(bucket), []byte(key), value)
+			}
+		}
+	}
+	if db == nil || merged.Len() == 0 {
+		return 0, nil
+	}
+	written, err := db.MultiPut(sortedTuples(merged)...)
+	if err != nil {
+		return 0, fmt.Errorf("db.MultiPut failed: %w", err)
+	}
+	now := time.Now()
+	for _, batch := range batches {
+		m := batch.(*mutation)
+		m.puts = newPuts()
+		m.lastCommit = now
+	}
+	return written, nil
+}
+
+func sortedTuples(p *puts) MultiPutTuples {
+	tuples := make(MultiPutTuples, 0, p.Len()*3)
+	for bucketStr, bt := range p.mp {
+		bucketB := []byte(bucketStr)
+		for key := range bt {
+			value, _ := bt.GetStr(key)
+			tuples = append(tuples, bucketB, []byte(key), value)
+		}
+	}
+	sort.Sort(tuples)
+	return tuples
+}
+
+// Rollback discards the pending mutations, and the ones of the background commit (see CommitAsync), so the database
+// is left as it was before the commit. To keep the mutations of the background commit, it is waited for first
+// (see WaitCommit). If the writes of the background commit can't be undone, the error is returned by the next commits
 func (m *mutation) Rollback() {
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	m.commitErr = m.cancelCommitNoLock()
 	m.puts = newPuts()
 }
 
@@ -0,0 +1,135 @@
+package ethdb
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+)
+
+// AsyncCommitter is implemented by the batches, which can write their mutations into the database in the background
+// while the next mutations are staged
+type AsyncCommitter interface {
+	// CommitAsync hands the pending mutations to the commit running in the background, and returns without
+	// waiting for it. Only one commit is in flight, the previous one is waited for, and its error is returned
+	CommitAsync() error
+	// WaitCommit waits for the background commit, and returns its error
+	WaitCommit() error
+}
+
+// inFlightCommit is the commit running in the background. Its mutations are not modified,
+// and are read together with the staged ones until the commit is waited for
+type inFlightCommit struct {
+	puts      *puts
+	done      chan struct{}
+	err       error
+	cancelled uint32         // Set by the rollback, the commit which has not started yet does not write anything
+	written   bool           // Whether the mutations have been written
+	undo      MultiPutTuples // The values of the keys before the commit, written back by the rollback
+}
+
+func (c *inFlightCommit) get(bucket, key []byte) ([]byte, bool) {
+	if c == nil {
+		return nil, false
+	}
+	return c.puts.get(bucket, key)
+}
+
+// CommitAsync writes the pending mutations in one database transaction, like Commit, but in the background, so that
+// the mutations of the next blocks are produced while the database is written. The mutations of the background
+// commit are visible to Get and Has, WalkMerged waits for the commit; Walk, MultiWalk and GetAsOf see them once
+// they are written. The transaction is atomic, so the database is never left with a part of the mutations.
+// If the commit fails, the mutations staged after it are not committed either, until the rollback.
+// The rollback cancels the background commit, see Rollback: the values of the written keys are read before
+// the commit, so that they can be written back
+func (m *mutation) CommitAsync() error {
+	if m.db == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.waitCommitNoLock(); err != nil {
+		return err
+	}
+	if m.puts.Len() == 0 {
+		return nil
+	}
+	c := &inFlightCommit{puts: m.puts, done: make(chan struct{})}
+	m.inFlight = c
+	m.puts = newPuts()
+	go func() {
+		defer close(c.done)
+		if atomic.LoadUint32(&c.cancelled) == 1 {
+			return
+		}
+		tuples := sortedTuples(c.puts)
+		undo, err := previousValues(m.db, tuples)
+		if err != nil {
+			c.err = err
+			return
+		}
+		if _, err := m.db.MultiPut(tuples...); err != nil {
+			c.err = fmt.Errorf("db.MultiPut failed: %w", err)
+			return
+		}
+		c.written, c.undo = true, undo
+	}()
+	return nil
+}
+
+// previousValues returns the tuples writing back the current values of the keys of the tuples,
+// nil values for the keys which are not in the database
+func previousValues(db Database, tuples MultiPutTuples) (MultiPutTuples, error) {
+	undo := make(MultiPutTuples, 0, len(tuples))
+	for i := 0; i < len(tuples); i += 3 {
+		v, err := db.Get(tuples[i], tuples[i+1])
+		if err != nil && !IsNotFound(err) {
+			return nil, err
+		}
+		if v != nil {
+			v = common.CopyBytes(v)
+		}
+		undo = append(undo, tuples[i], tuples[i+1], v)
+	}
+	return undo, nil
+}
+
+// cancelCommitNoLock cancels the background commit. The commit is skipped if it has not started yet,
+// otherwise it is waited for and its writes are undone
+func (m *mutation) cancelCommitNoLock() error {
+	c := m.inFlight
+	if c == nil {
+		return nil
+	}
+	atomic.StoreUint32(&c.cancelled, 1)
+	<-c.done
+	m.inFlight = nil
+	if !c.written {
+		return nil
+	}
+	if _, err := m.db.MultiPut(c.undo...); err != nil {
+		return fmt.Errorf("undoing the background commit: %w", err)
+	}
+	return nil
+}
+
+// WaitCommit waits for the commit started by CommitAsync, and returns its error
+func (m *mutation) WaitCommit() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.waitCommitNoLock()
+}
+
+func (m *mutation) waitCommitNoLock() error {
+	if c := m.inFlight; c != nil {
+		<-c.done
+		m.inFlight = nil
+		if c.err != nil {
+			m.commitErr = c.err
+		} else {
+			m.lastCommit = time.Now()
+		}
+	}
+	return m.commitErr
+}
@@ -0,0 +1,150 @@
+package ethdb
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCommitAsync(t *testing.T) {
+	bucket := []byte("B")
+
+	t.Run("staged over in flight", func(t *testing.T) {
+		db := NewMemDatabase()
+		defer db.Close()
+		batch := db.NewBatch()
+		require.NoError(t, batch.Put(bucket, []byte("k1"), []byte("v1")))
+		require.NoError(t, batch.Put(bucket, []byte("k2"), []byte("v2")))
+		require.NoError(t, batch.(AsyncCommitter).CommitAsync())
+		assert.Equal(t, 0, batch.BatchSize())
+
+		require.NoError(t, batch.Put(bucket, []byte("k1"), []byte("v1'")))
+		require.NoError(t, batch.Delete(bucket, []byte("k2")))
+		v, err := batch.Get(bucket, []byte("k1"))
+		require.NoError(t, err)
+		assert.Equal(t, []byte("v1'"), v)
+		_, err = batch.Get(bucket, []byte("k2"))
+		assert.True(t, IsNotFound(err))
+
+		require.NoError(t, batch.(AsyncCommitter).WaitCommit())
+		v, err = db.Get(bucket, []byte("k1"))
+		require.NoError(t, err)
+		assert.Equal(t, []byte("v1"), v)
+		v, err = db.Get(bucket, []byte("k2"))
+		require.NoError(t, err)
+		assert.Equal(t, []byte("v2"), v)
+
+		_, err = batch.Commit()
+		require.NoError(t, err)
+		v, err = db.Get(bucket, []byte("k1"))
+		require.NoError(t, err)
+		assert.Equal(t, []byte("v1'"), v)
+		_, err = db.Get(bucket, []byte("k2"))
+		assert.True(t, IsNotFound(err))
+	})
+
+	t.Run("read through in flight", func(t *testing.T) {
+		db := NewMemDatabase()
+		defer db.Close()
+		batch := db.NewBatch()
+		require.NoError(t, batch.Put(bucket, []byte("k1"), []byte("v1")))
+		require.NoError(t, batch.(AsyncCommitter).CommitAsync())
+		// Read before the commit is waited for
+		v, err := batch.Get(bucket, []byte("k1"))
+		require.NoError(t, err)
+		assert.Equal(t, []byte("v1"), v)
+		has, err := batch.Has(bucket, []byte("k1"))
+		require.NoError(t, err)
+		assert.True(t, has)
+	})
+
+	t.Run("rollback undoes in flight", func(t *testing.T) {
+		db := &blockingMultiPut{BoltDatabase: NewMemDatabase(), started: make(chan struct{}), release: make(chan struct{})}
+		defer db.Close()
+		require.NoError(t, db.BoltDatabase.Put(bucket, []byte("k0"), []byte("v0")))
+		require.NoError(t, db.BoltDatabase.Put(bucket, []byte("k1"), []byte("v1")))
+		batch := &mutation{db: db, puts: newPuts()}
+		require.NoError(t, batch.Put(bucket, []byte("k1"), []byte("v1'")))
+		require.NoError(t, batch.Delete(bucket, []byte("k0")))
+		require.NoError(t, batch.Put(bucket, []byte("k2"), []byte("v2")))
+		require.NoError(t, batch.CommitAsync())
+		require.NoError(t, batch.Put(bucket, []byte("k3"), []byte("v3")))
+
+		// The rollback happens while the commit is writing the database
+		<-db.started
+		rolledBack := make(chan struct{})
+		go func() {
+			defer close(rolledBack)
+			batch.Rollback()
+		}()
+		close(db.release)
+		<-rolledBack
+
+		for k, expected := range map[string]string{"k0": "v0", "k1": "v1"} {
+			v, err := db.Get(bucket, []byte(k))
+			require.NoError(t, err)
+			assert.Equal(t, []byte(expected), v, k)
+		}
+		for _, k := range []string{"k2", "k3"} {
+			_, err := db.Get(bucket, []byte(k))
+			assert.True(t, IsNotFound(err), k)
+		}
+		// The batch keeps working after the rollback
+		require.NoError(t, batch.Put(bucket, []byte("k2"), []byte("v2")))
+		_, err := batch.Commit()
+		require.NoError(t, err)
+		v, err := db.Get(bucket, []byte("k2"))
+		require.NoError(t, err)
+		assert.Equal(t, []byte("v2"), v)
+	})
+
+	t.Run("rollback after wait keeps commit", func(t *testing.T) {
+		db := NewMemDatabase()
+		defer db.Close()
+		batch := db.NewBatch()
+		require.NoError(t, batch.Put(bucket, []byte("k1"), []byte("v1")))
+		require.NoError(t, batch.(AsyncCommitter).CommitAsync())
+		require.NoError(t, batch.(AsyncCommitter).WaitCommit())
+		require.NoError(t, batch.Put(bucket, []byte("k2"), []byte("v2")))
+		batch.Rollback()
+
+		_, err := db.Get(bucket, []byte("k1"))
+		assert.NoError(t, err)
+		_, err = db.Get(bucket, []byte("k2"))
+		assert.True(t, IsNotFound(err))
+	})
+
+	t.Run("walk merged waits", func(t *testing.T) {
+		db := NewMemDatabase()
+		defer db.Close()
+		batch := db.NewBatch()
+		require.NoError(t, batch.Put(bucket, []byte("k1"), []byte("v1")))
+		require.NoError(t, batch.(AsyncCommitter).CommitAsync())
+		require.NoError(t, batch.Put(bucket, []byte("k2"), []byte("v2")))
+
+		var keys []string
+		require.NoError(t, batch.(MergedWalker).WalkMerged(bucket, nil, 0, func(k, v []byte) (bool, error) {
+			keys = append(keys, string(k))
+			return true, nil
+		}))
+		assert.Equal(t, []string{"k1", "k2"}, keys)
+	})
+}
+
+// blockingMultiPut holds the first MultiPut until it is released
+type blockingMultiPut struct {
+	*BoltDatabase
+	started chan struct{}
+	release chan struct{}
+	once    sync.Once
+}
+
+func (db *blockingMultiPut) MultiPut(tuples ...[]byte) (uint64, error) {
+	db.once.Do(func() {
+		close(db.started)
+		<-db.release
+	})
+	return db.BoltDatabase.MultiPut(tuples...)
+}
@@ -44,6 +44,33 @@ func (p *puts) Delete(bucket, key []byte) {
 	p.set(bucket, key, nil)
 }
 
+// unset discards the pending write of the key
+func (p *puts) unset(bucket, key []byte) {
+	bucketPuts, ok := p.mp[string(bucket)]
+	if !ok {
+		return
+	}
+	skey := string(key)
+	if oldVal, ok := bucketPuts[skey]; ok {
+		p.size -= len(skey) + 32 + len(oldVal)
+		p.len--
+		delete(bucketPuts, skey)
+	}
+}
+
+// dropBucket discards all the pending writes of the bucket
+func (p *puts) dropBucket(bucket []byte) {
+	bucketPuts, ok := p.mp[string(bucket)]
+	if !ok {
+		return
+	}
+	for k, v := range bucketPuts {
+		p.size -= len(k) + 32 + len(v)
+	}
+	p.len -= len(bucketPuts)
+	delete(p.mp, string(bucket))
+}
+
 func (p *puts) Len() int {
 	return p.len
 }
@@ -0,0 +1,92 @@
+package ethdb
+
+import (
+	"bytes"
+	"sort"
+)
+
+// matchesFixedBits returns true if first `fixedbits` bits of the key are the same as of the startkey
+func matchesFixedBits(key, startkey []byte, fixedbytes int, mask byte) bool {
+	if fixedbytes == 0 {
+		return true
+	}
+	if len(key) < fixedbytes {
+		return false
+	}
+	return bytes.Equal(key[:fixedbytes-1], startkey[:fixedbytes-1]) && (key[fixedbytes-1]&mask) == (startkey[fixedbytes-1]&mask)
+}
+
+// pendingKeys returns sorted keys of the pending writes (including deletions) matching Walk parameters
+func (m *mutation) pendingKeys(bucket, startkey []byte, fixedbits int) []string {
+	fixedbytes, mask := Bytesmask(fixedbits)
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	bt, ok := m.puts.mp[string(bucket)]
+	if !ok {
+		return nil
+	}
+	var keys []string
+	for k := range bt {
+		if k >= string(startkey) && matchesFixedBits([]byte(k), startkey, fixedbytes, mask) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// WalkMerged walks over the records of the database, replaced by the pending writes of the mutation,
+// and over the records not yet committed. Records deleted by the mutation are skipped.
+func (m *mutation) WalkMerged(bucket, startkey []byte, fixedbits int, walker func([]byte, []byte) (bool, error)) error {
+	m.panicOnEmptyDB()
+	// The database has to see the mutations of the background commit
+	if err := m.WaitCommit(); err != nil {
+		return err
+	}
+	pending := m.pendingKeys(bucket, startkey, fixedbits)
+	pendingValue := func(k string) []byte {
+		v, _ := m.getMem(bucket, []byte(k))
+		return v
+	}
+	i := 0
+	goOn := true
+	// emitPending emits the pending writes with the keys less than the `until` (all of them for nil)
+	emitPending := func(until []byte) error {
+		for ; goOn && i < len(pending) && (until == nil || pending[i] < string(until)); i++ {
+			v := pendingValue(pending[i])
+			if v == nil {
+				continue
+			}
+			var err error
+			if goOn, err = walker([]byte(pending[i]), v); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	dbWalk := m.db.Walk
+	if casted, ok := m.db.(MergedWalker); ok {
+		dbWalk = casted.WalkMerged
+	}
+	if err := dbWalk(bucket, startkey, fixedbits, func(k, v []byte) (bool, error) {
+		if err := emitPending(k); err != nil {
+			return false, err
+		}
+		if !goOn {
+			return false, nil
+		}
+		if i < len(pending) && pending[i] == string(k) {
+			v = pendingValue(pending[i])
+			i++
+			if v == nil {
+				return true, nil
+			}
+		}
+		var err error
+		goOn, err = walker(k, v)
+		return goOn, err
+	}); err != nil {
+		return err
+	}
+	return emitPending(nil)
+}
@@ -0,0 +1,53 @@
+package ethdb
+
+import (
+	"github.com/ledgerwatch/bolt"
+)
+
+// readOnlyDatabase fails the writes into the wrapped database, see NewReadOnlyDatabase
+type readOnlyDatabase struct {
+	Database
+}
+
+// NewReadOnlyDatabase wraps the database, so that the writes into it fail with ErrTxReadOnly. The batches created
+// by NewBatch keep their writes in memory, on top of the wrapped database, and can't be committed. Such a batch
+// is an overlay, in which the modified state (like the unwound one) is computed without touching the database,
+// and which is discarded by Rollback
+func NewReadOnlyDatabase(db Database) Database {
+	return readOnlyDatabase{db}
+}
+
+func (db readOnlyDatabase) Put(bucket, key, value []byte) error {
+	return ErrTxReadOnly
+}
+
+func (db readOnlyDatabase) Delete(bucket, key []byte) error {
+	return ErrTxReadOnly
+}
+
+func (db readOnlyDatabase) MultiPut(tuples ...[]byte) (uint64, error) {
+	return 0, ErrTxReadOnly
+}
+
+func (db readOnlyDatabase) TruncateAncients(items uint64) error {
+	return ErrTxReadOnly
+}
+
+// Close does nothing, the wrapped database is closed by its owner
+func (db readOnlyDatabase) Close() {
+}
+
+func (db readOnlyDatabase) NewBatch() DbWithPendingMutations {
+	return &mutation{
+		db:   db,
+		puts: newPuts(),
+	}
+}
+
+// KV returns the Bolt database of the wrapped database, for the readers like the trie loaders, as mutation.KV does
+func (db readOnlyDatabase) KV() *bolt.DB {
+	if casted, ok := db.Database.(HasKV); ok {
+		return casted.KV()
+	}
+	return nil
+}
@@ -25,6 +25,7 @@ import (
 	"time"
 
 	"github.com/ledgerwatch/turbo-geth/ethdb/codecpool"
+	"github.com/ledgerwatch/turbo-geth/ethdb/remote/remotekv"
 	"github.com/ledgerwatch/turbo-geth/log"
 	"github.com/ledgerwatch/turbo-geth/metrics"
 	"github.com/ugorji/go/codec"
@@ -36,7 +37,7 @@ var (
 
 // Version is the current version of the remote db protocol. If the protocol changes in a non backwards compatible way,
 // this constant needs to be increased
-const Version uint64 = 2
+const Version uint64 = 4
 
 // Command is the type of command in the boltdb remote protocol
 type Command uint8
@@ -46,11 +47,20 @@ const (
 	// ResponseOk
 	// successful response to client's request
 	ResponseOk ResponseCode = iota
-	// ResponseErr : errorMessage
-	// returns error to client
+	// ResponseErr : (errorCode, errorMessage)
+	// returns error to client, the code identifies the shared errors of the databases (see Error)
 	ResponseErr
 )
 
+// Error is the error returned by the server. Its code is sent over the wire together with the message,
+// so that the client restores the shared errors of ethdb (i.e. ethdb.ErrBucketNotFound) without parsing the message
+type Error struct {
+	Code    remotekv.ErrorCode
+	Message string
+}
+
+func (e *Error) Error() string { return e.Message }
+
 const (
 	// CmdVersion : version
 	// is sent from client to server to ask about the version of protocol the server supports
@@ -98,8 +108,17 @@ const (
 	// Moves given cursor over the next given number of keys and streams back the (key, valueSize) pairs
 	// Pair with key == nil signifies the end of the stream
 	CmdCursorNextKey
+	// CmdGetRange (bucketHandle, key, offset, length): (valueSize, value[offset:offset+length])
+	// requests a part of the value for a key from given bucket, together with the size of the whole value.
+	// The part is truncated at the end of the value, for the absent key valueSize is 0 and the part is nil
+	CmdGetRange
 )
 
+// ValueChunkSize is the size of the chunks, in which the values are sent in all the responses, so that the large values
+// (i.e. history indices and block bodies) are streamed instead of being encoded in one piece. A value is sent
+// as the chunks of ValueChunkSize bytes, followed by the last shorter (possibly empty) chunk, nil value is sent as nil
+const ValueChunkSize = 64 * 1024
+
 const DefaultCursorBatchSize uint = 1
 const CursorMaxBatchSize uint64 = 1 * 1000 * 1000
 const ClientMaxConnections uint64 = 128
@@ -120,9 +139,27 @@ func decodeKeyValue(decoder *codec.Decoder, key *[]byte, value *[]byte) (err err
 	if err := decoder.Decode(key); err != nil {
 		return err
 	}
-	if err := decoder.Decode(value); err != nil {
+	if err := decodeValue(decoder, value); err != nil {
+		return err
+	}
+	return nil
+}
+
+// decodeValue reassembles the value sent in chunks, see ValueChunkSize
+func decodeValue(decoder *codec.Decoder, value *[]byte) error {
+	var chunk []byte
+	if err := decoder.Decode(&chunk); err != nil {
 		return err
 	}
+	v := chunk
+	for len(chunk) == ValueChunkSize {
+		chunk = nil
+		if err := decoder.Decode(&chunk); err != nil {
+			return err
+		}
+		v = append(v, chunk...)
+	}
+	*value = v
 	return nil
 }
 
@@ -131,12 +168,16 @@ func decodeErr(decoder *codec.Decoder, responseCode ResponseCode) error {
 		return fmt.Errorf("unknown response code: %d", responseCode)
 	}
 
+	var errorCode remotekv.ErrorCode
+	if err := decoder.Decode(&errorCode); err != nil {
+		return fmt.Errorf("can't decode errorCode: %w", err)
+	}
 	var errorMessage string
 	if err := decoder.Decode(&errorMessage); err != nil {
 		return fmt.Errorf("can't decode errorMessage: %w", err)
 	}
 
-	return errors.New(errorMessage)
+	return &Error{Code: errorCode, Message: errorMessage}
 }
 
 type conn struct {
@@ -150,17 +191,24 @@ type DbOpts struct {
 	DialFunc       DialFunc
 	DialTimeout    time.Duration
 	PingTimeout    time.Duration
-	RetryDialAfter time.Duration
-	PingEvery      time.Duration
-	MaxConnections uint64
+	RetryDialAfter time.Duration // Delay before the first redial, it is doubled after every failed dial
+	// MaxRetryDialAfter caps the exponential backoff of the redials, so that the restarted server is picked up in time
+	MaxRetryDialAfter time.Duration
+	PingEvery         time.Duration
+	MaxConnections    uint64 // Size of the connection pool
+	// ViewRetries is how many times the read-only transaction is retried on a fresh connection,
+	// when it fails to begin because the connection is broken (i.e. the server is restarted)
+	ViewRetries int
 }
 
 var DefaultOpts = DbOpts{
-	MaxConnections: ClientMaxConnections,
-	DialTimeout:    3 * time.Second,
-	PingTimeout:    500 * time.Millisecond,
-	RetryDialAfter: 1 * time.Second,
-	PingEvery:      1 * time.Second,
+	MaxConnections:    ClientMaxConnections,
+	DialTimeout:       3 * time.Second,
+	PingTimeout:       500 * time.Millisecond,
+	RetryDialAfter:    1 * time.Second,
+	MaxRetryDialAfter: 30 * time.Second,
+	PingEvery:         1 * time.Second,
+	ViewRetries:       3,
 }
 
 func (opts DbOpts) Addr(v string) DbOpts {
@@ -168,6 +216,19 @@ func (opts DbOpts) Addr(v string) DbOpts {
 	return opts
 }
 
+// IsConnectionError tells whether the error is caused by the broken connection to the server,
+// as opposed to the errors reported by the server itself. Only the former are worth a retry
+func IsConnectionError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.ErrClosedPipe) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
 func defaultDialFunc(ctx context.Context, dialAddress string) (in io.Reader, out io.Writer, closer io.Closer, err error) {
 	dialer := net.Dialer{}
 	conn, err := dialer.DialContext(ctx, "tcp", dialAddress)
@@ -185,6 +246,7 @@ type DB struct {
 	doDial            chan struct{}
 	doPing            <-chan time.Time
 	cancelConnections context.CancelFunc
+	retryDialAfter    time.Duration // Current delay of the redial, grows exponentially while the dials fail
 }
 
 type DialFunc func(ctx context.Context) (in io.Reader, out io.Writer, closer io.Closer, err error)
@@ -277,13 +339,17 @@ func Open(parentCtx context.Context, opts DbOpts) (*DB, error) {
 		}
 	}
 
+	if opts.MaxConnections == 0 {
+		opts.MaxConnections = ClientMaxConnections
+	}
+
 	db := &DB{
 		opts:           opts,
-		connectionPool: make(chan *conn, ClientMaxConnections),
-		doDial:         make(chan struct{}, ClientMaxConnections),
+		connectionPool: make(chan *conn, opts.MaxConnections),
+		doDial:         make(chan struct{}, opts.MaxConnections),
 	}
 
-	for i := uint64(0); i < ClientMaxConnections; i++ {
+	for i := uint64(0); i < opts.MaxConnections; i++ {
 		db.doDial <- struct{}{}
 	}
 
@@ -320,11 +386,16 @@ func (db *DB) autoReconnect(ctx context.Context) {
 		defer cancel()
 		newIn, newOut, newCloser, err := db.opts.DialFunc(dialCtx)
 		if err != nil {
-			logger.Warn("dial failed", "err", err)
+			retryAfter := db.nextRetryDialAfter()
+			logger.Warn("dial failed", "err", err, "retry after", retryAfter)
 			db.doDial <- struct{}{}
-			time.Sleep(db.opts.RetryDialAfter)
+			select {
+			case <-time.After(retryAfter):
+			case <-ctx.Done():
+			}
 			return
 		}
+		db.retryDialAfter = 0
 
 		notifyCloser := notifyOnClose{notifyCh: db.doDial, internal: newCloser}
 		db.returnConn(ctx, newIn, newOut, notifyCloser)
@@ -339,7 +410,7 @@ func (db *DB) autoReconnect(ctx context.Context) {
 			}
 
 			// if server gone, then need re-check all connections by ping. It will remove broken connections from pool.
-			for i := uint64(0); i < ClientMaxConnections-1; i++ {
+			for i := uint64(1); i < db.opts.MaxConnections; i++ {
 				pingCtx, cancel := context.WithTimeout(ctx, db.opts.PingTimeout)
 				_ = db.ping(pingCtx)
 				cancel()
@@ -348,6 +419,20 @@ func (db *DB) autoReconnect(ctx context.Context) {
 	}
 }
 
+// nextRetryDialAfter returns the delay before the next dial and doubles it for the dial after that,
+// up to DbOpts.MaxRetryDialAfter
+func (db *DB) nextRetryDialAfter() time.Duration {
+	if db.retryDialAfter == 0 {
+		db.retryDialAfter = db.opts.RetryDialAfter
+	}
+	retryAfter := db.retryDialAfter
+	db.retryDialAfter *= 2
+	if db.opts.MaxRetryDialAfter > 0 && db.retryDialAfter > db.opts.MaxRetryDialAfter {
+		db.retryDialAfter = db.opts.MaxRetryDialAfter
+	}
+	return retryAfter
+}
+
 // Close closes DB by using the closer field
 func (db *DB) Close() error {
 	db.cancelConnections()
@@ -382,9 +467,32 @@ func (db *DB) endTx(ctx context.Context, encoder *codec.Encoder, decoder *codec.
 	return nil
 }
 
-// View performs read-only transaction on the remote database
+// View performs read-only transaction on the remote database. If the transaction fails to begin because of
+// the broken connection, it is retried on another connection up to DbOpts.ViewRetries times. Once f has run,
+// the transaction is not retried, so the side effects of f are not repeated
 // NOTE: not thread-safe
-func (db *DB) View(ctx context.Context, f func(tx *Tx) error) (err error) {
+func (db *DB) View(ctx context.Context, f func(tx *Tx) error) error {
+	retryAfter := db.opts.RetryDialAfter
+	for attempt := 0; ; attempt++ {
+		ran, err := db.view(ctx, f)
+		if ran || attempt >= db.opts.ViewRetries || !IsConnectionError(err) {
+			return err
+		}
+		logger.Warn("retrying read-only transaction", "attempt", attempt+1, "err", err)
+		select {
+		case <-time.After(retryAfter):
+		case <-ctx.Done():
+			return err
+		}
+		retryAfter *= 2
+		if db.opts.MaxRetryDialAfter > 0 && retryAfter > db.opts.MaxRetryDialAfter {
+			retryAfter = db.opts.MaxRetryDialAfter
+		}
+	}
+}
+
+// view returns ran == true if f has been called
+func (db *DB) view(ctx context.Context, f func(tx *Tx) error) (ran bool, err error) {
 	var opErr error
 	var endTxErr error
 
@@ -392,7 +500,7 @@ func (db *DB) View(ctx context.Context, f func(tx *Tx) error) (err error) {
 
 	in, out, closer, err := db.getConnection(ctx)
 	if err != nil {
-		return err
+		return false, err
 	}
 
 	defer func() {
@@ -411,15 +519,15 @@ func (db *DB) View(ctx context.Context, f func(tx *Tx) error) (err error) {
 	defer codecpool.Return(encoder)
 
 	if err = encoder.Encode(CmdBeginTx); err != nil {
-		return fmt.Errorf("could not encode CmdBeginTx: %w", err)
+		return false, fmt.Errorf("could not encode CmdBeginTx: %w", err)
 	}
 
 	if err = decoder.Decode(&responseCode); err != nil {
-		return fmt.Errorf("could not decode response code of CmdBeginTx: %w", err)
+		return false, fmt.Errorf("could not decode response code of CmdBeginTx: %w", err)
 	}
 
 	if responseCode != ResponseOk {
-		return decodeErr(decoder, responseCode)
+		return false, decodeErr(decoder, responseCode)
 	}
 
 	tx := &Tx{ctx: ctx, in: in, out: out}
@@ -430,7 +538,7 @@ func (db *DB) View(ctx context.Context, f func(tx *Tx) error) (err error) {
 		logger.Warn("could not finish tx", "err", err)
 	}
 
-	return opErr
+	return true, opErr
 }
 
 // Bucket mimicks the interface of bolt.Bucket
@@ -562,12 +670,69 @@ func (b *Bucket) Get(key []byte) ([]byte, error) {
 	}
 
 	var value []byte
-	if err := decoder.Decode(&value); err != nil {
+	if err := decodeValue(decoder, &value); err != nil {
 		return nil, fmt.Errorf("could not decode value for CmdGet: %w", err)
 	}
 	return value, nil
 }
 
+// GetRange reads up to length bytes of the value corresponding to the given key, starting from the offset,
+// and the size of the whole value. It allows to read the large values piece by piece.
+// return nil if they key is not present
+func (b *Bucket) GetRange(key []byte, offset, length uint32) (value []byte, valueSize uint32, err error) {
+	select {
+	default:
+	case <-b.ctx.Done():
+		return nil, 0, b.ctx.Err()
+	}
+
+	if !b.initialized {
+		if err := b.init(); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	decoder := codecpool.Decoder(b.in)
+	defer codecpool.Return(decoder)
+	encoder := codecpool.Encoder(b.out)
+	defer codecpool.Return(encoder)
+
+	if err := encoder.Encode(CmdGetRange); err != nil {
+		return nil, 0, fmt.Errorf("could not encode CmdGetRange: %w", err)
+	}
+	if err := encoder.Encode(b.bucketHandle); err != nil {
+		return nil, 0, fmt.Errorf("could not encode bucketHandle for CmdGetRange: %w", err)
+	}
+	if err := encoder.Encode(&key); err != nil {
+		return nil, 0, fmt.Errorf("could not encode key for CmdGetRange: %w", err)
+	}
+	if err := encoder.Encode(offset); err != nil {
+		return nil, 0, fmt.Errorf("could not encode offset for CmdGetRange: %w", err)
+	}
+	if err := encoder.Encode(length); err != nil {
+		return nil, 0, fmt.Errorf("could not encode length for CmdGetRange: %w", err)
+	}
+
+	var responseCode ResponseCode
+	if err := decoder.Decode(&responseCode); err != nil {
+		return nil, 0, fmt.Errorf("could not decode ResponseCode for CmdGetRange: %w", err)
+	}
+
+	if responseCode != ResponseOk {
+		if err := decodeErr(decoder, responseCode); err != nil {
+			return nil, 0, fmt.Errorf("could not decode errorMessage for CmdGetRange: %w", err)
+		}
+	}
+
+	if err := decoder.Decode(&valueSize); err != nil {
+		return nil, 0, fmt.Errorf("could not decode valueSize for CmdGetRange: %w", err)
+	}
+	if err := decodeValue(decoder, &value); err != nil {
+		return nil, 0, fmt.Errorf("could not decode value for CmdGetRange: %w", err)
+	}
+	return value, valueSize, nil
+}
+
 // Cursor iterating over bucket keys
 func (b *Bucket) Cursor() *Cursor {
 	return &Cursor{
@@ -780,7 +945,7 @@ func (c *Cursor) Seek(seek []byte) (key []byte, value []byte, err error) {
 		return nil, nil, fmt.Errorf("could not decode key for CmdCursorSeek: %w", err)
 	}
 
-	if err := decoder.Decode(&value); err != nil {
+	if err := decodeValue(decoder, &value); err != nil {
 		return nil, nil, fmt.Errorf("could not decode value for CmdCursorSeek: %w", err)
 	}
 
@@ -917,13 +1082,9 @@ func (c *Cursor) fetchPage(cmd Command) error {
 		}
 	}
 
+	// The page is read to the end even if the context is done, the pairs left in the stream would be read
+	// as the responses to the next commands of the connection
 	for c.cacheLastIdx = uint(0); c.cacheLastIdx < c.prefetchSize; c.cacheLastIdx++ {
-		select {
-		default:
-		case <-c.ctx.Done():
-			return c.ctx.Err()
-		}
-
 		switch cmd {
 		case CmdCursorFirst, CmdCursorNext:
 			if err := decodeKeyValue(decoder, &c.cacheKeys[c.cacheLastIdx], &c.cacheValues[c.cacheLastIdx]); err != nil {
@@ -3,12 +3,15 @@ package remote
 import (
 	"bytes"
 	"context"
+	"errors"
+	"fmt"
 	"io"
 	"net"
 	"testing"
 	"time"
 
 	"github.com/ledgerwatch/turbo-geth/ethdb/codecpool"
+	"github.com/ledgerwatch/turbo-geth/ethdb/remote/remotekv"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -78,3 +81,103 @@ func TestReconnect(t *testing.T) {
 
 	// TODO: cover case when ping receive io.EOF
 }
+
+func TestViewRetry(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	opts := DefaultOpts
+	opts.RetryDialAfter = time.Millisecond
+	db := &DB{
+		opts:           opts,
+		connectionPool: make(chan *conn, ClientMaxConnections),
+		doDial:         make(chan struct{}, ClientMaxConnections),
+	}
+
+	// The first connection is broken, the server responds on the second one
+	var brokenIn, brokenOut bytes.Buffer
+	db.returnConn(ctx, &brokenIn, &brokenOut, notifyOnClose{notifyCh: db.doDial})
+	var inBuf, outBuf bytes.Buffer
+	encoder := codecpool.Encoder(&inBuf)
+	defer codecpool.Return(encoder)
+	assert.Nil(encoder.Encode(ResponseOk)) // CmdBeginTx
+	assert.Nil(encoder.Encode(ResponseOk)) // CmdEndTx
+	db.returnConn(ctx, &inBuf, &outBuf, notifyOnClose{notifyCh: db.doDial})
+
+	calls := 0
+	assert.Nil(db.View(ctx, func(tx *Tx) error {
+		calls++
+		return nil
+	}))
+	assert.Equal(1, calls)
+	assert.Equal(1, len(db.doDial)) // The broken connection is closed and redialed
+	assert.Equal(1, len(db.connectionPool))
+
+	// The errors of the server are not retried
+	inBuf.Reset()
+	assert.Nil(encoder.Encode(ResponseErr))
+	assert.Nil(encoder.Encode(remotekv.ErrorCode_OTHER))
+	assert.Nil(encoder.Encode("no tx"))
+	assert.EqualError(db.View(ctx, func(tx *Tx) error {
+		calls++
+		return nil
+	}), "no tx")
+	assert.Equal(1, calls)
+
+	// The transaction, which breaks after f has run, is not retried
+	inBuf.Reset()
+	assert.Nil(encoder.Encode(ResponseOk)) // CmdBeginTx
+	assert.Nil(encoder.Encode(ResponseOk)) // CmdEndTx
+	db.returnConn(ctx, &inBuf, &outBuf, notifyOnClose{notifyCh: db.doDial})
+	var spareIn, spareOut bytes.Buffer
+	db.returnConn(ctx, &spareIn, &spareOut, notifyOnClose{notifyCh: db.doDial})
+	assert.True(errors.Is(db.View(ctx, func(tx *Tx) error {
+		calls++
+		return io.ErrUnexpectedEOF
+	}), io.ErrUnexpectedEOF))
+	assert.Equal(2, calls)
+	assert.Equal(1, len(db.connectionPool))
+}
+
+func TestRetryDialBackoff(t *testing.T) {
+	assert := assert.New(t)
+	opts := DefaultOpts
+	opts.RetryDialAfter = time.Second
+	opts.MaxRetryDialAfter = 5 * time.Second
+	db := &DB{opts: opts}
+	var delays []time.Duration
+	for i := 0; i < 5; i++ {
+		delays = append(delays, db.nextRetryDialAfter())
+	}
+	assert.Equal([]time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}, delays)
+	assert.True(IsConnectionError(fmt.Errorf("could not decode: %w", io.EOF)))
+	assert.True(IsConnectionError(net.UnknownNetworkError("Oops")))
+	assert.False(IsConnectionError(errors.New("bucket not found")))
+}
+
+func TestDecodeChunkedValue(t *testing.T) {
+	assert := assert.New(t)
+	var buf bytes.Buffer
+	encoder := codecpool.Encoder(&buf)
+	defer codecpool.Return(encoder)
+	decoder := codecpool.Decoder(&buf)
+	defer codecpool.Return(decoder)
+
+	// value of exactly one chunk is terminated by the empty chunk
+	chunk := bytes.Repeat([]byte{1}, ValueChunkSize)
+	assert.Nil(encoder.Encode(&chunk))
+	assert.Nil(encoder.Encode([]byte{}))
+	// nil value
+	var nilValue []byte
+	assert.Nil(encoder.Encode(&nilValue))
+	// short value
+	short := []byte{2, 3}
+	assert.Nil(encoder.Encode(&short))
+
+	var value []byte
+	assert.Nil(decodeValue(decoder, &value))
+	assert.Equal(chunk, value)
+	assert.Nil(decodeValue(decoder, &value))
+	assert.Nil(value)
+	assert.Nil(decodeValue(decoder, &value))
+	assert.Equal(short, value)
+}
@@ -0,0 +1,209 @@
+package remotedbserver
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+	"github.com/ledgerwatch/turbo-geth/ethdb/remote"
+	"github.com/ledgerwatch/turbo-geth/ethdb/remote/remotekv"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/keepalive"
+)
+
+// KvServer serves remotekv.KV gRPC service - the gRPC variant of the remote database protocol.
+// Unlike Server, one connection multiplexes any number of transactions (one stream per transaction).
+type KvServer struct {
+	remotekv.UnimplementedKVServer
+	kv ethdb.KV
+}
+
+func NewKvServer(kv ethdb.KV) *KvServer {
+	return &KvServer{kv: kv}
+}
+
+// StartGrpc starts gRPC server for the given database on the address and returns it, so it can be stopped
+func StartGrpc(kv ethdb.KV, addr string) (*grpc.Server, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("could not create listener: %w, addr=%s", err, addr)
+	}
+	grpcServer := NewGrpcServer(kv)
+	go func() {
+		if err := grpcServer.Serve(ln); err != nil {
+			logger.Error("gRPC server stopped", "err", err)
+		}
+	}()
+	logger.Info("gRPC server listening on", "address", addr)
+	return grpcServer, nil
+}
+
+// NewGrpcServer creates gRPC server with KvServer registered, which is not listening yet
+func NewGrpcServer(kv ethdb.KV) *grpc.Server {
+	grpcServer := grpc.NewServer(
+		grpc.KeepaliveParams(keepalive.ServerParameters{
+			Time:    10 * time.Minute,
+			Timeout: 10 * time.Second,
+		}),
+		grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
+			MinTime:             10 * time.Second,
+			PermitWithoutStream: true,
+		}),
+	)
+	remotekv.RegisterKVServer(grpcServer, NewKvServer(kv))
+	return grpcServer
+}
+
+func (s *KvServer) Version(context.Context, *remotekv.VersionRequest) (*remotekv.VersionReply, error) {
+	return &remotekv.VersionReply{Version: Version}, nil
+}
+
+// Tx serves one read-only transaction, it is rolled back when the stream ends
+func (s *KvServer) Tx(stream remotekv.KV_TxServer) error {
+	ctx := stream.Context()
+	tx, err := s.kv.Begin(ctx, false)
+	if err != nil {
+		return fmt.Errorf("could not start transaction: %w", err)
+	}
+	defer func() {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			logger.Error("could not roll back", "err", rollbackErr)
+		}
+	}()
+
+	buckets := make(map[string]ethdb.Bucket)
+	cursors := make(map[uint32]*grpcCursor)
+	var lastCursor uint32
+
+	for {
+		// Make sure we are not blocking the resizing of the memory map
+		type Yieldable interface {
+			Yield()
+		}
+		if casted, ok := tx.(Yieldable); ok {
+			casted.Yield()
+		}
+
+		req, err := stream.Recv()
+		if err == io.EOF {
+			// Graceful termination when the client closed the transaction
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		resp := &remotekv.TxResponse{}
+		switch req.Op {
+		case remotekv.Op_GET, remotekv.Op_OPEN_CURSOR:
+			bucket, ok := buckets[string(req.BucketName)]
+			if !ok {
+				exists, err := tx.ExistsBucket(req.BucketName)
+				if err != nil {
+					setError(resp, err)
+					break
+				}
+				if !exists {
+					setError(resp, fmt.Errorf("%w: %s", ethdb.ErrBucketNotFound, req.BucketName))
+					break
+				}
+				bucket = tx.Bucket(req.BucketName)
+				buckets[string(req.BucketName)] = bucket
+			}
+			if req.Op == remotekv.Op_GET {
+				v, _ := bucket.Get(req.Key)
+				resp.Pairs = []*remotekv.Pair{{Key: req.Key, Value: v}}
+				break
+			}
+			lastCursor++
+			c := &grpcCursor{noValues: req.NoValues}
+			if req.NoValues {
+				c.noValuesCursor = bucket.Cursor().Prefix(req.Key).NoValues()
+			} else {
+				c.cursor = bucket.Cursor().Prefix(req.Key)
+			}
+			cursors[lastCursor] = c
+			resp.Cursor = lastCursor
+		case remotekv.Op_FIRST, remotekv.Op_SEEK, remotekv.Op_NEXT:
+			c, ok := cursors[req.Cursor]
+			if !ok {
+				setError(resp, fmt.Errorf("cursor not found: %d", req.Cursor))
+				break
+			}
+			if uint64(req.Count) > remote.CursorMaxBatchSize {
+				setError(resp, fmt.Errorf("requested count is too large: %d", req.Count))
+				break
+			}
+			resp.Cursor = req.Cursor
+			if resp.Pairs, err = c.pairs(ctx, req.Op, req.Key, req.Count); err != nil {
+				setError(resp, err)
+			}
+		case remotekv.Op_CLOSE_CURSOR:
+			delete(cursors, req.Cursor)
+		default:
+			setError(resp, fmt.Errorf("unknown op: %s", req.Op))
+		}
+		if err := stream.Send(resp); err != nil {
+			return err
+		}
+	}
+}
+
+type grpcCursor struct {
+	noValues       bool
+	cursor         ethdb.Cursor
+	noValuesCursor ethdb.NoValuesCursor
+}
+
+func (c *grpcCursor) move(op remotekv.Op, seek []byte) (*remotekv.Pair, error) {
+	var k, v []byte
+	var vSize uint32
+	var err error
+	switch {
+	case c.noValues && op == remotekv.Op_FIRST:
+		k, vSize, err = c.noValuesCursor.First()
+	case c.noValues && op == remotekv.Op_SEEK:
+		k, vSize, err = c.noValuesCursor.Seek(seek)
+	case c.noValues:
+		k, vSize, err = c.noValuesCursor.Next()
+	case op == remotekv.Op_FIRST:
+		k, v, err = c.cursor.First()
+	case op == remotekv.Op_SEEK:
+		k, v, err = c.cursor.Seek(seek)
+	default:
+		k, v, err = c.cursor.Next()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &remotekv.Pair{Key: k, Value: v, ValueSize: vSize}, nil
+}
+
+// pairs moves the cursor `count` times, first move is defined by op, next ones are Next.
+// Pair with empty key is the last one
+func (c *grpcCursor) pairs(ctx context.Context, op remotekv.Op, seek []byte, count uint32) ([]*remotekv.Pair, error) {
+	if count == 0 {
+		count = 1
+	}
+	pairs := make([]*remotekv.Pair, 0, count)
+	for i := uint32(0); i < count; i++ {
+		select {
+		default:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		pair, err := c.move(op, seek)
+		if err != nil {
+			return nil, err
+		}
+		pairs = append(pairs, pair)
+		if pair.Key == nil {
+			break
+		}
+		op = remotekv.Op_NEXT
+	}
+	return pairs, nil
+}
@@ -2,30 +2,66 @@ package remotedbserver
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/ledgerwatch/turbo-geth/ethdb"
 	"github.com/ledgerwatch/turbo-geth/ethdb/codecpool"
 	"github.com/ledgerwatch/turbo-geth/ethdb/remote"
+	"github.com/ledgerwatch/turbo-geth/ethdb/remote/remotekv"
 	"github.com/ledgerwatch/turbo-geth/log"
 	"github.com/ugorji/go/codec"
 )
 
 // Version is the current version of the remote db protocol. If the protocol changes in a non backwards compatible way,
 // this constant needs to be increased
-const Version uint64 = 2
+const Version uint64 = 4
+
+// ServerOpts limits the resources held by the clients of the remote db server
+type ServerOpts struct {
+	// MaxConnections limits the connections served at the same time, the others wait in the backlog
+	// of the listener. 0 means no limit
+	MaxConnections uint64
+	// MaxTxsPerHost limits the transactions open at the same time by all the connections from one host,
+	// i.e. the connection pools of all the RPC daemons running on the host share it. 0 means no limit
+	MaxTxsPerHost   int
+	MaxCursorsPerTx int // 0 means no limit
+	// IdleTimeout is how long the open transaction may wait for the next command, before it is rolled back
+	// and its connection closed. The connections without the transaction are not timed out, the broken ones
+	// are detected by TCP keep-alive
+	IdleTimeout time.Duration
+}
+
+var DefaultServerOpts = ServerOpts{
+	MaxConnections:  ServerMaxConnections,
+	MaxTxsPerHost:   256,
+	MaxCursorsPerTx: 1024,
+	IdleTimeout:     5 * time.Minute,
+}
 
 // Server is to be called as a go-routine, one per every client connection.
 // It runs while the connection is active and keep the entire connection's context
 // in the local variables
 // For tests, bytes.Buffer can be used for both `in` and `out`
 func Server(ctx context.Context, db ethdb.KV, in io.Reader, out io.Writer, closer io.Closer) error {
+	return serve(ctx, db, in, out, closer, DefaultServerOpts, nil, "")
+}
+
+// readDeadliner is the connection, reading from which can be timed out (i.e. net.Conn)
+type readDeadliner interface {
+	SetReadDeadline(t time.Time) error
+}
+
+// serve is Server for the client, the transactions of which are counted by txs (nil for no limit),
+// the idle timeout only applies if `in` is readDeadliner
+func serve(ctx context.Context, db ethdb.KV, in io.Reader, out io.Writer, closer io.Closer, opts ServerOpts, txs *txLimiter, client string) error {
 	defer func() {
 		if closer != nil {
 			if err1 := closer.Close(); err1 != nil {
@@ -44,17 +80,6 @@ func Server(ctx context.Context, db ethdb.KV, in io.Reader, out io.Writer, close
 	// Read-only transactions opened by the client
 	var tx ethdb.Tx
 
-	// We do Rollback and never Commit, because the remote transactions are always read-only, and must never change
-	// anything
-	defer func() {
-		if tx != nil {
-			if rollbackErr := tx.Rollback(); rollbackErr != nil {
-				logger.Error("could not roll back", "err", rollbackErr)
-			}
-			tx = nil
-		}
-	}()
-
 	// Buckets opened by the client
 	buckets := make(map[uint64]ethdb.Bucket, 2)
 	// List of buckets opened in each transaction
@@ -64,6 +89,36 @@ func Server(ctx context.Context, db ethdb.KV, in io.Reader, out io.Writer, close
 	// List of cursors opened in each bucket
 	cursorsByBucket := make(map[uint64][]uint64, 2)
 
+	// We do Rollback and never Commit, because the remote transactions are always read-only, and must never change
+	// anything
+	endTx := func() error {
+		// Remove all the buckets
+		for bucketHandle := range buckets {
+			if cursorHandles, ok2 := cursorsByBucket[bucketHandle]; ok2 {
+				for _, cursorHandle := range cursorHandles {
+					delete(cursors, cursorHandle)
+				}
+				delete(cursorsByBucket, bucketHandle)
+			}
+			delete(buckets, bucketHandle)
+		}
+
+		if tx == nil {
+			return nil
+		}
+		err := tx.Rollback()
+		tx = nil
+		txs.release(client)
+		return err
+	}
+	defer func() {
+		if rollbackErr := endTx(); rollbackErr != nil {
+			logger.Error("could not roll back", "err", rollbackErr)
+		}
+	}()
+
+	deadliner, _ := in.(readDeadliner)
+
 	var c remote.Command
 	var bucketHandle uint64
 	var cursorHandle uint64
@@ -83,11 +138,30 @@ func Server(ctx context.Context, db ethdb.KV, in io.Reader, out io.Writer, close
 			}
 		}
 
+		// The connection with the open transaction is timed out, so that the abandoned transaction
+		// does not hold the pages of the database forever
+		var deadline time.Time
+		if deadliner != nil && opts.IdleTimeout > 0 {
+			if tx != nil {
+				deadline = time.Now().Add(opts.IdleTimeout)
+			}
+			if err := deadliner.SetReadDeadline(deadline); err != nil {
+				return fmt.Errorf("could not set read deadline: %w", err)
+			}
+		}
+
 		if err := decoder.Decode(&c); err != nil {
 			if err == io.EOF {
 				// Graceful termination when the end of the input is reached
 				break
 			}
+			if ctx.Err() != nil {
+				// The connection is closed by the shutdown of the server
+				return ctx.Err()
+			}
+			if !deadline.IsZero() && !time.Now().Before(deadline) {
+				return fmt.Errorf("transaction is idle for longer than %s, closing connection", opts.IdleTimeout)
+			}
 			return fmt.Errorf("could not decode remote.Command: %w", err)
 		}
 		switch c {
@@ -99,9 +173,19 @@ func Server(ctx context.Context, db ethdb.KV, in io.Reader, out io.Writer, close
 				return fmt.Errorf("could not encode response to remote.CmdVersion: %w", err)
 			}
 		case remote.CmdBeginTx:
+			// The client is not expected to begin the transaction without ending the previous one
+			if err := endTx(); err != nil {
+				return fmt.Errorf("could not end previous transaction for remote.CmdBeginTx: %w", err)
+			}
+			if !txs.acquire(client) {
+				encodeErr(encoder, fmt.Errorf("too many open transactions of host %s, limit %d", client, opts.MaxTxsPerHost))
+				continue
+			}
 			var err error
 			tx, err = db.Begin(ctx, false)
 			if err != nil {
+				tx = nil
+				txs.release(client)
 				err2 := fmt.Errorf("could not start transaction for remote.CmdBeginTx: %w", err)
 				encodeErr(encoder, err2)
 				return err2
@@ -111,22 +195,8 @@ func Server(ctx context.Context, db ethdb.KV, in io.Reader, out io.Writer, close
 				return fmt.Errorf("could not encode response to remote.CmdBeginTx: %w", err)
 			}
 		case remote.CmdEndTx:
-			// Remove all the buckets
-			for bucketHandle := range buckets {
-				if cursorHandles, ok2 := cursorsByBucket[bucketHandle]; ok2 {
-					for _, cursorHandle := range cursorHandles {
-						delete(cursors, cursorHandle)
-					}
-					delete(cursorsByBucket, bucketHandle)
-				}
-				delete(buckets, bucketHandle)
-			}
-
-			if tx != nil {
-				if err := tx.Rollback(); err != nil {
-					return fmt.Errorf("could not end transaction: %w", err)
-				}
-				tx = nil
+			if err := endTx(); err != nil {
+				return fmt.Errorf("could not end transaction: %w", err)
 			}
 
 			if err := encoder.Encode(remote.ResponseOk); err != nil {
@@ -145,12 +215,17 @@ func Server(ctx context.Context, db ethdb.KV, in io.Reader, out io.Writer, close
 				return err
 			}
 
-			bucket := tx.Bucket(name)
-			if bucket == nil {
-				err := fmt.Errorf("bucket not found: %s", name)
+			exists, err := tx.ExistsBucket(name)
+			if err != nil {
 				encodeErr(encoder, err)
 				continue
 			}
+			if !exists {
+				err := fmt.Errorf("%w: %s", ethdb.ErrBucketNotFound, name)
+				encodeErr(encoder, err)
+				continue
+			}
+			bucket := tx.Bucket(name)
 
 			lastHandle++
 			buckets[lastHandle] = bucket
@@ -172,7 +247,7 @@ func Server(ctx context.Context, db ethdb.KV, in io.Reader, out io.Writer, close
 			}
 			bucket, ok := buckets[bucketHandle]
 			if !ok {
-				encodeErr(encoder, fmt.Errorf("bucket not found for remote.CmdGet: %d", bucketHandle))
+				encodeErr(encoder, fmt.Errorf("%w for remote.CmdGet: %d", ethdb.ErrBucketNotFound, bucketHandle))
 				continue
 			}
 			v, _ := bucket.Get(k)
@@ -182,10 +257,43 @@ func Server(ctx context.Context, db ethdb.KV, in io.Reader, out io.Writer, close
 				return err
 			}
 
-			if err := encoder.Encode(&v); err != nil {
+			if err := encodeValue(encoder, v); err != nil {
 				return fmt.Errorf("could not encode value in response for remote.CmdGet: %w", err)
 			}
 
+		case remote.CmdGetRange:
+			var k []byte
+			var offset, length uint32
+			if err := decoder.Decode(&bucketHandle); err != nil {
+				return fmt.Errorf("could not decode bucketHandle for remote.CmdGetRange: %w", err)
+			}
+			if err := decoder.Decode(&k); err != nil {
+				return fmt.Errorf("could not decode key for remote.CmdGetRange: %w", err)
+			}
+			if err := decoder.Decode(&offset); err != nil {
+				return fmt.Errorf("could not decode offset for remote.CmdGetRange: %w", err)
+			}
+			if err := decoder.Decode(&length); err != nil {
+				return fmt.Errorf("could not decode length for remote.CmdGetRange: %w", err)
+			}
+			bucket, ok := buckets[bucketHandle]
+			if !ok {
+				encodeErr(encoder, fmt.Errorf("%w for remote.CmdGetRange: %d", ethdb.ErrBucketNotFound, bucketHandle))
+				continue
+			}
+			v, _ := bucket.Get(k)
+
+			if err := encoder.Encode(remote.ResponseOk); err != nil {
+				return fmt.Errorf("could not encode response code for remote.CmdGetRange: %w", err)
+			}
+			valueSize := uint32(len(v))
+			if err := encoder.Encode(valueSize); err != nil {
+				return fmt.Errorf("could not encode valueSize in response for remote.CmdGetRange: %w", err)
+			}
+			if err := encodeValue(encoder, valueRange(v, offset, length)); err != nil {
+				return fmt.Errorf("could not encode value in response for remote.CmdGetRange: %w", err)
+			}
+
 		case remote.CmdCursor:
 			if err := decoder.Decode(&bucketHandle); err != nil {
 				return fmt.Errorf("could not decode bucketHandle for remote.CmdCursor: %w", err)
@@ -195,7 +303,11 @@ func Server(ctx context.Context, db ethdb.KV, in io.Reader, out io.Writer, close
 			}
 			bucket, ok := buckets[bucketHandle]
 			if !ok {
-				encodeErr(encoder, fmt.Errorf("bucket not found for remote.CmdCursor: %d", bucketHandle))
+				encodeErr(encoder, fmt.Errorf("%w for remote.CmdCursor: %d", ethdb.ErrBucketNotFound, bucketHandle))
+				continue
+			}
+			if opts.MaxCursorsPerTx > 0 && len(cursors) >= opts.MaxCursorsPerTx {
+				encodeErr(encoder, fmt.Errorf("too many open cursors in transaction, limit %d", opts.MaxCursorsPerTx))
 				continue
 			}
 
@@ -460,12 +572,40 @@ func encodeKeyValue(encoder *codec.Encoder, key []byte, value []byte) error {
 	if err := encoder.Encode(&key); err != nil {
 		return err
 	}
-	if err := encoder.Encode(&value); err != nil {
+	if err := encodeValue(encoder, value); err != nil {
 		return err
 	}
 	return nil
 }
 
+// encodeValue sends the value in chunks, see remote.ValueChunkSize
+func encodeValue(encoder *codec.Encoder, value []byte) error {
+	for len(value) >= remote.ValueChunkSize {
+		chunk := value[:remote.ValueChunkSize]
+		if err := encoder.Encode(&chunk); err != nil {
+			return err
+		}
+		value = value[remote.ValueChunkSize:]
+	}
+	return encoder.Encode(&value)
+}
+
+// valueRange returns value[offset:offset+length], truncated at the end of the value
+func valueRange(value []byte, offset, length uint32) []byte {
+	if value == nil {
+		return nil
+	}
+	size := uint32(len(value))
+	if offset > size {
+		offset = size
+	}
+	end := size
+	if length < size-offset {
+		end = offset + length
+	}
+	return value[offset:end]
+}
+
 func encodeKey(encoder *codec.Encoder, key []byte, valueSize uint32) error {
 	if err := encoder.Encode(&key); err != nil {
 		return err
@@ -481,16 +621,49 @@ func encodeErr(encoder *codec.Encoder, mainError error) {
 		logger.Error("could not encode remote.ResponseErr", "err", err)
 		return
 	}
-	if err := encoder.Encode(mainError.Error()); err != nil {
+	if err := encoder.Encode(errorCode(mainError)); err != nil {
 		logger.Error("could not encode errCode", "err", err)
 		return
 	}
+	if err := encoder.Encode(mainError.Error()); err != nil {
+		logger.Error("could not encode errorMessage", "err", err)
+		return
+	}
+}
+
+// setError fills the error of the gRPC response together with its code
+func setError(resp *remotekv.TxResponse, err error) {
+	resp.Error = err.Error()
+	resp.ErrorCode = errorCode(err)
+}
+
+// errorCode identifies the shared errors of ethdb, which the clients restore by the code, see remote.Error
+func errorCode(err error) remotekv.ErrorCode {
+	switch {
+	case errors.Is(err, ethdb.ErrKeyNotFound):
+		return remotekv.ErrorCode_KEY_NOT_FOUND
+	case errors.Is(err, ethdb.ErrBucketNotFound):
+		return remotekv.ErrorCode_BUCKET_NOT_FOUND
+	case errors.Is(err, ethdb.ErrTxReadOnly):
+		return remotekv.ErrorCode_TX_READ_ONLY
+	case errors.Is(err, ethdb.ErrClosed):
+		return remotekv.ErrorCode_CLOSED
+	default:
+		return remotekv.ErrorCode_OTHER
+	}
 }
 
 var netAddr string
+var netOpts = DefaultServerOpts
 var stopNetInterface context.CancelFunc
 
+// StartDeprecated is StartDeprecatedWithOpts with the opts of the previous start, DefaultServerOpts by default
 func StartDeprecated(db ethdb.KV, addr string) {
+	StartDeprecatedWithOpts(db, addr, netOpts)
+}
+
+func StartDeprecatedWithOpts(db ethdb.KV, addr string, opts ServerOpts) {
+	netOpts = opts
 	if stopNetInterface != nil {
 		stopNetInterface()
 	}
@@ -525,20 +698,35 @@ func StartDeprecated(db ethdb.KV, addr string) {
 	}
 
 	logger.Info("Listening on", "address", netAddr)
-	go Listen(tcpCtx, ln, db)
+	go ListenWithOpts(tcpCtx, ln, db, opts)
 }
 
-// Listener starts listener that for each incoming connection
-// spawn a go-routine invoking Server
+// Listen serves every incoming connection by Server with DefaultServerOpts, see ListenWithOpts
 func Listen(ctx context.Context, ln net.Listener, db ethdb.KV) {
-	defer func() {
-		if err := ln.Close(); err != nil {
-			logger.Error("Could not close listener", "err", err)
-		}
-	}()
+	ListenWithOpts(ctx, ln, db, DefaultServerOpts)
+}
 
-	ch := make(chan bool, ServerMaxConnections)
-	defer close(ch)
+// ListenWithOpts serves every incoming connection by Server, up to opts.MaxConnections at a time.
+// When ctx is done, it closes the listener and all the connections, so that their transactions are rolled back,
+// and returns once they are all finished
+func ListenWithOpts(ctx context.Context, ln net.Listener, db ethdb.KV, opts ServerOpts) {
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	defer cancel()
+
+	txs := newTxLimiter(opts.MaxTxsPerHost)
+	var slots chan struct{} // nil - the connections are not limited
+	if opts.MaxConnections > 0 {
+		slots = make(chan struct{}, opts.MaxConnections)
+	}
+	releaseSlot := func() {
+		if slots != nil {
+			<-slots
+		}
+	}
+	var mu sync.Mutex // guards conns
+	conns := make(map[net.Conn]struct{})
 
 	go func() {
 		ticker := time.NewTicker(3 * time.Second)
@@ -547,16 +735,37 @@ func Listen(ctx context.Context, ln net.Listener, db ethdb.KV) {
 		for {
 			select {
 			case <-ticker.C:
-				logger.Debug("connections", "amount", len(ch))
+				mu.Lock()
+				logger.Debug("connections", "amount", len(conns))
+				mu.Unlock()
 			case <-ctx.Done():
+				if err := ln.Close(); err != nil {
+					logger.Error("Could not close listener", "err", err)
+				}
+				mu.Lock()
+				for conn := range conns {
+					conn.Close()
+				}
+				mu.Unlock()
 				return
 			}
 		}
 	}()
 
 	for {
+		if slots != nil {
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+		}
 		conn, err1 := ln.Accept()
 		if err1 != nil {
+			releaseSlot()
+			if ctx.Err() != nil {
+				return
+			}
 			if netErr, ok := err1.(*net.OpError); ok && !netErr.Temporary() {
 				return
 			}
@@ -564,16 +773,78 @@ func Listen(ctx context.Context, ln net.Listener, db ethdb.KV) {
 			continue
 		}
 
+		mu.Lock()
+		if ctx.Err() != nil {
+			mu.Unlock()
+			conn.Close()
+			releaseSlot()
+			return
+		}
+		conns[conn] = struct{}{}
+		mu.Unlock()
+
+		wg.Add(1)
 		go func() {
-			ch <- true
 			defer func() {
-				<-ch
+				mu.Lock()
+				delete(conns, conn)
+				mu.Unlock()
+				releaseSlot()
+				wg.Done()
 			}()
 
-			err := Server(ctx, db, conn, conn, conn)
-			if err != nil {
+			err := serve(ctx, db, conn, conn, conn, opts, txs, clientHost(conn))
+			if err != nil && ctx.Err() == nil {
 				logger.Warn("server error", "err", err)
 			}
 		}()
 	}
 }
+
+// clientHost identifies the client by the host of the connection, so that the pooled connections, which differ
+// by the ports only, are limited together, see ServerOpts.MaxTxsPerHost
+func clientHost(conn net.Conn) string {
+	addr := conn.RemoteAddr().String()
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+// txLimiter counts the open transactions of every client host
+type txLimiter struct {
+	limit int
+	mu    sync.Mutex
+	txs   map[string]int
+}
+
+func newTxLimiter(limit int) *txLimiter {
+	return &txLimiter{limit: limit, txs: make(map[string]int)}
+}
+
+// acquire counts the new transaction of the client, unless the client has reached the limit
+func (l *txLimiter) acquire(client string) bool {
+	if l == nil || l.limit <= 0 {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.txs[client] >= l.limit {
+		return false
+	}
+	l.txs[client]++
+	return true
+}
+
+func (l *txLimiter) release(client string) {
+	if l == nil || l.limit <= 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.txs[client] <= 1 {
+		delete(l.txs, client)
+		return
+	}
+	l.txs[client]--
+}
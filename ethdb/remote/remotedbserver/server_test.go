@@ -20,14 +20,18 @@ import (
 	"bytes"
 	"context"
 	"encoding/binary"
+	"net"
 	"testing"
+	"time"
 
 	"github.com/ledgerwatch/bolt"
 	"github.com/ledgerwatch/turbo-geth/ethdb"
 	"github.com/ledgerwatch/turbo-geth/ethdb/codecpool"
 	"github.com/ledgerwatch/turbo-geth/ethdb/remote"
+	"github.com/ledgerwatch/turbo-geth/ethdb/remote/remotekv"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/ugorji/go/codec"
 )
 
 type closerType struct {
@@ -234,6 +238,101 @@ func TestCmdGet(t *testing.T) {
 	assert.Nil(value, "Wrong value from CmdGet")
 }
 
+func TestCmdGetChunkedAndRange(t *testing.T) {
+	assert, require, ctx, db := assert.New(t), require.New(t), context.Background(), ethdb.NewMemDatabase()
+
+	// ---------- Start of boilerplate code
+	// Prepare input buffer with one command CmdVersion
+	var inBuf bytes.Buffer
+	encoder := codecpool.Encoder(&inBuf)
+	defer codecpool.Return(encoder)
+	// output buffer to receive the result of the command
+	var outBuf bytes.Buffer
+	decoder := codecpool.Decoder(&outBuf)
+	defer codecpool.Return(decoder)
+	// ---------- End of boilerplate code
+	// Create a bucket and put a value larger than two chunks
+	var name = []byte("testbucket")
+	largeValue := make([]byte, 2*remote.ValueChunkSize+10)
+	for i := range largeValue {
+		largeValue[i] = byte(i)
+	}
+	if err := db.KV().Update(func(tx *bolt.Tx) error {
+		b, err1 := tx.CreateBucket(name, false)
+		if err1 != nil {
+			return err1
+		}
+		return b.Put([]byte(key1), largeValue)
+	}); err != nil {
+		t.Errorf("Could not create and populate a bucket: %v", err)
+	}
+	assert.Nil(encoder.Encode(remote.CmdBeginTx), "Could not encode CmdBeginTx")
+
+	assert.Nil(encoder.Encode(remote.CmdBucket), "Could not encode CmdBucket")
+	assert.Nil(encoder.Encode(&name), "Could not encode name for CmdBucket")
+
+	var bucketHandle uint64 = 1
+	var key = []byte(key1)
+	assert.Nil(encoder.Encode(remote.CmdGet), "Could not encode CmdGet")
+	assert.Nil(encoder.Encode(bucketHandle), "Could not encode bucketHandle for CmdGet")
+	assert.Nil(encoder.Encode(&key), "Could not encode key for CmdGet")
+	// Range across the boundary of the chunks
+	assert.Nil(encoder.Encode(remote.CmdGetRange), "Could not encode CmdGetRange")
+	assert.Nil(encoder.Encode(bucketHandle), "Could not encode bucketHandle for CmdGetRange")
+	assert.Nil(encoder.Encode(&key), "Could not encode key for CmdGetRange")
+	assert.Nil(encoder.Encode(uint32(remote.ValueChunkSize-2)), "Could not encode offset for CmdGetRange")
+	assert.Nil(encoder.Encode(uint32(4)), "Could not encode length for CmdGetRange")
+	// Range of the non-existing key
+	key = []byte(key3)
+	assert.Nil(encoder.Encode(remote.CmdGetRange), "Could not encode CmdGetRange")
+	assert.Nil(encoder.Encode(bucketHandle), "Could not encode bucketHandle for CmdGetRange")
+	assert.Nil(encoder.Encode(&key), "Could not encode key for CmdGetRange")
+	assert.Nil(encoder.Encode(uint32(0)), "Could not encode offset for CmdGetRange")
+	assert.Nil(encoder.Encode(uint32(4)), "Could not encode length for CmdGetRange")
+
+	// By now we constructed all input requests, now we call the
+	// Server to process them all
+	err := Server(ctx, db.AbstractKV(), &inBuf, &outBuf, closer)
+	require.NoError(err, "Error while calling Server")
+
+	// And then we interpret the results
+	// Results of CmdBeginTx
+	var responseCode remote.ResponseCode
+	assert.Nil(decoder.Decode(&responseCode), "Could not decode ResponseCode returned by CmdBeginTx")
+	assert.Equal(remote.ResponseOk, responseCode, "unexpected response code")
+	// Results of CmdBucket
+	assert.Nil(decoder.Decode(&responseCode), "Could not decode ResponseCode returned by CmdBucket")
+	assert.Equal(remote.ResponseOk, responseCode, "unexpected response code")
+	assert.Nil(decoder.Decode(&bucketHandle), "Could not decode response from CmdBucket")
+	// Results of CmdGet - the value comes in 3 chunks
+	assert.Nil(decoder.Decode(&responseCode), "Could not decode ResponseCode returned by CmdGet")
+	assert.Equal(remote.ResponseOk, responseCode, "unexpected response code")
+	var value []byte
+	for i, expectedLen := range []int{remote.ValueChunkSize, remote.ValueChunkSize, 10} {
+		var chunk []byte
+		assert.Nil(decoder.Decode(&chunk), "Could not decode chunk from CmdGet")
+		assert.Equal(expectedLen, len(chunk), "Unexpected length of chunk %d", i)
+		value = append(value, chunk...)
+	}
+	assert.Equal(largeValue, value, "Wrong value from CmdGet")
+	// Results of CmdGetRange (for key1)
+	var valueSize uint32
+	assert.Nil(decoder.Decode(&responseCode), "Could not decode ResponseCode returned by CmdGetRange")
+	assert.Equal(remote.ResponseOk, responseCode, "unexpected response code")
+	assert.Nil(decoder.Decode(&valueSize), "Could not decode valueSize from CmdGetRange")
+	assert.Equal(uint32(len(largeValue)), valueSize, "Wrong valueSize from CmdGetRange")
+	assert.Nil(decoder.Decode(&value), "Could not decode value from CmdGetRange")
+	assert.Equal(largeValue[remote.ValueChunkSize-2:remote.ValueChunkSize+2], value, "Wrong value from CmdGetRange")
+	// Results of CmdGetRange (for key3)
+	assert.Nil(decoder.Decode(&responseCode), "Could not decode ResponseCode returned by CmdGetRange")
+	assert.Equal(remote.ResponseOk, responseCode, "unexpected response code")
+	assert.Nil(decoder.Decode(&valueSize), "Could not decode valueSize from CmdGetRange")
+	assert.Equal(uint32(0), valueSize, "Wrong valueSize from CmdGetRange")
+	value = nil
+	assert.Nil(decoder.Decode(&value), "Could not decode value from CmdGetRange")
+	assert.Nil(value, "Wrong value from CmdGetRange")
+}
+
 func TestCmdSeek(t *testing.T) {
 	assert, require, ctx, db := assert.New(t), require.New(t), context.Background(), ethdb.NewMemDatabase()
 
@@ -486,6 +585,110 @@ func TestTxYield(t *testing.T) {
 	}
 }
 
+// testClient speaks the remote db protocol over one connection
+type testClient struct {
+	t       *testing.T
+	conn    net.Conn
+	encoder *codec.Encoder
+	decoder *codec.Decoder
+}
+
+func dialTestClient(t *testing.T, addr string) *testClient {
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	return &testClient{t: t, conn: conn, encoder: codecpool.Encoder(conn), decoder: codecpool.Decoder(conn)}
+}
+
+func (c *testClient) close() {
+	codecpool.Return(c.encoder)
+	codecpool.Return(c.decoder)
+	c.conn.Close()
+}
+
+// send sends the command with its arguments and returns the response code, the error message is consumed
+func (c *testClient) send(cmd remote.Command, args ...interface{}) remote.ResponseCode {
+	require.NoError(c.t, c.encoder.Encode(cmd))
+	for _, arg := range args {
+		require.NoError(c.t, c.encoder.Encode(arg))
+	}
+	var responseCode remote.ResponseCode
+	require.NoError(c.t, c.decoder.Decode(&responseCode))
+	if responseCode != remote.ResponseOk {
+		var code remotekv.ErrorCode
+		require.NoError(c.t, c.decoder.Decode(&code))
+		var msg string
+		require.NoError(c.t, c.decoder.Decode(&msg))
+	}
+	return responseCode
+}
+
+func TestListenLimits(t *testing.T) {
+	db := ethdb.NewMemDatabase()
+	var name = []byte("testbucket")
+	require.NoError(t, db.KV().Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucket(name, false)
+		return err
+	}))
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ListenWithOpts(ctx, ln, db.AbstractKV(), ServerOpts{
+			MaxConnections:  16,
+			MaxTxsPerHost:   1,
+			MaxCursorsPerTx: 1,
+			IdleTimeout:     100 * time.Millisecond,
+		})
+	}()
+
+	// Both connections come from the same host, only one of them may have the open transaction
+	c1, c2 := dialTestClient(t, ln.Addr().String()), dialTestClient(t, ln.Addr().String())
+	defer c1.close()
+	defer c2.close()
+	require.Equal(t, remote.ResponseOk, c1.send(remote.CmdBeginTx))
+	require.Equal(t, remote.ResponseErr, c2.send(remote.CmdBeginTx))
+
+	// Only one cursor per transaction
+	require.Equal(t, remote.ResponseOk, c1.send(remote.CmdBucket, &name))
+	var bucketHandle, cursorHandle uint64
+	require.NoError(t, c1.decoder.Decode(&bucketHandle))
+	require.Equal(t, remote.ResponseOk, c1.send(remote.CmdCursor, bucketHandle, []byte(nil)))
+	require.NoError(t, c1.decoder.Decode(&cursorHandle))
+	require.Equal(t, remote.ResponseErr, c1.send(remote.CmdCursor, bucketHandle, []byte(nil)))
+
+	require.Equal(t, remote.ResponseOk, c1.send(remote.CmdEndTx))
+	require.Equal(t, remote.ResponseOk, c2.send(remote.CmdBeginTx))
+
+	// The idle transaction is rolled back before its connection is closed, which frees the slot of the host
+	var responseCode remote.ResponseCode
+	require.NoError(t, c2.conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	err = c2.decoder.Decode(&responseCode)
+	require.Error(t, err)
+	if netErr, ok := err.(net.Error); ok {
+		require.False(t, netErr.Timeout(), "idle connection has not been closed")
+	}
+	require.Equal(t, remote.ResponseOk, c1.send(remote.CmdBeginTx))
+
+	// The connection without the transaction is not timed out, the read times out on the client side instead
+	require.Equal(t, remote.ResponseOk, c1.send(remote.CmdEndTx))
+	require.NoError(t, c1.conn.SetReadDeadline(time.Now().Add(300*time.Millisecond)))
+	_, err = c1.conn.Read(make([]byte, 1))
+	netErr, ok := err.(net.Error)
+	require.True(t, ok && netErr.Timeout(), "connection without the transaction has been closed: %v", err)
+	require.NoError(t, c1.conn.SetReadDeadline(time.Time{}))
+	require.Equal(t, remote.ResponseOk, c1.send(remote.CmdBeginTx))
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("server has not shut down")
+	}
+	require.Error(t, c1.decoder.Decode(&responseCode))
+}
+
 func BenchmarkRemoteCursorFirst(b *testing.B) {
 	assert, require, ctx, db := assert.New(b), require.New(b), context.Background(), ethdb.NewMemDatabase()
 
@@ -622,3 +825,34 @@ func BenchmarkBoltCursorFirst(b *testing.B) {
 	}
 
 }
+
+func TestListenUnlimited(t *testing.T) {
+	db := ethdb.NewMemDatabase()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ListenWithOpts(ctx, ln, db.AbstractKV(), ServerOpts{})
+	}()
+
+	// The zero opts do not limit anything
+	var clients []*testClient
+	for i := 0; i < 3; i++ {
+		c := dialTestClient(t, ln.Addr().String())
+		defer c.close()
+		require.NoError(t, c.conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+		require.Equal(t, remote.ResponseOk, c.send(remote.CmdBeginTx))
+		clients = append(clients, c)
+	}
+	for _, c := range clients {
+		require.Equal(t, remote.ResponseOk, c.send(remote.CmdEndTx))
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("server has not shut down")
+	}
+}
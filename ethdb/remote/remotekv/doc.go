@@ -0,0 +1,10 @@
+// Package remotekv contains the gRPC variant of the remote database protocol, defined in kv.proto.
+// Clients in other languages can be generated from the same definition.
+//
+// kv.pb.go is generated with protoc-gen-go v1.3.3 (the version of github.com/golang/protobuf in go.mod):
+//
+//	go get github.com/golang/protobuf/protoc-gen-go@v1.3.3
+//	go generate ./ethdb/remote/remotekv
+package remotekv
+
+//go:generate protoc --go_out=plugins=grpc,paths=source_relative:. kv.proto
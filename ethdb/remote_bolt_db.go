@@ -74,7 +74,7 @@ func (db *RemoteBoltDatabase) Get(bucket, key []byte) ([]byte, error) {
 	err := db.db.View(context.Background(), func(tx Tx) error {
 		b := tx.Bucket(bucket)
 		if b == nil {
-			return fmt.Errorf("bucket not found, %s", bucket)
+			return fmt.Errorf("%w: %s", ErrBucketNotFound, bucket)
 		}
 
 		v, err := b.Get(key)
@@ -87,10 +87,13 @@ func (db *RemoteBoltDatabase) Get(bucket, key []byte) ([]byte, error) {
 		}
 		return nil
 	})
+	if err != nil {
+		return nil, err
+	}
 	if dat == nil {
 		return nil, ErrKeyNotFound
 	}
-	return dat, err
+	return dat, nil
 }
 
 // Get returns the value for a given key if it's present.
@@ -102,7 +105,7 @@ func (db *RemoteBoltDatabase) GetIndexChunk(bucket, key []byte, timestamp uint64
 	err := db.db.View(context.Background(), func(tx Tx) error {
 		b := tx.Bucket(bucket)
 		if b == nil {
-			return fmt.Errorf("bucket not found, %s", bucket)
+			return fmt.Errorf("%w: %s", ErrBucketNotFound, bucket)
 		}
 
 		c := b.Cursor()
@@ -123,49 +126,22 @@ func (db *RemoteBoltDatabase) GetIndexChunk(bucket, key []byte, timestamp uint64
 
 		return nil
 	})
+	if err != nil {
+		return nil, err
+	}
 	if dat == nil {
 		return nil, ErrKeyNotFound
 	}
-	return dat, err
+	return dat, nil
 }
 
-// GetAsOf returns the value valid as of a given timestamp.
-//func (db *RemoteBoltDatabase) GetAsOf(bucket, hBucket, key []byte, timestamp uint64) ([]byte, error) {
-//	return db.db.CmdGetAsOf(context.Background(), bucket, hBucket, key, timestamp)
-//}
-
 // GetAsOf returns the value valid as of a given timestamp.
 func (db *RemoteBoltDatabase) GetAsOf(bucket, hBucket, key []byte, timestamp uint64) ([]byte, error) {
-	composite, _ := dbutils.CompositeKeySuffix(key, timestamp)
 	var dat []byte
 	err := db.db.View(context.Background(), func(tx Tx) error {
-		{
-			hK, hV, err := tx.Bucket(hBucket).Cursor().Seek(composite)
-			if err != nil {
-				return err
-			}
-
-			if hK != nil && bytes.HasPrefix(hK, key) {
-				dat = make([]byte, len(hV))
-				copy(dat, hV)
-				return nil
-			}
-		}
-		{
-			v, err := tx.Bucket(bucket).Get(key)
-			if err != nil {
-				return err
-			}
-			if v == nil {
-				return ErrKeyNotFound
-			}
-
-			dat = make([]byte, len(v))
-			copy(dat, v)
-			return nil
-		}
-
-		return ErrKeyNotFound
+		var err error
+		dat, err = GetAsOfTx(tx, bucket, hBucket, key, timestamp)
+		return err
 	})
 	return dat, err
 }
@@ -269,103 +245,11 @@ func (db *RemoteBoltDatabase) MultiWalk(bucket []byte, startkeys [][]byte, fixed
 	return err
 }
 
+// WalkAsOf walks over the state as of the given timestamp, see WalkAsOfTx
 func (db *RemoteBoltDatabase) WalkAsOf(bucket, hBucket, startkey []byte, fixedbits int, timestamp uint64, walker func([]byte, []byte) (bool, error)) error {
-	fixedbytes, mask := Bytesmask(fixedbits)
-	encodedTS := dbutils.EncodeTimestamp(timestamp)
-	l := len(startkey)
-	sl := l + len(encodedTS)
-	keyBuffer := make([]byte, l+len(EndSuffix))
-	err := db.db.View(context.Background(), func(tx Tx) error {
-		var err error
-
-		b := tx.Bucket(bucket)
-		if b == nil {
-			return nil
-		}
-		hB := tx.Bucket(hBucket)
-		if hB == nil {
-			return nil
-		}
-		c := b.Cursor()
-		hC := hB.Cursor()
-		k, v, err := c.Seek(startkey)
-		if err != nil {
-			return err
-		}
-
-		hK, hV, err := hC.Seek(startkey)
-		if err != nil {
-			return err
-		}
-
-		goOn := true
-		for goOn {
-			if k != nil && fixedbits > 0 && !bytes.Equal(k[:fixedbytes-1], startkey[:fixedbytes-1]) {
-				k = nil
-			}
-			if k != nil && fixedbits > 0 && (k[fixedbytes-1]&mask) != (startkey[fixedbytes-1]&mask) {
-				k = nil
-			}
-			if hK != nil && fixedbits > 0 && !bytes.Equal(hK[:fixedbytes-1], startkey[:fixedbytes-1]) {
-				hK = nil
-			}
-			if hK != nil && fixedbits > 0 && (hK[fixedbytes-1]&mask) != (startkey[fixedbytes-1]&mask) {
-				hK = nil
-			}
-
-			// historical key points to an old block
-			if hK != nil && bytes.Compare(hK[l:], encodedTS) < 0 {
-				copy(keyBuffer, hK[:l])
-				copy(keyBuffer[l:], encodedTS)
-				// update historical key/value to the desired block
-				//hK, hV, err = hC.SeekTo(keyBuffer[:sl])
-				hK, hV, err = hC.Seek(keyBuffer[:sl])
-				if err != nil {
-					return err
-				}
-
-				continue
-			}
-
-			var cmp int
-			if k == nil {
-				if hK == nil {
-					break
-				} else {
-					cmp = 1
-				}
-			} else if hK == nil {
-				cmp = -1
-			} else {
-				cmp = bytes.Compare(k, hK[:l])
-			}
-			if cmp < 0 {
-				goOn, err = walker(k, v)
-			} else {
-				goOn, err = walker(hK[:l], hV)
-			}
-			if goOn {
-				if cmp <= 0 {
-					k, v, err = c.Next()
-					if err != nil {
-						return err
-					}
-				}
-				if cmp >= 0 {
-					copy(keyBuffer, hK[:l])
-					copy(keyBuffer[l:], EndSuffix)
-					//hK, hV, err = hC.SeekTo(keyBuffer)
-					hK, hV, err = hC.Seek(keyBuffer)
-					if err != nil {
-						return err
-					}
-				}
-			}
-		}
-
-		return err
+	return db.db.View(context.Background(), func(tx Tx) error {
+		return WalkAsOfTx(tx, bucket, hBucket, startkey, fixedbits, timestamp, walker)
 	})
-	return err
 }
 
 func (db *RemoteBoltDatabase) MultiWalkAsOf(bucket, hBucket []byte, startkeys [][]byte, fixedbits []int, timestamp uint64, walker func(int, []byte, []byte) error) error {
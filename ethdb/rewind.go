@@ -1,6 +1,11 @@
 package ethdb
 
 import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+
 	"github.com/ledgerwatch/turbo-geth/common"
 	"github.com/ledgerwatch/turbo-geth/common/changeset"
 	"github.com/ledgerwatch/turbo-geth/common/dbutils"
@@ -12,12 +17,10 @@ func RewindData(db Getter, timestampSrc, timestampDst uint64) (map[string][]byte
 	// Collect list of buckets and keys that need to be considered
 	collector := newRewindDataCollector()
 
-	suffixDst := dbutils.EncodeTimestamp(timestampDst + 1)
-
 	if err := walkAndCollect(
 		collector.AccountWalker,
 		db, dbutils.AccountChangeSetBucket,
-		suffixDst, timestampSrc,
+		timestampDst+1, timestampSrc,
 		bytesToAccountChangeSetWalker,
 	); err != nil {
 		return nil, nil, err
@@ -26,7 +29,7 @@ func RewindData(db Getter, timestampSrc, timestampDst uint64) (map[string][]byte
 	if err := walkAndCollect(
 		collector.StorageWalker,
 		db, dbutils.StorageChangeSetBucket,
-		suffixDst, timestampSrc,
+		timestampDst+1, timestampSrc,
 		bytesToStorageChangeSetWalker,
 	); err != nil {
 		return nil, nil, err
@@ -41,12 +44,10 @@ func RewindDataPlain(db Getter, timestampSrc, timestampDst uint64) (map[string][
 	// Collect list of buckets and keys that need to be considered
 	collector := newRewindDataCollector()
 
-	suffixDst := dbutils.EncodeTimestamp(timestampDst + 1)
-
 	if err := walkAndCollect(
 		collector.AccountWalker,
 		db, dbutils.PlainAccountChangeSetBucket,
-		suffixDst, timestampSrc,
+		timestampDst+1, timestampSrc,
 		bytesToAccountChangeSetWalkerPlain,
 	); err != nil {
 		return nil, nil, err
@@ -55,7 +56,7 @@ func RewindDataPlain(db Getter, timestampSrc, timestampDst uint64) (map[string][
 	if err := walkAndCollect(
 		collector.StorageWalker,
 		db, dbutils.PlainStorageChangeSetBucket,
-		suffixDst, timestampSrc,
+		timestampDst+1, timestampSrc,
 		bytesToStorageChangeSetWalkerPlain,
 	); err != nil {
 		return nil, nil, err
@@ -64,6 +65,134 @@ func RewindDataPlain(db Getter, timestampSrc, timestampDst uint64) (map[string][
 	return collector.AccountData, collector.StorageData, nil
 }
 
+// RewindAccount generates the rewind data of a single account (hashed) between the timestamps, in the form of RewindData.
+// Only the changesets of the blocks, in which the account or its storage changed according to the history indexes,
+// are read, and the changes of the other accounts are skipped without decoding, so the account and its storage
+// can be reconstructed without touching the rest of the state
+func RewindAccount(db Getter, addrHash common.Hash, timestampSrc, timestampDst uint64) (map[string][]byte, map[string][]byte, error) {
+	filter, err := ReadHistoryIndexFilter(db)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !filter.Indexed(addrHash[:]) {
+		return nil, nil, &HistoryNotIndexedError{Bucket: dbutils.AccountsHistoryBucket, Key: common.CopyBytes(addrHash[:])}
+	}
+	collector := newRewindDataCollector()
+	if timestampDst >= timestampSrc {
+		return collector.AccountData, collector.StorageData, nil
+	}
+
+	accountBlocks, err := historyIndexBlocks(db, dbutils.AccountsHistoryBucket, addrHash[:], timestampDst+1, timestampSrc, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err = collectChangeSets(
+		collector.AccountWalker,
+		db, dbutils.AccountsHistoryBucket,
+		accountBlocks,
+		accountChangeWalker{addrHash: addrHash}.wrap,
+	); err != nil {
+		return nil, nil, err
+	}
+
+	storageBlocks, err := storageHistoryBlocks(db, addrHash, timestampDst+1, timestampSrc)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err = collectChangeSets(
+		collector.StorageWalker,
+		db, dbutils.StorageHistoryBucket,
+		storageBlocks,
+		storageChangesWalker{addrHash: addrHash}.wrap,
+	); err != nil {
+		return nil, nil, err
+	}
+
+	return collector.AccountData, collector.StorageData, nil
+}
+
+// storageHistoryBlocks returns the blocks in the range [from, to], in which any storage item of the contract changed,
+// in the ascending order. The chunks of the index ending before from are skipped without decoding
+func storageHistoryBlocks(db Getter, addrHash common.Hash, from, to uint64) ([]uint64, error) {
+	set := make(map[uint64]struct{})
+	if err := db.Walk(dbutils.StorageHistoryBucket, addrHash[:], 8*common.HashLength, func(k, v []byte) (bool, error) {
+		if binary.BigEndian.Uint64(k[len(k)-8:]) < from {
+			return true, nil
+		}
+		numbers, _, err := dbutils.WrapHistoryIndex(v).Decode()
+		if err != nil {
+			return false, err
+		}
+		for _, n := range numbers {
+			if n >= from && n <= to {
+				set[n] = struct{}{}
+			}
+		}
+		return true, nil
+	}); err != nil {
+		return nil, err
+	}
+	blocks := make([]uint64, 0, len(set))
+	for n := range set {
+		blocks = append(blocks, n)
+	}
+	sort.Slice(blocks, func(i, j int) bool { return blocks[i] < blocks[j] })
+	return blocks, nil
+}
+
+// collectChangeSets walks the changesets of the blocks (in the ascending order), the missing changeset is an error,
+// because the rewind would silently return the newer values
+func collectChangeSets(collectorFunc func([]byte, []byte) error, db Getter, hBucket []byte, blocks []uint64, bytesToWalker func([]byte) walker) error {
+	for _, blockNr := range blocks {
+		v, err := GetChangeSetByBlock(db, hBucket, blockNr)
+		if err != nil {
+			return err
+		}
+		if v == nil {
+			return fmt.Errorf("%w: changeset of block %d for %s", ErrKeyNotFound, blockNr, hBucket)
+		}
+		if err = bytesToWalker(common.CopyBytes(v)).Walk(collectorFunc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// accountChangeWalker walks the change of one account in the changeset
+type accountChangeWalker struct {
+	cs       changeset.AccountChangeSetBytes
+	addrHash common.Hash
+}
+
+func (w accountChangeWalker) wrap(b []byte) walker {
+	w.cs = b
+	return w
+}
+
+func (w accountChangeWalker) Walk(f func(k, v []byte) error) error {
+	return w.cs.Walk(func(k, v []byte) error {
+		if !bytes.Equal(k, w.addrHash[:]) {
+			return nil
+		}
+		return f(k, v)
+	})
+}
+
+// storageChangesWalker walks the storage changes of one contract in the changeset
+type storageChangesWalker struct {
+	cs       changeset.StorageChangeSetBytes
+	addrHash common.Hash
+}
+
+func (w storageChangesWalker) wrap(b []byte) walker {
+	w.cs = b
+	return w
+}
+
+func (w storageChangesWalker) Walk(f func(k, v []byte) error) error {
+	return w.cs.WalkContract(w.addrHash[:], f)
+}
+
 type rewindDataCollector struct {
 	AccountData map[string][]byte
 	StorageData map[string][]byte
@@ -107,9 +236,8 @@ func bytesToStorageChangeSetWalkerPlain(b []byte) walker {
 	return changeset.StorageChangeSetPlainBytes(b)
 }
 
-func walkAndCollect(collectorFunc func([]byte, []byte) error, db Getter, bucket []byte, suffixDst []byte, timestampSrc uint64, bytesToWalker func([]byte) walker) error {
-	return db.Walk(bucket, suffixDst, 0, func(k, v []byte) (bool, error) {
-		timestamp, _ := dbutils.DecodeTimestamp(k)
+func walkAndCollect(collectorFunc func([]byte, []byte) error, db Getter, bucket []byte, timestampDst uint64, timestampSrc uint64, bytesToWalker func([]byte) walker) error {
+	return WalkChangeSets(db, bucket, timestampDst, func(timestamp uint64, v []byte) (bool, error) {
 		if timestamp > timestampSrc {
 			return false, nil
 		}
@@ -0,0 +1,331 @@
+package ethdb
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/ledgerwatch/bolt"
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/log"
+)
+
+// SplitDatabase keeps some buckets (normally history and changesets, see dbutils.ColdBuckets)
+// in a separate Bolt database, which can be placed on a different disk:
+// hot CurrentStateBucket on NVMe, cold history on HDD.
+// Requests are routed by bucket name, so for the users it looks like a single database.
+//
+// Writes can't be atomic across two databases, so MultiPut journals the history writes
+// in the state database first, see split_db_journal.go.
+type SplitDatabase struct {
+	main    *BoltDatabase
+	history *BoltDatabase
+	routed  map[string]struct{}
+	id      uint64
+
+	journalMu    sync.Mutex // MultiPut and the journal recovery must not interleave
+	journalDirty uint32     // 1 if the journal may contain history writes not yet applied, see setJournalDirty
+}
+
+// NewSplitDatabase routes buckets listed in historyBuckets to history, all other buckets - to main.
+// SplitDatabase takes ownership of both databases and closes them on Close.
+func NewSplitDatabase(main, history *BoltDatabase, historyBuckets [][]byte) *SplitDatabase {
+	routed := make(map[string]struct{}, len(historyBuckets))
+	for _, bucket := range historyBuckets {
+		routed[string(bucket)] = struct{}{}
+	}
+	return &SplitDatabase{
+		main:    main,
+		history: history,
+		routed:  routed,
+		id:      id(),
+		// The journal is recovered before the first MultiPut, or explicitly by RecoverJournal
+		journalDirty: 1,
+	}
+}
+
+// NewSplitBoltDatabase opens (or creates) two Bolt databases with the same options and combines them into SplitDatabase
+func NewSplitBoltDatabase(mainFile, historyFile string, historyBuckets [][]byte, options BoltOptions) (*SplitDatabase, error) {
+	main, err := NewBoltDatabaseWithOptions(mainFile, options)
+	if err != nil {
+		return nil, err
+	}
+	history, err := NewBoltDatabaseWithOptions(historyFile, options)
+	if err != nil {
+		main.Close()
+		return nil, err
+	}
+	log.Info("Opened split database", "state", mainFile, "history", historyFile)
+	d := NewSplitDatabase(main, history, historyBuckets)
+	if err := d.RecoverJournal(); err != nil {
+		d.Close()
+		return nil, err
+	}
+	return d, nil
+}
+
+// SetNoSync turns fsync of the commits off or on in both databases
+func (d *SplitDatabase) SetNoSync(noSync bool) error {
+	if err := d.main.SetNoSync(noSync); err != nil {
+		return err
+	}
+	return d.history.SetNoSync(noSync)
+}
+
+func (d *SplitDatabase) route(bucket []byte) *BoltDatabase {
+	if _, ok := d.routed[string(bucket)]; ok {
+		return d.history
+	}
+	return d.main
+}
+
+// routeRead is route for the reads, the history left in the journal is applied before it is read
+func (d *SplitDatabase) routeRead(bucket []byte) (*BoltDatabase, error) {
+	db := d.route(bucket)
+	if db == d.history {
+		if err := d.settleJournal(); err != nil {
+			return nil, err
+		}
+	}
+	return db, nil
+}
+
+// viewBoth opens read transactions in both databases, to read state and history consistently
+func (d *SplitDatabase) viewBoth(f func(stateTx, historyTx *bolt.Tx) error) error {
+	if err := d.settleJournal(); err != nil {
+		return err
+	}
+	err := d.main.db.View(func(stateTx *bolt.Tx) error {
+		return d.history.db.View(func(historyTx *bolt.Tx) error {
+			return f(stateTx, historyTx)
+		})
+	})
+	return boltErr(err)
+}
+
+// Put journals the writes into the history buckets, like MultiPut
+func (d *SplitDatabase) Put(bucket, key []byte, value []byte) error {
+	if d.route(bucket) == d.history {
+		if value == nil {
+			value = []byte{}
+		}
+		_, err := d.MultiPut(bucket, key, value)
+		return err
+	}
+	return d.main.Put(bucket, key, value)
+}
+
+// MultiPut splits the tuples between two databases. State is written together with the journal
+// of the history writes, then history is written and the journal is trimmed
+func (d *SplitDatabase) MultiPut(tuples ...[]byte) (uint64, error) {
+	d.journalMu.Lock()
+	defer d.journalMu.Unlock()
+	if d.isJournalDirty() {
+		if err := d.recoverJournal(); err != nil {
+			return 0, err
+		}
+	}
+	var mainTuples, historyTuples [][]byte
+	for i := 0; i < len(tuples); i += 3 {
+		if d.route(tuples[i]) == d.history {
+			historyTuples = append(historyTuples, tuples[i:i+3]...)
+		} else {
+			mainTuples = append(mainTuples, tuples[i:i+3]...)
+		}
+	}
+	if len(historyTuples) == 0 {
+		if len(mainTuples) == 0 {
+			return 0, nil
+		}
+		return d.main.MultiPut(mainTuples...)
+	}
+	mainTuples = append(mainTuples, journalTuples(historyTuples)...)
+	sort.Sort(MultiPutTuples(mainTuples))
+	written, err := d.main.MultiPut(mainTuples...)
+	if err != nil {
+		return 0, err
+	}
+	// From now on, the history writes are going to be applied even if the process crashes
+	d.setJournalDirty(true)
+	n, err := d.history.MultiPut(historyTuples...)
+	if err != nil {
+		return written, err
+	}
+	written += n
+	if err := d.trimJournal(); err != nil {
+		return written, err
+	}
+	d.setJournalDirty(false)
+	return written, nil
+}
+
+func (d *SplitDatabase) Get(bucket, key []byte) ([]byte, error) {
+	db, err := d.routeRead(bucket)
+	if err != nil {
+		return nil, err
+	}
+	return db.Get(bucket, key)
+}
+
+func (d *SplitDatabase) GetIndexChunk(bucket, key []byte, timestamp uint64) ([]byte, error) {
+	db, err := d.routeRead(bucket)
+	if err != nil {
+		return nil, err
+	}
+	return db.GetIndexChunk(bucket, key, timestamp)
+}
+
+func (d *SplitDatabase) GetChangeSetByBlock(hBucket []byte, timestamp uint64) ([]byte, error) {
+	if err := d.settleJournal(); err != nil {
+		return nil, err
+	}
+	return d.history.GetChangeSetByBlock(hBucket, timestamp)
+}
+
+func (d *SplitDatabase) GetAsOf(bucket, hBucket, key []byte, timestamp uint64) ([]byte, error) {
+	var dat []byte
+	err := d.viewBoth(func(stateTx, historyTx *bolt.Tx) error {
+		var err error
+		dat, err = getAsOf(stateTx, historyTx, bucket, hBucket, key, timestamp)
+		return err
+	})
+	return dat, err
+}
+
+func (d *SplitDatabase) Has(bucket, key []byte) (bool, error) {
+	db, err := d.routeRead(bucket)
+	if err != nil {
+		return false, err
+	}
+	return db.Has(bucket, key)
+}
+
+func (d *SplitDatabase) Walk(bucket, startkey []byte, fixedbits int, walker func([]byte, []byte) (bool, error)) error {
+	db, err := d.routeRead(bucket)
+	if err != nil {
+		return err
+	}
+	return db.Walk(bucket, startkey, fixedbits, walker)
+}
+
+func (d *SplitDatabase) MultiWalk(bucket []byte, startkeys [][]byte, fixedbits []int, walker func(int, []byte, []byte) error) error {
+	db, err := d.routeRead(bucket)
+	if err != nil {
+		return err
+	}
+	return db.MultiWalk(bucket, startkeys, fixedbits, walker)
+}
+
+func (d *SplitDatabase) WalkAsOf(bucket, hBucket, startkey []byte, fixedbits int, timestamp uint64, walker func([]byte, []byte) (bool, error)) error {
+	return d.AbstractKV().View(context.Background(), func(tx Tx) error {
+		return WalkAsOfTx(tx, bucket, hBucket, startkey, fixedbits, timestamp, walker)
+	})
+}
+
+// Delete journals the deletions from the history buckets, like MultiPut
+func (d *SplitDatabase) Delete(bucket, key []byte) error {
+	if d.route(bucket) == d.history {
+		_, err := d.MultiPut(bucket, key, nil)
+		return err
+	}
+	return d.main.Delete(bucket, key)
+}
+
+// DeleteBucket journals the deletion of every key of the history bucket, see splitTx.DropBucket
+func (d *SplitDatabase) DeleteBucket(bucket []byte) error {
+	if d.route(bucket) == d.history {
+		return d.AbstractKV().Update(context.Background(), func(tx Tx) error {
+			return tx.DropBucket(bucket)
+		})
+	}
+	return d.main.DeleteBucket(bucket)
+}
+
+func (d *SplitDatabase) Close() {
+	d.history.Close()
+	d.main.Close()
+}
+
+func (d *SplitDatabase) NewBatch() DbWithPendingMutations {
+	return &mutation{
+		db:   d,
+		puts: newPuts(),
+	}
+}
+
+func (d *SplitDatabase) IdealBatchSize() int {
+	return d.main.IdealBatchSize()
+}
+
+func (d *SplitDatabase) DiskSize() int64 {
+	return d.main.DiskSize() + d.history.DiskSize()
+}
+
+// Keys returns keys of the routed buckets from history, and keys of all other buckets from main
+func (d *SplitDatabase) Keys() ([][]byte, error) {
+	var keys [][]byte
+	for _, db := range []*BoltDatabase{d.main, d.history} {
+		dbKeys, err := db.Keys()
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; i < len(dbKeys); i += 2 {
+			if d.route(dbKeys[i]) == db {
+				keys = append(keys, dbKeys[i], dbKeys[i+1])
+			}
+		}
+	}
+	return keys, nil
+}
+
+// MemCopy merges both databases into a single in-memory database
+func (d *SplitDatabase) MemCopy() Database {
+	mem := d.main.MemCopy().(*BoltDatabase)
+	if err := d.history.db.View(func(readTx *bolt.Tx) error {
+		return readTx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			if d.route(name) != d.history {
+				return nil
+			}
+			return mem.db.Update(func(writeTx *bolt.Tx) error {
+				newBucketToWrite, err := writeTx.CreateBucketIfNotExists(name, false)
+				if err != nil {
+					return err
+				}
+				return b.ForEach(func(k, v []byte) error {
+					return newBucketToWrite.Put(common.CopyBytes(k), common.CopyBytes(v))
+				})
+			})
+		})
+	}); err != nil {
+		panic(err)
+	}
+	return mem
+}
+
+// KV returns the state database. Buckets routed to history are not visible through it, they are in HistoryKV.
+// AbstractKV routes the buckets between both databases
+func (d *SplitDatabase) KV() *bolt.DB {
+	return d.main.KV()
+}
+
+// HistoryKV returns the history database, see HasHistoryKV
+func (d *SplitDatabase) HistoryKV() *bolt.DB {
+	return d.history.KV()
+}
+
+// IsHistoryBucket tells whether the bucket is kept in the history database
+func (d *SplitDatabase) IsHistoryBucket(bucket []byte) bool {
+	return d.route(bucket) == d.history
+}
+
+func (d *SplitDatabase) Ancients() (uint64, error) {
+	return 0, errNotSupported
+}
+
+func (d *SplitDatabase) TruncateAncients(items uint64) error {
+	return errNotSupported
+}
+
+func (d *SplitDatabase) ID() uint64 {
+	return d.id
+}
@@ -0,0 +1,152 @@
+package ethdb
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"sync/atomic"
+
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+	"github.com/ledgerwatch/turbo-geth/log"
+)
+
+// Write-ahead journal of SplitDatabase.
+//
+// Two Bolt databases can't be updated in one transaction, so history writes are first journaled
+// into dbutils.HistoryJournalBucket of the state database, in the same transaction as the state writes.
+// Then they are applied to the history database, and the journal is trimmed.
+// If the process crashes after the state transaction is committed, the journal is replayed on the next start
+// (and before the next write), so the history always matches the state. If only the history write fails,
+// the journal is replayed before the next read of the history too.
+// Deletions (nil values) are journaled too, so unwinding (see TrieDbState.UnwindTo),
+// which deletes changesets and history records of the unwound blocks, is covered the same way.
+// UnwindTo reads the changesets of the blocks it unwinds, they are the ones of the journal if it is not replayed yet.
+//
+// The journal only covers the split between the two databases. Over one database the atomicity is the one of MultiPut,
+// a single transaction, so the writes which have to survive a crash together (like the state, the progress and
+// the changesets of the execution stage) are committed with one CommitTogether, not with two commits.
+
+const journalValueIsNil = 1 // flag of the journal record - history record has to be deleted
+
+// encodeJournalRecord encodes one history write as:
+// flags (1 byte), length of bucket (varint), bucket, length of key (varint), key, value
+func encodeJournalRecord(bucket, key, value []byte) []byte {
+	rec := make([]byte, 1+2*binary.MaxVarintLen64+len(bucket)+len(key)+len(value))
+	if value == nil {
+		rec[0] = journalValueIsNil
+	}
+	pos := 1
+	pos += binary.PutUvarint(rec[pos:], uint64(len(bucket)))
+	pos += copy(rec[pos:], bucket)
+	pos += binary.PutUvarint(rec[pos:], uint64(len(key)))
+	pos += copy(rec[pos:], key)
+	pos += copy(rec[pos:], value)
+	return rec[:pos]
+}
+
+func decodeJournalRecord(rec []byte) (bucket, key, value []byte, err error) {
+	if len(rec) == 0 {
+		return nil, nil, nil, fmt.Errorf("empty journal record")
+	}
+	pos := 1
+	for _, part := range []*[]byte{&bucket, &key} {
+		l, n := binary.Uvarint(rec[pos:])
+		if n <= 0 || uint64(len(rec)-pos-n) < l {
+			return nil, nil, nil, fmt.Errorf("malformed journal record %x", rec)
+		}
+		pos += n
+		*part = rec[pos : pos+int(l)]
+		pos += int(l)
+	}
+	if rec[0]&journalValueIsNil == 0 {
+		value = rec[pos:]
+	}
+	return bucket, key, value, nil
+}
+
+// journalTuples converts the tuples of history writes into the tuples writing them into the journal
+func journalTuples(historyTuples [][]byte) [][]byte {
+	tuples := make([][]byte, 0, len(historyTuples))
+	for i := 0; i < len(historyTuples); i += 3 {
+		idx := make([]byte, 4)
+		binary.BigEndian.PutUint32(idx, uint32(i/3))
+		tuples = append(tuples, dbutils.HistoryJournalBucket, idx, encodeJournalRecord(historyTuples[i], historyTuples[i+1], historyTuples[i+2]))
+	}
+	return tuples
+}
+
+// RecoverJournal applies the history writes left in the journal by the interrupted MultiPut, and trims the journal
+func (d *SplitDatabase) RecoverJournal() error {
+	d.journalMu.Lock()
+	defer d.journalMu.Unlock()
+	return d.recoverJournal()
+}
+
+// settleJournal is RecoverJournal, which does nothing unless the history writes may be left in the journal.
+// The flag is checked before taking the lock, so that the reads inside the writable transactions of the abstract KV,
+// which hold the lock with the journal already recovered, do not block
+func (d *SplitDatabase) settleJournal() error {
+	if !d.isJournalDirty() {
+		return nil
+	}
+	d.journalMu.Lock()
+	defer d.journalMu.Unlock()
+	if !d.isJournalDirty() {
+		return nil
+	}
+	return d.recoverJournal()
+}
+
+func (d *SplitDatabase) isJournalDirty() bool {
+	return atomic.LoadUint32(&d.journalDirty) == 1
+}
+
+// setJournalDirty is called with journalMu held, the flag is read without it by settleJournal
+func (d *SplitDatabase) setJournalDirty(dirty bool) {
+	var v uint32
+	if dirty {
+		v = 1
+	}
+	atomic.StoreUint32(&d.journalDirty, v)
+}
+
+func (d *SplitDatabase) recoverJournal() error {
+	var tuples MultiPutTuples
+	if err := d.main.AbstractKV().View(context.Background(), func(tx Tx) error {
+		if exists, err := tx.ExistsBucket(dbutils.HistoryJournalBucket); err != nil || !exists {
+			return err
+		}
+		return tx.Bucket(dbutils.HistoryJournalBucket).Cursor().Walk(func(_, rec []byte) (bool, error) {
+			bucket, key, value, err := decodeJournalRecord(rec)
+			if err != nil {
+				return false, err
+			}
+			if value != nil {
+				value = append([]byte{}, value...)
+			}
+			tuples = append(tuples, append([]byte{}, bucket...), append([]byte{}, key...), value)
+			return true, nil
+		})
+	}); err != nil {
+		return err
+	}
+	if len(tuples) > 0 {
+		log.Warn("Replaying history journal of the split database", "records", tuples.Len())
+		sort.Stable(tuples)
+		if _, err := d.history.MultiPut(tuples...); err != nil {
+			return fmt.Errorf("replaying history journal: %w", err)
+		}
+	}
+	if err := d.trimJournal(); err != nil {
+		return err
+	}
+	d.setJournalDirty(false)
+	return nil
+}
+
+func (d *SplitDatabase) trimJournal() error {
+	return d.main.AbstractKV().Update(context.Background(), func(tx Tx) error {
+		return tx.DropBucket(dbutils.HistoryJournalBucket)
+	})
+}
@@ -0,0 +1,205 @@
+package ethdb
+
+import (
+	"context"
+	"sort"
+	"testing"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/common/changeset"
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+	"github.com/ledgerwatch/turbo-geth/core/types/accounts"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func encodeTestAccount(balance uint64) []byte {
+	acc := accounts.NewAccount()
+	acc.Balance.SetUint64(balance)
+	v := make([]byte, acc.EncodingLengthForStorage())
+	acc.EncodeForStorage(v)
+	return v
+}
+
+func TestSplitDatabase(t *testing.T) {
+	main, history := NewMemDatabase(), NewMemDatabase()
+	db := NewSplitDatabase(main, history, dbutils.ColdBuckets)
+	defer db.Close()
+
+	addrHash := common.HexToHash("0x11").Bytes()
+	accOld, accNew := encodeTestAccount(1), encodeTestAccount(2)
+
+	// account has been changed at block 5
+	cs := changeset.NewAccountChangeSet()
+	require.NoError(t, cs.Add(addrHash, accOld))
+	csBytes, err := changeset.EncodeAccounts(cs)
+	require.NoError(t, err)
+
+	batch := db.NewBatch()
+	require.NoError(t, batch.Put(dbutils.CurrentStateBucket, addrHash, accNew))
+	require.NoError(t, batch.Put(dbutils.AccountChangeSetBucket, dbutils.EncodeTimestamp(5), csBytes))
+	require.NoError(t, batch.Put(dbutils.AccountsHistoryBucket, dbutils.CurrentChunkKey(addrHash), dbutils.NewHistoryIndex().Append(5, false)))
+	_, err = batch.Commit()
+	require.NoError(t, err)
+
+	// routing
+	_, err = main.Get(dbutils.AccountChangeSetBucket, dbutils.EncodeTimestamp(5))
+	assert.True(t, IsNotFound(err))
+	_, err = history.Get(dbutils.AccountChangeSetBucket, dbutils.EncodeTimestamp(5))
+	assert.NoError(t, err)
+	_, err = history.Get(dbutils.CurrentStateBucket, addrHash)
+	assert.True(t, IsNotFound(err))
+	v, err := db.Get(dbutils.CurrentStateBucket, addrHash)
+	assert.NoError(t, err)
+	assert.Equal(t, accNew, v)
+
+	// history lookups combine both databases
+	v, err = db.GetAsOf(dbutils.CurrentStateBucket, dbutils.AccountsHistoryBucket, addrHash, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, accOld, v)
+	v, err = db.GetAsOf(dbutils.CurrentStateBucket, dbutils.AccountsHistoryBucket, addrHash, 6)
+	assert.NoError(t, err)
+	assert.Equal(t, accNew, v)
+
+	var walked [][]byte
+	err = db.WalkAsOf(dbutils.CurrentStateBucket, dbutils.AccountsHistoryBucket, addrHash, 0, 3, func(k, v []byte) (bool, error) {
+		walked = append(walked, common.CopyBytes(v))
+		return true, nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{accOld}, walked)
+
+	// in-memory copy contains buckets of both databases
+	mem := db.MemCopy()
+	defer mem.Close()
+	v, err = mem.Get(dbutils.AccountChangeSetBucket, dbutils.EncodeTimestamp(5))
+	assert.NoError(t, err)
+	assert.Equal(t, csBytes, v)
+	v, err = mem.Get(dbutils.CurrentStateBucket, addrHash)
+	assert.NoError(t, err)
+	assert.Equal(t, accNew, v)
+
+	keys, err := db.Keys()
+	assert.NoError(t, err)
+	assert.Equal(t, 6, len(keys)) // 3 pairs of bucket and key
+}
+
+func TestSplitDatabaseJournal(t *testing.T) {
+	main, history := NewMemDatabase(), NewMemDatabase()
+
+	addrHash := common.HexToHash("0x11").Bytes()
+	staleKey := dbutils.EncodeTimestamp(4)
+	require.NoError(t, history.Put(dbutils.AccountChangeSetBucket, staleKey, []byte{1}))
+
+	// Process crashed after the state and the journal were committed, but before the history was
+	historyTuples := [][]byte{
+		dbutils.AccountChangeSetBucket, staleKey, nil,
+		dbutils.AccountChangeSetBucket, dbutils.EncodeTimestamp(5), []byte{2},
+		dbutils.AccountsHistoryBucket, addrHash, []byte{7},
+	}
+	tuples := append([][]byte{dbutils.CurrentStateBucket, addrHash, encodeTestAccount(2)}, journalTuples(historyTuples)...)
+	sort.Sort(MultiPutTuples(tuples))
+	_, err := main.MultiPut(tuples...)
+	require.NoError(t, err)
+
+	db := NewSplitDatabase(main, history, dbutils.ColdBuckets)
+	defer db.Close()
+	require.NoError(t, db.RecoverJournal())
+
+	_, err = history.Get(dbutils.AccountChangeSetBucket, staleKey)
+	assert.True(t, IsNotFound(err), "deletion should be replayed")
+	v, err := history.Get(dbutils.AccountChangeSetBucket, dbutils.EncodeTimestamp(5))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{2}, v)
+	v, err = history.Get(dbutils.AccountsHistoryBucket, addrHash)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{7}, v)
+
+	var journaled int
+	require.NoError(t, main.Walk(dbutils.HistoryJournalBucket, nil, 0, func(k, v []byte) (bool, error) {
+		journaled++
+		return true, nil
+	}))
+	assert.Equal(t, 0, journaled, "journal should be trimmed")
+
+	// Regular writes leave no journal behind
+	batch := db.NewBatch()
+	require.NoError(t, batch.Put(dbutils.AccountChangeSetBucket, dbutils.EncodeTimestamp(6), []byte{3}))
+	_, err = batch.Commit()
+	require.NoError(t, err)
+	_, err = main.Get(dbutils.HistoryJournalBucket, []byte{0, 0, 0, 0})
+	assert.True(t, IsNotFound(err))
+	v, err = history.Get(dbutils.AccountChangeSetBucket, dbutils.EncodeTimestamp(6))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{3}, v)
+}
+
+func TestSplitDatabaseJournalReads(t *testing.T) {
+	main, history := NewMemDatabase(), NewMemDatabase()
+	unwound := dbutils.EncodeTimestamp(5)
+	require.NoError(t, history.Put(dbutils.AccountChangeSetBucket, unwound, []byte{1}))
+
+	// The history write of the unwinding failed after the state and the journal were committed
+	tuples := journalTuples([][]byte{dbutils.AccountChangeSetBucket, unwound, nil})
+	_, err := main.MultiPut(tuples...)
+	require.NoError(t, err)
+	db := NewSplitDatabase(main, history, dbutils.ColdBuckets)
+	defer db.Close()
+
+	// The reads of the history see the journaled writes
+	_, err = db.Get(dbutils.AccountChangeSetBucket, unwound)
+	assert.True(t, IsNotFound(err), "deletion should be replayed before the read")
+	_, err = main.Get(dbutils.HistoryJournalBucket, []byte{0, 0, 0, 0})
+	assert.True(t, IsNotFound(err))
+
+	// Put and Delete of the history buckets go through the journal as well
+	require.NoError(t, db.Put(dbutils.AccountChangeSetBucket, unwound, []byte{2}))
+	v, err := history.Get(dbutils.AccountChangeSetBucket, unwound)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{2}, v)
+	require.NoError(t, db.Delete(dbutils.AccountChangeSetBucket, unwound))
+	_, err = history.Get(dbutils.AccountChangeSetBucket, unwound)
+	assert.True(t, IsNotFound(err))
+	_, err = main.Get(dbutils.HistoryJournalBucket, []byte{0, 0, 0, 0})
+	assert.True(t, IsNotFound(err))
+}
+
+func TestSplitDatabaseAbstractKV(t *testing.T) {
+	main, history := NewMemDatabase(), NewMemDatabase()
+	db := NewSplitDatabase(main, history, dbutils.ColdBuckets)
+	defer db.Close()
+
+	addrHash := common.HexToHash("0x11").Bytes()
+	require.NoError(t, db.Put(dbutils.CurrentStateBucket, addrHash, encodeTestAccount(2)))
+	require.NoError(t, db.Put(dbutils.AccountChangeSetBucket, dbutils.EncodeTimestamp(5), []byte{1}))
+
+	// Both databases are visible through the abstract KV
+	require.NoError(t, db.AbstractKV().View(context.Background(), func(tx Tx) error {
+		v, err := tx.Bucket(dbutils.CurrentStateBucket).Get(addrHash)
+		require.NoError(t, err)
+		assert.Equal(t, encodeTestAccount(2), v)
+		v, err = tx.Bucket(dbutils.AccountChangeSetBucket).Get(dbutils.EncodeTimestamp(5))
+		require.NoError(t, err)
+		assert.Equal(t, []byte{1}, v)
+		return nil
+	}))
+
+	require.NoError(t, db.AbstractKV().Update(context.Background(), func(tx Tx) error {
+		if err := tx.Bucket(dbutils.AccountChangeSetBucket).Put(dbutils.EncodeTimestamp(6), []byte{2}); err != nil {
+			return err
+		}
+		return tx.Bucket(dbutils.AccountChangeSetBucket).Delete(dbutils.EncodeTimestamp(5))
+	}))
+	v, err := history.Get(dbutils.AccountChangeSetBucket, dbutils.EncodeTimestamp(6))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{2}, v)
+	_, err = history.Get(dbutils.AccountChangeSetBucket, dbutils.EncodeTimestamp(5))
+	assert.True(t, IsNotFound(err))
+	_, err = main.Get(dbutils.AccountChangeSetBucket, dbutils.EncodeTimestamp(6))
+	assert.True(t, IsNotFound(err), "history bucket should not be written into the state database")
+	_, err = main.Get(dbutils.HistoryJournalBucket, []byte{0, 0, 0, 0})
+	assert.True(t, IsNotFound(err), "journal should be trimmed")
+
+	assert.Equal(t, main.KV(), BoltKVOf(db, dbutils.CurrentStateBucket))
+	assert.Equal(t, history.KV(), BoltKVOf(db, dbutils.AccountChangeSetBucket))
+}
@@ -0,0 +1,235 @@
+package ethdb
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+
+	"github.com/ledgerwatch/bolt"
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+)
+
+// In the split state schema the entries of dbutils.CurrentStateBucket are kept in two buckets: the accounts in
+// dbutils.CurrentStateAccountsBucket and the storage items in dbutils.CurrentStateStorageBucket, so that the walks
+// over the accounts do not skip the storage, and the accounts are packed densely in the pages.
+// BoltDatabase routes the requests to CurrentStateBucket into the shards, so for its users the schema makes
+// no difference, and so do the transactions of the abstract KV (see boltTx.Bucket), which the remote databases
+// are served from. The users of the Bolt transactions (see BoltDatabase.KV) have to open the state with StateCursor
+
+// splitStates caches IsSplitState of the open databases. The state is only split once, see MarkSplitState
+var splitStates sync.Map
+
+// IsSplitState tells whether the state of the database is kept in the split schema, see dbutils.SplitStateKey
+func IsSplitState(tx *bolt.Tx) bool {
+	if v, ok := splitStates.Load(tx.DB()); ok {
+		return v.(bool)
+	}
+	split := readSplitState(tx)
+	// The transaction, which began before the state was split, does not replace the cached schema
+	splitStates.LoadOrStore(tx.DB(), split)
+	return split
+}
+
+func readSplitState(tx *bolt.Tx) bool {
+	b := tx.Bucket(dbutils.DatabaseInfoBucket)
+	if b == nil {
+		return false
+	}
+	v, _ := b.Get(dbutils.SplitStateKey)
+	return len(v) > 0
+}
+
+// MarkSplitState switches the database to the split state schema, once the transaction is committed
+func MarkSplitState(tx *bolt.Tx) error {
+	info, err := tx.CreateBucketIfNotExists(dbutils.DatabaseInfoBucket, false)
+	if err != nil {
+		return err
+	}
+	if err = info.Put(dbutils.SplitStateKey, []byte{1}); err != nil {
+		return err
+	}
+	db := tx.DB()
+	tx.OnCommit(func() {
+		splitStates.Store(db, true)
+	})
+	return nil
+}
+
+// forgetSplitState drops the cached schema of the closed database
+func forgetSplitState(db *bolt.DB) {
+	splitStates.Delete(db)
+}
+
+// StateShard returns the bucket keeping the entry of CurrentStateBucket in the split state schema
+func StateShard(key []byte) []byte {
+	if len(key) <= common.HashLength {
+		return dbutils.CurrentStateAccountsBucket
+	}
+	return dbutils.CurrentStateStorageBucket
+}
+
+// stateBucket returns the name of the bucket, which keeps the key of the bucket in the schema of the transaction
+func stateBucket(tx *bolt.Tx, bucket, key []byte) []byte {
+	if bytes.Equal(bucket, dbutils.CurrentStateBucket) && IsSplitState(tx) {
+		return StateShard(key)
+	}
+	return bucket
+}
+
+// stateShard returns the bucket, which keeps the entries of the shard in the schema of the transaction
+func stateShard(tx *bolt.Tx, shard []byte) (*bolt.Bucket, []byte) {
+	if IsSplitState(tx) {
+		return tx.Bucket(shard), shard
+	}
+	return tx.Bucket(dbutils.CurrentStateBucket), dbutils.CurrentStateBucket
+}
+
+// IsSplitStateTx is IsSplitState for the transactions of the abstract KV, i.e. of the remote databases
+func IsSplitStateTx(tx Tx) (bool, error) {
+	v, err := tx.Bucket(dbutils.DatabaseInfoBucket).Get(dbutils.SplitStateKey)
+	if errors.Is(err, ErrBucketNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return len(v) > 0, nil
+}
+
+// stateShardTx is stateShard for the transactions of the abstract KV, it returns the name of the bucket
+func stateShardTx(tx Tx, shard []byte) ([]byte, error) {
+	split, err := IsSplitStateTx(tx)
+	if err != nil {
+		return nil, err
+	}
+	if split {
+		return shard, nil
+	}
+	return dbutils.CurrentStateBucket, nil
+}
+
+// BoltCursor is the part of *bolt.Cursor used to walk the buckets
+type BoltCursor interface {
+	First() ([]byte, []byte)
+	Seek(seek []byte) ([]byte, []byte)
+	SeekTo(seek []byte) ([]byte, []byte)
+	Next() ([]byte, []byte)
+}
+
+// bucketCursor opens the cursor over the bucket, the entries of CurrentStateBucket are merged
+// from the shards in the split state schema, the values of the compressed buckets are decoded (see codecCursor).
+// Returns nil if the bucket does not exist
+func bucketCursor(tx *bolt.Tx, bucket []byte) (BoltCursor, error) {
+	if bytes.Equal(bucket, dbutils.CurrentStateBucket) {
+		return StateCursor(tx), nil
+	}
+	b := tx.Bucket(bucket)
+	if b == nil {
+		return nil, nil
+	}
+	codec, err := bucketCodec(tx, bucket)
+	if err != nil {
+		return nil, err
+	}
+	if codec != nil {
+		return &codecCursor{BoltCursor: b.Cursor(), codec: codec}, nil
+	}
+	return b.Cursor(), nil
+}
+
+// StateCursor opens the cursor over the entries of CurrentStateBucket in the schema of the transaction,
+// returns nil if the state buckets do not exist
+func StateCursor(tx *bolt.Tx) BoltCursor {
+	if !IsSplitState(tx) {
+		b := tx.Bucket(dbutils.CurrentStateBucket)
+		if b == nil {
+			return nil
+		}
+		return b.Cursor()
+	}
+	accounts, storage := tx.Bucket(dbutils.CurrentStateAccountsBucket), tx.Bucket(dbutils.CurrentStateStorageBucket)
+	if accounts == nil || storage == nil {
+		return nil
+	}
+	return &stateShardsCursor{accounts: accounts.Cursor(), storage: storage.Cursor()}
+}
+
+// stateShardsCursor merges the accounts and the storage items into the order of CurrentStateBucket.
+// The account key is a prefix of the keys of its storage items, so it goes before them
+type stateShardsCursor struct {
+	accounts, storage *bolt.Cursor
+	ak, av            []byte
+	sk, sv            []byte
+	positioned        bool
+}
+
+func (c *stateShardsCursor) current() ([]byte, []byte) {
+	if c.ak != nil && (c.sk == nil || bytes.Compare(c.ak, c.sk) < 0) {
+		return c.ak, c.av
+	}
+	return c.sk, c.sv
+}
+
+func (c *stateShardsCursor) First() ([]byte, []byte) {
+	c.ak, c.av = c.accounts.First()
+	c.sk, c.sv = c.storage.First()
+	c.positioned = true
+	return c.current()
+}
+
+func (c *stateShardsCursor) Seek(seek []byte) ([]byte, []byte) {
+	c.ak, c.av = c.accounts.Seek(seek)
+	c.sk, c.sv = c.storage.Seek(seek)
+	c.positioned = true
+	return c.current()
+}
+
+func (c *stateShardsCursor) SeekTo(seek []byte) ([]byte, []byte) {
+	// The shards, which are already past the key, stay in place
+	if !c.positioned || c.ak != nil && bytes.Compare(c.ak, seek) < 0 {
+		c.ak, c.av = c.accounts.SeekTo(seek)
+	}
+	if !c.positioned || c.sk != nil && bytes.Compare(c.sk, seek) < 0 {
+		c.sk, c.sv = c.storage.SeekTo(seek)
+	}
+	c.positioned = true
+	return c.current()
+}
+
+func (c *stateShardsCursor) Next() ([]byte, []byte) {
+	if c.ak != nil && (c.sk == nil || bytes.Compare(c.ak, c.sk) < 0) {
+		c.ak, c.av = c.accounts.Next()
+	} else if c.sk != nil {
+		c.sk, c.sv = c.storage.Next()
+	}
+	return c.current()
+}
+
+// multiPutStateShards writes the sorted pairs of the keys and the values of CurrentStateBucket into the shards
+func multiPutStateShards(tx *bolt.Tx, pairs [][]byte) error {
+	var accountPairs, storagePairs [][]byte
+	for i := 0; i < len(pairs); i += 2 {
+		if bytes.Equal(StateShard(pairs[i]), dbutils.CurrentStateAccountsBucket) {
+			accountPairs = append(accountPairs, pairs[i], pairs[i+1])
+		} else {
+			storagePairs = append(storagePairs, pairs[i], pairs[i+1])
+		}
+	}
+	for _, shard := range []struct {
+		bucket []byte
+		pairs  [][]byte
+	}{{dbutils.CurrentStateAccountsBucket, accountPairs}, {dbutils.CurrentStateStorageBucket, storagePairs}} {
+		if len(shard.pairs) == 0 {
+			continue
+		}
+		b, err := tx.CreateBucketIfNotExists(shard.bucket, false)
+		if err != nil {
+			return err
+		}
+		if err := b.MultiPut(shard.pairs...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
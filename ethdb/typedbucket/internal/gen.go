@@ -39,7 +39,7 @@ import (
 	"bytes"
 	"errors"
 
-	"github.com/ledgerwatch/bolt"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
 	"github.com/ledgerwatch/turbo-geth/ethdb/codecpool"
 )
 `)
@@ -61,10 +61,10 @@ import (
 
 var typedBucketTemplate = template.Must(template.New("").Parse(`
 type {{.BucketType}} struct {
-	*bolt.Bucket
+	ethdb.Bucket
 }
 
-func New{{.BucketType}}(b *bolt.Bucket) *{{.BucketType}} {
+func New{{.BucketType}}(b ethdb.Bucket) *{{.BucketType}} {
 	return &{{.BucketType}}{b}
 }
 
@@ -129,13 +129,13 @@ func (b *{{.BucketType}}) Put(key []byte, value {{.Type}}) error {
 }
 
 func (b *{{.BucketType}}) ForEach(fn func([]byte, {{.Type}}) error) error {
-	return b.Bucket.ForEach(func(k, v []byte) error {
+	return b.Bucket.Cursor().Walk(func(k, v []byte) (bool, error) {
 		var value {{.Type}}
 		decoder := codecpool.Decoder(bytes.NewReader(v))
 		defer codecpool.Return(decoder)
 
 		decoder.MustDecode(&value)
-		return fn(k, value)
+		return true, fn(k, value)
 	})
 }
 `))
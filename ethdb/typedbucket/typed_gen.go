@@ -5,15 +5,15 @@ import (
 	"bytes"
 	"errors"
 
-	"github.com/ledgerwatch/bolt"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
 	"github.com/ledgerwatch/turbo-geth/ethdb/codecpool"
 )
 
 type Uint64 struct {
-	*bolt.Bucket
+	ethdb.Bucket
 }
 
-func NewUint64(b *bolt.Bucket) *Uint64 {
+func NewUint64(b ethdb.Bucket) *Uint64 {
 	return &Uint64{b}
 }
 
@@ -74,21 +74,21 @@ func (b *Uint64) Put(key []byte, value uint64) error {
 }
 
 func (b *Uint64) ForEach(fn func([]byte, uint64) error) error {
-	return b.Bucket.ForEach(func(k, v []byte) error {
+	return b.Bucket.Cursor().Walk(func(k, v []byte) (bool, error) {
 		var value uint64
 		decoder := codecpool.Decoder(bytes.NewReader(v))
 		defer codecpool.Return(decoder)
 
 		decoder.MustDecode(&value)
-		return fn(k, value)
+		return true, fn(k, value)
 	})
 }
 
 type Int struct {
-	*bolt.Bucket
+	ethdb.Bucket
 }
 
-func NewInt(b *bolt.Bucket) *Int {
+func NewInt(b ethdb.Bucket) *Int {
 	return &Int{b}
 }
 
@@ -141,12 +141,12 @@ func (b *Int) Put(key []byte, value int) error {
 }
 
 func (b *Int) ForEach(fn func([]byte, int) error) error {
-	return b.Bucket.ForEach(func(k, v []byte) error {
+	return b.Bucket.Cursor().Walk(func(k, v []byte) (bool, error) {
 		var value int
 		decoder := codecpool.Decoder(bytes.NewReader(v))
 		defer codecpool.Return(decoder)
 
 		decoder.MustDecode(&value)
-		return fn(k, value)
+		return true, fn(k, value)
 	})
 }
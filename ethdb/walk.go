@@ -17,7 +17,9 @@
 package ethdb
 
 import (
+	"bytes"
 	"fmt"
+	"sort"
 
 	"github.com/ledgerwatch/turbo-geth/common/changeset"
 
@@ -27,19 +29,54 @@ import (
 
 var EndSuffix = []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
 
-
+// GetModifiedAccounts returns the accounts modified in the blocks from startTimestamp to endTimestamp (both inclusive),
+// as recorded in the account changesets
 func GetModifiedAccounts(db Getter, startTimestamp, endTimestamp uint64) ([]common.Address, error) {
-	keys := make(map[common.Hash]struct{})
-	startCode := dbutils.EncodeTimestamp(startTimestamp)
-	if err := db.Walk(dbutils.AccountChangeSetBucket, startCode, 0, func(k, v []byte) (bool, error) {
-		keyTimestamp, _ := dbutils.DecodeTimestamp(k)
+	hashes, err := modifiedAccountHashes(db, startTimestamp, endTimestamp, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(hashes) == 0 {
+		return nil, nil
+	}
+	return accountsByHashes(db, hashes)
+}
 
+// GetModifiedAccountsRange is GetModifiedAccounts with pagination, for the ranges with huge changesets.
+// It returns up to maxResults accounts, ordered by the hashes of the addresses and starting from the (prefix of) hash keyStart,
+// and the hash to start the next page from, or nil if there are no more accounts
+func GetModifiedAccountsRange(db Getter, startTimestamp, endTimestamp uint64, keyStart []byte, maxResults int) ([]common.Address, *common.Hash, error) {
+	hashes, err := modifiedAccountHashes(db, startTimestamp, endTimestamp, keyStart)
+	if err != nil {
+		return nil, nil, err
+	}
+	var next *common.Hash
+	if len(hashes) > maxResults {
+		next = &hashes[maxResults]
+		hashes = hashes[:maxResults]
+	}
+	accounts, err := accountsByHashes(db, hashes)
+	if err != nil {
+		return nil, nil, err
+	}
+	return accounts, next, nil
+}
+
+// modifiedAccountHashes collects the sorted hashes of the accounts in the changesets of the given blocks, starting from keyStart
+func modifiedAccountHashes(db Getter, startTimestamp, endTimestamp uint64, keyStart []byte) ([]common.Hash, error) {
+	if startTimestamp > endTimestamp {
+		return nil, fmt.Errorf("start block (%d) must not be greater than end block (%d)", startTimestamp, endTimestamp)
+	}
+	keys := make(map[common.Hash]struct{})
+	if err := WalkChangeSets(db, dbutils.AccountChangeSetBucket, startTimestamp, func(keyTimestamp uint64, v []byte) (bool, error) {
 		if keyTimestamp > endTimestamp {
 			return false, nil
 		}
 
 		walker := func(addrHash, _ []byte) error {
-			keys[common.BytesToHash(addrHash)] = struct{}{}
+			if bytes.Compare(addrHash, keyStart) >= 0 {
+				keys[common.BytesToHash(addrHash)] = struct{}{}
+			}
 			return nil
 		}
 
@@ -52,19 +89,80 @@ func GetModifiedAccounts(db Getter, startTimestamp, endTimestamp uint64) ([]comm
 		return nil, err
 	}
 
-	if len(keys) == 0 {
-		return nil, nil
+	hashes := make([]common.Hash, 0, len(keys))
+	for key := range keys {
+		hashes = append(hashes, key)
 	}
-	accounts := make([]common.Address, len(keys))
-	idx := 0
+	sort.Slice(hashes, func(i, j int) bool { return bytes.Compare(hashes[i][:], hashes[j][:]) < 0 })
+	return hashes, nil
+}
 
-	for key := range keys {
+func accountsByHashes(db Getter, hashes []common.Hash) ([]common.Address, error) {
+	accounts := make([]common.Address, len(hashes))
+	for i, key := range hashes {
 		value, err := db.Get(dbutils.PreimagePrefix, key[:])
 		if err != nil {
 			return nil, fmt.Errorf("could not get preimage for key %x", key)
 		}
-		copy(accounts[idx][:], value)
-		idx++
+		copy(accounts[i][:], value)
 	}
 	return accounts, nil
 }
+
+// GetAccountHistory returns the blocks in the range [from, to], in which the account changed, in the ascending order,
+// as recorded in dbutils.AccountsHistoryBucket. At most limit blocks are returned (limit <= 0 - no limit)
+func GetAccountHistory(db Getter, address common.Address, from, to uint64, limit int) ([]uint64, error) {
+	addrHash, err := common.HashData(address[:])
+	if err != nil {
+		return nil, err
+	}
+	return historyIndexBlocks(db, dbutils.AccountsHistoryBucket, addrHash[:], from, to, limit)
+}
+
+// GetStorageHistory returns the blocks in the range [from, to], in which the storage item of the contract changed,
+// in the ascending order, as recorded in dbutils.StorageHistoryBucket. The index does not distinguish
+// the incarnations of the contract. At most limit blocks are returned (limit <= 0 - no limit)
+func GetStorageHistory(db Getter, address common.Address, slot common.Hash, from, to uint64, limit int) ([]uint64, error) {
+	addrHash, err := common.HashData(address[:])
+	if err != nil {
+		return nil, err
+	}
+	slotHash, err := common.HashData(slot[:])
+	if err != nil {
+		return nil, err
+	}
+	// The incarnation is removed from the key by dbutils.IndexChunkKey
+	return historyIndexBlocks(db, dbutils.StorageHistoryBucket, dbutils.GenerateCompositeStorageKey(addrHash, 0, slotHash), from, to, limit)
+}
+
+// historyIndexBlocks seeks the chunk of the index, which contains the first block not less than from, and reads
+// the chunks from there on. The chunks are keyed by their last block, see dbutils.HistoryIndexBytes.Key
+func historyIndexBlocks(db Getter, hBucket, key []byte, from, to uint64, limit int) ([]uint64, error) {
+	if from > to {
+		return nil, fmt.Errorf("start block (%d) must not be greater than end block (%d)", from, to)
+	}
+	startkey := dbutils.IndexChunkKey(key, from)
+	var blocks []uint64
+	if err := db.Walk(hBucket, startkey, 8*(len(startkey)-8), func(_, v []byte) (bool, error) {
+		numbers, _, err := dbutils.WrapHistoryIndex(v).Decode()
+		if err != nil {
+			return false, err
+		}
+		for _, n := range numbers {
+			if n > to {
+				return false, nil
+			}
+			if n < from {
+				continue
+			}
+			blocks = append(blocks, n)
+			if limit > 0 && len(blocks) >= limit {
+				return false, nil
+			}
+		}
+		return true, nil
+	}); err != nil {
+		return nil, err
+	}
+	return blocks, nil
+}
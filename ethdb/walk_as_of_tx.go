@@ -0,0 +1,302 @@
+package ethdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/common/changeset"
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+)
+
+// WalkAsOfTx is WalkAsOf through the transaction of the abstract KV, so that the walks over the historical state
+// are available on the remote databases. Like BoltDatabase.WalkAsOf, only the state buckets are supported
+func WalkAsOfTx(tx Tx, bucket, hBucket, startkey []byte, fixedbits int, timestamp uint64, walker func(k, v []byte) (bool, error)) error {
+	if bytes.Equal(bucket, dbutils.CurrentStateBucket) && bytes.Equal(hBucket, dbutils.AccountsHistoryBucket) {
+		return walkAsOfAccountsTx(tx, startkey, fixedbits, timestamp, walker)
+	} else if bytes.Equal(bucket, dbutils.CurrentStateBucket) && bytes.Equal(hBucket, dbutils.StorageHistoryBucket) {
+		return walkAsOfStorageTx(tx, startkey, fixedbits, timestamp, func(k1, k2, v []byte) (bool, error) {
+			return walker(append(common.CopyBytes(k1), k2...), v)
+		})
+	}
+	return fmt.Errorf("walk as of is not supported for the buckets %s and %s", bucket, hBucket)
+}
+
+// kvSplitCursor is splitCursor over the cursor of the abstract KV
+type kvSplitCursor struct {
+	c          Cursor
+	startkey   []byte
+	matchBytes int
+	mask       uint8
+	part1end   int
+	part2start int
+	part3start int
+}
+
+func newKVSplitCursor(c Cursor, startkey []byte, matchBits int, part1end, part2start, part3start int) *kvSplitCursor {
+	sc := &kvSplitCursor{c: c, startkey: startkey, part1end: part1end, part2start: part2start, part3start: part3start}
+	sc.matchBytes, sc.mask = Bytesmask(matchBits)
+	return sc
+}
+
+// split skips the shorter keys, i.e. the accounts in CurrentStateBucket walked for the storage items
+func (sc *kvSplitCursor) split(k, v []byte, err error) (key1, key2, key3, val []byte, _ error) {
+	for err == nil && k != nil && len(k) < sc.part3start {
+		k, v, err = sc.c.Next()
+	}
+	if err != nil || !matchFixedBits(k, sc.startkey, sc.matchBytes, sc.mask) {
+		return nil, nil, nil, nil, err
+	}
+	return k[:sc.part1end], k[sc.part2start:sc.part3start], k[sc.part3start:], v, nil
+}
+
+func (sc *kvSplitCursor) Seek() (key1, key2, key3, val []byte, err error) {
+	return sc.split(sc.c.Seek(sc.startkey))
+}
+
+func (sc *kvSplitCursor) Next() (key1, key2, key3, val []byte, err error) {
+	return sc.split(sc.c.Next())
+}
+
+// changeSetTx reads the changeset of the block referred to by the history index
+func changeSetTx(tx Tx, csBucket []byte, blockNr uint64, timestamp uint64) ([]byte, error) {
+	r := kvHistoryReader{tx: tx}
+	changeSetData, err := r.historyGet(csBucket, dbutils.EncodeTimestamp(blockNr))
+	if err != nil {
+		return nil, err
+	}
+	if changeSetData == nil {
+		if changeSetData, err = changeSetFromEpoch(r.historyGet, csBucket, blockNr); err != nil {
+			return nil, err
+		}
+	}
+	if changeSetData == nil {
+		return nil, fmt.Errorf("could not find ChangeSet record for index entry %d (query timestamp %d)", blockNr, timestamp)
+	}
+	return changeSetData, nil
+}
+
+// skipStorageTx moves the cursor over CurrentStateBucket from the storage items to the next account by the seek,
+// so that the remote cursor does not bring them over the wire
+func skipStorageTx(c Cursor, k, v []byte, err error) ([]byte, []byte, error) {
+	for err == nil && k != nil && len(k) > common.HashLength {
+		next := common.CopyBytes(k[:common.HashLength])
+		i := len(next) - 1
+		for ; i >= 0 && next[i] == 0xff; i-- {
+			next[i] = 0
+		}
+		if i < 0 {
+			return nil, nil, nil
+		}
+		next[i]++
+		k, v, err = c.Seek(next)
+	}
+	return k, v, err
+}
+
+func walkAsOfAccountsTx(tx Tx, startkey []byte, fixedbits int, timestamp uint64, walker func(k []byte, v []byte) (bool, error)) error {
+	fixedbytes, mask := Bytesmask(fixedbits)
+	stateBucket, err := stateShardTx(tx, dbutils.CurrentStateAccountsBucket)
+	if err != nil {
+		return err
+	}
+	//for state
+	mainCursor := tx.Bucket(stateBucket).Cursor()
+	//for historic data
+	historyCursor := newKVSplitCursor(
+		tx.Bucket(dbutils.AccountsHistoryBucket).Cursor(),
+		startkey,
+		fixedbits,
+		common.HashLength,   /* part1end */
+		common.HashLength,   /* part2start */
+		common.HashLength+8, /* part3start */
+	)
+	k, v, err := mainCursor.Seek(startkey)
+	if k, v, err = skipStorageTx(mainCursor, k, v, err); err != nil {
+		return err
+	}
+	hK, tsEnc, _, hV, err := historyCursor.Seek()
+	for err == nil && hK != nil && binary.BigEndian.Uint64(tsEnc) < timestamp {
+		hK, tsEnc, _, hV, err = historyCursor.Next()
+	}
+	if err != nil {
+		return err
+	}
+	goOn := true
+	for goOn {
+		//exit or next conditions
+		if k != nil && fixedbits > 0 && !bytes.Equal(k[:fixedbytes-1], startkey[:fixedbytes-1]) {
+			k = nil
+		}
+		if k != nil && fixedbits > 0 && (k[fixedbytes-1]&mask) != (startkey[fixedbytes-1]&mask) {
+			k = nil
+		}
+		var cmp int
+		if k == nil {
+			if hK == nil {
+				break
+			} else {
+				cmp = 1
+			}
+		} else if hK == nil {
+			cmp = -1
+		} else {
+			cmp = bytes.Compare(k, hK)
+		}
+		if cmp < 0 {
+			goOn, err = walker(k, v)
+		} else {
+			index := dbutils.WrapHistoryIndex(hV)
+			if changeSetBlock, set, ok := index.Search(timestamp); ok {
+				// set == true if this change was from empty record (non-existent account) to non-empty
+				// In such case, we do not need to examine changeSet and simply skip the record
+				if !set {
+					changeSetData, err1 := changeSetTx(tx, dbutils.AccountChangeSetBucket, changeSetBlock, timestamp)
+					if err1 != nil {
+						return err1
+					}
+					data, err1 := changeset.AccountChangeSetBytes(changeSetData).FindLast(hK)
+					if err1 != nil {
+						return fmt.Errorf("could not find key %x in the ChangeSet record for index entry %d (query timestamp %d)",
+							hK,
+							changeSetBlock,
+							timestamp,
+						)
+					}
+					if len(data) > 0 { // Skip accounts did not exist
+						goOn, err = walker(hK, data)
+					}
+				}
+			} else if cmp == 0 {
+				goOn, err = walker(k, v)
+			}
+		}
+		if err != nil {
+			return err
+		}
+		if goOn {
+			if cmp <= 0 {
+				k, v, err = mainCursor.Next()
+				if k, v, err = skipStorageTx(mainCursor, k, v, err); err != nil {
+					return err
+				}
+			}
+			if cmp >= 0 {
+				hK0 := common.CopyBytes(hK)
+				for hK != nil && (bytes.Equal(hK0, hK) || binary.BigEndian.Uint64(tsEnc) < timestamp) {
+					if hK, tsEnc, _, hV, err = historyCursor.Next(); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func walkAsOfStorageTx(tx Tx, startkey []byte, fixedbits int, timestamp uint64, walker func(k1, k2, v []byte) (bool, error)) error {
+	stateBucket, err := stateShardTx(tx, dbutils.CurrentStateStorageBucket)
+	if err != nil {
+		return err
+	}
+	startkeyNoInc := make([]byte, len(startkey)-common.IncarnationLength)
+	copy(startkeyNoInc, startkey[:common.HashLength])
+	copy(startkeyNoInc[common.HashLength:], startkey[common.HashLength+common.IncarnationLength:])
+	historyBits := fixedbits - 8*common.IncarnationLength
+	if historyBits < 0 {
+		historyBits = 0
+	}
+	//for storage
+	mainCursor := newKVSplitCursor(
+		tx.Bucket(stateBucket).Cursor(),
+		startkey,
+		fixedbits,
+		common.HashLength, /* part1end */
+		common.HashLength+common.IncarnationLength,                   /* part2start */
+		common.HashLength+common.IncarnationLength+common.HashLength, /* part3start */
+	)
+	//for historic data
+	historyCursor := newKVSplitCursor(
+		tx.Bucket(dbutils.StorageHistoryBucket).Cursor(),
+		startkeyNoInc,
+		historyBits,
+		common.HashLength,   /* part1end */
+		common.HashLength,   /* part2start */
+		common.HashLength*2, /* part3start */
+	)
+	addrHash, keyHash, _, v, err := mainCursor.Seek()
+	if err != nil {
+		return err
+	}
+	hAddrHash, hKeyHash, tsEnc, hV, err := historyCursor.Seek()
+	for err == nil && hKeyHash != nil && binary.BigEndian.Uint64(tsEnc) < timestamp {
+		hAddrHash, hKeyHash, tsEnc, hV, err = historyCursor.Next()
+	}
+	if err != nil {
+		return err
+	}
+	goOn := true
+	for goOn {
+		var cmp int
+		if keyHash == nil {
+			if hKeyHash == nil {
+				break
+			} else {
+				cmp = 1
+			}
+		} else if hKeyHash == nil {
+			cmp = -1
+		} else {
+			cmp = bytes.Compare(keyHash, hKeyHash)
+		}
+		if cmp < 0 {
+			goOn, err = walker(addrHash, keyHash, v)
+		} else {
+			index := dbutils.WrapHistoryIndex(hV)
+			if changeSetBlock, set, ok := index.Search(timestamp); ok {
+				// set == true if this change was from empty record (non-existent storage item) to non-empty
+				// In such case, we do not need to examine changeSet and simply skip the record
+				if !set {
+					changeSetData, err1 := changeSetTx(tx, dbutils.StorageChangeSetBucket, changeSetBlock, timestamp)
+					if err1 != nil {
+						return err1
+					}
+					data, err1 := changeset.StorageChangeSetBytes(changeSetData).FindWithoutIncarnation(hAddrHash, hKeyHash)
+					if err1 != nil {
+						return fmt.Errorf("could not find key %x%x in the ChangeSet record for index entry %d (query timestamp %d): %v",
+							hAddrHash, hKeyHash,
+							changeSetBlock,
+							timestamp,
+							err1,
+						)
+					}
+					if len(data) > 0 { // Skip deleted entries
+						goOn, err = walker(hAddrHash, hKeyHash, data)
+					}
+				}
+			} else if cmp == 0 {
+				goOn, err = walker(addrHash, keyHash, v)
+			}
+		}
+		if err != nil {
+			return err
+		}
+		if goOn {
+			if cmp <= 0 {
+				if addrHash, keyHash, _, v, err = mainCursor.Next(); err != nil {
+					return err
+				}
+			}
+			if cmp >= 0 {
+				hKeyHash0 := common.CopyBytes(hKeyHash)
+				for hKeyHash != nil && (bytes.Equal(hKeyHash0, hKeyHash) || binary.BigEndian.Uint64(tsEnc) < timestamp) {
+					if hAddrHash, hKeyHash, tsEnc, hV, err = historyCursor.Next(); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
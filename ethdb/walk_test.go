@@ -0,0 +1,56 @@
+package ethdb
+
+import (
+	"testing"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetAccountHistory(t *testing.T) {
+	db := NewMemDatabase()
+	defer db.Close()
+	address := common.HexToAddress("0x1234567890")
+	addrHash, err := common.HashData(address[:])
+	require.NoError(t, err)
+
+	// Two full chunks and the current one
+	var expected []uint64
+	index := dbutils.NewHistoryIndex()
+	for n := uint64(10); n < 10+2*dbutils.MaxChunkSize+5; n++ {
+		if dbutils.CheckNewIndexChunk(index, n) {
+			key, err1 := index.Key(addrHash[:])
+			require.NoError(t, err1)
+			require.NoError(t, db.Put(dbutils.AccountsHistoryBucket, key, index))
+			index = dbutils.NewHistoryIndex()
+		}
+		index = index.Append(n, false)
+		expected = append(expected, n)
+	}
+	require.NoError(t, db.Put(dbutils.AccountsHistoryBucket, dbutils.CurrentChunkKey(addrHash[:]), index))
+	// Another account, which must not be returned
+	otherHash, err := common.HashData(common.HexToAddress("0x01").Bytes())
+	require.NoError(t, err)
+	require.NoError(t, db.Put(dbutils.AccountsHistoryBucket, dbutils.CurrentChunkKey(otherHash[:]), dbutils.NewHistoryIndex().Append(15, false)))
+
+	blocks, err := GetAccountHistory(db, address, 0, 1<<32, 0)
+	require.NoError(t, err)
+	assert.Equal(t, expected, blocks)
+
+	blocks, err = GetAccountHistory(db, address, 1500, 1700, 0)
+	require.NoError(t, err)
+	assert.Equal(t, expected[1490:1691], blocks)
+
+	blocks, err = GetAccountHistory(db, address, 1009, 1020, 5)
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{1009, 1010, 1011, 1012, 1013}, blocks)
+
+	blocks, err = GetAccountHistory(db, address, 3000, 4000, 0)
+	require.NoError(t, err)
+	assert.Empty(t, blocks)
+
+	_, err = GetAccountHistory(db, address, 2, 1, 0)
+	assert.Error(t, err)
+}
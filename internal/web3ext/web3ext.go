@@ -181,6 +181,28 @@ web3._extend({
 			call: 'admin_importChain',
 			params: 1
 		}),
+		new web3._extend.Method({
+			name: 'pinStorage',
+			call: 'admin_pinStorage',
+			params: 1,
+			inputFormatter: [web3._extend.formatters.inputAddressFormatter]
+		}),
+		new web3._extend.Method({
+			name: 'unpinStorage',
+			call: 'admin_unpinStorage',
+			params: 1,
+			inputFormatter: [web3._extend.formatters.inputAddressFormatter]
+		}),
+		new web3._extend.Method({
+			name: 'syncFlatState',
+			call: 'admin_syncFlatState',
+			params: 2
+		}),
+		new web3._extend.Method({
+			name: 'syncChangeSets',
+			call: 'admin_syncChangeSets',
+			params: 2
+		}),
 		new web3._extend.Method({
 			name: 'sleepBlocks',
 			call: 'admin_sleepBlocks',
@@ -220,6 +242,10 @@ web3._extend({
 			name: 'datadir',
 			getter: 'admin_datadir'
 		}),
+		new web3._extend.Property({
+			name: 'pinnedStorage',
+			getter: 'admin_pinnedStorage'
+		}),
 	]
 });
 `
@@ -439,6 +465,12 @@ web3._extend({
 			call: 'debug_getBadBlocks',
 			params: 0,
 		}),
+		new web3._extend.Method({
+			name: 'getWitness',
+			call: 'debug_getWitness',
+			params: 2,
+			inputFormatter: [web3._extend.formatters.inputBlockNumberFormatter, null]
+		}),
 		new web3._extend.Method({
 			name: 'storageRangeAt',
 			call: 'debug_storageRangeAt',
@@ -456,6 +488,41 @@ web3._extend({
 			params: 2,
 			inputFormatter:[null, null],
 		}),
+		new web3._extend.Method({
+			name: 'intermediateHashesRange',
+			call: 'debug_intermediateHashesRange',
+			params: 3,
+			inputFormatter: [null, null, null],
+		}),
+		new web3._extend.Method({
+			name: 'changeSetSummaries',
+			call: 'debug_changeSetSummaries',
+			params: 2,
+		}),
+		new web3._extend.Method({
+			name: 'getAccountHistory',
+			call: 'debug_getAccountHistory',
+			params: 4,
+			inputFormatter: [web3._extend.formatters.inputAddressFormatter, null, null, null],
+		}),
+		new web3._extend.Method({
+			name: 'accountAt',
+			call: 'debug_accountAt',
+			params: 2,
+			inputFormatter: [web3._extend.formatters.inputAddressFormatter, null],
+		}),
+		new web3._extend.Method({
+			name: 'incarnationAt',
+			call: 'debug_incarnationAt',
+			params: 2,
+			inputFormatter: [web3._extend.formatters.inputAddressFormatter, null],
+		}),
+		new web3._extend.Method({
+			name: 'getStorageHistory',
+			call: 'debug_getStorageHistory',
+			params: 5,
+			inputFormatter: [web3._extend.formatters.inputAddressFormatter, null, null, null, null],
+		}),
 		new web3._extend.Method({
 			name: 'freezeClient',
 			call: 'debug_freezeClient',
@@ -538,6 +605,17 @@ web3._extend({
 			params: 2,
 			inputFormatter: [web3._extend.formatters.inputBlockNumberFormatter, web3._extend.utils.toHex]
 		}),
+		new web3._extend.Method({
+			name: 'chainSizeStats',
+			call: 'eth_chainSizeStats',
+			params: 0
+		}),
+		new web3._extend.Method({
+			name: 'getWitness',
+			call: 'eth_getWitness',
+			params: 2,
+			inputFormatter: [web3._extend.formatters.inputBlockNumberFormatter, null]
+		}),
 		new web3._extend.Method({
 			name: 'getProof',
 			call: 'eth_getProof',
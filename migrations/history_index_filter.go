@@ -0,0 +1,116 @@
+package migrations
+
+import (
+	"errors"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/common/changeset"
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+	"github.com/ledgerwatch/turbo-geth/core"
+	"github.com/ledgerwatch/turbo-geth/core/rawdb"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+	"github.com/ledgerwatch/turbo-geth/log"
+)
+
+// ErrHistoryIndexFilterConflict is returned when both the allowlist and the denylist of the history indexes are given
+var ErrHistoryIndexFilterConflict = errors.New("the history index allowlist and denylist are mutually exclusive")
+
+// ErrHistoryIndexFilterWidened is returned when the filter of the history indexes is changed to index the accounts,
+// which were excluded before, and the indexes are not rebuilt: the history of these accounts would miss the past blocks
+var ErrHistoryIndexFilterWidened = errors.New("the history index filter includes the accounts, which were not indexed, the indexes have to be rebuilt")
+
+// ApplyHistoryIndexFilter records the filter of the history indexes (see ethdb.HistoryIndexFilter) built from
+// the allowlist or the denylist of the addresses, no lists mean indexing all the accounts.
+// The indexes written before are kept as they are, unless the filter includes the accounts, which were not indexed:
+// then the indexes are rebuilt from the changesets if rebuild is set, otherwise ErrHistoryIndexFilterWidened is returned
+func ApplyHistoryIndexFilter(db ethdb.Database, allow, deny []common.Address, rebuild bool) error {
+	if len(allow) > 0 && len(deny) > 0 {
+		return ErrHistoryIndexFilterConflict
+	}
+	var filter ethdb.HistoryIndexFilter
+	var err error
+	switch {
+	case len(allow) > 0:
+		filter, err = ethdb.NewHistoryIndexFilter(allow, false)
+	case len(deny) > 0:
+		filter, err = ethdb.NewHistoryIndexFilter(deny, true)
+	}
+	if err != nil {
+		return err
+	}
+	previous, err := ethdb.ReadHistoryIndexFilter(db)
+	if err != nil {
+		return err
+	}
+	widened := !previous.Covers(filter)
+	if widened {
+		if widened, err = historyIndexed(db); err != nil {
+			return err
+		}
+	}
+	if widened && !rebuild {
+		return ErrHistoryIndexFilterWidened
+	}
+	changed, err := ethdb.WriteHistoryIndexFilter(db, filter)
+	if err != nil {
+		return err
+	}
+	if widened {
+		return rebuildHistoryIndexes(db)
+	}
+	if changed {
+		log.Warn("The filter of the history indexes has changed, the history of the excluded accounts is not available",
+			"allow", len(allow), "deny", len(deny))
+	}
+	return nil
+}
+
+// historyIndexed tells whether any history is indexed
+func historyIndexed(db ethdb.Database) (bool, error) {
+	var indexed bool
+	for _, bucket := range [][]byte{dbutils.AccountsHistoryBucket, dbutils.StorageHistoryBucket} {
+		if err := db.Walk(bucket, nil, 0, func(k, v []byte) (bool, error) {
+			indexed = true
+			return false, nil
+		}); err != nil {
+			return false, err
+		}
+	}
+	return indexed, nil
+}
+
+// rebuildHistoryIndexes regenerates the history indexes from the changesets with the recorded filter
+func rebuildHistoryIndexes(db ethdb.Database) error {
+	schema, err := rawdb.ReadStateSchemaVersion(db)
+	if err != nil {
+		return err
+	}
+	plain := schema != rawdb.HashedStateSchema
+	log.Warn("The filter of the history indexes includes the accounts, which were not indexed, rebuilding the indexes")
+	for _, b := range []struct {
+		changeSets, index []byte
+		walker            func([]byte) core.ChangesetWalker
+	}{
+		{dbutils.AccountChangeSetBucket, dbutils.AccountsHistoryBucket, func(cs []byte) core.ChangesetWalker {
+			if plain {
+				return changeset.AccountChangeSetPlainBytes(cs)
+			}
+			return changeset.AccountChangeSetBytes(cs)
+		}},
+		{dbutils.StorageChangeSetBucket, dbutils.StorageHistoryBucket, func(cs []byte) core.ChangesetWalker {
+			if plain {
+				return changeset.StorageChangeSetPlainBytes(cs)
+			}
+			return changeset.StorageChangeSetBytes(cs)
+		}},
+	} {
+		ig := core.NewIndexGenerator(db)
+		if err := ig.DropIndex(b.index); err != nil {
+			return err
+		}
+		if err := ig.GenerateIndex(0, b.changeSets, b.index, b.walker, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package migrations
+
+import (
+	"testing"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/common/changeset"
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+	"github.com/ledgerwatch/turbo-geth/core"
+	"github.com/ledgerwatch/turbo-geth/crypto"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWidenHistoryIndexFilter(t *testing.T) {
+	db := ethdb.NewMemDatabase()
+	defer db.Close()
+	a1, a2 := common.HexToAddress("0x1"), common.HexToAddress("0x2")
+	h1, h2 := crypto.Keccak256(a1[:]), crypto.Keccak256(a2[:])
+	for blockNr := uint64(1); blockNr <= 3; blockNr++ {
+		cs := changeset.NewAccountChangeSet()
+		require.NoError(t, cs.Add(h1, []byte{byte(blockNr)}))
+		require.NoError(t, cs.Add(h2, []byte{byte(blockNr)}))
+		enc, err := changeset.EncodeAccounts(cs)
+		require.NoError(t, err)
+		require.NoError(t, db.Put(dbutils.AccountChangeSetBucket, dbutils.EncodeTimestamp(blockNr), enc))
+	}
+	indexed := func() map[string]bool {
+		keys := make(map[string]bool)
+		require.NoError(t, db.Walk(dbutils.AccountsHistoryBucket, nil, 0, func(k, v []byte) (bool, error) {
+			keys[string(k[:common.HashLength])] = true
+			return true, nil
+		}))
+		return keys
+	}
+
+	// Nothing is indexed yet, so the filter is applied freely
+	require.NoError(t, ApplyHistoryIndexFilter(db, nil, []common.Address{a2}, false))
+	walker := func(cs []byte) core.ChangesetWalker { return changeset.AccountChangeSetBytes(cs) }
+	require.NoError(t, core.NewIndexGenerator(db).GenerateIndex(0, dbutils.AccountChangeSetBucket, dbutils.AccountsHistoryBucket, walker, nil))
+	assert.Equal(t, map[string]bool{string(h1): true}, indexed())
+
+	// Narrowing keeps the indexes
+	require.NoError(t, ApplyHistoryIndexFilter(db, nil, []common.Address{a2, common.HexToAddress("0x3")}, false))
+	assert.Equal(t, map[string]bool{string(h1): true}, indexed())
+
+	// Widening requires the indexes to be rebuilt
+	assert.Equal(t, ErrHistoryIndexFilterWidened, ApplyHistoryIndexFilter(db, nil, nil, false))
+	filter, err := ethdb.ReadHistoryIndexFilter(db)
+	require.NoError(t, err)
+	assert.False(t, filter.Indexed(h2))
+	require.NoError(t, ApplyHistoryIndexFilter(db, nil, nil, true))
+	assert.Equal(t, map[string]bool{string(h1): true, string(h2): true}, indexed())
+}
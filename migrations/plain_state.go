@@ -0,0 +1,163 @@
+package migrations
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+	"github.com/ledgerwatch/turbo-geth/core/rawdb"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+	"github.com/ledgerwatch/turbo-geth/log"
+)
+
+// ErrPlainStateSchema is returned when the database keeps the plain state, but the node is started without it
+var ErrPlainStateSchema = errors.New("the database keeps the state under the plain addresses, restart with --plainstate")
+
+// ErrUnhashedStateSchema is returned when the chain keeps no hashed state, but the node is started without the plain state
+var ErrUnhashedStateSchema = errors.New("the chain keeps the state under the plain addresses only, restart with --plainstate")
+
+// ApplyStateSchema brings the layout of the current state to the requested one.
+// The hashed state is converted into the plain state once, the conversion back is not supported,
+// because the hashed state is not kept up to date by the plain state execution. The databases of
+// rawdb.UnhashedStateSchema, chosen at genesis, require the plain state execution
+func ApplyStateSchema(db ethdb.Database, plain bool) error {
+	version, err := inferStateSchema(db)
+	if err != nil {
+		return err
+	}
+	switch {
+	case plain && version == rawdb.HashedStateSchema:
+		return ConvertToPlainState(db)
+	case !plain && version == rawdb.PlainStateSchema:
+		return ErrPlainStateSchema
+	case !plain && version == rawdb.UnhashedStateSchema:
+		return ErrUnhashedStateSchema
+	}
+	return nil
+}
+
+// inferStateSchema reads the layout of the current state. The databases, which were synced with --plainstate
+// before the layout was recorded, have no record, but keep the plain state, so rawdb.PlainStateSchema is recorded for them
+func inferStateSchema(db ethdb.Database) (uint8, error) {
+	version, err := rawdb.ReadStateSchemaVersion(db)
+	if err != nil || version != rawdb.HashedStateSchema {
+		return version, err
+	}
+	if _, err = db.Get(dbutils.DatabaseInfoBucket, dbutils.StateSchemaVersionKey); err == nil || !errors.Is(err, ethdb.ErrKeyNotFound) {
+		return version, err
+	}
+	var hasPlainState bool
+	if err = db.Walk(dbutils.PlainStateBucket, nil, 0, func(k, v []byte) (bool, error) {
+		hasPlainState = true
+		return false, nil
+	}); err != nil {
+		return 0, err
+	}
+	if !hasPlainState {
+		return version, nil
+	}
+	log.Info("The database keeps the plain state without the record of the layout, recording it")
+	if err = rawdb.WriteStateSchemaVersion(db, rawdb.PlainStateSchema); err != nil {
+		return 0, err
+	}
+	return rawdb.PlainStateSchema, nil
+}
+
+// ConvertToPlainState (experimental) copies the current state and the contract code hashes into the plain state buckets,
+// resolving the addresses and the storage keys through the preimages, and records rawdb.PlainStateSchema.
+// The hashed state is left in place, it is used to compute the state roots. The history written before
+// the conversion is only available in the hashed form, so the unwinds below the conversion point are not possible
+func ConvertToPlainState(db ethdb.Database) error {
+	log.Info("Converting the state to the plain addresses")
+	// The plain state left by the interrupted conversion is not taken for the state of --plainstate, see inferStateSchema
+	if err := rawdb.WriteStateSchemaVersion(db, rawdb.HashedStateSchema); err != nil {
+		return err
+	}
+	convertAccount := func(k []byte) ([]byte, error) {
+		switch len(k) {
+		case common.HashLength:
+			return resolvePreimage(db, k, common.AddressLength)
+		case common.HashLength + common.IncarnationLength + common.HashLength:
+			address, err := resolvePreimage(db, k[:common.HashLength], common.AddressLength)
+			if err != nil {
+				return nil, err
+			}
+			key, err := resolvePreimage(db, k[common.HashLength+common.IncarnationLength:], common.HashLength)
+			if err != nil {
+				return nil, err
+			}
+			plainKey := make([]byte, common.AddressLength+common.IncarnationLength+common.HashLength)
+			copy(plainKey, address)
+			copy(plainKey[common.AddressLength:], k[common.HashLength:common.HashLength+common.IncarnationLength])
+			copy(plainKey[common.AddressLength+common.IncarnationLength:], key)
+			return plainKey, nil
+		}
+		return nil, fmt.Errorf("unexpected key in the state: %x", k)
+	}
+	if err := convertBucket(db, dbutils.CurrentStateBucket, dbutils.PlainStateBucket, convertAccount); err != nil {
+		return err
+	}
+	convertCode := func(k []byte) ([]byte, error) {
+		if len(k) != common.HashLength+common.IncarnationLength {
+			return nil, fmt.Errorf("unexpected key in the contract codes: %x", k)
+		}
+		address, err := resolvePreimage(db, k[:common.HashLength], common.AddressLength)
+		if err != nil {
+			return nil, err
+		}
+		return append(address, k[common.HashLength:]...), nil
+	}
+	if err := convertBucket(db, dbutils.ContractCodeBucket, dbutils.PlainContractCodeBucket, convertCode); err != nil {
+		return err
+	}
+	if err := rawdb.WriteStateSchemaVersion(db, rawdb.PlainStateSchema); err != nil {
+		return err
+	}
+	log.Info("Converted the state to the plain addresses")
+	return nil
+}
+
+// resolvePreimage finds the preimage of the hash, which has to be of the given length
+func resolvePreimage(db ethdb.Getter, hash []byte, length int) ([]byte, error) {
+	preimage, err := db.Get(dbutils.PreimagePrefix, hash)
+	if err != nil && !errors.Is(err, ethdb.ErrKeyNotFound) {
+		return nil, err
+	}
+	if len(preimage) != length {
+		return nil, fmt.Errorf("no preimage of %x, the conversion requires the database with the preimages", hash)
+	}
+	return common.CopyBytes(preimage), nil
+}
+
+// convertBucket copies the entries of the bucket into the other one under the converted keys. The walk is restarted
+// after every commit of the batch, so that the read and the write transactions are not open at the same time
+func convertBucket(db ethdb.Database, from, to []byte, convertKey func([]byte) ([]byte, error)) error {
+	var startKey []byte
+	for {
+		batch := ethdb.NewBatchWithPolicy(db, ethdb.CommitPolicy{AtCheckpoints: true})
+		var nextKey []byte
+		if err := db.Walk(from, startKey, 0, func(k, v []byte) (bool, error) {
+			if ethdb.CommitDue(batch) {
+				nextKey = common.CopyBytes(k)
+				return false, nil
+			}
+			plainKey, err := convertKey(k)
+			if err != nil {
+				return false, err
+			}
+			return true, batch.Put(to, plainKey, common.CopyBytes(v))
+		}); err != nil {
+			batch.Rollback()
+			return err
+		}
+		if _, err := batch.Commit(); err != nil {
+			return err
+		}
+		if nextKey == nil {
+			return nil
+		}
+		log.Info("Converting the state to the plain addresses", "bucket", string(from), "key", fmt.Sprintf("%x", nextKey))
+		startKey = nextKey
+	}
+}
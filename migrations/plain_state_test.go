@@ -0,0 +1,93 @@
+package migrations
+
+import (
+	"testing"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+	"github.com/ledgerwatch/turbo-geth/core/rawdb"
+	"github.com/ledgerwatch/turbo-geth/core/state"
+	"github.com/ledgerwatch/turbo-geth/core/types/accounts"
+	"github.com/ledgerwatch/turbo-geth/crypto"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConvertToPlainState(t *testing.T) {
+	db := ethdb.NewMemDatabase()
+	address := common.HexToAddress("0x0000000000000000000000000000000000001000")
+	key := common.HexToHash("0x05")
+	addrHash := crypto.Keccak256Hash(address[:])
+	keyHash := crypto.Keccak256Hash(key[:])
+	codeHash := crypto.Keccak256Hash([]byte{0x60})
+	rawdb.WritePreimages(db, map[common.Hash][]byte{addrHash: address[:], keyHash: key[:]})
+
+	acc := accounts.NewAccount()
+	acc.Balance.SetUint64(10)
+	acc.Incarnation = 2
+	acc.CodeHash = codeHash
+	require.NoError(t, rawdb.WriteAccount(db, addrHash, acc))
+	require.NoError(t, db.Put(dbutils.CurrentStateBucket, dbutils.GenerateCompositeStorageKey(addrHash, 2, keyHash), []byte{0x2a}))
+	require.NoError(t, db.Put(dbutils.ContractCodeBucket, dbutils.GenerateStoragePrefix(addrHash[:], 2), codeHash[:]))
+
+	// The hashed state is read by default
+	reader, err := state.NewCurrentStateReader(db)
+	require.NoError(t, err)
+	assert.IsType(t, &state.DbStateReader{}, reader)
+	require.NoError(t, ApplyStateSchema(db, false))
+
+	require.NoError(t, ApplyStateSchema(db, true))
+	version, err := rawdb.ReadStateSchemaVersion(db)
+	require.NoError(t, err)
+	assert.Equal(t, rawdb.PlainStateSchema, version)
+	v, err := db.Get(dbutils.PlainContractCodeBucket, dbutils.PlainGenerateStoragePrefix(address, 2))
+	require.NoError(t, err)
+	assert.Equal(t, codeHash[:], v)
+
+	reader, err = state.NewCurrentStateReader(db)
+	require.NoError(t, err)
+	assert.IsType(t, &state.PlainStateReader{}, reader)
+	plainAcc, err := reader.ReadAccountData(address)
+	require.NoError(t, err)
+	require.NotNil(t, plainAcc)
+	assert.Equal(t, uint64(10), plainAcc.Balance.Uint64())
+	v, err = reader.ReadAccountStorage(address, 2, &key)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x2a}, v)
+
+	// The plain state is not maintained by the hashed state execution
+	assert.Equal(t, ErrPlainStateSchema, ApplyStateSchema(db, false))
+	require.NoError(t, ApplyStateSchema(db, true))
+}
+
+func TestConvertToPlainStateWithoutPreimages(t *testing.T) {
+	db := ethdb.NewMemDatabase()
+	addrHash := crypto.Keccak256Hash(common.HexToAddress("0x0000000000000000000000000000000000001000").Bytes())
+	require.NoError(t, rawdb.WriteAccount(db, addrHash, accounts.NewAccount()))
+	assert.Error(t, ApplyStateSchema(db, true))
+	version, err := rawdb.ReadStateSchemaVersion(db)
+	require.NoError(t, err)
+	assert.Equal(t, rawdb.HashedStateSchema, version)
+}
+
+func TestInferPlainStateSchema(t *testing.T) {
+	db := ethdb.NewMemDatabase()
+	address := common.HexToAddress("0x0000000000000000000000000000000000001000")
+	acc := accounts.NewAccount()
+	acc.Balance.SetUint64(10)
+	require.NoError(t, rawdb.PlainWriteAccount(db, address, acc))
+
+	// The database synced with --plainstate before the layout was recorded
+	assert.Equal(t, ErrPlainStateSchema, ApplyStateSchema(db, false))
+	version, err := rawdb.ReadStateSchemaVersion(db)
+	require.NoError(t, err)
+	assert.Equal(t, rawdb.PlainStateSchema, version)
+	require.NoError(t, ApplyStateSchema(db, true))
+
+	// The recorded hashed layout is not inferred again
+	db = ethdb.NewMemDatabase()
+	require.NoError(t, rawdb.WriteStateSchemaVersion(db, rawdb.HashedStateSchema))
+	require.NoError(t, rawdb.PlainWriteAccount(db, address, acc))
+	require.NoError(t, ApplyStateSchema(db, false))
+}
@@ -0,0 +1,34 @@
+package migrations
+
+import (
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/core/rawdb"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+	"github.com/ledgerwatch/turbo-geth/log"
+)
+
+var receiptsDictionary = Migration{
+	Name: "receipts_dictionary",
+	Up: func(db ethdb.Database, history, receipts, txIndex, preImages bool) error {
+		if !receipts {
+			return nil
+		}
+		return CompactReceipts(db)
+	},
+}
+
+// CompactReceipts re-encodes the receipts of the complete epochs up to the head block with the dictionaries
+// of the log addresses and topics (see rawdb.CompactReceipts) and reports the sizes
+func CompactReceipts(db ethdb.Database) error {
+	number := rawdb.ReadHeaderNumber(db, rawdb.ReadHeadBlockHash(db))
+	if number == nil {
+		return nil
+	}
+	stats, err := rawdb.CompactReceipts(db, *number)
+	if err != nil {
+		return err
+	}
+	log.Info("Compacted receipts", "epochs", stats.Epochs, "blocks", stats.Blocks,
+		"before", common.StorageSize(stats.Before), "after", common.StorageSize(stats.After), "dictionaries", common.StorageSize(stats.Dictionaries))
+	return nil
+}
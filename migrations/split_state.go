@@ -0,0 +1,107 @@
+package migrations
+
+import (
+	"errors"
+
+	"github.com/ledgerwatch/bolt"
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+	"github.com/ledgerwatch/turbo-geth/log"
+)
+
+// splitStateBatch is the number of the entries moved into the shards in one transaction
+const splitStateBatch = 100000
+
+// ErrSplitStateNotSupported is returned when the split state schema is requested for a database other than Bolt
+var ErrSplitStateNotSupported = errors.New("the split state schema is only supported by Bolt")
+
+// ApplySplitState moves the current state into the split schema (see ethdb.IsSplitState), if it is requested
+// and the state is not split yet. The split state is not moved back, the database serves it either way
+func ApplySplitState(db ethdb.Database, split bool) error {
+	hasKV, ok := db.(ethdb.HasKV)
+	if !ok {
+		if split {
+			return ErrSplitStateNotSupported
+		}
+		return nil
+	}
+	var isSplit bool
+	if err := hasKV.KV().View(func(tx *bolt.Tx) error {
+		isSplit = ethdb.IsSplitState(tx)
+		return nil
+	}); err != nil {
+		return err
+	}
+	if isSplit && !split {
+		log.Info("The current state is kept in the split schema")
+	}
+	if !split || isSplit {
+		return nil
+	}
+	return ConvertToSplitState(hasKV.KV())
+}
+
+// ConvertToSplitState moves the entries of CurrentStateBucket into the accounts and the storage shards.
+// The entries are copied in batches while the state is read from CurrentStateBucket, then the schema is switched
+// and CurrentStateBucket is emptied in one transaction, so the conversion, which is interrupted, starts over
+func ConvertToSplitState(kv *bolt.DB) error {
+	log.Info("Splitting the current state into the accounts and the storage")
+	if err := kv.Update(func(tx *bolt.Tx) error {
+		for _, shard := range [][]byte{dbutils.CurrentStateAccountsBucket, dbutils.CurrentStateStorageBucket} {
+			if err := recreateBucket(tx, shard); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	var next []byte
+	var copied int
+	for done := false; !done; {
+		if err := kv.Update(func(tx *bolt.Tx) error {
+			c := tx.Bucket(dbutils.CurrentStateBucket).Cursor()
+			var k, v []byte
+			if next == nil {
+				k, v = c.First()
+			} else {
+				k, v = c.Seek(next)
+			}
+			for n := 0; k != nil; k, v = c.Next() {
+				if n == splitStateBatch {
+					next = common.CopyBytes(k)
+					return nil
+				}
+				if err := tx.Bucket(ethdb.StateShard(k)).Put(common.CopyBytes(k), common.CopyBytes(v)); err != nil {
+					return err
+				}
+				n++
+				copied++
+			}
+			done = true
+			return nil
+		}); err != nil {
+			return err
+		}
+		log.Info("Splitting the current state", "entries", copied)
+	}
+	if err := kv.Update(func(tx *bolt.Tx) error {
+		if err := ethdb.MarkSplitState(tx); err != nil {
+			return err
+		}
+		return recreateBucket(tx, dbutils.CurrentStateBucket)
+	}); err != nil {
+		return err
+	}
+	log.Info("Split the current state", "entries", copied)
+	return nil
+}
+
+func recreateBucket(tx *bolt.Tx, bucket []byte) error {
+	if err := tx.DeleteBucket(bucket); err != nil && !errors.Is(err, bolt.ErrBucketNotFound) {
+		return err
+	}
+	_, err := tx.CreateBucket(bucket, false)
+	return err
+}
@@ -0,0 +1,150 @@
+package migrations
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/ledgerwatch/bolt"
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+	"github.com/ledgerwatch/turbo-geth/core/rawdb"
+	"github.com/ledgerwatch/turbo-geth/core/state"
+	"github.com/ledgerwatch/turbo-geth/core/types/accounts"
+	"github.com/ledgerwatch/turbo-geth/crypto"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+	"github.com/ledgerwatch/turbo-geth/trie"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeTestState writes the accounts, every one of them with the storage items, into CurrentStateBucket
+func writeTestState(tb testing.TB, db ethdb.Database, accountsNum, storageNum int) {
+	batch := db.NewBatch()
+	for i := 0; i < accountsNum; i++ {
+		addrHash := crypto.Keccak256Hash([]byte(fmt.Sprintf("account %d", i)))
+		acc := accounts.NewAccount()
+		acc.Balance.SetUint64(uint64(i + 1))
+		acc.Incarnation = state.FirstContractIncarnation
+		require.NoError(tb, rawdb.WriteAccount(batch, addrHash, acc))
+		for j := 0; j < storageNum; j++ {
+			keyHash := crypto.Keccak256Hash([]byte(fmt.Sprintf("key %d", j)))
+			require.NoError(tb, batch.Put(dbutils.CurrentStateBucket, dbutils.GenerateCompositeStorageKey(addrHash, acc.Incarnation, keyHash), []byte{byte(j + 1)}))
+		}
+	}
+	_, err := batch.Commit()
+	require.NoError(tb, err)
+}
+
+func stateRoot(tb testing.TB, db ethdb.Database) common.Hash {
+	loader := trie.NewFlatDbSubTrieLoader()
+	require.NoError(tb, loader.Reset(db, trie.NewRetainList(0), [][]byte{nil}, []int{0}, false))
+	subTries, err := loader.LoadSubTries()
+	require.NoError(tb, err)
+	return subTries.Hashes[0]
+}
+
+func countKeys(tx *bolt.Tx, bucket []byte) int {
+	var n int
+	c := tx.Bucket(bucket).Cursor()
+	for k, _ := c.First(); k != nil; k, _ = c.Next() {
+		n++
+	}
+	return n
+}
+
+func TestConvertToSplitState(t *testing.T) {
+	db := ethdb.NewMemDatabase()
+	defer db.Close()
+	writeTestState(t, db, 100, 3)
+	root := stateRoot(t, db)
+	var entries int
+	require.NoError(t, db.Walk(dbutils.CurrentStateBucket, nil, 0, func(k, v []byte) (bool, error) {
+		entries++
+		return true, nil
+	}))
+
+	require.NoError(t, ApplySplitState(db, false))
+	require.NoError(t, ApplySplitState(db, true))
+	require.NoError(t, db.KV().View(func(tx *bolt.Tx) error {
+		assert.True(t, ethdb.IsSplitState(tx))
+		assert.Equal(t, 0, countKeys(tx, dbutils.CurrentStateBucket))
+		assert.Equal(t, 100, countKeys(tx, dbutils.CurrentStateAccountsBucket))
+		assert.Equal(t, 300, countKeys(tx, dbutils.CurrentStateStorageBucket))
+		return nil
+	}))
+
+	// The split state is served as CurrentStateBucket
+	assert.Equal(t, root, stateRoot(t, db))
+	var walked int
+	var prev []byte
+	require.NoError(t, db.Walk(dbutils.CurrentStateBucket, nil, 0, func(k, v []byte) (bool, error) {
+		assert.True(t, prev == nil || string(prev) < string(k), "keys in order")
+		prev = common.CopyBytes(k)
+		walked++
+		return true, nil
+	}))
+	assert.Equal(t, entries, walked)
+
+	addrHash := crypto.Keccak256Hash([]byte("account 1"))
+	var acc accounts.Account
+	ok, err := rawdb.ReadAccount(db, addrHash, &acc)
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, uint64(2), acc.Balance.Uint64())
+
+	// The writes go into the shards
+	keyHash := crypto.Keccak256Hash([]byte("key 100"))
+	storageKey := dbutils.GenerateCompositeStorageKey(addrHash, acc.Incarnation, keyHash)
+	require.NoError(t, db.Put(dbutils.CurrentStateBucket, storageKey, []byte{0x2a}))
+	v, err := db.Get(dbutils.CurrentStateStorageBucket, storageKey)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x2a}, v)
+	require.NoError(t, db.Delete(dbutils.CurrentStateBucket, storageKey))
+	_, err = db.Get(dbutils.CurrentStateBucket, storageKey)
+	assert.Equal(t, ethdb.ErrKeyNotFound, err)
+	assert.Equal(t, root, stateRoot(t, db))
+
+	// The raw transactions of the abstract KV (e.g. of the remote readers) are routed into the shards too
+	require.NoError(t, db.AbstractKV().Update(context.Background(), func(tx ethdb.Tx) error {
+		b := tx.Bucket(dbutils.CurrentStateBucket)
+		v, err := b.Get(addrHash[:])
+		require.NoError(t, err)
+		assert.NotNil(t, v)
+		require.NoError(t, b.Put(storageKey, []byte{0x2a}))
+		v, err = tx.Bucket(dbutils.CurrentStateStorageBucket).Get(storageKey)
+		require.NoError(t, err)
+		assert.Equal(t, []byte{0x2a}, v)
+		require.NoError(t, b.Delete(storageKey))
+		var n int
+		prev = nil
+		require.NoError(t, b.Cursor().Prefix(addrHash[:]).Walk(func(k, v []byte) (bool, error) {
+			assert.True(t, prev == nil || string(prev) < string(k), "keys in order")
+			prev = common.CopyBytes(k)
+			n++
+			return true, nil
+		}))
+		assert.Equal(t, 4, n)
+		return nil
+	}))
+
+	require.NoError(t, ApplySplitState(db, true))
+	require.NoError(t, ApplySplitState(db, false))
+	assert.Equal(t, root, stateRoot(t, db))
+}
+
+func BenchmarkStateRoot(b *testing.B) {
+	for _, split := range []bool{false, true} {
+		split := split
+		b.Run(fmt.Sprintf("split=%t", split), func(b *testing.B) {
+			db := ethdb.NewMemDatabase()
+			defer db.Close()
+			writeTestState(b, db, 10000, 10)
+			require.NoError(b, ApplySplitState(db, split))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				stateRoot(b, db)
+			}
+		})
+	}
+}
@@ -0,0 +1,205 @@
+package migrations
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/common/changeset"
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+	"github.com/ledgerwatch/turbo-geth/log"
+)
+
+// storageChangeSetV2Batch is the number of the entries looked at in one batch of the conversion
+const storageChangeSetV2Batch = 10000
+
+// storageChangeSetV2Buckets - the buckets of the storage changesets with their conversions, in the order of
+// the conversion. The index of the bucket is recorded in the progress of the conversion
+var storageChangeSetV2Buckets = []struct {
+	bucket  []byte
+	convert func([]byte) ([]byte, bool, error)
+}{
+	{dbutils.StorageChangeSetBucket, storageToV2(changeset.DecodeStorage, changeset.EncodeStorageV2)},
+	{dbutils.PlainStorageChangeSetBucket, storageToV2(changeset.DecodeStoragePlain, changeset.EncodeStoragePlainV2)},
+	{dbutils.StorageChangeSetEpochBucket, epochToV2(storageToV2(changeset.DecodeStorage, changeset.EncodeStorageV2))},
+}
+
+// ApplyStorageChangeSetV2 converts the storage changesets to the v2 layout (see changeset.IsStorageV2), if it is
+// requested and they are not converted yet, and sets changeset.StorageV2 by the layout of the database.
+// The conversion is one-way: the converted database keeps writing the v2 layout without the request, and can't be
+// read by the versions, which don't know it
+func ApplyStorageChangeSetV2(db ethdb.Database, convert bool) error {
+	converted, err := hasKey(db, dbutils.StorageChangeSetV2Key)
+	if err != nil {
+		return err
+	}
+	switch {
+	case !converted && convert:
+		if err = ConvertStorageChangeSetsToV2(db); err != nil {
+			return err
+		}
+		converted = true
+	case converted && !convert:
+		log.Info("The storage changesets are kept in the v2 layout")
+	case !converted:
+		if interrupted, err := hasKey(db, dbutils.StorageChangeSetV2ProgressKey); err != nil {
+			return err
+		} else if interrupted {
+			log.Warn("The conversion of the storage changesets to the v2 layout was interrupted, both layouts are read until it is resumed with --storage-changeset-v2")
+		}
+	}
+	changeset.StorageV2 = converted
+	return nil
+}
+
+func hasKey(db ethdb.Database, key []byte) (bool, error) {
+	if _, err := db.Get(dbutils.DatabaseInfoBucket, key); err != nil {
+		if errors.Is(err, ethdb.ErrKeyNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// ConvertStorageChangeSetsToV2 re-encodes the storage changesets of the v1 layout in the v2 layout, which groups
+// the changes by contract (see changeset.IsStorageV2), including the changesets merged into the epochs, and marks
+// the database converted (dbutils.StorageChangeSetV2Key). The position of the conversion is recorded with every
+// batch (dbutils.StorageChangeSetV2ProgressKey), so the conversion, which is interrupted, resumes where it stopped
+func ConvertStorageChangeSetsToV2(db ethdb.Database) error {
+	var from int
+	var start []byte
+	progress, err := db.Get(dbutils.DatabaseInfoBucket, dbutils.StorageChangeSetV2ProgressKey)
+	if err != nil && !errors.Is(err, ethdb.ErrKeyNotFound) {
+		return err
+	}
+	if len(progress) > 0 {
+		from, start = int(progress[0]), common.CopyBytes(progress[1:])
+		if from < len(storageChangeSetV2Buckets) {
+			log.Info("Resuming the conversion of the storage changesets to v2", "bucket", string(storageChangeSetV2Buckets[from].bucket), "from", fmt.Sprintf("%x", start))
+		}
+	}
+	for i := from; i < len(storageChangeSetV2Buckets); i++ {
+		if err := convertChangeSetValues(db, i, start); err != nil {
+			return err
+		}
+		start = nil
+	}
+	batch := db.NewBatch()
+	defer batch.Rollback()
+	if err := batch.Put(dbutils.DatabaseInfoBucket, dbutils.StorageChangeSetV2Key, []byte{1}); err != nil {
+		return err
+	}
+	if err := batch.Delete(dbutils.DatabaseInfoBucket, dbutils.StorageChangeSetV2ProgressKey); err != nil {
+		return err
+	}
+	_, err = batch.Commit()
+	return err
+}
+
+func storageToV2(decode func([]byte) (*changeset.ChangeSet, error), encode func(*changeset.ChangeSet) ([]byte, error)) func([]byte) ([]byte, bool, error) {
+	return func(v []byte) ([]byte, bool, error) {
+		if len(v) == 0 || changeset.IsStorageV2(v) || changeset.Len(v) == 0 {
+			return nil, false, nil
+		}
+		cs, err := decode(v)
+		if err != nil {
+			return nil, false, err
+		}
+		enc, err := encode(cs)
+		if err != nil {
+			return nil, false, err
+		}
+		return enc, true, nil
+	}
+}
+
+func epochToV2(convert func([]byte) ([]byte, bool, error)) func([]byte) ([]byte, bool, error) {
+	return func(v []byte) ([]byte, bool, error) {
+		var blockNrs []uint64
+		var changeSets [][]byte
+		var changed bool
+		if err := changeset.EpochBytes(v).Walk(func(blockNr uint64, cs []byte) error {
+			enc, ok, err := convert(cs)
+			if err != nil {
+				return err
+			}
+			if ok {
+				cs = enc
+				changed = true
+			}
+			blockNrs = append(blockNrs, blockNr)
+			changeSets = append(changeSets, cs)
+			return nil
+		}); err != nil {
+			return nil, false, err
+		}
+		if !changed {
+			return nil, false, nil
+		}
+		enc, err := changeset.EncodeEpoch(blockNrs, changeSets)
+		if err != nil {
+			return nil, false, err
+		}
+		return enc, true, nil
+	}
+}
+
+// convertChangeSetValues converts the changesets of the bucket with the index i of storageChangeSetV2Buckets,
+// starting from the key. The position, from which the conversion resumes, is written after the converted changesets
+// of the batch, so it never gets ahead of them. The changesets in the v2 layout are skipped
+func convertChangeSetValues(db ethdb.Database, i int, start []byte) error {
+	bucket, convert := storageChangeSetV2Buckets[i].bucket, storageChangeSetV2Buckets[i].convert
+	var converted int
+	for {
+		var keys, values [][]byte
+		var next []byte
+		var n int
+		if err := db.Walk(bucket, start, 0, func(k, v []byte) (bool, error) {
+			if n == storageChangeSetV2Batch {
+				next = common.CopyBytes(k)
+				return false, nil
+			}
+			n++
+			enc, ok, err := convert(v)
+			if err != nil {
+				return false, err
+			}
+			if ok {
+				keys = append(keys, common.CopyBytes(k))
+				values = append(values, enc)
+			}
+			return true, nil
+		}); err != nil {
+			return err
+		}
+		// The converted changesets can be large, the batch is committed when it reaches the ideal size
+		batch := ethdb.NewBatchWithPolicy(db, ethdb.CommitPolicy{})
+		for j, k := range keys {
+			if err := batch.Put(bucket, k, values[j]); err != nil {
+				batch.Rollback()
+				return err
+			}
+		}
+		progress := []byte{byte(i + 1)} // The bucket is converted, the next one is converted from the start
+		if next != nil {
+			progress = append([]byte{byte(i)}, next...)
+		}
+		if err := batch.Put(dbutils.DatabaseInfoBucket, dbutils.StorageChangeSetV2ProgressKey, progress); err != nil {
+			batch.Rollback()
+			return err
+		}
+		if _, err := batch.Commit(); err != nil {
+			return err
+		}
+		converted += len(keys)
+		if next == nil {
+			break
+		}
+		log.Info("Converting storage changesets to v2", "bucket", string(bucket), "converted", converted)
+		start = next
+	}
+	log.Info("Converted storage changesets to v2", "bucket", string(bucket), "converted", converted)
+	return nil
+}
@@ -0,0 +1,113 @@
+package migrations
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/common/changeset"
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConvertStorageChangeSetsToV2(t *testing.T) {
+	db := ethdb.NewMemDatabase()
+	var expected []*changeset.ChangeSet
+	var epochNrs []uint64
+	var epochChangeSets [][]byte
+	for i := 0; i < 30; i++ {
+		cs := changeset.NewStorageChangeSet()
+		addrHash := common.BytesToHash([]byte{byte(i)})
+		require.NoError(t, cs.Add(dbutils.GenerateCompositeStorageKey(addrHash, 1, common.Hash{1}), []byte{byte(i)}))
+		require.NoError(t, cs.Add(dbutils.GenerateCompositeStorageKey(addrHash, 2, common.Hash{2}), []byte{}))
+		v, err := changeset.EncodeStorageV1(cs)
+		require.NoError(t, err)
+		require.NoError(t, db.Put(dbutils.StorageChangeSetBucket, dbutils.EncodeTimestamp(uint64(i)), v))
+		expected = append(expected, cs)
+		epochNrs = append(epochNrs, uint64(i))
+		epochChangeSets = append(epochChangeSets, v)
+	}
+	epoch, err := changeset.EncodeEpoch(epochNrs, epochChangeSets)
+	require.NoError(t, err)
+	require.NoError(t, db.Put(dbutils.StorageChangeSetEpochBucket, dbutils.EncodeTimestamp(0), epoch))
+
+	require.NoError(t, ConvertStorageChangeSetsToV2(db))
+	// Converting again is a no-op
+	require.NoError(t, ConvertStorageChangeSetsToV2(db))
+	_, err = db.Get(dbutils.DatabaseInfoBucket, dbutils.StorageChangeSetV2Key)
+	assert.NoError(t, err)
+	_, err = db.Get(dbutils.DatabaseInfoBucket, dbutils.StorageChangeSetV2ProgressKey)
+	assert.True(t, errors.Is(err, ethdb.ErrKeyNotFound))
+
+	for i, cs := range expected {
+		v, err := db.Get(dbutils.StorageChangeSetBucket, dbutils.EncodeTimestamp(uint64(i)))
+		require.NoError(t, err)
+		assert.True(t, changeset.IsStorageV2(v))
+		decoded, err := changeset.DecodeStorage(v)
+		require.NoError(t, err)
+		assert.Equal(t, cs.Changes, decoded.Changes)
+	}
+	v, err := db.Get(dbutils.StorageChangeSetEpochBucket, dbutils.EncodeTimestamp(0))
+	require.NoError(t, err)
+	require.NoError(t, changeset.EpochBytes(v).Walk(func(blockNr uint64, cs []byte) error {
+		assert.True(t, changeset.IsStorageV2(cs))
+		decoded, err := changeset.DecodeStorage(cs)
+		require.NoError(t, err)
+		assert.Equal(t, expected[blockNr].Changes, decoded.Changes)
+		return nil
+	}))
+}
+
+func writeStorageChangeSetsV1(t *testing.T, db ethdb.Database, n int) {
+	for i := 0; i < n; i++ {
+		cs := changeset.NewStorageChangeSet()
+		require.NoError(t, cs.Add(dbutils.GenerateCompositeStorageKey(common.BytesToHash([]byte{byte(i)}), 1, common.Hash{1}), []byte{byte(i)}))
+		v, err := changeset.EncodeStorageV1(cs)
+		require.NoError(t, err)
+		require.NoError(t, db.Put(dbutils.StorageChangeSetBucket, dbutils.EncodeTimestamp(uint64(i)), v))
+	}
+}
+
+func TestResumeStorageChangeSetsV2(t *testing.T) {
+	db := ethdb.NewMemDatabase()
+	defer db.Close()
+	writeStorageChangeSetsV1(t, db, 20)
+	// The conversion was interrupted at the changeset of block 10
+	progress := append([]byte{0}, dbutils.EncodeTimestamp(10)...)
+	require.NoError(t, db.Put(dbutils.DatabaseInfoBucket, dbutils.StorageChangeSetV2ProgressKey, progress))
+
+	require.NoError(t, ConvertStorageChangeSetsToV2(db))
+	for i := 0; i < 20; i++ {
+		v, err := db.Get(dbutils.StorageChangeSetBucket, dbutils.EncodeTimestamp(uint64(i)))
+		require.NoError(t, err)
+		assert.Equal(t, i >= 10, changeset.IsStorageV2(v), "block %d", i)
+	}
+}
+
+func TestApplyStorageChangeSetV2(t *testing.T) {
+	defer func() { changeset.StorageV2 = false }()
+	db := ethdb.NewMemDatabase()
+	defer db.Close()
+	writeStorageChangeSetsV1(t, db, 5)
+	isV2 := func() bool {
+		v, err := db.Get(dbutils.StorageChangeSetBucket, dbutils.EncodeTimestamp(0))
+		require.NoError(t, err)
+		return changeset.IsStorageV2(v)
+	}
+
+	// Not converted unless requested
+	require.NoError(t, ApplyStorageChangeSetV2(db, false))
+	assert.False(t, changeset.StorageV2)
+	assert.False(t, isV2())
+
+	require.NoError(t, ApplyStorageChangeSetV2(db, true))
+	assert.True(t, changeset.StorageV2)
+	assert.True(t, isV2())
+
+	// The converted database keeps the v2 layout
+	changeset.StorageV2 = false
+	require.NoError(t, ApplyStorageChangeSetV2(db, false))
+	assert.True(t, changeset.StorageV2)
+}
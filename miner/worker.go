@@ -36,6 +36,7 @@ import (
 	"github.com/ledgerwatch/turbo-geth/core"
 	"github.com/ledgerwatch/turbo-geth/core/state"
 	"github.com/ledgerwatch/turbo-geth/core/types"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
 	"github.com/ledgerwatch/turbo-geth/event"
 	"github.com/ledgerwatch/turbo-geth/log"
 	"github.com/ledgerwatch/turbo-geth/params"
@@ -249,6 +250,10 @@ func (w *worker) pending() (*types.Block, *state.IntraBlockState, *state.TrieDbS
 		return nil, nil, nil
 	}
 
+	if w.snapshotTds == nil {
+		// The chain of the unhashed state has no trie
+		return w.snapshotBlock, w.snapshotState, nil
+	}
 	return w.snapshotBlock, w.snapshotState, w.snapshotTds.Copy()
 }
 
@@ -618,7 +623,8 @@ func (w *worker) insertToChain(result consensus.ResultWithContext, createdAt tim
 	}
 
 	// Different block could share same sealhash, deep copy here to prevent write-write conflict.
-	if directInsert {
+	// The blocks of the unhashed state are written by the plain state execution of InsertChain
+	if directInsert && task.tds != nil {
 		var (
 			receipts = make([]*types.Receipt, len(task.receipts))
 			logs     = make([]*types.Log, len(task.receipts))
@@ -755,20 +761,28 @@ func (w *worker) updateSnapshot() {
 	)
 
 	w.snapshotState = w.current.state
-	w.snapshotTds = w.current.tds.WithNewBuffer()
+	if w.current.tds != nil {
+		w.snapshotTds = w.current.tds.WithNewBuffer()
+	} else {
+		w.snapshotTds = nil
+	}
 }
 
 func (w *worker) commitTransaction(tx *types.Transaction, coinbase common.Address) ([]*types.Log, error) {
 	snap := w.current.state.Snapshot()
 
 	header := w.current.GetHeader()
-	receipt, err := core.ApplyTransaction(w.chainConfig, w.chain, &coinbase, w.current.gasPool, w.current.state, w.current.tds.TrieStateWriter(), header, tx, &header.GasUsed, *w.chain.GetVMConfig())
+	var stateWriter state.StateWriter = state.NewNoopWriter()
+	if w.current.tds != nil {
+		stateWriter = w.current.tds.TrieStateWriter()
+	}
+	receipt, err := core.ApplyTransaction(w.chainConfig, w.chain, &coinbase, w.current.gasPool, w.current.state, stateWriter, header, tx, &header.GasUsed, *w.chain.GetVMConfig())
 	if err != nil {
 		w.current.state.RevertToSnapshot(snap)
 		return nil, err
 	}
 
-	if !w.chainConfig.IsByzantium(w.current.Number()) {
+	if !w.chainConfig.IsByzantium(w.current.Number()) && w.current.tds != nil {
 		w.current.tds.StartNewBuffer()
 	}
 	w.current.txs = append(w.current.txs, tx)
@@ -788,7 +802,9 @@ func (w *worker) commitTransactions(txs *types.TransactionsByPriceAndNonce, coin
 		w.current.gasPool = new(core.GasPool).AddGas(header.GasLimit)
 	}
 
-	w.current.tds.StartNewBuffer()
+	if w.current.tds != nil {
+		w.current.tds.StartNewBuffer()
+	}
 	var coalescedLogs []*types.Log
 
 	for {
@@ -1065,7 +1081,13 @@ func (w *worker) commit(ctx consensus.Cancel, uncles []*types.Header, interval f
 
 	s := &(*w.current.state)
 
-	block, err := NewBlock(w.engine, s, w.current.tds, w.chain.Config(), w.current.GetHeader(), w.current.txs, uncles, w.current.receipts)
+	var block *types.Block
+	var err error
+	if w.current.tds == nil {
+		block, err = NewUnhashedBlock(w.engine, s, w.chain.ChainDb(), w.chain.Config(), w.current.GetHeader(), w.current.txs, uncles, w.current.receipts)
+	} else {
+		block, err = NewBlock(w.engine, s, w.current.tds, w.chain.Config(), w.current.GetHeader(), w.current.txs, uncles, w.current.receipts)
+	}
 	if err != nil {
 		return err
 	}
@@ -1158,6 +1180,31 @@ func NewBlock(engine consensus.Engine, s *state.IntraBlockState, tds *state.Trie
 	return types.NewBlock(header, txs, uncles, receipts), nil
 }
 
+// NewUnhashedBlock is NewBlock for the chains of the unhashed state (see rawdb.UnhashedStateSchema), which have no trie.
+// The root is computed from the plain state with the writes of the block, which are discarded afterwards
+func NewUnhashedBlock(engine consensus.Engine, s *state.IntraBlockState, db ethdb.Database, chainConfig *params.ChainConfig, header *types.Header, txs []*types.Transaction, uncles []*types.Header, receipts []*types.Receipt) (*types.Block, error) {
+	block, err := engine.FinalizeAndAssemble(chainConfig, header, s, txs, uncles, receipts)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := chainConfig.WithEIPsFlags(context.Background(), header.Number)
+	batch := db.NewBatch()
+	defer batch.Rollback()
+	if err = s.CommitBlock(ctx, state.NewPlainStateWriter(batch, batch, header.Number.Uint64())); err != nil {
+		return nil, err
+	}
+	root, err := state.PlainStateRoot(batch, "")
+	if err != nil {
+		return nil, fmt.Errorf("newUnhashedBlock on %s: %w", header.Number.String(), err)
+	}
+
+	header = block.Header()
+	header.Root = root
+
+	return types.NewBlock(header, txs, uncles, receipts), nil
+}
+
 func GetState(blockchain *core.BlockChain, parent *types.Block) (*state.IntraBlockState, *state.TrieDbState, error) {
 	current := blockchain.CurrentBlock()
 	if current.Number().Cmp(parent.Number()) != 0 || current.Root() != parent.Root() {
@@ -1170,11 +1217,17 @@ func GetState(blockchain *core.BlockChain, parent *types.Block) (*state.IntraBlo
 		return nil, nil, errors.New("mining in an odd state")
 	}
 
+	if blockchain.UnhashedState() {
+		return state.New(state.NewPlainStateReader(blockchain.ChainDb())), nil, nil
+	}
+
 	tds, err := blockchain.GetTrieDbState()
 	if err != nil {
 		return nil, nil, err
 	}
 
+	// The block is assembled in the new buffer sharing the trie with the blockchain,
+	// the deep copy of the resolved trie is too expensive for every mining round
 	tds = tds.WithNewBuffer()
 	tds.SetResolveReads(false)
 	tds.SetNoHistory(true)
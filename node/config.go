@@ -33,6 +33,8 @@ import (
 	"github.com/ledgerwatch/turbo-geth/accounts/usbwallet"
 	"github.com/ledgerwatch/turbo-geth/common"
 	"github.com/ledgerwatch/turbo-geth/crypto"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+	"github.com/ledgerwatch/turbo-geth/ethdb/remote/remotedbserver"
 	"github.com/ledgerwatch/turbo-geth/log"
 	"github.com/ledgerwatch/turbo-geth/p2p"
 	"github.com/ledgerwatch/turbo-geth/p2p/enode"
@@ -70,6 +72,10 @@ type Config struct {
 	// in memory.
 	DataDir string
 
+	// HistoryDataDir is the file system folder for the history and changeset buckets
+	// of the chain database, i.e. on a slower disk. If empty, they are stored together with the state in DataDir.
+	HistoryDataDir string
+
 	// Configuration of peer-to-peer networking.
 	P2P p2p.Config
 
@@ -191,10 +197,20 @@ type Config struct {
 	// Whether to use BadgerDB or BoltDB.
 	BadgerDB bool
 
+	// BoltOptions are the tunable options of the Bolt databases
+	BoltOptions ethdb.BoltOptions `toml:",omitempty"`
+
 	// Address to listen to when launchig listener for remote database access
 	// empty string means not to start the listener
 	RemoteDbListenAddress string
 
+	// Limits of the remote database clients, nil means remotedbserver.DefaultServerOpts
+	RemoteDbServerOpts *remotedbserver.ServerOpts `toml:",omitempty"`
+
+	// Address to listen to for the gRPC variant of the remote database access
+	// empty string means not to start the gRPC server
+	RemoteDbGrpcListenAddress string
+
 	staticNodesWarning     bool
 	trustedNodesWarning    bool
 	oldGethResourceWarning bool
@@ -326,6 +342,16 @@ func (c *Config) ResolvePath(path string) string {
 	return filepath.Join(c.instanceDir(), path)
 }
 
+// ResolveHistoryPath returns the absolute path of a history database in the history data directory,
+// or empty string if the history is not split from the state.
+func (c *Config) ResolveHistoryPath(path string) string {
+	if c.HistoryDataDir == "" || c.DataDir == "" {
+		return ""
+	}
+	// same layout as in the instance directory
+	return filepath.Join(c.HistoryDataDir, filepath.Base(c.instanceDir()), path)
+}
+
 func (c *Config) instanceDir() string {
 	if c.DataDir == "" {
 		return ""
@@ -29,6 +29,7 @@ import (
 	"sync"
 
 	"github.com/ledgerwatch/turbo-geth/accounts"
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
 	"github.com/ledgerwatch/turbo-geth/ethdb"
 	"github.com/ledgerwatch/turbo-geth/event"
 	"github.com/ledgerwatch/turbo-geth/internal/debug"
@@ -656,8 +657,13 @@ func (n *Node) OpenDatabase(name string) (ethdb.Database, error) {
 		return ethdb.NewBadgerDatabase(n.config.ResolvePath(name + "_badger"))
 	}
 
+	if historyPath := n.config.ResolveHistoryPath(name); historyPath != "" {
+		log.Info("Opening Database (Bolt, history split)")
+		return ethdb.NewSplitBoltDatabase(n.config.ResolvePath(name), historyPath, dbutils.ColdBuckets, n.config.BoltOptions)
+	}
+
 	log.Info("Opening Database (Bolt)")
-	boltDb, err := ethdb.NewBoltDatabase(n.config.ResolvePath(name))
+	boltDb, err := ethdb.NewBoltDatabaseWithOptions(n.config.ResolvePath(name), n.config.BoltOptions)
 	if err != nil {
 		return nil, err
 	}
@@ -20,6 +20,7 @@ import (
 	"reflect"
 
 	"github.com/ledgerwatch/turbo-geth/accounts"
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
 	"github.com/ledgerwatch/turbo-geth/ethdb"
 	"github.com/ledgerwatch/turbo-geth/event"
 	"github.com/ledgerwatch/turbo-geth/log"
@@ -56,8 +57,13 @@ func (ctx *ServiceContext) OpenDatabase(name string) (ethdb.Database, error) {
 		return ethdb.NewBadgerDatabase(ctx.Config.ResolvePath(name + "_badger"))
 	}
 
+	if historyPath := ctx.Config.ResolveHistoryPath(name); historyPath != "" {
+		log.Info("Opening Database (Bolt, history split)")
+		return ethdb.NewSplitBoltDatabase(ctx.Config.ResolvePath(name), historyPath, dbutils.ColdBuckets, ctx.Config.BoltOptions)
+	}
+
 	log.Info("Opening Database (Bolt)")
-	boltDb, err := ethdb.NewBoltDatabase(ctx.Config.ResolvePath(name))
+	boltDb, err := ethdb.NewBoltDatabaseWithOptions(ctx.Config.ResolvePath(name), ctx.Config.BoltOptions)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,180 @@
+package trie
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/core/types/accounts"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+)
+
+// ModificationsReceiver is a StreamReceiver which merges sorted modifications, not yet written into the database,
+// into the stream produced by FlatDbSubTrieLoader, and passes the result to the DefaultReceiver.
+// Modifications replace the database records with the same keys, nil account values and empty storage values
+// remove the records. Storage records of the `wiped` accounts (deleted or re-created) are skipped.
+type ModificationsReceiver struct {
+	defaultReceiver *DefaultReceiver
+	aKeys           common.Hashes
+	aValues         []*accounts.Account
+	sKeys           common.StorageKeys
+	sValues         [][]byte
+	wiped           map[common.Hash]struct{}
+	ai, si          int
+	itemKey         []byte
+	emitted         bool // whether anything was passed to the defaultReceiver since the last cutoff
+}
+
+func NewModificationsReceiver(
+	aKeys common.Hashes, aValues []*accounts.Account,
+	sKeys common.StorageKeys, sValues [][]byte,
+	wiped map[common.Hash]struct{},
+) *ModificationsReceiver {
+	return &ModificationsReceiver{
+		defaultReceiver: NewDefaultReceiver(),
+		aKeys:           aKeys,
+		aValues:         aValues,
+		sKeys:           sKeys,
+		sValues:         sValues,
+		wiped:           wiped,
+	}
+}
+
+// nextModification returns the key of the smallest modification not yet emitted, nil if there are none
+func (r *ModificationsReceiver) nextModification() (key []byte, isAccount bool) {
+	switch {
+	case r.ai < len(r.aKeys) && r.si < len(r.sKeys):
+		if bytes.Compare(r.aKeys[r.ai][:], r.sKeys[r.si][:]) < 0 {
+			return r.aKeys[r.ai][:], true
+		}
+		return r.sKeys[r.si][:], false
+	case r.ai < len(r.aKeys):
+		return r.aKeys[r.ai][:], true
+	case r.si < len(r.sKeys):
+		return r.sKeys[r.si][:], false
+	}
+	return nil, false
+}
+
+func (r *ModificationsReceiver) emitModification(key []byte, isAccount bool) error {
+	if isAccount {
+		v := r.aValues[r.ai]
+		r.ai++
+		if v == nil {
+			return nil
+		}
+		r.emitted = true
+		return r.defaultReceiver.Receive(AccountStreamItem, key, nil, nil, v, nil, nil, 0, 0)
+	}
+	v := r.sValues[r.si]
+	r.si++
+	if len(v) == 0 {
+		return nil
+	}
+	r.emitted = true
+	return r.defaultReceiver.Receive(StorageStreamItem, nil, key[:common.HashLength], key[common.HashLength:], nil, v, nil, 0, 0)
+}
+
+func (r *ModificationsReceiver) Receive(
+	itemType StreamItem,
+	accountKey []byte,
+	storageKeyPart1 []byte,
+	storageKeyPart2 []byte,
+	accountValue *accounts.Account,
+	storageValue []byte,
+	hash []byte,
+	cutoff int,
+	witnessLen uint64,
+) error {
+	var itemKey []byte // nil for the cutoff, so that all remaining modifications are emitted before it
+	switch itemType {
+	case StorageStreamItem, SHashStreamItem:
+		if _, ok := r.wiped[common.BytesToHash(storageKeyPart1)]; ok {
+			return nil
+		}
+		r.itemKey = append(append(r.itemKey[:0], storageKeyPart1...), storageKeyPart2...)
+		itemKey = r.itemKey
+	case AccountStreamItem, AHashStreamItem:
+		itemKey = accountKey
+	}
+	for {
+		key, isAccount := r.nextModification()
+		if key == nil {
+			break
+		}
+		c := -1
+		if itemKey != nil {
+			c = bytes.Compare(key, itemKey)
+		}
+		if c > 0 {
+			break
+		}
+		if err := r.emitModification(key, isAccount); err != nil {
+			return err
+		}
+		if c == 0 {
+			// Database record is overwritten by the modification
+			return nil
+		}
+	}
+	if itemType == CutoffStreamItem {
+		if !r.emitted {
+			// DefaultReceiver expects at least one item before the cutoff
+			r.defaultReceiver.subTries.roots = append(r.defaultReceiver.subTries.roots, nil)
+			r.defaultReceiver.subTries.Hashes = append(r.defaultReceiver.subTries.Hashes, EmptyRoot)
+			return nil
+		}
+		r.emitted = false
+	} else {
+		r.emitted = true
+	}
+	return r.defaultReceiver.Receive(itemType, accountKey, storageKeyPart1, storageKeyPart2, accountValue, storageValue, hash, cutoff, witnessLen)
+}
+
+func (r *ModificationsReceiver) Result() SubTries {
+	return r.defaultReceiver.Result()
+}
+
+// HashFlatWithModifications computes the root of the sub-trie located at dbPrefix (nil for the whole state,
+// addrHash+incarnation for the storage of one account) by streaming the sorted union of CurrentStateBucket
+// and the modifications through HashBuilder, without building the trie in memory.
+// Intermediate hashes are used for the parts of the trie not affected by the modifications, so they must be
+// consistent with the database. Only the data committed into the underlying Bolt database is visible.
+// If ihObserver is not nil, it is given the hashed branch nodes, see FlatDbSubTrieLoader.SetIntermediateHashObserver
+func HashFlatWithModifications(
+	db ethdb.Getter,
+	dbPrefix []byte,
+	aKeys common.Hashes, aValues []*accounts.Account,
+	sKeys common.StorageKeys, sValues [][]byte,
+	wiped map[common.Hash]struct{},
+	ihObserver func(prefix []byte, incarnation uint64, hash []byte, witnessLen uint64),
+	trace bool,
+) (common.Hash, error) {
+	rl := NewRetainList(0)
+	for i := range aKeys {
+		rl.AddKey(aKeys[i][:])
+	}
+	for i := range sKeys {
+		rl.AddKey(sKeys[i][:])
+	}
+	loader := NewFlatDbSubTrieLoader()
+	if err := loader.Reset(db, rl, [][]byte{dbPrefix}, []int{8 * len(dbPrefix)}, trace); err != nil {
+		return common.Hash{}, err
+	}
+	r := NewModificationsReceiver(aKeys, aValues, sKeys, sValues, wiped)
+	// Nothing is retained by the receiver, only hashes are computed
+	r.defaultReceiver.Reset(NewRetainList(0), trace)
+	r.defaultReceiver.setIntermediateHashObserver(ihObserver)
+	loader.SetStreamReceiver(r)
+	subTries, err := loader.LoadSubTries()
+	if err != nil {
+		return common.Hash{}, err
+	}
+	if len(subTries.Hashes) != 1 {
+		return common.Hash{}, fmt.Errorf("expected 1 hash, got %d", len(subTries.Hashes))
+	}
+	if subTries.Hashes[0] == (common.Hash{}) {
+		return EmptyRoot, nil
+	}
+	return subTries.Hashes[0], nil
+}
@@ -3,6 +3,7 @@ package trie
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"time"
@@ -13,12 +14,14 @@ import (
 	"github.com/ledgerwatch/turbo-geth/common/debug"
 	"github.com/ledgerwatch/turbo-geth/core/types/accounts"
 	"github.com/ledgerwatch/turbo-geth/ethdb"
+	"github.com/ledgerwatch/turbo-geth/log"
 	"github.com/ledgerwatch/turbo-geth/metrics"
 	"github.com/ledgerwatch/turbo-geth/trie/rlphacks"
 )
 
 var (
 	trieFlatDbSubTrieLoaderTimer = metrics.NewRegisteredTimer("trie/subtrieloader/flatdb", nil)
+	trieMissingWitnessLenMeter   = metrics.NewRegisteredMeter("trie/subtrieloader/witnesslen/missing", nil)
 )
 
 type StreamReceiver interface {
@@ -39,6 +42,7 @@ type StreamReceiver interface {
 
 type FlatDbSubTrieLoader struct {
 	trace              bool
+	readCode           CodeReader // Reads the requested code instead of dbutils.CodeBucket, see SubTrieLoader.SetCodeReader
 	rl                 RetainDecider
 	rangeIdx           int
 	accAddrHashWithInc [40]byte // Concatenation of addrHash of the currently build account with its incarnation encoding
@@ -54,7 +58,9 @@ type FlatDbSubTrieLoader struct {
 
 	itemPresent   bool
 	itemType      StreamItem
-	getWitnessLen func(prefix []byte) uint64
+	getWitnessLen func(prefix []byte) (uint64, bool)
+	// Intermediate hashes of the last load, which were not used because their witness length was missing
+	missingWitnessLens int
 
 	// Storage item buffer
 	storageKeyPart1 []byte
@@ -70,6 +76,7 @@ type FlatDbSubTrieLoader struct {
 
 	receiver        StreamReceiver
 	defaultReceiver *DefaultReceiver
+	binary          bool // Building the binary trie, see SetBinary
 }
 
 type DefaultReceiver struct {
@@ -91,6 +98,7 @@ type DefaultReceiver struct {
 	leafData     GenStructStepLeafData
 	accData      GenStructStepAccountData
 	witnessLen   uint64
+	binary       bool // The keys are split into bits instead of nibbles, see SetBinary
 }
 
 func NewDefaultReceiver() *DefaultReceiver {
@@ -127,12 +135,10 @@ func (fstl *FlatDbSubTrieLoader) Reset(db ethdb.Getter, rl RetainDecider, dbPref
 	if len(dbPrefixes) == 0 {
 		return nil
 	}
+	fstl.boltDB = nil
 	if hasBolt, ok := db.(ethdb.HasKV); ok {
 		fstl.boltDB = hasBolt.KV()
 	}
-	if fstl.boltDB == nil {
-		return fmt.Errorf("only Bolt supported yet, given: %T", db)
-	}
 	fixedbytes := make([]int, len(fixedbits))
 	masks := make([]byte, len(fixedbits))
 	cutoffs := make([]int, len(fixedbits))
@@ -151,13 +157,49 @@ func (fstl *FlatDbSubTrieLoader) Reset(db ethdb.Getter, rl RetainDecider, dbPref
 	return nil
 }
 
+// SetBinary switches the loader to the binary trie experiment: the sub-tries of the binary (2-ary) trie are built
+// out of the same state, the hashes are the same as of the binary trie produced by HexToBin.
+// The intermediate hashes of the hexary trie are not used, so the whole ranges are read from the state.
+// The retain decider has to take the binary prefixes, i.e. NewBinaryRetainList
+func (fstl *FlatDbSubTrieLoader) SetBinary(binary bool) {
+	fstl.binary = binary
+	fstl.defaultReceiver.SetBinary(binary)
+}
+
+// SetIntermediateHashObserver makes the default receiver report every branch node it hashes, with the prefix in nibbles
+// (the storage prefixes start with the nibbles of the account's address hash) and the incarnation of the account
+// for the storage branches. It is used to regenerate the intermediate hashes, the slices are only valid during the call
+func (fstl *FlatDbSubTrieLoader) SetIntermediateHashObserver(f func(prefix []byte, incarnation uint64, hash []byte, witnessLen uint64)) {
+	fstl.defaultReceiver.setIntermediateHashObserver(f)
+}
+
+// setIntermediateHashObserver is SetIntermediateHashObserver of the receiver, see FlatDbSubTrieLoader
+func (dr *DefaultReceiver) setIntermediateHashObserver(f func(prefix []byte, incarnation uint64, hash []byte, witnessLen uint64)) {
+	if f == nil {
+		dr.hb.SetBranchHashObserver(nil)
+		return
+	}
+	dr.hb.SetBranchHashObserver(func(prefix []byte, hash []byte, witnessLen uint64) {
+		var incarnation uint64
+		if len(prefix) >= 2*common.HashLength {
+			incarnation = dr.a.Incarnation
+		}
+		f(prefix, incarnation, hash, witnessLen)
+	})
+}
+
+// SetHashBuilderTracer makes the default receiver pass every opcode it executes to the tracer, nil disables the tracing
+func (fstl *FlatDbSubTrieLoader) SetHashBuilderTracer(t HashBuilderTracer) {
+	fstl.defaultReceiver.hb.SetTracer(t)
+}
+
 func (fstl *FlatDbSubTrieLoader) SetStreamReceiver(receiver StreamReceiver) {
 	fstl.receiver = receiver
 }
 
 // iteration moves through the database buckets and creates at most
 // one stream item, which is indicated by setting the field fstl.itemPresent to true
-func (fstl *FlatDbSubTrieLoader) iteration(c, ih *bolt.Cursor, first bool) error {
+func (fstl *FlatDbSubTrieLoader) iteration(c, ih LoaderCursor, first bool) error {
 	var isIH bool
 	var minKey []byte
 	if !first {
@@ -223,7 +265,9 @@ func (fstl *FlatDbSubTrieLoader) iteration(c, ih *bolt.Cursor, first bool) error
 				}
 			}
 			isIH, minKey = keyIsBefore(fstl.ihK, fstl.k)
-			if fixedbytes == 0 {
+			// minKey is valid now, so the range is finished with a cutoff if it turns out to be empty
+			first = false
+			if fixedbytes == 0 && minKey != nil {
 				cmp = 0
 			}
 		} else if cmp > 0 {
@@ -293,7 +337,7 @@ func (fstl *FlatDbSubTrieLoader) iteration(c, ih *bolt.Cursor, first bool) error
 				return fmt.Errorf("fail DecodeForStorage: %w", err)
 			}
 			copy(fstl.accAddrHashWithInc[:], fstl.k)
-			binary.BigEndian.PutUint64(fstl.accAddrHashWithInc[32:], ^fstl.accountValue.Incarnation)
+			dbutils.EncodeIncarnation(fstl.accAddrHashWithInc[32:], fstl.accountValue.Incarnation)
 			// Now we know the correct incarnation of the account, and we can skip all irrelevant storage records
 			// Since 0 incarnation if 0xfff...fff, and we do not expect any records like that, this automatically
 			// skips over all storage items
@@ -340,7 +384,13 @@ func (fstl *FlatDbSubTrieLoader) iteration(c, ih *bolt.Cursor, first bool) error
 		}
 		return nil
 	}
+	witnessLen, ok := fstl.getWitnessLen(fstl.ihK)
+	if !ok { // the sub-trie is loaded from the children, which recomputes its witness length
+		fstl.ihK, fstl.ihV = ih.Next()
+		return nil
+	}
 	fstl.itemPresent = true
+	fstl.witnessLen = witnessLen
 	if len(fstl.ihK) > common.HashLength {
 		fstl.itemType = SHashStreamItem
 		if len(fstl.ihK) >= common.HashLength {
@@ -356,14 +406,12 @@ func (fstl *FlatDbSubTrieLoader) iteration(c, ih *bolt.Cursor, first bool) error
 		}
 		fstl.hashValue = fstl.ihV
 		fstl.storageValue = nil
-		fstl.witnessLen = fstl.getWitnessLen(fstl.ihK)
 	} else {
 		fstl.itemType = AHashStreamItem
 		fstl.accountKey = fstl.ihK
 		fstl.storageKeyPart1 = nil
 		fstl.storageKeyPart2 = nil
 		fstl.hashValue = fstl.ihV
-		fstl.witnessLen = fstl.getWitnessLen(fstl.ihK)
 	}
 
 	// skip subtree
@@ -430,6 +478,29 @@ func (dr *DefaultReceiver) Reset(rl RetainDecider, trace bool) {
 	dr.hb.trace = trace
 }
 
+// SetBinary switches the receiver to the binary trie: the keys are split into bits instead of nibbles,
+// so the hash builder receives the structure of the binary trie. Cutoffs are still given in nibbles
+func (dr *DefaultReceiver) SetBinary(binary bool) {
+	dr.binary = binary
+}
+
+// keyLen converts the length of the key in nibbles into the length in the units of the receiver
+func (dr *DefaultReceiver) keyLen(nibbles int) int {
+	if dr.binary {
+		return 4 * nibbles
+	}
+	return nibbles
+}
+
+// writeKey splits the key into nibbles, or into bits for the binary trie
+func (dr *DefaultReceiver) writeKey(k []byte, w io.ByteWriter) {
+	if dr.binary {
+		keyToBits(k, w)
+	} else {
+		keyToNibbles(k, w)
+	}
+}
+
 func (dr *DefaultReceiver) Receive(itemType StreamItem,
 	accountKey []byte,
 	storageKeyPart1 []byte,
@@ -440,6 +511,7 @@ func (dr *DefaultReceiver) Receive(itemType StreamItem,
 	cutoff int,
 	witnessLen uint64,
 ) error {
+	accountKeyLen := dr.keyLen(2 * common.HashLength) // Length of the account part of the storage keys
 	switch itemType {
 	case StorageStreamItem:
 		dr.advanceKeysStorage(storageKeyPart1, storageKeyPart2, true /* terminator */)
@@ -460,15 +532,18 @@ func (dr *DefaultReceiver) Receive(itemType StreamItem,
 	case AccountStreamItem:
 		dr.advanceKeysAccount(accountKey, true /* terminator */)
 		if dr.curr.Len() > 0 && !dr.wasIH {
-			dr.cutoffKeysStorage(2 * common.HashLength)
+			dr.cutoffKeysStorage(accountKeyLen)
 			if dr.currStorage.Len() > 0 {
 				if err := dr.genStructStorage(); err != nil {
 					return err
 				}
 			}
 			if dr.currStorage.Len() > 0 {
-				if len(dr.groups) >= 2*common.HashLength {
-					dr.groups = dr.groups[:2*common.HashLength-1]
+				if len(dr.groups) >= accountKeyLen {
+					// The storage root is left in the group of the branch above the account, the account leaf replaces it.
+					// The other accounts of that branch stay in the group
+					dr.groups = dr.groups[:accountKeyLen]
+					dr.groups[accountKeyLen-1] &^= uint16(1) << dr.currStorage.Bytes()[accountKeyLen-1]
 				}
 				for len(dr.groups) > 0 && dr.groups[len(dr.groups)-1] == 0 {
 					dr.groups = dr.groups[:len(dr.groups)-1]
@@ -491,15 +566,18 @@ func (dr *DefaultReceiver) Receive(itemType StreamItem,
 	case AHashStreamItem:
 		dr.advanceKeysAccount(accountKey, false /* terminator */)
 		if dr.curr.Len() > 0 && !dr.wasIH {
-			dr.cutoffKeysStorage(2 * common.HashLength)
+			dr.cutoffKeysStorage(accountKeyLen)
 			if dr.currStorage.Len() > 0 {
 				if err := dr.genStructStorage(); err != nil {
 					return err
 				}
 			}
 			if dr.currStorage.Len() > 0 {
-				if len(dr.groups) >= 2*common.HashLength {
-					dr.groups = dr.groups[:2*common.HashLength-1]
+				if len(dr.groups) >= accountKeyLen {
+					// The storage root is left in the group of the branch above the account, the account leaf replaces it.
+					// The other accounts of that branch stay in the group
+					dr.groups = dr.groups[:accountKeyLen]
+					dr.groups[accountKeyLen-1] &^= uint16(1) << dr.currStorage.Bytes()[accountKeyLen-1]
 				}
 				for len(dr.groups) > 0 && dr.groups[len(dr.groups)-1] == 0 {
 					dr.groups = dr.groups[:len(dr.groups)-1]
@@ -520,7 +598,8 @@ func (dr *DefaultReceiver) Receive(itemType StreamItem,
 			return err
 		}
 	case CutoffStreamItem:
-		if cutoff >= 2*common.HashLength {
+		cutoff = dr.keyLen(cutoff)
+		if cutoff >= accountKeyLen {
 			dr.cutoffKeysStorage(cutoff)
 			if dr.currStorage.Len() > 0 {
 				if err := dr.genStructStorage(); err != nil {
@@ -546,15 +625,18 @@ func (dr *DefaultReceiver) Receive(itemType StreamItem,
 		} else {
 			dr.cutoffKeysAccount(cutoff)
 			if dr.curr.Len() > 0 && !dr.wasIH {
-				dr.cutoffKeysStorage(2 * common.HashLength)
+				dr.cutoffKeysStorage(accountKeyLen)
 				if dr.currStorage.Len() > 0 {
 					if err := dr.genStructStorage(); err != nil {
 						return err
 					}
 				}
 				if dr.currStorage.Len() > 0 {
-					if len(dr.groups) >= 2*common.HashLength {
-						dr.groups = dr.groups[:2*common.HashLength-1]
+					if len(dr.groups) >= accountKeyLen {
+						// The storage root is left in the group of the branch above the account, the account leaf replaces it.
+						// The other accounts of that branch stay in the group
+						dr.groups = dr.groups[:accountKeyLen]
+						dr.groups[accountKeyLen-1] &^= uint16(1) << dr.currStorage.Bytes()[accountKeyLen-1]
 					}
 					for len(dr.groups) > 0 && dr.groups[len(dr.groups)-1] == 0 {
 						dr.groups = dr.groups[:len(dr.groups)-1]
@@ -579,6 +661,10 @@ func (dr *DefaultReceiver) Receive(itemType StreamItem,
 					dr.groups = dr.groups[:len(dr.groups)-1]
 				}
 			}
+			if !dr.hb.hasRoot() {
+				// No accounts in the range
+				dr.hb.emptyRoot()
+			}
 			dr.subTries.roots = append(dr.subTries.roots, dr.hb.root())
 			dr.subTries.Hashes = append(dr.subTries.Hashes, dr.hb.rootHash())
 			dr.groups = dr.groups[:0]
@@ -598,52 +684,230 @@ func (dr *DefaultReceiver) Result() SubTries {
 	return dr.subTries
 }
 
+// LoaderCursor is the part of the cursor used by the loader, implemented by *bolt.Cursor.
+// The loader only seeks forward, so SeekTo may skip the entries one by one
+type LoaderCursor interface {
+	SeekTo(seek []byte) ([]byte, []byte)
+	Next() ([]byte, []byte)
+}
+
+// kvLoaderCursor adapts ethdb.Cursor to the loader, remembering the first error. The values are decoded
+// before the next move, so they are not copied, see ethdb.Cursor.UnsafeValue
+type kvLoaderCursor struct {
+	c   ethdb.NoValuesCursor
+	err error
+}
+
+func newKvLoaderCursor(b ethdb.Bucket) *kvLoaderCursor {
+	return &kvLoaderCursor{c: b.Cursor().NoValues()}
+}
+
+func (c *kvLoaderCursor) SeekTo(seek []byte) ([]byte, []byte) {
+	k, _, err := c.c.SeekTo(seek)
+	return c.value(k, err)
+}
+
+func (c *kvLoaderCursor) Next() ([]byte, []byte) {
+	k, _, err := c.c.Next()
+	return c.value(k, err)
+}
+
+func (c *kvLoaderCursor) value(k []byte, err error) ([]byte, []byte) {
+	var v []byte
+	if err == nil && k != nil {
+		v, err = c.c.UnsafeValue()
+	}
+	if err != nil && c.err == nil {
+		c.err = err
+	}
+	return k, v
+}
+
 func (fstl *FlatDbSubTrieLoader) LoadSubTries() (SubTries, error) {
 	defer trieFlatDbSubTrieLoaderTimer.UpdateSince(time.Now())
 	if len(fstl.dbPrefixes) == 0 {
 		return SubTries{}, nil
 	}
+	if fstl.boltDB == nil {
+		return SubTries{}, fmt.Errorf("only Bolt supported yet, use LoadSubTriesFromTx")
+	}
 	if err := fstl.boltDB.View(func(tx *bolt.Tx) error {
-		c := tx.Bucket(dbutils.CurrentStateBucket).Cursor()
+		c := ethdb.StateCursor(tx)
+		if c == nil {
+			return fmt.Errorf("%w: %s", ethdb.ErrBucketNotFound, dbutils.CurrentStateBucket)
+		}
 		ih := tx.Bucket(dbutils.IntermediateTrieHashBucket).Cursor()
 		iwl := tx.Bucket(dbutils.IntermediateTrieWitnessLenBucket).Cursor()
-		fstl.getWitnessLen = func(prefix []byte) uint64 {
-			if !debug.IsTrackWitnessSizeEnabled() {
-				return 0
-			}
-			k, v := iwl.SeekTo(prefix)
-			if !bytes.Equal(k, prefix) {
-				panic(fmt.Sprintf("IH and DataLen buckets must have same keys set: %x, %x", k, prefix))
+		return fstl.load(c, ih, iwl)
+	}); err != nil {
+		return SubTries{}, err
+	}
+	return fstl.receiver.Result(), nil
+}
+
+// LoadSubTriesFromTx is LoadSubTries reading the state from the transaction instead of the database
+// given to Reset. With ethdb.OverlayTx, the sub-tries include the changes not written into the database yet
+func (fstl *FlatDbSubTrieLoader) LoadSubTriesFromTx(tx ethdb.Tx) (SubTries, error) {
+	defer trieFlatDbSubTrieLoaderTimer.UpdateSince(time.Now())
+	if len(fstl.dbPrefixes) == 0 {
+		return SubTries{}, nil
+	}
+	splitState, err := isSplitStateTx(tx)
+	if err != nil {
+		return SubTries{}, err
+	}
+	var c LoaderCursor
+	cursors := make([]*kvLoaderCursor, 0, 4)
+	if splitState {
+		accounts := newKvLoaderCursor(tx.Bucket(dbutils.CurrentStateAccountsBucket))
+		storage := newKvLoaderCursor(tx.Bucket(dbutils.CurrentStateStorageBucket))
+		c = &shardsLoaderCursor{accounts: accounts, storage: storage}
+		cursors = append(cursors, accounts, storage)
+	} else {
+		state := newKvLoaderCursor(tx.Bucket(dbutils.CurrentStateBucket))
+		c = state
+		cursors = append(cursors, state)
+	}
+	ih := newKvLoaderCursor(tx.Bucket(dbutils.IntermediateTrieHashBucket))
+	iwl := newKvLoaderCursor(tx.Bucket(dbutils.IntermediateTrieWitnessLenBucket))
+	if err := fstl.load(c, ih, iwl); err != nil {
+		return SubTries{}, err
+	}
+	for _, cursor := range append(cursors, ih, iwl) {
+		if cursor.err != nil {
+			return SubTries{}, cursor.err
+		}
+	}
+	return fstl.receiver.Result(), nil
+}
+
+// LoadSubTriesFromCursor is LoadSubTries reading the entries of dbutils.CurrentStateBucket from the cursor,
+// without the intermediate hashes. The database given to Reset is not read
+func (fstl *FlatDbSubTrieLoader) LoadSubTriesFromCursor(c LoaderCursor) (SubTries, error) {
+	defer trieFlatDbSubTrieLoaderTimer.UpdateSince(time.Now())
+	if len(fstl.dbPrefixes) == 0 {
+		return SubTries{}, nil
+	}
+	if err := fstl.load(c, emptyLoaderCursor{}, emptyLoaderCursor{}); err != nil {
+		return SubTries{}, err
+	}
+	return fstl.receiver.Result(), nil
+}
+
+// isSplitStateTx is ethdb.IsSplitState for the abstract transactions
+func isSplitStateTx(tx ethdb.Tx) (bool, error) {
+	v, err := tx.Bucket(dbutils.DatabaseInfoBucket).Get(dbutils.SplitStateKey)
+	if err != nil && !errors.Is(err, ethdb.ErrKeyNotFound) {
+		return false, err
+	}
+	return len(v) > 0, nil
+}
+
+// shardsLoaderCursor merges the accounts and the storage items of the split state schema (see ethdb.IsSplitState)
+// into the order of dbutils.CurrentStateBucket. Skipping the storage of an account (see nextAccount) moves only
+// the storage cursor, the accounts are read one after another
+type shardsLoaderCursor struct {
+	accounts, storage LoaderCursor
+	ak, av, sk, sv    []byte
+	positioned        bool
+}
+
+func (c *shardsLoaderCursor) SeekTo(seek []byte) ([]byte, []byte) {
+	if !c.positioned || c.ak != nil && bytes.Compare(c.ak, seek) < 0 {
+		c.ak, c.av = c.accounts.SeekTo(seek)
+	}
+	if !c.positioned || c.sk != nil && bytes.Compare(c.sk, seek) < 0 {
+		c.sk, c.sv = c.storage.SeekTo(seek)
+	}
+	c.positioned = true
+	return c.current()
+}
+
+func (c *shardsLoaderCursor) Next() ([]byte, []byte) {
+	if c.accountFirst() {
+		c.ak, c.av = c.accounts.Next()
+	} else if c.sk != nil {
+		c.sk, c.sv = c.storage.Next()
+	}
+	return c.current()
+}
+
+func (c *shardsLoaderCursor) accountFirst() bool {
+	return c.ak != nil && (c.sk == nil || bytes.Compare(c.ak, c.sk) < 0)
+}
+
+func (c *shardsLoaderCursor) current() ([]byte, []byte) {
+	if c.accountFirst() {
+		return c.ak, c.av
+	}
+	return c.sk, c.sv
+}
+
+// emptyLoaderCursor has no entries, it replaces the intermediate hashes in the binary mode
+type emptyLoaderCursor struct{}
+
+func (emptyLoaderCursor) SeekTo([]byte) ([]byte, []byte) { return nil, nil }
+func (emptyLoaderCursor) Next() ([]byte, []byte)         { return nil, nil }
+
+func (fstl *FlatDbSubTrieLoader) load(c, ih, iwl LoaderCursor) error {
+	if fstl.binary {
+		// Intermediate hashes are the hashes of the hexary trie
+		ih = emptyLoaderCursor{}
+	}
+	// The intermediate hash without the witness length (i.e. written before the tracking was enabled) is not used,
+	// the sub-trie is loaded from the state below instead, and the entry is written again with the witness length
+	// when the branch node is unloaded (see state.IntermediateHashes)
+	fstl.missingWitnessLens = 0
+	fstl.getWitnessLen = func(prefix []byte) (uint64, bool) {
+		if !debug.IsTrackWitnessSizeEnabled() {
+			return 0, true
+		}
+		k, v := iwl.SeekTo(prefix)
+		if !bytes.Equal(k, prefix) || len(v) != 8 {
+			if fstl.trace {
+				fmt.Printf("witness length of %x is missing\n", prefix)
 			}
-			return binary.BigEndian.Uint64(v)
+			fstl.missingWitnessLens++
+			return 0, false
 		}
-		if err := fstl.iteration(c, ih, true /* first */); err != nil {
-			return err
+		return binary.BigEndian.Uint64(v), true
+	}
+	defer func() {
+		if fstl.missingWitnessLens > 0 {
+			trieMissingWitnessLenMeter.Mark(int64(fstl.missingWitnessLens))
+			log.Warn("Recomputed the witness lengths missing for the intermediate hashes", "count", fstl.missingWitnessLens)
 		}
-		for fstl.rangeIdx < len(fstl.dbPrefixes) {
-			for !fstl.itemPresent {
-				if err := fstl.iteration(c, ih, false /* first */); err != nil {
-					return err
-				}
+	}()
+	if err := fstl.iteration(c, ih, true /* first */); err != nil {
+		return err
+	}
+	// The first iteration produces the cutoff of the last range by itself, if all the ranges are empty
+	for fstl.rangeIdx < len(fstl.dbPrefixes) || fstl.itemPresent {
+		for !fstl.itemPresent {
+			if err := fstl.iteration(c, ih, false /* first */); err != nil {
+				return err
 			}
-			if fstl.itemPresent {
-				if err := fstl.receiver.Receive(fstl.itemType, fstl.accountKey, fstl.storageKeyPart1, fstl.storageKeyPart2, &fstl.accountValue, fstl.storageValue, fstl.hashValue, fstl.streamCutoff, fstl.witnessLen); err != nil {
-					return err
-				}
-				fstl.itemPresent = false
+		}
+		if fstl.itemPresent {
+			if err := fstl.receiver.Receive(fstl.itemType, fstl.accountKey, fstl.storageKeyPart1, fstl.storageKeyPart2, &fstl.accountValue, fstl.storageValue, fstl.hashValue, fstl.streamCutoff, fstl.witnessLen); err != nil {
+				return err
 			}
+			fstl.itemPresent = false
 		}
-		return nil
-	}); err != nil {
-		return SubTries{}, err
 	}
-	return fstl.receiver.Result(), nil
+	return nil
 }
 
 func (fstl *FlatDbSubTrieLoader) AttachRequestedCode(db ethdb.Getter, requests []*LoadRequestForCode) error {
 	for _, req := range requests {
 		codeHash := req.codeHash
-		code, err := db.Get(dbutils.CodeBucket, codeHash[:])
+		var code []byte
+		var err error
+		if fstl.readCode != nil {
+			code, err = fstl.readCode(db, codeHash)
+		} else {
+			code, err = db.Get(dbutils.CodeBucket, codeHash[:])
+		}
 		if err != nil {
 			return err
 		}
@@ -669,6 +933,15 @@ func keyToNibbles(k []byte, w io.ByteWriter) {
 	}
 }
 
+func keyToBits(k []byte, w io.ByteWriter) {
+	for _, b := range k {
+		for shift := 7; shift >= 0; shift-- {
+			//nolint:errcheck
+			w.WriteByte((b >> uint(shift)) & 1)
+		}
+	}
+}
+
 func keyToNibblesWithoutInc(k []byte, w io.ByteWriter) {
 	// Transform k to nibbles, but skip the incarnation part in the middle
 	for i, b := range k {
@@ -695,8 +968,8 @@ func (dr *DefaultReceiver) advanceKeysStorage(kPart1, kPart2 []byte, terminator
 	dr.currStorage.Write(dr.succStorage.Bytes())
 	dr.succStorage.Reset()
 	// Transform k to nibbles, but skip the incarnation part in the middle
-	keyToNibbles(kPart1, &dr.succStorage)
-	keyToNibbles(kPart2, &dr.succStorage)
+	dr.writeKey(kPart1, &dr.succStorage)
+	dr.writeKey(kPart2, &dr.succStorage)
 
 	if terminator {
 		dr.succStorage.WriteByte(16)
@@ -747,10 +1020,7 @@ func (dr *DefaultReceiver) advanceKeysAccount(k []byte, terminator bool) {
 	dr.curr.Reset()
 	dr.curr.Write(dr.succ.Bytes())
 	dr.succ.Reset()
-	for _, b := range k {
-		dr.succ.WriteByte(b / 16)
-		dr.succ.WriteByte(b % 16)
-	}
+	dr.writeKey(k, &dr.succ)
 	if terminator {
 		dr.succ.WriteByte(16)
 	}
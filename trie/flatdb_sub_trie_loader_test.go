@@ -2,9 +2,13 @@ package trie
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"fmt"
+	"math/rand"
+	"reflect"
 	"testing"
+	"testing/quick"
 
 	"github.com/holiman/uint256"
 
@@ -197,6 +201,31 @@ func TestTwoStorageItems(t *testing.T) {
 	assert.NotNil(x)
 }
 
+func TestBinaryLoader(t *testing.T) {
+	require, assert, db := require.New(t), assert.New(t), ethdb.NewMemDatabase()
+	tr := New(common.Hash{})
+	for i, key := range []string{
+		"03601462093b5945d1676df093446790fd31b20e7b12a2e8e5e09d068109616b",
+		"0fbc62ba90dec43ec1d6016f9dd39dc324e967f2a3459a78281d1f4b2ba962a6",
+		"0fbc62ba90dec43ec1d6016f9dd39dc324e967f2a3459a78281d1f4b2ba962a7",
+		"d7b6990105719101dabeb77144f2a3385c8033acd3af97e9423a695e81ad1eb5",
+	} {
+		acc := accounts.NewAccount()
+		acc.Initialised = true
+		acc.Balance.SetUint64(uint64(1000 * (i + 1)))
+		require.NoError(writeAccount(db, common.HexToHash(key), acc))
+		tr.UpdateAccount(common.Hex2Bytes(key), &acc)
+	}
+
+	loader := NewFlatDbSubTrieLoader()
+	loader.SetBinary(true)
+	require.NoError(loader.Reset(db, NewBinaryRetainList(0), [][]byte{nil}, []int{0}, false))
+	subTries, err := loader.LoadSubTries()
+	require.NoError(err, "resolve error")
+	assert.Equal(HexToBin(tr).Trie().Hash().String(), subTries.Hashes[0].String())
+	assert.NotEqual(tr.Hash().String(), subTries.Hashes[0].String())
+}
+
 func TestTwoAccounts(t *testing.T) {
 	require, assert, db := require.New(t), assert.New(t), ethdb.NewMemDatabase()
 	key1 := common.Hex2Bytes("03601462093b5945d1676df093446790fd31b20e7b12a2e8e5e09d068109616b")
@@ -233,6 +262,76 @@ func TestTwoAccounts(t *testing.T) {
 	assert.NotNil(x)
 }
 
+func TestSiblingAccountWithStorage(t *testing.T) {
+	require, assert, db := require.New(t), assert.New(t), ethdb.NewMemDatabase()
+	// The accounts differ in the last nibble only, the storage of the second one is built under their branch node
+	key1 := common.HexToHash("8ecdb932460eeeff2bca46c96e8a02cfb55d770940de556373a4dd676e3a0dd6")
+	key2 := common.HexToHash("8ecdb932460eeeff2bca46c96e8a02cfb55d770940de556373a4dd676e3a0ddb")
+	storageKey := common.HexToHash("f261df6d37b017dee05cfc3a42e4130216e5540cf715c4e638d7d615c50bef57")
+	tr := New(common.Hash{})
+	acc := accounts.NewAccount()
+	acc.Initialised = true
+	acc.Nonce = 1
+	require.NoError(writeAccount(db, key1, acc))
+	tr.UpdateAccount(key1[:], &acc)
+	acc2 := accounts.NewAccount()
+	acc2.Initialised = true
+	acc2.Nonce = 2
+	acc2.Incarnation = 1
+	require.NoError(writeAccount(db, key2, acc2))
+	tr.UpdateAccount(key2[:], &acc2)
+	require.NoError(db.Put(dbutils.CurrentStateBucket, dbutils.GenerateCompositeStorageKey(key2, 1, storageKey), []byte{0x01}))
+	tr.Update(dbutils.GenerateCompositeTrieKey(key2, storageKey), []byte{0x01})
+
+	loader := NewFlatDbSubTrieLoader()
+	require.NoError(loader.Reset(db, NewRetainList(0), [][]byte{nil}, []int{0}, false))
+	subTries, err := loader.LoadSubTries()
+	require.NoError(err, "resolve error")
+	assert.Equal(tr.Hash().String(), subTries.Hashes[0].String())
+}
+
+func TestLoadSubTriesFromOverlay(t *testing.T) {
+	require, assert, db := require.New(t), assert.New(t), ethdb.NewMemDatabase()
+	key1 := common.Hex2Bytes("03601462093b5945d1676df093446790fd31b20e7b12a2e8e5e09d068109616b")
+	acc := accounts.NewAccount()
+	acc.Initialised = true
+	acc.Balance.SetUint64(10000000000)
+	acc.CodeHash.SetBytes(common.Hex2Bytes("c5d2460186f7233c927e7db2dcc703c0e500b653ca82273b7bfad8045d85a470"))
+	require.NoError(writeAccount(db, common.BytesToHash(key1), acc))
+
+	// The second account of TestTwoAccounts is only written into the overlay
+	key2 := common.Hex2Bytes("0fbc62ba90dec43ec1d6016f9dd39dc324e967f2a3459a78281d1f4b2ba962a6")
+	acc2 := accounts.NewAccount()
+	acc2.Initialised = true
+	acc2.Balance.SetUint64(100)
+	acc2.CodeHash.SetBytes(common.Hex2Bytes("4f1593970e8f030c0a2c39758181a447774eae7c65653c4e6440e8c18dad69bc"))
+	value := make([]byte, acc2.EncodingLengthForStorage())
+	acc2.EncodeForStorage(value)
+
+	expect := common.HexToHash("925002c3260b44e44c3edebad1cc442142b03020209df1ab8bb86752edbd2cd7")
+	loader := NewFlatDbSubTrieLoader()
+	require.NoError(db.AbstractKV().View(context.Background(), func(tx ethdb.Tx) error {
+		overlay := ethdb.NewOverlayTx(tx)
+		if err := overlay.Bucket(dbutils.CurrentStateBucket).Put(key2, value); err != nil {
+			return err
+		}
+		if err := loader.Reset(db, NewRetainList(0), [][]byte{nil}, []int{0}, false); err != nil {
+			return err
+		}
+		subTries, err := loader.LoadSubTriesFromTx(overlay)
+		if err != nil {
+			return err
+		}
+		assert.Equal(expect, subTries.Hashes[0])
+		return nil
+	}))
+
+	// The database itself is not modified
+	subTries, err := NewSubTrieLoader(0).LoadSubTries(db, 0, NewRetainList(0), [][]byte{nil}, []int{0}, false)
+	require.NoError(err)
+	assert.NotEqual(expect, subTries.Hashes[0])
+}
+
 func TestReturnErrOnWrongRootHash(t *testing.T) {
 	require, db := require.New(t), ethdb.NewMemDatabase()
 	putAccount := func(k string) {
@@ -444,6 +543,58 @@ func TestApiDetails(t *testing.T) {
 	}
 }
 
+func TestMissingWitnessLen(t *testing.T) {
+	debug.OverrideTrackWitnessSize(true)
+	defer debug.OverrideTrackWitnessSize(false)
+	require := require.New(t)
+	db := ethdb.NewMemDatabase()
+	defer db.Close()
+	for i := 0; i < 256; i++ {
+		a := accounts.Account{Nonce: uint64(i), Initialised: true, CodeHash: EmptyCodeHash, Balance: *uint256.NewInt()}
+		require.NoError(writeAccount(db, common.BytesToHash(crypto.Keccak256([]byte{byte(i)})), a))
+	}
+
+	// The hashes and the witness lengths of the branch nodes, by the keys of the intermediate hashes
+	hashes := make(map[string][]byte)
+	witnessLens := make(map[string]uint64)
+	load := func() (*FlatDbSubTrieLoader, common.Hash) {
+		loader := NewFlatDbSubTrieLoader()
+		loader.SetIntermediateHashObserver(func(prefix []byte, _ uint64, hash []byte, witnessLen uint64) {
+			if len(prefix) == 0 || len(prefix)%2 == 1 {
+				return
+			}
+			var key []byte
+			CompressNibbles(prefix, &key)
+			hashes[string(key)] = common.CopyBytes(hash)
+			witnessLens[string(key)] = witnessLen
+		})
+		require.NoError(loader.Reset(db, NewRetainList(0), [][]byte{nil}, []int{0}, false))
+		subTries, err := loader.LoadSubTries()
+		require.NoError(err)
+		return loader, subTries.Hashes[0]
+	}
+	_, root := load()
+	var missing []byte
+	for k, l := range witnessLens {
+		require.NoError(db.Put(dbutils.IntermediateTrieHashBucket, []byte(k), hashes[k]))
+		if len(k) == 1 && (missing == nil || bytes.Compare([]byte(k), missing) < 0) {
+			missing = []byte(k)
+		}
+		lenBytes := make([]byte, 8)
+		binary.BigEndian.PutUint64(lenBytes, l)
+		require.NoError(db.Put(dbutils.IntermediateTrieWitnessLenBucket, []byte(k), lenBytes))
+	}
+	require.NotNil(missing, "no branch nodes at the depth of 2 nibbles")
+	expected := witnessLens[string(missing)]
+	require.NoError(db.Delete(dbutils.IntermediateTrieWitnessLenBucket, missing))
+	delete(witnessLens, string(missing))
+
+	loader, recomputed := load()
+	require.Equal(root, recomputed)
+	require.Equal(1, loader.missingWitnessLens)
+	require.Equal(expected, witnessLens[string(missing)])
+}
+
 func hexf(format string, a ...interface{}) []byte {
 	return common.FromHex(fmt.Sprintf(format, a...))
 }
@@ -623,3 +774,193 @@ func writeAccount(db ethdb.Putter, addrHash common.Hash, acc accounts.Account) e
 	}
 	return nil
 }
+
+// flatDbRandTest is a random sequence of the account and storage mutations, which are applied both to the
+// trie and to the flat database. The roots and the witnesses of the trie are compared with the ones produced by
+// FlatDbSubTrieLoader at the check steps. Instances of this test are created by Generate
+type flatDbRandTest struct {
+	addrHashes []common.Hash
+	keyHashes  []common.Hash
+	steps      []flatDbRandTestStep
+}
+
+type flatDbRandTestStep struct {
+	op    int
+	addr  int    // index in addrHashes
+	key   int    // index in keyHashes, for fdbOpPutStorage, fdbOpDeleteStorage
+	value []byte // for fdbOpPutStorage, bitmasks of the retained accounts and storage items for fdbOpCheck
+	err   error  // for debugging
+}
+
+const (
+	fdbOpUpdateAccount = iota
+	fdbOpCreateContract
+	fdbOpDeleteAccount
+	fdbOpPutStorage
+	fdbOpDeleteStorage
+	fdbOpCheck
+	fdbOpMax // boundary value, not an actual op
+)
+
+func (flatDbRandTest) Generate(r *rand.Rand, size int) reflect.Value {
+	rt := flatDbRandTest{addrHashes: make([]common.Hash, 8), keyHashes: make([]common.Hash, 8)}
+	for i := range rt.addrHashes {
+		r.Read(rt.addrHashes[i][:])
+		if i%2 == 1 {
+			// Long common prefixes with the previous account
+			copy(rt.addrHashes[i][:], rt.addrHashes[i-1][:1+r.Intn(common.HashLength-1)])
+		}
+	}
+	for i := range rt.keyHashes {
+		r.Read(rt.keyHashes[i][:])
+	}
+	for i := 0; i < size; i++ {
+		step := flatDbRandTestStep{op: r.Intn(fdbOpMax), addr: r.Intn(len(rt.addrHashes)), key: r.Intn(len(rt.keyHashes))}
+		switch step.op {
+		case fdbOpPutStorage:
+			step.value = make([]byte, 1+r.Intn(common.HashLength))
+			r.Read(step.value)
+			step.value[0] |= 1 // The values are stored without the leading zeroes
+		case fdbOpCheck:
+			step.value = make([]byte, 2)
+			r.Read(step.value)
+		}
+		rt.steps = append(rt.steps, step)
+	}
+	// The final state is always checked
+	rt.steps = append(rt.steps, flatDbRandTestStep{op: fdbOpCheck, value: []byte{0xff, 0xff}})
+	return reflect.ValueOf(rt)
+}
+
+func runFlatDbRandTest(rt flatDbRandTest) bool {
+	db := ethdb.NewMemDatabase()
+	defer db.Close()
+	tr := New(common.Hash{})
+	accs := make(map[int]*accounts.Account)
+	incarnations := make(map[int]uint64) // The last incarnation of every account, kept after the deletion
+
+	for i, step := range rt.steps {
+		addrHash := rt.addrHashes[step.addr]
+		keyHash := rt.keyHashes[step.key]
+		acc, exists := accs[step.addr]
+		switch step.op {
+		case fdbOpUpdateAccount, fdbOpCreateContract:
+			if !exists {
+				a := accounts.NewAccount()
+				a.Initialised = true
+				acc = &a
+				accs[step.addr] = acc
+			}
+			acc.Nonce++
+			acc.Balance.SetUint64(uint64(i))
+			if step.op == fdbOpCreateContract {
+				// The storage of the previous incarnation is left in the database and has to be skipped by the loader
+				incarnations[step.addr]++
+				acc.Incarnation = incarnations[step.addr]
+				tr.DeleteSubtree(addrHash[:])
+			}
+			rt.steps[i].err = writeAccount(db, addrHash, *acc)
+			tr.UpdateAccount(addrHash[:], acc)
+		case fdbOpDeleteAccount:
+			if !exists {
+				continue
+			}
+			// The storage is abandoned in the database
+			delete(accs, step.addr)
+			rt.steps[i].err = db.Delete(dbutils.CurrentStateBucket, addrHash[:])
+			tr.Delete(addrHash[:])
+		case fdbOpPutStorage:
+			if !exists || acc.Incarnation == 0 {
+				continue
+			}
+			rt.steps[i].err = db.Put(dbutils.CurrentStateBucket, dbutils.GenerateCompositeStorageKey(addrHash, acc.Incarnation, keyHash), step.value)
+			tr.Update(dbutils.GenerateCompositeTrieKey(addrHash, keyHash), step.value)
+		case fdbOpDeleteStorage:
+			if !exists || acc.Incarnation == 0 {
+				continue
+			}
+			rt.steps[i].err = db.Delete(dbutils.CurrentStateBucket, dbutils.GenerateCompositeStorageKey(addrHash, acc.Incarnation, keyHash))
+			tr.Delete(dbutils.GenerateCompositeTrieKey(addrHash, keyHash))
+		case fdbOpCheck:
+			rt.steps[i].err = checkFlatDbLoader(db, tr, rt, step.value)
+		}
+		// Abort the test on error.
+		if rt.steps[i].err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// checkFlatDbLoader compares the root and the retained values of the trie with the ones produced by the loader,
+// the bits of the masks select the retained accounts and storage items
+func checkFlatDbLoader(db ethdb.Database, tr *Trie, rt flatDbRandTest, masks []byte) error {
+	rl := NewRetainList(0)
+	for i, addrHash := range rt.addrHashes {
+		if masks[0]&(1<<uint(i)) != 0 {
+			rl.AddKey(addrHash[:])
+		}
+		if masks[1]&(1<<uint(i)) != 0 {
+			rl.AddKey(dbutils.GenerateCompositeTrieKey(addrHash, rt.keyHashes[i]))
+		}
+	}
+	loader := NewFlatDbSubTrieLoader()
+	if err := loader.Reset(db, rl, [][]byte{nil}, []int{0}, false); err != nil {
+		return err
+	}
+	subTries, err := loader.LoadSubTries()
+	if err != nil {
+		return err
+	}
+	root := tr.Hash()
+	if subTries.Hashes[0] != root {
+		return fmt.Errorf("root mismatch, trie %x, loader %x", root, subTries.Hashes[0])
+	}
+	if root == EmptyRoot {
+		return nil
+	}
+	loaded := New(root)
+	if err = loaded.HookSubTries(subTries, [][]byte{nil}); err != nil {
+		return err
+	}
+	// The witness of the full trie can not be compared with the one of the loaded trie: the storage trie made of
+	// a single leaf goes into the witness, even if the account is not retained, while the loader hashes it
+	for i, addrHash := range rt.addrHashes {
+		if masks[0]&(1<<uint(i)) != 0 {
+			expected, _ := tr.GetAccount(addrHash[:])
+			actual, ok := loaded.GetAccount(addrHash[:])
+			if !ok || (expected == nil) != (actual == nil) || (expected != nil && !expected.Equals(actual)) {
+				return fmt.Errorf("account %x mismatch, trie %v, loader %v (%t)", addrHash, expected, actual, ok)
+			}
+		}
+		if masks[1]&(1<<uint(i)) != 0 {
+			key := dbutils.GenerateCompositeTrieKey(addrHash, rt.keyHashes[i])
+			expected, _ := tr.Get(key)
+			actual, ok := loaded.Get(key)
+			if !ok || !bytes.Equal(expected, actual) {
+				return fmt.Errorf("storage %x mismatch, trie %x, loader %x (%t)", key, expected, actual, ok)
+			}
+		}
+	}
+	rl.Rewind()
+	if _, err = loaded.ExtractWitness(false, rl); err != nil {
+		return fmt.Errorf("witness of the loaded trie for root %x: %w", root, err)
+	}
+	return nil
+}
+
+func TestFlatDbLoaderRandom(t *testing.T) {
+	// The fixed seed keeps the failures reproducible
+	config := &quick.Config{MaxCount: 100, Rand: rand.New(rand.NewSource(1))}
+	if err := quick.Check(runFlatDbRandTest, config); err != nil {
+		if cerr, ok := err.(*quick.CheckError); ok {
+			rt := cerr.In[0].(flatDbRandTest)
+			for i, step := range rt.steps {
+				if step.err != nil {
+					t.Fatalf("random test iteration %d failed at step %d (op %d): %v", cerr.Count, i, step.op, step.err)
+				}
+			}
+		}
+		t.Fatal(err)
+	}
+}
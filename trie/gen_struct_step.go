@@ -40,6 +40,11 @@ type structInfoReceiver interface {
 	hash(hash []byte, dataLen uint64) error
 }
 
+// branchHashObserver is implemented by the receivers, which need to know the prefixes of the hashed branch nodes
+type branchHashObserver interface {
+	branchHashed(prefix []byte)
+}
+
 func calcPrecLen(groups []uint16) int {
 	if len(groups) == 0 {
 		return 0
@@ -189,6 +194,9 @@ func GenStructStep(
 				if err := e.branchHash(groups[maxLen]); err != nil {
 					return nil, err
 				}
+				if o, ok := e.(branchHashObserver); ok {
+					o.branchHashed(curr[:maxLen])
+				}
 			}
 		}
 		groups = groups[:maxLen]
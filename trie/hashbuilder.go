@@ -31,12 +31,16 @@ type HashBuilder struct {
 	acc          accounts.Account      // Working account instance (to avoid extra allocations)
 	sha          keccakState           // Keccak primitive that can absorb data (Write), and get squeezed to the hash out (Read)
 	hashBuf      [hashStackStride]byte // RLP representation of hash (or un-hashes value)
-	keyPrefix    [1]byte
+	keyPrefix    [2]byte
 	lenPrefix    [4]byte
 	valBuf       [128]byte // Enough to accomodate hash encoding of any account
 	b            [1]byte   // Buffer for single byte
 	prefixBuf    [8]byte
-	trace        bool // Set to true when HashBuilder is required to print trace information for diagnostics
+	trace        bool              // Set to true when HashBuilder is required to print trace information for diagnostics
+	tracer       HashBuilderTracer // See SetTracer
+	traceSeq     uint64            // Sequence number of the last traced opcode
+
+	branchHashObserver func(prefix []byte, hash []byte, witnessLen uint64) // See SetBranchHashObserver
 }
 
 // NewHashBuilder creates a new HashBuilder
@@ -62,9 +66,6 @@ func (hb *HashBuilder) Reset() {
 }
 
 func (hb *HashBuilder) leaf(length int, keyHex []byte, val rlphacks.RlpSerializable) error {
-	if hb.trace {
-		fmt.Printf("LEAF %d\n", length)
-	}
 	if length < 0 {
 		return fmt.Errorf("length %d", length)
 	}
@@ -81,9 +82,8 @@ func (hb *HashBuilder) leaf(length int, keyHex []byte, val rlphacks.RlpSerializa
 		copy(s.ref.data[:], hb.hashStack[len(hb.hashStack)-common.HashLength-1:])
 	}
 	s.witnessLength = hb.dataLenStack[len(hb.dataLenStack)-1]
-	if hb.trace {
-		fmt.Printf("Stack depth: %d, %d\n", len(hb.nodeStack), len(hb.dataLenStack))
-
+	if hb.tracing() {
+		hb.traceLeaf("LEAF", length, key, val.RawBytes())
 	}
 	return nil
 }
@@ -112,8 +112,7 @@ func (hb *HashBuilder) leafHashWithKeyVal(key []byte, val rlphacks.RlpSerializab
 		}
 	}
 	if compactLen > 1 {
-		hb.keyPrefix[0] = 0x80 + byte(compactLen)
-		kp = 1
+		kp = hb.setKeyPrefix(compactLen)
 		kl = compactLen
 	} else {
 		kl = 1
@@ -132,6 +131,18 @@ func (hb *HashBuilder) leafHashWithKeyVal(key []byte, val rlphacks.RlpSerializab
 	return nil
 }
 
+// setKeyPrefix writes the RLP prefix of the compact key into keyPrefix and returns its length.
+// The keys of the binary trie are longer than 55 bytes and need the long string prefix
+func (hb *HashBuilder) setKeyPrefix(compactLen int) int {
+	if compactLen > 55 {
+		hb.keyPrefix[0] = 0xb8
+		hb.keyPrefix[1] = byte(compactLen)
+		return 2
+	}
+	hb.keyPrefix[0] = 0x80 + byte(compactLen)
+	return 1
+}
+
 func (hb *HashBuilder) completeLeafHash(kp, kl, compactLen int, key []byte, compact0 byte, ni int, val rlphacks.RlpSerializable) error {
 	totalLen := kp + kl + val.DoubleRLPLen()
 	pt := rlphacks.GenerateStructLen(hb.lenPrefix[:], totalLen)
@@ -182,20 +193,20 @@ func (hb *HashBuilder) completeLeafHash(kp, kl, compactLen int, key []byte, comp
 }
 
 func (hb *HashBuilder) leafHash(length int, keyHex []byte, val rlphacks.RlpSerializable) error {
-	if hb.trace {
-		fmt.Printf("LEAFHASH %d\n", length)
-	}
 	if length < 0 {
 		return fmt.Errorf("length %d", length)
 	}
 	key := keyHex[len(keyHex)-length:]
-	return hb.leafHashWithKeyVal(key, val)
+	if err := hb.leafHashWithKeyVal(key, val); err != nil {
+		return err
+	}
+	if hb.tracing() {
+		hb.traceLeaf("LEAFHASH", length, key, val.RawBytes())
+	}
+	return nil
 }
 
 func (hb *HashBuilder) accountLeaf(length int, keyHex []byte, balance *uint256.Int, nonce uint64, incarnation uint64, fieldSet uint32) (err error) {
-	if hb.trace {
-		fmt.Printf("ACCOUNTLEAF %d (%b)\n", length, fieldSet)
-	}
 	key := keyHex[len(keyHex)-length:]
 	copy(hb.acc.Root[:], EmptyRoot[:])
 	copy(hb.acc.CodeHash[:], EmptyCodeHash[:])
@@ -254,17 +265,13 @@ func (hb *HashBuilder) accountLeaf(length int, keyHex []byte, balance *uint256.I
 	s.witnessLength = hb.dataLenStack[len(hb.dataLenStack)-1]
 	// Replace top of the stack
 	hb.nodeStack[len(hb.nodeStack)-1] = s
-	if hb.trace {
-		fmt.Printf("Stack depth: %d, %d\n", len(hb.nodeStack), len(hb.dataLenStack))
-
+	if hb.tracing() {
+		hb.traceAccountLeaf("ACCOUNTLEAF", length, key, balance, nonce, incarnation, fieldSet)
 	}
 	return nil
 }
 
 func (hb *HashBuilder) accountLeafHash(length int, keyHex []byte, balance *uint256.Int, nonce uint64, incarnation uint64, fieldSet uint32) (err error) {
-	if hb.trace {
-		fmt.Printf("ACCOUNTLEAFHASH %d (%b)\n", length, fieldSet)
-	}
 	key := keyHex[len(keyHex)-length:]
 	hb.acc.Nonce = nonce
 	hb.acc.Balance.Set(balance)
@@ -286,7 +293,13 @@ func (hb *HashBuilder) accountLeafHash(length int, keyHex []byte, balance *uint2
 		copy(hb.acc.CodeHash[:], EmptyCodeHash[:])
 	}
 
-	return hb.accountLeafHashWithKey(key, popped)
+	if err = hb.accountLeafHashWithKey(key, popped); err != nil {
+		return err
+	}
+	if hb.tracing() {
+		hb.traceAccountLeaf("ACCOUNTLEAFHASH", length, key, balance, nonce, incarnation, fieldSet)
+	}
+	return nil
 }
 
 // To be called internally
@@ -313,8 +326,7 @@ func (hb *HashBuilder) accountLeafHashWithKey(key []byte, popped int) error {
 		}
 	}
 	if compactLen > 1 {
-		hb.keyPrefix[0] = byte(128 + compactLen)
-		kp = 1
+		kp = hb.setKeyPrefix(compactLen)
 		kl = compactLen
 	} else {
 		kl = 1
@@ -341,16 +353,10 @@ func (hb *HashBuilder) accountLeafHashWithKey(key []byte, popped int) error {
 	hb.hashStack = append(hb.hashStack, hb.hashBuf[:]...)
 	hb.nodeStack = append(hb.nodeStack, nil)
 	hb.dataLenStack = append(hb.dataLenStack, dataLen)
-	if hb.trace {
-		fmt.Printf("Stack depth: %d, %d\n", len(hb.nodeStack), len(hb.dataLenStack))
-	}
 	return nil
 }
 
 func (hb *HashBuilder) extension(key []byte) error {
-	if hb.trace {
-		fmt.Printf("EXTENSION %x\n", key)
-	}
 	nd := hb.nodeStack[len(hb.nodeStack)-1]
 	var s *shortNode
 	switch n := nd.(type) {
@@ -364,22 +370,30 @@ func (hb *HashBuilder) extension(key []byte) error {
 		return fmt.Errorf("wrong Val type for an extension: %T", nd)
 	}
 	hb.nodeStack[len(hb.nodeStack)-1] = s
-	if err := hb.extensionHash(key); err != nil {
+	if err := hb.hashExtension(key); err != nil {
 		return err
 	}
 	copy(s.ref.data[:], hb.hashStack[len(hb.hashStack)-common.HashLength:])
 	s.ref.len = 32
 	s.witnessLength = hb.dataLenStack[len(hb.dataLenStack)-1]
-	if hb.trace {
-		fmt.Printf("Stack depth: %d, %d\n", len(hb.nodeStack), len(hb.dataLenStack))
+	if hb.tracing() {
+		hb.traceOpcode(&OpcodeTrace{Op: "EXTENSION", Key: common.CopyBytes(key)})
 	}
 	return nil
 }
 
 func (hb *HashBuilder) extensionHash(key []byte) error {
-	if hb.trace {
-		fmt.Printf("EXTENSIONHASH %x\n", key)
+	if err := hb.hashExtension(key); err != nil {
+		return err
+	}
+	if hb.tracing() {
+		hb.traceOpcode(&OpcodeTrace{Op: "EXTENSIONHASH", Key: common.CopyBytes(key)})
 	}
+	return nil
+}
+
+// hashExtension replaces the hash on the top of the stack with the hash of the extension node, to be called internally
+func (hb *HashBuilder) hashExtension(key []byte) error {
 	branchHash := hb.hashStack[len(hb.hashStack)-hashStackStride:]
 	// Compute the total length of binary representation
 	var kp, kl int
@@ -404,8 +418,7 @@ func (hb *HashBuilder) extensionHash(key []byte) error {
 		}
 	}
 	if compactLen > 1 {
-		hb.keyPrefix[0] = 0x80 + byte(compactLen)
-		kp = 1
+		kp = hb.setKeyPrefix(compactLen)
 		kl = compactLen
 	} else {
 		kl = 1
@@ -446,12 +459,6 @@ func (hb *HashBuilder) extensionHash(key []byte) error {
 }
 
 func (hb *HashBuilder) branch(set uint16) error {
-	if hb.trace {
-		fmt.Printf("BRANCH (%b)\n", set)
-	}
-	if hb.trace {
-		fmt.Printf("Stack depth: %d, %d\n", len(hb.nodeStack), len(hb.dataLenStack))
-	}
 	f := &fullNode{}
 	digits := bits.OnesCount16(set)
 	if len(hb.nodeStack) < digits {
@@ -473,23 +480,30 @@ func (hb *HashBuilder) branch(set uint16) error {
 	}
 	hb.nodeStack = hb.nodeStack[:len(hb.nodeStack)-digits+1]
 	hb.nodeStack[len(hb.nodeStack)-1] = f
-	if err := hb.branchHash(set); err != nil {
+	if err := hb.hashBranch(set); err != nil {
 		return err
 	}
 	copy(f.ref.data[:], hb.hashStack[len(hb.hashStack)-common.HashLength:])
 	f.ref.len = 32
 	f.witnessLength = hb.dataLenStack[len(hb.dataLenStack)-1]
-	if hb.trace {
-		fmt.Printf("Stack depth: %d, %d\n", len(hb.nodeStack), len(hb.dataLenStack))
+	if hb.tracing() {
+		hb.traceOpcode(&OpcodeTrace{Op: "BRANCH", Set: set})
 	}
-
 	return nil
 }
 
 func (hb *HashBuilder) branchHash(set uint16) error {
-	if hb.trace {
-		fmt.Printf("BRANCHHASH (%b)\n", set)
+	if err := hb.hashBranch(set); err != nil {
+		return err
+	}
+	if hb.tracing() {
+		hb.traceOpcode(&OpcodeTrace{Op: "BRANCHHASH", Set: set})
 	}
+	return nil
+}
+
+// hashBranch replaces the hashes of the children on the top of the stack with the hash of the branch node, to be called internally
+func (hb *HashBuilder) hashBranch(set uint16) error {
 	digits := bits.OnesCount16(set)
 	if len(hb.hashStack) < hashStackStride*digits {
 		return fmt.Errorf("len(hb.hashStack) %d < hashStackStride*digits %d", len(hb.hashStack), hashStackStride*digits)
@@ -552,35 +566,37 @@ func (hb *HashBuilder) branchHash(set uint16) error {
 	if hashStackStride*len(hb.nodeStack) > len(hb.hashStack) {
 		hb.nodeStack = hb.nodeStack[:len(hb.nodeStack)-digits+1]
 		hb.nodeStack[len(hb.nodeStack)-1] = nil
-		if hb.trace {
-			fmt.Printf("Setting hb.nodeStack[%d] to nil\n", len(hb.nodeStack)-1)
-		}
-	}
-	if hb.trace {
-		fmt.Printf("Stack depth: %d, %d\n", len(hb.nodeStack), len(hb.dataLenStack))
 	}
 	return nil
 }
 
-func (hb *HashBuilder) hash(hash []byte, dataLen uint64) error {
-	if hb.trace {
-		fmt.Printf("HASH %d\n", dataLen)
+// SetBranchHashObserver sets the function, which is called with the prefix (in nibbles, as seen by GenStructStep),
+// the hash and the witness length of every branch node hashed by BRANCHHASH opcode. It is used to collect
+// the intermediate hashes, the slices are only valid during the call
+func (hb *HashBuilder) SetBranchHashObserver(f func(prefix []byte, hash []byte, witnessLen uint64)) {
+	hb.branchHashObserver = f
+}
+
+// branchHashed reports the branch node on the top of the stack, which has just been hashed, to the observer
+func (hb *HashBuilder) branchHashed(prefix []byte) {
+	if hb.branchHashObserver == nil {
+		return
 	}
+	hb.branchHashObserver(prefix, hb.hashStack[len(hb.hashStack)-common.HashLength:], hb.dataLenStack[len(hb.dataLenStack)-1])
+}
+
+func (hb *HashBuilder) hash(hash []byte, dataLen uint64) error {
 	hb.hashStack = append(hb.hashStack, 0x80+common.HashLength)
 	hb.hashStack = append(hb.hashStack, hash...)
 	hb.nodeStack = append(hb.nodeStack, nil)
 	hb.dataLenStack = append(hb.dataLenStack, dataLen) // count only data nodes, not hash nodes. so, no opcode added.
-	if hb.trace {
-		fmt.Printf("Stack depth: %d, %d\n", len(hb.nodeStack), len(hb.dataLenStack))
+	if hb.tracing() {
+		hb.traceOpcode(&OpcodeTrace{Op: "HASH", DataLen: dataLen})
 	}
-
 	return nil
 }
 
 func (hb *HashBuilder) code(code []byte) error {
-	if hb.trace {
-		fmt.Printf("CODE\n")
-	}
 	codeCopy := common.CopyBytes(code)
 	n := codeNode(codeCopy)
 	hb.nodeStack = append(hb.nodeStack, n)
@@ -595,19 +611,22 @@ func (hb *HashBuilder) code(code []byte) error {
 		return err
 	}
 	hb.hashStack = append(hb.hashStack, hash[:]...)
+	if hb.tracing() {
+		hb.traceOpcode(&OpcodeTrace{Op: "CODE", Value: codeCopy})
+	}
 	return nil
 }
 
 func (hb *HashBuilder) emptyRoot() {
-	if hb.trace {
-		fmt.Printf("EMPTYROOT\n")
-	}
 	hb.nodeStack = append(hb.nodeStack, nil)
 	hb.dataLenStack = append(hb.dataLenStack, 0)
 	var hash [hashStackStride]byte // RLP representation of hash (or un-hashes value)
 	hash[0] = 0x80 + common.HashLength
 	copy(hash[1:], EmptyRoot[:])
 	hb.hashStack = append(hb.hashStack, hash[:]...)
+	if hb.tracing() {
+		hb.traceOpcode(&OpcodeTrace{Op: "EMPTYROOT"})
+	}
 }
 
 func (hb *HashBuilder) RootHash() (common.Hash, error) {
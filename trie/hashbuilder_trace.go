@@ -0,0 +1,224 @@
+package trie
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/holiman/uint256"
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/common/hexutil"
+)
+
+// OpcodeTrace is the record of one opcode executed by HashBuilder: its operands, the item on the top
+// of the hash stack and the depths of the stacks after the execution. Two runs over the same state
+// produce the same sequences of the records, so the first differing record (see DiffTraces)
+// points at the cause of a root mismatch
+type OpcodeTrace struct {
+	Seq          uint64        `json:"seq"`
+	Op           string        `json:"op"`
+	Length       int           `json:"length,omitempty"`
+	Key          hexutil.Bytes `json:"key,omitempty"`
+	Value        hexutil.Bytes `json:"value,omitempty"`
+	Set          uint16        `json:"set,omitempty"`
+	FieldSet     uint32        `json:"fieldSet,omitempty"`
+	Balance      string        `json:"balance,omitempty"`
+	Nonce        uint64        `json:"nonce,omitempty"`
+	Incarnation  uint64        `json:"incarnation,omitempty"`
+	DataLen      uint64        `json:"dataLen,omitempty"`
+	Top          hexutil.Bytes `json:"top,omitempty"` // RLP of the hash (or of the embedded node) on the top of the hash stack
+	NodeStack    int           `json:"nodeStack"`
+	HashStack    int           `json:"hashStack"`
+	DataLenStack int           `json:"dataLenStack"`
+}
+
+func (t *OpcodeTrace) String() string {
+	var sb bytes.Buffer
+	fmt.Fprintf(&sb, "%d %s", t.Seq, t.Op)
+	switch t.Op {
+	case "LEAF", "LEAFHASH":
+		fmt.Fprintf(&sb, " %d key=%x value=%x", t.Length, t.Key, t.Value)
+	case "ACCOUNTLEAF", "ACCOUNTLEAFHASH":
+		fmt.Fprintf(&sb, " %d (%b) key=%x balance=%s nonce=%d incarnation=%d", t.Length, t.FieldSet, t.Key, t.Balance, t.Nonce, t.Incarnation)
+	case "EXTENSION", "EXTENSIONHASH":
+		fmt.Fprintf(&sb, " %x", t.Key)
+	case "BRANCH", "BRANCHHASH":
+		fmt.Fprintf(&sb, " (%b)", t.Set)
+	case "HASH":
+		fmt.Fprintf(&sb, " %d", t.DataLen)
+	}
+	fmt.Fprintf(&sb, " top=%x stack depth: %d, %d, %d", t.Top, t.NodeStack, t.HashStack, t.DataLenStack)
+	return sb.String()
+}
+
+// HashBuilderTracer receives the records of the opcodes executed by HashBuilder, see SetTracer.
+// The record is only valid during the call
+type HashBuilderTracer interface {
+	TraceOpcode(t *OpcodeTrace)
+}
+
+// JSONTracer writes the records of the opcodes as JSON lines, which can be compared by DiffTraces
+type JSONTracer struct {
+	enc *json.Encoder
+	err error
+}
+
+// NewJSONTracer creates the tracer writing into w
+func NewJSONTracer(w io.Writer) *JSONTracer {
+	return &JSONTracer{enc: json.NewEncoder(w)}
+}
+
+func (jt *JSONTracer) TraceOpcode(t *OpcodeTrace) {
+	if jt.err != nil {
+		return
+	}
+	jt.err = jt.enc.Encode(t)
+}
+
+// Err returns the first error of writing the records, the records after it are dropped
+func (jt *JSONTracer) Err() error {
+	return jt.err
+}
+
+// SetTracer sets the tracer receiving every opcode executed by HashBuilder, nil disables the tracing.
+// It works independently of the trace flag, which prints the same records to the standard output
+func (hb *HashBuilder) SetTracer(t HashBuilderTracer) {
+	hb.tracer = t
+}
+
+// tracing tells whether the records of the opcodes need to be built
+func (hb *HashBuilder) tracing() bool {
+	return hb.trace || hb.tracer != nil
+}
+
+// traceOpcode completes the record with the state of the stacks and passes it on. The sequence
+// number is not reset by Reset, so the records of the sub-tries loaded by one builder do not repeat
+func (hb *HashBuilder) traceOpcode(t *OpcodeTrace) {
+	hb.traceSeq++
+	t.Seq = hb.traceSeq
+	if len(hb.hashStack) >= hashStackStride {
+		top := hb.hashStack[len(hb.hashStack)-hashStackStride:]
+		if top[0] == 0x80+common.HashLength {
+			t.Top = common.CopyBytes(top)
+		} else {
+			// Embedded node
+			t.Top = common.CopyBytes(top[:int(top[0])-0xc0+1])
+		}
+	}
+	t.NodeStack = len(hb.nodeStack)
+	t.HashStack = len(hb.hashStack) / hashStackStride
+	t.DataLenStack = len(hb.dataLenStack)
+	if hb.trace {
+		fmt.Println(t)
+	}
+	if hb.tracer != nil {
+		hb.tracer.TraceOpcode(t)
+	}
+}
+
+func (hb *HashBuilder) traceLeaf(op string, length int, key []byte, val []byte) {
+	hb.traceOpcode(&OpcodeTrace{Op: op, Length: length, Key: common.CopyBytes(key), Value: common.CopyBytes(val)})
+}
+
+func (hb *HashBuilder) traceAccountLeaf(op string, length int, key []byte, balance *uint256.Int, nonce uint64, incarnation uint64, fieldSet uint32) {
+	hb.traceOpcode(&OpcodeTrace{
+		Op:          op,
+		Length:      length,
+		Key:         common.CopyBytes(key),
+		FieldSet:    fieldSet,
+		Balance:     balance.ToBig().String(),
+		Nonce:       nonce,
+		Incarnation: incarnation,
+	})
+}
+
+// TraceDivergence is the first pair of the differing records of two traces
+type TraceDivergence struct {
+	Line   int            // Line of the records in both traces, starting from 1
+	A, B   *OpcodeTrace   // Differing records, one of them is nil when its trace is shorter
+	Common []*OpcodeTrace // Records preceding the divergence, which are the same in both traces
+}
+
+func (d *TraceDivergence) String() string {
+	var sb bytes.Buffer
+	for _, t := range d.Common {
+		fmt.Fprintf(&sb, "  %s\n", t)
+	}
+	fmt.Fprintf(&sb, "divergence at line %d:\n", d.Line)
+	for _, r := range []struct {
+		name string
+		t    *OpcodeTrace
+	}{{"a", d.A}, {"b", d.B}} {
+		if r.t == nil {
+			fmt.Fprintf(&sb, "%s: end of trace\n", r.name)
+		} else {
+			fmt.Fprintf(&sb, "%s: %s\n", r.name, r.t)
+		}
+	}
+	return sb.String()
+}
+
+// DiffTraces compares two traces written by JSONTracer record by record and returns the first
+// divergence with up to contextLines preceding records, or nil when the traces are the same.
+// The sequence numbers are ignored, so the traces may start in the middle of the runs
+func DiffTraces(a, b io.Reader, contextLines int) (*TraceDivergence, error) {
+	sa, sb := newTraceScanner(a), newTraceScanner(b)
+	var preceding []*OpcodeTrace
+	for line := 1; ; line++ {
+		ta, err := sa.next()
+		if err != nil {
+			return nil, fmt.Errorf("trace a, line %d: %w", line, err)
+		}
+		tb, err := sb.next()
+		if err != nil {
+			return nil, fmt.Errorf("trace b, line %d: %w", line, err)
+		}
+		if ta == nil && tb == nil {
+			return nil, nil
+		}
+		if ta == nil || tb == nil || !sameOpcodeTrace(ta, tb) {
+			return &TraceDivergence{Line: line, A: ta, B: tb, Common: preceding}, nil
+		}
+		if contextLines > 0 {
+			if len(preceding) == contextLines {
+				preceding = preceding[1:]
+			}
+			preceding = append(preceding, ta)
+		}
+	}
+}
+
+func sameOpcodeTrace(a, b *OpcodeTrace) bool {
+	aa, bb := *a, *b
+	aa.Seq, bb.Seq = 0, 0
+	ea, _ := json.Marshal(&aa)
+	eb, _ := json.Marshal(&bb)
+	return bytes.Equal(ea, eb)
+}
+
+type traceScanner struct {
+	s *bufio.Scanner
+}
+
+func newTraceScanner(r io.Reader) *traceScanner {
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 64*1024), 16*1024*1024) // Leaves carry the values
+	return &traceScanner{s: s}
+}
+
+// next returns the next record, or nil at the end of the trace
+func (ts *traceScanner) next() (*OpcodeTrace, error) {
+	for ts.s.Scan() {
+		if len(bytes.TrimSpace(ts.s.Bytes())) == 0 {
+			continue
+		}
+		var t OpcodeTrace
+		if err := json.Unmarshal(ts.s.Bytes(), &t); err != nil {
+			return nil, err
+		}
+		return &t, nil
+	}
+	return nil, ts.s.Err()
+}
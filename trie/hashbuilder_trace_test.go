@@ -0,0 +1,82 @@
+package trie
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/trie/rlphacks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// traceHashBuilder builds the trie of the sorted keys with the values and returns its root with the trace
+func traceHashBuilder(t *testing.T, keys [][]byte, values [][]byte) (common.Hash, []byte) {
+	var trace bytes.Buffer
+	tracer := NewJSONTracer(&trace)
+	hb := NewHashBuilder(false)
+	hb.SetTracer(tracer)
+	var succ, curr bytes.Buffer
+	var groups []uint16
+	var err error
+	for i := 0; i <= len(keys); i++ {
+		curr.Reset()
+		curr.Write(succ.Bytes())
+		succ.Reset()
+		if i < len(keys) {
+			for _, b := range keys[i] {
+				succ.WriteByte(b / 16)
+				succ.WriteByte(b % 16)
+			}
+			succ.WriteByte(16)
+		}
+		if curr.Len() > 0 {
+			groups, err = GenStructStep(func(_ []byte) bool { return true }, curr.Bytes(), succ.Bytes(), hb, &GenStructStepLeafData{rlphacks.RlpSerializableBytes(values[i-1])}, groups, false)
+			require.NoError(t, err)
+		}
+	}
+	require.NoError(t, tracer.Err())
+	return hb.rootHash(), trace.Bytes()
+}
+
+func TestHashBuilderTrace(t *testing.T) {
+	var keys, values [][]byte
+	tr := New(common.Hash{})
+	for i := uint32(0); i < 100; i++ {
+		key := make([]byte, 4)
+		binary.BigEndian.PutUint32(key, i*0x01010101)
+		keys = append(keys, key)
+		values = append(values, []byte{byte(i), 0x01})
+		tr.Update(key, values[i])
+	}
+	root, trace := traceHashBuilder(t, keys, values)
+	assert.Equal(t, tr.Hash(), root)
+	assert.Equal(t, 100, bytes.Count(trace, []byte(`"op":"LEAF"`)))
+
+	// The same state gives the same trace
+	_, trace2 := traceHashBuilder(t, keys, values)
+	d, err := DiffTraces(bytes.NewReader(trace), bytes.NewReader(trace2), 3)
+	require.NoError(t, err)
+	assert.Nil(t, d)
+
+	// The trace with one value changed diverges at its leaf
+	values[50] = []byte{0xff}
+	_, trace3 := traceHashBuilder(t, keys, values)
+	d, err = DiffTraces(bytes.NewReader(trace), bytes.NewReader(trace3), 3)
+	require.NoError(t, err)
+	require.NotNil(t, d)
+	assert.Equal(t, "LEAF", d.A.Op)
+	assert.Equal(t, []byte{50, 0x01}, []byte(d.A.Value))
+	assert.Equal(t, []byte{0xff}, []byte(d.B.Value))
+	assert.Equal(t, 3, len(d.Common))
+
+	// The shorter trace ends at the divergence
+	lines := bytes.SplitAfter(trace, []byte("\n"))
+	d, err = DiffTraces(bytes.NewReader(trace), bytes.NewReader(bytes.Join(lines[:10], nil)), 0)
+	require.NoError(t, err)
+	require.NotNil(t, d)
+	assert.Equal(t, 11, d.Line)
+	assert.NotNil(t, d.A)
+	assert.Nil(t, d.B)
+}
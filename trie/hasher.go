@@ -22,6 +22,7 @@ import (
 	"hash"
 
 	"golang.org/x/crypto/sha3"
+	"golang.org/x/sync/errgroup"
 
 	"github.com/ledgerwatch/turbo-geth/common"
 	"github.com/ledgerwatch/turbo-geth/common/pool"
@@ -36,10 +37,15 @@ type hasher struct {
 	prefixBuf            [8]byte
 	bw                   *ByteArrayWriter
 	callback             func(common.Hash, node)
+	workers              int // Number of the goroutines hashing the children of a branch concurrently, 0 means serial hashing
 }
 
 const rlpPrefixLength = 4
 
+// minParallelChildren is the number of the children of a full node, which have to be hashed (and are not leaves),
+// from which they are hashed concurrently. Smaller branches are not worth the goroutines
+const minParallelChildren = 4
+
 // keccakState wraps sha3.state. In addition to the usual hash methods, it also supports
 // Read to get a variable amount of data from the hash state. Read is faster than Sum
 // because it doesn't copy the internal state, but also modifies the internal state.
@@ -67,6 +73,7 @@ func newHasher(valueNodesRlpEncoded bool) *hasher {
 
 func returnHasherToPool(h *hasher) {
 	h.callback = nil
+	h.workers = 0
 	select {
 	case hasherPool <- h:
 	default:
@@ -230,6 +237,11 @@ func (h *hasher) hashChildren(original node, bufOffset int) ([]byte, error) {
 		return writeRlpPrefix(buffer, pos), nil
 
 	case *fullNode:
+		if h.workers > 1 {
+			if err := h.hashChildrenParallel(n.Children[:16]); err != nil {
+				return nil, err
+			}
+		}
 		// Hash the full node's children, caching the newly hashed subtrees
 		for _, child := range n.Children[:16] {
 			written, err := h.hashChild(child, buffer, pos, bufOffset)
@@ -289,6 +301,51 @@ func (h *hasher) hashChildren(original node, bufOffset int) ([]byte, error) {
 	return nil, nil
 }
 
+// hashChildrenParallel computes and caches the references of the large children of a full node, each one
+// with its own hasher taken from the pool. The subtries of the children do not share nodes, so they
+// are hashed without locking. The children are hashed serially (with the same results) inside of the workers
+func (h *hasher) hashChildrenParallel(children []node) error {
+	var large []node
+	for _, child := range children {
+		if isLargeChild(child) {
+			large = append(large, child)
+		}
+	}
+	if len(large) < minParallelChildren {
+		return nil
+	}
+	var g errgroup.Group
+	sem := make(chan struct{}, h.workers)
+	for _, child := range large {
+		child := child
+		sem <- struct{}{}
+		g.Go(func() error {
+			defer func() { <-sem }()
+			ch := newHasher(h.valueNodesRlpEncoded)
+			defer returnHasherToPool(ch)
+			var ref [common.HashLength]byte
+			_, err := ch.hash(child, false, ref[:])
+			return err
+		})
+	}
+	return g.Wait()
+}
+
+// isLargeChild tells whether the child is a subtrie (not a leaf), which is not hashed yet
+func isLargeChild(n node) bool {
+	switch n := n.(type) {
+	case *fullNode, *duoNode:
+		return len(n.reference()) == 0
+	case *shortNode:
+		if _, ok := n.Val.(valueNode); ok {
+			return false
+		}
+		return len(n.reference()) == 0
+	default:
+		return false
+	}
+}
+
 func (h *hasher) valueNodeToBuffer(vn valueNode, buffer []byte, pos int) (int, error) {
 	h.bw.Setup(buffer, pos)
 
@@ -1,9 +1,13 @@
 package trie
 
 import (
+	"fmt"
+	"runtime"
 	"testing"
 
 	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/core/types/accounts"
+	"github.com/ledgerwatch/turbo-geth/crypto"
 )
 
 func TestValue(t *testing.T) {
@@ -17,3 +21,68 @@ func TestValue(t *testing.T) {
 		t.Errorf("Expected %s, got %s", expected, common.ToHex(hn[:]))
 	}
 }
+
+// newHashingTestTrie creates the trie of accounts, some of which have storage
+func newHashingTestTrie(accountsNum int) *Trie {
+	tr := New(EmptyRoot)
+	for i := 0; i < accountsNum; i++ {
+		addrHash := crypto.Keccak256Hash([]byte(fmt.Sprintf("account-%d", i)))
+		acc := accounts.NewAccount()
+		acc.Initialised = true
+		acc.Nonce = uint64(i)
+		acc.Balance.SetUint64(uint64(i + 1))
+		tr.UpdateAccount(addrHash[:], &acc)
+		if i%50 != 0 {
+			continue
+		}
+		for j := 0; j < 100; j++ {
+			keyHash := crypto.Keccak256Hash([]byte(fmt.Sprintf("location-%d", j)))
+			tr.Update(GenerateCompositeTrieKey(addrHash, keyHash), []byte{byte(j + 1)})
+		}
+	}
+	return tr
+}
+
+func TestParallelHashing(t *testing.T) {
+	serial := newHashingTestTrie(5000)
+	parallel := newHashingTestTrie(5000)
+	parallel.SetHashingWorkers(4)
+	if s, p := serial.Hash(), parallel.Hash(); s != p {
+		t.Fatalf("parallel hashing gives %x, serial %x", p, s)
+	}
+
+	// Only the modified subtries are hashed again
+	for _, tr := range []*Trie{serial, parallel} {
+		for i := 0; i < 5000; i += 7 {
+			addrHash := crypto.Keccak256Hash([]byte(fmt.Sprintf("account-%d", i)))
+			acc := accounts.NewAccount()
+			acc.Initialised = true
+			acc.Nonce = uint64(i + 1)
+			tr.UpdateAccount(addrHash[:], &acc)
+		}
+	}
+	if s, p := serial.Hash(), parallel.Hash(); s != p {
+		t.Fatalf("parallel hashing of the modified trie gives %x, serial %x", p, s)
+	}
+
+	// The whole account trie is hashed again
+	parallel.Reset()
+	if s, p := serial.Hash(), parallel.Hash(); s != p {
+		t.Fatalf("parallel hashing of the reset trie gives %x, serial %x", p, s)
+	}
+}
+
+func BenchmarkHashSerial(b *testing.B)   { benchHashWorkers(b, 0) }
+func BenchmarkHashParallel(b *testing.B) { benchHashWorkers(b, runtime.NumCPU()) }
+
+func benchHashWorkers(b *testing.B, workers int) {
+	tr := newHashingTestTrie(100000)
+	tr.SetHashingWorkers(workers)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		tr.Reset()
+		b.StartTimer()
+		tr.Hash()
+	}
+}
@@ -204,6 +204,37 @@ func (n *shortNode) copy() *shortNode {
 	return &c
 }
 
+// deepCopy copies the structure of the resolved nodes, because the trie modifies the nodes in place.
+// Hash, value and code nodes are never modified, so they are shared
+func deepCopy(nd node) node {
+	switch n := nd.(type) {
+	case *fullNode:
+		c := n.copy()
+		for i, child := range &c.Children {
+			if child != nil {
+				c.Children[i] = deepCopy(child)
+			}
+		}
+		return c
+	case *duoNode:
+		c := n.copy()
+		c.child1 = deepCopy(n.child1)
+		c.child2 = deepCopy(n.child2)
+		return c
+	case *shortNode:
+		c := n.copy()
+		c.Key = common.CopyBytes(n.Key)
+		c.Val = deepCopy(n.Val)
+		return c
+	case *accountNode:
+		c := *n
+		c.storage = deepCopy(n.storage)
+		return &c
+	default:
+		return nd
+	}
+}
+
 func resetRefs(nd node) {
 	switch n := nd.(type) {
 	case *shortNode:
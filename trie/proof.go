@@ -5,6 +5,9 @@ import (
 	"fmt"
 
 	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/core/types/accounts"
+	"github.com/ledgerwatch/turbo-geth/crypto"
+	"github.com/ledgerwatch/turbo-geth/rlp"
 )
 
 // Prove constructs a merkle proof for key. The result contains all encoded nodes
@@ -95,3 +98,115 @@ func (t *Trie) Prove(key []byte, fromLevel int, storage bool) ([][]byte, error)
 	}
 	return proof, nil
 }
+
+// VerifyAccountProof verifies the proof of the account (the nodes on the path from the root, as produced by Prove
+// and returned in the accountProof of eth_getProof) against the state root.
+// Returns nil if the proof shows that the account does not exist
+func VerifyAccountProof(stateRoot common.Hash, address common.Address, proof [][]byte) (*accounts.Account, error) {
+	enc, err := verifyProof(stateRoot, crypto.Keccak256(address[:]), proof)
+	if err != nil || enc == nil {
+		return nil, err
+	}
+	var acc accounts.Account
+	if err = acc.DecodeForHashing(enc); err != nil {
+		return nil, fmt.Errorf("account %x: %w", address, err)
+	}
+	return &acc, nil
+}
+
+// VerifyStorageProof verifies the proof of the storage item (the proof in the storageProof of eth_getProof)
+// against the storage root of the account, which is proven by VerifyAccountProof.
+// Returns the value without the leading zeroes, or nil if the proof shows that the item does not exist
+func VerifyStorageProof(storageRoot common.Hash, key common.Hash, proof [][]byte) ([]byte, error) {
+	enc, err := verifyProof(storageRoot, crypto.Keccak256(key[:]), proof)
+	if err != nil || enc == nil {
+		return nil, err
+	}
+	value, rest, err := rlp.SplitString(enc)
+	if err != nil {
+		return nil, fmt.Errorf("storage item %x: %w", key, err)
+	}
+	if len(rest) > 0 {
+		return nil, fmt.Errorf("storage item %x: %d bytes after the value", key, len(rest))
+	}
+	return value, nil
+}
+
+// verifyProof follows the key from the root through the proof nodes, and returns the value of the leaf.
+// The value is nil if the path ends before the leaf of the key, proving that the key is absent.
+// The nodes, which are not on the path, are ignored
+func verifyProof(root common.Hash, key []byte, proof [][]byte) ([]byte, error) {
+	if root == EmptyRoot {
+		return nil, nil
+	}
+	nodes := make(map[common.Hash][]byte, len(proof))
+	for _, enc := range proof {
+		nodes[crypto.Keccak256Hash(enc)] = enc
+	}
+	hex := keybytesToHex(key)
+	enc, ok := nodes[root]
+	if !ok {
+		return nil, fmt.Errorf("proof node of the root %x is missing", root)
+	}
+	for {
+		elems, _, err := rlp.SplitList(enc)
+		if err != nil {
+			return nil, fmt.Errorf("proof node %x: %w", enc, err)
+		}
+		n, err := rlp.CountValues(elems)
+		if err != nil {
+			return nil, fmt.Errorf("proof node %x: %w", enc, err)
+		}
+		var child []byte
+		switch n {
+		case 2:
+			compact, rest, err := rlp.SplitString(elems)
+			if err != nil {
+				return nil, fmt.Errorf("proof node %x: %w", enc, err)
+			}
+			nodeKey := compactToHex(compact)
+			if hasTerm(nodeKey) {
+				if !bytes.Equal(nodeKey, hex) {
+					return nil, nil
+				}
+				value, _, err := rlp.SplitString(rest)
+				return value, err
+			}
+			if !bytes.HasPrefix(hex, nodeKey) {
+				return nil, nil
+			}
+			hex = hex[len(nodeKey):]
+			child = rest
+		case 17:
+			if hex[0] == 16 {
+				return nil, fmt.Errorf("proof node %x: key %x ends in the branch", enc, key)
+			}
+			child = elems
+			for i := byte(0); i < hex[0]; i++ {
+				if _, _, child, err = rlp.Split(child); err != nil {
+					return nil, fmt.Errorf("proof node %x: %w", enc, err)
+				}
+			}
+			hex = hex[1:]
+		default:
+			return nil, fmt.Errorf("proof node %x: %d elements", enc, n)
+		}
+		kind, ref, rest, err := rlp.Split(child)
+		if err != nil {
+			return nil, fmt.Errorf("proof node %x: %w", enc, err)
+		}
+		switch {
+		case kind == rlp.List:
+			// The nodes shorter than the hash are embedded into the parent
+			enc = child[:len(child)-len(rest)]
+		case len(ref) == 0:
+			return nil, nil
+		case len(ref) == common.HashLength:
+			if enc, ok = nodes[common.BytesToHash(ref)]; !ok {
+				return nil, fmt.Errorf("proof node %x is missing", ref)
+			}
+		default:
+			return nil, fmt.Errorf("proof node %x: reference %x", enc, ref)
+		}
+	}
+}
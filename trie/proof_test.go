@@ -0,0 +1,85 @@
+package trie
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/core/types/accounts"
+	"github.com/ledgerwatch/turbo-geth/crypto"
+)
+
+func TestVerifyProof(t *testing.T) {
+	tr := New(EmptyRoot)
+	var addresses []common.Address
+	for i := 0; i < 100; i++ {
+		address := common.BytesToAddress(crypto.Keccak256([]byte(fmt.Sprintf("account-%d", i))))
+		acc := accounts.NewAccount()
+		acc.Initialised = true
+		acc.Nonce = uint64(i)
+		acc.Balance.SetUint64(uint64(i + 1))
+		addrHash := crypto.Keccak256Hash(address[:])
+		tr.UpdateAccount(addrHash[:], &acc)
+		addresses = append(addresses, address)
+	}
+	var keys []common.Hash
+	addrHash := crypto.Keccak256Hash(addresses[0][:])
+	for j := 0; j < 50; j++ {
+		key := common.BytesToHash([]byte{byte(j)})
+		tr.Update(GenerateCompositeTrieKey(addrHash, crypto.Keccak256Hash(key[:])), []byte{byte(j + 1)})
+		keys = append(keys, key)
+	}
+	root := tr.Hash()
+
+	for i, address := range addresses {
+		addrHash := crypto.Keccak256Hash(address[:])
+		proof, err := tr.Prove(addrHash[:], 0, false)
+		require.NoError(t, err)
+		acc, err := VerifyAccountProof(root, address, proof)
+		require.NoError(t, err)
+		require.NotNil(t, acc)
+		assert.Equal(t, uint64(i), acc.Nonce)
+		assert.Equal(t, uint64(i+1), acc.Balance.Uint64())
+	}
+
+	acc, _ := tr.GetAccount(addrHash[:])
+	for j, key := range keys {
+		proof, err := tr.Prove(GenerateCompositeTrieKey(addrHash, crypto.Keccak256Hash(key[:])), 64, true)
+		require.NoError(t, err)
+		value, err := VerifyStorageProof(acc.Root, key, proof)
+		require.NoError(t, err)
+		assert.Equal(t, []byte{byte(j + 1)}, value)
+	}
+
+	t.Run("absent", func(t *testing.T) {
+		address := common.HexToAddress("0xdeadbeef")
+		addrHash := crypto.Keccak256Hash(address[:])
+		proof, err := tr.Prove(addrHash[:], 0, false)
+		require.NoError(t, err)
+		acc, err := VerifyAccountProof(root, address, proof)
+		require.NoError(t, err)
+		assert.Nil(t, acc)
+
+		value, err := VerifyStorageProof(EmptyRoot, common.Hash{}, nil)
+		require.NoError(t, err)
+		assert.Nil(t, value)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		proof, err := tr.Prove(addrHash[:], 0, false)
+		require.NoError(t, err)
+		_, err = VerifyAccountProof(common.Hash{1}, addresses[0], proof)
+		assert.Error(t, err)
+		_, err = VerifyAccountProof(root, addresses[0], proof[:len(proof)-1])
+		assert.Error(t, err)
+		tampered := make([][]byte, len(proof))
+		copy(tampered, proof)
+		tampered[len(tampered)-1] = common.CopyBytes(proof[len(proof)-1])
+		tampered[len(tampered)-1][len(tampered[len(tampered)-1])-1]++
+		_, err = VerifyAccountProof(root, addresses[0], tampered)
+		assert.Error(t, err)
+	})
+}
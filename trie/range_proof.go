@@ -0,0 +1,338 @@
+package trie
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/core/types/accounts"
+	"github.com/ledgerwatch/turbo-geth/trie/rlphacks"
+)
+
+// ErrRangeProof is returned by RangeVerifier for the ranges, which do not match the root together with their proof
+var ErrRangeProof = errors.New("invalid range proof")
+
+// RangeProofHash is the hash of the subtrie outside of the range, which is a part of the boundary proof
+type RangeProofHash struct {
+	Prefix []byte // Path to the subtrie, in nibbles
+	Hash   common.Hash
+}
+
+// RangeProof is the boundary proof of the prefix range: the hashes of the subtries, which surround the range
+// on the paths from the root. The subtries too short to be referenced by the hash (as well as the leaf on
+// the path to an empty range) are proven by their leaves instead, the keys of which are listed in Boundary
+type RangeProof struct {
+	Hashes   []RangeProofHash
+	Boundary []common.Hash
+}
+
+// AccountRangeProof creates the boundary proof of the accounts, the hashed keys of which start with the prefix (in nibbles)
+func (t *Trie) AccountRangeProof(prefix []byte) (*RangeProof, error) {
+	return t.rangeProof(t.root, prefix)
+}
+
+// StorageRangeProof creates the boundary proof of the storage items of the account, the hashed keys of which
+// start with the prefix (in nibbles)
+func (t *Trie) StorageRangeProof(addrHash common.Hash, prefix []byte) (*RangeProof, error) {
+	acc, _ := t.getAccount(t.root, keybytesToHex(addrHash[:]), 0)
+	if acc == nil {
+		return nil, fmt.Errorf("account %x is not in the trie", addrHash)
+	}
+	return t.rangeProof(acc.storage, prefix)
+}
+
+func (t *Trie) rangeProof(root node, prefix []byte) (*RangeProof, error) {
+	h := t.getHasher()
+	defer returnHasherToPool(h)
+	proof := &RangeProof{}
+	var keyBuf []byte
+	addLeaf := func(leafHex []byte) {
+		CompressNibbles(leafHex, &keyBuf)
+		proof.Boundary = append(proof.Boundary, common.BytesToHash(keyBuf))
+	}
+
+	// boundary proves the subtrie outside of the range
+	var boundary func(n node, hex []byte) error
+	boundary = func(n node, hex []byte) error {
+		var ref common.Hash
+		l, err := h.hash(n, false, ref[:])
+		if err != nil {
+			return err
+		}
+		if l == common.HashLength {
+			proof.Hashes = append(proof.Hashes, RangeProofHash{Prefix: common.CopyBytes(hex), Hash: ref})
+			return nil
+		}
+		return walkLeaves(n, hex, addLeaf)
+	}
+
+	var walk func(n node, hex []byte) error
+	walk = func(n node, hex []byte) error {
+		if len(hex) >= len(prefix) {
+			// Inside of the range
+			return nil
+		}
+		switch n := n.(type) {
+		case nil:
+			return nil
+		case *fullNode:
+			for i, child := range n.Children[:16] {
+				if child == nil {
+					continue
+				}
+				childHex := concat(hex, byte(i))
+				if byte(i) == prefix[len(hex)] {
+					if err := walk(child, childHex); err != nil {
+						return err
+					}
+				} else if err := boundary(child, childHex); err != nil {
+					return err
+				}
+			}
+			return nil
+		case *duoNode:
+			i1, i2 := n.childrenIdx()
+			for _, c := range []struct {
+				i     byte
+				child node
+			}{{i1, n.child1}, {i2, n.child2}} {
+				childHex := concat(hex, c.i)
+				if c.i == prefix[len(hex)] {
+					if err := walk(c.child, childHex); err != nil {
+						return err
+					}
+				} else if err := boundary(c.child, childHex); err != nil {
+					return err
+				}
+			}
+			return nil
+		case *shortNode:
+			key := n.Key
+			if hasTerm(key) {
+				key = key[:len(key)-1]
+			}
+			childHex := concat(hex, key...)
+			m := len(childHex)
+			if m > len(prefix) {
+				m = len(prefix)
+			}
+			if bytes.Equal(childHex[:m], prefix[:m]) {
+				return walk(n.Val, childHex)
+			}
+			// The range is empty, the hash of the leaf would be on the path to it
+			switch n.Val.(type) {
+			case valueNode, *accountNode:
+				return walkLeaves(n.Val, childHex, addLeaf)
+			default:
+				return boundary(n.Val, childHex)
+			}
+		case hashNode:
+			return fmt.Errorf("subtrie %x on the path to the range %x is not resolved", hex, prefix)
+		default:
+			return fmt.Errorf("unexpected node %T on the path to the range %x", n, prefix)
+		}
+	}
+	if err := walk(root, nil); err != nil {
+		return nil, err
+	}
+	return proof, nil
+}
+
+// walkLeaves calls the function with the paths of all the leaves of the subtrie
+func walkLeaves(n node, hex []byte, f func(leafHex []byte)) error {
+	switch n := n.(type) {
+	case nil:
+		return nil
+	case *fullNode:
+		for i, child := range n.Children[:16] {
+			if err := walkLeaves(child, concat(hex, byte(i)), f); err != nil {
+				return err
+			}
+		}
+		return nil
+	case *duoNode:
+		i1, i2 := n.childrenIdx()
+		if err := walkLeaves(n.child1, concat(hex, i1), f); err != nil {
+			return err
+		}
+		return walkLeaves(n.child2, concat(hex, i2), f)
+	case *shortNode:
+		key := n.Key
+		if hasTerm(key) {
+			key = key[:len(key)-1]
+		}
+		return walkLeaves(n.Val, concat(hex, key...), f)
+	case valueNode, *accountNode:
+		f(hex)
+		return nil
+	default:
+		return fmt.Errorf("unexpected node %T at %x", n, hex)
+	}
+}
+
+// RangeVerifier checks the sorted stream of the leaves of a prefix range, surrounded by the hashes of its
+// boundary proof, against the root of the trie. The stream is hashed with GenStructStep as it arrives,
+// so only the hashes on the path of the current key are kept in memory. The leaves outside of the range
+// (see RangeProof.Boundary) are allowed, they are proven by the same root
+type RangeVerifier struct {
+	root   common.Hash
+	prefix []byte
+
+	hb       *HashBuilder
+	groups   []uint16
+	curr     bytes.Buffer
+	succ     bytes.Buffer
+	currData GenStructStepData
+	currAcc  *accounts.Account
+	acc      accounts.Account
+	proof    []RangeProofHash
+	finished bool
+
+	hashData GenStructStepHashData
+	leafData GenStructStepLeafData
+	accData  GenStructStepAccountData
+}
+
+// NewRangeVerifier creates the verifier of the leaves, the hashed keys of which start with the prefix (in nibbles),
+// of the trie with the given root
+func NewRangeVerifier(root common.Hash, prefix []byte, proof *RangeProof) (*RangeVerifier, error) {
+	v := &RangeVerifier{root: root, prefix: common.CopyBytes(prefix), hb: NewHashBuilder(false)}
+	var prev []byte
+	for _, h := range proof.Hashes {
+		m := len(h.Prefix)
+		if m > len(prefix) {
+			m = len(prefix)
+		}
+		if bytes.Equal(h.Prefix[:m], prefix[:m]) {
+			return nil, fmt.Errorf("%w: hash of %x overlaps the range %x", ErrRangeProof, h.Prefix, prefix)
+		}
+		if prev != nil && (bytes.Compare(prev, h.Prefix) >= 0 || bytes.HasPrefix(h.Prefix, prev)) {
+			return nil, fmt.Errorf("%w: hashes of %x and %x are out of order", ErrRangeProof, prev, h.Prefix)
+		}
+		prev = h.Prefix
+	}
+	v.proof = proof.Hashes
+	return v, nil
+}
+
+// AddAccount adds the account to the stream, its storage root and code hash are taken from the account
+func (v *RangeVerifier) AddAccount(addrHash common.Hash, acc *accounts.Account) error {
+	return v.addLeaf(addrHash, acc, nil)
+}
+
+// AddStorage adds the storage item to the stream
+func (v *RangeVerifier) AddStorage(keyHash common.Hash, value []byte) error {
+	if len(value) == 0 {
+		return fmt.Errorf("%w: empty value of the storage item %x", ErrRangeProof, keyHash)
+	}
+	return v.addLeaf(keyHash, nil, value)
+}
+
+func (v *RangeVerifier) addLeaf(key common.Hash, acc *accounts.Account, value []byte) error {
+	if v.finished {
+		return errors.New("range verification is finished")
+	}
+	hex := keybytesToHex(key[:])
+	// The hashes of the proof to the left of the leaf go first
+	for len(v.proof) > 0 && bytes.Compare(v.proof[0].Prefix, hex) < 0 {
+		if err := v.addHash(v.proof[0]); err != nil {
+			return err
+		}
+		v.proof = v.proof[1:]
+	}
+	if err := v.step(hex); err != nil {
+		return err
+	}
+	if acc != nil {
+		v.acc.Copy(acc)
+		v.currAcc = &v.acc
+		v.currData = &v.accData
+	} else {
+		v.currAcc = nil
+		v.leafData.Value = rlphacks.RlpSerializableBytes(common.CopyBytes(value))
+		v.currData = &v.leafData
+	}
+	return nil
+}
+
+func (v *RangeVerifier) addHash(h RangeProofHash) error {
+	if err := v.step(h.Prefix); err != nil {
+		return err
+	}
+	v.currAcc = nil
+	v.hashData.Hash = h.Hash
+	v.hashData.DataLen = 0
+	v.currData = &v.hashData
+	return nil
+}
+
+// step makes the key the next one in the stream and emits the structure of the previous one
+func (v *RangeVerifier) step(hex []byte) error {
+	if prev := v.succ.Bytes(); hex != nil && len(prev) > 0 && (bytes.Compare(prev, hex) >= 0 || bytes.HasPrefix(hex, prev)) {
+		return fmt.Errorf("%w: key %x does not follow %x", ErrRangeProof, hex, prev)
+	}
+	v.curr.Reset()
+	v.curr.Write(v.succ.Bytes())
+	v.succ.Reset()
+	v.succ.Write(hex)
+	if v.curr.Len() == 0 {
+		return nil
+	}
+	return v.emit()
+}
+
+func (v *RangeVerifier) emit() error {
+	if acc := v.currAcc; acc != nil {
+		// The code hash and the storage root are expected on the stack under the account leaf
+		v.accData.FieldSet = 0
+		if !acc.Balance.IsZero() {
+			v.accData.FieldSet |= AccountFieldBalanceOnly
+		}
+		if acc.Nonce != 0 {
+			v.accData.FieldSet |= AccountFieldNonceOnly
+		}
+		if !acc.IsEmptyCodeHash() {
+			v.accData.FieldSet |= AccountFieldCodeOnly
+			if err := v.hb.hash(acc.CodeHash[:], 0); err != nil {
+				return err
+			}
+		}
+		if !acc.IsEmptyRoot() {
+			v.accData.FieldSet |= AccountFieldStorageOnly
+			if err := v.hb.hash(acc.Root[:], 0); err != nil {
+				return err
+			}
+		}
+		v.accData.Balance.Set(&acc.Balance)
+		v.accData.Nonce = acc.Nonce
+		v.accData.Incarnation = 0
+	}
+	var err error
+	v.groups, err = GenStructStep(func(_ []byte) bool { return false }, v.curr.Bytes(), v.succ.Bytes(), v.hb, v.currData, v.groups, false)
+	return err
+}
+
+// Finish completes the stream with the rest of the proof and checks the root
+func (v *RangeVerifier) Finish() error {
+	if v.finished {
+		return errors.New("range verification is finished")
+	}
+	v.finished = true
+	for _, h := range v.proof {
+		if err := v.addHash(h); err != nil {
+			return err
+		}
+	}
+	if err := v.step(nil); err != nil {
+		return err
+	}
+	root := EmptyRoot
+	if v.hb.hasRoot() {
+		root = v.hb.rootHash()
+	}
+	if root != v.root {
+		return fmt.Errorf("%w: range %x gives root %x, expected %x", ErrRangeProof, v.prefix, root, v.root)
+	}
+	return nil
+}
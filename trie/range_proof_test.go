@@ -0,0 +1,170 @@
+package trie
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/core/types/accounts"
+	"github.com/ledgerwatch/turbo-geth/crypto"
+)
+
+type rangeProofTestState struct {
+	tr       *Trie
+	root     common.Hash
+	accounts []common.Hash // Sorted
+	storage  map[common.Hash][]common.Hash
+	values   map[common.Hash][]byte
+}
+
+func newRangeProofTestState() *rangeProofTestState {
+	s := &rangeProofTestState{tr: New(EmptyRoot), storage: make(map[common.Hash][]common.Hash), values: make(map[common.Hash][]byte)}
+	for i := 0; i < 200; i++ {
+		addrHash := crypto.Keccak256Hash([]byte(fmt.Sprintf("account-%d", i)))
+		acc := accounts.NewAccount()
+		acc.Initialised = true
+		acc.Balance.SetUint64(uint64(i + 1))
+		if i%10 == 0 {
+			acc.CodeHash = crypto.Keccak256Hash([]byte(fmt.Sprintf("code-%d", i)))
+		}
+		s.tr.UpdateAccount(addrHash[:], &acc)
+		s.accounts = append(s.accounts, addrHash)
+		if i%10 != 0 {
+			continue
+		}
+		for j := 0; j < 30; j++ {
+			keyHash := crypto.Keccak256Hash([]byte(fmt.Sprintf("location-%d", j)))
+			s.tr.Update(GenerateCompositeTrieKey(addrHash, keyHash), []byte{byte(j + 1)})
+			s.storage[addrHash] = append(s.storage[addrHash], keyHash)
+			s.values[keyHash] = []byte{byte(j + 1)}
+		}
+		sortHashes(s.storage[addrHash])
+	}
+	sortHashes(s.accounts)
+	s.root = s.tr.Hash()
+	return s
+}
+
+func sortHashes(hashes []common.Hash) {
+	sort.Slice(hashes, func(i, j int) bool { return bytes.Compare(hashes[i][:], hashes[j][:]) < 0 })
+}
+
+// rangeKeys selects the keys of the range and the boundary keys of the proof
+func rangeKeys(keys []common.Hash, prefix []byte, proof *RangeProof) (selected []common.Hash, inRange int) {
+	boundary := make(map[common.Hash]struct{})
+	for _, k := range proof.Boundary {
+		boundary[k] = struct{}{}
+	}
+	for _, k := range keys {
+		if bytes.HasPrefix(keybytesToHex(k[:]), prefix) {
+			selected = append(selected, k)
+			inRange++
+		} else if _, ok := boundary[k]; ok {
+			selected = append(selected, k)
+		}
+	}
+	return selected, inRange
+}
+
+func (s *rangeProofTestState) verifyAccounts(prefix []byte, proof *RangeProof, keys []common.Hash, modify func(acc *accounts.Account)) error {
+	v, err := NewRangeVerifier(s.root, prefix, proof)
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		acc, _ := s.tr.GetAccount(k[:])
+		if modify != nil {
+			modify(acc)
+		}
+		if err = v.AddAccount(k, acc); err != nil {
+			return err
+		}
+	}
+	return v.Finish()
+}
+
+func TestAccountRangeProof(t *testing.T) {
+	s := newRangeProofTestState()
+	for _, prefix := range [][]byte{{}, {0x3}, {0xa, 0x1}, {0x0, 0xf, 0x7}, {0x1, 0x2, 0x3, 0x4, 0x5}} {
+		proof, err := s.tr.AccountRangeProof(prefix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys, inRange := rangeKeys(s.accounts, prefix, proof)
+		if len(prefix) == 0 && (len(proof.Hashes) != 0 || inRange != len(s.accounts)) {
+			t.Errorf("the whole trie has to be in the range, got %d hashes in the proof and %d accounts", len(proof.Hashes), inRange)
+		}
+		if err = s.verifyAccounts(prefix, proof, keys, nil); err != nil {
+			t.Errorf("range %x with %d accounts: %v", prefix, inRange, err)
+		}
+	}
+
+	prefix := []byte{0x3}
+	proof, err := s.tr.AccountRangeProof(prefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, inRange := rangeKeys(s.accounts, prefix, proof)
+	if inRange < 2 {
+		t.Fatalf("expected several accounts in the range, got %d", inRange)
+	}
+	// An account of the range is missing
+	for i := range keys {
+		if !bytes.HasPrefix(keybytesToHex(keys[i][:]), prefix) {
+			continue
+		}
+		incomplete := append(append([]common.Hash{}, keys[:i]...), keys[i+1:]...)
+		if err = s.verifyAccounts(prefix, proof, incomplete, nil); !errors.Is(err, ErrRangeProof) {
+			t.Errorf("expected ErrRangeProof without the account %x, got %v", keys[i], err)
+		}
+		break
+	}
+	// An account of the range is modified
+	if err = s.verifyAccounts(prefix, proof, keys, func(acc *accounts.Account) { acc.Nonce++ }); !errors.Is(err, ErrRangeProof) {
+		t.Errorf("expected ErrRangeProof for the modified accounts, got %v", err)
+	}
+	// The accounts are out of order
+	swapped := append([]common.Hash{}, keys...)
+	swapped[0], swapped[1] = swapped[1], swapped[0]
+	if err = s.verifyAccounts(prefix, proof, swapped, nil); !errors.Is(err, ErrRangeProof) {
+		t.Errorf("expected ErrRangeProof for the accounts out of order, got %v", err)
+	}
+	// The proof hides a part of the range behind a hash
+	hiding := &RangeProof{Hashes: append([]RangeProofHash{{Prefix: []byte{0x3, 0x0}}}, proof.Hashes...), Boundary: proof.Boundary}
+	sort.Slice(hiding.Hashes, func(i, j int) bool { return bytes.Compare(hiding.Hashes[i].Prefix, hiding.Hashes[j].Prefix) < 0 })
+	if _, err = NewRangeVerifier(s.root, prefix, hiding); !errors.Is(err, ErrRangeProof) {
+		t.Errorf("expected ErrRangeProof for the hash inside of the range, got %v", err)
+	}
+}
+
+func TestStorageRangeProof(t *testing.T) {
+	s := newRangeProofTestState()
+	var addrHash common.Hash
+	for a := range s.storage {
+		addrHash = a
+		break
+	}
+	acc, _ := s.tr.GetAccount(addrHash[:])
+	for _, prefix := range [][]byte{{}, {0x5}, {0xc, 0x2, 0x9}} {
+		proof, err := s.tr.StorageRangeProof(addrHash, prefix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys, _ := rangeKeys(s.storage[addrHash], prefix, proof)
+		v, err := NewRangeVerifier(acc.Root, prefix, proof)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, k := range keys {
+			if err = v.AddStorage(k, s.values[k]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err = v.Finish(); err != nil {
+			t.Errorf("storage range %x: %v", prefix, err)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package trie
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/core/types/accounts"
+)
+
+// ErrRangeWitness is returned by VerifyRangeWitness for the witnesses, which match the state root,
+// but do not prove the complete content of the range
+var ErrRangeWitness = errors.New("incomplete range witness")
+
+// NewRetainAccountRange creates the retain decider for the witness of all the accounts (with their storage and code)
+// between the positions from and last (inclusive), last == nil means up to the end of the state.
+// A position is the hashed key of an account, optionally followed by the hashed key of its storage item,
+// so that the range can start or end inside the storage of an account
+func NewRetainAccountRange(from, last []byte) *RetainRange {
+	fromHex, lastHex := accountRangeHex(from, last)
+	return NewRetainRange(fromHex, lastHex)
+}
+
+// accountRangeHex converts the bounds of the account range into the nibbles. The open upper bound
+// is replaced by the largest key, because RetainRange with to == nil retains the keys below from as well
+func accountRangeHex(from, last []byte) ([]byte, []byte) {
+	fromHex := keybytesToHex(from)[:2*len(from)]
+	if last == nil {
+		return fromHex, bytes.Repeat([]byte{0xf}, 2*common.HashLength)
+	}
+	return fromHex, keybytesToHex(last)[:2*len(last)]
+}
+
+// RangeReceiver accepts the content of the range proven by the witness
+type RangeReceiver interface {
+	Account(addrHash common.Hash, acc *accounts.Account, code []byte) error
+	Storage(addrHash common.Hash, keyHash common.Hash, value []byte) error
+}
+
+// VerifyRangeWitness checks that the witness (created with the decider from NewRetainAccountRange) matches
+// the state root, and that it contains every account between the positions from and last (inclusive,
+// last == nil means up to the end of the state) together with their storage and code. The accounts
+// of the range and their storage items are passed to the receiver in the order of the keys. The account,
+// inside the storage of which the range starts, is not passed, only its storage items from that position
+func VerifyRangeWitness(w *Witness, root common.Hash, from, last []byte, receiver RangeReceiver) error {
+	if !validRangePosition(from) || (last != nil && !validRangePosition(last)) {
+		return fmt.Errorf("invalid range %x-%x", from, last)
+	}
+	t, err := w.verifiedTrie(root, false /* isBinary */)
+	if err != nil {
+		return err
+	}
+	v := &rangeVerifier{receiver: receiver}
+	v.fromHex, v.lastHex = accountRangeHex(from, last)
+	return v.walk(t.root, nil)
+}
+
+func validRangePosition(pos []byte) bool {
+	return len(pos) == common.HashLength || len(pos) == 2*common.HashLength
+}
+
+type rangeVerifier struct {
+	fromHex  []byte
+	lastHex  []byte
+	receiver RangeReceiver
+	keyBuf   []byte
+}
+
+// overlaps tells whether any of the keys starting with the prefix falls into the range
+func (v *rangeVerifier) overlaps(prefix []byte) bool {
+	return compareBound(prefix, v.fromHex) >= 0 && compareBound(prefix, v.lastHex) <= 0
+}
+
+// compareBound compares the prefix with the bound cut to the same length
+func compareBound(prefix, bound []byte) int {
+	m := len(prefix)
+	if m > len(bound) {
+		m = len(bound)
+	}
+	return bytes.Compare(prefix[:m], bound[:m])
+}
+
+// partialStorage tells whether the range starts or ends inside the storage of the account
+func (v *rangeVerifier) partialStorage(accountHex []byte) bool {
+	return (len(v.fromHex) > len(accountHex) && bytes.HasPrefix(v.fromHex, accountHex)) ||
+		(len(v.lastHex) > len(accountHex) && bytes.HasPrefix(v.lastHex, accountHex))
+}
+
+func (v *rangeVerifier) walk(n node, hex []byte) error {
+	switch n := n.(type) {
+	case nil:
+		return nil
+	case *fullNode:
+		for i, child := range n.Children[:16] {
+			if err := v.walk(child, concat(hex, byte(i))); err != nil {
+				return err
+			}
+		}
+		if n.Children[16] != nil {
+			return fmt.Errorf("%w: value in the branch node at %x", ErrRangeWitness, hex)
+		}
+		return nil
+	case *duoNode:
+		i1, i2 := n.childrenIdx()
+		if err := v.walk(n.child1, concat(hex, i1)); err != nil {
+			return err
+		}
+		return v.walk(n.child2, concat(hex, i2))
+	case *shortNode:
+		key := n.Key
+		if hasTerm(key) {
+			key = key[:len(key)-1]
+		}
+		return v.walk(n.Val, concat(hex, key...))
+	case hashNode:
+		if v.overlaps(hex) {
+			return fmt.Errorf("%w: hash of the accounts %x in the range", ErrRangeWitness, hex)
+		}
+		return nil
+	case *accountNode:
+		if len(hex) != 2*common.HashLength {
+			return fmt.Errorf("%w: account at %x", ErrRangeWitness, hex)
+		}
+		if !v.overlaps(hex) {
+			return nil
+		}
+		CompressNibbles(hex, &v.keyBuf)
+		addrHash := common.BytesToHash(v.keyBuf)
+		// The account itself is in the range, unless the range starts inside its storage
+		if len(v.fromHex) == len(hex) || !bytes.HasPrefix(v.fromHex, hex) {
+			if n.CodeHash != EmptyCodeHash && n.code == nil {
+				return fmt.Errorf("%w: no code of the account %x", ErrRangeWitness, addrHash)
+			}
+			if err := v.receiver.Account(addrHash, &n.Account, n.code); err != nil {
+				return err
+			}
+		}
+		if n.Root == EmptyRoot {
+			return nil
+		}
+		if n.storage == nil {
+			return fmt.Errorf("%w: no storage of the account %x", ErrRangeWitness, addrHash)
+		}
+		return v.walkStorage(addrHash, n.storage, hex, v.partialStorage(hex))
+	default:
+		return fmt.Errorf("%w: unexpected node %T at %x", ErrRangeWitness, n, hex)
+	}
+}
+
+// walkStorage passes the storage items of the account to the receiver. The storage has to be complete,
+// or, if the range starts or ends inside of it (partial), complete within the range. The hex includes
+// the key of the account
+func (v *rangeVerifier) walkStorage(addrHash common.Hash, n node, hex []byte, partial bool) error {
+	switch n := n.(type) {
+	case *fullNode:
+		for i, child := range n.Children[:16] {
+			if child == nil {
+				continue
+			}
+			if err := v.walkStorage(addrHash, child, concat(hex, byte(i)), partial); err != nil {
+				return err
+			}
+		}
+		if n.Children[16] != nil {
+			return fmt.Errorf("%w: value in the branch node of the storage of %x at %x", ErrRangeWitness, addrHash, hex)
+		}
+		return nil
+	case *duoNode:
+		i1, i2 := n.childrenIdx()
+		if err := v.walkStorage(addrHash, n.child1, concat(hex, i1), partial); err != nil {
+			return err
+		}
+		return v.walkStorage(addrHash, n.child2, concat(hex, i2), partial)
+	case *shortNode:
+		key := n.Key
+		if hasTerm(key) {
+			key = key[:len(key)-1]
+		}
+		return v.walkStorage(addrHash, n.Val, concat(hex, key...), partial)
+	case valueNode:
+		if len(hex) != 4*common.HashLength {
+			return fmt.Errorf("%w: storage item of %x at %x", ErrRangeWitness, addrHash, hex)
+		}
+		if partial && !v.overlaps(hex) {
+			// The neighbour of the range on the path to it
+			return nil
+		}
+		CompressNibbles(hex[2*common.HashLength:], &v.keyBuf)
+		return v.receiver.Storage(addrHash, common.BytesToHash(v.keyBuf), n)
+	case hashNode:
+		if partial && !v.overlaps(hex) {
+			return nil
+		}
+		return fmt.Errorf("%w: hash of the storage of %x at %x", ErrRangeWitness, addrHash, hex[2*common.HashLength:])
+	default:
+		return fmt.Errorf("%w: unexpected node %T in the storage of %x at %x", ErrRangeWitness, n, addrHash, hex)
+	}
+}
@@ -1,15 +1,46 @@
 package trie
 
-import "github.com/ledgerwatch/turbo-geth/common"
+import (
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+	"github.com/ledgerwatch/turbo-geth/metrics"
+)
+
+var (
+	retainListSizeHistogram = metrics.NewRegisteredHistogram("trie/retainlist/size", nil, metrics.NewExpDecaySample(1028, 0.015))
+	retainListSpillMeter    = metrics.NewRegisteredMeter("trie/retainlist/spill", nil)
+)
+
+const (
+	spillAccountTouch byte = 0
+	spillStorageTouch byte = 1
+)
+
+const (
+	touchOverhead = 24 // Slice header of a touch, counted against the budget together with the key
+	codeOverhead  = 16 // Estimated overhead of a map entry of a code hash
+
+	spillDeleteChunk = 10000 // Number of the spilled touches deleted in one batch
+)
 
 // RetainListBuilder is the structure that accumulates the list of keys that were read or changes (touched) during
 // the execution of a block. It also tracks the contract codes that were created and used during the execution
-// of a block
+// of a block. When the memory budget is set, the touches exceeding it are moved into dbutils.RetainListSpillBucket
+// of the spill database until the end of the block
 type RetainListBuilder struct {
 	touches        [][]byte                 // Read/change set of account keys (account hashes)
 	storageTouches [][]byte                 // Read/change set of storage keys (account hashes concatenated with storage key hashes)
 	proofCodes     map[common.Hash]struct{} // Contract codes that have been accessed (codeHash)
 	createdCodes   map[common.Hash]struct{} // Contract codes that were created (deployed) (codeHash)
+
+	budget      uint64 // Memory budget in bytes, 0 means unlimited
+	spill       ethdb.Database
+	touchesSize uint64 // Size of the touches kept in memory
+	codesSize   uint64 // Size of the accessed and created code hashes
+	spilledSize uint64 // Size of the touches moved into the spill database during the current block
+	err         error  // The first failure of the spill database during the current block
+	builtErr    error  // The failure of the spill database during the block of the last built retain list
 }
 
 // NewRetainListBuilder creates new ProofGenerator and initialised its maps
@@ -23,19 +54,134 @@ func NewRetainListBuilder() *RetainListBuilder {
 // AddTouch adds a key (in KEY encoding) into the read/change set of account keys
 func (rlb *RetainListBuilder) AddTouch(touch []byte) {
 	rlb.touches = append(rlb.touches, common.CopyBytes(touch))
+	rlb.touchesSize += uint64(len(touch)) + touchOverhead
+	rlb.checkBudget()
 }
 
 // AddStorageTouch adds a key (in KEY encoding) into the read/change set of storage keys
 func (rlb *RetainListBuilder) AddStorageTouch(touch []byte) {
 	rlb.storageTouches = append(rlb.storageTouches, common.CopyBytes(touch))
+	rlb.touchesSize += uint64(len(touch)) + touchOverhead
+	rlb.checkBudget()
+}
+
+// SetBudget limits the memory taken by the touches of a block to the given number of bytes (0 means unlimited),
+// the touches above the budget are moved into the spill database. The leftovers of an interrupted block
+// are removed from the spill database
+func (rlb *RetainListBuilder) SetBudget(budget uint64, spill ethdb.Database) {
+	rlb.budget = budget
+	rlb.spill = spill
+	if budget == 0 {
+		return
+	}
+	if err := rlb.clearSpill(); err != nil && rlb.err == nil {
+		rlb.err = err
+	}
+	rlb.spilledSize = 0
+}
+
+// Err returns the failure of the spill database during the block of the last built retain list (or extracted
+// touches), which misses some of the touches then. The failures of the earlier blocks are not reported again
+func (rlb *RetainListBuilder) Err() error {
+	return rlb.builtErr
+}
+
+func (rlb *RetainListBuilder) checkBudget() {
+	if rlb.budget == 0 || rlb.err != nil || rlb.touchesSize+rlb.codesSize <= rlb.budget || rlb.touchesSize == 0 {
+		return
+	}
+	batch := rlb.spill.NewBatch()
+	for _, touch := range rlb.touches {
+		if err := batch.Put(dbutils.RetainListSpillBucket, append([]byte{spillAccountTouch}, touch...), []byte{1}); err != nil {
+			rlb.err = err
+			return
+		}
+	}
+	for _, touch := range rlb.storageTouches {
+		if err := batch.Put(dbutils.RetainListSpillBucket, append([]byte{spillStorageTouch}, touch...), []byte{1}); err != nil {
+			rlb.err = err
+			return
+		}
+	}
+	if _, err := batch.Commit(); err != nil {
+		rlb.err = err
+		return
+	}
+	retainListSpillMeter.Mark(1)
+	rlb.spilledSize += rlb.touchesSize
+	rlb.touchesSize = 0
+	rlb.touches = nil
+	rlb.storageTouches = nil
+}
+
+// walkSpilled streams the touches of the current block from the spill database into walker and removes them
+func (rlb *RetainListBuilder) walkSpilled(walker func(kind byte, touch []byte)) {
+	if rlb.spilledSize == 0 {
+		return
+	}
+	if rlb.err == nil {
+		if err := rlb.spill.Walk(dbutils.RetainListSpillBucket, nil, 0, func(k, _ []byte) (bool, error) {
+			walker(k[0], common.CopyBytes(k[1:]))
+			return true, nil
+		}); err != nil {
+			rlb.err = err
+		}
+	}
+	if err := rlb.clearSpill(); err != nil && rlb.err == nil {
+		rlb.err = err
+	}
+}
+
+// clearSpill removes the touches from the spill database, the keys are deleted in chunks to bound the memory
+func (rlb *RetainListBuilder) clearSpill() error {
+	for {
+		keys := make([][]byte, 0, spillDeleteChunk)
+		if err := rlb.spill.Walk(dbutils.RetainListSpillBucket, nil, 0, func(k, _ []byte) (bool, error) {
+			keys = append(keys, common.CopyBytes(k))
+			return len(keys) < spillDeleteChunk, nil
+		}); err != nil {
+			return err
+		}
+		if len(keys) == 0 {
+			return nil
+		}
+		batch := rlb.spill.NewBatch()
+		for _, k := range keys {
+			if err := batch.Delete(dbutils.RetainListSpillBucket, k); err != nil {
+				return err
+			}
+		}
+		if _, err := batch.Commit(); err != nil {
+			return err
+		}
+	}
 }
 
-// ExtractTouches returns accumulated read/change sets and clears them for the next block's execution
+// endBlock clears the touches for the next block's execution, the failure of the spill database is reported by Err
+func (rlb *RetainListBuilder) endBlock() {
+	rlb.touches = nil
+	rlb.storageTouches = nil
+	rlb.touchesSize = 0
+	rlb.spilledSize = 0
+	rlb.builtErr = rlb.err
+	rlb.err = nil
+}
+
+// ExtractTouches returns accumulated read/change sets and clears them for the next block's execution.
+// The spilled touches are read back into memory, Build streams them into the retain list instead
 func (rlb *RetainListBuilder) ExtractTouches() ([][]byte, [][]byte) {
+	retainListSizeHistogram.Update(int64(rlb.touchesSize + rlb.spilledSize + rlb.codesSize))
 	touches := rlb.touches
 	storageTouches := rlb.storageTouches
-	rlb.touches = nil
-	rlb.storageTouches = nil
+	rlb.walkSpilled(func(kind byte, touch []byte) {
+		switch kind {
+		case spillAccountTouch:
+			touches = append(touches, touch)
+		case spillStorageTouch:
+			storageTouches = append(storageTouches, touch)
+		}
+	})
+	rlb.endBlock()
 	return touches, storageTouches
 }
 
@@ -45,6 +191,7 @@ func (rlb *RetainListBuilder) extractCodeTouches() map[common.Hash]struct{} {
 	proofCodes := rlb.proofCodes
 	rlb.proofCodes = make(map[common.Hash]struct{})
 	rlb.createdCodes = make(map[common.Hash]struct{})
+	rlb.codesSize = 0
 	return proofCodes
 }
 
@@ -52,17 +199,40 @@ func (rlb *RetainListBuilder) extractCodeTouches() map[common.Hash]struct{} {
 func (rlb *RetainListBuilder) ReadCode(codeHash common.Hash) {
 	if _, ok := rlb.proofCodes[codeHash]; !ok {
 		rlb.proofCodes[codeHash] = struct{}{}
+		rlb.codesSize += common.HashLength + codeOverhead
+		rlb.checkBudget()
 	}
 }
 
 // CreateCode registers that given contract code has been created (deployed) during current block's execution
 func (rlb *RetainListBuilder) CreateCode(codeHash common.Hash) {
 	if _, ok := rlb.proofCodes[codeHash]; !ok {
-		rlb.createdCodes[codeHash] = struct{}{}
+		if _, ok = rlb.createdCodes[codeHash]; !ok {
+			rlb.createdCodes[codeHash] = struct{}{}
+			rlb.codesSize += common.HashLength + codeOverhead
+			rlb.checkBudget()
+		}
 	}
 }
 
 func (rlb *RetainListBuilder) Build(isBinary bool) *RetainList {
+	return rlb.build(isBinary, nil)
+}
+
+// BuildForAccounts is Build limited to the given accounts (account hashes): the touches of the other accounts
+// and of their storage are dropped, so that the witness only proves the given accounts and their storage items
+func (rlb *RetainListBuilder) BuildForAccounts(isBinary bool, addrHashes []common.Hash) *RetainList {
+	accounts := make(map[common.Hash]struct{}, len(addrHashes))
+	for _, addrHash := range addrHashes {
+		accounts[addrHash] = struct{}{}
+	}
+	return rlb.build(isBinary, func(touch []byte) bool {
+		_, ok := accounts[common.BytesToHash(touch[:common.HashLength])]
+		return ok
+	})
+}
+
+func (rlb *RetainListBuilder) build(isBinary bool, keep func(touch []byte) bool) *RetainList {
 	var rl *RetainList
 	if isBinary {
 		rl = NewBinaryRetainList(0)
@@ -70,15 +240,25 @@ func (rlb *RetainListBuilder) Build(isBinary bool) *RetainList {
 		rl = NewRetainList(0)
 	}
 
-	touches, storageTouches := rlb.ExtractTouches()
-	codeTouches := rlb.extractCodeTouches()
-
-	for _, touch := range touches {
-		rl.AddKey(touch)
+	retainListSizeHistogram.Update(int64(rlb.touchesSize + rlb.spilledSize + rlb.codesSize))
+	for _, touch := range rlb.touches {
+		if keep == nil || keep(touch) {
+			rl.AddKey(touch)
+		}
 	}
-	for _, touch := range storageTouches {
-		rl.AddKey(touch)
+	for _, touch := range rlb.storageTouches {
+		if keep == nil || keep(touch) {
+			rl.AddKey(touch)
+		}
 	}
+	rlb.walkSpilled(func(_ byte, touch []byte) {
+		if keep == nil || keep(touch) {
+			rl.AddKey(touch)
+		}
+	})
+	rlb.endBlock()
+	codeTouches := rlb.extractCodeTouches()
+
 	for codeHash := range codeTouches {
 		rl.AddCodeTouch(codeHash)
 	}
@@ -0,0 +1,88 @@
+package trie
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildForAccounts(t *testing.T) {
+	a := common.HexToHash("0x1100000000000000000000000000000000000000000000000000000000000000")
+	b := common.HexToHash("0x2200000000000000000000000000000000000000000000000000000000000000")
+	storageKey := common.HexToHash("0x33")
+
+	rlb := NewRetainListBuilder()
+	rlb.AddTouch(a[:])
+	rlb.AddTouch(b[:])
+	rlb.AddStorageTouch(append(common.CopyBytes(a[:]), storageKey[:]...))
+	rlb.AddStorageTouch(append(common.CopyBytes(b[:]), storageKey[:]...))
+	rl := rlb.BuildForAccounts(false, []common.Hash{a})
+
+	assert.True(t, rl.Retain([]byte{1, 1}))
+	assert.False(t, rl.Retain([]byte{2, 2}))
+	assert.Equal(t, 2, len(rl.hexes), "the account and its storage item")
+
+	// The touches are consumed by the build
+	rl = rlb.Build(false)
+	assert.Equal(t, 0, len(rl.hexes))
+}
+
+func TestRetainListBudget(t *testing.T) {
+	db := ethdb.NewMemDatabase()
+	defer db.Close()
+	rlb := NewRetainListBuilder()
+	rlb.SetBudget(3*common.HashLength, db)
+
+	var keys []common.Hash
+	for i := 0; i < 10; i++ {
+		keys = append(keys, common.BytesToHash([]byte{byte(i + 1)}))
+	}
+	for _, k := range keys {
+		rlb.AddTouch(k[:])
+		rlb.AddStorageTouch(append(common.CopyBytes(k[:]), k[:]...))
+	}
+	rlb.ReadCode(common.Hash{1})
+	assert.True(t, len(rlb.touches)+len(rlb.storageTouches) < 2*len(keys), "the touches above the budget have to be spilled")
+
+	rl := rlb.Build(false)
+	require.NoError(t, rlb.Err())
+	assert.Equal(t, 2*len(keys), len(rl.hexes), "the spilled touches are retained too")
+	for _, k := range keys {
+		assert.True(t, rl.Retain(keybytesToHex(k[:])[:64]))
+	}
+	var spilled int
+	require.NoError(t, db.Walk(dbutils.RetainListSpillBucket, nil, 0, func(_, _ []byte) (bool, error) {
+		spilled++
+		return true, nil
+	}))
+	assert.Equal(t, 0, spilled, "the spill bucket is cleared by the build")
+}
+
+func TestRetainListBudgetErrPerBlock(t *testing.T) {
+	db := ethdb.NewMemDatabase()
+	defer db.Close()
+	rlb := NewRetainListBuilder()
+	rlb.SetBudget(3*common.HashLength, db)
+
+	// The code hashes count against the budget too
+	for i := 0; i < 4; i++ {
+		rlb.CreateCode(common.Hash{byte(i)})
+	}
+	rlb.AddTouch(common.Hash{1}.Bytes())
+	assert.Equal(t, 0, len(rlb.touches), "the touch above the budget has to be spilled")
+
+	rlb.err = errors.New("spill failure")
+	rlb.Build(false)
+	assert.EqualError(t, rlb.Err(), "spill failure")
+
+	// The failure is not carried into the next block
+	rlb.AddTouch(common.Hash{2}.Bytes())
+	rl := rlb.Build(false)
+	require.NoError(t, rlb.Err())
+	assert.Equal(t, 1, len(rl.hexes))
+}
@@ -24,8 +24,12 @@ type LoadFunc func(*SubTrieLoader, *RetainList, [][]byte, []int) (SubTries, erro
 type SubTrieLoader struct {
 	blockNr      uint64
 	codeRequests []*LoadRequestForCode
+	readCode     CodeReader
 }
 
+// CodeReader reads the contract code by its hash
+type CodeReader func(db ethdb.Getter, codeHash common.Hash) ([]byte, error)
+
 func NewSubTrieLoader(blockNr uint64) *SubTrieLoader {
 	tr := SubTrieLoader{
 		codeRequests: []*LoadRequestForCode{},
@@ -39,6 +43,11 @@ func (stl *SubTrieLoader) Reset(blockNr uint64) {
 	stl.codeRequests = stl.codeRequests[:0]
 }
 
+// SetCodeReader replaces the reads of dbutils.CodeBucket for the requested code, see AddCodeRequest
+func (stl *SubTrieLoader) SetCodeReader(readCode CodeReader) {
+	stl.readCode = readCode
+}
+
 // AddCodeRequest add a request for code loading
 func (stl *SubTrieLoader) AddCodeRequest(req *LoadRequestForCode) {
 	stl.codeRequests = append(stl.codeRequests, req)
@@ -68,6 +77,7 @@ func (stl *SubTrieLoader) LoadFromFlatDB(db ethdb.Getter, rl RetainDecider, dbPr
 	if err != nil {
 		return subTries, err
 	}
+	loader.readCode = stl.readCode
 	if err = loader.AttachRequestedCode(db, stl.codeRequests); err != nil {
 		return subTries, err
 	}
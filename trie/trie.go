@@ -19,10 +19,10 @@ package trie
 
 import (
 	"bytes"
-	"encoding/binary"
 	"fmt"
 
 	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
 	"github.com/ledgerwatch/turbo-geth/common/debug"
 	"github.com/ledgerwatch/turbo-geth/core/types/accounts"
 	"github.com/ledgerwatch/turbo-geth/crypto"
@@ -48,7 +48,8 @@ var (
 type Trie struct {
 	root node
 
-	newHasherFunc func() *hasher
+	newHasherFunc  func() *hasher
+	hashingWorkers int // See SetHashingWorkers
 
 	Version uint8
 
@@ -97,6 +98,20 @@ func NewTestRLPTrie(root common.Hash) *Trie {
 	return trie
 }
 
+// Copy returns the trie with the same resolved nodes, which can be modified independently of the original one.
+// Observers are not copied
+func (t *Trie) Copy() *Trie {
+	return &Trie{
+		root:           deepCopy(t.root),
+		newHasherFunc:  t.newHasherFunc,
+		hashingWorkers: t.hashingWorkers,
+		Version:        t.Version,
+		binary:         t.binary,
+		hashMap:        make(map[common.Hash]node),
+		observers:      NewTrieObserverMux(),
+	}
+}
+
 func (t *Trie) AddObserver(observer Observer) {
 	t.observers.AddChild(observer)
 }
@@ -496,7 +511,7 @@ func findSubTriesToLoad(nd node, nibblePath []byte, rl RetainDecider, dbPrefix [
 		if n.storage == nil {
 			return prefixes, fixedbits, hooks
 		}
-		binary.BigEndian.PutUint64(bytes8[:], ^n.Incarnation)
+		dbutils.EncodeIncarnation(bytes8[:], n.Incarnation)
 		dbPrefix = append(dbPrefix, bytes8[:]...)
 		return findSubTriesToLoad(n.storage, nibblePath, rl, dbPrefix, bits+64, prefixes, fixedbits, hooks)
 	case hashNode:
@@ -1164,12 +1179,21 @@ func (t *Trie) Reset() {
 	resetRefs(t.root)
 }
 
+// SetHashingWorkers makes the hashing of the trie compute the large subtries of a full node concurrently,
+// using up to the given number of goroutines. 0 and 1 mean serial hashing
+func (t *Trie) SetHashingWorkers(workers int) {
+	t.hashingWorkers = workers
+}
+
 func (t *Trie) getHasher() *hasher {
 	h := t.newHasherFunc()
 	if debug.IsGetNodeData() {
 		h.callback = func(key common.Hash, nd node) {
 			t.hashMap[key] = nd
 		}
+	} else {
+		// The callback is not safe for concurrent use
+		h.workers = t.hashingWorkers
 	}
 	return h
 }
@@ -1202,6 +1226,19 @@ func (t *Trie) DeepHash(keyPrefix []byte) (bool, common.Hash) {
 	return true, accNode.Root
 }
 
+// SubTrieWitnessLen returns the witness length of the loaded node at the path (in HEX encoding), which is the storage
+// trie if the path leads to an account. It implements SubTrieSizer
+func (t *Trie) SubTrieWitnessLen(hex []byte) (uint64, bool) {
+	nd, _, ok, _ := t.getNode(hex, false)
+	if !ok || nd == nil {
+		return 0, false
+	}
+	if _, isHash := nd.(hashNode); isHash {
+		return 0, false
+	}
+	return nd.witnessLen(), true
+}
+
 func (t *Trie) EvictNode(hex []byte) {
 	isCode := IsPointingToCode(hex)
 	if isCode {
@@ -19,15 +19,64 @@
 package trie
 
 import (
+	"bytes"
 	"fmt"
 	"sort"
 	"strings"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/metrics"
+)
+
+var (
+	evictedAccountNodesCounter = metrics.NewRegisteredCounter("trie/eviction/accounts/evicted", nil)
+	evictedStorageNodesCounter = metrics.NewRegisteredCounter("trie/eviction/storage/evicted", nil)
+	accountNodesSizeGauge      = metrics.NewRegisteredGauge("trie/eviction/accounts/size", nil)
+	storageNodesSizeGauge      = metrics.NewRegisteredGauge("trie/eviction/storage/size", nil)
 )
 
 type AccountEvicter interface {
 	EvictNode([]byte)
 }
 
+// SubTrieSizer is implemented by the evicters, which can estimate the size of the loaded sub-tries (see EvictBySize)
+type SubTrieSizer interface {
+	SubTrieWitnessLen(hex []byte) (uint64, bool)
+}
+
+// EvictionPolicy is the choice of the storage trie nodes to evict first
+type EvictionPolicy int
+
+const (
+	// EvictOldest evicts the nodes of the oldest generations, regardless of the storage tries they belong to
+	EvictOldest EvictionPolicy = iota
+	// EvictBySize evicts whole storage tries, which are cold (not touched since the generations the age-based
+	// pass would evict), from the smallest to the largest by the witness length, and only then falls back to
+	// the oldest generations. So many small cold storage tries go before the old nodes of a huge warm one
+	EvictBySize
+)
+
+func (p EvictionPolicy) String() string {
+	switch p {
+	case EvictOldest:
+		return "oldest"
+	case EvictBySize:
+		return "size"
+	default:
+		return fmt.Sprintf("EvictionPolicy(%d)", int(p))
+	}
+}
+
+// ParseEvictionPolicy parses the name of the policy, as returned by String
+func ParseEvictionPolicy(s string) (EvictionPolicy, error) {
+	for _, p := range []EvictionPolicy{EvictOldest, EvictBySize} {
+		if p.String() == s {
+			return p, nil
+		}
+	}
+	return EvictOldest, fmt.Errorf("unknown eviction policy %q, expected oldest or size", s)
+}
+
 type generations struct {
 	blockNumToGeneration map[uint64]*generation
 	keyToBlockNum        map[string]uint64
@@ -129,27 +178,146 @@ func (gs *generations) updateSize(blockNum uint64, key []byte, newSize uint) {
 }
 
 // popKeysToEvict returns the keys to evict from the trie,
-// also removing them from generations
-func (gs *generations) popKeysToEvict(threshold uint64) []string {
+// also removing them from generations. Keys for which `keep` returns true are not evicted,
+// they are moved to the generation of `blockNum` instead
+func (gs *generations) popKeysToEvict(threshold uint64, blockNum uint64, keep func(string) bool) []string {
 	keys := make([]string, 0)
-	for uint64(gs.totalSize) > threshold && len(gs.blockNumToGeneration) > 0 {
-		generation, ok := gs.blockNumToGeneration[gs.oldestBlockNum]
+	kept := make(map[string]uint)
+	for uint64(gs.totalSize) > threshold && gs.advanceOldest() {
+		keys = gs.popOldest(keep, keys, kept)
+	}
+	gs.addKept(blockNum, kept)
+	return keys
+}
+
+// advanceOldest moves oldestBlockNum to the oldest existing generation, returns false if there are none
+func (gs *generations) advanceOldest() bool {
+	for len(gs.blockNumToGeneration) > 0 {
+		if _, ok := gs.blockNumToGeneration[gs.oldestBlockNum]; ok {
+			return true
+		}
+		gs.oldestBlockNum++
+	}
+	return false
+}
+
+// popOldest removes the oldest generation (advanceOldest has to be called first),
+// appending its keys to `keys`, except the ones for which `keep` returns true, these are put into `kept`
+func (gs *generations) popOldest(keep func(string) bool, keys []string, kept map[string]uint) []string {
+	generation := gs.blockNumToGeneration[gs.oldestBlockNum]
+	gs.totalSize -= generation.totalSize
+	if gs.totalSize < 0 {
+		gs.totalSize = 0
+	}
+	for _, k := range generation.keys() {
+		if keep != nil && keep(k) {
+			kept[k] = generation.sizesByKey[k]
+		} else {
+			keys = append(keys, k)
+		}
+		delete(gs.keyToBlockNum, k)
+	}
+	delete(gs.blockNumToGeneration, gs.oldestBlockNum)
+	gs.oldestBlockNum++
+	return keys
+}
+
+func (gs *generations) addKept(blockNum uint64, kept map[string]uint) {
+	for k, size := range kept {
+		gs.add(blockNum, []byte(k), size)
+	}
+}
+
+// cutoffBlock returns the newest block of the generations, which the age-based eviction would evict
+// to bring the total size of all the given generations down to the threshold
+func cutoffBlock(threshold uint64, gss ...*generations) uint64 {
+	var size int64
+	sizes := make(map[uint64]int64)
+	for _, gs := range gss {
+		size += gs.totalSize
+		for blockNum, g := range gs.blockNumToGeneration {
+			sizes[blockNum] += g.totalSize
+		}
+	}
+	blockNums := make([]uint64, 0, len(sizes))
+	for blockNum := range sizes {
+		blockNums = append(blockNums, blockNum)
+	}
+	sort.Slice(blockNums, func(i, j int) bool { return blockNums[i] < blockNums[j] })
+	var cutoff uint64
+	for _, blockNum := range blockNums {
+		if size <= int64(threshold) {
+			break
+		}
+		size -= sizes[blockNum]
+		cutoff = blockNum
+	}
+	return cutoff
+}
+
+// subTrie is the group of the accounted nodes with the same path prefix
+type subTrie struct {
+	prefix    string
+	keys      []string
+	accounted int64  // Accounted size of the nodes
+	newest    uint64 // Newest generation of the nodes
+	size      uint64 // Size estimate for the ordering
+}
+
+// popColdSubTries returns the keys of the whole sub-tries (the nodes grouped by the path prefix of prefixLen nibbles),
+// which have not been touched after the cutoff block, also removing them from generations. The sub-tries go from
+// the smallest to the largest by sizeOf, until the total size fits into the threshold.
+// The sub-tries, for which prefix `keep` returns true, are not evicted. The prefix is the path to the account,
+// so `keep` must only be true for the accounts, whose storage tries are kept (i.e. the pinned ones, not the hot ones)
+func (gs *generations) popColdSubTries(threshold uint64, cutoff uint64, prefixLen int, keep func(string) bool, sizeOf func(prefix string, accounted int64) uint64) []string {
+	if uint64(gs.totalSize) <= threshold {
+		return nil
+	}
+	byPrefix := make(map[string]*subTrie)
+	for k, blockNum := range gs.keyToBlockNum {
+		if len(k) < prefixLen {
+			continue
+		}
+		st, ok := byPrefix[k[:prefixLen]]
 		if !ok {
-			gs.oldestBlockNum++
+			st = &subTrie{prefix: k[:prefixLen]}
+			byPrefix[st.prefix] = st
+		}
+		st.keys = append(st.keys, k)
+		if g, ok := gs.blockNumToGeneration[blockNum]; ok {
+			st.accounted += int64(g.sizesByKey[k])
+		}
+		if blockNum > st.newest {
+			st.newest = blockNum
+		}
+	}
+	cold := make([]*subTrie, 0, len(byPrefix))
+	for _, st := range byPrefix {
+		if st.newest > cutoff || (keep != nil && keep(st.prefix)) {
 			continue
 		}
-
-		gs.totalSize -= generation.totalSize
-		if gs.totalSize < 0 {
-			gs.totalSize = 0
+		st.size = sizeOf(st.prefix, st.accounted)
+		cold = append(cold, st)
+	}
+	sort.Slice(cold, func(i, j int) bool {
+		if cold[i].size != cold[j].size {
+			return cold[i].size < cold[j].size
 		}
-		keysToEvict := generation.keys()
-		keys = append(keys, keysToEvict...)
-		for _, k := range keysToEvict {
-			delete(gs.keyToBlockNum, k)
+		return cold[i].prefix < cold[j].prefix
+	})
+	var keys []string
+	for _, st := range cold {
+		if uint64(gs.totalSize) <= threshold {
+			break
 		}
-		delete(gs.blockNumToGeneration, gs.oldestBlockNum)
-		gs.oldestBlockNum++
+		for _, k := range st.keys {
+			blockNum := gs.keyToBlockNum[k]
+			gs.remove([]byte(k))
+			if g, ok := gs.blockNumToGeneration[blockNum]; ok && g.empty() {
+				delete(gs.blockNumToGeneration, blockNum)
+			}
+		}
+		keys = append(keys, st.keys...)
 	}
 	return keys
 }
@@ -231,13 +399,104 @@ type Eviction struct {
 
 	blockNumber uint64
 
-	generations *generations
+	// Account trie and storage tries are accounted separately, so they can have separate budgets
+	// (see EvictToFitBudgets), and a storage-heavy contract can't push the account trie out of the cache
+	generations        *generations // nodes of the account trie and contract code
+	storageGenerations *generations // nodes of the storage tries
+
+	pinned [][]byte // paths (in HEX encoding) to the accounts which storage tries are never evicted
+	hot    []string // sorted paths (in HEX encoding) to the accounts which are never evicted, unlike their storage tries
+
+	policy EvictionPolicy
 }
 
 func NewEviction() *Eviction {
 	return &Eviction{
-		generations: newGenerations(),
+		generations:        newGenerations(),
+		storageGenerations: newGenerations(),
+	}
+}
+
+// classOf returns the generations accounting the node with the given path (in HEX encoding):
+// storage trie nodes have the full path to the account as a prefix
+func (tp *Eviction) classOf(hex []byte) *generations {
+	if len(hex) >= 2*common.HashLength {
+		return tp.storageGenerations
+	}
+	return tp.generations
+}
+
+// Pin excludes the account with the given hash, its storage trie and code from the eviction
+func (tp *Eviction) Pin(addrHash []byte) {
+	hex := keybytesToHex(addrHash)
+	hex = hex[:len(hex)-1] // remove terminator
+	for _, p := range tp.pinned {
+		if bytes.Equal(p, hex) {
+			return
+		}
+	}
+	tp.pinned = append(tp.pinned, hex)
+}
+
+// Unpin makes the account with the given hash subject to the eviction again
+func (tp *Eviction) Unpin(addrHash []byte) {
+	hex := keybytesToHex(addrHash)
+	hex = hex[:len(hex)-1] // remove terminator
+	for i, p := range tp.pinned {
+		if bytes.Equal(p, hex) {
+			tp.pinned = append(tp.pinned[:i], tp.pinned[i+1:]...)
+			return
+		}
+	}
+}
+
+// Pinned returns the hashes of the pinned accounts
+func (tp *Eviction) Pinned() [][]byte {
+	addrHashes := make([][]byte, len(tp.pinned))
+	for i, hex := range tp.pinned {
+		addrHashes[i] = make([]byte, len(hex)/2)
+		decodeNibbles(hex, addrHashes[i])
+	}
+	return addrHashes
+}
+
+// SetHotAccounts replaces the accounts, which are never evicted from the account trie. Unlike Pin,
+// their storage tries and code are subject to the eviction
+func (tp *Eviction) SetHotAccounts(addrHashes [][]byte) {
+	hot := make([]string, len(addrHashes))
+	for i, addrHash := range addrHashes {
+		hex := keybytesToHex(addrHash)
+		hot[i] = string(hex[:len(hex)-1]) // remove terminator
+	}
+	sort.Strings(hot)
+	tp.hot = hot
+}
+
+// isHot returns true for the nodes on the path to the hot accounts, the paths are sorted, so the one
+// starting with the key (if any) is the first path not less than the key
+func (tp *Eviction) isHot(key string) bool {
+	i := sort.SearchStrings(tp.hot, key)
+	return i < len(tp.hot) && strings.HasPrefix(tp.hot[i], key)
+}
+
+// isPinned returns true for the nodes on the path to the pinned accounts (evicting them would evict the accounts)
+// and for the nodes of their storage tries and code
+func (tp *Eviction) isPinned(key string) bool {
+	for _, p := range tp.pinned {
+		if strings.HasPrefix(key, string(p)) || bytes.HasPrefix(p, []byte(key)) {
+			return true
+		}
 	}
+	return false
+}
+
+// SetPolicy sets the choice of the storage trie nodes to evict first
+func (tp *Eviction) SetPolicy(policy EvictionPolicy) {
+	tp.policy = policy
+}
+
+func (tp *Eviction) Policy() EvictionPolicy {
+	return tp.policy
 }
 
 func (tp *Eviction) SetBlockNumber(blockNumber uint64) {
@@ -250,17 +509,17 @@ func (tp *Eviction) BlockNumber() uint64 {
 
 func (tp *Eviction) BranchNodeCreated(hex []byte) {
 	key := hex
-	tp.generations.add(tp.blockNumber, key, 1)
+	tp.classOf(key).add(tp.blockNumber, key, 1)
 }
 
 func (tp *Eviction) BranchNodeDeleted(hex []byte) {
 	key := hex
-	tp.generations.remove(key)
+	tp.classOf(key).remove(key)
 }
 
 func (tp *Eviction) BranchNodeTouched(hex []byte) {
 	key := hex
-	tp.generations.touch(tp.blockNumber, key)
+	tp.classOf(key).touch(tp.blockNumber, key)
 }
 
 func (tp *Eviction) CodeNodeCreated(hex []byte, size uint) {
@@ -296,32 +555,146 @@ func evictList(evicter AccountEvicter, hexes []string) bool {
 }
 
 // EvictToFitSize evicts mininum number of generations necessary so that the total
-// size of accounts left is fits into the provided threshold
+// size of accounts left is fits into the provided threshold.
+// Account and storage generations are evicted together, from the oldest block to the newest.
+// Pinned nodes are not evicted, they are moved to the current generation, so if they
+// alone exceed the threshold, everything else gets evicted. With EvictBySize policy, the cold storage tries are
+// evicted whole instead of the storage generations of the age-based pass
 func (tp *Eviction) EvictToFitSize(
 	evicter AccountEvicter,
 	threshold uint64,
 ) bool {
 
-	if uint64(tp.generations.totalSize) <= threshold {
+	if tp.TotalSize() <= threshold {
 		return false
 	}
 
-	keys := tp.generations.popKeysToEvict(threshold)
+	keep := tp.keepFunc()
+	accountKeys, storageKeys := make([]string, 0), make([]string, 0)
+	accountKept, storageKept := make(map[string]uint), make(map[string]uint)
+	if tp.policy == EvictBySize {
+		// The account trie loses the generations of the age-based pass, the rest comes from the cold storage tries
+		cutoff := cutoffBlock(threshold, tp.generations, tp.storageGenerations)
+		for tp.TotalSize() > threshold && tp.generations.advanceOldest() && tp.generations.oldestBlockNum <= cutoff {
+			accountKeys = tp.generations.popOldest(keep, accountKeys, accountKept)
+		}
+		var storageThreshold uint64
+		if tp.AccountsSize() < threshold {
+			storageThreshold = threshold - tp.AccountsSize()
+		}
+		storageKeys = append(storageKeys, tp.storageGenerations.popColdSubTries(storageThreshold, cutoff, 2*common.HashLength, tp.pinnedFunc(), tp.subTrieSize(evicter))...)
+	}
+	for tp.TotalSize() > threshold {
+		hasAccounts := tp.generations.advanceOldest()
+		hasStorage := tp.storageGenerations.advanceOldest()
+		if hasAccounts && (!hasStorage || tp.generations.oldestBlockNum <= tp.storageGenerations.oldestBlockNum) {
+			accountKeys = tp.generations.popOldest(keep, accountKeys, accountKept)
+		} else if hasStorage {
+			storageKeys = tp.storageGenerations.popOldest(keep, storageKeys, storageKept)
+		} else {
+			break
+		}
+	}
+	tp.generations.addKept(tp.blockNumber, accountKept)
+	tp.storageGenerations.addKept(tp.blockNumber, storageKept)
 
-	return evictList(evicter, keys)
+	return tp.evict(evicter, accountKeys, storageKeys)
+}
+
+// EvictToFitBudgets evicts the oldest generations of the account trie (with the code) and of the storage tries
+// independently, so that each class fits into its own budget. With EvictBySize policy, the cold storage tries
+// are evicted whole first
+func (tp *Eviction) EvictToFitBudgets(
+	evicter AccountEvicter,
+	accountsBudget uint64,
+	storageBudget uint64,
+) bool {
+	keep := tp.keepFunc()
+	var accountKeys, storageKeys []string
+	if uint64(tp.generations.totalSize) > accountsBudget {
+		accountKeys = tp.generations.popKeysToEvict(accountsBudget, tp.blockNumber, keep)
+	}
+	if tp.policy == EvictBySize && uint64(tp.storageGenerations.totalSize) > storageBudget {
+		cutoff := cutoffBlock(storageBudget, tp.storageGenerations)
+		storageKeys = tp.storageGenerations.popColdSubTries(storageBudget, cutoff, 2*common.HashLength, tp.pinnedFunc(), tp.subTrieSize(evicter))
+	}
+	if uint64(tp.storageGenerations.totalSize) > storageBudget {
+		storageKeys = append(storageKeys, tp.storageGenerations.popKeysToEvict(storageBudget, tp.blockNumber, keep)...)
+	}
+	if len(accountKeys) == 0 && len(storageKeys) == 0 {
+		return false
+	}
+	return tp.evict(evicter, accountKeys, storageKeys)
+}
+
+// subTrieSize returns the size estimate of the storage trie with the given path prefix: its witness length
+// if the evicter knows it, or the accounted size of its nodes otherwise
+func (tp *Eviction) subTrieSize(evicter AccountEvicter) func(string, int64) uint64 {
+	sizer, ok := evicter.(SubTrieSizer)
+	return func(prefix string, accounted int64) uint64 {
+		if ok {
+			if witnessLen, found := sizer.SubTrieWitnessLen([]byte(prefix)); found {
+				return witnessLen
+			}
+		}
+		return uint64(accounted)
+	}
+}
+
+func (tp *Eviction) keepFunc() func(string) bool {
+	switch {
+	case len(tp.pinned) > 0 && len(tp.hot) > 0:
+		return func(key string) bool {
+			return tp.isPinned(key) || tp.isHot(key)
+		}
+	case len(tp.pinned) > 0:
+		return tp.isPinned
+	case len(tp.hot) > 0:
+		return tp.isHot
+	}
+	return nil
+}
+
+// pinnedFunc returns the check of the whole storage tries to keep: unlike the hot accounts, only the pinned ones
+// keep their storage
+func (tp *Eviction) pinnedFunc() func(string) bool {
+	if len(tp.pinned) > 0 {
+		return tp.isPinned
+	}
+	return nil
+}
+
+func (tp *Eviction) evict(evicter AccountEvicter, accountKeys, storageKeys []string) bool {
+	evictedAccountNodesCounter.Inc(int64(len(accountKeys)))
+	evictedStorageNodesCounter.Inc(int64(len(storageKeys)))
+	accountNodesSizeGauge.Update(tp.generations.totalSize)
+	storageNodesSizeGauge.Update(tp.storageGenerations.totalSize)
+	return evictList(evicter, append(accountKeys, storageKeys...))
 }
 
 func (tp *Eviction) TotalSize() uint64 {
+	return tp.AccountsSize() + tp.StorageSize()
+}
+
+// AccountsSize is the accounted size of the account trie nodes and contract code
+func (tp *Eviction) AccountsSize() uint64 {
 	return uint64(tp.generations.totalSize)
 }
 
+// StorageSize is the accounted size of the storage trie nodes
+func (tp *Eviction) StorageSize() uint64 {
+	return uint64(tp.storageGenerations.totalSize)
+}
+
 func (tp *Eviction) NumberOf() uint64 {
 	total := uint64(0)
-	for _, gen := range tp.generations.blockNumToGeneration {
-		if gen == nil {
-			continue
+	for _, gs := range []*generations{tp.generations, tp.storageGenerations} {
+		for _, gen := range gs.blockNumToGeneration {
+			if gen == nil {
+				continue
+			}
+			total += uint64(len(gen.sizesByKey))
 		}
-		total += uint64(len(gen.sizesByKey))
 	}
 	return total
 }
@@ -329,13 +702,15 @@ func (tp *Eviction) NumberOf() uint64 {
 func (tp *Eviction) DebugDump() string {
 	var sb strings.Builder
 
-	for block, gen := range tp.generations.blockNumToGeneration {
-		if gen.empty() {
-			continue
-		}
-		sb.WriteString(fmt.Sprintf("Block: %v\n", block))
-		for key, size := range gen.sizesByKey {
-			sb.WriteString(fmt.Sprintf("    %x->%v\n", key, size))
+	for _, gs := range []*generations{tp.generations, tp.storageGenerations} {
+		for block, gen := range gs.blockNumToGeneration {
+			if gen.empty() {
+				continue
+			}
+			sb.WriteString(fmt.Sprintf("Block: %v\n", block))
+			for key, size := range gen.sizesByKey {
+				sb.WriteString(fmt.Sprintf("    %x->%v\n", key, size))
+			}
 		}
 	}
 
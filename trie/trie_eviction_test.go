@@ -21,6 +21,7 @@ package trie
 import (
 	"testing"
 
+	"github.com/ledgerwatch/turbo-geth/common"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -392,3 +393,219 @@ func TestEvictionMoveBetweenGen(t *testing.T) {
 
 	assert.Equal(t, 2, len(eviction.generations.blockNumToGeneration[10].keys()), "should move one acc")
 }
+
+func TestEvictionPinned(t *testing.T) {
+	eviction := NewEviction()
+	eviction.SetBlockNumber(1)
+
+	pinnedAddrHash := []byte{0x01, 0x02, 0x03, 0x04}
+	pinnedHex := keybytesToHex(pinnedAddrHash)
+	pinnedHex = pinnedHex[:len(pinnedHex)-1]
+	eviction.Pin(pinnedAddrHash)
+
+	// path to the pinned account
+	eviction.BranchNodeCreated(pinnedHex[:2])
+	// storage of the pinned account
+	eviction.BranchNodeCreated(append(common.CopyBytes(pinnedHex), 0x05, 0x06))
+	// code of the pinned account
+	eviction.CodeNodeCreated(pinnedHex, 1024)
+	// other accounts
+	for i := 0; i < 100; i++ {
+		key := []byte{0x05, 0x01, 0x01, byte(i)}
+		eviction.BranchNodeCreated(keybytesToHex(key))
+	}
+	eviction.SetBlockNumber(2)
+
+	mock := newMockAccountEvicter()
+	eviction.EvictToFitSize(mock, 0)
+
+	assert.Equal(t, 100, len(mock.keys), "should evict only not pinned nodes")
+	assert.Equal(t, 1024+2, int(eviction.TotalSize()), "pinned nodes should stay accounted")
+	assert.Equal(t, 3, int(eviction.NumberOf()), "pinned nodes should stay accounted")
+	assert.Equal(t, 1024+2, int(eviction.generations.blockNumToGeneration[2].totalSize), "pinned nodes should move to the current generation")
+	assert.Equal(t, [][]byte{pinnedAddrHash}, eviction.Pinned())
+
+	eviction.Unpin(pinnedAddrHash)
+	mock = newMockAccountEvicter()
+	eviction.EvictToFitSize(mock, 0)
+
+	assert.Equal(t, 3, len(mock.keys), "should evict unpinned nodes")
+	assert.Equal(t, 0, int(eviction.TotalSize()), "should evict unpinned nodes")
+}
+
+func TestEvictionHotAccounts(t *testing.T) {
+	eviction := NewEviction()
+	eviction.SetBlockNumber(1)
+
+	hotAddrHash := []byte{0x01, 0x02, 0x03, 0x04}
+	hotHex := keybytesToHex(hotAddrHash)
+	hotHex = hotHex[:len(hotHex)-1]
+	eviction.SetHotAccounts([][]byte{{0x07, 0x07, 0x07, 0x07}, hotAddrHash})
+
+	// path to the hot account
+	eviction.BranchNodeCreated(hotHex[:2])
+	eviction.BranchNodeCreated(hotHex[:5])
+	// storage of the hot account
+	eviction.BranchNodeCreated(append(common.CopyBytes(hotHex), 0x05, 0x06))
+	// code of the hot account
+	eviction.CodeNodeCreated(hotHex, 1024)
+	// other accounts
+	for i := 0; i < 100; i++ {
+		key := []byte{0x05, 0x01, 0x01, byte(i)}
+		eviction.BranchNodeCreated(keybytesToHex(key))
+	}
+	eviction.SetBlockNumber(2)
+
+	mock := newMockAccountEvicter()
+	eviction.EvictToFitSize(mock, 0)
+
+	assert.Equal(t, 102, len(mock.keys), "should evict the storage and the code of the hot account")
+	assert.Equal(t, 2, int(eviction.TotalSize()), "path to the hot account should stay accounted")
+	assert.Equal(t, 2, int(eviction.generations.blockNumToGeneration[2].totalSize), "hot nodes should move to the current generation")
+
+	eviction.SetHotAccounts(nil)
+	mock = newMockAccountEvicter()
+	eviction.EvictToFitSize(mock, 0)
+
+	assert.Equal(t, 2, len(mock.keys), "should evict the accounts, which are not hot any more")
+	assert.Equal(t, 0, int(eviction.TotalSize()))
+}
+
+func TestEvictionSeparateBudgets(t *testing.T) {
+	eviction := NewEviction()
+	eviction.SetBlockNumber(1)
+
+	// account trie
+	for i := 0; i < 10; i++ {
+		key := []byte{0x01, byte(i)}
+		eviction.BranchNodeCreated(keybytesToHex(key)[:4])
+	}
+	eviction.SetBlockNumber(2)
+
+	// storage trie of a single contract, touched in the newer block
+	contractHex := keybytesToHex(common.FromHex("0x0202020202020202020202020202020202020202020202020202020202020202"))
+	contractHex = contractHex[:len(contractHex)-1]
+	for i := 0; i < 100; i++ {
+		eviction.BranchNodeCreated(append(common.CopyBytes(contractHex), 0x01, byte(i%16), byte(i/16)))
+	}
+	assert.Equal(t, 10, int(eviction.AccountsSize()))
+	assert.Equal(t, 100, int(eviction.StorageSize()))
+	assert.Equal(t, 110, int(eviction.NumberOf()))
+
+	eviction.SetBlockNumber(3)
+	mock := newMockAccountEvicter()
+	eviction.EvictToFitBudgets(mock, 10, 50)
+	assert.Equal(t, 100, len(mock.keys), "should evict only the storage generation")
+	assert.Equal(t, 10, int(eviction.AccountsSize()), "account trie should stay within its own budget")
+	assert.Equal(t, 0, int(eviction.StorageSize()))
+
+	// With the shared limit the older generation goes first, regardless of the class
+	for i := 0; i < 100; i++ {
+		eviction.BranchNodeCreated(append(common.CopyBytes(contractHex), 0x01, byte(i%16), byte(i/16)))
+	}
+	mock = newMockAccountEvicter()
+	eviction.EvictToFitSize(mock, 100)
+	assert.Equal(t, 10, len(mock.keys), "should evict the older account generation")
+	assert.Equal(t, 0, int(eviction.AccountsSize()))
+	assert.Equal(t, 100, int(eviction.StorageSize()))
+}
+
+// mockSizingEvicter knows the witness lengths of the storage tries
+type mockSizingEvicter struct {
+	mockAccountEvicter
+	witnessLens map[string]uint64
+}
+
+func (m *mockSizingEvicter) SubTrieWitnessLen(hex []byte) (uint64, bool) {
+	l, ok := m.witnessLens[string(hex)]
+	return l, ok
+}
+
+func TestEvictionBySize(t *testing.T) {
+	contractHex := func(b byte) []byte {
+		hex := keybytesToHex(common.Hash{b}.Bytes())
+		return hex[:len(hex)-1]
+	}
+	storageKey := func(b byte, i int) []byte {
+		return append(contractHex(b), 0x01, byte(i%16), byte(i/16))
+	}
+	fill := func(policy EvictionPolicy) *Eviction {
+		eviction := NewEviction()
+		eviction.SetPolicy(policy)
+		eviction.SetBlockNumber(1)
+		// The huge contract and 10 small ones
+		for i := 0; i < 100; i++ {
+			eviction.BranchNodeCreated(storageKey(0xff, i))
+		}
+		for b := byte(0); b < 10; b++ {
+			for i := 0; i < 3; i++ {
+				eviction.BranchNodeCreated(storageKey(b, i))
+			}
+		}
+		// The huge contract is touched again
+		eviction.SetBlockNumber(2)
+		for i := 0; i < 10; i++ {
+			eviction.BranchNodeTouched(storageKey(0xff, i))
+		}
+		eviction.SetBlockNumber(3)
+		return eviction
+	}
+
+	// The oldest generation goes whole, with the most of the huge contract
+	eviction := fill(EvictOldest)
+	mock := newMockAccountEvicter()
+	eviction.EvictToFitBudgets(mock, 10, 100)
+	assert.Equal(t, 120, len(mock.keys))
+	assert.Equal(t, 10, int(eviction.StorageSize()))
+
+	// The small cold storage tries go first
+	eviction = fill(EvictBySize)
+	mock = newMockAccountEvicter()
+	eviction.EvictToFitBudgets(mock, 10, 100)
+	assert.Equal(t, 30, len(mock.keys))
+	assert.Equal(t, 100, int(eviction.StorageSize()))
+	for _, k := range mock.keys {
+		assert.NotEqual(t, string(contractHex(0xff)), string(k[:2*common.HashLength]), "the huge contract should stay")
+	}
+
+	// The storage tries of the hot accounts are evicted, the ones of the pinned accounts stay
+	eviction = fill(EvictBySize)
+	eviction.SetHotAccounts([][]byte{common.Hash{0}.Bytes()})
+	eviction.Pin(common.Hash{1}.Bytes())
+	mock = newMockAccountEvicter()
+	eviction.EvictToFitBudgets(mock, 10, 103)
+	assert.Equal(t, 27, len(mock.keys))
+	for _, k := range mock.keys {
+		assert.NotEqual(t, string(contractHex(1)), string(k[:2*common.HashLength]), "the pinned contract should stay")
+	}
+
+	// The smallest ones by the witness length
+	sizer := &mockSizingEvicter{witnessLens: make(map[string]uint64)}
+	for b := byte(0); b < 10; b++ {
+		sizer.witnessLens[string(contractHex(b))] = uint64(100 - b)
+	}
+	eviction = fill(EvictBySize)
+	eviction.EvictToFitBudgets(sizer, 10, 115)
+	assert.Equal(t, 15, len(sizer.keys))
+	evicted := make(map[string]struct{})
+	for _, k := range sizer.keys {
+		evicted[string(k[:2*common.HashLength])] = struct{}{}
+	}
+	for b := byte(0); b < 10; b++ {
+		_, ok := evicted[string(contractHex(b))]
+		assert.Equal(t, b >= 5, ok, "contract %d", b)
+	}
+
+	// With the shared limit
+	eviction = fill(EvictBySize)
+	mock = newMockAccountEvicter()
+	eviction.EvictToFitSize(mock, 100)
+	assert.Equal(t, 30, len(mock.keys))
+	assert.Equal(t, 100, int(eviction.TotalSize()))
+
+	policy, err := ParseEvictionPolicy(EvictBySize.String())
+	assert.NoError(t, err)
+	assert.Equal(t, EvictBySize, policy)
+	_, err = ParseEvictionPolicy("newest")
+	assert.Error(t, err)
+}
@@ -9,6 +9,15 @@ import (
 )
 
 func BuildTrieFromWitness(witness *Witness, isBinary bool, trace bool) (*Trie, error) {
+	hb, err := hashBuilderFromWitness(witness, trace)
+	if err != nil {
+		return nil, err
+	}
+	return trieFromHashBuilder(hb, isBinary), nil
+}
+
+// hashBuilderFromWitness executes the operators of the witness, recomputing the hashes of all the nodes
+func hashBuilderFromWitness(witness *Witness, trace bool) (*HashBuilder, error) {
 	hb := NewHashBuilder(false)
 	for _, operator := range witness.Operators {
 		switch op := operator.(type) {
@@ -85,11 +94,15 @@ func BuildTrieFromWitness(witness *Witness, isBinary bool, trace bool) (*Trie, e
 	if trace {
 		fmt.Printf("\n")
 	}
+	return hb, nil
+}
+
+func trieFromHashBuilder(hb *HashBuilder, isBinary bool) *Trie {
 	if !hb.hasRoot() {
 		if isBinary {
-			return NewBinary(EmptyRoot), nil
+			return NewBinary(EmptyRoot)
 		}
-		return New(EmptyRoot), nil
+		return New(EmptyRoot)
 	}
 	r := hb.root()
 	var tr *Trie
@@ -99,5 +112,5 @@ func BuildTrieFromWitness(witness *Witness, isBinary bool, trace bool) (*Trie, e
 		tr = New(hb.rootHash())
 	}
 	tr.root = r
-	return tr, nil
+	return tr
 }
@@ -7,7 +7,17 @@ func (t *Trie) ExtractWitness(trace bool, rl RetainDecider) (*Witness, error) {
 	if rl != nil {
 		rd = rl
 	}
-	return extractWitnessFromRootNode(t.root, trace, rd)
+	return extractWitnessFromRootNode(t.root, trace, rd, nil)
+}
+
+// ExtractWitnessWithSizeTracer works like ExtractWitness, and also reports every produced operator
+// to the tracer, so the contributions of accounts and key prefixes to the witness size can be analysed
+func (t *Trie) ExtractWitnessWithSizeTracer(trace bool, rl RetainDecider, tracer *WitnessSizeTracer) (*Witness, error) {
+	var rd RetainDecider
+	if rl != nil {
+		rd = rl
+	}
+	return extractWitnessFromRootNode(t.root, trace, rd, tracer)
 }
 
 func (t *Trie) ExtractWitnessForPrefix(prefix []byte, trace bool, rl RetainDecider) (*Witness, error) {
@@ -15,7 +25,7 @@ func (t *Trie) ExtractWitnessForPrefix(prefix []byte, trace bool, rl RetainDecid
 	if !found {
 		return nil, errors.New("no data found for given prefix")
 	}
-	return extractWitnessFromRootNode(foundNode, trace, rl)
+	return extractWitnessFromRootNode(foundNode, trace, rl, nil)
 }
 
 // ExtractWitnesses extracts witnesses for subtries starting from the specified root
@@ -43,8 +53,9 @@ func ExtractWitnesses(subTries SubTries, trace bool, retainDec RetainDecider) ([
 // extractWitnessFromRootNode extracts witness for subtrie starting from the specified root
 // if retainDec param is nil it will make a witness for the full subtrie,
 // if retainDec param is set to a RetainList instance, it will make a witness for only the accounts/storages that were actually touched; other paths will be hashed.
-func extractWitnessFromRootNode(root node, trace bool, retainDec RetainDecider) (*Witness, error) {
+func extractWitnessFromRootNode(root node, trace bool, retainDec RetainDecider, tracer *WitnessSizeTracer) (*Witness, error) {
 	builder := NewWitnessBuilder(root, trace)
+	builder.SetSizeTracer(tracer)
 	var limiter *MerklePathLimiter = nil
 	if retainDec != nil {
 		hr := newHasher(false)
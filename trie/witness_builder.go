@@ -15,9 +15,10 @@ type MerklePathLimiter struct {
 }
 
 type WitnessBuilder struct {
-	root     node
-	trace    bool
-	operands []WitnessOperator
+	root       node
+	trace      bool
+	operands   []WitnessOperator
+	sizeTracer *WitnessSizeTracer
 }
 
 func NewWitnessBuilder(root node, trace bool) *WitnessBuilder {
@@ -28,6 +29,24 @@ func NewWitnessBuilder(root node, trace bool) *WitnessBuilder {
 	}
 }
 
+// SetSizeTracer makes the builder report every produced operator to the tracer
+func (b *WitnessBuilder) SetSizeTracer(tracer *WitnessSizeTracer) {
+	b.sizeTracer = tracer
+}
+
+// traceSize attributes operators added since position `from` to the trie path hex
+func (b *WitnessBuilder) traceSize(hex []byte, from int, estimate uint64) error {
+	if b.sizeTracer == nil {
+		return nil
+	}
+	for _, op := range b.operands[from:] {
+		if err := b.sizeTracer.add(hex, op, estimate); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (b *WitnessBuilder) Build(limiter *MerklePathLimiter) (*Witness, error) {
 	err := b.makeBlockWitness(b.root, []byte{}, limiter, true)
 	witness := NewWitness(b.operands)
@@ -148,16 +167,23 @@ func (b *WitnessBuilder) addEmptyRoot() error {
 	return nil
 }
 
-func (b *WitnessBuilder) processAccountCode(n *accountNode, retainDec RetainDecider) error {
+func (b *WitnessBuilder) processAccountCode(n *accountNode, hex []byte, retainDec RetainDecider) error {
 	if n.IsEmptyRoot() && n.IsEmptyCodeHash() {
 		return nil
 	}
 
+	from := len(b.operands)
 	if n.code == nil || (retainDec != nil && !retainDec.IsCodeTouched(n.CodeHash)) {
-		return b.addHashOp(hashNode{hash: n.CodeHash[:], witnessLength: uint64(n.codeSize)})
+		if err := b.addHashOp(hashNode{hash: n.CodeHash[:], witnessLength: uint64(n.codeSize)}); err != nil {
+			return err
+		}
+		return b.traceSize(hex, from, uint64(n.codeSize))
 	}
 
-	return b.addCodeOp(n.code)
+	if err := b.addCodeOp(n.code); err != nil {
+		return err
+	}
+	return b.traceSize(hex, from, 0)
 }
 
 func (b *WitnessBuilder) processAccountStorage(n *accountNode, hex []byte, limiter *MerklePathLimiter) error {
@@ -166,7 +192,11 @@ func (b *WitnessBuilder) processAccountStorage(n *accountNode, hex []byte, limit
 	}
 
 	if n.storage == nil {
-		return b.addEmptyRoot()
+		from := len(b.operands)
+		if err := b.addEmptyRoot(); err != nil {
+			return err
+		}
+		return b.traceSize(hex, from, 0)
 	}
 
 	// Here we substitute rs parameter for storageRs, because it needs to become the default
@@ -181,20 +211,28 @@ func (b *WitnessBuilder) makeBlockWitness(
 		if limiter != nil {
 			retainDec = limiter.RetainDecider
 		}
-		if err := b.processAccountCode(n, retainDec); err != nil {
+		if err := b.processAccountCode(n, storageKey, retainDec); err != nil {
 			return err
 		}
 		if err := b.processAccountStorage(n, storageKey, limiter); err != nil {
 			return err
 		}
-		return b.addAccountLeafOp(key, n)
+		from := len(b.operands)
+		if err := b.addAccountLeafOp(key, n); err != nil {
+			return err
+		}
+		return b.traceSize(storageKey, from, 0)
 	}
 
+	from := len(b.operands)
 	switch n := nd.(type) {
 	case nil:
 		return nil
 	case valueNode:
-		return b.addLeafOp(hex, n)
+		if err := b.addLeafOp(hex, n); err != nil {
+			return err
+		}
+		return b.traceSize(hex, from, 0)
 	case *accountNode:
 		return processAccountNode(hex, hex, n)
 	case *shortNode:
@@ -206,7 +244,10 @@ func (b *WitnessBuilder) makeBlockWitness(
 		hexVal := concat(hex, h...)
 		switch v := n.Val.(type) {
 		case valueNode:
-			return b.addLeafOp(n.Key, v[:])
+			if err := b.addLeafOp(n.Key, v[:]); err != nil {
+				return err
+			}
+			return b.traceSize(hexVal, from, 0)
 		case *accountNode:
 			return processAccountNode(n.Key, hexVal, v)
 		default:
@@ -214,7 +255,11 @@ func (b *WitnessBuilder) makeBlockWitness(
 				return err
 			}
 
-			return b.addExtensionOp(n.Key)
+			from = len(b.operands)
+			if err := b.addExtensionOp(n.Key); err != nil {
+				return err
+			}
+			return b.traceSize(hex, from, 0)
 		}
 	case *duoNode:
 		hashOnly := limiter != nil && !limiter.RetainDecider.Retain(hex) // Save this because rl can move on to other keys during the recursive invocation
@@ -226,7 +271,10 @@ func (b *WitnessBuilder) makeBlockWitness(
 			if err != nil {
 				return err
 			}
-			return b.addHashOp(hn)
+			if err = b.addHashOp(hn); err != nil {
+				return err
+			}
+			return b.traceSize(hex, from, hn.witnessLength)
 		}
 
 		i1, i2 := n.childrenIdx()
@@ -237,7 +285,11 @@ func (b *WitnessBuilder) makeBlockWitness(
 		if err := b.makeBlockWitness(n.child2, expandKeyHex(hex, i2), limiter, false); err != nil {
 			return err
 		}
-		return b.addBranchOp(n.mask)
+		from = len(b.operands)
+		if err := b.addBranchOp(n.mask); err != nil {
+			return err
+		}
+		return b.traceSize(hex, from, 0)
 
 	case *fullNode:
 		hashOnly := limiter != nil && !limiter.RetainDecider.Retain(hex) // Save this because rs can move on to other keys during the recursive invocation
@@ -246,7 +298,10 @@ func (b *WitnessBuilder) makeBlockWitness(
 			if err != nil {
 				return err
 			}
-			return b.addHashOp(hn)
+			if err = b.addHashOp(hn); err != nil {
+				return err
+			}
+			return b.traceSize(hex, from, hn.witnessLength)
 		}
 
 		var mask uint32
@@ -258,12 +313,19 @@ func (b *WitnessBuilder) makeBlockWitness(
 				mask |= (uint32(1) << uint(i))
 			}
 		}
-		return b.addBranchOp(mask)
+		from = len(b.operands)
+		if err := b.addBranchOp(mask); err != nil {
+			return err
+		}
+		return b.traceSize(hex, from, 0)
 
 	case hashNode:
 		hashOnly := limiter == nil || !limiter.RetainDecider.Retain(hex)
 		if hashOnly {
-			return b.addHashOp(n)
+			if err := b.addHashOp(n); err != nil {
+				return err
+			}
+			return b.traceSize(hex, from, n.witnessLength)
 		}
 		return fmt.Errorf("unexpected hashNode: %s, at hex: %x, (%d), hashOnly: %t", n, hex, len(hex), hashOnly)
 	default:
@@ -0,0 +1,161 @@
+package trie
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+)
+
+// accountKeyNibbles - length of the path to an account leaf in the hexary trie
+const accountKeyNibbles = 2 * 32
+
+// WitnessSizeItem is a contribution of a single account or a key prefix to the size of a block witness
+type WitnessSizeItem struct {
+	Key      []byte // nibbles of the path in the trie (for accounts - full path, i.e. hash of the address)
+	Size     uint64 // number of bytes in the serialized witness
+	Estimate uint64 // witness length of the hashed sub-tries (taken from dataLenStack), would be added if they were expanded
+	Ops      int    // number of witness operators
+}
+
+// WitnessSizeTracer attributes every operator of the block witness to the path in the trie
+// where the operator was produced, and aggregates these contributions per account (including
+// its storage and code) and per key prefix of fixed length.
+// Used to find out which contracts make the witness large.
+// IMPORTANT: not thread-safe! use from a single thread only
+type WitnessSizeTracer struct {
+	prefixLen  int
+	accounts   map[string]*WitnessSizeItem
+	prefixes   map[string]*WitnessSizeItem
+	marshaller *OperatorMarshaller
+	total      uint64
+}
+
+// NewWitnessSizeTracer creates a tracer that groups contributions by the first prefixLen nibbles of the path
+func NewWitnessSizeTracer(prefixLen int) *WitnessSizeTracer {
+	if prefixLen > accountKeyNibbles {
+		prefixLen = accountKeyNibbles
+	}
+	return &WitnessSizeTracer{
+		prefixLen:  prefixLen,
+		accounts:   make(map[string]*WitnessSizeItem),
+		prefixes:   make(map[string]*WitnessSizeItem),
+		marshaller: NewOperatorMarshaller(ioutil.Discard),
+	}
+}
+
+func (t *WitnessSizeTracer) add(hex []byte, op WitnessOperator, estimate uint64) error {
+	before := t.marshaller.total
+	if err := op.WriteTo(t.marshaller); err != nil {
+		return err
+	}
+	size := t.marshaller.total - before
+	t.total += size
+
+	prefix := hex
+	if len(prefix) > t.prefixLen {
+		prefix = prefix[:t.prefixLen]
+	}
+	t.accumulate(t.prefixes, prefix, size, estimate)
+	if len(hex) >= accountKeyNibbles {
+		t.accumulate(t.accounts, hex[:accountKeyNibbles], size, estimate)
+	}
+	return nil
+}
+
+func (t *WitnessSizeTracer) accumulate(m map[string]*WitnessSizeItem, key []byte, size uint64, estimate uint64) {
+	item, ok := m[string(key)]
+	if !ok {
+		item = &WitnessSizeItem{Key: common.CopyBytes(key)}
+		m[string(key)] = item
+	}
+	item.Size += size
+	item.Estimate += estimate
+	item.Ops++
+}
+
+// Total returns the size of all traced operators (witness header is not included)
+func (t *WitnessSizeTracer) Total() uint64 {
+	return t.total
+}
+
+// Accounts returns contributions of the accounts, sorted by size descending
+func (t *WitnessSizeTracer) Accounts() []*WitnessSizeItem {
+	return sortedWitnessSizeItems(t.accounts)
+}
+
+// Prefixes returns contributions of the key prefixes, sorted by size descending
+func (t *WitnessSizeTracer) Prefixes() []*WitnessSizeItem {
+	return sortedWitnessSizeItems(t.prefixes)
+}
+
+func sortedWitnessSizeItems(m map[string]*WitnessSizeItem) []*WitnessSizeItem {
+	items := make([]*WitnessSizeItem, 0, len(m))
+	for _, item := range m {
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Size != items[j].Size {
+			return items[i].Size > items[j].Size
+		}
+		return bytes.Compare(items[i].Key, items[j].Key) < 0
+	})
+	return items
+}
+
+// WriteTo prints top contributors into w. Accounts are printed as hashes, prefixes as nibbles.
+// nameFunc is optional, it may resolve hash of the account into something human-readable (i.e. address)
+func (t *WitnessSizeTracer) WriteTo(w io.Writer, top int, nameFunc func(addrHash []byte) string) error {
+	if _, err := fmt.Fprintf(w, "Total witness size: %d\n\nAccounts (with storage and code):\n", t.total); err != nil {
+		return err
+	}
+	for i, item := range t.Accounts() {
+		if top > 0 && i >= top {
+			break
+		}
+		addrHash := keyNibblesToBytes(item.Key)[1:] // skip the parity byte
+		name := ""
+		if nameFunc != nil {
+			name = nameFunc(addrHash)
+		}
+		if _, err := fmt.Fprintf(w, "%x %s size=%d (%.2f%%) ops=%d hashed_estimate=%d\n",
+			addrHash, name, item.Size, percentOf(item.Size, t.total), item.Ops, item.Estimate); err != nil {
+			return err
+		}
+	}
+	if _, err := fmt.Fprintf(w, "\nPrefixes (%d nibbles):\n", t.prefixLen); err != nil {
+		return err
+	}
+	for i, item := range t.Prefixes() {
+		if top > 0 && i >= top {
+			break
+		}
+		if _, err := fmt.Fprintf(w, "%s size=%d (%.2f%%) ops=%d hashed_estimate=%d\n",
+			nibblesToString(item.Key), item.Size, percentOf(item.Size, t.total), item.Ops, item.Estimate); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func percentOf(v, total uint64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return 100 * float64(v) / float64(total)
+}
+
+func nibblesToString(nibbles []byte) string {
+	if len(nibbles) == 0 {
+		return "<root>"
+	}
+	const digits = "0123456789abcdef"
+	s := make([]byte, len(nibbles))
+	for i, n := range nibbles {
+		s[i] = digits[n&0x0f]
+	}
+	return string(s)
+}
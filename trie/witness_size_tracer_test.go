@@ -0,0 +1,65 @@
+package trie
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/core/types/accounts"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWitnessSizeTracer(t *testing.T) {
+	tr := New(common.Hash{})
+	var keys [][]byte
+	for i := byte(0); i < 16; i++ {
+		acc := accounts.NewAccount()
+		acc.Balance.SetUint64(uint64(i) * 1000)
+		// 8 accounts under prefix 0xa and 8 under prefix 0xb
+		key := common.RightPadBytes([]byte{0xa0 + (i/8)<<4 + i%8, i}, 32)
+		keys = append(keys, key)
+		tr.UpdateAccount(key, &acc)
+	}
+
+	rl := NewRetainList(0)
+	rl.AddKey(keys[3])
+
+	tracer := NewWitnessSizeTracer(1)
+	w, err := tr.ExtractWitnessWithSizeTracer(false, rl, tracer)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	_, err = w.WriteTo(&buf)
+	require.NoError(t, err)
+	// header is the only thing not attributed to any path
+	assert.Equal(t, uint64(buf.Len()-1), tracer.Total())
+
+	var prefixTotal uint64
+	for _, item := range tracer.Prefixes() {
+		prefixTotal += item.Size
+	}
+	assert.Equal(t, tracer.Total(), prefixTotal)
+
+	// sub-trie of the retained account is expanded, the other one is hashed
+	accs := tracer.Accounts()
+	require.Equal(t, 8, len(accs))
+	for _, acc := range accs {
+		assert.Equal(t, byte(0xa), acc.Key[0])
+	}
+	var hashed *WitnessSizeItem
+	for _, item := range tracer.Prefixes() {
+		if bytes.Equal(item.Key, []byte{0xb}) {
+			hashed = item
+		}
+	}
+	require.NotNil(t, hashed)
+	assert.Equal(t, 1, hashed.Ops)
+	assert.Equal(t, uint64(1+common.HashLength), hashed.Size)
+
+	// sorted descending
+	prefixes := tracer.Prefixes()
+	for i := 1; i < len(prefixes); i++ {
+		assert.True(t, prefixes[i-1].Size >= prefixes[i].Size)
+	}
+}
@@ -0,0 +1,137 @@
+package trie
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/holiman/uint256"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/core/types/accounts"
+)
+
+// ErrNotInWitness is returned for the parts of the state, which the witness replaces with their hashes
+var ErrNotInWitness = errors.New("not covered by the witness")
+
+// HydrateTrie builds the state trie out of the serialized block witness, the parts of the state omitted
+// from the witness are kept as the hash nodes. The root of the trie has to match the state root
+func HydrateTrie(witness []byte, stateRoot common.Hash) (*Trie, error) {
+	w, err := NewWitnessFromReader(bytes.NewReader(witness), false /* trace */)
+	if err != nil {
+		return nil, fmt.Errorf("reading witness: %w", err)
+	}
+	t, err := BuildTrieFromWitness(w, false /* isBinary */, false /* trace */)
+	if err != nil {
+		return nil, fmt.Errorf("building trie from witness: %w", err)
+	}
+	if root := t.Hash(); root != stateRoot {
+		return nil, fmt.Errorf("witness root mismatch, got %x, expected %x", root, stateRoot)
+	}
+	return t, nil
+}
+
+// WitnessState answers the state queries of the stateless and the light clients (eth_getBalance,
+// eth_getStorageAt, eth_getProof) from the trie hydrated from the block witness. The queries about
+// the accounts and the storage items, which are not covered by the witness, fail with ErrNotInWitness
+type WitnessState struct {
+	t *Trie
+}
+
+// NewWitnessState hydrates the trie from the serialized block witness, see HydrateTrie
+func NewWitnessState(witness []byte, stateRoot common.Hash) (*WitnessState, error) {
+	t, err := HydrateTrie(witness, stateRoot)
+	if err != nil {
+		return nil, err
+	}
+	return &WitnessState{t: t}, nil
+}
+
+// Trie returns the hydrated trie
+func (s *WitnessState) Trie() *Trie {
+	return s.t
+}
+
+// Account returns the account, nil if the witness proves that it does not exist
+func (s *WitnessState) Account(address common.Address) (*accounts.Account, error) {
+	addrHash, err := common.HashData(address[:])
+	if err != nil {
+		return nil, err
+	}
+	acc, ok := s.t.GetAccount(addrHash[:])
+	if !ok {
+		return nil, fmt.Errorf("account %x: %w", address, ErrNotInWitness)
+	}
+	return acc, nil
+}
+
+// Balance returns the balance of the account, zero for the accounts, which do not exist
+func (s *WitnessState) Balance(address common.Address) (*uint256.Int, error) {
+	acc, err := s.Account(address)
+	if err != nil {
+		return nil, err
+	}
+	if acc == nil {
+		return uint256.NewInt(), nil
+	}
+	return new(uint256.Int).Set(&acc.Balance), nil
+}
+
+// Nonce returns the nonce of the account, zero for the accounts, which do not exist
+func (s *WitnessState) Nonce(address common.Address) (uint64, error) {
+	acc, err := s.Account(address)
+	if err != nil || acc == nil {
+		return 0, err
+	}
+	return acc.Nonce, nil
+}
+
+// StorageAt returns the value of the storage item, the zero hash for the items, which are not set
+func (s *WitnessState) StorageAt(address common.Address, key common.Hash) (common.Hash, error) {
+	trieKey, err := storageTrieKey(address, key)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	v, ok := s.t.Get(trieKey)
+	if !ok {
+		return common.Hash{}, fmt.Errorf("storage %x of account %x: %w", key, address, ErrNotInWitness)
+	}
+	return common.BytesToHash(v), nil
+}
+
+// AccountProof returns the nodes on the path from the root to the account, as in the accountProof of eth_getProof
+func (s *WitnessState) AccountProof(address common.Address) ([][]byte, error) {
+	addrHash, err := common.HashData(address[:])
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := s.t.GetAccount(addrHash[:]); !ok {
+		return nil, fmt.Errorf("account %x: %w", address, ErrNotInWitness)
+	}
+	return s.t.Prove(addrHash[:], 0, false /* storage */)
+}
+
+// StorageProof returns the nodes on the path from the storage root of the account to the storage item,
+// as in the storageProof of eth_getProof
+func (s *WitnessState) StorageProof(address common.Address, key common.Hash) ([][]byte, error) {
+	trieKey, err := storageTrieKey(address, key)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := s.t.Get(trieKey); !ok {
+		return nil, fmt.Errorf("storage %x of account %x: %w", key, address, ErrNotInWitness)
+	}
+	return s.t.Prove(trieKey, 2*common.HashLength /* nibbles to get to the storage sub-trie */, true /* storage */)
+}
+
+func storageTrieKey(address common.Address, key common.Hash) ([]byte, error) {
+	addrHash, err := common.HashData(address[:])
+	if err != nil {
+		return nil, err
+	}
+	keyHash, err := common.HashData(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return append(addrHash[:], keyHash[:]...), nil
+}
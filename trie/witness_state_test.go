@@ -0,0 +1,87 @@
+package trie
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/holiman/uint256"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/core/types/accounts"
+	"github.com/ledgerwatch/turbo-geth/crypto"
+)
+
+func TestWitnessState(t *testing.T) {
+	tr := New(common.Hash{})
+	var addresses []common.Address
+	for i := 0; i < 32; i++ {
+		address := common.BytesToAddress([]byte{byte(i + 1)})
+		addresses = append(addresses, address)
+		acc := &accounts.Account{
+			Initialised: true,
+			Nonce:       uint64(i),
+			Balance:     *uint256.NewInt().SetUint64(uint64(1000 * (i + 1))),
+			Root:        EmptyRoot,
+			CodeHash:    emptyState,
+		}
+		tr.UpdateAccount(crypto.Keccak256(address[:]), acc)
+	}
+	covered := addresses[0]
+	addrHash := crypto.Keccak256Hash(covered[:])
+	key1, key2 := common.HexToHash("0x01"), common.HexToHash("0x02")
+	tr.Update(append(addrHash.Bytes(), crypto.Keccak256(key1[:])...), []byte{0x2a})
+	tr.Update(append(addrHash.Bytes(), crypto.Keccak256(key2[:])...), bytes.Repeat([]byte{0x2b}, 32))
+	tr.DeepHash(addrHash[:])
+	root := tr.Hash()
+
+	// The account, which is not on the path to the covered one already at the root
+	var omitted common.Address
+	for _, address := range addresses[1:] {
+		if crypto.Keccak256(address[:])[0]>>4 != addrHash[0]>>4 {
+			omitted = address
+			break
+		}
+	}
+
+	rl := NewRetainList(0)
+	rl.AddKey(addrHash[:])
+	rl.AddKey(append(addrHash.Bytes(), crypto.Keccak256(key1[:])...))
+	witness, err := tr.ExtractWitness(false, rl)
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	_, err = witness.WriteTo(&buf)
+	require.NoError(t, err)
+
+	_, err = NewWitnessState(buf.Bytes(), common.HexToHash("0x01"))
+	assert.Error(t, err)
+	s, err := NewWitnessState(buf.Bytes(), root)
+	require.NoError(t, err)
+
+	balance, err := s.Balance(covered)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1000), balance.Uint64())
+	value, err := s.StorageAt(covered, key1)
+	require.NoError(t, err)
+	assert.Equal(t, common.HexToHash("0x2a"), value)
+
+	_, err = s.Balance(omitted)
+	assert.True(t, errors.Is(err, ErrNotInWitness))
+	_, err = s.AccountProof(omitted)
+	assert.True(t, errors.Is(err, ErrNotInWitness))
+
+	// The proofs produced from the hydrated trie are verified against the state root
+	accountProof, err := s.AccountProof(covered)
+	require.NoError(t, err)
+	acc, err := VerifyAccountProof(root, covered, accountProof)
+	require.NoError(t, err)
+	require.NotNil(t, acc)
+	assert.Equal(t, uint64(1000), acc.Balance.Uint64())
+	storageProof, err := s.StorageProof(covered, key1)
+	require.NoError(t, err)
+	v, err := VerifyStorageProof(acc.Root, key1, storageProof)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x2a}, v)
+}
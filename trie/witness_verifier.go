@@ -0,0 +1,62 @@
+package trie
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+)
+
+var (
+	// ErrWitnessRootMismatch is returned when the hash of the trie recomputed from the witness differs from the expected root
+	ErrWitnessRootMismatch = errors.New("witness root mismatch")
+	// ErrMalformedWitness is returned when the operators of the witness do not form a single trie
+	ErrMalformedWitness = errors.New("malformed witness")
+)
+
+// VerifyWitness decodes the serialized witness (see Witness.WriteTo) and verifies it against the state root (see Witness.Verify).
+// It does not need the database, so the witnesses can be validated without running the node
+func VerifyWitness(input io.Reader, root common.Hash, isBinary bool) (witness *Witness, err error) {
+	defer recoverMalformedWitness(&err)
+	witness, err = NewWitnessFromReader(input, false /* trace */)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformedWitness, err)
+	}
+	if err = witness.Verify(root, isBinary); err != nil {
+		return nil, err
+	}
+	return witness, nil
+}
+
+// Verify checks the internal consistency of the witness: the hashes of all the nodes (including the code hashes
+// of the accounts) are recomputed by the HashBuilder, the operators have to leave exactly one trie on its stack,
+// and the root hash of that trie has to be equal to root
+func (w *Witness) Verify(root common.Hash, isBinary bool) error {
+	_, err := w.verifiedTrie(root, isBinary)
+	return err
+}
+
+// verifiedTrie builds the trie from the witness and checks its root, see Verify
+func (w *Witness) verifiedTrie(root common.Hash, isBinary bool) (t *Trie, err error) {
+	defer recoverMalformedWitness(&err)
+	hb, err := hashBuilderFromWitness(w, false /* trace */)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformedWitness, err)
+	}
+	if n := len(hb.nodeStack); n > 1 {
+		return nil, fmt.Errorf("%w: %d tries left on the stack, expected 1", ErrMalformedWitness, n)
+	}
+	t = trieFromHashBuilder(hb, isBinary)
+	if got := t.Hash(); got != root {
+		return nil, fmt.Errorf("%w: expected %x, got %x", ErrWitnessRootMismatch, root, got)
+	}
+	return t, nil
+}
+
+// recoverMalformedWitness turns the panics on the arbitrary input (i.e. the stack underflow of the HashBuilder) into errors
+func recoverMalformedWitness(err *error) {
+	if r := recover(); r != nil {
+		*err = fmt.Errorf("%w: %v", ErrMalformedWitness, r)
+	}
+}
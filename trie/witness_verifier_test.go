@@ -0,0 +1,47 @@
+package trie
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+)
+
+func TestVerifyWitness(t *testing.T) {
+	tr := New(common.Hash{})
+	tr.Update([]byte("ABCD0001"), []byte("val1"))
+	tr.Update([]byte("ABCE0002"), []byte("val2"))
+	tr.Update([]byte("ABCF0003"), []byte("val3"))
+	root := tr.Hash()
+
+	rl := NewRetainList(0)
+	rl.AddKey([]byte("ABCD0001"))
+	w, err := tr.ExtractWitness(false, rl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if _, err = w.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	serialized := buf.Bytes()
+
+	if _, err = VerifyWitness(bytes.NewReader(serialized), root, false); err != nil {
+		t.Errorf("valid witness is rejected: %v", err)
+	}
+
+	if _, err = VerifyWitness(bytes.NewReader(serialized), common.HexToHash("0x01"), false); !errors.Is(err, ErrWitnessRootMismatch) {
+		t.Errorf("expected root mismatch, got %v", err)
+	}
+
+	// Extra subtrie, which is not connected to the root
+	w.Operators = append(w.Operators, &OperatorHash{root})
+	if err = w.Verify(root, false); !errors.Is(err, ErrMalformedWitness) {
+		t.Errorf("expected malformed witness, got %v", err)
+	}
+
+	if _, err = VerifyWitness(bytes.NewReader(serialized[:len(serialized)/2]), root, false); err == nil {
+		t.Errorf("truncated witness is accepted")
+	}
+}
@@ -0,0 +1,170 @@
+// Package chainstack creates isolated chains (the database, the state and the blockchain) over the in-memory
+// databases within one process, so that the integration tests and the simulators of the networks of the nodes
+// do not need to spawn the processes or to create the temporary directories. The chains created by one Factory
+// share the genesis, but nothing else: every one of them can be advanced, reorganised and closed on its own
+package chainstack
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/ledgerwatch/turbo-geth/consensus"
+	"github.com/ledgerwatch/turbo-geth/consensus/ethash"
+	"github.com/ledgerwatch/turbo-geth/core"
+	"github.com/ledgerwatch/turbo-geth/core/types"
+	"github.com/ledgerwatch/turbo-geth/core/vm"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+	"github.com/ledgerwatch/turbo-geth/params"
+)
+
+var errClosed = errors.New("chain factory is closed")
+
+// Config tunes the chains created by a Factory
+type Config struct {
+	// NewEngine creates the consensus engine of every chain, ethash.NewFaker if nil
+	NewEngine func() consensus.Engine
+	// CacheConfig is passed to core.NewBlockChain, the defaults of the blockchain if nil
+	CacheConfig *core.CacheConfig
+	VMConfig    vm.Config
+	// Receipts tells whether the chains store the receipts of the inserted blocks
+	Receipts bool
+}
+
+// Factory creates the chains sharing the genesis, see New
+type Factory struct {
+	genesis *core.Genesis
+	config  Config
+
+	lock   sync.Mutex
+	chains map[*Chain]struct{}
+	closed bool
+}
+
+// Chain is one isolated chain: the in-memory database and the blockchain on top of it
+type Chain struct {
+	factory    *Factory
+	DB         ethdb.Database
+	Engine     consensus.Engine
+	Blockchain *core.BlockChain
+	Genesis    *types.Block
+}
+
+// NewFactory creates the factory of the chains with the given genesis. The genesis has to have the chain config
+func NewFactory(genesis *core.Genesis, config Config) (*Factory, error) {
+	if genesis == nil || genesis.Config == nil {
+		return nil, errors.New("genesis without the chain config")
+	}
+	if config.NewEngine == nil {
+		config.NewEngine = func() consensus.Engine { return ethash.NewFaker() }
+	}
+	return &Factory{genesis: genesis, config: config, chains: make(map[*Chain]struct{})}, nil
+}
+
+// ChainConfig returns the config of the chains created by the factory
+func (f *Factory) ChainConfig() *params.ChainConfig {
+	return f.genesis.Config
+}
+
+// New creates the chain with only the genesis block in a fresh in-memory database
+func (f *Factory) New() (*Chain, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.closed {
+		return nil, errClosed
+	}
+	db := ethdb.NewMemDatabase()
+	genesis, _, err := f.genesis.Commit(db, true /* history */)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("committing the genesis: %w", err)
+	}
+	engine := f.config.NewEngine()
+	blockchain, err := core.NewBlockChain(db, f.config.CacheConfig, f.genesis.Config, engine, f.config.VMConfig, nil, nil)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	blockchain.EnableReceipts(f.config.Receipts)
+	c := &Chain{factory: f, DB: db, Engine: engine, Blockchain: blockchain, Genesis: genesis}
+	f.chains[c] = struct{}{}
+	return c, nil
+}
+
+// Close closes all the chains created by the factory, no chains can be created afterwards
+func (f *Factory) Close() {
+	f.lock.Lock()
+	chains := f.chains
+	f.chains = nil
+	f.closed = true
+	f.lock.Unlock()
+	for c := range chains {
+		c.close()
+	}
+}
+
+// Generate creates n blocks on top of the current head of the chain, without inserting them.
+// The generator function is called for every block, as in core.GenerateChain. The state of the chain
+// is copied for the generation, so the chain is not modified
+func (c *Chain) Generate(n int, gen func(int, *core.BlockGen)) []*types.Block {
+	head := c.Blockchain.CurrentBlock()
+	ctx := c.Blockchain.WithContext(context.Background(), head.Number())
+	blocks, _ := core.GenerateChain(ctx, c.factory.genesis.Config, head, c.Engine, c.DB.MemCopy(), n, gen)
+	return blocks
+}
+
+// Extend generates n blocks on top of the current head of the chain and inserts them
+func (c *Chain) Extend(n int, gen func(int, *core.BlockGen)) ([]*types.Block, error) {
+	blocks := c.Generate(n, gen)
+	if _, err := c.Blockchain.InsertChain(context.Background(), blocks); err != nil {
+		return nil, err
+	}
+	return blocks, nil
+}
+
+// SyncFrom inserts the canonical blocks of the other chain, which are missing in this one, as a full sync
+// between two nodes would. The chains have to be created by the same factory
+func (c *Chain) SyncFrom(other *Chain) error {
+	if c.factory != other.factory {
+		return errors.New("chains of different factories")
+	}
+	head := other.Blockchain.CurrentBlock().NumberU64()
+	// The first block, which differs from the canonical block of the other chain
+	var from uint64 = 1
+	for ; from <= head; from++ {
+		ours, theirs := c.Blockchain.GetBlockByNumber(from), other.Blockchain.GetBlockByNumber(from)
+		if ours == nil || theirs == nil || ours.Hash() != theirs.Hash() {
+			break
+		}
+	}
+	if from > head {
+		return nil
+	}
+	blocks := make(types.Blocks, 0, head-from+1)
+	for number := from; number <= head; number++ {
+		block := other.Blockchain.GetBlockByNumber(number)
+		if block == nil {
+			return fmt.Errorf("block %d is missing in the other chain", number)
+		}
+		blocks = append(blocks, block)
+	}
+	_, err := c.Blockchain.InsertChain(context.Background(), blocks)
+	return err
+}
+
+// Close stops the blockchain and drops the database of the chain
+func (c *Chain) Close() {
+	c.factory.lock.Lock()
+	_, ok := c.factory.chains[c]
+	delete(c.factory.chains, c)
+	c.factory.lock.Unlock()
+	if ok {
+		c.close()
+	}
+}
+
+func (c *Chain) close() {
+	c.Blockchain.Stop()
+	c.DB.Close()
+}
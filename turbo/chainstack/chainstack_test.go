@@ -0,0 +1,50 @@
+package chainstack
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/core"
+	"github.com/ledgerwatch/turbo-geth/params"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsolatedChains(t *testing.T) {
+	genesis := &core.Genesis{
+		Config: params.TestChainConfig,
+		Alloc:  core.GenesisAlloc{common.HexToAddress("0x1"): {Balance: big.NewInt(1000000)}},
+	}
+	f, err := NewFactory(genesis, Config{})
+	require.NoError(t, err)
+	defer f.Close()
+
+	a, err := f.New()
+	require.NoError(t, err)
+	b, err := f.New()
+	require.NoError(t, err)
+	assert.Equal(t, a.Genesis.Hash(), b.Genesis.Hash())
+
+	// The chains advance independently
+	_, err = a.Extend(5, func(i int, gen *core.BlockGen) { gen.SetCoinbase(common.HexToAddress("0xa")) })
+	require.NoError(t, err)
+	_, err = b.Extend(2, func(i int, gen *core.BlockGen) { gen.SetCoinbase(common.HexToAddress("0xb")) })
+	require.NoError(t, err)
+	assert.Equal(t, uint64(5), a.Blockchain.CurrentBlock().NumberU64())
+	assert.Equal(t, uint64(2), b.Blockchain.CurrentBlock().NumberU64())
+
+	// The longer chain wins after the sync
+	require.NoError(t, b.SyncFrom(a))
+	assert.Equal(t, a.Blockchain.CurrentBlock().Hash(), b.Blockchain.CurrentBlock().Hash())
+	assert.Equal(t, a.Blockchain.CurrentBlock().Root(), b.Blockchain.CurrentBlock().Root())
+
+	b.Close()
+	c, err := f.New()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(0), c.Blockchain.CurrentBlock().NumberU64())
+
+	f.Close()
+	_, err = f.New()
+	assert.Error(t, err)
+}
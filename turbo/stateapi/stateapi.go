@@ -0,0 +1,104 @@
+// Package stateapi gives the programs embedding turbo-geth (block explorers, analytics tools) read access
+// to the state of the turbo-geth database, current and historical, without depending on the internals
+// of the state readers. The API is kept small and stable.
+package stateapi
+
+import (
+	"errors"
+
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/common/dbutils"
+	"github.com/ledgerwatch/turbo-geth/core/types/accounts"
+	"github.com/ledgerwatch/turbo-geth/crypto"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+)
+
+// DB is the state database opened by OpenDatabase
+type DB struct {
+	db ethdb.Database
+}
+
+// OpenDatabase opens the chaindata database of turbo-geth. In the read-only mode the database can be opened
+// while other readers hold it, but not while the node is running, see ethdb.OpenBoltDatabase
+func OpenDatabase(path string, readonly bool) (*DB, error) {
+	db, err := ethdb.OpenBoltDatabase(path, readonly)
+	if err != nil {
+		return nil, err
+	}
+	return &DB{db: db}, nil
+}
+
+// NewDB wraps the database, which has been opened already
+func NewDB(db ethdb.Database) *DB {
+	return &DB{db: db}
+}
+
+// Close closes the database
+func (db *DB) Close() {
+	db.db.Close()
+}
+
+// GetAccount returns the account as of the end of the given block, nil if the account did not exist
+func (db *DB) GetAccount(addr common.Address, block uint64) (*accounts.Account, error) {
+	addrHash := crypto.Keccak256(addr[:])
+	enc, err := db.db.GetAsOf(dbutils.CurrentStateBucket, dbutils.AccountsHistoryBucket, addrHash, block+1)
+	if err != nil && !errors.Is(err, ethdb.ErrKeyNotFound) {
+		return nil, err
+	}
+	if len(enc) == 0 {
+		return nil, nil
+	}
+	var acc accounts.Account
+	if err = acc.DecodeForStorage(enc); err != nil {
+		return nil, err
+	}
+	return &acc, nil
+}
+
+// GetStorage returns the value of the storage item of the contract as of the end of the given block,
+// the empty hash if the item or the contract did not exist
+func (db *DB) GetStorage(addr common.Address, key common.Hash, block uint64) (common.Hash, error) {
+	acc, err := db.GetAccount(addr, block)
+	if err != nil || acc == nil {
+		return common.Hash{}, err
+	}
+	addrHash := common.BytesToHash(crypto.Keccak256(addr[:]))
+	keyHash := common.BytesToHash(crypto.Keccak256(key[:]))
+	compositeKey := dbutils.GenerateCompositeStorageKey(addrHash, acc.Incarnation, keyHash)
+	enc, err := db.db.GetAsOf(dbutils.CurrentStateBucket, dbutils.StorageHistoryBucket, compositeKey, block+1)
+	if err != nil && !errors.Is(err, ethdb.ErrKeyNotFound) {
+		return common.Hash{}, err
+	}
+	return common.BytesToHash(enc), nil
+}
+
+// GetAccountHistory returns the blocks in the range [fromBlock, toBlock], in which the account changed,
+// in the ascending order. At most limit blocks are returned (limit <= 0 - no limit)
+func (db *DB) GetAccountHistory(addr common.Address, fromBlock, toBlock uint64, limit int) ([]uint64, error) {
+	return ethdb.GetAccountHistory(db.db, addr, fromBlock, toBlock, limit)
+}
+
+// GetStorageHistory returns the blocks in the range [fromBlock, toBlock], in which the storage item of the contract
+// changed, in the ascending order. At most limit blocks are returned (limit <= 0 - no limit)
+func (db *DB) GetStorageHistory(addr common.Address, key common.Hash, fromBlock, toBlock uint64, limit int) ([]uint64, error) {
+	return ethdb.GetStorageHistory(db.db, addr, key, fromBlock, toBlock, limit)
+}
+
+// WalkAccounts calls the callback for the accounts of the current state, whose address hashes start
+// with the prefix, in the order of the address hashes. The walk stops when the callback returns false or an error.
+// The account is only valid during the call
+func (db *DB) WalkAccounts(prefix []byte, cb func(addrHash common.Hash, acc *accounts.Account) (bool, error)) error {
+	startkey := make([]byte, common.HashLength)
+	copy(startkey, prefix)
+	var acc accounts.Account
+	return db.db.Walk(dbutils.CurrentStateBucket, startkey, 8*len(prefix), func(k, v []byte) (bool, error) {
+		if len(k) != common.HashLength {
+			// Storage items of the contract
+			return true, nil
+		}
+		if err := acc.DecodeForStorage(v); err != nil {
+			return false, err
+		}
+		return cb(common.BytesToHash(k), &acc)
+	})
+}
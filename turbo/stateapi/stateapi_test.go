@@ -0,0 +1,96 @@
+package stateapi
+
+import (
+	"context"
+	"testing"
+
+	"github.com/holiman/uint256"
+	"github.com/ledgerwatch/turbo-geth/common"
+	"github.com/ledgerwatch/turbo-geth/core/state"
+	"github.com/ledgerwatch/turbo-geth/core/types/accounts"
+	"github.com/ledgerwatch/turbo-geth/crypto"
+	"github.com/ledgerwatch/turbo-geth/ethdb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStateAPI(t *testing.T) {
+	db := ethdb.NewMemDatabase()
+	ctx := context.Background()
+	addr := common.HexToAddress("0x1234567890")
+	location := common.HexToHash("0x01")
+	code := []byte{0x60, 0x00}
+	codeHash := common.BytesToHash(crypto.Keccak256(code))
+
+	// Block 1 creates the contract, block 2 changes its balance and storage
+	var original *accounts.Account
+	for blockNr := uint64(1); blockNr <= 2; blockNr++ {
+		w := state.NewDbStateWriter(db, db, blockNr)
+		acc := accounts.NewAccount()
+		acc.Initialised = true
+		acc.Incarnation = 1
+		acc.Balance.SetUint64(blockNr * 10)
+		acc.CodeHash = codeHash
+		if original == nil {
+			empty := accounts.NewAccount()
+			original = &empty
+			require.NoError(t, w.CreateContract(addr))
+			require.NoError(t, w.UpdateAccountCode(addr, acc.Incarnation, codeHash, code))
+		}
+		require.NoError(t, w.UpdateAccountData(ctx, addr, original, &acc))
+		var prev, value uint256.Int
+		prev.SetUint64(blockNr - 1)
+		value.SetUint64(blockNr)
+		require.NoError(t, w.WriteAccountStorage(ctx, addr, acc.Incarnation, &location, &prev, &value))
+		require.NoError(t, w.WriteChangeSets())
+		require.NoError(t, w.WriteHistory())
+		original = &acc
+	}
+
+	api := NewDB(db)
+	defer api.Close()
+
+	acc, err := api.GetAccount(addr, 0)
+	require.NoError(t, err)
+	assert.Nil(t, acc)
+	for blockNr := uint64(1); blockNr <= 3; blockNr++ {
+		expected := blockNr
+		if expected > 2 {
+			expected = 2
+		}
+		acc, err = api.GetAccount(addr, blockNr)
+		require.NoError(t, err)
+		require.NotNil(t, acc)
+		assert.Equal(t, expected*10, acc.Balance.Uint64())
+		// The code hash, which the changesets do not keep, is restored by GetAsOf
+		assert.Equal(t, codeHash, acc.CodeHash)
+		value, err1 := api.GetStorage(addr, location, blockNr)
+		require.NoError(t, err1)
+		assert.Equal(t, common.BigToHash(new(uint256.Int).SetUint64(expected).ToBig()), value)
+	}
+	value, err := api.GetStorage(common.HexToAddress("0x01"), location, 2)
+	require.NoError(t, err)
+	assert.Equal(t, common.Hash{}, value)
+
+	history, err := api.GetAccountHistory(addr, 0, 10, 0)
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{1, 2}, history)
+	history, err = api.GetStorageHistory(addr, location, 2, 10, 0)
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{2}, history)
+
+	addrHash := common.BytesToHash(crypto.Keccak256(addr[:]))
+	var walked []common.Hash
+	require.NoError(t, api.WalkAccounts(addrHash[:1], func(h common.Hash, a *accounts.Account) (bool, error) {
+		walked = append(walked, h)
+		assert.Equal(t, uint64(20), a.Balance.Uint64())
+		return true, nil
+	}))
+	assert.Equal(t, []common.Hash{addrHash}, walked)
+	walked = nil
+	require.NoError(t, api.WalkAccounts([]byte{^addrHash[0]}, func(h common.Hash, a *accounts.Account) (bool, error) {
+		walked = append(walked, h)
+		return true, nil
+	}))
+	assert.Empty(t, walked)
+}